@@ -17,10 +17,16 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"encoding/json"
 	goflag "flag"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/golang/glog"
@@ -31,8 +37,12 @@ import (
 	"github.com/Mirantis/virtlet/pkg/cni"
 	"github.com/Mirantis/virtlet/pkg/config"
 	"github.com/Mirantis/virtlet/pkg/diag"
+	"github.com/Mirantis/virtlet/pkg/faults"
 	"github.com/Mirantis/virtlet/pkg/fs"
+	"github.com/Mirantis/virtlet/pkg/image"
+	"github.com/Mirantis/virtlet/pkg/imageserver"
 	"github.com/Mirantis/virtlet/pkg/manager"
+	"github.com/Mirantis/virtlet/pkg/metadata"
 	"github.com/Mirantis/virtlet/pkg/nsfix"
 	"github.com/Mirantis/virtlet/pkg/tapmanager"
 	"github.com/Mirantis/virtlet/pkg/utils"
@@ -48,10 +58,29 @@ const (
 )
 
 var (
-	dumpConfig     = flag.Bool("dump-config", false, "Dump node-specific Virtlet config as a shell script and exit")
-	dumpDiag       = flag.Bool("diag", false, "Dump diagnostics as JSON and exit")
-	displayVersion = flag.Bool("version", false, "Display version and exit")
-	versionFormat  = flag.String("version-format", "text", "Version format to use (text, short, json, yaml)")
+	dumpConfig      = flag.Bool("dump-config", false, "Dump node-specific Virtlet config as a shell script and exit")
+	dumpDiag        = flag.Bool("diag", false, "Dump diagnostics as JSON and exit")
+	displayVersion  = flag.Bool("version", false, "Display version and exit")
+	versionFormat   = flag.String("version-format", "text", "Version format to use (text, short, json, yaml)")
+	imageSave       = flag.Bool("image-save", false, "Write the images specified as the arguments (all of the images if none are specified) to stdout as a tar archive and exit")
+	imageLoad       = flag.Bool("image-load", false, "Load the images from a tar archive read from stdin and exit")
+	imageList       = flag.Bool("image-list", false, "List the images in the image directory as JSON and exit")
+	imagePull       = flag.Bool("image-pull", false, "Pull the images specified as the arguments into the image directory and exit")
+	imageServer     = flag.Bool("image-server", false, "Serve the images from the image directory over HTTP instead of running Virtlet")
+	imageServerAddr = flag.String("image-server-listen", ":8080", "The address for the image server to listen on")
+	imageServerCert = flag.String("image-server-tls-cert-file", "", "Path to the PEM-encoded TLS certificate of the image server. If it's set together with --image-server-tls-key-file, the images are served over HTTPS")
+	imageServerKey  = flag.String("image-server-tls-key-file", "", "Path to the PEM-encoded private key for --image-server-tls-cert-file")
+	migrateDryRun   = flag.Bool("metadata-migrate-dry-run", false, "List the metadata schema migrations that would be applied to the database on Virtlet startup and exit")
+	migrateToBadger = flag.Bool("metadata-migrate-to-badger", false, "Copy the metadata from the bolt database at databasePath to a new badger database at badgerDatabaseDir and exit. Virtlet must not be running during the migration")
+	metadataBackup  = flag.Bool("metadata-backup", false, "Write a snapshot of the metadata database taken from the running Virtlet process to stdout and exit")
+	metadataRestore = flag.Bool("metadata-restore", false, "Validate the metadata database snapshot read from stdin and stage it to replace the database upon the next Virtlet start, then exit")
+	metadataExport  = flag.Bool("metadata-export", false, "Write the pod sandboxes and the containers from the metadata of the running Virtlet process to stdout as JSON and exit")
+	metadataImport  = flag.Bool("metadata-import", false, "Add the pod sandboxes and the containers read from stdin as JSON to the metadata of the running Virtlet process and exit")
+	metadataCheck   = flag.Bool("metadata-check", false, "Check the consistency of the metadata of the running Virtlet process, write the report to stdout as JSON and exit")
+	metadataRepair  = flag.Bool("metadata-repair", false, "Same as --metadata-check, but also repair the problems found where possible")
+	metadataCompact = flag.Bool("metadata-compact", false, "Request the compaction of the metadata database upon the next Virtlet start, then exit")
+	configFile      = flag.String("config-file", "/etc/virtlet/config/config.yaml", "Path to the YAML file with Virtlet config, such as a key of the mounted virtlet-config ConfigMap. The file is watched for changes of hot-reloadable fields. The settings from this file take precedence over the config mappings, but the command line flags and the environment variables override them")
+	metadataRO      = flag.Bool("metadata-read-only", false, "Make --metadata-export and --metadata-check open the metadata database read-only instead of asking the running Virtlet process to do it. If the database is in use by the running Virtlet process, a snapshot taken from it is opened instead")
 )
 
 func configWithDefaults(cfg *v1.VirtletConfig) *v1.VirtletConfig {
@@ -60,14 +89,50 @@ func configWithDefaults(cfg *v1.VirtletConfig) *v1.VirtletConfig {
 	return r
 }
 
+// withConfigFile merges the config from the config file, if there's
+// one, with the local config, which takes precedence.
+func withConfigFile(localConfig *v1.VirtletConfig) *v1.VirtletConfig {
+	fileConfig, err := config.LoadConfigFile(*configFile)
+	switch {
+	case err != nil:
+		glog.Warningf("Ignoring Virtlet config file %q: %v", *configFile, err)
+		return localConfig
+	case fileConfig == nil:
+		return localConfig
+	}
+	return config.MergeConfigs([]*v1.VirtletConfig{fileConfig, localConfig})
+}
+
 func runVirtlet(config *v1.VirtletConfig, clientCfg clientcmd.ClientConfig, diagSet *diag.Set) {
 	manager := manager.NewVirtletManager(config, nil, clientCfg, diagSet)
+	// without the node name, only the config file is watched
+	go watchConfig(manager, config, clientCfg, os.Getenv(nodeNameEnv))
 	if err := manager.Run(); err != nil {
 		glog.Errorf("Error: %v", err)
 		os.Exit(1)
 	}
 }
 
+func watchConfig(manager *manager.VirtletManager, cfg *v1.VirtletConfig, clientCfg clientcmd.ClientConfig, nodeName string) {
+	watcher := config.NewConfigWatcher(config.NewNodeConfig(clientCfg), nodeName, cfg, func(cfg *v1.VirtletConfig) {
+		setLogLevel(cfg)
+		manager.UpdateConfig(cfg)
+	})
+	watcher.SetCordonHandler(manager.SetVMsCordoned)
+	watcher.SetConfigFile(*configFile)
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGHUP)
+		for range sigCh {
+			glog.V(1).Infof("Got SIGHUP, reloading the config")
+			if err := watcher.Sync(); err != nil {
+				glog.Warningf("Failed to reload the config: %v", err)
+			}
+		}
+	}()
+	watcher.Run(nil)
+}
+
 func runTapManager(config *v1.VirtletConfig) {
 	cniClient, err := cni.NewClient(*config.CNIPluginDir, *config.CNIConfigDir)
 	if err != nil {
@@ -137,6 +202,263 @@ func doDiag() {
 	os.Stdout.Write(dr.ToJSON())
 }
 
+func doImageSave(config *v1.VirtletConfig, names []string) {
+	store := image.NewFileStore(*config.ImageDir, nil, nil)
+	if err := store.SaveImages(names, os.Stdout); err != nil {
+		glog.Errorf("Failed to save the images: %v", err)
+		os.Exit(1)
+	}
+}
+
+func doImageLoad(config *v1.VirtletConfig) {
+	store := image.NewFileStore(*config.ImageDir, nil, nil)
+	images, err := store.LoadImages(os.Stdin)
+	for _, img := range images {
+		fmt.Printf("Loaded image: %s@%s\n", img.Name, img.Digest)
+	}
+	if err != nil {
+		glog.Errorf("Failed to load the images: %v", err)
+		os.Exit(1)
+	}
+}
+
+func doImagePull(config *v1.VirtletConfig, clientCfg clientcmd.ClientConfig, names []string) {
+	store := image.NewFileStore(*config.ImageDir, image.NewDownloader(*config.DownloadProtocol), nil)
+	translator := manager.NewImageTranslator(config, clientCfg)
+	for _, name := range names {
+		ref, err := store.PullImage(context.Background(), name, translator)
+		if err != nil {
+			glog.Errorf("Failed to pull image %q: %v", name, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Pulled image: %s\n", ref)
+	}
+}
+
+func doImageList(config *v1.VirtletConfig) {
+	store := image.NewFileStore(*config.ImageDir, nil, nil)
+	images, err := imageserver.NewHandler(store).ListImages()
+	if err == nil {
+		err = json.NewEncoder(os.Stdout).Encode(images)
+	}
+	if err != nil {
+		glog.Errorf("Failed to list the images: %v", err)
+		os.Exit(1)
+	}
+}
+
+func runImageServer(config *v1.VirtletConfig) {
+	store := image.NewFileStore(*config.ImageDir, nil, nil)
+	handler := imageserver.NewHandler(store)
+	var err error
+	switch {
+	case *imageServerCert == "" && *imageServerKey == "":
+		glog.V(1).Infof("Serving the images from %q on %s", *config.ImageDir, *imageServerAddr)
+		err = http.ListenAndServe(*imageServerAddr, handler)
+	case *imageServerCert == "" || *imageServerKey == "":
+		glog.Errorf("Both --image-server-tls-cert-file and --image-server-tls-key-file must be specified for the image server to use TLS")
+		os.Exit(1)
+	default:
+		glog.V(1).Infof("Serving the images from %q on %s over HTTPS", *config.ImageDir, *imageServerAddr)
+		err = http.ListenAndServeTLS(*imageServerAddr, *imageServerCert, *imageServerKey, handler)
+	}
+	if err != nil {
+		glog.Errorf("Image server returned error: %v", err)
+		os.Exit(1)
+	}
+}
+
+func doMigrateDryRun(config *v1.VirtletConfig, clientCfg clientcmd.ClientConfig) {
+	if *config.MetadataBackend != "bolt" {
+		fmt.Println("Schema migrations are only supported for the bolt metadata backend")
+		return
+	}
+	key, err := manager.MetadataEncryptionKey(config, clientCfg)
+	if err != nil {
+		glog.Errorf("Can't get the metadata encryption key: %v", err)
+		os.Exit(1)
+	}
+	steps, err := metadata.MigrateDB(*config.DatabasePath, key, true)
+	if err != nil {
+		glog.Errorf("Metadata migration check failed: %v", err)
+		os.Exit(1)
+	}
+	if len(steps) == 0 {
+		fmt.Printf("The metadata schema is up to date (version %d)\n", metadata.CurrentSchemaVersion())
+		return
+	}
+	for _, step := range steps {
+		fmt.Printf("Would migrate to schema version %d: %s\n", step.Version, step.Description)
+	}
+}
+
+func doMigrateToBadger(config *v1.VirtletConfig) {
+	if *config.MetadataEncryptionKeyFile != "" || *config.MetadataEncryptionKeySecret != "" {
+		glog.Errorf("The metadata encryption is not supported by the badger metadata backend")
+		os.Exit(1)
+	}
+	stats, err := metadata.MigrateBoltToBadger(*config.DatabasePath, *config.BadgerDatabaseDir)
+	switch {
+	case err == metadata.ErrDatabaseLocked:
+		glog.Errorf("The metadata database is in use, Virtlet must be stopped before the migration")
+		os.Exit(1)
+	case err == metadata.ErrEncryptedMetadata:
+		glog.Errorf("The metadata database is encrypted and can't be migrated to the badger backend, which doesn't support the encryption")
+		os.Exit(1)
+	case err != nil:
+		glog.Errorf("Metadata migration failed: %v", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Copied %d pod sandboxes, %d containers, %d start records, %d sandbox tombstones, %d sandbox events, %d first boot records and %d image pull jobs to %s\n",
+		stats.Sandboxes, stats.Containers, stats.StartRecords, stats.SandboxTombstones, stats.SandboxEvents, stats.FirstBootRecords, stats.ImagePullJobs, *config.BadgerDatabaseDir)
+	fmt.Println("Set metadataBackend to badger to make Virtlet use the migrated metadata")
+}
+
+func doMetadataBackup(config *v1.VirtletConfig) {
+	if *config.MetadataBackend != "bolt" {
+		glog.Errorf("Metadata backups are only supported for the bolt metadata backend")
+		os.Exit(1)
+	}
+	if err := metadata.RetrieveBackup(metadata.BackupSocketPath, os.Stdout); err != nil {
+		glog.Errorf("Metadata backup failed: %v", err)
+		os.Exit(1)
+	}
+}
+
+func doMetadataRestore(config *v1.VirtletConfig) {
+	if *config.MetadataBackend != "bolt" {
+		glog.Errorf("Metadata restore is only supported for the bolt metadata backend")
+		os.Exit(1)
+	}
+	if err := metadata.StageRestore(*config.DatabasePath, os.Stdin); err != nil {
+		glog.Errorf("Metadata restore failed: %v", err)
+		os.Exit(1)
+	}
+	fmt.Println("The snapshot is valid and will replace the metadata database upon Virtlet restart")
+}
+
+// openReadOnlyMetadataStore opens the metadata database read-only.
+// If the database is in use by the running Virtlet process, a snapshot
+// is taken from that process and opened instead, so the running
+// Virtlet is never blocked. The returned function closes the store.
+func openReadOnlyMetadataStore(config *v1.VirtletConfig, clientCfg clientcmd.ClientConfig) (metadata.Store, func(), error) {
+	key, err := manager.MetadataEncryptionKey(config, clientCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := metadata.NewReadOnlyStore(*config.DatabasePath, key)
+	switch {
+	case err == nil:
+		return store, func() { store.Close() }, nil
+	case err != metadata.ErrDatabaseLocked:
+		return nil, nil, err
+	}
+
+	f, err := ioutil.TempFile("", "virtlet-metadata-")
+	if err != nil {
+		return nil, nil, err
+	}
+	err = metadata.RetrieveBackup(metadata.BackupSocketPath, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, nil, fmt.Errorf("error taking a metadata snapshot from the running Virtlet process: %v", err)
+	}
+	if store, err = metadata.NewReadOnlyStore(f.Name(), key); err != nil {
+		os.Remove(f.Name())
+		return nil, nil, err
+	}
+	return store, func() {
+		store.Close()
+		os.Remove(f.Name())
+	}, nil
+}
+
+func doMetadataExport(config *v1.VirtletConfig, clientCfg clientcmd.ClientConfig, readOnly bool) {
+	if *config.MetadataBackend != "bolt" {
+		glog.Errorf("Metadata export is only supported for the bolt metadata backend")
+		os.Exit(1)
+	}
+	export := func() error {
+		return metadata.ExportMetadata(metadata.BackupSocketPath, os.Stdout)
+	}
+	if readOnly {
+		export = func() error {
+			store, closeStore, err := openReadOnlyMetadataStore(config, clientCfg)
+			if err != nil {
+				return err
+			}
+			defer closeStore()
+			return store.(metadata.Exporter).Export(os.Stdout)
+		}
+	}
+	if err := export(); err != nil {
+		glog.Errorf("Metadata export failed: %v", err)
+		os.Exit(1)
+	}
+}
+
+func doMetadataImport(config *v1.VirtletConfig) {
+	if *config.MetadataBackend != "bolt" {
+		glog.Errorf("Metadata import is only supported for the bolt metadata backend")
+		os.Exit(1)
+	}
+	if err := metadata.ImportMetadata(metadata.BackupSocketPath, os.Stdin); err != nil {
+		glog.Errorf("Metadata import failed: %v", err)
+		os.Exit(1)
+	}
+	fmt.Println("The metadata has been imported")
+}
+
+func doMetadataCheck(config *v1.VirtletConfig, clientCfg clientcmd.ClientConfig, repair, readOnly bool) {
+	if *config.MetadataBackend != "bolt" {
+		glog.Errorf("Metadata check is only supported for the bolt metadata backend")
+		os.Exit(1)
+	}
+	check := func() (*metadata.CheckReport, error) {
+		return metadata.CheckMetadata(metadata.BackupSocketPath, repair)
+	}
+	if readOnly {
+		if repair {
+			glog.Errorf("The metadata can't be repaired in read-only mode")
+			os.Exit(1)
+		}
+		// the image store is not available here, so
+		// the images used by the containers aren't checked
+		check = func() (*metadata.CheckReport, error) {
+			store, closeStore, err := openReadOnlyMetadataStore(config, clientCfg)
+			if err != nil {
+				return nil, err
+			}
+			defer closeStore()
+			return store.(metadata.Checker).Check(nil, false)
+		}
+	}
+	report, err := check()
+	if err != nil {
+		glog.Errorf("Metadata check failed: %v", err)
+		os.Exit(1)
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+		glog.Errorf("Error writing the metadata check report: %v", err)
+		os.Exit(1)
+	}
+}
+
+func doMetadataCompact(config *v1.VirtletConfig) {
+	if *config.MetadataBackend != "bolt" {
+		glog.Errorf("Metadata compaction is only supported for the bolt metadata backend")
+		os.Exit(1)
+	}
+	if err := metadata.RequestCompaction(*config.DatabasePath); err != nil {
+		glog.Errorf("Metadata compaction request failed: %v", err)
+		os.Exit(1)
+	}
+	fmt.Println("The metadata database will be compacted upon Virtlet restart")
+}
+
 func main() {
 	nsfix.HandleReexec()
 	clientCfg := utils.BindFlags(flag.CommandLine)
@@ -153,6 +475,7 @@ func main() {
 	case *dumpConfig:
 		nodeConfig := config.NewNodeConfig(clientCfg)
 		nodeName := os.Getenv(nodeNameEnv)
+		localConfig = withConfigFile(localConfig)
 		cfg, err := nodeConfig.LoadConfig(localConfig, nodeName)
 		if err != nil {
 			glog.Warningf("Failed to load per-node configs, using local config only: %v", err)
@@ -164,9 +487,42 @@ func main() {
 		}
 	case *dumpDiag:
 		doDiag()
+	case *imageSave:
+		doImageSave(configWithDefaults(localConfig), flag.Args())
+	case *imageLoad:
+		doImageLoad(configWithDefaults(localConfig))
+	case *imagePull:
+		doImagePull(configWithDefaults(localConfig), clientCfg, flag.Args())
+	case *imageList:
+		doImageList(configWithDefaults(localConfig))
+	case *imageServer:
+		runImageServer(configWithDefaults(localConfig))
+	case *migrateDryRun:
+		doMigrateDryRun(configWithDefaults(localConfig), clientCfg)
+	case *migrateToBadger:
+		doMigrateToBadger(configWithDefaults(localConfig))
+	case *metadataBackup:
+		doMetadataBackup(configWithDefaults(localConfig))
+	case *metadataRestore:
+		doMetadataRestore(configWithDefaults(localConfig))
+	case *metadataExport:
+		doMetadataExport(configWithDefaults(localConfig), clientCfg, *metadataRO)
+	case *metadataImport:
+		doMetadataImport(configWithDefaults(localConfig))
+	case *metadataCheck, *metadataRepair:
+		doMetadataCheck(configWithDefaults(localConfig), clientCfg, *metadataRepair, *metadataRO)
+	case *metadataCompact:
+		doMetadataCompact(configWithDefaults(localConfig))
 	default:
-		localConfig = configWithDefaults(localConfig)
-		go runTapManager(localConfig)
+		if err := faults.SetupFromEnv(); err != nil {
+			glog.Errorf("Bad fault injection rules: %v", err)
+			os.Exit(1)
+		}
+		localConfig = configWithDefaults(withConfigFile(localConfig))
+		// the VM simulator provides its own pod networking
+		if !*localConfig.SimulateVMs {
+			go runTapManager(localConfig)
+		}
 		diagSet := runDiagServer()
 		runVirtlet(localConfig, clientCfg, diagSet)
 	}
@@ -45,6 +45,8 @@ func newRootCmd() *cobra.Command {
 
 	client := tools.NewRealKubeClient(clientCfg)
 	cmd.AddCommand(tools.NewVirshCmd(client, os.Stdout))
+	cmd.AddCommand(tools.NewCDROMCmd(client, os.Stdout))
+	cmd.AddCommand(tools.NewCpCmd(client, os.Stdin, os.Stdout))
 	cmd.AddCommand(tools.NewSSHCmd(client, os.Stdout, ""))
 	cmd.AddCommand(tools.NewVNCCmd(client, os.Stdout, true))
 	cmd.AddCommand(tools.NewInstallCmd(cmd, "", ""))
@@ -53,6 +55,23 @@ func newRootCmd() *cobra.Command {
 	cmd.AddCommand(tools.NewVersionCommand(client, os.Stdout, nil))
 	cmd.AddCommand(tools.NewDiagCommand(client, os.Stdin, os.Stdout))
 	cmd.AddCommand(tools.NewValidateCommand(client, os.Stdin))
+	cmd.AddCommand(tools.NewImageCmd(client, os.Stdin, os.Stdout))
+	cmd.AddCommand(tools.NewConsoleCmd(os.Stdin, os.Stdout, nil))
+	cmd.AddCommand(tools.NewStartHistoryCmd(client, os.Stdout))
+	cmd.AddCommand(tools.NewNetworkInfoCmd(client, os.Stdout))
+	cmd.AddCommand(tools.NewChannelCmd(client, os.Stdin, os.Stdout))
+	cmd.AddCommand(tools.NewConfirmDeleteCmd(client, os.Stdout))
+	cmd.AddCommand(tools.NewDescribeCmd(client, os.Stdout))
+	cmd.AddCommand(tools.NewDumpMemoryCmd(client, os.Stdout))
+	cmd.AddCommand(tools.NewRolloutImageCmd(client, os.Stdout, nil))
+	cmd.AddCommand(tools.NewMetadataCmd(client, os.Stdin, os.Stdout))
+	cmd.AddCommand(tools.NewDumpMetadataCmd(client, os.Stdout))
+	cmd.AddCommand(tools.NewLoadMetadataCmd(client, os.Stdin, os.Stdout))
+	cmd.AddCommand(tools.NewCheckMetadataCmd(client, os.Stdout))
+	cmd.AddCommand(tools.NewCompactMetadataCmd(client, os.Stdout))
+	cmd.AddCommand(tools.NewNodeCmd(client, os.Stdout))
+	cmd.AddCommand(tools.NewAdoptCmd(client, os.Stdout))
+	cmd.AddCommand(tools.NewExportCmd(client, os.Stdout))
 
 	for _, c := range cmd.Commands() {
 		c.PreRunE = func(*cobra.Command, []string) error {
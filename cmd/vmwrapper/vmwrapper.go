@@ -75,12 +75,18 @@ func main() {
 	// for cgroups that aren't managed by libvirt.
 	// If we don't do this, the VM pod will be killed by kubelet when Virtlet pod
 	// is removed dnd cgroup-per-qos is enabled in kubelet settings.
+	// With cgroup v2 unified hierarchy, all of the controllers are
+	// managed by libvirt, so there's nothing to move.
 	cm := cgroups.NewManager(os.Getpid(), nil)
-	for _, ctl := range []string{"hugetlb", "systemd", "pids"} {
-		if _, err := cm.GetProcessController(ctl); err == nil {
-			err = cm.MoveProcess(ctl, "/")
-			if err != nil {
-				glog.Warningf("failed to move pid into cgroup %q path /: %v", ctl, err)
+	if unified, err := cm.IsUnified(); err != nil {
+		glog.Warningf("failed to check cgroup hierarchy type: %v", err)
+	} else if !unified {
+		for _, ctl := range []string{"hugetlb", "systemd", "pids"} {
+			if _, err := cm.GetProcessController(ctl); err == nil {
+				err = cm.MoveProcess(ctl, "/")
+				if err != nil {
+					glog.Warningf("failed to move pid into cgroup %q path /: %v", ctl, err)
+				}
 			}
 		}
 	}
@@ -94,7 +100,18 @@ func main() {
 		emulator = defaultEmulator
 	} else {
 		netFdKey := os.Getenv(config.NetKeyEnvVarName)
+		nicDevice := "virtio-net-pci"
+		if nicModel := os.Getenv(config.NICModelEnvVarName); nicModel != "" {
+			// only e1000 is supported besides virtio for now,
+			// and its qemu device name matches the model name
+			nicDevice = nicModel
+		}
 		nextToUseHostdevNo := 0
+		// bootindex is only set for the first interface
+		bootIndexOpt := ""
+		if bootIndex := os.Getenv(config.NetBootIndexEnvVarName); bootIndex != "" {
+			bootIndexOpt = ",bootindex=" + bootIndex
+		}
 
 		if netFdKey != "" {
 			c := tapmanager.NewFDClient(fdSocketPath)
@@ -111,20 +128,24 @@ func main() {
 			}
 
 			for i, desc := range descriptions {
+				if i > 0 {
+					bootIndexOpt = ""
+				}
 				switch desc.Type {
 				case network.InterfaceTypeTap:
 					netArgs = append(netArgs,
 						"-netdev",
 						fmt.Sprintf("tap,id=tap%d,fd=%d", desc.FdIndex, fds[desc.FdIndex]),
 						"-device",
-						fmt.Sprintf("virtio-net-pci,netdev=tap%d,id=net%d,mac=%s", desc.FdIndex, i, desc.HardwareAddr),
+						fmt.Sprintf("%s,netdev=tap%d,id=net%d,mac=%s%s", nicDevice, desc.FdIndex, i, desc.HardwareAddr, bootIndexOpt),
 					)
 				case network.InterfaceTypeVF:
 					netArgs = append(netArgs,
 						"-device",
-						fmt.Sprintf("vfio-pci,host=%s,id=hostdev%d",
+						fmt.Sprintf("vfio-pci,host=%s,id=hostdev%d%s",
 							desc.PCIAddress[5:],
 							nextToUseHostdevNo,
+							bootIndexOpt,
 						),
 					)
 					nextToUseHostdevNo += 1
@@ -137,6 +158,10 @@ func main() {
 		}
 	}
 
+	if os.Getenv(config.DisableImageLockingEnvVarName) != "" {
+		emulatorArgs = utils.DisableQemuImageLocking(emulatorArgs)
+	}
+
 	args := append([]string{emulator}, emulatorArgs...)
 	args = append(args, netArgs...)
 	env := os.Environ()
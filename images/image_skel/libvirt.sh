@@ -34,6 +34,29 @@ else
   sed -i "/# @DEVS@/d" /etc/libvirt/qemu.conf
 fi
 
+function set_hugetlbfs_mounts() {
+  local mounts=() path
+  # libvirtd refuses to start if any of the listed paths isn't
+  # a hugetlbfs mount, so the unavailable ones are skipped here
+  for path in ${VIRTLET_HUGETLBFS_MOUNTS//,/ }; do
+    if [[ $(stat -f -c %T "${path}" 2>/dev/null) == hugetlbfs ]]; then
+      mounts+=("\"${path}\"")
+    else
+      echo "WARNING - hugetlbfs is not mounted at ${path}"
+    fi
+  done
+  sed -i "/^hugetlbfs_mount *=/d" /etc/libvirt/qemu.conf
+  if [[ ${#mounts[@]} -gt 0 ]]; then
+    local IFS=,
+    echo "hugetlbfs_mount = [${mounts[*]}]" >>/etc/libvirt/qemu.conf
+  fi
+}
+
+VIRTLET_HUGETLBFS_MOUNTS="${VIRTLET_HUGETLBFS_MOUNTS:-}"
+if [[ ${VIRTLET_HUGETLBFS_MOUNTS} ]]; then
+  set_hugetlbfs_mounts
+fi
+
 chown root:root /etc/libvirt/libvirtd.conf
 chown root:root /etc/libvirt/qemu.conf
 chmod 644 /etc/libvirt/libvirtd.conf
@@ -47,9 +47,15 @@ if [[ ! ${VIRTLET_DISABLE_KVM:-} ]]; then
     echo "Can't create /dev/kvm" >&2
   fi
   if ! kvm-ok; then
-    echo "*** VIRTLET_DISABLE_KVM is not set but KVM extensions are not available ***" >&2
-    echo "*** Virtlet startup failed ***" >&2
-    exit 1
+    if [[ ${VIRTLET_AUTO_DISABLE_KVM:-} ]]; then
+      echo "*** KVM extensions are not available, Virtlet will use TCG (software emulation) ***" >&2
+    else
+      echo "*** VIRTLET_DISABLE_KVM is not set but KVM extensions are not available ***" >&2
+      echo "*** Virtlet startup failed ***" >&2
+      exit 1
+    fi
+  fi
+  if [[ -e /dev/kvm ]]; then
+    chown libvirt-qemu.kvm /dev/kvm
   fi
-  chown libvirt-qemu.kvm /dev/kvm
 fi
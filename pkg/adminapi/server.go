@@ -0,0 +1,395 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adminapi
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcmetadata "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/Mirantis/virtlet/pkg/localapi"
+	"github.com/Mirantis/virtlet/pkg/metadata"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+const (
+	socketMode        = 0660
+	snapshotChunkSize = 64 * 1024
+)
+
+// Role determines the set of the operations a token grants
+// access to.
+type Role string
+
+const (
+	// RoleViewer grants access to the read-only operations
+	// that don't expose the VM contents
+	RoleViewer Role = "viewer"
+	// RoleOperator grants access to all the operations
+	RoleOperator Role = "operator"
+)
+
+// viewerMethods lists the methods that are available to the
+// viewer role. All the other methods require the operator role.
+var viewerMethods = map[string]bool{
+	fullMethodName("ListVMs"):          true,
+	fullMethodName("GetVMStats"):       true,
+	fullMethodName("GetSandboxEvents"): true,
+}
+
+// VMController provides access to the VMs for the admin API.
+type VMController interface {
+	localapi.VMController
+	// VMStats returns the resource usage of the VM
+	VMStats(containerID string, name string) (*types.VMStats, error)
+	// DomainXML returns the current definition of the VM domain
+	// in libvirt
+	DomainXML(containerID string) (string, error)
+	// SandboxEvents returns the lifecycle event history of the
+	// pod sandbox
+	SandboxEvents(podID string) ([]*types.SandboxEvent, error)
+	// SnapshotContainer takes a snapshot of the VM and returns
+	// the name of the snapshot, which is generated if name is empty
+	SnapshotContainer(containerID, name string) (string, error)
+	// AttachHostBlockDevice attaches the host block device to the
+	// running VM and returns the target device name of the disk
+	AttachHostBlockDevice(containerID, path string) (string, error)
+}
+
+// LoadTokens reads the tokens from the specified file. Each
+// non-empty line of the file that doesn't start with '#' must
+// contain a token and a role separated by a comma, e.g.
+// "s3cr3t,operator".
+func LoadTokens(path string) (map[string]Role, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the token file: %v", err)
+	}
+	tokens := make(map[string]Role)
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Split(line, ",")
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("%s:%d: expected token,role", path, n+1)
+		}
+		role := Role(strings.TrimSpace(parts[1]))
+		if role != RoleViewer && role != RoleOperator {
+			return nil, fmt.Errorf("%s:%d: bad role %q", path, n+1, role)
+		}
+		tokens[strings.TrimSpace(parts[0])] = role
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens in the token file %q", path)
+	}
+	return tokens, nil
+}
+
+// Server provides a node-local gRPC admin API for the VMs that
+// listens on a unix domain socket. The clients must present the
+// bearer token in the "authorization" metadata key, with the role
+// of the token determining the operations that are allowed.
+type Server struct {
+	vmc      VMController
+	watcher  localapi.ConsoleWatcher
+	backuper metadata.Backuper
+	tokens   map[string]Role
+	server   *grpc.Server
+}
+
+var _ AdminServer = &Server{}
+
+// NewServer makes a new admin API server. watcher may be nil, in
+// which case the console streaming is not available. backuper may
+// be nil if the metadata store doesn't support the snapshots.
+func NewServer(vmc VMController, watcher localapi.ConsoleWatcher, backuper metadata.Backuper, tokens map[string]Role) *Server {
+	s := &Server{vmc: vmc, watcher: watcher, backuper: backuper, tokens: tokens}
+	s.server = grpc.NewServer(
+		grpc.CustomCodec(jsonCodec{}),
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := s.authorize(ctx, info.FullMethod); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := s.authorize(ss.Context(), info.FullMethod); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}))
+	s.server.RegisterService(&serviceDesc, s)
+	return s
+}
+
+// Serve makes the server listen on the specified socket path.
+// This function doesn't return till the server stops listening.
+func (s *Server) Serve(socketPath string) error {
+	if err := syscall.Unlink(socketPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(socketPath, socketMode); err != nil {
+		ln.Close()
+		return fmt.Errorf("can't set the permissions of %q: %v", socketPath, err)
+	}
+	return s.server.Serve(ln)
+}
+
+// Stop stops the server.
+func (s *Server) Stop() {
+	s.server.Stop()
+}
+
+func (s *Server) authorize(ctx context.Context, fullMethod string) error {
+	md, _ := grpcmetadata.FromIncomingContext(ctx)
+	var role Role
+	for _, auth := range md["authorization"] {
+		if !strings.HasPrefix(auth, "Bearer ") {
+			continue
+		}
+		token := []byte(strings.TrimPrefix(auth, "Bearer "))
+		for t, r := range s.tokens {
+			if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+				role = r
+			}
+		}
+	}
+	switch {
+	case role == "":
+		return status.Error(codes.Unauthenticated, "unauthorized")
+	case role != RoleOperator && !viewerMethods[fullMethod]:
+		return status.Errorf(codes.PermissionDenied, "%s role is not allowed to call %s", role, fullMethod)
+	}
+	return nil
+}
+
+func (s *Server) containerInfo(id string) (*types.ContainerInfo, error) {
+	ci, err := s.vmc.ContainerInfo(id)
+	switch {
+	case err == virt.ErrDomainNotFound || (err == nil && ci == nil):
+		return nil, status.Errorf(codes.NotFound, "VM %q not found", id)
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return ci, nil
+}
+
+// ListVMs implements ListVMs method of AdminServer interface.
+func (s *Server) ListVMs(ctx context.Context, req *Empty) (*ListVMsResponse, error) {
+	containers, err := s.vmc.ListContainers(nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &ListVMsResponse{VMs: []*localapi.VMInfo{}}
+	for _, ci := range containers {
+		resp.VMs = append(resp.VMs, localapi.NewVMInfo(ci, s.watcher))
+	}
+	return resp, nil
+}
+
+// GetVMStats implements GetVMStats method of AdminServer interface.
+func (s *Server) GetVMStats(ctx context.Context, req *VMRequest) (*VMStats, error) {
+	ci, err := s.containerInfo(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	if ci.State != types.ContainerState_CONTAINER_RUNNING {
+		return nil, status.Errorf(codes.FailedPrecondition, "VM %q is not running", req.ID)
+	}
+	stats, err := s.vmc.VMStats(req.ID, ci.Name)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &VMStats{
+		ID:              req.ID,
+		Timestamp:       stats.Timestamp,
+		CPUUsage:        stats.CpuUsage,
+		MemoryUsage:     stats.MemoryUsage,
+		MemoryAvailable: stats.MemoryAvailable,
+		FsBytes:         stats.FsBytes,
+	}, nil
+}
+
+// RebootVM implements RebootVM method of AdminServer interface.
+func (s *Server) RebootVM(ctx context.Context, req *VMRequest) (*Empty, error) {
+	ci, err := s.containerInfo(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.vmc.RebootContainer(req.ID); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	glog.V(1).Infof("VM %q (pod %s/%s) rebooted via the admin API", req.ID, ci.Config.PodNamespace, ci.Config.PodName)
+	return &Empty{}, nil
+}
+
+// GetVMDomainXML implements GetVMDomainXML method of AdminServer
+// interface.
+func (s *Server) GetVMDomainXML(ctx context.Context, req *VMRequest) (*VMDomainXML, error) {
+	ci, err := s.containerInfo(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	liveXML, err := s.vmc.DomainXML(req.ID)
+	switch {
+	case err == virt.ErrDomainNotFound:
+		return nil, status.Errorf(codes.NotFound, "VM %q not found", req.ID)
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &VMDomainXML{
+		ID:        req.ID,
+		StoredXML: ci.DomainXML,
+		LiveXML:   liveXML,
+		Drifted:   ci.DomainXML != "" && ci.DomainXML != liveXML,
+	}, nil
+}
+
+// SnapshotVM implements SnapshotVM method of AdminServer interface.
+func (s *Server) SnapshotVM(ctx context.Context, req *SnapshotVMRequest) (*SnapshotVMResponse, error) {
+	ci, err := s.containerInfo(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	name, err := s.vmc.SnapshotContainer(req.ID, req.Name)
+	switch {
+	case err == virt.ErrDomainNotFound:
+		return nil, status.Errorf(codes.NotFound, "VM %q not found", req.ID)
+	case err != nil:
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	glog.V(1).Infof("Snapshot %q of VM %q (pod %s/%s) taken via the admin API", name, req.ID, ci.Config.PodNamespace, ci.Config.PodName)
+	return &SnapshotVMResponse{Name: name}, nil
+}
+
+// MigrateVM implements MigrateVM method of AdminServer interface.
+// The method is reserved in the API, but the live migration isn't
+// supported as the metadata, the volumes and the network setup of
+// the VM are local to the node. The VM pods are moved between the
+// nodes by re-creating them.
+func (s *Server) MigrateVM(ctx context.Context, req *MigrateVMRequest) (*Empty, error) {
+	return nil, status.Error(codes.Unimplemented, "VM migration is not supported by Virtlet")
+}
+
+// HotplugDevice implements HotplugDevice method of AdminServer
+// interface.
+func (s *Server) HotplugDevice(ctx context.Context, req *HotplugDeviceRequest) (*HotplugDeviceResponse, error) {
+	if req.Type != "disk" {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported device type %q", req.Type)
+	}
+	if req.Source == "" {
+		return nil, status.Error(codes.InvalidArgument, "device source must be specified")
+	}
+	ci, err := s.containerInfo(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := s.vmc.AttachHostBlockDevice(req.ID, req.Source)
+	switch {
+	case err == virt.ErrDomainNotFound:
+		return nil, status.Errorf(codes.NotFound, "VM %q not found", req.ID)
+	case err != nil:
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	glog.V(1).Infof("Host device %q attached to VM %q (pod %s/%s) via the admin API", req.Source, req.ID, ci.Config.PodNamespace, ci.Config.PodName)
+	return &HotplugDeviceResponse{Target: target}, nil
+}
+
+// GetSandboxEvents implements GetSandboxEvents method of AdminServer
+// interface. The history is kept for the removed pod sandboxes, too,
+// so no error is returned for the unknown pod sandboxes.
+func (s *Server) GetSandboxEvents(ctx context.Context, req *SandboxRequest) (*SandboxEventsResponse, error) {
+	events, err := s.vmc.SandboxEvents(req.PodID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &SandboxEventsResponse{Events: []*SandboxEvent{}}
+	for _, event := range events {
+		resp.Events = append(resp.Events, &SandboxEvent{
+			ID:          event.ID,
+			PodID:       event.PodSandboxID,
+			Timestamp:   event.Timestamp,
+			Type:        event.Type,
+			ContainerID: event.ContainerID,
+			Message:     event.Message,
+		})
+	}
+	return resp, nil
+}
+
+// chunkWriter sends each write as a separate chunk.
+type chunkWriter struct {
+	sender ChunkSender
+}
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	if err := w.sender.Send(&Chunk{Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// StreamConsole implements StreamConsole method of AdminServer
+// interface.
+func (s *Server) StreamConsole(req *VMRequest, sender ChunkSender) error {
+	if s.watcher == nil {
+		return status.Error(codes.Unavailable, "console streaming is disabled")
+	}
+	if _, err := s.containerInfo(req.ID); err != nil {
+		return err
+	}
+	if err := s.watcher.WatchConsole(req.ID, chunkWriter{sender}, sender.Context().Done()); err != nil {
+		glog.V(1).Infof("Console streaming for VM %q stopped: %v", req.ID, err)
+	}
+	return nil
+}
+
+// SnapshotMetadata implements SnapshotMetadata method of
+// AdminServer interface.
+func (s *Server) SnapshotMetadata(req *Empty, sender ChunkSender) error {
+	if s.backuper == nil {
+		return status.Error(codes.Unavailable, "the metadata backend doesn't support the snapshots")
+	}
+	w := bufio.NewWriterSize(chunkWriter{sender}, snapshotChunkSize)
+	if _, err := s.backuper.Backup(w); err != nil {
+		return status.Errorf(codes.Internal, "metadata backup failed: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	glog.V(1).Infof("Metadata snapshot taken via the admin API")
+	return nil
+}
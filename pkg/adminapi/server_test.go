@@ -0,0 +1,520 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adminapi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Mirantis/virtlet/pkg/client"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+const (
+	viewerToken   = "v13w3r"
+	operatorToken = "0p3r4t0r"
+	runningVMID   = "231700d5-c9a6-5a49-738d-99a954c51550"
+	stoppedVMID   = "14a4d9a8-1ef3-4ac3-6d8c-d4e4fc1d2ab7"
+)
+
+type fakeVMController struct {
+	containers    []*types.ContainerInfo
+	domainXMLs    map[string]string
+	sandboxEvents []*types.SandboxEvent
+	rebooted      []string
+	snapshots     []string
+	attached      []string
+}
+
+var _ VMController = &fakeVMController{}
+
+func (c *fakeVMController) ListContainers(filter *types.ContainerFilter) ([]*types.ContainerInfo, error) {
+	return c.containers, nil
+}
+
+func (c *fakeVMController) ContainerInfo(containerID string) (*types.ContainerInfo, error) {
+	for _, ci := range c.containers {
+		if ci.Id == containerID {
+			return ci, nil
+		}
+	}
+	return nil, virt.ErrDomainNotFound
+}
+
+func (c *fakeVMController) RebootContainer(containerID string) error {
+	ci, _ := c.ContainerInfo(containerID)
+	if ci.State != types.ContainerState_CONTAINER_RUNNING {
+		return fmt.Errorf("domain %q is not running", containerID)
+	}
+	c.rebooted = append(c.rebooted, containerID)
+	return nil
+}
+
+func (c *fakeVMController) VMStats(containerID string, name string) (*types.VMStats, error) {
+	return &types.VMStats{
+		ContainerID:     containerID,
+		Name:            name,
+		Timestamp:       1496175542000000000,
+		CpuUsage:        4200000000,
+		MemoryUsage:     512 * 1024 * 1024,
+		MemoryAvailable: 1024 * 1024 * 1024,
+		FsBytes:         10 * 1024 * 1024,
+	}, nil
+}
+
+func (c *fakeVMController) DomainXML(containerID string) (string, error) {
+	domainXML, found := c.domainXMLs[containerID]
+	if !found {
+		return "", virt.ErrDomainNotFound
+	}
+	return domainXML, nil
+}
+
+func (c *fakeVMController) SandboxEvents(podID string) ([]*types.SandboxEvent, error) {
+	var events []*types.SandboxEvent
+	for _, event := range c.sandboxEvents {
+		if podID == "" || event.PodSandboxID == podID {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (c *fakeVMController) SnapshotContainer(containerID, name string) (string, error) {
+	if _, err := c.ContainerInfo(containerID); err != nil {
+		return "", err
+	}
+	if name == "" {
+		name = "virtlet-20170530-200542"
+	}
+	c.snapshots = append(c.snapshots, containerID+"/"+name)
+	return name, nil
+}
+
+func (c *fakeVMController) AttachHostBlockDevice(containerID, path string) (string, error) {
+	ci, err := c.ContainerInfo(containerID)
+	if err != nil {
+		return "", err
+	}
+	if ci.State != types.ContainerState_CONTAINER_RUNNING {
+		return "", fmt.Errorf("domain %q is not running", containerID)
+	}
+	c.attached = append(c.attached, containerID+":"+path)
+	return "vdc", nil
+}
+
+type fakeConsoleWatcher struct{}
+
+func (w fakeConsoleWatcher) WatchConsole(containerID string, out io.Writer, stopCh <-chan struct{}) error {
+	_, err := fmt.Fprintf(out, "console output of %s\n", containerID)
+	return err
+}
+
+type fakeBackuper struct{}
+
+func (b fakeBackuper) Backup(w io.Writer) (int64, error) {
+	n, err := w.Write(bytes.Repeat([]byte("snapshot"), snapshotChunkSize/4))
+	return int64(n), err
+}
+
+func errorCode(err error) codes.Code {
+	st, _ := status.FromError(err)
+	return st.Code()
+}
+
+func TestLoadTokens(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "virtlet-adminapi")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	for _, tc := range []struct {
+		name           string
+		contents       string
+		expectedTokens map[string]Role
+		errSubstring   string
+	}{
+		{
+			name:     "valid file",
+			contents: "# tokens\nabc,viewer\n\n def , operator \n",
+			expectedTokens: map[string]Role{
+				"abc": RoleViewer,
+				"def": RoleOperator,
+			},
+		},
+		{
+			name:         "bad role",
+			contents:     "abc,admin\n",
+			errSubstring: "bad role",
+		},
+		{
+			name:         "no role",
+			contents:     "abc\n",
+			errSubstring: "expected token,role",
+		},
+		{
+			name:         "empty file",
+			contents:     "# no tokens\n",
+			errSubstring: "no tokens",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(tmpDir, "tokens")
+			if err := ioutil.WriteFile(path, []byte(tc.contents), 0600); err != nil {
+				t.Fatalf("WriteFile(): %v", err)
+			}
+			tokens, err := LoadTokens(path)
+			switch {
+			case tc.errSubstring == "" && err != nil:
+				t.Errorf("LoadTokens(): %v", err)
+			case tc.errSubstring != "" && (err == nil || !strings.Contains(err.Error(), tc.errSubstring)):
+				t.Errorf("didn't get the expected error (substring %q): %v", tc.errSubstring, err)
+			case !reflect.DeepEqual(tokens, tc.expectedTokens):
+				t.Errorf("bad tokens: %#v instead of %#v", tokens, tc.expectedTokens)
+			}
+		})
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	vmc := &fakeVMController{
+		containers: []*types.ContainerInfo{
+			{
+				Id:        runningVMID,
+				Name:      "vm",
+				CreatedAt: 1496175540000000000,
+				StartedAt: 1496175541000000000,
+				State:     types.ContainerState_CONTAINER_RUNNING,
+				Config: types.VMConfig{
+					PodSandboxID: "69eec606-0493-5825-73a4-c5e0c0236155",
+					PodName:      "testvm",
+					PodNamespace: "default",
+					Image:        "cirros",
+				},
+				DomainXML: "<domain><vcpu>1</vcpu></domain>",
+			},
+			{
+				Id:        stoppedVMID,
+				Name:      "vm",
+				CreatedAt: 1496175540000000000,
+				State:     types.ContainerState_CONTAINER_EXITED,
+				Config: types.VMConfig{
+					PodSandboxID: "cd0e1c3e-0b8c-5bbb-4b5e-5e6ad6b3d4a1",
+					PodName:      "stoppedvm",
+					PodNamespace: "default",
+					Image:        "cirros",
+				},
+				DomainXML: "<domain><vcpu>1</vcpu></domain>",
+			},
+		},
+		domainXMLs: map[string]string{
+			runningVMID: "<domain><vcpu>1</vcpu></domain>",
+			stoppedVMID: "<domain><vcpu>2</vcpu></domain>",
+		},
+		sandboxEvents: []*types.SandboxEvent{
+			{
+				ID:           1,
+				PodSandboxID: "69eec606-0493-5825-73a4-c5e0c0236155",
+				Timestamp:    1496175539000000000,
+				Type:         types.SandboxEventNetworkSetUp,
+			},
+			{
+				ID:           2,
+				PodSandboxID: "cd0e1c3e-0b8c-5bbb-4b5e-5e6ad6b3d4a1",
+				Timestamp:    1496175539500000000,
+				Type:         types.SandboxEventNetworkSetupFailed,
+				Message:      "no IP addresses available",
+			},
+			{
+				ID:           3,
+				PodSandboxID: "69eec606-0493-5825-73a4-c5e0c0236155",
+				Timestamp:    1496175541000000000,
+				Type:         types.SandboxEventVMStarted,
+				ContainerID:  runningVMID,
+			},
+		},
+	}
+
+	tmpDir, err := ioutil.TempDir("", "virtlet-adminapi")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	socketPath := filepath.Join(tmpDir, "admin.sock")
+
+	s := NewServer(vmc, fakeConsoleWatcher{}, fakeBackuper{}, map[string]Role{
+		viewerToken:   RoleViewer,
+		operatorToken: RoleOperator,
+	})
+	go s.Serve(socketPath)
+	defer s.Stop()
+
+	newClient := func(token string) *client.Client {
+		c, err := client.New(client.Config{AdminSocketPath: socketPath, Token: token})
+		if err != nil {
+			t.Fatalf("client.New(): %v", err)
+		}
+		return c
+	}
+	viewer := newClient(viewerToken)
+	defer viewer.Close()
+	operator := newClient(operatorToken)
+	defer operator.Close()
+	stranger := newClient("foobar")
+	defer stranger.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for i := 0; ; i++ {
+		// wait for the server to start listening
+		if _, err = viewer.ListVMs(ctx); err == nil || i == 50 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("ListVMs(): %v", err)
+	}
+
+	t.Run("list", func(t *testing.T) {
+		vms, err := viewer.ListVMs(ctx)
+		if err != nil {
+			t.Fatalf("ListVMs(): %v", err)
+		}
+		var names []string
+		for _, vm := range vms {
+			names = append(names, vm.PodName+":"+vm.State)
+		}
+		expectedNames := []string{"testvm:running", "stoppedvm:exited"}
+		if !reflect.DeepEqual(names, expectedNames) {
+			t.Errorf("bad VM list: %v instead of %v", names, expectedNames)
+		}
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		stats, err := viewer.GetVMStats(ctx, runningVMID)
+		if err != nil {
+			t.Fatalf("GetVMStats(): %v", err)
+		}
+		expectedStats := &client.VMStats{
+			ID:              runningVMID,
+			Timestamp:       1496175542000000000,
+			CPUUsage:        4200000000,
+			MemoryUsage:     512 * 1024 * 1024,
+			MemoryAvailable: 1024 * 1024 * 1024,
+			FsBytes:         10 * 1024 * 1024,
+		}
+		if !reflect.DeepEqual(stats, expectedStats) {
+			t.Errorf("bad stats: %#v instead of %#v", stats, expectedStats)
+		}
+		if _, err := viewer.GetVMStats(ctx, stoppedVMID); errorCode(err) != codes.FailedPrecondition {
+			t.Errorf("GetVMStats() for a stopped VM: unexpected error %v", err)
+		}
+		if _, err := viewer.GetVMStats(ctx, "nosuchvm"); errorCode(err) != codes.NotFound {
+			t.Errorf("GetVMStats() for a nonexistent VM: unexpected error %v", err)
+		}
+	})
+
+	t.Run("domain xml", func(t *testing.T) {
+		for _, tc := range []struct {
+			id       string
+			expected *client.VMDomainXML
+		}{
+			{
+				id: runningVMID,
+				expected: &client.VMDomainXML{
+					ID:        runningVMID,
+					StoredXML: "<domain><vcpu>1</vcpu></domain>",
+					LiveXML:   "<domain><vcpu>1</vcpu></domain>",
+				},
+			},
+			{
+				id: stoppedVMID,
+				expected: &client.VMDomainXML{
+					ID:        stoppedVMID,
+					StoredXML: "<domain><vcpu>1</vcpu></domain>",
+					LiveXML:   "<domain><vcpu>2</vcpu></domain>",
+					Drifted:   true,
+				},
+			},
+		} {
+			domainXML, err := operator.GetVMDomainXML(ctx, tc.id)
+			if err != nil {
+				t.Fatalf("GetVMDomainXML(): %v", err)
+			}
+			if !reflect.DeepEqual(domainXML, tc.expected) {
+				t.Errorf("bad domain xml: %#v instead of %#v", domainXML, tc.expected)
+			}
+		}
+		if _, err := operator.GetVMDomainXML(ctx, "nosuchvm"); errorCode(err) != codes.NotFound {
+			t.Errorf("GetVMDomainXML() for a nonexistent VM: unexpected error %v", err)
+		}
+	})
+
+	t.Run("sandbox events", func(t *testing.T) {
+		events, err := viewer.GetSandboxEvents(ctx, "69eec606-0493-5825-73a4-c5e0c0236155")
+		if err != nil {
+			t.Fatalf("GetSandboxEvents(): %v", err)
+		}
+		expectedEvents := []*client.SandboxEvent{
+			{
+				ID:        1,
+				PodID:     "69eec606-0493-5825-73a4-c5e0c0236155",
+				Timestamp: 1496175539000000000,
+				Type:      "NetworkSetUp",
+			},
+			{
+				ID:          3,
+				PodID:       "69eec606-0493-5825-73a4-c5e0c0236155",
+				Timestamp:   1496175541000000000,
+				Type:        "VMStarted",
+				ContainerID: runningVMID,
+			},
+		}
+		if !reflect.DeepEqual(events, expectedEvents) {
+			t.Errorf("bad sandbox events: %#v instead of %#v", events, expectedEvents)
+		}
+		if events, err := viewer.GetSandboxEvents(ctx, ""); err != nil {
+			t.Errorf("GetSandboxEvents() for all the pod sandboxes: %v", err)
+		} else if len(events) != 3 {
+			t.Errorf("bad number of events for all the pod sandboxes: %d instead of 3", len(events))
+		}
+		if events, err := viewer.GetSandboxEvents(ctx, "nosuchpod"); err != nil || len(events) != 0 {
+			t.Errorf("GetSandboxEvents() for a nonexistent pod sandbox: %#v, %v", events, err)
+		}
+	})
+
+	t.Run("console", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := operator.StreamConsole(ctx, runningVMID, &out); err != nil {
+			t.Fatalf("StreamConsole(): %v", err)
+		}
+		if expected := "console output of " + runningVMID + "\n"; out.String() != expected {
+			t.Errorf("bad console output %q instead of %q", out.String(), expected)
+		}
+	})
+
+	t.Run("metadata snapshot", func(t *testing.T) {
+		var out bytes.Buffer
+		if err := operator.SnapshotMetadata(ctx, &out); err != nil {
+			t.Fatalf("SnapshotMetadata(): %v", err)
+		}
+		if expected := bytes.Repeat([]byte("snapshot"), snapshotChunkSize/4); !bytes.Equal(out.Bytes(), expected) {
+			t.Errorf("bad snapshot (%d bytes instead of %d)", out.Len(), len(expected))
+		}
+	})
+
+	t.Run("reboot", func(t *testing.T) {
+		if err := operator.RebootVM(ctx, runningVMID); err != nil {
+			t.Errorf("RebootVM(): %v", err)
+		}
+		if err := operator.RebootVM(ctx, stoppedVMID); errorCode(err) != codes.FailedPrecondition {
+			t.Errorf("RebootVM() for a stopped VM: unexpected error %v", err)
+		}
+		if expected := []string{runningVMID}; !reflect.DeepEqual(vmc.rebooted, expected) {
+			t.Errorf("bad list of the rebooted VMs: %v instead of %v", vmc.rebooted, expected)
+		}
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		name, err := operator.SnapshotVM(ctx, runningVMID, "")
+		if err != nil {
+			t.Fatalf("SnapshotVM(): %v", err)
+		}
+		if name != "virtlet-20170530-200542" {
+			t.Errorf("bad generated snapshot name %q", name)
+		}
+		if name, err = operator.SnapshotVM(ctx, stoppedVMID, "before-upgrade"); err != nil {
+			t.Errorf("SnapshotVM() with a name: %v", err)
+		} else if name != "before-upgrade" {
+			t.Errorf("bad snapshot name %q", name)
+		}
+		if _, err := operator.SnapshotVM(ctx, "nosuchvm", ""); errorCode(err) != codes.NotFound {
+			t.Errorf("SnapshotVM() for a nonexistent VM: unexpected error %v", err)
+		}
+		expected := []string{runningVMID + "/virtlet-20170530-200542", stoppedVMID + "/before-upgrade"}
+		if !reflect.DeepEqual(vmc.snapshots, expected) {
+			t.Errorf("bad list of the snapshots: %v instead of %v", vmc.snapshots, expected)
+		}
+	})
+
+	t.Run("migrate", func(t *testing.T) {
+		if err := operator.MigrateVM(ctx, runningVMID, "node2"); errorCode(err) != codes.Unimplemented {
+			t.Errorf("MigrateVM(): unexpected error %v", err)
+		}
+	})
+
+	t.Run("hotplug", func(t *testing.T) {
+		target, err := operator.HotplugDevice(ctx, runningVMID, "/dev/sdb")
+		if err != nil {
+			t.Fatalf("HotplugDevice(): %v", err)
+		}
+		if target != "vdc" {
+			t.Errorf("bad target device %q", target)
+		}
+		if _, err := operator.HotplugDevice(ctx, stoppedVMID, "/dev/sdb"); errorCode(err) != codes.FailedPrecondition {
+			t.Errorf("HotplugDevice() for a stopped VM: unexpected error %v", err)
+		}
+		if _, err := operator.HotplugDevice(ctx, runningVMID, ""); errorCode(err) != codes.InvalidArgument {
+			t.Errorf("HotplugDevice() without the source: unexpected error %v", err)
+		}
+		if expected := []string{runningVMID + ":/dev/sdb"}; !reflect.DeepEqual(vmc.attached, expected) {
+			t.Errorf("bad list of the attached devices: %v instead of %v", vmc.attached, expected)
+		}
+	})
+
+	t.Run("authorization", func(t *testing.T) {
+		if _, err := stranger.ListVMs(ctx); errorCode(err) != codes.Unauthenticated {
+			t.Errorf("ListVMs() with a bad token: unexpected error %v", err)
+		}
+		if err := viewer.RebootVM(ctx, runningVMID); errorCode(err) != codes.PermissionDenied {
+			t.Errorf("RebootVM() by a viewer: unexpected error %v", err)
+		}
+		if err := viewer.StreamConsole(ctx, runningVMID, ioutil.Discard); errorCode(err) != codes.PermissionDenied {
+			t.Errorf("StreamConsole() by a viewer: unexpected error %v", err)
+		}
+		if err := viewer.SnapshotMetadata(ctx, ioutil.Discard); errorCode(err) != codes.PermissionDenied {
+			t.Errorf("SnapshotMetadata() by a viewer: unexpected error %v", err)
+		}
+		if _, err := viewer.GetVMDomainXML(ctx, runningVMID); errorCode(err) != codes.PermissionDenied {
+			t.Errorf("GetVMDomainXML() by a viewer: unexpected error %v", err)
+		}
+		if _, err := viewer.SnapshotVM(ctx, runningVMID, ""); errorCode(err) != codes.PermissionDenied {
+			t.Errorf("SnapshotVM() by a viewer: unexpected error %v", err)
+		}
+		if _, err := viewer.HotplugDevice(ctx, runningVMID, "/dev/sdb"); errorCode(err) != codes.PermissionDenied {
+			t.Errorf("HotplugDevice() by a viewer: unexpected error %v", err)
+		}
+		if expected := []string{runningVMID}; !reflect.DeepEqual(vmc.rebooted, expected) {
+			t.Errorf("bad list of the rebooted VMs: %v instead of %v", vmc.rebooted, expected)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adminapi
+
+import (
+	"encoding/json"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// AdminServer is the server API of the admin service.
+type AdminServer interface {
+	// ListVMs returns the list of the VMs on the node
+	ListVMs(context.Context, *Empty) (*ListVMsResponse, error)
+	// GetVMStats returns the resource usage of a VM
+	GetVMStats(context.Context, *VMRequest) (*VMStats, error)
+	// RebootVM asks the guest OS of a VM to reboot
+	RebootVM(context.Context, *VMRequest) (*Empty, error)
+	// GetVMDomainXML returns the libvirt domain definition of a VM
+	GetVMDomainXML(context.Context, *VMRequest) (*VMDomainXML, error)
+	// SnapshotVM takes a snapshot of a VM
+	SnapshotVM(context.Context, *SnapshotVMRequest) (*SnapshotVMResponse, error)
+	// MigrateVM moves a VM to another node
+	MigrateVM(context.Context, *MigrateVMRequest) (*Empty, error)
+	// HotplugDevice attaches a host device to a running VM
+	HotplugDevice(context.Context, *HotplugDeviceRequest) (*HotplugDeviceResponse, error)
+	// GetSandboxEvents returns the lifecycle event history of
+	// a pod sandbox
+	GetSandboxEvents(context.Context, *SandboxRequest) (*SandboxEventsResponse, error)
+	// StreamConsole streams the console output of a VM
+	// until the client cancels the call
+	StreamConsole(*VMRequest, ChunkSender) error
+	// SnapshotMetadata streams a snapshot of the Virtlet
+	// metadata database
+	SnapshotMetadata(*Empty, ChunkSender) error
+}
+
+// ChunkSender is used by the streaming calls to send the data
+// to the client.
+type ChunkSender interface {
+	grpc.ServerStream
+	// Send sends a chunk of data to the client
+	Send(*Chunk) error
+}
+
+type chunkSender struct {
+	grpc.ServerStream
+}
+
+func (s chunkSender) Send(chunk *Chunk) error {
+	return s.ServerStream.SendMsg(chunk)
+}
+
+// jsonCodec encodes the messages of the admin service as JSON
+// instead of protobuf. This keeps the API usable without the
+// code generated from the .proto files, with the messages
+// being described by the Go types in this package.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) String() string {
+	return "json"
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func fullMethodName(method string) string {
+	return "/" + ServiceName + "/" + method
+}
+
+func unaryMethod(name string, newReq func() interface{}, call func(srv AdminServer, ctx context.Context, req interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newReq()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(AdminServer), ctx, req)
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethodName(name)}, handler)
+		},
+	}
+}
+
+func streamMethod(name string, newReq func() interface{}, call func(srv AdminServer, req interface{}, sender ChunkSender) error) grpc.StreamDesc {
+	return grpc.StreamDesc{
+		StreamName:    name,
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			req := newReq()
+			if err := stream.RecvMsg(req); err != nil {
+				return err
+			}
+			return call(srv.(AdminServer), req, chunkSender{stream})
+		},
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("ListVMs", func() interface{} { return &Empty{} }, func(srv AdminServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.ListVMs(ctx, req.(*Empty))
+		}),
+		unaryMethod("GetVMStats", func() interface{} { return &VMRequest{} }, func(srv AdminServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.GetVMStats(ctx, req.(*VMRequest))
+		}),
+		unaryMethod("RebootVM", func() interface{} { return &VMRequest{} }, func(srv AdminServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.RebootVM(ctx, req.(*VMRequest))
+		}),
+		unaryMethod("GetVMDomainXML", func() interface{} { return &VMRequest{} }, func(srv AdminServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.GetVMDomainXML(ctx, req.(*VMRequest))
+		}),
+		unaryMethod("SnapshotVM", func() interface{} { return &SnapshotVMRequest{} }, func(srv AdminServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.SnapshotVM(ctx, req.(*SnapshotVMRequest))
+		}),
+		unaryMethod("MigrateVM", func() interface{} { return &MigrateVMRequest{} }, func(srv AdminServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.MigrateVM(ctx, req.(*MigrateVMRequest))
+		}),
+		unaryMethod("HotplugDevice", func() interface{} { return &HotplugDeviceRequest{} }, func(srv AdminServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.HotplugDevice(ctx, req.(*HotplugDeviceRequest))
+		}),
+		unaryMethod("GetSandboxEvents", func() interface{} { return &SandboxRequest{} }, func(srv AdminServer, ctx context.Context, req interface{}) (interface{}, error) {
+			return srv.GetSandboxEvents(ctx, req.(*SandboxRequest))
+		}),
+	},
+	Streams: []grpc.StreamDesc{
+		streamMethod("StreamConsole", func() interface{} { return &VMRequest{} }, func(srv AdminServer, req interface{}, sender ChunkSender) error {
+			return srv.StreamConsole(req.(*VMRequest), sender)
+		}),
+		streamMethod("SnapshotMetadata", func() interface{} { return &Empty{} }, func(srv AdminServer, req interface{}, sender ChunkSender) error {
+			return srv.SnapshotMetadata(req.(*Empty), sender)
+		}),
+	},
+	Metadata: "adminapi",
+}
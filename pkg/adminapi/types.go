@@ -0,0 +1,158 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adminapi
+
+import (
+	"github.com/Mirantis/virtlet/pkg/localapi"
+)
+
+// ServiceName is the full name of the version 1 of the admin
+// gRPC service. Incompatible changes of the API must be done in
+// a new version of the service.
+const ServiceName = "virtlet.admin.v1.Admin"
+
+// Empty is used for the requests and the responses that carry
+// no data.
+type Empty struct{}
+
+// VMRequest identifies the VM the operation applies to.
+type VMRequest struct {
+	// ID is the id of the VM (container)
+	ID string `json:"id"`
+}
+
+// ListVMsResponse contains the list of the VMs on the node.
+type ListVMsResponse struct {
+	// VMs is the list of the VMs
+	VMs []*localapi.VMInfo `json:"vms"`
+}
+
+// VMStats contains the resource usage of a VM.
+type VMStats struct {
+	// ID is the id of the VM (container)
+	ID string `json:"id"`
+	// Timestamp is the time the stats were collected at
+	// (unix nanoseconds)
+	Timestamp int64 `json:"timestamp"`
+	// CPUUsage is the cumulative CPU time consumed by the VM
+	// in nanoseconds
+	CPUUsage uint64 `json:"cpuUsage"`
+	// MemoryUsage is the working set memory of the VM in bytes
+	MemoryUsage uint64 `json:"memoryUsage"`
+	// MemoryAvailable is the memory available to the guest in
+	// bytes as reported by the balloon driver, 0 if the guest
+	// stats aren't available
+	MemoryAvailable uint64 `json:"memoryAvailable"`
+	// FsBytes is the size of the root filesystem of the VM in bytes
+	FsBytes uint64 `json:"fsBytes"`
+}
+
+// Chunk is a piece of a data stream, such as the console output
+// of a VM or a metadata snapshot.
+type Chunk struct {
+	// Data is the contents of the chunk
+	Data []byte `json:"data"`
+}
+
+// VMDomainXML contains the libvirt domain definition of a VM.
+type VMDomainXML struct {
+	// ID is the id of the VM (container)
+	ID string `json:"id"`
+	// StoredXML is the domain definition saved in the metadata
+	// store when the domain was last defined by Virtlet. It's
+	// empty for the VMs created by the older Virtlet versions
+	StoredXML string `json:"storedXML"`
+	// LiveXML is the current definition of the domain in libvirt
+	LiveXML string `json:"liveXML"`
+	// Drifted is true if the domain definition was changed
+	// outside of Virtlet, i.e. LiveXML differs from StoredXML
+	Drifted bool `json:"drifted"`
+}
+
+// SnapshotVMRequest asks to take a snapshot of a VM.
+type SnapshotVMRequest struct {
+	// ID is the id of the VM (container)
+	ID string `json:"id"`
+	// Name is the name of the snapshot. If it's empty, the name
+	// is generated from the current time
+	Name string `json:"name,omitempty"`
+}
+
+// SnapshotVMResponse describes the snapshot taken.
+type SnapshotVMResponse struct {
+	// Name is the name of the snapshot
+	Name string `json:"name"`
+}
+
+// MigrateVMRequest asks to move a VM to another node.
+type MigrateVMRequest struct {
+	// ID is the id of the VM (container)
+	ID string `json:"id"`
+	// TargetNode is the name of the node to move the VM to
+	TargetNode string `json:"targetNode"`
+}
+
+// HotplugDeviceRequest asks to attach a host device to a running VM.
+type HotplugDeviceRequest struct {
+	// ID is the id of the VM (container)
+	ID string `json:"id"`
+	// Type is the type of the device. Only "disk" is supported
+	Type string `json:"type"`
+	// Source is the path of the host block device
+	Source string `json:"source"`
+}
+
+// HotplugDeviceResponse describes the attached device.
+type HotplugDeviceResponse struct {
+	// Target is the name of the target device of the disk in
+	// the domain definition, e.g. vdc
+	Target string `json:"target"`
+}
+
+// SandboxRequest identifies the pod sandbox the operation applies
+// to.
+type SandboxRequest struct {
+	// PodID is the id of the pod sandbox (pod UID). An empty
+	// PodID denotes all the pod sandboxes
+	PodID string `json:"podID"`
+}
+
+// SandboxEvent describes a lifecycle event of a pod sandbox or
+// its VM.
+type SandboxEvent struct {
+	// ID is the sequential number of the event
+	ID uint64 `json:"id"`
+	// PodID is the id of the pod sandbox (pod UID)
+	PodID string `json:"podID"`
+	// Timestamp is the time of the event (unix nanoseconds)
+	Timestamp int64 `json:"timestamp"`
+	// Type is the type of the event, e.g. NetworkSetUp or VMCrashed
+	Type string `json:"type"`
+	// ContainerID is the id of the VM (container) the event
+	// applies to, if any
+	ContainerID string `json:"containerID,omitempty"`
+	// Message contains the details of the event, if any
+	Message string `json:"message,omitempty"`
+}
+
+// SandboxEventsResponse contains the lifecycle event history of
+// a pod sandbox.
+type SandboxEventsResponse struct {
+	// Events is the list of the events ordered from the oldest
+	// to the newest one
+	Events []*SandboxEvent `json:"events"`
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VirtletFlavorSpec is the contents of a VirtletFlavor.
+type VirtletFlavorSpec struct {
+	// VCPUCount specifies the number of vCPUs of the VM.
+	VCPUCount *int `json:"vcpuCount,omitempty"`
+	// Memory specifies the amount of memory of the VM. It's used
+	// when the VM container doesn't have a memory limit.
+	Memory *resource.Quantity `json:"memory,omitempty"`
+	// RootVolumeSize specifies the size of the root volume of the VM.
+	RootVolumeSize *resource.Quantity `json:"rootVolumeSize,omitempty"`
+	// TuningProfile specifies the performance tuning profile of
+	// the VM, either "latency" or "throughput".
+	TuningProfile string `json:"tuningProfile,omitempty"`
+}
+
+// +genclient
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// VirtletFlavor is a predefined VM shape that can be referenced by
+// the VM pods from its namespace using VirtletFlavor annotation
+// instead of specifying the resources and the annotations directly.
+// The values from the flavor can be overridden using pod annotations.
+type VirtletFlavor struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata"`
+
+	Spec VirtletFlavorSpec `json:"spec,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// VirtletFlavorList lists flavors.
+type VirtletFlavorList struct {
+	meta_v1.TypeMeta `json:",inline"`
+	meta_v1.ListMeta `json:"metadata"`
+	Items            []VirtletFlavor `json:"items,omitempty"`
+}
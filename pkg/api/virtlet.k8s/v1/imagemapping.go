@@ -60,6 +60,13 @@ type TransportProfile struct {
 
 	// Proxy server to use for downloading
 	Proxy string `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+
+	// MaxSize is the maximum size of the image in bytes. The download fails if the image is larger. <= 0 is no limit (default)
+	MaxSize int64 `yaml:"maxSize,omitempty" json:"maxSize,omitempty"`
+
+	// URLRefreshService is the URL of the service that is asked for a fresh image URL when the current one expires during
+	// the download, e.g. in case of S3 presigned or GCS signed URLs. Empty value means no refreshing (default)
+	URLRefreshService string `yaml:"urlRefreshService,omitempty" json:"urlRefreshService,omitempty"`
 }
 
 // TLSConfig has the TLS transport parameters
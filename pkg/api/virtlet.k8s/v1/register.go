@@ -47,6 +47,10 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 		&VirtletImageMappingList{},
 		&VirtletConfigMapping{},
 		&VirtletConfigMappingList{},
+		&VirtletVMPolicy{},
+		&VirtletVMPolicyList{},
+		&VirtletFlavor{},
+		&VirtletFlavorList{},
 	)
 	meta_v1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
@@ -68,6 +68,194 @@ type VirtletConfig struct {
 	LogLevel *int `json:"logLevel,omitempty"`
 	// Kubelet's root dir
 	KubeletRootDir *string `json:"kubeletRootDir,omitempty"`
+	// ImagePullLimit specifies the maximum number of images that
+	// can be pulled simultaneously. 0 means no limit.
+	ImagePullLimit *int `json:"imagePullLimit,omitempty"`
+	// ImagePullRateLimit specifies the maximum number of image
+	// pulls that can be started per minute. 0 means no limit.
+	ImagePullRateLimit *int `json:"imagePullRateLimit,omitempty"`
+	// ImageGCInterval specifies the interval in seconds between
+	// periodic image garbage collection runs. 0 disables periodic
+	// image GC.
+	ImageGCInterval *int `json:"imageGCInterval,omitempty"`
+	// ImageGCMinAge specifies the minimum age of an unused image
+	// in seconds before it can be removed by the disk usage based
+	// image GC.
+	ImageGCMinAge *int `json:"imageGCMinAge,omitempty"`
+	// ImageGCProtectedImages specifies a comma-separated list of
+	// glob patterns for the names of the images that must never be
+	// removed by the disk usage based image GC.
+	ImageGCProtectedImages *string `json:"imageGCProtectedImages,omitempty"`
+	// ImageGCHighWatermark specifies the disk usage percentage of
+	// the filesystem holding the images which triggers removal of
+	// unused images. 0 disables the disk usage based image GC.
+	ImageGCHighWatermark *int `json:"imageGCHighWatermark,omitempty"`
+	// ImageGCLowWatermark specifies the disk usage percentage the
+	// disk usage based image GC attempts to reach.
+	ImageGCLowWatermark *int `json:"imageGCLowWatermark,omitempty"`
+	// MemoryStatsPeriod specifies the interval in seconds between
+	// the polls of the memory balloon driver for guest memory stats.
+	// 0 disables the polling.
+	MemoryStatsPeriod *int `json:"memoryStatsPeriod,omitempty"`
+	// ConsoleAuditDir specifies the directory to store the
+	// recordings of the interactive console sessions of the VMs
+	// in. Empty value disables the recording unless
+	// ConsoleAuditWebhook is set.
+	ConsoleAuditDir *string `json:"consoleAuditDir,omitempty"`
+	// ConsoleAuditWebhook specifies the URL to POST the
+	// recordings of the console sessions to after they end.
+	ConsoleAuditWebhook *string `json:"consoleAuditWebhook,omitempty"`
+	// DiskCacheMode specifies the cache mode for the qcow2 VM
+	// disks. "auto" selects the mode based on the filesystem
+	// that holds the images and the volumes, "default" leaves
+	// the choice to libvirt.
+	DiskCacheMode *string `json:"diskCacheMode,omitempty"`
+	// ImageLocking specifies whether qemu should lock the disk
+	// images. "auto" disables the locking on filesystems that
+	// don't support it, such as NFSv3 mounts without lockd.
+	ImageLocking *string `json:"imageLocking,omitempty"`
+	// LocalAPISocketPath specifies the path of the unix socket
+	// for the node-local REST API. Empty value disables the API.
+	LocalAPISocketPath *string `json:"localAPISocketPath,omitempty"`
+	// LocalAPITokenFile specifies the path to the file containing
+	// the bearer token that the clients of the node-local REST
+	// API must present.
+	LocalAPITokenFile *string `json:"localAPITokenFile,omitempty"`
+	// LocalAPITLSCertFile specifies the path to the TLS
+	// certificate for the node-local REST API. If it's set, the
+	// API is served over HTTPS. Empty value means plain HTTP.
+	LocalAPITLSCertFile *string `json:"localAPITLSCertFile,omitempty"`
+	// LocalAPITLSKeyFile specifies the path to the private key
+	// of the TLS certificate for the node-local REST API.
+	LocalAPITLSKeyFile *string `json:"localAPITLSKeyFile,omitempty"`
+	// ConsoleEndpoints specifies whether the serial consoles of
+	// the VMs should be exposed on local endpoints for the external
+	// tools: "unix" for unix domain sockets in ConsoleEndpointDir,
+	// "tcp" for TCP ports on 127.0.0.1. Empty value disables the
+	// endpoints.
+	ConsoleEndpoints *string `json:"consoleEndpoints,omitempty"`
+	// ConsoleEndpointDir specifies the directory for the unix
+	// domain sockets of the console endpoints.
+	ConsoleEndpointDir *string `json:"consoleEndpointDir,omitempty"`
+	// AutoDisableKVM specifies whether KVM should be disabled
+	// automatically, making Virtlet use TCG (software emulation),
+	// if it's not usable on the node.
+	AutoDisableKVM *bool `json:"autoDisableKVM,omitempty"`
+	// DomainMetadataLabels specifies a comma-separated list of pod
+	// label keys that are copied to the metadata of libvirt domains.
+	DomainMetadataLabels *string `json:"domainMetadataLabels,omitempty"`
+	// HostDevicePolicyFile specifies the path to the file with
+	// the policy that lists the host devices that can be passed
+	// to the VMs. Empty value disables passing the host devices.
+	HostDevicePolicyFile *string `json:"hostDevicePolicyFile,omitempty"`
+	// MetadataBackend specifies the backend of the metadata store,
+	// "bolt" for a local database file at DatabasePath, "badger"
+	// for a local Badger database at BadgerDatabaseDir, "etcd" or
+	// "sqlite" for a local SQLite database at SQLiteDatabasePath.
+	MetadataBackend *string `json:"metadataBackend,omitempty"`
+	// EtcdEndpoints specifies a comma-separated list of etcd
+	// client URLs used by the etcd metadata backend.
+	EtcdEndpoints *string `json:"etcdEndpoints,omitempty"`
+	// EtcdKeyPrefix specifies the prefix of the etcd keys used by
+	// the etcd metadata backend. The node name is appended to it.
+	EtcdKeyPrefix *string `json:"etcdKeyPrefix,omitempty"`
+	// EtcdCAFile specifies the path to the CA certificate used to
+	// verify the etcd server certificates.
+	EtcdCAFile *string `json:"etcdCAFile,omitempty"`
+	// EtcdCertFile specifies the path to the client certificate
+	// for etcd.
+	EtcdCertFile *string `json:"etcdCertFile,omitempty"`
+	// EtcdKeyFile specifies the path to the private key of the
+	// client certificate for etcd.
+	EtcdKeyFile *string `json:"etcdKeyFile,omitempty"`
+	// LifecycleWebhooks specifies a comma-separated list of URLs
+	// to POST the VM lifecycle events to.
+	LifecycleWebhooks *string `json:"lifecycleWebhooks,omitempty"`
+	// LifecycleWebhookSecretFile specifies the path to the file
+	// with the key used to sign the lifecycle webhook requests.
+	LifecycleWebhookSecretFile *string `json:"lifecycleWebhookSecretFile,omitempty"`
+	// AdminAPISocketPath specifies the path of the unix socket
+	// for the node-local gRPC admin API. Empty value disables
+	// the API.
+	AdminAPISocketPath *string `json:"adminAPISocketPath,omitempty"`
+	// AdminAPITokenFile specifies the path to the file containing
+	// the tokens for the gRPC admin API along with their roles.
+	AdminAPITokenFile *string `json:"adminAPITokenFile,omitempty"`
+	// MemoryBacking specifies the default source of the VM memory:
+	// "default" (anonymous memory), "memfd", "hugepages" or "file".
+	MemoryBacking *string `json:"memoryBacking,omitempty"`
+	// HugetlbfsMounts specifies a comma-separated list of the
+	// hugetlbfs mount points to use for the hugepage-backed VM
+	// memory, one per huge page size.
+	HugetlbfsMounts *string `json:"hugetlbfsMounts,omitempty"`
+	// MetadataEncryptionKeyFile specifies the path to the file
+	// containing the key used to encrypt the pod sandbox and
+	// container records in the metadata database.
+	MetadataEncryptionKeyFile *string `json:"metadataEncryptionKeyFile,omitempty"`
+	// MetadataEncryptionKeySecret specifies the Kubernetes secret
+	// containing the metadata encryption key as namespace/name.
+	// The key is taken from the "key" item of the secret.
+	MetadataEncryptionKeySecret *string `json:"metadataEncryptionKeySecret,omitempty"`
+	// MetadataGCInterval specifies the interval in seconds between
+	// periodic removals of the orphaned pod sandbox and container
+	// records from the metadata store. 0 disables periodic metadata GC.
+	MetadataGCInterval *int `json:"metadataGCInterval,omitempty"`
+	// SimulateVMs makes Virtlet use a no-op simulator instead of
+	// libvirt and CNI. The "VMs" start instantly and report fake
+	// stats, so Virtlet and the metadata store can be scale tested
+	// without the hardware. Not for production use.
+	SimulateVMs *bool `json:"simulateVMs,omitempty"`
+	// MetricsAddress specifies the address (host:port) for the
+	// Prometheus metrics endpoint to listen on. Empty string
+	// disables the metrics endpoint.
+	MetricsAddress *string `json:"metricsAddress,omitempty"`
+	// SandboxTombstoneTTL specifies the time in seconds to keep the
+	// tombstone records of the removed pod sandboxes in the metadata
+	// store. 0 disables the tombstones.
+	SandboxTombstoneTTL *int `json:"sandboxTombstoneTTL,omitempty"`
+	// SQLiteDatabasePath specifies the path to the database file
+	// used by the sqlite metadata backend.
+	SQLiteDatabasePath *string `json:"sqliteDatabasePath,omitempty"`
+	// BadgerDatabaseDir specifies the path to the directory that
+	// holds the database used by the badger metadata backend.
+	BadgerDatabaseDir *string `json:"badgerDatabaseDir,omitempty"`
+	// SandboxRemovalPolicy specifies what to do with the container
+	// records of a pod sandbox when the sandbox is removed from the
+	// metadata store: "cascade" to remove them along with the sandbox
+	// or "restrict" to refuse to remove the sandbox.
+	SandboxRemovalPolicy *string `json:"sandboxRemovalPolicy,omitempty"`
+	// MetadataCompactionThreshold specifies the percentage of the
+	// free pages in the bolt metadata database file above which the
+	// database is compacted when Virtlet starts. 0 disables the
+	// automatic compaction.
+	MetadataCompactionThreshold *int `json:"metadataCompactionThreshold,omitempty"`
+	// MaxConcurrentMaintenanceReboots specifies the maximum number
+	// of VMs that may be rebooted during their maintenance windows
+	// at the same time on the node. 0 disables the maintenance
+	// reboots.
+	MaxConcurrentMaintenanceReboots *int `json:"maxConcurrentMaintenanceReboots,omitempty"`
+	// CrashLoopReuseTTL specifies the time in seconds during which
+	// the root volume, the ephemeral disks and the config drive of
+	// a removed VM as well as the recently pulled images are reused
+	// if the VM is re-created, e.g. because it's crash-looping.
+	// 0 disables the reuse.
+	CrashLoopReuseTTL *int `json:"crashLoopReuseTTL,omitempty"`
+	// ReconcilePolicy specifies how the discrepancies between the
+	// metadata store and the libvirt domains that are found when
+	// Virtlet starts are handled: "repair" to remove the stale
+	// container records and the orphan domains, "adopt" to keep
+	// the orphan domains so they can be taken over by the VM pods,
+	// or "mark-orphaned" to only mark the stale container records
+	// and keep the orphan domains.
+	ReconcilePolicy *string `json:"reconcilePolicy,omitempty"`
+	// MetadataAuditLog specifies the path of the file to append
+	// the audit records of the pod sandbox, container and image
+	// pull record changes made in the metadata store to. Empty
+	// value disables the audit log.
+	MetadataAuditLog *string `json:"metadataAuditLog,omitempty"`
+	// MetadataAuditLogMaxSize specifies the size of the metadata
+	// audit log in MiB after which the log is rotated.
+	MetadataAuditLogMaxSize *int `json:"metadataAuditLogMaxSize,omitempty"`
 }
 
 // VirtletConfigMappingSpec is the contents of a VirtletConfigMapping.
@@ -77,6 +265,11 @@ type VirtletConfigMappingSpec struct {
 	// NodeSelector specifies the labels that must be matched for this
 	// mapping to apply to the node.
 	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// NodeSelectorExpressions specifies the label selector
+	// requirements (In, NotIn, Exists, DoesNotExist) that must all
+	// be satisfied by the node labels for this mapping to apply
+	// to the node.
+	NodeSelectorExpressions []meta_v1.LabelSelectorRequirement `json:"nodeSelectorExpressions,omitempty"`
 	// Node name to match.
 	NodeName string `json:"nodeName,omitempty"`
 	// Priority specifies the priority of this setting.
@@ -85,8 +278,50 @@ type VirtletConfigMappingSpec struct {
 	Config *VirtletConfig `json:"config,omitempty"`
 }
 
+// VirtletConfigMappingNodeStatus describes the state of a
+// VirtletConfigMapping on a particular node.
+type VirtletConfigMappingNodeStatus struct {
+	// NodeName is the name of the node.
+	NodeName string `json:"nodeName"`
+	// ObservedGeneration is the generation of the mapping that
+	// was last processed by Virtlet on the node.
+	ObservedGeneration int64 `json:"observedGeneration"`
+	// LastUpdateTime is the time when the mapping was last
+	// processed by Virtlet on the node.
+	LastUpdateTime meta_v1.Time `json:"lastUpdateTime,omitempty"`
+	// Error contains the validation error message if the
+	// mapping was rejected.
+	Error string `json:"error,omitempty"`
+	// RestartRequired lists the config fields that have changed
+	// since Virtlet was started on the node and that only take
+	// effect after Virtlet restart.
+	RestartRequired []string `json:"restartRequired,omitempty"`
+	// ConfigDiff lists the fields of the effective node config
+	// that are changed by this mapping.
+	ConfigDiff []VirtletConfigFieldDiff `json:"configDiff,omitempty"`
+}
+
+// VirtletConfigFieldDiff describes a change of a config field that
+// is caused by a VirtletConfigMapping.
+type VirtletConfigFieldDiff struct {
+	// Field is the name of the config field.
+	Field string `json:"field"`
+	// Value is the effective value of the field on the node.
+	Value string `json:"value"`
+	// BaseValue is the value the field would have on the node
+	// if the mapping wasn't applied.
+	BaseValue string `json:"baseValue"`
+}
+
+// VirtletConfigMappingStatus describes the nodes that have
+// processed a VirtletConfigMapping.
+type VirtletConfigMappingStatus struct {
+	// Nodes contains the status of the mapping on each node
+	// it applies to.
+	Nodes []VirtletConfigMappingNodeStatus `json:"nodes,omitempty"`
+}
+
 // +genclient
-// +genclient:noStatus
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // VirtletConfigMapping specifies the mapping of node names or labels
@@ -95,7 +330,8 @@ type VirtletConfigMapping struct {
 	meta_v1.TypeMeta   `json:",inline"`
 	meta_v1.ObjectMeta `json:"metadata"`
 
-	Spec VirtletConfigMappingSpec `json:"spec,omitempty"`
+	Spec   VirtletConfigMappingSpec   `json:"spec,omitempty"`
+	Status VirtletConfigMappingStatus `json:"status,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
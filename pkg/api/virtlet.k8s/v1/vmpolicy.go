@@ -0,0 +1,85 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VirtletVMPolicySpec is the contents of a VirtletVMPolicy.
+type VirtletVMPolicySpec struct {
+	// TerminationGracePeriodSeconds specifies the default time
+	// given to the guest to shut down gracefully before the
+	// domain is destroyed. It's used if CRI StopContainer request
+	// doesn't specify a timeout.
+	TerminationGracePeriodSeconds *int64 `json:"terminationGracePeriodSeconds,omitempty"`
+	// OnCrash specifies the libvirt on_crash action for the VMs.
+	OnCrash string `json:"onCrash,omitempty"`
+	// WatchdogAction specifies the action to take when the guest
+	// watchdog fires. Empty value means that no watchdog device is
+	// added to the domain.
+	WatchdogAction string `json:"watchdogAction,omitempty"`
+	// RestartBackoffSeconds specifies the minimum amount of time
+	// that must pass after the VM has been started before it can
+	// be restarted.
+	RestartBackoffSeconds *int64 `json:"restartBackoffSeconds,omitempty"`
+	// MaxVMsPerNode specifies the maximum number of VM pods from
+	// the namespace that may be running on each node.
+	MaxVMsPerNode *int `json:"maxVMsPerNode,omitempty"`
+	// MaxVCPUsPerNode specifies the maximum total number of vCPUs
+	// of the VM pods from the namespace on each node.
+	MaxVCPUsPerNode *int `json:"maxVCPUsPerNode,omitempty"`
+	// MaxMemoryPerNode specifies the maximum total amount of
+	// memory of the VM pods from the namespace on each node.
+	MaxMemoryPerNode *resource.Quantity `json:"maxMemoryPerNode,omitempty"`
+	// MaintenanceWindow specifies the default maintenance window
+	// during which the VMs may be rebooted by Virtlet, in the same
+	// format as VirtletMaintenanceWindow pod annotation.
+	MaintenanceWindow string `json:"maintenanceWindow,omitempty"`
+	// MaintenanceRebootCondition specifies the default condition
+	// for rebooting the VMs during their maintenance window.
+	MaintenanceRebootCondition string `json:"maintenanceRebootCondition,omitempty"`
+	// MaxConcurrentMaintenanceReboots specifies the maximum
+	// number of VMs from the namespace that may be rebooted for
+	// maintenance at the same time on each node.
+	MaxConcurrentMaintenanceReboots *int `json:"maxConcurrentMaintenanceReboots,omitempty"`
+}
+
+// +genclient
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// VirtletVMPolicy specifies the default shutdown, crash handling
+// and maintenance settings for the VM pods in its namespace. These
+// settings can be overridden using pod annotations. It also specifies
+// the per-node quotas for the VM pods in the namespace.
+type VirtletVMPolicy struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata"`
+
+	Spec VirtletVMPolicySpec `json:"spec,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// VirtletVMPolicyList lists VM policies.
+type VirtletVMPolicyList struct {
+	meta_v1.TypeMeta `json:",inline"`
+	meta_v1.ListMeta `json:"metadata"`
+	Items            []VirtletVMPolicy `json:"items,omitempty"`
+}
@@ -21,6 +21,7 @@ limitations under the License.
 package v1
 
 import (
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -324,6 +325,438 @@ func (in *VirtletConfig) DeepCopyInto(out *VirtletConfig) {
 			**out = **in
 		}
 	}
+	if in.ImagePullLimit != nil {
+		in, out := &in.ImagePullLimit, &out.ImagePullLimit
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int)
+			**out = **in
+		}
+	}
+	if in.ImagePullRateLimit != nil {
+		in, out := &in.ImagePullRateLimit, &out.ImagePullRateLimit
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int)
+			**out = **in
+		}
+	}
+	if in.ImageGCInterval != nil {
+		in, out := &in.ImageGCInterval, &out.ImageGCInterval
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int)
+			**out = **in
+		}
+	}
+	if in.ImageGCMinAge != nil {
+		in, out := &in.ImageGCMinAge, &out.ImageGCMinAge
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int)
+			**out = **in
+		}
+	}
+	if in.ImageGCProtectedImages != nil {
+		in, out := &in.ImageGCProtectedImages, &out.ImageGCProtectedImages
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.ImageGCHighWatermark != nil {
+		in, out := &in.ImageGCHighWatermark, &out.ImageGCHighWatermark
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int)
+			**out = **in
+		}
+	}
+	if in.ImageGCLowWatermark != nil {
+		in, out := &in.ImageGCLowWatermark, &out.ImageGCLowWatermark
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int)
+			**out = **in
+		}
+	}
+	if in.MemoryStatsPeriod != nil {
+		in, out := &in.MemoryStatsPeriod, &out.MemoryStatsPeriod
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int)
+			**out = **in
+		}
+	}
+	if in.ConsoleAuditDir != nil {
+		in, out := &in.ConsoleAuditDir, &out.ConsoleAuditDir
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.ConsoleAuditWebhook != nil {
+		in, out := &in.ConsoleAuditWebhook, &out.ConsoleAuditWebhook
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.DiskCacheMode != nil {
+		in, out := &in.DiskCacheMode, &out.DiskCacheMode
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.ImageLocking != nil {
+		in, out := &in.ImageLocking, &out.ImageLocking
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.LocalAPISocketPath != nil {
+		in, out := &in.LocalAPISocketPath, &out.LocalAPISocketPath
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.LocalAPITokenFile != nil {
+		in, out := &in.LocalAPITokenFile, &out.LocalAPITokenFile
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.LocalAPITLSCertFile != nil {
+		in, out := &in.LocalAPITLSCertFile, &out.LocalAPITLSCertFile
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.LocalAPITLSKeyFile != nil {
+		in, out := &in.LocalAPITLSKeyFile, &out.LocalAPITLSKeyFile
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.ConsoleEndpoints != nil {
+		in, out := &in.ConsoleEndpoints, &out.ConsoleEndpoints
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.ConsoleEndpointDir != nil {
+		in, out := &in.ConsoleEndpointDir, &out.ConsoleEndpointDir
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.AutoDisableKVM != nil {
+		in, out := &in.AutoDisableKVM, &out.AutoDisableKVM
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(bool)
+			**out = **in
+		}
+	}
+	if in.DomainMetadataLabels != nil {
+		in, out := &in.DomainMetadataLabels, &out.DomainMetadataLabels
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.HostDevicePolicyFile != nil {
+		in, out := &in.HostDevicePolicyFile, &out.HostDevicePolicyFile
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.MetadataBackend != nil {
+		in, out := &in.MetadataBackend, &out.MetadataBackend
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.EtcdEndpoints != nil {
+		in, out := &in.EtcdEndpoints, &out.EtcdEndpoints
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.EtcdKeyPrefix != nil {
+		in, out := &in.EtcdKeyPrefix, &out.EtcdKeyPrefix
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.EtcdCAFile != nil {
+		in, out := &in.EtcdCAFile, &out.EtcdCAFile
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.EtcdCertFile != nil {
+		in, out := &in.EtcdCertFile, &out.EtcdCertFile
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.EtcdKeyFile != nil {
+		in, out := &in.EtcdKeyFile, &out.EtcdKeyFile
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.LifecycleWebhooks != nil {
+		in, out := &in.LifecycleWebhooks, &out.LifecycleWebhooks
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.LifecycleWebhookSecretFile != nil {
+		in, out := &in.LifecycleWebhookSecretFile, &out.LifecycleWebhookSecretFile
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.AdminAPISocketPath != nil {
+		in, out := &in.AdminAPISocketPath, &out.AdminAPISocketPath
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.AdminAPITokenFile != nil {
+		in, out := &in.AdminAPITokenFile, &out.AdminAPITokenFile
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.MemoryBacking != nil {
+		in, out := &in.MemoryBacking, &out.MemoryBacking
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.HugetlbfsMounts != nil {
+		in, out := &in.HugetlbfsMounts, &out.HugetlbfsMounts
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.MetadataEncryptionKeyFile != nil {
+		in, out := &in.MetadataEncryptionKeyFile, &out.MetadataEncryptionKeyFile
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.MetadataEncryptionKeySecret != nil {
+		in, out := &in.MetadataEncryptionKeySecret, &out.MetadataEncryptionKeySecret
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.MetadataGCInterval != nil {
+		in, out := &in.MetadataGCInterval, &out.MetadataGCInterval
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int)
+			**out = **in
+		}
+	}
+	if in.SimulateVMs != nil {
+		in, out := &in.SimulateVMs, &out.SimulateVMs
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(bool)
+			**out = **in
+		}
+	}
+	if in.MetricsAddress != nil {
+		in, out := &in.MetricsAddress, &out.MetricsAddress
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.SandboxTombstoneTTL != nil {
+		in, out := &in.SandboxTombstoneTTL, &out.SandboxTombstoneTTL
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int)
+			**out = **in
+		}
+	}
+	if in.SQLiteDatabasePath != nil {
+		in, out := &in.SQLiteDatabasePath, &out.SQLiteDatabasePath
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.BadgerDatabaseDir != nil {
+		in, out := &in.BadgerDatabaseDir, &out.BadgerDatabaseDir
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.SandboxRemovalPolicy != nil {
+		in, out := &in.SandboxRemovalPolicy, &out.SandboxRemovalPolicy
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.MetadataCompactionThreshold != nil {
+		in, out := &in.MetadataCompactionThreshold, &out.MetadataCompactionThreshold
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int)
+			**out = **in
+		}
+	}
+	if in.MaxConcurrentMaintenanceReboots != nil {
+		in, out := &in.MaxConcurrentMaintenanceReboots, &out.MaxConcurrentMaintenanceReboots
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int)
+			**out = **in
+		}
+	}
+	if in.CrashLoopReuseTTL != nil {
+		in, out := &in.CrashLoopReuseTTL, &out.CrashLoopReuseTTL
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int)
+			**out = **in
+		}
+	}
+	if in.ReconcilePolicy != nil {
+		in, out := &in.ReconcilePolicy, &out.ReconcilePolicy
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.MetadataAuditLog != nil {
+		in, out := &in.MetadataAuditLog, &out.MetadataAuditLog
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(string)
+			**out = **in
+		}
+	}
+	if in.MetadataAuditLogMaxSize != nil {
+		in, out := &in.MetadataAuditLogMaxSize, &out.MetadataAuditLogMaxSize
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int)
+			**out = **in
+		}
+	}
 	return
 }
 
@@ -337,12 +770,29 @@ func (in *VirtletConfig) DeepCopy() *VirtletConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtletConfigFieldDiff) DeepCopyInto(out *VirtletConfigFieldDiff) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtletConfigFieldDiff.
+func (in *VirtletConfigFieldDiff) DeepCopy() *VirtletConfigFieldDiff {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtletConfigFieldDiff)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VirtletConfigMapping) DeepCopyInto(out *VirtletConfigMapping) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -397,6 +847,33 @@ func (in *VirtletConfigMappingList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtletConfigMappingNodeStatus) DeepCopyInto(out *VirtletConfigMappingNodeStatus) {
+	*out = *in
+	in.LastUpdateTime.DeepCopyInto(&out.LastUpdateTime)
+	if in.RestartRequired != nil {
+		in, out := &in.RestartRequired, &out.RestartRequired
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ConfigDiff != nil {
+		in, out := &in.ConfigDiff, &out.ConfigDiff
+		*out = make([]VirtletConfigFieldDiff, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtletConfigMappingNodeStatus.
+func (in *VirtletConfigMappingNodeStatus) DeepCopy() *VirtletConfigMappingNodeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtletConfigMappingNodeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VirtletConfigMappingSpec) DeepCopyInto(out *VirtletConfigMappingSpec) {
 	*out = *in
@@ -409,6 +886,13 @@ func (in *VirtletConfigMappingSpec) DeepCopyInto(out *VirtletConfigMappingSpec)
 			(*out)[key] = val
 		}
 	}
+	if in.NodeSelectorExpressions != nil {
+		in, out := &in.NodeSelectorExpressions, &out.NodeSelectorExpressions
+		*out = make([]meta_v1.LabelSelectorRequirement, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.Config != nil {
 		in, out := &in.Config, &out.Config
 		if *in == nil {
@@ -431,6 +915,132 @@ func (in *VirtletConfigMappingSpec) DeepCopy() *VirtletConfigMappingSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtletConfigMappingStatus) DeepCopyInto(out *VirtletConfigMappingStatus) {
+	*out = *in
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]VirtletConfigMappingNodeStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtletConfigMappingStatus.
+func (in *VirtletConfigMappingStatus) DeepCopy() *VirtletConfigMappingStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtletConfigMappingStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtletFlavor) DeepCopyInto(out *VirtletFlavor) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtletFlavor.
+func (in *VirtletFlavor) DeepCopy() *VirtletFlavor {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtletFlavor)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VirtletFlavor) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtletFlavorList) DeepCopyInto(out *VirtletFlavorList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]VirtletFlavor, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtletFlavorList.
+func (in *VirtletFlavorList) DeepCopy() *VirtletFlavorList {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtletFlavorList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VirtletFlavorList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtletFlavorSpec) DeepCopyInto(out *VirtletFlavorSpec) {
+	*out = *in
+	if in.VCPUCount != nil {
+		in, out := &in.VCPUCount, &out.VCPUCount
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int)
+			**out = **in
+		}
+	}
+	if in.Memory != nil {
+		in, out := &in.Memory, &out.Memory
+		if *in == nil {
+			*out = nil
+		} else {
+			x := (*in).DeepCopy()
+			*out = &x
+		}
+	}
+	if in.RootVolumeSize != nil {
+		in, out := &in.RootVolumeSize, &out.RootVolumeSize
+		if *in == nil {
+			*out = nil
+		} else {
+			x := (*in).DeepCopy()
+			*out = &x
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtletFlavorSpec.
+func (in *VirtletFlavorSpec) DeepCopy() *VirtletFlavorSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtletFlavorSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VirtletImageMapping) DeepCopyInto(out *VirtletImageMapping) {
 	*out = *in
@@ -490,3 +1100,133 @@ func (in *VirtletImageMappingList) DeepCopyObject() runtime.Object {
 	}
 	return nil
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtletVMPolicy) DeepCopyInto(out *VirtletVMPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtletVMPolicy.
+func (in *VirtletVMPolicy) DeepCopy() *VirtletVMPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtletVMPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VirtletVMPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtletVMPolicyList) DeepCopyInto(out *VirtletVMPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]VirtletVMPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtletVMPolicyList.
+func (in *VirtletVMPolicyList) DeepCopy() *VirtletVMPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtletVMPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VirtletVMPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VirtletVMPolicySpec) DeepCopyInto(out *VirtletVMPolicySpec) {
+	*out = *in
+	if in.TerminationGracePeriodSeconds != nil {
+		in, out := &in.TerminationGracePeriodSeconds, &out.TerminationGracePeriodSeconds
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int64)
+			**out = **in
+		}
+	}
+	if in.RestartBackoffSeconds != nil {
+		in, out := &in.RestartBackoffSeconds, &out.RestartBackoffSeconds
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int64)
+			**out = **in
+		}
+	}
+	if in.MaxVMsPerNode != nil {
+		in, out := &in.MaxVMsPerNode, &out.MaxVMsPerNode
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int)
+			**out = **in
+		}
+	}
+	if in.MaxVCPUsPerNode != nil {
+		in, out := &in.MaxVCPUsPerNode, &out.MaxVCPUsPerNode
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int)
+			**out = **in
+		}
+	}
+	if in.MaxMemoryPerNode != nil {
+		in, out := &in.MaxMemoryPerNode, &out.MaxMemoryPerNode
+		if *in == nil {
+			*out = nil
+		} else {
+			x := (*in).DeepCopy()
+			*out = &x
+		}
+	}
+	if in.MaxConcurrentMaintenanceReboots != nil {
+		in, out := &in.MaxConcurrentMaintenanceReboots, &out.MaxConcurrentMaintenanceReboots
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(int)
+			**out = **in
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VirtletVMPolicySpec.
+func (in *VirtletVMPolicySpec) DeepCopy() *VirtletVMPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VirtletVMPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,308 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package client provides a Go client for the node-local Virtlet
+// APIs, namely the gRPC admin API and the diagnostics socket. It
+// lets external controllers and tests interact with a Virtlet node
+// programmatically instead of invoking virtletctl. The package
+// doesn't depend on the Virtlet internals, and its interfaces are
+// kept backward compatible.
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+const (
+	// DefaultDiagSocketPath is the default path of the unix
+	// socket that serves the diagnostic info of the node.
+	DefaultDiagSocketPath = "/run/virtlet-diag.sock"
+	// adminServiceName is the full name of the version 1 of
+	// the admin gRPC service.
+	adminServiceName = "virtlet.admin.v1.Admin"
+)
+
+// Interface is the interface of the Virtlet node client. It can be
+// used to substitute a fake implementation of the client in tests.
+type Interface interface {
+	// ListVMs returns the list of the VMs on the node.
+	ListVMs(ctx context.Context) ([]*VMInfo, error)
+	// GetVMStats returns the resource usage of the specified VM.
+	GetVMStats(ctx context.Context, id string) (*VMStats, error)
+	// RebootVM asks the guest OS of the specified VM to reboot.
+	RebootVM(ctx context.Context, id string) error
+	// GetVMDomainXML returns the libvirt domain definition of
+	// the specified VM.
+	GetVMDomainXML(ctx context.Context, id string) (*VMDomainXML, error)
+	// SnapshotVM takes a snapshot of the specified VM and
+	// returns the name of the snapshot. If name is empty, it's
+	// generated by Virtlet.
+	SnapshotVM(ctx context.Context, id, name string) (string, error)
+	// MigrateVM moves the specified VM to another node. Virtlet
+	// doesn't support VM migration, so currently the call always
+	// fails with codes.Unimplemented.
+	MigrateVM(ctx context.Context, id, targetNode string) error
+	// HotplugDevice attaches the host block device with the
+	// specified path to the running VM and returns the target
+	// device name of the disk in the VM.
+	HotplugDevice(ctx context.Context, id, source string) (string, error)
+	// GetSandboxEvents returns the lifecycle event history of the
+	// pod sandbox with the specified id (pod UID) ordered from the
+	// oldest to the newest event. If podID is empty, the events of
+	// all the pod sandboxes on the node are returned.
+	GetSandboxEvents(ctx context.Context, podID string) ([]*SandboxEvent, error)
+	// StreamConsole copies the console output of the specified
+	// VM to w until ctx is cancelled.
+	StreamConsole(ctx context.Context, id string, w io.Writer) error
+	// SnapshotMetadata writes a snapshot of the Virtlet
+	// metadata database to w.
+	SnapshotMetadata(ctx context.Context, w io.Writer) error
+	// Diagnostics retrieves the diagnostic info of the node.
+	Diagnostics(ctx context.Context) (*DiagResult, error)
+	// Close closes the connections to the node.
+	Close() error
+}
+
+// Config specifies how to connect to a Virtlet node.
+type Config struct {
+	// AdminSocketPath is the path of the unix socket of the
+	// admin API. The admin API calls fail if it's empty.
+	AdminSocketPath string
+	// Token is the admin API token.
+	Token string
+	// DiagSocketPath is the path of the unix socket that
+	// serves the diagnostic info. DefaultDiagSocketPath
+	// is used if it's empty.
+	DiagSocketPath string
+}
+
+// Client is the client of a Virtlet node.
+type Client struct {
+	conn           *grpc.ClientConn
+	diagSocketPath string
+}
+
+var _ Interface = &Client{}
+
+// New returns a new client for the Virtlet node that's specified
+// by config. Connecting to the admin API doesn't block, so New
+// doesn't fail if Virtlet isn't running yet.
+func New(config Config) (*Client, error) {
+	c := &Client{diagSocketPath: config.DiagSocketPath}
+	if c.diagSocketPath == "" {
+		c.diagSocketPath = DefaultDiagSocketPath
+	}
+	if config.AdminSocketPath == "" {
+		return c, nil
+	}
+	conn, err := grpc.Dial(config.AdminSocketPath,
+		grpc.WithInsecure(),
+		grpc.WithCodec(jsonCodec{}),
+		grpc.WithPerRPCCredentials(tokenCredentials(config.Token)),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}))
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to the admin API at %q: %v", config.AdminSocketPath, err)
+	}
+	c.conn = conn
+	return c, nil
+}
+
+// Close implements Close method of Interface.
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// ListVMs implements ListVMs method of Interface.
+func (c *Client) ListVMs(ctx context.Context) ([]*VMInfo, error) {
+	var resp listVMsResponse
+	if err := c.invoke(ctx, "ListVMs", &empty{}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.VMs, nil
+}
+
+// GetVMStats implements GetVMStats method of Interface.
+func (c *Client) GetVMStats(ctx context.Context, id string) (*VMStats, error) {
+	var resp VMStats
+	if err := c.invoke(ctx, "GetVMStats", &vmRequest{ID: id}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RebootVM implements RebootVM method of Interface.
+func (c *Client) RebootVM(ctx context.Context, id string) error {
+	return c.invoke(ctx, "RebootVM", &vmRequest{ID: id}, &empty{})
+}
+
+// GetVMDomainXML implements GetVMDomainXML method of Interface.
+func (c *Client) GetVMDomainXML(ctx context.Context, id string) (*VMDomainXML, error) {
+	var resp VMDomainXML
+	if err := c.invoke(ctx, "GetVMDomainXML", &vmRequest{ID: id}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SnapshotVM implements SnapshotVM method of Interface.
+func (c *Client) SnapshotVM(ctx context.Context, id, name string) (string, error) {
+	var resp snapshotVMResponse
+	if err := c.invoke(ctx, "SnapshotVM", &snapshotVMRequest{ID: id, Name: name}, &resp); err != nil {
+		return "", err
+	}
+	return resp.Name, nil
+}
+
+// MigrateVM implements MigrateVM method of Interface.
+func (c *Client) MigrateVM(ctx context.Context, id, targetNode string) error {
+	return c.invoke(ctx, "MigrateVM", &migrateVMRequest{ID: id, TargetNode: targetNode}, &empty{})
+}
+
+// HotplugDevice implements HotplugDevice method of Interface.
+func (c *Client) HotplugDevice(ctx context.Context, id, source string) (string, error) {
+	var resp hotplugDeviceResponse
+	if err := c.invoke(ctx, "HotplugDevice", &hotplugDeviceRequest{ID: id, Type: "disk", Source: source}, &resp); err != nil {
+		return "", err
+	}
+	return resp.Target, nil
+}
+
+// GetSandboxEvents implements GetSandboxEvents method of Interface.
+func (c *Client) GetSandboxEvents(ctx context.Context, podID string) ([]*SandboxEvent, error) {
+	var resp sandboxEventsResponse
+	if err := c.invoke(ctx, "GetSandboxEvents", &sandboxRequest{PodID: podID}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Events, nil
+}
+
+// StreamConsole implements StreamConsole method of Interface.
+func (c *Client) StreamConsole(ctx context.Context, id string, w io.Writer) error {
+	return c.receive(ctx, "StreamConsole", &vmRequest{ID: id}, w)
+}
+
+// SnapshotMetadata implements SnapshotMetadata method of Interface.
+func (c *Client) SnapshotMetadata(ctx context.Context, w io.Writer) error {
+	return c.receive(ctx, "SnapshotMetadata", &empty{}, w)
+}
+
+// Diagnostics implements Diagnostics method of Interface.
+func (c *Client) Diagnostics(ctx context.Context) (*DiagResult, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", c.diagSocketPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to %q: %v", c.diagSocketPath, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	data, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return nil, fmt.Errorf("can't read diagnostics: %v", err)
+	}
+	var r DiagResult
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("error unmarshalling the diagnostics: %v", err)
+	}
+	return &r, nil
+}
+
+func (c *Client) invoke(ctx context.Context, method string, req, resp interface{}) error {
+	if c.conn == nil {
+		return errors.New("admin API socket path is not specified")
+	}
+	return grpc.Invoke(ctx, fullMethodName(method), req, resp, c.conn)
+}
+
+func (c *Client) receive(ctx context.Context, method string, req interface{}, w io.Writer) error {
+	if c.conn == nil {
+		return errors.New("admin API socket path is not specified")
+	}
+	desc := &grpc.StreamDesc{StreamName: method, ServerStreams: true}
+	stream, err := grpc.NewClientStream(ctx, desc, c.conn, fullMethodName(method))
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		var chunk chunk
+		switch err := stream.RecvMsg(&chunk); {
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+		if _, err := w.Write(chunk.Data); err != nil {
+			return err
+		}
+	}
+}
+
+func fullMethodName(method string) string {
+	return "/" + adminServiceName + "/" + method
+}
+
+// tokenCredentials passes the bearer token with each call.
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	// the API is only served on a unix domain socket
+	return false
+}
+
+// jsonCodec encodes the messages of the admin API as JSON, the
+// same way the server does.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) String() string {
+	return "json"
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
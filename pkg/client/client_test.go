@@ -0,0 +1,83 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestDiagnostics(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "virtlet-client")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	socketPath := filepath.Join(tmpDir, "diag.sock")
+
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Listen(): %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte(`{"name":"diagnostics","isdir":true,"children":{"foo":{"name":"foo","ext":"txt","data":"bar"}}}`))
+	}()
+
+	c, err := New(Config{DiagSocketPath: socketPath})
+	if err != nil {
+		t.Fatalf("New(): %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	r, err := c.Diagnostics(ctx)
+	if err != nil {
+		t.Fatalf("Diagnostics(): %v", err)
+	}
+	expected := &DiagResult{
+		Name:  "diagnostics",
+		IsDir: true,
+		Children: map[string]DiagResult{
+			"foo": {
+				Name: "foo",
+				Ext:  "txt",
+				Data: "bar",
+			},
+		},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("bad diagnostics: %#v instead of %#v", r, expected)
+	}
+
+	if _, err := c.ListVMs(ctx); err == nil {
+		t.Errorf("ListVMs() didn't fail without the admin API socket path")
+	}
+}
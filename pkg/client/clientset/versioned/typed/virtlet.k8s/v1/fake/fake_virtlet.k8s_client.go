@@ -32,10 +32,18 @@ func (c *FakeVirtletV1) VirtletConfigMappings(namespace string) v1.VirtletConfig
 	return &FakeVirtletConfigMappings{c, namespace}
 }
 
+func (c *FakeVirtletV1) VirtletFlavors(namespace string) v1.VirtletFlavorInterface {
+	return &FakeVirtletFlavors{c, namespace}
+}
+
 func (c *FakeVirtletV1) VirtletImageMappings(namespace string) v1.VirtletImageMappingInterface {
 	return &FakeVirtletImageMappings{c, namespace}
 }
 
+func (c *FakeVirtletV1) VirtletVMPolicies(namespace string) v1.VirtletVMPolicyInterface {
+	return &FakeVirtletVMPolicies{c, namespace}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeVirtletV1) RESTClient() rest.Interface {
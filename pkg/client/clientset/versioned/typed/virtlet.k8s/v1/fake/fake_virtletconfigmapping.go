@@ -100,6 +100,18 @@ func (c *FakeVirtletConfigMappings) Update(virtletConfigMapping *virtlet_k8s_v1.
 	return obj.(*virtlet_k8s_v1.VirtletConfigMapping), err
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeVirtletConfigMappings) UpdateStatus(virtletConfigMapping *virtlet_k8s_v1.VirtletConfigMapping) (*virtlet_k8s_v1.VirtletConfigMapping, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(virtletconfigmappingsResource, "status", c.ns, virtletConfigMapping), &virtlet_k8s_v1.VirtletConfigMapping{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*virtlet_k8s_v1.VirtletConfigMapping), err
+}
+
 // Delete takes name of the virtletConfigMapping and deletes it. Returns an error if one occurs.
 func (c *FakeVirtletConfigMappings) Delete(name string, options *v1.DeleteOptions) error {
 	_, err := c.Fake.
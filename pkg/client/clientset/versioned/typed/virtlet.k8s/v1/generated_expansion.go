@@ -20,4 +20,8 @@ package v1
 
 type VirtletConfigMappingExpansion interface{}
 
+type VirtletFlavorExpansion interface{}
+
 type VirtletImageMappingExpansion interface{}
+
+type VirtletVMPolicyExpansion interface{}
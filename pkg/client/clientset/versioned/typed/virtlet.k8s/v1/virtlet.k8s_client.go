@@ -28,7 +28,9 @@ import (
 type VirtletV1Interface interface {
 	RESTClient() rest.Interface
 	VirtletConfigMappingsGetter
+	VirtletFlavorsGetter
 	VirtletImageMappingsGetter
+	VirtletVMPoliciesGetter
 }
 
 // VirtletV1Client is used to interact with features provided by the virtlet.k8s group.
@@ -40,10 +42,18 @@ func (c *VirtletV1Client) VirtletConfigMappings(namespace string) VirtletConfigM
 	return newVirtletConfigMappings(c, namespace)
 }
 
+func (c *VirtletV1Client) VirtletFlavors(namespace string) VirtletFlavorInterface {
+	return newVirtletFlavors(c, namespace)
+}
+
 func (c *VirtletV1Client) VirtletImageMappings(namespace string) VirtletImageMappingInterface {
 	return newVirtletImageMappings(c, namespace)
 }
 
+func (c *VirtletV1Client) VirtletVMPolicies(namespace string) VirtletVMPolicyInterface {
+	return newVirtletVMPolicies(c, namespace)
+}
+
 // NewForConfig creates a new VirtletV1Client for the given config.
 func NewForConfig(c *rest.Config) (*VirtletV1Client, error) {
 	config := *c
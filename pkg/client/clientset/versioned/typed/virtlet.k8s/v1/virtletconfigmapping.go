@@ -37,6 +37,7 @@ type VirtletConfigMappingsGetter interface {
 type VirtletConfigMappingInterface interface {
 	Create(*v1.VirtletConfigMapping) (*v1.VirtletConfigMapping, error)
 	Update(*v1.VirtletConfigMapping) (*v1.VirtletConfigMapping, error)
+	UpdateStatus(*v1.VirtletConfigMapping) (*v1.VirtletConfigMapping, error)
 	Delete(name string, options *meta_v1.DeleteOptions) error
 	DeleteCollection(options *meta_v1.DeleteOptions, listOptions meta_v1.ListOptions) error
 	Get(name string, options meta_v1.GetOptions) (*v1.VirtletConfigMapping, error)
@@ -120,6 +121,22 @@ func (c *virtletConfigMappings) Update(virtletConfigMapping *v1.VirtletConfigMap
 	return
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+
+func (c *virtletConfigMappings) UpdateStatus(virtletConfigMapping *v1.VirtletConfigMapping) (result *v1.VirtletConfigMapping, err error) {
+	result = &v1.VirtletConfigMapping{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("virtletconfigmappings").
+		Name(virtletConfigMapping.Name).
+		SubResource("status").
+		Body(virtletConfigMapping).
+		Do().
+		Into(result)
+	return
+}
+
 // Delete takes name of the virtletConfigMapping and deletes it. Returns an error if one occurs.
 func (c *virtletConfigMappings) Delete(name string, options *meta_v1.DeleteOptions) error {
 	return c.client.Delete().
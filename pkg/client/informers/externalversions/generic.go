@@ -55,8 +55,12 @@ func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource
 	// Group=virtlet.k8s, Version=v1
 	case v1.SchemeGroupVersion.WithResource("virtletconfigmappings"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Virtlet().V1().VirtletConfigMappings().Informer()}, nil
+	case v1.SchemeGroupVersion.WithResource("virtletflavors"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Virtlet().V1().VirtletFlavors().Informer()}, nil
 	case v1.SchemeGroupVersion.WithResource("virtletimagemappings"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Virtlet().V1().VirtletImageMappings().Informer()}, nil
+	case v1.SchemeGroupVersion.WithResource("virtletvmpolicies"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Virtlet().V1().VirtletVMPolicies().Informer()}, nil
 
 	}
 
@@ -26,8 +26,12 @@ import (
 type Interface interface {
 	// VirtletConfigMappings returns a VirtletConfigMappingInformer.
 	VirtletConfigMappings() VirtletConfigMappingInformer
+	// VirtletFlavors returns a VirtletFlavorInformer.
+	VirtletFlavors() VirtletFlavorInformer
 	// VirtletImageMappings returns a VirtletImageMappingInformer.
 	VirtletImageMappings() VirtletImageMappingInformer
+	// VirtletVMPolicies returns a VirtletVMPolicyInformer.
+	VirtletVMPolicies() VirtletVMPolicyInformer
 }
 
 type version struct {
@@ -46,7 +50,17 @@ func (v *version) VirtletConfigMappings() VirtletConfigMappingInformer {
 	return &virtletConfigMappingInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
 
+// VirtletFlavors returns a VirtletFlavorInformer.
+func (v *version) VirtletFlavors() VirtletFlavorInformer {
+	return &virtletFlavorInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
+
 // VirtletImageMappings returns a VirtletImageMappingInformer.
 func (v *version) VirtletImageMappings() VirtletImageMappingInformer {
 	return &virtletImageMappingInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
+
+// VirtletVMPolicies returns a VirtletVMPolicyInformer.
+func (v *version) VirtletVMPolicies() VirtletVMPolicyInformer {
+	return &virtletVMPolicyInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
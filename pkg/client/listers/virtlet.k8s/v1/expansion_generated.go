@@ -26,6 +26,14 @@ type VirtletConfigMappingListerExpansion interface{}
 // VirtletConfigMappingNamespaceLister.
 type VirtletConfigMappingNamespaceListerExpansion interface{}
 
+// VirtletFlavorListerExpansion allows custom methods to be added to
+// VirtletFlavorLister.
+type VirtletFlavorListerExpansion interface{}
+
+// VirtletFlavorNamespaceListerExpansion allows custom methods to be added to
+// VirtletFlavorNamespaceLister.
+type VirtletFlavorNamespaceListerExpansion interface{}
+
 // VirtletImageMappingListerExpansion allows custom methods to be added to
 // VirtletImageMappingLister.
 type VirtletImageMappingListerExpansion interface{}
@@ -33,3 +41,11 @@ type VirtletImageMappingListerExpansion interface{}
 // VirtletImageMappingNamespaceListerExpansion allows custom methods to be added to
 // VirtletImageMappingNamespaceLister.
 type VirtletImageMappingNamespaceListerExpansion interface{}
+
+// VirtletVMPolicyListerExpansion allows custom methods to be added to
+// VirtletVMPolicyLister.
+type VirtletVMPolicyListerExpansion interface{}
+
+// VirtletVMPolicyNamespaceListerExpansion allows custom methods to be added to
+// VirtletVMPolicyNamespaceLister.
+type VirtletVMPolicyNamespaceListerExpansion interface{}
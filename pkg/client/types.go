@@ -0,0 +1,172 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+// The types in this file mirror the messages of the Virtlet admin
+// API and the diagnostics protocol. They're defined here instead of
+// being imported from the Virtlet internals so that this package
+// doesn't pull in any internal dependencies. The JSON field names
+// are a part of the wire format and must not be changed.
+
+// VMInfo describes a VM running on the node.
+type VMInfo struct {
+	// ID is the id of the VM (container)
+	ID string `json:"id"`
+	// Name is the name of the container
+	Name string `json:"name"`
+	// PodID is the id of the pod sandbox (pod UID)
+	PodID string `json:"podID"`
+	// PodName is the name of the VM pod
+	PodName string `json:"podName"`
+	// PodNamespace is the namespace of the VM pod
+	PodNamespace string `json:"podNamespace"`
+	// Image is the name of the VM image
+	Image string `json:"image"`
+	// State is the state of the VM: created, running, exited
+	// or unknown
+	State string `json:"state"`
+	// CreatedAt is the time of the VM creation (unix nanoseconds)
+	CreatedAt int64 `json:"createdAt"`
+	// StartedAt is the time of the VM start (unix nanoseconds)
+	StartedAt int64 `json:"startedAt,omitempty"`
+	// Reason is a brief reason for the current state of the VM
+	Reason string `json:"reason,omitempty"`
+	// Message is a human-readable message describing the state
+	// of the VM
+	Message string `json:"message,omitempty"`
+}
+
+// VMStats contains the resource usage of a VM.
+type VMStats struct {
+	// ID is the id of the VM (container)
+	ID string `json:"id"`
+	// Timestamp is the time the stats were collected at
+	// (unix nanoseconds)
+	Timestamp int64 `json:"timestamp"`
+	// CPUUsage is the cumulative CPU time consumed by the VM
+	// in nanoseconds
+	CPUUsage uint64 `json:"cpuUsage"`
+	// MemoryUsage is the working set memory of the VM in bytes
+	MemoryUsage uint64 `json:"memoryUsage"`
+	// MemoryAvailable is the memory available to the guest in
+	// bytes as reported by the balloon driver, 0 if the guest
+	// stats aren't available
+	MemoryAvailable uint64 `json:"memoryAvailable"`
+	// FsBytes is the size of the root filesystem of the VM in bytes
+	FsBytes uint64 `json:"fsBytes"`
+}
+
+// VMDomainXML contains the libvirt domain definition of a VM.
+type VMDomainXML struct {
+	// ID is the id of the VM (container)
+	ID string `json:"id"`
+	// StoredXML is the domain definition saved in the metadata
+	// store when the domain was last defined by Virtlet
+	StoredXML string `json:"storedXML"`
+	// LiveXML is the current definition of the domain in libvirt
+	LiveXML string `json:"liveXML"`
+	// Drifted is true if the domain definition was changed
+	// outside of Virtlet, i.e. LiveXML differs from StoredXML
+	Drifted bool `json:"drifted"`
+}
+
+// SandboxEvent describes a lifecycle event of a pod sandbox or
+// its VM.
+type SandboxEvent struct {
+	// ID is the sequential number of the event
+	ID uint64 `json:"id"`
+	// PodID is the id of the pod sandbox (pod UID)
+	PodID string `json:"podID"`
+	// Timestamp is the time of the event (unix nanoseconds)
+	Timestamp int64 `json:"timestamp"`
+	// Type is the type of the event, e.g. NetworkSetUp or VMCrashed
+	Type string `json:"type"`
+	// ContainerID is the id of the VM (container) the event
+	// applies to, if any
+	ContainerID string `json:"containerID,omitempty"`
+	// Message contains the details of the event, if any
+	Message string `json:"message,omitempty"`
+}
+
+// DiagResult is the diagnostic info collected on the node. The
+// results form a tree that corresponds to the directory structure
+// produced by 'virtletctl diag dump'.
+type DiagResult struct {
+	// Name is the name of the item sans extension
+	Name string `json:"name,omitempty"`
+	// Ext is the file extension of the item
+	Ext string `json:"ext,omitempty"`
+	// Data is the text content of the item
+	Data string `json:"data,omitempty"`
+	// BinaryData is the binary content of the item. It's
+	// used instead of Data for the contents that are not
+	// valid UTF-8 text, e.g. images
+	BinaryData []byte `json:"binaryData,omitempty"`
+	// IsDir is true if the item is a directory
+	IsDir bool `json:"isdir"`
+	// Children contains the child items of the directory
+	Children map[string]DiagResult `json:"children,omitempty"`
+	// Error contains an error message in case if the
+	// diagnostics source has failed to provide the information
+	Error string `json:"error,omitempty"`
+}
+
+type empty struct{}
+
+type vmRequest struct {
+	ID string `json:"id"`
+}
+
+type listVMsResponse struct {
+	VMs []*VMInfo `json:"vms"`
+}
+
+type snapshotVMRequest struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+type snapshotVMResponse struct {
+	Name string `json:"name"`
+}
+
+type migrateVMRequest struct {
+	ID         string `json:"id"`
+	TargetNode string `json:"targetNode"`
+}
+
+type hotplugDeviceRequest struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Source string `json:"source"`
+}
+
+type hotplugDeviceResponse struct {
+	Target string `json:"target"`
+}
+
+type sandboxRequest struct {
+	PodID string `json:"podID"`
+}
+
+type sandboxEventsResponse struct {
+	Events []*SandboxEvent `json:"events"`
+}
+
+type chunk struct {
+	Data []byte `json:"data"`
+}
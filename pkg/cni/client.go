@@ -38,6 +38,9 @@ type Client interface {
 	// It's used for making a dummy gateway for Calico CNI plugin.
 	// It returns a CNI result and a path to the network namespace.
 	GetDummyNetwork() (*cnicurrent.Result, string, error)
+	// NetworkName returns the name of the CNI network the pod
+	// sandboxes are added to.
+	NetworkName() (string, error)
 }
 
 // client provides an implementation of Client interface.
@@ -91,6 +94,15 @@ func (c *client) AddSandboxToNetwork(podID, podName, podNs string) (*cnicurrent.
 	return &r, nil
 }
 
+// NetworkName implements NetworkName method of Client interface.
+func (c *client) NetworkName() (string, error) {
+	netConfigList, err := ReadConfiguration(c.configsDir)
+	if err != nil {
+		return "", fmt.Errorf("error reading CNI configuration: %v", err)
+	}
+	return netConfigList.Name, nil
+}
+
 // RemoveSandboxFromNetwork implements RemoveSandboxFromNetwork method of Client interface.
 func (c *client) RemoveSandboxFromNetwork(podID, podName, podNs string) error {
 	return nsfix.NewCall("cniRemoveSandboxFromNetwork").
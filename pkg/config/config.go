@@ -18,12 +18,17 @@ package config
 
 import (
 	"fmt"
+	"io/ioutil"
 	"math"
+	"os"
 	"sort"
 
 	virtletclient "github.com/Mirantis/virtlet/pkg/client/clientset/versioned"
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
 	flag "github.com/spf13/pflag"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 
@@ -77,35 +82,200 @@ const (
 	defaultStreamPort = 10010
 	streamPortEnv     = "VIRTLET_STREAM_PORT"
 
+	imagePullLimitEnv = "VIRTLET_IMAGE_PULL_LIMIT"
+
+	imagePullRateLimitEnv = "VIRTLET_IMAGE_PULL_RATE_LIMIT"
+
+	imageGCIntervalEnv = "VIRTLET_IMAGE_GC_INTERVAL"
+
+	defaultImageGCMinAge = 120
+	imageGCMinAgeEnv     = "VIRTLET_IMAGE_GC_MIN_AGE"
+
+	imageGCProtectedImagesEnv = "VIRTLET_IMAGE_GC_PROTECTED_IMAGES"
+
+	imageGCHighWatermarkEnv = "VIRTLET_IMAGE_GC_HIGH_WATERMARK"
+
+	defaultImageGCLowWatermark = 80
+	imageGCLowWatermarkEnv     = "VIRTLET_IMAGE_GC_LOW_WATERMARK"
+
+	defaultMemoryStatsPeriod = 10
+	memoryStatsPeriodEnv     = "VIRTLET_MEMORY_STATS_PERIOD"
+
+	consoleAuditDirEnv     = "VIRTLET_CONSOLE_AUDIT_DIR"
+	consoleAuditWebhookEnv = "VIRTLET_CONSOLE_AUDIT_WEBHOOK"
+
+	defaultDiskCacheMode = "auto"
+	diskCacheModeEnv     = "VIRTLET_DISK_CACHE_MODE"
+
+	defaultImageLocking = "auto"
+	imageLockingEnv     = "VIRTLET_IMAGE_LOCKING"
+
+	localAPISocketPathEnv  = "VIRTLET_LOCAL_API_SOCKET"
+	localAPITokenFileEnv   = "VIRTLET_LOCAL_API_TOKEN_FILE"
+	localAPITLSCertFileEnv = "VIRTLET_LOCAL_API_TLS_CERT_FILE"
+	localAPITLSKeyFileEnv  = "VIRTLET_LOCAL_API_TLS_KEY_FILE"
+
+	consoleEndpointsEnv       = "VIRTLET_CONSOLE_ENDPOINTS"
+	defaultConsoleEndpointDir = "/var/run/virtlet/consoles"
+	consoleEndpointDirEnv     = "VIRTLET_CONSOLE_ENDPOINT_DIR"
+
+	autoDisableKVMEnv = "VIRTLET_AUTO_DISABLE_KVM"
+
+	domainMetadataLabelsEnv = "VIRTLET_DOMAIN_METADATA_LABELS"
+
+	hostDevicePolicyFileEnv = "VIRTLET_HOST_DEVICE_POLICY_FILE"
+
+	defaultMetadataBackend = "bolt"
+	metadataBackendEnv     = "VIRTLET_METADATA_BACKEND"
+	etcdEndpointsEnv       = "VIRTLET_ETCD_ENDPOINTS"
+	defaultEtcdKeyPrefix   = "/virtlet/"
+	etcdKeyPrefixEnv       = "VIRTLET_ETCD_KEY_PREFIX"
+	etcdCAFileEnv          = "VIRTLET_ETCD_CA_FILE"
+	etcdCertFileEnv        = "VIRTLET_ETCD_CERT_FILE"
+	etcdKeyFileEnv         = "VIRTLET_ETCD_KEY_FILE"
+
+	defaultSQLiteDatabasePath = "/var/lib/virtlet/virtlet.sqlite"
+	sqliteDatabasePathEnv     = "VIRTLET_SQLITE_DATABASE_PATH"
+
+	defaultBadgerDatabaseDir = "/var/lib/virtlet/badger"
+	badgerDatabaseDirEnv     = "VIRTLET_BADGER_DATABASE_DIR"
+
+	defaultSandboxRemovalPolicy = "cascade"
+	sandboxRemovalPolicyEnv     = "VIRTLET_SANDBOX_REMOVAL_POLICY"
+
+	metadataCompactionThresholdEnv = "VIRTLET_METADATA_COMPACTION_THRESHOLD"
+
+	defaultMaxConcurrentMaintenanceReboots = 1
+	maxConcurrentMaintenanceRebootsEnv     = "VIRTLET_MAX_CONCURRENT_MAINTENANCE_REBOOTS"
+
+	lifecycleWebhooksEnv          = "VIRTLET_LIFECYCLE_WEBHOOKS"
+	lifecycleWebhookSecretFileEnv = "VIRTLET_LIFECYCLE_WEBHOOK_SECRET_FILE"
+
+	adminAPISocketPathEnv = "VIRTLET_ADMIN_API_SOCKET"
+	adminAPITokenFileEnv  = "VIRTLET_ADMIN_API_TOKEN_FILE"
+
+	defaultMemoryBacking   = "default"
+	memoryBackingEnv       = "VIRTLET_MEMORY_BACKING"
+	defaultHugetlbfsMounts = "/dev/hugepages"
+	hugetlbfsMountsEnv     = "VIRTLET_HUGETLBFS_MOUNTS"
+
+	metadataEncryptionKeyFileEnv   = "VIRTLET_METADATA_ENCRYPTION_KEY_FILE"
+	metadataEncryptionKeySecretEnv = "VIRTLET_METADATA_ENCRYPTION_KEY_SECRET"
+
+	defaultMetadataGCInterval = 600
+	metadataGCIntervalEnv     = "VIRTLET_METADATA_GC_INTERVAL"
+	simulateVMsEnv            = "VIRTLET_SIMULATE_VMS"
+	metricsAddressEnv         = "VIRTLET_METRICS_ADDRESS"
+
+	defaultSandboxTombstoneTTL = 86400
+	sandboxTombstoneTTLEnv     = "VIRTLET_SANDBOX_TOMBSTONE_TTL"
+
+	crashLoopReuseTTLEnv = "VIRTLET_CRASH_LOOP_REUSE_TTL"
+
+	defaultReconcilePolicy = "repair"
+	reconcilePolicyEnv     = "VIRTLET_RECONCILE_POLICY"
+
+	metadataAuditLogEnv            = "VIRTLET_METADATA_AUDIT_LOG"
+	defaultMetadataAuditLogMaxSize = 10
+	metadataAuditLogMaxSizeEnv     = "VIRTLET_METADATA_AUDIT_LOG_MAX_SIZE"
+
+	configMappingNamespace = "kube-system"
+
 	kubeletRootDir    = "/var/lib/kubelet/pods"
 	kubeletRootDirEnv = "KUBELET_ROOT_DIR"
+
+	absolutePathPattern         = "^/"
+	optionalAbsolutePathPattern = "^(/.*)?$"
 )
 
+// hotReloadableFields lists the config fields which can be applied
+// by a running Virtlet instance without restarting it.
+var hotReloadableFields = map[string]bool{
+	"logLevel":               true,
+	"rawDevices":             true,
+	"cpuModel":               true,
+	"imagePullLimit":         true,
+	"imagePullRateLimit":     true,
+	"imageGCInterval":        true,
+	"imageGCMinAge":          true,
+	"imageGCProtectedImages": true,
+	"imageGCHighWatermark":   true,
+	"imageGCLowWatermark":    true,
+}
+
 func configFieldSet(c *virtlet_v1.VirtletConfig) *fieldSet {
 	var fs fieldSet
-	fs.addStringField("fdServerSocketPath", "fd-server-socket-path", "", "Path to fd server socket", fdServerSocketPathEnv, defaultFDServerSocketPath, &c.FDServerSocketPath)
-	fs.addStringField("databasePath", "database-path", "", "Path to the virtlet database", databasePathEnv, defaultDatabasePath, &c.DatabasePath)
+	fs.addStringFieldWithPattern("fdServerSocketPath", "fd-server-socket-path", "", "Path to fd server socket", fdServerSocketPathEnv, defaultFDServerSocketPath, absolutePathPattern, &c.FDServerSocketPath)
+	fs.addStringFieldWithPattern("databasePath", "database-path", "", "Path to the virtlet database", databasePathEnv, defaultDatabasePath, absolutePathPattern, &c.DatabasePath)
 	fs.addStringFieldWithPattern("downloadProtocol", "image-download-protocol", "", "Image download protocol. Can be https or http", imageDownloadProtocolEnv, defaultDownloadProtocol, "^https?$", &c.DownloadProtocol)
-	fs.addStringField("imageDir", "image-dir", "", "Image directory", imageDirEnv, defaultImageDir, &c.ImageDir)
-	fs.addStringField("imageTranslationConfigsDir", "image-translation-configs-dir", "", "Image name translation configs directory", imageTranslationsConfigDirEnv, defaultImageTranslationConfigsDir, &c.ImageTranslationConfigsDir)
+	fs.addStringFieldWithPattern("imageDir", "image-dir", "", "Image directory", imageDirEnv, defaultImageDir, absolutePathPattern, &c.ImageDir)
+	fs.addStringFieldWithPattern("imageTranslationConfigsDir", "image-translation-configs-dir", "", "Image name translation configs directory", imageTranslationsConfigDirEnv, defaultImageTranslationConfigsDir, optionalAbsolutePathPattern, &c.ImageTranslationConfigsDir)
 	// SkipImageTranslation doesn't have corresponding flag or env var as it's only used by tests
 	fs.addBoolField("skipImageTranslation", "", "", "", "", false, &c.SkipImageTranslation)
-	fs.addStringField("libvirtURI", "libvirt-uri", "", "Libvirt connection URI", libvirtURIEnv, defaultLibvirtURI, &c.LibvirtURI)
+	fs.addStringFieldWithPattern("libvirtURI", "libvirt-uri", "", "Libvirt connection URI", libvirtURIEnv, defaultLibvirtURI, "^[a-z][a-z0-9+]*://", &c.LibvirtURI)
 	fs.addStringField("rawDevices", "raw-devices", "", "Comma separated list of raw device glob patterns which VMs can access (without '/dev/' prefix)", rawDevicesEnv, defaultRawDevices, &c.RawDevices)
-	fs.addStringField("criSocketPath", "listen", "", "The path to UNIX domain socket for CRI service to listen on", criSocketPathEnv, defaultCRISocketPath, &c.CRISocketPath)
+	fs.addStringFieldWithPattern("criSocketPath", "listen", "", "The path to UNIX domain socket for CRI service to listen on", criSocketPathEnv, defaultCRISocketPath, absolutePathPattern, &c.CRISocketPath)
 	fs.addBoolField("disableLogging", "disable-logging", "", "Display logging and the streamer", disableLoggingEnv, false, &c.DisableLogging)
 	fs.addBoolField("disableKVM", "disable-kvm", "", "Forcibly disable KVM support", disableKVMEnv, false, &c.DisableKVM)
 	fs.addBoolField("enableSriov", "enable-sriov", "", "Enable SR-IOV support", enableSriovEnv, false, &c.EnableSriov)
-	fs.addStringField("cniPluginDir", "cni-bin-dir", "", "Path to CNI plugin binaries", cniPluginDirEnv, defaultCNIPluginDir, &c.CNIPluginDir)
-	fs.addStringField("cniConfigDir", "cni-conf-dir", "", "Path to the CNI configuration directory", cniConfigDirEnv, defaultCNIConfigDir, &c.CNIConfigDir)
+	fs.addStringFieldWithPattern("cniPluginDir", "cni-bin-dir", "", "Path to CNI plugin binaries", cniPluginDirEnv, defaultCNIPluginDir, absolutePathPattern, &c.CNIPluginDir)
+	fs.addStringFieldWithPattern("cniConfigDir", "cni-conf-dir", "", "Path to the CNI configuration directory", cniConfigDirEnv, defaultCNIConfigDir, absolutePathPattern, &c.CNIConfigDir)
 	fs.addIntField("calicoSubnetSize", "calico-subnet-size", "", "Calico subnet size to use", calicoSubnetEnv, defaultCalicoSubnet, 0, 32, &c.CalicoSubnetSize)
 	fs.addBoolField("enableRegexpImageTranslation", "enable-regexp-image-translation", "", "Enable regexp image name translation", enableRegexpImageTranslationEnv, true, &c.EnableRegexpImageTranslation)
-	fs.addStringField("cpuModel", "cpu-model", "", "CPU model to use in libvirt domain definition (libvirt's default value will be used if not set)", cpuModelEnv, defaultCPUModel, &c.CPUModel)
+	fs.addStringFieldWithPattern("cpuModel", "cpu-model", "", "CPU model to use in libvirt domain definition (libvirt's default value will be used if not set)", cpuModelEnv, defaultCPUModel, "^(host-model)?$", &c.CPUModel)
 	fs.addIntField("streamPort", "stream-port", "", "configurable port to the virtlet server", streamPortEnv, defaultStreamPort, 1, 65535, &c.StreamPort)
-	fs.addStringField("kubeletRootDir", "kubelet-root-dir", "", "Pod's root dir in kubelet", kubeletRootDirEnv, kubeletRootDir, &c.KubeletRootDir)
+	fs.addStringFieldWithPattern("kubeletRootDir", "kubelet-root-dir", "", "Pod's root dir in kubelet", kubeletRootDirEnv, kubeletRootDir, absolutePathPattern, &c.KubeletRootDir)
 	// this field duplicates glog's --v, so no option for it, which is signified
 	// by "+" here (it's only for doc)
 	fs.addIntField("logLevel", "+v", "", "Log level to use", logLevelEnv, 1, 0, math.MaxInt32, &c.LogLevel)
+	fs.addIntField("imagePullLimit", "image-pull-limit", "", "Maximum number of images that can be pulled simultaneously (0 means no limit)", imagePullLimitEnv, 0, 0, math.MaxInt32, &c.ImagePullLimit)
+	fs.addIntField("imagePullRateLimit", "image-pull-rate-limit", "", "Maximum number of image pulls that can be started per minute (0 means no limit)", imagePullRateLimitEnv, 0, 0, math.MaxInt32, &c.ImagePullRateLimit)
+	fs.addIntField("imageGCInterval", "image-gc-interval", "", "Interval between periodic image garbage collection runs in seconds (0 disables periodic image GC)", imageGCIntervalEnv, 0, 0, math.MaxInt32, &c.ImageGCInterval)
+	fs.addIntField("imageGCMinAge", "image-gc-min-age", "", "Minimum age of an unused image in seconds before it can be removed by the disk usage based image GC", imageGCMinAgeEnv, defaultImageGCMinAge, 0, math.MaxInt32, &c.ImageGCMinAge)
+	fs.addStringField("imageGCProtectedImages", "image-gc-protected-images", "", "Comma separated list of glob patterns for the names of the images that are never removed by the disk usage based image GC", imageGCProtectedImagesEnv, "", &c.ImageGCProtectedImages)
+	fs.addIntField("imageGCHighWatermark", "image-gc-high-watermark", "", "Disk usage percentage of the image filesystem that triggers removal of unused images (0 disables the disk usage based image GC)", imageGCHighWatermarkEnv, 0, 0, 100, &c.ImageGCHighWatermark)
+	fs.addIntField("imageGCLowWatermark", "image-gc-low-watermark", "", "Disk usage percentage of the image filesystem the disk usage based image GC attempts to reach", imageGCLowWatermarkEnv, defaultImageGCLowWatermark, 0, 100, &c.ImageGCLowWatermark)
+	fs.addIntField("memoryStatsPeriod", "memory-stats-period", "", "Interval between the polls of guest memory stats via the memory balloon driver in seconds (0 disables the polling)", memoryStatsPeriodEnv, defaultMemoryStatsPeriod, 0, math.MaxInt32, &c.MemoryStatsPeriod)
+	fs.addStringFieldWithPattern("consoleAuditDir", "console-audit-dir", "", "Directory to store the recordings of the interactive VM console sessions in (empty value disables the recording)", consoleAuditDirEnv, "", optionalAbsolutePathPattern, &c.ConsoleAuditDir)
+	fs.addStringFieldWithPattern("consoleAuditWebhook", "console-audit-webhook", "", "URL to POST the recordings of the VM console sessions to after the sessions end", consoleAuditWebhookEnv, "", "^(https?://.*)?$", &c.ConsoleAuditWebhook)
+	fs.addStringFieldWithPattern("diskCacheMode", "disk-cache-mode", "", "Cache mode for the qcow2 VM disks: auto, default, none, writethrough, writeback, directsync or unsafe (auto selects the mode based on the storage filesystem)", diskCacheModeEnv, defaultDiskCacheMode, "^(auto|default|none|writethrough|writeback|directsync|unsafe)$", &c.DiskCacheMode)
+	fs.addStringFieldWithPattern("imageLocking", "image-locking", "", "Whether qemu should lock the VM disk images: auto, on or off (auto disables the locking on filesystems that don't support it)", imageLockingEnv, defaultImageLocking, "^(auto|on|off)$", &c.ImageLocking)
+	fs.addStringFieldWithPattern("localAPISocketPath", "local-api-socket", "", "Path of the unix socket for the node-local REST API (empty value disables the API)", localAPISocketPathEnv, "", optionalAbsolutePathPattern, &c.LocalAPISocketPath)
+	fs.addStringFieldWithPattern("localAPITokenFile", "local-api-token-file", "", "Path to the file containing the bearer token for the node-local REST API", localAPITokenFileEnv, "", optionalAbsolutePathPattern, &c.LocalAPITokenFile)
+	fs.addStringFieldWithPattern("localAPITLSCertFile", "local-api-tls-cert-file", "", "Path to the TLS certificate for the node-local REST API (empty value makes the API use plain HTTP)", localAPITLSCertFileEnv, "", optionalAbsolutePathPattern, &c.LocalAPITLSCertFile)
+	fs.addStringFieldWithPattern("localAPITLSKeyFile", "local-api-tls-key-file", "", "Path to the private key of the TLS certificate for the node-local REST API", localAPITLSKeyFileEnv, "", optionalAbsolutePathPattern, &c.LocalAPITLSKeyFile)
+	fs.addStringFieldWithPattern("consoleEndpoints", "console-endpoints", "", "Expose the serial consoles of the VMs on authenticated local endpoints for external tools: unix (sockets in consoleEndpointDir) or tcp (ports on 127.0.0.1); empty value disables the endpoints", consoleEndpointsEnv, "", "^(unix|tcp)?$", &c.ConsoleEndpoints)
+	fs.addStringFieldWithPattern("consoleEndpointDir", "console-endpoint-dir", "", "Directory for the unix domain sockets of the console endpoints", consoleEndpointDirEnv, defaultConsoleEndpointDir, absolutePathPattern, &c.ConsoleEndpointDir)
+	fs.addBoolField("autoDisableKVM", "auto-disable-kvm", "", "Disable KVM support automatically if KVM is not usable on the node", autoDisableKVMEnv, false, &c.AutoDisableKVM)
+	fs.addStringField("domainMetadataLabels", "domain-metadata-labels", "", "Comma separated list of pod label keys to copy to the metadata of libvirt domains", domainMetadataLabelsEnv, "", &c.DomainMetadataLabels)
+	fs.addStringFieldWithPattern("hostDevicePolicyFile", "host-device-policy-file", "", "Path to the file with the policy that specifies which host devices can be passed to the VMs (empty value disables passing the host devices)", hostDevicePolicyFileEnv, "", optionalAbsolutePathPattern, &c.HostDevicePolicyFile)
+	fs.addStringFieldWithPattern("metadataBackend", "metadata-backend", "", "Metadata store backend: bolt (local database file at databasePath), badger (local database at badgerDatabaseDir), etcd or sqlite (local database file at sqliteDatabasePath)", metadataBackendEnv, defaultMetadataBackend, "^(bolt|badger|etcd|sqlite)$", &c.MetadataBackend)
+	fs.addStringField("etcdEndpoints", "etcd-endpoints", "", "Comma separated list of etcd client URLs for the etcd metadata backend", etcdEndpointsEnv, "", &c.EtcdEndpoints)
+	fs.addStringFieldWithPattern("etcdKeyPrefix", "etcd-key-prefix", "", "Prefix of the etcd keys used by the etcd metadata backend (the node name is appended to it)", etcdKeyPrefixEnv, defaultEtcdKeyPrefix, "^/", &c.EtcdKeyPrefix)
+	fs.addStringFieldWithPattern("etcdCAFile", "etcd-ca-file", "", "Path to the CA certificate used to verify the etcd server certificates", etcdCAFileEnv, "", optionalAbsolutePathPattern, &c.EtcdCAFile)
+	fs.addStringFieldWithPattern("etcdCertFile", "etcd-cert-file", "", "Path to the client certificate for etcd", etcdCertFileEnv, "", optionalAbsolutePathPattern, &c.EtcdCertFile)
+	fs.addStringFieldWithPattern("etcdKeyFile", "etcd-key-file", "", "Path to the private key of the client certificate for etcd", etcdKeyFileEnv, "", optionalAbsolutePathPattern, &c.EtcdKeyFile)
+	fs.addStringFieldWithPattern("lifecycleWebhooks", "lifecycle-webhooks", "", "Comma separated list of URLs to POST the VM lifecycle events to", lifecycleWebhooksEnv, "", "^(https?://[^,]+(,https?://[^,]+)*)?$", &c.LifecycleWebhooks)
+	fs.addStringFieldWithPattern("lifecycleWebhookSecretFile", "lifecycle-webhook-secret-file", "", "Path to the file with the key used to sign the lifecycle webhook requests (empty value disables signing)", lifecycleWebhookSecretFileEnv, "", optionalAbsolutePathPattern, &c.LifecycleWebhookSecretFile)
+	fs.addStringFieldWithPattern("adminAPISocketPath", "admin-api-socket", "", "Path of the unix socket for the node-local gRPC admin API (empty value disables the API)", adminAPISocketPathEnv, "", optionalAbsolutePathPattern, &c.AdminAPISocketPath)
+	fs.addStringFieldWithPattern("adminAPITokenFile", "admin-api-token-file", "", "Path to the file containing the tokens and their roles for the gRPC admin API", adminAPITokenFileEnv, "", optionalAbsolutePathPattern, &c.AdminAPITokenFile)
+	fs.addStringFieldWithPattern("memoryBacking", "memory-backing", "", "Default source of the VM memory: default, memfd, hugepages or file (can be overridden using VirtletMemoryBacking pod annotation)", memoryBackingEnv, defaultMemoryBacking, "^(default|memfd|hugepages|file)$", &c.MemoryBacking)
+	fs.addStringField("hugetlbfsMounts", "hugetlbfs-mounts", "", "Comma separated list of hugetlbfs mount points to use for hugepage-backed VM memory, one per huge page size", hugetlbfsMountsEnv, defaultHugetlbfsMounts, &c.HugetlbfsMounts)
+	fs.addStringFieldWithPattern("metadataEncryptionKeyFile", "metadata-encryption-key-file", "", "Path to the file containing the key used to encrypt the pod sandbox and container records in the bolt metadata database", metadataEncryptionKeyFileEnv, "", optionalAbsolutePathPattern, &c.MetadataEncryptionKeyFile)
+	fs.addStringFieldWithPattern("metadataEncryptionKeySecret", "metadata-encryption-key-secret", "", "Kubernetes secret (namespace/name) whose key item contains the key used to encrypt the bolt metadata database records", metadataEncryptionKeySecretEnv, "", "^([a-z0-9.-]+/[a-z0-9.-]+)?$", &c.MetadataEncryptionKeySecret)
+	fs.addIntField("metadataGCInterval", "metadata-gc-interval", "", "Interval between periodic removals of the orphaned pod sandbox and container records from the metadata store in seconds (0 disables periodic metadata GC)", metadataGCIntervalEnv, defaultMetadataGCInterval, 0, math.MaxInt32, &c.MetadataGCInterval)
+	fs.addBoolField("simulateVMs", "simulate-vms", "", "Use a no-op VM simulator instead of libvirt and CNI for scale testing of Virtlet (no actual VMs are started)", simulateVMsEnv, false, &c.SimulateVMs)
+	fs.addStringFieldWithPattern("metricsAddress", "metrics-address", "", "Address (host:port) for the Prometheus metrics endpoint to listen on (empty disables the metrics endpoint)", metricsAddressEnv, "", "^([^:/]*:[0-9]+)?$", &c.MetricsAddress)
+	fs.addIntField("sandboxTombstoneTTL", "sandbox-tombstone-ttl", "", "Time in seconds to keep the tombstone records of the removed pod sandboxes in the metadata store (0 disables the tombstones)", sandboxTombstoneTTLEnv, defaultSandboxTombstoneTTL, 0, math.MaxInt32, &c.SandboxTombstoneTTL)
+	fs.addStringFieldWithPattern("sqliteDatabasePath", "sqlite-database-path", "", "Path to the database file for the sqlite metadata backend", sqliteDatabasePathEnv, defaultSQLiteDatabasePath, absolutePathPattern, &c.SQLiteDatabasePath)
+	fs.addStringFieldWithPattern("badgerDatabaseDir", "badger-database-dir", "", "Path to the directory holding the database of the badger metadata backend", badgerDatabaseDirEnv, defaultBadgerDatabaseDir, absolutePathPattern, &c.BadgerDatabaseDir)
+	fs.addStringFieldWithPattern("sandboxRemovalPolicy", "sandbox-removal-policy", "", "What to do with the container records of a pod sandbox when it is removed from the metadata store: cascade (remove them too) or restrict (refuse to remove the sandbox)", sandboxRemovalPolicyEnv, defaultSandboxRemovalPolicy, "^(cascade|restrict)$", &c.SandboxRemovalPolicy)
+	fs.addIntField("metadataCompactionThreshold", "metadata-compaction-threshold", "", "Percentage of free pages in the bolt metadata database file above which the database is compacted upon Virtlet start (0 disables the automatic compaction)", metadataCompactionThresholdEnv, 0, 0, 100, &c.MetadataCompactionThreshold)
+	fs.addIntField("maxConcurrentMaintenanceReboots", "max-concurrent-maintenance-reboots", "", "Maximum number of VMs that may be rebooted during their maintenance windows at the same time on the node (0 disables the maintenance reboots)", maxConcurrentMaintenanceRebootsEnv, defaultMaxConcurrentMaintenanceReboots, 0, math.MaxInt32, &c.MaxConcurrentMaintenanceReboots)
+	fs.addIntField("crashLoopReuseTTL", "crash-loop-reuse-ttl", "", "Time in seconds during which the root volume, the ephemeral disks and the config drive of a removed VM and the recently pulled images are reused if the VM is re-created, e.g. when it's crash-looping (0 disables the reuse)", crashLoopReuseTTLEnv, 0, 0, math.MaxInt32, &c.CrashLoopReuseTTL)
+	fs.addStringFieldWithPattern("reconcilePolicy", "reconcile-policy", "", "What to do with the discrepancies between the metadata store and libvirt domains found upon Virtlet start: repair (remove stale container records and orphan domains), adopt (keep orphan domains so they can be adopted) or mark-orphaned (only mark stale container records)", reconcilePolicyEnv, defaultReconcilePolicy, "^(repair|adopt|mark-orphaned)$", &c.ReconcilePolicy)
+	fs.addStringFieldWithPattern("metadataAuditLog", "metadata-audit-log", "", "Path of the file to append the audit records of the pod sandbox, container and image pull record changes made in the metadata store to (empty value disables the audit log)", metadataAuditLogEnv, "", optionalAbsolutePathPattern, &c.MetadataAuditLog)
+	fs.addIntField("metadataAuditLogMaxSize", "metadata-audit-log-max-size", "", "Size of the metadata audit log in MiB after which the log is rotated", metadataAuditLogMaxSizeEnv, defaultMetadataAuditLogMaxSize, 1, math.MaxInt32, &c.MetadataAuditLogMaxSize)
 	return &fs
 }
 
@@ -128,6 +298,44 @@ func DumpEnv(c *virtlet_v1.VirtletConfig) string {
 	return configFieldSet(c).dumpEnv()
 }
 
+// ValidateConfig verifies that the values of the fields that are
+// set in the config are valid.
+func ValidateConfig(c *virtlet_v1.VirtletConfig) error {
+	if err := configFieldSet(c).validate(); err != nil {
+		return err
+	}
+	if c.ImageGCHighWatermark != nil && c.ImageGCLowWatermark != nil &&
+		*c.ImageGCHighWatermark != 0 && *c.ImageGCLowWatermark >= *c.ImageGCHighWatermark {
+		return fmt.Errorf("imageGCLowWatermark (%d) must be less than imageGCHighWatermark (%d)", *c.ImageGCLowWatermark, *c.ImageGCHighWatermark)
+	}
+	return nil
+}
+
+// LoadConfigFile loads the config from the specified YAML file, such
+// as a key of a ConfigMap mounted into Virtlet container. It returns
+// nil config and no error if the file doesn't exist.
+func LoadConfigFile(path string) (*virtlet_v1.VirtletConfig, error) {
+	bs, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	return parseConfigFile(bs)
+}
+
+func parseConfigFile(bs []byte) (*virtlet_v1.VirtletConfig, error) {
+	var cfg virtlet_v1.VirtletConfig
+	if err := yaml.Unmarshal(bs, &cfg); err != nil {
+		return nil, fmt.Errorf("can't parse the config file: %v", err)
+	}
+	if err := ValidateConfig(&cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
 // GenerateDoc generates a markdown document with a table describing
 // all the configuration settings.
 func GenerateDoc() string {
@@ -147,6 +355,18 @@ func mappingMatches(cm virtlet_v1.VirtletConfigMapping, nodeName string, nodeLab
 			return false
 		}
 	}
+	if len(cm.Spec.NodeSelectorExpressions) != 0 {
+		selector, err := meta_v1.LabelSelectorAsSelector(&meta_v1.LabelSelector{
+			MatchExpressions: cm.Spec.NodeSelectorExpressions,
+		})
+		if err != nil {
+			glog.Warningf("Bad node selector expressions in Virtlet config mapping %q: %v", cm.Name, err)
+			return false
+		}
+		if !selector.Matches(labels.Set(nodeLabels)) {
+			return false
+		}
+	}
 	return true
 }
 
@@ -270,7 +490,7 @@ func (nc *NodeConfig) LoadConfig(localConfig *virtlet_v1.VirtletConfig, nodeName
 		return nil, fmt.Errorf("can't get node info for node %q: %v", nodeName, err)
 	}
 
-	mappingList, err := nc.virtletClient.VirtletV1().VirtletConfigMappings("kube-system").List(meta_v1.ListOptions{})
+	mappingList, err := nc.virtletClient.VirtletV1().VirtletConfigMappings(configMappingNamespace).List(meta_v1.ListOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list Virtlet config mappings: %v", err)
 	}
@@ -195,6 +195,14 @@ spec:
 spec:
   nodeSelector:
     label-a: "1"` + fullConfig
+	labelExpressionsFullMapping = `
+spec:
+  nodeSelectorExpressions:
+  - key: label-a
+    operator: In
+    values: ["1", "2"]
+  - key: label-x
+    operator: DoesNotExist` + fullConfig
 	globalFullMapping = "spec:" + fullConfig
 	anotherMapping1   = `
 spec:
@@ -264,6 +272,18 @@ func TestConfigForNode(t *testing.T) {
 			nodeLabels: map[string]string{"label-x": "1"},
 			mappings:   []string{labelAFullMapping},
 		},
+		{
+			name:       "mapping by node label expressions",
+			nodeName:   "kube-node-1",
+			nodeLabels: map[string]string{"label-a": "2"},
+			mappings:   []string{labelExpressionsFullMapping},
+		},
+		{
+			name:       "mapping by node label expressions (no match)",
+			nodeName:   "kube-node-1",
+			nodeLabels: map[string]string{"label-a": "1", "label-x": "1"},
+			mappings:   []string{labelExpressionsFullMapping},
+		},
 		{
 			name:       "global mapping",
 			nodeName:   "kube-node-1",
@@ -27,4 +27,13 @@ const (
 	LogPathEnvVarName = "VIRTLET_CONTAINER_LOG_PATH"
 	// NetKeyEnvVarName contains name of env variable passed from virtlet to vmwrapper
 	NetKeyEnvVarName = "VIRTLET_NET_KEY"
+	// NetBootIndexEnvVarName contains name of env variable passed from virtlet to vmwrapper
+	// that specifies the boot index of the first network interface
+	NetBootIndexEnvVarName = "VIRTLET_NET_BOOT_INDEX"
+	// DisableImageLockingEnvVarName contains name of env variable passed from virtlet to vmwrapper
+	// that makes it turn off qemu image locking for the disks
+	DisableImageLockingEnvVarName = "VIRTLET_DISABLE_IMAGE_LOCKING"
+	// NICModelEnvVarName contains name of env variable passed from virtlet to vmwrapper
+	// that specifies the model of the network interfaces if it's not virtio
+	NICModelEnvVarName = "VIRTLET_NIC_MODEL"
 )
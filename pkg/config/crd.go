@@ -42,6 +42,31 @@ func configMappingProps() *apiext.JSONSchemaProps {
 						// 	},
 						// },
 					},
+					"nodeSelectorExpressions": {
+						Type: "array",
+						Items: &apiext.JSONSchemaPropsOrArray{
+							Schema: &apiext.JSONSchemaProps{
+								Required: []string{"key", "operator"},
+								Properties: map[string]apiext.JSONSchemaProps{
+									"key": {
+										Type: "string",
+									},
+									"operator": {
+										Type:    "string",
+										Pattern: "^(In|NotIn|Exists|DoesNotExist)$",
+									},
+									"values": {
+										Type: "array",
+										Items: &apiext.JSONSchemaPropsOrArray{
+											Schema: &apiext.JSONSchemaProps{
+												Type: "string",
+											},
+										},
+									},
+								},
+							},
+						},
+					},
 					"priority": {
 						Type: "integer",
 					},
@@ -54,7 +79,75 @@ func configMappingProps() *apiext.JSONSchemaProps {
 	}
 }
 
-// GetCRDDefinitions returns custom resource definitions for VirtletImageMapping kind in k8s.
+func vmPolicyProps() *apiext.JSONSchemaProps {
+	minSeconds, minCount := float64(0), float64(0)
+	return &apiext.JSONSchemaProps{
+		Properties: map[string]apiext.JSONSchemaProps{
+			"spec": {
+				Properties: map[string]apiext.JSONSchemaProps{
+					"terminationGracePeriodSeconds": {
+						Type:    "integer",
+						Minimum: &minSeconds,
+					},
+					"onCrash": {
+						Type:    "string",
+						Pattern: "^(destroy|restart|preserve|coredump-destroy|coredump-restart)$",
+					},
+					"watchdogAction": {
+						Type:    "string",
+						Pattern: "^(reset|shutdown|poweroff|pause|none|dump|inject-nmi)$",
+					},
+					"restartBackoffSeconds": {
+						Type:    "integer",
+						Minimum: &minSeconds,
+					},
+					"maxVMsPerNode": {
+						Type:    "integer",
+						Minimum: &minCount,
+					},
+					"maxVCPUsPerNode": {
+						Type:    "integer",
+						Minimum: &minCount,
+					},
+					"maintenanceWindow": {
+						Type: "string",
+					},
+					"maintenanceRebootCondition": {
+						Type:    "string",
+						Pattern: "^(always|reboot-required)$",
+					},
+					"maxConcurrentMaintenanceReboots": {
+						Type:    "integer",
+						Minimum: &minCount,
+					},
+				},
+			},
+		},
+	}
+}
+
+func flavorProps() *apiext.JSONSchemaProps {
+	minVCPUs, maxVCPUs := float64(1), float64(255)
+	return &apiext.JSONSchemaProps{
+		Properties: map[string]apiext.JSONSchemaProps{
+			"spec": {
+				Properties: map[string]apiext.JSONSchemaProps{
+					"vcpuCount": {
+						Type:    "integer",
+						Minimum: &minVCPUs,
+						Maximum: &maxVCPUs,
+					},
+					"tuningProfile": {
+						Type:    "string",
+						Pattern: "^(latency|throughput)$",
+					},
+				},
+			},
+		},
+	}
+}
+
+// GetCRDDefinitions returns custom resource definitions for Virtlet kinds in k8s.
 func GetCRDDefinitions() []runtime.Object {
 	gv := virtlet_v1.SchemeGroupVersion
 	return []runtime.Object{
@@ -105,6 +198,61 @@ func GetCRDDefinitions() []runtime.Object {
 				Validation: &apiext.CustomResourceValidation{
 					OpenAPIV3Schema: configMappingProps(),
 				},
+				Subresources: &apiext.CustomResourceSubresources{
+					Status: &apiext.CustomResourceSubresourceStatus{},
+				},
+			},
+		},
+		&apiext.CustomResourceDefinition{
+			TypeMeta: meta_v1.TypeMeta{
+				APIVersion: "apiextensions.k8s.io/v1beta1",
+				Kind:       "CustomResourceDefinition",
+			},
+			ObjectMeta: meta_v1.ObjectMeta{
+				Labels: map[string]string{
+					"virtlet.cloud": "",
+				},
+				Name: "virtletvmpolicies." + gv.Group,
+			},
+			Spec: apiext.CustomResourceDefinitionSpec{
+				Group:   gv.Group,
+				Version: gv.Version,
+				Scope:   apiext.NamespaceScoped,
+				Names: apiext.CustomResourceDefinitionNames{
+					Plural:     "virtletvmpolicies",
+					Singular:   "virtletvmpolicy",
+					Kind:       "VirtletVMPolicy",
+					ShortNames: []string{"vvp"},
+				},
+				Validation: &apiext.CustomResourceValidation{
+					OpenAPIV3Schema: vmPolicyProps(),
+				},
+			},
+		},
+		&apiext.CustomResourceDefinition{
+			TypeMeta: meta_v1.TypeMeta{
+				APIVersion: "apiextensions.k8s.io/v1beta1",
+				Kind:       "CustomResourceDefinition",
+			},
+			ObjectMeta: meta_v1.ObjectMeta{
+				Labels: map[string]string{
+					"virtlet.cloud": "",
+				},
+				Name: "virtletflavors." + gv.Group,
+			},
+			Spec: apiext.CustomResourceDefinitionSpec{
+				Group:   gv.Group,
+				Version: gv.Version,
+				Scope:   apiext.NamespaceScoped,
+				Names: apiext.CustomResourceDefinitionNames{
+					Plural:     "virtletflavors",
+					Singular:   "virtletflavor",
+					Kind:       "VirtletFlavor",
+					ShortNames: []string{"vfl"},
+				},
+				Validation: &apiext.CustomResourceValidation{
+					OpenAPIV3Schema: flavorProps(),
+				},
 			},
 		},
 	}
@@ -18,8 +18,10 @@ package config
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -27,6 +29,8 @@ import (
 	"github.com/kballard/go-shellquote"
 	flag "github.com/spf13/pflag"
 	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+
+	virtlet_v1 "github.com/Mirantis/virtlet/pkg/api/virtlet.k8s/v1"
 )
 
 type configField interface {
@@ -42,8 +46,10 @@ type configField interface {
 	override(from configField)
 	setFromEnvValue(value string)
 	envValue() string
+	valueStr() string
 	schemaProps() (string, apiext.JSONSchemaProps)
 	description() string
+	validate() error
 }
 
 type fieldBase struct {
@@ -102,6 +108,8 @@ func (sf *stringField) envValue() string {
 	return **sf.value
 }
 
+func (sf *stringField) valueStr() string { return sf.envValue() }
+
 func (sf *stringField) schemaProps() (string, apiext.JSONSchemaProps) {
 	return sf.field, apiext.JSONSchemaProps{
 		Type:    "string",
@@ -109,6 +117,20 @@ func (sf *stringField) schemaProps() (string, apiext.JSONSchemaProps) {
 	}
 }
 
+func (sf *stringField) validate() error {
+	if *sf.value == nil || sf.pattern == "" {
+		return nil
+	}
+	matched, err := regexp.MatchString(sf.pattern, **sf.value)
+	if err != nil {
+		return fmt.Errorf("bad pattern for field %s: %v", sf.field, err)
+	}
+	if !matched {
+		return fmt.Errorf("bad value for field %s: %q doesn't match %q", sf.field, **sf.value, sf.pattern)
+	}
+	return nil
+}
+
 type boolField struct {
 	fieldBase
 	defValue bool
@@ -152,12 +174,21 @@ func (bf *boolField) envValue() string {
 	return "1"
 }
 
+func (bf *boolField) valueStr() string {
+	if *bf.value == nil {
+		return ""
+	}
+	return strconv.FormatBool(**bf.value)
+}
+
 func (bf *boolField) schemaProps() (string, apiext.JSONSchemaProps) {
 	return bf.field, apiext.JSONSchemaProps{
 		Type: "boolean",
 	}
 }
 
+func (bf *boolField) validate() error { return nil }
+
 type intField struct {
 	fieldBase
 	defValue int
@@ -206,6 +237,8 @@ func (intf *intField) envValue() string {
 	return strconv.Itoa(**intf.value)
 }
 
+func (intf *intField) valueStr() string { return intf.envValue() }
+
 func (intf *intField) schemaProps() (string, apiext.JSONSchemaProps) {
 	min := float64(intf.min)
 	max := float64(intf.max)
@@ -216,6 +249,16 @@ func (intf *intField) schemaProps() (string, apiext.JSONSchemaProps) {
 	}
 }
 
+func (intf *intField) validate() error {
+	if *intf.value == nil {
+		return nil
+	}
+	if v := **intf.value; v < intf.min || v > intf.max {
+		return fmt.Errorf("bad value for field %s: %d is out of range [%d, %d]", intf.field, v, intf.min, intf.max)
+	}
+	return nil
+}
+
 type envLookup func(name string) (string, bool)
 
 type fieldSet struct {
@@ -318,6 +361,64 @@ func (fs *fieldSet) dumpEnv() string {
 	return buf.String()
 }
 
+func (fs *fieldSet) validate() error {
+	var errs []string
+	for _, f := range fs.fields {
+		if err := f.validate(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// changedFields returns the names of the fields which have
+// different values in the other field set.
+func (fs *fieldSet) changedFields(other *fieldSet) []string {
+	var r []string
+	for n, f := range fs.fields {
+		o := other.fields[n]
+		if f.present() != o.present() || f.envValue() != o.envValue() {
+			r = append(r, f.fieldName())
+		}
+	}
+	return r
+}
+
+// diff returns the descriptions of the fields which have different
+// values in the other field set, with the values from the other
+// field set treated as the effective ones.
+func (fs *fieldSet) diff(other *fieldSet) []virtlet_v1.VirtletConfigFieldDiff {
+	var r []virtlet_v1.VirtletConfigFieldDiff
+	for n, f := range fs.fields {
+		o := other.fields[n]
+		if f.present() != o.present() || f.envValue() != o.envValue() {
+			r = append(r, virtlet_v1.VirtletConfigFieldDiff{
+				Field:     f.fieldName(),
+				Value:     o.valueStr(),
+				BaseValue: f.valueStr(),
+			})
+		}
+	}
+	return r
+}
+
+// overrideFields replaces the values of the specified fields with
+// those from the other field set.
+func (fs *fieldSet) overrideFields(from *fieldSet, names []string) {
+	toOverride := make(map[string]bool)
+	for _, name := range names {
+		toOverride[name] = true
+	}
+	for n, f := range fs.fields {
+		if toOverride[f.fieldName()] {
+			f.override(from.fields[n])
+		}
+	}
+}
+
 func (fs *fieldSet) schemaProps() map[string]apiext.JSONSchemaProps {
 	r := make(map[string]apiext.JSONSchemaProps)
 	for _, f := range fs.fields {
@@ -0,0 +1,458 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jonboulle/clockwork"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+
+	virtlet_v1 "github.com/Mirantis/virtlet/pkg/api/virtlet.k8s/v1"
+)
+
+const (
+	watchRetryInterval     = 10 * time.Second
+	configFilePollInterval = 10 * time.Second
+
+	// CordonVMsAnnotation is the node annotation that makes
+	// Virtlet reject new VM pods on the node while leaving the
+	// running VMs untouched. The value of the annotation is the
+	// reason of the cordon.
+	CordonVMsAnnotation = "virtlet.cloud/cordon-vms"
+)
+
+// ConfigUpdateHandler is invoked with the updated Virtlet config
+// after some of hot-reloadable config fields have changed.
+type ConfigUpdateHandler func(cfg *virtlet_v1.VirtletConfig)
+
+// CordonHandler is invoked when CordonVMsAnnotation is added to the
+// node, changed or removed from it. reason is the value of the
+// annotation.
+type CordonHandler func(cordoned bool, reason string)
+
+// ConfigWatcher watches VirtletConfigMappings, the labels of the
+// node and the config file, applies the changes of hot-reloadable
+// config fields and reports the state of the mappings on the node in
+// their status. It also tracks CordonVMsAnnotation of the node.
+type ConfigWatcher struct {
+	sync.Mutex
+	nc            *NodeConfig
+	nodeName      string
+	nodeLabels    map[string]string
+	configFile    string
+	fileRead      bool
+	fileData      []byte
+	fileConfig    *virtlet_v1.VirtletConfig
+	current       *virtlet_v1.VirtletConfig
+	initial       *virtlet_v1.VirtletConfig
+	last          *virtlet_v1.VirtletConfig
+	handler       ConfigUpdateHandler
+	cordonHandler CordonHandler
+	cordonKnown   bool
+	cordoned      bool
+	cordonReason  string
+	clock         clockwork.Clock
+}
+
+// NewConfigWatcher creates a new ConfigWatcher for the specified node.
+// current is the config Virtlet is currently running with. If
+// nodeName is empty, the config mappings aren't watched and only the
+// config file is used.
+func NewConfigWatcher(nc *NodeConfig, nodeName string, current *virtlet_v1.VirtletConfig, handler ConfigUpdateHandler) *ConfigWatcher {
+	return &ConfigWatcher{
+		nc:       nc,
+		nodeName: nodeName,
+		current:  current.DeepCopy(),
+		handler:  handler,
+		clock:    clockwork.NewRealClock(),
+	}
+}
+
+// SetCordonHandler sets the handler to invoke when the cordon
+// annotation of the node changes. The handler is also invoked
+// upon the first Sync(). It must be called before Run.
+func (cw *ConfigWatcher) SetCordonHandler(handler CordonHandler) {
+	cw.Lock()
+	defer cw.Unlock()
+	cw.cordonHandler = handler
+}
+
+// SetConfigFile sets the path to the YAML config file to watch. The
+// settings from this file take precedence over the ones from the
+// config mappings. It must be called before Run.
+func (cw *ConfigWatcher) SetConfigFile(path string) {
+	cw.Lock()
+	defer cw.Unlock()
+	cw.configFile = path
+}
+
+// readConfigFile re-reads the config file and returns true if its
+// contents have changed since the last read. Invalid config files
+// are ignored, with the last valid one being used instead. It must
+// be called with the lock held.
+func (cw *ConfigWatcher) readConfigFile() bool {
+	if cw.configFile == "" {
+		return false
+	}
+	bs, err := ioutil.ReadFile(cw.configFile)
+	switch {
+	case os.IsNotExist(err):
+		bs = nil
+	case err != nil:
+		glog.Warningf("Error reading Virtlet config file %q: %v", cw.configFile, err)
+		return false
+	}
+	if cw.fileRead && bytes.Equal(bs, cw.fileData) {
+		return false
+	}
+	cw.fileRead = true
+	cw.fileData = bs
+	if bs == nil {
+		cw.fileConfig = nil
+		return true
+	}
+	cfg, err := parseConfigFile(bs)
+	if err != nil {
+		glog.Warningf("Ignoring invalid Virtlet config file %q: %v", cw.configFile, err)
+		return false
+	}
+	cw.fileConfig = cfg
+	return true
+}
+
+// configFileChanged returns true if the contents of the config file
+// have changed since the last Sync().
+func (cw *ConfigWatcher) configFileChanged() bool {
+	cw.Lock()
+	defer cw.Unlock()
+	return cw.readConfigFile()
+}
+
+// configFilePoll returns a channel that fires when it's time to check
+// the config file for changes, or nil if there's no config file.
+func (cw *ConfigWatcher) configFilePoll() <-chan time.Time {
+	if cw.configFile == "" {
+		return nil
+	}
+	return cw.clock.After(configFilePollInterval)
+}
+
+// updateCordon invokes the cordon handler if the cordon annotation
+// of the node has changed. It must be called with the lock held.
+func (cw *ConfigWatcher) updateCordon(node *v1.Node) {
+	reason, cordoned := node.Annotations[CordonVMsAnnotation]
+	if cw.cordonKnown && cordoned == cw.cordoned && reason == cw.cordonReason {
+		return
+	}
+	cw.cordonKnown = true
+	cw.cordoned = cordoned
+	cw.cordonReason = reason
+	if cw.cordonHandler != nil {
+		cw.cordonHandler(cordoned, reason)
+	}
+}
+
+// Sync recalculates the node config from the config mappings and
+// the config file, applies the changes of hot-reloadable fields and
+// updates the status of the mappings. The mappings with invalid
+// configs are ignored and the validation errors are reported in their
+// status. Sync can be called concurrently with Run, e.g. to force
+// config reload upon SIGHUP.
+func (cw *ConfigWatcher) Sync() error {
+	cw.Lock()
+	defer cw.Unlock()
+	cw.readConfigFile()
+	if cw.nodeName == "" {
+		if restartRequired := cw.apply(configForNode(nil, cw.fileConfig, "", nil)); len(restartRequired) != 0 {
+			glog.Warningf("Virtlet restart is required to apply the changes of the config fields: %s", strings.Join(restartRequired, ", "))
+		}
+		return nil
+	}
+
+	if err := cw.nc.setup(); err != nil {
+		return err
+	}
+
+	node, err := cw.nc.kubeClient.CoreV1().Nodes().Get(cw.nodeName, meta_v1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("can't get node info for node %q: %v", cw.nodeName, err)
+	}
+	cw.nodeLabels = node.Labels
+	cw.updateCordon(node)
+
+	mappingList, err := cw.nc.virtletClient.VirtletV1().VirtletConfigMappings(configMappingNamespace).List(meta_v1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list Virtlet config mappings: %v", err)
+	}
+
+	var validMappings []virtlet_v1.VirtletConfigMapping
+	errs := make(map[string]string)
+	for _, m := range mappingList.Items {
+		if !mappingMatches(m, cw.nodeName, node.Labels) {
+			continue
+		}
+		if err := ValidateConfig(m.Spec.Config); err != nil {
+			glog.Warningf("Ignoring invalid Virtlet config mapping %q: %v", m.Name, err)
+			errs[m.Name] = err.Error()
+		} else {
+			validMappings = append(validMappings, m)
+		}
+	}
+
+	cfg := configForNode(validMappings, cw.fileConfig, cw.nodeName, node.Labels)
+	restartRequired := cw.apply(cfg)
+
+	for _, m := range mappingList.Items {
+		matches := mappingMatches(m, cw.nodeName, node.Labels)
+		var status *virtlet_v1.VirtletConfigMappingNodeStatus
+		if matches {
+			status = &virtlet_v1.VirtletConfigMappingNodeStatus{
+				NodeName:           cw.nodeName,
+				ObservedGeneration: m.Generation,
+				Error:              errs[m.Name],
+				RestartRequired:    restartRequired,
+			}
+			if status.Error == "" {
+				status.ConfigDiff = mappingConfigDiff(validMappings, m.Name, cw.nodeName, node.Labels, cw.fileConfig, cfg)
+			}
+		}
+		cw.updateMappingStatus(m, status)
+	}
+
+	return nil
+}
+
+// apply applies the changes of hot-reloadable fields of the node
+// config and returns the sorted list of the changed fields that
+// require Virtlet restart. It must be called with the lock held.
+func (cw *ConfigWatcher) apply(cfg *virtlet_v1.VirtletConfig) []string {
+	if cw.initial == nil {
+		cw.initial = cfg
+		cw.last = cfg
+	}
+
+	var hotReload []string
+	for _, name := range configFieldSet(cw.last).changedFields(configFieldSet(cfg)) {
+		if hotReloadableFields[name] {
+			hotReload = append(hotReload, name)
+		}
+	}
+	var restartRequired []string
+	for _, name := range configFieldSet(cw.initial).changedFields(configFieldSet(cfg)) {
+		if !hotReloadableFields[name] {
+			restartRequired = append(restartRequired, name)
+		}
+	}
+	sort.Strings(restartRequired)
+	cw.last = cfg
+
+	if len(hotReload) != 0 {
+		updated := cw.current.DeepCopy()
+		configFieldSet(updated).overrideFields(configFieldSet(cfg), hotReload)
+		if len(configFieldSet(cw.current).changedFields(configFieldSet(updated))) != 0 {
+			glog.V(1).Infof("Applying updated Virtlet config fields: %v", hotReload)
+			cw.current = updated
+			if cw.handler != nil {
+				cw.handler(updated.DeepCopy())
+			}
+		}
+	}
+
+	return restartRequired
+}
+
+// mappingConfigDiff returns the changes of the effective node config
+// cfg that are caused by the mapping with the specified name.
+// fileConfig is the config from the config file, if any.
+func mappingConfigDiff(mappings []virtlet_v1.VirtletConfigMapping, name, nodeName string, nodeLabels map[string]string, fileConfig, cfg *virtlet_v1.VirtletConfig) []virtlet_v1.VirtletConfigFieldDiff {
+	var others []virtlet_v1.VirtletConfigMapping
+	for _, m := range mappings {
+		if m.Name != name {
+			others = append(others, m)
+		}
+	}
+	base := configForNode(others, fileConfig, nodeName, nodeLabels)
+	return configFieldSet(base).diff(configFieldSet(cfg))
+}
+
+// nodeLabelsChanged returns true if the labels of the node differ
+// from the ones used during the last Sync().
+func (cw *ConfigWatcher) nodeLabelsChanged(node *v1.Node) bool {
+	cw.Lock()
+	defer cw.Unlock()
+	if len(node.Labels) != len(cw.nodeLabels) {
+		return true
+	}
+	for k, v := range node.Labels {
+		if cur, found := cw.nodeLabels[k]; !found || cur != v {
+			return true
+		}
+	}
+	return false
+}
+
+// updateMappingStatus sets the node status of the mapping, removing
+// it if status is nil. The mapping is only updated if the status
+// actually changes.
+func (cw *ConfigWatcher) updateMappingStatus(m virtlet_v1.VirtletConfigMapping, status *virtlet_v1.VirtletConfigMappingNodeStatus) {
+	var nodes []virtlet_v1.VirtletConfigMappingNodeStatus
+	changed := status != nil
+	for _, s := range m.Status.Nodes {
+		if s.NodeName != cw.nodeName {
+			nodes = append(nodes, s)
+			continue
+		}
+		if status == nil {
+			changed = true
+			continue
+		}
+		s.LastUpdateTime = status.LastUpdateTime
+		changed = !reflect.DeepEqual(s, *status)
+	}
+	if !changed {
+		return
+	}
+	if status != nil {
+		status.LastUpdateTime = meta_v1.NewTime(cw.clock.Now())
+		nodes = append(nodes, *status)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].NodeName < nodes[j].NodeName
+	})
+	updated := m.DeepCopy()
+	updated.Status.Nodes = nodes
+	if _, err := cw.nc.virtletClient.VirtletV1().VirtletConfigMappings(m.Namespace).UpdateStatus(updated); err != nil {
+		glog.Warningf("Failed to update the status of Virtlet config mapping %q: %v", m.Name, err)
+	}
+}
+
+// Run watches the config mappings, the node labels and the config
+// file, invoking Sync() upon changes, until stopCh is closed.
+func (cw *ConfigWatcher) Run(stopCh <-chan struct{}) {
+	if cw.nodeName == "" {
+		cw.watchConfigFile(stopCh)
+		return
+	}
+	for {
+		if err := cw.Sync(); err != nil {
+			glog.Warningf("Error syncing Virtlet config: %v", err)
+		} else if cw.watch(stopCh) {
+			return
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-cw.clock.After(watchRetryInterval):
+		}
+	}
+}
+
+// watchConfigFile invokes Sync() upon the changes of the config file
+// until stopCh is closed. It's used when there's no node name and
+// thus no config mappings to watch.
+func (cw *ConfigWatcher) watchConfigFile(stopCh <-chan struct{}) {
+	cw.Sync()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-cw.configFilePoll():
+		}
+		if cw.configFileChanged() {
+			glog.V(1).Infof("Virtlet config file %q has changed", cw.configFile)
+			cw.Sync()
+		}
+	}
+}
+
+// watch handles the config mapping and node events and the changes
+// of the config file till either one of the watches is closed or
+// stopCh is closed, in which case it returns true. The node events
+// only cause Sync() if the node labels have changed, the changes of
+// the cordon annotation are handled right away.
+func (cw *ConfigWatcher) watch(stopCh <-chan struct{}) bool {
+	w, err := cw.nc.virtletClient.VirtletV1().VirtletConfigMappings(configMappingNamespace).Watch(meta_v1.ListOptions{})
+	if err != nil {
+		glog.Warningf("Failed to watch Virtlet config mappings: %v", err)
+		return false
+	}
+	defer w.Stop()
+	nw, err := cw.nc.kubeClient.CoreV1().Nodes().Watch(meta_v1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", cw.nodeName).String(),
+	})
+	if err != nil {
+		glog.Warningf("Failed to watch node %q: %v", cw.nodeName, err)
+		return false
+	}
+	defer nw.Stop()
+	filePoll := cw.configFilePoll()
+	for {
+		select {
+		case <-stopCh:
+			return true
+		case <-filePoll:
+			filePoll = cw.configFilePoll()
+			if !cw.configFileChanged() {
+				continue
+			}
+			glog.V(1).Infof("Virtlet config file %q has changed", cw.configFile)
+		case ev, ok := <-w.ResultChan():
+			if !ok {
+				return false
+			}
+			if ev.Type == watch.Error {
+				glog.Warningf("Error watching Virtlet config mappings: %v", ev.Object)
+				return false
+			}
+		case ev, ok := <-nw.ResultChan():
+			if !ok {
+				return false
+			}
+			if ev.Type == watch.Error {
+				glog.Warningf("Error watching node %q: %v", cw.nodeName, ev.Object)
+				return false
+			}
+			node, ok := ev.Object.(*v1.Node)
+			if !ok || node.Name != cw.nodeName {
+				continue
+			}
+			cw.Lock()
+			cw.updateCordon(node)
+			cw.Unlock()
+			if !cw.nodeLabelsChanged(node) {
+				continue
+			}
+			glog.V(1).Infof("The labels of node %q have changed, re-evaluating Virtlet config mappings", cw.nodeName)
+		}
+		if err := cw.Sync(); err != nil {
+			glog.Warningf("Error syncing Virtlet config: %v", err)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakekube "k8s.io/client-go/kubernetes/fake"
+
+	virtlet_v1 "github.com/Mirantis/virtlet/pkg/api/virtlet.k8s/v1"
+	"github.com/Mirantis/virtlet/pkg/client/clientset/versioned/fake"
+)
+
+func TestConfigWatcher(t *testing.T) {
+	pstr := func(s string) *string { return &s }
+	pint := func(i int) *int { return &i }
+	nc := NewNodeConfig(nil)
+	nc.kubeClient = fakekube.NewSimpleClientset(
+		&v1.Node{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:   "kube-node-1",
+				Labels: map[string]string{"label-a": "1"},
+			},
+		})
+	nc.virtletClient = fake.NewSimpleClientset(
+		&virtlet_v1.VirtletConfigMapping{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:       "mapping-1",
+				Namespace:  "kube-system",
+				Generation: 1,
+			},
+			Spec: virtlet_v1.VirtletConfigMappingSpec{
+				NodeName: "kube-node-1",
+				Config: &virtlet_v1.VirtletConfig{
+					LogLevel: pint(3),
+				},
+			},
+		},
+		&virtlet_v1.VirtletConfigMapping{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:       "mapping-2",
+				Namespace:  "kube-system",
+				Generation: 1,
+			},
+			Spec: virtlet_v1.VirtletConfigMappingSpec{
+				NodeSelector: map[string]string{"label-a": "1"},
+				Config: &virtlet_v1.VirtletConfig{
+					ImageDir: pstr("relative/path"),
+				},
+			},
+		},
+		&virtlet_v1.VirtletConfigMapping{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:       "mapping-3",
+				Namespace:  "kube-system",
+				Generation: 1,
+			},
+			Spec: virtlet_v1.VirtletConfigMappingSpec{
+				NodeName: "kube-node-2",
+				Config: &virtlet_v1.VirtletConfig{
+					LogLevel: pint(5),
+				},
+			},
+		})
+
+	var updates []*virtlet_v1.VirtletConfig
+	current := GetDefaultConfig()
+	current.LogLevel = pint(3)
+	cw := NewConfigWatcher(nc, "kube-node-1", current, func(cfg *virtlet_v1.VirtletConfig) {
+		updates = append(updates, cfg)
+	})
+	clock := clockwork.NewFakeClockAt(time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC))
+	cw.clock = clock
+
+	mappings := nc.virtletClient.VirtletV1().VirtletConfigMappings("kube-system")
+	verifyStatus := func(name string, expected []virtlet_v1.VirtletConfigMappingNodeStatus) {
+		m, err := mappings.Get(name, meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Get(): %v", err)
+		}
+		if !reflect.DeepEqual(m.Status.Nodes, expected) {
+			t.Errorf("bad status for %s: expected %#v, got %#v", name, expected, m.Status.Nodes)
+		}
+	}
+
+	if err := cw.Sync(); err != nil {
+		t.Fatalf("Sync(): %v", err)
+	}
+	if len(updates) != 0 {
+		t.Errorf("unexpected config updates after the initial sync: %#v", updates)
+	}
+	firstSyncTime := meta_v1.NewTime(clock.Now())
+	verifyStatus("mapping-1", []virtlet_v1.VirtletConfigMappingNodeStatus{
+		{
+			NodeName:           "kube-node-1",
+			ObservedGeneration: 1,
+			LastUpdateTime:     firstSyncTime,
+			ConfigDiff: []virtlet_v1.VirtletConfigFieldDiff{
+				{Field: "logLevel", Value: "3", BaseValue: "1"},
+			},
+		},
+	})
+	verifyStatus("mapping-2", []virtlet_v1.VirtletConfigMappingNodeStatus{
+		{
+			NodeName:           "kube-node-1",
+			ObservedGeneration: 1,
+			LastUpdateTime:     firstSyncTime,
+			Error:              `bad value for field imageDir: "relative/path" doesn't match "^/"`,
+		},
+	})
+	verifyStatus("mapping-3", nil)
+
+	m, err := mappings.Get("mapping-1", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get(): %v", err)
+	}
+	m.Generation = 2
+	m.Spec.Config.LogLevel = pint(4)
+	m.Spec.Config.RawDevices = pstr("sd*")
+	m.Spec.Config.DatabasePath = pstr("/var/lib/virtlet/new.db")
+	if _, err := mappings.Update(m); err != nil {
+		t.Fatalf("Update(): %v", err)
+	}
+
+	clock.Advance(time.Minute)
+	if err := cw.Sync(); err != nil {
+		t.Fatalf("Sync(): %v", err)
+	}
+	if len(updates) != 1 {
+		t.Fatalf("expected exactly one config update, got %d", len(updates))
+	}
+	if *updates[0].LogLevel != 4 || *updates[0].RawDevices != "sd*" {
+		t.Errorf("hot-reloadable fields weren't updated: %#v", updates[0])
+	}
+	if *updates[0].DatabasePath != defaultDatabasePath {
+		t.Errorf("databasePath must not be hot-reloaded, got %q", *updates[0].DatabasePath)
+	}
+	secondSyncTime := meta_v1.NewTime(clock.Now())
+	verifyStatus("mapping-1", []virtlet_v1.VirtletConfigMappingNodeStatus{
+		{
+			NodeName:           "kube-node-1",
+			ObservedGeneration: 2,
+			LastUpdateTime:     secondSyncTime,
+			RestartRequired:    []string{"databasePath"},
+			ConfigDiff: []virtlet_v1.VirtletConfigFieldDiff{
+				{Field: "databasePath", Value: "/var/lib/virtlet/new.db", BaseValue: defaultDatabasePath},
+				{Field: "rawDevices", Value: "sd*", BaseValue: defaultRawDevices},
+				{Field: "logLevel", Value: "4", BaseValue: "1"},
+			},
+		},
+	})
+
+	// unchanged mappings don't cause config or status updates
+	clock.Advance(time.Minute)
+	if err := cw.Sync(); err != nil {
+		t.Fatalf("Sync(): %v", err)
+	}
+	if len(updates) != 1 {
+		t.Errorf("unexpected config update: %#v", updates[1:])
+	}
+	verifyStatus("mapping-2", []virtlet_v1.VirtletConfigMappingNodeStatus{
+		{
+			NodeName:           "kube-node-1",
+			ObservedGeneration: 1,
+			LastUpdateTime:     secondSyncTime,
+			Error:              `bad value for field imageDir: "relative/path" doesn't match "^/"`,
+			RestartRequired:    []string{"databasePath"},
+		},
+	})
+}
+
+func TestConfigWatcherNodeLabels(t *testing.T) {
+	pint := func(i int) *int { return &i }
+	pbool := func(b bool) *bool { return &b }
+	node := &v1.Node{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:   "kube-node-1",
+			Labels: map[string]string{"label-a": "3"},
+		},
+	}
+	nc := NewNodeConfig(nil)
+	nc.kubeClient = fakekube.NewSimpleClientset(node)
+	nc.virtletClient = fake.NewSimpleClientset(
+		&virtlet_v1.VirtletConfigMapping{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:       "mapping-1",
+				Namespace:  "kube-system",
+				Generation: 1,
+			},
+			Spec: virtlet_v1.VirtletConfigMappingSpec{
+				NodeSelectorExpressions: []meta_v1.LabelSelectorRequirement{
+					{
+						Key:      "label-a",
+						Operator: meta_v1.LabelSelectorOpIn,
+						Values:   []string{"1", "2"},
+					},
+				},
+				Config: &virtlet_v1.VirtletConfig{
+					LogLevel:   pint(5),
+					DisableKVM: pbool(true),
+				},
+			},
+		})
+
+	var updates []*virtlet_v1.VirtletConfig
+	cw := NewConfigWatcher(nc, "kube-node-1", GetDefaultConfig(), func(cfg *virtlet_v1.VirtletConfig) {
+		updates = append(updates, cfg)
+	})
+	clock := clockwork.NewFakeClockAt(time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC))
+	cw.clock = clock
+
+	mappings := nc.virtletClient.VirtletV1().VirtletConfigMappings("kube-system")
+	verifyStatus := func(expected []virtlet_v1.VirtletConfigMappingNodeStatus) {
+		m, err := mappings.Get("mapping-1", meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Get(): %v", err)
+		}
+		if !reflect.DeepEqual(m.Status.Nodes, expected) {
+			t.Errorf("bad mapping status: expected %#v, got %#v", expected, m.Status.Nodes)
+		}
+	}
+
+	if err := cw.Sync(); err != nil {
+		t.Fatalf("Sync(): %v", err)
+	}
+	if len(updates) != 0 {
+		t.Errorf("unexpected config updates after the initial sync: %#v", updates)
+	}
+	// the mapping doesn't apply to the node yet
+	verifyStatus(nil)
+
+	if cw.nodeLabelsChanged(node.DeepCopy()) {
+		t.Errorf("nodeLabelsChanged() returned true for unchanged labels")
+	}
+	node.Labels["label-a"] = "1"
+	if !cw.nodeLabelsChanged(node) {
+		t.Errorf("nodeLabelsChanged() returned false for changed labels")
+	}
+	if _, err := nc.kubeClient.CoreV1().Nodes().Update(node); err != nil {
+		t.Fatalf("Update(): %v", err)
+	}
+
+	clock.Advance(time.Minute)
+	if err := cw.Sync(); err != nil {
+		t.Fatalf("Sync(): %v", err)
+	}
+	if len(updates) != 1 || *updates[0].LogLevel != 5 {
+		t.Errorf("expected a config update with logLevel 5, got %#v", updates)
+	}
+	verifyStatus([]virtlet_v1.VirtletConfigMappingNodeStatus{
+		{
+			NodeName:           "kube-node-1",
+			ObservedGeneration: 1,
+			LastUpdateTime:     meta_v1.NewTime(clock.Now()),
+			RestartRequired:    []string{"disableKVM"},
+			ConfigDiff: []virtlet_v1.VirtletConfigFieldDiff{
+				{Field: "disableKVM", Value: "true", BaseValue: "false"},
+				{Field: "logLevel", Value: "5", BaseValue: "1"},
+			},
+		},
+	})
+
+	node.Labels["label-a"] = "3"
+	if _, err := nc.kubeClient.CoreV1().Nodes().Update(node); err != nil {
+		t.Fatalf("Update(): %v", err)
+	}
+
+	clock.Advance(time.Minute)
+	if err := cw.Sync(); err != nil {
+		t.Fatalf("Sync(): %v", err)
+	}
+	if len(updates) != 2 || *updates[1].LogLevel != 1 {
+		t.Errorf("expected a config update with logLevel 1, got %#v", updates)
+	}
+	// the mapping doesn't apply to the node anymore
+	verifyStatus(nil)
+}
+
+func TestConfigWatcherCordon(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        "kube-node-1",
+			Annotations: map[string]string{CordonVMsAnnotation: "libvirt upgrade"},
+		},
+	}
+	nc := NewNodeConfig(nil)
+	nc.kubeClient = fakekube.NewSimpleClientset(node)
+	nc.virtletClient = fake.NewSimpleClientset()
+
+	type cordonState struct {
+		cordoned bool
+		reason   string
+	}
+	var states []cordonState
+	cw := NewConfigWatcher(nc, "kube-node-1", GetDefaultConfig(), nil)
+	cw.SetCordonHandler(func(cordoned bool, reason string) {
+		states = append(states, cordonState{cordoned, reason})
+	})
+
+	if err := cw.Sync(); err != nil {
+		t.Fatalf("Sync(): %v", err)
+	}
+	// the handler is only invoked when the annotation changes
+	if err := cw.Sync(); err != nil {
+		t.Fatalf("Sync(): %v", err)
+	}
+	delete(node.Annotations, CordonVMsAnnotation)
+	cw.Lock()
+	cw.updateCordon(node)
+	cw.Unlock()
+
+	expectedStates := []cordonState{
+		{true, "libvirt upgrade"},
+		{false, ""},
+	}
+	if !reflect.DeepEqual(states, expectedStates) {
+		t.Errorf("bad cordon states: %#v instead of %#v", states, expectedStates)
+	}
+}
+
+func TestConfigWatcherConfigFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "config-file")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	configFile := filepath.Join(tmpDir, "config.yaml")
+	writeConfig := func(text string) {
+		if err := ioutil.WriteFile(configFile, []byte(text), 0644); err != nil {
+			t.Fatalf("WriteFile(): %v", err)
+		}
+	}
+
+	var updates []*virtlet_v1.VirtletConfig
+	// no node name, so only the config file is watched
+	cw := NewConfigWatcher(NewNodeConfig(nil), "", GetDefaultConfig(), func(cfg *virtlet_v1.VirtletConfig) {
+		updates = append(updates, cfg)
+	})
+	cw.SetConfigFile(configFile)
+	if err := cw.Sync(); err != nil {
+		t.Fatalf("Sync(): %v", err)
+	}
+	if cw.configFileChanged() {
+		t.Errorf("configFileChanged() returned true for a missing file")
+	}
+
+	writeConfig("logLevel: 4\nimagePullRateLimit: 10\ndatabasePath: /var/lib/virtlet/new.db\n")
+	if !cw.configFileChanged() {
+		t.Errorf("configFileChanged() returned false after the file was written")
+	}
+	if err := cw.Sync(); err != nil {
+		t.Fatalf("Sync(): %v", err)
+	}
+	if len(updates) != 1 {
+		t.Fatalf("expected exactly one config update, got %d", len(updates))
+	}
+	if *updates[0].LogLevel != 4 || *updates[0].ImagePullRateLimit != 10 {
+		t.Errorf("hot-reloadable fields weren't updated: %#v", updates[0])
+	}
+	if *updates[0].DatabasePath != defaultDatabasePath {
+		t.Errorf("databasePath must not be hot-reloaded, got %q", *updates[0].DatabasePath)
+	}
+
+	// invalid config files are ignored
+	writeConfig("logLevel: -1\n")
+	if cw.configFileChanged() {
+		t.Errorf("configFileChanged() returned true for an invalid config file")
+	}
+	if err := cw.Sync(); err != nil {
+		t.Fatalf("Sync(): %v", err)
+	}
+	if len(updates) != 1 {
+		t.Errorf("unexpected config update: %#v", updates[1:])
+	}
+
+	// removing the file reverts the settings
+	if err := os.Remove(configFile); err != nil {
+		t.Fatalf("Remove(): %v", err)
+	}
+	if err := cw.Sync(); err != nil {
+		t.Fatalf("Sync(): %v", err)
+	}
+	if len(updates) != 2 || *updates[1].LogLevel != 1 || *updates[1].ImagePullRateLimit != 0 {
+		t.Errorf("expected a config update reverting the settings, got %#v", updates)
+	}
+}
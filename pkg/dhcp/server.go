@@ -25,7 +25,10 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	cnicurrent "github.com/containernetworking/cni/pkg/types/current"
 	"github.com/golang/glog"
@@ -38,6 +41,22 @@ const (
 	serverPort = 67
 	// option 121 is for static routes as defined in rfc3442
 	classlessRouteOption = 121
+	// options 66 and 67 are TFTP server name and boot file name
+	// as defined in rfc2132
+	tftpServerNameOption = 66
+	bootFileNameOption   = 67
+	// option 77 is user class as defined in rfc3004
+	userClassOption = 77
+	ipxeUserClass   = "iPXE"
+
+	// LeaseStateOffered denotes a lease that was offered to the
+	// client but not yet requested by it.
+	LeaseStateOffered = "offered"
+	// LeaseStateAcked denotes a lease that was acknowledged.
+	LeaseStateAcked = "acked"
+	// LeaseStateFailed denotes a lease for which the server
+	// failed to construct a response.
+	LeaseStateFailed = "failed"
 )
 
 var (
@@ -46,13 +65,83 @@ var (
 
 // Server implements a DHCP server that runs in the container network namespace.
 type Server struct {
-	config   *network.ContainerSideNetwork
-	listener *dhcp4.Conn
+	config      *network.ContainerSideNetwork
+	bootOptions *network.BootOptions
+	listener    *dhcp4.Conn
+	mu          sync.Mutex
+	leases      map[string]*Lease
+}
+
+// Lease describes the state of DHCP exchange with a client.
+type Lease struct {
+	// HardwareAddr is the MAC address of the client.
+	HardwareAddr string `json:"mac"`
+	// IP is the address offered to the client, if any.
+	IP string `json:"ip,omitempty"`
+	// State is the state of the lease, LeaseStateOffered,
+	// LeaseStateAcked or LeaseStateFailed.
+	State string `json:"state"`
+	// Discovers is the number of DHCPDISCOVER packets received
+	// from the client.
+	Discovers int `json:"discovers"`
+	// Requests is the number of DHCPREQUEST packets received
+	// from the client.
+	Requests int `json:"requests"`
+	// Updated is the time of the last packet received from the
+	// client.
+	Updated time.Time `json:"updated"`
+	// Error contains the error that happened when constructing
+	// the last response for the client, if any.
+	Error string `json:"error,omitempty"`
 }
 
 // NewServer returns an initialized instance of Server.
-func NewServer(config *network.ContainerSideNetwork) *Server {
-	return &Server{config: config}
+// bootOptions may be nil if network boot isn't used.
+func NewServer(config *network.ContainerSideNetwork, bootOptions *network.BootOptions) *Server {
+	return &Server{config: config, bootOptions: bootOptions, leases: make(map[string]*Lease)}
+}
+
+// Leases returns the states of the DHCP exchanges with the clients
+// that contacted the server, sorted by the MAC addresses of the
+// clients.
+func (s *Server) Leases() []Lease {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := []Lease{}
+	for _, l := range s.leases {
+		r = append(r, *l)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].HardwareAddr < r[j].HardwareAddr })
+	return r
+}
+
+func (s *Server) updateLease(pkt, resp *dhcp4.Packet, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mac := pkt.HardwareAddr.String()
+	l := s.leases[mac]
+	if l == nil {
+		l = &Lease{HardwareAddr: mac}
+		s.leases[mac] = l
+	}
+	l.Updated = time.Now()
+	if pkt.Type == dhcp4.MsgDiscover {
+		l.Discovers++
+	} else {
+		l.Requests++
+	}
+	if err != nil {
+		l.State = LeaseStateFailed
+		l.Error = err.Error()
+		return
+	}
+	l.Error = ""
+	l.IP = resp.YourAddr.String()
+	if resp.Type == dhcp4.MsgAck {
+		l.State = LeaseStateAcked
+	} else {
+		l.State = LeaseStateOffered
+	}
 }
 
 // SetupListener sets up a DHCP4 listener that listens on the default DHCP
@@ -95,12 +184,14 @@ func (s *Server) Serve() error {
 		switch pkt.Type {
 		case dhcp4.MsgDiscover:
 			resp, err = s.offerDHCP(pkt, serverIP)
+			s.updateLease(pkt, resp, err)
 			if err != nil {
 				glog.Warningf("Failed to construct DHCP offer for %s: %s", pkt.HardwareAddr.String(), err)
 				continue
 			}
 		case dhcp4.MsgRequest:
 			resp, err = s.ackDHCP(pkt, serverIP)
+			s.updateLease(pkt, resp, err)
 			if err != nil {
 				glog.Warningf("Failed to construct DHCP ACK for %s: %s", pkt.HardwareAddr.String(), err)
 				continue
@@ -258,9 +349,36 @@ func (s *Server) prepareResponse(pkt *dhcp4.Packet, serverIP net.IP, mt dhcp4.Me
 		}
 	}
 
+	s.setBootOptions(p, pkt)
+
 	return p, nil
 }
 
+func (s *Server) setBootOptions(p, pkt *dhcp4.Packet) {
+	if s.bootOptions == nil {
+		return
+	}
+
+	if s.bootOptions.NextServer != "" {
+		if ip := net.ParseIP(s.bootOptions.NextServer).To4(); ip != nil {
+			p.ServerAddr = ip
+		}
+		p.Options[tftpServerNameOption] = []byte(s.bootOptions.NextServer)
+	}
+
+	// iPXE identifies itself using the user class option. As the
+	// VMs use iPXE option ROMs, they can load the script directly,
+	// but the boot file is still given to other PXE clients.
+	filename := s.bootOptions.BootFilename
+	if s.bootOptions.IPXEScriptURL != "" && string(pkt.Options[userClassOption]) == ipxeUserClass {
+		filename = s.bootOptions.IPXEScriptURL
+	}
+	if filename != "" {
+		p.BootFilename = filename
+		p.Options[bootFileNameOption] = []byte(filename)
+	}
+}
+
 func (s *Server) offerDHCP(pkt *dhcp4.Packet, serverIP net.IP) (*dhcp4.Packet, error) {
 	return s.prepareResponse(pkt, serverIP, dhcp4.MsgOffer)
 }
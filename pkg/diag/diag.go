@@ -66,6 +66,7 @@ import (
 	"sync"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/golang/glog"
 )
@@ -82,6 +83,10 @@ type Result struct {
 	Ext string `json:"ext,omitempty"`
 	// Data is the content returned by the Source.
 	Data string `json:"data,omitempty"`
+	// BinaryData is the binary content returned by the Source.
+	// It's used instead of Data for the contents that are not
+	// valid UTF-8 text, e.g. images.
+	BinaryData []byte `json:"binaryData,omitempty"`
 	// IsDir specifies whether this diagnostics result
 	// needs to be unpacked to a directory.
 	IsDir bool `json:"isdir"`
@@ -125,7 +130,11 @@ func (dr Result) Unpack(parentDir string) error {
 		return nil
 	default:
 		targetPath := filepath.Join(parentDir, dr.FileName())
-		if err := ioutil.WriteFile(targetPath, []byte(dr.Data), 0777); err != nil {
+		data := []byte(dr.Data)
+		if dr.BinaryData != nil {
+			data = dr.BinaryData
+		}
+		if err := ioutil.WriteFile(targetPath, data, 0777); err != nil {
 			return fmt.Errorf("error writing %q: %v", targetPath, err)
 		}
 		return nil
@@ -446,7 +455,11 @@ func (s *LogDirSource) DiagnosticInfo() (Result, error) {
 		if err != nil {
 			return Result{}, fmt.Errorf("error reading %q: %v", fullPath, err)
 		}
-		cur.Data = string(data)
+		if utf8.Valid(data) {
+			cur.Data = string(data)
+		} else {
+			cur.BinaryData = data
+		}
 		r.Children[cur.Name] = cur
 	}
 	return r, nil
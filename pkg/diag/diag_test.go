@@ -48,6 +48,7 @@ func setupLogDir(t *testing.T, baseDir string) string {
 	for name, contents := range map[string]string{
 		"log1.txt":      "log1 contents",
 		"log2.txt":      "log2 contents",
+		"image.bin":     "\xff\x00\xfe",
 		".placeholder":  "ignored file",
 		".place.holder": "another ignored file",
 	} {
@@ -129,6 +130,11 @@ func TestDiagServer(t *testing.T) {
 						Ext:  "txt",
 						Data: "log2 contents",
 					},
+					"image": {
+						Name:       "image",
+						Ext:        "bin",
+						BinaryData: []byte("\xff\x00\xfe"),
+					},
 				},
 			},
 			"fail": {
@@ -164,8 +170,9 @@ func TestDiagServer(t *testing.T) {
 		"bar.log":         "this is bar",
 		"simple_text.txt": "baz",
 		"logdir": map[string]interface{}{
-			"log1.txt": "log1 contents",
-			"log2.txt": "log2 contents",
+			"log1.txt":  "log1 contents",
+			"log2.txt":  "log2 contents",
+			"image.bin": "\xff\x00\xfe",
 		},
 	}
 	files, err := testutils.DirToMap(diagDir)
@@ -242,6 +242,84 @@ int g_wrapper_put(g_wrapper* w, int n, g_file* files)
 	return 0;
 }
 
+int g_wrapper_grow_root(g_wrapper* w)
+{
+	int last, partnum, r = -1;
+	char *parttype = 0, *fstype = 0;
+	const char *part;
+
+	if (g_wrapper_get_partitions(w) < 0)
+		return -1;
+
+	// the root filesystem is expected to reside on the last partition
+	for (last = 0; w->parts[last+1]; last++);
+	part = w->parts[last];
+
+	partnum = guestfs_part_to_partnum(w->g, part);
+	if (partnum < 0) {
+		update_error(w, "guestfs_part_to_partnum()", 1);
+		return -1;
+	}
+
+	parttype = guestfs_part_get_parttype(w->g, w->devs[0]);
+	if (!parttype) {
+		update_error(w, "guestfs_part_get_parttype()", 1);
+		return -1;
+	}
+
+	if (!strcmp(parttype, "gpt")) {
+		// move the backup GPT header to the end of the enlarged disk
+		if (guestfs_part_expand_gpt(w->g, w->devs[0]) < 0) {
+			update_error(w, "guestfs_part_expand_gpt()", 1);
+			goto out;
+		}
+		// the last 34 sectors are occupied by the backup GPT
+		if (guestfs_part_resize(w->g, w->devs[0], partnum, -34) < 0) {
+			update_error(w, "guestfs_part_resize()", 1);
+			goto out;
+		}
+	} else if (guestfs_part_resize(w->g, w->devs[0], partnum, -1) < 0) {
+		update_error(w, "guestfs_part_resize()", 1);
+		goto out;
+	}
+
+	fstype = guestfs_vfs_type(w->g, part);
+	if (!fstype) {
+		update_error(w, "guestfs_vfs_type()", 1);
+		goto out;
+	}
+
+	if (!strncmp(fstype, "ext", 3)) {
+		if (guestfs_e2fsck_f(w->g, part) < 0) {
+			update_error(w, "guestfs_e2fsck_f()", 1);
+			goto out;
+		}
+		if (guestfs_resize2fs(w->g, part) < 0) {
+			update_error(w, "guestfs_resize2fs()", 1);
+			goto out;
+		}
+	} else if (!strcmp(fstype, "xfs")) {
+		// xfs can only be grown while it's mounted
+		if (guestfs_mount(w->g, part, "/")) {
+			update_error(w, "guestfs_mount()", 1);
+			goto out;
+		}
+		if (guestfs_xfs_growfs(w->g, "/", -1) < 0) {
+			update_error(w, "guestfs_xfs_growfs()", 1);
+			goto out;
+		}
+	} else {
+		update_error(w, "unsupported root filesystem type", 0);
+		goto out;
+	}
+
+	r = 0;
+out:
+	free(parttype);
+	free(fstype);
+	return r;
+}
+
 char** g_wrapper_ls(g_wrapper* w, const char* dir)
 {
 	if (w->files)
@@ -364,6 +442,15 @@ func Put(imagePath string, files map[string][]byte) error {
 	})
 }
 
+// GrowRootFS grows the last partition of the specified image file so
+// it occupies all of the disk space available and then resizes the
+// filesystem on it. ext2/3/4 and xfs filesystems are supported.
+func GrowRootFS(imagePath string) error {
+	return callWithGWrapper(imagePath, func(w *C.g_wrapper) int {
+		return int(C.g_wrapper_grow_root(w))
+	})
+}
+
 // ListFiles returns the list of files in the specified directory.
 func ListFiles(imagePath, dir string) ([]string, error) {
 	var r []string
@@ -32,6 +32,11 @@ func Put(image string, files map[string][]byte) error {
 	return errors.New("not implemented")
 }
 
+// GrowRootFS is a stub for non-linux systems
+func GrowRootFS(imagePath string) error {
+	return errors.New("not implemented")
+}
+
 // ListFiles is a stub for non-linux systems
 func ListFiles(imagePath, dir string) ([]string, error) {
 	return nil, errors.New("not implemented")
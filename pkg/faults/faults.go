@@ -0,0 +1,236 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package faults implements fault injection that can be used to test
+// Virtlet's resilience deterministically. The faults are configured
+// using VIRTLET_FAULTS environment variable and are never injected
+// unless it's set.
+//
+// The value of VIRTLET_FAULTS is a comma-separated list of rules in
+// the form point=action[:arg][@n], where point is the name of a fault
+// point, action is one of fail, drop or delay (the latter requires a
+// duration argument, e.g. delay:5s) and n is the 1-based number of
+// the hit of the fault point the rule applies to. Rules without @n
+// apply to every hit. For example,
+//
+//	VIRTLET_FAULTS=libvirt=fail@3,image.pull=delay:10s,tapmanager.recv=drop@2
+//
+// makes the 3rd libvirt call fail, delays every image pull by 10
+// seconds and drops the 2nd request received by the tapmanager.
+package faults
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// FaultsEnv is the name of the environment variable that
+	// holds the fault injection rules.
+	FaultsEnv = "VIRTLET_FAULTS"
+
+	// PointLibvirt denotes libvirt connection calls.
+	PointLibvirt = "libvirt"
+	// PointImagePull denotes image pulls.
+	PointImagePull = "image.pull"
+	// PointTapManagerRecv denotes the requests received by
+	// the tapmanager.
+	PointTapManagerRecv = "tapmanager.recv"
+
+	actionFail  = "fail"
+	actionDrop  = "drop"
+	actionDelay = "delay"
+)
+
+var knownPoints = map[string]bool{
+	PointLibvirt:        true,
+	PointImagePull:      true,
+	PointTapManagerRecv: true,
+}
+
+// Error denotes an injected failure.
+type Error struct {
+	// Point is the name of the fault point
+	Point string
+	// Action is the injected action (fail or drop)
+	Action string
+	// Hit is the number of the hit of the fault point
+	Hit int
+}
+
+// Error implements Error method of the error interface.
+func (e *Error) Error() string {
+	return fmt.Sprintf("injected fault at %s: %s (hit %d)", e.Point, e.Action, e.Hit)
+}
+
+// IsInjected returns true if err is an injected failure.
+func IsInjected(err error) bool {
+	_, ok := err.(*Error)
+	return ok
+}
+
+type rule struct {
+	action string
+	delay  time.Duration
+	hit    int
+}
+
+func parseRule(s string) (string, rule, error) {
+	var r rule
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", r, fmt.Errorf("bad fault rule %q", s)
+	}
+	point, actionStr := parts[0], parts[1]
+	if !knownPoints[point] {
+		return "", r, fmt.Errorf("unknown fault point %q", point)
+	}
+
+	if n := strings.LastIndex(actionStr, "@"); n >= 0 {
+		hit, err := strconv.Atoi(actionStr[n+1:])
+		if err != nil || hit <= 0 {
+			return "", r, fmt.Errorf("bad hit number in fault rule %q", s)
+		}
+		r.hit = hit
+		actionStr = actionStr[:n]
+	}
+
+	parts = strings.SplitN(actionStr, ":", 2)
+	r.action = parts[0]
+	switch r.action {
+	case actionFail, actionDrop:
+		if len(parts) > 1 {
+			return "", r, fmt.Errorf("unexpected argument in fault rule %q", s)
+		}
+	case actionDelay:
+		if len(parts) < 2 {
+			return "", r, fmt.Errorf("delay duration not specified in fault rule %q", s)
+		}
+		d, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return "", r, fmt.Errorf("bad delay duration in fault rule %q: %v", s, err)
+		}
+		r.delay = d
+	default:
+		return "", r, fmt.Errorf("unknown action in fault rule %q", s)
+	}
+
+	return point, r, nil
+}
+
+// Injector injects faults at the fault points according to its rules.
+type Injector struct {
+	sync.Mutex
+	rules map[string][]rule
+	hits  map[string]int
+	sleep func(time.Duration)
+}
+
+// NewInjector creates an Injector using the specified rules.
+// See the package description for the format of the rules.
+func NewInjector(spec string) (*Injector, error) {
+	inj := &Injector{
+		rules: make(map[string][]rule),
+		hits:  make(map[string]int),
+		sleep: time.Sleep,
+	}
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		point, r, err := parseRule(s)
+		if err != nil {
+			return nil, err
+		}
+		inj.rules[point] = append(inj.rules[point], r)
+	}
+	return inj, nil
+}
+
+// Inject registers a hit of the specified fault point. It applies
+// the delays configured for the hit and returns an *Error if the
+// hit must fail, or, for the points that handle messages, if the
+// message must be dropped.
+func (inj *Injector) Inject(point string) error {
+	inj.Lock()
+	inj.hits[point]++
+	hit := inj.hits[point]
+	var delay time.Duration
+	var err error
+	for _, r := range inj.rules[point] {
+		if r.hit != 0 && r.hit != hit {
+			continue
+		}
+		switch r.action {
+		case actionDelay:
+			delay += r.delay
+		default:
+			err = &Error{Point: point, Action: r.action, Hit: hit}
+		}
+	}
+	inj.Unlock()
+
+	if delay != 0 {
+		glog.Warningf("Injecting delay %v at %s (hit %d)", delay, point, hit)
+		inj.sleep(delay)
+	}
+	if err != nil {
+		glog.Warningf("Injecting fault: %v", err)
+	}
+	return err
+}
+
+var defaultInjector *Injector
+
+// Setup sets up the fault injection using the specified rules.
+// An empty spec disables the fault injection. Setup must be called
+// before any fault points are hit.
+func Setup(spec string) error {
+	if spec == "" {
+		defaultInjector = nil
+		return nil
+	}
+	inj, err := NewInjector(spec)
+	if err != nil {
+		return err
+	}
+	glog.Warningf("Fault injection enabled: %s", spec)
+	defaultInjector = inj
+	return nil
+}
+
+// SetupFromEnv sets up the fault injection using the rules
+// from VIRTLET_FAULTS environment variable.
+func SetupFromEnv() error {
+	return Setup(os.Getenv(FaultsEnv))
+}
+
+// Inject registers a hit of the specified fault point using the
+// rules passed to Setup. It's a no-op if the fault injection isn't
+// enabled. See Injector.Inject for more info.
+func Inject(point string) error {
+	if defaultInjector == nil {
+		return nil
+	}
+	return defaultInjector.Inject(point)
+}
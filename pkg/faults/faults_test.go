@@ -0,0 +1,96 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package faults
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInjector(t *testing.T) {
+	inj, err := NewInjector("libvirt=fail@3, image.pull=delay:5s,image.pull=fail@2,tapmanager.recv=drop")
+	if err != nil {
+		t.Fatalf("NewInjector(): %v", err)
+	}
+	var delays []time.Duration
+	inj.sleep = func(d time.Duration) {
+		delays = append(delays, d)
+	}
+
+	for i := 1; i <= 4; i++ {
+		err := inj.Inject(PointLibvirt)
+		switch {
+		case i == 3 && !IsInjected(err):
+			t.Errorf("libvirt call %d: expected an injected failure, got %v", i, err)
+		case i != 3 && err != nil:
+			t.Errorf("libvirt call %d: unexpected error: %v", i, err)
+		}
+	}
+
+	if err := inj.Inject(PointImagePull); err != nil {
+		t.Errorf("image pull 1: unexpected error: %v", err)
+	}
+	err = inj.Inject(PointImagePull)
+	expectedErr := &Error{Point: PointImagePull, Action: "fail", Hit: 2}
+	if !reflect.DeepEqual(err, expectedErr) {
+		t.Errorf("image pull 2: expected error %#v, got %#v", expectedErr, err)
+	}
+	if !reflect.DeepEqual(delays, []time.Duration{5 * time.Second, 5 * time.Second}) {
+		t.Errorf("bad image pull delays: %v", delays)
+	}
+
+	for i := 1; i <= 2; i++ {
+		if err := inj.Inject(PointTapManagerRecv); !IsInjected(err) {
+			t.Errorf("tapmanager message %d: expected the message to be dropped, got %v", i, err)
+		}
+	}
+}
+
+func TestBadRules(t *testing.T) {
+	for _, spec := range []string{
+		"libvirt",
+		"libvirt=",
+		"nosuchpoint=fail",
+		"libvirt=explode",
+		"libvirt=fail:1s",
+		"libvirt=fail@0",
+		"libvirt=fail@x",
+		"image.pull=delay",
+		"image.pull=delay:forever",
+	} {
+		if _, err := NewInjector(spec); err == nil {
+			t.Errorf("NewInjector(%q) didn't fail", spec)
+		}
+	}
+}
+
+func TestDisabledInjection(t *testing.T) {
+	if err := Setup(""); err != nil {
+		t.Fatalf("Setup(): %v", err)
+	}
+	if err := Inject(PointLibvirt); err != nil {
+		t.Errorf("unexpected error with fault injection disabled: %v", err)
+	}
+	if err := Setup("libvirt=fail@1"); err != nil {
+		t.Fatalf("Setup(): %v", err)
+	}
+	defer Setup("")
+	if err := Inject(PointLibvirt); !IsInjected(err) {
+		t.Errorf("expected an injected failure, got %v", err)
+	}
+}
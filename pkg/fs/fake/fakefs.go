@@ -48,11 +48,13 @@ var _ fs.DelimitedReader = &fakeDelimitedReader{}
 
 // ReadString implements ReadString method of utils.FileReader interface
 func (fr *fakeDelimitedReader) ReadString(delim byte) (line string, err error) {
-	lines := strings.SplitN(fr.fileData, string(delim), 1)
+	lines := strings.SplitN(fr.fileData, string(delim), 2)
 	line = lines[0]
 	if len(lines) > 1 {
+		line += string(delim)
 		fr.fileData = lines[1]
 	} else {
+		fr.fileData = ""
 		err = io.EOF
 	}
 	fr.rec.Rec("ReadString", line)
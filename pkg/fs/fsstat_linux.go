@@ -31,3 +31,13 @@ func GetFsStatsForPath(path string) (uint64, uint64, error) {
 	}
 	return (fs.Blocks - fs.Bfree) * uint64(fs.Bsize), fs.Files - fs.Ffree, nil
 }
+
+// GetFsSpaceForPath returns the number of used bytes and the total
+// size in bytes of the filesystem that contains the provided path.
+func GetFsSpaceForPath(path string) (uint64, uint64, error) {
+	fs := syscall.Statfs_t{}
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, 0, err
+	}
+	return (fs.Blocks - fs.Bfree) * uint64(fs.Bsize), fs.Blocks * uint64(fs.Bsize), nil
+}
@@ -26,3 +26,8 @@ import (
 func GetFsStatsForPath(path string) (uint64, uint64, error) {
 	return 0, 0, errors.New("not implemented")
 }
+
+// GetFsSpaceForPath is a placeholder for an unimplemented function
+func GetFsSpaceForPath(path string) (uint64, uint64, error) {
+	return 0, 0, errors.New("not implemented")
+}
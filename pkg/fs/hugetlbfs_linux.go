@@ -0,0 +1,42 @@
+// +build linux
+
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fs
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// hugetlbfsMagic is HUGETLBFS_MAGIC from linux/magic.h
+const hugetlbfsMagic = 0x958458f6
+
+// HugetlbfsPageSize returns the size of the huge pages in bytes
+// for the hugetlbfs mounted at the specified path. It returns an
+// error if there's no hugetlbfs mounted there.
+func HugetlbfsPageSize(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, fmt.Errorf("statfs %q: %v", path, err)
+	}
+	if int64(st.Type)&0xffffffff != hugetlbfsMagic {
+		return 0, fmt.Errorf("%q is not a hugetlbfs mount", path)
+	}
+	// for hugetlbfs, the block size is the huge page size
+	return uint64(st.Bsize), nil
+}
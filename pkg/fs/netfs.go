@@ -0,0 +1,65 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fs
+
+// Filesystem magic numbers from linux/magic.h and the filesystem
+// sources.
+var networkFSMagic = map[int64]string{
+	0x6969:     "nfs",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x517b:     "smb",
+	0x65735546: "fuse",
+	0x00c36400: "ceph",
+	0x01161970: "gfs2",
+	0x7461636f: "ocfs2",
+	0x47504653: "gpfs",
+	0x0bd00bd0: "lustre",
+}
+
+// StorageInfo describes the properties of a filesystem that affect
+// the way qemu should access the VM disks stored on it.
+type StorageInfo struct {
+	// NetworkFSType is the type of the network or cluster filesystem,
+	// such as "nfs", or an empty string for local filesystems.
+	// GlusterFS volumes are mounted via FUSE and thus reported as "fuse".
+	NetworkFSType string
+	// DirectIO is true if the filesystem supports O_DIRECT.
+	DirectIO bool
+	// OFDLocks is true if the open file description locks used by
+	// qemu for disk image locking work on the filesystem.
+	OFDLocks bool
+}
+
+// Merge combines the properties of two filesystems so that the
+// result is safe for disks that span both of them, e.g. qcow2
+// overlays and their backing files.
+func (si *StorageInfo) Merge(other *StorageInfo) *StorageInfo {
+	r := &StorageInfo{
+		NetworkFSType: si.NetworkFSType,
+		DirectIO:      si.DirectIO && other.DirectIO,
+		OFDLocks:      si.OFDLocks && other.OFDLocks,
+	}
+	if r.NetworkFSType == "" {
+		r.NetworkFSType = other.NetworkFSType
+	}
+	return r
+}
+
+func networkFSTypeName(magic int64) string {
+	return networkFSMagic[magic&0xffffffff]
+}
@@ -0,0 +1,91 @@
+// +build linux
+
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// F_OFD_SETLK from fcntl.h, not available in syscall package
+	fOFDSetLk = 37
+	// the alignment that's sufficient for O_DIRECT writes
+	// on all of the common filesystems
+	directIOAlignment = 4096
+)
+
+// ProbeStorage returns the properties of the filesystem which
+// contains the specified directory that are relevant for keeping
+// VM disks on it.
+func ProbeStorage(dir string) (*StorageInfo, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return nil, fmt.Errorf("statfs %q: %v", dir, err)
+	}
+
+	f, err := ioutil.TempFile(dir, ".virtlet-probe-")
+	if err != nil {
+		return nil, fmt.Errorf("can't create probe file in %q: %v", dir, err)
+	}
+	probePath := f.Name()
+	defer os.Remove(probePath)
+	defer f.Close()
+
+	return &StorageInfo{
+		NetworkFSType: networkFSTypeName(int64(st.Type)),
+		DirectIO:      probeDirectIO(probePath),
+		OFDLocks:      probeOFDLocks(f),
+	}, nil
+}
+
+// probeDirectIO checks whether an aligned write to the file opened
+// with O_DIRECT succeeds. Some filesystems (e.g. older FUSE based
+// ones) refuse O_DIRECT on open, while others only fail on I/O.
+func probeDirectIO(path string) bool {
+	fd, err := syscall.Open(path, syscall.O_WRONLY|syscall.O_DIRECT, 0)
+	if err != nil {
+		return false
+	}
+	defer syscall.Close(fd)
+	// allocate an extra block to be able to align the buffer
+	buf := make([]byte, 2*directIOAlignment)
+	offset := directIOAlignment - int(uintptr(unsafe.Pointer(&buf[0]))%directIOAlignment)
+	_, err = syscall.Write(fd, buf[offset:offset+directIOAlignment])
+	return err == nil
+}
+
+// probeOFDLocks checks whether open file description locks, which
+// are used by qemu to protect the disk images from concurrent
+// access, work on the file.
+func probeOFDLocks(f *os.File) bool {
+	lk := syscall.Flock_t{
+		Type:   syscall.F_WRLCK,
+		Whence: int16(os.SEEK_SET),
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), fOFDSetLk, uintptr(unsafe.Pointer(&lk))); errno != 0 {
+		return false
+	}
+	lk.Type = syscall.F_UNLCK
+	syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), fOFDSetLk, uintptr(unsafe.Pointer(&lk)))
+	return true
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestNetworkFSTypeName(t *testing.T) {
+	for _, tc := range []struct {
+		magic    int64
+		expected string
+	}{
+		{0x6969, "nfs"},
+		{0x65735546, "fuse"},
+		// statfs f_type is a signed 32-bit value on some architectures
+		{int64(int32(-0xacb2be)), "cifs"},
+		// ext4
+		{0xef53, ""},
+		// tmpfs
+		{0x01021994, ""},
+	} {
+		if r := networkFSTypeName(tc.magic); r != tc.expected {
+			t.Errorf("networkFSTypeName(0x%x): %q instead of %q", tc.magic, r, tc.expected)
+		}
+	}
+}
+
+func TestStorageInfoMerge(t *testing.T) {
+	local := &StorageInfo{DirectIO: true, OFDLocks: true}
+	nfs := &StorageInfo{NetworkFSType: "nfs", DirectIO: true}
+	expected := &StorageInfo{NetworkFSType: "nfs", DirectIO: true}
+	for _, r := range []*StorageInfo{local.Merge(nfs), nfs.Merge(local)} {
+		if !reflect.DeepEqual(r, expected) {
+			t.Errorf("bad merge result: %#v instead of %#v", r, expected)
+		}
+	}
+}
+
+func TestProbeStorage(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "probe-storage")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if _, err := ProbeStorage(tmpDir); err != nil {
+		t.Errorf("ProbeStorage(): %v", err)
+	}
+	files, err := ioutil.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("ReadDir(): %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("the probe file was left behind in %q", tmpDir)
+	}
+
+	if _, err := ProbeStorage("/no/such/dir"); err == nil {
+		t.Errorf("ProbeStorage() didn't fail for a nonexistent directory")
+	}
+}
+
+func TestHugetlbfsPageSize(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "hugetlbfs")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if _, err := HugetlbfsPageSize(tmpDir); err == nil {
+		t.Errorf("HugetlbfsPageSize() didn't fail for a directory that's not a hugetlbfs mount")
+	}
+	if _, err := HugetlbfsPageSize("/no/such/dir"); err == nil {
+		t.Errorf("HugetlbfsPageSize() didn't fail for a nonexistent directory")
+	}
+}
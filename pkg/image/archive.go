@@ -0,0 +1,311 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	digest "github.com/opencontainers/go-digest"
+)
+
+const (
+	archiveManifestName = "manifest.json"
+	archiveDataPrefix   = "data/"
+)
+
+// ArchiveEntry describes an image stored in an image archive.
+type ArchiveEntry struct {
+	// Name is the name of the image.
+	Name string `json:"name"`
+	// Digest is the digest of the image data.
+	Digest string `json:"digest"`
+	// Size is the size of the image data in bytes.
+	Size uint64 `json:"size"`
+}
+
+// SaveImages writes the specified images to w as a tar archive
+// that can be loaded using LoadImages. If no names are specified,
+// all of the images in the store are saved. The archive contains
+// manifest.json that lists the image names along with their digests
+// followed by the image data files, one per unique digest.
+func (s *FileStore) SaveImages(names []string, w io.Writer) error {
+	images, err := s.imagesToSave(names)
+	if err != nil {
+		return err
+	}
+
+	var manifest []ArchiveEntry
+	var dataImages []*Image
+	seen := make(map[string]bool)
+	for _, img := range images {
+		manifest = append(manifest, ArchiveEntry{
+			Name:   img.Name,
+			Digest: img.Digest,
+			Size:   img.Size,
+		})
+		if !seen[img.Digest] {
+			seen[img.Digest] = true
+			dataImages = append(dataImages, img)
+		}
+	}
+
+	tw := tar.NewWriter(w)
+	if err := writeManifest(tw, manifest); err != nil {
+		return err
+	}
+	for _, img := range dataImages {
+		if err := writeImageData(tw, img); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// WriteFileArchive writes a tar archive that can be loaded using
+// LoadImages and contains a single image with the specified name
+// and the data taken from the specified file. It returns the
+// manifest entry for the image.
+func WriteFileArchive(w io.Writer, name, path string) (*ArchiveEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	d, err := digest.FromReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("error calculating the digest of %q: %v", path, err)
+	}
+	entry := ArchiveEntry{
+		Name:   name,
+		Digest: d.String(),
+		Size:   uint64(fi.Size()),
+	}
+
+	tw := tar.NewWriter(w)
+	if err := writeManifest(tw, []ArchiveEntry{entry}); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := writeDataFile(tw, d.Hex(), f, fi.Size()); err != nil {
+		return nil, fmt.Errorf("error writing the data of image %q: %v", name, err)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func writeManifest(tw *tar.Writer, manifest []ArchiveEntry) error {
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling the manifest: %v", err)
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name: archiveManifestName,
+		Mode: 0644,
+		Size: int64(len(manifestData)),
+	}); err != nil {
+		return err
+	}
+	_, err = tw.Write(manifestData)
+	return err
+}
+
+func (s *FileStore) imagesToSave(names []string) ([]*Image, error) {
+	if len(names) == 0 {
+		return s.ListImages("")
+	}
+	var images []*Image
+	for _, name := range names {
+		img, err := s.ImageStatus(name)
+		switch {
+		case err != nil:
+			return nil, err
+		case img == nil:
+			return nil, fmt.Errorf("image not found: %q", name)
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+func writeImageData(tw *tar.Writer, img *Image) error {
+	hexDigest, err := img.hexDigest()
+	if err != nil {
+		return fmt.Errorf("bad digest for image %q: %v", img.Name, err)
+	}
+	f, err := os.Open(img.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := writeDataFile(tw, hexDigest, f, fi.Size()); err != nil {
+		return fmt.Errorf("error writing the data of image %q: %v", img.Name, err)
+	}
+	return nil
+}
+
+func writeDataFile(tw *tar.Writer, hexDigest string, r io.Reader, size int64) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name: archiveDataPrefix + hexDigest,
+		Mode: 0644,
+		Size: size,
+	}); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+// LoadImages loads the images from a tar archive produced by
+// SaveImages into the store. The digests of the image data are
+// verified against the manifest. It returns the list of images
+// that were loaded.
+func (s *FileStore) LoadImages(r io.Reader) ([]*Image, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	switch {
+	case err == io.EOF:
+		return nil, fmt.Errorf("the image archive is empty")
+	case err != nil:
+		return nil, fmt.Errorf("error reading the image archive: %v", err)
+	case hdr.Name != archiveManifestName:
+		return nil, fmt.Errorf("bad image archive: expected %q as the first entry, got %q", archiveManifestName, hdr.Name)
+	}
+	var manifest []ArchiveEntry
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("error unmarshalling the manifest: %v", err)
+	}
+	namesByDigest := make(map[string][]string)
+	for _, entry := range manifest {
+		d, err := digest.Parse(entry.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("bad digest for image %q: %v", entry.Name, err)
+		}
+		namesByDigest[d.Hex()] = append(namesByDigest[d.Hex()], entry.Name)
+	}
+
+	if err := os.MkdirAll(s.dataDir(), 0777); err != nil {
+		return nil, fmt.Errorf("mkdir %q: %v", s.dataDir(), err)
+	}
+	var loaded []*Image
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return loaded, fmt.Errorf("error reading the image archive: %v", err)
+		}
+		if !strings.HasPrefix(hdr.Name, archiveDataPrefix) {
+			glog.Warningf("Skipping unexpected image archive entry %q", hdr.Name)
+			continue
+		}
+		hexDigest := path.Base(hdr.Name)
+		names := namesByDigest[hexDigest]
+		if len(names) == 0 {
+			glog.Warningf("Skipping image data %q not referenced by the manifest", hdr.Name)
+			continue
+		}
+		if err := s.loadImageData(tr, hexDigest, names); err != nil {
+			return loaded, err
+		}
+		delete(namesByDigest, hexDigest)
+		for _, name := range names {
+			img, err := s.ImageStatus(name)
+			if err != nil {
+				return loaded, err
+			}
+			if img != nil {
+				loaded = append(loaded, img)
+			}
+		}
+	}
+
+	var missing []string
+	for _, names := range namesByDigest {
+		missing = append(missing, names...)
+	}
+	if len(missing) != 0 {
+		sort.Strings(missing)
+		return loaded, fmt.Errorf("image archive doesn't contain the data for %s", strings.Join(missing, ", "))
+	}
+	return loaded, nil
+}
+
+// loadImageData places the image data read from r into the store
+// under the specified names after verifying its digest.
+func (s *FileStore) loadImageData(r io.Reader, hexDigest string, names []string) error {
+	tempFile, err := s.createTempFile()
+	if err != nil {
+		return err
+	}
+	tempPath := tempFile.Name()
+	ok := false
+	defer func() {
+		// closing the file releases the lock on it
+		tempFile.Close()
+		if !ok {
+			if err := os.Remove(tempPath); err != nil && !os.IsNotExist(err) {
+				glog.Warningf("Error removing %q: %v", tempPath, err)
+			}
+		}
+	}()
+
+	digester := digest.Canonical.Digester()
+	if _, err := io.Copy(io.MultiWriter(tempFile, digester.Hash()), r); err != nil {
+		return fmt.Errorf("error writing %q: %v", tempPath, err)
+	}
+	if d := digester.Digest(); d.Hex() != hexDigest {
+		return fmt.Errorf("image digest mismatch for %s: %s instead of sha256:%s", strings.Join(names, ", "), d, hexDigest)
+	}
+
+	for n, name := range names {
+		srcPath := tempPath
+		if n < len(names)-1 {
+			// placeImage consumes the file, so use a hard link
+			// for all of the names except the last one
+			srcPath = fmt.Sprintf("%s_%d", tempPath, n)
+			if err := os.Link(tempPath, srcPath); err != nil {
+				return fmt.Errorf("error linking %q to %q: %v", tempPath, srcPath, err)
+			}
+		}
+		if err := s.placeImage(srcPath, hexDigest, name); err != nil {
+			return err
+		}
+	}
+	ok = true
+	return nil
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+func (tst *ifsTester) saveImages(names ...string) *bytes.Buffer {
+	var buf bytes.Buffer
+	if err := tst.store.SaveImages(names, &buf); err != nil {
+		tst.t.Fatalf("SaveImages(): %v", err)
+	}
+	return &buf
+}
+
+func (tst *ifsTester) loadImages(archive *bytes.Buffer, expectedImages ...*Image) {
+	images, err := tst.store.LoadImages(archive)
+	if err != nil {
+		tst.t.Fatalf("LoadImages(): %v", err)
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].Name < images[j].Name })
+	if !reflect.DeepEqual(images, expectedImages) {
+		tst.t.Errorf("LoadImages(): bad result:\n%s\n-- instead of --\n%s", spew.Sdump(images), spew.Sdump(expectedImages))
+	}
+}
+
+func archiveEntryNames(archive []byte) []string {
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, hdr.Name)
+	}
+	return names
+}
+
+func TestSaveLoadImages(t *testing.T) {
+	src := newIfsTester(t)
+	defer src.teardown()
+	src.pullAllImages()
+
+	archive := src.saveImages()
+	expectedEntries := []string{
+		"manifest.json",
+		"data/" + sha256str("###baz"),
+		"data/" + sha256str("###example.com:1234/foo/bar"),
+	}
+	if entries := archiveEntryNames(archive.Bytes()); !reflect.DeepEqual(entries, expectedEntries) {
+		t.Errorf("bad archive entries: %#v instead of %#v", entries, expectedEntries)
+	}
+
+	dst := newIfsTester(t)
+	defer dst.teardown()
+	dst.loadImages(archive, dst.images[1], dst.images[0], dst.images[2])
+	dst.verifyListImages("", dst.images[1], dst.images[0], dst.images[2])
+	dst.verifyImage(dst.refs[0], "###example.com:1234/foo/bar")
+	dst.verifyImage(dst.refs[1], "###baz")
+	dst.verifyImage(dst.refs[2], "###baz")
+	dst.verifyDataFiles(sha256str("###example.com:1234/foo/bar"), sha256str("###baz"))
+	if len(dst.downloader.started) != 0 {
+		t.Errorf("the images were downloaded instead of being loaded from the archive")
+	}
+
+	// loading the same images again is ok
+	dst.loadImages(src.saveImages(), dst.images[1], dst.images[0], dst.images[2])
+	dst.verifyDataFiles(sha256str("###example.com:1234/foo/bar"), sha256str("###baz"))
+}
+
+func TestSaveSelectedImages(t *testing.T) {
+	src := newIfsTester(t)
+	defer src.teardown()
+	src.pullAllImages()
+
+	dst := newIfsTester(t)
+	defer dst.teardown()
+	dst.loadImages(src.saveImages("foobar"), dst.images[2])
+	dst.verifyListImages("", dst.images[2])
+	dst.verifyDataFiles(sha256str("###baz"))
+
+	var buf bytes.Buffer
+	if err := src.store.SaveImages([]string{"nosuchimage"}, &buf); err == nil {
+		t.Errorf("SaveImages() didn't fail for a nonexistent image")
+	}
+}
+
+func TestLoadCorruptedImages(t *testing.T) {
+	src := newIfsTester(t)
+	defer src.teardown()
+	src.pullImage(src.images[1].Name, src.refs[1])
+
+	// replace the image data keeping its size
+	corrupted := bytes.Replace(src.saveImages().Bytes(), []byte("###baz"), []byte("###bar"), 1)
+
+	dst := newIfsTester(t)
+	defer dst.teardown()
+	_, err := dst.store.LoadImages(bytes.NewBuffer(corrupted))
+	if err == nil {
+		t.Fatalf("LoadImages() didn't fail for corrupted image data")
+	}
+	if !strings.Contains(err.Error(), "image digest mismatch") {
+		t.Errorf("bad error message: %v", err)
+	}
+	dst.verifyListImages("")
+	dst.verifyDataDirIsEmpty()
+}
+
+func TestWriteFileArchive(t *testing.T) {
+	dst := newIfsTester(t)
+	defer dst.teardown()
+
+	srcPath := filepath.Join(dst.tmpDir, "cirros.img")
+	if err := ioutil.WriteFile(srcPath, []byte("###cirros"), 0644); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	var buf bytes.Buffer
+	entry, err := WriteFileArchive(&buf, "cirros", srcPath)
+	if err != nil {
+		t.Fatalf("WriteFileArchive(): %v", err)
+	}
+	expectedEntry := &ArchiveEntry{
+		Name:   "cirros",
+		Digest: "sha256:" + sha256str("###cirros"),
+		Size:   9,
+	}
+	if !reflect.DeepEqual(entry, expectedEntry) {
+		t.Errorf("bad archive entry: %#v instead of %#v", entry, expectedEntry)
+	}
+	expectedEntries := []string{"manifest.json", "data/" + sha256str("###cirros")}
+	if entries := archiveEntryNames(buf.Bytes()); !reflect.DeepEqual(entries, expectedEntries) {
+		t.Errorf("bad archive entries: %#v instead of %#v", entries, expectedEntries)
+	}
+
+	dst.loadImages(&buf, &Image{
+		Digest: expectedEntry.Digest,
+		Name:   "cirros",
+		Path:   dst.subpath("data/" + sha256str("###cirros")),
+		Size:   9,
+	})
+	dst.verifyImage("cirros@"+expectedEntry.Digest, "###cirros")
+}
@@ -21,8 +21,10 @@ import (
 	"crypto"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
@@ -35,6 +37,18 @@ import (
 
 const (
 	copyBufferSize = 1024 * 1024
+	// maxResumeAttempts is the max number of times the download
+	// is resumed after the connection breaks
+	maxResumeAttempts = 5
+	// maxURLRefreshes is the max number of times the URL is
+	// refreshed during a single download
+	maxURLRefreshes = 5
+	// urlRefreshTimeout is the time limit for a single URL
+	// refresh request made by the HTTP URL refresher
+	urlRefreshTimeout = 30 * time.Second
+	// maxRefreshedURLLength is the max length of the URL returned
+	// by the URL refresh service
+	maxRefreshedURLLength = 65536
 )
 
 // Endpoint contains all the endpoint parameters needed to download a file
@@ -57,6 +71,70 @@ type Endpoint struct {
 
 	// Transport profile name for this endpoint. Provided for logging/debugging
 	ProfileName string
+
+	// MaxSize is the max size of the file in bytes. <= 0 means no limit (default)
+	MaxSize int64
+
+	// URLRefresher is used to get a fresh URL when the current one
+	// expires during the download. nil means no refreshing (default)
+	URLRefresher URLRefresher
+}
+
+// URLRefresher is used to obtain a fresh URL of the file when the
+// current one expires, which may happen for example with presigned
+// S3 or signed GCS URLs if the download takes long time.
+type URLRefresher interface {
+	// RefreshURL returns a fresh URL to use instead of the
+	// specified one
+	RefreshURL(ctx context.Context, url string) (string, error)
+}
+
+type httpURLRefresher struct {
+	serviceURL string
+	client     *http.Client
+}
+
+// NewHTTPURLRefresher returns an URLRefresher that obtains fresh
+// URLs from the specified HTTP(S) service. The refresher makes a
+// GET request to the service URL passing the expired URL as the
+// "url" query parameter and expects the fresh URL as the plain
+// text response body.
+func NewHTTPURLRefresher(serviceURL string) URLRefresher {
+	return &httpURLRefresher{
+		serviceURL: serviceURL,
+		client:     &http.Client{Timeout: urlRefreshTimeout},
+	}
+}
+
+func (r *httpURLRefresher) RefreshURL(ctx context.Context, oldURL string) (string, error) {
+	u, err := url.Parse(r.serviceURL)
+	if err != nil {
+		return "", fmt.Errorf("bad URL refresh service URL %q: %v", r.serviceURL, err)
+	}
+	q := u.Query()
+	q.Set("url", oldURL)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("URL refresh service returned bad http status %q", resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRefreshedURLLength))
+	if err != nil {
+		return "", err
+	}
+	newURL := strings.TrimSpace(string(body))
+	if newURL == "" {
+		return "", errors.New("URL refresh service returned an empty URL")
+	}
+	return newURL, nil
 }
 
 // TLSConfig has the TLS transport parameters
@@ -86,6 +164,49 @@ type Downloader interface {
 	DownloadFile(ctx context.Context, endpoint Endpoint, w io.Writer) error
 }
 
+// ProgressFunc is called during the download to report the number of
+// bytes that were already downloaded and the total size of the file.
+// bytesTotal is -1 if the size of the file is not known.
+type ProgressFunc func(bytesDone, bytesTotal int64)
+
+type progressKey struct{}
+
+// WithProgress returns a copy of the context that makes
+// the downloader report the download progress using the
+// specified function.
+func WithProgress(ctx context.Context, progress ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, progress)
+}
+
+func progressFromContext(ctx context.Context) ProgressFunc {
+	progress, _ := ctx.Value(progressKey{}).(ProgressFunc)
+	return progress
+}
+
+// progressWriter counts the bytes written to the destination,
+// reporting the progress if needed. It also remembers the write
+// errors so they can be told apart from the errors that happen
+// while reading the response body.
+type progressWriter struct {
+	w          io.Writer
+	bytesDone  int64
+	bytesTotal int64
+	progress   ProgressFunc
+	err        error
+}
+
+var _ io.Writer = &progressWriter{}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.bytesDone += int64(n)
+	pw.err = err
+	if pw.progress != nil {
+		pw.progress(pw.bytesDone, pw.bytesTotal)
+	}
+	return n, err
+}
+
 type defaultDownloader struct {
 	protocol string
 }
@@ -180,6 +301,29 @@ func createHTTPClient(endpoint Endpoint) (*http.Client, error) {
 	}, nil
 }
 
+// urlMayBeExpired returns true if the http status may mean that
+// a signed URL has expired. S3 responds with 403 Forbidden to the
+// expired presigned URLs while GCS uses 400 Bad Request.
+func urlMayBeExpired(statusCode int) bool {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusGone:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *defaultDownloader) get(ctx context.Context, client *http.Client, url string, offset int64) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	return client.Do(req.WithContext(ctx))
+}
+
 func (d *defaultDownloader) DownloadFile(ctx context.Context, endpoint Endpoint, w io.Writer) error {
 	url := endpoint.URL
 	if !strings.Contains(url, "://") {
@@ -193,23 +337,72 @@ func (d *defaultDownloader) DownloadFile(ctx context.Context, endpoint Endpoint,
 
 	glog.V(2).Infof("Start downloading %s", url)
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return err
-	}
-	req = req.WithContext(ctx)
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+	pw := &progressWriter{w: w, bytesTotal: -1, progress: progressFromContext(ctx)}
+	resumes, refreshes := 0, 0
+	for {
+		resp, err := d.get(ctx, client, url, pw.bytesDone)
+		if err != nil {
+			if pw.bytesDone > 0 && ctx.Err() == nil && resumes < maxResumeAttempts {
+				resumes++
+				glog.Warningf("Error resuming download of %s, retrying: %v", url, err)
+				continue
+			}
+			return err
+		}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad http status %q", resp.Status)
-	}
+		if urlMayBeExpired(resp.StatusCode) && endpoint.URLRefresher != nil && refreshes < maxURLRefreshes {
+			resp.Body.Close()
+			refreshes++
+			newURL, err := endpoint.URLRefresher.RefreshURL(ctx, url)
+			if err != nil {
+				return fmt.Errorf("error refreshing URL %s after getting http status %q: %v", url, resp.Status, err)
+			}
+			glog.V(2).Infof("Refreshed URL %s after getting http status %q", url, resp.Status)
+			url = newURL
+			continue
+		}
 
-	if _, err = io.CopyBuffer(w, resp.Body, make([]byte, copyBufferSize)); err != nil {
-		return err
+		if pw.bytesDone == 0 {
+			if resp.StatusCode != http.StatusOK {
+				resp.Body.Close()
+				return fmt.Errorf("bad http status %q", resp.Status)
+			}
+			if endpoint.MaxSize > 0 && resp.ContentLength > endpoint.MaxSize {
+				resp.Body.Close()
+				return fmt.Errorf("the size of %s (%d bytes) exceeds the limit of %d bytes", url, resp.ContentLength, endpoint.MaxSize)
+			}
+			pw.bytesTotal = resp.ContentLength
+			if pw.progress != nil {
+				pw.progress(0, pw.bytesTotal)
+			}
+		} else if resp.StatusCode != http.StatusPartialContent ||
+			!strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", pw.bytesDone)) {
+			// can't rewind the destination to start over
+			resp.Body.Close()
+			return fmt.Errorf("can't resume download of %s: bad http status %q", url, resp.Status)
+		}
+
+		var body io.Reader = resp.Body
+		if endpoint.MaxSize > 0 {
+			// read one byte more than allowed to detect
+			// the files that are too big
+			body = io.LimitReader(body, endpoint.MaxSize-pw.bytesDone+1)
+		}
+		_, err = io.CopyBuffer(pw, body, make([]byte, copyBufferSize))
+		resp.Body.Close()
+		switch {
+		case err == nil:
+		case pw.err != nil || ctx.Err() != nil || resumes >= maxResumeAttempts:
+			return err
+		default:
+			resumes++
+			glog.Warningf("Download of %s interrupted after %d bytes, resuming: %v", url, pw.bytesDone, err)
+			continue
+		}
+		if endpoint.MaxSize > 0 && pw.bytesDone > endpoint.MaxSize {
+			return fmt.Errorf("the size of %s exceeds the limit of %d bytes", url, endpoint.MaxSize)
+		}
+		break
 	}
 
 	if f, ok := w.(*os.File); ok {
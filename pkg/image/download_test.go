@@ -22,6 +22,7 @@ import (
 	"crypto/tls"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -75,6 +76,30 @@ func TestDownloadRedirect(t *testing.T) {
 	})
 }
 
+func TestDownloadProgress(t *testing.T) {
+	ts := httptest.NewServer(downloadHandler("foobar"))
+	defer ts.Close()
+	var bytesDone, bytesTotal int64 = -1, -1
+	ctx := WithProgress(context.Background(), func(done, total int64) {
+		if done < bytesDone {
+			t.Errorf("progress went backwards: %d after %d", done, bytesDone)
+		}
+		bytesDone, bytesTotal = done, total
+	})
+	var buf bytes.Buffer
+	if err := NewDownloader("http").DownloadFile(ctx, Endpoint{
+		URL: ts.Listener.Addr().String() + "/base.qcow2",
+	}, &buf); err != nil {
+		t.Fatalf("DownloadFile(): %v", err)
+	}
+	if buf.String() != "foobar" {
+		t.Errorf("bad content: %q instead of %q", buf.String(), "foobar")
+	}
+	if bytesDone != 6 || bytesTotal != 6 {
+		t.Errorf("bad progress: %d/%d instead of 6/6", bytesDone, bytesTotal)
+	}
+}
+
 func TestTLSDownload(t *testing.T) {
 	ca, caKey := testutils.GenerateCert(t, true, "CA", nil, nil)
 	cert, key := testutils.GenerateCert(t, false, "127.0.0.1", ca, caKey)
@@ -152,3 +177,114 @@ func TestNotFound(t *testing.T) {
 		t.Errorf("bad error message for nonexistent image")
 	}
 }
+
+func TestDownloadSizeLimit(t *testing.T) {
+	ts := httptest.NewServer(downloadHandler("foobar"))
+	defer ts.Close()
+	verifyDownload(t, "http", "foobar", Endpoint{
+		URL:     ts.Listener.Addr().String() + "/base.qcow2",
+		MaxSize: 6,
+	})
+
+	var buf bytes.Buffer
+	ep := Endpoint{
+		URL:     ts.Listener.Addr().String() + "/base.qcow2",
+		MaxSize: 5,
+	}
+	switch err := NewDownloader("http").DownloadFile(context.Background(), ep, &buf); {
+	case err == nil:
+		t.Errorf("no error returned for an image that exceeds the size limit")
+	case !strings.Contains(err.Error(), "exceeds the limit"):
+		t.Errorf("bad error message for an image that exceeds the size limit: %v", err)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rangeHeader := r.Header.Get("Range")
+		ranges = append(ranges, rangeHeader)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		switch rangeHeader {
+		case "":
+			// the connection is closed after the handler returns
+			// because of the incomplete body
+			w.Header().Set("Content-Length", "6")
+			w.Write([]byte("foo"))
+		case "bytes=3-":
+			w.Header().Set("Content-Range", "bytes 3-5/6")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte("bar"))
+		default:
+			http.Error(w, "bad range", http.StatusRequestedRangeNotSatisfiable)
+		}
+	}))
+	defer ts.Close()
+	verifyDownload(t, "http", "foobar", Endpoint{
+		URL: ts.Listener.Addr().String() + "/base.qcow2",
+	})
+	expectedRanges := []string{"", "bytes=3-"}
+	if !reflect.DeepEqual(ranges, expectedRanges) {
+		t.Errorf("bad ranges: %#v instead of %#v", ranges, expectedRanges)
+	}
+}
+
+type fakeURLRefresher struct {
+	urls   []string
+	newURL string
+}
+
+func (r *fakeURLRefresher) RefreshURL(ctx context.Context, url string) (string, error) {
+	r.urls = append(r.urls, url)
+	return r.newURL, nil
+}
+
+func TestDownloadURLRefresh(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.String() {
+		case "/base.qcow2?sig=old":
+			http.Error(w, "Request has expired", http.StatusForbidden)
+		case "/base.qcow2?sig=new":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte("foobar"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	baseURL := "http://" + ts.Listener.Addr().String() + "/base.qcow2"
+	refresher := &fakeURLRefresher{newURL: baseURL + "?sig=new"}
+	verifyDownload(t, "http", "foobar", Endpoint{
+		URL:          baseURL + "?sig=old",
+		URLRefresher: refresher,
+	})
+	expectedURLs := []string{baseURL + "?sig=old"}
+	if !reflect.DeepEqual(refresher.urls, expectedURLs) {
+		t.Errorf("bad refreshed URLs: %#v instead of %#v", refresher.urls, expectedURLs)
+	}
+}
+
+func TestHTTPURLRefresher(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/refresh" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(r.URL.Query().Get("url") + "-fresh\n"))
+	}))
+	defer ts.Close()
+
+	refresher := NewHTTPURLRefresher("http://" + ts.Listener.Addr().String() + "/refresh")
+	newURL, err := refresher.RefreshURL(context.Background(), "http://example.com/foo?sig=abc&x=1")
+	if err != nil {
+		t.Fatalf("RefreshURL(): %v", err)
+	}
+	if expectedURL := "http://example.com/foo?sig=abc&x=1-fresh"; newURL != expectedURL {
+		t.Errorf("bad refreshed URL: %q instead of %q", newURL, expectedURL)
+	}
+
+	refresher = NewHTTPURLRefresher("http://" + ts.Listener.Addr().String() + "/nonexistent")
+	if _, err := refresher.RefreshURL(context.Background(), "http://example.com/foo"); err == nil {
+		t.Errorf("RefreshURL() didn't fail for a bad URL refresh service")
+	}
+}
@@ -133,6 +133,27 @@ func (s *FakeStore) SetRefGetter(imageRefGetter image.RefGetter) {
 	s.refGetter = imageRefGetter
 }
 
+// SetPullLimit implements SetPullLimit method of Store interface.
+func (s *FakeStore) SetPullLimit(limit int) {
+	s.rec.Rec("SetPullLimit", limit)
+}
+
+// SetPullRateLimit implements SetPullRateLimit method of Store interface.
+func (s *FakeStore) SetPullRateLimit(pullsPerMinute int) {
+	s.rec.Rec("SetPullRateLimit", pullsPerMinute)
+}
+
+// SetGCPolicy implements SetGCPolicy method of Store interface.
+func (s *FakeStore) SetGCPolicy(policy image.GCPolicy) {
+	s.rec.Rec("SetGCPolicy", policy)
+}
+
+// FreeDiskSpace implements FreeDiskSpace method of Store interface.
+func (s *FakeStore) FreeDiskSpace() error {
+	s.rec.Rec("FreeDiskSpace", nil)
+	return nil
+}
+
 // FilesystemStats implements FilesystemStats method from Store interface.
 func (s *FakeStore) FilesystemStats() (*types.FilesystemStats, error) {
 	return &types.FilesystemStats{
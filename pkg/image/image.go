@@ -22,14 +22,19 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/aykevl/osfs"
 	"github.com/docker/distribution/reference"
 	"github.com/golang/glog"
+	"github.com/jonboulle/clockwork"
 	digest "github.com/opencontainers/go-digest"
+	"golang.org/x/time/rate"
 
+	"github.com/Mirantis/virtlet/pkg/faults"
 	"github.com/Mirantis/virtlet/pkg/fs"
 	"github.com/Mirantis/virtlet/pkg/metadata/types"
 )
@@ -58,6 +63,29 @@ type Translator func(context.Context, string) Endpoint
 // that are currently in use.
 type RefGetter func() (map[string]bool, error)
 
+// GCPolicy specifies the policy for removing unused images
+// when the disk space runs low.
+type GCPolicy struct {
+	// MinAge is the minimum age of an unused image before
+	// it can be removed.
+	MinAge time.Duration
+	// ProtectedImages is a list of glob patterns for the names
+	// of the images (without tags) that must never be removed.
+	ProtectedImages []string
+	// HighWatermark is the disk usage percentage of the filesystem
+	// holding the images which triggers removal of unused images.
+	// 0 disables the removal.
+	HighWatermark int
+	// LowWatermark is the disk usage percentage the image
+	// removal attempts to reach.
+	LowWatermark int
+}
+
+// DiskSpaceFunc specifies a function that returns the number
+// of used bytes and the total size of the filesystem that
+// contains the specified path.
+type DiskSpaceFunc func(path string) (uint64, uint64, error)
+
 // Store is an interface for the image store.
 type Store interface {
 	// ListImage returns the list of images in the store.
@@ -90,6 +118,23 @@ type Store interface {
 	// the set of images that are currently in use.
 	SetRefGetter(imageRefGetter RefGetter)
 
+	// SetPullLimit sets the maximum number of images that can be
+	// pulled simultaneously. 0 means no limit.
+	SetPullLimit(limit int)
+
+	// SetPullRateLimit sets the maximum number of image pulls
+	// that can be started per minute. 0 means no limit.
+	SetPullRateLimit(pullsPerMinute int)
+
+	// SetGCPolicy sets the policy for removing unused images
+	// when the disk space runs low.
+	SetGCPolicy(policy GCPolicy)
+
+	// FreeDiskSpace removes unused images according to the GC
+	// policy if the disk usage of the filesystem holding the
+	// images exceeds the high watermark.
+	FreeDiskSpace() error
+
 	// FilesystemStats returns disk space and inode usage info for this store.
 	FilesystemStats() (*types.FilesystemStats, error)
 
@@ -105,14 +150,35 @@ type VirtualSizeFunc func(string) (uint64, error)
 // workings, see docs/images.md
 type FileStore struct {
 	sync.Mutex
-	dir        string
-	downloader Downloader
-	vsizeFunc  VirtualSizeFunc
-	refGetter  RefGetter
+	dir         string
+	downloader  Downloader
+	vsizeFunc   VirtualSizeFunc
+	refGetter   RefGetter
+	pullSlotCh  chan struct{}
+	pullLimit   int
+	activePulls int
+	pullRate    int
+	pullLimiter *rate.Limiter
+	gcPolicy    GCPolicy
+	diskSpace   DiskSpaceFunc
+	clock       clockwork.Clock
 }
 
 var _ Store = &FileStore{}
 
+const (
+	// storeLockFileName is the name of the file inside the store
+	// directory that's used for inter-process locking
+	storeLockFileName = "lock"
+	// tempFilePrefix is the prefix of the temporary files in
+	// the data directory
+	tempFilePrefix = "part_"
+	// tempLinkPrefix is the prefix of the temporary symlinks in
+	// the link directory. The image names can't start with a dot
+	// so there can be no conflicts with the actual image links.
+	tempLinkPrefix = ".new_"
+)
+
 // NewFileStore creates a new FileStore that will be using
 // the specified dir to store the images, image downloader and
 // a function for getting virtual size of the image. If vsizeFunc
@@ -121,11 +187,65 @@ func NewFileStore(dir string, downloader Downloader, vsizeFunc VirtualSizeFunc)
 	if vsizeFunc == nil {
 		vsizeFunc = GetImageVirtualSize
 	}
-	return &FileStore{
+	s := &FileStore{
 		dir:        dir,
 		downloader: downloader,
 		vsizeFunc:  vsizeFunc,
+		diskSpace:  fs.GetFsSpaceForPath,
+		clock:      clockwork.NewRealClock(),
 	}
+	s.pullSlotCh = make(chan struct{})
+	return s
+}
+
+// lockDir acquires the advisory lock on the store directory which
+// makes it possible for several processes, such as Virtlet itself
+// and 'virtlet --image-load' invoked by virtletctl, to update the
+// store concurrently. The lock must be acquired after the store
+// mutex and is released by closing the returned file.
+func (s *FileStore) lockDir() (*os.File, error) {
+	if err := os.MkdirAll(s.dir, 0777); err != nil {
+		return nil, fmt.Errorf("mkdir %q: %v", s.dir, err)
+	}
+	lockFileName := filepath.Join(s.dir, storeLockFileName)
+	f, err := os.OpenFile(lockFileName, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("error opening the lock file %q: %v", lockFileName, err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error locking %q: %v", lockFileName, err)
+	}
+	return f, nil
+}
+
+// createTempFile creates a temporary file for the image data.
+// The file is locked till it's closed so GC doesn't remove it
+// even if it's invoked by another process.
+func (s *FileStore) createTempFile() (*os.File, error) {
+	s.Lock()
+	defer s.Unlock()
+	lf, err := s.lockDir()
+	if err != nil {
+		return nil, err
+	}
+	defer lf.Close()
+
+	if err := os.MkdirAll(s.dataDir(), 0777); err != nil {
+		return nil, fmt.Errorf("mkdir %q: %v", s.dataDir(), err)
+	}
+	f, err := ioutil.TempFile(s.dataDir(), tempFilePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a temporary file: %v", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if err := os.Remove(f.Name()); err != nil {
+			glog.Warningf("Error removing %q: %v", f.Name(), err)
+		}
+		return nil, fmt.Errorf("error locking %q: %v", f.Name(), err)
+	}
+	return f, nil
 }
 
 func (s *FileStore) linkDir() string {
@@ -242,9 +362,35 @@ func (s *FileStore) removeImageIfItsNotNeeded(name, keepData string) (bool, erro
 	}
 }
 
+// symlinkAtomically creates a symbolic link, replacing the existing
+// one if there is any, so that the link is never missing or
+// partially written for the readers.
+func (s *FileStore) symlinkAtomically(target, linkFileName string) error {
+	tempLinkName := filepath.Join(filepath.Dir(linkFileName), tempLinkPrefix+filepath.Base(linkFileName))
+	// remove the leftover temporary link, if any
+	if err := os.Remove(tempLinkName); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing %q: %v", tempLinkName, err)
+	}
+	if err := os.Symlink(target, tempLinkName); err != nil {
+		return err
+	}
+	if err := os.Rename(tempLinkName, linkFileName); err != nil {
+		if err := os.Remove(tempLinkName); err != nil {
+			glog.Warningf("error removing %q: %v", tempLinkName, err)
+		}
+		return err
+	}
+	return nil
+}
+
 func (s *FileStore) placeImage(tempPath string, dataName string, imageName string) error {
 	s.Lock()
 	defer s.Unlock()
+	lf, err := s.lockDir()
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
 
 	dataPath := s.dataFileName(dataName)
 	isNew, err := s.renameIfNewOrDelete(tempPath, dataPath)
@@ -257,11 +403,11 @@ func (s *FileStore) placeImage(tempPath string, dataName string, imageName strin
 	}
 
 	linkFileName := s.linkFileName(imageName)
-	switch _, err := os.Stat(linkFileName); {
+	oldDataName := ""
+	switch dest, err := os.Readlink(linkFileName); {
 	case err == nil:
-		if removed, err := s.removeImageIfItsNotNeeded(imageName, dataName); err != nil {
-			return fmt.Errorf("error removing old symlink %q: %v", linkFileName, err)
-		} else if !removed {
+		oldDataName = filepath.Base(dest)
+		if oldDataName == dataName {
 			// same image with the same name
 			return nil
 		}
@@ -271,7 +417,7 @@ func (s *FileStore) placeImage(tempPath string, dataName string, imageName strin
 		return fmt.Errorf("error checking for symlink %q: %v", linkFileName, err)
 	}
 
-	if err := os.Symlink(filepath.Join("../data/", dataName), linkFileName); err != nil {
+	if err := s.symlinkAtomically(filepath.Join("../data/", dataName), linkFileName); err != nil {
 		if isNew {
 			if err := os.Remove(dataPath); err != nil {
 				glog.Warningf("error removing %q: %v", dataPath, err)
@@ -279,21 +425,37 @@ func (s *FileStore) placeImage(tempPath string, dataName string, imageName strin
 		}
 		return fmt.Errorf("error creating symbolic link %q for image %q: %v", linkFileName, imageName, err)
 	}
+
+	if oldDataName != "" {
+		if err := s.removeIfUnreferenced(oldDataName); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing the old data file %q of image %q: %v", oldDataName, imageName, err)
+		}
+	}
 	return nil
 }
 
+// imageInfo returns the info for the image with the specified link.
+// As the images may be listed while being removed, it returns nil
+// with no error if either the link or the data file doesn't exist
+// anymore.
 func (s *FileStore) imageInfo(fi os.FileInfo) (*Image, error) {
 	fullPath := filepath.Join(s.linkDir(), fi.Name())
 	if fi.Mode()&os.ModeSymlink == 0 {
 		return nil, fmt.Errorf("%q is not a symbolic link", fullPath)
 	}
 	dest, err := os.Readlink(fullPath)
-	if err != nil {
+	switch {
+	case os.IsNotExist(err):
+		return nil, nil
+	case err != nil:
 		return nil, fmt.Errorf("error reading link %q: %v", fullPath, err)
 	}
 	fullDataPath := filepath.Join(s.linkDir(), dest)
 	destFi, err := os.Stat(fullDataPath)
-	if err != nil {
+	switch {
+	case os.IsNotExist(err):
+		return nil, nil
+	case err != nil:
 		return nil, fmt.Errorf("stat %q: %v", fullDataPath, err)
 	}
 	absPath, err := filepath.Abs(fullDataPath)
@@ -333,7 +495,7 @@ func (s *FileStore) listImagesUnlocked(filter string) ([]*Image, error) {
 
 	var r []*Image
 	for _, fi := range infos {
-		if fi.Mode().IsDir() {
+		if fi.Mode().IsDir() || strings.HasPrefix(fi.Name(), tempLinkPrefix) {
 			continue
 		}
 		image, err := s.imageInfo(fi)
@@ -341,6 +503,9 @@ func (s *FileStore) listImagesUnlocked(filter string) ([]*Image, error) {
 		case err != nil:
 			glog.Warningf("listing images: skipping image link %q: %v", fi.Name(), err)
 			continue
+		case image == nil:
+			// the image was removed during the listing
+			continue
 		case filter != "" && image.Name != filter:
 			continue
 		case digestSpec != "" && digest.Digest(image.Digest) != digestSpec:
@@ -353,9 +518,11 @@ func (s *FileStore) listImagesUnlocked(filter string) ([]*Image, error) {
 }
 
 // ListImages implements ListImages method of ImageStore interface.
+// The store mutex is not held while listing the images so the
+// listing doesn't wait for the image writes and GC. This is safe
+// because the image links are always replaced atomically and the
+// data files are only removed after their links.
 func (s *FileStore) ListImages(filter string) ([]*Image, error) {
-	s.Lock()
-	defer s.Unlock()
 	return s.listImagesUnlocked(filter)
 }
 
@@ -365,8 +532,12 @@ func (s *FileStore) imageStatusUnlocked(name string) (*Image, error) {
 	switch fi, err := os.Lstat(linkFileName); {
 	case err == nil:
 		info, err := s.imageInfo(fi)
-		if err != nil {
+		switch {
+		case err != nil:
 			return nil, err
+		case info == nil:
+			// the image was removed after the link was found
+			return nil, nil
 		}
 		_, digestSpec := SplitImageName(name)
 		if digestSpec != "" && digest.Digest(info.Digest) != digestSpec {
@@ -381,29 +552,75 @@ func (s *FileStore) imageStatusUnlocked(name string) (*Image, error) {
 }
 
 // ImageStatus implements ImageStatus method of Store interface.
+// Same as ListImages, it doesn't hold the store mutex.
 func (s *FileStore) ImageStatus(name string) (*Image, error) {
+	return s.imageStatusUnlocked(name)
+}
+
+// startPull waits till the pull rate limit and the pull limit
+// allow the pull to be started. It returns an error if ctx is
+// cancelled while waiting.
+func (s *FileStore) startPull(ctx context.Context) error {
+	s.Lock()
+	limiter := s.pullLimiter
+	s.Unlock()
+	if limiter != nil {
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
 	s.Lock()
 	defer s.Unlock()
-	return s.imageStatusUnlocked(name)
+	for s.pullLimit > 0 && s.activePulls >= s.pullLimit {
+		slotCh := s.pullSlotCh
+		s.Unlock()
+		select {
+		case <-ctx.Done():
+			s.Lock()
+			return ctx.Err()
+		case <-slotCh:
+		}
+		s.Lock()
+	}
+	s.activePulls++
+	return nil
+}
+
+func (s *FileStore) finishPull() {
+	s.Lock()
+	defer s.Unlock()
+	s.activePulls--
+	s.wakePullWaiters()
+}
+
+// wakePullWaiters wakes up the pulls that are waiting for the
+// number of the active pulls to go below the pull limit. It must be
+// called with the store mutex held.
+func (s *FileStore) wakePullWaiters() {
+	close(s.pullSlotCh)
+	s.pullSlotCh = make(chan struct{})
 }
 
 // PullImage implements PullImage method of Store interface.
 func (s *FileStore) PullImage(ctx context.Context, name string, translator Translator) (string, error) {
+	if err := s.startPull(ctx); err != nil {
+		return "", fmt.Errorf("error waiting to pull %q: %v", name, err)
+	}
+	defer s.finishPull()
+	if err := faults.Inject(faults.PointImagePull); err != nil {
+		return "", err
+	}
 	name, specDigest := SplitImageName(name)
 	ep := translator(ctx, name)
 	glog.V(1).Infof("Image translation: %q -> %q", name, ep.URL)
-	if err := os.MkdirAll(s.dataDir(), 0777); err != nil {
-		return "", fmt.Errorf("mkdir %q: %v", s.dataDir(), err)
-	}
-	tempFile, err := ioutil.TempFile(s.dataDir(), "part_")
+	tempFile, err := s.createTempFile()
 	if err != nil {
-		return "", fmt.Errorf("failed to create a temporary file: %v", err)
+		return "", err
 	}
-	defer func() {
-		if tempFile != nil {
-			tempFile.Close()
-		}
-	}()
+	// the temporary file is kept open and thus locked till
+	// it's placed into the store
+	defer tempFile.Close()
 	if err := s.downloader.DownloadFile(ctx, ep, tempFile); err != nil {
 		tempFile.Close()
 		if err := os.Remove(tempFile.Name()); err != nil {
@@ -420,15 +637,10 @@ func (s *FileStore) PullImage(ctx context.Context, name string, translator Trans
 	if err != nil {
 		return "", err
 	}
-	if err := tempFile.Close(); err != nil {
-		return "", fmt.Errorf("closing %q: %v", tempFile.Name(), err)
-	}
-	fileName := tempFile.Name()
-	tempFile = nil
 	if specDigest != "" && d != specDigest {
 		return "", fmt.Errorf("image digest mismatch: %s instead of %s", d, specDigest)
 	}
-	if err := s.placeImage(fileName, d.Hex(), name); err != nil {
+	if err := s.placeImage(tempFile.Name(), d.Hex(), name); err != nil {
 		return "", err
 	}
 	named, err := reference.WithName(name)
@@ -446,7 +658,12 @@ func (s *FileStore) PullImage(ctx context.Context, name string, translator Trans
 func (s *FileStore) RemoveImage(name string) error {
 	s.Lock()
 	defer s.Unlock()
-	_, err := s.removeImageIfItsNotNeeded(name, "")
+	lf, err := s.lockDir()
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
+	_, err = s.removeImageIfItsNotNeeded(name, "")
 	return err
 }
 
@@ -454,6 +671,11 @@ func (s *FileStore) RemoveImage(name string) error {
 func (s *FileStore) GC() error {
 	s.Lock()
 	defer s.Unlock()
+	lf, err := s.lockDir()
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
 	imagesInUse, err := s.getImageHexDigestsInUse()
 	if err != nil {
 		return err
@@ -467,6 +689,20 @@ func (s *FileStore) GC() error {
 		if imagesInUse[filepath.Base(m)] {
 			continue
 		}
+		// don't remove the files that are being written,
+		// possibly by another process
+		if strings.HasPrefix(filepath.Base(m), tempFilePrefix) {
+			if s.activePulls > 0 {
+				continue
+			}
+			switch locked, err := isFileLocked(m); {
+			case err != nil && !os.IsNotExist(err):
+				glog.Warningf("GC: error checking the lock on %q: %v", m, err)
+				continue
+			case locked:
+				continue
+			}
+		}
 		glog.V(1).Infof("GC: removing unreferenced image file %q", m)
 		if err := os.Remove(m); err != nil {
 			glog.Warningf("GC: removing %q: %v", m, err)
@@ -547,6 +783,156 @@ func (s *FileStore) SetRefGetter(imageRefGetter RefGetter) {
 	s.refGetter = imageRefGetter
 }
 
+// SetPullLimit implements SetPullLimit method of Store interface.
+func (s *FileStore) SetPullLimit(limit int) {
+	s.Lock()
+	defer s.Unlock()
+	s.pullLimit = limit
+	s.wakePullWaiters()
+}
+
+// SetPullRateLimit implements SetPullRateLimit method of Store interface.
+// The burst size equals to the number of pulls per minute, i.e. the
+// pulls are only delayed after the first minute's worth of them.
+func (s *FileStore) SetPullRateLimit(pullsPerMinute int) {
+	s.Lock()
+	defer s.Unlock()
+	if pullsPerMinute == s.pullRate {
+		return
+	}
+	s.pullRate = pullsPerMinute
+	if pullsPerMinute <= 0 {
+		s.pullLimiter = nil
+		return
+	}
+	s.pullLimiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(pullsPerMinute)), pullsPerMinute)
+}
+
+// SetGCPolicy implements SetGCPolicy method of Store interface.
+func (s *FileStore) SetGCPolicy(policy GCPolicy) {
+	s.Lock()
+	defer s.Unlock()
+	s.gcPolicy = policy
+}
+
+func (s *FileStore) diskUsagePercent() (int, error) {
+	used, total, err := s.diskSpace(s.dir)
+	switch {
+	case err != nil:
+		return 0, fmt.Errorf("can't get disk space info for %q: %v", s.dir, err)
+	case total == 0:
+		return 0, fmt.Errorf("can't get disk space info for %q: zero filesystem size", s.dir)
+	default:
+		return int(used * 100 / total), nil
+	}
+}
+
+func (s *FileStore) isProtected(name string) bool {
+	for _, pattern := range s.gcPolicy.ProtectedImages {
+		if matched, err := filepath.Match(pattern, name); err != nil {
+			glog.Warningf("Image GC: bad protected image pattern %q: %v", pattern, err)
+		} else if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// removableImages returns the list of the images that can be
+// removed according to the GC policy, oldest ones first.
+func (s *FileStore) removableImages() ([]*Image, error) {
+	imagesInUse := make(map[string]bool)
+	if s.refGetter != nil {
+		refSet, err := s.refGetter()
+		if err != nil {
+			return nil, fmt.Errorf("error listing images in use: %v", err)
+		}
+		for spec, present := range refSet {
+			if d := GetHexDigest(spec); present && d != "" {
+				imagesInUse[d] = true
+			}
+		}
+	}
+
+	images, err := s.listImagesUnlocked("")
+	if err != nil {
+		return nil, err
+	}
+
+	var r []*Image
+	imageTimes := make(map[string]time.Time)
+	for _, img := range images {
+		hexDigest, err := img.hexDigest()
+		switch {
+		case err != nil:
+			glog.Warningf("Image GC: error calculating digest for image %q: %v", img.Name, err)
+			continue
+		case imagesInUse[hexDigest] || s.isProtected(img.Name):
+			continue
+		}
+		fi, err := os.Lstat(s.linkFileName(img.Name))
+		if err != nil {
+			glog.Warningf("Image GC: can't stat the link for image %q: %v", img.Name, err)
+			continue
+		}
+		if s.clock.Since(fi.ModTime()) < s.gcPolicy.MinAge {
+			continue
+		}
+		imageTimes[img.Name] = fi.ModTime()
+		r = append(r, img)
+	}
+	sort.SliceStable(r, func(i, j int) bool {
+		return imageTimes[r[i].Name].Before(imageTimes[r[j].Name])
+	})
+	return r, nil
+}
+
+// FreeDiskSpace implements FreeDiskSpace method of Store interface.
+func (s *FileStore) FreeDiskSpace() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.gcPolicy.HighWatermark <= 0 {
+		return nil
+	}
+	lf, err := s.lockDir()
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
+
+	usage, err := s.diskUsagePercent()
+	if err != nil {
+		return err
+	}
+	if usage < s.gcPolicy.HighWatermark {
+		return nil
+	}
+	glog.V(1).Infof("Image GC: disk usage %d%% exceeds the high watermark %d%%", usage, s.gcPolicy.HighWatermark)
+
+	images, err := s.removableImages()
+	if err != nil {
+		return err
+	}
+	for _, img := range images {
+		if usage <= s.gcPolicy.LowWatermark {
+			break
+		}
+		glog.V(1).Infof("Image GC: removing image %q", img.Name)
+		if _, err := s.removeImageIfItsNotNeeded(img.Name, ""); err != nil {
+			glog.Warningf("Image GC: error removing image %q: %v", img.Name, err)
+			continue
+		}
+		if usage, err = s.diskUsagePercent(); err != nil {
+			return err
+		}
+	}
+
+	if usage > s.gcPolicy.LowWatermark {
+		glog.Warningf("Image GC: couldn't reduce the disk usage below the low watermark %d%%, %d%% used", s.gcPolicy.LowWatermark, usage)
+	}
+	return nil
+}
+
 // SplitImageName parses image nmae and returns the name sans tag and
 // the digest, if any.
 func SplitImageName(imageName string) (string, digest.Digest) {
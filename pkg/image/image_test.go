@@ -29,8 +29,10 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/jonboulle/clockwork"
 )
 
 func sha256str(s string) string {
@@ -276,7 +278,7 @@ func TestPullListStatus(t *testing.T) {
 		tst.pullImage(tst.images[0].Name, tst.refs[0])
 		tst.verifyListImages("foobar")
 		tst.verifyImageStatus("foobar", nil)
-		tst.verifyListImages("", tst.images[0])
+		tst.verifyListImages("", tst.images[1], tst.images[0]) // alphabetically sorted by name
 		tst.verifyListImages(tst.images[0].Name, tst.images[0])
 		tst.verifySubpathContents("links/example.com:1234%foo%bar", "###example.com:1234/foo/bar")
 		tst.verifyImage(tst.refs[0], "###example.com:1234/foo/bar")
@@ -407,7 +409,7 @@ func TestRemoveImage(t *testing.T) {
 	if err := tst.store.RemoveImage(tst.images[2].Name); err != nil {
 		t.Errorf("RemoveImage(): %v", err)
 	}
-	tst.verifyListImages("", tst.images[0]) // alphabetically sorted by name
+	tst.verifyListImages("", tst.images[1], tst.images[0]) // alphabetically sorted by name // alphabetically sorted by name
 	tst.verifySubpathContents("links/example.com:1234%foo%bar", "###example.com:1234/foo/bar")
 
 	tst.referencedImages = []string{tst.images[0].Digest}
@@ -418,6 +420,34 @@ func TestRemoveImage(t *testing.T) {
 	tst.verifyDataFiles(sha256str("###example.com:1234/foo/bar"))
 }
 
+func TestListImagesDuringWrites(t *testing.T) {
+	tst := newIfsTester(t)
+	defer tst.teardown()
+	tst.pullAllImages()
+
+	// the listing must not wait for the writes that hold
+	// the store mutex
+	tst.store.Lock()
+	done := make(chan struct{})
+	go func() {
+		tst.verifyListImages("", tst.images[1], tst.images[0], tst.images[2])
+		tst.verifyImageStatus(tst.images[0].Name, tst.images[0])
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("the listing is blocked by the store mutex")
+	}
+	tst.store.Unlock()
+	<-done
+
+	// an image being removed is skipped
+	tst.removeFile("data/" + sha256str("###example.com:1234/foo/bar"))
+	tst.verifyListImages("", tst.images[1], tst.images[2])
+	tst.verifyImageStatus(tst.images[0].Name, nil)
+}
+
 func TestImageGC(t *testing.T) {
 	tst := newIfsTester(t)
 	defer tst.teardown()
@@ -443,7 +473,7 @@ func TestImageGC(t *testing.T) {
 	tst.referencedImages = []string{tst.images[1].Digest}
 	tst.removeFile("links/example.com:1234%foo%bar")
 	tst.store.GC()
-	tst.verifyListImages("", tst.images[2])
+	tst.verifyListImages("", tst.images[1], tst.images[0]) // alphabetically sorted by name
 	tst.verifyImage(tst.refs[2], "###baz")
 	tst.verifyDataFiles(sha256str("###baz"))
 
@@ -462,6 +492,37 @@ func TestImageGC(t *testing.T) {
 	tst.verifyDataFiles()
 }
 
+func TestImageGCKeepsLockedTempFiles(t *testing.T) {
+	tst := newIfsTester(t)
+	defer tst.teardown()
+	tst.pullAllImages()
+
+	// the temporary file that's being written by another
+	// process or goroutine
+	tempFile, err := tst.store.createTempFile()
+	if err != nil {
+		t.Fatalf("createTempFile(): %v", err)
+	}
+	defer tempFile.Close()
+	// a leftover temporary file
+	staleFileName := filepath.Join(tst.tmpDir, "data/part_stale")
+	if err := ioutil.WriteFile(staleFileName, []byte("4"), 0666); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+
+	if err := tst.store.GC(); err != nil {
+		t.Errorf("GC(): %v", err)
+	}
+	tst.verifyListImages("", tst.images[1], tst.images[0], tst.images[2])
+	tst.verifyDataFiles(sha256str("###example.com:1234/foo/bar"), sha256str("###baz"), filepath.Base(tempFile.Name()))
+
+	tempFile.Close()
+	if err := tst.store.GC(); err != nil {
+		t.Errorf("GC(): %v", err)
+	}
+	tst.verifyDataFiles(sha256str("###example.com:1234/foo/bar"), sha256str("###baz"))
+}
+
 func TestCancelPullImage(t *testing.T) {
 	tst := newIfsTester(t)
 	defer tst.teardown()
@@ -483,6 +544,124 @@ func TestCancelPullImage(t *testing.T) {
 	}
 }
 
+func TestPullLimit(t *testing.T) {
+	tst := newIfsTester(t)
+	defer tst.teardown()
+	tst.store.SetPullLimit(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	firstDone := make(chan struct{})
+	go func() {
+		tst.store.PullImage(ctx, "cancelme", tst.translateImageName)
+		close(firstDone)
+	}()
+	<-tst.downloader.started
+
+	// the partially downloaded file must survive GC
+	if err := tst.store.GC(); err != nil {
+		t.Errorf("GC(): %v", err)
+	}
+	if items, err := filepath.Glob(filepath.Join(tst.tmpDir, "data/part_*")); err != nil {
+		t.Fatalf("Glob(): %v", err)
+	} else if len(items) != 1 {
+		t.Errorf("expected exactly one partially downloaded file, got: %v", items)
+	}
+
+	secondDone := make(chan struct{})
+	go func() {
+		tst.pullImage(tst.images[1].Name, tst.refs[1])
+		close(secondDone)
+	}()
+	select {
+	case <-tst.downloader.started:
+		t.Errorf("the second pull wasn't delayed by the pull limit")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the pull that's waiting for the limit can be cancelled
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer waitCancel()
+	if _, err := tst.store.PullImage(waitCtx, tst.images[0].Name, tst.translateImageName); err == nil || !strings.Contains(err.Error(), "context deadline exceeded") {
+		t.Errorf("PullImage() waiting for the pull limit is expected to fail upon the context timeout, but returned %v", err)
+	}
+
+	cancel()
+	<-firstDone
+	<-secondDone
+	tst.verifyListImages("", tst.images[1], tst.images[0]) // alphabetically sorted by name
+}
+
+func TestPullRateLimit(t *testing.T) {
+	tst := newIfsTester(t)
+	defer tst.teardown()
+	tst.store.SetPullRateLimit(1)
+	tst.pullImage(tst.images[0].Name, tst.refs[0])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := tst.store.PullImage(ctx, tst.images[1].Name, tst.translateImageName); err == nil {
+		t.Errorf("the second pull wasn't delayed by the pull rate limit")
+	}
+	tst.verifyListImages("", tst.images[1], tst.images[0]) // alphabetically sorted by name
+
+	// the rate limit can be lifted on the fly
+	tst.store.SetPullRateLimit(0)
+	tst.pullImage(tst.images[1].Name, tst.refs[1])
+	tst.verifyListImages("", tst.images[1], tst.images[0]) // alphabetically sorted by name
+}
+
+func TestFreeDiskSpace(t *testing.T) {
+	tst := newIfsTester(t)
+	defer tst.teardown()
+	tst.pullAllImages()
+
+	// each data file takes 20% of the disk space, with 50% used by
+	// other files
+	tst.store.diskSpace = func(path string) (uint64, uint64, error) {
+		items, err := filepath.Glob(filepath.Join(tst.tmpDir, "data/*"))
+		if err != nil {
+			t.Fatalf("Glob(): %v", err)
+		}
+		return uint64(50 + 20*len(items)), 100, nil
+	}
+	clock := clockwork.NewFakeClockAt(time.Now())
+	tst.store.clock = clock
+	policy := GCPolicy{
+		MinAge:          time.Hour,
+		ProtectedImages: []string{"example.com:1234/foo/*"},
+		HighWatermark:   95,
+		LowWatermark:    75,
+	}
+	freeDiskSpace := func() {
+		tst.store.SetGCPolicy(policy)
+		if err := tst.store.FreeDiskSpace(); err != nil {
+			t.Fatalf("FreeDiskSpace(): %v", err)
+		}
+	}
+
+	// the disk usage is below the high watermark
+	freeDiskSpace()
+	tst.verifyListImages("", tst.images[1], tst.images[0], tst.images[2])
+
+	// the images are too new to be removed
+	policy.HighWatermark = 85
+	freeDiskSpace()
+	tst.verifyListImages("", tst.images[1], tst.images[0], tst.images[2])
+
+	// the images that are in use are never removed
+	clock.Advance(2 * time.Hour)
+	tst.referencedImages = []string{tst.refs[1]}
+	freeDiskSpace()
+	tst.verifyListImages("", tst.images[1], tst.images[0], tst.images[2])
+
+	// the unused images are removed till the disk usage drops
+	// below the low watermark, but the protected ones are kept
+	tst.referencedImages = nil
+	freeDiskSpace()
+	tst.verifyListImages("", tst.images[1], tst.images[0]) // alphabetically sorted by name
+	tst.verifyDataFiles(sha256str("###example.com:1234/foo/bar"))
+}
+
 func TestVerifyImageChecksum(t *testing.T) {
 	tst := newIfsTester(t)
 	defer tst.teardown()
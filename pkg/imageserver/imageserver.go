@@ -0,0 +1,156 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imageserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/Mirantis/virtlet/pkg/image"
+)
+
+const (
+	// ImagePathPrefix is the prefix of the URL paths
+	// used to download the images from the image server.
+	ImagePathPrefix = "/images/"
+	// DigestHeader is the name of the HTTP header that
+	// holds the digest of the image being downloaded.
+	DigestHeader = "X-Image-Digest"
+)
+
+// ImageInfo describes an image served by the image server.
+type ImageInfo struct {
+	// Name is the name of the image.
+	Name string `json:"name"`
+	// Digest is the digest of the image data.
+	Digest string `json:"digest"`
+	// Size is the size of the image data in bytes.
+	Size uint64 `json:"size"`
+	// Path is the URL path of the image on the server.
+	Path string `json:"path"`
+}
+
+// Handler serves the images from an image store over HTTP.
+// GET /images/ returns the list of images as JSON, and
+// GET /images/<name> returns the image data. The image name may
+// include the digest, e.g. /images/cirros@sha256:<hex>, in which
+// case the digest is verified. Range requests are supported so
+// the interrupted downloads can be resumed.
+type Handler struct {
+	store image.Store
+}
+
+var _ http.Handler = &Handler{}
+
+// NewHandler returns a new Handler that serves the images
+// from the specified store.
+func NewHandler(store image.Store) *Handler {
+	return &Handler{store: store}
+}
+
+// ServeHTTP implements ServeHTTP method of http.Handler interface.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, ImagePathPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+	switch name := r.URL.Path[len(ImagePathPrefix):]; name {
+	case "":
+		h.serveList(w)
+	case ".", "..":
+		http.NotFound(w, r)
+	default:
+		h.serveImage(w, r, name)
+	}
+}
+
+// ListImages returns the descriptions of the images that are
+// served by the image server.
+func (h *Handler) ListImages() ([]ImageInfo, error) {
+	images, err := h.store.ListImages("")
+	if err != nil {
+		return nil, err
+	}
+	r := []ImageInfo{}
+	for _, img := range images {
+		r = append(r, ImageInfo{
+			Name:   img.Name,
+			Digest: img.Digest,
+			Size:   img.Size,
+			Path:   ImagePathPrefix + img.Name,
+		})
+	}
+	return r, nil
+}
+
+func (h *Handler) serveList(w http.ResponseWriter) {
+	images, err := h.ListImages()
+	if err != nil {
+		glog.Errorf("Error listing the images: %v", err)
+		http.Error(w, "error listing the images", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(images); err != nil {
+		glog.Warningf("Error writing the image list: %v", err)
+	}
+}
+
+func (h *Handler) serveImage(w http.ResponseWriter, r *http.Request, name string) {
+	img, err := h.store.ImageStatus(name)
+	switch {
+	case err != nil:
+		glog.Warningf("Error getting the status of image %q: %v", name, err)
+		http.Error(w, fmt.Sprintf("bad image %q", name), http.StatusBadRequest)
+		return
+	case img == nil:
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(img.Path)
+	if err != nil {
+		glog.Errorf("Error opening the data file of image %q: %v", name, err)
+		http.Error(w, "error reading the image", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		glog.Errorf("Error reading the data file of image %q: %v", name, err)
+		http.Error(w, "error reading the image", http.StatusInternalServerError)
+		return
+	}
+
+	glog.V(2).Infof("Serving image %q (%s)", name, img.Digest)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set(DigestHeader, img.Digest)
+	// the data files are named after their digests and never
+	// change, so the digest can be used as a strong ETag which
+	// makes it possible to resume the downloads safely
+	w.Header().Set("ETag", fmt.Sprintf("%q", img.Digest))
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
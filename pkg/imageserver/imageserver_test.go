@@ -0,0 +1,199 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package imageserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/image"
+)
+
+const (
+	cirrosDigest = "sha256:a6a4ccbc1e8b8efb7df5b8c1b79d5b9bd7b56e6eb5b6bff7e3e0d1c7e2c7c0d1"
+	cirrosData   = "cirros image data"
+)
+
+// fakeStore is an image store that only implements the
+// methods used by the image server.
+type fakeStore struct {
+	image.Store
+	images []*image.Image
+}
+
+func (s *fakeStore) ListImages(filter string) ([]*image.Image, error) {
+	return s.images, nil
+}
+
+func (s *fakeStore) ImageStatus(name string) (*image.Image, error) {
+	name, d := image.SplitImageName(name)
+	for _, img := range s.images {
+		if img.Name != name {
+			continue
+		}
+		if d != "" && string(d) != img.Digest {
+			return nil, fmt.Errorf("image digest mismatch: %s instead of %s", img.Digest, d)
+		}
+		return img, nil
+	}
+	return nil, nil
+}
+
+func setupServer(t *testing.T) (*httptest.Server, func()) {
+	tmpDir, err := ioutil.TempDir("", "imageserver")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	dataPath := filepath.Join(tmpDir, "cirros")
+	if err := ioutil.WriteFile(dataPath, []byte(cirrosData), 0644); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	ts := httptest.NewServer(NewHandler(&fakeStore{
+		images: []*image.Image{
+			{
+				Name:   "cirros",
+				Digest: cirrosDigest,
+				Path:   dataPath,
+				Size:   uint64(len(cirrosData)),
+			},
+		},
+	}))
+	return ts, func() {
+		ts.Close()
+		os.RemoveAll(tmpDir)
+	}
+}
+
+func get(t *testing.T, url string, header http.Header) (*http.Response, string) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatalf("NewRequest(): %v", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading the response body: %v", err)
+	}
+	return resp, string(body)
+}
+
+func TestListImages(t *testing.T) {
+	ts, teardown := setupServer(t)
+	defer teardown()
+
+	resp, body := get(t, ts.URL+"/images/", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("bad http status %q", resp.Status)
+	}
+	var images []ImageInfo
+	if err := json.Unmarshal([]byte(body), &images); err != nil {
+		t.Fatalf("error unmarshalling the image list: %v", err)
+	}
+	expectedImages := []ImageInfo{
+		{
+			Name:   "cirros",
+			Digest: cirrosDigest,
+			Size:   uint64(len(cirrosData)),
+			Path:   "/images/cirros",
+		},
+	}
+	if !reflect.DeepEqual(images, expectedImages) {
+		t.Errorf("bad image list: %#v instead of %#v", images, expectedImages)
+	}
+}
+
+func TestServeImage(t *testing.T) {
+	ts, teardown := setupServer(t)
+	defer teardown()
+
+	for _, tc := range []struct {
+		name           string
+		path           string
+		header         http.Header
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "plain",
+			path:           "/images/cirros",
+			expectedStatus: http.StatusOK,
+			expectedBody:   cirrosData,
+		},
+		{
+			name:           "with digest",
+			path:           "/images/cirros@" + cirrosDigest,
+			expectedStatus: http.StatusOK,
+			expectedBody:   cirrosData,
+		},
+		{
+			name:           "range",
+			path:           "/images/cirros",
+			header:         http.Header{"Range": {"bytes=7-"}},
+			expectedStatus: http.StatusPartialContent,
+			expectedBody:   cirrosData[7:],
+		},
+		{
+			name:           "digest mismatch",
+			path:           "/images/cirros@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "nonexistent image",
+			path:           "/images/fedora",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "bad path",
+			path:           "/foo/cirros",
+			expectedStatus: http.StatusNotFound,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, body := get(t, ts.URL+tc.path, tc.header)
+			if resp.StatusCode != tc.expectedStatus {
+				t.Fatalf("bad http status %q", resp.Status)
+			}
+			if tc.expectedBody == "" {
+				return
+			}
+			if body != tc.expectedBody {
+				t.Errorf("bad body: %q instead of %q", body, tc.expectedBody)
+			}
+			if d := resp.Header.Get(DigestHeader); d != cirrosDigest {
+				t.Errorf("bad digest header: %q", d)
+			}
+			if etag := resp.Header.Get("ETag"); !strings.Contains(etag, cirrosDigest) {
+				t.Errorf("bad ETag: %q", etag)
+			}
+		})
+	}
+}
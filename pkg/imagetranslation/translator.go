@@ -126,6 +126,11 @@ func convertEndpoint(rule v1.TranslationRule, config *v1.ImageTranslation) image
 		}
 	}
 
+	var urlRefresher image.URLRefresher
+	if profile.URLRefreshService != "" {
+		urlRefresher = image.NewHTTPURLRefresher(profile.URLRefreshService)
+	}
+
 	return image.Endpoint{
 		URL:          rule.URL,
 		Timeout:      time.Millisecond * time.Duration(profile.TimeoutMilliseconds),
@@ -133,6 +138,8 @@ func convertEndpoint(rule v1.TranslationRule, config *v1.ImageTranslation) image
 		ProfileName:  rule.Transport,
 		MaxRedirects: maxRedirects,
 		TLS:          tlsConfig,
+		MaxSize:      profile.MaxSize,
+		URLRefresher: urlRefresher,
 	}
 }
 
@@ -0,0 +1,246 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kvmcheck
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	emulatorUserName = "libvirt-qemu"
+	kvmGroupName     = "kvm"
+	// KVM_API_VERSION from linux/kvm.h
+	expectedKVMAPIVersion = 12
+)
+
+// Finding describes the result of a single check.
+type Finding struct {
+	// Name is the name of the check.
+	Name string
+	// OK is true if the check has passed.
+	OK bool
+	// Message describes the result of the check.
+	Message string
+}
+
+// Report contains the results of KVM usability checks.
+type Report struct {
+	// Usable is true if the VMs can be run using KVM on the node.
+	Usable bool
+	// Nested is true if Virtlet runs inside a VM.
+	Nested bool
+	// Findings contains the results of individual checks.
+	Findings []Finding
+}
+
+func (r *Report) add(name string, ok bool, format string, args ...interface{}) bool {
+	r.Findings = append(r.Findings, Finding{Name: name, OK: ok, Message: fmt.Sprintf(format, args...)})
+	if !ok {
+		r.Usable = false
+	}
+	return ok
+}
+
+// Problems returns a brief description of the failed checks.
+func (r *Report) Problems() string {
+	var problems []string
+	for _, f := range r.Findings {
+		if !f.OK {
+			problems = append(problems, f.Message)
+		}
+	}
+	return strings.Join(problems, "; ")
+}
+
+// String returns a human-readable representation of the report.
+func (r *Report) String() string {
+	var buf bytes.Buffer
+	if r.Usable {
+		buf.WriteString("KVM is usable\n")
+	} else {
+		buf.WriteString("KVM is NOT usable\n")
+	}
+	for _, f := range r.Findings {
+		status := "OK"
+		if !f.OK {
+			status = "FAIL"
+		}
+		fmt.Fprintf(&buf, "[%s] %s: %s\n", status, f.Name, f.Message)
+	}
+	return buf.String()
+}
+
+// Checker checks whether KVM can be used on the node.
+type Checker struct {
+	procDir string
+	sysDir  string
+	devKVM  string
+	// stat returns the mode, the uid and the gid of the file
+	stat func(path string) (os.FileMode, int, int, error)
+	// apiVersion returns the version of KVM API reported by the device
+	apiVersion func(path string) (int, error)
+	// emulatorIDs returns the uid of the emulator user and
+	// the gid of kvm group
+	emulatorIDs func() (int, int, error)
+}
+
+// NewChecker returns a Checker for the current node.
+func NewChecker() *Checker {
+	return &Checker{
+		procDir:     "/proc",
+		sysDir:      "/sys",
+		devKVM:      "/dev/kvm",
+		stat:        statFile,
+		apiVersion:  kvmAPIVersion,
+		emulatorIDs: emulatorIDs,
+	}
+}
+
+func emulatorIDs() (int, int, error) {
+	u, err := user.Lookup(emulatorUserName)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't find user %q: %v", emulatorUserName, err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad uid %q for user %q: %v", u.Uid, emulatorUserName, err)
+	}
+	g, err := user.LookupGroup(kvmGroupName)
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't find group %q: %v", kvmGroupName, err)
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad gid %q for group %q: %v", g.Gid, kvmGroupName, err)
+	}
+	return uid, gid, nil
+}
+
+func (c *Checker) cpuFlags() (map[string]bool, error) {
+	f, err := os.Open(filepath.Join(c.procDir, "cpuinfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	flags := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != "flags" {
+			continue
+		}
+		for _, flag := range strings.Fields(parts[1]) {
+			flags[flag] = true
+		}
+		// the flags are the same for all the CPUs
+		break
+	}
+	return flags, scanner.Err()
+}
+
+func (c *Checker) moduleLoaded(name string) bool {
+	_, err := os.Stat(filepath.Join(c.sysDir, "module", name))
+	return err == nil
+}
+
+// Check runs KVM usability checks and returns a report.
+func (c *Checker) Check() *Report {
+	r := &Report{Usable: true}
+
+	flags, err := c.cpuFlags()
+	if err != nil {
+		r.add("cpu", false, "can't read CPU flags: %v", err)
+		return r
+	}
+	var vendorModule string
+	switch {
+	case flags["vmx"]:
+		vendorModule = "kvm_intel"
+		r.add("cpu", true, "the CPU supports Intel VT-x (vmx)")
+	case flags["svm"]:
+		vendorModule = "kvm_amd"
+		r.add("cpu", true, "the CPU supports AMD-V (svm)")
+	default:
+		r.add("cpu", false, "the CPU doesn't report hardware virtualization support (neither vmx nor svm flag is present)")
+	}
+	if flags["hypervisor"] {
+		r.Nested = true
+		if vendorModule == "" {
+			r.add("nested", false, "the node is a VM without nested virtualization support, enable nested virtualization on the host (e.g. modprobe kvm_intel nested=1) or use TCG")
+		} else {
+			r.add("nested", true, "the node is a VM with nested virtualization support")
+		}
+	}
+	if vendorModule == "" {
+		return r
+	}
+
+	switch {
+	case !c.moduleLoaded("kvm"):
+		r.add("modules", false, "kvm kernel module is not loaded")
+	case !c.moduleLoaded(vendorModule):
+		r.add("modules", false, "%s kernel module is not loaded (virtualization may be disabled in BIOS)", vendorModule)
+	default:
+		r.add("modules", true, "kvm and %s kernel modules are loaded", vendorModule)
+	}
+
+	mode, uid, gid, err := c.stat(c.devKVM)
+	switch {
+	case os.IsNotExist(err):
+		r.add("device", false, "%s doesn't exist", c.devKVM)
+		return r
+	case err != nil:
+		r.add("device", false, "can't stat %s: %v", c.devKVM, err)
+		return r
+	case mode&os.ModeCharDevice == 0:
+		r.add("device", false, "%s is not a character device", c.devKVM)
+		return r
+	}
+	r.add("device", true, "%s is present", c.devKVM)
+
+	emulatorUID, kvmGID, err := c.emulatorIDs()
+	perm := mode.Perm()
+	switch {
+	case err != nil:
+		r.add("permissions", false, "can't check the permissions of %s: %v", c.devKVM, err)
+	case perm&0006 == 0006,
+		uid == emulatorUID && perm&0600 == 0600,
+		gid == kvmGID && perm&0060 == 0060:
+		r.add("permissions", true, "%s (owner %d:%d, mode %04o) is accessible by %s user", c.devKVM, uid, gid, perm, emulatorUserName)
+	default:
+		r.add("permissions", false, "%s (owner %d:%d, mode %04o) is not accessible by %s user, it must be owned by %s group and be group-writable", c.devKVM, uid, gid, perm, emulatorUserName, kvmGroupName)
+	}
+
+	version, err := c.apiVersion(c.devKVM)
+	switch {
+	case err != nil:
+		r.add("api", false, "can't query KVM API version: %v", err)
+	case version != expectedKVMAPIVersion:
+		r.add("api", false, "unsupported KVM API version %d (expected %d)", version, expectedKVMAPIVersion)
+	default:
+		r.add("api", true, "KVM API version %d", version)
+	}
+
+	return r
+}
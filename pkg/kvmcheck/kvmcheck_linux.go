@@ -0,0 +1,54 @@
+// +build linux
+
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kvmcheck
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	// KVM_GET_API_VERSION from linux/kvm.h
+	kvmGetAPIVersion = 0xae00
+)
+
+func statFile(path string) (os.FileMode, int, int, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fi.Mode(), -1, -1, nil
+	}
+	return fi.Mode(), int(st.Uid), int(st.Gid), nil
+}
+
+func kvmAPIVersion(path string) (int, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), kvmGetAPIVersion, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
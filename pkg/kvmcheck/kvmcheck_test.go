@@ -0,0 +1,330 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kvmcheck
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakekube "k8s.io/client-go/kubernetes/fake"
+	testcore "k8s.io/client-go/testing"
+)
+
+const (
+	intelCPUInfo  = "processor\t: 0\nvendor_id\t: GenuineIntel\nflags\t\t: fpu vme de pse vmx sse sse2\n\nprocessor\t: 1\nflags\t\t: fpu vme de pse vmx sse sse2\n"
+	amdCPUInfo    = "processor\t: 0\nvendor_id\t: AuthenticAMD\nflags\t\t: fpu vme de pse svm sse sse2\n"
+	nestedCPUInfo = "processor\t: 0\nvendor_id\t: GenuineIntel\nflags\t\t: fpu vme de pse vmx sse sse2 hypervisor\n"
+	noVirtCPUInfo = "processor\t: 0\nvendor_id\t: GenuineIntel\nflags\t\t: fpu vme de pse sse sse2 hypervisor\n"
+	emulatorUID   = 64055
+	kvmGID        = 108
+)
+
+func TestCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		cpuInfo          string
+		modules          []string
+		noDevice         bool
+		mode             os.FileMode
+		uid, gid         int
+		apiVersion       int
+		apiErr           error
+		expectedUsable   bool
+		expectedNested   bool
+		expectedFindings []Finding
+	}{
+		{
+			name:           "usable (intel)",
+			cpuInfo:        intelCPUInfo,
+			modules:        []string{"kvm", "kvm_intel"},
+			mode:           0660,
+			gid:            kvmGID,
+			apiVersion:     12,
+			expectedUsable: true,
+			expectedFindings: []Finding{
+				{"cpu", true, "the CPU supports Intel VT-x (vmx)"},
+				{"modules", true, "kvm and kvm_intel kernel modules are loaded"},
+				{"device", true, "/dev/kvm is present"},
+				{"permissions", true, "/dev/kvm (owner 0:108, mode 0660) is accessible by libvirt-qemu user"},
+				{"api", true, "KVM API version 12"},
+			},
+		},
+		{
+			name:           "usable (amd, world-writable device)",
+			cpuInfo:        amdCPUInfo,
+			modules:        []string{"kvm", "kvm_amd"},
+			mode:           0666,
+			apiVersion:     12,
+			expectedUsable: true,
+			expectedFindings: []Finding{
+				{"cpu", true, "the CPU supports AMD-V (svm)"},
+				{"modules", true, "kvm and kvm_amd kernel modules are loaded"},
+				{"device", true, "/dev/kvm is present"},
+				{"permissions", true, "/dev/kvm (owner 0:0, mode 0666) is accessible by libvirt-qemu user"},
+				{"api", true, "KVM API version 12"},
+			},
+		},
+		{
+			name:           "nested virtualization",
+			cpuInfo:        nestedCPUInfo,
+			modules:        []string{"kvm", "kvm_intel"},
+			mode:           0600,
+			uid:            emulatorUID,
+			apiVersion:     12,
+			expectedUsable: true,
+			expectedNested: true,
+			expectedFindings: []Finding{
+				{"cpu", true, "the CPU supports Intel VT-x (vmx)"},
+				{"nested", true, "the node is a VM with nested virtualization support"},
+				{"modules", true, "kvm and kvm_intel kernel modules are loaded"},
+				{"device", true, "/dev/kvm is present"},
+				{"permissions", true, "/dev/kvm (owner 64055:0, mode 0600) is accessible by libvirt-qemu user"},
+				{"api", true, "KVM API version 12"},
+			},
+		},
+		{
+			name:           "VM without nested virtualization",
+			cpuInfo:        noVirtCPUInfo,
+			expectedNested: true,
+			expectedFindings: []Finding{
+				{"cpu", false, "the CPU doesn't report hardware virtualization support (neither vmx nor svm flag is present)"},
+				{"nested", false, "the node is a VM without nested virtualization support, enable nested virtualization on the host (e.g. modprobe kvm_intel nested=1) or use TCG"},
+			},
+		},
+		{
+			name:    "vendor module not loaded",
+			cpuInfo: intelCPUInfo,
+			modules: []string{"kvm"},
+			mode:    0660,
+			gid:     kvmGID,
+			// the device may still be present
+			apiVersion: 12,
+			expectedFindings: []Finding{
+				{"cpu", true, "the CPU supports Intel VT-x (vmx)"},
+				{"modules", false, "kvm_intel kernel module is not loaded (virtualization may be disabled in BIOS)"},
+				{"device", true, "/dev/kvm is present"},
+				{"permissions", true, "/dev/kvm (owner 0:108, mode 0660) is accessible by libvirt-qemu user"},
+				{"api", true, "KVM API version 12"},
+			},
+		},
+		{
+			name:     "no device",
+			cpuInfo:  intelCPUInfo,
+			modules:  []string{"kvm", "kvm_intel"},
+			noDevice: true,
+			expectedFindings: []Finding{
+				{"cpu", true, "the CPU supports Intel VT-x (vmx)"},
+				{"modules", true, "kvm and kvm_intel kernel modules are loaded"},
+				{"device", false, "/dev/kvm doesn't exist"},
+			},
+		},
+		{
+			name:       "bad permissions and API error",
+			cpuInfo:    intelCPUInfo,
+			modules:    []string{"kvm", "kvm_intel"},
+			mode:       0600,
+			apiErr:     errors.New("permission denied"),
+			apiVersion: 12,
+			expectedFindings: []Finding{
+				{"cpu", true, "the CPU supports Intel VT-x (vmx)"},
+				{"modules", true, "kvm and kvm_intel kernel modules are loaded"},
+				{"device", true, "/dev/kvm is present"},
+				{"permissions", false, "/dev/kvm (owner 0:0, mode 0600) is not accessible by libvirt-qemu user, it must be owned by kvm group and be group-writable"},
+				{"api", false, "can't query KVM API version: permission denied"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, err := ioutil.TempDir("", "kvmcheck-test")
+			if err != nil {
+				t.Fatalf("TempDir(): %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			procDir := filepath.Join(tmpDir, "proc")
+			if err := os.MkdirAll(procDir, 0755); err != nil {
+				t.Fatalf("MkdirAll(): %v", err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(procDir, "cpuinfo"), []byte(tc.cpuInfo), 0644); err != nil {
+				t.Fatalf("WriteFile(): %v", err)
+			}
+			sysDir := filepath.Join(tmpDir, "sys")
+			for _, m := range tc.modules {
+				if err := os.MkdirAll(filepath.Join(sysDir, "module", m), 0755); err != nil {
+					t.Fatalf("MkdirAll(): %v", err)
+				}
+			}
+
+			c := &Checker{
+				procDir: procDir,
+				sysDir:  sysDir,
+				devKVM:  "/dev/kvm",
+				stat: func(path string) (os.FileMode, int, int, error) {
+					if path != "/dev/kvm" {
+						t.Errorf("stat() called for a wrong path %q", path)
+					}
+					if tc.noDevice {
+						return 0, 0, 0, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+					}
+					return os.ModeDevice | os.ModeCharDevice | tc.mode, tc.uid, tc.gid, nil
+				},
+				apiVersion: func(path string) (int, error) {
+					if tc.apiErr != nil {
+						return 0, tc.apiErr
+					}
+					return tc.apiVersion, nil
+				},
+				emulatorIDs: func() (int, int, error) {
+					return emulatorUID, kvmGID, nil
+				},
+			}
+			r := c.Check()
+			if r.Usable != tc.expectedUsable {
+				t.Errorf("bad Usable value %v instead of %v", r.Usable, tc.expectedUsable)
+			}
+			if r.Nested != tc.expectedNested {
+				t.Errorf("bad Nested value %v instead of %v", r.Nested, tc.expectedNested)
+			}
+			if !reflect.DeepEqual(r.Findings, tc.expectedFindings) {
+				t.Errorf("bad findings:\n%#v\ninstead of\n%#v", r.Findings, tc.expectedFindings)
+			}
+		})
+	}
+}
+
+func TestNodeReporter(t *testing.T) {
+	kubeClient := fakekube.NewSimpleClientset(&v1.Node{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "kube-node-1"},
+	})
+	r := NewNodeReporter(nil, "kube-node-1")
+	r.kubeClient = kubeClient
+	ts := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return ts }
+
+	usable := &Report{Usable: true}
+	notUsable := &Report{
+		Findings: []Finding{
+			{"cpu", true, "the CPU supports Intel VT-x (vmx)"},
+			{"device", false, "/dev/kvm doesn't exist"},
+		},
+	}
+	for _, step := range []struct {
+		report          *Report
+		kvmDisabled     bool
+		expectedStatus  v1.ConditionStatus
+		expectedReason  string
+		expectedMessage string
+		expectEvent     bool
+		expectedEvType  string
+	}{
+		{
+			report:          usable,
+			expectedStatus:  v1.ConditionFalse,
+			expectedReason:  ReasonKVMUsable,
+			expectedMessage: "KVM is usable",
+			expectEvent:     true,
+			expectedEvType:  v1.EventTypeNormal,
+		},
+		{
+			// no event is recorded if nothing changes
+			report:          usable,
+			expectedStatus:  v1.ConditionFalse,
+			expectedReason:  ReasonKVMUsable,
+			expectedMessage: "KVM is usable",
+		},
+		{
+			report:          notUsable,
+			expectedStatus:  v1.ConditionTrue,
+			expectedReason:  ReasonKVMNotUsable,
+			expectedMessage: "/dev/kvm doesn't exist",
+			expectEvent:     true,
+			expectedEvType:  v1.EventTypeWarning,
+		},
+		{
+			report:          notUsable,
+			kvmDisabled:     true,
+			expectedStatus:  v1.ConditionTrue,
+			expectedReason:  ReasonUsingTCG,
+			expectedMessage: "/dev/kvm doesn't exist",
+			expectEvent:     true,
+			expectedEvType:  v1.EventTypeWarning,
+		},
+		{
+			report:          usable,
+			kvmDisabled:     true,
+			expectedStatus:  v1.ConditionTrue,
+			expectedReason:  ReasonKVMDisabled,
+			expectedMessage: "KVM is disabled in Virtlet config",
+			expectEvent:     true,
+			expectedEvType:  v1.EventTypeNormal,
+		},
+	} {
+		kubeClient.ClearActions()
+		if err := r.Report(step.report, step.kvmDisabled); err != nil {
+			t.Fatalf("Report(): %v", err)
+		}
+		var condition *v1.NodeCondition
+		var event *v1.Event
+		for _, a := range kubeClient.Actions() {
+			switch action := a.(type) {
+			case testcore.PatchAction:
+				if action.GetResource().Resource != "nodes" || action.GetSubresource() != "status" {
+					t.Errorf("unexpected patch action: %#v", action)
+					continue
+				}
+				var patch struct {
+					Status struct {
+						Conditions []v1.NodeCondition `json:"conditions"`
+					} `json:"status"`
+				}
+				if err := json.Unmarshal(action.GetPatch(), &patch); err != nil {
+					t.Fatalf("error unmarshalling the patch: %v", err)
+				}
+				if len(patch.Status.Conditions) != 1 {
+					t.Fatalf("expected exactly one condition in the patch, got %#v", patch.Status.Conditions)
+				}
+				condition = &patch.Status.Conditions[0]
+			case testcore.CreateAction:
+				event = action.GetObject().(*v1.Event)
+			}
+		}
+		if condition == nil {
+			t.Fatalf("node condition wasn't updated")
+		}
+		if condition.Type != NodeConditionType || condition.Status != step.expectedStatus || condition.Reason != step.expectedReason || condition.Message != step.expectedMessage {
+			t.Errorf("bad node condition: %#v", condition)
+		}
+		switch {
+		case !step.expectEvent && event != nil:
+			t.Errorf("unexpected event: %#v", event)
+		case step.expectEvent && event == nil:
+			t.Errorf("event not recorded for reason %q", step.expectedReason)
+		case step.expectEvent:
+			if event.InvolvedObject.Kind != "Node" || event.InvolvedObject.Name != "kube-node-1" || event.Reason != step.expectedReason || event.Type != step.expectedEvType || event.Message != step.expectedMessage {
+				t.Errorf("bad event: %#v", event)
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kvmcheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// NodeConditionType is the type of the node condition that
+	// is set to True when KVM is not used by Virtlet on the node.
+	NodeConditionType v1.NodeConditionType = "VirtletKVMUnavailable"
+	// ReasonKVMUsable means that KVM is usable and used by Virtlet.
+	ReasonKVMUsable = "KVMUsable"
+	// ReasonKVMNotUsable means that KVM is enabled in Virtlet
+	// config but can't be used, so the VMs will fail to start.
+	ReasonKVMNotUsable = "KVMNotUsable"
+	// ReasonUsingTCG means that KVM can't be used and Virtlet
+	// uses TCG (software emulation) instead.
+	ReasonUsingTCG = "UsingTCG"
+	// ReasonKVMDisabled means that KVM is usable but disabled
+	// in Virtlet config.
+	ReasonKVMDisabled = "KVMDisabled"
+
+	eventSourceComponent = "virtlet"
+)
+
+// Reason returns the node condition reason that corresponds to the
+// report given the Virtlet setting that disables KVM.
+func Reason(report *Report, kvmDisabled bool) string {
+	switch {
+	case report.Usable && !kvmDisabled:
+		return ReasonKVMUsable
+	case report.Usable:
+		return ReasonKVMDisabled
+	case kvmDisabled:
+		return ReasonUsingTCG
+	default:
+		return ReasonKVMNotUsable
+	}
+}
+
+// NodeReporter reports the results of KVM checks via the node
+// condition and the node events.
+type NodeReporter struct {
+	sync.Mutex
+	clientCfg  clientcmd.ClientConfig
+	kubeClient kubernetes.Interface
+	nodeName   string
+	lastReason string
+	now        func() time.Time
+}
+
+// NewNodeReporter returns a NodeReporter for the specified node.
+func NewNodeReporter(clientCfg clientcmd.ClientConfig, nodeName string) *NodeReporter {
+	return &NodeReporter{clientCfg: clientCfg, nodeName: nodeName, now: time.Now}
+}
+
+func (r *NodeReporter) ensureKubeClient() error {
+	if r.kubeClient != nil {
+		return nil
+	}
+	config, err := r.clientCfg.ClientConfig()
+	if err != nil {
+		return err
+	}
+	r.kubeClient, err = kubernetes.NewForConfig(config)
+	return err
+}
+
+// Report updates the node condition according to the report. It
+// also records a node event if the reason of the condition has
+// changed since the previous call.
+func (r *NodeReporter) Report(report *Report, kvmDisabled bool) error {
+	r.Lock()
+	defer r.Unlock()
+	if err := r.ensureKubeClient(); err != nil {
+		return err
+	}
+
+	reason := Reason(report, kvmDisabled)
+	message := "KVM is usable"
+	if problems := report.Problems(); problems != "" {
+		message = problems
+	} else if kvmDisabled {
+		message = "KVM is disabled in Virtlet config"
+	}
+	status := v1.ConditionTrue
+	if reason == ReasonKVMUsable {
+		status = v1.ConditionFalse
+	}
+
+	node, err := r.kubeClient.CoreV1().Nodes().Get(r.nodeName, meta_v1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("can't get node %q: %v", r.nodeName, err)
+	}
+	now := meta_v1.NewTime(r.now())
+	condition := v1.NodeCondition{
+		Type:               NodeConditionType,
+		Status:             status,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+		Reason:             reason,
+		Message:            message,
+	}
+	for _, c := range node.Status.Conditions {
+		if c.Type == NodeConditionType && c.Status == status {
+			condition.LastTransitionTime = c.LastTransitionTime
+		}
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []v1.NodeCondition{condition},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error marshalling node condition patch: %v", err)
+	}
+	if _, err := r.kubeClient.CoreV1().Nodes().PatchStatus(r.nodeName, patch); err != nil {
+		return fmt.Errorf("can't update the condition of node %q: %v", r.nodeName, err)
+	}
+
+	if reason != r.lastReason {
+		r.lastReason = reason
+		eventType := v1.EventTypeNormal
+		if !report.Usable {
+			eventType = v1.EventTypeWarning
+		}
+		r.recordEvent(eventType, reason, message, now)
+	}
+	return nil
+}
+
+func (r *NodeReporter) recordEvent(eventType, reason, message string, now meta_v1.Time) {
+	event := &v1.Event{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", r.nodeName, now.UnixNano()),
+			Namespace: meta_v1.NamespaceDefault,
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind: "Node",
+			Name: r.nodeName,
+			// kubelet uses node name as node UID in the events
+			UID: types.UID(r.nodeName),
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         v1.EventSource{Component: eventSourceComponent, Host: r.nodeName},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if _, err := r.kubeClient.CoreV1().Events(meta_v1.NamespaceDefault).Create(event); err != nil {
+		glog.Warningf("Can't record event %q for node %s: %v", reason, r.nodeName, err)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+// prepareAdoption looks up the pre-existing libvirt domain that is
+// to be taken over by the VM and stores its definition in the VM
+// config. The domain must be shut off. The domain itself is
+// returned so it can be undefined before the VM domain is defined.
+func (v *VirtualizationTool) prepareAdoption(config *types.VMConfig) (virt.Domain, error) {
+	name := config.ParsedAnnotations.AdoptDomain
+	domain, err := v.domainConn.LookupDomainByName(name)
+	switch {
+	case err == virt.ErrDomainNotFound:
+		return nil, fmt.Errorf("domain %q to adopt not found", name)
+	case err != nil:
+		return nil, fmt.Errorf("error looking up domain %q to adopt: %v", name, err)
+	}
+
+	state, err := domain.State()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state of domain %q to adopt: %v", name, err)
+	}
+	if state != virt.DomainStateShutoff {
+		return nil, fmt.Errorf("domain %q must be shut off to be adopted", name)
+	}
+
+	def, err := domain.XML()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get the definition of domain %q to adopt: %v", name, err)
+	}
+	if len(adoptableDisks(def)) == 0 {
+		return nil, fmt.Errorf("domain %q to adopt has no disks", name)
+	}
+	if config.AdoptedDomainXML, err = def.Marshal(); err != nil {
+		return nil, fmt.Errorf("error marshalling the definition of domain %q to adopt: %v", name, err)
+	}
+	return domain, nil
+}
+
+// restoreAdoptedDomain defines the domain adopted by the VM again
+// after the VM domain is removed, unless it's already defined.
+func (v *VirtualizationTool) restoreAdoptedDomain(config *types.VMConfig) error {
+	if config.AdoptedDomainXML == "" {
+		return nil
+	}
+	var def libvirtxml.Domain
+	if err := def.Unmarshal(config.AdoptedDomainXML); err != nil {
+		return fmt.Errorf("error unmarshalling the adopted domain definition: %v", err)
+	}
+	switch _, err := v.domainConn.LookupDomainByName(def.Name); {
+	case err == nil:
+		return nil
+	case err != virt.ErrDomainNotFound:
+		return fmt.Errorf("error looking up adopted domain %q: %v", def.Name, err)
+	}
+	if _, err := v.domainConn.DefineDomain(&def); err != nil {
+		return fmt.Errorf("error restoring adopted domain %q: %v", def.Name, err)
+	}
+	glog.V(1).Infof("Restored adopted domain %q", def.Name)
+	return nil
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+const (
+	legacyDomainName  = "legacy-vm"
+	legacyDomainUUID  = "2a3ba6c9-0b4a-4e6c-9b7a-a1b5b1e3f0d2"
+	legacyDomainImage = "/var/lib/libvirt/images/legacy.qcow2"
+)
+
+func defineLegacyDomain(t *testing.T, ct *containerTester) virt.Domain {
+	domain, err := ct.domainConn.DefineDomain(&libvirtxml.Domain{
+		Type: "kvm",
+		Name: legacyDomainName,
+		UUID: legacyDomainUUID,
+		Devices: &libvirtxml.DomainDeviceList{
+			Disks: []libvirtxml.DomainDisk{
+				{
+					Device: "disk",
+					Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "qcow2"},
+					Source: &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: legacyDomainImage}},
+					Target: &libvirtxml.DomainDiskTarget{Dev: "hda", Bus: "ide"},
+				},
+				{
+					Device: "cdrom",
+					Target: &libvirtxml.DomainDiskTarget{Dev: "hdc", Bus: "ide"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("DefineDomain(): %v", err)
+	}
+	return domain
+}
+
+func TestAdoptDomain(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+	defineLegacyDomain(t, ct)
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	sandbox.Annotations["VirtletAdoptDomain"] = legacyDomainName
+	sandbox.Annotations["VirtletSystemUUID"] = legacyDomainUUID
+	ct.setPodSandbox(sandbox)
+
+	containerID := ct.createContainer(sandbox, nil, nil)
+	if containerID != legacyDomainUUID {
+		t.Errorf("bad container id %q instead of %q", containerID, legacyDomainUUID)
+	}
+	if _, err := ct.domainConn.LookupDomainByName(legacyDomainName); err != virt.ErrDomainNotFound {
+		t.Errorf("the adopted domain was not undefined")
+	}
+	domain, err := ct.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		t.Fatalf("LookupDomainByUUIDString(): %v", err)
+	}
+	def, err := domain.XML()
+	if err != nil {
+		t.Fatalf("XML(): %v", err)
+	}
+	disk := def.Devices.Disks[0]
+	if disk.Source == nil || disk.Source.File == nil || disk.Source.File.File != legacyDomainImage {
+		t.Errorf("the disk of the adopted domain is not used as the first disk: %#v", disk)
+	}
+	if disk.Target == nil || disk.Target.Dev != "sda" {
+		t.Errorf("bad target for the disk of the adopted domain: %#v", disk.Target)
+	}
+	container := ct.containerInfo(containerID)
+	if ct.verifyContainerRootfsExists(container) {
+		t.Errorf("root volume was created for the adopted domain")
+	}
+
+	ct.removeContainer(containerID)
+	restored, err := ct.domainConn.LookupDomainByName(legacyDomainName)
+	if err != nil {
+		t.Fatalf("the adopted domain was not restored: %v", err)
+	}
+	if uuid, _ := restored.UUIDString(); uuid != legacyDomainUUID {
+		t.Errorf("bad uuid of the restored domain: %q", uuid)
+	}
+}
+
+func TestAdoptRunningDomain(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+	if err := defineLegacyDomain(t, ct).Create(); err != nil {
+		t.Fatalf("Create(): %v", err)
+	}
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	sandbox.Annotations["VirtletAdoptDomain"] = legacyDomainName
+	ct.setPodSandbox(sandbox)
+	if _, err := ct.virtTool.CreateContainer(&types.VMConfig{
+		PodSandboxID:   sandbox.Uid,
+		PodName:        sandbox.Name,
+		PodNamespace:   sandbox.Namespace,
+		Name:           fakeContainerName,
+		Image:          fakeImageName,
+		PodAnnotations: sandbox.Annotations,
+	}, "/tmp/fakenetns"); err == nil {
+		t.Errorf("didn't get an error when adopting a running domain")
+	}
+	if _, err := ct.domainConn.LookupDomainByName(legacyDomainName); err != nil {
+		t.Errorf("the running domain was removed: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+// adoptedDiskVolume denotes a disk of a pre-existing libvirt domain
+// taken over by the VM. The disk is used as is and is left intact
+// when the VM is removed.
+type adoptedDiskVolume struct {
+	volumeBase
+	index int
+	disk  libvirtxml.DomainDisk
+}
+
+var _ VMVolume = &adoptedDiskVolume{}
+
+// adoptableDisks returns the disks of the domain that can be taken
+// over by a VM. CD-ROMs and floppies are skipped, as well as the
+// disks without a source.
+func adoptableDisks(def *libvirtxml.Domain) []libvirtxml.DomainDisk {
+	if def.Devices == nil {
+		return nil
+	}
+	var r []libvirtxml.DomainDisk
+	for _, disk := range def.Devices.Disks {
+		if (disk.Device == "" || disk.Device == "disk") && disk.Source != nil {
+			r = append(r, disk)
+		}
+	}
+	return r
+}
+
+// getAdoptedDiskVolumes returns the volumes for the disks of the
+// domain adopted by the VM. These volumes replace the root volume.
+func getAdoptedDiskVolumes(config *types.VMConfig, owner volumeOwner) ([]VMVolume, error) {
+	var def libvirtxml.Domain
+	if err := def.Unmarshal(config.AdoptedDomainXML); err != nil {
+		return nil, fmt.Errorf("error unmarshalling the adopted domain definition: %v", err)
+	}
+	disks := adoptableDisks(&def)
+	if len(disks) == 0 {
+		return nil, fmt.Errorf("adopted domain %q has no disks", def.Name)
+	}
+	var vols []VMVolume
+	for n, disk := range disks {
+		vols = append(vols, &adoptedDiskVolume{
+			volumeBase: volumeBase{config, owner},
+			index:      n,
+			disk:       disk,
+		})
+	}
+	return vols, nil
+}
+
+func (v *adoptedDiskVolume) IsDisk() bool { return true }
+
+func (v *adoptedDiskVolume) UUID() string { return "" }
+
+func (v *adoptedDiskVolume) PodVolumeName() string {
+	if v.index == 0 {
+		return "root"
+	}
+	return fmt.Sprintf("adopted-%d", v.index)
+}
+
+func (v *adoptedDiskVolume) Setup() (*libvirtxml.DomainDisk, *libvirtxml.DomainFilesystem, error) {
+	disk := v.disk
+	// the target, the address and the boot order are assigned
+	// by Virtlet, while the alias is assigned by libvirt
+	disk.Target = nil
+	disk.Address = nil
+	disk.Boot = nil
+	disk.Alias = nil
+	return &disk, nil, nil
+}
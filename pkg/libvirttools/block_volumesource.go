@@ -38,6 +38,12 @@ func (v *blockVolume) UUID() string {
 	return v.dev.UUID()
 }
 
+// PodVolumeName returns the last component of the device path on
+// the host which is the name of the PersistentVolume for block PVs.
+func (v *blockVolume) PodVolumeName() string {
+	return filepath.Base(v.dev.HostPath)
+}
+
 func (v *blockVolume) Setup() (*libvirtxml.DomainDisk, *libvirtxml.DomainFilesystem, error) {
 	// we need to follow the symlinks as only devices under /dev
 	// will be chown'ed properly by QEMU
@@ -0,0 +1,179 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/utils"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+const (
+	bootCheckInterval = 5 * time.Second
+	// maxBootSerialLogSize is the maximum number of bytes taken
+	// from the end of the VM serial console log
+	maxBootSerialLogSize = 64 * 1024
+)
+
+var (
+	errDomainNotRunning = errors.New("domain is not running")
+	errBootWatchStopped = errors.New("boot watch stopped")
+)
+
+// bootDiagnosticsPrefix returns the file name prefix for the boot
+// diagnostics of the container with the specified config.
+func bootDiagnosticsPrefix(config *types.VMConfig) string {
+	return fmt.Sprintf("%s_%s_%s_%d", config.PodNamespace, config.PodName, config.Name, config.Attempt)
+}
+
+// screenshotExtension returns the file extension to use for
+// the screenshot with the specified MIME type.
+func screenshotExtension(mimeType string) string {
+	switch mimeType {
+	case "image/png":
+		return "png"
+	case "image/x-portable-pixmap":
+		return "ppm"
+	default:
+		return "img"
+	}
+}
+
+// startBootWatch starts watching the boot of the VM in background.
+func (v *VirtualizationTool) startBootWatch(containerID string, domain virt.Domain, config *types.VMConfig) {
+	w := v.bootWatchers.start(containerID)
+	go func() {
+		defer v.bootWatchers.done(containerID, w)
+		v.watchBoot(w, domain, config)
+	}()
+}
+
+// watchBoot waits for the guest agent of the VM to become available
+// and captures the boot diagnostics if it doesn't happen within the
+// boot timeout specified for the VM. The check is abandoned if the
+// domain stops running, can't be checked or the watcher is stopped
+// because the VM is being stopped or removed.
+func (v *VirtualizationTool) watchBoot(w *vmWatcher, domain virt.Domain, config *types.VMConfig) {
+	timeout := time.Duration(config.ParsedAnnotations.BootTimeoutSeconds) * time.Second
+	var checkErr error
+	err := utils.WaitLoop(func() (bool, error) {
+		if w.isStopped() {
+			checkErr = errBootWatchStopped
+			return false, checkErr
+		}
+		state, err := domain.State()
+		switch {
+		case err != nil:
+			checkErr = err
+			return false, checkErr
+		case state != virt.DomainStateRunning:
+			checkErr = errDomainNotRunning
+			return false, checkErr
+		}
+		return guestAgentCommand(domain, "guest-ping", nil, nil) == nil, nil
+	}, bootCheckInterval, timeout, v.clock)
+	switch {
+	case err == nil:
+		glog.V(1).Infof("VM %s/%s has booted", config.PodNamespace, config.PodName)
+	case checkErr == errDomainNotRunning:
+		glog.V(1).Infof("VM %s/%s stopped before booting", config.PodNamespace, config.PodName)
+	case checkErr == errBootWatchStopped:
+		glog.V(1).Infof("VM %s/%s: boot watch stopped", config.PodNamespace, config.PodName)
+	case checkErr != nil:
+		// the domain may have been removed during the wait
+		glog.Warningf("Can't check the boot of VM %s/%s: %v", config.PodNamespace, config.PodName, checkErr)
+	default:
+		// only the real timeout is reported, and not after
+		// the VM is stopped or removed
+		w.do(func() { v.captureBootDiagnostics(domain, config, err) })
+	}
+}
+
+// captureBootDiagnostics saves the screenshot of the VM console and
+// the tail of its serial console log in the boot diagnostics
+// directory and records an event pointing to them.
+func (v *VirtualizationTool) captureBootDiagnostics(domain virt.Domain, config *types.VMConfig, bootErr error) {
+	if v.config.BootDiagnosticsDirectory == "" {
+		v.eventRecorder.Eventf(config, v1.EventTypeWarning, "VMBootTimeout", "VM didn't boot within %d seconds: %v", config.ParsedAnnotations.BootTimeoutSeconds, bootErr)
+		return
+	}
+
+	prefix := filepath.Join(v.config.BootDiagnosticsDirectory, bootDiagnosticsPrefix(config))
+	var files []string
+	if data, mimeType, err := domain.Screenshot(); err != nil {
+		glog.Warningf("Can't take a screenshot of VM %s/%s: %v", config.PodNamespace, config.PodName, err)
+	} else {
+		screenshotPath := fmt.Sprintf("%s-screenshot.%s", prefix, screenshotExtension(mimeType))
+		if err := ioutil.WriteFile(screenshotPath, data, 0644); err != nil {
+			glog.Warningf("Error writing %q: %v", screenshotPath, err)
+		} else {
+			files = append(files, screenshotPath)
+		}
+	}
+
+	if config.LogDirectory != "" && config.LogPath != "" {
+		data, err := readLogTail(filepath.Join(config.LogDirectory, config.LogPath), maxBootSerialLogSize)
+		if err != nil {
+			glog.Warningf("Can't read the serial console log of VM %s/%s: %v", config.PodNamespace, config.PodName, err)
+		} else {
+			serialPath := prefix + "-serial.log"
+			if err := ioutil.WriteFile(serialPath, data, 0644); err != nil {
+				glog.Warningf("Error writing %q: %v", serialPath, err)
+			} else {
+				files = append(files, serialPath)
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		v.eventRecorder.Eventf(config, v1.EventTypeWarning, "VMBootTimeout", "VM didn't boot within %d seconds: %v; failed to capture boot diagnostics", config.ParsedAnnotations.BootTimeoutSeconds, bootErr)
+		return
+	}
+	v.eventRecorder.Eventf(config, v1.EventTypeWarning, "VMBootTimeout", "VM didn't boot within %d seconds: %v; boot diagnostics saved on the node: %s", config.ParsedAnnotations.BootTimeoutSeconds, bootErr, strings.Join(files, ", "))
+}
+
+// removeBootDiagnostics removes the boot diagnostics captured
+// for the container with the specified config, if any.
+func (v *VirtualizationTool) removeBootDiagnostics(config *types.VMConfig) {
+	if v.config.BootDiagnosticsDirectory == "" {
+		return
+	}
+	prefix := filepath.Join(v.config.BootDiagnosticsDirectory, bootDiagnosticsPrefix(config))
+	for _, suffix := range []string{"-screenshot.*", "-serial.log"} {
+		matches, err := filepath.Glob(prefix + suffix)
+		if err != nil {
+			glog.Warningf("Error looking for boot diagnostics files: %v", err)
+			continue
+		}
+		for _, m := range matches {
+			if err := os.Remove(m); err != nil {
+				glog.Warningf("Error removing %q: %v", m, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+func TestBootDiagnostics(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+	recorder := &fakeEventRecorder{}
+	ct.virtTool.SetEventRecorder(recorder)
+	diagDir := filepath.Join(ct.tmpDir, "boot-diagnostics")
+	logDir := filepath.Join(ct.tmpDir, "logs")
+	for _, dir := range []string{diagDir, logDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("MkdirAll(): %v", err)
+		}
+	}
+	ct.virtTool.config.BootDiagnosticsDirectory = diagDir
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	sandbox.Annotations["VirtletGuestAgent"] = "true"
+	ct.setPodSandbox(sandbox)
+
+	containerID := ct.createContainer(sandbox, nil, nil)
+	ct.clock.Advance(1 * time.Second)
+	ct.startContainer(containerID)
+
+	config, _, err := ct.virtTool.getVMConfigFromMetadata(containerID)
+	if err != nil {
+		t.Fatalf("getVMConfigFromMetadata(): %v", err)
+	}
+	config.ParsedAnnotations.BootTimeoutSeconds = 300
+	config.LogDirectory = logDir
+	if err := ioutil.WriteFile(filepath.Join(logDir, config.LogPath), []byte("Booting...\nKernel panic\n"), 0644); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+
+	domain, err := ct.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		t.Fatalf("LookupDomainByUUIDString(): %v", err)
+	}
+	ct.virtTool.captureBootDiagnostics(domain, config, errors.New("timeout reached"))
+
+	prefix := fmt.Sprintf("%s_%s_%s_%d", sandbox.Namespace, sandbox.Name, fakeContainerName, fakeContainerAttempt)
+	expectedFiles := map[string]interface{}{
+		prefix + "-screenshot.ppm": "P6\n1 1\n255\n\x00\x00\x00",
+		prefix + "-serial.log":     "Booting...\nKernel panic\n",
+	}
+	files, err := testutils.DirToMap(diagDir)
+	if err != nil {
+		t.Fatalf("DirToMap(): %v", err)
+	}
+	if !reflect.DeepEqual(files, expectedFiles) {
+		t.Errorf("bad boot diagnostics files:\n%#v\ninstead of\n%#v", files, expectedFiles)
+	}
+
+	expectedEvents := []string{
+		fmt.Sprintf("%s/%s Warning VMBootTimeout: VM didn't boot within 300 seconds: timeout reached; boot diagnostics saved on the node: %s, %s",
+			sandbox.Namespace, sandbox.Name,
+			filepath.Join(diagDir, prefix+"-screenshot.ppm"),
+			filepath.Join(diagDir, prefix+"-serial.log")),
+	}
+	if !reflect.DeepEqual(recorder.events, expectedEvents) {
+		t.Errorf("bad events:\n%#v\ninstead of\n%#v", recorder.events, expectedEvents)
+	}
+
+	ct.virtTool.removeBootDiagnostics(config)
+	if files, err := testutils.DirToMap(diagDir); err != nil {
+		t.Fatalf("DirToMap(): %v", err)
+	} else if len(files) != 0 {
+		t.Errorf("boot diagnostics files were not removed: %#v", files)
+	}
+}
+
+// bootTestDomain is a domain with a guest agent that never responds
+// and the state that can be changed while the boot is being watched.
+type bootTestDomain struct {
+	virt.Domain
+	sync.Mutex
+	removed bool
+}
+
+func (d *bootTestDomain) State() (virt.DomainState, error) {
+	d.Lock()
+	defer d.Unlock()
+	if d.removed {
+		return virt.DomainStateNoState, errors.New("domain removed")
+	}
+	return virt.DomainStateRunning, nil
+}
+
+func (d *bootTestDomain) QemuAgentCommand(command string, timeout time.Duration) (string, error) {
+	return "", errors.New("guest agent is not connected")
+}
+
+func (d *bootTestDomain) remove() {
+	d.Lock()
+	defer d.Unlock()
+	d.removed = true
+}
+
+func TestWatchBoot(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// interrupt is called after the first boot check
+		interrupt     func(ct *containerTester, containerID string, domain *bootTestDomain)
+		expectCapture bool
+	}{
+		{
+			name:          "timeout",
+			expectCapture: true,
+		},
+		{
+			name: "domain removed",
+			interrupt: func(ct *containerTester, containerID string, domain *bootTestDomain) {
+				domain.remove()
+			},
+		},
+		{
+			name: "stopped",
+			interrupt: func(ct *containerTester, containerID string, domain *bootTestDomain) {
+				ct.stopContainer(containerID)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+			defer ct.teardown()
+			recorder := &fakeEventRecorder{}
+			ct.virtTool.SetEventRecorder(recorder)
+			diagDir := filepath.Join(ct.tmpDir, "boot-diagnostics")
+			if err := os.MkdirAll(diagDir, 0755); err != nil {
+				t.Fatalf("MkdirAll(): %v", err)
+			}
+			ct.virtTool.config.BootDiagnosticsDirectory = diagDir
+
+			sandbox := fakemeta.GetSandboxes(1)[0]
+			ct.setPodSandbox(sandbox)
+			containerID := ct.createContainer(sandbox, nil, nil)
+			fakeDomain, err := ct.domainConn.LookupDomainByUUIDString(containerID)
+			if err != nil {
+				t.Fatalf("LookupDomainByUUIDString(): %v", err)
+			}
+			domain := &bootTestDomain{Domain: fakeDomain}
+			config := &types.VMConfig{
+				PodName:           sandbox.Name,
+				PodNamespace:      sandbox.Namespace,
+				Name:              fakeContainerName,
+				ParsedAnnotations: &types.VirtletAnnotations{BootTimeoutSeconds: 10},
+			}
+
+			done := make(chan struct{})
+			w := ct.virtTool.bootWatchers.start(containerID)
+			go func() {
+				ct.virtTool.watchBoot(w, domain, config)
+				close(done)
+			}()
+
+			ct.clock.BlockUntil(1)
+			if tc.interrupt != nil {
+				tc.interrupt(ct, containerID, domain)
+			}
+			ct.clock.Advance(bootCheckInterval)
+			if tc.interrupt == nil {
+				ct.clock.BlockUntil(1)
+				ct.clock.Advance(bootCheckInterval)
+			}
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatalf("the boot watcher didn't exit")
+			}
+
+			files, err := testutils.DirToMap(diagDir)
+			if err != nil {
+				t.Fatalf("DirToMap(): %v", err)
+			}
+			if tc.expectCapture {
+				if len(files) == 0 {
+					t.Errorf("boot diagnostics were not captured")
+				}
+				if len(recorder.events) != 1 {
+					t.Errorf("bad events: %#v", recorder.events)
+				}
+			} else {
+				if len(files) != 0 {
+					t.Errorf("unexpected boot diagnostics files: %#v", files)
+				}
+				if len(recorder.events) != 0 {
+					t.Errorf("unexpected events: %#v", recorder.events)
+				}
+			}
+		})
+	}
+}
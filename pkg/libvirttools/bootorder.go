@@ -0,0 +1,79 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+	"strconv"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	vconfig "github.com/Mirantis/virtlet/pkg/config"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+// applyBootOrder sets per-device boot order for the domain disks
+// according to the specified boot order. It must be called after the
+// disks are added to the domain. The network interfaces aren't part
+// of the domain definition, so the boot index for network boot is
+// passed to vmwrapper via an environment variable.
+func applyBootOrder(domain *libvirtxml.Domain, dl *diskList, bootOrder []string) error {
+	if len(bootOrder) == 0 {
+		return nil
+	}
+
+	// The disks in the domain definition follow the disk volumes
+	// in the disk list
+	var diskNames []string
+	for _, item := range dl.items {
+		if item.volume.IsDisk() {
+			diskNames = append(diskNames, podVolumeName(item.volume))
+		}
+	}
+	if len(diskNames) != len(domain.Devices.Disks) {
+		return fmt.Errorf("disk count mismatch: %d disk volumes, %d domain disks", len(diskNames), len(domain.Devices.Disks))
+	}
+
+	// libvirt doesn't allow per-device boot order to be combined
+	// with <boot dev="..."/> elements
+	domain.OS.BootDevices = nil
+
+	for n, dev := range bootOrder {
+		order := uint(n + 1)
+		if dev == types.BootDeviceNetwork {
+			domain.QEMUCommandline.Envs = append(domain.QEMUCommandline.Envs,
+				libvirtxml.DomainQEMUCommandlineEnv{
+					Name:  vconfig.NetBootIndexEnvVarName,
+					Value: strconv.Itoa(int(order)),
+				})
+			continue
+		}
+		found := false
+		for i, name := range diskNames {
+			if name == dev {
+				domain.Devices.Disks[i].Boot = &libvirtxml.DomainDeviceBoot{Order: order}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("boot device %q doesn't match any disk of the VM", dev)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"reflect"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	vconfig "github.com/Mirantis/virtlet/pkg/config"
+)
+
+type fakeNamedVolume struct {
+	name   string
+	isDisk bool
+}
+
+var _ VMVolume = &fakeNamedVolume{}
+
+func (v *fakeNamedVolume) IsDisk() bool                 { return v.isDisk }
+func (v *fakeNamedVolume) UUID() string                 { return "" }
+func (v *fakeNamedVolume) WriteImage(diskPathMap) error { return nil }
+func (v *fakeNamedVolume) Teardown() error              { return nil }
+func (v *fakeNamedVolume) PodVolumeName() string        { return v.name }
+func (v *fakeNamedVolume) Setup() (*libvirtxml.DomainDisk, *libvirtxml.DomainFilesystem, error) {
+	return nil, nil, nil
+}
+
+func newBootOrderTestDomain() (*libvirtxml.Domain, *diskList) {
+	domain := &libvirtxml.Domain{
+		OS: &libvirtxml.DomainOS{
+			BootDevices: []libvirtxml.DomainBootDevice{{Dev: "hd"}},
+		},
+		Devices: &libvirtxml.DomainDeviceList{
+			Disks: []libvirtxml.DomainDisk{
+				{Target: &libvirtxml.DomainDiskTarget{Dev: "sda"}},
+				{Target: &libvirtxml.DomainDiskTarget{Dev: "sdb"}},
+				{Target: &libvirtxml.DomainDiskTarget{Dev: "sdc"}},
+			},
+		},
+		QEMUCommandline: &libvirtxml.DomainQEMUCommandline{},
+	}
+	dl := &diskList{
+		items: []*diskItem{
+			{volume: &fakeNamedVolume{name: "root", isDisk: true}},
+			{volume: &fakeNamedVolume{name: "shared"}},
+			{volume: &fakeNamedVolume{name: "data", isDisk: true}},
+			{volume: &fakeNamedVolume{name: "cloud-init", isDisk: true}},
+		},
+	}
+	return domain, dl
+}
+
+func TestBootOrder(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		domain, dl := newBootOrderTestDomain()
+		if err := applyBootOrder(domain, dl, nil); err != nil {
+			t.Fatalf("applyBootOrder(): %v", err)
+		}
+		expectedDomain, _ := newBootOrderTestDomain()
+		if !reflect.DeepEqual(domain, expectedDomain) {
+			t.Errorf("domain changed without a boot order")
+		}
+	})
+
+	t.Run("disks and network", func(t *testing.T) {
+		domain, dl := newBootOrderTestDomain()
+		if err := applyBootOrder(domain, dl, []string{"data", "network", "root"}); err != nil {
+			t.Fatalf("applyBootOrder(): %v", err)
+		}
+		if domain.OS.BootDevices != nil {
+			t.Errorf("boot devices not removed: %#v", domain.OS.BootDevices)
+		}
+		var orders []uint
+		for _, d := range domain.Devices.Disks {
+			if d.Boot == nil {
+				orders = append(orders, 0)
+			} else {
+				orders = append(orders, d.Boot.Order)
+			}
+		}
+		if !reflect.DeepEqual(orders, []uint{3, 1, 0}) {
+			t.Errorf("bad disk boot order: %v", orders)
+		}
+		expectedEnvs := []libvirtxml.DomainQEMUCommandlineEnv{
+			{Name: vconfig.NetBootIndexEnvVarName, Value: "2"},
+		}
+		if !reflect.DeepEqual(domain.QEMUCommandline.Envs, expectedEnvs) {
+			t.Errorf("bad qemu env: %#v", domain.QEMUCommandline.Envs)
+		}
+	})
+
+	t.Run("unknown device", func(t *testing.T) {
+		domain, dl := newBootOrderTestDomain()
+		if err := applyBootOrder(domain, dl, []string{"shared"}); err == nil {
+			t.Errorf("applyBootOrder() didn't fail for a non-disk volume")
+		}
+	})
+}
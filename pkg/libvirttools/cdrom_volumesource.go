@@ -0,0 +1,77 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+// cdromVolume denotes an ISO image from the image store that's
+// attached to the VM as a CD-ROM device
+type cdromVolume struct {
+	volumeBase
+	index int
+	image string
+}
+
+var _ VMVolume = &cdromVolume{}
+
+func (v *cdromVolume) IsDisk() bool { return true }
+
+func (v *cdromVolume) UUID() string { return "" }
+
+// PodVolumeName returns the name of the CD-ROM that can be used
+// to reference it in the boot order.
+func (v *cdromVolume) PodVolumeName() string { return fmt.Sprintf("cdrom%d", v.index) }
+
+func (v *cdromVolume) Setup() (*libvirtxml.DomainDisk, *libvirtxml.DomainFilesystem, error) {
+	imagePath, _, _, err := v.owner.ImageManager().GetImagePathDigestAndVirtualSize(v.image)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting the path of CD-ROM image %q: %v", v.image, err)
+	}
+	return &libvirtxml.DomainDisk{
+		Device:   "cdrom",
+		Driver:   &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "raw"},
+		Source:   &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: imagePath}},
+		ReadOnly: &libvirtxml.DomainDiskReadOnly{},
+	}, nil, nil
+}
+
+// Teardown is a no-op as the images are removed by the image
+// store GC when they're no longer used.
+func (v *cdromVolume) Teardown() error { return nil }
+
+// GetCDROMVolumes returns VMVolume objects for the ISO images
+// that are attached to the VM as CD-ROM devices.
+func GetCDROMVolumes(config *types.VMConfig, owner volumeOwner) ([]VMVolume, error) {
+	if config.ParsedAnnotations == nil {
+		return nil, nil
+	}
+	var vols []VMVolume
+	for n, image := range config.ParsedAnnotations.CDROMImages {
+		vols = append(vols, &cdromVolume{
+			volumeBase: volumeBase{config, owner},
+			index:      n,
+			image:      image,
+		})
+	}
+	return vols, nil
+}
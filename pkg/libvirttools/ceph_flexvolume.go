@@ -91,6 +91,8 @@ func (v *cephVolume) secretDef() *libvirtxml.Secret {
 
 func (v *cephVolume) IsDisk() bool { return true }
 
+func (v *cephVolume) PodVolumeName() string { return v.volumeName }
+
 func (v *cephVolume) UUID() string {
 	return v.opts.UUID
 }
@@ -0,0 +1,83 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+var serialChannelDir = "/var/lib/virtlet/channels"
+
+// SetSerialChannelDir sets the directory that holds the sockets of
+// the virtio-serial channels. It can be useful in tests.
+func SetSerialChannelDir(dir string) {
+	serialChannelDir = dir
+}
+
+// SerialChannelSocketPath returns the path of the unix socket for
+// the virtio-serial channel with the specified name that belongs
+// to the pod with the specified sandbox id (pod UID).
+func SerialChannelSocketPath(podSandboxID, name string) string {
+	return filepath.Join(serialChannelDir, podSandboxID, name)
+}
+
+// addSerialChannelsToDomain adds the virtio-serial channels requested
+// using VirtletSerialChannels annotation to the domain. The sockets
+// are created by qemu in a per-pod directory when the VM starts.
+func (v *VirtualizationTool) addSerialChannelsToDomain(domain *libvirtxml.Domain, config *types.VMConfig) error {
+	if len(config.ParsedAnnotations.SerialChannels) == 0 {
+		return nil
+	}
+
+	dir := filepath.Join(serialChannelDir, config.PodSandboxID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("can't create serial channel directory %q: %v", dir, err)
+	}
+	if err := v.fsys.ChownForEmulator(dir, false); err != nil {
+		return fmt.Errorf("can't set the owner of serial channel directory %q: %v", dir, err)
+	}
+
+	for _, name := range config.ParsedAnnotations.SerialChannels {
+		domain.Devices.Channels = append(domain.Devices.Channels, libvirtxml.DomainChannel{
+			Source: &libvirtxml.DomainChardevSource{
+				UNIX: &libvirtxml.DomainChardevSourceUNIX{
+					Mode: "bind",
+					Path: SerialChannelSocketPath(config.PodSandboxID, name),
+				},
+			},
+			Target: &libvirtxml.DomainChannelTarget{
+				VirtIO: &libvirtxml.DomainChannelTargetVirtIO{Name: name},
+			},
+		})
+	}
+	return nil
+}
+
+// removeSerialChannels removes the directory with the sockets of the
+// virtio-serial channels of the pod.
+func removeSerialChannels(config *types.VMConfig) error {
+	if config.PodSandboxID == "" {
+		return nil
+	}
+	return os.RemoveAll(filepath.Join(serialChannelDir, config.PodSandboxID))
+}
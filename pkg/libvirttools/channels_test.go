@@ -0,0 +1,75 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+)
+
+func TestSerialChannels(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	sandbox.Annotations["VirtletSerialChannels"] = "com.example.agent,org.example.metrics"
+	ct.setPodSandbox(sandbox)
+	containerID := ct.createContainer(sandbox, nil, nil)
+
+	domain, err := ct.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		t.Fatalf("LookupDomainByUUIDString(): %v", err)
+	}
+	domainDef, err := domain.XML()
+	if err != nil {
+		t.Fatalf("XML(): %v", err)
+	}
+
+	type channel struct{ name, mode, path string }
+	var channels []channel
+	for _, ch := range domainDef.Devices.Channels {
+		if ch.Source == nil || ch.Source.UNIX == nil || ch.Target == nil || ch.Target.VirtIO == nil {
+			t.Fatalf("bad channel in the domain definition: %#v", ch)
+		}
+		channels = append(channels, channel{ch.Target.VirtIO.Name, ch.Source.UNIX.Mode, ch.Source.UNIX.Path})
+	}
+	channelDir := filepath.Join(ct.tmpDir, "channels", sandbox.Uid)
+	expectedChannels := []channel{
+		{"com.example.agent", "bind", filepath.Join(channelDir, "com.example.agent")},
+		{"org.example.metrics", "bind", filepath.Join(channelDir, "org.example.metrics")},
+	}
+	if !reflect.DeepEqual(channels, expectedChannels) {
+		t.Errorf("bad channels in the domain definition: %#v instead of %#v", channels, expectedChannels)
+	}
+	if fi, err := os.Stat(channelDir); err != nil {
+		t.Errorf("can't stat the channel directory: %v", err)
+	} else if !fi.IsDir() {
+		t.Errorf("%q is not a directory", channelDir)
+	}
+
+	if err := ct.virtTool.RemoveContainer(containerID); err != nil {
+		t.Fatalf("RemoveContainer(): %v", err)
+	}
+	if _, err := os.Stat(channelDir); !os.IsNotExist(err) {
+		t.Errorf("the channel directory wasn't removed (stat result: %v)", err)
+	}
+}
@@ -18,7 +18,9 @@ package libvirttools
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -26,6 +28,8 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -49,8 +53,26 @@ const (
 	mountFileLocation   = "/etc/cloud/mount-volumes.sh"
 	mountScriptSubst    = "@virtlet-mount-script@"
 	cloudInitPerBootDir = "/var/lib/cloud/scripts/per-boot"
+	// etcEnvFileLocation is the file holding the environment
+	// variables to be added to /etc/environment
+	etcEnvFileLocation = "/etc/cloud/etc-environment"
+	// envDFileLocation is the file that passes the environment
+	// variables to systemd user sessions
+	envDFileLocation = "/etc/environment.d/60-virtlet.conf"
+	// systemdEnvFileLocation is the file that passes the
+	// environment variables to systemd services
+	systemdEnvFileLocation = "/etc/systemd/system.conf.d/60-virtlet-environment.conf"
+	// etcHostsLocation is the path of the hosts file that's
+	// generated by kubelet and mounted into the pod containers
+	etcHostsLocation = "/etc/hosts"
+	// maxGuestEnvironmentSize is the max total size of the
+	// environment variables that are written to /etc/environment
+	// and systemd configuration
+	maxGuestEnvironmentSize = 32768
 )
 
+var envVarNameRx = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 // Note that in the templates below, we don't use shellquote (shq) on
 // SysfsPath, because it *must* be expanded by the shell to work
 // (it contains '*')
@@ -64,12 +86,36 @@ var mountDevScriptTemplate = utils.NewShellTemplate(
 		"mkdir -p {{ shq .ContainerPath }} && " +
 		"mount /dev/`ls {{ .SysfsPath }}`{{ .DevSuffix }} {{ .ContainerPath }}; " +
 		"fi")
+var etcEnvironmentScriptTemplate = utils.NewShellTemplate(
+	"sed -i '/^# virtlet-env-begin$/,/^# virtlet-env-end$/d' /etc/environment && " +
+		"{ echo '# virtlet-env-begin'; cat {{ shq .EnvFile }}; echo '# virtlet-env-end'; } >> /etc/environment")
+var swapDiskScriptTemplate = utils.NewShellTemplate(
+	"mkswap {{ shq .DevicePath }}")
+var zramSwapScriptTemplate = utils.NewShellTemplate(
+	"modprobe zram && " +
+		"echo {{ .Size }} > /sys/block/zram0/disksize && " +
+		"mkswap /dev/zram0 && " +
+		"swapon{{ if .Priority }} -p {{ .Priority }}{{ end }} /dev/zram0")
 var mountFSScriptTemplate = utils.NewShellTemplate(
 	"if ! mountpoint {{ shq .ContainerPath }}; then " +
 		"mkdir -p {{ shq .ContainerPath }} && " +
 		"mount -t 9p -o trans=virtio {{ shq .MountTag }} {{ shq .ContainerPath }}; " +
 		"fi")
 
+// defaultInstanceID returns the cloud-init instance-id that's
+// derived from the name and the namespace of the VM pod.
+func defaultInstanceID(config *types.VMConfig) string {
+	return fmt.Sprintf("%s.%s", config.PodName, config.PodNamespace)
+}
+
+// instanceID returns the cloud-init instance-id for the VM.
+func instanceID(config *types.VMConfig) string {
+	if config.InstanceID != "" {
+		return config.InstanceID
+	}
+	return defaultInstanceID(config)
+}
+
 // CloudInitGenerator provides a common part for Cloud Init ISO drive preparation
 // for NoCloud and ConfigDrive volume sources.
 type CloudInitGenerator struct {
@@ -87,8 +133,8 @@ func NewCloudInitGenerator(config *types.VMConfig, isoDir string) *CloudInitGene
 
 func (g *CloudInitGenerator) generateMetaData() ([]byte, error) {
 	m := map[string]interface{}{
-		"instance-id":    fmt.Sprintf("%s.%s", g.config.PodName, g.config.PodNamespace),
-		"local-hostname": g.config.PodName,
+		"instance-id":    instanceID(g.config),
+		"local-hostname": g.localHostname(),
 	}
 
 	// TODO: get rid of this if. Use descriptor for cloud-init image types.
@@ -117,6 +163,57 @@ func (g *CloudInitGenerator) generateMetaData() ([]byte, error) {
 	return r, nil
 }
 
+// localHostname returns the hostname to be passed to the VM via
+// the meta-data. If the pod has both hostname and subdomain set,
+// the fully qualified name is used so that the VM's idea of its
+// name matches the DNS record for the pod in the headless service.
+// As the meta-data is regenerated each time the VM is started,
+// cloud-init updates the guest hostname if it has changed.
+func (g *CloudInitGenerator) localHostname() string {
+	hostname := g.config.Hostname
+	if hostname == "" {
+		return g.config.PodName
+	}
+	if fqdn := g.podFQDN(hostname); fqdn != "" {
+		return fqdn
+	}
+	return hostname
+}
+
+// podFQDN returns the fully qualified domain name of the pod as
+// found in the hosts file generated by kubelet. kubelet only adds
+// such name to the file if the pod has its subdomain set, in which
+// case the line for the pod IP looks like
+// "10.1.90.5 foo.bar.default.svc.cluster.local foo".
+// An empty string is returned if there's no such entry.
+func (g *CloudInitGenerator) podFQDN(hostname string) string {
+	for _, mount := range g.config.Mounts {
+		if mount.ContainerPath != etcHostsLocation {
+			continue
+		}
+		content, err := ioutil.ReadFile(mount.HostPath)
+		if err != nil {
+			glog.Warningf("Error reading hosts file %q: %v", mount.HostPath, err)
+			return ""
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if n := strings.Index(line, "#"); n >= 0 {
+				line = line[:n]
+			}
+			fields := strings.Fields(line)
+			if len(fields) < 3 || !strings.HasPrefix(fields[1], hostname+".") {
+				continue
+			}
+			for _, alias := range fields[2:] {
+				if alias == hostname {
+					return fields[1]
+				}
+			}
+		}
+	}
+	return ""
+}
+
 func (g *CloudInitGenerator) generateUserData(volumeMap diskPathMap) ([]byte, error) {
 	symlinkScript := g.generateSymlinkScript(volumeMap)
 	mounts, mountScript := g.generateMounts(volumeMap)
@@ -143,6 +240,20 @@ func (g *CloudInitGenerator) generateUserData(volumeMap diskPathMap) ([]byte, er
 	if len(mounts) != 0 {
 		userData["mounts"] = g.fixMounts(volumeMap, mounts)
 	}
+	g.addSwap(volumeMap, userData)
+
+	if g.rootVolumeEnlarged() && (g.config.ParsedAnnotations.RootFSGrowMode == "" || g.config.ParsedAnnotations.RootFSGrowMode == types.RootFSGrowCloudInit) {
+		// explicit settings from the user data take precedence
+		if _, found := userData["growpart"]; !found {
+			userData["growpart"] = map[string]interface{}{
+				"mode":    "auto",
+				"devices": []string{"/"},
+			}
+		}
+		if _, found := userData["resize_rootfs"]; !found {
+			userData["resize_rootfs"] = true
+		}
+	}
 
 	writeFilesUpdater := newWriteFilesUpdater(g.config.Mounts)
 	writeFilesUpdater.addSecrets()
@@ -163,6 +274,9 @@ func (g *CloudInitGenerator) generateUserData(volumeMap diskPathMap) ([]byte, er
 	if envContent := g.generateEnvVarsContent(); envContent != "" {
 		writeFilesUpdater.addEnvironmentFile(envContent)
 	}
+	if err := g.addGuestEnvironment(userData, writeFilesUpdater); err != nil {
+		return nil, err
+	}
 	writeFilesUpdater.updateUserData(userData)
 
 	r := []byte{}
@@ -176,6 +290,57 @@ func (g *CloudInitGenerator) generateUserData(volumeMap diskPathMap) ([]byte, er
 	return []byte("#cloud-config\n" + string(r)), nil
 }
 
+// addSwap updates the user data so that cloud-init sets up the swap
+// space requested by the pod annotations. The swap disk is
+// initialized using mkswap in bootcmd and is activated via an fstab
+// entry added by the mounts module. zram swap device is set up by
+// bootcmd, too, as it needs to be recreated upon each boot.
+func (g *CloudInitGenerator) addSwap(volumeMap diskPathMap, userData map[string]interface{}) {
+	swap := g.config.ParsedAnnotations.Swap
+	if swap == nil {
+		return
+	}
+	priority := ""
+	if swap.Priority != nil {
+		priority = strconv.Itoa(*swap.Priority)
+	}
+	switch swap.Type {
+	case types.SwapDisk:
+		dpath, found := volumeMap[swapVolumeUUID(g.config)]
+		if !found {
+			glog.Warningf("Couldn't determine the path of the swap disk inside the VM")
+			return
+		}
+		opts := "sw"
+		if priority != "" {
+			opts += ",pri=" + priority
+		}
+		userData["bootcmd"] = utils.Merge(userData["bootcmd"], []interface{}{
+			swapDiskScriptTemplate.MustExecuteToString(map[string]string{
+				"DevicePath": dpath.devPath,
+			}),
+		})
+		userData["mounts"] = utils.Merge(userData["mounts"], []interface{}{
+			[]interface{}{dpath.devPath, "none", "swap", opts, "0", "0"},
+		})
+	case types.SwapZram:
+		userData["bootcmd"] = utils.Merge(userData["bootcmd"], []interface{}{
+			zramSwapScriptTemplate.MustExecuteToString(map[string]string{
+				"Size":     strconv.FormatInt(swap.Size, 10),
+				"Priority": priority,
+			}),
+		})
+	}
+}
+
+// rootVolumeEnlarged returns true if the root volume size is
+// specified for a VM without a persistent root filesystem. If the
+// specified size doesn't exceed the virtual size of the image,
+// growpart just doesn't do anything.
+func (g *CloudInitGenerator) rootVolumeEnlarged() bool {
+	return g.config.ParsedAnnotations.RootVolumeSize > 0 && g.config.RootVolumeDevice() == nil
+}
+
 func (g *CloudInitGenerator) generateNetworkConfiguration() ([]byte, error) {
 	if g.config.ParsedAnnotations.ForceDHCPNetworkConfig || g.config.RootVolumeDevice() != nil {
 		// Don't use cloud-init network config if asked not
@@ -413,6 +578,26 @@ func (g *CloudInitGenerator) IsoPath() string {
 	return filepath.Join(g.isoDir, fmt.Sprintf("config-%s.iso", g.config.DomainUUID))
 }
 
+// HashesPath returns a full path to the file that contains the hashes
+// of the files inside the iso image.
+func (g *CloudInitGenerator) HashesPath() string {
+	return g.IsoPath() + ".sha256"
+}
+
+// ContentHashes returns SHA256 hashes of the files inside the iso
+// image produced by GenerateImage, keyed by the paths of the files.
+func (g *CloudInitGenerator) ContentHashes() (map[string]string, error) {
+	data, err := ioutil.ReadFile(g.HashesPath())
+	if err != nil {
+		return nil, err
+	}
+	var hashes map[string]string
+	if err := json.Unmarshal(data, &hashes); err != nil {
+		return nil, fmt.Errorf("error unmarshalling %q: %v", g.HashesPath(), err)
+	}
+	return hashes, nil
+}
+
 // DiskDef returns a DomainDisk definition for Cloud Init ISO image to be included
 // in VM pod libvirt domain definition.
 func (g *CloudInitGenerator) DiskDef() *libvirtxml.DomainDisk {
@@ -428,14 +613,8 @@ func (g *CloudInitGenerator) DiskDef() *libvirtxml.DomainDisk {
 // them to prepare an ISO image for NoCloud or ConfigDrive selecting the type
 // using an info from pod annotations.
 func (g *CloudInitGenerator) GenerateImage(volumeMap diskPathMap) error {
-	tmpDir, err := ioutil.TempDir("", "config-")
-	if err != nil {
-		return fmt.Errorf("can't create temp dir for config image: %v", err)
-	}
-	defer os.RemoveAll(tmpDir)
-
 	var metaData, userData, networkConfiguration []byte
-	metaData, err = g.generateMetaData()
+	metaData, err := g.generateMetaData()
 	if err == nil {
 		userData, err = g.generateUserData(volumeMap)
 	}
@@ -474,6 +653,24 @@ func (g *CloudInitGenerator) GenerateImage(volumeMap diskPathMap) error {
 	if networkConfiguration != nil {
 		fileMap[networkConfigLocation] = networkConfiguration
 	}
+
+	hashes := make(map[string]string)
+	for location, content := range fileMap {
+		sum := sha256.Sum256(content)
+		hashes[location] = hex.EncodeToString(sum[:])
+	}
+	if g.imageUpToDate(hashes) {
+		// the image is kept when the VM is restarted,
+		// so it's only regenerated if its contents change
+		return nil
+	}
+
+	tmpDir, err := ioutil.TempDir("", "config-")
+	if err != nil {
+		return fmt.Errorf("can't create temp dir for config image: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
 	if err := fs.WriteFiles(tmpDir, fileMap); err != nil {
 		return fmt.Errorf("can't write user-data: %v", err)
 	}
@@ -489,9 +686,31 @@ func (g *CloudInitGenerator) GenerateImage(volumeMap diskPathMap) error {
 		return fmt.Errorf("error generating iso image: %v", err)
 	}
 
+	hashData, err := json.Marshal(hashes)
+	if err == nil {
+		err = ioutil.WriteFile(g.HashesPath(), hashData, 0644)
+	}
+	if err != nil {
+		// the hashes are only used for the diagnostics
+		glog.Warningf("Error writing config image hashes to %q: %v", g.HashesPath(), err)
+	}
+
 	return nil
 }
 
+// imageUpToDate returns true if the iso image exists and contains
+// the files with the specified hashes.
+func (g *CloudInitGenerator) imageUpToDate(hashes map[string]string) bool {
+	if _, err := os.Stat(g.IsoPath()); err != nil {
+		return false
+	}
+	oldHashes, err := g.ContentHashes()
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(oldHashes, hashes)
+}
+
 func (g *CloudInitGenerator) generateEnvVarsContent() string {
 	var buffer bytes.Buffer
 	for _, entry := range g.config.Environment {
@@ -501,6 +720,74 @@ func (g *CloudInitGenerator) generateEnvVarsContent() string {
 	return buffer.String()
 }
 
+// addGuestEnvironment updates the user data so that the environment
+// variables are added to /etc/environment and/or systemd
+// configuration inside the VM as requested by VirtletGuestEnvironment
+// annotation. Unlike /etc/cloud/environment, these files can't hold
+// arbitrary variables, so the variables with names that aren't valid
+// shell identifiers and multiline values are skipped.
+func (g *CloudInitGenerator) addGuestEnvironment(userData map[string]interface{}, u *writeFilesUpdater) error {
+	targets := g.config.ParsedAnnotations.GuestEnvironment
+	if len(targets) == 0 {
+		return nil
+	}
+	var vars []types.VMKeyValue
+	size := 0
+	for _, entry := range g.config.Environment {
+		if !envVarNameRx.MatchString(entry.Key) || strings.ContainsAny(entry.Value, "\n\x00") {
+			glog.Warningf("Skipping environment variable %q for the guest environment of pod %s/%s: bad name or multiline value", entry.Key, g.config.PodNamespace, g.config.PodName)
+			continue
+		}
+		vars = append(vars, entry)
+		size += len(entry.Key) + len(entry.Value) + 2
+	}
+	if size > maxGuestEnvironmentSize {
+		return fmt.Errorf("the environment variables take %d bytes which exceeds the guest environment size limit of %d bytes", size, maxGuestEnvironmentSize)
+	}
+	if len(vars) == 0 {
+		return nil
+	}
+
+	var runcmd []string
+	for _, target := range targets {
+		switch target {
+		case types.GuestEnvironmentEtcEnvironment:
+			var buf bytes.Buffer
+			for _, entry := range vars {
+				fmt.Fprintf(&buf, "%s=%s\n", entry.Key, entry.Value)
+			}
+			u.putPlainText(etcEnvFileLocation, buf.String(), 0644)
+			// the previously added variables are replaced so
+			// that they're not duplicated
+			runcmd = append(runcmd, etcEnvironmentScriptTemplate.MustExecuteToString(map[string]string{
+				"EnvFile": etcEnvFileLocation,
+			}))
+		case types.GuestEnvironmentSystemd:
+			var envD bytes.Buffer
+			var assignments []string
+			for _, entry := range vars {
+				fmt.Fprintf(&envD, "%s=%s\n", entry.Key, envDEscaper.Replace(entry.Value))
+				assignments = append(assignments, `"`+systemdEscaper.Replace(entry.Key+"="+entry.Value)+`"`)
+			}
+			u.putPlainText(envDFileLocation, envD.String(), 0644)
+			u.putPlainText(systemdEnvFileLocation, "[Manager]\nDefaultEnvironment="+strings.Join(assignments, " ")+"\n", 0644)
+			// make systemd re-read DefaultEnvironment so that
+			// it's applied to the services started after that
+			runcmd = append(runcmd, "systemctl daemon-reexec")
+		}
+	}
+	userData["runcmd"] = utils.Merge(userData["runcmd"], runcmd)
+	return nil
+}
+
+// envDEscaper escapes the characters that have special meaning
+// in environment.d files
+var envDEscaper = strings.NewReplacer(`\`, `\\`, `$`, `\$`, `"`, `\"`, `'`, `\'`, "`", "\\`")
+
+// systemdEscaper escapes the characters that have special meaning
+// inside double-quoted strings in systemd configuration files
+var systemdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
 func isRegularFile(path string) bool {
 	fi, err := os.Stat(path)
 	if err != nil {
@@ -581,6 +868,17 @@ func (g *CloudInitGenerator) generateMounts(volumeMap diskPathMap) ([]interface{
 			continue
 		}
 
+		if isBlockDevice(m.HostPath) {
+			mountInfo, mountScriptLine, err := generateHostBlockDeviceMounts(volumeMap, hostBlockDeviceUUID(g.config, m.HostPath), m)
+			if err != nil {
+				glog.Errorf("Can't mount block device %q to %q inside the VM: %v", m.HostPath, m.ContainerPath, err)
+				continue
+			}
+			r = append(r, mountInfo)
+			mountScriptLines = append(mountScriptLines, mountScriptLine)
+			continue
+		}
+
 		mountInfo, mountScriptLine, err := generateFlexvolumeMounts(volumeMap, m)
 		if err != nil {
 			if !os.IsNotExist(err) {
@@ -635,6 +933,23 @@ func generateFlexvolumeMounts(volumeMap diskPathMap, mount types.VMMount) ([]int
 	return []interface{}{devPath, mount.ContainerPath}, mountScriptLine, nil
 }
 
+// generateHostBlockDeviceMounts generates the mount entry for a
+// block device on the host that's mounted into the pod. The
+// filesystem is expected to reside on the device itself rather
+// than on one of its partitions.
+func generateHostBlockDeviceMounts(volumeMap diskPathMap, uuid string, mount types.VMMount) ([]interface{}, string, error) {
+	dpath, found := volumeMap[uuid]
+	if !found {
+		return nil, "", fmt.Errorf("no device found for volume uuid %q", uuid)
+	}
+	mountScriptLine := mountDevScriptTemplate.MustExecuteToString(map[string]string{
+		"ContainerPath": mount.ContainerPath,
+		"SysfsPath":     dpath.sysfsPath,
+		"DevSuffix":     "",
+	})
+	return []interface{}{dpath.devPath, mount.ContainerPath}, mountScriptLine, nil
+}
+
 func generateFsBasedVolumeMounts(mount types.VMMount) ([]interface{}, string, error) {
 	mountTag := path.Base(mount.ContainerPath)
 	fsMountScript := mountFSScriptTemplate.MustExecuteToString(map[string]string{
@@ -18,6 +18,8 @@ package libvirttools
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"io/ioutil"
 	"net"
@@ -25,6 +27,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 
 	cnitypes "github.com/containernetworking/cni/pkg/types"
@@ -114,11 +117,36 @@ func TestCloudInitGenerator(t *testing.T) {
 		},
 	}
 
+	swapPriority := 10
+	swapDiskConfig := &types.VMConfig{
+		PodSandboxID: "69eec606-0493-5825-73a4-c5e0c0236155",
+		PodName:      "foo",
+		PodNamespace: "default",
+		ParsedAnnotations: &types.VirtletAnnotations{
+			CDImageType: types.CloudInitImageTypeNoCloud,
+			Swap: &types.SwapSettings{
+				Type:     types.SwapDisk,
+				Size:     1073741824,
+				Priority: &swapPriority,
+			},
+		},
+	}
+
 	sharedDir := filepath.Join(tmpDir, "640ad329-e533-4ec0-820f-f11b2255bd56")
 	if err := os.MkdirAll(sharedDir, 0777); err != nil {
 		t.Fatalf("MkdirAll(): %q: %v", sharedDir, err)
 	}
 
+	// kubelet adds the fully qualified name of the pod to the
+	// hosts file if the pod has its subdomain set
+	etcHostsPath := filepath.Join(tmpDir, "etc-hosts")
+	if err := ioutil.WriteFile(etcHostsPath, []byte(
+		"# Kubernetes-managed hosts file.\n"+
+			"127.0.0.1\tlocalhost\n"+
+			"10.1.90.5\tdb-0.db.default.svc.cluster.local\tdb-0\n"), 0644); err != nil {
+		t.Fatalf("WriteFile(): %q: %v", etcHostsPath, err)
+	}
+
 	for _, tc := range []struct {
 		name                string
 		config              *types.VMConfig
@@ -147,6 +175,49 @@ func TestCloudInitGenerator(t *testing.T) {
 			},
 			verifyMetaData: true,
 		},
+		{
+			name: "pod with hostname",
+			config: &types.VMConfig{
+				PodName:           "foo",
+				PodNamespace:      "default",
+				Hostname:          "bar",
+				ParsedAnnotations: &types.VirtletAnnotations{CDImageType: types.CloudInitImageTypeNoCloud},
+			},
+			verifyMetaData: true,
+		},
+		{
+			name: "pod in a headless service",
+			config: &types.VMConfig{
+				PodName:           "db-0",
+				PodNamespace:      "default",
+				Hostname:          "db-0",
+				ParsedAnnotations: &types.VirtletAnnotations{CDImageType: types.CloudInitImageTypeNoCloud},
+				Mounts: []types.VMMount{
+					{
+						ContainerPath: "/etc/hosts",
+						HostPath:      etcHostsPath,
+					},
+				},
+			},
+			verifyMetaData: true,
+			verifyUserData: true,
+		},
+		{
+			name: "pod in a headless service - configdrive",
+			config: &types.VMConfig{
+				PodName:           "db-0",
+				PodNamespace:      "default",
+				Hostname:          "db-0",
+				ParsedAnnotations: &types.VirtletAnnotations{CDImageType: types.CloudInitImageTypeConfigDrive},
+				Mounts: []types.VMMount{
+					{
+						ContainerPath: "/etc/hosts",
+						HostPath:      etcHostsPath,
+					},
+				},
+			},
+			verifyMetaData: true,
+		},
 		{
 			name: "pod with ssh keys",
 			config: &types.VMConfig{
@@ -208,6 +279,29 @@ func TestCloudInitGenerator(t *testing.T) {
 			verifyMetaData: true,
 			verifyUserData: true,
 		},
+		{
+			name: "pod with env variables and guest environment",
+			config: &types.VMConfig{
+				PodName:      "foo",
+				PodNamespace: "default",
+				ParsedAnnotations: &types.VirtletAnnotations{
+					CDImageType: types.CloudInitImageTypeNoCloud,
+					GuestEnvironment: []types.GuestEnvironmentTarget{
+						types.GuestEnvironmentEtcEnvironment,
+						types.GuestEnvironmentSystemd,
+					},
+				},
+				Environment: []types.VMKeyValue{
+					{"foo", "bar"},
+					{"GREETING", `say "hi" to $USER`},
+					// these are only written to /etc/cloud/environment
+					{"bad.name", "x"},
+					{"MULTI", "a\nb"},
+				},
+			},
+			verifyMetaData: true,
+			verifyUserData: true,
+		},
 		{
 			name: "pod with env variables and user data",
 			config: &types.VMConfig{
@@ -388,6 +482,73 @@ func TestCloudInitGenerator(t *testing.T) {
 			// make sure network config is null for the persistent rootfs case
 			verifyNetworkConfig: true,
 		},
+		{
+			name: "pod with persistent rootfs and recorded instance-id",
+			config: &types.VMConfig{
+				PodName:           "bar",
+				PodNamespace:      "default",
+				InstanceID:        "foo.default",
+				ParsedAnnotations: &types.VirtletAnnotations{CDImageType: types.CloudInitImageTypeNoCloud},
+				VolumeDevices: []types.VMVolumeDevice{
+					{
+						DevicePath: "/",
+						HostPath:   volDevs[0].HostPath,
+					},
+				},
+			},
+			verifyMetaData: true,
+		},
+		{
+			name: "pod with enlarged root volume",
+			config: &types.VMConfig{
+				PodName:      "foo",
+				PodNamespace: "default",
+				ParsedAnnotations: &types.VirtletAnnotations{
+					CDImageType:    types.CloudInitImageTypeNoCloud,
+					RootVolumeSize: 4294967296,
+				},
+			},
+			verifyUserData: true,
+		},
+		{
+			name: "pod with enlarged root volume and offline resize",
+			config: &types.VMConfig{
+				PodName:      "foo",
+				PodNamespace: "default",
+				ParsedAnnotations: &types.VirtletAnnotations{
+					CDImageType:    types.CloudInitImageTypeNoCloud,
+					RootVolumeSize: 4294967296,
+					RootFSGrowMode: types.RootFSGrowOffline,
+				},
+			},
+			verifyUserData: true,
+		},
+		{
+			name:   "pod with swap disk",
+			config: swapDiskConfig,
+			volumeMap: diskPathMap{
+				swapVolumeUUID(swapDiskConfig): {
+					devPath:   "/dev/disk/by-path/virtio-pci-0000:00:01.0-scsi-0:0:0:1",
+					sysfsPath: "/sys/devices/pci0000:00/0000:00:03.0/virtio*/host*/target*:0:0/*:0:0:1/block/",
+				},
+			},
+			verifyUserData: true,
+		},
+		{
+			name: "pod with zram swap",
+			config: &types.VMConfig{
+				PodName:      "foo",
+				PodNamespace: "default",
+				ParsedAnnotations: &types.VirtletAnnotations{
+					CDImageType: types.CloudInitImageTypeNoCloud,
+					Swap: &types.SwapSettings{
+						Type: types.SwapZram,
+						Size: 536870912,
+					},
+				},
+			},
+			verifyUserData: true,
+		},
 		{
 			name: "pod with forced dhcp network config",
 			config: &types.VMConfig{
@@ -834,10 +995,32 @@ func TestCloudInitGenerateImage(t *testing.T) {
 			if !reflect.DeepEqual(m, tc.expectedFiles) {
 				t.Errorf("Bad iso content:\n%s", spew.Sdump(m))
 			}
+
+			expectedHashes := make(map[string]string)
+			collectFileHashes("", tc.expectedFiles, expectedHashes)
+			hashes, err := g.ContentHashes()
+			if err != nil {
+				t.Fatalf("ContentHashes(): %v", err)
+			}
+			if !reflect.DeepEqual(hashes, expectedHashes) {
+				t.Errorf("Bad content hashes:\n%s\ninstead of\n%s", spew.Sdump(hashes), spew.Sdump(expectedHashes))
+			}
 		})
 	}
 }
 
+func collectFileHashes(prefix string, files map[string]interface{}, hashes map[string]string) {
+	for name, v := range files {
+		switch content := v.(type) {
+		case string:
+			sum := sha256.Sum256([]byte(content))
+			hashes[prefix+name] = hex.EncodeToString(sum[:])
+		case map[string]interface{}:
+			collectFileHashes(prefix+name+"/", content, hashes)
+		}
+	}
+}
+
 func TestEnvDataGeneration(t *testing.T) {
 	expected := "key=value\n"
 	g := NewCloudInitGenerator(&types.VMConfig{
@@ -852,6 +1035,22 @@ func TestEnvDataGeneration(t *testing.T) {
 	}
 }
 
+func TestGuestEnvironmentSizeLimit(t *testing.T) {
+	g := NewCloudInitGenerator(&types.VMConfig{
+		ParsedAnnotations: &types.VirtletAnnotations{
+			GuestEnvironment: []types.GuestEnvironmentTarget{types.GuestEnvironmentSystemd},
+		},
+		Environment: []types.VMKeyValue{
+			{Key: "FOO", Value: strings.Repeat("x", maxGuestEnvironmentSize)},
+		},
+	}, "")
+
+	_, err := g.generateUserData(nil)
+	if err == nil || !strings.Contains(err.Error(), "size limit") {
+		t.Errorf("didn't get the expected size limit error, got: %v", err)
+	}
+}
+
 func verifyWriteFiles(t *testing.T, u *writeFilesUpdater, expectedWriteFiles ...interface{}) {
 	userData := make(map[string]interface{})
 	u.updateUserData(userData)
@@ -49,6 +49,10 @@ func (v *configVolume) IsDisk() bool { return true }
 
 func (v *configVolume) UUID() string { return "" }
 
+func (v *configVolume) PodVolumeName() string { return "cloud-init" }
+
+func (v *configVolume) IsRetainable() bool { return true }
+
 func (v *configVolume) cloudInitGenerator() *CloudInitGenerator {
 	return NewCloudInitGenerator(v.config, configIsoDir)
 }
@@ -62,9 +66,11 @@ func (v *configVolume) WriteImage(volumeMap diskPathMap) error {
 }
 
 func (v *configVolume) Teardown() error {
-	isoPath := v.cloudInitGenerator().IsoPath()
-	if err := os.Remove(isoPath); err != nil && !os.IsNotExist(err) {
-		glog.Warningf("Cannot remove temporary config file %q: %v", isoPath, err)
+	g := v.cloudInitGenerator()
+	for _, path := range []string{g.IsoPath(), g.HashesPath()} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			glog.Warningf("Cannot remove temporary config file %q: %v", path, err)
+		}
 	}
 	return nil
 }
@@ -21,6 +21,8 @@ import (
 
 	"github.com/golang/glog"
 	libvirt "github.com/libvirt/libvirt-go"
+
+	"github.com/Mirantis/virtlet/pkg/faults"
 )
 
 const (
@@ -77,6 +79,22 @@ func (c *Connection) connect() error {
 }
 
 func (c *Connection) invoke(call libvirtCall) (interface{}, error) {
+	if err := faults.Inject(faults.PointLibvirt); err != nil {
+		return nil, err
+	}
+	var r interface{}
+	err := retryTransient("calling libvirt", func() error {
+		var err error
+		r, err = c.invokeOnce(call)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c *Connection) invokeOnce(call libvirtCall) (interface{}, error) {
 	for {
 		if c.conn == nil {
 			if err := c.connect(); err != nil {
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	libvirtxml "github.com/libvirt/libvirt-go-xml"
 
@@ -64,47 +65,62 @@ func (v *VirtualizationTool) UpdateCpusetsInContainerDefinition(containerID, cpu
 		return err
 	}
 
-	_, err = v.domainConn.DefineDomain(domainxml)
-	return err
+	if domain, err = v.domainConn.DefineDomain(domainxml); err != nil {
+		return err
+	}
+	return v.saveDomainDefinition(containerID, domain)
 }
 
 // UpdateCpusetsForEmulatorProcess looks through /proc for emulator process
 // to find its cgroup manager for cpusets then uses it to adjust the setting
 func (v *VirtualizationTool) UpdateCpusetsForEmulatorProcess(containerID, cpusets string) (bool, error) {
-	pidFilePath, err := v.getEmulatorPidFileLocation(containerID)
+	pid, err := v.getEmulatorPid(containerID)
+	switch {
+	case err != nil:
+		return false, err
+	case pid == "":
+		// there is no emulator yet
+		return false, nil
+	}
+
+	cm := cgroups.NewManager(pid, v.fsys)
+	controller, err := cm.GetProcessController("cpuset")
 	if err != nil {
 		return false, err
 	}
 
+	if err := controller.Set("cpus", cpusets); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// getEmulatorPid returns the pid of the emulator process for the
+// specified container, or an empty string if the emulator isn't
+// running.
+func (v *VirtualizationTool) getEmulatorPid(containerID string) (string, error) {
+	pidFilePath, err := v.getEmulatorPidFileLocation(containerID)
+	if err != nil {
+		return "", err
+	}
+
 	f, err := v.fsys.GetDelimitedReader(pidFilePath)
 	if err != nil {
 		// File not found - so there is no emulator yet
 		if _, ok := err.(*os.PathError); ok {
-			return false, nil
+			return "", nil
 		}
-		return false, err
+		return "", err
 	}
 	defer f.Close()
 
 	// there should be only a single line without eol, but use eol as
 	// a marker to read data to EOF.
 	pid, err := f.ReadString('\n')
-	if err != nil {
-		if err != io.EOF {
-			return false, err
-		}
-	}
-
-	cm := cgroups.NewManager(pid, v.fsys)
-	controller, err := cm.GetProcessController("cpuset")
-	if err != nil {
-		return false, err
-	}
-
-	if err := controller.Set("cpus", cpusets); err != nil {
-		return false, err
+	if err != nil && err != io.EOF {
+		return "", err
 	}
-	return true, nil
+	return strings.TrimSpace(pid), nil
 }
 
 func (v *VirtualizationTool) getEmulatorPidFileLocation(containerID string) (string, error) {
@@ -0,0 +1,202 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+// volumeFingerprint returns a string that identifies the contents of
+// the root volume of the VM right after it's set up. The retained
+// volumes are only reused for a VM with the same fingerprint.
+func (v *VirtualizationTool) volumeFingerprint(config *types.VMConfig) (string, error) {
+	_, imageDigest, _, err := v.imageManager.GetImagePathDigestAndVirtualSize(config.Image)
+	if err != nil {
+		return "", err
+	}
+	va := config.ParsedAnnotations
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%d\n%s\n%v\n", config.PodSandboxID, imageDigest, va.RootVolumeSize, va.RootFSGrowMode, va.ReadOnlyRootfs)
+	var paths []string
+	for path := range va.InjectedFiles {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(h, "%s\n%x\n", path, sha256.Sum256(va.InjectedFiles[path]))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// shouldRetainVolumes returns true if the retainable volumes of the
+// VM that's in the specified state must be kept when the VM is
+// stopped or removed. Only the volumes of the VMs that have exited
+// are kept, as that's what happens when the VM is crash-looping.
+func (v *VirtualizationTool) shouldRetainVolumes(state types.ContainerState) bool {
+	return v.config.VolumeReuseTTL > 0 && state == types.ContainerState_CONTAINER_EXITED
+}
+
+// shouldRetainVolumesOnRemoval returns true if the retainable volumes
+// of the VM must be kept when it's removed. Besides the crash-looping
+// VMs, this includes the exited VMs with soft reboot enabled, so that
+// the disks of the VM survive the container being re-created by
+// kubelet.
+func (v *VirtualizationTool) shouldRetainVolumesOnRemoval(config *types.VMConfig, state types.ContainerState) bool {
+	if state == types.ContainerState_CONTAINER_EXITED && config.ParsedAnnotations != nil && config.ParsedAnnotations.SoftReboot {
+		return true
+	}
+	return v.shouldRetainVolumes(state)
+}
+
+// expired returns true if the retained volumes can no longer be
+// reused. The volumes kept because of the soft reboot expire along
+// with the pod sandbox they're recorded in.
+func (v *VirtualizationTool) expired(retained *types.RetainedVolumeSet) bool {
+	if retained.SoftReboot {
+		return false
+	}
+	return v.clock.Since(time.Unix(0, retained.RetainedAt)) >= v.config.VolumeReuseTTL
+}
+
+// retainVolumes records in the pod sandbox metadata that the
+// retainable volumes of the removed container are kept, so the
+// record survives Virtlet restarts. It returns false if the volumes
+// can't be retained and must be torn down.
+func (v *VirtualizationTool) retainVolumes(containerID string, config *types.VMConfig) bool {
+	fingerprint, err := v.volumeFingerprint(config)
+	if err != nil {
+		glog.Warningf("Not keeping the volumes of container %s for reuse: %v", containerID, err)
+		return false
+	}
+	retained := &types.RetainedVolumeSet{
+		ContainerID: containerID,
+		RetainedAt:  v.clock.Now().UnixNano(),
+		Fingerprint: fingerprint,
+		SoftReboot:  config.ParsedAnnotations != nil && config.ParsedAnnotations.SoftReboot,
+	}
+	found := false
+	if err := v.metadataStore.PodSandbox(config.PodSandboxID).Save(
+		func(c *types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+			if c != nil {
+				found = true
+				c.RetainedVolumes = retained
+			}
+			return c, nil
+		}); err != nil {
+		glog.Warningf("Not keeping the volumes of container %s for reuse: %v", containerID, err)
+		return false
+	}
+	if !found {
+		glog.Warningf("Not keeping the volumes of container %s for reuse: pod sandbox %s doesn't exist", containerID, config.PodSandboxID)
+		return false
+	}
+	if retained.SoftReboot {
+		glog.V(2).Infof("Soft reboot enabled for container %s, keeping the domain and its volumes while the pod sandbox exists", containerID)
+	} else {
+		glog.V(2).Infof("Keeping the root volume, the ephemeral disks and the config drive of container %s for %v", containerID, v.config.VolumeReuseTTL)
+	}
+	return true
+}
+
+// retainedVolumeSet returns the record of the volumes retained for
+// the VM, or nil if there's none.
+func (v *VirtualizationTool) retainedVolumeSet(config *types.VMConfig) (*types.RetainedVolumeSet, error) {
+	sandbox, err := v.metadataStore.PodSandbox(config.PodSandboxID).Retrieve()
+	if err != nil {
+		return nil, err
+	}
+	if sandbox == nil || sandbox.RetainedVolumes == nil || sandbox.RetainedVolumes.ContainerID != config.DomainUUID {
+		return nil, nil
+	}
+	return sandbox.RetainedVolumes, nil
+}
+
+// checkRetainedVolumes is called by the retainable volumes of the VM
+// that's being created. It returns true as the first value if the
+// retained volumes can be reused for the VM, and true as the second
+// value if there are any retained volumes for the VM, in which case
+// the volumes that can't be reused must be removed before being set
+// up again. The record of the retained volumes is removed by
+// forgetRetainedVolumes after all of the volumes are set up.
+func (v *VirtualizationTool) checkRetainedVolumes(config *types.VMConfig) (bool, bool) {
+	retained, err := v.retainedVolumeSet(config)
+	if err != nil {
+		glog.Warningf("Can't reuse the volumes of container %s: %v", config.DomainUUID, err)
+		return false, true
+	}
+	if retained == nil {
+		return false, false
+	}
+	if v.expired(retained) {
+		return false, true
+	}
+	fingerprint, err := v.volumeFingerprint(config)
+	if err != nil {
+		glog.Warningf("Can't reuse the volumes of container %s: %v", config.DomainUUID, err)
+		return false, true
+	}
+	if fingerprint != retained.Fingerprint {
+		glog.V(2).Infof("Not reusing the volumes of container %s because the VM config has changed", config.DomainUUID)
+		return false, true
+	}
+	return true, true
+}
+
+// forgetRetainedVolumes removes the record of the volumes retained
+// for the VM from the pod sandbox metadata after the VM is re-created.
+func (v *VirtualizationTool) forgetRetainedVolumes(config *types.VMConfig) {
+	if err := v.metadataStore.PodSandbox(config.PodSandboxID).Save(
+		func(c *types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+			if c != nil && c.RetainedVolumes != nil && c.RetainedVolumes.ContainerID == config.DomainUUID {
+				c.RetainedVolumes = nil
+			}
+			return c, nil
+		}); err != nil {
+		glog.Warningf("Can't forget the retained volumes of container %s: %v", config.DomainUUID, err)
+	}
+}
+
+// retainedVolumeIDs returns the ids of the removed containers whose
+// retained volumes haven't expired yet. The expired volumes, as well
+// as those recorded in the removed pod sandboxes, are then removed by
+// the garbage collector.
+func (v *VirtualizationTool) retainedVolumeIDs() ([]string, error) {
+	sandboxes, err := v.metadataStore.ListPodSandboxes(nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list pod sandboxes: %v", err)
+	}
+	var ids []string
+	for _, sandbox := range sandboxes {
+		psi, err := sandbox.Retrieve()
+		if err != nil {
+			return nil, fmt.Errorf("cannot retrieve pod sandbox %s: %v", sandbox.GetID(), err)
+		}
+		if psi != nil && psi.RetainedVolumes != nil && !v.expired(psi.RetainedVolumes) {
+			ids = append(ids, psi.RetainedVolumes.ContainerID)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Mirantis/virtlet/pkg/flexvolume"
+	"github.com/Mirantis/virtlet/pkg/fs"
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/utils"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+func (ct *containerTester) storageVolume(name string) virt.StorageVolume {
+	pool, err := ct.virtTool.StoragePool()
+	if err != nil {
+		ct.t.Fatalf("StoragePool(): %v", err)
+	}
+	vol, err := pool.LookupVolumeByName(name)
+	if err == virt.ErrStorageVolumeNotFound {
+		return nil
+	}
+	if err != nil {
+		ct.t.Fatalf("LookupVolumeByName(): %v", err)
+	}
+	return vol
+}
+
+func (ct *containerTester) rootVolume(containerID string) virt.StorageVolume {
+	return ct.storageVolume("virtlet_root_" + containerID)
+}
+
+func (ct *containerTester) mountQcow2Volume(sandbox *types.PodSandboxConfig, name string) {
+	flexVolumeDriver := flexvolume.NewDriver(func() string { return fakeUUID }, fs.NullFileSystem)
+	targetDir := filepath.Join(ct.kubeletRootDir, sandbox.Uid, "volumes/virtlet~flexvolume_driver", name)
+	var r map[string]interface{}
+	if err := json.Unmarshal([]byte(flexVolumeDriver.Run([]string{"mount", targetDir, utils.ToJSON(map[string]interface{}{"type": "qcow2"})})), &r); err != nil {
+		ct.t.Fatalf("failed to unmarshal flexvolume driver result: %v", err)
+	}
+	if r["status"] != "Success" {
+		ct.t.Fatalf("mounting flexvolume failed: %s", r["message"])
+	}
+}
+
+func (ct *containerTester) retainedVolumeIDs() []string {
+	ids, err := ct.retainedVolumeIDs()
+	if err != nil {
+		ct.t.Fatalf("retainedVolumeIDs(): %v", err)
+	}
+	return ids
+}
+
+// restartVirtlet replaces the VirtualizationTool with a new one that
+// uses the same metadata store, libvirt connections and config.
+func (ct *containerTester) restartVirtlet() {
+	virtTool := NewVirtualizationTool(
+		ct.domainConn, ct.storageConn, ct.virtTool.imageManager, ct.metadataStore,
+		ct.virtTool.volumeSource, ct.virtTool.config, ct.virtTool.fsys,
+		ct.virtTool.commander)
+	virtTool.SetClock(ct.clock)
+	ct.virtTool = virtTool
+}
+
+func (ct *containerTester) crashContainer(containerID string) {
+	ct.startContainer(containerID)
+	ct.stopContainer(containerID)
+	ct.removeContainer(containerID)
+}
+
+func TestVolumeReuse(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+	ct.virtTool.config.VolumeReuseTTL = 5 * time.Minute
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	ct.setPodSandbox(sandbox)
+	ct.mountQcow2Volume(sandbox, "vol1")
+
+	containerID := ct.createContainer(sandbox, nil, nil)
+	vol := ct.rootVolume(containerID)
+	if vol == nil {
+		t.Fatalf("root volume not found after creating the container")
+	}
+	ephemeralVolName := "virtlet-" + containerID + "-vol1"
+	ephemeralVol := ct.storageVolume(ephemeralVolName)
+	if ephemeralVol == nil {
+		t.Fatalf("ephemeral volume not found after creating the container")
+	}
+	ct.crashContainer(containerID)
+	if ct.rootVolume(containerID) != vol {
+		t.Errorf("root volume was not kept after removing the exited container")
+	}
+	if ct.storageVolume(ephemeralVolName) != ephemeralVol {
+		t.Errorf("ephemeral volume was not kept after removing the exited container")
+	}
+	if ids := ct.retainedVolumeIDs(); !reflect.DeepEqual(ids, []string{containerID}) {
+		t.Errorf("bad retained volume ids: %v", ids)
+	}
+
+	// the retained volumes are recorded in the metadata store
+	ct.restartVirtlet()
+	if ids := ct.retainedVolumeIDs(); !reflect.DeepEqual(ids, []string{containerID}) {
+		t.Errorf("bad retained volume ids after Virtlet restart: %v", ids)
+	}
+
+	ct.clock.Advance(time.Minute)
+	if newContainerID := ct.createContainer(sandbox, nil, nil); newContainerID != containerID {
+		t.Fatalf("container id changed after re-creating the container: %q instead of %q", newContainerID, containerID)
+	}
+	if ct.rootVolume(containerID) != vol {
+		t.Errorf("root volume was not reused after re-creating the container")
+	}
+	if ct.storageVolume(ephemeralVolName) != ephemeralVol {
+		t.Errorf("ephemeral volume was not reused after re-creating the container")
+	}
+	if ids := ct.retainedVolumeIDs(); len(ids) != 0 {
+		t.Errorf("retained volumes were not claimed by the new container: %v", ids)
+	}
+
+	ct.crashContainer(containerID)
+	ct.clock.Advance(10 * time.Minute)
+	ct.createContainer(sandbox, nil, nil)
+	newVol := ct.rootVolume(containerID)
+	if newVol == nil || newVol == vol {
+		t.Errorf("expired root volume was reused after re-creating the container")
+	}
+	if newVol := ct.storageVolume(ephemeralVolName); newVol == nil || newVol == ephemeralVol {
+		t.Errorf("expired ephemeral volume was reused after re-creating the container")
+	}
+
+	// the expired volumes are removed by the garbage collector
+	ct.crashContainer(containerID)
+	ct.clock.Advance(10 * time.Minute)
+	if errors := ct.virtTool.removeOrphanRootVolumes(ct.retainedVolumeIDs()); len(errors) != 0 {
+		t.Errorf("removeOrphanRootVolumes returned errors: %v", errors)
+	}
+	if ct.rootVolume(containerID) != nil {
+		t.Errorf("expired root volume was not removed")
+	}
+}
+
+func TestVolumeReuseDisabled(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	ct.setPodSandbox(sandbox)
+
+	containerID := ct.createContainer(sandbox, nil, nil)
+	ct.crashContainer(containerID)
+	if ct.rootVolume(containerID) != nil {
+		t.Errorf("root volume was kept with the volume reuse disabled")
+	}
+	if ids := ct.retainedVolumeIDs(); len(ids) != 0 {
+		t.Errorf("unexpected retained volume ids: %v", ids)
+	}
+}
@@ -17,13 +17,18 @@ limitations under the License.
 package libvirttools
 
 // GetDefaultVolumeSource returns a volume source that supports
-// root volume, flexvolumes and a ConfigSource for cloud-init
+// root volume, block devices, host devices, flexvolumes, filesystem
+// mounts, swap disk, CD-ROM images and a ConfigSource for cloud-init
 func GetDefaultVolumeSource() VMVolumeSource {
 	return CombineVMVolumeSources(
 		GetRootVolume,
 		GetBlockVolumes,
+		GetHostBlockDeviceVolumes,
+		GetHostDeviceVolumes,
 		ScanFlexVolumes,
 		GetFileSystemVolumes,
+		GetSwapVolume,
+		GetCDROMVolumes,
 		// XXX: GetConfigVolume must go last because it
 		// doesn't produce correct name for cdrom devices
 		GetConfigVolume)
@@ -0,0 +1,130 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const (
+	// DeletionConfirmationGracePeriod is the time during which the
+	// confirmation made using 'virtletctl confirm-delete' allows
+	// Virtlet to remove the persistent volumes of the VM.
+	DeletionConfirmationGracePeriod = 10 * time.Minute
+)
+
+var deletionConfirmationDir = "/var/lib/virtlet/deletion-confirmations"
+
+// SetDeletionConfirmationDir sets the directory that holds the
+// volume deletion confirmations. It can be useful in tests.
+func SetDeletionConfirmationDir(dir string) {
+	deletionConfirmationDir = dir
+}
+
+// DeletionConfirmationPath returns the path of the file that
+// confirms the deletion of the persistent volumes of the pod with
+// the specified sandbox id (pod UID). The file contains the time
+// of the confirmation as the number of seconds since the epoch.
+func DeletionConfirmationPath(podSandboxID string) string {
+	return filepath.Join(deletionConfirmationDir, podSandboxID)
+}
+
+// persistentVolumeNames returns the names of the volumes in the list
+// that are marked as persistent.
+func (dl *diskList) persistentVolumeNames() []string {
+	var names []string
+	for _, item := range dl.items {
+		if isPersistentVolume(item.volume) {
+			names = append(names, podVolumeName(item.volume))
+		}
+	}
+	return names
+}
+
+// deletionConfirmed returns true if there's a non-expired
+// confirmation of the deletion of the persistent volumes of the pod.
+func (v *VirtualizationTool) deletionConfirmed(config *types.VMConfig) (bool, error) {
+	if config.PodSandboxID == "" {
+		return false, nil
+	}
+	path := DeletionConfirmationPath(config.PodSandboxID)
+	content, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("can't read deletion confirmation file %q: %v", path, err)
+	}
+	ts, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("bad deletion confirmation file %q: %v", path, err)
+	}
+	return v.clock.Now().Sub(time.Unix(ts, 0)) <= DeletionConfirmationGracePeriod, nil
+}
+
+// checkVolumeDeletion returns an error if the VM has volumes that are
+// marked as persistent and the deletion of these volumes wasn't
+// confirmed either using VirtletConfirmVolumeDeletion pod annotation
+// or 'virtletctl confirm-delete' command. In this case, the domain and
+// its volumes are kept until the deletion is confirmed and kubelet
+// retries the container removal.
+func (v *VirtualizationTool) checkVolumeDeletion(containerID string, config *types.VMConfig) error {
+	if config.ParsedAnnotations != nil && config.ParsedAnnotations.ConfirmVolumeDeletion {
+		return nil
+	}
+	names := config.PersistentVolumes
+	if len(names) == 0 {
+		return nil
+	}
+	confirmed, err := v.deletionConfirmed(config)
+	if err != nil {
+		return err
+	}
+	if confirmed {
+		return nil
+	}
+	glog.Warningf("Not removing container %s of pod %s/%s: the deletion of persistent volumes %s is not confirmed",
+		containerID, config.PodNamespace, config.PodName, strings.Join(names, ", "))
+	v.eventRecorder.Eventf(config, v1.EventTypeWarning, "VolumeDeletionNotConfirmed",
+		"The VM and its persistent volumes %s are kept until their deletion is confirmed using 'virtletctl confirm-delete' (pod UID %s)",
+		strings.Join(names, ", "), config.PodSandboxID)
+	return fmt.Errorf("the deletion of persistent volumes %s of pod %s/%s is not confirmed",
+		strings.Join(names, ", "), config.PodNamespace, config.PodName)
+}
+
+// removeDeletionConfirmation removes the volume deletion confirmation
+// for the pod, if any.
+func removeDeletionConfirmation(config *types.VMConfig) error {
+	if config.PodSandboxID == "" {
+		return nil
+	}
+	if err := os.Remove(DeletionConfirmationPath(config.PodSandboxID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mirantis/virtlet/pkg/flexvolume"
+	"github.com/Mirantis/virtlet/pkg/fs"
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	"github.com/Mirantis/virtlet/pkg/utils"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+)
+
+func TestVolumeDeletionConfirmation(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// annotations are the pod annotations to set
+		annotations map[string]string
+		// persistent is true if the volume is marked as persistent
+		persistent bool
+		// confirmedAgo is the time that has passed since the
+		// confirmation of the volume deletion. Negative value
+		// means that the deletion wasn't confirmed.
+		confirmedAgo   time.Duration
+		expectedEvents []string
+		errSubstring   string
+	}{
+		{
+			name:         "no persistent volumes",
+			confirmedAgo: -1,
+		},
+		{
+			name:         "persistent volume, deletion not confirmed",
+			persistent:   true,
+			confirmedAgo: -1,
+			expectedEvents: []string{
+				"default/testName_0 Warning VolumeDeletionNotConfirmed: The VM and its persistent volumes vol1 are kept until their deletion is confirmed using 'virtletctl confirm-delete' (pod UID 69eec606-0493-5825-73a4-c5e0c0236155)",
+			},
+			errSubstring: "the deletion of persistent volumes vol1 of pod default/testName_0 is not confirmed",
+		},
+		{
+			name:         "persistent volume, deletion confirmed using the annotation",
+			annotations:  map[string]string{"VirtletConfirmVolumeDeletion": "true"},
+			persistent:   true,
+			confirmedAgo: -1,
+		},
+		{
+			name:         "persistent volume, deletion confirmed using virtletctl",
+			persistent:   true,
+			confirmedAgo: time.Minute,
+		},
+		{
+			name:         "persistent volume, deletion confirmation expired",
+			persistent:   true,
+			confirmedAgo: DeletionConfirmationGracePeriod + time.Minute,
+			expectedEvents: []string{
+				"default/testName_0 Warning VolumeDeletionNotConfirmed: The VM and its persistent volumes vol1 are kept until their deletion is confirmed using 'virtletctl confirm-delete' (pod UID 69eec606-0493-5825-73a4-c5e0c0236155)",
+			},
+			errSubstring: "is not confirmed",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+			defer ct.teardown()
+			recorder := &fakeEventRecorder{}
+			ct.virtTool.SetEventRecorder(recorder)
+
+			sandbox := fakemeta.GetSandboxes(1)[0]
+			for k, v := range tc.annotations {
+				sandbox.Annotations[k] = v
+			}
+			ct.setPodSandbox(sandbox)
+
+			flexVolumeDriver := flexvolume.NewDriver(func() string { return fakeUUID }, fs.NullFileSystem)
+			opts := map[string]interface{}{"type": "qcow2"}
+			if tc.persistent {
+				opts["persistent"] = "true"
+			}
+			targetDir := filepath.Join(ct.kubeletRootDir, sandbox.Uid, "volumes/virtlet~flexvolume_driver", "vol1")
+			var r map[string]interface{}
+			if err := json.Unmarshal([]byte(flexVolumeDriver.Run([]string{"mount", targetDir, utils.ToJSON(opts)})), &r); err != nil {
+				t.Fatalf("failed to unmarshal flexvolume driver result: %v", err)
+			}
+			if r["status"] != "Success" {
+				t.Fatalf("mounting flexvolume failed: %s", r["message"])
+			}
+
+			containerID := ct.createContainer(sandbox, nil, nil)
+			ct.startContainer(containerID)
+			ct.stopContainer(containerID)
+
+			// the flexvolume is unmounted by kubelet after the VM is stopped
+			if err := os.RemoveAll(targetDir); err != nil {
+				t.Fatalf("RemoveAll(): %v", err)
+			}
+
+			confirmationPath := DeletionConfirmationPath(sandbox.Uid)
+			if tc.confirmedAgo >= 0 {
+				if err := os.MkdirAll(filepath.Dir(confirmationPath), 0755); err != nil {
+					t.Fatalf("MkdirAll(): %v", err)
+				}
+				ts := ct.clock.Now().Add(-tc.confirmedAgo).Unix()
+				if err := ioutil.WriteFile(confirmationPath, []byte(fmt.Sprintf("%d\n", ts)), 0644); err != nil {
+					t.Fatalf("WriteFile(): %v", err)
+				}
+			}
+
+			volumeName := "virtlet-" + containerID + "-vol1"
+			storagePool, err := ct.storageConn.LookupStoragePoolByName("volumes")
+			if err != nil {
+				t.Fatalf("can't find 'volumes' storage pool: %v", err)
+			}
+
+			err = ct.virtTool.RemoveContainer(containerID)
+			switch {
+			case err != nil && tc.errSubstring == "":
+				t.Fatalf("RemoveContainer(): %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Fatalf("RemoveContainer() didn't return the expected error (substring %q)", tc.errSubstring)
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Fatalf("RemoveContainer() returned an error %q that doesn't contain %q", err, tc.errSubstring)
+			}
+
+			_, volErr := storagePool.LookupVolumeByName(volumeName)
+			containerInfo, err := ct.metadataStore.Container(containerID).Retrieve()
+			if err != nil {
+				t.Fatalf("can't retrieve container info: %v", err)
+			}
+			if tc.errSubstring != "" {
+				if volErr != nil {
+					t.Errorf("persistent volume was removed without a confirmation")
+				}
+				if _, err := ct.domainConn.LookupDomainByUUIDString(containerID); err != nil {
+					t.Errorf("the domain was removed without a confirmation")
+				}
+				if containerInfo == nil {
+					t.Errorf("the container was removed from the metadata store without a confirmation")
+				}
+			} else {
+				// the volume itself is removed by the GC after the
+				// container is removed from the metadata store
+				if containerInfo != nil {
+					t.Errorf("the container was not removed from the metadata store")
+				}
+				if _, err := os.Stat(confirmationPath); !os.IsNotExist(err) {
+					t.Errorf("the confirmation file was not removed")
+				}
+			}
+
+			if !reflect.DeepEqual(recorder.events, tc.expectedEvents) {
+				t.Errorf("bad events:\n%#v\ninstead of\n%#v", recorder.events, tc.expectedEvents)
+			}
+		})
+	}
+}
@@ -17,11 +17,14 @@ limitations under the License.
 package libvirttools
 
 import (
+	"encoding/json"
 	"fmt"
 
+	"github.com/golang/glog"
 	libvirtxml "github.com/libvirt/libvirt-go-xml"
 
 	"github.com/Mirantis/virtlet/pkg/diag"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
 	"github.com/Mirantis/virtlet/pkg/virt"
 )
 
@@ -126,3 +129,102 @@ func addLibvirtXMLToDiagResult(objType, name string, xml libvirtxml.Document, dr
 	}
 	return nil
 }
+
+// DiskStatsDiagSource dumps IO statistics for the disks of the
+// running VMs, one JSON file per VM.
+type DiskStatsDiagSource struct {
+	virtTool *VirtualizationTool
+}
+
+var _ diag.Source = &DiskStatsDiagSource{}
+
+// NewDiskStatsDiagSource creates a new DiskStatsDiagSource.
+func NewDiskStatsDiagSource(virtTool *VirtualizationTool) *DiskStatsDiagSource {
+	return &DiskStatsDiagSource{virtTool: virtTool}
+}
+
+// DiagnosticInfo implements DiagnosticInfo method of the Source
+// interface.
+func (s *DiskStatsDiagSource) DiagnosticInfo() (diag.Result, error) {
+	dr := diag.Result{
+		IsDir:    true,
+		Children: make(map[string]diag.Result),
+	}
+	containers, err := s.virtTool.ListContainers(nil)
+	if err != nil {
+		return diag.Result{}, err
+	}
+	for _, c := range containers {
+		if c.State != types.ContainerState_CONTAINER_RUNNING {
+			continue
+		}
+		// a VM may go away or become unresponsive while the
+		// stats are being collected, which must not prevent
+		// the stats of the other VMs from being dumped
+		stats, err := s.virtTool.DiskStats(c.Id)
+		if err != nil {
+			glog.Warningf("Error getting disk stats for container %q: %v", c.Id, err)
+			continue
+		}
+		out, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return diag.Result{}, fmt.Errorf("error marshalling disk stats: %v", err)
+		}
+		fileName := fmt.Sprintf("%s-%s", c.Config.PodNamespace, c.Config.PodName)
+		dr.Children[fileName] = diag.Result{
+			Name: fileName,
+			Ext:  "json",
+			Data: string(out),
+		}
+	}
+	return dr, nil
+}
+
+// EmulatorInfoDiagSource dumps the information about the emulator
+// processes of the running VMs, one JSON file per VM.
+type EmulatorInfoDiagSource struct {
+	virtTool *VirtualizationTool
+}
+
+var _ diag.Source = &EmulatorInfoDiagSource{}
+
+// NewEmulatorInfoDiagSource creates a new EmulatorInfoDiagSource.
+func NewEmulatorInfoDiagSource(virtTool *VirtualizationTool) *EmulatorInfoDiagSource {
+	return &EmulatorInfoDiagSource{virtTool: virtTool}
+}
+
+// DiagnosticInfo implements DiagnosticInfo method of the Source
+// interface.
+func (s *EmulatorInfoDiagSource) DiagnosticInfo() (diag.Result, error) {
+	dr := diag.Result{
+		IsDir:    true,
+		Children: make(map[string]diag.Result),
+	}
+	containers, err := s.virtTool.ListContainers(nil)
+	if err != nil {
+		return diag.Result{}, err
+	}
+	for _, c := range containers {
+		if c.State != types.ContainerState_CONTAINER_RUNNING {
+			continue
+		}
+		info, err := s.virtTool.EmulatorInfo(c.Id)
+		switch {
+		case err != nil:
+			return diag.Result{}, err
+		case info == nil:
+			continue
+		}
+		out, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return diag.Result{}, fmt.Errorf("error marshalling emulator info: %v", err)
+		}
+		fileName := fmt.Sprintf("%s-%s", c.Config.PodNamespace, c.Config.PodName)
+		dr.Children[fileName] = diag.Result{
+			Name: fileName,
+			Ext:  "json",
+			Data: string(out),
+		}
+	}
+	return dr, nil
+}
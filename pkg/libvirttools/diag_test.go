@@ -17,10 +17,13 @@ limitations under the License.
 package libvirttools
 
 import (
+	"errors"
+	"reflect"
 	"testing"
 
 	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
 	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+	"github.com/Mirantis/virtlet/pkg/virt/fake"
 	"github.com/Mirantis/virtlet/tests/gm"
 )
 
@@ -44,3 +47,36 @@ func TestDump(t *testing.T) {
 	}
 	gm.Verify(t, gm.NewYamlVerifier(dr))
 }
+
+func TestDiskStatsDump(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+
+	sandboxes := fakemeta.GetSandboxes(2)
+	var containerIDs []string
+	for _, sandbox := range sandboxes {
+		ct.setPodSandbox(sandbox)
+		containerID := ct.createContainer(sandbox, nil, nil)
+		ct.startContainer(containerID)
+		containerIDs = append(containerIDs, containerID)
+	}
+
+	// the VM the stats can't be taken for is skipped
+	domain, err := ct.domainConn.LookupDomainByUUIDString(containerIDs[0])
+	if err != nil {
+		t.Fatalf("LookupDomainByUUIDString(): %v", err)
+	}
+	domain.(*fake.FakeDomain).SetBlockStatsError(errors.New("domain is not running"))
+
+	dr, err := NewDiskStatsDiagSource(ct.virtTool).DiagnosticInfo()
+	if err != nil {
+		t.Fatalf("DiagnosticInfo(): %v", err)
+	}
+	var names []string
+	for name := range dr.Children {
+		names = append(names, name)
+	}
+	if expected := []string{sandboxes[1].Namespace + "-" + sandboxes[1].Name}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("bad disk stats files: %v instead of %v", names, expected)
+	}
+}
@@ -33,6 +33,17 @@ const (
 	// the root volume), but we want to be on the safe side here
 	maxVirtioBlockDevChar = 'u'
 	maxScsiBlockDevChar   = 'z'
+	// The emulated IDE controller has 2 buses with 2 units on each
+	maxIdeBlockDevChar = 'd'
+	// The address of the IDE controller built into the PIIX3
+	// chipset which is used by the i440fx machine type
+	ideControllerPCIAddress = "0000:00:01.1"
+	// The emulated AHCI controller has 6 ports
+	maxSataPorts = 6
+	// The ATA ports of the AHCI controller are numbered after
+	// the ones of the PIIX3 IDE controller which is always
+	// present in the i440fx machine used by Virtlet
+	sataATAPortOffset = 2
 )
 
 type diskDriver interface {
@@ -41,11 +52,32 @@ type diskDriver interface {
 	address() *libvirtxml.DomainAddress
 }
 
-type diskDriverFactory func(n int) (diskDriver, error)
+// diskDriverFactory makes a diskDriver for the disk number n on the
+// bus. devIndex is the index of the disk among the ones that share
+// the device name prefix, see diskDevNamePrefix().
+type diskDriverFactory func(n, devIndex int) (diskDriver, error)
 
 var diskDriverMap = map[types.DiskDriverName]diskDriverFactory{
 	types.DiskDriverVirtio: virtioBlkDriverFactory,
 	types.DiskDriverScsi:   scsiDriverFactory,
+	types.DiskDriverIde:    ideDriverFactory,
+	types.DiskDriverSata:   sataDriverFactory,
+	types.DiskDriverUSB:    usbDriverFactory,
+}
+
+// diskDevNamePrefix returns the prefix of the target device names
+// for the specified disk driver. The device names must be unique
+// within the domain, so the scsi, sata and usb disks, which all
+// use sdX names, share the device letters.
+func diskDevNamePrefix(name types.DiskDriverName) string {
+	switch name {
+	case types.DiskDriverVirtio:
+		return "vd"
+	case types.DiskDriverIde:
+		return "hd"
+	default:
+		return "sd"
+	}
 }
 
 type virtioBlkDriver struct {
@@ -53,8 +85,8 @@ type virtioBlkDriver struct {
 	diskChar int
 }
 
-func virtioBlkDriverFactory(n int) (diskDriver, error) {
-	diskChar := minBlockDevChar + n
+func virtioBlkDriverFactory(n, devIndex int) (diskDriver, error) {
+	diskChar := minBlockDevChar + devIndex
 	if diskChar > maxVirtioBlockDevChar {
 		return nil, errors.New("too many virtio block devices")
 	}
@@ -66,7 +98,7 @@ func (d *virtioBlkDriver) diskPath(domainDef *libvirtxml.Domain) (*diskPath, err
 	if err != nil {
 		return nil, err
 	}
-	devPath, sysfsPath, err := pciPath(domainDef, disk.Address)
+	devPath, sysfsPath, err := pciPath(domainDef, disk.Address, "virtio-pci")
 	if err != nil {
 		return nil, err
 	}
@@ -107,8 +139,8 @@ type scsiDriver struct {
 	diskChar int
 }
 
-func scsiDriverFactory(n int) (diskDriver, error) {
-	diskChar := minBlockDevChar + n
+func scsiDriverFactory(n, devIndex int) (diskDriver, error) {
+	diskChar := minBlockDevChar + devIndex
 	if diskChar > maxScsiBlockDevChar {
 		return nil, errors.New("too many scsi block devices")
 	}
@@ -136,7 +168,7 @@ func (d *scsiDriver) diskPath(domainDef *libvirtxml.Domain) (*diskPath, error) {
 		return nil, fmt.Errorf("bad controller index for scsi disk %q", d.devName())
 	}
 
-	devPath, sysfsPath, err := pciPath(domainDef, scsiControllers[0].Address)
+	devPath, sysfsPath, err := pciPath(domainDef, scsiControllers[0].Address, "virtio-pci")
 	if err != nil {
 		return nil, err
 	}
@@ -181,6 +213,167 @@ func (d *scsiDriver) address() *libvirtxml.DomainAddress {
 	}
 }
 
+type ideDriver struct {
+	n        int
+	diskChar int
+}
+
+func ideDriverFactory(n, devIndex int) (diskDriver, error) {
+	diskChar := minBlockDevChar + devIndex
+	if diskChar > maxIdeBlockDevChar {
+		return nil, errors.New("too many ide block devices")
+	}
+	return &ideDriver{n, diskChar}, nil
+}
+
+func (d *ideDriver) diskPath(domainDef *libvirtxml.Domain) (*diskPath, error) {
+	disk, err := findDisk(domainDef, d.devName())
+	if err != nil {
+		return nil, err
+	}
+	if disk.Address.Drive == nil || disk.Address.Drive.Bus == nil || disk.Address.Drive.Unit == nil {
+		return nil, fmt.Errorf("bad disk address for ide disk %q", d.devName())
+	}
+	return &diskPath{
+		fmt.Sprintf("/dev/disk/by-path/pci-%s-ata-%d.%d", ideControllerPCIAddress, *disk.Address.Drive.Bus+1, *disk.Address.Drive.Unit),
+		fmt.Sprintf("/sys/devices/pci0000:00/%s/ata%d/host*/target*:0:%d/*:0:%d:0/block/",
+			ideControllerPCIAddress,
+			*disk.Address.Drive.Bus+1,
+			*disk.Address.Drive.Unit,
+			*disk.Address.Drive.Unit),
+	}, nil
+}
+
+func (d *ideDriver) devName() string {
+	return fmt.Sprintf("hd%c", d.diskChar)
+}
+
+func (d *ideDriver) target() *libvirtxml.DomainDiskTarget {
+	return &libvirtxml.DomainDiskTarget{
+		Dev: d.devName(),
+		Bus: "ide",
+	}
+}
+
+func (d *ideDriver) address() *libvirtxml.DomainAddress {
+	controller := uint(0)
+	bus := uint(d.n / 2)
+	target := uint(0)
+	unit := uint(d.n % 2)
+	return &libvirtxml.DomainAddress{
+		Drive: &libvirtxml.DomainAddressDrive{
+			Controller: &controller,
+			Bus:        &bus,
+			Target:     &target,
+			Unit:       &unit,
+		},
+	}
+}
+
+type sataDriver struct {
+	n        int
+	diskChar int
+}
+
+func sataDriverFactory(n, devIndex int) (diskDriver, error) {
+	diskChar := minBlockDevChar + devIndex
+	if n >= maxSataPorts || diskChar > maxScsiBlockDevChar {
+		return nil, errors.New("too many sata block devices")
+	}
+	return &sataDriver{n, diskChar}, nil
+}
+
+func (d *sataDriver) diskPath(domainDef *libvirtxml.Domain) (*diskPath, error) {
+	// libvirt adds the AHCI controller automatically
+	// when the domain is defined
+	sataControllers := findControllers(domainDef, "sata")
+	switch {
+	case len(sataControllers) == 0:
+		return nil, errors.New("no sata controllers found")
+	case len(sataControllers) > 1:
+		return nil, errors.New("more than one sata controller is not supported")
+	}
+
+	disk, err := findDisk(domainDef, d.devName())
+	if err != nil {
+		return nil, err
+	}
+	if disk.Address.Drive == nil || disk.Address.Drive.Unit == nil {
+		return nil, fmt.Errorf("bad disk address for sata disk %q", d.devName())
+	}
+
+	devPath, sysfsPath, err := pciPath(domainDef, sataControllers[0].Address, "pci")
+	if err != nil {
+		return nil, err
+	}
+	port := *disk.Address.Drive.Unit + 1
+	return &diskPath{
+		fmt.Sprintf("%s-ata-%d", devPath, port),
+		fmt.Sprintf("%s/ata%d/host*/target*:0:0/*:0:0:0/block/", sysfsPath, port+sataATAPortOffset),
+	}, nil
+}
+
+func (d *sataDriver) devName() string {
+	return fmt.Sprintf("sd%c", d.diskChar)
+}
+
+func (d *sataDriver) target() *libvirtxml.DomainDiskTarget {
+	return &libvirtxml.DomainDiskTarget{
+		Dev: d.devName(),
+		Bus: "sata",
+	}
+}
+
+func (d *sataDriver) address() *libvirtxml.DomainAddress {
+	// each port of the AHCI controller is a separate unit on bus 0
+	controller := uint(0)
+	bus := uint(0)
+	target := uint(0)
+	unit := uint(d.n)
+	return &libvirtxml.DomainAddress{
+		Drive: &libvirtxml.DomainAddressDrive{
+			Controller: &controller,
+			Bus:        &bus,
+			Target:     &target,
+			Unit:       &unit,
+		},
+	}
+}
+
+type usbDriver struct {
+	diskChar int
+}
+
+func usbDriverFactory(n, devIndex int) (diskDriver, error) {
+	diskChar := minBlockDevChar + devIndex
+	if diskChar > maxScsiBlockDevChar {
+		return nil, errors.New("too many usb block devices")
+	}
+	return &usbDriver{diskChar}, nil
+}
+
+func (d *usbDriver) diskPath(domainDef *libvirtxml.Domain) (*diskPath, error) {
+	// the guest path of an usb disk depends on the port
+	// assigned by libvirt and the usb controller model
+	return nil, fmt.Errorf("can't determine the guest path of usb disk %q", d.devName())
+}
+
+func (d *usbDriver) devName() string {
+	return fmt.Sprintf("sd%c", d.diskChar)
+}
+
+func (d *usbDriver) target() *libvirtxml.DomainDiskTarget {
+	return &libvirtxml.DomainDiskTarget{
+		Dev: d.devName(),
+		Bus: "usb",
+	}
+}
+
+func (d *usbDriver) address() *libvirtxml.DomainAddress {
+	// let libvirt choose the usb port
+	return nil
+}
+
 func getDiskDriverFactory(name types.DiskDriverName) (diskDriverFactory, error) {
 	if f, found := diskDriverMap[name]; found {
 		return f, nil
@@ -225,7 +418,11 @@ func findControllers(domainDef *libvirtxml.Domain, controllerType string) []libv
 	return r
 }
 
-func pciPath(domainDef *libvirtxml.Domain, address *libvirtxml.DomainAddress) (string, string, error) {
+// pciPath returns the /dev/disk/by-path and sysfs paths of the
+// PCI device with the specified address. pathPrefix is the prefix
+// used by udev for the device in by-path names, e.g. "virtio-pci"
+// for the virtio devices or "pci" for the emulated ones.
+func pciPath(domainDef *libvirtxml.Domain, address *libvirtxml.DomainAddress, pathPrefix string) (string, string, error) {
 	pciControllers := findControllers(domainDef, "pci")
 	devPath := "/dev/disk/by-path/"
 	sysfsPath := "/sys/devices"
@@ -257,7 +454,7 @@ func pciPath(domainDef *libvirtxml.Domain, address *libvirtxml.DomainAddress) (s
 		sysfsPath += "/" + addressStr
 		return nil
 	}
-	if err := recurse(address, "virtio-pci", 0); err != nil {
+	if err := recurse(address, pathPrefix, 0); err != nil {
 		return "", "", fmt.Errorf("pciPath for %#v: %v", address, err)
 	}
 	return devPath, sysfsPath, nil
@@ -17,6 +17,8 @@ limitations under the License.
 package libvirttools
 
 import (
+	"fmt"
+	"reflect"
 	"testing"
 
 	libvirtxml "github.com/libvirt/libvirt-go-xml"
@@ -155,6 +157,101 @@ func TestDiskPath(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:       "ide driver",
+			driverName: types.DiskDriverIde,
+			diskCount:  3,
+			devList: libvirtxml.DomainDeviceList{
+				Disks: []libvirtxml.DomainDisk{
+					{
+						Device: "disk",
+						Target: &libvirtxml.DomainDiskTarget{
+							Dev: "hda",
+							Bus: "ide",
+						},
+						Address: scsiAddress(0, 0, 0, 0),
+					},
+					{
+						Device: "disk",
+						Target: &libvirtxml.DomainDiskTarget{
+							Dev: "hdb",
+							Bus: "ide",
+						},
+						Address: scsiAddress(0, 0, 0, 1),
+					},
+					{
+						Device: "cdrom",
+						Target: &libvirtxml.DomainDiskTarget{
+							Dev: "hdc",
+							Bus: "ide",
+						},
+						Address:  scsiAddress(0, 1, 0, 0),
+						ReadOnly: &libvirtxml.DomainDiskReadOnly{},
+					},
+				},
+			},
+			diskPaths: []diskPath{
+				{
+					"/dev/disk/by-path/pci-0000:00:01.1-ata-1.0",
+					"/sys/devices/pci0000:00/0000:00:01.1/ata1/host*/target*:0:0/*:0:0:0/block/",
+				},
+				{
+					"/dev/disk/by-path/pci-0000:00:01.1-ata-1.1",
+					"/sys/devices/pci0000:00/0000:00:01.1/ata1/host*/target*:0:1/*:0:1:0/block/",
+				},
+				{
+					"/dev/disk/by-path/pci-0000:00:01.1-ata-2.0",
+					"/sys/devices/pci0000:00/0000:00:01.1/ata2/host*/target*:0:0/*:0:0:0/block/",
+				},
+			},
+		},
+		{
+			name:       "sata driver",
+			driverName: types.DiskDriverSata,
+			diskCount:  2,
+			devList: libvirtxml.DomainDeviceList{
+				Disks: []libvirtxml.DomainDisk{
+					{
+						Device: "disk",
+						Target: &libvirtxml.DomainDiskTarget{
+							Dev: "sda",
+							Bus: "sata",
+						},
+						Address: scsiAddress(0, 0, 0, 0),
+					},
+					{
+						Device: "cdrom",
+						Target: &libvirtxml.DomainDiskTarget{
+							Dev: "sdb",
+							Bus: "sata",
+						},
+						Address:  scsiAddress(0, 0, 0, 1),
+						ReadOnly: &libvirtxml.DomainDiskReadOnly{},
+					},
+				},
+				Controllers: []libvirtxml.DomainController{
+					{
+						Type:  "pci",
+						Model: "pci-root",
+					},
+					{
+						Type:    "sata",
+						Index:   puint(0),
+						Address: pciAddress(0, 0, 5, 0),
+					},
+				},
+			},
+			diskPaths: []diskPath{
+				{
+					"/dev/disk/by-path/pci-0000:00:05.0-ata-1",
+					"/sys/devices/pci0000:00/0000:00:05.0/ata3/host*/target*:0:0/*:0:0:0/block/",
+				},
+				{
+					"/dev/disk/by-path/pci-0000:00:05.0-ata-2",
+					"/sys/devices/pci0000:00/0000:00:05.0/ata4/host*/target*:0:0/*:0:0:0/block/",
+				},
+			},
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			factory, err := getDiskDriverFactory(tc.driverName)
@@ -163,7 +260,7 @@ func TestDiskPath(t *testing.T) {
 			}
 			domain := &libvirtxml.Domain{Devices: &tc.devList}
 			for n := 0; n < tc.diskCount; n++ {
-				driver, err := factory(n)
+				driver, err := factory(n, n)
 				if err != nil {
 					t.Errorf("error making driver #%d: %v", n, err)
 					continue
@@ -184,6 +281,72 @@ func TestDiskPath(t *testing.T) {
 	}
 }
 
+func TestDiskBuses(t *testing.T) {
+	config := &types.VMConfig{
+		ParsedAnnotations: &types.VirtletAnnotations{
+			DiskDriver: types.DiskDriverScsi,
+			DiskBuses: map[string]types.DiskDriverName{
+				"root":       types.DiskDriverSata,
+				"data2":      types.DiskDriverSata,
+				"cloud-init": types.DiskDriverIde,
+				"stick":      types.DiskDriverUSB,
+			},
+		},
+	}
+	source := func(config *types.VMConfig, owner volumeOwner) ([]VMVolume, error) {
+		return []VMVolume{
+			&fakeNamedVolume{name: "root", isDisk: true},
+			&fakeNamedVolume{name: "data1", isDisk: true},
+			&fakeNamedVolume{name: "shared"},
+			&fakeNamedVolume{name: "data2", isDisk: true},
+			&fakeNamedVolume{name: "stick", isDisk: true},
+			&fakeNamedVolume{name: "cloud-init", isDisk: true},
+		}, nil
+	}
+	dl, err := newDiskList(config, source, nil)
+	if err != nil {
+		t.Fatalf("newDiskList(): %v", err)
+	}
+	var targets []string
+	for _, item := range dl.items {
+		if item.driver == nil {
+			continue
+		}
+		target := item.driver.target()
+		address := "-"
+		if a := item.driver.address(); a != nil && a.Drive != nil {
+			address = fmt.Sprintf("%d:%d", *a.Drive.Bus, *a.Drive.Unit)
+		}
+		targets = append(targets, fmt.Sprintf("%s:%s:%s", target.Bus, target.Dev, address))
+	}
+	expectedTargets := []string{
+		"sata:sda:0:0",
+		"scsi:sdb:0:0",
+		"sata:sdc:0:1",
+		"usb:sdd:-",
+		"ide:hda:0:0",
+	}
+	if !reflect.DeepEqual(targets, expectedTargets) {
+		t.Errorf("bad disk targets: %#v instead of %#v", targets, expectedTargets)
+	}
+}
+
+func TestTooManySataDisks(t *testing.T) {
+	config := &types.VMConfig{
+		ParsedAnnotations: &types.VirtletAnnotations{DiskDriver: types.DiskDriverSata},
+	}
+	source := func(config *types.VMConfig, owner volumeOwner) ([]VMVolume, error) {
+		var vols []VMVolume
+		for n := 0; n <= maxSataPorts; n++ {
+			vols = append(vols, &fakeNamedVolume{name: fmt.Sprintf("vol%d", n), isDisk: true})
+		}
+		return vols, nil
+	}
+	if _, err := newDiskList(config, source, nil); err == nil {
+		t.Errorf("newDiskList() didn't fail for %d sata disks", maxSataPorts+1)
+	}
+}
+
 func puint(n uint) *uint { return &n }
 
 func scsiAddress(controller, bus, target, unit uint) *libvirtxml.DomainAddress {
@@ -72,20 +72,27 @@ func newDiskList(config *types.VMConfig, source VMVolumeSource, owner volumeOwne
 		return nil, err
 	}
 
-	diskDriverFactory, err := getDiskDriverFactory(config.ParsedAnnotations.DiskDriver)
-	if err != nil {
-		return nil, err
-	}
 	var items []*diskItem
-	n := 0
+	// the disks are numbered separately for each bus, while
+	// the device letters are shared by the buses with the same
+	// device name prefix
+	busCounts := make(map[types.DiskDriverName]int)
+	devCounts := make(map[string]int)
 	for _, volume := range vmVols {
 		var driver diskDriver
 		if volume.IsDisk() {
-			driver, err = diskDriverFactory(n)
+			driverName := volumeDiskDriverName(config, volume)
+			diskDriverFactory, err := getDiskDriverFactory(driverName)
+			if err != nil {
+				return nil, err
+			}
+			prefix := diskDevNamePrefix(driverName)
+			driver, err = diskDriverFactory(busCounts[driverName], devCounts[prefix])
 			if err != nil {
 				return nil, err
 			}
-			n++
+			busCounts[driverName]++
+			devCounts[prefix]++
 		}
 		items = append(items, &diskItem{driver, volume})
 	}
@@ -93,6 +100,18 @@ func newDiskList(config *types.VMConfig, source VMVolumeSource, owner volumeOwne
 	return &diskList{config, items}, nil
 }
 
+// volumeDiskDriverName returns the name of the disk driver to use
+// for the volume, which is either set for the volume using the
+// pod annotations or is the default one for the VM.
+func volumeDiskDriverName(config *types.VMConfig, volume VMVolume) types.DiskDriverName {
+	if name := podVolumeName(volume); name != "" {
+		if driverName, found := config.ParsedAnnotations.DiskBuses[name]; found {
+			return driverName
+		}
+	}
+	return config.ParsedAnnotations.DiskDriver
+}
+
 // setup performs the setup procedure on each volume in the diskList
 // and returns a list of libvirtxml DomainDisk and domainFileSystems structs
 func (dl *diskList) setup() ([]libvirtxml.DomainDisk, []libvirtxml.DomainFilesystem, error) {
@@ -150,8 +169,22 @@ func (dl *diskList) writeImages(domain virt.Domain) error {
 }
 
 func (dl *diskList) teardown() error {
+	return dl.teardownVolumes(func(VMVolume) bool { return true })
+}
+
+// teardownNonRetainable tears down the volumes except for those
+// that can be kept for reuse, i.e. the root volume and the config
+// drive.
+func (dl *diskList) teardownNonRetainable() error {
+	return dl.teardownVolumes(func(v VMVolume) bool { return !isRetainableVolume(v) })
+}
+
+func (dl *diskList) teardownVolumes(filter func(VMVolume) bool) error {
 	var errs []string
 	for _, item := range dl.items {
+		if !filter(item.volume) {
+			continue
+		}
 		if err := item.volume.Teardown(); err != nil {
 			errs = append(errs, err.Error())
 		}
@@ -0,0 +1,100 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"github.com/golang/glog"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/fs"
+)
+
+const (
+	// DiskCacheModeAuto makes Virtlet choose the cache mode based
+	// on the properties of the storage filesystem.
+	DiskCacheModeAuto = "auto"
+	// DiskCacheModeDefault leaves the choice of the cache mode to libvirt.
+	DiskCacheModeDefault = "default"
+
+	// ImageLockingAuto makes Virtlet disable qemu image locking
+	// if the storage filesystem doesn't support it.
+	ImageLockingAuto = "auto"
+	// ImageLockingOff disables qemu image locking.
+	ImageLockingOff = "off"
+)
+
+// DiskStorageSettings returns the cache mode to use for the qcow2
+// disks of the VMs (empty string meaning libvirt default) and
+// whether the qemu image locking must be disabled, given the
+// configured values and the properties of the storage filesystem.
+// storageInfo may be nil if the storage couldn't be probed, in which
+// case the storage is assumed to be local.
+func DiskStorageSettings(cacheMode, imageLocking string, storageInfo *fs.StorageInfo) (string, bool) {
+	if storageInfo == nil {
+		storageInfo = &fs.StorageInfo{DirectIO: true, OFDLocks: true}
+	}
+
+	switch cacheMode {
+	case DiskCacheModeAuto:
+		switch {
+		case storageInfo.NetworkFSType == "":
+			// writeback caching in the host page cache is
+			// fine for local disks
+			cacheMode = ""
+		case storageInfo.DirectIO:
+			// bypass the host page cache which isn't kept
+			// coherent on network filesystems
+			cacheMode = "none"
+		default:
+			cacheMode = "writethrough"
+		}
+	case DiskCacheModeDefault:
+		cacheMode = ""
+	case "none", "directsync":
+		// qemu refuses to open the disks with these modes
+		// if O_DIRECT is not supported
+		if !storageInfo.DirectIO {
+			glog.Warningf("Disk cache mode %q requires O_DIRECT which isn't supported by the storage filesystem, using \"writethrough\" instead", cacheMode)
+			cacheMode = "writethrough"
+		}
+	}
+
+	disableImageLocking := false
+	switch imageLocking {
+	case ImageLockingOff:
+		disableImageLocking = true
+	case ImageLockingAuto:
+		disableImageLocking = !storageInfo.OFDLocks
+	}
+
+	return cacheMode, disableImageLocking
+}
+
+// applyDiskCacheMode sets the cache mode for the qcow2 disks of the
+// domain, which are the ones stored in the volume pool and backed
+// by the images from the image store.
+func applyDiskCacheMode(domain *libvirtxml.Domain, cacheMode string) {
+	if cacheMode == "" || domain.Devices == nil {
+		return
+	}
+	for n := range domain.Devices.Disks {
+		disk := &domain.Devices.Disks[n]
+		if disk.Driver != nil && disk.Driver.Type == "qcow2" && disk.Driver.Cache == "" {
+			disk.Driver.Cache = cacheMode
+		}
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/fs"
+)
+
+func TestDiskStorageSettings(t *testing.T) {
+	local := &fs.StorageInfo{DirectIO: true, OFDLocks: true}
+	nfs := &fs.StorageInfo{NetworkFSType: "nfs", DirectIO: true}
+	fuse := &fs.StorageInfo{NetworkFSType: "fuse", OFDLocks: true}
+	for _, tc := range []struct {
+		name                        string
+		cacheMode, imageLocking     string
+		storageInfo                 *fs.StorageInfo
+		expectedCacheMode           string
+		expectedDisableImageLocking bool
+	}{
+		{
+			name:         "auto, local storage",
+			cacheMode:    "auto",
+			imageLocking: "auto",
+			storageInfo:  local,
+		},
+		{
+			name:         "auto, unknown storage",
+			cacheMode:    "auto",
+			imageLocking: "auto",
+		},
+		{
+			name:                        "auto, nfs without locks",
+			cacheMode:                   "auto",
+			imageLocking:                "auto",
+			storageInfo:                 nfs,
+			expectedCacheMode:           "none",
+			expectedDisableImageLocking: true,
+		},
+		{
+			name:              "auto, fuse without O_DIRECT",
+			cacheMode:         "auto",
+			imageLocking:      "auto",
+			storageInfo:       fuse,
+			expectedCacheMode: "writethrough",
+		},
+		{
+			name:         "default cache mode, locking on",
+			cacheMode:    "default",
+			imageLocking: "on",
+			storageInfo:  nfs,
+		},
+		{
+			name:                        "explicit cache mode, locking off",
+			cacheMode:                   "writeback",
+			imageLocking:                "off",
+			storageInfo:                 local,
+			expectedCacheMode:           "writeback",
+			expectedDisableImageLocking: true,
+		},
+		{
+			name:              "cache mode requiring O_DIRECT",
+			cacheMode:         "none",
+			imageLocking:      "auto",
+			storageInfo:       fuse,
+			expectedCacheMode: "writethrough",
+		},
+		{
+			name:              "cache mode requiring O_DIRECT, O_DIRECT supported",
+			cacheMode:         "directsync",
+			imageLocking:      "on",
+			storageInfo:       nfs,
+			expectedCacheMode: "directsync",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cacheMode, disableImageLocking := DiskStorageSettings(tc.cacheMode, tc.imageLocking, tc.storageInfo)
+			if cacheMode != tc.expectedCacheMode {
+				t.Errorf("bad cache mode %q instead of %q", cacheMode, tc.expectedCacheMode)
+			}
+			if disableImageLocking != tc.expectedDisableImageLocking {
+				t.Errorf("bad disableImageLocking %v instead of %v", disableImageLocking, tc.expectedDisableImageLocking)
+			}
+		})
+	}
+}
+
+func TestApplyDiskCacheMode(t *testing.T) {
+	domain := &libvirtxml.Domain{
+		Devices: &libvirtxml.DomainDeviceList{
+			Disks: []libvirtxml.DomainDisk{
+				{Device: "disk", Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "qcow2"}},
+				{Device: "cdrom", Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "raw"}},
+				{Device: "disk", Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "qcow2", Cache: "unsafe"}},
+			},
+		},
+	}
+	applyDiskCacheMode(domain, "none")
+	for n, expected := range []string{"none", "", "unsafe"} {
+		if cache := domain.Devices.Disks[n].Driver.Cache; cache != expected {
+			t.Errorf("bad cache mode for disk %d: %q instead of %q", n, cache, expected)
+		}
+	}
+}
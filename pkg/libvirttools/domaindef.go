@@ -0,0 +1,98 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+// domainDefinition returns the current persistent definition of the
+// domain as XML. libvirt fills in the defaults, such as the device
+// addresses, when the domain is defined, so this is the form that
+// should be used to compare the definitions.
+func domainDefinition(domain virt.Domain) (string, error) {
+	def, err := domain.XML()
+	if err != nil {
+		return "", fmt.Errorf("couldn't get domain xml: %v", err)
+	}
+	domainXML, err := def.Marshal()
+	if err != nil {
+		return "", fmt.Errorf("error marshalling domain definition: %v", err)
+	}
+	return domainXML, nil
+}
+
+// saveDomainDefinition stores the current definition of the domain
+// in the container metadata after the domain is redefined by Virtlet.
+func (v *VirtualizationTool) saveDomainDefinition(containerID string, domain virt.Domain) error {
+	domainXML, err := domainDefinition(domain)
+	if err != nil {
+		return err
+	}
+	return v.metadataStore.Container(containerID).Save(
+		func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
+			// make sure the container is not removed during the call
+			if c != nil {
+				c.DomainXML = domainXML
+			}
+			return c, nil
+		})
+}
+
+// DomainXML returns the current definition of the VM domain in
+// libvirt. If the domain doesn't exist, virt.ErrDomainNotFound is
+// returned.
+func (v *VirtualizationTool) DomainXML(containerID string) (string, error) {
+	domain, err := v.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		return "", err
+	}
+	return domainDefinition(domain)
+}
+
+// checkDomainDrift compares the definition of the domain with the
+// one stored in the container metadata, and if they differ, which
+// means that the domain was changed bypassing Virtlet, e.g. using
+// 'virsh edit', logs a warning and records an event for the pod.
+// The VM is still started using the changed definition.
+func (v *VirtualizationTool) checkDomainDrift(containerID string, domain virt.Domain) {
+	ci, err := v.metadataStore.Container(containerID).Retrieve()
+	if err != nil {
+		glog.Warningf("Can't check the definition of domain %q for changes: %v", containerID, err)
+		return
+	}
+	if ci == nil || ci.DomainXML == "" {
+		// the VM was created by an older Virtlet version
+		return
+	}
+	domainXML, err := domainDefinition(domain)
+	if err != nil {
+		glog.Warningf("Can't check the definition of domain %q for changes: %v", containerID, err)
+		return
+	}
+	if domainXML == ci.DomainXML {
+		return
+	}
+	glog.Warningf("The definition of domain %q was changed outside of Virtlet", containerID)
+	v.eventRecorder.Eventf(&ci.Config, v1.EventTypeWarning, "DomainDefinitionChanged", "The libvirt domain definition of the VM was changed outside of Virtlet")
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"reflect"
+	"testing"
+
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+)
+
+func TestDomainDrift(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+	recorder := &fakeEventRecorder{}
+	ct.virtTool.SetEventRecorder(recorder)
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	ct.setPodSandbox(sandbox)
+	containerID := ct.createContainer(sandbox, nil, nil)
+
+	domainXML, err := ct.virtTool.DomainXML(containerID)
+	if err != nil {
+		t.Fatalf("DomainXML(): %v", err)
+	}
+	if ci := ct.containerInfo(containerID); ci.DomainXML == "" || ci.DomainXML != domainXML {
+		t.Errorf("bad stored domain definition %q, expected %q", ci.DomainXML, domainXML)
+	}
+
+	// the changes made by Virtlet itself are not reported
+	if err := ct.virtTool.UpdateCpusetsInContainerDefinition(containerID, "1-2"); err != nil {
+		t.Fatalf("UpdateCpusetsInContainerDefinition(): %v", err)
+	}
+	domain, err := ct.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		t.Fatalf("LookupDomainByUUIDString(): %v", err)
+	}
+	ct.virtTool.checkDomainDrift(containerID, domain)
+	if len(recorder.events) != 0 {
+		t.Errorf("unexpected events: %#v", recorder.events)
+	}
+
+	// simulate 'virsh edit'
+	def, err := domain.XML()
+	if err != nil {
+		t.Fatalf("XML(): %v", err)
+	}
+	def.VCPU.Value = 4
+	if err := domain.Undefine(); err != nil {
+		t.Fatalf("Undefine(): %v", err)
+	}
+	if _, err := ct.domainConn.DefineDomain(def); err != nil {
+		t.Fatalf("DefineDomain(): %v", err)
+	}
+	ct.startContainer(containerID)
+	expectedEvents := []string{
+		sandbox.Namespace + "/" + sandbox.Name + " Warning DomainDefinitionChanged: The libvirt domain definition of the VM was changed outside of Virtlet",
+	}
+	if !reflect.DeepEqual(recorder.events, expectedEvents) {
+		t.Errorf("bad events:\n%#v\ninstead of\n%#v", recorder.events, expectedEvents)
+	}
+}
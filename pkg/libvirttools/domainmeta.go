@@ -0,0 +1,234 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const (
+	// DomainMetadataNamespace is the XML namespace of the element
+	// that Virtlet puts into the <metadata> of the libvirt domains.
+	DomainMetadataNamespace = "https://github.com/Mirantis/virtlet/schema/vm/1.0"
+	// DomainMetadataPrefix is the XML namespace prefix used for
+	// the Virtlet domain metadata.
+	DomainMetadataPrefix = "virtlet"
+
+	rootVolumePrefix       = "virtlet_root_"
+	persistentRootPrefix   = "virtlet-dm-"
+	uuidLength             = 36
+	filesystemVolumePrefix = "virtlet_"
+)
+
+// DomainMetadataPod identifies the pod that a domain belongs to.
+type DomainMetadataPod struct {
+	Namespace string `xml:"namespace,attr"`
+	Name      string `xml:"name,attr"`
+	UID       string `xml:"uid,attr"`
+}
+
+// DomainMetadataContainer identifies the container that
+// corresponds to a domain.
+type DomainMetadataContainer struct {
+	Name    string `xml:"name,attr"`
+	ID      string `xml:"id,attr"`
+	Attempt uint32 `xml:"attempt,attr"`
+}
+
+// DomainMetadataLabel is a pod label copied to the domain metadata.
+type DomainMetadataLabel struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// DomainMetadata describes the Kubernetes objects that correspond
+// to a libvirt domain. It's stored in the <metadata> element of
+// the domain so that it can be mapped back to its pod by the tools
+// that have access only to libvirt.
+type DomainMetadata struct {
+	XMLName   xml.Name                `xml:"https://github.com/Mirantis/virtlet/schema/vm/1.0 vm"`
+	Pod       DomainMetadataPod       `xml:"https://github.com/Mirantis/virtlet/schema/vm/1.0 pod"`
+	Container DomainMetadataContainer `xml:"https://github.com/Mirantis/virtlet/schema/vm/1.0 container"`
+	Labels    []DomainMetadataLabel   `xml:"https://github.com/Mirantis/virtlet/schema/vm/1.0 labels>label"`
+}
+
+// LabelMap returns the labels stored in the domain metadata as a map.
+func (m *DomainMetadata) LabelMap() map[string]string {
+	r := map[string]string{}
+	for _, l := range m.Labels {
+		r[l.Key] = l.Value
+	}
+	return r
+}
+
+// newDomainMetadata returns the domain metadata for the specified
+// VM config. Only the pod labels with the keys listed in labelKeys
+// are included.
+func newDomainMetadata(config *types.VMConfig, domainUUID string, podLabels map[string]string, labelKeys []string) *DomainMetadata {
+	m := &DomainMetadata{
+		Pod: DomainMetadataPod{
+			Namespace: config.PodNamespace,
+			Name:      config.PodName,
+			UID:       config.PodSandboxID,
+		},
+		Container: DomainMetadataContainer{
+			Name:    config.Name,
+			ID:      domainUUID,
+			Attempt: config.Attempt,
+		},
+	}
+	keys := append([]string(nil), labelKeys...)
+	sort.Strings(keys)
+	for _, k := range keys {
+		if v, found := podLabels[k]; found {
+			m.Labels = append(m.Labels, DomainMetadataLabel{Key: k, Value: v})
+		}
+	}
+	return m
+}
+
+func escapeXML(s string) string {
+	var buf bytes.Buffer
+	// xml.EscapeText only fails if the writer fails
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// domainXML returns the XML representation of the metadata that
+// uses DomainMetadataPrefix for the elements, as libvirt requires
+// the metadata elements to be namespaced.
+func (m *DomainMetadata) domainXML() string {
+	p := DomainMetadataPrefix
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<%s:vm xmlns:%s=%q>", p, p, DomainMetadataNamespace)
+	fmt.Fprintf(&buf, "<%s:pod namespace=\"%s\" name=\"%s\" uid=\"%s\"></%s:pod>",
+		p, escapeXML(m.Pod.Namespace), escapeXML(m.Pod.Name), escapeXML(m.Pod.UID), p)
+	fmt.Fprintf(&buf, "<%s:container name=\"%s\" id=\"%s\" attempt=\"%d\"></%s:container>",
+		p, escapeXML(m.Container.Name), escapeXML(m.Container.ID), m.Container.Attempt, p)
+	if len(m.Labels) != 0 {
+		fmt.Fprintf(&buf, "<%s:labels>", p)
+		for _, l := range m.Labels {
+			fmt.Fprintf(&buf, "<%s:label key=\"%s\">%s</%s:label>", p, escapeXML(l.Key), escapeXML(l.Value), p)
+		}
+		fmt.Fprintf(&buf, "</%s:labels>", p)
+	}
+	fmt.Fprintf(&buf, "</%s:vm>", p)
+	return buf.String()
+}
+
+// ParseDomainMetadata extracts Virtlet metadata from the domain
+// definition. It returns nil if the domain has no Virtlet metadata,
+// e.g. because it was created by an older Virtlet version or
+// doesn't belong to Virtlet at all.
+func ParseDomainMetadata(domain *libvirtxml.Domain) (*DomainMetadata, error) {
+	if domain.Metadata == nil || !strings.Contains(domain.Metadata.XML, DomainMetadataNamespace) {
+		return nil, nil
+	}
+	// the metadata may contain elements from other namespaces
+	// which may be added by other tools
+	decoder := xml.NewDecoder(strings.NewReader(domain.Metadata.XML))
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("can't find Virtlet metadata in domain %q: %v", domain.Name, err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Space != DomainMetadataNamespace || start.Name.Local != "vm" {
+			continue
+		}
+		var m DomainMetadata
+		if err := decoder.DecodeElement(&m, &start); err != nil {
+			return nil, fmt.Errorf("error parsing Virtlet metadata of domain %q: %v", domain.Name, err)
+		}
+		return &m, nil
+	}
+}
+
+// FindPodDomains returns the UUIDs of the domains that belong
+// to the specified pod according to their metadata.
+func (v *VirtualizationTool) FindPodDomains(namespace, name string) ([]string, error) {
+	domains, err := v.domainConn.ListDomains()
+	if err != nil {
+		return nil, err
+	}
+	var r []string
+	for _, d := range domains {
+		def, err := d.XML()
+		if err != nil {
+			return nil, err
+		}
+		m, err := ParseDomainMetadata(def)
+		if err != nil {
+			glog.Warningf("Skipping domain %q: %v", def.Name, err)
+			continue
+		}
+		if m != nil && m.Pod.Namespace == namespace && m.Pod.Name == name {
+			r = append(r, m.Container.ID)
+		}
+	}
+	return r, nil
+}
+
+// ContainerIDFromVolumeName returns the id of the container (which
+// is also the UUID of its domain) that the volume with the specified
+// name belongs to, or an empty string if the volume name doesn't
+// follow Virtlet naming schema. The schema includes
+// virtlet_root_<id> for the root volumes, virtlet_swap_<id> for the
+// swap disks, virtlet-dm-<id> for the persistent root device mapper
+// devices and virtlet_<id>_<hostpath basename>_<index> for the
+// directories that hold the filesystem volumes.
+func ContainerIDFromVolumeName(name string) string {
+	var rest string
+	switch {
+	case strings.HasPrefix(name, rootVolumePrefix):
+		rest = name[len(rootVolumePrefix):]
+		if len(rest) != uuidLength {
+			return ""
+		}
+	case strings.HasPrefix(name, swapVolumePrefix):
+		rest = name[len(swapVolumePrefix):]
+		if len(rest) != uuidLength {
+			return ""
+		}
+	case strings.HasPrefix(name, persistentRootPrefix):
+		rest = name[len(persistentRootPrefix):]
+		if len(rest) != uuidLength {
+			return ""
+		}
+	case strings.HasPrefix(name, filesystemVolumePrefix):
+		rest = name[len(filesystemVolumePrefix):]
+		if len(rest) <= uuidLength || rest[uuidLength] != '_' {
+			return ""
+		}
+	default:
+		return ""
+	}
+	id := rest[:uuidLength]
+	if strings.Count(id, "-") != 4 {
+		return ""
+	}
+	return id
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"reflect"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+)
+
+func TestDomainMetadataRoundTrip(t *testing.T) {
+	config := &types.VMConfig{
+		PodSandboxID: "69eec606-0493-5825-73a4-c5e0c0236155",
+		PodName:      "foo<&>\"bar\"",
+		PodNamespace: "default",
+		Name:         "container1",
+		Attempt:      3,
+	}
+	m := newDomainMetadata(config, "231700d5-c9a6-5a49-738d-99a954c51550", map[string]string{
+		"app":    "web",
+		"tier":   "<front>",
+		"ignore": "me",
+	}, []string{"tier", "app", "missing"})
+	domain := &libvirtxml.Domain{
+		Name: "virtlet-231700d5-c9a6-container1",
+		Metadata: &libvirtxml.DomainMetadata{
+			XML: "<other:info xmlns:other=\"http://example.com/other\">abc</other:info>" + m.domainXML(),
+		},
+	}
+	parsed, err := ParseDomainMetadata(domain)
+	if err != nil {
+		t.Fatalf("ParseDomainMetadata(): %v", err)
+	}
+	if parsed == nil {
+		t.Fatalf("ParseDomainMetadata() didn't find the metadata")
+	}
+	parsed.XMLName = m.XMLName
+	if !reflect.DeepEqual(parsed, m) {
+		t.Errorf("bad metadata after the round trip: %#v instead of %#v", parsed, m)
+	}
+	expectedLabels := map[string]string{"app": "web", "tier": "<front>"}
+	if labels := parsed.LabelMap(); !reflect.DeepEqual(labels, expectedLabels) {
+		t.Errorf("bad labels: %#v instead of %#v", labels, expectedLabels)
+	}
+
+	for _, md := range []*libvirtxml.DomainMetadata{
+		nil,
+		{XML: "<other:info xmlns:other=\"http://example.com/other\">abc</other:info>"},
+	} {
+		parsed, err := ParseDomainMetadata(&libvirtxml.Domain{Name: "foo", Metadata: md})
+		switch {
+		case err != nil:
+			t.Errorf("ParseDomainMetadata(): %v", err)
+		case parsed != nil:
+			t.Errorf("unexpected metadata for a non-Virtlet domain: %#v", parsed)
+		}
+	}
+}
+
+func TestFindPodDomains(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+	ct.virtTool.config.DomainMetadataLabels = []string{"foo", "nonexistent"}
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	ct.setPodSandbox(sandbox)
+	containerID := ct.createContainer(sandbox, nil, nil)
+
+	domain, err := ct.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		t.Fatalf("LookupDomainByUUIDString(): %v", err)
+	}
+	def, err := domain.XML()
+	if err != nil {
+		t.Fatalf("XML(): %v", err)
+	}
+	m, err := ParseDomainMetadata(def)
+	if err != nil {
+		t.Fatalf("ParseDomainMetadata(): %v", err)
+	}
+	if m == nil {
+		t.Fatalf("no metadata found for the domain")
+	}
+	expectedPod := DomainMetadataPod{Namespace: sandbox.Namespace, Name: sandbox.Name, UID: sandbox.Uid}
+	if m.Pod != expectedPod {
+		t.Errorf("bad pod metadata: %#v instead of %#v", m.Pod, expectedPod)
+	}
+	expectedContainer := DomainMetadataContainer{Name: fakeContainerName, ID: containerID, Attempt: fakeContainerAttempt}
+	if m.Container != expectedContainer {
+		t.Errorf("bad container metadata: %#v instead of %#v", m.Container, expectedContainer)
+	}
+	expectedLabels := map[string]string{"foo": "bar"}
+	if labels := m.LabelMap(); !reflect.DeepEqual(labels, expectedLabels) {
+		t.Errorf("bad labels: %#v instead of %#v", labels, expectedLabels)
+	}
+
+	ids, err := ct.virtTool.FindPodDomains(sandbox.Namespace, sandbox.Name)
+	if err != nil {
+		t.Fatalf("FindPodDomains(): %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{containerID}) {
+		t.Errorf("bad domain list for the pod: %#v", ids)
+	}
+
+	ids, err = ct.virtTool.FindPodDomains(sandbox.Namespace, "nonexistent-pod")
+	if err != nil {
+		t.Fatalf("FindPodDomains(): %v", err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("unexpected domains for a nonexistent pod: %#v", ids)
+	}
+}
+
+func TestContainerIDFromVolumeName(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		volumeName string
+		id         string
+	}{
+		{
+			name:       "root volume",
+			volumeName: "virtlet_root_231700d5-c9a6-5a49-738d-99a954c51550",
+			id:         "231700d5-c9a6-5a49-738d-99a954c51550",
+		},
+		{
+			name:       "swap disk",
+			volumeName: "virtlet_swap_231700d5-c9a6-5a49-738d-99a954c51550",
+			id:         "231700d5-c9a6-5a49-738d-99a954c51550",
+		},
+		{
+			name:       "persistent root",
+			volumeName: "virtlet-dm-231700d5-c9a6-5a49-738d-99a954c51550",
+			id:         "231700d5-c9a6-5a49-738d-99a954c51550",
+		},
+		{
+			name:       "filesystem volume",
+			volumeName: "virtlet_231700d5-c9a6-5a49-738d-99a954c51550_data_0",
+			id:         "231700d5-c9a6-5a49-738d-99a954c51550",
+		},
+		{
+			name:       "truncated root volume",
+			volumeName: "virtlet_root_231700d5-c9a6",
+		},
+		{
+			name:       "filesystem volume without suffix",
+			volumeName: "virtlet_231700d5-c9a6-5a49-738d-99a954c51550",
+		},
+		{
+			name:       "foreign volume",
+			volumeName: "some-volume",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if id := ContainerIDFromVolumeName(tc.volumeName); id != tc.id {
+				t.Errorf("bad id for %q: %q instead of %q", tc.volumeName, id, tc.id)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/utils/cgroups"
+)
+
+// monitorChardevID is the id of the chardev that libvirt uses
+// for the QEMU monitor.
+const monitorChardevID = "charmonitor"
+
+// EmulatorInfo returns the information about the emulator process
+// of the specified container, or nil if the emulator isn't running.
+func (v *VirtualizationTool) EmulatorInfo(containerID string) (*types.EmulatorInfo, error) {
+	pidStr, err := v.getEmulatorPid(containerID)
+	switch {
+	case err != nil:
+		return nil, err
+	case pidStr == "":
+		return nil, nil
+	}
+	pid, err := strconv.Atoi(pidStr)
+	if err != nil {
+		return nil, fmt.Errorf("bad emulator pid %q for container %q", pidStr, containerID)
+	}
+
+	cgroupPaths, err := cgroups.NewManager(pid, v.fsys).GetProcessControllers()
+	if err != nil {
+		return nil, fmt.Errorf("can't get the cgroups of the emulator process %d: %v", pid, err)
+	}
+
+	monitorSocketPath, err := v.getEmulatorMonitorSocketPath(pid)
+	if err != nil {
+		// the emulator may be exiting, don't fail in this case
+		glog.Warningf("Can't get the monitor socket path for the emulator process %d: %v", pid, err)
+	}
+
+	return &types.EmulatorInfo{
+		PID:               pid,
+		Cgroups:           cgroupPaths,
+		VhostThreads:      v.getVhostThreads(pid),
+		MonitorSocketPath: monitorSocketPath,
+	}, nil
+}
+
+// getEmulatorMonitorSocketPath extracts the path of the monitor
+// socket from the command line of the emulator process, where it's
+// specified like this:
+// -chardev socket,id=charmonitor,path=/var/lib/libvirt/qemu/domain-1-virtlet-xxx/monitor.sock,server,nowait
+func (v *VirtualizationTool) getEmulatorMonitorSocketPath(pid int) (string, error) {
+	f, err := v.fsys.GetDelimitedReader(filepath.Join(procfsLocation, strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	isChardev := false
+	for {
+		arg, err := f.ReadString(0)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		arg = strings.TrimRight(arg, "\x00")
+		if isChardev {
+			if path := monitorSocketPathFromChardev(arg); path != "" {
+				return path, nil
+			}
+		}
+		isChardev = arg == "-chardev"
+		if err == io.EOF {
+			break
+		}
+	}
+	return "", nil
+}
+
+func monitorSocketPathFromChardev(spec string) string {
+	isMonitor := false
+	path := ""
+	for _, opt := range strings.Split(spec, ",") {
+		switch {
+		case opt == "id="+monitorChardevID:
+			isMonitor = true
+		case strings.HasPrefix(opt, "path="):
+			path = opt[5:]
+		}
+	}
+	if !isMonitor {
+		return ""
+	}
+	return path
+}
+
+// getVhostThreads returns the ids of the vhost threads of the
+// emulator process. The vhost threads are named vhost-<pid>, and
+// depending on the kernel version they are either kernel threads or
+// the threads of the emulator process itself.
+func (v *VirtualizationTool) getVhostThreads(pid int) []int {
+	out, err := v.commander.Command("pgrep", "-w", "-x", fmt.Sprintf("vhost-%d", pid)).Run(nil)
+	if err != nil {
+		// pgrep exits with code 1 if there are no matches
+		glog.V(3).Infof("No vhost threads found for the emulator process %d: %v", pid, err)
+		return nil
+	}
+	var r []int
+	for _, s := range strings.Fields(string(out)) {
+		tid, err := strconv.Atoi(s)
+		if err != nil {
+			glog.Warningf("Bad thread id %q in pgrep output", s)
+			continue
+		}
+		r = append(r, tid)
+	}
+	return r
+}
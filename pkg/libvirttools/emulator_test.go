@@ -0,0 +1,87 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	fakeutils "github.com/Mirantis/virtlet/pkg/utils/fake"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+)
+
+func TestEmulatorInfo(t *testing.T) {
+	monitorSocketPath := "/var/lib/libvirt/qemu/domain-1-virtlet-231700d5-c9a6/monitor.sock"
+	files := map[string]string{
+		"/proc/4242/cgroup": "4:memory:/machine/qemu-1\n" +
+			"3:cpuset:/machine/qemu-1/emulator\n" +
+			"1:name=systemd:/machine/qemu-1\n",
+		"/proc/4242/cmdline": strings.Join([]string{
+			"/usr/bin/qemu-system-x86_64",
+			"-name", "guest=virtlet-231700d5-c9a6",
+			"-chardev", "socket,id=charserial0,path=/var/lib/libvirt/streamer.sock",
+			"-chardev", "socket,id=charmonitor,path=" + monitorSocketPath + ",server,nowait",
+			"-mon", "chardev=charmonitor,id=monitor,mode=control",
+		}, "\x00") + "\x00",
+	}
+	cmds := []fakeutils.CmdSpec{
+		{
+			Match:  "pgrep -w -x vhost-4242",
+			Stdout: "4250\n4251\n",
+		},
+	}
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), cmds, files)
+	defer ct.teardown()
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	ct.setPodSandbox(sandbox)
+	containerID := ct.createContainer(sandbox, nil, nil)
+
+	info, err := ct.virtTool.EmulatorInfo(containerID)
+	switch {
+	case err != nil:
+		t.Fatalf("EmulatorInfo(): %v", err)
+	case info != nil:
+		t.Errorf("expected no emulator info before the emulator is started, got %s", spew.Sdump(info))
+	}
+
+	pidFilePath := fmt.Sprintf("/run/libvirt/qemu/virtlet-%s-%s.pid", containerID[:13], sandbox.Name)
+	files[pidFilePath] = "4242\n"
+	info, err = ct.virtTool.EmulatorInfo(containerID)
+	if err != nil {
+		t.Fatalf("EmulatorInfo(): %v", err)
+	}
+	expectedInfo := &types.EmulatorInfo{
+		PID: 4242,
+		Cgroups: map[string]string{
+			"memory":  "/machine/qemu-1",
+			"cpuset":  "/machine/qemu-1/emulator",
+			"systemd": "/machine/qemu-1",
+		},
+		VhostThreads:      []int{4250, 4251},
+		MonitorSocketPath: monitorSocketPath,
+	}
+	if !reflect.DeepEqual(info, expectedInfo) {
+		t.Errorf("bad emulator info: expected:\n%s\n-- got --\n%s", spew.Sdump(expectedInfo), spew.Sdump(info))
+	}
+}
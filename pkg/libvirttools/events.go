@@ -0,0 +1,121 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/webhook"
+)
+
+const (
+	eventSourceComponent = "virtlet"
+	nodeNameEnv          = "KUBE_NODE_NAME"
+)
+
+// EventRecorder records Kubernetes events for the VM pods.
+type EventRecorder interface {
+	// Eventf records an event for the pod that contains the VM.
+	// eventType must be either v1.EventTypeNormal or v1.EventTypeWarning.
+	Eventf(config *types.VMConfig, eventType, reason, messageFmt string, args ...interface{})
+}
+
+// LifecycleEventSink receives the VM lifecycle events, e.g. to
+// deliver them to the webhook endpoints.
+type LifecycleEventSink interface {
+	// Dispatch handles the event. It must not block.
+	Dispatch(event webhook.Event)
+}
+
+type nullEventRecorder struct{}
+
+func (r nullEventRecorder) Eventf(config *types.VMConfig, eventType, reason, messageFmt string, args ...interface{}) {
+	glog.V(2).Infof("Event for pod %s/%s: %s %s: %s", config.PodNamespace, config.PodName, eventType, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+type kubeEventRecorder struct {
+	sync.Mutex
+	clientCfg  clientcmd.ClientConfig
+	kubeClient kubernetes.Interface
+	host       string
+}
+
+// NewKubeEventRecorder returns an EventRecorder that posts the events
+// using Kubernetes API.
+func NewKubeEventRecorder(clientCfg clientcmd.ClientConfig) EventRecorder {
+	host := os.Getenv(nodeNameEnv)
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	return &kubeEventRecorder{clientCfg: clientCfg, host: host}
+}
+
+func (r *kubeEventRecorder) ensureKubeClient() error {
+	r.Lock()
+	defer r.Unlock()
+	if r.kubeClient != nil {
+		return nil
+	}
+	config, err := r.clientCfg.ClientConfig()
+	if err != nil {
+		return err
+	}
+	r.kubeClient, err = kubernetes.NewForConfig(config)
+	return err
+}
+
+func (r *kubeEventRecorder) Eventf(config *types.VMConfig, eventType, reason, messageFmt string, args ...interface{}) {
+	if err := r.ensureKubeClient(); err != nil {
+		glog.Warningf("Can't record event %q for pod %s/%s: %v", reason, config.PodNamespace, config.PodName, err)
+		return
+	}
+	now := meta_v1.NewTime(time.Now())
+	event := &v1.Event{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", config.PodName, now.UnixNano()),
+			Namespace: config.PodNamespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind:      "Pod",
+			Namespace: config.PodNamespace,
+			Name:      config.PodName,
+		},
+		Reason:         reason,
+		Message:        fmt.Sprintf(messageFmt, args...),
+		Type:           eventType,
+		Source:         v1.EventSource{Component: eventSourceComponent, Host: r.host},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if config.Name != "" {
+		event.InvolvedObject.FieldPath = fmt.Sprintf("spec.containers{%s}", config.Name)
+	}
+	if _, err := r.kubeClient.CoreV1().Events(config.PodNamespace).Create(event); err != nil {
+		glog.Warningf("Can't record event %q for pod %s/%s: %v", reason, config.PodNamespace, config.PodName, err)
+	}
+}
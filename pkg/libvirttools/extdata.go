@@ -18,29 +18,50 @@ package libvirttools
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 
 	// use this instead of "gopkg.in/yaml.v2" so we don't get
 	// map[interface{}]interface{} when unmarshalling cloud-init data
 	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 
+	virtlet_v1 "github.com/Mirantis/virtlet/pkg/api/virtlet.k8s/v1"
+	virtletclient "github.com/Mirantis/virtlet/pkg/client/clientset/versioned"
 	"github.com/Mirantis/virtlet/pkg/metadata/types"
 	"github.com/Mirantis/virtlet/pkg/utils"
 )
 
+// runtimeClassPath is the API path of RuntimeClass resources.
+const runtimeClassPath = "/apis/node.k8s.io/v1beta1/runtimeclasses"
+
 func init() {
 	types.SetExternalDataLoader(&defaultExternalDataLoader{})
 }
 
 type defaultExternalDataLoader struct {
-	kubeClient kubernetes.Interface
+	kubeClient    kubernetes.Interface
+	restClient    rest.Interface
+	clientCfg     clientcmd.ClientConfig
+	virtletClient virtletclient.Interface
 }
 
 var _ types.ExternalDataLoader = &defaultExternalDataLoader{}
 
+// EnableVMPolicies makes Virtlet apply VirtletVMPolicy resources
+// from the pod namespace to the VMs and enables VirtletFlavor
+// resources. The policies and the flavors are read using the
+// specified client config.
+func EnableVMPolicies(clientCfg clientcmd.ClientConfig) {
+	types.SetExternalDataLoader(&defaultExternalDataLoader{clientCfg: clientCfg})
+}
+
 // LoadCloudInitData implements LoadCloudInitData method of ExternalDataLoader interface.
 func (l *defaultExternalDataLoader) LoadCloudInitData(va *types.VirtletAnnotations, namespace string, podAnnotations map[string]string) error {
 	if namespace == "" {
@@ -82,6 +103,170 @@ func (l *defaultExternalDataLoader) LoadFileMap(namespace, dsSpec string) (map[s
 	return parseDataAsFileMap(data)
 }
 
+// listVMPolicies returns VirtletVMPolicy resources from the specified
+// namespace sorted by their names.
+func (l *defaultExternalDataLoader) listVMPolicies(namespace string) ([]virtlet_v1.VirtletVMPolicy, error) {
+	if namespace == "" || (l.virtletClient == nil && l.clientCfg == nil) {
+		return nil, nil
+	}
+	if err := l.ensureVirtletClient(); err != nil {
+		return nil, err
+	}
+	list, err := l.virtletClient.VirtletV1().VirtletVMPolicies(namespace).List(meta_v1.ListOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// VirtletVMPolicy CRD is not registered
+			return nil, nil
+		}
+		return nil, err
+	}
+	policies := list.Items
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].Name < policies[j].Name
+	})
+	return policies, nil
+}
+
+// LoadVMPolicy implements LoadVMPolicy method of ExternalDataLoader interface.
+// If there are several policies in the namespace, they're applied in
+// the order of their names, so the values from the policy that comes
+// last take precedence.
+func (l *defaultExternalDataLoader) LoadVMPolicy(va *types.VirtletAnnotations, namespace string) error {
+	policies, err := l.listVMPolicies(namespace)
+	if err != nil {
+		return err
+	}
+	for _, p := range policies {
+		if p.Spec.TerminationGracePeriodSeconds != nil {
+			va.TerminationGracePeriodSeconds = *p.Spec.TerminationGracePeriodSeconds
+		}
+		if p.Spec.OnCrash != "" {
+			va.OnCrash = p.Spec.OnCrash
+		}
+		if p.Spec.WatchdogAction != "" {
+			va.WatchdogAction = p.Spec.WatchdogAction
+		}
+		if p.Spec.RestartBackoffSeconds != nil {
+			va.RestartBackoffSeconds = *p.Spec.RestartBackoffSeconds
+		}
+		if p.Spec.MaintenanceWindow != "" {
+			if va.MaintenanceWindow, err = types.ParseMaintenanceWindow(p.Spec.MaintenanceWindow); err != nil {
+				return fmt.Errorf("VM policy %q: %v", p.Name, err)
+			}
+		}
+		if p.Spec.MaintenanceRebootCondition != "" {
+			va.MaintenanceRebootCondition = types.MaintenanceRebootCondition(p.Spec.MaintenanceRebootCondition)
+		}
+		if p.Spec.MaxConcurrentMaintenanceReboots != nil {
+			va.MaxConcurrentMaintenanceReboots = *p.Spec.MaxConcurrentMaintenanceReboots
+		}
+	}
+	return nil
+}
+
+// LoadVMQuota implements LoadVMQuota method of ExternalDataLoader interface.
+// The policies are merged in the same way as in LoadVMPolicy.
+func (l *defaultExternalDataLoader) LoadVMQuota(namespace string) (*types.VMQuota, error) {
+	policies, err := l.listVMPolicies(namespace)
+	if err != nil {
+		return nil, err
+	}
+	var quota *types.VMQuota
+	for _, p := range policies {
+		if p.Spec.MaxVMsPerNode == nil && p.Spec.MaxVCPUsPerNode == nil && p.Spec.MaxMemoryPerNode == nil {
+			continue
+		}
+		if quota == nil {
+			quota = &types.VMQuota{MaxVMs: -1, MaxVCPUs: -1, MaxMemory: -1}
+		}
+		if p.Spec.MaxVMsPerNode != nil {
+			quota.MaxVMs = *p.Spec.MaxVMsPerNode
+		}
+		if p.Spec.MaxVCPUsPerNode != nil {
+			quota.MaxVCPUs = *p.Spec.MaxVCPUsPerNode
+		}
+		if p.Spec.MaxMemoryPerNode != nil {
+			quota.MaxMemory = p.Spec.MaxMemoryPerNode.Value()
+		}
+	}
+	return quota, nil
+}
+
+// LoadFlavor implements LoadFlavor method of ExternalDataLoader interface.
+func (l *defaultExternalDataLoader) LoadFlavor(va *types.VirtletAnnotations, namespace, name string) error {
+	if l.virtletClient == nil && l.clientCfg == nil {
+		return fmt.Errorf("VirtletFlavor resources are not available")
+	}
+	if err := l.ensureVirtletClient(); err != nil {
+		return err
+	}
+	flavor, err := l.virtletClient.VirtletV1().VirtletFlavors(namespace).Get(name, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if flavor.Spec.VCPUCount != nil {
+		va.VCPUCount = *flavor.Spec.VCPUCount
+	}
+	if flavor.Spec.Memory != nil {
+		va.Memory = flavor.Spec.Memory.Value()
+	}
+	if flavor.Spec.RootVolumeSize != nil {
+		va.RootVolumeSize = flavor.Spec.RootVolumeSize.Value()
+	}
+	if flavor.Spec.TuningProfile != "" {
+		va.TuningProfile = types.TuningProfile(flavor.Spec.TuningProfile)
+	}
+	return nil
+}
+
+// LoadRuntimeClass implements LoadRuntimeClass method of ExternalDataLoader interface.
+// The pod and its RuntimeClass are read using raw API requests as the
+// client library used by Virtlet doesn't know about RuntimeClasses.
+// Currently, only VirtletTuningProfile annotation of the RuntimeClass
+// is used.
+func (l *defaultExternalDataLoader) LoadRuntimeClass(va *types.VirtletAnnotations, namespace, podName string) error {
+	if namespace == "" || podName == "" || (l.restClient == nil && l.clientCfg == nil) {
+		return nil
+	}
+	if err := l.ensureRESTClient(); err != nil {
+		return err
+	}
+	data, err := l.restClient.Get().Namespace(namespace).Resource("pods").Name(podName).DoRaw()
+	switch {
+	case errors.IsNotFound(err):
+		// the pod may have been deleted already
+		return nil
+	case err != nil:
+		return err
+	}
+	var pod struct {
+		Spec struct {
+			RuntimeClassName string `json:"runtimeClassName"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(data, &pod); err != nil {
+		return fmt.Errorf("error unmarshalling pod: %v", err)
+	}
+	if pod.Spec.RuntimeClassName == "" {
+		return nil
+	}
+
+	data, err = l.restClient.Get().AbsPath(runtimeClassPath, pod.Spec.RuntimeClassName).DoRaw()
+	if err != nil {
+		return fmt.Errorf("error getting RuntimeClass %q: %v", pod.Spec.RuntimeClassName, err)
+	}
+	var runtimeClass struct {
+		meta_v1.ObjectMeta `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &runtimeClass); err != nil {
+		return fmt.Errorf("error unmarshalling RuntimeClass %q: %v", pod.Spec.RuntimeClassName, err)
+	}
+	if profile, found := runtimeClass.Annotations[types.TuningProfileKeyName]; found {
+		va.TuningProfile = types.TuningProfile(profile)
+	}
+	return nil
+}
+
 func (l *defaultExternalDataLoader) loadUserDataFromDataSource(va *types.VirtletAnnotations, namespace, key string) error {
 	parts := strings.Split(key, "/")
 	if len(parts) != 2 {
@@ -136,6 +321,37 @@ func (l *defaultExternalDataLoader) ensureKubeClient() error {
 	return nil
 }
 
+func (l *defaultExternalDataLoader) ensureVirtletClient() error {
+	if l.virtletClient != nil {
+		return nil
+	}
+	config, err := l.clientCfg.ClientConfig()
+	if err != nil {
+		return err
+	}
+	l.virtletClient, err = virtletclient.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("can't create Virtlet api client: %v", err)
+	}
+	return nil
+}
+
+func (l *defaultExternalDataLoader) ensureRESTClient() error {
+	if l.restClient != nil {
+		return nil
+	}
+	config, err := l.clientCfg.ClientConfig()
+	if err != nil {
+		return err
+	}
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("can't create kubernetes api client: %v", err)
+	}
+	l.restClient = kubeClient.CoreV1().RESTClient()
+	return nil
+}
+
 func (l *defaultExternalDataLoader) readK8sKeySource(sourceType, sourceName, namespace, key string) (map[string]string, error) {
 	if err := l.ensureKubeClient(); err != nil {
 		return nil, err
@@ -17,13 +17,21 @@ limitations under the License.
 package libvirttools
 
 import (
+	"io/ioutil"
+	"net/http"
 	"reflect"
+	"strings"
 	"testing"
 
 	v1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
 	fakekube "k8s.io/client-go/kubernetes/fake"
+	fakerest "k8s.io/client-go/rest/fake"
 
+	virtlet_v1 "github.com/Mirantis/virtlet/pkg/api/virtlet.k8s/v1"
+	fakevirtlet "github.com/Mirantis/virtlet/pkg/client/clientset/versioned/fake"
 	"github.com/Mirantis/virtlet/pkg/metadata/types"
 	"github.com/Mirantis/virtlet/pkg/utils"
 )
@@ -230,3 +238,291 @@ func TestLoadExternalUserData(t *testing.T) {
 		})
 	}
 }
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+func TestLoadVMPolicy(t *testing.T) {
+	loader := &defaultExternalDataLoader{
+		virtletClient: fakevirtlet.NewSimpleClientset(
+			&virtlet_v1.VirtletVMPolicy{
+				ObjectMeta: meta_v1.ObjectMeta{
+					Name:      "b-policy",
+					Namespace: "testns",
+				},
+				Spec: virtlet_v1.VirtletVMPolicySpec{
+					TerminationGracePeriodSeconds: int64Ptr(120),
+					OnCrash:                       "preserve",
+					RestartBackoffSeconds:         int64Ptr(30),
+				},
+			},
+			&virtlet_v1.VirtletVMPolicy{
+				ObjectMeta: meta_v1.ObjectMeta{
+					Name:      "a-policy",
+					Namespace: "testns",
+				},
+				Spec: virtlet_v1.VirtletVMPolicySpec{
+					TerminationGracePeriodSeconds: int64Ptr(60),
+					OnCrash:                       "destroy",
+					WatchdogAction:                "reset",
+				},
+			},
+			&virtlet_v1.VirtletVMPolicy{
+				ObjectMeta: meta_v1.ObjectMeta{
+					Name:      "policy",
+					Namespace: "otherns",
+				},
+				Spec: virtlet_v1.VirtletVMPolicySpec{
+					RestartBackoffSeconds: int64Ptr(600),
+				},
+			},
+		),
+	}
+	for _, tc := range []struct {
+		name                   string
+		namespace              string
+		podAnnotations         map[string]string
+		expectedGracePeriod    int64
+		expectedOnCrash        string
+		expectedWatchdogAction string
+		expectedRestartBackoff int64
+	}{
+		{
+			name:                   "policies merged in the order of their names",
+			namespace:              "testns",
+			expectedGracePeriod:    120,
+			expectedOnCrash:        "preserve",
+			expectedWatchdogAction: "reset",
+			expectedRestartBackoff: 30,
+		},
+		{
+			name:      "policies overridden by the annotations",
+			namespace: "testns",
+			podAnnotations: map[string]string{
+				"VirtletTerminationGracePeriodSeconds": "15",
+				"VirtletRestartBackoffSeconds":         "0",
+			},
+			expectedGracePeriod:    15,
+			expectedOnCrash:        "preserve",
+			expectedWatchdogAction: "reset",
+		},
+		{
+			name:                   "policy from another namespace",
+			namespace:              "otherns",
+			expectedRestartBackoff: 600,
+		},
+		{
+			name:      "no policies",
+			namespace: "emptyns",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withExternalDataLoader(loader, func() {
+				vmc := &types.VMConfig{
+					PodNamespace:   tc.namespace,
+					PodAnnotations: tc.podAnnotations,
+				}
+				if err := vmc.LoadAnnotations(); err != nil {
+					t.Fatalf("LoadAnnotations(): %v", err)
+				}
+				va := vmc.ParsedAnnotations
+				if va.TerminationGracePeriodSeconds != tc.expectedGracePeriod {
+					t.Errorf("bad termination grace period %d instead of %d", va.TerminationGracePeriodSeconds, tc.expectedGracePeriod)
+				}
+				if va.OnCrash != tc.expectedOnCrash {
+					t.Errorf("bad on_crash action %q instead of %q", va.OnCrash, tc.expectedOnCrash)
+				}
+				if va.WatchdogAction != tc.expectedWatchdogAction {
+					t.Errorf("bad watchdog action %q instead of %q", va.WatchdogAction, tc.expectedWatchdogAction)
+				}
+				if va.RestartBackoffSeconds != tc.expectedRestartBackoff {
+					t.Errorf("bad restart backoff %d instead of %d", va.RestartBackoffSeconds, tc.expectedRestartBackoff)
+				}
+			})
+		})
+	}
+}
+
+func TestLoadFlavor(t *testing.T) {
+	loader := &defaultExternalDataLoader{
+		virtletClient: fakevirtlet.NewSimpleClientset(
+			&virtlet_v1.VirtletFlavor{
+				ObjectMeta: meta_v1.ObjectMeta{
+					Name:      "m1.small",
+					Namespace: "testns",
+				},
+				Spec: virtlet_v1.VirtletFlavorSpec{
+					VCPUCount:      intPtr(2),
+					Memory:         quantityPtr("1Gi"),
+					RootVolumeSize: quantityPtr("10Gi"),
+					TuningProfile:  "latency",
+				},
+			},
+		),
+	}
+	for _, tc := range []struct {
+		name                  string
+		podAnnotations        map[string]string
+		expectedVCPUCount     int
+		expectedMemory        int64
+		expectedRootVolume    int64
+		expectedTuningProfile types.TuningProfile
+		expectError           bool
+	}{
+		{
+			name:                  "flavor",
+			podAnnotations:        map[string]string{"VirtletFlavor": "m1.small"},
+			expectedVCPUCount:     2,
+			expectedMemory:        1024 * mib,
+			expectedRootVolume:    10240 * mib,
+			expectedTuningProfile: types.TuningProfileLatency,
+		},
+		{
+			name: "flavor overridden by the annotations",
+			podAnnotations: map[string]string{
+				"VirtletFlavor":        "m1.small",
+				"VirtletVCPUCount":     "4",
+				"VirtletTuningProfile": "throughput",
+			},
+			expectedVCPUCount:     4,
+			expectedMemory:        1024 * mib,
+			expectedRootVolume:    10240 * mib,
+			expectedTuningProfile: types.TuningProfileThroughput,
+		},
+		{
+			name:              "no flavor",
+			podAnnotations:    map[string]string{},
+			expectedVCPUCount: 1,
+		},
+		{
+			name:           "nonexistent flavor",
+			podAnnotations: map[string]string{"VirtletFlavor": "m1.huge"},
+			expectError:    true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withExternalDataLoader(loader, func() {
+				vmc := &types.VMConfig{
+					PodNamespace:   "testns",
+					PodAnnotations: tc.podAnnotations,
+				}
+				err := vmc.LoadAnnotations()
+				switch {
+				case tc.expectError:
+					if err == nil {
+						t.Errorf("LoadAnnotations() didn't fail")
+					}
+					return
+				case err != nil:
+					t.Fatalf("LoadAnnotations(): %v", err)
+				}
+				va := vmc.ParsedAnnotations
+				if va.VCPUCount != tc.expectedVCPUCount {
+					t.Errorf("bad vcpu count %d instead of %d", va.VCPUCount, tc.expectedVCPUCount)
+				}
+				if va.Memory != tc.expectedMemory {
+					t.Errorf("bad memory %d instead of %d", va.Memory, tc.expectedMemory)
+				}
+				if va.RootVolumeSize != tc.expectedRootVolume {
+					t.Errorf("bad root volume size %d instead of %d", va.RootVolumeSize, tc.expectedRootVolume)
+				}
+				if va.TuningProfile != tc.expectedTuningProfile {
+					t.Errorf("bad tuning profile %q instead of %q", va.TuningProfile, tc.expectedTuningProfile)
+				}
+			})
+		})
+	}
+}
+
+func TestLoadRuntimeClass(t *testing.T) {
+	objects := map[string]string{
+		"/namespaces/testns/pods/latency-vm":     `{"spec":{"runtimeClassName":"vm-latency"}}`,
+		"/namespaces/testns/pods/unannotated-vm": `{"spec":{"runtimeClassName":"unannotated"}}`,
+		"/namespaces/testns/pods/bad-class-vm":   `{"spec":{"runtimeClassName":"nonexistent"}}`,
+		"/namespaces/testns/pods/plain-vm":       `{"spec":{}}`,
+		runtimeClassPath + "/vm-latency":         `{"metadata":{"name":"vm-latency","annotations":{"VirtletTuningProfile":"latency"}},"handler":"virtlet"}`,
+		runtimeClassPath + "/unannotated":        `{"metadata":{"name":"unannotated"},"handler":"virtlet"}`,
+	}
+	loader := &defaultExternalDataLoader{
+		restClient: &fakerest.RESTClient{
+			GroupVersion:         schema.GroupVersion{Version: "v1"},
+			NegotiatedSerializer: dynamic.ContentConfig().NegotiatedSerializer,
+			Client: fakerest.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+				header := http.Header{}
+				header.Set("Content-Type", "application/json")
+				if body, found := objects[req.URL.Path]; found {
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Header:     header,
+						Body:       ioutil.NopCloser(strings.NewReader(body)),
+					}, nil
+				}
+				return &http.Response{
+					StatusCode: http.StatusNotFound,
+					Header:     header,
+					Body:       ioutil.NopCloser(strings.NewReader(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)),
+				}, nil
+			}),
+		},
+	}
+	for _, tc := range []struct {
+		name                  string
+		podName               string
+		podAnnotations        map[string]string
+		expectedTuningProfile types.TuningProfile
+		expectError           bool
+	}{
+		{
+			name:                  "runtime class with a tuning profile",
+			podName:               "latency-vm",
+			expectedTuningProfile: types.TuningProfileLatency,
+		},
+		{
+			name:                  "runtime class overridden by the annotations",
+			podName:               "latency-vm",
+			podAnnotations:        map[string]string{"VirtletTuningProfile": "throughput"},
+			expectedTuningProfile: types.TuningProfileThroughput,
+		},
+		{
+			name:    "runtime class without a tuning profile",
+			podName: "unannotated-vm",
+		},
+		{
+			name:    "no runtime class",
+			podName: "plain-vm",
+		},
+		{
+			name:    "pod not found",
+			podName: "deleted-vm",
+		},
+		{
+			name:        "nonexistent runtime class",
+			podName:     "bad-class-vm",
+			expectError: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withExternalDataLoader(loader, func() {
+				vmc := &types.VMConfig{
+					PodName:        tc.podName,
+					PodNamespace:   "testns",
+					PodAnnotations: tc.podAnnotations,
+				}
+				err := vmc.LoadAnnotations()
+				switch {
+				case tc.expectError:
+					if err == nil {
+						t.Errorf("LoadAnnotations() didn't fail")
+					}
+					return
+				case err != nil:
+					t.Fatalf("LoadAnnotations(): %v", err)
+				}
+				if vmc.ParsedAnnotations.TuningProfile != tc.expectedTuningProfile {
+					t.Errorf("bad tuning profile %q instead of %q", vmc.ParsedAnnotations.TuningProfile, tc.expectedTuningProfile)
+				}
+			})
+		})
+	}
+}
@@ -30,6 +30,7 @@ func GetFileSystemVolumes(config *types.VMConfig, owner volumeOwner) ([]VMVolume
 	var fsVolumes []VMVolume
 	for index, mount := range config.Mounts {
 		if isRegularFile(mount.HostPath) ||
+			isBlockDevice(mount.HostPath) ||
 			strings.Contains(mount.HostPath, flexvolumeSubdir) ||
 			strings.Contains(mount.HostPath, "kubernetes.io~secret") ||
 			strings.Contains(mount.HostPath, "kubernetes.io~configmap") {
@@ -0,0 +1,53 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"github.com/golang/glog"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+// markFirstBootCompleted updates the first boot record of the
+// persistent root volume of the VM, if there's one, after the VM
+// has been started from it for the first time. The errors are
+// only logged because they don't prevent the VM from running.
+func (v *VirtualizationTool) markFirstBootCompleted(containerID string) {
+	config, _, err := v.getVMConfigFromMetadata(containerID)
+	if err != nil {
+		glog.Warningf("Can't update first boot record for domain %q: %v", containerID, err)
+		return
+	}
+	if config == nil {
+		return
+	}
+	rootDev := config.RootVolumeDevice()
+	if rootDev == nil {
+		return
+	}
+
+	if err := v.metadataStore.SaveFirstBootRecord(rootDev.UUID(), func(r *types.FirstBootRecord) (*types.FirstBootRecord, error) {
+		if r == nil || r.Completed() {
+			return r, nil
+		}
+		glog.V(2).Infof("First boot from persistent root volume %q completed by pod %s/%s (instance-id %q)", rootDev.HostPath, config.PodNamespace, config.PodName, r.InstanceID)
+		r.CompletedAt = v.clock.Now().UnixNano()
+		return r, nil
+	}); err != nil {
+		glog.Warningf("Can't update first boot record for domain %q: %v", containerID, err)
+	}
+}
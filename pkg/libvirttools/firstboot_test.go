@@ -0,0 +1,98 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	fakeutils "github.com/Mirantis/virtlet/pkg/utils/fake"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+)
+
+func TestFirstBoot(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), []fakeutils.CmdSpec{
+		{
+			Match:  "blockdev --getsz",
+			Stdout: "1000",
+		},
+		{
+			Match: "qemu-img convert",
+		},
+		{
+			Match: "dmsetup create",
+		},
+		{
+			Match: "dmsetup remove",
+		},
+	}, nil)
+	defer ct.teardown()
+
+	devPath := filepath.Join(ct.tmpDir, "rootdev")
+	if err := ioutil.WriteFile(devPath, make([]byte, 512000), 0666); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	volDevs := []types.VMVolumeDevice{
+		{
+			DevicePath: "/",
+			HostPath:   devPath,
+		},
+	}
+	volumeID := volDevs[0].UUID()
+
+	firstBootRecord := func() *types.FirstBootRecord {
+		record, err := ct.metadataStore.GetFirstBootRecord(volumeID)
+		if err != nil {
+			t.Fatalf("GetFirstBootRecord(): %v", err)
+		}
+		if record == nil {
+			t.Fatalf("first boot record not found")
+		}
+		return record
+	}
+
+	sandboxes := fakemeta.GetSandboxes(2)
+	expectedInstanceID := sandboxes[0].Name + "." + sandboxes[0].Namespace
+	for n, sandbox := range sandboxes {
+		ct.setPodSandbox(sandbox)
+		containerID := ct.createContainer(sandbox, nil, volDevs)
+		containerInfo, err := ct.metadataStore.Container(containerID).Retrieve()
+		if err != nil {
+			t.Fatalf("Retrieve(): %v", err)
+		}
+		if containerInfo.Config.InstanceID != expectedInstanceID {
+			t.Errorf("bad instance-id for pod %q: %q instead of %q", sandbox.Name, containerInfo.Config.InstanceID, expectedInstanceID)
+		}
+
+		record := firstBootRecord()
+		if record.InstanceID != expectedInstanceID || record.PodName != sandboxes[0].Name {
+			t.Errorf("bad first boot record: %#v", record)
+		}
+		if n == 0 && record.Completed() {
+			t.Errorf("first boot is reported as completed before the VM is started")
+		}
+
+		ct.startContainer(containerID)
+		if !firstBootRecord().Completed() {
+			t.Errorf("first boot is not reported as completed after the VM is started")
+		}
+		ct.removeContainer(containerID)
+	}
+}
@@ -21,6 +21,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/golang/glog"
 
 	"github.com/Mirantis/virtlet/pkg/blockdev"
 	"github.com/Mirantis/virtlet/pkg/metadata"
@@ -29,6 +32,12 @@ import (
 
 const (
 	configFilenameTemplate = "config-*.iso"
+
+	// orphanSandboxGracePeriod is the minimum age of a pod sandbox
+	// that's not ready and has no containers before it's removed by
+	// RemoveOrphanMetadata. It gives kubelet a chance to remove the
+	// sandbox itself.
+	orphanSandboxGracePeriod = 30 * time.Minute
 )
 
 // GarbageCollect retrieves from metadata store list of container ids,
@@ -42,6 +51,17 @@ func (v *VirtualizationTool) GarbageCollect() (allErrors []error) {
 	if fatal {
 		return
 	}
+	// the volumes retained for reuse are only removed after
+	// they expire, and so are the domains kept because of the
+	// soft reboot
+	retainedIDs, err := v.retainedVolumeIDs()
+	if err != nil {
+		allErrors = append(allErrors, err)
+		return
+	}
+	ids = append(ids, retainedIDs...)
+	// so are the orphan domains kept by Reconcile
+	ids = append(ids, v.keptDomainIDList()...)
 
 	allErrors = append(allErrors, v.removeOrphanDomains(ids)...)
 	allErrors = append(allErrors, v.removeOrphanRootVolumes(ids)...)
@@ -94,15 +114,21 @@ func (v *VirtualizationTool) checkSandboxNetNs(sandbox metadata.PodSandboxMetada
 	if err != nil {
 		return err
 	}
+	if sinfo == nil {
+		// the sandbox has no data, it will be removed by
+		// RemoveOrphanMetadata if it has no containers
+		return nil
+	}
 
 	if !v.fsys.IsPathAnNs(sinfo.ContainerSideNetwork.NsPath) {
 		// NS didn't found, need RunSandbox again
-		if err := sandbox.Save(func(s *types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
-			if s != nil {
-				s.State = types.PodSandboxState_SANDBOX_NOTREADY
-			}
+		// the sandbox may have been run again in the meantime,
+		// getting a new network namespace
+		err := sandbox.Save(metadata.ExpectPodSandboxRevision(sinfo.Revision, func(s *types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+			s.State = types.PodSandboxState_SANDBOX_NOTREADY
 			return s, nil
-		}); err != nil {
+		}))
+		if err != nil && err != metadata.ErrRevisionMismatch {
 			return err
 		}
 	}
@@ -110,6 +136,136 @@ func (v *VirtualizationTool) checkSandboxNetNs(sandbox metadata.PodSandboxMetada
 	return nil
 }
 
+// RemoveOrphanMetadata removes the containers whose pod sandboxes
+// don't exist anymore, including their domains and volumes, and then
+// removes the pod sandboxes left by the failed or interrupted
+// RunPodSandbox calls and the sandboxes of the pods that are gone.
+// A pod sandbox is removed if it has no containers and either has no
+// data or is not ready, is older than orphanSandboxGracePeriod and
+// its pod doesn't exist anymore according to the PodChecker. If
+// there's no PodChecker, the sandboxes that are not ready are left
+// for kubelet to remove. The ready sandboxes are never removed because
+// their network resources are still allocated. The expired sandbox
+// tombstones are removed, too.
+func (v *VirtualizationTool) RemoveOrphanMetadata() []error {
+	// the containers must be listed before the pod sandboxes, so a
+	// container of a sandbox created in the meantime is never
+	// considered to be an orphan
+	containers, _, err := v.metadataStore.ListContainersPage(0, "")
+	if err != nil {
+		return []error{fmt.Errorf("cannot list containers: %v", err)}
+	}
+	sandboxes, err := v.metadataStore.ListPodSandboxes(nil)
+	if err != nil {
+		return []error{fmt.Errorf("cannot list pod sandboxes: %v", err)}
+	}
+
+	var allErrors []error
+	sandboxInfos := make(map[string]*types.PodSandboxInfo)
+	unreadable := make(map[string]bool)
+	for _, sandbox := range sandboxes {
+		psi, err := sandbox.Retrieve()
+		if err != nil {
+			allErrors = append(allErrors, fmt.Errorf("cannot retrieve pod sandbox %s: %v", sandbox.GetID(), err))
+			unreadable[sandbox.GetID()] = true
+			continue
+		}
+		sandboxInfos[sandbox.GetID()] = psi
+	}
+
+	for _, container := range containers {
+		ci, err := container.Retrieve()
+		if err != nil {
+			allErrors = append(allErrors, fmt.Errorf("cannot retrieve container %s: %v", container.GetID(), err))
+			continue
+		}
+		if ci == nil {
+			continue
+		}
+		podID := ci.Config.PodSandboxID
+		if unreadable[podID] || sandboxInfos[podID] != nil {
+			continue
+		}
+		glog.Warningf("Removing container %s (%s) of the nonexistent pod sandbox %s", container.GetID(), ci.Name, podID)
+		if err := v.RemoveContainer(container.GetID()); err != nil {
+			allErrors = append(allErrors, fmt.Errorf("cannot remove orphan container %s: %v", container.GetID(), err))
+		}
+	}
+
+	for _, sandbox := range sandboxes {
+		podID := sandbox.GetID()
+		if unreadable[podID] {
+			continue
+		}
+		psi := sandboxInfos[podID]
+		if psi != nil && (psi.State == types.PodSandboxState_SANDBOX_READY ||
+			v.clock.Since(time.Unix(0, psi.CreatedAt)) < orphanSandboxGracePeriod) {
+			continue
+		}
+		podContainers, err := v.metadataStore.ListPodContainers(podID)
+		if err != nil {
+			allErrors = append(allErrors, fmt.Errorf("cannot list containers for pod %s: %v", podID, err))
+			continue
+		}
+		if len(podContainers) != 0 {
+			continue
+		}
+		if psi != nil {
+			// kubelet may still run the sandbox again
+			// while the pod exists
+			exists, err := v.podExists(psi)
+			if err != nil {
+				allErrors = append(allErrors, fmt.Errorf("cannot check the pod of pod sandbox %s: %v", podID, err))
+				continue
+			}
+			if exists {
+				continue
+			}
+		}
+		glog.Warningf("Removing orphan pod sandbox %s", podID)
+		var revision uint64
+		if psi != nil {
+			revision = psi.Revision
+		}
+		var removed *types.PodSandboxInfo
+		// the sandbox may have been run again in the meantime
+		err = sandbox.Save(metadata.ExpectPodSandboxRevision(revision, func(c *types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+			removed = c
+			return nil, nil
+		}))
+		switch {
+		case err == metadata.ErrRevisionMismatch:
+			glog.V(2).Infof("Pod sandbox %s was changed while being checked, not removing it", podID)
+		case err != nil:
+			allErrors = append(allErrors, fmt.Errorf("cannot remove orphan pod sandbox %s: %v", podID, err))
+		default:
+			if removed != nil {
+				v.RecordSandboxEvent(podID, types.SandboxEventRemoved, "", types.SandboxRemovedAsOrphan)
+			}
+			v.SaveSandboxTombstone(podID, removed, types.SandboxRemovedAsOrphan)
+		}
+	}
+
+	if err := v.pruneSandboxTombstones(); err != nil {
+		allErrors = append(allErrors, err)
+	}
+
+	return allErrors
+}
+
+// podExists returns true if the pod of the sandbox exists or if
+// there's no way to check it.
+func (v *VirtualizationTool) podExists(psi *types.PodSandboxInfo) (bool, error) {
+	switch {
+	case v.podChecker == nil:
+		return true, nil
+	case psi.Config == nil:
+		return false, nil
+	default:
+		return v.podChecker.PodExists(psi.Config.Namespace, psi.Config.Name, psi.Config.Uid)
+	}
+}
+
 func inList(list []string, filter func(string) bool) bool {
 	for _, element := range list {
 		if filter(element) {
@@ -193,11 +349,17 @@ func (v *VirtualizationTool) removeOrphanRootVolumes(ids []string) []error {
 		}
 
 		filename := filepath.Base(path)
+		prefix := ""
+		for _, p := range []string{"virtlet_root_", swapVolumePrefix} {
+			if strings.HasPrefix(filename, p) {
+				prefix = p
+			}
+		}
 		filter := func(id string) bool {
-			return "virtlet_root_"+id == filename
+			return prefix+id == filename
 		}
 
-		if strings.HasPrefix(filename, "virtlet_root_") && !inList(ids, filter) {
+		if prefix != "" && !inList(ids, filter) {
 			if err := volume.Remove(); err != nil {
 				allErrors = append(
 					allErrors,
@@ -289,6 +451,11 @@ func (v *VirtualizationTool) removeOrphanConfigImages(ids []string, directory st
 					),
 				)
 			}
+			// remove the content hashes of the iso image, too
+			hashesPath := path + ".sha256"
+			if err := os.Remove(hashesPath); err != nil && !os.IsNotExist(err) {
+				allErrors = append(allErrors, fmt.Errorf("cannot remove '%s': %v", hashesPath, err))
+			}
 		}
 	}
 
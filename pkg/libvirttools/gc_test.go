@@ -21,12 +21,17 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"testing"
+	"time"
 
 	libvirtxml "github.com/libvirt/libvirt-go-xml"
 
 	blockdev "github.com/Mirantis/virtlet/pkg/blockdev"
 	fakeblockdev "github.com/Mirantis/virtlet/pkg/blockdev/fake"
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
 	fakeutils "github.com/Mirantis/virtlet/pkg/utils/fake"
 	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
 	"github.com/Mirantis/virtlet/tests/gm"
@@ -277,3 +282,125 @@ func difference(a, b []string) []string {
 	}
 	return ab
 }
+
+type fakePodChecker map[string]bool
+
+func (c fakePodChecker) PodExists(namespace, name, uid string) (bool, error) {
+	return c[uid], nil
+}
+
+func TestOrphanMetadataCleanup(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+	ct.virtTool.config.SandboxTombstoneTTL = time.Hour
+
+	sandboxes := fakemeta.GetSandboxes(5)
+	for _, sandbox := range sandboxes {
+		ct.setPodSandbox(sandbox)
+	}
+	// the pod of the last sandbox still exists
+	ct.virtTool.SetPodChecker(fakePodChecker{sandboxes[4].Uid: true})
+	liveContainerID := ct.createContainer(sandboxes[0], nil, nil)
+	orphanContainerID := ct.createContainer(sandboxes[1], nil, nil)
+	// the container is left behind by a removed sandbox. As the
+	// containers are removed along with their sandbox, such a
+	// container is made by moving it to another sandbox that
+	// doesn't exist before removing its sandbox
+	if err := ct.metadataStore.Container(orphanContainerID).Save(func(ci *types.ContainerInfo) (*types.ContainerInfo, error) {
+		ci.Config.PodSandboxID = "removed-pod"
+		return ci, nil
+	}); err != nil {
+		t.Fatalf("Container().Save(): %v", err)
+	}
+	if err := ct.metadataStore.PodSandbox(sandboxes[1].Uid).Save(func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatalf("PodSandbox().Save(): %v", err)
+	}
+	// the sandboxes are stopped and have no containers
+	for _, sandbox := range []*types.PodSandboxConfig{sandboxes[2], sandboxes[4]} {
+		if err := ct.metadataStore.PodSandbox(sandbox.Uid).Save(func(psi *types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+			psi.State = types.PodSandboxState_SANDBOX_NOTREADY
+			return psi, nil
+		}); err != nil {
+			t.Fatalf("PodSandbox().Save(): %v", err)
+		}
+	}
+	// the container refers to a sandbox that was never created,
+	// which leaves a sandbox without data in the store
+	danglingContainerID := "1c8e5b8e-9e76-4b5a-8c1a-0b3a1cbd9bb2"
+	if err := ct.metadataStore.Container(danglingContainerID).Save(func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+		return &types.ContainerInfo{
+			Name:   "dangling",
+			Config: types.VMConfig{PodSandboxID: "nonexistent-pod"},
+		}, nil
+	}); err != nil {
+		t.Fatalf("Container().Save(): %v", err)
+	}
+
+	if errors := ct.virtTool.RemoveOrphanMetadata(); len(errors) != 0 {
+		t.Errorf("RemoveOrphanMetadata returned errors: %v", errors)
+	}
+	for _, id := range []string{orphanContainerID, danglingContainerID} {
+		if ci, err := ct.metadataStore.Container(id).Retrieve(); err != nil {
+			t.Errorf("Container().Retrieve(): %v", err)
+		} else if ci != nil {
+			t.Errorf("orphan container %s was not removed", id)
+		}
+	}
+	if ci, err := ct.metadataStore.Container(liveContainerID).Retrieve(); err != nil || ci == nil {
+		t.Errorf("the container of an existing sandbox was removed (error: %v)", err)
+	}
+	if domains, _ := ct.domainConn.ListDomains(); len(domains) != 1 {
+		t.Errorf("Expected a single remaining domain, ListDomains() returned %d of them", len(domains))
+	}
+
+	verifySandboxes := func(expectedIDs ...string) {
+		metas, err := ct.metadataStore.ListPodSandboxes(nil)
+		if err != nil {
+			t.Fatalf("ListPodSandboxes(): %v", err)
+		}
+		var ids []string
+		for _, meta := range metas {
+			ids = append(ids, meta.GetID())
+		}
+		sort.Strings(expectedIDs)
+		if !reflect.DeepEqual(ids, expectedIDs) {
+			t.Errorf("bad list of pod sandboxes: %v instead of %v", ids, expectedIDs)
+		}
+	}
+	// the stopped sandboxes are kept until the grace period passes
+	verifySandboxes(sandboxes[0].Uid, sandboxes[2].Uid, sandboxes[3].Uid, sandboxes[4].Uid)
+
+	ct.clock.Advance(orphanSandboxGracePeriod + time.Minute)
+	if errors := ct.virtTool.RemoveOrphanMetadata(); len(errors) != 0 {
+		t.Errorf("RemoveOrphanMetadata returned errors: %v", errors)
+	}
+	// the ready sandboxes are never removed, and neither are
+	// the stopped sandboxes of the existing pods
+	verifySandboxes(sandboxes[0].Uid, sandboxes[3].Uid, sandboxes[4].Uid)
+
+	// only the sandboxes that had data get the tombstones
+	tombstones, err := ct.metadataStore.ListSandboxTombstones()
+	if err != nil {
+		t.Fatalf("ListSandboxTombstones(): %v", err)
+	}
+	if len(tombstones) != 1 {
+		t.Fatalf("expected a single sandbox tombstone, got %#v", tombstones)
+	}
+	if tombstones[0].PodSandboxID != sandboxes[2].Uid || tombstones[0].PodName != sandboxes[2].Name ||
+		tombstones[0].Reason != types.SandboxRemovedAsOrphan || tombstones[0].DeletedAt != ct.clock.Now().UnixNano() {
+		t.Errorf("bad sandbox tombstone: %#v", tombstones[0])
+	}
+
+	// the tombstone expires after the TTL
+	ct.clock.Advance(time.Hour + time.Minute)
+	if errors := ct.virtTool.RemoveOrphanMetadata(); len(errors) != 0 {
+		t.Errorf("RemoveOrphanMetadata returned errors: %v", errors)
+	}
+	if tombstones, err := ct.metadataStore.ListSandboxTombstones(); err != nil {
+		t.Errorf("ListSandboxTombstones(): %v", err)
+	} else if len(tombstones) != 0 {
+		t.Errorf("the expired sandbox tombstones were not removed: %#v", tombstones)
+	}
+}
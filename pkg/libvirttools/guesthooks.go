@@ -0,0 +1,198 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/utils"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+const (
+	guestHookPostStart = "PostStart"
+	guestHookPreStop   = "PreStop"
+
+	defaultGuestHookTimeout  = 30 * time.Second
+	guestHookRetryInterval   = time.Second
+	guestAgentCommandTimeout = 5 * time.Second
+	// the output of the hooks is truncated to this length
+	// in the events
+	maxGuestHookOutputLength = 1024
+
+	// ContainerReasonPostStartHookError is the container status
+	// reason used when the VM is stopped because its post-start
+	// hook has failed.
+	ContainerReasonPostStartHookError = "PostStartHookError"
+)
+
+type guestAgentRequest struct {
+	Execute   string      `json:"execute"`
+	Arguments interface{} `json:"arguments,omitempty"`
+}
+
+type guestExecStatus struct {
+	Exited   bool   `json:"exited"`
+	ExitCode int    `json:"exitcode"`
+	OutData  string `json:"out-data"`
+	ErrData  string `json:"err-data"`
+}
+
+// guestAgentCommand executes the guest agent command and unmarshals
+// the contents of the "return" field of its response into result
+// unless it's nil.
+func guestAgentCommand(domain virt.Domain, execute string, args, result interface{}) error {
+	req, err := json.Marshal(guestAgentRequest{Execute: execute, Arguments: args})
+	if err != nil {
+		return err
+	}
+	resp, err := domain.QemuAgentCommand(string(req), guestAgentCommandTimeout)
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	r := struct {
+		Return interface{} `json:"return"`
+	}{Return: result}
+	if err := json.Unmarshal([]byte(resp), &r); err != nil {
+		return fmt.Errorf("error unmarshalling guest agent response for %s: %v", execute, err)
+	}
+	return nil
+}
+
+// guestHookTimeout returns the timeout for the guest hooks of the VM.
+func guestHookTimeout(config *types.VMConfig) time.Duration {
+	if config.ParsedAnnotations.GuestHookTimeoutSeconds > 0 {
+		return time.Duration(config.ParsedAnnotations.GuestHookTimeoutSeconds) * time.Second
+	}
+	return defaultGuestHookTimeout
+}
+
+// execInGuest runs the shell command inside the VM via the guest
+// agent, waiting for the agent to become available first. It returns
+// the combined output of the command.
+func (v *VirtualizationTool) execInGuest(domain virt.Domain, command string, timeout time.Duration) (string, error) {
+	start := v.clock.Now()
+	if err := utils.WaitLoop(func() (bool, error) {
+		return guestAgentCommand(domain, "guest-ping", nil, nil) == nil, nil
+	}, guestHookRetryInterval, timeout, v.clock); err != nil {
+		return "", fmt.Errorf("guest agent is not available: %v", err)
+	}
+
+	var execResult struct {
+		PID int `json:"pid"`
+	}
+	if err := guestAgentCommand(domain, "guest-exec", map[string]interface{}{
+		"path":           "/bin/sh",
+		"arg":            []string{"-c", command},
+		"capture-output": true,
+	}, &execResult); err != nil {
+		return "", err
+	}
+
+	var status guestExecStatus
+	if err := utils.WaitLoop(func() (bool, error) {
+		if err := guestAgentCommand(domain, "guest-exec-status", map[string]int{"pid": execResult.PID}, &status); err != nil {
+			return false, err
+		}
+		return status.Exited, nil
+	}, guestHookRetryInterval, timeout-v.clock.Since(start), v.clock); err != nil {
+		return "", err
+	}
+
+	var output []string
+	for _, data := range []string{status.OutData, status.ErrData} {
+		bs, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return "", fmt.Errorf("error decoding the command output: %v", err)
+		}
+		if s := strings.TrimSpace(string(bs)); s != "" {
+			output = append(output, s)
+		}
+	}
+	outStr := strings.Join(output, "\n")
+	if status.ExitCode != 0 {
+		return outStr, fmt.Errorf("command exited with code %d", status.ExitCode)
+	}
+	return outStr, nil
+}
+
+// runGuestHook runs the specified guest hook and records its outcome
+// as an event for the VM pod.
+func (v *VirtualizationTool) runGuestHook(domain virt.Domain, config *types.VMConfig, hookName, command string, timeout time.Duration) error {
+	output, err := v.execInGuest(domain, command, timeout)
+	if len(output) > maxGuestHookOutputLength {
+		output = output[:maxGuestHookOutputLength] + "..."
+	}
+	if err != nil {
+		v.eventRecorder.Eventf(config, v1.EventTypeWarning, "Failed"+hookName+"Hook", "%s hook %q failed: %v, output: %q", hookName, command, err, output)
+		return err
+	}
+	v.eventRecorder.Eventf(config, v1.EventTypeNormal, hookName+"HookSucceeded", "%s hook %q succeeded, output: %q", hookName, command, output)
+	return nil
+}
+
+// startPostStartHook runs the post-start hook of the VM in the
+// background.
+func (v *VirtualizationTool) startPostStartHook(containerID string, domain virt.Domain, config *types.VMConfig) {
+	w := v.postStartHooks.start(containerID)
+	go func() {
+		defer v.postStartHooks.done(containerID, w)
+		v.runPostStartHook(w, containerID, domain, config)
+	}()
+}
+
+// runPostStartHook runs the post-start hook of the VM. Like with
+// the container lifecycle hooks, the VM is stopped if the hook fails,
+// so it's restarted by kubelet, and the failure is reported in the
+// container status. Nothing is done after the hook fails if the
+// watcher is stopped because the VM is being stopped or removed.
+func (v *VirtualizationTool) runPostStartHook(w *vmWatcher, containerID string, domain virt.Domain, config *types.VMConfig) {
+	hookErr := v.runGuestHook(domain, config, guestHookPostStart, config.ParsedAnnotations.PostStartHook, guestHookTimeout(config))
+	if hookErr == nil {
+		return
+	}
+	w.do(func() {
+		glog.Warningf("Post-start hook failed for domain %q, stopping the VM: %v", containerID, hookErr)
+		if err := v.metadataStore.Container(containerID).Save(
+			func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
+				// make sure the container is not removed during the call
+				if c != nil {
+					c.Reason = ContainerReasonPostStartHookError
+					c.Message = fmt.Sprintf("post-start hook failed: %v", hookErr)
+				}
+				return c, nil
+			}); err != nil {
+			glog.Errorf("Error storing post-start hook failure info for container %q: %v", containerID, err)
+		}
+		// the container is marked as exited by ContainerInfo()
+		// once the domain is found to be shut off
+		if err := domain.Destroy(); err != nil {
+			glog.Errorf("Failed to destroy domain %q after the post-start hook failure: %v", containerID, err)
+		}
+	})
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/stream"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+const (
+	guestLogPollInterval = 2 * time.Second
+	// the max amount of data read from the guest log file
+	// using a single guest-file-read command
+	guestLogReadChunkSize = 48 * 1024
+	// the incomplete line is written to the container log
+	// if it becomes longer than this
+	maxGuestLogLineLength = 16 * 1024
+	// guest-file-seek 'whence' values. Integer values are used
+	// instead of "set" / "end" for compatibility with older
+	// guest agent versions
+	guestSeekSet = 0
+	guestSeekEnd = 2
+)
+
+type guestFileReadResult struct {
+	Count  int    `json:"count"`
+	BufB64 string `json:"buf-b64"`
+	EOF    bool   `json:"eof"`
+}
+
+type guestFileSeekResult struct {
+	Position int64 `json:"position"`
+	EOF      bool  `json:"eof"`
+}
+
+// guestLogStreamer copies the contents of a log file inside the VM
+// to the container log using the guest agent.
+type guestLogStreamer struct {
+	domain    virt.Domain
+	guestPath string
+	logPath   string
+	handle    int
+	offset    int64
+	fromEnd   bool
+	partial   string
+}
+
+// newGuestLogStreamer returns a guestLogStreamer for the specified
+// file inside the VM. If fromEnd is true, the contents that's
+// already present in the file is skipped.
+func newGuestLogStreamer(domain virt.Domain, guestPath, logPath string, fromEnd bool) *guestLogStreamer {
+	return &guestLogStreamer{
+		domain:    domain,
+		guestPath: guestPath,
+		logPath:   logPath,
+		handle:    -1,
+		fromEnd:   fromEnd,
+	}
+}
+
+// open opens the guest log file and positions the handle at the
+// offset where the previous read has stopped. If the file has
+// become shorter than that, it's considered to be truncated or
+// recreated, and is read from the beginning.
+func (s *guestLogStreamer) open() error {
+	var handle int
+	if err := guestAgentCommand(s.domain, "guest-file-open", map[string]interface{}{
+		"path": s.guestPath,
+		"mode": "r",
+	}, &handle); err != nil {
+		return fmt.Errorf("can't open %q inside the VM: %v", s.guestPath, err)
+	}
+	var seekResult guestFileSeekResult
+	err := guestAgentCommand(s.domain, "guest-file-seek", map[string]interface{}{
+		"handle": handle,
+		"offset": 0,
+		"whence": guestSeekEnd,
+	}, &seekResult)
+	if err == nil {
+		switch {
+		case s.fromEnd:
+			s.offset = seekResult.Position
+			s.fromEnd = false
+		case seekResult.Position < s.offset:
+			s.offset = 0
+		}
+		err = guestAgentCommand(s.domain, "guest-file-seek", map[string]interface{}{
+			"handle": handle,
+			"offset": s.offset,
+			"whence": guestSeekSet,
+		}, &seekResult)
+	}
+	if err != nil {
+		s.closeHandle(handle)
+		return fmt.Errorf("can't seek in %q inside the VM: %v", s.guestPath, err)
+	}
+	s.handle = handle
+	return nil
+}
+
+func (s *guestLogStreamer) closeHandle(handle int) {
+	if err := guestAgentCommand(s.domain, "guest-file-close", map[string]int{"handle": handle}, nil); err != nil {
+		glog.V(3).Infof("Error closing guest log file %q: %v", s.guestPath, err)
+	}
+}
+
+// poll reads any new data from the guest log file and appends the
+// complete lines to the container log.
+func (s *guestLogStreamer) poll() error {
+	if s.handle < 0 {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	for {
+		var r guestFileReadResult
+		if err := guestAgentCommand(s.domain, "guest-file-read", map[string]int{
+			"handle": s.handle,
+			"count":  guestLogReadChunkSize,
+		}, &r); err != nil {
+			// the guest agent may have been restarted or
+			// the VM rebooted, so the handle needs to be
+			// reopened
+			s.handle = -1
+			return fmt.Errorf("error reading %q inside the VM: %v", s.guestPath, err)
+		}
+		data, err := base64.StdEncoding.DecodeString(r.BufB64)
+		if err != nil {
+			return fmt.Errorf("error decoding the contents of %q: %v", s.guestPath, err)
+		}
+		s.offset += int64(len(data))
+		if err := s.write(string(data)); err != nil {
+			return err
+		}
+		if r.EOF || len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+// write appends the complete lines from data to the container log,
+// keeping the incomplete last line until more data arrives.
+func (s *guestLogStreamer) write(data string) error {
+	s.partial += data
+	var lines []string
+	for {
+		n := strings.IndexByte(s.partial, '\n')
+		if n < 0 {
+			break
+		}
+		lines = append(lines, s.partial[:n+1])
+		s.partial = s.partial[n+1:]
+	}
+	if len(s.partial) > maxGuestLogLineLength {
+		lines = append(lines, s.partial)
+		s.partial = ""
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return stream.AppendLogLines(s.logPath, stream.StderrStream, lines)
+}
+
+// streamGuestLog copies the in-guest log file specified for the VM
+// to the container log till the domain stops running. Errors are
+// expected while the VM is booting and the guest agent isn't
+// available yet, so they're only logged at high verbosity levels.
+func (v *VirtualizationTool) streamGuestLog(domain virt.Domain, config *types.VMConfig, fromEnd bool) {
+	guestPath := config.ParsedAnnotations.GuestLogFile
+	if config.LogDirectory == "" || config.LogPath == "" {
+		glog.Warningf("Can't stream guest log file %q for VM %s/%s: no container log path", guestPath, config.PodNamespace, config.PodName)
+		return
+	}
+	s := newGuestLogStreamer(domain, guestPath, filepath.Join(config.LogDirectory, config.LogPath), fromEnd)
+	glog.V(1).Infof("Streaming guest log file %q for VM %s/%s", guestPath, config.PodNamespace, config.PodName)
+	for {
+		state, err := domain.State()
+		if err != nil || state != virt.DomainStateRunning {
+			glog.V(1).Infof("Stopped streaming guest log file %q for VM %s/%s", guestPath, config.PodNamespace, config.PodName)
+			return
+		}
+		if err := s.poll(); err != nil {
+			glog.V(3).Infof("Guest log streaming for VM %s/%s: %v", config.PodNamespace, config.PodName, err)
+		}
+		<-v.clock.After(guestLogPollInterval)
+	}
+}
+
+// ResumeGuestLogStreaming restarts streaming the in-guest log files
+// for the running VMs after Virtlet restart. The contents that was
+// added to the files while Virtlet was not running is skipped.
+func (v *VirtualizationTool) ResumeGuestLogStreaming() error {
+	containers, err := v.ListContainers(nil)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		if c.State != types.ContainerState_CONTAINER_RUNNING ||
+			c.Config.ParsedAnnotations == nil ||
+			c.Config.ParsedAnnotations.GuestLogFile == "" {
+			continue
+		}
+		domain, err := v.domainConn.LookupDomainByUUIDString(c.Id)
+		if err != nil {
+			return fmt.Errorf("failed to look up domain %q: %v", c.Id, err)
+		}
+		go v.streamGuestLog(domain, &c.Config, true)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+)
+
+func TestGuestLogStreaming(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	sandbox.Annotations["VirtletGuestAgent"] = "true"
+	ct.setPodSandbox(sandbox)
+	containerID := ct.createContainer(sandbox, nil, nil)
+	ct.startContainer(containerID)
+	domain, err := ct.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		t.Fatalf("LookupDomainByUUIDString(): %v", err)
+	}
+
+	content := "Cloud-init v. 18.2 running 'init'\nCloud-init v. 18.2 finished\nincomplete"
+	ct.domainConn.SetGuestAgentResponse("guest-file-open", `{"return":1000}`)
+	ct.domainConn.SetGuestAgentResponse("guest-file-seek", `{"return":{"position":120,"eof":true}}`)
+	ct.domainConn.SetGuestAgentResponse("guest-file-read", `{"return":{"count":70,"buf-b64":"`+
+		base64.StdEncoding.EncodeToString([]byte(content))+`","eof":true}}`)
+
+	logPath := filepath.Join(ct.tmpDir, "container.log")
+	s := newGuestLogStreamer(domain, "/var/log/cloud-init-output.log", logPath, true)
+	if err := s.poll(); err != nil {
+		t.Fatalf("poll(): %v", err)
+	}
+	if s.offset != 120+int64(len(content)) {
+		t.Errorf("bad offset %d after the first poll", s.offset)
+	}
+	if s.partial != "incomplete" {
+		t.Errorf("bad incomplete line %q", s.partial)
+	}
+
+	data, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("ReadFile(): %v", err)
+	}
+	var lines []string
+	for _, l := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var m map[string]string
+		if err := json.Unmarshal([]byte(l), &m); err != nil {
+			t.Fatalf("failed to unmarshal log line %q: %v", l, err)
+		}
+		if m["stream"] != "stderr" {
+			t.Errorf("bad stream in log line %q", l)
+		}
+		lines = append(lines, m["log"])
+	}
+	expectedLines := []string{
+		"Cloud-init v. 18.2 running 'init'\n",
+		"Cloud-init v. 18.2 finished\n",
+	}
+	if !reflect.DeepEqual(lines, expectedLines) {
+		t.Errorf("bad log lines:\n%#v\ninstead of\n%#v", lines, expectedLines)
+	}
+
+	// the file appears to be truncated upon reopening
+	s.handle = -1
+	if err := s.open(); err != nil {
+		t.Fatalf("open(): %v", err)
+	}
+	if s.offset != 0 {
+		t.Errorf("the offset was not reset for the truncated file: %d", s.offset)
+	}
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const (
+	// hostDeviceChannelPrefix is the prefix of the names of the
+	// virtio-serial ports used to pass the host character devices
+	// to the VMs.
+	hostDeviceChannelPrefix = "hostdev."
+	// mdevModel is the device API of the mediated devices.
+	mdevModel = "vfio-pci"
+)
+
+// HostDevicePolicy specifies which host devices can be passed
+// to the VMs on the node.
+type HostDevicePolicy struct {
+	// Devices lists the rules that allow passing the host
+	// devices to the VMs.
+	Devices []HostDeviceRule `json:"devices"`
+}
+
+// HostDeviceRule allows passing the host devices that match
+// a glob pattern to the VMs.
+type HostDeviceRule struct {
+	// Path is a glob pattern for the device paths, e.g.
+	// /dev/net/tun, /dev/sgx/* or /sys/bus/mdev/devices/*
+	Path string `json:"path"`
+	// Namespaces lists the namespaces of the pods that can use
+	// the devices. Empty list means any namespace.
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// LoadHostDevicePolicy loads the host device policy from the
+// specified YAML file.
+func LoadHostDevicePolicy(path string) (*HostDevicePolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read host device policy file %q: %v", path, err)
+	}
+	var policy HostDevicePolicy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("can't parse host device policy file %q: %v", path, err)
+	}
+	for _, rule := range policy.Devices {
+		if !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("bad host device policy file %q: device path must be absolute: %q", path, rule.Path)
+		}
+		if _, err := filepath.Match(rule.Path, ""); err != nil {
+			return nil, fmt.Errorf("bad host device policy file %q: bad device path pattern %q: %v", path, rule.Path, err)
+		}
+	}
+	return &policy, nil
+}
+
+// allows returns true if the policy allows passing the device with
+// the specified path to the VMs from the specified namespace.
+func (p *HostDevicePolicy) allows(path, namespace string) bool {
+	for _, rule := range p.Devices {
+		// the patterns are verified when the policy is loaded
+		if matches, _ := filepath.Match(rule.Path, path); !matches {
+			continue
+		}
+		if len(rule.Namespaces) == 0 || stringInList(namespace, rule.Namespaces) {
+			return true
+		}
+	}
+	return false
+}
+
+func stringInList(s string, l []string) bool {
+	for _, item := range l {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isCharDevice returns true if the path points to a character device.
+func isCharDevice(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// hostDeviceChannelName returns the name of the virtio-serial port
+// used to pass the host character device to the VM, e.g.
+// hostdev.net.tun for /dev/net/tun.
+func hostDeviceChannelName(path string) string {
+	return hostDeviceChannelPrefix + strings.Replace(strings.TrimPrefix(path, "/dev/"), "/", ".", -1)
+}
+
+// checkHostDevices verifies that the host devices requested using
+// VirtletHostDevices annotation and the mediated devices requested
+// using VirtletMdevProfiles annotation are allowed for the pod by
+// the host device policy of the node.
+func (v *VirtualizationTool) checkHostDevices(config *types.VMConfig) error {
+	paths := append([]string{}, config.ParsedAnnotations.HostDevices...)
+	for n := range config.ParsedAnnotations.MdevProfiles {
+		paths = append(paths, types.MdevDevicePathPrefix+mdevUUID(config.PodSandboxID, n))
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	return v.checkHostDevicePaths(paths, config.PodNamespace)
+}
+
+// checkHostDevicePaths verifies that the host device policy of the
+// node allows passing the devices with the specified paths to the
+// VMs of the pods in the specified namespace.
+func (v *VirtualizationTool) checkHostDevicePaths(paths []string, namespace string) error {
+	if v.config.HostDevicePolicyFile == "" {
+		return errors.New("host devices are not enabled on this node")
+	}
+	// the policy is reloaded each time so it can be updated
+	// without restarting Virtlet
+	policy, err := LoadHostDevicePolicy(v.config.HostDevicePolicyFile)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if !policy.allows(path, namespace) {
+			return fmt.Errorf("host device %q is not allowed for the pods in namespace %q on this node", path, namespace)
+		}
+	}
+	return nil
+}
+
+// addHostDevicesToDomain adds the host character devices and the
+// mediated devices requested using VirtletHostDevices annotation to
+// the domain. The character devices are passed to the VM as
+// virtio-serial ports and the mediated devices as vfio-pci devices.
+// The block devices are handled by GetHostDeviceVolumes.
+func addHostDevicesToDomain(domain *libvirtxml.Domain, config *types.VMConfig) error {
+	for _, path := range config.ParsedAnnotations.HostDevices {
+		switch {
+		case strings.HasPrefix(path, types.MdevDevicePathPrefix):
+			if _, err := os.Stat(path); err != nil {
+				return fmt.Errorf("mediated device %q is not available: %v", path, err)
+			}
+			domain.Devices.Hostdevs = append(domain.Devices.Hostdevs, newMdevHostdev(path[len(types.MdevDevicePathPrefix):]))
+		case isCharDevice(path):
+			domain.Devices.Channels = append(domain.Devices.Channels, libvirtxml.DomainChannel{
+				Source: &libvirtxml.DomainChardevSource{
+					Dev: &libvirtxml.DomainChardevSourceDev{Path: path},
+				},
+				Target: &libvirtxml.DomainChannelTarget{
+					VirtIO: &libvirtxml.DomainChannelTargetVirtIO{Name: hostDeviceChannelName(path)},
+				},
+			})
+		case isBlockDevice(path):
+			// attached as a disk by hostDeviceVolume
+		default:
+			return fmt.Errorf("host device %q doesn't exist or is neither a character nor a block device", path)
+		}
+	}
+	return nil
+}
+
+// hostDeviceVolume denotes a host block device that's passed to
+// the VM as a disk using VirtletHostDevices annotation
+type hostDeviceVolume struct {
+	volumeBase
+	path string
+}
+
+var _ VMVolume = &hostDeviceVolume{}
+
+func (v *hostDeviceVolume) IsDisk() bool { return true }
+
+func (v *hostDeviceVolume) UUID() string { return "" }
+
+func (v *hostDeviceVolume) Setup() (*libvirtxml.DomainDisk, *libvirtxml.DomainFilesystem, error) {
+	// we need to follow the symlinks as only devices under /dev
+	// will be chown'ed properly by QEMU
+	path, err := filepath.EvalSymlinks(v.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := verifyRawDeviceAccess(path); err != nil {
+		return nil, nil, err
+	}
+	return &libvirtxml.DomainDisk{
+		Device: "disk",
+		Source: &libvirtxml.DomainDiskSource{Block: &libvirtxml.DomainDiskSourceBlock{Dev: path}},
+		Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "raw"},
+	}, nil, nil
+}
+
+// GetHostDeviceVolumes returns VMVolume objects for the host block
+// devices requested using VirtletHostDevices annotation. These
+// devices are attached to the VM as disks.
+func GetHostDeviceVolumes(config *types.VMConfig, owner volumeOwner) ([]VMVolume, error) {
+	if config.ParsedAnnotations == nil {
+		return nil, nil
+	}
+	var vols []VMVolume
+	for _, path := range config.ParsedAnnotations.HostDevices {
+		if strings.HasPrefix(path, types.MdevDevicePathPrefix) || !isBlockDevice(path) {
+			continue
+		}
+		vols = append(vols, &hostDeviceVolume{
+			volumeBase: volumeBase{config, owner},
+			path:       path,
+		})
+	}
+	return vols, nil
+}
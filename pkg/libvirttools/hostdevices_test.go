@@ -0,0 +1,206 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+// charDevice is a character device that's expected to be present
+// in the build container
+const charDevice = "/dev/null"
+
+const samplePolicy = `
+devices:
+- path: /dev/null
+- path: /dev/sgx/*
+  namespaces:
+  - secure
+  - secure-2
+- path: /sys/bus/mdev/devices/*
+  namespaces:
+  - fpga
+`
+
+func writeHostDevicePolicy(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "host-devices.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	return path
+}
+
+func TestHostDevicePolicy(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "hostdevices-")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, tc := range []struct {
+		name         string
+		policy       string
+		devices      []string
+		mdevProfiles []string
+		namespace    string
+		errSubstring string
+	}{
+		{
+			name:      "no devices",
+			namespace: "default",
+		},
+		{
+			name:         "host devices disabled",
+			devices:      []string{"/dev/null"},
+			namespace:    "default",
+			errSubstring: "not enabled",
+		},
+		{
+			name:      "device allowed for any namespace",
+			policy:    samplePolicy,
+			devices:   []string{"/dev/null"},
+			namespace: "default",
+		},
+		{
+			name:      "device allowed for the namespace",
+			policy:    samplePolicy,
+			devices:   []string{"/dev/sgx/enclave", "/dev/null"},
+			namespace: "secure-2",
+		},
+		{
+			name:         "device not allowed for the namespace",
+			policy:       samplePolicy,
+			devices:      []string{"/dev/sgx/enclave"},
+			namespace:    "default",
+			errSubstring: `host device "/dev/sgx/enclave" is not allowed`,
+		},
+		{
+			name:         "device not listed in the policy",
+			policy:       samplePolicy,
+			devices:      []string{"/dev/null", "/dev/net/tun"},
+			namespace:    "secure",
+			errSubstring: `host device "/dev/net/tun" is not allowed`,
+		},
+		{
+			name:         "mediated devices disabled",
+			mdevProfiles: []string{"nvidia-63"},
+			namespace:    "fpga",
+			errSubstring: "not enabled",
+		},
+		{
+			name:         "mediated device profile allowed for the namespace",
+			policy:       samplePolicy,
+			mdevProfiles: []string{"nvidia-63"},
+			namespace:    "fpga",
+		},
+		{
+			name:         "mediated device profile not allowed for the namespace",
+			policy:       samplePolicy,
+			mdevProfiles: []string{"nvidia-63"},
+			namespace:    "default",
+			errSubstring: `host device "/sys/bus/mdev/devices/`,
+		},
+		{
+			name:         "relative path in the policy",
+			policy:       "devices:\n- path: dev/null\n",
+			devices:      []string{"/dev/null"},
+			namespace:    "default",
+			errSubstring: "device path must be absolute",
+		},
+		{
+			name:         "bad pattern in the policy",
+			policy:       "devices:\n- path: /dev/[\n",
+			devices:      []string{"/dev/null"},
+			namespace:    "default",
+			errSubstring: "bad device path pattern",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v := &VirtualizationTool{virtualizationState: &virtualizationState{}}
+			if tc.policy != "" {
+				v.config.HostDevicePolicyFile = writeHostDevicePolicy(t, tmpDir, tc.policy)
+			}
+			err := v.checkHostDevices(&types.VMConfig{
+				PodSandboxID: "69eec606-0493-5825-73a4-c5e0c0236155",
+				PodNamespace: tc.namespace,
+				ParsedAnnotations: &types.VirtletAnnotations{
+					HostDevices:  tc.devices,
+					MdevProfiles: tc.mdevProfiles,
+				},
+			})
+			switch {
+			case err != nil && tc.errSubstring == "":
+				t.Errorf("checkHostDevices(): unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Errorf("checkHostDevices() didn't return the expected error (substring %q)", tc.errSubstring)
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("checkHostDevices(): didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			}
+		})
+	}
+}
+
+func TestAddHostDevicesToDomain(t *testing.T) {
+	if !isCharDevice(charDevice) {
+		t.Skipf("%s is not available", charDevice)
+	}
+
+	config := &types.VMConfig{
+		ParsedAnnotations: &types.VirtletAnnotations{
+			HostDevices: []string{charDevice},
+		},
+	}
+	domain := &libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{}}
+	if err := addHostDevicesToDomain(domain, config); err != nil {
+		t.Fatalf("addHostDevicesToDomain(): %v", err)
+	}
+	expectedChannels := []libvirtxml.DomainChannel{
+		{
+			Source: &libvirtxml.DomainChardevSource{
+				Dev: &libvirtxml.DomainChardevSourceDev{Path: charDevice},
+			},
+			Target: &libvirtxml.DomainChannelTarget{
+				VirtIO: &libvirtxml.DomainChannelTargetVirtIO{Name: "hostdev.null"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(domain.Devices.Channels, expectedChannels) {
+		t.Errorf("bad channels:\n%#v\ninstead of\n%#v", domain.Devices.Channels, expectedChannels)
+	}
+
+	vols, err := GetHostDeviceVolumes(config, nil)
+	switch {
+	case err != nil:
+		t.Errorf("GetHostDeviceVolumes(): %v", err)
+	case len(vols) != 0:
+		t.Errorf("character devices must not be attached as disks, got %#v", vols)
+	}
+
+	config.ParsedAnnotations.HostDevices = []string{"/dev/no-such-device"}
+	if err := addHostDevicesToDomain(domain, config); err == nil {
+		t.Errorf("addHostDevicesToDomain() didn't fail for a nonexistent device")
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"os"
+	"path/filepath"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/utils"
+)
+
+// isBlockDevice returns true if the path points to a block device.
+func isBlockDevice(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeDevice != 0 && fi.Mode()&os.ModeCharDevice == 0
+}
+
+// hostBlockDeviceUUID returns the UUID of the volume that corresponds
+// to a block device mounted into the pod.
+func hostBlockDeviceUUID(config *types.VMConfig, hostPath string) string {
+	return utils.NewUUID5(ContainerNsUUID, config.PodSandboxID+":"+hostPath)
+}
+
+// hostBlockDeviceVolume denotes a block device on the host that's
+// mounted into the pod, e.g. using a hostPath volume, and is passed
+// to the VM as a disk
+type hostBlockDeviceVolume struct {
+	volumeBase
+	mount types.VMMount
+}
+
+var _ VMVolume = &hostBlockDeviceVolume{}
+
+func (v *hostBlockDeviceVolume) IsDisk() bool { return true }
+
+func (v *hostBlockDeviceVolume) UUID() string {
+	return hostBlockDeviceUUID(v.config, v.mount.HostPath)
+}
+
+// PodVolumeName returns the last component of the container path
+// as the mounts don't include the names of the pod volumes.
+func (v *hostBlockDeviceVolume) PodVolumeName() string {
+	return filepath.Base(v.mount.ContainerPath)
+}
+
+func (v *hostBlockDeviceVolume) Setup() (*libvirtxml.DomainDisk, *libvirtxml.DomainFilesystem, error) {
+	// we need to follow the symlinks as only devices under /dev
+	// will be chown'ed properly by QEMU
+	hostPath, err := filepath.EvalSymlinks(v.mount.HostPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := verifyRawDeviceAccess(hostPath); err != nil {
+		return nil, nil, err
+	}
+	disk := &libvirtxml.DomainDisk{
+		Device: "disk",
+		Source: &libvirtxml.DomainDiskSource{Block: &libvirtxml.DomainDiskSourceBlock{Dev: hostPath}},
+		Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "raw"},
+	}
+	if v.mount.Readonly {
+		disk.ReadOnly = &libvirtxml.DomainDiskReadOnly{}
+	}
+	return disk, nil, nil
+}
+
+// GetHostBlockDeviceVolumes returns VMVolume objects for the mounts
+// that point to block devices on the host, such as hostPath volumes
+// with the path of a block device. These devices are attached to
+// the VM as disks and are mounted by cloud-init at the mount path.
+func GetHostBlockDeviceVolumes(config *types.VMConfig, owner volumeOwner) ([]VMVolume, error) {
+	var vols []VMVolume
+	for _, mount := range config.Mounts {
+		if !isBlockDevice(mount.HostPath) {
+			continue
+		}
+		vols = append(vols, &hostBlockDeviceVolume{
+			volumeBase: volumeBase{config, owner},
+			mount:      mount,
+		})
+	}
+	return vols, nil
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+// loopDevice is a block device that's expected to be present
+// in the build container
+const loopDevice = "/dev/loop0"
+
+func TestHostBlockDeviceVolumes(t *testing.T) {
+	if !isBlockDevice(loopDevice) {
+		t.Skipf("%s is not available", loopDevice)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "hostpath-")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	filePath := filepath.Join(tmpDir, "file")
+	if err := ioutil.WriteFile(filePath, []byte("foo"), 0644); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+
+	config := &types.VMConfig{
+		PodSandboxID: "69eec606-0493-5825-73a4-c5e0c0236155",
+		Mounts: []types.VMMount{
+			{ContainerPath: "/data", HostPath: tmpDir},
+			{ContainerPath: "/etc/foo", HostPath: filePath},
+			{ContainerPath: "/mnt/disk", HostPath: loopDevice, Readonly: true},
+		},
+	}
+	vols, err := GetHostBlockDeviceVolumes(config, nil)
+	if err != nil {
+		t.Fatalf("GetHostBlockDeviceVolumes(): %v", err)
+	}
+	if len(vols) != 1 {
+		t.Fatalf("expected exactly one volume, got %d", len(vols))
+	}
+	vol := vols[0]
+	if !vol.IsDisk() {
+		t.Errorf("the host block device volume is not a disk")
+	}
+	if podVolumeName(vol) != "disk" {
+		t.Errorf("bad pod volume name %q", podVolumeName(vol))
+	}
+	uuid := hostBlockDeviceUUID(config, loopDevice)
+	if vol.UUID() != uuid {
+		t.Errorf("bad volume uuid %q instead of %q", vol.UUID(), uuid)
+	}
+
+	disk, fs, err := vol.Setup()
+	if err != nil {
+		t.Fatalf("Setup(): %v", err)
+	}
+	if fs != nil {
+		t.Errorf("unexpected filesystem definition")
+	}
+	expectedDisk := &libvirtxml.DomainDisk{
+		Device:   "disk",
+		Source:   &libvirtxml.DomainDiskSource{Block: &libvirtxml.DomainDiskSourceBlock{Dev: loopDevice}},
+		Driver:   &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "raw"},
+		ReadOnly: &libvirtxml.DomainDiskReadOnly{},
+	}
+	if !reflect.DeepEqual(disk, expectedDisk) {
+		t.Errorf("bad disk definition:\n%#v\ninstead of\n%#v", disk, expectedDisk)
+	}
+
+	fsVols, err := GetFileSystemVolumes(&types.VMConfig{
+		ParsedAnnotations: &types.VirtletAnnotations{},
+		Mounts:            config.Mounts,
+	}, &fakeVolumeOwner{})
+	if err != nil {
+		t.Fatalf("GetFileSystemVolumes(): %v", err)
+	}
+	if len(fsVols) != 1 || fsVols[0].(*filesystemVolume).mount.HostPath != tmpDir {
+		t.Errorf("only the directory is expected to be passed as a filesystem, got %#v", fsVols)
+	}
+
+	mountInfo, _, err := generateHostBlockDeviceMounts(diskPathMap{
+		uuid: {
+			devPath:   "/dev/disk/by-path/virtio-pci-0000:00:01.0-scsi-0:0:0:1",
+			sysfsPath: "/sys/devices/pci0000:00/0000:00:03.0/virtio*/host*/target*:0:0/*:0:0:1/block/",
+		},
+	}, uuid, config.Mounts[2])
+	if err != nil {
+		t.Fatalf("generateHostBlockDeviceMounts(): %v", err)
+	}
+	expectedMountInfo := []interface{}{"/dev/disk/by-path/virtio-pci-0000:00:01.0-scsi-0:0:0:1", "/mnt/disk"}
+	if !reflect.DeepEqual(mountInfo, expectedMountInfo) {
+		t.Errorf("bad mount info %#v instead of %#v", mountInfo, expectedMountInfo)
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"github.com/golang/glog"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+const snapshotNameTimeFormat = "20060102-150405"
+
+var snapshotNameRx = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// SnapshotContainer takes an internal snapshot of the VM domain with
+// the specified name and returns the name of the snapshot. If the
+// name is empty, it's generated from the current time. The snapshots
+// are kept in the qcow2 volumes of the VM, so they're only possible
+// for the VMs that don't use other kinds of writable disks, and they
+// go away when the VM is removed. If the domain doesn't exist,
+// virt.ErrDomainNotFound is returned.
+func (v *VirtualizationTool) SnapshotContainer(containerID, name string) (string, error) {
+	if name == "" {
+		name = "virtlet-" + v.clock.Now().UTC().Format(snapshotNameTimeFormat)
+	}
+	if !snapshotNameRx.MatchString(name) {
+		return "", fmt.Errorf("bad snapshot name %q", name)
+	}
+	domain, err := v.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		return "", err
+	}
+	if err := domain.CreateSnapshot(name); err != nil {
+		return "", fmt.Errorf("failed to take snapshot %q of domain %q: %v", name, containerID, err)
+	}
+	glog.V(1).Infof("Snapshot %q of domain %q taken", name, containerID)
+	return name, nil
+}
+
+// AttachHostBlockDevice attaches the host block device with the
+// specified path to the running VM as a virtio disk and returns the
+// target device name of the disk. The host device policy of the node
+// must allow the device for the namespace of the VM pod. The disk
+// stays attached across the VM reboots, but it's not attached again
+// if the VM is re-created. If the domain doesn't exist,
+// virt.ErrDomainNotFound is returned.
+func (v *VirtualizationTool) AttachHostBlockDevice(containerID, path string) (string, error) {
+	config, _, err := v.getVMConfigFromMetadata(containerID)
+	if err != nil {
+		return "", err
+	}
+	if config == nil {
+		return "", virt.ErrDomainNotFound
+	}
+	if err := v.checkHostDevicePaths([]string{path}, config.PodNamespace); err != nil {
+		return "", err
+	}
+	// only devices under /dev will be chown'ed properly by QEMU
+	devPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("can't resolve host device path %q: %v", path, err)
+	}
+	if !isBlockDevice(devPath) {
+		return "", fmt.Errorf("host device %q is not a block device", path)
+	}
+
+	domain, err := v.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		return "", err
+	}
+	state, err := domain.State()
+	if err != nil {
+		return "", fmt.Errorf("failed to get state of the domain %q: %v", containerID, err)
+	}
+	if state != virt.DomainStateRunning {
+		return "", fmt.Errorf("domain %q is not running", containerID)
+	}
+	def, err := domain.XML()
+	if err != nil {
+		return "", fmt.Errorf("couldn't get domain xml: %v", err)
+	}
+	dev, err := freeVirtioDevName(def)
+	if err != nil {
+		return "", err
+	}
+	if err := domain.AttachDisk(&libvirtxml.DomainDisk{
+		Device: "disk",
+		Source: &libvirtxml.DomainDiskSource{Block: &libvirtxml.DomainDiskSourceBlock{Dev: devPath}},
+		Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "raw"},
+		Target: &libvirtxml.DomainDiskTarget{Dev: dev, Bus: "virtio"},
+	}); err != nil {
+		return "", fmt.Errorf("failed to attach host device %q to domain %q: %v", path, containerID, err)
+	}
+	// the attached disk is a part of the persistent domain
+	// definition now, which must not be reported as a drift
+	if err := v.saveDomainDefinition(containerID, domain); err != nil {
+		glog.Warningf("Can't save the definition of domain %q after attaching host device %q: %v", containerID, path, err)
+	}
+	glog.V(1).Infof("Host device %q attached to domain %q as %s", path, containerID, dev)
+	return dev, nil
+}
+
+// freeVirtioDevName returns the first virtio disk device name that's
+// not used by the domain.
+func freeVirtioDevName(def *libvirtxml.Domain) (string, error) {
+	used := make(map[string]bool)
+	if def.Devices != nil {
+		for _, disk := range def.Devices.Disks {
+			if disk.Target != nil {
+				used[disk.Target.Dev] = true
+			}
+		}
+	}
+	for c := minBlockDevChar; c <= maxVirtioBlockDevChar; c++ {
+		dev := fmt.Sprintf("vd%c", c)
+		if !used[dev] {
+			return dev, nil
+		}
+	}
+	return "", errors.New("too many virtio block devices")
+}
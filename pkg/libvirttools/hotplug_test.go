@@ -0,0 +1,117 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+func TestSnapshotContainer(t *testing.T) {
+	rec := testutils.NewToplevelRecorder()
+	rec.AddFilter("CreateSnapshot")
+	ct := newContainerTester(t, rec, nil, nil)
+	defer ct.teardown()
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	ct.setPodSandbox(sandbox)
+	containerID := ct.createContainer(sandbox, nil, nil)
+	ct.startContainer(containerID)
+
+	for _, tc := range []struct {
+		name, expectedName, errSubstring string
+	}{
+		{name: "", expectedName: "virtlet-20170530-201900"},
+		{name: "before-upgrade", expectedName: "before-upgrade"},
+		{name: "../etc", errSubstring: "bad snapshot name"},
+	} {
+		name, err := ct.virtTool.SnapshotContainer(containerID, tc.name)
+		switch {
+		case tc.errSubstring != "" && err == nil:
+			t.Errorf("SnapshotContainer(%q) didn't fail", tc.name)
+		case tc.errSubstring != "" && !strings.Contains(err.Error(), tc.errSubstring):
+			t.Errorf("SnapshotContainer(%q): didn't get expected substring %q in the error: %v", tc.name, tc.errSubstring, err)
+		case tc.errSubstring == "" && err != nil:
+			t.Errorf("SnapshotContainer(%q): %v", tc.name, err)
+		case name != tc.expectedName:
+			t.Errorf("SnapshotContainer(%q): bad snapshot name %q instead of %q", tc.name, name, tc.expectedName)
+		}
+	}
+
+	var snapshots []interface{}
+	for _, r := range rec.Content() {
+		snapshots = append(snapshots, r.Value)
+	}
+	if expected := []interface{}{"virtlet-20170530-201900", "before-upgrade"}; !reflect.DeepEqual(snapshots, expected) {
+		t.Errorf("bad snapshots taken: %v instead of %v", snapshots, expected)
+	}
+
+	if _, err := ct.virtTool.SnapshotContainer("9ab1e5d2-5cf2-4c8b-8a8b-5e9c2a8a7b6f", ""); err != virt.ErrDomainNotFound {
+		t.Errorf("SnapshotContainer() for a nonexistent domain: unexpected error %v", err)
+	}
+}
+
+func TestAttachHostBlockDeviceWithoutPolicy(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	ct.setPodSandbox(sandbox)
+	containerID := ct.createContainer(sandbox, nil, nil)
+	ct.startContainer(containerID)
+
+	_, err := ct.virtTool.AttachHostBlockDevice(containerID, "/dev/null")
+	if err == nil || !strings.Contains(err.Error(), "not enabled") {
+		t.Errorf("AttachHostBlockDevice() without the host device policy: unexpected error %v", err)
+	}
+}
+
+func TestFreeVirtioDevName(t *testing.T) {
+	def := &libvirtxml.Domain{
+		Devices: &libvirtxml.DomainDeviceList{
+			Disks: []libvirtxml.DomainDisk{
+				{Target: &libvirtxml.DomainDiskTarget{Dev: "vda", Bus: "virtio"}},
+				{Target: &libvirtxml.DomainDiskTarget{Dev: "vdb", Bus: "virtio"}},
+				{Target: &libvirtxml.DomainDiskTarget{Dev: "sda", Bus: "scsi"}},
+			},
+		},
+	}
+	dev, err := freeVirtioDevName(def)
+	switch {
+	case err != nil:
+		t.Errorf("freeVirtioDevName(): %v", err)
+	case dev != "vdc":
+		t.Errorf("bad device name %q instead of vdc", dev)
+	}
+
+	def.Devices.Disks = nil
+	for c := minBlockDevChar; c <= maxVirtioBlockDevChar; c++ {
+		def.Devices.Disks = append(def.Devices.Disks, libvirtxml.DomainDisk{
+			Target: &libvirtxml.DomainDiskTarget{Dev: "vd" + string(c), Bus: "virtio"},
+		})
+	}
+	if _, err := freeVirtioDevName(def); err == nil {
+		t.Errorf("freeVirtioDevName() didn't fail when all the device names are used")
+	}
+}
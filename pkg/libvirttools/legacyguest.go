@@ -0,0 +1,71 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	vconfig "github.com/Mirantis/virtlet/pkg/config"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+// virtioFeatureProperties maps the virtio features that can be
+// disabled to the corresponding qemu virtio device properties.
+var virtioFeatureProperties = map[types.VirtioFeature]string{
+	types.VirtioFeaturePacked: "packed",
+	types.VirtioFeatureIOMMU:  "iommu_platform",
+}
+
+// applyLegacyGuestSettings updates the domain definition to make
+// it usable with the old guests that can't boot with the default
+// virtio devices. The virtio device settings are passed to qemu
+// as global properties so they also apply to the network interfaces
+// that are added by vmwrapper and aren't present in the domain
+// definition, and the NIC model is passed to vmwrapper itself.
+func applyLegacyGuestSettings(domain *libvirtxml.Domain, va *types.VirtletAnnotations) {
+	var globals []string
+	if va.LegacyVirtio {
+		// virtio-pci is the parent type of all the virtio PCI
+		// devices, so this makes all of them legacy-only
+		globals = append(globals, "virtio-pci.disable-legacy=off", "virtio-pci.disable-modern=on")
+	}
+	for _, feature := range va.DisabledVirtioFeatures {
+		if prop, found := virtioFeatureProperties[feature]; found {
+			globals = append(globals, "virtio-device."+prop+"=off")
+		}
+	}
+
+	if len(globals) > 0 && domain.QEMUCommandline == nil {
+		domain.QEMUCommandline = &libvirtxml.DomainQEMUCommandline{}
+	}
+	for _, global := range globals {
+		domain.QEMUCommandline.Args = append(domain.QEMUCommandline.Args,
+			libvirtxml.DomainQEMUCommandlineArg{Value: "-global"},
+			libvirtxml.DomainQEMUCommandlineArg{Value: global})
+	}
+
+	if va.NICModel != "" && va.NICModel != types.NICModelVirtio {
+		if domain.QEMUCommandline == nil {
+			domain.QEMUCommandline = &libvirtxml.DomainQEMUCommandline{}
+		}
+		domain.QEMUCommandline.Envs = append(domain.QEMUCommandline.Envs,
+			libvirtxml.DomainQEMUCommandlineEnv{
+				Name:  vconfig.NICModelEnvVarName,
+				Value: string(va.NICModel),
+			})
+	}
+}
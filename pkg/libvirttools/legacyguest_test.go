@@ -0,0 +1,93 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"reflect"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	vconfig "github.com/Mirantis/virtlet/pkg/config"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func TestLegacyGuestSettings(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		va           types.VirtletAnnotations
+		expectedArgs []libvirtxml.DomainQEMUCommandlineArg
+		expectedEnvs []libvirtxml.DomainQEMUCommandlineEnv
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name: "explicit virtio NIC model",
+			va:   types.VirtletAnnotations{NICModel: types.NICModelVirtio},
+		},
+		{
+			name: "legacy virtio",
+			va:   types.VirtletAnnotations{LegacyVirtio: true},
+			expectedArgs: []libvirtxml.DomainQEMUCommandlineArg{
+				{Value: "-global"},
+				{Value: "virtio-pci.disable-legacy=off"},
+				{Value: "-global"},
+				{Value: "virtio-pci.disable-modern=on"},
+			},
+		},
+		{
+			name: "disabled virtio features",
+			va: types.VirtletAnnotations{
+				DisabledVirtioFeatures: []types.VirtioFeature{types.VirtioFeatureIOMMU, types.VirtioFeaturePacked},
+			},
+			expectedArgs: []libvirtxml.DomainQEMUCommandlineArg{
+				{Value: "-global"},
+				{Value: "virtio-device.iommu_platform=off"},
+				{Value: "-global"},
+				{Value: "virtio-device.packed=off"},
+			},
+		},
+		{
+			name: "e1000 NIC model",
+			va:   types.VirtletAnnotations{NICModel: types.NICModelE1000},
+			expectedEnvs: []libvirtxml.DomainQEMUCommandlineEnv{
+				{Name: vconfig.NICModelEnvVarName, Value: "e1000"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			domain := &libvirtxml.Domain{}
+			applyLegacyGuestSettings(domain, &tc.va)
+			if tc.expectedArgs == nil && tc.expectedEnvs == nil {
+				if domain.QEMUCommandline != nil {
+					t.Errorf("unexpected qemu commandline: %#v", domain.QEMUCommandline)
+				}
+				return
+			}
+			if domain.QEMUCommandline == nil {
+				t.Fatalf("qemu commandline not set")
+			}
+			if !reflect.DeepEqual(domain.QEMUCommandline.Args, tc.expectedArgs) {
+				t.Errorf("bad qemu args: %#v instead of %#v", domain.QEMUCommandline.Args, tc.expectedArgs)
+			}
+			if !reflect.DeepEqual(domain.QEMUCommandline.Envs, tc.expectedEnvs) {
+				t.Errorf("bad qemu env: %#v instead of %#v", domain.QEMUCommandline.Envs, tc.expectedEnvs)
+			}
+		})
+	}
+}
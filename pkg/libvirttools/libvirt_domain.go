@@ -17,7 +17,9 @@ limitations under the License.
 package libvirttools
 
 import (
+	"encoding/xml"
 	"fmt"
+	"time"
 
 	"github.com/golang/glog"
 	libvirt "github.com/libvirt/libvirt-go"
@@ -26,6 +28,10 @@ import (
 	"github.com/Mirantis/virtlet/pkg/virt"
 )
 
+const (
+	screenshotBufferSize = 65536
+)
+
 type libvirtDomainConnection struct {
 	conn libvirtConnection
 }
@@ -142,6 +148,16 @@ func (dc *libvirtDomainConnection) LookupSecretByUsageName(usageType string, usa
 	return &libvirtSecret{secret.(*libvirt.Secret)}, nil
 }
 
+func (dc *libvirtDomainConnection) HypervisorVersion() (uint32, error) {
+	version, err := dc.conn.invoke(func(c *libvirt.Connect) (interface{}, error) {
+		return c.GetVersion()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return version.(uint32), nil
+}
+
 type libvirtDomain struct {
 	d *libvirt.Domain
 }
@@ -149,7 +165,7 @@ type libvirtDomain struct {
 var _ virt.Domain = &libvirtDomain{}
 
 func (domain *libvirtDomain) Create() error {
-	return domain.d.Create()
+	return retryTransient("starting domain", domain.d.Create)
 }
 
 func (domain *libvirtDomain) Destroy() error {
@@ -157,13 +173,38 @@ func (domain *libvirtDomain) Destroy() error {
 }
 
 func (domain *libvirtDomain) Undefine() error {
-	return domain.d.Undefine()
+	// the domains that have snapshots can't be undefined unless
+	// the snapshot metadata is removed, too. The snapshots
+	// themselves are stored in the qcow2 volumes of the VM
+	return retryTransient("undefining domain", func() error {
+		return domain.d.UndefineFlags(libvirt.DOMAIN_UNDEFINE_SNAPSHOTS_METADATA)
+	})
 }
 
 func (domain *libvirtDomain) Shutdown() error {
 	return domain.d.Shutdown()
 }
 
+func (domain *libvirtDomain) Reboot() error {
+	return domain.d.Reboot(libvirt.DOMAIN_REBOOT_DEFAULT)
+}
+
+func (domain *libvirtDomain) Reset() error {
+	if err := domain.d.Reset(0); err != nil {
+		return err
+	}
+	// the domain preserved after a guest crash stays paused
+	// after the reset
+	state, _, err := domain.d.GetState()
+	if err != nil {
+		return err
+	}
+	if state == libvirt.DOMAIN_PAUSED || state == libvirt.DOMAIN_CRASHED {
+		return domain.d.Resume()
+	}
+	return nil
+}
+
 func (domain *libvirtDomain) State() (virt.DomainState, error) {
 	di, err := domain.d.GetInfo()
 	if err != nil {
@@ -225,6 +266,33 @@ func (domain *libvirtDomain) GetRSS() (uint64, error) {
 	return 0, fmt.Errorf("rss not found in memory stats")
 }
 
+// GetMemoryStats returns memory statistics for the VM
+func (domain *libvirtDomain) GetMemoryStats() (*virt.MemoryStats, error) {
+	stats, err := domain.d.MemoryStats(uint32(libvirt.DOMAIN_MEMORY_STAT_LAST), 0)
+	if err != nil {
+		return nil, err
+	}
+	var r virt.MemoryStats
+	for _, stat := range stats {
+		// all of the values except for the timestamp are in KiB
+		switch libvirt.DomainMemoryStatTags(stat.Tag) {
+		case libvirt.DOMAIN_MEMORY_STAT_RSS:
+			r.RSS = stat.Val * 1024
+		case libvirt.DOMAIN_MEMORY_STAT_ACTUAL_BALLOON:
+			r.Actual = stat.Val * 1024
+		case libvirt.DOMAIN_MEMORY_STAT_AVAILABLE:
+			r.Available = stat.Val * 1024
+		case libvirt.DOMAIN_MEMORY_STAT_UNUSED:
+			r.Unused = stat.Val * 1024
+		case libvirt.DOMAIN_MEMORY_STAT_USABLE:
+			r.Usable = stat.Val * 1024
+		case libvirt.DOMAIN_MEMORY_STAT_LAST_UPDATE:
+			r.LastUpdate = int64(stat.Val)
+		}
+	}
+	return &r, nil
+}
+
 // GetCPUTime returns cpu time used by VM in nanoseconds per core
 func (domain *libvirtDomain) GetCPUTime() (uint64, error) {
 	// all vcpus as a single value
@@ -241,6 +309,98 @@ func (domain *libvirtDomain) GetCPUTime() (uint64, error) {
 	return stats[0].CpuTime, nil
 }
 
+// GetBlockStats returns IO statistics for the disk
+// with the specified target device name
+func (domain *libvirtDomain) GetBlockStats(dev string) (*virt.BlockStats, error) {
+	stats, err := domain.d.BlockStatsFlags(dev, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &virt.BlockStats{
+		ReadRequests:  stats.RdReq,
+		ReadBytes:     stats.RdBytes,
+		ReadTimeNs:    stats.RdTotalTimes,
+		WriteRequests: stats.WrReq,
+		WriteBytes:    stats.WrBytes,
+		WriteTimeNs:   stats.WrTotalTimes,
+		FlushRequests: stats.FlushReq,
+		FlushTimeNs:   stats.FlushTotalTimes,
+	}, nil
+}
+
+// QemuAgentCommand sends a command to the QEMU guest agent
+func (domain *libvirtDomain) QemuAgentCommand(command string, timeout time.Duration) (string, error) {
+	return domain.d.QemuAgentCommand(command, libvirt.DomainQemuAgentCommandTimeout(timeout/time.Second), 0)
+}
+
+// Screenshot takes a screenshot of the VM console
+func (domain *libvirtDomain) Screenshot() ([]byte, string, error) {
+	conn, err := domain.d.DomainGetConnect()
+	if err != nil {
+		return nil, "", err
+	}
+	stream, err := conn.NewStream(0)
+	if err != nil {
+		return nil, "", err
+	}
+	defer stream.Free()
+
+	mimeType, err := domain.d.Screenshot(stream, 0, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var data []byte
+	buf := make([]byte, screenshotBufferSize)
+	for {
+		n, err := stream.Recv(buf)
+		if err != nil {
+			stream.Abort()
+			return nil, "", fmt.Errorf("error receiving the screenshot: %v", err)
+		}
+		if n == 0 {
+			break
+		}
+		data = append(data, buf[:n]...)
+	}
+	if err := stream.Finish(); err != nil {
+		return nil, "", err
+	}
+	return data, mimeType, nil
+}
+
+func (domain *libvirtDomain) SetVCPUs(count int) error {
+	return domain.d.SetVcpusFlags(uint(count), libvirt.DOMAIN_VCPU_LIVE)
+}
+
+func (domain *libvirtDomain) GetVCPUs() (int, error) {
+	count, err := domain.d.GetVcpusFlags(libvirt.DOMAIN_VCPU_LIVE)
+	if err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+func (domain *libvirtDomain) CreateSnapshot(name string) error {
+	snapshotXML, err := (&libvirtxml.DomainSnapshot{Name: name}).Marshal()
+	if err != nil {
+		return err
+	}
+	snapshot, err := domain.d.CreateSnapshotXML(snapshotXML, 0)
+	if err != nil {
+		return err
+	}
+	return snapshot.Free()
+}
+
+func (domain *libvirtDomain) AttachDisk(disk *libvirtxml.DomainDisk) error {
+	diskXML, err := xml.Marshal(disk)
+	if err != nil {
+		return err
+	}
+	return domain.d.AttachDeviceFlags(string(diskXML), libvirt.DOMAIN_DEVICE_MODIFY_LIVE|libvirt.DOMAIN_DEVICE_MODIFY_CONFIG)
+}
+
 type libvirtSecret struct {
 	s *libvirt.Secret
 }
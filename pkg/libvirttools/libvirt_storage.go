@@ -90,6 +90,10 @@ func (sc *libvirtStorageConnection) PutFiles(imagePath string, files map[string]
 	return diskimage.Put(imagePath, files)
 }
 
+func (sc *libvirtStorageConnection) GrowRootFS(imagePath string) error {
+	return diskimage.GrowRootFS(imagePath)
+}
+
 type libvirtStoragePool struct {
 	*sync.Mutex
 	conn libvirtConnection
@@ -106,15 +110,21 @@ func (pool *libvirtStoragePool) CreateStorageVol(def *libvirtxml.StorageVolume)
 		return nil, err
 	}
 	glog.V(2).Infof("Creating storage volume:\n%s", xml)
-	v, err := pool.p.StorageVolCreateXML(xml, 0)
-	if err != nil {
+	var v *libvirt.StorageVol
+	if err := retryTransient("creating storage volume "+def.Name, func() error {
+		var err error
+		v, err = pool.p.StorageVolCreateXML(xml, 0)
+		return err
+	}); err != nil {
 		return nil, err
 	}
 	// libvirt may report qcow2 file size as 'capacity' for
 	// qcow2-based volumes for some time after creating them.
 	// Here we work around this problem by refreshing the pool
 	// which invokes acquiring volume info.
-	if err := pool.p.Refresh(0); err != nil {
+	if err := retryTransient("refreshing the storage pool", func() error {
+		return pool.p.Refresh(0)
+	}); err != nil {
 		v.Delete(0)
 		return nil, fmt.Errorf("failed to refresh the storage pool: %v", err)
 	}
@@ -210,7 +220,9 @@ func (volume *libvirtStorageVolume) Path() (string, error) {
 func (volume *libvirtStorageVolume) Remove() error {
 	volume.Lock()
 	defer volume.Unlock()
-	return volume.v.Delete(0)
+	return retryTransient("removing storage volume "+volume.name, func() error {
+		return volume.v.Delete(0)
+	})
 }
 
 func (volume *libvirtStorageVolume) Format() error {
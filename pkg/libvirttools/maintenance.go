@@ -0,0 +1,161 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const (
+	// maintenanceRebootSettleTime is the time during which a VM
+	// rebooted for maintenance counts against the limits on the
+	// number of concurrent maintenance reboots
+	maintenanceRebootSettleTime = 5 * time.Minute
+	rebootRequiredCheckTimeout  = 30 * time.Second
+	rebootRequiredCommand       = "if [ -e /var/run/reboot-required ]; then echo yes; fi"
+)
+
+// maintenanceRecord describes the last maintenance reboot attempt
+// for a VM.
+type maintenanceRecord struct {
+	time     time.Time
+	rebooted bool
+}
+
+// RebootForMaintenance reboots the running VMs that are within their
+// maintenance windows and weren't started or rebooted during the
+// current occurrence of the window yet. At most maxPerNode VMs on the
+// node and MaxConcurrentMaintenanceReboots VMs from each namespace
+// are rebooted within maintenanceRebootSettleTime. Each reboot is
+// recorded as an event.
+func (v *VirtualizationTool) RebootForMaintenance(maxPerNode int) error {
+	containers, err := v.ListContainers(nil)
+	if err != nil {
+		return fmt.Errorf("error listing containers: %v", err)
+	}
+	now := v.clock.Now()
+
+	v.maintenanceLock.Lock()
+	defer v.maintenanceLock.Unlock()
+
+	present := make(map[string]bool)
+	for _, c := range containers {
+		present[c.Id] = true
+	}
+	for id := range v.maintenanceRecords {
+		if !present[id] {
+			delete(v.maintenanceRecords, id)
+		}
+	}
+
+	nodeReboots := 0
+	nsReboots := make(map[string]int)
+	nsLimits := make(map[string]int)
+	var candidates []*types.ContainerInfo
+	for _, c := range containers {
+		va := c.Config.ParsedAnnotations
+		if va == nil {
+			continue
+		}
+		ns := c.Config.PodNamespace
+		// the VMs may have been started with different
+		// versions of the namespace policy, the strictest
+		// limit wins
+		if limit := va.MaxConcurrentMaintenanceReboots; limit > 0 && (nsLimits[ns] == 0 || limit < nsLimits[ns]) {
+			nsLimits[ns] = limit
+		}
+		record, found := v.maintenanceRecords[c.Id]
+		if found && record.rebooted && now.Sub(record.time) < maintenanceRebootSettleTime {
+			nodeReboots++
+			nsReboots[ns]++
+		}
+		if va.MaintenanceWindow == nil || c.State != types.ContainerState_CONTAINER_RUNNING {
+			continue
+		}
+		windowStart, inWindow := va.MaintenanceWindow.OccurrenceStart(now)
+		if !inWindow || c.StartedAt >= windowStart.UnixNano() || (found && !record.time.Before(windowStart)) {
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+
+	// the VMs that have been running for the longest time
+	// are rebooted first
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].StartedAt != candidates[j].StartedAt {
+			return candidates[i].StartedAt < candidates[j].StartedAt
+		}
+		return candidates[i].Id < candidates[j].Id
+	})
+	for _, c := range candidates {
+		if nodeReboots >= maxPerNode {
+			break
+		}
+		ns := c.Config.PodNamespace
+		if limit := nsLimits[ns]; limit > 0 && nsReboots[ns] >= limit {
+			continue
+		}
+		rebooted, err := v.maintenanceReboot(c)
+		if err != nil || rebooted {
+			// the failed attempts aren't retried until the
+			// next occurrence of the window, while the guests
+			// that don't require a reboot yet are checked
+			// again later
+			v.maintenanceRecords[c.Id] = maintenanceRecord{time: now, rebooted: rebooted}
+		}
+		switch {
+		case err != nil:
+			glog.Warningf("Maintenance reboot of VM %q failed: %v", c.Id, err)
+			v.eventRecorder.Eventf(&c.Config, v1.EventTypeWarning, "MaintenanceRebootFailed", "Maintenance reboot failed: %v", err)
+		case rebooted:
+			glog.V(1).Infof("VM %q rebooted during its maintenance window", c.Id)
+			v.eventRecorder.Eventf(&c.Config, v1.EventTypeNormal, "MaintenanceReboot", "VM rebooted during its maintenance window")
+			nodeReboots++
+			nsReboots[ns]++
+		}
+	}
+	return nil
+}
+
+// maintenanceReboot reboots the VM if its maintenance reboot
+// condition is satisfied, returning true if the VM was rebooted.
+func (v *VirtualizationTool) maintenanceReboot(c *types.ContainerInfo) (bool, error) {
+	if c.Config.ParsedAnnotations.MaintenanceRebootCondition == types.MaintenanceRebootRequired {
+		domain, err := v.domainConn.LookupDomainByUUIDString(c.Id)
+		if err != nil {
+			return false, err
+		}
+		out, err := v.execInGuest(domain, rebootRequiredCommand, rebootRequiredCheckTimeout)
+		if err != nil {
+			return false, fmt.Errorf("error checking whether the guest requires a reboot: %v", err)
+		}
+		if out != "yes" {
+			return false, nil
+		}
+	}
+	if err := v.RebootContainer(c.Id); err != nil {
+		return false, err
+	}
+	return true, nil
+}
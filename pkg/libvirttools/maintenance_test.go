@@ -0,0 +1,155 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	virtlet_v1 "github.com/Mirantis/virtlet/pkg/api/virtlet.k8s/v1"
+	"github.com/Mirantis/virtlet/pkg/client/clientset/versioned/fake"
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+)
+
+const (
+	// the container tester clock starts on Tuesday, 20:19 UTC
+	testMaintenanceWindow = "Tue 20:30-22:00"
+	maintenanceRebootMsg  = " Normal MaintenanceReboot: VM rebooted during its maintenance window"
+)
+
+func (ct *containerTester) rebootForMaintenance(maxPerNode int) {
+	if err := ct.virtTool.RebootForMaintenance(maxPerNode); err != nil {
+		ct.t.Fatalf("RebootForMaintenance(): %v", err)
+	}
+}
+
+func verifyEvents(t *testing.T, recorder *fakeEventRecorder, expectedEvents []string) {
+	if !reflect.DeepEqual(recorder.events, expectedEvents) {
+		t.Errorf("bad events:\n%#v\ninstead of\n%#v", recorder.events, expectedEvents)
+	}
+	recorder.events = nil
+}
+
+func TestMaintenanceReboots(t *testing.T) {
+	loader := &defaultExternalDataLoader{
+		virtletClient: fake.NewSimpleClientset(
+			&virtlet_v1.VirtletVMPolicy{
+				ObjectMeta: meta_v1.ObjectMeta{
+					Name:      "maintenance",
+					Namespace: "default",
+				},
+				Spec: virtlet_v1.VirtletVMPolicySpec{
+					MaintenanceWindow:               testMaintenanceWindow,
+					MaxConcurrentMaintenanceReboots: intPtr(1),
+				},
+			},
+		),
+	}
+	withExternalDataLoader(loader, func() {
+		ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+		defer ct.teardown()
+		recorder := &fakeEventRecorder{}
+		ct.virtTool.SetEventRecorder(recorder)
+
+		sandboxes := fakemeta.GetSandboxes(4)
+		// the window for this VM comes from the annotation
+		// and there's no per-namespace limit for it
+		sandboxes[2].Namespace = "other"
+		sandboxes[2].Annotations["VirtletMaintenanceWindow"] = testMaintenanceWindow
+		// this VM is outside of the policy namespace and
+		// has no maintenance window
+		sandboxes[3].Namespace = "other"
+		for _, sandbox := range sandboxes {
+			ct.setPodSandbox(sandbox)
+			containerID := ct.createContainer(sandbox, nil, nil)
+			// make the VMs start at different times so
+			// the order of the reboots is predictable
+			ct.clock.Advance(time.Second)
+			ct.startContainer(containerID)
+		}
+
+		// outside of the window
+		ct.rebootForMaintenance(2)
+		verifyEvents(t, recorder, nil)
+
+		// the per-namespace limit prevents the second VM
+		// from the default namespace from being rebooted
+		ct.clock.Advance(12 * time.Minute)
+		ct.rebootForMaintenance(2)
+		verifyEvents(t, recorder, []string{
+			"default/" + sandboxes[0].Name + maintenanceRebootMsg,
+			"other/" + sandboxes[2].Name + maintenanceRebootMsg,
+		})
+
+		// the reboots are still in progress
+		ct.clock.Advance(time.Minute)
+		ct.rebootForMaintenance(2)
+		verifyEvents(t, recorder, nil)
+
+		ct.clock.Advance(maintenanceRebootSettleTime)
+		ct.rebootForMaintenance(2)
+		verifyEvents(t, recorder, []string{
+			"default/" + sandboxes[1].Name + maintenanceRebootMsg,
+		})
+
+		// each VM is rebooted just once during the window
+		ct.clock.Advance(maintenanceRebootSettleTime)
+		ct.rebootForMaintenance(2)
+		verifyEvents(t, recorder, nil)
+
+		// next week's window
+		ct.clock.Advance(7 * 24 * time.Hour)
+		ct.rebootForMaintenance(3)
+		verifyEvents(t, recorder, []string{
+			"default/" + sandboxes[0].Name + maintenanceRebootMsg,
+			"other/" + sandboxes[2].Name + maintenanceRebootMsg,
+		})
+	})
+}
+
+func TestMaintenanceRebootWhenRequired(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+	recorder := &fakeEventRecorder{}
+	ct.virtTool.SetEventRecorder(recorder)
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	sandbox.Annotations["VirtletGuestAgent"] = "true"
+	sandbox.Annotations["VirtletMaintenanceWindow"] = testMaintenanceWindow
+	sandbox.Annotations["VirtletMaintenanceRebootCondition"] = "reboot-required"
+	ct.setPodSandbox(sandbox)
+	containerID := ct.createContainer(sandbox, nil, nil)
+	ct.clock.Advance(time.Second)
+	ct.startContainer(containerID)
+
+	// the guest doesn't need to be rebooted yet
+	ct.clock.Advance(15 * time.Minute)
+	ct.rebootForMaintenance(1)
+	verifyEvents(t, recorder, nil)
+
+	// "yes" in base64
+	ct.domainConn.SetGuestAgentResponse("guest-exec-status", `{"return":{"exited":true,"exitcode":0,"out-data":"eWVz"}}`)
+	ct.clock.Advance(time.Minute)
+	ct.rebootForMaintenance(1)
+	verifyEvents(t, recorder, []string{
+		"default/" + sandbox.Name + maintenanceRebootMsg,
+	})
+}
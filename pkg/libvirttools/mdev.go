@@ -0,0 +1,169 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/utils"
+)
+
+var (
+	sysfsDir = "/sys"
+	// mdevLock serializes the creation of the mediated devices so
+	// that the available instances aren't overcommitted
+	mdevLock sync.Mutex
+)
+
+// SetSysfsDir sets the directory where sysfs is mounted.
+// It can be useful in tests.
+func SetSysfsDir(dir string) {
+	sysfsDir = dir
+}
+
+// mdevDevicePath returns the sysfs path of the mediated device
+// with the specified UUID.
+func mdevDevicePath(uuid string) string {
+	return filepath.Join(sysfsDir, strings.TrimPrefix(types.MdevDevicePathPrefix, "/sys/"), uuid)
+}
+
+// mdevUUID returns the UUID of the n-th mediated device of the pod.
+// The UUIDs are stable so the devices left behind by a previous
+// attempt to create the VM can be reused.
+func mdevUUID(podSandboxID string, n int) string {
+	return utils.NewUUID5(ContainerNsUUID, fmt.Sprintf("%s:mdev:%d", podSandboxID, n))
+}
+
+// newMdevHostdev returns the definition of a mediated device
+// with the specified UUID that's passed to the VM.
+func newMdevHostdev(uuid string) libvirtxml.DomainHostdev {
+	return libvirtxml.DomainHostdev{
+		SubsysMDev: &libvirtxml.DomainHostdevSubsysMDev{
+			Model: mdevModel,
+			Source: &libvirtxml.DomainHostdevSubsysMDevSource{
+				Address: &libvirtxml.DomainAddressMDev{UUID: uuid},
+			},
+		},
+	}
+}
+
+func readAvailableMdevInstances(typeDir string) (int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(typeDir, "available_instances"))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// createMdev creates a mediated device of the specified type
+// (profile) with the specified UUID. The device is created using the
+// first parent device that has available instances of this type. If
+// the device already exists and has the requested type, it's reused.
+func createMdev(profile, uuid string) error {
+	mdevLock.Lock()
+	defer mdevLock.Unlock()
+
+	devPath := mdevDevicePath(uuid)
+	if typePath, err := filepath.EvalSymlinks(filepath.Join(devPath, "mdev_type")); err == nil {
+		if filepath.Base(typePath) != profile {
+			return fmt.Errorf("mediated device %s already exists and has type %q instead of %q", uuid, filepath.Base(typePath), profile)
+		}
+		return nil
+	}
+
+	typeDirs, err := filepath.Glob(filepath.Join(sysfsDir, "class/mdev_bus/*/mdev_supported_types", profile))
+	if err != nil {
+		return err
+	}
+	if len(typeDirs) == 0 {
+		return fmt.Errorf("no devices on the node support mediated device profile %q", profile)
+	}
+	sort.Strings(typeDirs)
+	for _, typeDir := range typeDirs {
+		available, err := readAvailableMdevInstances(typeDir)
+		if err != nil {
+			glog.Warningf("Can't get the number of available instances of mediated device profile %q from %q: %v", profile, typeDir, err)
+			continue
+		}
+		if available <= 0 {
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(typeDir, "create"), []byte(uuid), 0200); err != nil {
+			return fmt.Errorf("error creating mediated device %s with profile %q: %v", uuid, profile, err)
+		}
+		glog.V(1).Infof("Created mediated device %s with profile %q using %q", uuid, profile, typeDir)
+		return nil
+	}
+	return fmt.Errorf("no available instances of mediated device profile %q on the node", profile)
+}
+
+// removeMdev removes the mediated device with the specified UUID
+// if it exists.
+func removeMdev(uuid string) error {
+	removePath := filepath.Join(mdevDevicePath(uuid), "remove")
+	if _, err := os.Stat(removePath); os.IsNotExist(err) {
+		return nil
+	}
+	if err := ioutil.WriteFile(removePath, []byte("1"), 0200); err != nil {
+		return fmt.Errorf("error removing mediated device %s: %v", uuid, err)
+	}
+	glog.V(1).Infof("Removed mediated device %s", uuid)
+	return nil
+}
+
+// addMdevsToDomain creates the mediated devices requested using
+// VirtletMdevProfiles annotation and adds them to the domain. The
+// UUIDs of the devices are recorded in the VM config so that they
+// can be removed together with the VM.
+func addMdevsToDomain(domain *libvirtxml.Domain, config *types.VMConfig) error {
+	config.MdevUUIDs = nil
+	for n, profile := range config.ParsedAnnotations.MdevProfiles {
+		uuid := mdevUUID(config.PodSandboxID, n)
+		if err := createMdev(profile, uuid); err != nil {
+			return err
+		}
+		config.MdevUUIDs = append(config.MdevUUIDs, uuid)
+		domain.Devices.Hostdevs = append(domain.Devices.Hostdevs, newMdevHostdev(uuid))
+	}
+	return nil
+}
+
+// removeMdevs removes the mediated devices that were created for
+// the VM.
+func removeMdevs(config *types.VMConfig) error {
+	var errs []string
+	for _, uuid := range config.MdevUUIDs {
+		if err := removeMdev(uuid); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if errs != nil {
+		return fmt.Errorf("errors removing mediated devices: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
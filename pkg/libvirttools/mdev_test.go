@@ -0,0 +1,229 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const (
+	testMdevProfile    = "nvidia-63"
+	testMdevPodSandbox = "69eec606-0493-5825-73a4-c5e0c0236155"
+	testMdevDevicesDir = "bus/mdev/devices"
+)
+
+type fakeMdevSysfs struct {
+	t   *testing.T
+	dir string
+}
+
+func newFakeMdevSysfs(t *testing.T) *fakeMdevSysfs {
+	dir, err := ioutil.TempDir("", "sysfs-")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	return &fakeMdevSysfs{t: t, dir: dir}
+}
+
+func (s *fakeMdevSysfs) writeFile(path, content string) {
+	fullPath := filepath.Join(s.dir, path)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		s.t.Fatalf("MkdirAll(): %v", err)
+	}
+	if err := ioutil.WriteFile(fullPath, []byte(content), 0644); err != nil {
+		s.t.Fatalf("WriteFile(): %v", err)
+	}
+}
+
+func (s *fakeMdevSysfs) readFile(path string) string {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ""
+		}
+		s.t.Fatalf("ReadFile(): %v", err)
+	}
+	return string(data)
+}
+
+func (s *fakeMdevSysfs) addParent(pciAddr, profile string, available int) {
+	typeDir := filepath.Join("class/mdev_bus", pciAddr, "mdev_supported_types", profile)
+	s.writeFile(filepath.Join(typeDir, "available_instances"), strconv.Itoa(available)+"\n")
+}
+
+func (s *fakeMdevSysfs) addDevice(uuid, profile string) {
+	devDir := filepath.Join(s.dir, testMdevDevicesDir, uuid)
+	typeDir := filepath.Join(s.dir, "types", profile)
+	if err := os.MkdirAll(devDir, 0755); err != nil {
+		s.t.Fatalf("MkdirAll(): %v", err)
+	}
+	if err := os.MkdirAll(typeDir, 0755); err != nil {
+		s.t.Fatalf("MkdirAll(): %v", err)
+	}
+	if err := os.Symlink(typeDir, filepath.Join(devDir, "mdev_type")); err != nil {
+		s.t.Fatalf("Symlink(): %v", err)
+	}
+	s.writeFile(filepath.Join(testMdevDevicesDir, uuid, "remove"), "")
+}
+
+func (s *fakeMdevSysfs) cleanup() {
+	SetSysfsDir("/sys")
+	os.RemoveAll(s.dir)
+}
+
+func TestMdevs(t *testing.T) {
+	uuid0 := mdevUUID(testMdevPodSandbox, 0)
+	uuid1 := mdevUUID(testMdevPodSandbox, 1)
+	for _, tc := range []struct {
+		name          string
+		profiles      []string
+		setup         func(s *fakeMdevSysfs)
+		expectedErr   string
+		expectedUUIDs []string
+		verify        func(s *fakeMdevSysfs)
+	}{
+		{
+			name: "no mediated devices",
+		},
+		{
+			name:     "create devices",
+			profiles: []string{testMdevProfile, testMdevProfile},
+			setup: func(s *fakeMdevSysfs) {
+				s.addParent("0000:01:00.0", testMdevProfile, 0)
+				s.addParent("0000:02:00.0", testMdevProfile, 2)
+				s.addParent("0000:03:00.0", "nvidia-64", 4)
+			},
+			expectedUUIDs: []string{uuid0, uuid1},
+			verify: func(s *fakeMdevSysfs) {
+				if created := s.readFile("class/mdev_bus/0000:01:00.0/mdev_supported_types/nvidia-63/create"); created != "" {
+					t.Errorf("unexpected device created using a parent without available instances: %q", created)
+				}
+				// the fake sysfs doesn't update available_instances
+				// so the last UUID written is seen here
+				if created := s.readFile("class/mdev_bus/0000:02:00.0/mdev_supported_types/nvidia-63/create"); created != uuid1 {
+					t.Errorf("bad create file content: %q instead of %q", created, uuid1)
+				}
+				if created := s.readFile("class/mdev_bus/0000:03:00.0/mdev_supported_types/nvidia-64/create"); created != "" {
+					t.Errorf("unexpected device created using a parent with another profile: %q", created)
+				}
+			},
+		},
+		{
+			name:     "reuse existing device",
+			profiles: []string{testMdevProfile},
+			setup: func(s *fakeMdevSysfs) {
+				s.addDevice(uuid0, testMdevProfile)
+			},
+			expectedUUIDs: []string{uuid0},
+		},
+		{
+			name:     "existing device with another profile",
+			profiles: []string{testMdevProfile},
+			setup: func(s *fakeMdevSysfs) {
+				s.addDevice(uuid0, "nvidia-64")
+				s.addParent("0000:02:00.0", testMdevProfile, 2)
+			},
+			expectedErr: "already exists",
+		},
+		{
+			name:        "unknown profile",
+			profiles:    []string{testMdevProfile},
+			expectedErr: "no devices on the node support",
+		},
+		{
+			name:     "no available instances",
+			profiles: []string{testMdevProfile},
+			setup: func(s *fakeMdevSysfs) {
+				s.addParent("0000:01:00.0", testMdevProfile, 0)
+			},
+			expectedErr: "no available instances",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newFakeMdevSysfs(t)
+			defer s.cleanup()
+			SetSysfsDir(s.dir)
+			if tc.setup != nil {
+				tc.setup(s)
+			}
+
+			domain := &libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{}}
+			config := &types.VMConfig{
+				PodSandboxID:      testMdevPodSandbox,
+				ParsedAnnotations: &types.VirtletAnnotations{MdevProfiles: tc.profiles},
+			}
+			err := addMdevsToDomain(domain, config)
+			if tc.expectedErr != "" {
+				switch {
+				case err == nil:
+					t.Fatalf("didn't get the expected error")
+				case !strings.Contains(err.Error(), tc.expectedErr):
+					t.Fatalf("bad error message %q (doesn't contain %q)", err, tc.expectedErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("addMdevsToDomain(): %v", err)
+			}
+
+			if !reflect.DeepEqual(config.MdevUUIDs, tc.expectedUUIDs) {
+				t.Errorf("bad mdev UUIDs: %#v instead of %#v", config.MdevUUIDs, tc.expectedUUIDs)
+			}
+			var expectedHostdevs []libvirtxml.DomainHostdev
+			for _, uuid := range tc.expectedUUIDs {
+				expectedHostdevs = append(expectedHostdevs, newMdevHostdev(uuid))
+			}
+			if !reflect.DeepEqual(domain.Devices.Hostdevs, expectedHostdevs) {
+				t.Errorf("bad hostdevs: %#v instead of %#v", domain.Devices.Hostdevs, expectedHostdevs)
+			}
+			if tc.verify != nil {
+				tc.verify(s)
+			}
+
+			if err := removeMdevs(config); err != nil {
+				t.Errorf("removeMdevs(): %v", err)
+			}
+		})
+	}
+}
+
+func TestRemoveMdevs(t *testing.T) {
+	s := newFakeMdevSysfs(t)
+	defer s.cleanup()
+	SetSysfsDir(s.dir)
+
+	uuid0 := mdevUUID(testMdevPodSandbox, 0)
+	uuid1 := mdevUUID(testMdevPodSandbox, 1)
+	s.addDevice(uuid0, testMdevProfile)
+	// uuid1 is already gone and must be skipped
+	if err := removeMdevs(&types.VMConfig{MdevUUIDs: []string{uuid0, uuid1}}); err != nil {
+		t.Fatalf("removeMdevs(): %v", err)
+	}
+	if removed := s.readFile(filepath.Join(testMdevDevicesDir, uuid0, "remove")); removed != "1" {
+		t.Errorf("bad remove file content for %s: %q", uuid0, removed)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"errors"
+	"fmt"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+// domainMemoryBacking returns the memoryBacking element of the domain
+// for the source selected by the pod annotations, falling back to the
+// node default, or nil if the default anonymous memory is to be used.
+// hugetlbfsMounts maps the huge page sizes available on the node to
+// the corresponding hugetlbfs mount points. memorySize is the size of
+// the VM memory in bytes.
+func domainMemoryBacking(va *types.VirtletAnnotations, defaultSource string, hugetlbfsMounts map[uint64]string, memorySize uint64) (*libvirtxml.DomainMemoryBacking, error) {
+	source := va.MemoryBacking
+	if source == "" {
+		source = types.MemoryBackingSource(defaultSource)
+	}
+	if va.SharedMemory && (source == "" || source == types.MemoryBackingDefault) {
+		// anonymous memory can't be shared with the
+		// vhost-user backends, so memfd is used instead
+		source = types.MemoryBackingMemfd
+	}
+
+	var mb libvirtxml.DomainMemoryBacking
+	switch source {
+	case "", types.MemoryBackingDefault:
+		return nil, nil
+	case types.MemoryBackingMemfd, types.MemoryBackingFile:
+		mb.MemorySource = &libvirtxml.DomainMemorySource{Type: string(source)}
+	case types.MemoryBackingHugepages:
+		pageSize, err := hugePageSize(uint64(va.HugePageSize), hugetlbfsMounts)
+		if err != nil {
+			return nil, err
+		}
+		if memorySize%pageSize != 0 {
+			return nil, fmt.Errorf("VM memory size %d is not a multiple of the huge page size %d", memorySize, pageSize)
+		}
+		mb.MemoryHugePages = &libvirtxml.DomainMemoryHugepages{
+			Hugepages: []libvirtxml.DomainMemoryHugepage{
+				{Size: uint(pageSize / 1024), Unit: "KiB"},
+			},
+		}
+	default:
+		return nil, fmt.Errorf("unknown memory backing %q", source)
+	}
+
+	if va.SharedMemory {
+		mb.MemoryAccess = &libvirtxml.DomainMemoryAccess{Mode: "shared"}
+	}
+	return &mb, nil
+}
+
+// hugePageSize verifies that there's a hugetlbfs mount for the
+// requested huge page size on the node. If the size is not
+// specified, the smallest available one is returned.
+func hugePageSize(requested uint64, hugetlbfsMounts map[uint64]string) (uint64, error) {
+	if requested != 0 {
+		if _, found := hugetlbfsMounts[requested]; !found {
+			return 0, fmt.Errorf("no hugetlbfs mount for %d byte huge pages on the node", requested)
+		}
+		return requested, nil
+	}
+	var r uint64
+	for size := range hugetlbfsMounts {
+		if r == 0 || size < r {
+			r = size
+		}
+	}
+	if r == 0 {
+		return 0, errors.New("no hugetlbfs mounts available on the node")
+	}
+	return r, nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const (
+	testMemorySize = 1024 * 1024 * 1024
+	hugePageSize2M = 2 * 1024 * 1024
+	hugePageSize1G = 1024 * 1024 * 1024
+)
+
+func TestDomainMemoryBacking(t *testing.T) {
+	allMounts := map[uint64]string{
+		hugePageSize2M: "/dev/hugepages",
+		hugePageSize1G: "/dev/hugepages-1G",
+	}
+	for _, tc := range []struct {
+		name            string
+		va              types.VirtletAnnotations
+		defaultSource   string
+		hugetlbfsMounts map[uint64]string
+		memorySize      uint64
+		expected        *libvirtxml.DomainMemoryBacking
+		errSubstring    string
+	}{
+		{
+			name:          "default",
+			defaultSource: "default",
+		},
+		{
+			name: "memfd",
+			va:   types.VirtletAnnotations{MemoryBacking: types.MemoryBackingMemfd},
+			expected: &libvirtxml.DomainMemoryBacking{
+				MemorySource: &libvirtxml.DomainMemorySource{Type: "memfd"},
+			},
+		},
+		{
+			name:          "file as the node default",
+			defaultSource: "file",
+			expected: &libvirtxml.DomainMemoryBacking{
+				MemorySource: &libvirtxml.DomainMemorySource{Type: "file"},
+			},
+		},
+		{
+			name:          "annotation overrides the node default",
+			va:            types.VirtletAnnotations{MemoryBacking: types.MemoryBackingDefault},
+			defaultSource: "memfd",
+		},
+		{
+			name:          "shared memory",
+			va:            types.VirtletAnnotations{SharedMemory: true},
+			defaultSource: "default",
+			expected: &libvirtxml.DomainMemoryBacking{
+				MemorySource: &libvirtxml.DomainMemorySource{Type: "memfd"},
+				MemoryAccess: &libvirtxml.DomainMemoryAccess{Mode: "shared"},
+			},
+		},
+		{
+			name:            "smallest available huge pages",
+			va:              types.VirtletAnnotations{MemoryBacking: types.MemoryBackingHugepages},
+			hugetlbfsMounts: allMounts,
+			expected: &libvirtxml.DomainMemoryBacking{
+				MemoryHugePages: &libvirtxml.DomainMemoryHugepages{
+					Hugepages: []libvirtxml.DomainMemoryHugepage{{Size: 2048, Unit: "KiB"}},
+				},
+			},
+		},
+		{
+			name: "shared 1G huge pages",
+			va: types.VirtletAnnotations{
+				MemoryBacking: types.MemoryBackingHugepages,
+				HugePageSize:  hugePageSize1G,
+				SharedMemory:  true,
+			},
+			hugetlbfsMounts: allMounts,
+			expected: &libvirtxml.DomainMemoryBacking{
+				MemoryHugePages: &libvirtxml.DomainMemoryHugepages{
+					Hugepages: []libvirtxml.DomainMemoryHugepage{{Size: 1048576, Unit: "KiB"}},
+				},
+				MemoryAccess: &libvirtxml.DomainMemoryAccess{Mode: "shared"},
+			},
+		},
+		{
+			name:         "no hugetlbfs mounts",
+			va:           types.VirtletAnnotations{MemoryBacking: types.MemoryBackingHugepages},
+			errSubstring: "no hugetlbfs mounts available",
+		},
+		{
+			name: "no hugetlbfs mount for the page size",
+			va: types.VirtletAnnotations{
+				MemoryBacking: types.MemoryBackingHugepages,
+				HugePageSize:  hugePageSize1G,
+			},
+			hugetlbfsMounts: map[uint64]string{hugePageSize2M: "/dev/hugepages"},
+			errSubstring:    "no hugetlbfs mount for 1073741824 byte huge pages",
+		},
+		{
+			name: "memory size not aligned to the page size",
+			va: types.VirtletAnnotations{
+				MemoryBacking: types.MemoryBackingHugepages,
+				HugePageSize:  hugePageSize1G,
+			},
+			hugetlbfsMounts: allMounts,
+			memorySize:      testMemorySize + hugePageSize2M,
+			errSubstring:    "is not a multiple of the huge page size",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			memorySize := tc.memorySize
+			if memorySize == 0 {
+				memorySize = testMemorySize
+			}
+			mb, err := domainMemoryBacking(&tc.va, tc.defaultSource, tc.hugetlbfsMounts, memorySize)
+			switch {
+			case tc.errSubstring == "" && err != nil:
+				t.Errorf("domainMemoryBacking(): %v", err)
+			case tc.errSubstring != "" && err == nil:
+				t.Errorf("domainMemoryBacking() didn't fail")
+			case tc.errSubstring != "" && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("bad error %q, expected it to contain %q", err, tc.errSubstring)
+			case !reflect.DeepEqual(mb, tc.expected):
+				t.Errorf("bad memory backing: %#v instead of %#v", mb, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/utils"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+var cephConfigDir = "/etc/ceph"
+
+// SetCephConfigDir sets the directory that contains Ceph keyrings.
+// It can be useful in tests.
+func SetCephConfigDir(dir string) {
+	cephConfigDir = dir
+}
+
+// networkRootVolume denotes a root volume that resides on the
+// network storage. RBD root volumes are cloned from a snapshot
+// on the Ceph side and are removed together with the VM, while
+// iSCSI and NBD volumes are used as is.
+type networkRootVolume struct {
+	volumeBase
+	src *types.NetworkRootVolume
+}
+
+var _ VMVolume = &networkRootVolume{}
+
+func (v *networkRootVolume) IsDisk() bool { return true }
+
+func (v *networkRootVolume) UUID() string { return "" }
+
+func (v *networkRootVolume) PodVolumeName() string { return "root" }
+
+// volumeName returns the name of the volume as it's passed to QEMU.
+func (v *networkRootVolume) volumeName() string {
+	if v.src.Protocol != types.NetworkVolumeRBD {
+		return v.src.Name
+	}
+	pool := strings.SplitN(v.src.Name, "/", 2)[0]
+	return pool + "/virtlet_root_" + v.config.DomainUUID
+}
+
+func (v *networkRootVolume) secretUsageName() string {
+	return v.src.User + "-" + utils.NewUUID5(ContainerNsUUID, v.config.PodSandboxID) + "-root"
+}
+
+func (v *networkRootVolume) rbd(args ...string) error {
+	var fullArgs []string
+	if v.src.User != "" {
+		fullArgs = append(fullArgs, "--id", v.src.User)
+	}
+	if len(v.src.Hosts) > 0 {
+		fullArgs = append(fullArgs, "-m", strings.Join(v.src.Hosts, ","))
+	}
+	_, err := v.owner.Commander().Command("rbd", append(fullArgs, args...)...).Run(nil)
+	return err
+}
+
+// cloneImage makes a copy-on-write clone of the RBD snapshot unless
+// the clone already exists, e.g. after a failed attempt to start
+// the VM.
+func (v *networkRootVolume) cloneImage() error {
+	if err := v.rbd("info", v.volumeName()); err == nil {
+		glog.V(3).Infof("Using the existing rbd clone %q", v.volumeName())
+		return nil
+	}
+	snapshot := v.src.Name + "@" + v.src.Snapshot
+	if err := v.rbd("clone", snapshot, v.volumeName()); err != nil {
+		return fmt.Errorf("error cloning rbd snapshot %q to %q: %v", snapshot, v.volumeName(), err)
+	}
+	return nil
+}
+
+// cephKey returns the key of the Ceph user from its keyring.
+func (v *networkRootVolume) cephKey() ([]byte, error) {
+	keyringPath := filepath.Join(cephConfigDir, "ceph.client."+v.src.User+".keyring")
+	data, err := ioutil.ReadFile(keyringPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ceph keyring: %v", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// key = AQBm...==
+		parts := strings.SplitN(scanner.Text(), "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != "key" {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding the key in ceph keyring %q: %v", keyringPath, err)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("no key found in ceph keyring %q", keyringPath)
+}
+
+// setupSecret defines the libvirt secret holding the key of the
+// Ceph user.
+func (v *networkRootVolume) setupSecret() (*libvirtxml.DomainDiskAuth, error) {
+	key, err := v.cephKey()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := v.owner.DomainConnection().DefineSecret(&libvirtxml.Secret{
+		Ephemeral: "no",
+		Private:   "no",
+		UUID:      utils.NewUUID(),
+		Usage:     &libvirtxml.SecretUsage{Name: v.secretUsageName(), Type: "ceph"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error defining ceph secret: %v", err)
+	}
+	if err := secret.SetValue(key); err != nil {
+		return nil, fmt.Errorf("error setting value of secret %q: %v", v.secretUsageName(), err)
+	}
+	return &libvirtxml.DomainDiskAuth{
+		Username: v.src.User,
+		Secret: &libvirtxml.DomainDiskSecret{
+			Type:  "ceph",
+			Usage: v.secretUsageName(),
+		},
+	}, nil
+}
+
+func (v *networkRootVolume) Setup() (*libvirtxml.DomainDisk, *libvirtxml.DomainFilesystem, error) {
+	var auth *libvirtxml.DomainDiskAuth
+	if v.src.Protocol == types.NetworkVolumeRBD {
+		if err := v.cloneImage(); err != nil {
+			return nil, nil, err
+		}
+		if v.src.User != "" {
+			var err error
+			if auth, err = v.setupSecret(); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	var hosts []libvirtxml.DomainDiskSourceHost
+	for _, host := range v.src.Hosts {
+		h := libvirtxml.DomainDiskSourceHost{Name: host}
+		if p := strings.LastIndex(host, ":"); p >= 0 {
+			h.Name, h.Port = host[:p], host[p+1:]
+		}
+		hosts = append(hosts, h)
+	}
+
+	return &libvirtxml.DomainDisk{
+		Device: "disk",
+		Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "raw"},
+		Auth:   auth,
+		Source: &libvirtxml.DomainDiskSource{
+			Network: &libvirtxml.DomainDiskSourceNetwork{
+				Protocol: string(v.src.Protocol),
+				Name:     v.volumeName(),
+				Hosts:    hosts,
+			},
+		},
+	}, nil, nil
+}
+
+func (v *networkRootVolume) Teardown() error {
+	if v.src.Protocol != types.NetworkVolumeRBD {
+		return nil
+	}
+
+	if v.src.User != "" {
+		secret, err := v.owner.DomainConnection().LookupSecretByUsageName("ceph", v.secretUsageName())
+		if err == nil {
+			err = secret.Remove()
+		}
+		if err != nil && err != virt.ErrSecretNotFound {
+			return fmt.Errorf("error deleting secret with usage name %q: %v", v.secretUsageName(), err)
+		}
+	}
+
+	if err := v.rbd("info", v.volumeName()); err != nil {
+		glog.V(3).Infof("rbd clone %q not found, not removing it", v.volumeName())
+		return nil
+	}
+	if err := v.rbd("rm", "--no-progress", v.volumeName()); err != nil {
+		return fmt.Errorf("error removing rbd clone %q: %v", v.volumeName(), err)
+	}
+	return nil
+}
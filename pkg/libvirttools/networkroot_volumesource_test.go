@@ -0,0 +1,131 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	fakeutils "github.com/Mirantis/virtlet/pkg/utils/fake"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+	"github.com/Mirantis/virtlet/tests/gm"
+)
+
+func TestNetworkRootVolume(t *testing.T) {
+	rbdSource := &types.NetworkRootVolume{
+		Protocol: types.NetworkVolumeRBD,
+		Hosts:    []string{"10.0.0.1:6789", "10.0.0.2"},
+		Name:     "rbd/ubuntu-18.04",
+		Snapshot: "golden",
+	}
+	for _, tc := range []struct {
+		name string
+		src  *types.NetworkRootVolume
+		cmds []fakeutils.CmdSpec
+	}{
+		{
+			name: "rbd",
+			src:  rbdSource,
+			cmds: []fakeutils.CmdSpec{
+				{Match: " clone "},
+			},
+		},
+		{
+			name: "existing rbd clone",
+			src:  rbdSource,
+			cmds: []fakeutils.CmdSpec{
+				{Match: " info "},
+				{Match: " rm "},
+			},
+		},
+		{
+			name: "iscsi",
+			src: &types.NetworkRootVolume{
+				Protocol: types.NetworkVolumeISCSI,
+				Hosts:    []string{"10.0.0.3:3260"},
+				Name:     "iqn.2018-12.com.example:vm-1/0",
+			},
+		},
+		{
+			name: "nbd",
+			src: &types.NetworkRootVolume{
+				Protocol: types.NetworkVolumeNBD,
+				Hosts:    []string{"10.0.0.4"},
+				Name:     "vm-1",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := testutils.NewToplevelRecorder()
+			owner := newFakeVolumeOwner(nil, nil, nil, fakeutils.NewCommander(rec, tc.cmds))
+			vols, err := GetRootVolume(&types.VMConfig{
+				DomainUUID:        testUUID,
+				ParsedAnnotations: &types.VirtletAnnotations{RootVolumeSource: tc.src},
+			}, owner)
+			if err != nil {
+				t.Fatalf("GetRootVolume(): %v", err)
+			}
+			if len(vols) != 1 {
+				t.Fatalf("GetRootVolume() returned %d volumes instead of 1", len(vols))
+			}
+
+			rec.Rec("setup", nil)
+			disk, fs, err := vols[0].Setup()
+			if err != nil {
+				t.Fatalf("Setup(): %v", err)
+			}
+			if fs != nil {
+				t.Errorf("Didn't expect a filesystem")
+			}
+			out, err := disk.Marshal()
+			if err != nil {
+				t.Fatalf("error marshalling the volume: %v", err)
+			}
+			rec.Rec("end setup -- root disk", out)
+
+			rec.Rec("teardown", nil)
+			if err := vols[0].Teardown(); err != nil {
+				t.Errorf("Teardown(): %v", err)
+			}
+			rec.Rec("end teardown", nil)
+
+			gm.Verify(t, gm.NewYamlVerifier(rec.Content()))
+		})
+	}
+}
+
+func TestNetworkRootVolumeWithPersistentRootVolume(t *testing.T) {
+	_, err := GetRootVolume(&types.VMConfig{
+		DomainUUID: testUUID,
+		VolumeDevices: []types.VMVolumeDevice{
+			{
+				DevicePath: "/",
+				HostPath:   "/dev/rootdev",
+			},
+		},
+		ParsedAnnotations: &types.VirtletAnnotations{
+			RootVolumeSource: &types.NetworkRootVolume{
+				Protocol: types.NetworkVolumeNBD,
+				Hosts:    []string{"10.0.0.4"},
+				Name:     "vm-1",
+			},
+		},
+	}, nil)
+	if err == nil {
+		t.Errorf("GetRootVolume() didn't return an error")
+	}
+}
@@ -28,6 +28,7 @@ import (
 
 	"github.com/Mirantis/virtlet/pkg/blockdev"
 	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/utils"
 )
 
 // persistentRootVolume represents a root volume that can survive the
@@ -45,6 +46,8 @@ func (v *persistentRootVolume) devHandler() *blockdev.LogicalDeviceHandler {
 
 func (v *persistentRootVolume) IsDisk() bool { return true }
 
+func (v *persistentRootVolume) PodVolumeName() string { return "root" }
+
 func (v *persistentRootVolume) UUID() string {
 	return v.dev.UUID()
 }
@@ -59,7 +62,15 @@ func (v *persistentRootVolume) dmPath() string {
 
 func (v *persistentRootVolume) copyImageToDev(imagePath string) error {
 	syncFiles(imagePath, v.dev.HostPath, v.dmPath())
-	if _, err := v.owner.Commander().Command("qemu-img", "convert", "-O", "raw", imagePath, v.dmPath()).Run(nil); err != nil {
+	progress := v.owner.startProgress(v.config, "Writing the image to the persistent root volume")
+	cmd := v.owner.Commander().Command("qemu-img", "convert", "-p", "-O", "raw", imagePath, v.dmPath())
+	_, err := utils.RunWithLines(cmd, nil, func(line string) {
+		if percent, ok := parseQemuImgProgress(line); ok {
+			progress.update(percent)
+		}
+	})
+	progress.finish(err)
+	if err != nil {
 		return err
 	}
 	syncFiles(v.dmPath())
@@ -107,6 +118,10 @@ func (v *persistentRootVolume) Setup() (*libvirtxml.DomainDisk, *libvirtxml.Doma
 		}
 	}
 
+	if err == nil {
+		err = v.trackFirstBoot(imageDigest, !headerMatches)
+	}
+
 	if err != nil {
 		glog.V(4).Infof("Persistent rootfs setup on %q: error: %v", v.dev.HostPath, err)
 		return nil, nil, err
@@ -125,6 +140,28 @@ func (v *persistentRootVolume) Setup() (*libvirtxml.DomainDisk, *libvirtxml.Doma
 	}, nil, nil
 }
 
+// trackFirstBoot updates the first boot record for the volume and
+// sets cloud-init instance-id for the VM. The instance-id is kept
+// the same until a new image is written to the volume, so the VM
+// isn't provisioned by cloud-init again when its pod is recreated,
+// possibly under a different name.
+func (v *persistentRootVolume) trackFirstBoot(imageDigest digest.Digest, imageWritten bool) error {
+	return v.owner.MetadataStore().SaveFirstBootRecord(v.UUID(), func(r *types.FirstBootRecord) (*types.FirstBootRecord, error) {
+		if r != nil && !imageWritten && r.ImageDigest == imageDigest.String() {
+			glog.V(4).Infof("Persistent rootfs setup on %q: using instance-id %q from the first boot record", v.dev.HostPath, r.InstanceID)
+			v.config.InstanceID = r.InstanceID
+			return r, nil
+		}
+		v.config.InstanceID = defaultInstanceID(v.config)
+		return &types.FirstBootRecord{
+			ImageDigest:  imageDigest.String(),
+			InstanceID:   v.config.InstanceID,
+			PodNamespace: v.config.PodNamespace,
+			PodName:      v.config.PodName,
+		}, nil
+	})
+}
+
 func (v *persistentRootVolume) Teardown() error {
 	return v.devHandler().Unmap(v.dmName())
 }
@@ -0,0 +1,74 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// PodChecker checks whether the pods still exist in the cluster.
+type PodChecker interface {
+	// PodExists returns true if the pod with the specified
+	// namespace and name exists and has the specified UID.
+	PodExists(namespace, name, uid string) (bool, error)
+}
+
+type kubePodChecker struct {
+	sync.Mutex
+	clientCfg  clientcmd.ClientConfig
+	kubeClient kubernetes.Interface
+}
+
+// NewKubePodChecker returns a PodChecker that looks up the pods
+// using Kubernetes API.
+func NewKubePodChecker(clientCfg clientcmd.ClientConfig) PodChecker {
+	return &kubePodChecker{clientCfg: clientCfg}
+}
+
+func (c *kubePodChecker) ensureKubeClient() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.kubeClient != nil {
+		return nil
+	}
+	config, err := c.clientCfg.ClientConfig()
+	if err != nil {
+		return err
+	}
+	c.kubeClient, err = kubernetes.NewForConfig(config)
+	return err
+}
+
+func (c *kubePodChecker) PodExists(namespace, name, uid string) (bool, error) {
+	if err := c.ensureKubeClient(); err != nil {
+		return false, err
+	}
+	pod, err := c.kubeClient.CoreV1().Pods(namespace).Get(name, meta_v1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	// a pod with the same name may have been created again
+	return string(pod.UID) == uid, nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const (
+	// progressReportInterval is the minimum interval between
+	// the progress events posted for a long operation.
+	progressReportInterval = 15 * time.Second
+	// eventReasonProgress is the reason of the events that
+	// report the progress of the long operations.
+	eventReasonProgress = "OperationProgress"
+	// eventReasonCompleted is the reason of the events that
+	// report the completion of the long operations.
+	eventReasonCompleted = "OperationCompleted"
+)
+
+// qemuImgProgressRx matches the progress lines that are printed
+// by 'qemu-img convert -p', e.g. "    (42.52/100%)".
+var qemuImgProgressRx = regexp.MustCompile(`^\s*\(\s*(\d+(?:\.\d+)?)/100%\)\s*$`)
+
+// parseQemuImgProgress returns the percentage from a progress line
+// printed by qemu-img. The second returned value is false if the line
+// doesn't denote the progress.
+func parseQemuImgProgress(line string) (float64, bool) {
+	m := qemuImgProgressRx.FindStringSubmatch(line)
+	if m == nil {
+		return 0, false
+	}
+	percent, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, false
+	}
+	return percent, true
+}
+
+// operationProgress reports the progress of a long operation, such as
+// writing an image to a persistent root volume, for a VM. The progress
+// is posted as pod events and is also included in the message of the
+// container status, so users watching 'kubectl describe' can tell the
+// pod isn't stuck. All the methods are no-ops for nil operationProgress.
+type operationProgress struct {
+	owner      *VirtualizationTool
+	config     *types.VMConfig
+	operation  string
+	startedAt  time.Time
+	reportedAt time.Time
+	reported   bool
+}
+
+// startProgress starts tracking the progress of the specified
+// operation for the VM.
+func (v *VirtualizationTool) startProgress(config *types.VMConfig, operation string) *operationProgress {
+	return &operationProgress{
+		owner:     v,
+		config:    config,
+		operation: operation,
+		startedAt: v.clock.Now(),
+	}
+}
+
+// update records the progress of the operation given the percentage
+// of the work that's done. The events are throttled so that there's
+// at most one of them per progressReportInterval.
+func (p *operationProgress) update(percent float64) {
+	if p == nil {
+		return
+	}
+	now := p.owner.clock.Now()
+	if p.reported && now.Sub(p.reportedAt) < progressReportInterval {
+		return
+	}
+	elapsed := now.Sub(p.startedAt)
+	if !p.reported && elapsed < progressReportInterval {
+		// don't bother users with quick operations
+		return
+	}
+	message := fmt.Sprintf("%s: %d%% done", p.operation, int(percent))
+	if percent > 0 && percent < 100 {
+		remaining := time.Duration(float64(elapsed) * (100 - percent) / percent)
+		message += fmt.Sprintf(", about %v remaining", remaining.Round(time.Second))
+	}
+	p.reported = true
+	p.reportedAt = now
+	p.owner.setProgressMessage(p.config.DomainUUID, message)
+	p.owner.eventRecorder.Eventf(p.config, v1.EventTypeNormal, eventReasonProgress, "%s", message)
+}
+
+// finish marks the end of the operation. The completion event is
+// only posted if the progress of the operation was reported.
+func (p *operationProgress) finish(err error) {
+	if p == nil || !p.reported {
+		return
+	}
+	p.owner.setProgressMessage(p.config.DomainUUID, "")
+	elapsed := p.owner.clock.Now().Sub(p.startedAt).Round(time.Second)
+	if err != nil {
+		p.owner.eventRecorder.Eventf(p.config, v1.EventTypeWarning, eventReasonCompleted, "%s failed after %v: %v", p.operation, elapsed, err)
+		return
+	}
+	p.owner.eventRecorder.Eventf(p.config, v1.EventTypeNormal, eventReasonCompleted, "%s done in %v", p.operation, elapsed)
+}
+
+// setProgressMessage sets the progress message for the container.
+// Empty message removes the progress info.
+func (v *VirtualizationTool) setProgressMessage(containerID, message string) {
+	v.progressLock.Lock()
+	defer v.progressLock.Unlock()
+	if message == "" {
+		delete(v.progressMessages, containerID)
+	} else {
+		v.progressMessages[containerID] = message
+	}
+}
+
+// progressMessage returns the message describing the progress of
+// the long operation that's being done for the container, if any.
+func (v *VirtualizationTool) progressMessage(containerID string) string {
+	v.progressLock.Lock()
+	defer v.progressLock.Unlock()
+	return v.progressMessages[containerID]
+}
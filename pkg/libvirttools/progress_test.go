@@ -0,0 +1,113 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+)
+
+func TestParseQemuImgProgress(t *testing.T) {
+	for _, tc := range []struct {
+		line    string
+		percent float64
+		ok      bool
+	}{
+		{line: "    (0.00/100%)", percent: 0, ok: true},
+		{line: "    (42.52/100%)", percent: 42.52, ok: true},
+		{line: "    (100.00/100%)", percent: 100, ok: true},
+		{line: "", ok: false},
+		{line: "qemu-img: error", ok: false},
+	} {
+		percent, ok := parseQemuImgProgress(tc.line)
+		if ok != tc.ok || percent != tc.percent {
+			t.Errorf("parseQemuImgProgress(%q) = %v, %v instead of %v, %v", tc.line, percent, ok, tc.percent, tc.ok)
+		}
+	}
+}
+
+func (ct *containerTester) verifyContainerMessage(containerID, expectedMessage string) {
+	info, err := ct.virtTool.ContainerInfo(containerID)
+	if err != nil {
+		ct.t.Fatalf("ContainerInfo(): %v", err)
+	}
+	if info.Message != expectedMessage {
+		ct.t.Errorf("bad container message %q instead of %q", info.Message, expectedMessage)
+	}
+}
+
+func TestOperationProgress(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+	recorder := &fakeEventRecorder{}
+	ct.virtTool.SetEventRecorder(recorder)
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	ct.setPodSandbox(sandbox)
+	containerID := ct.createContainer(sandbox, nil, nil)
+	info, err := ct.virtTool.ContainerInfo(containerID)
+	if err != nil {
+		t.Fatalf("ContainerInfo(): %v", err)
+	}
+	eventPrefix := "default/" + sandbox.Name
+
+	// quick operations aren't reported
+	progress := ct.virtTool.startProgress(&info.Config, "Copying the volume")
+	progress.update(50)
+	progress.finish(nil)
+	verifyEvents(t, recorder, nil)
+	ct.verifyContainerMessage(containerID, "")
+
+	progress = ct.virtTool.startProgress(&info.Config, "Copying the volume")
+	ct.clock.Advance(20 * time.Second)
+	progress.update(25)
+	verifyEvents(t, recorder, []string{
+		eventPrefix + " Normal OperationProgress: Copying the volume: 25% done, about 1m0s remaining",
+	})
+	ct.verifyContainerMessage(containerID, "Copying the volume: 25% done, about 1m0s remaining")
+
+	// the updates are throttled
+	ct.clock.Advance(5 * time.Second)
+	progress.update(30)
+	verifyEvents(t, recorder, nil)
+
+	ct.clock.Advance(15 * time.Second)
+	progress.update(50)
+	verifyEvents(t, recorder, []string{
+		eventPrefix + " Normal OperationProgress: Copying the volume: 50% done, about 40s remaining",
+	})
+
+	progress.finish(nil)
+	verifyEvents(t, recorder, []string{
+		eventPrefix + " Normal OperationCompleted: Copying the volume done in 40s",
+	})
+	ct.verifyContainerMessage(containerID, "")
+
+	progress = ct.virtTool.startProgress(&info.Config, "Copying the volume")
+	ct.clock.Advance(30 * time.Second)
+	progress.update(10)
+	progress.finish(errors.New("no space left on device"))
+	verifyEvents(t, recorder, []string{
+		eventPrefix + " Normal OperationProgress: Copying the volume: 10% done, about 4m30s remaining",
+		eventPrefix + " Warning OperationCompleted: Copying the volume failed after 30s: no space left on device",
+	})
+	ct.verifyContainerMessage(containerID, "")
+}
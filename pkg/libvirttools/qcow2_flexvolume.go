@@ -44,6 +44,9 @@ var capacityRx = regexp.MustCompile(`^\s*(\d+)\s*(\S*)\s*$`)
 type qcow2VolumeOptions struct {
 	Capacity string `json:"capacity,omitempty"`
 	UUID     string `json:"uuid"`
+	// Persistent is set to "true" if the volume holds the data
+	// that must not be removed without a confirmation.
+	Persistent string `json:"persistent,omitempty"`
 }
 
 // qcow2Volume denotes a volume in QCOW2 format
@@ -53,6 +56,7 @@ type qcow2Volume struct {
 	capacityUnit string
 	name         string
 	uuid         string
+	persistent   bool
 }
 
 var _ VMVolume = &qcow2Volume{}
@@ -67,6 +71,7 @@ func newQCOW2Volume(volumeName, configPath string, config *types.VMConfig, owner
 		volumeBase: volumeBase{config, owner},
 		name:       volumeName,
 		uuid:       opts.UUID,
+		persistent: opts.Persistent == "true",
 	}
 
 	v.capacity, v.capacityUnit, err = parseCapacityStr(opts.Capacity)
@@ -95,22 +100,35 @@ func (v *qcow2Volume) createQCOW2Volume(capacity uint64, capacityUnit string) (v
 
 func (v *qcow2Volume) IsDisk() bool { return true }
 
+func (v *qcow2Volume) PodVolumeName() string { return v.name }
+
+func (v *qcow2Volume) IsPersistent() bool { return v.persistent }
+
 func (v *qcow2Volume) UUID() string {
 	return v.uuid
 }
 
-func (v *qcow2Volume) Setup() (*libvirtxml.DomainDisk, *libvirtxml.DomainFilesystem, error) {
-	vol, err := v.createQCOW2Volume(uint64(v.capacity), v.capacityUnit)
-	if err != nil {
-		return nil, nil, fmt.Errorf("error during creation of volume '%s' with virtlet description %s: %v", v.volumeName(), v.name, err)
-	}
+// IsRetainable returns true because the ephemeral disks of a
+// crash-looping or soft-rebooted VM must keep their contents
+// along with its root volume.
+func (v *qcow2Volume) IsRetainable() bool { return true }
 
-	path, err := vol.Path()
+func (v *qcow2Volume) Setup() (*libvirtxml.DomainDisk, *libvirtxml.DomainFilesystem, error) {
+	vol, err := retainedStorageVolume(v.owner, v.config, v.volumeName())
 	if err != nil {
 		return nil, nil, err
 	}
+	if vol == nil {
+		vol, err = v.createQCOW2Volume(uint64(v.capacity), v.capacityUnit)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error during creation of volume '%s' with virtlet description %s: %v", v.volumeName(), v.name, err)
+		}
+		if err := vol.Format(); err != nil {
+			return nil, nil, err
+		}
+	}
 
-	err = vol.Format()
+	path, err := vol.Path()
 	if err != nil {
 		return nil, nil, err
 	}
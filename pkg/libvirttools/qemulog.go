@@ -0,0 +1,160 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+const (
+	// maxQemuLogSize is the maximum number of bytes taken from
+	// the end of libvirt's qemu log when it's captured.
+	maxQemuLogSize = 1024 * 1024
+	// qemuLogBackups is the number of rotated copies of the
+	// captured qemu log that are kept in the pod log directory.
+	qemuLogBackups = 2
+	// qemuLogTailLines is the number of the last lines of the qemu
+	// log that are included in the container status message.
+	qemuLogTailLines = 20
+	// ContainerReasonVMStartFailed is the container status reason
+	// used when a VM fails to start.
+	ContainerReasonVMStartFailed = "VMStartFailed"
+)
+
+// qemuLogPath returns the path of the captured qemu log for the
+// container with the specified config. It returns an empty string
+// if there's no pod log directory.
+func qemuLogPath(config *types.VMConfig) string {
+	if config.LogDirectory == "" {
+		return ""
+	}
+	return filepath.Join(config.LogDirectory, config.Name+"_qemu.log")
+}
+
+// readLogTail reads up to maxSize last bytes of the specified log file.
+// If the log is truncated, the partial first line is skipped.
+func readLogTail(path string, maxSize int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	truncated := fi.Size() > maxSize
+	if truncated {
+		if _, err := f.Seek(-maxSize, io.SeekEnd); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if truncated {
+		if n := bytes.IndexByte(data, '\n'); n >= 0 {
+			data = data[n+1:]
+		}
+	}
+	return data, nil
+}
+
+// rotateLog renames path to path.1, path.1 to path.2 and so on,
+// keeping at most numBackups old copies of the log.
+func rotateLog(path string, numBackups int) error {
+	for i := numBackups; i > 0; i-- {
+		src := path
+		if i > 1 {
+			src = fmt.Sprintf("%s.%d", path, i-1)
+		}
+		if err := os.Rename(src, fmt.Sprintf("%s.%d", path, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// lastLines returns up to n last lines of the text.
+func lastLines(data []byte, n int) string {
+	text := strings.TrimRight(string(data), "\n")
+	if text == "" {
+		return ""
+	}
+	lines := strings.Split(text, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// captureQemuLog copies the tail of the qemu log written by libvirt
+// for the specified domain into the pod log directory, rotating the
+// previously captured logs. It returns the last qemuLogTailLines
+// lines of the log. If the log capture is disabled or the log
+// doesn't exist, captureQemuLog returns an empty string.
+func (v *VirtualizationTool) captureQemuLog(domainName string, config *types.VMConfig) (string, error) {
+	if v.config.QemuLogDirectory == "" {
+		return "", nil
+	}
+
+	data, err := readLogTail(filepath.Join(v.config.QemuLogDirectory, domainName+".log"), maxQemuLogSize)
+	switch {
+	case os.IsNotExist(err):
+		return "", nil
+	case err != nil:
+		return "", fmt.Errorf("error reading qemu log for domain %q: %v", domainName, err)
+	}
+
+	if logPath := qemuLogPath(config); logPath != "" {
+		if err := rotateLog(logPath, qemuLogBackups); err != nil {
+			return "", fmt.Errorf("error rotating qemu log %q: %v", logPath, err)
+		}
+		if err := ioutil.WriteFile(logPath, data, 0644); err != nil {
+			return "", fmt.Errorf("error writing qemu log %q: %v", logPath, err)
+		}
+	}
+
+	return lastLines(data, qemuLogTailLines), nil
+}
+
+// captureDomainQemuLog captures the qemu log of the specified domain.
+// See captureQemuLog for details.
+func (v *VirtualizationTool) captureDomainQemuLog(domain virt.Domain, config *types.VMConfig) (string, error) {
+	if v.config.QemuLogDirectory == "" {
+		return "", nil
+	}
+	domainName, err := domain.Name()
+	if err != nil {
+		return "", fmt.Errorf("can't get domain name: %v", err)
+	}
+	return v.captureQemuLog(domainName, config)
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func TestCaptureQemuLog(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "qemu-log-")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	qemuLogDir := filepath.Join(tmpDir, "qemu")
+	podLogDir := filepath.Join(tmpDir, "pod")
+	for _, dir := range []string{qemuLogDir, podLogDir} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("Mkdir(): %v", err)
+		}
+	}
+
+	v := &VirtualizationTool{
+		virtualizationState: &virtualizationState{
+			config: VirtualizationConfig{QemuLogDirectory: qemuLogDir},
+		},
+	}
+	config := &types.VMConfig{
+		Name:         "vm",
+		LogDirectory: podLogDir,
+	}
+
+	tail, err := v.captureQemuLog("virtlet-abc-vm", config)
+	if err != nil {
+		t.Fatalf("captureQemuLog() for a missing log: %v", err)
+	}
+	if tail != "" {
+		t.Errorf("unexpected log tail for a missing log: %q", tail)
+	}
+
+	var lines []string
+	for i := 0; i < 30; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	for n := 0; n < 4; n++ {
+		content := fmt.Sprintf("attempt %d\n%s\n", n, strings.Join(lines, "\n"))
+		if err := ioutil.WriteFile(filepath.Join(qemuLogDir, "virtlet-abc-vm.log"), []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile(): %v", err)
+		}
+		tail, err := v.captureQemuLog("virtlet-abc-vm", config)
+		if err != nil {
+			t.Fatalf("captureQemuLog(): %v", err)
+		}
+		if expectedTail := strings.Join(lines[10:], "\n"); tail != expectedTail {
+			t.Errorf("bad log tail: expected %q, got %q", expectedTail, tail)
+		}
+	}
+
+	for suffix, attempt := range map[string]int{"": 3, ".1": 2, ".2": 1} {
+		content, err := ioutil.ReadFile(filepath.Join(podLogDir, "vm_qemu.log"+suffix))
+		if err != nil {
+			t.Fatalf("ReadFile(): %v", err)
+		}
+		if !strings.HasPrefix(string(content), fmt.Sprintf("attempt %d\n", attempt)) {
+			t.Errorf("bad content of vm_qemu.log%s: %q", suffix, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(podLogDir, "vm_qemu.log.3")); !os.IsNotExist(err) {
+		t.Errorf("vm_qemu.log.3 should not exist")
+	}
+}
+
+func TestReadLogTail(t *testing.T) {
+	tmpFile, err := ioutil.TempFile("", "qemu-log-")
+	if err != nil {
+		t.Fatalf("TempFile(): %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.WriteString("first line\nsecond line\nthird line\n"); err != nil {
+		t.Fatalf("WriteString(): %v", err)
+	}
+	tmpFile.Close()
+
+	for _, tc := range []struct {
+		maxSize  int64
+		expected string
+	}{
+		{100, "first line\nsecond line\nthird line\n"},
+		// the partial first line is dropped
+		{20, "third line\n"},
+	} {
+		data, err := readLogTail(tmpFile.Name(), tc.maxSize)
+		if err != nil {
+			t.Fatalf("readLogTail(): %v", err)
+		}
+		if string(data) != tc.expected {
+			t.Errorf("readLogTail(..., %d): expected %q, got %q", tc.maxSize, tc.expected, data)
+		}
+	}
+}
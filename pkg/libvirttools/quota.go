@@ -0,0 +1,119 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const mib = 1024 * 1024
+
+// vmResources returns the number of vCPUs and the amount of memory
+// in bytes given to the VM described by the config.
+func vmResources(config *types.VMConfig) (int, int64) {
+	vcpus := 1
+	if config.ParsedAnnotations != nil && config.ParsedAnnotations.MaxVCPUs() > 0 {
+		// the vCPUs that may be hot-added are counted, too
+		vcpus = config.ParsedAnnotations.MaxVCPUs()
+	}
+	memory := config.MemoryLimitInBytes
+	if memory == 0 && config.ParsedAnnotations != nil {
+		memory = config.ParsedAnnotations.Memory
+	}
+	if memory == 0 {
+		memory = defaultMemory * mib
+	}
+	return vcpus, memory
+}
+
+// admitVM checks whether the VM described by the config fits into
+// the per-node quota for its namespace and reserves its resources.
+// On success, it returns a function that releases the reservation and
+// must be called after the container is saved in the metadata store
+// or its creation fails, so that the VMs that are being created
+// concurrently are accounted for properly. The quota lock is only
+// held while the quota is checked and the reservation is made.
+// ParsedAnnotations must be populated.
+func (v *VirtualizationTool) admitVM(config *types.VMConfig) (func(), error) {
+	loader := types.GetExternalDataLoader()
+	if loader == nil {
+		return func() {}, nil
+	}
+	quota, err := loader.LoadVMQuota(config.PodNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("error loading VM quota for namespace %q: %v", config.PodNamespace, err)
+	}
+	if quota == nil {
+		return func() {}, nil
+	}
+
+	v.quotaLock.Lock()
+	defer v.quotaLock.Unlock()
+	if err := v.checkVMQuota(config, quota); err != nil {
+		v.eventRecorder.Eventf(config, v1.EventTypeWarning, "VMQuotaExceeded", "%v", err)
+		return nil, err
+	}
+	v.quotaReservations[config] = true
+	return func() {
+		v.quotaLock.Lock()
+		defer v.quotaLock.Unlock()
+		delete(v.quotaReservations, config)
+	}, nil
+}
+
+func (v *VirtualizationTool) checkVMQuota(config *types.VMConfig, quota *types.VMQuota) error {
+	containers, err := v.ListContainers(nil)
+	if err != nil {
+		return fmt.Errorf("error listing containers: %v", err)
+	}
+	numVMs, usedVCPUs, usedMemory := 0, 0, int64(0)
+	count := func(c *types.VMConfig) {
+		if c.PodNamespace != config.PodNamespace {
+			return
+		}
+		vcpus, memory := vmResources(c)
+		numVMs++
+		usedVCPUs += vcpus
+		usedMemory += memory
+	}
+	for _, c := range containers {
+		if c.State == types.ContainerState_CONTAINER_CREATED || c.State == types.ContainerState_CONTAINER_RUNNING {
+			count(&c.Config)
+		}
+	}
+	// The VMs that are being created are counted, too. A VM that's
+	// already saved but not released yet is counted twice for a
+	// short while, which errs on the safe side.
+	for c := range v.quotaReservations {
+		count(c)
+	}
+
+	vcpus, memory := vmResources(config)
+	switch {
+	case quota.MaxVMs >= 0 && numVMs+1 > quota.MaxVMs:
+		return fmt.Errorf("VM quota exceeded for namespace %q on this node: %d of %d VMs already in use", config.PodNamespace, numVMs, quota.MaxVMs)
+	case quota.MaxVCPUs >= 0 && usedVCPUs+vcpus > quota.MaxVCPUs:
+		return fmt.Errorf("VM quota exceeded for namespace %q on this node: requested %d vCPUs, %d of %d already in use", config.PodNamespace, vcpus, usedVCPUs, quota.MaxVCPUs)
+	case quota.MaxMemory >= 0 && usedMemory+memory > quota.MaxMemory:
+		return fmt.Errorf("VM quota exceeded for namespace %q on this node: requested %d MiB of memory, %d of %d MiB already in use", config.PodNamespace, memory/mib, usedMemory/mib, quota.MaxMemory/mib)
+	}
+	return nil
+}
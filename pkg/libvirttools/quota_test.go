@@ -0,0 +1,187 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	virtlet_v1 "github.com/Mirantis/virtlet/pkg/api/virtlet.k8s/v1"
+	"github.com/Mirantis/virtlet/pkg/client/clientset/versioned/fake"
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+)
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func quantityPtr(s string) *resource.Quantity {
+	q := resource.MustParse(s)
+	return &q
+}
+
+func newQuotaTestLoader() *defaultExternalDataLoader {
+	return &defaultExternalDataLoader{
+		virtletClient: fake.NewSimpleClientset(
+			&virtlet_v1.VirtletVMPolicy{
+				ObjectMeta: meta_v1.ObjectMeta{
+					Name:      "policy-b",
+					Namespace: "default",
+				},
+				Spec: virtlet_v1.VirtletVMPolicySpec{
+					MaxVMsPerNode:    intPtr(2),
+					MaxMemoryPerNode: quantityPtr("1536Mi"),
+				},
+			},
+			&virtlet_v1.VirtletVMPolicy{
+				ObjectMeta: meta_v1.ObjectMeta{
+					Name:      "policy-a",
+					Namespace: "default",
+				},
+				Spec: virtlet_v1.VirtletVMPolicySpec{
+					MaxVMsPerNode: intPtr(1),
+				},
+			},
+			&virtlet_v1.VirtletVMPolicy{
+				ObjectMeta: meta_v1.ObjectMeta{
+					Name:      "no-quota",
+					Namespace: "other",
+				},
+				Spec: virtlet_v1.VirtletVMPolicySpec{
+					OnCrash: "preserve",
+				},
+			},
+		),
+	}
+}
+
+func TestLoadVMQuota(t *testing.T) {
+	loader := newQuotaTestLoader()
+	for _, tc := range []struct {
+		namespace     string
+		expectedQuota *types.VMQuota
+	}{
+		{
+			namespace: "default",
+			expectedQuota: &types.VMQuota{
+				MaxVMs:    2,
+				MaxVCPUs:  -1,
+				MaxMemory: 1536 * mib,
+			},
+		},
+		{
+			namespace: "other",
+		},
+		{
+			namespace: "nopolicies",
+		},
+	} {
+		t.Run(tc.namespace, func(t *testing.T) {
+			quota, err := loader.LoadVMQuota(tc.namespace)
+			if err != nil {
+				t.Fatalf("LoadVMQuota(): %v", err)
+			}
+			if !reflect.DeepEqual(quota, tc.expectedQuota) {
+				t.Errorf("bad quota: expected %#v, got %#v", tc.expectedQuota, quota)
+			}
+		})
+	}
+}
+
+func TestVMQuota(t *testing.T) {
+	withExternalDataLoader(newQuotaTestLoader(), func() {
+		ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+		defer ct.teardown()
+		recorder := &fakeEventRecorder{}
+		ct.virtTool.SetEventRecorder(recorder)
+
+		sandboxes := fakemeta.GetSandboxes(2)
+		for _, sandbox := range sandboxes {
+			ct.setPodSandbox(sandbox)
+		}
+		containerID := ct.createContainer(sandboxes[0], nil, nil)
+
+		_, err := ct.virtTool.CreateContainer(&types.VMConfig{
+			PodSandboxID:   sandboxes[1].Uid,
+			PodName:        sandboxes[1].Name,
+			PodNamespace:   sandboxes[1].Namespace,
+			Name:           fakeContainerName,
+			Image:          fakeImageName,
+			PodAnnotations: sandboxes[1].Annotations,
+		}, "/tmp/fakenetns")
+		expectedMsg := `VM quota exceeded for namespace "default" on this node: requested 1024 MiB of memory, 1024 of 1536 MiB already in use`
+		if err == nil {
+			t.Fatalf("CreateContainer() didn't fail")
+		} else if err.Error() != expectedMsg {
+			t.Errorf("bad error message: expected %q, got %q", expectedMsg, err.Error())
+		}
+		expectedEvents := []string{
+			"default/" + sandboxes[1].Name + " Warning VMQuotaExceeded: " + expectedMsg,
+		}
+		if !reflect.DeepEqual(recorder.events, expectedEvents) {
+			t.Errorf("bad events:\n%#v\ninstead of\n%#v", recorder.events, expectedEvents)
+		}
+
+		// removed VMs don't count against the quota
+		ct.removeContainer(containerID)
+		ct.createContainer(sandboxes[1], nil, nil)
+	})
+}
+
+func TestVMQuotaReservation(t *testing.T) {
+	withExternalDataLoader(newQuotaTestLoader(), func() {
+		ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+		defer ct.teardown()
+
+		sandboxes := fakemeta.GetSandboxes(2)
+		var configs []*types.VMConfig
+		for _, sandbox := range sandboxes {
+			config := &types.VMConfig{
+				PodSandboxID:   sandbox.Uid,
+				PodName:        sandbox.Name,
+				PodNamespace:   sandbox.Namespace,
+				Name:           fakeContainerName,
+				Image:          fakeImageName,
+				PodAnnotations: sandbox.Annotations,
+			}
+			if err := config.LoadAnnotations(); err != nil {
+				t.Fatalf("LoadAnnotations(): %v", err)
+			}
+			configs = append(configs, config)
+		}
+
+		release, err := ct.virtTool.admitVM(configs[0])
+		if err != nil {
+			t.Fatalf("admitVM(): %v", err)
+		}
+		// the VM that's being created counts against the quota
+		if _, err := ct.virtTool.admitVM(configs[1]); err == nil {
+			t.Errorf("admitVM() didn't fail for a VM exceeding the quota")
+		}
+		release()
+		release, err = ct.virtTool.admitVM(configs[1])
+		if err != nil {
+			t.Fatalf("admitVM() after releasing the reservation: %v", err)
+		}
+		release()
+	})
+}
@@ -42,6 +42,7 @@ func (vo *rawVolumeOptions) validate() error {
 // rawDeviceVolume denotes a raw device that's made accessible for a VM
 type rawDeviceVolume struct {
 	volumeBase
+	name string
 	opts *rawVolumeOptions
 }
 
@@ -57,6 +58,7 @@ func newRawDeviceVolume(volumeName, configPath string, config *types.VMConfig, o
 	}
 	return &rawDeviceVolume{
 		volumeBase: volumeBase{config, owner},
+		name:       volumeName,
 		opts:       &opts,
 	}, nil
 }
@@ -80,6 +82,8 @@ func (v *rawDeviceVolume) UUID() string {
 	return v.opts.UUID
 }
 
+func (v *rawDeviceVolume) PodVolumeName() string { return v.name }
+
 func (v *rawDeviceVolume) Setup() (*libvirtxml.DomainDisk, *libvirtxml.DomainFilesystem, error) {
 	if err := v.verifyRawDeviceWhitelisted(v.opts.Path); err != nil {
 		return nil, nil, err
@@ -0,0 +1,341 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/Mirantis/virtlet/pkg/metadata"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+// ReconcilePolicy specifies how Reconcile handles the discrepancies
+// between the metadata store and libvirt.
+type ReconcilePolicy string
+
+const (
+	// ReconcileRepair makes Reconcile remove the container records
+	// whose domains are gone and the Virtlet domains that have no
+	// container records.
+	ReconcileRepair ReconcilePolicy = "repair"
+	// ReconcileAdopt makes Reconcile remove the container records
+	// whose domains are gone, but keep the orphan domains along
+	// with their volumes so they can be recovered, e.g. renamed and
+	// taken over by a VM pod using VirtletAdoptDomain annotation.
+	ReconcileAdopt ReconcilePolicy = "adopt"
+	// ReconcileMarkOrphaned makes Reconcile keep both the container
+	// records whose domains are gone and the orphan domains, only
+	// marking such records as exited with ContainerReasonDomainMissing
+	// reason.
+	ReconcileMarkOrphaned ReconcilePolicy = "mark-orphaned"
+
+	// ContainerReasonDomainMissing is the container status reason
+	// used for the container records whose domains are gone.
+	ContainerReasonDomainMissing = "DomainMissing"
+)
+
+// The following are the kinds of the discrepancies found by Reconcile.
+const (
+	// DiscrepancyMissingDomain means that the domain of the
+	// container doesn't exist.
+	DiscrepancyMissingDomain = "MissingDomain"
+	// DiscrepancyOrphanDomain means that there's no container
+	// record for a Virtlet domain.
+	DiscrepancyOrphanDomain = "OrphanDomain"
+	// DiscrepancyStateMismatch means that the state of the
+	// container record doesn't match the state of its domain.
+	DiscrepancyStateMismatch = "StateMismatch"
+	// DiscrepancyMissingNetNs means that the network namespace of
+	// a ready pod sandbox doesn't exist.
+	DiscrepancyMissingNetNs = "MissingNetNs"
+)
+
+// Discrepancy describes an inconsistency between the metadata store
+// and libvirt found by Reconcile along with the action taken.
+type Discrepancy struct {
+	// Kind is one of Discrepancy* values
+	Kind string
+	// PodSandboxID is the id of the pod sandbox if it's known
+	PodSandboxID string
+	// ContainerID is the id of the container or the UUID of
+	// the orphan domain
+	ContainerID string
+	// Action describes what was done to fix the discrepancy
+	Action string
+}
+
+func (d *Discrepancy) String() string {
+	var ids []string
+	if d.PodSandboxID != "" {
+		ids = append(ids, "pod sandbox "+d.PodSandboxID)
+	}
+	if d.ContainerID != "" {
+		ids = append(ids, "container "+d.ContainerID)
+	}
+	return fmt.Sprintf("%s (%s): %s", d.Kind, strings.Join(ids, ", "), d.Action)
+}
+
+func containerStateName(state types.ContainerState) string {
+	switch state {
+	case types.ContainerState_CONTAINER_CREATED:
+		return "created"
+	case types.ContainerState_CONTAINER_RUNNING:
+		return "running"
+	case types.ContainerState_CONTAINER_EXITED:
+		return "exited"
+	default:
+		return "unknown"
+	}
+}
+
+// Reconcile compares the container and pod sandbox records in the
+// metadata store with the libvirt domains and the network namespaces
+// and handles each discrepancy according to the reconcile policy.
+// It's intended to be called upon Virtlet startup before the GC, so
+// that the stale metadata left after a crash doesn't prevent the
+// domains and their volumes from being cleaned up. The discrepancies
+// are logged and recorded in the event history of the pod sandboxes.
+func (v *VirtualizationTool) Reconcile() ([]*Discrepancy, []error) {
+	policy := v.config.ReconcilePolicy
+	if policy == "" {
+		policy = ReconcileRepair
+	}
+
+	domains, err := v.domainConn.ListDomains()
+	if err != nil {
+		return nil, []error{fmt.Errorf("cannot list domains: %v", err)}
+	}
+	containers, _, err := v.metadataStore.ListContainersPage(0, "")
+	if err != nil {
+		return nil, []error{fmt.Errorf("cannot list containers: %v", err)}
+	}
+	// the domains kept because of the soft reboot have no container
+	// records, but they're not orphans
+	retainedIDs, err := v.retainedVolumeIDs()
+	if err != nil {
+		return nil, []error{err}
+	}
+	retained := make(map[string]bool)
+	for _, id := range retainedIDs {
+		retained[id] = true
+	}
+
+	var allErrors []error
+	domainsByID := make(map[string]virt.Domain)
+	for _, domain := range domains {
+		id, err := domain.UUIDString()
+		if err != nil {
+			allErrors = append(allErrors, fmt.Errorf("cannot retrieve domain UUID: %v", err))
+			continue
+		}
+		domainsByID[id] = domain
+	}
+
+	var discrepancies []*Discrepancy
+	report := func(d *Discrepancy) {
+		glog.Warningf("Reconcile: %s", d)
+		discrepancies = append(discrepancies, d)
+		v.RecordSandboxEvent(d.PodSandboxID, types.SandboxEventReconciled, d.ContainerID, d.Kind+": "+d.Action)
+	}
+
+	knownIDs := make(map[string]bool)
+	for _, container := range containers {
+		containerID := container.GetID()
+		knownIDs[containerID] = true
+		ci, err := container.Retrieve()
+		if err != nil {
+			allErrors = append(allErrors, fmt.Errorf("cannot retrieve container %s: %v", containerID, err))
+			continue
+		}
+		if ci == nil {
+			continue
+		}
+
+		domain := domainsByID[containerID]
+		if domain == nil {
+			action, err := v.reconcileMissingDomain(ci, policy)
+			if err != nil {
+				allErrors = append(allErrors, fmt.Errorf("cannot reconcile container %s: %v", containerID, err))
+			}
+			if action != "" {
+				report(&Discrepancy{
+					Kind:         DiscrepancyMissingDomain,
+					PodSandboxID: ci.Config.PodSandboxID,
+					ContainerID:  containerID,
+					Action:       action,
+				})
+			}
+			continue
+		}
+
+		oldState := ci.State
+		if err := v.syncContainerState(domain, ci); err != nil {
+			allErrors = append(allErrors, fmt.Errorf("cannot sync the state of container %s: %v", containerID, err))
+			continue
+		}
+		if ci.State != oldState {
+			report(&Discrepancy{
+				Kind:         DiscrepancyStateMismatch,
+				PodSandboxID: ci.Config.PodSandboxID,
+				ContainerID:  containerID,
+				Action:       fmt.Sprintf("container state changed from %s to %s", containerStateName(oldState), containerStateName(ci.State)),
+			})
+		}
+	}
+
+	var orphanIDs []string
+	for id := range domainsByID {
+		if !knownIDs[id] && !retained[id] {
+			orphanIDs = append(orphanIDs, id)
+		}
+	}
+	sort.Strings(orphanIDs)
+	for _, id := range orphanIDs {
+		action, err := v.reconcileOrphanDomain(domainsByID[id], id, policy)
+		if err != nil {
+			allErrors = append(allErrors, err)
+		}
+		if action != "" {
+			report(&Discrepancy{
+				Kind:        DiscrepancyOrphanDomain,
+				ContainerID: id,
+				Action:      action,
+			})
+		}
+	}
+
+	sandboxes, err := v.metadataStore.ListPodSandboxes(nil)
+	if err != nil {
+		return discrepancies, append(allErrors, fmt.Errorf("cannot list pod sandboxes: %v", err))
+	}
+	netNsMissing := func(s *types.PodSandboxInfo) bool {
+		return s != nil && s.State == types.PodSandboxState_SANDBOX_READY &&
+			s.ContainerSideNetwork != nil && !v.fsys.IsPathAnNs(s.ContainerSideNetwork.NsPath)
+	}
+	for _, sandbox := range sandboxes {
+		podID := sandbox.GetID()
+		psi, err := sandbox.Retrieve()
+		if err != nil {
+			allErrors = append(allErrors, fmt.Errorf("cannot retrieve pod sandbox %s: %v", podID, err))
+			continue
+		}
+		if !netNsMissing(psi) {
+			continue
+		}
+		// the sandbox may have been changed in the meantime
+		switch err := sandbox.Save(metadata.ExpectPodSandboxRevision(psi.Revision, func(s *types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+			s.State = types.PodSandboxState_SANDBOX_NOTREADY
+			return s, nil
+		})); {
+		case err == metadata.ErrRevisionMismatch:
+			continue
+		case err != nil:
+			allErrors = append(allErrors, fmt.Errorf("cannot reconcile pod sandbox %s: %v", podID, err))
+			continue
+		}
+		report(&Discrepancy{
+			Kind:         DiscrepancyMissingNetNs,
+			PodSandboxID: podID,
+			Action:       "pod sandbox marked as not ready",
+		})
+	}
+
+	return discrepancies, allErrors
+}
+
+// reconcileMissingDomain handles a container record whose domain
+// doesn't exist. It returns the description of the action taken or
+// an empty string if the domain has appeared in the meantime.
+func (v *VirtualizationTool) reconcileMissingDomain(ci *types.ContainerInfo, policy ReconcilePolicy) (string, error) {
+	switch _, err := v.domainConn.LookupDomainByUUIDString(ci.Id); {
+	case err == nil:
+		return "", nil
+	case err != virt.ErrDomainNotFound:
+		return "", fmt.Errorf("error looking up domain %q: %v", ci.Id, err)
+	}
+
+	if policy != ReconcileMarkOrphaned {
+		if err := v.RemoveContainer(ci.Id); err != nil {
+			return "", err
+		}
+		return "container record removed", nil
+	}
+
+	// the container may have been changed or re-created in the
+	// meantime, e.g. by a new CreateContainer() call
+	switch err := v.metadataStore.Container(ci.Id).Save(metadata.ExpectContainerRevision(ci.Revision,
+		func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
+			c.State = types.ContainerState_CONTAINER_EXITED
+			c.Reason = ContainerReasonDomainMissing
+			c.Message = "The domain of the VM doesn't exist"
+			return c, nil
+		},
+	)); {
+	case err == metadata.ErrRevisionMismatch:
+		return "", nil
+	case err != nil:
+		return "", err
+	}
+	return "container record marked as orphaned", nil
+}
+
+// reconcileOrphanDomain handles a domain which has no container
+// record. Only the domains created by Virtlet are handled. It
+// returns the description of the action taken or an empty string if
+// the domain was skipped.
+func (v *VirtualizationTool) reconcileOrphanDomain(domain virt.Domain, id string, policy ReconcilePolicy) (string, error) {
+	name, err := domain.Name()
+	if err != nil {
+		return "", fmt.Errorf("cannot retrieve the name of domain %q: %v", id, err)
+	}
+	if !strings.HasPrefix(name, "virtlet-") {
+		return "", nil
+	}
+
+	if policy != ReconcileRepair {
+		v.keptDomainLock.Lock()
+		defer v.keptDomainLock.Unlock()
+		v.keptDomainIDs[id] = true
+		return fmt.Sprintf("domain %q kept", name), nil
+	}
+
+	// ignore errors from stopping domain - it can be (and probably is) already stopped
+	domain.Destroy()
+	if err := domain.Undefine(); err != nil {
+		return "", fmt.Errorf("cannot undefine domain %q: %v", name, err)
+	}
+	return fmt.Sprintf("domain %q removed", name), nil
+}
+
+// keptDomainIDList returns the UUIDs of the orphan domains kept by
+// Reconcile, which must not be removed by the GC together with their
+// volumes.
+func (v *VirtualizationTool) keptDomainIDList() []string {
+	v.keptDomainLock.Lock()
+	defer v.keptDomainLock.Unlock()
+	var r []string
+	for id := range v.keptDomainIDs {
+		r = append(r, id)
+	}
+	sort.Strings(r)
+	return r
+}
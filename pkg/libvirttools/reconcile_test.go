@@ -0,0 +1,180 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/network"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+type reconcileTest struct {
+	*containerTester
+	sandboxes  []*types.PodSandboxConfig
+	missingID  string
+	orphanID   string
+	mismatchID string
+}
+
+// newReconcileTest sets up a container with a missing domain, an
+// orphan domain, a running container whose domain is shut off and a
+// ready pod sandbox whose network namespace is gone.
+func newReconcileTest(t *testing.T, policy ReconcilePolicy) *reconcileTest {
+	rt := &reconcileTest{
+		containerTester: newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil),
+		sandboxes:       fakemeta.GetSandboxes(4),
+	}
+	rt.virtTool.config.ReconcilePolicy = policy
+	for _, sandbox := range rt.sandboxes {
+		rt.setPodSandbox(sandbox)
+	}
+
+	rt.createContainer(rt.sandboxes[0], nil, nil)
+
+	rt.missingID = rt.createContainer(rt.sandboxes[1], nil, nil)
+	if err := rt.lookupDomain(rt.missingID).Undefine(); err != nil {
+		t.Fatalf("Undefine(): %v", err)
+	}
+
+	rt.orphanID = rt.createContainer(rt.sandboxes[2], nil, nil)
+	if err := rt.metadataStore.Container(rt.orphanID).Save(func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatalf("Container().Save(): %v", err)
+	}
+
+	rt.mismatchID = rt.createContainer(rt.sandboxes[3], nil, nil)
+	rt.startContainer(rt.mismatchID)
+	if err := rt.lookupDomain(rt.mismatchID).Destroy(); err != nil {
+		t.Fatalf("Destroy(): %v", err)
+	}
+
+	if err := rt.metadataStore.PodSandbox(rt.sandboxes[0].Uid).Save(func(psi *types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+		psi.ContainerSideNetwork = &network.ContainerSideNetwork{NsPath: "/var/run/netns/gone"}
+		return psi, nil
+	}); err != nil {
+		t.Fatalf("PodSandbox().Save(): %v", err)
+	}
+
+	return rt
+}
+
+func (rt *reconcileTest) lookupDomain(id string) virt.Domain {
+	domain, err := rt.domainConn.LookupDomainByUUIDString(id)
+	if err != nil {
+		rt.t.Fatalf("LookupDomainByUUIDString(): %v", err)
+	}
+	return domain
+}
+
+func (rt *reconcileTest) reconcile() {
+	discrepancies, errs := rt.virtTool.Reconcile()
+	if len(errs) != 0 {
+		rt.t.Fatalf("Reconcile() returned errors: %v", errs)
+	}
+	var kinds []string
+	for _, d := range discrepancies {
+		kinds = append(kinds, d.Kind+"/"+d.PodSandboxID+"/"+d.ContainerID)
+	}
+	sort.Strings(kinds)
+	expectedKinds := []string{
+		DiscrepancyMissingDomain + "/" + rt.sandboxes[1].Uid + "/" + rt.missingID,
+		DiscrepancyMissingNetNs + "/" + rt.sandboxes[0].Uid + "/",
+		DiscrepancyOrphanDomain + "//" + rt.orphanID,
+		DiscrepancyStateMismatch + "/" + rt.sandboxes[3].Uid + "/" + rt.mismatchID,
+	}
+	if !reflect.DeepEqual(kinds, expectedKinds) {
+		rt.t.Errorf("bad discrepancies: %#v instead of %#v", kinds, expectedKinds)
+	}
+
+	if ci, err := rt.metadataStore.Container(rt.mismatchID).Retrieve(); err != nil {
+		rt.t.Errorf("Container().Retrieve(): %v", err)
+	} else if ci.State != types.ContainerState_CONTAINER_EXITED {
+		rt.t.Errorf("the state of the container with shut off domain was not updated: %v", ci.State)
+	}
+
+	if psi, err := rt.metadataStore.PodSandbox(rt.sandboxes[0].Uid).Retrieve(); err != nil {
+		rt.t.Errorf("PodSandbox().Retrieve(): %v", err)
+	} else if psi.State != types.PodSandboxState_SANDBOX_NOTREADY {
+		rt.t.Errorf("the pod sandbox without network namespace was not marked as not ready")
+	}
+
+	events, err := rt.metadataStore.ListSandboxEvents(rt.sandboxes[1].Uid)
+	if err != nil {
+		rt.t.Fatalf("ListSandboxEvents(): %v", err)
+	}
+	if len(events) == 0 || events[len(events)-1].Type != types.SandboxEventReconciled || events[len(events)-1].ContainerID != rt.missingID {
+		rt.t.Errorf("the discrepancy was not recorded in the sandbox event history: %#v", events)
+	}
+}
+
+func (rt *reconcileTest) domainExists(id string) bool {
+	_, err := rt.domainConn.LookupDomainByUUIDString(id)
+	switch {
+	case err == virt.ErrDomainNotFound:
+		return false
+	case err != nil:
+		rt.t.Fatalf("LookupDomainByUUIDString(): %v", err)
+	}
+	return true
+}
+
+func TestReconcileRepair(t *testing.T) {
+	rt := newReconcileTest(t, ReconcileRepair)
+	defer rt.teardown()
+	rt.reconcile()
+
+	if ci, err := rt.metadataStore.Container(rt.missingID).Retrieve(); err != nil {
+		t.Errorf("Container().Retrieve(): %v", err)
+	} else if ci != nil {
+		t.Errorf("the container with missing domain was not removed")
+	}
+	if rt.domainExists(rt.orphanID) {
+		t.Errorf("the orphan domain was not removed")
+	}
+	if ids := rt.virtTool.keptDomainIDList(); len(ids) != 0 {
+		t.Errorf("unexpected kept domains: %v", ids)
+	}
+}
+
+func TestReconcileMarkOrphaned(t *testing.T) {
+	rt := newReconcileTest(t, ReconcileMarkOrphaned)
+	defer rt.teardown()
+	rt.reconcile()
+
+	if ci, err := rt.metadataStore.Container(rt.missingID).Retrieve(); err != nil {
+		t.Errorf("Container().Retrieve(): %v", err)
+	} else if ci == nil {
+		t.Errorf("the container with missing domain was removed")
+	} else if ci.State != types.ContainerState_CONTAINER_EXITED || ci.Reason != ContainerReasonDomainMissing {
+		t.Errorf("the container with missing domain was not marked as orphaned: %v %q", ci.State, ci.Reason)
+	}
+
+	if !rt.domainExists(rt.orphanID) {
+		t.Errorf("the orphan domain was removed")
+	}
+	// the kept domains are excluded from the GC
+	if ids := rt.virtTool.keptDomainIDList(); !reflect.DeepEqual(ids, []string{rt.orphanID}) {
+		t.Errorf("bad list of kept domains: %v", ids)
+	}
+}
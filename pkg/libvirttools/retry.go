@@ -0,0 +1,106 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	libvirt "github.com/libvirt/libvirt-go"
+)
+
+const (
+	transientRetryAttempts     = 5
+	transientRetryInitialDelay = 200 * time.Millisecond
+	transientRetryMaxDelay     = 3 * time.Second
+)
+
+// transientErrorMessages lists the substrings of libvirt error
+// messages that denote conditions which usually go away by
+// themselves, such as another operation holding the domain or the
+// storage pool. libvirt reports most of these as internal or
+// "operation failed" errors, so the error code alone is not enough.
+var transientErrorMessages = []string{
+	// another job on the domain holds its state change lock
+	"cannot acquire state change lock",
+	// the storage pool is being refreshed or built
+	"has asynchronous jobs running",
+	// the volume is being used by a domain that's being torn down
+	"is still in use",
+	// the previous domain with the same name is still being undefined
+	"already exists with uuid",
+	"is already being",
+	"resource temporarily unavailable",
+	"device or resource busy",
+}
+
+// sleepBeforeRetry and retryJitter are overridden in tests.
+var (
+	sleepBeforeRetry = time.Sleep
+	retryJitter      = rand.Float64
+)
+
+// isTransientError returns true if the error is a libvirt error that
+// is likely to go away if the operation is retried after a short
+// delay. Errors that aren't coming from libvirt are never considered
+// transient.
+func isTransientError(err error) bool {
+	libvirtErr, ok := err.(libvirt.Error)
+	if !ok {
+		return false
+	}
+	switch libvirtErr.Code {
+	case libvirt.ERR_OPERATION_TIMEOUT:
+		return true
+	case libvirt.ERR_INTERNAL_ERROR, libvirt.ERR_OPERATION_FAILED, libvirt.ERR_OPERATION_INVALID, libvirt.ERR_SYSTEM_ERROR:
+		msg := strings.ToLower(libvirtErr.Message)
+		for _, s := range transientErrorMessages {
+			if strings.Contains(msg, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// retryTransient invokes f until it either succeeds or returns an
+// error that's not transient, making at most transientRetryAttempts
+// attempts. The delay between the attempts grows exponentially
+// and is randomized to avoid retrying concurrent operations in lockstep.
+// The error from the last attempt is returned as is, so the callers
+// can still check its code.
+func retryTransient(what string, f func() error) error {
+	delay := transientRetryInitialDelay
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || !isTransientError(err) {
+			return err
+		}
+		if attempt == transientRetryAttempts {
+			glog.Warningf("Giving up %s after %d attempts: %v", what, attempt, err)
+			return err
+		}
+		d := delay/2 + time.Duration(retryJitter()*float64(delay/2))
+		glog.Warningf("Transient error while %s (attempt %d of %d), retrying in %v: %v", what, attempt, transientRetryAttempts, d, err)
+		sleepBeforeRetry(d)
+		if delay *= 2; delay > transientRetryMaxDelay {
+			delay = transientRetryMaxDelay
+		}
+	}
+}
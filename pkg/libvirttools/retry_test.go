@@ -0,0 +1,149 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	libvirt "github.com/libvirt/libvirt-go"
+)
+
+func TestIsTransientError(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{
+			name:      "state change lock timeout",
+			err:       libvirt.Error{Code: libvirt.ERR_OPERATION_TIMEOUT, Message: "Timed out during operation: cannot acquire state change lock"},
+			transient: true,
+		},
+		{
+			name:      "pool refresh in progress",
+			err:       libvirt.Error{Code: libvirt.ERR_INTERNAL_ERROR, Message: "pool 'volumes' has asynchronous jobs running."},
+			transient: true,
+		},
+		{
+			name:      "domain define race",
+			err:       libvirt.Error{Code: libvirt.ERR_OPERATION_FAILED, Message: "domain 'foo' already exists with uuid 2b4c9c5e-4d1b-4c0a-8e2a-3c3f8e0a6b1d"},
+			transient: true,
+		},
+		{
+			name: "unrelated internal error",
+			err:  libvirt.Error{Code: libvirt.ERR_INTERNAL_ERROR, Message: "unexpected domain type kvm"},
+		},
+		{
+			name: "no domain",
+			err:  libvirt.Error{Code: libvirt.ERR_NO_DOMAIN, Message: "Domain not found: cannot acquire state change lock"},
+		},
+		{
+			name: "bad xml",
+			err:  libvirt.Error{Code: libvirt.ERR_XML_ERROR, Message: "XML error: device or resource busy"},
+		},
+		{
+			name: "non-libvirt error",
+			err:  errors.New("cannot acquire state change lock"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if r := isTransientError(tc.err); r != tc.transient {
+				t.Errorf("isTransientError() returned %v for %v", r, tc.err)
+			}
+		})
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	oldSleep, oldJitter := sleepBeforeRetry, retryJitter
+	defer func() {
+		sleepBeforeRetry, retryJitter = oldSleep, oldJitter
+	}()
+	var delays []time.Duration
+	sleepBeforeRetry = func(d time.Duration) { delays = append(delays, d) }
+	retryJitter = func() float64 { return 1 }
+
+	transientErr := libvirt.Error{Code: libvirt.ERR_OPERATION_TIMEOUT, Message: "cannot acquire state change lock"}
+	permanentErr := libvirt.Error{Code: libvirt.ERR_NO_DOMAIN, Message: "Domain not found"}
+	for _, tc := range []struct {
+		name           string
+		errs           []error
+		expectedErr    error
+		expectedCalls  int
+		expectedDelays []time.Duration
+	}{
+		{
+			name:          "success",
+			expectedCalls: 1,
+		},
+		{
+			name:          "permanent error",
+			errs:          []error{permanentErr},
+			expectedErr:   permanentErr,
+			expectedCalls: 1,
+		},
+		{
+			name:           "transient error followed by success",
+			errs:           []error{transientErr, transientErr},
+			expectedCalls:  3,
+			expectedDelays: []time.Duration{200 * time.Millisecond, 400 * time.Millisecond},
+		},
+		{
+			name:           "transient error followed by permanent one",
+			errs:           []error{transientErr, permanentErr},
+			expectedErr:    permanentErr,
+			expectedCalls:  2,
+			expectedDelays: []time.Duration{200 * time.Millisecond},
+		},
+		{
+			name:          "persistent transient error",
+			errs:          []error{transientErr, transientErr, transientErr, transientErr, transientErr, transientErr},
+			expectedErr:   transientErr,
+			expectedCalls: 5,
+			expectedDelays: []time.Duration{
+				200 * time.Millisecond,
+				400 * time.Millisecond,
+				800 * time.Millisecond,
+				1600 * time.Millisecond,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			delays = nil
+			calls := 0
+			err := retryTransient("testing", func() error {
+				calls++
+				if calls <= len(tc.errs) {
+					return tc.errs[calls-1]
+				}
+				return nil
+			})
+			if err != tc.expectedErr {
+				t.Errorf("bad error: %v instead of %v", err, tc.expectedErr)
+			}
+			if calls != tc.expectedCalls {
+				t.Errorf("bad number of calls: %d instead of %d", calls, tc.expectedCalls)
+			}
+			if !reflect.DeepEqual(delays, tc.expectedDelays) {
+				t.Errorf("bad delays: %v instead of %v", delays, tc.expectedDelays)
+			}
+		})
+	}
+}
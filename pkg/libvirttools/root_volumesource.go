@@ -17,6 +17,7 @@ limitations under the License.
 package libvirttools
 
 import (
+	"errors"
 	"fmt"
 
 	libvirtxml "github.com/libvirt/libvirt-go-xml"
@@ -25,6 +26,16 @@ import (
 	"github.com/Mirantis/virtlet/pkg/virt"
 )
 
+const (
+	// overlayRootConfPath is the path of the overlayroot config
+	// file that's injected into the read-only root volume.
+	// overlayroot is included in the initramfs of Ubuntu cloud
+	// images and it mounts the root filesystem with a tmpfs
+	// overlay on top of it before the init is started.
+	overlayRootConfPath = "/etc/overlayroot.local.conf"
+	overlayRootConf     = "overlayroot=\"tmpfs:recurse=0\"\n"
+)
+
 // rootVolume denotes the root disk of the VM
 type rootVolume struct {
 	volumeBase
@@ -36,12 +47,30 @@ var _ VMVolume = &rootVolume{}
 func GetRootVolume(config *types.VMConfig, owner volumeOwner) ([]VMVolume, error) {
 	var vol VMVolume
 	rootDev := config.RootVolumeDevice()
-	if rootDev != nil {
+	var networkSrc *types.NetworkRootVolume
+	if config.ParsedAnnotations != nil {
+		networkSrc = config.ParsedAnnotations.RootVolumeSource
+	}
+	switch {
+	case config.AdoptedDomainXML != "" && (networkSrc != nil || rootDev != nil):
+		return nil, errors.New("the disks of an adopted domain can't be used together with a persistent or network root volume")
+	case config.AdoptedDomainXML != "":
+		return getAdoptedDiskVolumes(config, owner)
+	case rootDev != nil && config.ParsedAnnotations != nil && config.ParsedAnnotations.ReadOnlyRootfs:
+		return nil, errors.New("persistent root volume can't be read-only")
+	case networkSrc != nil && rootDev != nil:
+		return nil, errors.New("network root volume can't be used together with a persistent root volume")
+	case networkSrc != nil:
+		vol = &networkRootVolume{
+			volumeBase: volumeBase{config, owner},
+			src:        networkSrc,
+		}
+	case rootDev != nil:
 		vol = &persistentRootVolume{
 			volumeBase: volumeBase{config, owner},
 			dev:        *rootDev,
 		}
-	} else {
+	default:
 		vol = &rootVolume{
 			volumeBase{config, owner},
 		}
@@ -53,22 +82,26 @@ func (v *rootVolume) volumeName() string {
 	return "virtlet_root_" + v.config.DomainUUID
 }
 
-func (v *rootVolume) createVolume() (virt.StorageVolume, error) {
+// createVolume creates the root volume. It returns true as the
+// second value if the volume is made larger than the image.
+func (v *rootVolume) createVolume() (virt.StorageVolume, bool, error) {
 	imagePath, _, virtualSize, err := v.owner.ImageManager().GetImagePathDigestAndVirtualSize(v.config.Image)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
+	enlarged := false
 	if v.config.ParsedAnnotations != nil && v.config.ParsedAnnotations.RootVolumeSize > 0 &&
 		uint64(v.config.ParsedAnnotations.RootVolumeSize) > virtualSize {
 		virtualSize = uint64(v.config.ParsedAnnotations.RootVolumeSize)
+		enlarged = true
 	}
 
 	storagePool, err := v.owner.StoragePool()
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	return storagePool.CreateStorageVol(&libvirtxml.StorageVolume{
+	vol, err := storagePool.CreateStorageVol(&libvirtxml.StorageVolume{
 		Type: "file",
 		Name: v.volumeName(),
 		Allocation: &libvirtxml.StorageVolumeSize{
@@ -87,14 +120,33 @@ func (v *rootVolume) createVolume() (virt.StorageVolume, error) {
 			Format: &libvirtxml.StorageVolumeTargetFormat{Type: "qcow2"},
 		},
 	})
+	return vol, enlarged, err
 }
 
 func (v *rootVolume) IsDisk() bool { return true }
 
 func (v *rootVolume) UUID() string { return "" }
 
+func (v *rootVolume) PodVolumeName() string { return "root" }
+
+func (v *rootVolume) IsRetainable() bool { return true }
+
 func (v *rootVolume) Setup() (*libvirtxml.DomainDisk, *libvirtxml.DomainFilesystem, error) {
-	vol, err := v.createVolume()
+	vol, err := retainedStorageVolume(v.owner, v.config, v.volumeName())
+	if err != nil {
+		return nil, nil, err
+	}
+	if vol != nil {
+		volPath, err := vol.Path()
+		if err != nil {
+			return nil, nil, fmt.Errorf("error getting root volume path: %v", err)
+		}
+		// the retained volume is already resized and has
+		// the files injected into it
+		return v.diskDef(volPath), nil, nil
+	}
+
+	vol, enlarged, err := v.createVolume()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -104,17 +156,47 @@ func (v *rootVolume) Setup() (*libvirtxml.DomainDisk, *libvirtxml.DomainFilesyst
 		return nil, nil, fmt.Errorf("error getting root volume path: %v", err)
 	}
 
-	if len(v.config.ParsedAnnotations.InjectedFiles) > 0 {
-		if err := v.owner.StorageConnection().PutFiles(volPath, v.config.ParsedAnnotations.InjectedFiles); err != nil {
+	if enlarged && v.config.ParsedAnnotations.RootFSGrowMode == types.RootFSGrowOffline {
+		if err := v.owner.StorageConnection().GrowRootFS(volPath); err != nil {
+			return nil, nil, fmt.Errorf("error growing the root filesystem: %v", err)
+		}
+	}
+
+	files := v.injectedFiles()
+	if len(files) > 0 {
+		if err := v.owner.StorageConnection().PutFiles(volPath, files); err != nil {
 			return nil, nil, fmt.Errorf("error adding files to rootfs: %v", err)
 		}
 	}
 
-	return &libvirtxml.DomainDisk{
+	return v.diskDef(volPath), nil, nil
+}
+
+func (v *rootVolume) diskDef(volPath string) *libvirtxml.DomainDisk {
+	disk := &libvirtxml.DomainDisk{
 		Device: "disk",
 		Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "qcow2"},
 		Source: &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: volPath}},
-	}, nil, nil
+	}
+	if v.config.ParsedAnnotations.ReadOnlyRootfs {
+		disk.ReadOnly = &libvirtxml.DomainDiskReadOnly{}
+	}
+	return disk
+}
+
+// injectedFiles returns the files to be put into the root volume
+// before the VM is started. For a read-only root volume, this
+// includes the overlayroot config unless the user provides
+// their own one.
+func (v *rootVolume) injectedFiles() map[string][]byte {
+	if !v.config.ParsedAnnotations.ReadOnlyRootfs {
+		return v.config.ParsedAnnotations.InjectedFiles
+	}
+	files := map[string][]byte{overlayRootConfPath: []byte(overlayRootConf)}
+	for path, content := range v.config.ParsedAnnotations.InjectedFiles {
+		files[path] = content
+	}
+	return files
 }
 
 func (v *rootVolume) Teardown() error {
@@ -18,12 +18,14 @@ package libvirttools
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 
 	libvirtxml "github.com/libvirt/libvirt-go-xml"
 	digest "github.com/opencontainers/go-digest"
 
 	"github.com/Mirantis/virtlet/pkg/fs"
+	"github.com/Mirantis/virtlet/pkg/metadata"
 	"github.com/Mirantis/virtlet/pkg/metadata/types"
 	"github.com/Mirantis/virtlet/pkg/utils"
 	fakeutils "github.com/Mirantis/virtlet/pkg/utils/fake"
@@ -138,6 +140,7 @@ func TestRootVolumeSize(t *testing.T) {
 	for _, tc := range []struct {
 		name                    string
 		specifiedRootVolumeSize int64
+		rootFSGrowMode          types.RootFSGrowMode
 		expectedVolumeSize      int64
 	}{
 		{
@@ -165,6 +168,18 @@ func TestRootVolumeSize(t *testing.T) {
 			specifiedRootVolumeSize: fakeImageVirtualSize + 10,
 			expectedVolumeSize:      fakeImageVirtualSize + 10,
 		},
+		{
+			name:                    "greater than fakeImageVirtualSize with offline resize",
+			specifiedRootVolumeSize: fakeImageVirtualSize + 10,
+			rootFSGrowMode:          types.RootFSGrowOffline,
+			expectedVolumeSize:      fakeImageVirtualSize + 10,
+		},
+		{
+			name:                    "same as fakeImageVirtualSize with offline resize",
+			specifiedRootVolumeSize: fakeImageVirtualSize,
+			rootFSGrowMode:          types.RootFSGrowOffline,
+			expectedVolumeSize:      fakeImageVirtualSize,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			rootVol, rec, spool := getRootVolumeForTest(t, &types.VMConfig{
@@ -172,6 +187,7 @@ func TestRootVolumeSize(t *testing.T) {
 				Image:      "fake/image1",
 				ParsedAnnotations: &types.VirtletAnnotations{
 					RootVolumeSize: tc.specifiedRootVolumeSize,
+					RootFSGrowMode: tc.rootFSGrowMode,
 				},
 			})
 
@@ -260,21 +276,52 @@ func TestRootVolumeFiles(t *testing.T) {
 	gm.Verify(t, gm.NewYamlVerifier(rec.Content()))
 }
 
+func TestReadOnlyRootVolume(t *testing.T) {
+	rootVol, _, _ := getRootVolumeForTest(t, &types.VMConfig{
+		DomainUUID: testUUID,
+		Image:      "fake/image1",
+		ParsedAnnotations: &types.VirtletAnnotations{
+			ReadOnlyRootfs: true,
+			InjectedFiles: map[string][]byte{
+				"/foo/bar.txt": []byte("bar"),
+			},
+		},
+	})
+
+	disk, _, err := rootVol.Setup()
+	if err != nil {
+		t.Fatalf("Setup returned an error: %v", err)
+	}
+	if disk.ReadOnly == nil {
+		t.Errorf("the root disk is not read-only")
+	}
+
+	expectedFiles := map[string][]byte{
+		"/foo/bar.txt":      []byte("bar"),
+		overlayRootConfPath: []byte(overlayRootConf),
+	}
+	if files := rootVol.injectedFiles(); !reflect.DeepEqual(files, expectedFiles) {
+		t.Errorf("bad injected files: %#v instead of %#v", files, expectedFiles)
+	}
+}
+
 type fakeVolumeOwner struct {
-	sc           *fake.FakeStorageConnection
-	storagePool  *fake.FakeStoragePool
-	imageManager *fakeImageManager
-	commander    *fakeutils.Commander
+	sc            *fake.FakeStorageConnection
+	storagePool   *fake.FakeStoragePool
+	imageManager  *fakeImageManager
+	commander     *fakeutils.Commander
+	metadataStore metadata.Store
 }
 
 var _ volumeOwner = fakeVolumeOwner{}
 
 func newFakeVolumeOwner(sc *fake.FakeStorageConnection, storagePool *fake.FakeStoragePool, imageManager *fakeImageManager, commander *fakeutils.Commander) *fakeVolumeOwner {
 	return &fakeVolumeOwner{
-		sc:           sc,
-		storagePool:  storagePool,
-		imageManager: imageManager,
-		commander:    commander,
+		sc:            sc,
+		storagePool:   storagePool,
+		imageManager:  imageManager,
+		commander:     commander,
+		metadataStore: metadata.NewMemStore(),
 	}
 }
 
@@ -305,3 +352,13 @@ func (vo fakeVolumeOwner) FileSystem() fs.FileSystem { return fs.NullFileSystem
 func (vo fakeVolumeOwner) SharedFilesystemPath() string { return "/var/lib/virtlet/fs" }
 
 func (vo fakeVolumeOwner) Commander() utils.Commander { return vo.commander }
+
+func (vo fakeVolumeOwner) MetadataStore() metadata.Store { return vo.metadataStore }
+
+func (vo fakeVolumeOwner) startProgress(config *types.VMConfig, operation string) *operationProgress {
+	return nil
+}
+
+func (vo fakeVolumeOwner) checkRetainedVolumes(config *types.VMConfig) (bool, bool) {
+	return false, false
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"github.com/Mirantis/virtlet/pkg/metadata"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+// RemovePodSandbox removes the pod sandbox with the specified id
+// along with the containers that are still left in it. The domains
+// and the volumes of these containers are removed first, and then
+// the pod sandbox and the containers are deleted from the metadata
+// store within a single transaction, so a failure can't leave the
+// metadata of a partially removed pod sandbox behind.
+func (v *VirtualizationTool) RemovePodSandbox(podSandboxID string) error {
+	containers, err := v.metadataStore.ListPodContainers(podSandboxID)
+	if err != nil {
+		// There's no such sandbox. Looks like it's already removed
+		glog.V(2).Infof("Can't list containers of pod sandbox %q: %v", podSandboxID, err)
+		containers = nil
+	}
+
+	removedContainers := make(map[string]*types.ContainerInfo)
+	for _, container := range containers {
+		glog.Warningf("Removing container %s that's left in pod sandbox %s", container.GetID(), podSandboxID)
+		containerInfo, err := v.removeContainerDomain(container.GetID())
+		if err != nil {
+			return fmt.Errorf("error removing container %q of pod sandbox %q: %v", container.GetID(), podSandboxID, err)
+		}
+		removedContainers[container.GetID()] = containerInfo
+	}
+
+	var removed *types.PodSandboxInfo
+	if err := v.metadataStore.Update(func(tx metadata.Tx) error {
+		ids, err := tx.PodContainerIDs(podSandboxID)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			containerInfo, found := removedContainers[id]
+			if !found {
+				return fmt.Errorf("container %q was added to pod sandbox %q while it was being removed", id, podSandboxID)
+			}
+			// the revision check catches the containers that
+			// were re-created with the same id in the meantime
+			var revision uint64
+			if containerInfo != nil {
+				revision = containerInfo.Revision
+			}
+			if err := tx.SaveContainer(id, metadata.ExpectContainerRevision(revision, func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+				return nil, nil
+			})); err != nil {
+				return fmt.Errorf("error removing container %q of pod sandbox %q: %v", id, podSandboxID, err)
+			}
+		}
+		return tx.SavePodSandbox(podSandboxID, func(c *types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+			removed = c
+			return nil, nil
+		})
+	}); err != nil {
+		return err
+	}
+
+	for id, containerInfo := range removedContainers {
+		if containerInfo != nil {
+			v.containerRemoved(id, &containerInfo.Config)
+		}
+	}
+	if removed != nil {
+		v.RecordSandboxEvent(podSandboxID, types.SandboxEventRemoved, "", types.SandboxRemovedByKubelet)
+	}
+	v.SaveSandboxTombstone(podSandboxID, removed, types.SandboxRemovedByKubelet)
+	return nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/metadata"
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+)
+
+func TestRemovePodSandbox(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+
+	sandboxes := fakemeta.GetSandboxes(2)
+	for _, sandbox := range sandboxes {
+		ct.setPodSandbox(sandbox)
+	}
+	containerID := ct.createContainer(sandboxes[0], nil, nil)
+	otherContainerID := ct.createContainer(sandboxes[1], nil, nil)
+
+	verifyContainers := func(expectedIDs ...string) {
+		var ids []string
+		for _, id := range []string{containerID, otherContainerID} {
+			ci, err := ct.metadataStore.Container(id).Retrieve()
+			if err != nil {
+				t.Fatalf("Container().Retrieve(): %v", err)
+			}
+			if ci != nil {
+				ids = append(ids, id)
+			}
+		}
+		if !reflect.DeepEqual(ids, expectedIDs) {
+			t.Errorf("bad list of containers: %v instead of %v", ids, expectedIDs)
+		}
+	}
+
+	// the metadata is left intact if the transaction fails
+	store := ct.metadataStore.(*metadata.MemStore)
+	store.SetFaultInjector(func(op, id string) error {
+		if op == "Update" {
+			return errors.New("oops")
+		}
+		return nil
+	})
+	if err := ct.virtTool.RemovePodSandbox(sandboxes[0].Uid); err == nil {
+		t.Errorf("RemovePodSandbox didn't fail")
+	}
+	verifyContainers(containerID, otherContainerID)
+	if psi, err := ct.metadataStore.PodSandbox(sandboxes[0].Uid).Retrieve(); err != nil || psi == nil {
+		t.Errorf("the pod sandbox was removed despite the failure (error: %v)", err)
+	}
+	store.SetFaultInjector(nil)
+
+	if err := ct.virtTool.RemovePodSandbox(sandboxes[0].Uid); err != nil {
+		t.Fatalf("RemovePodSandbox(): %v", err)
+	}
+	verifyContainers(otherContainerID)
+	metas, err := ct.metadataStore.ListPodSandboxes(nil)
+	if err != nil {
+		t.Fatalf("ListPodSandboxes(): %v", err)
+	}
+	if len(metas) != 1 || metas[0].GetID() != sandboxes[1].Uid {
+		t.Errorf("bad list of pod sandboxes after the removal: %v", metas)
+	}
+	if domains, _ := ct.domainConn.ListDomains(); len(domains) != 1 {
+		t.Errorf("Expected a single remaining domain, ListDomains() returned %d of them", len(domains))
+	}
+
+	// removing a nonexistent pod sandbox is not an error
+	if err := ct.virtTool.RemovePodSandbox(sandboxes[0].Uid); err != nil {
+		t.Errorf("RemovePodSandbox() failed for a removed pod sandbox: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"github.com/golang/glog"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/webhook"
+)
+
+// vmSandboxEventTypes maps the VM lifecycle event types to the
+// types of the corresponding pod sandbox events.
+var vmSandboxEventTypes = map[webhook.EventType]string{
+	webhook.EventCreated:   types.SandboxEventVMCreated,
+	webhook.EventStarted:   types.SandboxEventVMStarted,
+	webhook.EventStopped:   types.SandboxEventVMStopped,
+	webhook.EventCrashed:   types.SandboxEventVMCrashed,
+	webhook.EventRemoved:   types.SandboxEventVMRemoved,
+	webhook.EventSuspended: types.SandboxEventVMSuspended,
+	webhook.EventResumed:   types.SandboxEventVMResumed,
+}
+
+// RecordSandboxEvent adds an event with the specified type to the
+// lifecycle event history of the pod sandbox. containerID and
+// message may be empty. The errors are only logged as the history
+// is only used for debugging.
+func (v *VirtualizationTool) RecordSandboxEvent(podID, eventType, containerID, message string) {
+	if podID == "" {
+		return
+	}
+	if err := v.metadataStore.AddSandboxEvent(&types.SandboxEvent{
+		PodSandboxID: podID,
+		Timestamp:    v.clock.Now().UnixNano(),
+		Type:         eventType,
+		ContainerID:  containerID,
+		Message:      message,
+	}); err != nil {
+		glog.Warningf("Can't record %s event for pod sandbox %q: %v", eventType, podID, err)
+	}
+}
+
+// SandboxEvents returns the lifecycle event history of the pod
+// sandbox with the specified id from the oldest to the newest event.
+func (v *VirtualizationTool) SandboxEvents(podID string) ([]*types.SandboxEvent, error) {
+	return v.metadataStore.ListSandboxEvents(podID)
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"os"
+
+	"github.com/golang/glog"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+// saveStartRecord stores the domain definition, the hashes of the
+// cloud-init data and the CNI result used to start the domain in
+// the metadata store. The errors are only logged as the records are
+// only used for debugging.
+func (v *VirtualizationTool) saveStartRecord(containerID string, domain virt.Domain, startErr error) {
+	config, _, err := v.getVMConfigFromMetadata(containerID)
+	if err != nil {
+		glog.Warningf("Can't save start record for domain %q: %v", containerID, err)
+		return
+	}
+	if config == nil {
+		return
+	}
+
+	record := &types.VMStartRecord{
+		Timestamp:    v.clock.Now().UnixNano(),
+		ContainerID:  containerID,
+		PodSandboxID: config.PodSandboxID,
+		PodNamespace: config.PodNamespace,
+		PodName:      config.PodName,
+		Image:        config.Image,
+	}
+	if startErr != nil {
+		record.Error = startErr.Error()
+	}
+	if config.ContainerSideNetwork != nil {
+		record.CNIResult = config.ContainerSideNetwork.Result
+	}
+
+	if domainDef, err := domain.XML(); err != nil {
+		glog.Warningf("Can't get the definition of domain %q: %v", containerID, err)
+	} else if record.DomainXML, err = domainDef.Marshal(); err != nil {
+		glog.Warningf("Can't marshal the definition of domain %q: %v", containerID, err)
+	}
+
+	hashes, err := NewCloudInitGenerator(config, configIsoDir).ContentHashes()
+	switch {
+	case err == nil:
+		record.CloudInitHashes = hashes
+	case !os.IsNotExist(err):
+		glog.Warningf("Can't get cloud-init data hashes for domain %q: %v", containerID, err)
+	}
+
+	if err := v.metadataStore.AddStartRecord(record); err != nil {
+		glog.Warningf("Can't save start record for domain %q: %v", containerID, err)
+	}
+}
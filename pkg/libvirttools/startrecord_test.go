@@ -0,0 +1,75 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+)
+
+func TestStartRecords(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	// soft reboot makes it possible to restart the VM in place
+	sandbox.Annotations["VirtletSoftReboot"] = "true"
+	ct.setPodSandbox(sandbox)
+	containerID := ct.createContainer(sandbox, nil, nil)
+	ct.startContainer(containerID)
+	ct.stopContainer(containerID)
+	ct.clock.Advance(time.Minute)
+	ct.startContainer(containerID)
+
+	records, err := ct.metadataStore.ListStartRecords(sandbox.Namespace, sandbox.Name)
+	if err != nil {
+		t.Fatalf("ListStartRecords(): %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 start records, got %d", len(records))
+	}
+	for n, record := range records {
+		if record.ID != uint64(n+1) {
+			t.Errorf("bad record ID %d, expected %d", record.ID, n+1)
+		}
+		if record.ContainerID != containerID || record.PodSandboxID != sandbox.Uid || record.Image != fakeImageName {
+			t.Errorf("bad start record: %#v", record)
+		}
+		if record.Error != "" {
+			t.Errorf("unexpected error in the start record: %q", record.Error)
+		}
+		if !strings.Contains(record.DomainXML, "<domain") {
+			t.Errorf("bad domain XML in the start record: %q", record.DomainXML)
+		}
+		var hashedFiles []string
+		for name := range record.CloudInitHashes {
+			hashedFiles = append(hashedFiles, name)
+		}
+		sort.Strings(hashedFiles)
+		if strings.Join(hashedFiles, ",") != "meta-data,network-config,user-data" {
+			t.Errorf("bad cloud-init hashes in the start record: %#v", record.CloudInitHashes)
+		}
+	}
+	if records[1].Timestamp-records[0].Timestamp != int64(time.Minute) {
+		t.Errorf("bad start record timestamps: %d, %d", records[0].Timestamp, records[1].Timestamp)
+	}
+}
@@ -52,6 +52,12 @@ var supportedStoragePools = map[string]string{
 	"volumes": "/var/lib/virtlet/volumes",
 }
 
+// StoragePoolPath returns the directory of the storage pool with the
+// specified name or an empty string if the pool is not supported.
+func StoragePoolPath(name string) string {
+	return supportedStoragePools[name]
+}
+
 func ensureStoragePool(conn virt.StorageConnection, name string) (virt.StoragePool, error) {
 	poolDir, found := supportedStoragePools[name]
 	if !found {
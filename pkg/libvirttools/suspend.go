@@ -0,0 +1,124 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/virt"
+	"github.com/Mirantis/virtlet/pkg/webhook"
+)
+
+const guestHookPostResume = "PostResume"
+
+// CheckSuspendedVMs detects the running VMs that have been paused or
+// suspended since the previous check, e.g. using `virsh suspend`,
+// by libvirt upon an I/O error or by the guest OS itself, and the
+// ones that have been resumed. Each change is recorded as an event.
+// The guest clock of a resumed VM lags behind by the time the VM
+// was suspended, so if the guest agent is enabled for the VM, the
+// guest clock is set to the host time and the post-resume guest hook
+// is run, if any. The VMs that are suspended and resumed between
+// the checks aren't noticed.
+func (v *VirtualizationTool) CheckSuspendedVMs() error {
+	containers, err := v.ListContainers(nil)
+	if err != nil {
+		return fmt.Errorf("error listing containers: %v", err)
+	}
+	now := v.clock.Now()
+
+	v.suspendLock.Lock()
+	defer v.suspendLock.Unlock()
+
+	present := make(map[string]bool)
+	for _, c := range containers {
+		if c.State != types.ContainerState_CONTAINER_RUNNING {
+			continue
+		}
+		present[c.Id] = true
+		domain, err := v.domainConn.LookupDomainByUUIDString(c.Id)
+		if err != nil {
+			glog.Warningf("Can't check whether VM %q is suspended: %v", c.Id, err)
+			continue
+		}
+		state, err := domain.State()
+		if err != nil {
+			glog.Warningf("Can't check whether VM %q is suspended: %v", c.Id, err)
+			continue
+		}
+		suspendedAt, suspended := v.suspendedVMs[c.Id]
+		switch {
+		case (state == virt.DomainStatePaused || state == virt.DomainStatePMSuspended) && !suspended:
+			v.suspendedVMs[c.Id] = now
+			v.vmSuspended(c, state)
+		case state == virt.DomainStateRunning && suspended:
+			delete(v.suspendedVMs, c.Id)
+			v.vmResumed(domain, c, now.Sub(suspendedAt))
+		}
+	}
+	for id := range v.suspendedVMs {
+		if !present[id] {
+			delete(v.suspendedVMs, id)
+		}
+	}
+	return nil
+}
+
+// vmSuspended records the events for the VM that has been paused or
+// suspended.
+func (v *VirtualizationTool) vmSuspended(c *types.ContainerInfo, state virt.DomainState) {
+	message := "VM paused"
+	if state == virt.DomainStatePMSuspended {
+		message = "VM suspended by the guest OS"
+	}
+	glog.V(1).Infof("VM %q: %s", c.Id, message)
+	v.eventRecorder.Eventf(&c.Config, v1.EventTypeNormal, "VMSuspended", message)
+	v.notifyLifecycleEvent(webhook.EventSuspended, c.Id, &c.Config)
+}
+
+// vmResumed records the events for the VM that has been resumed and
+// brings its guest clock up to date.
+func (v *VirtualizationTool) vmResumed(domain virt.Domain, c *types.ContainerInfo, suspendedFor time.Duration) {
+	glog.V(1).Infof("VM %q resumed after being suspended for %v", c.Id, suspendedFor)
+	v.eventRecorder.Eventf(&c.Config, v1.EventTypeNormal, "VMResumed", "VM resumed after being suspended for %v", suspendedFor)
+	v.notifyLifecycleEvent(webhook.EventResumed, c.Id, &c.Config)
+
+	va := c.Config.ParsedAnnotations
+	if va == nil || !va.GuestAgent {
+		return
+	}
+	if err := v.syncGuestClock(domain); err != nil {
+		glog.Warningf("Can't set the guest clock of VM %q: %v", c.Id, err)
+		v.eventRecorder.Eventf(&c.Config, v1.EventTypeWarning, "GuestClockSyncFailed", "Failed to set the guest clock after resume: %v", err)
+	}
+	if va.PostResumeHook != "" {
+		if err := v.runGuestHook(domain, &c.Config, guestHookPostResume, va.PostResumeHook, guestHookTimeout(&c.Config)); err != nil {
+			glog.Warningf("Post-resume hook failed for VM %q: %v", c.Id, err)
+		}
+	}
+}
+
+// syncGuestClock sets the guest clock to the host time via the guest
+// agent. The agent also updates the RTC of the VM.
+func (v *VirtualizationTool) syncGuestClock(domain virt.Domain) error {
+	return guestAgentCommand(domain, "guest-set-time", map[string]int64{"time": v.clock.Now().UnixNano()}, nil)
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+	"github.com/Mirantis/virtlet/pkg/virt"
+	"github.com/Mirantis/virtlet/pkg/virt/fake"
+)
+
+func TestCheckSuspendedVMs(t *testing.T) {
+	rec := testutils.NewToplevelRecorder()
+	rec.AddFilter("QemuAgentCommand")
+	ct := newContainerTester(t, rec, nil, nil)
+	defer ct.teardown()
+	recorder := &fakeEventRecorder{}
+	ct.virtTool.SetEventRecorder(recorder)
+	sink := &fakeLifecycleSink{}
+	ct.virtTool.SetLifecycleEventSink(sink)
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	sandbox.Annotations["VirtletGuestAgent"] = "true"
+	sandbox.Annotations["VirtletPostResumeHook"] = "systemctl restart myapp"
+	ct.setPodSandbox(sandbox)
+	containerID := ct.createContainer(sandbox, nil, nil)
+	ct.startContainer(containerID)
+	sink.events = nil
+
+	domain, err := ct.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		t.Fatalf("LookupDomainByUUIDString(): %v", err)
+	}
+	check := func() {
+		if err := ct.virtTool.CheckSuspendedVMs(); err != nil {
+			t.Fatalf("CheckSuspendedVMs(): %v", err)
+		}
+	}
+
+	check()
+	domain.(*fake.FakeDomain).SetState(virt.DomainStatePaused)
+	check()
+	// the paused VM is not considered to be exited
+	if container := ct.containerInfo(containerID); container.State != types.ContainerState_CONTAINER_RUNNING {
+		t.Errorf("Bad container state of the paused VM: %v instead of %v", container.State, types.ContainerState_CONTAINER_RUNNING)
+	}
+	ct.clock.Advance(time.Minute)
+	check()
+	domain.(*fake.FakeDomain).SetState(virt.DomainStateRunning)
+	check()
+	check()
+
+	expectedEvents := []string{
+		fmt.Sprintf("%s/%s Normal VMSuspended: VM paused", sandbox.Namespace, sandbox.Name),
+		fmt.Sprintf("%s/%s Normal VMResumed: VM resumed after being suspended for 1m0s", sandbox.Namespace, sandbox.Name),
+		fmt.Sprintf(`%s/%s Normal PostResumeHookSucceeded: PostResume hook "systemctl restart myapp" succeeded, output: ""`, sandbox.Namespace, sandbox.Name),
+	}
+	if !reflect.DeepEqual(recorder.events, expectedEvents) {
+		t.Errorf("bad events:\n%#v\ninstead of\n%#v", recorder.events, expectedEvents)
+	}
+
+	var expectedLifecycleEvents []string
+	for _, eventType := range []string{"suspended", "resumed"} {
+		expectedLifecycleEvents = append(expectedLifecycleEvents, fmt.Sprintf("%s %s/%s %s %s", eventType, sandbox.Namespace, sandbox.Name, containerID, fakeImageName))
+	}
+	if !reflect.DeepEqual(sink.events, expectedLifecycleEvents) {
+		t.Errorf("bad lifecycle events:\n%#v\ninstead of\n%#v", sink.events, expectedLifecycleEvents)
+	}
+
+	// the guest clock is set to the host time before the hook is run
+	var commands []string
+	for _, r := range rec.Content() {
+		cmd := r.Value.(map[string]interface{})
+		commands = append(commands, cmd["execute"].(string))
+		if cmd["execute"] == "guest-set-time" {
+			args := cmd["arguments"].(map[string]interface{})
+			if args["time"] != float64(ct.clock.Now().UnixNano()) {
+				t.Errorf("bad time passed to guest-set-time: %v", args["time"])
+			}
+		}
+	}
+	if expectedCommands := []string{"guest-set-time", "guest-ping", "guest-exec", "guest-exec-status"}; !reflect.DeepEqual(commands, expectedCommands) {
+		t.Errorf("bad guest agent commands: %#v instead of %#v", commands, expectedCommands)
+	}
+}
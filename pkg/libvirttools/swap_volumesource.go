@@ -0,0 +1,101 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/utils"
+)
+
+const (
+	swapVolumePrefix = "virtlet_swap_"
+)
+
+// swapVolumeUUID returns the UUID of the swap volume of the VM.
+func swapVolumeUUID(config *types.VMConfig) string {
+	return utils.NewUUID5(ContainerNsUUID, config.PodSandboxID+":swap")
+}
+
+// swapVolume denotes an ephemeral disk that's used as the swap
+// space by the VM
+type swapVolume struct {
+	volumeBase
+}
+
+var _ VMVolume = &swapVolume{}
+
+// GetSwapVolume returns the swap volume for the VM if the pod
+// annotations request a swap disk.
+func GetSwapVolume(config *types.VMConfig, owner volumeOwner) ([]VMVolume, error) {
+	if config.ParsedAnnotations == nil || config.ParsedAnnotations.Swap == nil ||
+		config.ParsedAnnotations.Swap.Type != types.SwapDisk {
+		return nil, nil
+	}
+	return []VMVolume{&swapVolume{volumeBase{config, owner}}}, nil
+}
+
+func (v *swapVolume) volumeName() string {
+	return swapVolumePrefix + v.config.DomainUUID
+}
+
+func (v *swapVolume) IsDisk() bool { return true }
+
+func (v *swapVolume) UUID() string { return swapVolumeUUID(v.config) }
+
+func (v *swapVolume) PodVolumeName() string { return "swap" }
+
+func (v *swapVolume) Setup() (*libvirtxml.DomainDisk, *libvirtxml.DomainFilesystem, error) {
+	storagePool, err := v.owner.StoragePool()
+	if err != nil {
+		return nil, nil, err
+	}
+	// the volume is initialized as swap space by cloud-init
+	// inside the VM
+	vol, err := storagePool.CreateStorageVol(&libvirtxml.StorageVolume{
+		Type:       "file",
+		Name:       v.volumeName(),
+		Allocation: &libvirtxml.StorageVolumeSize{Unit: "b", Value: 0},
+		Capacity:   &libvirtxml.StorageVolumeSize{Unit: "b", Value: uint64(v.config.ParsedAnnotations.Swap.Size)},
+		Target:     &libvirtxml.StorageVolumeTarget{Format: &libvirtxml.StorageVolumeTargetFormat{Type: "qcow2"}},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating swap volume: %v", err)
+	}
+
+	volPath, err := vol.Path()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting swap volume path: %v", err)
+	}
+
+	return &libvirtxml.DomainDisk{
+		Device: "disk",
+		Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "qcow2"},
+		Source: &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: volPath}},
+	}, nil, nil
+}
+
+func (v *swapVolume) Teardown() error {
+	storagePool, err := v.owner.StoragePool()
+	if err != nil {
+		return err
+	}
+	return storagePool.RemoveVolumeByName(v.volumeName())
+}
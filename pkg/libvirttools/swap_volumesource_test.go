@@ -0,0 +1,101 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	fakeutils "github.com/Mirantis/virtlet/pkg/utils/fake"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+	"github.com/Mirantis/virtlet/pkg/virt/fake"
+)
+
+func TestSwapVolume(t *testing.T) {
+	rec := testutils.NewToplevelRecorder()
+	sc := fake.NewFakeStorageConnection(rec)
+	spool, err := sc.CreateStoragePool(&libvirtxml.StoragePool{
+		Name:   "volumes",
+		Target: &libvirtxml.StoragePoolTarget{Path: "/fake/volumes/pool"},
+	})
+	if err != nil {
+		t.Fatalf("CreateStoragePool(): %v", err)
+	}
+	owner := newFakeVolumeOwner(sc, spool.(*fake.FakeStoragePool), newFakeImageManager(rec.Child("image")), fakeutils.NewCommander(nil, nil))
+
+	for _, swap := range []*types.SwapSettings{
+		nil,
+		{Type: types.SwapZram, Size: 1073741824},
+	} {
+		volumes, err := GetSwapVolume(&types.VMConfig{
+			DomainUUID:        testUUID,
+			ParsedAnnotations: &types.VirtletAnnotations{Swap: swap},
+		}, owner)
+		if err != nil {
+			t.Fatalf("GetSwapVolume(): %v", err)
+		}
+		if len(volumes) != 0 {
+			t.Errorf("unexpected swap volumes for swap settings %#v", swap)
+		}
+	}
+
+	volumes, err := GetSwapVolume(&types.VMConfig{
+		DomainUUID: testUUID,
+		ParsedAnnotations: &types.VirtletAnnotations{
+			Swap: &types.SwapSettings{Type: types.SwapDisk, Size: 1073741824},
+		},
+	}, owner)
+	if err != nil {
+		t.Fatalf("GetSwapVolume(): %v", err)
+	}
+	if len(volumes) != 1 {
+		t.Fatalf("expected exactly one swap volume, got %d", len(volumes))
+	}
+
+	disk, fs, err := volumes[0].Setup()
+	if err != nil {
+		t.Fatalf("Setup(): %v", err)
+	}
+	if fs != nil {
+		t.Errorf("didn't expect a filesystem")
+	}
+	expectedPath := "/fake/volumes/pool/virtlet_swap_" + testUUID
+	if disk.Source.File == nil || disk.Source.File.File != expectedPath {
+		t.Errorf("bad swap disk source: %#v", disk.Source)
+	}
+
+	vol, err := spool.LookupVolumeByName("virtlet_swap_" + testUUID)
+	if err != nil {
+		t.Fatalf("swap volume not found: %v", err)
+	}
+	size, err := vol.Size()
+	if err != nil {
+		t.Fatalf("Size(): %v", err)
+	}
+	if size != 1073741824 {
+		t.Errorf("bad swap volume size %d", size)
+	}
+
+	if err := volumes[0].Teardown(); err != nil {
+		t.Fatalf("Teardown(): %v", err)
+	}
+	if _, err := spool.LookupVolumeByName("virtlet_swap_" + testUUID); err == nil {
+		t.Errorf("swap volume wasn't removed")
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+// SaveSandboxTombstone records the removal of the pod sandbox with
+// the specified id and data in the metadata store, so it's possible
+// to find out what has happened to the pod for some time after the
+// removal. The ids of the containers that were started in the
+// sandbox are taken from the start records. The errors are only
+// logged as the tombstones are only used for debugging.
+func (v *VirtualizationTool) SaveSandboxTombstone(podID string, psi *types.PodSandboxInfo, reason string) {
+	if psi == nil || v.config.SandboxTombstoneTTL <= 0 {
+		return
+	}
+	tombstone := &types.SandboxTombstone{
+		PodSandboxID: podID,
+		CreatedAt:    psi.CreatedAt,
+		DeletedAt:    v.clock.Now().UnixNano(),
+		Reason:       reason,
+	}
+	if psi.Config != nil {
+		tombstone.PodNamespace = psi.Config.Namespace
+		tombstone.PodName = psi.Config.Name
+		tombstone.PodUID = psi.Config.Uid
+	}
+	if tombstone.PodName != "" {
+		records, err := v.metadataStore.ListStartRecords(tombstone.PodNamespace, tombstone.PodName)
+		if err != nil {
+			glog.Warningf("Can't get start records for pod sandbox %q: %v", podID, err)
+		}
+		seen := make(map[string]bool)
+		for _, record := range records {
+			if record.PodSandboxID == podID && !seen[record.ContainerID] {
+				seen[record.ContainerID] = true
+				tombstone.ContainerIDs = append(tombstone.ContainerIDs, record.ContainerID)
+			}
+		}
+	}
+	if err := v.metadataStore.AddSandboxTombstone(tombstone); err != nil {
+		glog.Warningf("Can't save tombstone for pod sandbox %q: %v", podID, err)
+	}
+}
+
+// pruneSandboxTombstones removes the sandbox tombstones that are
+// older than the configured TTL. If the tombstones are disabled,
+// all of them are removed.
+func (v *VirtualizationTool) pruneSandboxTombstones() error {
+	before := v.clock.Now().Add(-v.config.SandboxTombstoneTTL).UnixNano()
+	n, err := v.metadataStore.PruneSandboxTombstones(before)
+	if err != nil {
+		return fmt.Errorf("cannot prune sandbox tombstones: %v", err)
+	}
+	if n > 0 {
+		glog.V(2).Infof("Removed %d expired sandbox tombstone(s)", n)
+	}
+	return nil
+}
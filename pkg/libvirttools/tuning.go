@@ -0,0 +1,319 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const (
+	isolatedCPUsPath = "/sys/devices/system/cpu/isolated"
+	onlineCPUsPath   = "/sys/devices/system/cpu/online"
+
+	// minCPUPMHypervisorVersion is the first QEMU version that
+	// supports -overcommit cpu-pm=on, i.e. 3.0.0
+	minCPUPMHypervisorVersion = 3000000
+)
+
+// hostCPUs returns the host CPUs that are available for vCPU
+// pinning. The CPUs isolated from the scheduler using isolcpus
+// kernel option are preferred as no other tasks run on them.
+// It's a variable so it can be overridden in tests.
+var hostCPUs = func() ([]int, error) {
+	for _, path := range []string{isolatedCPUsPath, onlineCPUsPath} {
+		data, err := ioutil.ReadFile(path)
+		switch {
+		case os.IsNotExist(err):
+			continue
+		case err != nil:
+			return nil, err
+		}
+		cpus, err := parseCPUList(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %q: %v", path, err)
+		}
+		if len(cpus) > 0 {
+			return cpus, nil
+		}
+	}
+	cpus := make([]int, runtime.NumCPU())
+	for i := range cpus {
+		cpus[i] = i
+	}
+	return cpus, nil
+}
+
+// parseCPUList parses a CPU list in the format used by the kernel,
+// e.g. "0-3,8,10-11".
+func parseCPUList(s string) ([]int, error) {
+	var r []int
+	if s == "" {
+		return nil, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.SplitN(item, "-", 2)
+		first, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("bad cpu list item %q", item)
+		}
+		last := first
+		if len(parts) == 2 {
+			if last, err = strconv.Atoi(parts[1]); err != nil || last < first {
+				return nil, fmt.Errorf("bad cpu list item %q", item)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			r = append(r, cpu)
+		}
+	}
+	return r, nil
+}
+
+// applyTuningProfile updates the domain definition according to the
+// specified tuning profile. The throughput profile only changes the
+// disk queue settings, which are handled by configureDiskQueues().
+// If cpuset is not empty, e.g. when the CPUs are assigned to the pod
+// by the kubelet CPU manager, the vCPUs are only pinned to these CPUs.
+// The caller must hold cpuPinLock until the domain is defined, as
+// the host CPUs are allocated based on the pinning of the existing
+// domains.
+func (v *VirtualizationTool) applyTuningProfile(domain *libvirtxml.Domain, profile types.TuningProfile, cpuset string) error {
+	if profile != types.TuningProfileLatency {
+		return nil
+	}
+	var cpus []int
+	dedicated := false
+	if domain.VCPU != nil {
+		available, err := v.pinnableCPUs(cpuset)
+		if err != nil {
+			return err
+		}
+		usage, err := v.hostCPUUsage()
+		if err != nil {
+			return err
+		}
+		cpus, dedicated = allocateCPUs(available, usage, domain.VCPU.Value)
+		if cpus == nil {
+			glog.Warningf("Not pinning the vCPUs of domain %q: %d vCPUs, but only %d host CPUs available", domain.Name, domain.VCPU.Value, len(available))
+		}
+	}
+	applyLatencyProfile(domain, cpus, dedicated && v.guestCPUPMSupported())
+	return nil
+}
+
+// pinnableCPUs returns the host CPUs the vCPUs can be pinned to,
+// which are taken from cpuset if it's not empty.
+func (v *VirtualizationTool) pinnableCPUs(cpuset string) ([]int, error) {
+	if cpuset == "" {
+		cpus, err := hostCPUs()
+		if err != nil {
+			return nil, fmt.Errorf("can't get the host CPUs: %v", err)
+		}
+		return cpus, nil
+	}
+	cpus, err := parseCPUList(cpuset)
+	if err != nil {
+		return nil, fmt.Errorf("bad cpuset %q: %v", cpuset, err)
+	}
+	return cpus, nil
+}
+
+// guestCPUPMSupported returns true if the hypervisor supports
+// letting the guest halt the idle vCPUs by itself.
+func (v *VirtualizationTool) guestCPUPMSupported() bool {
+	version, err := v.domainConn.HypervisorVersion()
+	if err != nil {
+		glog.Warningf("Can't get the hypervisor version: %v", err)
+		return false
+	}
+	return version >= minCPUPMHypervisorVersion
+}
+
+// hostCPUUsage returns the number of vCPUs pinned to each host CPU
+// by the existing domains.
+func (v *VirtualizationTool) hostCPUUsage() (map[int]int, error) {
+	domains, err := v.domainConn.ListDomains()
+	if err != nil {
+		return nil, fmt.Errorf("error listing domains: %v", err)
+	}
+	usage := make(map[int]int)
+	for _, d := range domains {
+		def, err := d.XML()
+		if err != nil {
+			return nil, fmt.Errorf("error getting domain xml: %v", err)
+		}
+		if def.CPUTune == nil {
+			continue
+		}
+		for _, pin := range def.CPUTune.VCPUPin {
+			cpus, err := parseCPUList(pin.CPUSet)
+			if err != nil {
+				return nil, fmt.Errorf("domain %q: %v", def.Name, err)
+			}
+			for _, cpu := range cpus {
+				usage[cpu]++
+			}
+		}
+	}
+	return usage, nil
+}
+
+// allocateCPUs picks a host CPU for each of the n vCPUs, preferring
+// the least used CPUs. The usage map is the number of vCPUs that
+// are already pinned to each CPU. The returned flag is true if
+// none of the picked CPUs is used by another vCPU. No CPUs are
+// picked if there are more vCPUs than available CPUs, as the vCPUs
+// of the VM would compete for the same CPUs.
+func allocateCPUs(available []int, usage map[int]int, n int) ([]int, bool) {
+	if len(available) < n || n <= 0 {
+		return nil, false
+	}
+	used := make(map[int]int)
+	for _, cpu := range available {
+		used[cpu] = usage[cpu]
+	}
+	candidates := append([]int(nil), available...)
+	r := make([]int, n)
+	dedicated := true
+	for i := range r {
+		sort.SliceStable(candidates, func(a, b int) bool {
+			return used[candidates[a]] < used[candidates[b]]
+		})
+		cpu := candidates[0]
+		if used[cpu] > 0 {
+			dedicated = false
+		}
+		used[cpu]++
+		r[i] = cpu
+	}
+	return r, dedicated
+}
+
+// applyLatencyProfile pins the vCPUs of the domain to the specified
+// host CPUs. If cpuPM is true, which must only be the case if the
+// CPUs are dedicated to the domain, the idle vCPUs are made to halt
+// without leaving the guest, so the wakeups don't suffer from the
+// host-side halt polling latency.
+func applyLatencyProfile(domain *libvirtxml.Domain, cpus []int, cpuPM bool) {
+	// An explicit CPU setting from the pod annotations or the
+	// Virtlet config takes precedence over the profile
+	if domain.CPU == nil {
+		domain.CPU = &libvirtxml.DomainCPU{Mode: "host-passthrough"}
+	}
+
+	if len(cpus) > 0 {
+		if domain.CPUTune == nil {
+			domain.CPUTune = &libvirtxml.DomainCPUTune{}
+		}
+		for i, cpu := range cpus {
+			domain.CPUTune.VCPUPin = append(domain.CPUTune.VCPUPin, libvirtxml.DomainCPUTuneVCPUPin{
+				VCPU:   uint(i),
+				CPUSet: strconv.Itoa(cpu),
+			})
+		}
+	}
+
+	if cpuPM {
+		if domain.QEMUCommandline == nil {
+			domain.QEMUCommandline = &libvirtxml.DomainQEMUCommandline{}
+		}
+		domain.QEMUCommandline.Args = append(domain.QEMUCommandline.Args,
+			libvirtxml.DomainQEMUCommandlineArg{Value: "-overcommit"},
+			libvirtxml.DomainQEMUCommandlineArg{Value: "cpu-pm=on"})
+	}
+
+	// Memory ballooning causes unpredictable latencies
+	domain.Devices.MemBalloon = &libvirtxml.DomainMemBalloon{Model: "none"}
+}
+
+// configureDiskQueues sets up multi-queue IO and the iothreads for
+// the virtio disks and the virtio-scsi controllers of the domain.
+// queueCount and ioThreadCount override the automatically chosen
+// values if they're non-zero. By default, there's a queue per vCPU
+// and an iothread per virtio disk, but no more iothreads than vCPUs.
+// The virtio-scsi controllers share the iothreads with the virtio
+// disks, as they only handle the config CD-ROM when the virtio disk
+// driver is used. The single-vCPU VMs are left with the defaults
+// unless force is true or the values are specified explicitly.
+// It must be called after the disks are added to the domain.
+func configureDiskQueues(domain *libvirtxml.Domain, queueCount, ioThreadCount int, force bool) {
+	vcpus := 1
+	if domain.VCPU != nil && domain.VCPU.Value > 1 {
+		vcpus = domain.VCPU.Value
+	}
+	if !force && vcpus == 1 && queueCount == 0 && ioThreadCount == 0 {
+		return
+	}
+
+	var disks, controllers []int
+	for n, d := range domain.Devices.Disks {
+		if d.Target != nil && d.Target.Bus == "virtio" && d.Driver != nil {
+			disks = append(disks, n)
+		}
+	}
+	for n, c := range domain.Devices.Controllers {
+		if c.Type == "scsi" && c.Model == "virtio-scsi" {
+			controllers = append(controllers, n)
+		}
+	}
+	if len(disks) == 0 && len(controllers) == 0 {
+		return
+	}
+
+	if queueCount == 0 {
+		queueCount = vcpus
+	}
+	if ioThreadCount == 0 {
+		ioThreadCount = len(disks)
+		if ioThreadCount > vcpus {
+			ioThreadCount = vcpus
+		}
+		if ioThreadCount == 0 {
+			ioThreadCount = 1
+		}
+	}
+	domain.IOThreads = uint(ioThreadCount)
+
+	queues := uint(queueCount)
+	n := 0
+	nextIOThread := func() *uint {
+		ioThread := uint(n%ioThreadCount + 1)
+		n++
+		return &ioThread
+	}
+	for _, i := range disks {
+		domain.Devices.Disks[i].Driver.IOThread = nextIOThread()
+		domain.Devices.Disks[i].Driver.Queues = &queues
+	}
+	for _, i := range controllers {
+		domain.Devices.Controllers[i].Driver = &libvirtxml.DomainControllerDriver{
+			Queues:   &queues,
+			IOThread: nextIOThread(),
+		}
+	}
+}
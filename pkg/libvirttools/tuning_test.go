@@ -0,0 +1,387 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+)
+
+func newTuningTestDomain(vcpus int) *libvirtxml.Domain {
+	scsiControllerIndex := uint(0)
+	return &libvirtxml.Domain{
+		VCPU: &libvirtxml.DomainVCPU{Value: vcpus},
+		Devices: &libvirtxml.DomainDeviceList{
+			Controllers: []libvirtxml.DomainController{
+				{Type: "scsi", Index: &scsiControllerIndex, Model: "virtio-scsi"},
+			},
+			Disks: []libvirtxml.DomainDisk{
+				{
+					Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "qcow2"},
+					Target: &libvirtxml.DomainDiskTarget{Dev: "vda", Bus: "virtio"},
+				},
+				{
+					Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "raw"},
+					Target: &libvirtxml.DomainDiskTarget{Dev: "sda", Bus: "scsi"},
+				},
+			},
+		},
+	}
+}
+
+func TestParseCPUList(t *testing.T) {
+	for _, tc := range []struct {
+		list        string
+		expected    []int
+		expectError bool
+	}{
+		{list: ""},
+		{list: "3", expected: []int{3}},
+		{list: "0-3,8,10-11", expected: []int{0, 1, 2, 3, 8, 10, 11}},
+		{list: "3-1", expectError: true},
+		{list: "a-b", expectError: true},
+	} {
+		cpus, err := parseCPUList(tc.list)
+		switch {
+		case tc.expectError && err == nil:
+			t.Errorf("%q: didn't get an expected error", tc.list)
+		case !tc.expectError && err != nil:
+			t.Errorf("%q: parseCPUList(): %v", tc.list, err)
+		case !reflect.DeepEqual(cpus, tc.expected):
+			t.Errorf("%q: bad cpu list %v instead of %v", tc.list, cpus, tc.expected)
+		}
+	}
+}
+
+func TestAllocateCPUs(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		available         []int
+		usage             map[int]int
+		n                 int
+		expectedCPUs      []int
+		expectedDedicated bool
+	}{
+		{
+			name:              "free cpus",
+			available:         []int{2, 3, 4, 5},
+			n:                 2,
+			expectedCPUs:      []int{2, 3},
+			expectedDedicated: true,
+		},
+		{
+			name:              "cpus used by other vms are skipped",
+			available:         []int{2, 3, 4, 5},
+			usage:             map[int]int{0: 3, 2: 1, 3: 1},
+			n:                 2,
+			expectedCPUs:      []int{4, 5},
+			expectedDedicated: true,
+		},
+		{
+			name:         "least used cpus are shared",
+			available:    []int{2, 3, 4},
+			usage:        map[int]int{2: 2, 3: 1},
+			n:            3,
+			expectedCPUs: []int{4, 3, 4},
+		},
+		{
+			name:      "more vcpus than host cpus",
+			available: []int{0, 1},
+			n:         3,
+		},
+		{
+			name: "no host cpus",
+			n:    2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cpus, dedicated := allocateCPUs(tc.available, tc.usage, tc.n)
+			if !reflect.DeepEqual(cpus, tc.expectedCPUs) {
+				t.Errorf("bad cpus %v instead of %v", cpus, tc.expectedCPUs)
+			}
+			if dedicated != tc.expectedDedicated {
+				t.Errorf("bad dedicated flag %v", dedicated)
+			}
+		})
+	}
+}
+
+func TestLatencyProfile(t *testing.T) {
+	t.Run("dedicated cpus", func(t *testing.T) {
+		domain := newTuningTestDomain(2)
+		applyLatencyProfile(domain, []int{4, 5}, true)
+		if domain.CPU == nil || domain.CPU.Mode != "host-passthrough" {
+			t.Errorf("bad cpu setting: %#v", domain.CPU)
+		}
+		expectedPins := []libvirtxml.DomainCPUTuneVCPUPin{
+			{VCPU: 0, CPUSet: "4"},
+			{VCPU: 1, CPUSet: "5"},
+		}
+		if domain.CPUTune == nil || !reflect.DeepEqual(domain.CPUTune.VCPUPin, expectedPins) {
+			t.Errorf("bad vcpu pinning: %#v", domain.CPUTune)
+		}
+		expectedArgs := []libvirtxml.DomainQEMUCommandlineArg{
+			{Value: "-overcommit"},
+			{Value: "cpu-pm=on"},
+		}
+		if domain.QEMUCommandline == nil || !reflect.DeepEqual(domain.QEMUCommandline.Args, expectedArgs) {
+			t.Errorf("bad qemu command line: %#v", domain.QEMUCommandline)
+		}
+		if domain.Devices.MemBalloon == nil || domain.Devices.MemBalloon.Model != "none" {
+			t.Errorf("memory balloon not disabled: %#v", domain.Devices.MemBalloon)
+		}
+	})
+
+	t.Run("shared cpus", func(t *testing.T) {
+		domain := newTuningTestDomain(2)
+		applyLatencyProfile(domain, []int{4, 4}, false)
+		if domain.CPUTune == nil || len(domain.CPUTune.VCPUPin) != 2 {
+			t.Errorf("bad vcpu pinning: %#v", domain.CPUTune)
+		}
+		if domain.QEMUCommandline != nil {
+			t.Errorf("vcpus sharing host cpus must not use cpu-pm: %#v", domain.QEMUCommandline)
+		}
+	})
+}
+
+func TestLatencyProfileCPUAllocation(t *testing.T) {
+	oldHostCPUs := hostCPUs
+	defer func() { hostCPUs = oldHostCPUs }()
+	hostCPUs = func() ([]int, error) { return []int{2, 3, 4, 5}, nil }
+
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+
+	vcpuPins := func(containerID string) []string {
+		domain, err := ct.domainConn.LookupDomainByUUIDString(containerID)
+		if err != nil {
+			t.Fatalf("LookupDomainByUUIDString(): %v", err)
+		}
+		def, err := domain.XML()
+		if err != nil {
+			t.Fatalf("XML(): %v", err)
+		}
+		if def.CPUTune == nil {
+			return nil
+		}
+		var r []string
+		for _, pin := range def.CPUTune.VCPUPin {
+			r = append(r, pin.CPUSet)
+		}
+		return r
+	}
+
+	sandboxes := fakemeta.GetSandboxes(3)
+	var containerIDs []string
+	for _, sandbox := range sandboxes {
+		sandbox.Annotations["VirtletTuningProfile"] = "latency"
+		sandbox.Annotations["VirtletVCPUCount"] = "2"
+		ct.setPodSandbox(sandbox)
+	}
+	for _, sandbox := range sandboxes[:2] {
+		containerIDs = append(containerIDs, ct.createContainer(sandbox, nil, nil))
+	}
+	if pins := vcpuPins(containerIDs[0]); !reflect.DeepEqual(pins, []string{"2", "3"}) {
+		t.Errorf("bad vcpu pinning of the first VM: %v", pins)
+	}
+	if pins := vcpuPins(containerIDs[1]); !reflect.DeepEqual(pins, []string{"4", "5"}) {
+		t.Errorf("bad vcpu pinning of the second VM: %v", pins)
+	}
+
+	// the CPUs of the removed VM are reused
+	ct.removeContainer(containerIDs[0])
+	containerID := ct.createContainer(sandboxes[2], nil, nil)
+	if pins := vcpuPins(containerID); !reflect.DeepEqual(pins, []string{"2", "3"}) {
+		t.Errorf("bad vcpu pinning of the third VM: %v", pins)
+	}
+}
+
+func TestLatencyProfileHostSettings(t *testing.T) {
+	oldHostCPUs := hostCPUs
+	defer func() { hostCPUs = oldHostCPUs }()
+	hostCPUs = func() ([]int, error) { return []int{0, 1, 2, 3, 4, 5, 6, 7}, nil }
+
+	for _, tc := range []struct {
+		name              string
+		vcpus             int
+		cpuset            string
+		hypervisorVersion uint32
+		expectedPins      []string
+		expectCPUPM       bool
+	}{
+		{
+			name:              "host cpus",
+			vcpus:             2,
+			hypervisorVersion: 3001000,
+			expectedPins:      []string{"0", "1"},
+			expectCPUPM:       true,
+		},
+		{
+			name:              "cpuset",
+			vcpus:             2,
+			cpuset:            "6-7",
+			hypervisorVersion: 3001000,
+			expectedPins:      []string{"6", "7"},
+			expectCPUPM:       true,
+		},
+		{
+			name:              "more vcpus than cpus in the cpuset",
+			vcpus:             4,
+			cpuset:            "6-7",
+			hypervisorVersion: 3001000,
+		},
+		{
+			name:              "old qemu",
+			vcpus:             2,
+			hypervisorVersion: 2012000,
+			expectedPins:      []string{"0", "1"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+			defer ct.teardown()
+			ct.domainConn.SetHypervisorVersion(tc.hypervisorVersion)
+
+			domain := newTuningTestDomain(tc.vcpus)
+			if err := ct.virtTool.applyTuningProfile(domain, types.TuningProfileLatency, tc.cpuset); err != nil {
+				t.Fatalf("applyTuningProfile(): %v", err)
+			}
+			var pins []string
+			if domain.CPUTune != nil {
+				for _, pin := range domain.CPUTune.VCPUPin {
+					pins = append(pins, pin.CPUSet)
+				}
+			}
+			if !reflect.DeepEqual(pins, tc.expectedPins) {
+				t.Errorf("bad vcpu pinning %v instead of %v", pins, tc.expectedPins)
+			}
+			if cpuPM := domain.QEMUCommandline != nil; cpuPM != tc.expectCPUPM {
+				t.Errorf("bad qemu command line: %#v", domain.QEMUCommandline)
+			}
+		})
+	}
+}
+
+func TestConfigureDiskQueues(t *testing.T) {
+	newDomain := func(vcpus, virtioDisks int) *libvirtxml.Domain {
+		domain := newTuningTestDomain(vcpus)
+		for i := 1; i < virtioDisks; i++ {
+			domain.Devices.Disks = append(domain.Devices.Disks, libvirtxml.DomainDisk{
+				Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "raw"},
+				Target: &libvirtxml.DomainDiskTarget{Dev: fmt.Sprintf("vd%c", 'a'+i), Bus: "virtio"},
+			})
+		}
+		return domain
+	}
+	for _, tc := range []struct {
+		name                string
+		vcpus, virtioDisks  int
+		queues, ioThreads   int
+		force               bool
+		expectedQueues      uint
+		expectedIOThreads   uint
+		expectedDiskThreads []uint
+		expectedSCSIThread  uint
+	}{
+		{
+			name:        "single vcpu",
+			vcpus:       1,
+			virtioDisks: 1,
+		},
+		{
+			name:                "single vcpu, forced",
+			vcpus:               1,
+			virtioDisks:         2,
+			force:               true,
+			expectedQueues:      1,
+			expectedIOThreads:   1,
+			expectedDiskThreads: []uint{1, 1},
+			expectedSCSIThread:  1,
+		},
+		{
+			name:                "iothread per disk",
+			vcpus:               4,
+			virtioDisks:         3,
+			expectedQueues:      4,
+			expectedIOThreads:   3,
+			expectedDiskThreads: []uint{1, 2, 3},
+			expectedSCSIThread:  1,
+		},
+		{
+			name:                "no more iothreads than vcpus",
+			vcpus:               2,
+			virtioDisks:         3,
+			expectedQueues:      2,
+			expectedIOThreads:   2,
+			expectedDiskThreads: []uint{1, 2, 1},
+			expectedSCSIThread:  2,
+		},
+		{
+			name:                "explicit values",
+			vcpus:               1,
+			virtioDisks:         2,
+			queues:              8,
+			ioThreads:           4,
+			expectedQueues:      8,
+			expectedIOThreads:   4,
+			expectedDiskThreads: []uint{1, 2},
+			expectedSCSIThread:  3,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			domain := newDomain(tc.vcpus, tc.virtioDisks)
+			configureDiskQueues(domain, tc.queues, tc.ioThreads, tc.force)
+			if tc.expectedIOThreads == 0 {
+				if !reflect.DeepEqual(domain, newDomain(tc.vcpus, tc.virtioDisks)) {
+					t.Errorf("the domain was changed")
+				}
+				return
+			}
+			if domain.IOThreads != tc.expectedIOThreads {
+				t.Errorf("bad iothread count %d instead of %d", domain.IOThreads, tc.expectedIOThreads)
+			}
+			var diskThreads []uint
+			for _, d := range domain.Devices.Disks {
+				if d.Target.Bus != "virtio" {
+					if d.Driver.Queues != nil || d.Driver.IOThread != nil {
+						t.Errorf("scsi disk driver settings must not be changed: %#v", d.Driver)
+					}
+					continue
+				}
+				if d.Driver.Queues == nil || *d.Driver.Queues != tc.expectedQueues || d.Driver.IOThread == nil {
+					t.Errorf("bad virtio disk driver settings: %#v", d.Driver)
+					continue
+				}
+				diskThreads = append(diskThreads, *d.Driver.IOThread)
+			}
+			if !reflect.DeepEqual(diskThreads, tc.expectedDiskThreads) {
+				t.Errorf("bad disk iothreads %v instead of %v", diskThreads, tc.expectedDiskThreads)
+			}
+			driver := domain.Devices.Controllers[0].Driver
+			if driver == nil || driver.Queues == nil || *driver.Queues != tc.expectedQueues || driver.IOThread == nil || *driver.IOThread != tc.expectedSCSIThread {
+				t.Errorf("bad scsi controller driver settings: %#v", driver)
+			}
+		})
+	}
+}
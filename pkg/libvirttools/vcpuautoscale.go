@@ -0,0 +1,173 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+const (
+	vcpuAutoscaleInterval = 30 * time.Second
+	// a vCPU is added when the average usage of the vCPUs is at
+	// or above vcpuHighUsage for vcpuScaleUpSamples consecutive
+	// intervals, and removed when it's at or below vcpuLowUsage
+	// for vcpuScaleDownSamples consecutive intervals
+	vcpuHighUsage        = 0.8
+	vcpuLowUsage         = 0.2
+	vcpuScaleUpSamples   = 3
+	vcpuScaleDownSamples = 10
+)
+
+// vcpuAutoscaler decides when vCPUs should be added to or removed
+// from the VM based on its CPU usage.
+type vcpuAutoscaler struct {
+	min, max, current int
+	lastCPUTime       uint64
+	lastSampleTime    time.Time
+	highSamples       int
+	lowSamples        int
+}
+
+func newVCPUAutoscaler(min, max, current int) *vcpuAutoscaler {
+	return &vcpuAutoscaler{min: min, max: max, current: current}
+}
+
+// sample takes the total CPU time used by the VM in nanoseconds
+// along with the time of the measurement and returns the desired
+// number of vCPUs.
+func (a *vcpuAutoscaler) sample(cpuTime uint64, now time.Time) int {
+	prevCPUTime, prevSampleTime := a.lastCPUTime, a.lastSampleTime
+	a.lastCPUTime, a.lastSampleTime = cpuTime, now
+	if prevSampleTime.IsZero() || !now.After(prevSampleTime) || cpuTime < prevCPUTime {
+		// this is either the first sample or the VM has been
+		// restarted since the previous one
+		return a.current
+	}
+	usage := float64(cpuTime-prevCPUTime) / float64(now.Sub(prevSampleTime)) / float64(a.current)
+	switch {
+	case usage >= vcpuHighUsage:
+		a.highSamples++
+		a.lowSamples = 0
+	case usage <= vcpuLowUsage:
+		a.lowSamples++
+		a.highSamples = 0
+	default:
+		a.highSamples, a.lowSamples = 0, 0
+	}
+	switch {
+	case a.highSamples >= vcpuScaleUpSamples && a.current < a.max:
+		a.highSamples = 0
+		return a.current + 1
+	case a.lowSamples >= vcpuScaleDownSamples && a.current > a.min:
+		a.lowSamples = 0
+		return a.current - 1
+	}
+	return a.current
+}
+
+// currentVCPUs returns the number of vCPUs the running domain
+// currently has, or defaultCount if it can't be determined.
+func currentVCPUs(domain virt.Domain, defaultCount int) int {
+	count, err := domain.GetVCPUs()
+	if err != nil {
+		return defaultCount
+	}
+	return count
+}
+
+// setVCPUs changes the number of vCPUs of the VM, recording an event
+// for the pod.
+func (v *VirtualizationTool) setVCPUs(domain virt.Domain, config *types.VMConfig, a *vcpuAutoscaler, count int) {
+	if count == a.current {
+		return
+	}
+	if err := domain.SetVCPUs(count); err != nil {
+		v.eventRecorder.Eventf(config, v1.EventTypeWarning, "VCPUScalingFailed", "Failed to change the number of vCPUs from %d to %d: %v", a.current, count, err)
+		return
+	}
+	reason, usage := "VCPUsAdded", "high"
+	if count < a.current {
+		reason, usage = "VCPUsRemoved", "low"
+	}
+	v.eventRecorder.Eventf(config, v1.EventTypeNormal, reason, "Changed the number of vCPUs from %d to %d due to %s CPU usage", a.current, count, usage)
+	a.current = count
+}
+
+// startVCPUAutoscaling starts vCPU autoscaling for the VM in
+// background.
+func (v *VirtualizationTool) startVCPUAutoscaling(containerID string, domain virt.Domain, config *types.VMConfig) {
+	w := v.vcpuAutoscalers.start(containerID)
+	go func() {
+		defer v.vcpuAutoscalers.done(containerID, w)
+		v.autoscaleVCPUs(w, domain, config)
+	}()
+}
+
+// autoscaleVCPUs adds vCPUs to the VM while its CPU usage stays high
+// and removes them while it's idle till the domain stops running or
+// the watcher is stopped because the VM is being stopped or removed.
+func (v *VirtualizationTool) autoscaleVCPUs(w *vmWatcher, domain virt.Domain, config *types.VMConfig) {
+	va := config.ParsedAnnotations
+	a := newVCPUAutoscaler(va.VCPUCount, va.MaxVCPUs(), currentVCPUs(domain, va.VCPUCount))
+	glog.V(1).Infof("Starting vCPU autoscaling for VM %s/%s (%d..%d vCPUs)", config.PodNamespace, config.PodName, a.min, a.max)
+	defer glog.V(1).Infof("Stopped vCPU autoscaling for VM %s/%s", config.PodNamespace, config.PodName)
+	for {
+		state, err := domain.State()
+		if err != nil || state != virt.DomainStateRunning {
+			return
+		}
+		if cpuTime, err := domain.GetCPUTime(); err != nil {
+			glog.V(3).Infof("vCPU autoscaling for VM %s/%s: can't get cpu time: %v", config.PodNamespace, config.PodName, err)
+		} else if !w.do(func() { v.setVCPUs(domain, config, a, a.sample(cpuTime, v.clock.Now())) }) {
+			return
+		}
+		select {
+		case <-w.stopCh:
+			return
+		case <-v.clock.After(vcpuAutoscaleInterval):
+		}
+	}
+}
+
+// ResumeVCPUAutoscaling restarts vCPU autoscaling for the running
+// VMs after Virtlet restart.
+func (v *VirtualizationTool) ResumeVCPUAutoscaling() error {
+	containers, err := v.ListContainers(nil)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		if c.State != types.ContainerState_CONTAINER_RUNNING ||
+			c.Config.ParsedAnnotations == nil ||
+			!c.Config.ParsedAnnotations.VCPUAutoscale {
+			continue
+		}
+		domain, err := v.domainConn.LookupDomainByUUIDString(c.Id)
+		if err != nil {
+			return fmt.Errorf("failed to look up domain %q: %v", c.Id, err)
+		}
+		v.startVCPUAutoscaling(c.Id, domain, &c.Config)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+func TestVCPUAutoscaler(t *testing.T) {
+	a := newVCPUAutoscaler(1, 3, 1)
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	var cpuTime uint64
+	// feed samples the specified number of times, with the VM using
+	// the specified number of host CPUs
+	feed := func(n int, cpus float64) []int {
+		var r []int
+		for i := 0; i < n; i++ {
+			now = now.Add(vcpuAutoscaleInterval)
+			cpuTime += uint64(cpus * float64(vcpuAutoscaleInterval))
+			count := a.sample(cpuTime, now)
+			r = append(r, count)
+			a.current = count
+		}
+		return r
+	}
+	for _, step := range []struct {
+		name     string
+		n        int
+		cpus     float64
+		expected []int
+	}{
+		{
+			name:     "first sample",
+			n:        1,
+			cpus:     1,
+			expected: []int{1},
+		},
+		{
+			name:     "sustained high usage",
+			n:        4,
+			cpus:     0.9,
+			expected: []int{1, 1, 2, 2},
+		},
+		{
+			name:     "moderate usage resets the counter",
+			n:        3,
+			cpus:     1,
+			expected: []int{2, 2, 2},
+		},
+		{
+			name:     "high usage again",
+			n:        7,
+			cpus:     2,
+			expected: []int{2, 2, 3, 3, 3, 3, 3},
+		},
+		{
+			name:     "idle",
+			n:        21,
+			cpus:     0.1,
+			expected: []int{3, 3, 3, 3, 3, 3, 3, 3, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1},
+		},
+	} {
+		if counts := feed(step.n, step.cpus); !reflect.DeepEqual(counts, step.expected) {
+			t.Errorf("%s: bad vcpu counts %v instead of %v", step.name, counts, step.expected)
+		}
+	}
+
+	// the VM was restarted
+	if count := a.sample(0, now.Add(vcpuAutoscaleInterval)); count != 1 {
+		t.Errorf("bad vcpu count after restart: %d instead of 1", count)
+	}
+}
+
+func TestVCPUAutoscaling(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+	recorder := &fakeEventRecorder{}
+	ct.virtTool.SetEventRecorder(recorder)
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	sandbox.Annotations["VirtletMaxVCPUCount"] = "2"
+	sandbox.Annotations["VirtletVCPUAutoscale"] = "true"
+	ct.setPodSandbox(sandbox)
+
+	containerID := ct.createContainer(sandbox, nil, nil)
+	ct.clock.Advance(1 * time.Second)
+	ct.startContainer(containerID)
+
+	var cpuTime uint64
+	runIntervals := func(n int, cpus float64) {
+		for i := 0; i < n; i++ {
+			ct.clock.BlockUntil(1)
+			cpuTime += uint64(cpus * float64(vcpuAutoscaleInterval))
+			ct.domainConn.SetCPUTime(cpuTime)
+			ct.clock.Advance(vcpuAutoscaleInterval)
+		}
+		ct.clock.BlockUntil(1)
+	}
+	runIntervals(vcpuScaleUpSamples, 1)
+	domain, err := ct.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		t.Fatalf("LookupDomainByUUIDString(): %v", err)
+	}
+	if n := currentVCPUs(domain, 0); n != 2 {
+		t.Errorf("bad vcpu count after high cpu usage: %d instead of 2", n)
+	}
+
+	runIntervals(vcpuScaleDownSamples, 0)
+	if n := currentVCPUs(domain, 0); n != 1 {
+		t.Errorf("bad vcpu count after low cpu usage: %d instead of 1", n)
+	}
+
+	expectedEvents := []string{
+		fmt.Sprintf("%s/%s Normal VCPUsAdded: Changed the number of vCPUs from 1 to 2 due to high CPU usage", sandbox.Namespace, sandbox.Name),
+		fmt.Sprintf("%s/%s Normal VCPUsRemoved: Changed the number of vCPUs from 2 to 1 due to low CPU usage", sandbox.Namespace, sandbox.Name),
+	}
+	if !reflect.DeepEqual(recorder.events, expectedEvents) {
+		t.Errorf("bad events:\n%#v\ninstead of\n%#v", recorder.events, expectedEvents)
+	}
+
+	// the autoscaling is stopped together with the VM
+	ct.stopContainer(containerID)
+	ct.virtTool.vcpuAutoscalers.Lock()
+	if n := len(ct.virtTool.vcpuAutoscalers.watchers); n != 0 {
+		t.Errorf("vCPU autoscaling is still active for %d VM(s) after stopping the VM", n)
+	}
+	ct.virtTool.vcpuAutoscalers.Unlock()
+	for i := 0; i < vcpuScaleUpSamples+1; i++ {
+		cpuTime += uint64(2 * float64(vcpuAutoscaleInterval))
+		ct.domainConn.SetCPUTime(cpuTime)
+		ct.clock.Advance(vcpuAutoscaleInterval)
+	}
+	if !reflect.DeepEqual(recorder.events, expectedEvents) {
+		t.Errorf("bad events after stopping the VM:\n%#v\ninstead of\n%#v", recorder.events, expectedEvents)
+	}
+}
+
+// liveVCPUDomain is a domain that only reports its live vCPU count.
+type liveVCPUDomain struct {
+	virt.Domain
+	liveVCPUs int
+}
+
+func (d *liveVCPUDomain) GetVCPUs() (int, error) {
+	if d.liveVCPUs == 0 {
+		return 0, errors.New("domain is not running")
+	}
+	return d.liveVCPUs, nil
+}
+
+func TestCurrentVCPUs(t *testing.T) {
+	// the count is taken from the running domain and not from
+	// its definition, so XML() of the domain is never called
+	if n := currentVCPUs(&liveVCPUDomain{liveVCPUs: 3}, 1); n != 3 {
+		t.Errorf("bad vcpu count %d instead of 3", n)
+	}
+	if n := currentVCPUs(&liveVCPUDomain{}, 1); n != 1 {
+		t.Errorf("bad default vcpu count %d instead of 1", n)
+	}
+}
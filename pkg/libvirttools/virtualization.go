@@ -17,9 +17,12 @@ limitations under the License.
 package libvirttools
 
 import (
+	"errors"
 	"fmt"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -36,6 +39,7 @@ import (
 	"github.com/Mirantis/virtlet/pkg/network"
 	"github.com/Mirantis/virtlet/pkg/utils"
 	"github.com/Mirantis/virtlet/pkg/virt"
+	"github.com/Mirantis/virtlet/pkg/webhook"
 )
 
 const (
@@ -67,20 +71,47 @@ const (
 )
 
 type domainSettings struct {
-	useKvm           bool
-	domainName       string
-	domainUUID       string
-	memory           int
-	memoryUnit       string
-	vcpuNum          int
-	cpuShares        uint
-	cpuPeriod        uint64
-	cpuQuota         int64
-	rootDiskFilepath string
-	netFdKey         string
-	enableSriov      bool
-	cpuModel         string
-	systemUUID       *uuid.UUID
+	useKvm              bool
+	domainName          string
+	domainUUID          string
+	memory              int
+	memoryUnit          string
+	vcpuNum             int
+	maxVCPUs            int
+	cpuShares           uint
+	cpuPeriod           uint64
+	cpuQuota            int64
+	rootDiskFilepath    string
+	netFdKey            string
+	enableSriov         bool
+	cpuModel            string
+	systemUUID          *uuid.UUID
+	onCrash             string
+	watchdogAction      string
+	guestAgent          bool
+	memStatsPeriod      int
+	disableImageLocking bool
+}
+
+// memorySize returns the size of the VM memory in bytes.
+func (ds *domainSettings) memorySize() uint64 {
+	if ds.memoryUnit == defaultMemoryUnit {
+		return uint64(ds.memory) * 1024 * 1024
+	}
+	return uint64(ds.memory)
+}
+
+// vcpu returns the vCPU settings of the domain. If the VM can
+// have more vCPUs than it starts with, the domain is started with
+// vcpuNum vCPUs, and the rest of them can be hot-added later.
+func (ds *domainSettings) vcpu() *libvirtxml.DomainVCPU {
+	if ds.maxVCPUs <= ds.vcpuNum {
+		return &libvirtxml.DomainVCPU{Value: ds.vcpuNum}
+	}
+	return &libvirtxml.DomainVCPU{
+		Value:   ds.maxVCPUs,
+		Current: strconv.Itoa(ds.vcpuNum),
+	}
 }
 
 func (ds *domainSettings) createDomain(config *types.VMConfig) *libvirtxml.Domain {
@@ -127,7 +158,7 @@ func (ds *domainSettings) createDomain(config *types.VMConfig) *libvirtxml.Domai
 		Name:   ds.domainName,
 		UUID:   ds.domainUUID,
 		Memory: &libvirtxml.DomainMemory{Value: uint(ds.memory), Unit: ds.memoryUnit},
-		VCPU:   &libvirtxml.DomainVCPU{Value: ds.vcpuNum},
+		VCPU:   ds.vcpu(),
 		CPUTune: &libvirtxml.DomainCPUTune{
 			Shares: &libvirtxml.DomainCPUTuneShares{Value: ds.cpuShares},
 			Period: &libvirtxml.DomainCPUTunePeriod{Value: ds.cpuPeriod},
@@ -185,6 +216,37 @@ func (ds *domainSettings) createDomain(config *types.VMConfig) *libvirtxml.Domai
 		}
 	}
 
+	if ds.onCrash != "" {
+		domain.OnCrash = ds.onCrash
+	}
+
+	if ds.watchdogAction != "" {
+		domain.Devices.Watchdog = &libvirtxml.DomainWatchdog{
+			Model:  "i6300esb",
+			Action: ds.watchdogAction,
+		}
+	}
+
+	if ds.guestAgent {
+		domain.Devices.Channels = []libvirtxml.DomainChannel{
+			{
+				Source: &libvirtxml.DomainChardevSource{
+					UNIX: &libvirtxml.DomainChardevSourceUNIX{Mode: "bind"},
+				},
+				Target: &libvirtxml.DomainChannelTarget{
+					VirtIO: &libvirtxml.DomainChannelTargetVirtIO{Name: types.GuestAgentChannelName},
+				},
+			},
+		}
+	}
+
+	if ds.memStatsPeriod > 0 {
+		domain.Devices.MemBalloon = &libvirtxml.DomainMemBalloon{
+			Model: "virtio",
+			Stats: &libvirtxml.DomainMemBalloonStats{Period: uint(ds.memStatsPeriod)},
+		}
+	}
+
 	if ds.systemUUID != nil {
 		domain.SysInfo = &libvirtxml.DomainSysInfo{
 			Type: "smbios",
@@ -204,6 +266,11 @@ func (ds *domainSettings) createDomain(config *types.VMConfig) *libvirtxml.Domai
 			libvirtxml.DomainQEMUCommandlineEnv{Name: "VMWRAPPER_KEEP_PRIVS", Value: "1"})
 	}
 
+	if ds.disableImageLocking {
+		domain.QEMUCommandline.Envs = append(domain.QEMUCommandline.Envs,
+			libvirtxml.DomainQEMUCommandlineEnv{Name: vconfig.DisableImageLockingEnvVarName, Value: "1"})
+	}
+
 	return domain
 }
 
@@ -233,19 +300,109 @@ type VirtualizationConfig struct {
 	CPUModel string
 	// Path to the directory used for shared filesystems
 	SharedFilesystemPath string
+	// Path to the directory where libvirt writes qemu logs
+	// for the domains. Empty value disables qemu log capture.
+	QemuLogDirectory string
+	// Path to the directory used to store boot diagnostics
+	// of the VMs that fail to boot in time. Empty value disables
+	// saving boot diagnostics.
+	BootDiagnosticsDirectory string
+	// Interval in seconds between the polls of guest memory
+	// stats via the memory balloon driver. 0 disables the polling,
+	// in which case the RSS of the emulator process is reported
+	// as the memory usage of the VM.
+	MemoryStatsPeriod int
+	// Cache mode for the qcow2 disks of the VMs. Empty value
+	// leaves the choice to libvirt.
+	DiskCacheMode string
+	// True if qemu image locking should be disabled for the VM
+	// disks, e.g. because the storage filesystem doesn't support
+	// the locks.
+	DisableImageLocking bool
+	// Keys of the pod labels that are copied to the metadata
+	// of the domains.
+	DomainMetadataLabels []string
+	// Path to the file with the host device policy that
+	// specifies which host devices can be passed to the VMs.
+	// Empty value disables passing the host devices to the VMs.
+	HostDevicePolicyFile string
+	// Default source of the VM memory, one of the
+	// types.MemoryBackingSource values. Empty value means
+	// anonymous memory.
+	MemoryBacking string
+	// Huge page sizes in bytes mapped to the hugetlbfs mount
+	// points that are available on the node.
+	HugetlbfsMounts map[uint64]string
+	// Time to keep the tombstones of the removed pod sandboxes
+	// in the metadata store. 0 disables the tombstones.
+	SandboxTombstoneTTL time.Duration
+	// Time to keep the root volume and the config drive of a
+	// removed VM so they can be reused if the VM is re-created,
+	// e.g. because it's crash-looping. 0 disables the reuse.
+	VolumeReuseTTL time.Duration
+	// Policy for handling the discrepancies between the metadata
+	// store and libvirt found by Reconcile. Empty value means
+	// ReconcileRepair.
+	ReconcilePolicy ReconcilePolicy
 }
 
 // VirtualizationTool provides methods to operate on libvirt.
 type VirtualizationTool struct {
+	*virtualizationState
+	// metadataStore is the metadata store used by this view of
+	// the tool (see WithAuditActor)
+	metadataStore metadata.Store
+}
+
+// virtualizationState is the state of VirtualizationTool that's
+// shared by all of its views.
+type virtualizationState struct {
 	domainConn    virt.DomainConnection
 	storageConn   virt.StorageConnection
 	imageManager  ImageManager
-	metadataStore metadata.Store
 	clock         clockwork.Clock
 	volumeSource  VMVolumeSource
 	config        VirtualizationConfig
+	configLock    sync.Mutex
+	cpuPinLock    sync.Mutex
 	fsys          fs.FileSystem
 	commander     utils.Commander
+	eventRecorder EventRecorder
+	lifecycleSink LifecycleEventSink
+	podChecker    PodChecker
+
+	// quotaLock guards quotaReservations
+	quotaLock         sync.Mutex
+	quotaReservations map[*types.VMConfig]bool
+
+	// maintenanceLock guards maintenanceRecords
+	maintenanceLock    sync.Mutex
+	maintenanceRecords map[string]maintenanceRecord
+
+	// progressLock guards progressMessages
+	progressLock     sync.Mutex
+	progressMessages map[string]string
+
+	// keptDomainLock guards keptDomainIDs
+	keptDomainLock sync.Mutex
+	keptDomainIDs  map[string]bool
+
+	// suspendLock guards suspendedVMs
+	suspendLock  sync.Mutex
+	suspendedVMs map[string]time.Time
+
+	// diskStatsLock guards diskStatsSamples
+	diskStatsLock    sync.Mutex
+	diskStatsSamples map[string]diskStatsSample
+
+	// bootWatchers are the goroutines that watch the VMs booting
+	bootWatchers vmWatchers
+	// vcpuAutoscalers are the goroutines that change the number
+	// of vCPUs of the running VMs
+	vcpuAutoscalers vmWatchers
+	// postStartHooks are the goroutines that run the post-start
+	// hooks of the VMs
+	postStartHooks vmWatchers
 }
 
 var _ volumeOwner = &VirtualizationTool{}
@@ -258,15 +415,35 @@ func NewVirtualizationTool(domainConn virt.DomainConnection,
 	config VirtualizationConfig, fsys fs.FileSystem,
 	commander utils.Commander) *VirtualizationTool {
 	return &VirtualizationTool{
-		domainConn:    domainConn,
-		storageConn:   storageConn,
-		imageManager:  imageManager,
+		virtualizationState: &virtualizationState{
+			domainConn:    domainConn,
+			storageConn:   storageConn,
+			imageManager:  imageManager,
+			clock:         clockwork.NewRealClock(),
+			volumeSource:  volumeSource,
+			config:        config,
+			fsys:          fsys,
+			commander:     commander,
+			eventRecorder: nullEventRecorder{},
+
+			quotaReservations:  make(map[*types.VMConfig]bool),
+			maintenanceRecords: make(map[string]maintenanceRecord),
+			progressMessages:   make(map[string]string),
+			keptDomainIDs:      make(map[string]bool),
+			suspendedVMs:       make(map[string]time.Time),
+			diskStatsSamples:   make(map[string]diskStatsSample),
+		},
 		metadataStore: metadataStore,
-		clock:         clockwork.NewRealClock(),
-		volumeSource:  volumeSource,
-		config:        config,
-		fsys:          fsys,
-		commander:     commander,
+	}
+}
+
+// WithAuditActor returns a view of the tool that shares its state
+// but attributes the changes made in the metadata store to the
+// specified actor in the audit log (see metadata.WithAuditActor).
+func (v *VirtualizationTool) WithAuditActor(actor, method string) *VirtualizationTool {
+	return &VirtualizationTool{
+		virtualizationState: v.virtualizationState,
+		metadataStore:       metadata.WithAuditActor(v.metadataStore, actor, method),
 	}
 }
 
@@ -275,6 +452,67 @@ func (v *VirtualizationTool) SetClock(clock clockwork.Clock) {
 	v.clock = clock
 }
 
+// SetEventRecorder sets the recorder to use for the VM pod events.
+func (v *VirtualizationTool) SetEventRecorder(recorder EventRecorder) {
+	v.eventRecorder = recorder
+}
+
+// SetPodChecker sets the PodChecker that's used to verify that the
+// pods of the stopped pod sandboxes are gone before the sandboxes
+// are removed by RemoveOrphanMetadata.
+func (v *VirtualizationTool) SetPodChecker(checker PodChecker) {
+	v.podChecker = checker
+}
+
+// EventRecorder returns the recorder used for the VM pod events.
+func (v *VirtualizationTool) EventRecorder() EventRecorder {
+	return v.eventRecorder
+}
+
+// SetLifecycleEventSink sets the sink for the VM lifecycle events.
+func (v *VirtualizationTool) SetLifecycleEventSink(sink LifecycleEventSink) {
+	v.lifecycleSink = sink
+}
+
+// notifyLifecycleEvent records a VM lifecycle event in the event
+// history of the pod sandbox and passes it to the lifecycle event
+// sink, if there's one.
+func (v *VirtualizationTool) notifyLifecycleEvent(eventType webhook.EventType, containerID string, config *types.VMConfig) {
+	if config == nil {
+		return
+	}
+	v.RecordSandboxEvent(config.PodSandboxID, vmSandboxEventTypes[eventType], containerID, "")
+	if v.lifecycleSink == nil {
+		return
+	}
+	v.lifecycleSink.Dispatch(webhook.Event{
+		Type:          eventType,
+		Timestamp:     v.clock.Now().UTC(),
+		ContainerID:   containerID,
+		ContainerName: config.Name,
+		PodID:         config.PodSandboxID,
+		PodName:       config.PodName,
+		PodNamespace:  config.PodNamespace,
+		Image:         config.Image,
+	})
+}
+
+// UpdateConfig replaces the settings of VirtualizationTool that can
+// be changed without restarting Virtlet, namely the raw device list
+// and the default CPU model.
+func (v *VirtualizationTool) UpdateConfig(rawDevices []string, cpuModel string) {
+	v.configLock.Lock()
+	defer v.configLock.Unlock()
+	v.config.RawDevices = rawDevices
+	v.config.CPUModel = cpuModel
+}
+
+func (v *VirtualizationTool) defaultCPUModel() string {
+	v.configLock.Lock()
+	defer v.configLock.Unlock()
+	return v.config.CPUModel
+}
+
 func (v *VirtualizationTool) addSerialDevicesToDomain(domain *libvirtxml.Domain) error {
 	port := uint(0)
 	timeout := uint(1)
@@ -317,6 +555,16 @@ func (v *VirtualizationTool) CreateContainer(config *types.VMConfig, netFdKey st
 		return "", err
 	}
 
+	if err := v.checkHostDevices(config); err != nil {
+		return "", err
+	}
+
+	release, err := v.admitVM(config)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
 	var domainUUID string
 	if config.ParsedAnnotations.SystemUUID != nil {
 		domainUUID = config.ParsedAnnotations.SystemUUID.String()
@@ -325,7 +573,7 @@ func (v *VirtualizationTool) CreateContainer(config *types.VMConfig, netFdKey st
 	}
 	// FIXME: this field should be moved to VMStatus struct (to be added)
 	config.DomainUUID = domainUUID
-	cpuModel := v.config.CPUModel
+	cpuModel := v.defaultCPUModel()
 	if config.ParsedAnnotations.CPUModel != "" {
 		cpuModel = string(config.ParsedAnnotations.CPUModel)
 	}
@@ -336,6 +584,7 @@ func (v *VirtualizationTool) CreateContainer(config *types.VMConfig, netFdKey st
 		domainName:  "virtlet-" + domainUUID[:13] + "-" + config.Name,
 		netFdKey:    netFdKey,
 		vcpuNum:     config.ParsedAnnotations.VCPUCount,
+		maxVCPUs:    config.ParsedAnnotations.MaxVCPUs(),
 		memory:      int(config.MemoryLimitInBytes),
 		cpuShares:   uint(config.CPUShares),
 		cpuPeriod:   uint64(config.CPUPeriod),
@@ -343,27 +592,67 @@ func (v *VirtualizationTool) CreateContainer(config *types.VMConfig, netFdKey st
 		// CPU bandwidth limits for domains are actually set equal per
 		// each vCPU by libvirt. Thus, to limit overall VM's CPU
 		// threads consumption by the value from the pod definition
-		// we need to perform this division
-		cpuQuota:   config.CPUQuota / int64(config.ParsedAnnotations.VCPUCount),
-		memoryUnit: "b",
-		useKvm:     !v.config.DisableKVM,
-		cpuModel:   cpuModel,
-		systemUUID: config.ParsedAnnotations.SystemUUID,
+		// we need to perform this division. The vCPUs that may be
+		// hot-added later are taken into account, too
+		cpuQuota:            config.CPUQuota / int64(config.ParsedAnnotations.MaxVCPUs()),
+		memoryUnit:          "b",
+		useKvm:              !v.config.DisableKVM,
+		cpuModel:            cpuModel,
+		systemUUID:          config.ParsedAnnotations.SystemUUID,
+		onCrash:             config.ParsedAnnotations.OnCrash,
+		watchdogAction:      config.ParsedAnnotations.WatchdogAction,
+		guestAgent:          config.ParsedAnnotations.GuestAgent,
+		memStatsPeriod:      v.config.MemoryStatsPeriod,
+		disableImageLocking: v.config.DisableImageLocking,
+	}
+	if settings.memory == 0 && config.ParsedAnnotations.Memory > 0 {
+		settings.memory = int(config.ParsedAnnotations.Memory)
 	}
 	if settings.memory == 0 {
 		settings.memory = defaultMemory
 		settings.memoryUnit = defaultMemoryUnit
 	}
+	memoryBacking, err := domainMemoryBacking(config.ParsedAnnotations, v.config.MemoryBacking, v.config.HugetlbfsMounts, settings.memorySize())
+	if err != nil {
+		return "", err
+	}
+
+	var adoptedDomain virt.Domain
+	if config.ParsedAnnotations.AdoptDomain != "" {
+		if adoptedDomain, err = v.prepareAdoption(config); err != nil {
+			return "", err
+		}
+	}
 
 	domainDef := settings.createDomain(config)
+	domainDef.MemoryBacking = memoryBacking
 	diskList, err := newDiskList(config, v.volumeSource, v)
 	if err != nil {
 		return "", err
 	}
 	domainDef.Devices.Disks, domainDef.Devices.Filesystems, err = diskList.setup()
+	// the retained volumes are either reused or removed by now
+	v.forgetRetainedVolumes(config)
 	if err != nil {
 		return "", err
 	}
+	domainDef.Metadata = &libvirtxml.DomainMetadata{
+		XML: newDomainMetadata(config, domainUUID, v.podLabels(config), v.config.DomainMetadataLabels).domainXML(),
+	}
+	va := config.ParsedAnnotations
+	if va.TuningProfile == types.TuningProfileLatency {
+		// the domain must be defined before the CPUs are
+		// allocated for another VM
+		v.cpuPinLock.Lock()
+		defer v.cpuPinLock.Unlock()
+	}
+	if err := v.applyTuningProfile(domainDef, va.TuningProfile, config.CPUSetCpus); err != nil {
+		return "", err
+	}
+	configureDiskQueues(domainDef, va.DiskQueues, va.IOThreads, va.TuningProfile == types.TuningProfileThroughput)
+	applyDiskCacheMode(domainDef, v.config.DiskCacheMode)
+	applyLegacyGuestSettings(domainDef, va)
+	config.PersistentVolumes = diskList.persistentVolumeNames()
 
 	ok := false
 	defer func() {
@@ -382,6 +671,22 @@ func (v *VirtualizationTool) CreateContainer(config *types.VMConfig, netFdKey st
 		return "", err
 	}
 
+	if err := v.addSerialChannelsToDomain(domainDef, config); err != nil {
+		return "", err
+	}
+
+	if err := addHostDevicesToDomain(domainDef, config); err != nil {
+		return "", err
+	}
+
+	if err := addMdevsToDomain(domainDef, config); err != nil {
+		return "", err
+	}
+
+	if err := applyBootOrder(domainDef, diskList, config.ParsedAnnotations.BootOrder); err != nil {
+		return "", err
+	}
+
 	if config.ContainerLabels == nil {
 		config.ContainerLabels = map[string]string{}
 	}
@@ -390,10 +695,24 @@ func (v *VirtualizationTool) CreateContainer(config *types.VMConfig, netFdKey st
 	config.ContainerLabels[kubetypes.KubernetesPodUIDLabel] = config.PodSandboxID
 	config.ContainerLabels[kubetypes.KubernetesContainerNameLabel] = config.Name
 
+	if adoptedDomain != nil {
+		// the adopted domain is defined again by removeDomain()
+		// if the VM can't be created
+		if err := adoptedDomain.Undefine(); err != nil {
+			return "", fmt.Errorf("error undefining adopted domain %q: %v", config.ParsedAnnotations.AdoptDomain, err)
+		}
+	}
+
+	// The domain kept after the removal of the container of the
+	// VM with soft reboot enabled is redefined in place
 	domain, err := v.domainConn.DefineDomain(domainDef)
 	if err == nil {
 		err = diskList.writeImages(domain)
 	}
+	var domainXML string
+	if err == nil {
+		domainXML, err = domainDefinition(domain)
+	}
 	if err == nil {
 		err = v.metadataStore.Container(settings.domainUUID).Save(
 			func(_ *types.ContainerInfo) (*types.ContainerInfo, error) {
@@ -402,6 +721,7 @@ func (v *VirtualizationTool) CreateContainer(config *types.VMConfig, netFdKey st
 					CreatedAt: v.clock.Now().UnixNano(),
 					Config:    *config,
 					State:     types.ContainerState_CONTAINER_CREATED,
+					DomainXML: domainXML,
 				}, nil
 			})
 	}
@@ -410,9 +730,29 @@ func (v *VirtualizationTool) CreateContainer(config *types.VMConfig, netFdKey st
 	}
 
 	ok = true
+	v.notifyLifecycleEvent(webhook.EventCreated, settings.domainUUID, config)
 	return settings.domainUUID, nil
 }
 
+// podLabels returns the labels of the pod the VM belongs to. It
+// only consults the metadata store if any labels need to be copied
+// to the domain metadata.
+func (v *VirtualizationTool) podLabels(config *types.VMConfig) map[string]string {
+	if len(v.config.DomainMetadataLabels) == 0 {
+		return nil
+	}
+	sandbox, err := v.metadataStore.PodSandbox(config.PodSandboxID).Retrieve()
+	switch {
+	case err != nil:
+		glog.Warningf("Can't retrieve pod sandbox %q, not copying pod labels to the domain metadata: %v", config.PodSandboxID, err)
+		return nil
+	case sandbox == nil || sandbox.Config == nil:
+		return nil
+	default:
+		return sandbox.Config.Labels
+	}
+}
+
 func (v *VirtualizationTool) updateDiskImages(containerID string) error {
 	domain, err := v.domainConn.LookupDomainByUUIDString(containerID)
 	if err != nil {
@@ -468,12 +808,26 @@ func (v *VirtualizationTool) startContainer(containerID string) error {
 	if err != nil {
 		return fmt.Errorf("failed to get state of the domain %q: %v", containerID, err)
 	}
-	if state != virt.DomainStateShutoff {
+	// The domain of the VM with soft reboot enabled that's
+	// preserved after a guest crash is reset in place
+	reset := state == virt.DomainStateCrashed && v.softRebootEnabled(containerID)
+	if state != virt.DomainStateShutoff && !reset {
 		return fmt.Errorf("domain %q: bad state %v upon StartContainer()", containerID, state)
 	}
 
-	if err = domain.Create(); err != nil {
-		return fmt.Errorf("failed to create domain %q: %v", containerID, err)
+	if err := v.checkRestartBackoff(containerID); err != nil {
+		return err
+	}
+
+	if reset {
+		if err = domain.Reset(); err != nil {
+			return v.recordStartFailure(containerID, domain, fmt.Errorf("failed to reset domain %q: %v", containerID, err))
+		}
+	} else {
+		v.checkDomainDrift(containerID, domain)
+		if err = domain.Create(); err != nil {
+			return v.recordStartFailure(containerID, domain, fmt.Errorf("failed to create domain %q: %v", containerID, err))
+		}
 	}
 
 	// XXX: maybe we don't really have to wait here but I couldn't
@@ -494,91 +848,235 @@ func (v *VirtualizationTool) startContainer(containerID string) error {
 			return false, nil
 		}
 	}, domainStartCheckInterval, domainStartTimeout, v.clock); err != nil {
-		return err
+		return v.recordStartFailure(containerID, domain, err)
 	}
+	v.saveStartRecord(containerID, domain, nil)
+	v.markFirstBootCompleted(containerID)
 
-	return v.metadataStore.Container(containerID).Save(
+	if err := v.metadataStore.Container(containerID).Save(
 		func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
 			// make sure the container is not removed during the call
 			if c != nil {
 				c.State = types.ContainerState_CONTAINER_RUNNING
 				c.StartedAt = v.clock.Now().UnixNano()
+				c.Reason = ""
+				c.Message = ""
+			}
+			return c, nil
+		}); err != nil {
+		return err
+	}
+
+	config, _, err := v.getVMConfigFromMetadata(containerID)
+	if err != nil {
+		return err
+	}
+	v.notifyLifecycleEvent(webhook.EventStarted, containerID, config)
+	if config != nil && config.ParsedAnnotations.BootTimeoutSeconds > 0 {
+		v.startBootWatch(containerID, domain, config)
+	}
+	if config != nil && config.ParsedAnnotations.GuestLogFile != "" {
+		go v.streamGuestLog(domain, config, false)
+	}
+	if config != nil && config.ParsedAnnotations.VCPUAutoscale {
+		v.startVCPUAutoscaling(containerID, domain, config)
+	}
+	if config != nil && config.ParsedAnnotations.PostStartHook != "" {
+		// the hook may need to wait for the VM to boot, which
+		// must not hold up the CRI StartContainer request
+		v.startPostStartHook(containerID, domain, config)
+	}
+
+	return nil
+}
+
+// recordStartFailure captures the qemu log of the domain that has
+// failed to start and stores the failure reason together with the
+// tail of the log in the container metadata, so they're reported
+// in the container status. It returns startErr with the tail of
+// the log appended to it.
+func (v *VirtualizationTool) recordStartFailure(containerID string, domain virt.Domain, startErr error) error {
+	v.saveStartRecord(containerID, domain, startErr)
+	message := startErr.Error()
+	config, _, err := v.getVMConfigFromMetadata(containerID)
+	if err != nil {
+		glog.Warningf("Can't capture qemu log for domain %q: %v", containerID, err)
+	} else if config != nil {
+		if logTail, err := v.captureDomainQemuLog(domain, config); err != nil {
+			glog.Warningf("Can't capture qemu log for domain %q: %v", containerID, err)
+		} else if logTail != "" {
+			message = fmt.Sprintf("%s\nqemu log:\n%s", message, logTail)
+		}
+	}
+
+	if err := v.metadataStore.Container(containerID).Save(
+		func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
+			// make sure the container is not removed during the call
+			if c != nil {
+				c.Reason = ContainerReasonVMStartFailed
+				c.Message = message
 			}
 			return c, nil
-		})
+		}); err != nil {
+		glog.Errorf("Error storing start failure info for container %q: %v", containerID, err)
+	}
+
+	return errors.New(message)
+}
+
+// checkRestartBackoff returns an error if the VM of the pod was
+// started less than RestartBackoffSeconds ago. As kubelet restarts
+// the containers by removing them and creating new ones, the last
+// start time is taken from the start records of the pod, which
+// survive the container removal.
+func (v *VirtualizationTool) checkRestartBackoff(containerID string) error {
+	containerInfo, err := v.metadataStore.Container(containerID).Retrieve()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve the info for container %q: %v", containerID, err)
+	}
+	if containerInfo == nil {
+		return nil
+	}
+	backoff := time.Duration(containerInfo.Config.ParsedAnnotations.RestartBackoffSeconds) * time.Second
+	if backoff == 0 {
+		return nil
+	}
+	lastStart := containerInfo.StartedAt
+	records, err := v.metadataStore.ListStartRecords(containerInfo.Config.PodNamespace, containerInfo.Config.PodName)
+	if err != nil {
+		return fmt.Errorf("failed to list the start records for container %q: %v", containerID, err)
+	}
+	for _, record := range records {
+		if record.Error == "" && record.Timestamp > lastStart {
+			lastStart = record.Timestamp
+		}
+	}
+	if lastStart == 0 {
+		return nil
+	}
+	startedAt := time.Unix(0, lastStart)
+	if elapsed := v.clock.Now().Sub(startedAt); elapsed < backoff {
+		return fmt.Errorf("domain %q: restart backoff in effect, can't restart it for another %v", containerID, backoff-elapsed)
+	}
+	return nil
+}
+
+// softRebootEnabled returns true if soft reboot is enabled for the
+// VM.
+func (v *VirtualizationTool) softRebootEnabled(containerID string) bool {
+	config, _, err := v.getVMConfigFromMetadata(containerID)
+	return err == nil && config != nil && config.ParsedAnnotations.SoftReboot
 }
 
 // StartContainer calls libvirt to start domain, waits up to 10 seconds for
 // DOMAIN_RUNNING state, then updates it's state in metadata store.
 // If there was an error it will be returned to caller after an domain removal
 // attempt.  If also it had an error - both of them will be combined.
+// If soft reboot is enabled for the VM and its domain is preserved
+// after a guest crash, the domain is reset instead of being started.
 func (v *VirtualizationTool) StartContainer(containerID string) error {
 	return v.startContainer(containerID)
 }
 
+// RebootContainer asks the guest OS of the VM to reboot. The VM
+// must be running. If the domain doesn't exist, virt.ErrDomainNotFound
+// is returned.
+func (v *VirtualizationTool) RebootContainer(containerID string) error {
+	domain, err := v.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		return err
+	}
+	state, err := domain.State()
+	if err != nil {
+		return fmt.Errorf("failed to get state of the domain %q: %v", containerID, err)
+	}
+	if state != virt.DomainStateRunning {
+		return fmt.Errorf("domain %q is not running", containerID)
+	}
+	if err := domain.Reboot(); err != nil {
+		return fmt.Errorf("failed to reboot domain %q: %v", containerID, err)
+	}
+	return nil
+}
+
 // StopContainer calls graceful shutdown of domain and if it was non successful
 // it calls libvirt to destroy that domain.
 // Successful shutdown or destroy of domain is followed by removal of
 // VM info from metadata store.
 // Succeeded removal of metadata is followed by volumes cleanup.
+// The timeout is the time given to the guest to shut down. If it's
+// zero, the termination grace period from the VM pod settings is
+// used instead, and if there's none, the domain is destroyed right
+// away.
+// If soft reboot is enabled for the VM, the volumes are kept so
+// the VM can be restarted in place.
 func (v *VirtualizationTool) StopContainer(containerID string, timeout time.Duration) error {
+	v.bootWatchers.stop(containerID)
+	v.vcpuAutoscalers.stop(containerID)
+	v.postStartHooks.stop(containerID)
+	config, _, err := v.getVMConfigFromMetadata(containerID)
+	if err != nil {
+		// the VM config is only needed for the termination
+		// grace period, the hooks and the soft reboot
+		glog.Warningf("Stopping container %q without its VM config", containerID)
+		config = nil
+	}
+	if timeout == 0 && config != nil && config.ParsedAnnotations.TerminationGracePeriodSeconds > 0 {
+		timeout = time.Duration(config.ParsedAnnotations.TerminationGracePeriodSeconds) * time.Second
+	}
+
 	domain, err := v.domainConn.LookupDomainByUUIDString(containerID)
 	if err != nil {
 		return err
 	}
 
-	// We try to shut down the VM gracefully first. This may take several attempts
-	// because shutdown requests may be ignored e.g. when the VM boots.
-	// If this fails, we just destroy the domain (i.e. power off the VM).
-	err = utils.WaitLoop(func() (bool, error) {
-		_, err := v.domainConn.LookupDomainByUUIDString(containerID)
-		if err == virt.ErrDomainNotFound {
-			return true, nil
-		}
-		if err != nil {
-			return false, fmt.Errorf("failed to look up the domain %q: %v", containerID, err)
-		}
-
-		// domain.Shutdown() may return 'invalid operation' error if domain is already
-		// shut down. But checking the state beforehand will not make the situation
-		// any simpler because we'll still have a race, thus we need multiple attempts
-		domainShutdownErr := domain.Shutdown()
-
-		state, err := domain.State()
-		if err != nil {
-			return false, fmt.Errorf("failed to get state of the domain %q: %v", containerID, err)
-		}
-
-		if state == virt.DomainStateShutoff {
-			return true, nil
-		}
-
-		if domainShutdownErr != nil {
-			// The domain is not in 'DOMAIN_SHUTOFF' state and domain.Shutdown() failed,
-			// so we need to return the error that happened during Shutdown()
-			return false, fmt.Errorf("failed to shut down domain %q: %v", containerID, err)
-		}
-
-		return false, nil
-	}, domainShutdownRetryInterval, timeout, v.clock)
-
-	if err != nil {
-		glog.Warningf("Failed to shut down VM %q: %v -- trying to destroy the domain", containerID, err)
-		// if the domain is destroyed successfully we return no error
-		if err = domain.Destroy(); err != nil {
-			return fmt.Errorf("failed to destroy the domain: %v", err)
+	if timeout > 0 && config != nil && config.ParsedAnnotations.PreStopHook != "" {
+		if state, err := domain.State(); err == nil && state == virt.DomainStateRunning {
+			// The time taken by the pre-stop hook counts
+			// towards the termination grace period.
+			// The failure of the hook doesn't prevent
+			// the VM from being stopped.
+			hookTimeout := guestHookTimeout(config)
+			if timeout < hookTimeout {
+				hookTimeout = timeout
+			}
+			start := v.clock.Now()
+			if err := v.runGuestHook(domain, config, guestHookPreStop, config.ParsedAnnotations.PreStopHook, hookTimeout); err != nil {
+				glog.Warningf("Pre-stop hook failed for domain %q: %v", containerID, err)
+			}
+			// If the hook has used up the whole grace period,
+			// the domain is destroyed right away
+			timeout -= v.clock.Since(start)
+			if timeout < 0 {
+				timeout = 0
+			}
 		}
 	}
 
+	err = v.shutDownDomain(containerID, domain, timeout)
 	if err == nil {
+		stopped := false
 		err = v.metadataStore.Container(containerID).Save(
 			func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
 				// make sure the container is not removed during the call
 				if c != nil {
+					// the stop may have been already noticed
+					// by ContainerInfo()
+					stopped = c.State != types.ContainerState_CONTAINER_EXITED
 					c.State = types.ContainerState_CONTAINER_EXITED
 				}
 				return c, nil
 			})
+		if err == nil && stopped {
+			v.notifyLifecycleEvent(webhook.EventStopped, containerID, config)
+		}
+	}
+
+	if err == nil && config != nil && config.ParsedAnnotations.SoftReboot {
+		// The volumes are kept upon RemoveContainer(), too,
+		// so they can be reused by the re-created container
+		glog.V(2).Infof("Soft reboot enabled for container %q, keeping the volumes", containerID)
+		return nil
 	}
 
 	if err == nil {
@@ -591,6 +1089,60 @@ func (v *VirtualizationTool) StopContainer(containerID string, timeout time.Dura
 	return err
 }
 
+// shutDownDomain tries to shut down the domain gracefully within the
+// specified timeout and destroys it if that fails. Zero timeout means
+// that the domain must be destroyed right away.
+func (v *VirtualizationTool) shutDownDomain(containerID string, domain virt.Domain, timeout time.Duration) error {
+	if timeout > 0 {
+		// We try to shut down the VM gracefully first. This may take several attempts
+		// because shutdown requests may be ignored e.g. when the VM boots.
+		// If this fails, we just destroy the domain (i.e. power off the VM).
+		err := utils.WaitLoop(func() (bool, error) {
+			_, err := v.domainConn.LookupDomainByUUIDString(containerID)
+			if err == virt.ErrDomainNotFound {
+				return true, nil
+			}
+			if err != nil {
+				return false, fmt.Errorf("failed to look up the domain %q: %v", containerID, err)
+			}
+
+			// domain.Shutdown() may return 'invalid operation' error if domain is already
+			// shut down. But checking the state beforehand will not make the situation
+			// any simpler because we'll still have a race, thus we need multiple attempts
+			domainShutdownErr := domain.Shutdown()
+
+			state, err := domain.State()
+			if err != nil {
+				return false, fmt.Errorf("failed to get state of the domain %q: %v", containerID, err)
+			}
+
+			if state == virt.DomainStateShutoff {
+				return true, nil
+			}
+
+			if domainShutdownErr != nil {
+				// The domain is not in 'DOMAIN_SHUTOFF' state and domain.Shutdown() failed,
+				// so we need to return the error that happened during Shutdown()
+				return false, fmt.Errorf("failed to shut down domain %q: %v", containerID, err)
+			}
+
+			return false, nil
+		}, domainShutdownRetryInterval, timeout, v.clock)
+
+		if err == nil {
+			return nil
+		}
+		glog.Warningf("Failed to shut down VM %q: %v -- trying to destroy the domain", containerID, err)
+	} else if state, err := domain.State(); err == nil && state == virt.DomainStateShutoff {
+		return nil
+	}
+
+	if err := domain.Destroy(); err != nil {
+		return fmt.Errorf("failed to destroy the domain: %v", err)
+	}
+	return nil
+}
+
 func (v *VirtualizationTool) getVMConfigFromMetadata(containerID string) (*types.VMConfig, types.ContainerState, error) {
 	containerInfo, err := v.metadataStore.Container(containerID).Retrieve()
 	if err != nil {
@@ -606,7 +1158,7 @@ func (v *VirtualizationTool) getVMConfigFromMetadata(containerID string) (*types
 }
 
 func (v *VirtualizationTool) cleanupVolumes(containerID string) error {
-	config, _, err := v.getVMConfigFromMetadata(containerID)
+	config, state, err := v.getVMConfigFromMetadata(containerID)
 	if err != nil {
 		return err
 	}
@@ -617,7 +1169,14 @@ func (v *VirtualizationTool) cleanupVolumes(containerID string) error {
 	}
 
 	diskList, err := newDiskList(config, v.volumeSource, v)
-	if err == nil {
+	switch {
+	case err != nil:
+	case v.shouldRetainVolumes(state):
+		// The root volume and the config drive are kept so
+		// the VM can be restarted quickly. They're torn down
+		// or retained for reuse upon RemoveContainer()
+		err = diskList.teardownNonRetainable()
+	default:
 		err = diskList.teardown()
 	}
 
@@ -638,6 +1197,12 @@ func (v *VirtualizationTool) removeDomain(containerID string, config *types.VMCo
 		return err
 	}
 
+	retain := v.shouldRetainVolumesOnRemoval(config, state) && v.retainVolumes(containerID, config)
+	// With soft reboot enabled, the domain is kept along with its
+	// volumes, so it's redefined and restarted in place after the
+	// container is re-created
+	keepDomain := retain && config.ParsedAnnotations != nil && config.ParsedAnnotations.SoftReboot
+
 	if domain != nil {
 		if state == types.ContainerState_CONTAINER_RUNNING {
 			if err := domain.Destroy(); err != nil {
@@ -645,6 +1210,21 @@ func (v *VirtualizationTool) removeDomain(containerID string, config *types.VMCo
 			}
 		}
 
+		// Preserve the qemu log in the pod log directory
+		// before the domain goes away
+		if _, err := v.captureDomainQemuLog(domain, config); err != nil {
+			glog.Warningf("Can't capture qemu log for domain %q: %v", containerID, err)
+		}
+	}
+
+	if domain != nil && keepDomain {
+		// the domain may be preserved after a guest crash
+		if state, err := domain.State(); err == nil && state != virt.DomainStateShutoff {
+			if err := domain.Destroy(); err != nil {
+				return fmt.Errorf("failed to destroy the domain: %v", err)
+			}
+		}
+	} else if domain != nil {
 		if err := domain.Undefine(); err != nil {
 			return fmt.Errorf("error undefining the domain %q: %v", containerID, err)
 		}
@@ -663,8 +1243,24 @@ func (v *VirtualizationTool) removeDomain(containerID string, config *types.VMCo
 		}
 	}
 
+	if err := removeSerialChannels(config); err != nil {
+		glog.Warningf("Error removing serial channel sockets for container %s: %v", containerID, err)
+	}
+
+	if err := removeMdevs(config); err != nil {
+		glog.Warningf("Error removing mediated devices for container %s: %v", containerID, err)
+	}
+
+	if err := v.restoreAdoptedDomain(config); err != nil {
+		glog.Warningf("Error restoring the domain adopted by container %s: %v", containerID, err)
+	}
+
 	diskList, err := newDiskList(config, v.volumeSource, v)
-	if err == nil {
+	switch {
+	case err != nil:
+	case retain:
+		err = diskList.teardownNonRetainable()
+	default:
 		err = diskList.teardown()
 	}
 
@@ -683,32 +1279,67 @@ func (v *VirtualizationTool) removeDomain(containerID string, config *types.VMCo
 // even if it's still running.
 // It waits up to 5 sec for doing the job by libvirt.
 func (v *VirtualizationTool) RemoveContainer(containerID string) error {
-	config, state, err := v.getVMConfigFromMetadata(containerID)
+	containerInfo, err := v.removeContainerDomain(containerID)
+	if err != nil || containerInfo == nil {
+		return err
+	}
 
-	if err != nil {
+	// the container may have been re-created with the same id
+	// while its domain was being removed, in which case the new
+	// container must be kept
+	if err := v.metadataStore.Container(containerID).Save(metadata.ExpectContainerRevision(containerInfo.Revision,
+		func(_ *types.ContainerInfo) (*types.ContainerInfo, error) {
+			return nil, nil // delete container
+		},
+	)); err != nil {
+		glog.Errorf("Error when removing container '%s' from metadata store: %v", containerID, err)
 		return err
 	}
 
-	if config == nil {
+	v.containerRemoved(containerID, &containerInfo.Config)
+	return nil
+}
+
+// removeContainerDomain removes the domain of the container along
+// with its volumes, leaving the container metadata intact. It
+// returns the container info that was used for the removal, or nil
+// if there's no such container in the metadata store.
+func (v *VirtualizationTool) removeContainerDomain(containerID string) (*types.ContainerInfo, error) {
+	containerInfo, err := v.metadataStore.Container(containerID).Retrieve()
+	if err != nil {
+		glog.Errorf("Error when retrieving domain %q info from metadata store: %v", containerID, err)
+		return nil, err
+	}
+
+	if containerInfo == nil {
 		glog.Warningf("No info found for domain %q in metadata store. Domain cleanup skipped", containerID)
-		return nil
+		return nil, nil
 	}
+	config, state := &containerInfo.Config, containerInfo.State
 
+	if err := v.checkVolumeDeletion(containerID, config); err != nil {
+		return nil, err
+	}
+
+	v.bootWatchers.stop(containerID)
+	v.vcpuAutoscalers.stop(containerID)
+	v.postStartHooks.stop(containerID)
 	if err := v.removeDomain(containerID, config, state, state == types.ContainerState_CONTAINER_CREATED ||
 		state == types.ContainerState_CONTAINER_RUNNING); err != nil {
-		return err
+		return nil, err
 	}
+	v.removeBootDiagnostics(config)
+	v.forgetDiskStats(containerID)
+	return containerInfo, nil
+}
 
-	if v.metadataStore.Container(containerID).Save(
-		func(_ *types.ContainerInfo) (*types.ContainerInfo, error) {
-			return nil, nil // delete container
-		},
-	); err != nil {
-		glog.Errorf("Error when removing container '%s' from metadata store: %v", containerID, err)
-		return err
+// containerRemoved finishes the removal of the container after its
+// metadata is deleted.
+func (v *VirtualizationTool) containerRemoved(containerID string, config *types.VMConfig) {
+	if err := removeDeletionConfirmation(config); err != nil {
+		glog.Warningf("Error removing volume deletion confirmation for container %s: %v", containerID, err)
 	}
-
-	return nil
+	v.notifyLifecycleEvent(webhook.EventRemoved, containerID, config)
 }
 
 func virtToKubeState(domainState virt.DomainState, lastState types.ContainerState) types.ContainerState {
@@ -720,9 +1351,12 @@ func virtToKubeState(domainState virt.DomainState, lastState types.ContainerStat
 		fallthrough
 	case virt.DomainStateRunning:
 		containerState = types.ContainerState_CONTAINER_RUNNING
-	case virt.DomainStatePaused:
-		if lastState == types.ContainerState_CONTAINER_CREATED {
-			containerState = types.ContainerState_CONTAINER_CREATED
+	case virt.DomainStatePaused, virt.DomainStatePMSuspended:
+		// a paused or suspended VM is still there and can be
+		// resumed (see CheckSuspendedVMs), so it must not be
+		// restarted by the kubelet
+		if lastState == types.ContainerState_CONTAINER_CREATED || lastState == types.ContainerState_CONTAINER_RUNNING {
+			containerState = lastState
 		} else {
 			containerState = types.ContainerState_CONTAINER_EXITED
 		}
@@ -734,8 +1368,6 @@ func virtToKubeState(domainState virt.DomainState, lastState types.ContainerStat
 		}
 	case virt.DomainStateCrashed:
 		containerState = types.ContainerState_CONTAINER_EXITED
-	case virt.DomainStatePMSuspended:
-		containerState = types.ContainerState_CONTAINER_EXITED
 	default:
 		containerState = types.ContainerState_CONTAINER_UNKNOWN
 	}
@@ -795,25 +1427,34 @@ func (v *VirtualizationTool) ListContainers(filter *types.ContainerFilter) ([]*t
 	default:
 		// Get list of all the defined domains from libvirt
 		// and check each container against the remaining
-		// filter settings
+		// filter settings. The container metadata is read
+		// at once as a snapshot so the listing isn't made
+		// to wait for the metadata writes for each domain
 		domains, err := v.domainConn.ListDomains()
 		if err != nil {
 			return nil, err
 		}
+		infos, err := v.metadataStore.ListContainerInfos()
+		if err != nil {
+			return nil, err
+		}
+		infoByID := make(map[string]*types.ContainerInfo)
+		for _, ci := range infos {
+			infoByID[ci.Id] = ci
+		}
 		for _, domain := range domains {
 			containerID, err := domain.UUIDString()
 			if err != nil {
 				return nil, err
 			}
-			containerInfo, err := v.ContainerInfo(containerID)
-			if err != nil {
-				return nil, err
-			}
-
+			containerInfo := infoByID[containerID]
 			if containerInfo == nil {
 				glog.V(1).Infof("Failed to find info for domain with id %q in virtlet db, considering it a non-virtlet libvirt domain.", containerID)
 				continue
 			}
+			if err := v.syncContainerState(domain, containerInfo); err != nil {
+				return nil, err
+			}
 			containers = append(containers, containerInfo)
 		}
 	}
@@ -848,27 +1489,58 @@ func (v *VirtualizationTool) ContainerInfo(containerID string) (*types.Container
 		return nil, nil
 	}
 
+	if err := v.syncContainerState(domain, containerInfo); err != nil {
+		return nil, err
+	}
+	if containerInfo.Message == "" {
+		containerInfo.Message = v.progressMessage(containerID)
+	}
+	return containerInfo, nil
+}
+
+// syncContainerState updates the state of the container in
+// containerInfo and in the metadata store according to the state of
+// its domain. The metadata store is only updated if the state has
+// changed.
+func (v *VirtualizationTool) syncContainerState(domain virt.Domain, containerInfo *types.ContainerInfo) error {
 	state, err := domain.State()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
+	containerID := containerInfo.Id
 	containerState := virtToKubeState(state, containerInfo.State)
 	if containerInfo.State != containerState {
-		if err := v.metadataStore.Container(containerID).Save(
+		exited := false
+		// the new state is based on the one that was retrieved
+		// before, so it must not overwrite the changes made
+		// concurrently, such as the ones made by StopContainer()
+		err := v.metadataStore.Container(containerID).Save(metadata.ExpectContainerRevision(containerInfo.Revision,
 			func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
-				// make sure the container is not removed during the call
-				if c != nil {
-					c.State = containerState
-				}
+				exited = c.State == types.ContainerState_CONTAINER_RUNNING &&
+					containerState == types.ContainerState_CONTAINER_EXITED
+				c.State = containerState
 				return c, nil
 			},
-		); err != nil {
-			return nil, err
+		))
+		switch {
+		case err == metadata.ErrRevisionMismatch:
+			glog.V(2).Infof("Container %q was changed concurrently, not updating its state", containerID)
+			return nil
+		case err != nil:
+			return err
 		}
 		containerInfo.State = containerState
+		if exited {
+			// the VM has stopped or crashed by itself
+			eventType := webhook.EventStopped
+			if state == virt.DomainStateCrashed {
+				eventType = webhook.EventCrashed
+			}
+			v.notifyLifecycleEvent(eventType, containerID, &containerInfo.Config)
+		}
 	}
-	return containerInfo, nil
+	return nil
 }
 
 // VMStats returns current cpu/memory/disk usage for VM
@@ -883,11 +1555,11 @@ func (v *VirtualizationTool) VMStats(containerID string, name string) (*types.VM
 		Name:        name,
 	}
 
-	rss, err := domain.GetRSS()
+	memStats, err := domain.GetMemoryStats()
 	if err != nil {
 		return nil, err
 	}
-	vs.MemoryUsage = rss
+	vs.MemoryUsage, vs.MemoryAvailable = vmMemoryUsage(memStats)
 
 	cpuTime, err := domain.GetCPUTime()
 	if err != nil {
@@ -922,11 +1594,164 @@ func (v *VirtualizationTool) VMStats(containerID string, name string) (*types.VM
 	}
 	vs.FsBytes = rootDiskSize
 
-	glog.V(4).Infof("VMStats - cpu: %d, mem: %d, disk: %d, timestamp: %d", vs.CpuUsage, vs.MemoryUsage, vs.FsBytes, vs.Timestamp)
+	glog.V(4).Infof("VMStats - cpu: %d, mem: %d, mem available: %d, disk: %d, timestamp: %d", vs.CpuUsage, vs.MemoryUsage, vs.MemoryAvailable, vs.FsBytes, vs.Timestamp)
 
 	return &vs, nil
 }
 
+// vmMemoryUsage returns the working set memory of the VM along with
+// the amount of memory available to the guest. If the balloon driver
+// reports the guest memory stats, the working set is the memory used by
+// the guest, otherwise it's the RSS of the emulator process and the
+// available memory is unknown (0).
+func vmMemoryUsage(stats *virt.MemoryStats) (uint64, uint64) {
+	if stats.LastUpdate == 0 || stats.Available == 0 {
+		return stats.RSS, 0
+	}
+	free := stats.Usable
+	if free == 0 {
+		// older guest kernels don't report MemAvailable
+		free = stats.Unused
+	}
+	if free > stats.Available {
+		return stats.RSS, 0
+	}
+	return stats.Available - free, free
+}
+
+// MemoryStats returns memory statistics of the VM which corresponds
+// to the specified container.
+func (v *VirtualizationTool) MemoryStats(containerID string) (*types.VMMemoryStats, error) {
+	domain, err := v.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		return nil, err
+	}
+	memStats, err := domain.GetMemoryStats()
+	if err != nil {
+		return nil, err
+	}
+	workingSet, available := vmMemoryUsage(memStats)
+	return &types.VMMemoryStats{
+		WorkingSet: workingSet,
+		Available:  available,
+		RSS:        memStats.RSS,
+		Actual:     memStats.Actual,
+		Unused:     memStats.Unused,
+		LastUpdate: memStats.LastUpdate,
+	}, nil
+}
+
+// diskStatsSample holds the block stats of the disks of a VM taken
+// at the specified time, keyed by the target device name.
+type diskStatsSample struct {
+	takenAt time.Time
+	stats   map[string]*virt.BlockStats
+}
+
+// DiskStats returns IO statistics for each disk of the VM
+// which corresponds to the specified container. The average
+// latencies are derived from the total times spent on the requests,
+// and the IOPS are calculated using the stats taken the previous
+// time DiskStats was called for the container.
+func (v *VirtualizationTool) DiskStats(containerID string) ([]types.VMDiskStats, error) {
+	domain, err := v.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	config, _, err := v.getVMConfigFromMetadata(containerID)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, fmt.Errorf("no info found for domain %q in the metadata store", containerID)
+	}
+
+	domainxml, err := domain.XML()
+	if err != nil {
+		return nil, err
+	}
+
+	diskList, err := newDiskList(config, v.volumeSource, v)
+	if err != nil {
+		return nil, err
+	}
+
+	// the disks are matched to the volumes by their target
+	// device names, as the disks may be reordered in the domain
+	// definition, e.g. after a hotplug
+	domainDevs := make(map[string]bool)
+	for _, disk := range domainxml.Devices.Disks {
+		if disk.Target != nil {
+			domainDevs[disk.Target.Dev] = true
+		}
+	}
+	v.diskStatsLock.Lock()
+	defer v.diskStatsLock.Unlock()
+	prev, hasPrev := v.diskStatsSamples[containerID]
+	sample := diskStatsSample{
+		takenAt: v.clock.Now(),
+		stats:   make(map[string]*virt.BlockStats),
+	}
+	interval := sample.takenAt.Sub(prev.takenAt).Seconds()
+	var r []types.VMDiskStats
+	for _, item := range diskList.items {
+		if !item.volume.IsDisk() || item.driver == nil {
+			continue
+		}
+		dev := item.driver.target().Dev
+		if !domainDevs[dev] {
+			continue
+		}
+		stats, err := domain.GetBlockStats(dev)
+		if err != nil {
+			return nil, fmt.Errorf("error getting block stats for disk %q of domain %q: %v", dev, containerID, err)
+		}
+		sample.stats[dev] = stats
+		diskStats := types.VMDiskStats{
+			Dev:            dev,
+			VolumeName:     podVolumeName(item.volume),
+			ReadRequests:   stats.ReadRequests,
+			ReadBytes:      stats.ReadBytes,
+			ReadTimeNs:     stats.ReadTimeNs,
+			WriteRequests:  stats.WriteRequests,
+			WriteBytes:     stats.WriteBytes,
+			WriteTimeNs:    stats.WriteTimeNs,
+			FlushRequests:  stats.FlushRequests,
+			FlushTimeNs:    stats.FlushTimeNs,
+			ReadLatencyNs:  averageRequestTime(stats.ReadTimeNs, stats.ReadRequests),
+			WriteLatencyNs: averageRequestTime(stats.WriteTimeNs, stats.WriteRequests),
+			FlushLatencyNs: averageRequestTime(stats.FlushTimeNs, stats.FlushRequests),
+		}
+		// the counters are reset when the VM is restarted,
+		// in which case there's no usable previous sample
+		if prevStats := prev.stats[dev]; hasPrev && interval > 0 && prevStats != nil &&
+			stats.ReadRequests >= prevStats.ReadRequests && stats.WriteRequests >= prevStats.WriteRequests {
+			diskStats.ReadIOPS = float64(stats.ReadRequests-prevStats.ReadRequests) / interval
+			diskStats.WriteIOPS = float64(stats.WriteRequests-prevStats.WriteRequests) / interval
+		}
+		r = append(r, diskStats)
+	}
+	v.diskStatsSamples[containerID] = sample
+
+	return r, nil
+}
+
+// forgetDiskStats removes the disk stats sample kept for the
+// specified container.
+func (v *VirtualizationTool) forgetDiskStats(containerID string) {
+	v.diskStatsLock.Lock()
+	defer v.diskStatsLock.Unlock()
+	delete(v.diskStatsSamples, containerID)
+}
+
+func averageRequestTime(totalTimeNs, requests int64) int64 {
+	if requests == 0 {
+		return 0
+	}
+	return totalTimeNs / requests
+}
+
 // ListVMStats returns statistics (same as VMStats) for all containers matching
 // provided filter (id AND podstandboxid AND labels)
 func (v *VirtualizationTool) ListVMStats(filter *types.VMStatsFilter) ([]types.VMStats, error) {
@@ -977,7 +1802,11 @@ func (v *VirtualizationTool) StorageConnection() virt.StorageConnection { return
 func (v *VirtualizationTool) ImageManager() ImageManager { return v.imageManager }
 
 // RawDevices implements volumeOwner RawDevices method
-func (v *VirtualizationTool) RawDevices() []string { return v.config.RawDevices }
+func (v *VirtualizationTool) RawDevices() []string {
+	v.configLock.Lock()
+	defer v.configLock.Unlock()
+	return v.config.RawDevices
+}
 
 // KubeletRootDir implements volumeOwner KubeletRootDir method
 func (v *VirtualizationTool) KubeletRootDir() string { return v.config.KubeletRootDir }
@@ -994,6 +1823,9 @@ func (v *VirtualizationTool) SharedFilesystemPath() string { return v.config.Sha
 // Commander implements volumeOwner Commander method
 func (v *VirtualizationTool) Commander() utils.Commander { return v.commander }
 
+// MetadataStore implements volumeOwner MetadataStore method
+func (v *VirtualizationTool) MetadataStore() metadata.Store { return v.metadataStore }
+
 func filterContainer(containerInfo *types.ContainerInfo, filter types.ContainerFilter) bool {
 	if filter.Id != "" && containerInfo.Id != filter.Id {
 		return false
@@ -22,7 +22,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -41,7 +43,9 @@ import (
 	"github.com/Mirantis/virtlet/pkg/utils"
 	fakeutils "github.com/Mirantis/virtlet/pkg/utils/fake"
 	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+	"github.com/Mirantis/virtlet/pkg/virt"
 	"github.com/Mirantis/virtlet/pkg/virt/fake"
+	"github.com/Mirantis/virtlet/pkg/webhook"
 	"github.com/Mirantis/virtlet/tests/gm"
 )
 
@@ -80,15 +84,14 @@ func newContainerTester(t *testing.T, rec *testutils.TopLevelRecorder, cmds []fa
 
 	// __config__  is a hint for fake libvirt domain to fix the path so it becomes non-volatile
 	SetConfigIsoDir(filepath.Join(ct.tmpDir, "__config__"))
+	SetSerialChannelDir(filepath.Join(ct.tmpDir, "channels"))
+	SetDeletionConfirmationDir(filepath.Join(ct.tmpDir, "deletion-confirmations"))
 
 	ct.rec = rec
 	ct.domainConn = fake.NewFakeDomainConnection(ct.rec.Child("domain conn"))
 	ct.storageConn = fake.NewFakeStorageConnection(ct.rec.Child("storage"))
 
-	ct.metadataStore, err = metadata.NewFakeStore()
-	if err != nil {
-		t.Fatalf("Failed to create fake bolt client: %v", err)
-	}
+	ct.metadataStore = metadata.NewMemStore()
 
 	imageManager := newFakeImageManager(ct.rec)
 	ct.kubeletRootDir = filepath.Join(ct.tmpDir, "__fs__/kubelet-root")
@@ -329,6 +332,540 @@ func TestDoubleStartError(t *testing.T) {
 	}
 }
 
+func TestRebootContainer(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	ct.setPodSandbox(sandbox)
+
+	containerID := ct.createContainer(sandbox, nil, nil)
+	if err := ct.virtTool.RebootContainer(containerID); err == nil {
+		t.Errorf("RebootContainer() didn't fail for a VM that's not running")
+	}
+	ct.startContainer(containerID)
+	if err := ct.virtTool.RebootContainer(containerID); err != nil {
+		t.Errorf("RebootContainer(): %v", err)
+	}
+	if err := ct.virtTool.RebootContainer("no-such-container"); err != virt.ErrDomainNotFound {
+		t.Errorf("RebootContainer() returned %v instead of ErrDomainNotFound for a non-existent VM", err)
+	}
+}
+
+func TestSoftReboot(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	sandbox.Annotations["VirtletSoftReboot"] = "true"
+	ct.setPodSandbox(sandbox)
+
+	containerID := ct.createContainer(sandbox, nil, nil)
+	vol := ct.rootVolume(containerID)
+	if vol == nil {
+		t.Fatalf("root volume not found after creating the container")
+	}
+	ct.clock.Advance(1 * time.Second)
+	ct.startContainer(containerID)
+	ct.stopContainer(containerID)
+	if ct.rootVolume(containerID) != vol {
+		t.Errorf("root volume was not kept upon StopContainer() with soft reboot enabled")
+	}
+
+	// kubelet restarts the container by removing it and
+	// creating a new one in the same pod sandbox
+	for i := 0; i < 2; i++ {
+		ct.removeContainer(containerID)
+		if ct.rootVolume(containerID) != vol {
+			t.Errorf("root volume was not kept upon RemoveContainer() with soft reboot enabled")
+		}
+		if _, err := ct.domainConn.LookupDomainByUUIDString(containerID); err != nil {
+			t.Errorf("domain was not kept upon RemoveContainer() with soft reboot enabled: %v", err)
+		}
+		// soft reboot doesn't depend on the volume reuse TTL
+		ct.clock.Advance(time.Hour)
+		if newContainerID := ct.createContainer(sandbox, nil, nil); newContainerID != containerID {
+			t.Fatalf("container id changed after re-creating the container: %q instead of %q", newContainerID, containerID)
+		}
+		if ct.rootVolume(containerID) != vol {
+			t.Errorf("root volume was not reused after re-creating the container")
+		}
+		ct.startContainer(containerID)
+		ct.stopContainer(containerID)
+	}
+	if n := countDomainCalls(ct.rec, "Undefine"); n != 0 {
+		t.Errorf("the domain was undefined %d times instead of being redefined in place", n)
+	}
+
+	// the domain preserved after a guest crash is reset in place
+	ct.removeContainer(containerID)
+	ct.createContainer(sandbox, nil, nil)
+	ct.startContainer(containerID)
+	domain, err := ct.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		t.Fatalf("LookupDomainByUUIDString(): %v", err)
+	}
+	domain.(*fake.FakeDomain).SetState(virt.DomainStateCrashed)
+	if container := ct.containerInfo(containerID); container.State != types.ContainerState_CONTAINER_EXITED {
+		t.Errorf("bad state of the crashed VM: %v instead of %v", container.State, types.ContainerState_CONTAINER_EXITED)
+	}
+	ct.startContainer(containerID)
+	if n := countDomainCalls(ct.rec, "Reset"); n != 1 {
+		t.Errorf("the crashed domain was reset %d times instead of once", n)
+	}
+	if container := ct.containerInfo(containerID); container.State != types.ContainerState_CONTAINER_RUNNING {
+		t.Errorf("bad state of the VM after the reset: %v instead of %v", container.State, types.ContainerState_CONTAINER_RUNNING)
+	}
+	ct.stopContainer(containerID)
+
+	// the volumes are kept until the pod sandbox is removed
+	ct.removeContainer(containerID)
+	if ids := ct.retainedVolumeIDs(); !reflect.DeepEqual(ids, []string{containerID}) {
+		t.Errorf("bad retained volume ids: %v", ids)
+	}
+	if err := ct.metadataStore.PodSandbox(sandbox.Uid).Save(func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatalf("error removing the pod sandbox: %v", err)
+	}
+	if errors := ct.virtTool.removeOrphanRootVolumes(ct.retainedVolumeIDs()); len(errors) != 0 {
+		t.Errorf("removeOrphanRootVolumes returned errors: %v", errors)
+	}
+	if ct.rootVolume(containerID) != nil {
+		t.Errorf("root volume was not removed after the removal of the pod sandbox")
+	}
+}
+
+func countDomainCalls(rec *testutils.TopLevelRecorder, method string) int {
+	n := 0
+	for _, r := range rec.Content() {
+		if strings.HasSuffix(r.Name, ": "+method) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestStopContainerTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		gracePeriod      string
+		timeout          time.Duration
+		advance          []time.Duration
+		expectedShutdown int
+	}{
+		{
+			name:    "zero timeout",
+			timeout: 0,
+		},
+		{
+			name:             "zero timeout with the grace period",
+			gracePeriod:      "10",
+			timeout:          0,
+			advance:          []time.Duration{5 * time.Second, 5 * time.Second},
+			expectedShutdown: 2,
+		},
+		{
+			name:             "timeout longer than the grace period",
+			gracePeriod:      "10",
+			timeout:          20 * time.Second,
+			advance:          []time.Duration{5 * time.Second, 5 * time.Second, 5 * time.Second, 5 * time.Second},
+			expectedShutdown: 4,
+		},
+		{
+			name:             "timeout shorter than the grace period",
+			gracePeriod:      "60",
+			timeout:          5 * time.Second,
+			advance:          []time.Duration{5 * time.Second},
+			expectedShutdown: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := testutils.NewToplevelRecorder()
+			ct := newContainerTester(t, rec, nil, nil)
+			defer ct.teardown()
+
+			sandbox := fakemeta.GetSandboxes(1)[0]
+			if tc.gracePeriod != "" {
+				sandbox.Annotations["VirtletTerminationGracePeriodSeconds"] = tc.gracePeriod
+			}
+			ct.setPodSandbox(sandbox)
+			containerID := ct.createContainer(sandbox, nil, nil)
+			ct.startContainer(containerID)
+
+			ct.domainConn.SetIgnoreShutdown(true)
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- ct.virtTool.StopContainer(containerID, tc.timeout)
+			}()
+			for _, d := range tc.advance {
+				ct.clock.BlockUntil(1)
+				ct.clock.Advance(d)
+			}
+			select {
+			case err := <-errCh:
+				if err != nil {
+					t.Fatalf("StopContainer(): %v", err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("StopContainer() didn't finish in time")
+			}
+
+			if n := countDomainCalls(rec, "Shutdown"); n != tc.expectedShutdown {
+				t.Errorf("bad number of shutdown attempts: %d instead of %d", n, tc.expectedShutdown)
+			}
+			if n := countDomainCalls(rec, "Destroy"); n != 1 {
+				t.Errorf("bad number of Destroy() calls: %d instead of 1", n)
+			}
+			if container := ct.containerInfo(containerID); container.State != types.ContainerState_CONTAINER_EXITED {
+				t.Errorf("Bad container state: %v instead of %v", container.State, types.ContainerState_CONTAINER_EXITED)
+			}
+		})
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	sandbox.Annotations["VirtletRestartBackoffSeconds"] = "60"
+	ct.setPodSandbox(sandbox)
+
+	containerID := ct.createContainer(sandbox, nil, nil)
+	ct.startContainer(containerID)
+	ct.clock.Advance(10 * time.Second)
+	ct.stopContainer(containerID)
+
+	// kubelet restarts the container by removing it and
+	// creating a new one in the same pod sandbox
+	ct.removeContainer(containerID)
+	if newContainerID := ct.createContainer(sandbox, nil, nil); newContainerID != containerID {
+		t.Fatalf("container id changed upon re-creation: %q instead of %q", newContainerID, containerID)
+	}
+	if err := ct.virtTool.StartContainer(containerID); err == nil {
+		t.Errorf("StartContainer() didn't fail while the restart backoff is in effect")
+	}
+
+	ct.clock.Advance(51 * time.Second)
+	ct.startContainer(containerID)
+}
+
+type fakeEventRecorder struct {
+	events []string
+}
+
+func (r *fakeEventRecorder) Eventf(config *types.VMConfig, eventType, reason, messageFmt string, args ...interface{}) {
+	r.events = append(r.events, fmt.Sprintf("%s/%s %s %s: %s", config.PodNamespace, config.PodName, eventType, reason, fmt.Sprintf(messageFmt, args...)))
+}
+
+func TestGuestHooks(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+	recorder := &fakeEventRecorder{}
+	ct.virtTool.SetEventRecorder(recorder)
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	sandbox.Annotations["VirtletGuestAgent"] = "true"
+	sandbox.Annotations["VirtletPostStartHook"] = "touch /tmp/started"
+	sandbox.Annotations["VirtletPreStopHook"] = "systemctl stop myapp"
+	ct.setPodSandbox(sandbox)
+
+	containerID := ct.createContainer(sandbox, nil, nil)
+	ct.clock.Advance(1 * time.Second)
+	ct.startContainer(containerID)
+	waitForVMWatcher(t, &ct.virtTool.postStartHooks, containerID)
+
+	// "oops" in base64
+	ct.domainConn.SetGuestAgentResponse("guest-exec-status", `{"return":{"exited":true,"exitcode":1,"err-data":"b29wcw=="}}`)
+	ct.stopContainer(containerID)
+
+	expectedEvents := []string{
+		fmt.Sprintf(`%s/%s Normal PostStartHookSucceeded: PostStart hook "touch /tmp/started" succeeded, output: ""`, sandbox.Namespace, sandbox.Name),
+		fmt.Sprintf(`%s/%s Warning FailedPreStopHook: PreStop hook "systemctl stop myapp" failed: command exited with code 1, output: "oops"`, sandbox.Namespace, sandbox.Name),
+	}
+	if !reflect.DeepEqual(recorder.events, expectedEvents) {
+		t.Errorf("bad events:\n%#v\ninstead of\n%#v", recorder.events, expectedEvents)
+	}
+}
+
+func TestPostStartHookFailure(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+	recorder := &fakeEventRecorder{}
+	ct.virtTool.SetEventRecorder(recorder)
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	sandbox.Annotations["VirtletGuestAgent"] = "true"
+	sandbox.Annotations["VirtletPostStartHook"] = "systemctl start myapp"
+	ct.setPodSandbox(sandbox)
+
+	containerID := ct.createContainer(sandbox, nil, nil)
+	ct.clock.Advance(1 * time.Second)
+	// "oops" in base64
+	ct.domainConn.SetGuestAgentResponse("guest-exec-status", `{"return":{"exited":true,"exitcode":1,"err-data":"b29wcw=="}}`)
+	// the hook failure doesn't fail StartContainer
+	ct.startContainer(containerID)
+	waitForVMWatcher(t, &ct.virtTool.postStartHooks, containerID)
+
+	container := ct.containerInfo(containerID)
+	if container.State != types.ContainerState_CONTAINER_EXITED {
+		t.Errorf("Bad container state: %v instead of %v", container.State, types.ContainerState_CONTAINER_EXITED)
+	}
+	if container.Reason != ContainerReasonPostStartHookError {
+		t.Errorf("Bad container status reason %q instead of %q", container.Reason, ContainerReasonPostStartHookError)
+	}
+	if expectedMessage := "post-start hook failed: command exited with code 1"; container.Message != expectedMessage {
+		t.Errorf("Bad container status message %q instead of %q", container.Message, expectedMessage)
+	}
+	expectedEvents := []string{
+		fmt.Sprintf(`%s/%s Warning FailedPostStartHook: PostStart hook "systemctl start myapp" failed: command exited with code 1, output: "oops"`, sandbox.Namespace, sandbox.Name),
+	}
+	if !reflect.DeepEqual(recorder.events, expectedEvents) {
+		t.Errorf("bad events:\n%#v\ninstead of\n%#v", recorder.events, expectedEvents)
+	}
+}
+
+// waitForVMWatcher waits for the goroutine of the watcher of the
+// specified container to exit.
+func waitForVMWatcher(t *testing.T, ws *vmWatchers, containerID string) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		ws.Lock()
+		_, found := ws.watchers[containerID]
+		ws.Unlock()
+		if !found {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the watcher of container %q didn't exit", containerID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type fakeLifecycleSink struct {
+	events []string
+}
+
+func (s *fakeLifecycleSink) Dispatch(event webhook.Event) {
+	s.events = append(s.events, fmt.Sprintf("%s %s/%s %s %s", event.Type, event.PodNamespace, event.PodName, event.ContainerID, event.Image))
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+	sink := &fakeLifecycleSink{}
+	ct.virtTool.SetLifecycleEventSink(sink)
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	ct.setPodSandbox(sandbox)
+
+	containerID := ct.createContainer(sandbox, nil, nil)
+	ct.clock.Advance(1 * time.Second)
+	ct.startContainer(containerID)
+
+	// the guest OS shuts down the VM
+	domain, err := ct.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		t.Fatalf("LookupDomainByUUIDString(): %v", err)
+	}
+	if err := domain.Shutdown(); err != nil {
+		t.Fatalf("Shutdown(): %v", err)
+	}
+	if container := ct.containerInfo(containerID); container.State != types.ContainerState_CONTAINER_EXITED {
+		t.Errorf("Bad container state: %v instead of %v", container.State, types.ContainerState_CONTAINER_EXITED)
+	}
+
+	// no duplicate event is sent for a VM that has already stopped
+	ct.stopContainer(containerID)
+	ct.removeContainer(containerID)
+
+	var expectedEvents []string
+	for _, eventType := range []string{"created", "started", "stopped", "removed"} {
+		expectedEvents = append(expectedEvents, fmt.Sprintf("%s %s/%s %s %s", eventType, sandbox.Namespace, sandbox.Name, containerID, fakeImageName))
+	}
+	if !reflect.DeepEqual(sink.events, expectedEvents) {
+		t.Errorf("bad lifecycle events:\n%#v\ninstead of\n%#v", sink.events, expectedEvents)
+	}
+
+	// the events are also kept in the event history of the sandbox
+	if err := ct.virtTool.RemovePodSandbox(sandbox.Uid); err != nil {
+		t.Fatalf("RemovePodSandbox(): %v", err)
+	}
+	sandboxEvents, err := ct.virtTool.SandboxEvents(sandbox.Uid)
+	if err != nil {
+		t.Fatalf("SandboxEvents(): %v", err)
+	}
+	var history []string
+	for _, event := range sandboxEvents {
+		history = append(history, fmt.Sprintf("%s %s %s", event.Type, event.ContainerID, event.Message))
+	}
+	expectedHistory := []string{
+		types.SandboxEventVMCreated + " " + containerID + " ",
+		types.SandboxEventVMStarted + " " + containerID + " ",
+		types.SandboxEventVMStopped + " " + containerID + " ",
+		types.SandboxEventVMRemoved + " " + containerID + " ",
+		types.SandboxEventRemoved + "  " + types.SandboxRemovedByKubelet,
+	}
+	if !reflect.DeepEqual(history, expectedHistory) {
+		t.Errorf("bad sandbox event history:\n%#v\ninstead of\n%#v", history, expectedHistory)
+	}
+}
+
+func TestDiskStats(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	ct.setPodSandbox(sandbox)
+
+	containerID := ct.createContainer(sandbox, nil, nil)
+	ct.clock.Advance(1 * time.Second)
+	ct.startContainer(containerID)
+
+	ct.domainConn.SetBlockStats("sda", &virt.BlockStats{ReadRequests: 100, ReadBytes: 409600, ReadTimeNs: 50000000})
+	ct.domainConn.SetBlockStats("sdb", &virt.BlockStats{ReadRequests: 2, ReadBytes: 8192, ReadTimeNs: 400000})
+	expected := []types.VMDiskStats{
+		{Dev: "sda", VolumeName: "root", ReadRequests: 100, ReadBytes: 409600, ReadTimeNs: 50000000, ReadLatencyNs: 500000},
+		{Dev: "sdb", VolumeName: "cloud-init", ReadRequests: 2, ReadBytes: 8192, ReadTimeNs: 400000, ReadLatencyNs: 200000},
+	}
+	stats, err := ct.virtTool.DiskStats(containerID)
+	if err != nil {
+		t.Fatalf("DiskStats(): %v", err)
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("bad disk stats: %#v instead of %#v", stats, expected)
+	}
+
+	// the stats must stay with their volumes when the disks
+	// are reordered in the domain definition
+	domain, err := ct.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		t.Fatalf("LookupDomainByUUIDString(): %v", err)
+	}
+	def, err := domain.XML()
+	if err != nil {
+		t.Fatalf("XML(): %v", err)
+	}
+	disks := def.Devices.Disks
+	for i, j := 0, len(disks)-1; i < j; i, j = i+1, j-1 {
+		disks[i], disks[j] = disks[j], disks[i]
+	}
+	stats, err = ct.virtTool.DiskStats(containerID)
+	if err != nil {
+		t.Fatalf("DiskStats(): %v", err)
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("bad disk stats after reordering the disks: %#v instead of %#v", stats, expected)
+	}
+
+	// the IOPS are calculated using the previous sample
+	ct.clock.Advance(10 * time.Second)
+	ct.domainConn.SetBlockStats("sda", &virt.BlockStats{ReadRequests: 600, ReadBytes: 2457600, ReadTimeNs: 250000000, WriteRequests: 50, WriteBytes: 204800, WriteTimeNs: 100000000})
+	expected[0] = types.VMDiskStats{
+		Dev:            "sda",
+		VolumeName:     "root",
+		ReadRequests:   600,
+		ReadBytes:      2457600,
+		ReadTimeNs:     250000000,
+		WriteRequests:  50,
+		WriteBytes:     204800,
+		WriteTimeNs:    100000000,
+		ReadLatencyNs:  416666,
+		WriteLatencyNs: 2000000,
+		ReadIOPS:       50,
+		WriteIOPS:      5,
+	}
+	stats, err = ct.virtTool.DiskStats(containerID)
+	if err != nil {
+		t.Fatalf("DiskStats(): %v", err)
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("bad disk stats after 10s: %#v instead of %#v", stats, expected)
+	}
+}
+
+func TestMemoryStats(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	ct.setPodSandbox(sandbox)
+
+	containerID := ct.createContainer(sandbox, nil, nil)
+	ct.clock.Advance(1 * time.Second)
+	ct.startContainer(containerID)
+
+	for _, tc := range []struct {
+		name     string
+		stats    *virt.MemoryStats
+		expected types.VMMemoryStats
+	}{
+		{
+			name:     "no balloon stats",
+			stats:    &virt.MemoryStats{RSS: 300000000},
+			expected: types.VMMemoryStats{WorkingSet: 300000000, RSS: 300000000},
+		},
+		{
+			name: "balloon stats with usable memory",
+			stats: &virt.MemoryStats{
+				RSS:        300000000,
+				Actual:     1073741824,
+				Available:  1000000000,
+				Unused:     600000000,
+				Usable:     800000000,
+				LastUpdate: 1500000000,
+			},
+			expected: types.VMMemoryStats{
+				WorkingSet: 200000000,
+				Available:  800000000,
+				RSS:        300000000,
+				Actual:     1073741824,
+				Unused:     600000000,
+				LastUpdate: 1500000000,
+			},
+		},
+		{
+			name: "balloon stats without usable memory",
+			stats: &virt.MemoryStats{
+				RSS:        300000000,
+				Actual:     1073741824,
+				Available:  1000000000,
+				Unused:     600000000,
+				LastUpdate: 1500000000,
+			},
+			expected: types.VMMemoryStats{
+				WorkingSet: 400000000,
+				Available:  600000000,
+				RSS:        300000000,
+				Actual:     1073741824,
+				Unused:     600000000,
+				LastUpdate: 1500000000,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ct.domainConn.SetMemoryStats(tc.stats)
+			stats, err := ct.virtTool.MemoryStats(containerID)
+			if err != nil {
+				t.Fatalf("MemoryStats(): %v", err)
+			}
+			if !reflect.DeepEqual(*stats, tc.expected) {
+				t.Errorf("bad memory stats: %#v instead of %#v", *stats, tc.expected)
+			}
+
+			vs, err := ct.virtTool.VMStats(containerID, fakeContainerName)
+			if err != nil {
+				t.Fatalf("VMStats(): %v", err)
+			}
+			if vs.MemoryUsage != tc.expected.WorkingSet || vs.MemoryAvailable != tc.expected.Available {
+				t.Errorf("bad VM memory usage: %d/%d instead of %d/%d", vs.MemoryUsage, vs.MemoryAvailable, tc.expected.WorkingSet, tc.expected.Available)
+			}
+		})
+	}
+}
+
 type volMount struct {
 	name          string
 	containerPath string
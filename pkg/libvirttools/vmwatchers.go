@@ -0,0 +1,111 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"sync"
+)
+
+// vmWatcher is a handle for a goroutine that watches a running VM.
+type vmWatcher struct {
+	sync.Mutex
+	stopped bool
+	stopCh  chan struct{}
+}
+
+func newVMWatcher() *vmWatcher {
+	return &vmWatcher{stopCh: make(chan struct{})}
+}
+
+// stop makes the watcher stop. After stop returns, no action
+// passed to do() is running and no new one will be started.
+func (w *vmWatcher) stop() {
+	w.Lock()
+	defer w.Unlock()
+	if !w.stopped {
+		w.stopped = true
+		close(w.stopCh)
+	}
+}
+
+// isStopped returns true if the watcher is stopped.
+func (w *vmWatcher) isStopped() bool {
+	select {
+	case <-w.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// do invokes the action unless the watcher is stopped. The action
+// can't be interrupted by stop(). do returns false if the watcher
+// is stopped.
+func (w *vmWatcher) do(action func()) bool {
+	w.Lock()
+	defer w.Unlock()
+	if w.stopped {
+		return false
+	}
+	action()
+	return true
+}
+
+// vmWatchers keeps track of the goroutines of a kind that watch
+// the running VMs, so they can be stopped when the VMs are stopped
+// or removed. The zero value is ready to use.
+type vmWatchers struct {
+	sync.Mutex
+	watchers map[string]*vmWatcher
+}
+
+// start registers a new watcher for the container, stopping the
+// previous one, if any.
+func (ws *vmWatchers) start(containerID string) *vmWatcher {
+	w := newVMWatcher()
+	ws.Lock()
+	old := ws.watchers[containerID]
+	if ws.watchers == nil {
+		ws.watchers = make(map[string]*vmWatcher)
+	}
+	ws.watchers[containerID] = w
+	ws.Unlock()
+	if old != nil {
+		old.stop()
+	}
+	return w
+}
+
+// done forgets the watcher after its goroutine exits.
+func (ws *vmWatchers) done(containerID string, w *vmWatcher) {
+	ws.Lock()
+	defer ws.Unlock()
+	if ws.watchers[containerID] == w {
+		delete(ws.watchers, containerID)
+	}
+}
+
+// stop stops the watcher of the container, if any.
+func (ws *vmWatchers) stop(containerID string) {
+	ws.Lock()
+	w := ws.watchers[containerID]
+	delete(ws.watchers, containerID)
+	ws.Unlock()
+	if w != nil {
+		w.stop()
+	}
+}
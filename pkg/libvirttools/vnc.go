@@ -0,0 +1,44 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"fmt"
+)
+
+// VNCPort returns the port of the VNC server of the VM. The port
+// is assigned by libvirt when the VM is started.
+func (v *VirtualizationTool) VNCPort(containerID string) (int, error) {
+	domain, err := v.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		return 0, err
+	}
+
+	domainxml, err := domain.XML()
+	if err != nil {
+		return 0, err
+	}
+
+	if domainxml.Devices != nil {
+		for _, graphics := range domainxml.Devices.Graphics {
+			if graphics.VNC != nil && graphics.VNC.Port > 0 {
+				return graphics.VNC.Port, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("VNC server of VM %q is not available", containerID)
+}
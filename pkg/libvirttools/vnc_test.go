@@ -0,0 +1,58 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libvirttools
+
+import (
+	"testing"
+
+	fakemeta "github.com/Mirantis/virtlet/pkg/metadata/fake"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+)
+
+func TestVNCPort(t *testing.T) {
+	ct := newContainerTester(t, testutils.NewToplevelRecorder(), nil, nil)
+	defer ct.teardown()
+
+	sandbox := fakemeta.GetSandboxes(1)[0]
+	ct.setPodSandbox(sandbox)
+	containerID := ct.createContainer(sandbox, nil, nil)
+
+	if _, err := ct.virtTool.VNCPort(containerID); err == nil {
+		t.Errorf("VNCPort() didn't fail before the port was assigned")
+	}
+
+	// emulate the port assignment done by libvirt
+	domain, err := ct.domainConn.LookupDomainByUUIDString(containerID)
+	if err != nil {
+		t.Fatalf("LookupDomainByUUIDString(): %v", err)
+	}
+	domainxml, err := domain.XML()
+	if err != nil {
+		t.Fatalf("XML(): %v", err)
+	}
+	domainxml.Devices.Graphics[0].VNC.Port = 5901
+
+	if port, err := ct.virtTool.VNCPort(containerID); err != nil {
+		t.Errorf("VNCPort(): %v", err)
+	} else if port != 5901 {
+		t.Errorf("bad VNC port %d instead of 5901", port)
+	}
+
+	if _, err := ct.virtTool.VNCPort("nosuchcontainer"); err == nil {
+		t.Errorf("VNCPort() didn't fail for a nonexistent container")
+	}
+}
@@ -21,6 +21,7 @@ import (
 	digest "github.com/opencontainers/go-digest"
 
 	"github.com/Mirantis/virtlet/pkg/fs"
+	"github.com/Mirantis/virtlet/pkg/metadata"
 	"github.com/Mirantis/virtlet/pkg/metadata/types"
 	"github.com/Mirantis/virtlet/pkg/utils"
 	"github.com/Mirantis/virtlet/pkg/virt"
@@ -49,6 +50,9 @@ type volumeOwner interface {
 	FileSystem() fs.FileSystem
 	SharedFilesystemPath() string
 	Commander() utils.Commander
+	MetadataStore() metadata.Store
+	startProgress(config *types.VMConfig, operation string) *operationProgress
+	checkRetainedVolumes(config *types.VMConfig) (bool, bool)
 }
 
 // VMVolumeSource is a function that provides `VMVolume`s for VMs
@@ -63,6 +67,75 @@ type VMVolume interface {
 	Teardown() error
 }
 
+// podVolume is implemented by VMVolumes that have a name which
+// can be used to identify them in the stats and diagnostics output.
+type podVolume interface {
+	PodVolumeName() string
+}
+
+// podVolumeName returns the name of the volume or an empty string
+// if the volume doesn't have a name.
+func podVolumeName(v VMVolume) string {
+	if pv, ok := v.(podVolume); ok {
+		return pv.PodVolumeName()
+	}
+	return ""
+}
+
+// persistentVolume is implemented by VMVolumes that can be marked
+// as persistent, i.e. holding the data that must not be removed
+// together with the VM without a confirmation.
+type persistentVolume interface {
+	IsPersistent() bool
+}
+
+// isPersistentVolume returns true if the volume is marked as persistent.
+func isPersistentVolume(v VMVolume) bool {
+	if pv, ok := v.(persistentVolume); ok {
+		return pv.IsPersistent()
+	}
+	return false
+}
+
+// retainableVolume is implemented by VMVolumes that can be kept
+// after the VM is stopped or removed, so they can be reused if the
+// VM is re-created within the volume reuse TTL or has soft reboot
+// enabled.
+type retainableVolume interface {
+	IsRetainable() bool
+}
+
+// isRetainableVolume returns true if the volume can be kept for reuse.
+func isRetainableVolume(v VMVolume) bool {
+	if rv, ok := v.(retainableVolume); ok {
+		return rv.IsRetainable()
+	}
+	return false
+}
+
+// retainedStorageVolume returns the storage volume with the specified
+// name that was kept after the removal of the VM if it can be reused,
+// or nil otherwise. The retained volume that can't be reused is
+// removed, so it can be set up again.
+func retainedStorageVolume(owner volumeOwner, config *types.VMConfig, name string) (virt.StorageVolume, error) {
+	reuse, retained := owner.checkRetainedVolumes(config)
+	if !retained {
+		return nil, nil
+	}
+	storagePool, err := owner.StoragePool()
+	if err != nil {
+		return nil, err
+	}
+	if !reuse {
+		return nil, storagePool.RemoveVolumeByName(name)
+	}
+	vol, err := storagePool.LookupVolumeByName(name)
+	if err == virt.ErrStorageVolumeNotFound {
+		return nil, nil
+	}
+	return vol, err
+}
+
 type volumeBase struct {
 	config *types.VMConfig
 	owner  volumeOwner
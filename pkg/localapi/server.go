@@ -0,0 +1,399 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package localapi
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/golang/glog"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+const (
+	vmsPath    = "/v1/vms"
+	socketMode = 0660
+)
+
+// VMController provides access to the VMs for the API.
+type VMController interface {
+	// ListContainers returns the list of the VMs matching the filter
+	ListContainers(filter *types.ContainerFilter) ([]*types.ContainerInfo, error)
+	// ContainerInfo returns the info for the specified VM, or
+	// nil if there's no such VM
+	ContainerInfo(containerID string) (*types.ContainerInfo, error)
+	// RebootContainer asks the guest OS of the VM to reboot
+	RebootContainer(containerID string) error
+}
+
+// ConsoleWatcher provides access to the console output of the VMs.
+type ConsoleWatcher interface {
+	// WatchConsole copies the console output of the VM to out
+	// until stopCh is closed
+	WatchConsole(containerID string, out io.Writer, stopCh <-chan struct{}) error
+}
+
+// ConsoleURLProvider issues the URLs of the WebSocket consoles of
+// the VMs. It's implemented by the ConsoleWatcher if the WebSocket
+// consoles are supported.
+type ConsoleURLProvider interface {
+	// ConsoleURL returns a single-use WebSocket URL for the
+	// specified console type ("serial" or "vnc") of the VM
+	ConsoleURL(containerID, consoleType string) (string, error)
+}
+
+// ConsoleEndpointProvider reports the addresses of the local
+// console endpoints of the VMs. It's implemented by the
+// ConsoleWatcher if the console endpoints are supported.
+type ConsoleEndpointProvider interface {
+	// ConsoleEndpoint returns the address of the console
+	// endpoint of the VM, or an empty string if there's none
+	ConsoleEndpoint(containerID string) string
+}
+
+// ConsoleURL is the response of the console URL request.
+type ConsoleURL struct {
+	// URL is the single-use WebSocket URL of the console
+	URL string `json:"url"`
+}
+
+// VMInfo describes a VM in the API responses.
+type VMInfo struct {
+	// ID is the id of the VM (container)
+	ID string `json:"id"`
+	// Name is the name of the container
+	Name string `json:"name"`
+	// PodID is the id of the pod sandbox (pod UID)
+	PodID string `json:"podID"`
+	// PodName is the name of the VM pod
+	PodName string `json:"podName"`
+	// PodNamespace is the namespace of the VM pod
+	PodNamespace string `json:"podNamespace"`
+	// Image is the name of the VM image
+	Image string `json:"image"`
+	// State is the state of the VM: created, running, exited
+	// or unknown
+	State string `json:"state"`
+	// CreatedAt is the time of the VM creation (unix nanoseconds)
+	CreatedAt int64 `json:"createdAt"`
+	// StartedAt is the time of the VM start (unix nanoseconds)
+	StartedAt int64 `json:"startedAt,omitempty"`
+	// Reason is a brief reason for the current state of the VM
+	Reason string `json:"reason,omitempty"`
+	// Message is a human-readable message describing the state
+	// of the VM
+	Message string `json:"message,omitempty"`
+	// ConsoleEndpoint is the address of the local endpoint of the
+	// serial console of the VM, "unix:/path/to/socket" or
+	// "tcp:127.0.0.1:port", if the console endpoints are enabled
+	ConsoleEndpoint string `json:"consoleEndpoint,omitempty"`
+}
+
+func stateName(state types.ContainerState) string {
+	switch state {
+	case types.ContainerState_CONTAINER_CREATED:
+		return "created"
+	case types.ContainerState_CONTAINER_RUNNING:
+		return "running"
+	case types.ContainerState_CONTAINER_EXITED:
+		return "exited"
+	default:
+		return "unknown"
+	}
+}
+
+// NewVMInfo makes a VMInfo describing the specified container. The
+// console endpoint of the VM is taken from the watcher if it
+// implements ConsoleEndpointProvider.
+func NewVMInfo(ci *types.ContainerInfo, watcher ConsoleWatcher) *VMInfo {
+	vm := &VMInfo{
+		ID:           ci.Id,
+		Name:         ci.Name,
+		PodID:        ci.Config.PodSandboxID,
+		PodName:      ci.Config.PodName,
+		PodNamespace: ci.Config.PodNamespace,
+		Image:        ci.Config.Image,
+		State:        stateName(ci.State),
+		CreatedAt:    ci.CreatedAt,
+		StartedAt:    ci.StartedAt,
+		Reason:       ci.Reason,
+		Message:      ci.Message,
+	}
+	if provider, ok := watcher.(ConsoleEndpointProvider); ok {
+		vm.ConsoleEndpoint = provider.ConsoleEndpoint(ci.Id)
+	}
+	return vm
+}
+
+// Server provides a node-local REST API for the VMs that listens
+// on a unix domain socket. The clients must present the bearer
+// token in the Authorization header. The API is served over HTTPS
+// if a TLS certificate is set using SetTLS.
+type Server struct {
+	sync.Mutex
+	vmc         VMController
+	watcher     ConsoleWatcher
+	urlProvider ConsoleURLProvider
+	token       string
+	tlsConfig   *tls.Config
+	handler     http.Handler
+	ln          net.Listener
+}
+
+// NewServer makes a new local API server that uses the specified
+// token for authentication. watcher may be nil, in which case the
+// console streaming is not available. The WebSocket console URLs
+// are available if watcher implements ConsoleURLProvider.
+func NewServer(vmc VMController, watcher ConsoleWatcher, token string) *Server {
+	s := &Server{vmc: vmc, watcher: watcher, token: token}
+	s.urlProvider, _ = watcher.(ConsoleURLProvider)
+	mux := http.NewServeMux()
+	mux.HandleFunc(vmsPath, s.handleList)
+	mux.HandleFunc(vmsPath+"/", s.handleVM)
+	s.handler = s.authenticate(mux)
+	return s
+}
+
+// LoadToken reads the API token from the specified file. The
+// leading and trailing whitespace is ignored.
+func LoadToken(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("can't read the token file: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("the token file %q is empty", path)
+	}
+	return token, nil
+}
+
+// SetTLS makes the server use HTTPS with the specified certificate
+// and private key files. Both files must be specified. It must be
+// called before Serve.
+func (s *Server) SetTLS(certFile, keyFile string) error {
+	if certFile == "" || keyFile == "" {
+		return errors.New("both the TLS certificate and the key must be specified")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("can't load the TLS certificate: %v", err)
+	}
+	s.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	return nil
+}
+
+// ServeHTTP implements http.Handler interface.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Serve makes the server listen on the specified socket path.
+// This function doesn't return till the server stops listening.
+func (s *Server) Serve(socketPath string) error {
+	if err := syscall.Unlink(socketPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(socketPath, socketMode); err != nil {
+		ln.Close()
+		return fmt.Errorf("can't set the permissions of %q: %v", socketPath, err)
+	}
+	if s.tlsConfig != nil {
+		ln = tls.NewListener(ln, s.tlsConfig)
+	}
+	s.Lock()
+	s.ln = ln
+	s.Unlock()
+	return http.Serve(ln, s)
+}
+
+// Stop stops the server.
+func (s *Server) Stop() {
+	s.Lock()
+	defer s.Unlock()
+	if s.ln != nil {
+		s.ln.Close()
+		s.ln = nil
+	}
+}
+
+func (s *Server) authenticate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	containers, err := s.vmc.ListContainers(nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	vms := []*VMInfo{}
+	for _, ci := range containers {
+		vms = append(vms, NewVMInfo(ci, s.watcher))
+	}
+	writeJSON(w, http.StatusOK, vms)
+}
+
+func (s *Server) handleVM(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, vmsPath+"/"), "/")
+	id, action := parts[0], ""
+	switch {
+	case id == "" || len(parts) > 2:
+		writeError(w, http.StatusNotFound, fmt.Errorf("bad path %q", r.URL.Path))
+		return
+	case len(parts) == 2:
+		action = parts[1]
+	}
+
+	ci, err := s.vmc.ContainerInfo(id)
+	switch {
+	case err == virt.ErrDomainNotFound || (err == nil && ci == nil):
+		writeError(w, http.StatusNotFound, fmt.Errorf("VM %q not found", id))
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, NewVMInfo(ci, s.watcher))
+	case action == "console" && r.Method == http.MethodGet:
+		s.streamConsole(w, r, id)
+	case action == "reboot" && r.Method == http.MethodPost:
+		if err := s.vmc.RebootContainer(id); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		glog.V(1).Infof("VM %q (pod %s/%s) rebooted via the local API", id, ci.Config.PodNamespace, ci.Config.PodName)
+		w.WriteHeader(http.StatusNoContent)
+	case action == "console-url" && r.Method == http.MethodPost:
+		s.consoleURL(w, r, id)
+	case action == "" || action == "console" || action == "reboot" || action == "console-url":
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("bad path %q", r.URL.Path))
+	}
+}
+
+func (s *Server) streamConsole(w http.ResponseWriter, r *http.Request, id string) {
+	if s.watcher == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("console streaming is disabled"))
+		return
+	}
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	if cn, ok := w.(http.CloseNotifier); ok {
+		closeCh := cn.CloseNotify()
+		go func() {
+			select {
+			case <-closeCh:
+				close(stopCh)
+			case <-doneCh:
+			}
+		}()
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	if err := s.watcher.WatchConsole(id, flushWriter{w}, stopCh); err != nil {
+		glog.V(1).Infof("Console streaming for VM %q stopped: %v", id, err)
+	}
+}
+
+func (s *Server) consoleURL(w http.ResponseWriter, r *http.Request, id string) {
+	if s.urlProvider == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("WebSocket consoles are not available"))
+		return
+	}
+	consoleType := r.URL.Query().Get("type")
+	switch consoleType {
+	case "":
+		consoleType = "serial"
+	case "serial", "vnc":
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bad console type %q", consoleType))
+		return
+	}
+	url, err := s.urlProvider.ConsoleURL(id, consoleType)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, &ConsoleURL{URL: url})
+}
+
+// flushWriter flushes the response after each write so the console
+// output is delivered to the client immediately.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if f, ok := fw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("error marshalling the response: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	data, _ := json.Marshal(map[string]string{"error": err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
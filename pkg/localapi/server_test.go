@@ -0,0 +1,393 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package localapi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+const testToken = "s3cr3t"
+
+type fakeVMController struct {
+	containers []*types.ContainerInfo
+	rebooted   []string
+}
+
+var _ VMController = &fakeVMController{}
+
+func (c *fakeVMController) ListContainers(filter *types.ContainerFilter) ([]*types.ContainerInfo, error) {
+	return c.containers, nil
+}
+
+func (c *fakeVMController) ContainerInfo(containerID string) (*types.ContainerInfo, error) {
+	for _, ci := range c.containers {
+		if ci.Id == containerID {
+			return ci, nil
+		}
+	}
+	return nil, virt.ErrDomainNotFound
+}
+
+func (c *fakeVMController) RebootContainer(containerID string) error {
+	ci, _ := c.ContainerInfo(containerID)
+	if ci.State != types.ContainerState_CONTAINER_RUNNING {
+		return fmt.Errorf("domain %q is not running", containerID)
+	}
+	c.rebooted = append(c.rebooted, containerID)
+	return nil
+}
+
+type fakeConsoleWatcher struct{}
+
+func (w fakeConsoleWatcher) WatchConsole(containerID string, out io.Writer, stopCh <-chan struct{}) error {
+	_, err := fmt.Fprintf(out, "console output of %s\n", containerID)
+	return err
+}
+
+func (w fakeConsoleWatcher) ConsoleURL(containerID, consoleType string) (string, error) {
+	if containerID != "231700d5-c9a6-5a49-738d-99a954c51550" {
+		return "", fmt.Errorf("could not find vm %q", containerID)
+	}
+	return "ws://10.0.0.1:10010/console/" + consoleType + "-token", nil
+}
+
+func (w fakeConsoleWatcher) ConsoleEndpoint(containerID string) string {
+	if containerID != "231700d5-c9a6-5a49-738d-99a954c51550" {
+		return ""
+	}
+	return "unix:/var/run/virtlet/consoles/default_cirros-vm.sock"
+}
+
+func TestLocalAPI(t *testing.T) {
+	vmc := &fakeVMController{
+		containers: []*types.ContainerInfo{
+			{
+				Id:        "231700d5-c9a6-5a49-738d-99a954c51550",
+				Name:      "vm",
+				CreatedAt: 1496175540000000000,
+				StartedAt: 1496175541000000000,
+				State:     types.ContainerState_CONTAINER_RUNNING,
+				Config: types.VMConfig{
+					PodSandboxID: "69eec606-0493-5825-73a4-c5e0c0236155",
+					PodName:      "cirros-vm",
+					PodNamespace: "default",
+					Image:        "virtlet.cloud/cirros",
+				},
+			},
+			{
+				Id:        "d59d8fe6-153f-5959-64a6-6817f77f867a",
+				Name:      "vm",
+				CreatedAt: 1496175540000000000,
+				State:     types.ContainerState_CONTAINER_CREATED,
+				Config: types.VMConfig{
+					PodSandboxID: "d25ded14-d35d-510b-5749-f83cc165794e",
+					PodName:      "ubuntu-vm",
+					PodNamespace: "default",
+					Image:        "virtlet.cloud/ubuntu",
+				},
+			},
+		},
+	}
+	runningVM := `{"id":"231700d5-c9a6-5a49-738d-99a954c51550","name":"vm","podID":"69eec606-0493-5825-73a4-c5e0c0236155","podName":"cirros-vm","podNamespace":"default","image":"virtlet.cloud/cirros","state":"running","createdAt":1496175540000000000,"startedAt":1496175541000000000,"consoleEndpoint":"unix:/var/run/virtlet/consoles/default_cirros-vm.sock"}`
+	createdVM := `{"id":"d59d8fe6-153f-5959-64a6-6817f77f867a","name":"vm","podID":"d25ded14-d35d-510b-5749-f83cc165794e","podName":"ubuntu-vm","podNamespace":"default","image":"virtlet.cloud/ubuntu","state":"created","createdAt":1496175540000000000}`
+	for _, tc := range []struct {
+		name             string
+		method           string
+		path             string
+		token            string
+		noConsole        bool
+		noConsoleURL     bool
+		expectedStatus   int
+		expectedBody     string
+		expectedRebooted []string
+	}{
+		{
+			name:           "list VMs",
+			method:         "GET",
+			path:           "/v1/vms",
+			token:          testToken,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "[" + runningVM + "," + createdVM + "]",
+		},
+		{
+			name:           "no token",
+			method:         "GET",
+			path:           "/v1/vms",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"unauthorized"}`,
+		},
+		{
+			name:           "bad token",
+			method:         "GET",
+			path:           "/v1/vms",
+			token:          "foobar",
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   `{"error":"unauthorized"}`,
+		},
+		{
+			name:           "get VM",
+			method:         "GET",
+			path:           "/v1/vms/231700d5-c9a6-5a49-738d-99a954c51550",
+			token:          testToken,
+			expectedStatus: http.StatusOK,
+			expectedBody:   runningVM,
+		},
+		{
+			name:           "get non-existent VM",
+			method:         "GET",
+			path:           "/v1/vms/foobar",
+			token:          testToken,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error":"VM \"foobar\" not found"}`,
+		},
+		{
+			name:           "bad path",
+			method:         "GET",
+			path:           "/v1/vms/231700d5-c9a6-5a49-738d-99a954c51550/foo",
+			token:          testToken,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `{"error":"bad path \"/v1/vms/231700d5-c9a6-5a49-738d-99a954c51550/foo\""}`,
+		},
+		{
+			name:           "console",
+			method:         "GET",
+			path:           "/v1/vms/231700d5-c9a6-5a49-738d-99a954c51550/console",
+			token:          testToken,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "console output of 231700d5-c9a6-5a49-738d-99a954c51550\n",
+		},
+		{
+			name:           "console streaming disabled",
+			method:         "GET",
+			path:           "/v1/vms/231700d5-c9a6-5a49-738d-99a954c51550/console",
+			token:          testToken,
+			noConsole:      true,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   `{"error":"console streaming is disabled"}`,
+		},
+		{
+			name:             "reboot",
+			method:           "POST",
+			path:             "/v1/vms/231700d5-c9a6-5a49-738d-99a954c51550/reboot",
+			token:            testToken,
+			expectedStatus:   http.StatusNoContent,
+			expectedRebooted: []string{"231700d5-c9a6-5a49-738d-99a954c51550"},
+		},
+		{
+			name:           "reboot using GET",
+			method:         "GET",
+			path:           "/v1/vms/231700d5-c9a6-5a49-738d-99a954c51550/reboot",
+			token:          testToken,
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedBody:   `{"error":"method GET not allowed"}`,
+		},
+		{
+			name:           "reboot a VM that's not running",
+			method:         "POST",
+			path:           "/v1/vms/d59d8fe6-153f-5959-64a6-6817f77f867a/reboot",
+			token:          testToken,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"error":"domain \"d59d8fe6-153f-5959-64a6-6817f77f867a\" is not running"}`,
+		},
+		{
+			name:           "serial console URL",
+			method:         "POST",
+			path:           "/v1/vms/231700d5-c9a6-5a49-738d-99a954c51550/console-url",
+			token:          testToken,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"url":"ws://10.0.0.1:10010/console/serial-token"}`,
+		},
+		{
+			name:           "VNC console URL",
+			method:         "POST",
+			path:           "/v1/vms/231700d5-c9a6-5a49-738d-99a954c51550/console-url?type=vnc",
+			token:          testToken,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"url":"ws://10.0.0.1:10010/console/vnc-token"}`,
+		},
+		{
+			name:           "console URL with a bad console type",
+			method:         "POST",
+			path:           "/v1/vms/231700d5-c9a6-5a49-738d-99a954c51550/console-url?type=spice",
+			token:          testToken,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"bad console type \"spice\""}`,
+		},
+		{
+			name:           "console URL using GET",
+			method:         "GET",
+			path:           "/v1/vms/231700d5-c9a6-5a49-738d-99a954c51550/console-url",
+			token:          testToken,
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedBody:   `{"error":"method GET not allowed"}`,
+		},
+		{
+			name:           "console URL for a VM without the console",
+			method:         "POST",
+			path:           "/v1/vms/d59d8fe6-153f-5959-64a6-6817f77f867a/console-url",
+			token:          testToken,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"error":"could not find vm \"d59d8fe6-153f-5959-64a6-6817f77f867a\""}`,
+		},
+		{
+			name:           "WebSocket consoles not available",
+			method:         "POST",
+			path:           "/v1/vms/231700d5-c9a6-5a49-738d-99a954c51550/console-url",
+			token:          testToken,
+			noConsoleURL:   true,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   `{"error":"WebSocket consoles are not available"}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			vmc.rebooted = nil
+			var watcher ConsoleWatcher
+			switch {
+			case tc.noConsoleURL:
+				// hide ConsoleURL method
+				watcher = struct{ ConsoleWatcher }{fakeConsoleWatcher{}}
+			case !tc.noConsole:
+				watcher = fakeConsoleWatcher{}
+			}
+			s := NewServer(vmc, watcher, testToken)
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, req)
+			if w.Code != tc.expectedStatus {
+				t.Errorf("bad status %d instead of %d", w.Code, tc.expectedStatus)
+			}
+			if body := w.Body.String(); body != tc.expectedBody {
+				t.Errorf("bad response body:\n%s\ninstead of\n%s", body, tc.expectedBody)
+			}
+			if !reflect.DeepEqual(vmc.rebooted, tc.expectedRebooted) {
+				t.Errorf("bad list of rebooted VMs: %#v instead of %#v", vmc.rebooted, tc.expectedRebooted)
+			}
+		})
+	}
+}
+
+func TestLoadToken(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "localapi-test")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tokenPath := filepath.Join(tmpDir, "token")
+	if err := ioutil.WriteFile(tokenPath, []byte(testToken+"\n"), 0600); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	token, err := LoadToken(tokenPath)
+	if err != nil {
+		t.Errorf("LoadToken(): %v", err)
+	} else if token != testToken {
+		t.Errorf("bad token %q instead of %q", token, testToken)
+	}
+
+	if err := ioutil.WriteFile(tokenPath, []byte(" \n"), 0600); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	if _, err := LoadToken(tokenPath); err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Errorf("LoadToken() didn't return the expected error for an empty token file: %v", err)
+	}
+
+	if _, err := LoadToken(filepath.Join(tmpDir, "nosuchfile")); err == nil {
+		t.Errorf("LoadToken() didn't fail for a non-existent file")
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "localapi-test")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ca, caKey := testutils.GenerateCert(t, true, "CA", nil, nil)
+	cert, key := testutils.GenerateCert(t, false, "localhost", ca, caKey)
+	certPath := filepath.Join(tmpDir, "tls.crt")
+	keyPath := filepath.Join(tmpDir, "tls.key")
+	if err := ioutil.WriteFile(certPath, []byte(testutils.EncodePEMCert(cert)), 0600); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	if err := ioutil.WriteFile(keyPath, []byte(testutils.EncodePEMKey(key)), 0600); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+
+	s := NewServer(&fakeVMController{}, nil, testToken)
+	if err := s.SetTLS(certPath, ""); err == nil {
+		t.Errorf("SetTLS() didn't fail without the key file")
+	}
+	if err := s.SetTLS(certPath, keyPath); err != nil {
+		t.Fatalf("SetTLS(): %v", err)
+	}
+
+	socketPath := filepath.Join(tmpDir, "api.sock")
+	go s.Serve(socketPath)
+	defer s.Stop()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.Dial("unix", socketPath)
+			},
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+		},
+	}
+	req, err := http.NewRequest("GET", "https://localhost"+vmsPath, nil)
+	if err != nil {
+		t.Fatalf("NewRequest(): %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = client.Do(req); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("HTTPS request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("bad status code %d instead of %d", resp.StatusCode, http.StatusOK)
+	}
+}
@@ -151,6 +151,7 @@ func GetVMConfig(in *kubeapi.CreateContainerRequest, csn *network.ContainerSideN
 		r.CPUShares = res.CpuShares
 		r.CPUPeriod = res.CpuPeriod
 		r.CPUQuota = res.CpuQuota
+		r.CPUSetCpus = res.CpusetCpus
 	}
 
 	for _, entry := range in.Config.Envs {
@@ -248,6 +249,8 @@ func ContainerInfoToCRIContainerStatus(in *types.ContainerInfo) *kubeapi.Contain
 		Annotations: in.Config.ContainerAnnotations,
 		Mounts:      mounts,
 		LogPath:     filepath.Join(in.Config.LogDirectory, in.Config.LogPath),
-		// TODO: FinishedAt, Reason, Message
+		Reason:      in.Reason,
+		Message:     in.Message,
+		// TODO: FinishedAt
 	}
 }
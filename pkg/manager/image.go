@@ -17,32 +17,85 @@ limitations under the License.
 package manager
 
 import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
 	"github.com/jonboulle/clockwork"
 	"golang.org/x/net/context"
 	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 
 	"github.com/Mirantis/virtlet/pkg/image"
+	"github.com/Mirantis/virtlet/pkg/metadata"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const (
+	// imagePullWaitTimeout is the time PullImage waits for the
+	// background pull to finish before returning an error that
+	// reports the progress of the pull. It must be well below
+	// kubelet's image pull deadline.
+	imagePullWaitTimeout = 10 * time.Second
+	// imagePullSaveInterval is the minimum interval between
+	// the updates of the image pull progress in the metadata store.
+	imagePullSaveInterval = 5 * time.Second
 )
 
+// imagePull denotes an image pull that runs in background.
+type imagePull struct {
+	done      chan struct{}
+	ref       string
+	err       error
+	lastSaved time.Time
+}
+
+// recentPull denotes a successful image pull whose result can be
+// reused instead of downloading the image again.
+type recentPull struct {
+	ref        string
+	finishedAt time.Time
+}
+
 // VirtletImageService handles CRI image service calls.
 type VirtletImageService struct {
+	sync.Mutex
 	imageStore      image.Store
 	imageTranslator image.Translator
+	metadataStore   metadata.ImagePullStore
 	clock           clockwork.Clock
+	pulls           map[string]*imagePull
+	pullReuseTTL    time.Duration
+	recentPulls     map[string]recentPull
 }
 
 // NewVirtletImageService returns a new instance of VirtletImageService.
-func NewVirtletImageService(imageStore image.Store, imageTranslator image.Translator, clock clockwork.Clock) *VirtletImageService {
+func NewVirtletImageService(imageStore image.Store, imageTranslator image.Translator, metadataStore metadata.ImagePullStore, clock clockwork.Clock) *VirtletImageService {
 	if clock == nil {
 		clock = clockwork.NewRealClock()
 	}
 	return &VirtletImageService{
 		imageStore:      imageStore,
 		imageTranslator: imageTranslator,
+		metadataStore:   metadataStore,
 		clock:           clock,
+		pulls:           make(map[string]*imagePull),
+		recentPulls:     make(map[string]recentPull),
 	}
 }
 
+// SetPullReuseTTL sets the time during which PullImage doesn't
+// download the image again after it was pulled successfully, as long
+// as the image is still present in the image store. This way, the
+// crash-looping VMs with imagePullPolicy: Always don't cause the
+// image to be downloaded upon each restart. 0 disables the reuse.
+func (v *VirtletImageService) SetPullReuseTTL(ttl time.Duration) {
+	v.Lock()
+	defer v.Unlock()
+	v.pullReuseTTL = ttl
+}
+
 // ListImages method implements ListImages from CRI.
 func (v *VirtletImageService) ListImages(ctx context.Context, in *kubeapi.ListImagesRequest) (*kubeapi.ListImagesResponse, error) {
 	images, err := v.imageStore.ListImages(in.GetFilter().GetImage().GetImage())
@@ -69,16 +122,221 @@ func (v *VirtletImageService) ImageStatus(ctx context.Context, in *kubeapi.Image
 }
 
 // PullImage method implements PullImage from CRI.
+// The actual download is done in background so it's not
+// interrupted by kubelet's image pull deadline. If the pull doesn't
+// finish within imagePullWaitTimeout, PullImage returns an error
+// that describes the progress of the pull, and the pod stays
+// Pending until one of the subsequent PullImage calls made by
+// kubelet picks up the result.
 func (v *VirtletImageService) PullImage(ctx context.Context, in *kubeapi.PullImageRequest) (*kubeapi.PullImageResponse, error) {
 	imageName := in.GetImage().GetImage()
+	if imageName == "" {
+		return nil, errors.New("image name not specified")
+	}
 
-	ref, err := v.imageStore.PullImage(ctx, imageName, v.imageTranslator)
+	if ref := v.recentPullRef(imageName); ref != "" {
+		glog.V(2).Infof("Image %q was pulled recently, not downloading it again", imageName)
+		return &kubeapi.PullImageResponse{ImageRef: ref}, nil
+	}
+
+	pull, err := v.startPull(imageName)
 	if err != nil {
 		return nil, err
 	}
+	select {
+	case <-pull.done:
+	case <-v.clock.After(imagePullWaitTimeout):
+		return nil, v.pullInProgressError(imageName)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	v.forgetPull(imageName, pull)
+	if pull.err != nil {
+		return nil, pull.err
+	}
+	v.rememberPull(imageName, pull.ref)
+	return &kubeapi.PullImageResponse{ImageRef: pull.ref}, nil
+}
+
+// rememberPull records the successful image pull so its result can
+// be reused within pullReuseTTL.
+func (v *VirtletImageService) rememberPull(imageName, ref string) {
+	v.Lock()
+	defer v.Unlock()
+	if v.pullReuseTTL == 0 {
+		return
+	}
+	v.recentPulls[imageName] = recentPull{ref: ref, finishedAt: v.clock.Now()}
+}
+
+// recentPullRef returns the ref of the image if it was pulled less
+// than pullReuseTTL ago and is still present in the image store, or
+// an empty string otherwise.
+func (v *VirtletImageService) recentPullRef(imageName string) string {
+	v.Lock()
+	pull, found := v.recentPulls[imageName]
+	if found && v.clock.Since(pull.finishedAt) >= v.pullReuseTTL {
+		delete(v.recentPulls, imageName)
+		found = false
+	}
+	v.Unlock()
+	if !found {
+		return ""
+	}
+	img, err := v.imageStore.ImageStatus(pull.ref)
+	if err != nil || img == nil {
+		return ""
+	}
+	return pull.ref
+}
+
+// RecoverPulls restarts the image pulls that were interrupted by
+// Virtlet restart and removes the records of the pulls that have
+// finished but their results weren't picked up by kubelet.
+func (v *VirtletImageService) RecoverPulls() error {
+	jobs, err := v.metadataStore.ListImagePullJobs()
+	if err != nil {
+		return fmt.Errorf("error listing image pull jobs: %v", err)
+	}
+	for _, job := range jobs {
+		if job.Finished() {
+			if err := v.metadataStore.SaveImagePullJob(job.ImageName, func(*types.ImagePullJob) (*types.ImagePullJob, error) {
+				return nil, nil
+			}); err != nil {
+				return fmt.Errorf("error removing image pull job for %q: %v", job.ImageName, err)
+			}
+			continue
+		}
+		glog.V(1).Infof("Resuming the pull of image %q", job.ImageName)
+		if _, err := v.startPull(job.ImageName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	response := &kubeapi.PullImageResponse{ImageRef: ref}
-	return response, nil
+// startPull returns the image pull for the specified image,
+// starting it in background if necessary.
+func (v *VirtletImageService) startPull(imageName string) (*imagePull, error) {
+	v.Lock()
+	defer v.Unlock()
+	if pull, found := v.pulls[imageName]; found {
+		return pull, nil
+	}
+
+	now := v.clock.Now()
+	if err := v.metadataStore.SaveImagePullJob(imageName, func(*types.ImagePullJob) (*types.ImagePullJob, error) {
+		return &types.ImagePullJob{StartedAt: now.UnixNano()}, nil
+	}); err != nil {
+		return nil, fmt.Errorf("error saving image pull job for %q: %v", imageName, err)
+	}
+	pull := &imagePull{done: make(chan struct{}), lastSaved: now}
+	v.pulls[imageName] = pull
+
+	go func() {
+		// The pull must not be cancelled when kubelet's
+		// PullImage call times out, so the context
+		// passed to PullImage is not used here.
+		ctx := image.WithProgress(context.Background(), func(bytesDone, bytesTotal int64) {
+			v.updatePullProgress(imageName, pull, bytesDone, bytesTotal)
+		})
+		ref, pullErr := v.imageStore.PullImage(ctx, imageName, v.imageTranslator)
+		if err := v.metadataStore.SaveImagePullJob(imageName, func(job *types.ImagePullJob) (*types.ImagePullJob, error) {
+			if job == nil {
+				job = &types.ImagePullJob{StartedAt: now.UnixNano()}
+			}
+			job.FinishedAt = v.clock.Now().UnixNano()
+			job.ImageRef = ref
+			if pullErr != nil {
+				job.Error = pullErr.Error()
+			}
+			return job, nil
+		}); err != nil {
+			glog.Warningf("Error saving image pull job for %q: %v", imageName, err)
+		}
+		v.Lock()
+		pull.ref = ref
+		pull.err = pullErr
+		v.Unlock()
+		close(pull.done)
+	}()
+	return pull, nil
+}
+
+// updatePullProgress stores the progress of the image pull in the
+// metadata store. The updates are throttled so the store isn't
+// written to upon each chunk of downloaded data.
+func (v *VirtletImageService) updatePullProgress(imageName string, pull *imagePull, bytesDone, bytesTotal int64) {
+	v.Lock()
+	now := v.clock.Now()
+	save := bytesDone == 0 || now.Sub(pull.lastSaved) >= imagePullSaveInterval
+	if save {
+		pull.lastSaved = now
+	}
+	v.Unlock()
+	if !save {
+		return
+	}
+	if err := v.metadataStore.SaveImagePullJob(imageName, func(job *types.ImagePullJob) (*types.ImagePullJob, error) {
+		if job == nil {
+			job = &types.ImagePullJob{StartedAt: now.UnixNano()}
+		}
+		job.BytesDone = bytesDone
+		if bytesTotal > 0 {
+			job.BytesTotal = bytesTotal
+		}
+		return job, nil
+	}); err != nil {
+		glog.Warningf("Error saving image pull progress for %q: %v", imageName, err)
+	}
+}
+
+// forgetPull removes the finished image pull along with its
+// metadata record.
+func (v *VirtletImageService) forgetPull(imageName string, pull *imagePull) {
+	v.Lock()
+	defer v.Unlock()
+	if v.pulls[imageName] != pull {
+		// the result was already picked up by another caller
+		return
+	}
+	delete(v.pulls, imageName)
+	if err := v.metadataStore.SaveImagePullJob(imageName, func(*types.ImagePullJob) (*types.ImagePullJob, error) {
+		return nil, nil
+	}); err != nil {
+		glog.Warningf("Error removing image pull job for %q: %v", imageName, err)
+	}
+}
+
+// pullInProgressError returns an error that describes the progress of
+// the image pull.
+func (v *VirtletImageService) pullInProgressError(imageName string) error {
+	job, err := v.metadataStore.GetImagePullJob(imageName)
+	if err != nil || job == nil {
+		return fmt.Errorf("image %q is being pulled in background", imageName)
+	}
+	elapsed := time.Duration(v.clock.Now().UnixNano() - job.StartedAt).Round(time.Second)
+	if job.BytesTotal > 0 {
+		return fmt.Errorf("image %q is being pulled in background: %s of %s (%d%%) downloaded in %v",
+			imageName, formatBytes(job.BytesDone), formatBytes(job.BytesTotal),
+			job.BytesDone*100/job.BytesTotal, elapsed)
+	}
+	return fmt.Errorf("image %q is being pulled in background: %s downloaded in %v",
+		imageName, formatBytes(job.BytesDone), elapsed)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
 }
 
 // RemoveImage method implements RemoveImage from CRI.
@@ -17,9 +17,19 @@ limitations under the License.
 package manager
 
 import (
+	"context"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/jonboulle/clockwork"
 	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
+
+	"github.com/Mirantis/virtlet/pkg/image"
+	fakeimage "github.com/Mirantis/virtlet/pkg/image/fake"
+	"github.com/Mirantis/virtlet/pkg/metadata"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
 )
 
 func TestCRIImages(t *testing.T) {
@@ -39,3 +49,147 @@ func TestCRIImages(t *testing.T) {
 	tst.imageFsInfo(&kubeapi.ImageFsInfoRequest{})
 	tst.verify()
 }
+
+type blockingImageStore struct {
+	image.Store
+	release chan struct{}
+}
+
+func (s *blockingImageStore) PullImage(ctx context.Context, name string, translator image.Translator) (string, error) {
+	<-s.release
+	return s.Store.PullImage(ctx, name, translator)
+}
+
+func TestBackgroundImagePull(t *testing.T) {
+	metadataStore := metadata.NewMemStore()
+	clock := clockwork.NewFakeClockAt(time.Date(2018, 7, 10, 10, 0, 0, 0, time.UTC))
+	imageStore := &blockingImageStore{
+		Store:   fakeimage.NewFakeStore(testutils.NewToplevelRecorder()),
+		release: make(chan struct{}),
+	}
+	imageService := NewVirtletImageService(imageStore, translateImageName, metadataStore, clock)
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := imageService.PullImage(context.Background(), &kubeapi.PullImageRequest{Image: cirrosImg()})
+		errCh <- err
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(imagePullWaitTimeout)
+	err := <-errCh
+	if err == nil {
+		t.Fatalf("PullImage() didn't return an error for an unfinished pull")
+	}
+	expectedMsg := `image "localhost/cirros.img" is being pulled in background: 0 B downloaded in 10s`
+	if err.Error() != expectedMsg {
+		t.Errorf("bad error message: %q instead of %q", err.Error(), expectedMsg)
+	}
+
+	job, err := metadataStore.GetImagePullJob(cirrosImg().Image)
+	switch {
+	case err != nil:
+		t.Fatalf("GetImagePullJob(): %v", err)
+	case job == nil:
+		t.Fatalf("image pull job not found")
+	case job.Finished():
+		t.Errorf("image pull job is reported as finished: %#v", job)
+	}
+
+	close(imageStore.release)
+	resp, err := imageService.PullImage(context.Background(), &kubeapi.PullImageRequest{Image: cirrosImg()})
+	if err != nil {
+		t.Fatalf("PullImage(): %v", err)
+	}
+	if !strings.HasPrefix(resp.ImageRef, "localhost/cirros.img@sha256:") {
+		t.Errorf("bad image ref %q", resp.ImageRef)
+	}
+
+	job, err = metadataStore.GetImagePullJob(cirrosImg().Image)
+	if err != nil {
+		t.Fatalf("GetImagePullJob(): %v", err)
+	}
+	if job != nil {
+		t.Errorf("image pull job wasn't removed: %#v", job)
+	}
+}
+
+func TestRecoverImagePulls(t *testing.T) {
+	metadataStore := metadata.NewMemStore()
+	for _, job := range []*types.ImagePullJob{
+		{ImageName: cirrosImg().Image, StartedAt: 1531216800000000000, BytesDone: 42},
+		{ImageName: ubuntuImg().Image, StartedAt: 1531216800000000000, FinishedAt: 1531216900000000000, Error: "oops"},
+	} {
+		if err := metadataStore.SaveImagePullJob(job.ImageName, func(*types.ImagePullJob) (*types.ImagePullJob, error) {
+			return job, nil
+		}); err != nil {
+			t.Fatalf("SaveImagePullJob(): %v", err)
+		}
+	}
+
+	imageStore := fakeimage.NewFakeStore(testutils.NewToplevelRecorder())
+	imageService := NewVirtletImageService(imageStore, translateImageName, metadataStore, clockwork.NewFakeClock())
+	if err := imageService.RecoverPulls(); err != nil {
+		t.Fatalf("RecoverPulls(): %v", err)
+	}
+	if job, err := metadataStore.GetImagePullJob(ubuntuImg().Image); err != nil {
+		t.Errorf("GetImagePullJob(): %v", err)
+	} else if job != nil {
+		t.Errorf("finished image pull job wasn't removed: %#v", job)
+	}
+
+	resp, err := imageService.PullImage(context.Background(), &kubeapi.PullImageRequest{Image: cirrosImg()})
+	if err != nil {
+		t.Fatalf("PullImage(): %v", err)
+	}
+	if !strings.HasPrefix(resp.ImageRef, "localhost/cirros.img@sha256:") {
+		t.Errorf("bad image ref %q", resp.ImageRef)
+	}
+	if imgs, err := imageStore.ListImages(""); err != nil {
+		t.Errorf("ListImages(): %v", err)
+	} else if len(imgs) != 1 {
+		t.Errorf("expected exactly 1 image to be pulled, got %d", len(imgs))
+	}
+}
+
+type countingImageStore struct {
+	image.Store
+	pulls int
+}
+
+func (s *countingImageStore) PullImage(ctx context.Context, name string, translator image.Translator) (string, error) {
+	s.pulls++
+	return s.Store.PullImage(ctx, name, translator)
+}
+
+func TestImagePullReuse(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Date(2018, 7, 10, 10, 0, 0, 0, time.UTC))
+	imageStore := &countingImageStore{Store: fakeimage.NewFakeStore(testutils.NewToplevelRecorder())}
+	imageService := NewVirtletImageService(imageStore, translateImageName, metadata.NewMemStore(), clock)
+	imageService.SetPullReuseTTL(5 * time.Minute)
+
+	pull := func(expectedPulls int) {
+		resp, err := imageService.PullImage(context.Background(), &kubeapi.PullImageRequest{Image: cirrosImg()})
+		if err != nil {
+			t.Fatalf("PullImage(): %v", err)
+		}
+		if !strings.HasPrefix(resp.ImageRef, "localhost/cirros.img@sha256:") {
+			t.Errorf("bad image ref %q", resp.ImageRef)
+		}
+		if imageStore.pulls != expectedPulls {
+			t.Errorf("bad number of image downloads: %d instead of %d", imageStore.pulls, expectedPulls)
+		}
+	}
+
+	pull(1)
+	clock.Advance(time.Minute)
+	// the recently pulled image is reused
+	pull(1)
+	clock.Advance(4 * time.Minute)
+	// the reuse TTL has expired
+	pull(2)
+	if err := imageStore.RemoveImage(cirrosImg().Image); err != nil {
+		t.Fatalf("RemoveImage(): %v", err)
+	}
+	// the image is downloaded again if it's removed
+	pull(3)
+}
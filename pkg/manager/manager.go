@@ -17,24 +17,39 @@ limitations under the License.
 package manager
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 
+	"github.com/Mirantis/virtlet/pkg/adminapi"
 	"github.com/Mirantis/virtlet/pkg/api/virtlet.k8s/v1"
 	"github.com/Mirantis/virtlet/pkg/diag"
 	"github.com/Mirantis/virtlet/pkg/fs"
 	"github.com/Mirantis/virtlet/pkg/image"
 	"github.com/Mirantis/virtlet/pkg/imagetranslation"
+	"github.com/Mirantis/virtlet/pkg/kvmcheck"
 	"github.com/Mirantis/virtlet/pkg/libvirttools"
+	"github.com/Mirantis/virtlet/pkg/localapi"
 	"github.com/Mirantis/virtlet/pkg/metadata"
 	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/simulator"
 	"github.com/Mirantis/virtlet/pkg/stream"
 	"github.com/Mirantis/virtlet/pkg/tapmanager"
 	"github.com/Mirantis/virtlet/pkg/utils"
+	"github.com/Mirantis/virtlet/pkg/virt"
+	"github.com/Mirantis/virtlet/pkg/webhook"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
 )
@@ -45,12 +60,23 @@ const (
 	streamerSocketPath        = "/var/lib/libvirt/streamer.sock"
 	volumePoolName            = "volumes"
 	virtletSharedFsDir        = "/var/lib/virtlet/fs"
+	qemuLogDir                = "/var/log/libvirt/qemu"
+	bootDiagnosticsDir        = "/var/lib/virtlet/boot-diagnostics"
+	imageGCCheckInterval      = 10 * time.Second
+	kvmCheckInterval          = 5 * time.Minute
+	maintenanceCheckInterval  = time.Minute
+	suspendCheckInterval      = 5 * time.Second
+	nodeNameEnv               = "KUBE_NODE_NAME"
+	// metadataEncryptionKeySecretItem is the item of the secret
+	// that holds the metadata encryption key
+	metadataEncryptionKeySecretItem = "key"
 )
 
 // VirtletManager wraps the Virtlet's Runtime and Image CRI services,
 // as well as a gRPC server that provides access to them.
 type VirtletManager struct {
 	config         *v1.VirtletConfig
+	configLock     sync.Mutex
 	metadataStore  metadata.Store
 	fdManager      tapmanager.FDManager
 	diagSet        *diag.Set
@@ -60,6 +86,13 @@ type VirtletManager struct {
 	runtimeService *VirtletRuntimeService
 	imageService   *VirtletImageService
 	server         *Server
+	localAPIServer *localapi.Server
+	adminAPIServer *adminapi.Server
+	dispatcher     *webhook.Dispatcher
+	backupServer   *metadata.BackupServer
+	metricsServer  *http.Server
+	cordoned       bool
+	cordonReason   string
 }
 
 // NewVirtletManager creates a new VirtletManager.
@@ -72,6 +105,14 @@ func NewVirtletManager(config *v1.VirtletConfig, fdManager tapmanager.FDManager,
 // stopped or an error occurs.
 func (v *VirtletManager) Run() error {
 	var err error
+	var simConn *simulator.Connection
+	if *v.config.SimulateVMs {
+		glog.Warning("Using the VM simulator, no actual VMs will be started")
+		simConn = simulator.NewConnection(nil)
+		if v.fdManager == nil {
+			v.fdManager = simulator.NewFDManager(simConn)
+		}
+	}
 	if v.fdManager == nil {
 		client := tapmanager.NewFDClient(*v.config.FDServerSocketPath)
 		for i := 0; i < tapManagerAttemptCount; i++ {
@@ -86,47 +127,144 @@ func (v *VirtletManager) Run() error {
 		v.fdManager = client
 	}
 
-	v.metadataStore, err = metadata.NewStore(*v.config.DatabasePath)
+	v.metadataStore, err = newMetadataStore(v.config, v.clientCfg)
 	if err != nil {
 		return fmt.Errorf("failed to create metadata store: %v", err)
 	}
+	v.metadataStore.SetSandboxRemovalPolicy(metadata.SandboxRemovalPolicy(*v.config.SandboxRemovalPolicy))
+	if *v.config.MetadataAuditLog != "" {
+		auditLog, err := metadata.NewAuditLog(*v.config.MetadataAuditLog, int64(*v.config.MetadataAuditLogMaxSize)*1024*1024, nil)
+		if err != nil {
+			return fmt.Errorf("failed to open metadata audit log: %v", err)
+		}
+		v.metadataStore.SetAuditLog(auditLog)
+	}
 	v.diagSet.RegisterDiagSource("metadata", metadata.GetMetadataDumpSource(v.metadataStore))
+	v.diagSet.RegisterDiagSource("start-records", metadata.GetStartRecordsSource(v.metadataStore))
+	v.diagSet.RegisterDiagSource("sandbox-tombstones", metadata.GetSandboxTombstonesSource(v.metadataStore))
+	v.diagSet.RegisterDiagSource("sandbox-events", metadata.GetSandboxEventsSource(v.metadataStore))
+	if backuper, ok := v.metadataStore.(metadata.Backuper); ok {
+		v.backupServer = metadata.NewBackupServer(backuper)
+		go func() {
+			if err := v.backupServer.Serve(metadata.BackupSocketPath); err != nil {
+				glog.V(1).Infof("Metadata backup server stopped: %v", err)
+			}
+		}()
+	}
+
+	if *v.config.MetricsAddress != "" {
+		var collectors []prometheus.Collector
+		if c, ok := v.metadataStore.(prometheus.Collector); ok {
+			collectors = append(collectors, c)
+		}
+		collectors = append(collectors, newNetworkStatsCollector(v.metadataStore, v.fdManager))
+		handler, err := newMetricsHandler(collectors...)
+		if err != nil {
+			return err
+		}
+		v.metricsServer = &http.Server{Addr: *v.config.MetricsAddress, Handler: handler}
+		go func() {
+			glog.V(1).Infof("Serving the metrics on %s%s", *v.config.MetricsAddress, metricsPath)
+			if err := v.metricsServer.ListenAndServe(); err != http.ErrServerClosed {
+				glog.Errorf("Metrics server stopped: %v", err)
+			}
+		}()
+	}
 
 	downloader := image.NewDownloader(*v.config.DownloadProtocol)
-	v.imageStore = image.NewFileStore(*v.config.ImageDir, downloader, nil)
-	v.imageStore.SetRefGetter(v.metadataStore.ImagesInUse)
+	imageStore := image.NewFileStore(*v.config.ImageDir, downloader, nil)
+	imageStore.SetRefGetter(v.metadataStore.ImagesInUse)
+	v.configLock.Lock()
+	imageStore.SetPullLimit(*v.config.ImagePullLimit)
+	imageStore.SetPullRateLimit(*v.config.ImagePullRateLimit)
+	imageStore.SetGCPolicy(imageGCPolicy(v.config))
+	v.imageStore = imageStore
+	v.configLock.Unlock()
+	if v.backupServer != nil {
+		v.backupServer.SetImageChecker(func(name string) (bool, error) {
+			img, err := imageStore.ImageStatus(name)
+			return img != nil, err
+		})
+	}
 
-	var translator image.Translator
-	if !*v.config.SkipImageTranslation {
-		translator = imagetranslation.GetDefaultImageTranslator(*v.config.ImageTranslationConfigsDir, *v.config.EnableRegexpImageTranslation, v.clientCfg)
-	} else {
-		translator = imagetranslation.GetEmptyImageTranslator()
+	translator := NewImageTranslator(v.config, v.clientCfg)
+
+	if v.clientCfg != nil {
+		libvirttools.EnableVMPolicies(v.clientCfg)
 	}
 
-	conn, err := libvirttools.NewConnection(*v.config.LibvirtURI)
-	if err != nil {
-		return fmt.Errorf("error establishing libvirt connection: %v", err)
+	var domainConn virt.DomainConnection
+	var storageConn virt.StorageConnection
+	if simConn != nil {
+		domainConn, storageConn = simConn, simConn
+	} else {
+		conn, err := libvirttools.NewConnection(*v.config.LibvirtURI)
+		if err != nil {
+			return fmt.Errorf("error establishing libvirt connection: %v", err)
+		}
+		domainConn, storageConn = conn, conn
 	}
-	v.diagSet.RegisterDiagSource("libvirt-xml", libvirttools.NewLibvirtDiagSource(conn, conn))
+	v.diagSet.RegisterDiagSource("libvirt-xml", libvirttools.NewLibvirtDiagSource(domainConn, storageConn))
+
+	v.diagSet.RegisterDiagSource("kvm", diag.NewSimpleTextSource("txt", func() (string, error) {
+		return kvmcheck.NewChecker().Check().String(), nil
+	}))
 
+	v.configLock.Lock()
+	disableKVM := v.checkKVM()
 	virtConfig := libvirttools.VirtualizationConfig{
-		DisableKVM:           *v.config.DisableKVM,
+		DisableKVM:           disableKVM,
 		EnableSriov:          *v.config.EnableSriov,
 		CPUModel:             *v.config.CPUModel,
 		VolumePoolName:       volumePoolName,
 		SharedFilesystemPath: virtletSharedFsDir,
 		KubeletRootDir:       *v.config.KubeletRootDir,
+		RawDevices:           rawDeviceList(v.config),
+		QemuLogDirectory:     qemuLogDir,
+		MemoryStatsPeriod:    *v.config.MemoryStatsPeriod,
+		DomainMetadataLabels: domainMetadataLabelList(v.config),
+		HostDevicePolicyFile: *v.config.HostDevicePolicyFile,
+		MemoryBacking:        *v.config.MemoryBacking,
+		HugetlbfsMounts:      probeHugetlbfsMounts(*v.config.HugetlbfsMounts),
+		SandboxTombstoneTTL:  time.Duration(*v.config.SandboxTombstoneTTL) * time.Second,
+		VolumeReuseTTL:       time.Duration(*v.config.CrashLoopReuseTTL) * time.Second,
+		ReconcilePolicy:      libvirttools.ReconcilePolicy(*v.config.ReconcilePolicy),
 	}
-	if *v.config.RawDevices != "" {
-		virtConfig.RawDevices = strings.Split(*v.config.RawDevices, ",")
+	if virtConfig.MemoryBacking == string(types.MemoryBackingHugepages) && len(virtConfig.HugetlbfsMounts) == 0 {
+		glog.Warningf("Hugepage memory backing is used by default, but no hugetlbfs mounts are available")
 	}
+	storageInfo := probeDiskStorage(*v.config.ImageDir, libvirttools.StoragePoolPath(volumePoolName))
+	virtConfig.DiskCacheMode, virtConfig.DisableImageLocking = libvirttools.DiskStorageSettings(*v.config.DiskCacheMode, *v.config.ImageLocking, storageInfo)
+	glog.V(1).Infof("VM disk storage: %#v, cache mode: %q, image locking disabled: %v", storageInfo, virtConfig.DiskCacheMode, virtConfig.DisableImageLocking)
+	if err := os.MkdirAll(bootDiagnosticsDir, 0755); err != nil {
+		glog.Warningf("Can't create boot diagnostics directory %q, boot diagnostics disabled: %v", bootDiagnosticsDir, err)
+	} else {
+		virtConfig.BootDiagnosticsDirectory = bootDiagnosticsDir
+		v.diagSet.RegisterDiagSource("boot-diagnostics", diag.NewLogDirSource(bootDiagnosticsDir))
+	}
+	v.configLock.Unlock()
 
 	var streamServer StreamServer
+	var consoleWatcher localapi.ConsoleWatcher
 	if !*v.config.DisableLogging {
 		s, err := stream.NewServer(streamerSocketPath, v.metadataStore, *v.config.StreamPort)
 		if err != nil {
 			return fmt.Errorf("couldn't create stream server: %v", err)
 		}
+		s.SetConsoleAudit(*v.config.ConsoleAuditDir, *v.config.ConsoleAuditWebhook)
+		if *v.config.ConsoleEndpoints != "" {
+			// the TCP console endpoints use the local API token
+			// for authentication
+			var token string
+			if *v.config.ConsoleEndpoints == stream.ConsoleEndpointTCP {
+				if token, err = localapi.LoadToken(*v.config.LocalAPITokenFile); err != nil {
+					return fmt.Errorf("can't load the token for the console endpoints: %v", err)
+				}
+			}
+			if err := s.SetConsoleEndpoints(*v.config.ConsoleEndpoints, *v.config.ConsoleEndpointDir, token); err != nil {
+				return fmt.Errorf("can't set up the console endpoints: %v", err)
+			}
+		}
 
 		err = s.Start()
 		if err != nil {
@@ -134,19 +272,85 @@ func (v *VirtletManager) Run() error {
 
 		}
 		streamServer = s
+		consoleWatcher = s
 		virtConfig.StreamerSocketPath = streamerSocketPath
+	} else if *v.config.ConsoleEndpoints != "" {
+		glog.Warningf("The console endpoints are not available because logging is disabled")
 	}
 
 	volSrc := libvirttools.GetDefaultVolumeSource()
-	v.virtTool = libvirttools.NewVirtualizationTool(
-		conn, conn, v.imageStore, v.metadataStore, volSrc, virtConfig,
+	virtTool := libvirttools.NewVirtualizationTool(
+		domainConn, storageConn, v.imageStore, v.metadataStore, volSrc, virtConfig,
 		fs.RealFileSystem, utils.DefaultCommander)
+	v.configLock.Lock()
+	v.virtTool = virtTool
+	// pick up the config changes that were made while the
+	// VirtualizationTool was being created
+	v.virtTool.UpdateConfig(rawDeviceList(v.config), *v.config.CPUModel)
+	v.configLock.Unlock()
+	if s, ok := streamServer.(*stream.Server); ok {
+		s.SetVNCPortFunc(virtTool.VNCPort)
+	}
+	if v.clientCfg != nil {
+		v.virtTool.SetEventRecorder(libvirttools.NewKubeEventRecorder(v.clientCfg))
+		v.virtTool.SetPodChecker(libvirttools.NewKubePodChecker(v.clientCfg))
+	}
+	if *v.config.LifecycleWebhooks != "" {
+		if v.dispatcher, err = newWebhookDispatcher(v.config); err != nil {
+			return err
+		}
+		v.virtTool.SetLifecycleEventSink(v.dispatcher)
+	}
+	v.diagSet.RegisterDiagSource("disk-stats", libvirttools.NewDiskStatsDiagSource(v.virtTool))
+	v.diagSet.RegisterDiagSource("emulator-info", libvirttools.NewEmulatorInfoDiagSource(v.virtTool))
+	v.diagSet.RegisterDiagSource("network-stats", NewNetworkStatsDiagSource(v.metadataStore, v.fdManager))
+	v.diagSet.RegisterDiagSource("network-traces", NewNetworkTraceDiagSource(v.fdManager))
+	v.diagSet.RegisterDiagSource("network-info", NewNetworkInfoDiagSource(v.metadataStore, v.fdManager))
+
+	v.imageService = NewVirtletImageService(v.imageStore, translator, metadata.WithAuditActor(v.metadataStore, metadata.AuditActorCRI, "PullImage"), nil)
+	v.imageService.SetPullReuseTTL(time.Duration(*v.config.CrashLoopReuseTTL) * time.Second)
+	runtimeService := NewVirtletRuntimeService(v.virtTool, v.metadataStore, v.fdManager, streamServer, v.imageStore, v.imageService, nil)
+	v.configLock.Lock()
+	runtimeService.SetVMsCordoned(v.cordoned, v.cordonReason)
+	v.runtimeService = runtimeService
+	v.configLock.Unlock()
+
+	if *v.config.LocalAPISocketPath != "" {
+		token, err := localapi.LoadToken(*v.config.LocalAPITokenFile)
+		if err != nil {
+			return fmt.Errorf("can't load the local API token: %v", err)
+		}
+		v.localAPIServer = localapi.NewServer(v.virtTool.WithAuditActor(metadata.AuditActorLocalAPI, ""), consoleWatcher, token)
+		if *v.config.LocalAPITLSCertFile != "" || *v.config.LocalAPITLSKeyFile != "" {
+			if err := v.localAPIServer.SetTLS(*v.config.LocalAPITLSCertFile, *v.config.LocalAPITLSKeyFile); err != nil {
+				return fmt.Errorf("can't set up TLS for the local API: %v", err)
+			}
+		}
+		go func() {
+			glog.V(1).Infof("Starting local API server on socket %s", *v.config.LocalAPISocketPath)
+			if err := v.localAPIServer.Serve(*v.config.LocalAPISocketPath); err != nil {
+				glog.Errorf("Local API server stopped: %v", err)
+			}
+		}()
+	}
 
-	runtimeService := NewVirtletRuntimeService(v.virtTool, v.metadataStore, v.fdManager, streamServer, v.imageStore, nil)
-	imageService := NewVirtletImageService(v.imageStore, translator, nil)
+	if *v.config.AdminAPISocketPath != "" {
+		tokens, err := adminapi.LoadTokens(*v.config.AdminAPITokenFile)
+		if err != nil {
+			return fmt.Errorf("can't load the admin API tokens: %v", err)
+		}
+		backuper, _ := v.metadataStore.(metadata.Backuper)
+		v.adminAPIServer = adminapi.NewServer(v.virtTool.WithAuditActor(metadata.AuditActorVirtletctl, ""), consoleWatcher, backuper, tokens)
+		go func() {
+			glog.V(1).Infof("Starting admin API server on socket %s", *v.config.AdminAPISocketPath)
+			if err := v.adminAPIServer.Serve(*v.config.AdminAPISocketPath); err != nil {
+				glog.Errorf("Admin API server stopped: %v", err)
+			}
+		}()
+	}
 
 	v.server = NewServer()
-	v.server.Register(runtimeService, imageService)
+	v.server.Register(v.runtimeService, v.imageService)
 
 	healthServer := health.NewServer()
 	grpc_health_v1.RegisterHealthServer(v.server.server, healthServer)
@@ -155,6 +359,11 @@ func (v *VirtletManager) Run() error {
 		// we consider recover / gc errors non-fatal
 		glog.Warning(err)
 	}
+	go v.runImageGC()
+	go v.runMetadataGC()
+	go v.runMaintenanceReboots()
+	go v.runSuspendCheck()
+	go v.runKVMCheck(disableKVM)
 
 	glog.V(1).Infof("Starting server on socket %s", *v.config.CRISocketPath)
 	if err = v.server.Serve(*v.config.CRISocketPath); err != nil {
@@ -164,20 +373,391 @@ func (v *VirtletManager) Run() error {
 	return nil
 }
 
-// Stop stops the gRPC listener of the VirtletManager, if it's active.
+// UpdateConfig applies the settings from the config that can be
+// changed without restarting Virtlet. Currently these are the raw
+// device list, the default CPU model, the image pull limits and the
+// image GC settings.
+func (v *VirtletManager) UpdateConfig(config *v1.VirtletConfig) {
+	v.configLock.Lock()
+	defer v.configLock.Unlock()
+	v.config.RawDevices = config.RawDevices
+	v.config.CPUModel = config.CPUModel
+	v.config.ImagePullLimit = config.ImagePullLimit
+	v.config.ImagePullRateLimit = config.ImagePullRateLimit
+	v.config.ImageGCInterval = config.ImageGCInterval
+	v.config.ImageGCMinAge = config.ImageGCMinAge
+	v.config.ImageGCProtectedImages = config.ImageGCProtectedImages
+	v.config.ImageGCHighWatermark = config.ImageGCHighWatermark
+	v.config.ImageGCLowWatermark = config.ImageGCLowWatermark
+	if v.virtTool != nil {
+		v.virtTool.UpdateConfig(rawDeviceList(v.config), *v.config.CPUModel)
+	}
+	if v.imageStore != nil {
+		v.imageStore.SetPullLimit(*v.config.ImagePullLimit)
+		v.imageStore.SetPullRateLimit(*v.config.ImagePullRateLimit)
+		v.imageStore.SetGCPolicy(imageGCPolicy(v.config))
+	}
+}
+
+// SetVMsCordoned pauses or resumes the admission of new VM pods on
+// the node. It can be called before Run.
+func (v *VirtletManager) SetVMsCordoned(cordoned bool, reason string) {
+	v.configLock.Lock()
+	defer v.configLock.Unlock()
+	if cordoned {
+		glog.Warningf("The node is cordoned for new VMs: %q", reason)
+	} else if v.cordoned {
+		glog.V(1).Infof("The node is uncordoned for new VMs")
+	}
+	v.cordoned = cordoned
+	v.cordonReason = reason
+	if v.runtimeService != nil {
+		v.runtimeService.SetVMsCordoned(cordoned, reason)
+	}
+}
+
+func (v *VirtletManager) imageGCInterval() time.Duration {
+	v.configLock.Lock()
+	defer v.configLock.Unlock()
+	return time.Duration(*v.config.ImageGCInterval) * time.Second
+}
+
+// runImageGC performs periodic image garbage collection if it's
+// enabled in the config and removes unused images when the disk
+// usage exceeds the high watermark. The GC settings can be changed
+// on the fly.
+func (v *VirtletManager) runImageGC() {
+	lastGC := time.Now()
+	for range time.Tick(imageGCCheckInterval) {
+		if err := v.imageStore.FreeDiskSpace(); err != nil {
+			glog.Warningf("Disk usage based image GC failed: %v", err)
+		}
+		interval := v.imageGCInterval()
+		if interval <= 0 || time.Since(lastGC) < interval {
+			continue
+		}
+		glog.V(2).Infof("Running periodic image GC")
+		if err := v.imageStore.GC(); err != nil {
+			glog.Warningf("Image GC failed: %v", err)
+		}
+		lastGC = time.Now()
+	}
+}
+
+// runMetadataGC periodically removes the orphaned pod sandbox and
+// container records from the metadata store, such as the ones left
+// by the crashed RunPodSandbox flows, along with the expired pod
+// sandbox tombstones.
+func (v *VirtletManager) runMetadataGC() {
+	interval := time.Duration(*v.config.MetadataGCInterval) * time.Second
+	if interval <= 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		glog.V(2).Infof("Running periodic metadata GC")
+		for _, err := range v.virtTool.WithAuditActor(metadata.AuditActorGC, "").RemoveOrphanMetadata() {
+			glog.Warningf("Metadata GC error: %v", err)
+		}
+	}
+}
+
+// runMaintenanceReboots periodically reboots the VMs that are
+// within their maintenance windows.
+func (v *VirtletManager) runMaintenanceReboots() {
+	maxReboots := *v.config.MaxConcurrentMaintenanceReboots
+	if maxReboots <= 0 {
+		return
+	}
+	for range time.Tick(maintenanceCheckInterval) {
+		if err := v.virtTool.RebootForMaintenance(maxReboots); err != nil {
+			glog.Warningf("Maintenance reboot check failed: %v", err)
+		}
+	}
+}
+
+// runSuspendCheck periodically looks for the VMs that were paused or
+// suspended and the ones that were resumed.
+func (v *VirtletManager) runSuspendCheck() {
+	for range time.Tick(suspendCheckInterval) {
+		if err := v.virtTool.CheckSuspendedVMs(); err != nil {
+			glog.Warningf("Suspended VM check failed: %v", err)
+		}
+	}
+}
+
+// checkKVM checks whether KVM is usable on the node and returns
+// true if KVM should be disabled, which is the case if it's disabled
+// in the config or if it's not usable and the config allows Virtlet
+// to fall back to TCG. It must be called with configLock held.
+func (v *VirtletManager) checkKVM() bool {
+	report := kvmcheck.NewChecker().Check()
+	disableKVM := *v.config.DisableKVM
+	switch {
+	case disableKVM || report.Usable:
+		glog.V(1).Infof("KVM check results:\n%s", report)
+	case *v.config.AutoDisableKVM:
+		glog.Warningf("KVM is not usable on this node, falling back to TCG (software emulation):\n%s", report)
+		disableKVM = true
+	default:
+		glog.Warningf("KVM is not usable on this node, VMs will fail to start. Fix the problems or set autoDisableKVM/disableKVM option:\n%s", report)
+	}
+	return disableKVM
+}
+
+// runKVMCheck periodically checks whether KVM is usable on the node
+// and reports the results via the node condition and the events.
+func (v *VirtletManager) runKVMCheck(disableKVM bool) {
+	nodeName := os.Getenv(nodeNameEnv)
+	if v.clientCfg == nil || nodeName == "" {
+		return
+	}
+	checker := kvmcheck.NewChecker()
+	reporter := kvmcheck.NewNodeReporter(v.clientCfg, nodeName)
+	for {
+		report := checker.Check()
+		if !disableKVM && !report.Usable {
+			glog.Warningf("KVM is not usable on this node: %s", report.Problems())
+		}
+		if err := reporter.Report(report, disableKVM); err != nil {
+			glog.Warningf("Failed to report KVM check results: %v", err)
+		}
+		time.Sleep(kvmCheckInterval)
+	}
+}
+
+func imageGCPolicy(config *v1.VirtletConfig) image.GCPolicy {
+	var protected []string
+	if *config.ImageGCProtectedImages != "" {
+		protected = strings.Split(*config.ImageGCProtectedImages, ",")
+	}
+	return image.GCPolicy{
+		MinAge:          time.Duration(*config.ImageGCMinAge) * time.Second,
+		ProtectedImages: protected,
+		HighWatermark:   *config.ImageGCHighWatermark,
+		LowWatermark:    *config.ImageGCLowWatermark,
+	}
+}
+
+func rawDeviceList(config *v1.VirtletConfig) []string {
+	if *config.RawDevices == "" {
+		return nil
+	}
+	return strings.Split(*config.RawDevices, ",")
+}
+
+func domainMetadataLabelList(config *v1.VirtletConfig) []string {
+	if *config.DomainMetadataLabels == "" {
+		return nil
+	}
+	return strings.Split(*config.DomainMetadataLabels, ",")
+}
+
+// NewImageTranslator returns the image name translator that's
+// configured by the specified Virtlet config.
+func NewImageTranslator(config *v1.VirtletConfig, clientCfg clientcmd.ClientConfig) image.Translator {
+	if *config.SkipImageTranslation {
+		return imagetranslation.GetEmptyImageTranslator()
+	}
+	return imagetranslation.GetDefaultImageTranslator(*config.ImageTranslationConfigsDir, *config.EnableRegexpImageTranslation, clientCfg)
+}
+
+// newMetadataStore creates the metadata store using the backend
+// specified in the config.
+func newMetadataStore(config *v1.VirtletConfig, clientCfg clientcmd.ClientConfig) (metadata.Store, error) {
+	key, err := MetadataEncryptionKey(config, clientCfg)
+	if err != nil {
+		return nil, err
+	}
+	switch *config.MetadataBackend {
+	case "badger", "etcd", "sqlite":
+	default:
+		if err := metadata.CompactDatabase(*config.DatabasePath, *config.MetadataCompactionThreshold); err != nil {
+			return nil, err
+		}
+		return metadata.NewEncryptedStore(*config.DatabasePath, key)
+	}
+	if key != nil {
+		// don't let the sensitive data be stored in plaintext
+		// while the encryption is thought to be enabled
+		return nil, fmt.Errorf("metadata encryption is not supported by the %s metadata backend", *config.MetadataBackend)
+	}
+	switch *config.MetadataBackend {
+	case "badger":
+		return metadata.NewBadgerStore(*config.BadgerDatabaseDir)
+	case "sqlite":
+		return metadata.NewSQLiteStore(*config.SQLiteDatabasePath)
+	}
+	nodeName := os.Getenv(nodeNameEnv)
+	if nodeName == "" {
+		return nil, fmt.Errorf("%s must be set to use the etcd metadata backend", nodeNameEnv)
+	}
+	var endpoints []string
+	for _, endpoint := range strings.Split(*config.EtcdEndpoints, ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return metadata.NewEtcdStore(metadata.EtcdConfig{
+		Endpoints: endpoints,
+		KeyPrefix: strings.TrimSuffix(*config.EtcdKeyPrefix, "/") + "/" + nodeName + "/",
+		CAFile:    *config.EtcdCAFile,
+		CertFile:  *config.EtcdCertFile,
+		KeyFile:   *config.EtcdKeyFile,
+	})
+}
+
+// newWebhookDispatcher creates the dispatcher that delivers the VM
+// lifecycle events to the webhooks specified in the config.
+func newWebhookDispatcher(config *v1.VirtletConfig) (*webhook.Dispatcher, error) {
+	opts := webhook.Options{Node: os.Getenv(nodeNameEnv)}
+	for _, url := range strings.Split(*config.LifecycleWebhooks, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			opts.URLs = append(opts.URLs, url)
+		}
+	}
+	if *config.LifecycleWebhookSecretFile != "" {
+		var err error
+		if opts.Secret, err = webhook.LoadSecret(*config.LifecycleWebhookSecretFile); err != nil {
+			return nil, err
+		}
+	}
+	return webhook.NewDispatcher(opts), nil
+}
+
+// probeDiskStorage returns the combined properties of the filesystems
+// that hold the VM disks and their backing images, or nil if none of
+// them could be probed.
+func probeDiskStorage(dirs ...string) *fs.StorageInfo {
+	var r *fs.StorageInfo
+	for _, dir := range dirs {
+		si, err := fs.ProbeStorage(dir)
+		switch {
+		case err != nil:
+			glog.Warningf("Can't probe the storage filesystem at %q: %v", dir, err)
+		case r == nil:
+			r = si
+		default:
+			r = r.Merge(si)
+		}
+		if si != nil && si.NetworkFSType != "" {
+			glog.Infof("%q is located on a network filesystem (%s), O_DIRECT supported: %v, OFD locks supported: %v", dir, si.NetworkFSType, si.DirectIO, si.OFDLocks)
+		}
+	}
+	return r
+}
+
+// MetadataEncryptionKey returns the key for the metadata encryption
+// from the file or the Kubernetes secret specified in the config, or
+// nil if the encryption is not enabled.
+func MetadataEncryptionKey(config *v1.VirtletConfig, clientCfg clientcmd.ClientConfig) ([]byte, error) {
+	switch {
+	case *config.MetadataEncryptionKeyFile != "":
+		key, err := ioutil.ReadFile(*config.MetadataEncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read the metadata encryption key: %v", err)
+		}
+		return bytes.TrimSpace(key), nil
+	case *config.MetadataEncryptionKeySecret != "":
+		parts := strings.SplitN(*config.MetadataEncryptionKeySecret, "/", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad metadata encryption key secret %q", *config.MetadataEncryptionKeySecret)
+		}
+		if clientCfg == nil {
+			return nil, errors.New("can't get the metadata encryption key secret without a Kubernetes client config")
+		}
+		restConfig, err := clientCfg.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		kubeClient, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return nil, err
+		}
+		secret, err := kubeClient.CoreV1().Secrets(parts[0]).Get(parts[1], meta_v1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("can't get the metadata encryption key secret %q: %v", *config.MetadataEncryptionKeySecret, err)
+		}
+		key, found := secret.Data[metadataEncryptionKeySecretItem]
+		if !found {
+			return nil, fmt.Errorf("the metadata encryption key secret %q has no %q item", *config.MetadataEncryptionKeySecret, metadataEncryptionKeySecretItem)
+		}
+		return bytes.TrimSpace(key), nil
+	default:
+		return nil, nil
+	}
+}
+
+// probeHugetlbfsMounts returns the hugetlbfs mount points from the
+// comma-separated list that are actually available on the node,
+// keyed by their huge page size.
+func probeHugetlbfsMounts(mounts string) map[uint64]string {
+	r := make(map[uint64]string)
+	for _, path := range strings.Split(mounts, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		pageSize, err := fs.HugetlbfsPageSize(path)
+		if err != nil {
+			glog.Infof("Can't use %q for hugepage-backed VM memory: %v", path, err)
+			continue
+		}
+		if existing, found := r[pageSize]; found {
+			glog.Warningf("Ignoring hugetlbfs mount %q: %q is already used for %d byte huge pages", path, existing, pageSize)
+			continue
+		}
+		glog.V(1).Infof("Using hugetlbfs mount %q for %d byte huge pages", path, pageSize)
+		r[pageSize] = path
+	}
+	return r
+}
+
+// Stop stops the gRPC listener, the local API listener, the
+// metadata backup listener and the metrics listener of the
+// VirtletManager, if they're active, and delivers the pending
+// lifecycle webhook events.
 func (v *VirtletManager) Stop() {
 	if v.server != nil {
 		v.server.Stop()
 	}
+	if v.localAPIServer != nil {
+		v.localAPIServer.Stop()
+	}
+	if v.adminAPIServer != nil {
+		v.adminAPIServer.Stop()
+	}
+	if v.backupServer != nil {
+		v.backupServer.Stop()
+	}
+	if v.metricsServer != nil {
+		v.metricsServer.Close()
+	}
+	v.dispatcher.Stop()
 }
 
 // recoverAndGC performs the initial actions during VirtletManager
-// startup, including recovering network namespaces and performing
-// garbage collection for both libvirt and the image store.
+// startup, including reconciling the metadata with libvirt domains,
+// recovering network namespaces and performing garbage collection
+// for the metadata, libvirt and the image store and resuming the
+// guest log streaming and the image pulls interrupted by Virtlet
+// restart.
 func (v *VirtletManager) recoverAndGC() error {
 	var errors []string
 
-	for _, err := range v.virtTool.GarbageCollect() {
+	discrepancies, reconcileErrors := v.virtTool.WithAuditActor(metadata.AuditActorReconcile, "").Reconcile()
+	if len(discrepancies) != 0 {
+		glog.Warningf("Reconciled %d discrepancies between the metadata store and libvirt", len(discrepancies))
+	}
+	for _, err := range reconcileErrors {
+		errors = append(errors, fmt.Sprintf("* error reconciling metadata with libvirt: %v", err))
+	}
+
+	gcTool := v.virtTool.WithAuditActor(metadata.AuditActorGC, "")
+	for _, err := range gcTool.RemoveOrphanMetadata() {
+		errors = append(errors, fmt.Sprintf("* error removing orphan metadata: %v", err))
+	}
+
+	for _, err := range gcTool.GarbageCollect() {
 		errors = append(errors, fmt.Sprintf("* error performing libvirt GC: %v", err))
 	}
 
@@ -186,10 +766,22 @@ func (v *VirtletManager) recoverAndGC() error {
 		errors = append(errors, fmt.Sprintf("* error recovering VM network namespaces: %v", err))
 	}
 
+	if err := v.virtTool.ResumeGuestLogStreaming(); err != nil {
+		errors = append(errors, fmt.Sprintf("* error resuming guest log streaming: %v", err))
+	}
+
+	if err := v.virtTool.ResumeVCPUAutoscaling(); err != nil {
+		errors = append(errors, fmt.Sprintf("* error resuming vCPU autoscaling: %v", err))
+	}
+
 	if err := v.imageStore.GC(); err != nil {
 		errors = append(errors, fmt.Sprintf("* error during image GC: %v", err))
 	}
 
+	if err := v.imageService.RecoverPulls(); err != nil {
+		errors = append(errors, fmt.Sprintf("* error recovering image pulls: %v", err))
+	}
+
 	if len(errors) == 0 {
 		return nil
 	}
@@ -240,13 +832,20 @@ OUTER:
 			}
 		}
 
+		bootOptions, err := types.ParseNetBootOptions(psi.Config.Annotations)
+		if err != nil {
+			glog.Warningf("Ignoring bad network boot settings for pod %q: %v", s.GetID(), err)
+		}
+
 		if err := v.fdManager.Recover(
 			s.GetID(),
 			tapmanager.RecoverPayload{
 				Description: &tapmanager.PodNetworkDesc{
-					PodID:   s.GetID(),
-					PodNs:   psi.Config.Namespace,
-					PodName: psi.Config.Name,
+					PodID:                s.GetID(),
+					PodNs:                psi.Config.Namespace,
+					PodName:              psi.Config.Name,
+					BootOptions:          bootOptions,
+					DetectGuestAddresses: types.ParseGuestAddressDetection(psi.Config.Annotations),
 				},
 				ContainerSideNetwork:  psi.ContainerSideNetwork,
 				HaveRunningContainers: haveRunningContainers,
@@ -0,0 +1,86 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"github.com/Mirantis/virtlet/pkg/diag"
+	"github.com/Mirantis/virtlet/pkg/metadata"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/tapmanager"
+)
+
+// NetworkInfoDiagSource dumps the DHCP lease states, the neighbor
+// tables and the routes of the network namespaces of the VM pods,
+// one JSON file per pod.
+type NetworkInfoDiagSource struct {
+	metadataStore metadata.Store
+	fdManager     tapmanager.FDManager
+}
+
+var _ diag.Source = &NetworkInfoDiagSource{}
+
+// NewNetworkInfoDiagSource creates a new NetworkInfoDiagSource.
+func NewNetworkInfoDiagSource(metadataStore metadata.Store, fdManager tapmanager.FDManager) *NetworkInfoDiagSource {
+	return &NetworkInfoDiagSource{metadataStore: metadataStore, fdManager: fdManager}
+}
+
+// DiagnosticInfo implements DiagnosticInfo method of the Source
+// interface.
+func (s *NetworkInfoDiagSource) DiagnosticInfo() (diag.Result, error) {
+	dr := diag.Result{
+		IsDir:    true,
+		Children: make(map[string]diag.Result),
+	}
+	sandboxes, err := s.metadataStore.ListPodSandboxes(nil)
+	if err != nil {
+		return diag.Result{}, err
+	}
+	for _, sandbox := range sandboxes {
+		psi, err := sandbox.Retrieve()
+		if err != nil {
+			return diag.Result{}, err
+		}
+		if psi == nil || psi.State != types.PodSandboxState_SANDBOX_READY {
+			continue
+		}
+		data, err := s.fdManager.GetNetworkInfo(sandbox.GetID())
+		if err != nil {
+			glog.Warningf("Error getting network info for pod sandbox %q: %v", sandbox.GetID(), err)
+			continue
+		}
+		var info tapmanager.PodNetworkInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			return diag.Result{}, fmt.Errorf("error unmarshalling network info: %v", err)
+		}
+		out, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return diag.Result{}, fmt.Errorf("error marshalling network info: %v", err)
+		}
+		fileName := fmt.Sprintf("%s-%s", psi.Config.Namespace, psi.Config.Name)
+		dr.Children[fileName] = diag.Result{
+			Name: fileName,
+			Ext:  "json",
+			Data: string(out),
+		}
+	}
+	return dr, nil
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Mirantis/virtlet/pkg/diag"
+	"github.com/Mirantis/virtlet/pkg/metadata"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+	"github.com/Mirantis/virtlet/pkg/tapmanager"
+)
+
+// podNetworkStats returns packet and byte counters for the network
+// interfaces of the VM pod with the specified sandbox id.
+func podNetworkStats(fdManager tapmanager.FDManager, podSandboxID string) ([]tapmanager.InterfaceStats, error) {
+	data, err := fdManager.GetStats(podSandboxID)
+	if err != nil {
+		return nil, err
+	}
+	var stats []tapmanager.InterfaceStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, fmt.Errorf("error unmarshalling network stats: %v", err)
+	}
+	return stats, nil
+}
+
+// statsByNetwork groups the interface stats by the name of the CNI
+// network the interfaces belong to.
+func statsByNetwork(stats []tapmanager.InterfaceStats) map[string][]tapmanager.InterfaceStats {
+	r := make(map[string][]tapmanager.InterfaceStats)
+	for _, s := range stats {
+		r[s.Network] = append(r[s.Network], s)
+	}
+	return r
+}
+
+// forEachReadyPodNetworkStats invokes fn with the network stats of
+// each ready pod sandbox. The pods for which the stats can't be
+// retrieved are skipped.
+func forEachReadyPodNetworkStats(metadataStore metadata.Store, fdManager tapmanager.FDManager, fn func(psi *types.PodSandboxInfo, stats []tapmanager.InterfaceStats) error) error {
+	sandboxes, err := metadataStore.ListPodSandboxes(nil)
+	if err != nil {
+		return err
+	}
+	for _, sandbox := range sandboxes {
+		psi, err := sandbox.Retrieve()
+		if err != nil {
+			return err
+		}
+		if psi == nil || psi.State != types.PodSandboxState_SANDBOX_READY {
+			continue
+		}
+		stats, err := podNetworkStats(fdManager, sandbox.GetID())
+		if err != nil {
+			glog.Warningf("Error getting network stats for pod sandbox %q: %v", sandbox.GetID(), err)
+			continue
+		}
+		if err := fn(psi, stats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// podGuestAddress returns the IP address used by the VM of the pod
+// with the specified sandbox id as learned by tapmanager, or an empty
+// string if the address isn't known yet. IPv4 addresses take
+// precedence over IPv6 ones.
+func podGuestAddress(fdManager tapmanager.FDManager, podSandboxID string) (string, error) {
+	data, err := fdManager.GetGuestAddresses(podSandboxID)
+	if err != nil {
+		return "", err
+	}
+	var addrs []tapmanager.GuestAddresses
+	if err := json.Unmarshal(data, &addrs); err != nil {
+		return "", fmt.Errorf("error unmarshalling guest addresses: %v", err)
+	}
+	r := ""
+	for _, ga := range addrs {
+		for _, ip := range ga.IPs {
+			if net.ParseIP(ip).To4() != nil {
+				return ip, nil
+			}
+			if r == "" {
+				r = ip
+			}
+		}
+	}
+	return r, nil
+}
+
+// NetworkStatsDiagSource dumps packet and byte counters for the
+// network interfaces of the VM pods, one JSON file per pod, with the
+// interfaces grouped by the CNI network name.
+type NetworkStatsDiagSource struct {
+	metadataStore metadata.Store
+	fdManager     tapmanager.FDManager
+}
+
+var _ diag.Source = &NetworkStatsDiagSource{}
+
+// NewNetworkStatsDiagSource creates a new NetworkStatsDiagSource.
+func NewNetworkStatsDiagSource(metadataStore metadata.Store, fdManager tapmanager.FDManager) *NetworkStatsDiagSource {
+	return &NetworkStatsDiagSource{metadataStore: metadataStore, fdManager: fdManager}
+}
+
+// DiagnosticInfo implements DiagnosticInfo method of the Source
+// interface.
+func (s *NetworkStatsDiagSource) DiagnosticInfo() (diag.Result, error) {
+	dr := diag.Result{
+		IsDir:    true,
+		Children: make(map[string]diag.Result),
+	}
+	if err := forEachReadyPodNetworkStats(s.metadataStore, s.fdManager, func(psi *types.PodSandboxInfo, stats []tapmanager.InterfaceStats) error {
+		out, err := json.MarshalIndent(statsByNetwork(stats), "", "  ")
+		if err != nil {
+			return fmt.Errorf("error marshalling network stats: %v", err)
+		}
+		fileName := fmt.Sprintf("%s-%s", psi.Config.Namespace, psi.Config.Name)
+		dr.Children[fileName] = diag.Result{
+			Name: fileName,
+			Ext:  "json",
+			Data: string(out),
+		}
+		return nil
+	}); err != nil {
+		return diag.Result{}, err
+	}
+	return dr, nil
+}
+
+// networkCounter describes a Prometheus counter for a field of the
+// VM network interface stats.
+type networkCounter struct {
+	desc  *prometheus.Desc
+	value func(s *tapmanager.InterfaceStats) uint64
+}
+
+func newNetworkCounter(name, help string, value func(s *tapmanager.InterfaceStats) uint64) networkCounter {
+	return networkCounter{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName("virtlet", "vm_network", name),
+			help,
+			[]string{"namespace", "pod", "network", "interface"}, nil),
+		value: value,
+	}
+}
+
+var networkCounters = []networkCounter{
+	newNetworkCounter("receive_bytes_total", "Number of bytes received by the VM network interface.",
+		func(s *tapmanager.InterfaceStats) uint64 { return s.RxBytes }),
+	newNetworkCounter("receive_packets_total", "Number of packets received by the VM network interface.",
+		func(s *tapmanager.InterfaceStats) uint64 { return s.RxPackets }),
+	newNetworkCounter("receive_errors_total", "Number of receive errors of the VM network interface.",
+		func(s *tapmanager.InterfaceStats) uint64 { return s.RxErrors }),
+	newNetworkCounter("receive_dropped_total", "Number of incoming packets dropped by the VM network interface.",
+		func(s *tapmanager.InterfaceStats) uint64 { return s.RxDropped }),
+	newNetworkCounter("transmit_bytes_total", "Number of bytes sent by the VM network interface.",
+		func(s *tapmanager.InterfaceStats) uint64 { return s.TxBytes }),
+	newNetworkCounter("transmit_packets_total", "Number of packets sent by the VM network interface.",
+		func(s *tapmanager.InterfaceStats) uint64 { return s.TxPackets }),
+	newNetworkCounter("transmit_errors_total", "Number of transmit errors of the VM network interface.",
+		func(s *tapmanager.InterfaceStats) uint64 { return s.TxErrors }),
+	newNetworkCounter("transmit_dropped_total", "Number of outgoing packets dropped by the VM network interface.",
+		func(s *tapmanager.InterfaceStats) uint64 { return s.TxDropped }),
+}
+
+// networkStatsCollector exports packet and byte counters for the
+// network interfaces of the VM pods as Prometheus metrics labeled by
+// the pod and the CNI network name. CRI v1alpha2 which is used by
+// Virtlet has no pod sandbox network stats, so this is the way to
+// make them available to the standard monitoring tools.
+type networkStatsCollector struct {
+	metadataStore metadata.Store
+	fdManager     tapmanager.FDManager
+}
+
+var _ prometheus.Collector = &networkStatsCollector{}
+
+func newNetworkStatsCollector(metadataStore metadata.Store, fdManager tapmanager.FDManager) *networkStatsCollector {
+	return &networkStatsCollector{metadataStore: metadataStore, fdManager: fdManager}
+}
+
+// Describe implements Describe method of prometheus.Collector
+// interface.
+func (c *networkStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, nc := range networkCounters {
+		ch <- nc.desc
+	}
+}
+
+// Collect implements Collect method of prometheus.Collector
+// interface. The stats are retrieved from tapmanager each time the
+// metrics are collected.
+func (c *networkStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := forEachReadyPodNetworkStats(c.metadataStore, c.fdManager, func(psi *types.PodSandboxInfo, stats []tapmanager.InterfaceStats) error {
+		for n := range stats {
+			for _, nc := range networkCounters {
+				ch <- prometheus.MustNewConstMetric(nc.desc, prometheus.CounterValue, float64(nc.value(&stats[n])),
+					psi.Config.Namespace, psi.Config.Name, stats[n].Network, stats[n].Name)
+			}
+		}
+		return nil
+	}); err != nil {
+		glog.Warningf("Error collecting VM network metrics: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Mirantis/virtlet/pkg/diag"
+	"github.com/Mirantis/virtlet/pkg/tapmanager"
+)
+
+// NetworkTraceDiagSource dumps the traces of the recent network
+// setups and teardowns of the VM pods, one JSON file per pod.
+// The traces are kept by the tapmanager after the pod network
+// is torn down, so the pods that are already gone, e.g. because
+// of a failed CNI setup, are included, too.
+type NetworkTraceDiagSource struct {
+	fdManager tapmanager.FDManager
+}
+
+var _ diag.Source = &NetworkTraceDiagSource{}
+
+// NewNetworkTraceDiagSource creates a new NetworkTraceDiagSource.
+func NewNetworkTraceDiagSource(fdManager tapmanager.FDManager) *NetworkTraceDiagSource {
+	return &NetworkTraceDiagSource{fdManager: fdManager}
+}
+
+// DiagnosticInfo implements DiagnosticInfo method of the Source
+// interface.
+func (s *NetworkTraceDiagSource) DiagnosticInfo() (diag.Result, error) {
+	data, err := s.fdManager.GetTraces("")
+	if err != nil {
+		return diag.Result{}, err
+	}
+	var traces []tapmanager.NetworkTrace
+	if err := json.Unmarshal(data, &traces); err != nil {
+		return diag.Result{}, fmt.Errorf("error unmarshalling network traces: %v", err)
+	}
+
+	tracesByPod := make(map[string][]tapmanager.NetworkTrace)
+	var podIDs []string
+	for _, trace := range traces {
+		if _, found := tracesByPod[trace.PodID]; !found {
+			podIDs = append(podIDs, trace.PodID)
+		}
+		tracesByPod[trace.PodID] = append(tracesByPod[trace.PodID], trace)
+	}
+
+	dr := diag.Result{
+		IsDir:    true,
+		Children: make(map[string]diag.Result),
+	}
+	for _, podID := range podIDs {
+		podTraces := tracesByPod[podID]
+		out, err := json.MarshalIndent(podTraces, "", "  ")
+		if err != nil {
+			return diag.Result{}, fmt.Errorf("error marshalling network traces: %v", err)
+		}
+		// the pod id is included in the file name because
+		// the pods with the same name may have been recreated
+		fileName := fmt.Sprintf("%s-%s-%s", podTraces[0].PodNs, podTraces[0].PodName, podID)
+		dr.Children[fileName] = diag.Result{
+			Name: fileName,
+			Ext:  "json",
+			Data: string(out),
+		}
+	}
+	return dr, nil
+}
@@ -17,8 +17,11 @@ limitations under the License.
 package manager
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	cnitypes "github.com/containernetworking/cni/pkg/types"
@@ -26,6 +29,9 @@ import (
 	"github.com/golang/glog"
 	"github.com/jonboulle/clockwork"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
 	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 
 	"github.com/Mirantis/virtlet/pkg/cni"
@@ -59,7 +65,11 @@ type VirtletRuntimeService struct {
 	fdManager     tapmanager.FDManager
 	streamServer  StreamServer
 	gcHandler     GCHandler
+	imageService  kubeapi.ImageServiceServer
 	clock         clockwork.Clock
+	cordonLock    sync.Mutex
+	cordoned      bool
+	cordonReason  string
 }
 
 // NewVirtletRuntimeService returns a new instance of VirtletRuntimeService.
@@ -69,6 +79,7 @@ func NewVirtletRuntimeService(
 	fdManager tapmanager.FDManager,
 	streamServer StreamServer,
 	gcHandler GCHandler,
+	imageService kubeapi.ImageServiceServer,
 	clock clockwork.Clock) *VirtletRuntimeService {
 	if clock == nil {
 		clock = clockwork.NewRealClock()
@@ -79,10 +90,47 @@ func NewVirtletRuntimeService(
 		fdManager:     fdManager,
 		streamServer:  streamServer,
 		gcHandler:     gcHandler,
+		imageService:  imageService,
 		clock:         clock,
 	}
 }
 
+// criTool returns the view of the VM tool that attributes the
+// metadata changes to the specified CRI method in the audit log.
+func (v *VirtletRuntimeService) criTool(method string) *libvirttools.VirtualizationTool {
+	return v.virtTool.WithAuditActor(metadata.AuditActorCRI, method)
+}
+
+// criStore returns the view of the metadata store that attributes
+// the changes to the specified CRI method in the audit log.
+func (v *VirtletRuntimeService) criStore(method string) metadata.Store {
+	return metadata.WithAuditActor(v.metadataStore, metadata.AuditActorCRI, method)
+}
+
+// SetVMsCordoned makes RunPodSandbox reject new pod sandboxes if
+// cordoned is true, or lets it accept them again otherwise. The
+// running VMs are not affected.
+func (v *VirtletRuntimeService) SetVMsCordoned(cordoned bool, reason string) {
+	v.cordonLock.Lock()
+	defer v.cordonLock.Unlock()
+	v.cordoned = cordoned
+	v.cordonReason = reason
+}
+
+// checkCordon returns an error if the node is cordoned for new VMs.
+func (v *VirtletRuntimeService) checkCordon() error {
+	v.cordonLock.Lock()
+	defer v.cordonLock.Unlock()
+	if !v.cordoned {
+		return nil
+	}
+	msg := "the node is cordoned for new VMs"
+	if v.cordonReason != "" {
+		msg += ": " + v.cordonReason
+	}
+	return status.Error(codes.Unavailable, msg)
+}
+
 // Version implements Version method of CRI.
 func (v *VirtletRuntimeService) Version(ctx context.Context, in *kubeapi.VersionRequest) (*kubeapi.VersionResponse, error) {
 	vRuntimeAPIVersion := runtimeAPIVersion
@@ -102,6 +150,8 @@ func (v *VirtletRuntimeService) Version(ctx context.Context, in *kubeapi.Version
 
 // RunPodSandbox implements RunPodSandbox method of CRI.
 func (v *VirtletRuntimeService) RunPodSandbox(ctx context.Context, in *kubeapi.RunPodSandboxRequest) (response *kubeapi.RunPodSandboxResponse, retErr error) {
+	tool := v.criTool("RunPodSandbox")
+	store := v.criStore("RunPodSandbox")
 	config := in.GetConfig()
 	if config == nil {
 		return nil, errors.New("no pod sandbox config passed to RunPodSandbox")
@@ -113,18 +163,31 @@ func (v *VirtletRuntimeService) RunPodSandbox(ctx context.Context, in *kubeapi.R
 	if err := validatePodSandboxConfig(config); err != nil {
 		return nil, err
 	}
+	// validate and normalize the config before
+	// doing anything else with the pod
+	sandboxConfig := CRIPodSandboxConfigToPodSandboxConfig(config)
+	if err := sandboxConfig.Normalize(); err != nil {
+		return nil, err
+	}
 	podID := config.Metadata.Uid
 	podNs := config.Metadata.Namespace
 
 	// Check if sandbox already exists, it may happen when virtlet restarts and kubelet "thinks" that sandbox disappered
-	sandbox := v.metadataStore.PodSandbox(podID)
+	sandbox := store.PodSandbox(podID)
 	sandboxInfo, err := sandbox.Retrieve()
+	var revision uint64
 	if err == nil && sandboxInfo != nil {
 		if sandboxInfo.State == types.PodSandboxState_SANDBOX_READY {
 			return &kubeapi.RunPodSandboxResponse{
 				PodSandboxId: podID,
 			}, nil
 		}
+		revision = sandboxInfo.Revision
+	}
+
+	if err := v.checkCordon(); err != nil {
+		glog.Warningf("Rejecting pod %s (%s): %v", podName, podID, err)
+		return nil, err
 	}
 
 	state := kubeapi.PodSandboxState_SANDBOX_READY
@@ -133,12 +196,9 @@ func (v *VirtletRuntimeService) RunPodSandbox(ctx context.Context, in *kubeapi.R
 		PodNs:   podNs,
 		PodName: podName,
 	}
-	// Mimic kubelet's method of handling nameservers.
-	// As of k8s 1.5.2, kubelet doesn't use any nameserver information from CNI.
-	// (TODO: recheck this for 1.6)
-	// CNI is used just to configure the network namespace and CNI DNS
-	// info is ignored. Instead of this, DnsConfig from PodSandboxConfig
-	// is used to configure container's resolv.conf.
+	// DnsConfig from PodSandboxConfig is used to configure the DNS
+	// settings of the VM. The DNS settings from the CNI result are
+	// only used for the fields that are left empty in DnsConfig.
 	if config.DnsConfig != nil {
 		pnd.DNS = &cnitypes.DNS{
 			Nameservers: config.DnsConfig.Servers,
@@ -147,6 +207,11 @@ func (v *VirtletRuntimeService) RunPodSandbox(ctx context.Context, in *kubeapi.R
 		}
 	}
 
+	if pnd.BootOptions, err = types.ParseNetBootOptions(config.Annotations); err != nil {
+		return nil, err
+	}
+	pnd.DetectGuestAddresses = types.ParseGuestAddressDetection(config.Annotations)
+
 	fdPayload := &tapmanager.GetFDPayload{Description: pnd}
 	csnBytes, err := v.fdManager.AddFDs(podID, fdPayload)
 	// The reason for defer here is that it is also necessary to ReleaseFDs if AddFDs fail
@@ -161,24 +226,36 @@ func (v *VirtletRuntimeService) RunPodSandbox(ctx context.Context, in *kubeapi.R
 		}
 	}()
 	if err != nil {
+		tool.RecordSandboxEvent(podID, types.SandboxEventNetworkSetupFailed, "", err.Error())
 		return nil, fmt.Errorf("Error adding pod %s (%s) to CNI network: %v", podName, podID, err)
 	}
+	tool.RecordSandboxEvent(podID, types.SandboxEventNetworkSetUp, "", "")
 
-	psi, err := metadata.NewPodSandboxInfo(
-		CRIPodSandboxConfigToPodSandboxConfig(config),
-		csnBytes, types.PodSandboxState(state), v.clock)
+	psi, err := metadata.NewPodSandboxInfo(sandboxConfig, csnBytes, types.PodSandboxState(state), v.clock)
 	if err != nil {
 		return nil, err
 	}
 
-	sandbox = v.metadataStore.PodSandbox(config.Metadata.Uid)
-	if err := sandbox.Save(
+	// the sandbox may have been changed while the pod network
+	// was being set up, in which case it must not be overwritten
+	sandbox = store.PodSandbox(config.Metadata.Uid)
+	if err := sandbox.Save(metadata.ExpectPodSandboxRevision(revision,
 		func(c *types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
 			return psi, nil
 		},
-	); err != nil {
+	)); err != nil {
 		return nil, err
 	}
+	tool.RecordSandboxEvent(podID, types.SandboxEventCreated, "", "")
+
+	if psi.ContainerSideNetwork != nil && len(psi.ContainerSideNetwork.SelfTestProblems) != 0 {
+		tool.EventRecorder().Eventf(&types.VMConfig{
+			PodSandboxID: podID,
+			PodName:      podName,
+			PodNamespace: podNs,
+		}, v1.EventTypeWarning, "NetworkSelfTestFailed", "Pod network self-test failed: %s",
+			strings.Join(psi.ContainerSideNetwork.SelfTestProblems, "; "))
+	}
 
 	return &kubeapi.RunPodSandboxResponse{
 		PodSandboxId: podID,
@@ -187,7 +264,9 @@ func (v *VirtletRuntimeService) RunPodSandbox(ctx context.Context, in *kubeapi.R
 
 // StopPodSandbox implements StopPodSandbox method of CRI.
 func (v *VirtletRuntimeService) StopPodSandbox(ctx context.Context, in *kubeapi.StopPodSandboxRequest) (*kubeapi.StopPodSandboxResponse, error) {
-	sandbox := v.metadataStore.PodSandbox(in.PodSandboxId)
+	tool := v.criTool("StopPodSandbox")
+	store := v.criStore("StopPodSandbox")
+	sandbox := store.PodSandbox(in.PodSandboxId)
 	switch sandboxInfo, err := sandbox.Retrieve(); {
 	case err != nil:
 		return nil, err
@@ -195,20 +274,22 @@ func (v *VirtletRuntimeService) StopPodSandbox(ctx context.Context, in *kubeapi.
 		return nil, fmt.Errorf("sandbox %q not found in Virtlet metadata store", in.PodSandboxId)
 	// check if the sandbox is already stopped
 	case sandboxInfo.State != types.PodSandboxState_SANDBOX_NOTREADY:
-		if err := sandbox.Save(
+		// the sandbox must not be removed or run again
+		// during the call
+		if err := sandbox.Save(metadata.ExpectPodSandboxRevision(sandboxInfo.Revision,
 			func(c *types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
-				// make sure the pod is not removed during the call
-				if c != nil {
-					c.State = types.PodSandboxState_SANDBOX_NOTREADY
-				}
+				c.State = types.PodSandboxState_SANDBOX_NOTREADY
 				return c, nil
 			},
-		); err != nil {
+		)); err != nil {
 			return nil, err
 		}
+		tool.RecordSandboxEvent(in.PodSandboxId, types.SandboxEventStopped, "", "")
 
 		if err := v.fdManager.ReleaseFDs(in.PodSandboxId); err != nil {
 			glog.Errorf("Error releasing tap fd for the pod %q: %v", in.PodSandboxId, err)
+		} else {
+			tool.RecordSandboxEvent(in.PodSandboxId, types.SandboxEventNetworkTornDown, "", "")
 		}
 	}
 
@@ -218,13 +299,8 @@ func (v *VirtletRuntimeService) StopPodSandbox(ctx context.Context, in *kubeapi.
 
 // RemovePodSandbox method implements RemovePodSandbox from CRI.
 func (v *VirtletRuntimeService) RemovePodSandbox(ctx context.Context, in *kubeapi.RemovePodSandboxRequest) (*kubeapi.RemovePodSandboxResponse, error) {
-	podSandboxID := in.PodSandboxId
-
-	if err := v.metadataStore.PodSandbox(podSandboxID).Save(
-		func(c *types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
-			return nil, nil
-		},
-	); err != nil {
+	tool := v.criTool("RemovePodSandbox")
+	if err := tool.RemovePodSandbox(in.PodSandboxId); err != nil {
 		return nil, err
 	}
 
@@ -234,9 +310,10 @@ func (v *VirtletRuntimeService) RemovePodSandbox(ctx context.Context, in *kubeap
 
 // PodSandboxStatus method implements PodSandboxStatus from CRI.
 func (v *VirtletRuntimeService) PodSandboxStatus(ctx context.Context, in *kubeapi.PodSandboxStatusRequest) (*kubeapi.PodSandboxStatusResponse, error) {
+	store := v.criStore("PodSandboxStatus")
 	podSandboxID := in.PodSandboxId
 
-	sandbox := v.metadataStore.PodSandbox(podSandboxID)
+	sandbox := store.PodSandbox(podSandboxID)
 	sandboxInfo, err := sandbox.Retrieve()
 	if err != nil {
 		return nil, err
@@ -252,23 +329,43 @@ func (v *VirtletRuntimeService) PodSandboxStatus(ctx context.Context, in *kubeap
 	}
 
 	ip := cni.GetPodIP(cniResult)
+	if types.ParseGuestAddressDetection(sandboxInfo.Config.Annotations) && sandboxInfo.State == types.PodSandboxState_SANDBOX_READY {
+		// until the VM uses its network, the address
+		// assigned by the CNI plugin is reported
+		guestIP, err := podGuestAddress(v.fdManager, podSandboxID)
+		switch {
+		case err != nil:
+			glog.Warningf("Error getting guest addresses for pod sandbox %q: %v", podSandboxID, err)
+		case guestIP != "":
+			ip = guestIP
+		}
+	}
 	if ip != "" {
 		status.Network = &kubeapi.PodSandboxNetworkStatus{Ip: ip}
 	}
 
 	response := &kubeapi.PodSandboxStatusResponse{Status: status}
+	if in.Verbose && sandboxInfo.State == types.PodSandboxState_SANDBOX_READY {
+		netStats, err := podNetworkStats(v.fdManager, podSandboxID)
+		if err != nil {
+			glog.Warningf("Error getting network stats for pod sandbox %q: %v", podSandboxID, err)
+		} else {
+			bs, err := json.Marshal(statsByNetwork(netStats))
+			if err != nil {
+				return nil, fmt.Errorf("error marshalling network stats: %v", err)
+			}
+			response.Info = map[string]string{"networkStats": string(bs)}
+		}
+	}
 	return response, nil
 }
 
 // ListPodSandbox method implements ListPodSandbox from CRI.
 func (v *VirtletRuntimeService) ListPodSandbox(ctx context.Context, in *kubeapi.ListPodSandboxRequest) (*kubeapi.ListPodSandboxResponse, error) {
+	store := v.criStore("ListPodSandbox")
 	filter := CRIPodSandboxFilterToPodSandboxFilter(in.GetFilter())
-	sandboxes, err := v.metadataStore.ListPodSandboxes(filter)
-	if err != nil {
-		return nil, err
-	}
 	var podSandboxList []*kubeapi.PodSandbox
-	for _, sandbox := range sandboxes {
+	if err := metadata.ForEachPodSandbox(store, filter, 0, func(sandbox metadata.PodSandboxMetadata) error {
 		sandboxInfo, err := sandbox.Retrieve()
 		if err != nil {
 			glog.Errorf("Error retrieving pod sandbox %q", sandbox.GetID())
@@ -276,6 +373,9 @@ func (v *VirtletRuntimeService) ListPodSandbox(ctx context.Context, in *kubeapi.
 		if sandboxInfo != nil {
 			podSandboxList = append(podSandboxList, PodSandboxInfoToCRIPodSandbox(sandboxInfo))
 		}
+		return nil
+	}); err != nil {
+		return nil, err
 	}
 	response := &kubeapi.ListPodSandboxResponse{Items: podSandboxList}
 	return response, nil
@@ -287,11 +387,13 @@ func (v *VirtletRuntimeService) ListPodSandbox(ctx context.Context, in *kubeapi.
 
 // CreateContainer method implements CreateContainer from CRI.
 func (v *VirtletRuntimeService) CreateContainer(ctx context.Context, in *kubeapi.CreateContainerRequest) (*kubeapi.CreateContainerResponse, error) {
+	tool := v.criTool("CreateContainer")
+	store := v.criStore("CreateContainer")
 	config := in.GetConfig()
 	podSandboxID := in.PodSandboxId
 	name := config.GetMetadata().Name
 
-	sandboxInfo, err := v.metadataStore.PodSandbox(podSandboxID).Retrieve()
+	sandboxInfo, err := store.PodSandbox(podSandboxID).Retrieve()
 	if err != nil {
 		return nil, err
 	}
@@ -303,7 +405,7 @@ func (v *VirtletRuntimeService) CreateContainer(ctx context.Context, in *kubeapi
 	// NOTE: there is no distinction between lack of key and other types of
 	// errors when accessing boltdb. This will be changed when we switch to
 	// storing whole marshaled sandbox metadata as json.
-	curContainers, err := v.metadataStore.ListPodContainers(podSandboxID)
+	curContainers, err := store.ListPodContainers(podSandboxID)
 	if err != nil {
 		glog.V(3).Infof("Error retrieving pod %q containers", podSandboxID)
 	} else {
@@ -311,7 +413,7 @@ func (v *VirtletRuntimeService) CreateContainer(ctx context.Context, in *kubeapi
 			// TODO: check container name; if it's the same, update the network config
 			glog.V(3).Infof("CreateContainer: there's already a container in the sandbox (id: %s)", container.GetID())
 			//err := v.updateContainer(sandboxInfo, container.GetID())
-			err := v.virtTool.UpdateContainerNetwork(container.GetID(), sandboxInfo.ContainerSideNetwork)
+			err := tool.UpdateContainerNetwork(container.GetID(), sandboxInfo.ContainerSideNetwork)
 			if err != nil {
 				return nil, err
 			}
@@ -325,11 +427,18 @@ func (v *VirtletRuntimeService) CreateContainer(ctx context.Context, in *kubeapi
 	if err != nil {
 		return nil, err
 	}
+	// the sandbox hostname is already normalized so it can
+	// be used as the VM hostname as is
+	vmConfig.Hostname = sandboxInfo.Config.Hostname
 	if sandboxInfo.ContainerSideNetwork == nil || sandboxInfo.ContainerSideNetwork.Result == nil {
 		fdKey = ""
 	}
 
-	uuid, err := v.virtTool.CreateContainer(vmConfig, fdKey)
+	if err := v.pullCDROMImages(ctx, types.ParseCDROMImages(vmConfig.PodAnnotations)); err != nil {
+		return nil, err
+	}
+
+	uuid, err := tool.CreateContainer(vmConfig, fdKey)
 	if err != nil {
 		glog.Errorf("Error creating container %s: %v", name, err)
 		return nil, err
@@ -339,16 +448,40 @@ func (v *VirtletRuntimeService) CreateContainer(ctx context.Context, in *kubeapi
 	return response, nil
 }
 
+// pullCDROMImages pulls the images to be attached to the VM as
+// CD-ROMs unless they're already present in the image store.
+func (v *VirtletRuntimeService) pullCDROMImages(ctx context.Context, images []string) error {
+	if v.imageService == nil {
+		return nil
+	}
+	for _, name := range images {
+		spec := &kubeapi.ImageSpec{Image: name}
+		status, err := v.imageService.ImageStatus(ctx, &kubeapi.ImageStatusRequest{Image: spec})
+		if err != nil {
+			return fmt.Errorf("error checking status of CD-ROM image %q: %v", name, err)
+		}
+		if status.Image != nil {
+			continue
+		}
+		glog.V(2).Infof("Pulling CD-ROM image %q", name)
+		if _, err := v.imageService.PullImage(ctx, &kubeapi.PullImageRequest{Image: spec}); err != nil {
+			return fmt.Errorf("error pulling CD-ROM image %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
 // StartContainer method implements StartContainer from CRI.
 func (v *VirtletRuntimeService) StartContainer(ctx context.Context, in *kubeapi.StartContainerRequest) (*kubeapi.StartContainerResponse, error) {
-	info, err := v.virtTool.ContainerInfo(in.ContainerId)
+	tool := v.criTool("StartContainer")
+	info, err := tool.ContainerInfo(in.ContainerId)
 	if err == nil && info != nil && info.State == types.ContainerState_CONTAINER_RUNNING {
 		glog.V(2).Infof("StartContainer: Container %s is already running", in.ContainerId)
 		response := &kubeapi.StartContainerResponse{}
 		return response, nil
 	}
 
-	if err := v.virtTool.StartContainer(in.ContainerId); err != nil {
+	if err := tool.StartContainer(in.ContainerId); err != nil {
 		return nil, err
 	}
 	response := &kubeapi.StartContainerResponse{}
@@ -357,7 +490,8 @@ func (v *VirtletRuntimeService) StartContainer(ctx context.Context, in *kubeapi.
 
 // StopContainer method implements StopContainer from CRI.
 func (v *VirtletRuntimeService) StopContainer(ctx context.Context, in *kubeapi.StopContainerRequest) (*kubeapi.StopContainerResponse, error) {
-	if err := v.virtTool.StopContainer(in.ContainerId, time.Duration(in.Timeout)*time.Second); err != nil {
+	tool := v.criTool("StopContainer")
+	if err := tool.StopContainer(in.ContainerId, time.Duration(in.Timeout)*time.Second); err != nil {
 		return nil, err
 	}
 	response := &kubeapi.StopContainerResponse{}
@@ -366,7 +500,8 @@ func (v *VirtletRuntimeService) StopContainer(ctx context.Context, in *kubeapi.S
 
 // RemoveContainer method implements RemoveContainer from CRI.
 func (v *VirtletRuntimeService) RemoveContainer(ctx context.Context, in *kubeapi.RemoveContainerRequest) (*kubeapi.RemoveContainerResponse, error) {
-	if err := v.virtTool.RemoveContainer(in.ContainerId); err != nil {
+	tool := v.criTool("RemoveContainer")
+	if err := tool.RemoveContainer(in.ContainerId); err != nil {
 		return nil, err
 	}
 
@@ -380,8 +515,9 @@ func (v *VirtletRuntimeService) RemoveContainer(ctx context.Context, in *kubeapi
 
 // ListContainers method implements ListContainers from CRI.
 func (v *VirtletRuntimeService) ListContainers(ctx context.Context, in *kubeapi.ListContainersRequest) (*kubeapi.ListContainersResponse, error) {
+	tool := v.criTool("ListContainers")
 	filter := CRIContainerFilterToContainerFilter(in.GetFilter())
-	containers, err := v.virtTool.ListContainers(filter)
+	containers, err := tool.ListContainers(filter)
 	if err != nil {
 		return nil, err
 	}
@@ -395,12 +531,57 @@ func (v *VirtletRuntimeService) ListContainers(ctx context.Context, in *kubeapi.
 
 // ContainerStatus method implements ContainerStatus from CRI.
 func (v *VirtletRuntimeService) ContainerStatus(ctx context.Context, in *kubeapi.ContainerStatusRequest) (*kubeapi.ContainerStatusResponse, error) {
-	info, err := v.virtTool.ContainerInfo(in.ContainerId)
+	tool := v.criTool("ContainerStatus")
+	info, err := tool.ContainerInfo(in.ContainerId)
 	if err != nil {
 		return nil, err
 	}
 
 	response := &kubeapi.ContainerStatusResponse{Status: ContainerInfoToCRIContainerStatus(info)}
+	if !in.Verbose {
+		return response, nil
+	}
+	response.Info = make(map[string]string)
+	if info.Config.ParsedAnnotations != nil {
+		bs, err := json.Marshal(info.Config.Overhead())
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling VM overhead: %v", err)
+		}
+		response.Info["overhead"] = string(bs)
+	}
+	if info.State == types.ContainerState_CONTAINER_RUNNING {
+		diskStats, err := tool.DiskStats(in.ContainerId)
+		if err != nil {
+			glog.Warningf("Error getting disk stats for container %q: %v", in.ContainerId, err)
+		} else {
+			bs, err := json.Marshal(diskStats)
+			if err != nil {
+				return nil, fmt.Errorf("error marshalling disk stats: %v", err)
+			}
+			response.Info["diskStats"] = string(bs)
+		}
+		memStats, err := tool.MemoryStats(in.ContainerId)
+		if err != nil {
+			glog.Warningf("Error getting memory stats for container %q: %v", in.ContainerId, err)
+		} else {
+			bs, err := json.Marshal(memStats)
+			if err != nil {
+				return nil, fmt.Errorf("error marshalling memory stats: %v", err)
+			}
+			response.Info["memoryStats"] = string(bs)
+		}
+		emulatorInfo, err := tool.EmulatorInfo(in.ContainerId)
+		switch {
+		case err != nil:
+			glog.Warningf("Error getting emulator info for container %q: %v", in.ContainerId, err)
+		case emulatorInfo != nil:
+			bs, err := json.Marshal(emulatorInfo)
+			if err != nil {
+				return nil, fmt.Errorf("error marshalling emulator info: %v", err)
+			}
+			response.Info["emulator"] = string(bs)
+		}
+	}
 	return response, nil
 }
 
@@ -440,12 +621,13 @@ func (v *VirtletRuntimeService) UpdateRuntimeConfig(context.Context, *kubeapi.Up
 // for container then looks for running emulator and tries to adjust its
 // current settings through cgroups
 func (v *VirtletRuntimeService) UpdateContainerResources(ctx context.Context, req *kubeapi.UpdateContainerResourcesRequest) (*kubeapi.UpdateContainerResourcesResponse, error) {
-	setByCgroup, err := v.virtTool.UpdateCpusetsForEmulatorProcess(req.GetContainerId(), req.GetLinux().CpusetCpus)
+	tool := v.criTool("UpdateContainerResources")
+	setByCgroup, err := tool.UpdateCpusetsForEmulatorProcess(req.GetContainerId(), req.GetLinux().CpusetCpus)
 	if err != nil {
 		return nil, err
 	}
 	if !setByCgroup {
-		if err = v.virtTool.UpdateCpusetsInContainerDefinition(req.GetContainerId(), req.GetLinux().CpusetCpus); err != nil {
+		if err = tool.UpdateCpusetsInContainerDefinition(req.GetContainerId(), req.GetLinux().CpusetCpus); err != nil {
 			return nil, err
 		}
 	}
@@ -475,15 +657,16 @@ func (v *VirtletRuntimeService) Status(context.Context, *kubeapi.StatusRequest)
 
 // ContainerStats returns cpu/memory/disk usage for particular container id
 func (v *VirtletRuntimeService) ContainerStats(ctx context.Context, in *kubeapi.ContainerStatsRequest) (*kubeapi.ContainerStatsResponse, error) {
-	info, err := v.virtTool.ContainerInfo(in.ContainerId)
+	tool := v.criTool("ContainerStats")
+	info, err := tool.ContainerInfo(in.ContainerId)
 	if err != nil {
 		return nil, err
 	}
-	vs, err := v.virtTool.VMStats(info.Id, info.Name)
+	vs, err := tool.VMStats(info.Id, info.Name)
 	if err != nil {
 		return nil, err
 	}
-	fsstats, err := v.virtTool.ImageManager().FilesystemStats()
+	fsstats, err := tool.ImageManager().FilesystemStats()
 	if err != nil {
 		return nil, err
 	}
@@ -495,12 +678,13 @@ func (v *VirtletRuntimeService) ContainerStats(ctx context.Context, in *kubeapi.
 // ListContainerStats returns stats (same as ContainerStats) for containers
 // selected by filter
 func (v *VirtletRuntimeService) ListContainerStats(ctx context.Context, in *kubeapi.ListContainerStatsRequest) (*kubeapi.ListContainerStatsResponse, error) {
+	tool := v.criTool("ListContainerStats")
 	filter := CRIContainerStatsFilterToVMStatsFilter(in.GetFilter())
-	vmstatsList, err := v.virtTool.ListVMStats(filter)
+	vmstatsList, err := tool.ListVMStats(filter)
 	if err != nil {
 		return nil, err
 	}
-	fsstats, err := v.virtTool.ImageManager().FilesystemStats()
+	fsstats, err := tool.ImageManager().FilesystemStats()
 	if err != nil {
 		return nil, err
 	}
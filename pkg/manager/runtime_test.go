@@ -34,9 +34,13 @@ import (
 	cnicurrent "github.com/containernetworking/cni/pkg/types/current"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/jonboulle/clockwork"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	kubeapi "k8s.io/kubernetes/pkg/kubelet/apis/cri/runtime/v1alpha2"
 
 	"github.com/Mirantis/virtlet/pkg/cni"
+	"github.com/Mirantis/virtlet/pkg/dhcp"
 	"github.com/Mirantis/virtlet/pkg/flexvolume"
 	"github.com/Mirantis/virtlet/pkg/fs"
 	fakefs "github.com/Mirantis/virtlet/pkg/fs/fake"
@@ -44,6 +48,7 @@ import (
 	fakeimage "github.com/Mirantis/virtlet/pkg/image/fake"
 	"github.com/Mirantis/virtlet/pkg/libvirttools"
 	"github.com/Mirantis/virtlet/pkg/metadata"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
 	"github.com/Mirantis/virtlet/pkg/network"
 	"github.com/Mirantis/virtlet/pkg/tapmanager"
 	"github.com/Mirantis/virtlet/pkg/utils"
@@ -62,6 +67,8 @@ type fakeFDManager struct {
 	rec         testutils.Recorder
 	items       map[string]bool
 	lastIpOctet byte
+	traces      []tapmanager.NetworkTrace
+	guestIPs    []string
 }
 
 var _ tapmanager.FDManager = &fakeFDManager{}
@@ -136,6 +143,12 @@ func (m *fakeFDManager) AddFDs(key string, data interface{}) ([]byte, error) {
 			},
 		},
 	}
+	if strings.Contains(key, "network-self-test-fail") {
+		csn.SelfTestProblems = []string{
+			"gateway 10.1.90.1 doesn't respond: no reply to ICMP echo requests",
+			"DNS server 10.96.0.10 is not reachable: no reply to DNS queries",
+		}
+	}
 
 	respData, err := json.Marshal(csn)
 	if err != nil {
@@ -163,6 +176,73 @@ func (m *fakeFDManager) Recover(key string, data interface{}) error {
 	return nil
 }
 
+func (m *fakeFDManager) GetStats(key string) ([]byte, error) {
+	m.rec.Rec("GetStats", key)
+	if !m.items[key] {
+		return nil, fmt.Errorf("key not found: %q", key)
+	}
+	return json.Marshal([]tapmanager.InterfaceStats{
+		{
+			Name:      "eth0",
+			Network:   "calico",
+			Mac:       "42:a4:a6:22:80:2e",
+			RxBytes:   4242,
+			RxPackets: 42,
+			TxBytes:   2121,
+			TxPackets: 21,
+		},
+	})
+}
+
+func (m *fakeFDManager) GetGuestAddresses(key string) ([]byte, error) {
+	m.rec.Rec("GetGuestAddresses", key)
+	if !m.items[key] {
+		return nil, fmt.Errorf("key not found: %q", key)
+	}
+	addrs := []tapmanager.GuestAddresses{}
+	if m.guestIPs != nil {
+		addrs = append(addrs, tapmanager.GuestAddresses{
+			Name: "eth0",
+			Mac:  "42:a4:a6:22:80:2e",
+			IPs:  m.guestIPs,
+		})
+	}
+	return json.Marshal(addrs)
+}
+
+func (m *fakeFDManager) GetNetworkInfo(key string) ([]byte, error) {
+	m.rec.Rec("GetNetworkInfo", key)
+	if !m.items[key] {
+		return nil, fmt.Errorf("key not found: %q", key)
+	}
+	return json.Marshal(tapmanager.PodNetworkInfo{
+		Leases: []dhcp.Lease{
+			{
+				HardwareAddr: "42:a4:a6:22:80:2e",
+				IP:           "10.1.90.5",
+				State:        dhcp.LeaseStateAcked,
+				Discovers:    1,
+				Requests:     1,
+				Updated:      time.Unix(0, podTimestap).UTC(),
+			},
+		},
+		Neighbors: []tapmanager.NeighborInfo{
+			{Link: "br0", IP: "10.1.90.5", Mac: "42:a4:a6:22:80:2e", State: "REACHABLE"},
+		},
+		Routes: []tapmanager.RouteInfo{},
+	})
+}
+
+func (m *fakeFDManager) GetTraces(key string) ([]byte, error) {
+	traces := []tapmanager.NetworkTrace{}
+	for _, trace := range m.traces {
+		if key == "" || trace.PodID == key {
+			traces = append(traces, trace)
+		}
+	}
+	return json.Marshal(traces)
+}
+
 type fakeStreamServer struct {
 	rec testutils.Recorder
 }
@@ -211,6 +291,8 @@ type virtletCRITester struct {
 	t              *testing.T
 	rec            *testutils.TopLevelRecorder
 	handler        *criHandler
+	metadataStore  metadata.Store
+	fdManager      *fakeFDManager
 	tmpDir         string
 	kubeletRootDir string
 }
@@ -225,10 +307,7 @@ func makeVirtletCRITester(t *testing.T) *virtletCRITester {
 	libvirttools.SetConfigIsoDir(filepath.Join(tmpDir, "__config__"))
 	fdManager := newFakeFDManager(rec.Child("fdManager"))
 	imageStore := fakeimage.NewFakeStore(rec.Child("imageStore"))
-	metadataStore, err := metadata.NewFakeStore()
-	if err != nil {
-		t.Fatalf("Failed to create fake bolt client: %v", err)
-	}
+	metadataStore := metadata.NewMemStore()
 	domainConn := fakevirt.NewFakeDomainConnection(rec.Child("domain conn"))
 	storageConn := fakevirt.NewFakeStorageConnection(rec.Child("storage"))
 	clock := clockwork.NewFakeClockAt(time.Unix(0, podTimestap))
@@ -246,14 +325,17 @@ func makeVirtletCRITester(t *testing.T) *virtletCRITester {
 		fakefs.NewFakeFileSystem(t, rec, "", nil), commander)
 	virtTool.SetClock(clock)
 	streamServer := newFakeStreamServer(rec.Child("streamServer"))
+	imageService := NewVirtletImageService(imageStore, translateImageName, metadataStore, clock)
 	criHandler := &criHandler{
-		VirtletRuntimeService: NewVirtletRuntimeService(virtTool, metadataStore, fdManager, streamServer, imageStore, clock),
-		VirtletImageService:   NewVirtletImageService(imageStore, translateImageName, clock),
+		VirtletRuntimeService: NewVirtletRuntimeService(virtTool, metadataStore, fdManager, streamServer, imageStore, imageService, clock),
+		VirtletImageService:   imageService,
 	}
 	return &virtletCRITester{
 		t:              t,
 		rec:            rec,
 		handler:        criHandler,
+		metadataStore:  metadataStore,
+		fdManager:      fdManager,
 		tmpDir:         tmpDir,
 		kubeletRootDir: kubeletRootDir,
 	}
@@ -531,6 +613,95 @@ func TestRunPodSandboxWithFailingCNI(t *testing.T) {
 	tst.verify()
 }
 
+func TestRunPodSandboxWithMalformedConfig(t *testing.T) {
+	tst := makeVirtletCRITester(t)
+	defer tst.teardown()
+
+	sandboxes := criapi.GetSandboxes(1)
+	for n := 0; n <= types.MaxSandboxLabels; n++ {
+		sandboxes[0].Labels[fmt.Sprintf("label%d", n)] = "value"
+	}
+	_, err := tst.handler.RunPodSandbox(context.Background(), &kubeapi.RunPodSandboxRequest{Config: sandboxes[0]})
+	switch {
+	case err == nil:
+		t.Fatalf("RunPodSandbox() didn't fail for a sandbox with too many labels")
+	case !types.IsSandboxConfigError(err):
+		t.Errorf("RunPodSandbox() returned unexpected error: %v", err)
+	}
+
+	resp, err := tst.handler.ListPodSandbox(context.Background(), &kubeapi.ListPodSandboxRequest{})
+	if err != nil {
+		t.Fatalf("ListPodSandbox(): %v", err)
+	}
+	if len(resp.Items) != 0 {
+		t.Errorf("the malformed sandbox was stored: %#v", resp.Items)
+	}
+}
+
+func TestRunPodSandboxOnCordonedNode(t *testing.T) {
+	tst := makeVirtletCRITester(t)
+	defer tst.teardown()
+
+	sandboxes := criapi.GetSandboxes(2)
+	runPodSandbox := func(n int) error {
+		_, err := tst.handler.RunPodSandbox(context.Background(), &kubeapi.RunPodSandboxRequest{Config: sandboxes[n]})
+		return err
+	}
+	if err := runPodSandbox(0); err != nil {
+		t.Fatalf("RunPodSandbox(): %v", err)
+	}
+
+	tst.handler.SetVMsCordoned(true, "storage maintenance")
+	switch err := runPodSandbox(1); {
+	case err == nil:
+		t.Errorf("RunPodSandbox() didn't fail on a cordoned node")
+	case status.Code(err) != codes.Unavailable || !strings.Contains(err.Error(), "storage maintenance"):
+		t.Errorf("RunPodSandbox() returned unexpected error: %v", err)
+	}
+	// the sandboxes that are already running are not affected
+	if err := runPodSandbox(0); err != nil {
+		t.Errorf("RunPodSandbox() failed for an existing sandbox on a cordoned node: %v", err)
+	}
+
+	tst.handler.SetVMsCordoned(false, "")
+	if err := runPodSandbox(1); err != nil {
+		t.Errorf("RunPodSandbox() failed after uncordoning the node: %v", err)
+	}
+}
+
+type fakeEventRecorder struct {
+	events []string
+}
+
+func (r *fakeEventRecorder) Eventf(config *types.VMConfig, eventType, reason, messageFmt string, args ...interface{}) {
+	r.events = append(r.events, fmt.Sprintf("%s/%s %s %s: %s", config.PodNamespace, config.PodName, eventType, reason, fmt.Sprintf(messageFmt, args...)))
+}
+
+func TestNetworkSelfTestEvents(t *testing.T) {
+	tst := makeVirtletCRITester(t)
+	defer tst.teardown()
+	recorder := &fakeEventRecorder{}
+	tst.handler.VirtletRuntimeService.virtTool.SetEventRecorder(recorder)
+
+	sandboxes := criapi.GetSandboxes(2)
+	sandboxes[1].Metadata.Uid = "network-self-test-fail"
+	for _, sandbox := range sandboxes {
+		if _, err := tst.handler.RunPodSandbox(context.Background(), &kubeapi.RunPodSandboxRequest{Config: sandbox}); err != nil {
+			t.Fatalf("RunPodSandbox(): %v", err)
+		}
+	}
+
+	expectedEvents := []string{
+		fmt.Sprintf("%s/%s Warning NetworkSelfTestFailed: Pod network self-test failed: "+
+			"gateway 10.1.90.1 doesn't respond: no reply to ICMP echo requests; "+
+			"DNS server 10.96.0.10 is not reachable: no reply to DNS queries",
+			sandboxes[1].Metadata.Namespace, sandboxes[1].Metadata.Name),
+	}
+	if !reflect.DeepEqual(recorder.events, expectedEvents) {
+		t.Errorf("bad events:\n%#v\ninstead of\n%#v", recorder.events, expectedEvents)
+	}
+}
+
 func TestCRIMounts(t *testing.T) {
 	tst := makeVirtletCRITester(t)
 	defer tst.teardown()
@@ -679,6 +850,189 @@ func TestContainerListStats(t *testing.T) {
 	tst.verify()
 }
 
+func TestPodSandboxNetworkStats(t *testing.T) {
+	tst := makeVirtletCRITester(t)
+	defer tst.teardown()
+
+	sandboxes := criapi.GetSandboxes(1)
+	tst.runPodSandbox(sandboxes[0])
+
+	resp, err := tst.handler.PodSandboxStatus(context.Background(), &kubeapi.PodSandboxStatusRequest{
+		PodSandboxId: sandboxes[0].Metadata.Uid,
+		Verbose:      true,
+	})
+	if err != nil {
+		t.Fatalf("PodSandboxStatus(): %v", err)
+	}
+	expectedStats := `{"calico":[{"name":"eth0","network":"calico","mac":"42:a4:a6:22:80:2e","rxBytes":4242,"rxPackets":42,"rxErrors":0,"rxDropped":0,"txBytes":2121,"txPackets":21,"txErrors":0,"txDropped":0}]}`
+	if resp.Info["networkStats"] != expectedStats {
+		t.Errorf("bad network stats: %q instead of %q", resp.Info["networkStats"], expectedStats)
+	}
+}
+
+func TestNetworkStatsCollector(t *testing.T) {
+	tst := makeVirtletCRITester(t)
+	defer tst.teardown()
+
+	sandboxes := criapi.GetSandboxes(1)
+	tst.runPodSandbox(sandboxes[0])
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(newNetworkStatsCollector(tst.metadataStore, tst.fdManager)); err != nil {
+		t.Fatalf("Register(): %v", err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather(): %v", err)
+	}
+
+	values := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["namespace"] != sandboxes[0].Metadata.Namespace ||
+				labels["pod"] != sandboxes[0].Metadata.Name ||
+				labels["network"] != "calico" ||
+				labels["interface"] != "eth0" {
+				t.Errorf("bad labels for %s: %#v", mf.GetName(), labels)
+			}
+			values[mf.GetName()] = m.GetCounter().GetValue()
+		}
+	}
+	expectedValues := map[string]float64{
+		"virtlet_vm_network_receive_bytes_total":    4242,
+		"virtlet_vm_network_receive_packets_total":  42,
+		"virtlet_vm_network_receive_errors_total":   0,
+		"virtlet_vm_network_receive_dropped_total":  0,
+		"virtlet_vm_network_transmit_bytes_total":   2121,
+		"virtlet_vm_network_transmit_packets_total": 21,
+		"virtlet_vm_network_transmit_errors_total":  0,
+		"virtlet_vm_network_transmit_dropped_total": 0,
+	}
+	if !reflect.DeepEqual(values, expectedValues) {
+		t.Errorf("bad network metrics: %#v instead of %#v", values, expectedValues)
+	}
+}
+
+func TestPodSandboxGuestAddress(t *testing.T) {
+	tst := makeVirtletCRITester(t)
+	defer tst.teardown()
+
+	sandboxes := criapi.GetSandboxes(1)
+	sandboxes[0].Annotations["VirtletGuestAddressDetection"] = "true"
+	tst.runPodSandbox(sandboxes[0])
+
+	for _, tc := range []struct {
+		guestIPs   []string
+		expectedIP string
+	}{
+		// the CNI address is used until the guest address is detected
+		{nil, "10.1.90.5"},
+		{[]string{"fd00::5"}, "fd00::5"},
+		{[]string{"fd00::5", "10.1.90.42"}, "10.1.90.42"},
+	} {
+		tst.fdManager.guestIPs = tc.guestIPs
+		resp, err := tst.handler.PodSandboxStatus(context.Background(), &kubeapi.PodSandboxStatusRequest{
+			PodSandboxId: sandboxes[0].Metadata.Uid,
+		})
+		if err != nil {
+			t.Fatalf("PodSandboxStatus(): %v", err)
+		}
+		if resp.Status.Network == nil || resp.Status.Network.Ip != tc.expectedIP {
+			t.Errorf("bad pod network status for guest IPs %v: %#v (expected IP %q)", tc.guestIPs, resp.Status.Network, tc.expectedIP)
+		}
+	}
+}
+
+func TestNetworkTraceDiagSource(t *testing.T) {
+	fdManager := newFakeFDManager(testutils.NewToplevelRecorder())
+	fdManager.traces = []tapmanager.NetworkTrace{
+		{
+			PodID:     "69eec606-0493-5825-73a4-c5e0c0236155",
+			PodNs:     "default",
+			PodName:   "foo",
+			Operation: "setup",
+			Steps: []tapmanager.NetworkTraceStep{
+				{Name: "create-netns", Duration: "1ms"},
+				{Name: "cni-add", Duration: "2s", Error: "cni plugin failed"},
+			},
+			Error: "error adding pod foo to CNI network: cni plugin failed",
+		},
+		{
+			PodID:     "4b8a7e9c-5a1b-4f4c-8e2a-7f4c0d6e2b11",
+			PodNs:     "kube-system",
+			PodName:   "bar",
+			Operation: "setup",
+			Steps: []tapmanager.NetworkTraceStep{
+				{Name: "create-netns", Duration: "1ms"},
+			},
+		},
+		{
+			PodID:     "69eec606-0493-5825-73a4-c5e0c0236155",
+			PodNs:     "default",
+			PodName:   "foo",
+			Operation: "teardown",
+			Steps: []tapmanager.NetworkTraceStep{
+				{Name: "cni-del", Duration: "3ms"},
+			},
+		},
+	}
+
+	dr, err := NewNetworkTraceDiagSource(fdManager).DiagnosticInfo()
+	if err != nil {
+		t.Fatalf("DiagnosticInfo(): %v", err)
+	}
+	if len(dr.Children) != 2 {
+		t.Fatalf("expected 2 children, got %d", len(dr.Children))
+	}
+	fooResult, found := dr.Children["default-foo-69eec606-0493-5825-73a4-c5e0c0236155"]
+	if !found {
+		t.Fatalf("traces for pod foo not found")
+	}
+	var fooTraces []tapmanager.NetworkTrace
+	if err := json.Unmarshal([]byte(fooResult.Data), &fooTraces); err != nil {
+		t.Fatalf("error unmarshalling the traces: %v", err)
+	}
+	expectedTraces := []tapmanager.NetworkTrace{fdManager.traces[0], fdManager.traces[2]}
+	if !reflect.DeepEqual(fooTraces, expectedTraces) {
+		t.Errorf("bad traces for pod foo:\n%s\n-- instead of --\n%s", spew.Sdump(fooTraces), spew.Sdump(expectedTraces))
+	}
+	if _, found := dr.Children["kube-system-bar-4b8a7e9c-5a1b-4f4c-8e2a-7f4c0d6e2b11"]; !found {
+		t.Errorf("traces for pod bar not found")
+	}
+}
+
+func TestNetworkInfoDiagSource(t *testing.T) {
+	tst := makeVirtletCRITester(t)
+	defer tst.teardown()
+
+	sandboxes := criapi.GetSandboxes(1)
+	tst.runPodSandbox(sandboxes[0])
+
+	dr, err := NewNetworkInfoDiagSource(tst.handler.VirtletRuntimeService.metadataStore, tst.fdManager).DiagnosticInfo()
+	if err != nil {
+		t.Fatalf("DiagnosticInfo(): %v", err)
+	}
+	fileName := sandboxes[0].Metadata.Namespace + "-" + sandboxes[0].Metadata.Name
+	result, found := dr.Children[fileName]
+	if !found || len(dr.Children) != 1 {
+		t.Fatalf("network info for the pod not found: %#v", dr.Children)
+	}
+	var info tapmanager.PodNetworkInfo
+	if err := json.Unmarshal([]byte(result.Data), &info); err != nil {
+		t.Fatalf("error unmarshalling network info: %v", err)
+	}
+	if len(info.Leases) != 1 || info.Leases[0].State != dhcp.LeaseStateAcked || info.Leases[0].IP != "10.1.90.5" {
+		t.Errorf("bad leases: %#v", info.Leases)
+	}
+	if len(info.Neighbors) != 1 || info.Neighbors[0].Mac != "42:a4:a6:22:80:2e" {
+		t.Errorf("bad neighbors: %#v", info.Neighbors)
+	}
+}
+
 func TestCRIAttachPortForward(t *testing.T) {
 	tst := makeVirtletCRITester(t)
 	tst.rec.AddFilter("Attach")
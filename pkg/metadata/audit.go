@@ -0,0 +1,428 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/jonboulle/clockwork"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const (
+	// KindImagePullJob denotes image pull job records. It's only
+	// used in the audit records as the watchers don't receive the
+	// events for these records
+	KindImagePullJob ObjectKind = "imagePullJob"
+
+	// auditLogBackups is the number of rotated copies of the
+	// audit log that are kept
+	auditLogBackups = 5
+)
+
+// The following are the actors recorded in the audit log. They
+// denote the components that have made the changes.
+const (
+	// AuditActorCRI denotes the CRI calls made by kubelet
+	AuditActorCRI = "cri"
+	// AuditActorVirtletctl denotes the requests made via the
+	// admin API, which is used by virtletctl
+	AuditActorVirtletctl = "virtletctl"
+	// AuditActorLocalAPI denotes the requests made via the
+	// node-local REST API
+	AuditActorLocalAPI = "local-api"
+	// AuditActorGC denotes the garbage collection of the
+	// domains and the metadata records
+	AuditActorGC = "gc"
+	// AuditActorReconcile denotes the reconciliation of the
+	// metadata with libvirt domains upon Virtlet startup
+	AuditActorReconcile = "reconcile"
+	// AuditActorVirtlet denotes any other changes made by Virtlet
+	AuditActorVirtlet = "virtlet"
+)
+
+// AuditRecord describes a single change made in the metadata store.
+type AuditRecord struct {
+	// Time is the time of the change (unix nanoseconds)
+	Time int64 `json:"time"`
+	// Actor is one of AuditActor* values
+	Actor string `json:"actor"`
+	// Method is the CRI method, if the change was made by a CRI call
+	Method string `json:"method,omitempty"`
+	// Type is the type of the change
+	Type EventType `json:"type"`
+	// Kind is the kind of the object that has changed
+	Kind ObjectKind `json:"kind"`
+	// ID is the id of the pod sandbox or the container, or the
+	// image name for the image pull job records
+	ID string `json:"id"`
+	// PodNamespace is the namespace of the pod, if it's known
+	PodNamespace string `json:"podNamespace,omitempty"`
+	// PodName is the name of the pod, if it's known
+	PodName string `json:"podName,omitempty"`
+}
+
+// auditOrigin describes the actor that has made a change.
+type auditOrigin struct {
+	actor  string
+	method string
+}
+
+// defaultAuditOrigin is used for the changes made via the stores
+// that aren't wrapped by WithAuditActor.
+var defaultAuditOrigin = &auditOrigin{actor: AuditActorVirtlet}
+
+// auditedBackend is implemented by the stores that can attribute the
+// changes to the actors specified by their callers. nil origin
+// denotes defaultAuditOrigin.
+type auditedBackend interface {
+	Store
+
+	// podSandboxAs works like PodSandbox, but the changes made via
+	// the returned object are attributed to origin
+	podSandboxAs(origin *auditOrigin, podID string) PodSandboxMetadata
+
+	// containerAs works like Container, but the changes made via
+	// the returned object are attributed to origin
+	containerAs(origin *auditOrigin, containerID string) ContainerMetadata
+
+	// updateAs works like Update, but the changes made via the Tx
+	// are attributed to origin
+	updateAs(origin *auditOrigin, fn func(tx Tx) error) error
+
+	// saveImagePullJobAs works like SaveImagePullJob, but the change
+	// is attributed to origin
+	saveImagePullJobAs(origin *auditOrigin, imageName string, updater func(*types.ImagePullJob) (*types.ImagePullJob, error)) error
+}
+
+// auditedStore is a view of a store that attributes the changes made
+// through it to a specific actor.
+type auditedStore struct {
+	auditedBackend
+	origin *auditOrigin
+}
+
+var _ Store = &auditedStore{}
+
+// WithAuditActor returns a view of the store that records the changes
+// made through it in the audit log as made by the specified actor,
+// which is one of AuditActor* values. method is the CRI method for
+// AuditActorCRI and is empty otherwise. The changes made directly via
+// the store are attributed to AuditActorVirtlet.
+func WithAuditActor(store Store, actor, method string) Store {
+	if s, ok := store.(*auditedStore); ok {
+		store = s.auditedBackend
+	}
+	backend, ok := store.(auditedBackend)
+	if !ok {
+		return store
+	}
+	return &auditedStore{
+		auditedBackend: backend,
+		origin:         &auditOrigin{actor: actor, method: method},
+	}
+}
+
+// PodSandbox implements PodSandbox method of SandboxStore interface
+func (s *auditedStore) PodSandbox(podID string) PodSandboxMetadata {
+	return s.podSandboxAs(s.origin, podID)
+}
+
+func (s *auditedStore) wrapPodSandboxes(sandboxes []PodSandboxMetadata) []PodSandboxMetadata {
+	for i, sandbox := range sandboxes {
+		sandboxes[i] = s.PodSandbox(sandbox.GetID())
+	}
+	return sandboxes
+}
+
+// ListPodSandboxes implements ListPodSandboxes method of SandboxStore interface
+func (s *auditedStore) ListPodSandboxes(filter *types.PodSandboxFilter) ([]PodSandboxMetadata, error) {
+	sandboxes, err := s.auditedBackend.ListPodSandboxes(filter)
+	return s.wrapPodSandboxes(sandboxes), err
+}
+
+// ListPodSandboxesPage implements ListPodSandboxesPage method of SandboxStore interface
+func (s *auditedStore) ListPodSandboxesPage(filter *types.PodSandboxFilter, limit int, continueToken string) ([]PodSandboxMetadata, string, error) {
+	sandboxes, token, err := s.auditedBackend.ListPodSandboxesPage(filter, limit, continueToken)
+	return s.wrapPodSandboxes(sandboxes), token, err
+}
+
+// Container implements Container method of ContainerStore interface
+func (s *auditedStore) Container(containerID string) ContainerMetadata {
+	return s.containerAs(s.origin, containerID)
+}
+
+func (s *auditedStore) wrapContainers(containers []ContainerMetadata) []ContainerMetadata {
+	for i, container := range containers {
+		containers[i] = s.Container(container.GetID())
+	}
+	return containers
+}
+
+// ListPodContainers implements ListPodContainers method of ContainerStore interface
+func (s *auditedStore) ListPodContainers(podID string) ([]ContainerMetadata, error) {
+	containers, err := s.auditedBackend.ListPodContainers(podID)
+	return s.wrapContainers(containers), err
+}
+
+// ListContainersPage implements ListContainersPage method of ContainerStore interface
+func (s *auditedStore) ListContainersPage(limit int, continueToken string) ([]ContainerMetadata, string, error) {
+	containers, token, err := s.auditedBackend.ListContainersPage(limit, continueToken)
+	return s.wrapContainers(containers), token, err
+}
+
+// Update implements Update method of TransactionStore interface
+func (s *auditedStore) Update(fn func(tx Tx) error) error {
+	return s.updateAs(s.origin, fn)
+}
+
+// SaveImagePullJob implements SaveImagePullJob method of ImagePullStore interface
+func (s *auditedStore) SaveImagePullJob(imageName string, updater func(*types.ImagePullJob) (*types.ImagePullJob, error)) error {
+	return s.saveImagePullJobAs(s.origin, imageName, updater)
+}
+
+func (o *auditOrigin) newRecord(eventType EventType, kind ObjectKind, id string) *AuditRecord {
+	return &AuditRecord{
+		Actor:  o.actor,
+		Method: o.method,
+		Type:   eventType,
+		Kind:   kind,
+		ID:     id,
+	}
+}
+
+// recordsForEvents makes the audit records for the watch events.
+func (o *auditOrigin) recordsForEvents(events []*Event) []*AuditRecord {
+	var records []*AuditRecord
+	for _, event := range events {
+		record := o.newRecord(event.Type, event.Kind, event.ID)
+		switch {
+		case event.Sandbox != nil && event.Sandbox.Config != nil:
+			record.PodNamespace = event.Sandbox.Config.Namespace
+			record.PodName = event.Sandbox.Config.Name
+		case event.Container != nil:
+			record.PodNamespace = event.Container.Config.PodNamespace
+			record.PodName = event.Container.Config.PodName
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// AuditLog appends the records describing the changes made in the
+// metadata store to a file, one JSON object per line. The file is
+// rotated when it grows larger than the specified size, keeping
+// auditLogBackups old copies of it (path.1, path.2 and so on). The
+// file is only appended to, the existing records are never changed.
+type AuditLog struct {
+	sync.Mutex
+	path    string
+	maxSize int64
+	clock   clockwork.Clock
+	file    *os.File
+	size    int64
+}
+
+// NewAuditLog opens the audit log at the specified path, creating it
+// if it doesn't exist. maxSize is the size of the log in bytes after
+// which it's rotated. If clock is nil, the real clock is used.
+func NewAuditLog(path string, maxSize int64, clock clockwork.Clock) (*AuditLog, error) {
+	if clock == nil {
+		clock = clockwork.NewRealClock()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("can't create the directory for the audit log %q: %v", path, err)
+	}
+	l := &AuditLog{path: path, maxSize: maxSize, clock: clock}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *AuditLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("can't open the audit log %q: %v", l.path, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("can't stat the audit log %q: %v", l.path, err)
+	}
+	l.file = f
+	l.size = fi.Size()
+	return nil
+}
+
+// rotateAuditLog renames path to path.1, path.1 to path.2 and so on,
+// keeping at most numBackups old copies of the log.
+func rotateAuditLog(path string, numBackups int) error {
+	for i := numBackups; i > 0; i-- {
+		src := path
+		if i > 1 {
+			src = fmt.Sprintf("%s.%d", path, i-1)
+		}
+		if err := os.Rename(src, fmt.Sprintf("%s.%d", path, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *AuditLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		glog.Warningf("Error closing the audit log %q: %v", l.path, err)
+	}
+	l.file = nil
+	if err := rotateAuditLog(l.path, auditLogBackups); err != nil {
+		return fmt.Errorf("error rotating the audit log %q: %v", l.path, err)
+	}
+	return l.open()
+}
+
+func (l *AuditLog) write(record *AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if l.file == nil {
+		// the previous attempt to rotate the log has failed
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+	if l.size > 0 && l.size+int64(len(data)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	return err
+}
+
+// record appends the records to the log. The changes are already
+// committed at this point, so the errors are only logged.
+func (l *AuditLog) record(records []*AuditRecord) {
+	l.Lock()
+	defer l.Unlock()
+	now := l.clock.Now().UnixNano()
+	for _, record := range records {
+		record.Time = now
+		if err := l.write(record); err != nil {
+			glog.Errorf("Error writing the metadata audit log: %v", err)
+		}
+	}
+}
+
+// Close closes the audit log file.
+func (l *AuditLog) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// ReadAuditLog reads the records from the audit log file at the
+// specified path. The rotated copies of the log are not read.
+func ReadAuditLog(path string) ([]*AuditRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []*AuditRecord
+	decoder := json.NewDecoder(f)
+	for decoder.More() {
+		var record AuditRecord
+		if err := decoder.Decode(&record); err != nil {
+			return nil, fmt.Errorf("error reading the audit log %q: %v", path, err)
+		}
+		records = append(records, &record)
+	}
+	return records, nil
+}
+
+// setAuditLog sets the audit log for the changes delivered via the
+// hub. nil log disables the audit.
+func (h *watchHub) setAuditLog(log *AuditLog) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.audit = log
+}
+
+func (h *watchHub) auditLog() *AuditLog {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.audit
+}
+
+// recordAudit records the changes described by the events in the
+// audit log, if it's enabled.
+func (h *watchHub) recordAudit(origin *auditOrigin, events []*Event) {
+	log := h.auditLog()
+	if log == nil || len(events) == 0 {
+		return
+	}
+	if origin == nil {
+		origin = defaultAuditOrigin
+	}
+	log.record(origin.recordsForEvents(events))
+}
+
+// auditImagePullJob wraps the updater passed to SaveImagePullJob so
+// the change of the record made by origin can be recorded in the
+// audit log. The returned function must be invoked with the result
+// of the update. It records the change if the update has succeeded
+// and returns the error passed to it.
+func (h *watchHub) auditImagePullJob(origin *auditOrigin, imageName string, updater func(*types.ImagePullJob) (*types.ImagePullJob, error)) (func(*types.ImagePullJob) (*types.ImagePullJob, error), func(error) error) {
+	if h.auditLog() == nil {
+		return updater, func(err error) error { return err }
+	}
+	if origin == nil {
+		origin = defaultAuditOrigin
+	}
+	var record *AuditRecord
+	wrapped := func(current *types.ImagePullJob) (*types.ImagePullJob, error) {
+		// the updater may be invoked more than once, the last
+		// invocation is the one that's committed
+		record = nil
+		job, err := updater(current)
+		if err == nil && (current != nil || job != nil) {
+			record = origin.newRecord(eventType(current != nil, job == nil), KindImagePullJob, imageName)
+		}
+		return job, err
+	}
+	return wrapped, func(err error) error {
+		if log := h.auditLog(); err == nil && record != nil && log != nil {
+			log.record([]*AuditRecord{record})
+		}
+		return err
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func withAuditLog(t *testing.T, maxSize int64, toCall func(path string, auditLog *AuditLog)) {
+	dir, err := ioutil.TempDir("", "audit-test-")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit", "metadata-audit.log")
+	clock := clockwork.NewFakeClockAt(time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC))
+	auditLog, err := NewAuditLog(path, maxSize, clock)
+	if err != nil {
+		t.Fatalf("NewAuditLog(): %v", err)
+	}
+	defer auditLog.Close()
+	toCall(path, auditLog)
+}
+
+func TestAuditLog(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		newStore func() (Store, error)
+	}{
+		{"bolt", NewFakeStore},
+		{"memstore", func() (Store, error) { return NewMemStore(), nil }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withAuditLog(t, 1024*1024, func(path string, auditLog *AuditLog) {
+				store, err := tc.newStore()
+				if err != nil {
+					t.Fatalf("error creating the store: %v", err)
+				}
+				defer store.Close()
+				// the changes made before the audit is enabled are not recorded
+				if err := store.PodSandbox("pod0").Save(func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+					return &types.PodSandboxInfo{Config: &types.PodSandboxConfig{Name: "pod0", Namespace: "default"}}, nil
+				}); err != nil {
+					t.Fatalf("PodSandbox().Save(): %v", err)
+				}
+				store.SetAuditLog(auditLog)
+
+				if err := store.PodSandbox("pod1").Save(func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+					return &types.PodSandboxInfo{Config: &types.PodSandboxConfig{Name: "foo", Namespace: "default"}}, nil
+				}); err != nil {
+					t.Fatalf("PodSandbox().Save(): %v", err)
+				}
+				criStore := WithAuditActor(store, AuditActorCRI, "CreateContainer")
+				for _, state := range []types.ContainerState{types.ContainerState_CONTAINER_CREATED, types.ContainerState_CONTAINER_RUNNING} {
+					if err := criStore.Container("container1").Save(func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+						return &types.ContainerInfo{
+							Name:  "vm",
+							State: state,
+							Config: types.VMConfig{
+								PodSandboxID: "pod1",
+								PodName:      "foo",
+								PodNamespace: "default",
+							},
+						}, nil
+					}); err != nil {
+						t.Fatalf("Container().Save(): %v", err)
+					}
+				}
+				// failed updates are not recorded
+				if err := store.Container("container1").Save(func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+					return nil, fmt.Errorf("oops")
+				}); err == nil {
+					t.Errorf("Container().Save() didn't fail")
+				}
+				pullStore := WithAuditActor(store, AuditActorCRI, "PullImage")
+				for _, remove := range []bool{false, true} {
+					if err := pullStore.SaveImagePullJob("example.com/foo.qcow2", func(*types.ImagePullJob) (*types.ImagePullJob, error) {
+						if remove {
+							return nil, nil
+						}
+						return &types.ImagePullJob{BytesTotal: 1048576}, nil
+					}); err != nil {
+						t.Fatalf("SaveImagePullJob(): %v", err)
+					}
+				}
+				// removing the pod sandbox removes its containers, too
+				gcStore := WithAuditActor(criStore, AuditActorGC, "")
+				sandboxes, err := gcStore.ListPodSandboxes(nil)
+				if err != nil {
+					t.Fatalf("ListPodSandboxes(): %v", err)
+				}
+				if len(sandboxes) != 2 || sandboxes[1].GetID() != "pod1" {
+					t.Fatalf("bad sandbox list %#v", sandboxes)
+				}
+				if err := sandboxes[1].Save(func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+					return nil, nil
+				}); err != nil {
+					t.Fatalf("PodSandbox().Save(): %v", err)
+				}
+
+				records, err := ReadAuditLog(path)
+				if err != nil {
+					t.Fatalf("ReadAuditLog(): %v", err)
+				}
+				var changes []string
+				for _, record := range records {
+					if record.Time != time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC).UnixNano() {
+						t.Errorf("bad record time %d", record.Time)
+					}
+					changes = append(changes, fmt.Sprintf("%s/%s: %s %s %s %s/%s", record.Actor, record.Method, record.Type, record.Kind, record.ID, record.PodNamespace, record.PodName))
+				}
+				expectedChanges := []string{
+					"virtlet/: created sandbox pod1 default/foo",
+					"cri/CreateContainer: created container container1 default/foo",
+					"cri/CreateContainer: updated container container1 default/foo",
+					"cri/PullImage: created imagePullJob example.com/foo.qcow2 /",
+					"cri/PullImage: deleted imagePullJob example.com/foo.qcow2 /",
+					"gc/: deleted container container1 default/foo",
+					"gc/: deleted sandbox pod1 default/foo",
+				}
+				if !reflect.DeepEqual(changes, expectedChanges) {
+					t.Errorf("bad audit records:\n%s\ninstead of\n%s", strings.Join(changes, "\n"), strings.Join(expectedChanges, "\n"))
+				}
+			})
+		})
+	}
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	withAuditLog(t, 1024, func(path string, auditLog *AuditLog) {
+		origin := &auditOrigin{actor: AuditActorGC}
+		numRecords := 100
+		for i := 0; i < numRecords; i++ {
+			auditLog.record([]*AuditRecord{origin.newRecord(EventDeleted, KindContainer, fmt.Sprintf("container%d", i))})
+		}
+
+		var ids []string
+		for i := auditLogBackups; i >= 0; i-- {
+			p := path
+			if i > 0 {
+				p = fmt.Sprintf("%s.%d", path, i)
+			}
+			fi, err := os.Stat(p)
+			if err != nil {
+				t.Fatalf("Stat(): %v", err)
+			}
+			if fi.Size() > 1024 {
+				t.Errorf("the size of %q exceeds the limit: %d", p, fi.Size())
+			}
+			records, err := ReadAuditLog(p)
+			if err != nil {
+				t.Fatalf("ReadAuditLog(): %v", err)
+			}
+			for _, record := range records {
+				ids = append(ids, record.ID)
+			}
+		}
+		if _, err := os.Stat(fmt.Sprintf("%s.%d", path, auditLogBackups+1)); !os.IsNotExist(err) {
+			t.Errorf("too many rotated copies of the audit log are kept")
+		}
+		// the rotated logs contain the most recent records
+		for i, id := range ids {
+			if expectedID := fmt.Sprintf("container%d", numRecords-len(ids)+i); id != expectedID {
+				t.Errorf("bad record id %q instead of %q", id, expectedID)
+				break
+			}
+		}
+	})
+}
@@ -0,0 +1,473 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// BackupSocketPath is the path of the unix domain socket
+	// that's used to retrieve the snapshots of the metadata
+	// database from the running Virtlet process
+	BackupSocketPath = "/run/virtlet-metadata.sock"
+
+	backupURL           = "http://virtlet/backup"
+	exportURL           = "http://virtlet/export"
+	importURL           = "http://virtlet/import"
+	checkURL            = "http://virtlet/check"
+	snapshotOpenTimeout = 10 * time.Second
+	// restoreSuffix is the suffix of the file holding the snapshot
+	// that will replace the database upon the next Virtlet start
+	restoreSuffix = ".restore"
+	// preRestoreSuffix is the suffix of the file that keeps the
+	// database that was replaced by a snapshot
+	preRestoreSuffix = ".pre-restore"
+)
+
+// Backuper is implemented by the stores that can take consistent
+// snapshots of their database while it's in use.
+type Backuper interface {
+	// Backup writes a snapshot of the database to w, returning
+	// the number of bytes written.
+	Backup(w io.Writer) (int64, error)
+}
+
+var _ Backuper = boltClient{}
+
+// Backup implements Backup method of Backuper interface. The
+// snapshot is written within a read-only transaction so it
+// doesn't block the updates of the database.
+func (b boltClient) Backup(w io.Writer) (int64, error) {
+	var n int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// BackupServer serves the snapshots of the metadata database over
+// HTTP on a unix domain socket. If the store implements Exporter,
+// the server also handles the JSON export and import of the
+// metadata, and if it implements Checker, the server handles the
+// metadata consistency checks.
+type BackupServer struct {
+	sync.Mutex
+	backuper    Backuper
+	ln          net.Listener
+	imageExists ImageChecker
+}
+
+// NewBackupServer makes a new BackupServer for the specified store.
+func NewBackupServer(backuper Backuper) *BackupServer {
+	return &BackupServer{backuper: backuper}
+}
+
+// SetImageChecker sets the function that's used by the metadata
+// consistency checks to verify that the images used by the
+// containers are present.
+func (s *BackupServer) SetImageChecker(imageExists ImageChecker) {
+	s.Lock()
+	defer s.Unlock()
+	s.imageExists = imageExists
+}
+
+// ServeHTTP implements http.Handler interface.
+func (s *BackupServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/export":
+		s.serveExport(w, r)
+		return
+	case "/import":
+		s.serveImport(w, r)
+		return
+	case "/check":
+		s.serveCheck(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	// If the backup fails after a part of the snapshot is
+	// written, the error can't be reported to the client, but
+	// the client will detect the truncated snapshot during the
+	// validation.
+	if n, err := s.backuper.Backup(w); err != nil {
+		glog.Errorf("Metadata backup failed: %v", err)
+		if n == 0 {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	} else {
+		glog.V(1).Infof("Metadata backup taken (%d bytes)", n)
+	}
+}
+
+func (s *BackupServer) serveExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	exporter, ok := s.backuper.(Exporter)
+	if !ok {
+		http.Error(w, "the metadata backend doesn't support the export", http.StatusNotImplemented)
+		return
+	}
+	var buf bytes.Buffer
+	if err := exporter.Export(&buf); err != nil {
+		glog.Errorf("Metadata export failed: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
+}
+
+func (s *BackupServer) serveImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	exporter, ok := s.backuper.(Exporter)
+	if !ok {
+		http.Error(w, "the metadata backend doesn't support the import", http.StatusNotImplemented)
+		return
+	}
+	if err := exporter.Import(r.Body); err != nil {
+		glog.Errorf("Metadata import failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	glog.V(1).Infof("Metadata imported")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveCheck checks the metadata, repairing it in case of a POST
+// request.
+func (s *BackupServer) serveCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	checker, ok := s.backuper.(Checker)
+	if !ok {
+		http.Error(w, "the metadata backend doesn't support the checks", http.StatusNotImplemented)
+		return
+	}
+	s.Lock()
+	imageExists := s.imageExists
+	s.Unlock()
+	repair := r.Method == http.MethodPost
+	report, err := checker.Check(imageExists, repair)
+	if err != nil {
+		glog.Errorf("Metadata check failed: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(report.Problems) != 0 {
+		glog.Warningf("Metadata check found %d problem(s), %d unrepaired", len(report.Problems), report.Unrepaired())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// Serve makes the server listen on the specified socket path.
+// This function doesn't return till the server stops listening.
+func (s *BackupServer) Serve(socketPath string) error {
+	if err := syscall.Unlink(socketPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	s.ln = ln
+	s.Unlock()
+	return http.Serve(ln, s)
+}
+
+// Stop stops the server.
+func (s *BackupServer) Stop() {
+	s.Lock()
+	defer s.Unlock()
+	if s.ln != nil {
+		s.ln.Close()
+		s.ln = nil
+	}
+}
+
+// RetrieveBackup retrieves a snapshot of the metadata database from
+// the BackupServer listening on the specified socket and writes it
+// to w. The snapshot is validated before it's written.
+func RetrieveBackup(socketPath string, w io.Writer) error {
+	resp, err := newBackupClient(socketPath).Get(backupURL)
+	if err != nil {
+		return fmt.Errorf("can't connect to %q: %v", socketPath, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("metadata backup failed: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	f, err := ioutil.TempFile("", "virtlet-backup-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return fmt.Errorf("error retrieving the snapshot: %v", err)
+	}
+	if err := ValidateSnapshot(f.Name()); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// ExportMetadata retrieves the pod sandboxes and the containers as
+// JSON from the BackupServer listening on the specified socket and
+// writes them to w.
+func ExportMetadata(socketPath string, w io.Writer) error {
+	resp, err := newBackupClient(socketPath).Get(exportURL)
+	if err != nil {
+		return fmt.Errorf("can't connect to %q: %v", socketPath, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("metadata export failed: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// ImportMetadata sends the JSON produced by ExportMetadata to the
+// BackupServer listening on the specified socket, which adds the
+// pod sandboxes and the containers to the store.
+func ImportMetadata(socketPath string, r io.Reader) error {
+	resp, err := newBackupClient(socketPath).Post(importURL, "application/json", r)
+	if err != nil {
+		return fmt.Errorf("can't connect to %q: %v", socketPath, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("metadata import failed: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// CheckMetadata checks the metadata using the BackupServer listening
+// on the specified socket. If repair is true, the problems found are
+// repaired where possible.
+func CheckMetadata(socketPath string, repair bool) (*CheckReport, error) {
+	client := newBackupClient(socketPath)
+	var resp *http.Response
+	var err error
+	if repair {
+		resp, err = client.Post(checkURL, "application/json", nil)
+	} else {
+		resp, err = client.Get(checkURL)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to %q: %v", socketPath, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("metadata check failed: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	var report CheckReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("error decoding the metadata check report: %v", err)
+	}
+	return &report, nil
+}
+
+func newBackupClient(socketPath string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+}
+
+// ValidateSnapshot verifies that the file at the specified path is a
+// consistent bolt database with the schema that's supported by this
+// Virtlet build and with valid JSON data in the pod sandbox and
+// container records.
+func ValidateSnapshot(path string) (err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Size() < 2*int64(os.Getpagesize()) {
+		return errors.New("bad metadata snapshot: the file is too short")
+	}
+	// bolt maps the file into memory, so accessing the pages
+	// past the end of a truncated file causes a fault, which
+	// is turned into a panic here
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("bad metadata snapshot: %v", r)
+		}
+	}()
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: snapshotOpenTimeout})
+	if err != nil {
+		return fmt.Errorf("bad metadata snapshot: %v", err)
+	}
+	defer db.Close()
+	if err := db.View(func(tx *bolt.Tx) error {
+		return validateSnapshotTx(tx, fi.Size())
+	}); err != nil {
+		return fmt.Errorf("bad metadata snapshot: %v", err)
+	}
+	return nil
+}
+
+// validateSnapshotTx checks the snapshot within a read-only
+// transaction. The consistency check runs in a separate goroutine,
+// so the size check must be done before it.
+func validateSnapshotTx(tx *bolt.Tx, fileSize int64) error {
+	if tx.Size() > fileSize {
+		return fmt.Errorf("the snapshot is truncated (%d bytes instead of %d)", fileSize, tx.Size())
+	}
+	// the channel must be drained as the checker goroutine
+	// uses the transaction
+	var checkErr error
+	for err := range tx.Check() {
+		if checkErr == nil {
+			checkErr = err
+		}
+	}
+	if checkErr != nil {
+		return checkErr
+	}
+	version, err := getSchemaVersion(tx)
+	if err != nil {
+		return err
+	}
+	if current := CurrentSchemaVersion(); version > current {
+		return fmt.Errorf("schema version %d is newer than the version %d supported by this Virtlet build", version, current)
+	}
+	if bucket := tx.Bucket(containersBucket); bucket != nil {
+		if err := bucket.ForEach(func(k, v []byte) error {
+			if v != nil && !isEncryptedValue(v) && !json.Valid(v) {
+				return fmt.Errorf("bad data for container %q", k)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	c := tx.Cursor()
+	for k, _ := c.Seek(sandboxKeyPrefix); k != nil && bytes.HasPrefix(k, sandboxKeyPrefix); k, _ = c.Next() {
+		if bucket := tx.Bucket(k); bucket != nil {
+			if data := bucket.Get(sandboxDataBucket); data != nil && !isEncryptedValue(data) && !json.Valid(data) {
+				return fmt.Errorf("bad data for pod sandbox %q", k[len(sandboxKeyPrefix):])
+			}
+		}
+	}
+	return nil
+}
+
+// StageRestore validates the snapshot read from r and stores it next
+// to the database at dbPath, so that it replaces the database upon
+// the next Virtlet start. The live database is not touched as it
+// can't be replaced while Virtlet is using it.
+func StageRestore(dbPath string, r io.Reader) error {
+	f, err := ioutil.TempFile(filepath.Dir(dbPath), filepath.Base(dbPath)+restoreSuffix+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	ok := false
+	defer func() {
+		if !ok {
+			f.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("error reading the snapshot: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := ValidateSnapshot(tmpPath); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, dbPath+restoreSuffix); err != nil {
+		return err
+	}
+	ok = true
+	return nil
+}
+
+// applyPendingRestore replaces the database at dbPath with the
+// snapshot staged by StageRestore, if there's one. The replaced
+// database is kept with ".pre-restore" suffix.
+func applyPendingRestore(dbPath string) error {
+	restorePath := dbPath + restoreSuffix
+	if _, err := os.Stat(restorePath); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := os.Rename(dbPath, dbPath+preRestoreSuffix); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error saving the database before the restore: %v", err)
+	}
+	if err := os.Rename(restorePath, dbPath); err != nil {
+		return fmt.Errorf("error restoring the database from the snapshot: %v", err)
+	}
+	glog.Warningf("Metadata database %q restored from the snapshot, the previous database is kept at %q", dbPath, dbPath+preRestoreSuffix)
+	return nil
+}
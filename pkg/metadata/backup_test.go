@@ -0,0 +1,176 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const testContainerID = "231700d5-c9a6-5a49-738d-99a954c51550"
+
+func saveTestContainer(t *testing.T, store Store, name string) {
+	if err := store.Container(testContainerID).Save(func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
+		return &types.ContainerInfo{
+			Name:   name,
+			Config: types.VMConfig{PodSandboxID: testSandboxID},
+		}, nil
+	}); err != nil {
+		t.Fatalf("Container().Save(): %v", err)
+	}
+}
+
+func testContainerName(t *testing.T, store Store) string {
+	ci, err := store.Container(testContainerID).Retrieve()
+	switch {
+	case err != nil:
+		t.Fatalf("Container().Retrieve(): %v", err)
+	case ci == nil:
+		return ""
+	}
+	return ci.Name
+}
+
+func TestBackupRestore(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "virtlet-backup-")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dbPath := filepath.Join(tmpDir, "virtlet.db")
+	socketPath := filepath.Join(tmpDir, "backup.sock")
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore(): %v", err)
+	}
+	defer func() {
+		if store != nil {
+			store.Close()
+		}
+	}()
+	saveTestContainer(t, store, "before-backup")
+
+	s := NewBackupServer(store.(Backuper))
+	go s.Serve(socketPath)
+	defer s.Stop()
+
+	var snapshot bytes.Buffer
+	for i := 0; ; i++ {
+		// wait for the server to start listening
+		if err = RetrieveBackup(socketPath, &snapshot); err == nil || i == 50 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("RetrieveBackup(): %v", err)
+	}
+
+	saveTestContainer(t, store, "after-backup")
+	if err := StageRestore(dbPath, &snapshot); err != nil {
+		t.Fatalf("StageRestore(): %v", err)
+	}
+	// the live database is not touched until Virtlet restarts
+	if name := testContainerName(t, store); name != "after-backup" {
+		t.Errorf("bad container name before the restart: %q", name)
+	}
+
+	store.Close()
+	if store, err = NewStore(dbPath); err != nil {
+		t.Fatalf("NewStore() after the restore: %v", err)
+	}
+	if name := testContainerName(t, store); name != "before-backup" {
+		t.Errorf("bad container name after the restore: %q", name)
+	}
+	if _, err := os.Stat(dbPath + restoreSuffix); !os.IsNotExist(err) {
+		t.Errorf("the staged snapshot was not removed: %v", err)
+	}
+	if _, err := os.Stat(dbPath + preRestoreSuffix); err != nil {
+		t.Errorf("the replaced database was not kept: %v", err)
+	}
+}
+
+func TestValidateSnapshot(t *testing.T) {
+	withTestDB(t, func(path string) {
+		writeOldSandbox(t, path)
+		if err := ValidateSnapshot(path); err != nil {
+			t.Errorf("ValidateSnapshot() failed for a valid snapshot: %v", err)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile(): %v", err)
+		}
+		tmpDir, err := ioutil.TempDir("", "virtlet-backup-")
+		if err != nil {
+			t.Fatalf("TempDir(): %v", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		dbPath := filepath.Join(tmpDir, "virtlet.db")
+		for _, tc := range []struct {
+			name         string
+			data         []byte
+			errSubstring string
+		}{
+			{
+				name:         "truncated snapshot",
+				data:         data[:2*os.Getpagesize()],
+				errSubstring: "bad metadata snapshot",
+			},
+			{
+				name:         "too short",
+				data:         data[:100],
+				errSubstring: "the file is too short",
+			},
+			{
+				name:         "garbage",
+				data:         bytes.Repeat([]byte("foobar"), 4096),
+				errSubstring: "bad metadata snapshot",
+			},
+		} {
+			if err := StageRestore(dbPath, bytes.NewReader(tc.data)); err == nil {
+				t.Errorf("%s: StageRestore() didn't fail", tc.name)
+			} else if !strings.Contains(err.Error(), tc.errSubstring) {
+				t.Errorf("%s: bad error: %v", tc.name, err)
+			}
+		}
+		files, err := ioutil.ReadDir(tmpDir)
+		if err != nil {
+			t.Fatalf("ReadDir(): %v", err)
+		}
+		if len(files) != 0 {
+			t.Errorf("StageRestore() left files after failing: %v", files)
+		}
+
+		updateTestDB(t, path, func(tx *bolt.Tx) error {
+			return setSchemaVersion(tx, CurrentSchemaVersion()+1)
+		})
+		if err := ValidateSnapshot(path); err == nil || !strings.Contains(err.Error(), "is newer than") {
+			t.Errorf("ValidateSnapshot() didn't fail for a newer schema version: %v", err)
+		}
+	})
+}
@@ -0,0 +1,1246 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/golang/glog"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const (
+	// badgerSchemaVersion is the version of the key layout used
+	// by the badger store, which is kept under badgerSchemaVersionKey
+	badgerSchemaVersion    = 1
+	badgerSchemaVersionKey = "schemaVersion"
+
+	// badgerMaxConflictRetries is the maximum number of times a
+	// transaction is retried if it conflicts with a concurrent one
+	badgerMaxConflictRetries = 100
+	// badgerGCInterval is the interval between the runs of the
+	// badger value log GC
+	badgerGCInterval = 10 * time.Minute
+	// badgerGCDiscardRatio is the fraction of the stale data in a
+	// value log file above which the file is rewritten by the GC
+	badgerGCDiscardRatio = 0.5
+
+	badgerSandboxPrefix           = "sandboxes/"
+	badgerSandboxContainersPrefix = "sandboxContainers/"
+	badgerContainerPrefix         = "containers/"
+	badgerStartRecordPrefix       = "startRecords/"
+	badgerStartRecordSeqKey       = "startRecordSeq"
+	badgerFirstBootPrefix         = "firstBoot/"
+	badgerImagePullPrefix         = "imagePulls/"
+	badgerSandboxTombstonePrefix  = "sandboxTombstones/"
+	badgerSandboxEventPrefix      = "sandboxEvents/"
+	badgerSandboxEventSeqKey      = "sandboxEventSeq"
+	badgerRevisionSeqKey          = "revisionSeq"
+)
+
+// badgerClient is a Store implementation that keeps the metadata in
+// a local Badger database. The key layout is the same as the one
+// used by the etcd store. Badger is an LSM tree based store with
+// optimistic concurrency control, so unlike bolt, the transactions
+// that don't touch the same keys don't wait for each other, and the
+// writes are appended to the value log instead of rewriting the
+// B+tree pages. This makes it a better fit for the nodes with
+// frequent pod churn. The updaters passed to Save methods may be
+// invoked more than once if the transaction conflicts with a
+// concurrent one.
+type badgerClient struct {
+	db            *badger.DB
+	watchers      *watchHub
+	removalPolicy SandboxRemovalPolicy
+	stop          chan struct{}
+	wg            sync.WaitGroup
+}
+
+var _ Store = &badgerClient{}
+
+// badgerLogger passes the badger log messages to glog
+type badgerLogger struct{}
+
+func (badgerLogger) Errorf(format string, args ...interface{}) {
+	glog.Errorf("badger: "+format, args...)
+}
+
+func (badgerLogger) Warningf(format string, args ...interface{}) {
+	glog.Warningf("badger: "+format, args...)
+}
+
+func (badgerLogger) Infof(format string, args ...interface{}) {
+	glog.V(2).Infof("badger: "+format, args...)
+}
+
+func (badgerLogger) Debugf(format string, args ...interface{}) {
+	glog.V(4).Infof("badger: "+format, args...)
+}
+
+// NewBadgerStore returns a Store that keeps the metadata in a Badger
+// database in the specified directory, creating the database if it
+// doesn't exist.
+func NewBadgerStore(dir string) (Store, error) {
+	return newBadgerClient(dir)
+}
+
+func newBadgerClient(dir string) (*badgerClient, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating badger database directory %q: %v", dir, err)
+	}
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = badgerLogger{}
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("error opening badger database %q: %v", dir, err)
+	}
+	c := &badgerClient{db: db, watchers: newWatchHub(), stop: make(chan struct{})}
+	if err := c.initSchema(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing badger database %q: %v", dir, err)
+	}
+	c.wg.Add(1)
+	go c.runValueLogGC()
+	return c, nil
+}
+
+func (c *badgerClient) initSchema() error {
+	return c.update(func(txn *badger.Txn) error {
+		var version int
+		if _, err := badgerGet(txn, badgerSchemaVersionKey, &version); err != nil {
+			return err
+		}
+		if version > badgerSchemaVersion {
+			return fmt.Errorf("the database schema version %d is newer than the supported one (%d)", version, badgerSchemaVersion)
+		}
+		return badgerPut(txn, badgerSchemaVersionKey, badgerSchemaVersion)
+	})
+}
+
+// runValueLogGC periodically reclaims the space taken by the stale
+// values in the value log until the store is closed
+func (c *badgerClient) runValueLogGC() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(badgerGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			// each successful run rewrites a single value log file
+			for c.db.RunValueLogGC(badgerGCDiscardRatio) == nil {
+			}
+		}
+	}
+}
+
+// Watch implements Watch method of WatchStore interface
+func (c *badgerClient) Watch() (<-chan Event, func()) {
+	return c.watchers.watch()
+}
+
+// SetAuditLog implements SetAuditLog method of AuditStore interface
+func (c *badgerClient) SetAuditLog(log *AuditLog) {
+	c.watchers.setAuditLog(log)
+}
+
+// Close stops the value log GC and closes the database
+func (c *badgerClient) Close() error {
+	close(c.stop)
+	c.wg.Wait()
+	return c.db.Close()
+}
+
+func badgerSandboxKey(podID string) string {
+	return badgerSandboxPrefix + podID
+}
+
+func badgerSandboxContainersKey(podID string) string {
+	return badgerSandboxContainersPrefix + podID
+}
+
+func badgerContainerKey(containerID string) string {
+	return badgerContainerPrefix + containerID
+}
+
+// update runs fn in a read-write transaction, retrying it if the
+// transaction conflicts with a concurrent one
+func (c *badgerClient) update(fn func(txn *badger.Txn) error) error {
+	for n := 0; ; n++ {
+		err := c.db.Update(fn)
+		if err != badger.ErrConflict || n >= badgerMaxConflictRetries {
+			return err
+		}
+	}
+}
+
+// badgerGet unmarshals the value of the key into v leaving v
+// untouched if there's no such key. It returns false if there's
+// no such key.
+func badgerGet(txn *badger.Txn, key string, v interface{}) (bool, error) {
+	item, err := txn.Get([]byte(key))
+	switch {
+	case err == badger.ErrKeyNotFound:
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	data, err := item.ValueCopy(nil)
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("error unmarshalling the value of badger key %q: %v", key, err)
+	}
+	return true, nil
+}
+
+func badgerPut(txn *badger.Txn, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return txn.Set([]byte(key), data)
+}
+
+// badgerHas returns true if the key exists
+func badgerHas(txn *badger.Txn, key string) (bool, error) {
+	_, err := txn.Get([]byte(key))
+	switch {
+	case err == badger.ErrKeyNotFound:
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+
+// badgerKeys returns the keys with the specified prefix sorted
+func badgerKeys(txn *badger.Txn, prefix string) []string {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	var keys []string
+	for it.Seek([]byte(prefix)); it.ValidForPrefix([]byte(prefix)); it.Next() {
+		keys = append(keys, string(it.Item().Key()))
+	}
+	return keys
+}
+
+// badgerList invokes fn for each key-value pair with the specified
+// key prefix in the order of the keys
+func badgerList(txn *badger.Txn, prefix string, fn func(key string, value []byte) error) error {
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	for it.Seek([]byte(prefix)); it.ValidForPrefix([]byte(prefix)); it.Next() {
+		item := it.Item()
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err := fn(string(item.Key()), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// badgerNextID increments the sequence number kept under the
+// specified key and returns the new value
+func badgerNextID(txn *badger.Txn, seqKey string) (uint64, error) {
+	var id uint64
+	item, err := txn.Get([]byte(seqKey))
+	switch {
+	case err == badger.ErrKeyNotFound:
+	case err != nil:
+		return 0, err
+	default:
+		seq, err := item.ValueCopy(nil)
+		if err != nil {
+			return 0, err
+		}
+		if id, err = strconv.ParseUint(string(seq), 10, 64); err != nil {
+			return 0, fmt.Errorf("bad sequence number %q under badger key %q: %v", seq, seqKey, err)
+		}
+	}
+	id++
+	return id, txn.Set([]byte(seqKey), []byte(strconv.FormatUint(id, 10)))
+}
+
+type badgerPodSandboxMeta struct {
+	client *badgerClient
+	id     string
+	origin *auditOrigin
+}
+
+// GetID implements GetID method of PodSandboxMetadata interface
+func (m badgerPodSandboxMeta) GetID() string {
+	return m.id
+}
+
+// Retrieve implements Retrieve method of PodSandboxMetadata interface
+func (m badgerPodSandboxMeta) Retrieve() (*types.PodSandboxInfo, error) {
+	if m.GetID() == "" {
+		return nil, errors.New("Pod sandbox ID cannot be empty")
+	}
+	var psi *types.PodSandboxInfo
+	if err := m.client.db.View(func(txn *badger.Txn) error {
+		found, err := badgerGet(txn, badgerSandboxKey(m.GetID()), &psi)
+		if err != nil || found {
+			return err
+		}
+		hasContainers, err := badgerHas(txn, badgerSandboxContainersKey(m.GetID()))
+		switch {
+		case err != nil:
+			return err
+		case !hasContainers:
+			return sandboxNotFoundError(m.GetID())
+		}
+		// the sandbox only has containers associated with it
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if psi != nil {
+		psi.PodID = m.GetID()
+	}
+	return psi, nil
+}
+
+// Save implements Save method of PodSandboxMetadata interface
+func (m badgerPodSandboxMeta) Save(updater func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error)) error {
+	if m.GetID() == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	return m.client.watchers.updateMulti(m.origin, func() ([]*Event, error) {
+		var events []*Event
+		if err := m.client.update(func(txn *badger.Txn) error {
+			var err error
+			events, err = m.client.savePodSandbox(txn, m.GetID(), updater)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		return events, nil
+	})
+}
+
+// savePodSandbox updates the pod sandbox with given ID within the
+// transaction, returning the events that describe the changes. When
+// the pod sandbox is removed, its containers are handled according
+// to the removal policy.
+func (c *badgerClient) savePodSandbox(txn *badger.Txn, podID string, updater func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error)) ([]*Event, error) {
+	key := badgerSandboxKey(podID)
+	var current *types.PodSandboxInfo
+	if _, err := badgerGet(txn, key, &current); err != nil {
+		return nil, err
+	}
+	newData, err := updatePodSandbox(current, updater, func() (uint64, error) {
+		return badgerNextID(txn, badgerRevisionSeqKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if newData != nil {
+		if err := badgerPut(txn, key, newData); err != nil {
+			return nil, err
+		}
+		return eventList(sandboxEvent(podID, current, newData)), nil
+	}
+
+	var containerIDs []string
+	if _, err := badgerGet(txn, badgerSandboxContainersKey(podID), &containerIDs); err != nil {
+		return nil, err
+	}
+	if err := checkSandboxRemoval(c.removalPolicy, podID, containerIDs); err != nil {
+		return nil, err
+	}
+	var events []*Event
+	for _, containerID := range containerIDs {
+		event, err := c.saveContainer(txn, containerID, func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+			return nil, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, eventList(event)...)
+	}
+	if err := txn.Delete([]byte(key)); err != nil {
+		return nil, err
+	}
+	if err := txn.Delete([]byte(badgerSandboxContainersKey(podID))); err != nil {
+		return nil, err
+	}
+	return append(events, eventList(sandboxEvent(podID, current, nil))...), nil
+}
+
+// SetSandboxRemovalPolicy implements SetSandboxRemovalPolicy method
+// of SandboxStore interface
+func (c *badgerClient) SetSandboxRemovalPolicy(policy SandboxRemovalPolicy) {
+	c.removalPolicy = policy
+}
+
+// PodSandbox implements PodSandbox method of SandboxStore interface
+func (c *badgerClient) PodSandbox(podID string) PodSandboxMetadata {
+	return c.podSandboxAs(nil, podID)
+}
+
+// podSandboxAs implements podSandboxAs method of auditedBackend interface
+func (c *badgerClient) podSandboxAs(origin *auditOrigin, podID string) PodSandboxMetadata {
+	return &badgerPodSandboxMeta{id: podID, client: c, origin: origin}
+}
+
+// ListPodSandboxes implements ListPodSandboxes method of SandboxStore interface
+func (c *badgerClient) ListPodSandboxes(filter *types.PodSandboxFilter) ([]PodSandboxMetadata, error) {
+	result, _, err := c.ListPodSandboxesPage(filter, 0, "")
+	return result, err
+}
+
+// ListPodSandboxesPage implements ListPodSandboxesPage method of SandboxStore interface
+func (c *badgerClient) ListPodSandboxesPage(filter *types.PodSandboxFilter, limit int, continueToken string) ([]PodSandboxMetadata, string, error) {
+	ids := make(map[string]bool)
+	if err := c.db.View(func(txn *badger.Txn) error {
+		for _, prefix := range []string{badgerSandboxPrefix, badgerSandboxContainersPrefix} {
+			for _, key := range badgerKeys(txn, prefix) {
+				ids[key[len(prefix):]] = true
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, "", err
+	}
+	var sortedIDs []string
+	for id := range ids {
+		sortedIDs = append(sortedIDs, id)
+	}
+	sort.Strings(sortedIDs)
+
+	pageIDs, nextToken, err := listPage(sortedIDs, limit, continueToken, func(id string) (bool, error) {
+		return filterPodSandboxMeta(&badgerPodSandboxMeta{client: c, id: id}, filter)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	var result []PodSandboxMetadata
+	for _, id := range pageIDs {
+		result = append(result, badgerPodSandboxMeta{client: c, id: id})
+	}
+	return result, nextToken, nil
+}
+
+type badgerContainerMeta struct {
+	client *badgerClient
+	id     string
+	origin *auditOrigin
+}
+
+// GetID implements GetID method of ContainerMetadata interface
+func (m badgerContainerMeta) GetID() string {
+	return m.id
+}
+
+// Retrieve implements Retrieve method of ContainerMetadata interface
+func (m badgerContainerMeta) Retrieve() (*types.ContainerInfo, error) {
+	if m.GetID() == "" {
+		return nil, errors.New("Container ID cannot be empty")
+	}
+	var ci *types.ContainerInfo
+	if err := m.client.db.View(func(txn *badger.Txn) error {
+		_, err := badgerGet(txn, badgerContainerKey(m.GetID()), &ci)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return ci, nil
+}
+
+// Save implements Save method of ContainerMetadata interface
+func (m badgerContainerMeta) Save(updater func(*types.ContainerInfo) (*types.ContainerInfo, error)) error {
+	if m.GetID() == "" {
+		return errors.New("Container ID cannot be empty")
+	}
+	return m.client.watchers.update(m.origin, func() (*Event, error) {
+		var event *Event
+		if err := m.client.update(func(txn *badger.Txn) error {
+			var err error
+			event, err = m.client.saveContainer(txn, m.GetID(), updater)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		return event, nil
+	})
+}
+
+// saveContainer updates the container with given ID within the
+// transaction, returning the event that describes the change, if any.
+func (c *badgerClient) saveContainer(txn *badger.Txn, containerID string, updater func(*types.ContainerInfo) (*types.ContainerInfo, error)) (*Event, error) {
+	key := badgerContainerKey(containerID)
+	var current *types.ContainerInfo
+	if _, err := badgerGet(txn, key, &current); err != nil {
+		return nil, err
+	}
+	var oldPodID string
+	if current != nil {
+		oldPodID = current.Config.PodSandboxID
+	}
+	newData, err := updateContainer(current, updater, func() (uint64, error) {
+		return badgerNextID(txn, badgerRevisionSeqKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if current == nil && newData == nil {
+		return nil, nil
+	}
+
+	if newData == nil {
+		if oldPodID != "" {
+			if err := updateBadgerSandboxContainers(txn, oldPodID, containerID, false); err != nil {
+				return nil, err
+			}
+		}
+		if err := txn.Delete([]byte(key)); err != nil {
+			return nil, err
+		}
+		return containerEvent(containerID, current, nil), nil
+	}
+	newData.Id = containerID
+
+	if oldPodID != newData.Config.PodSandboxID {
+		if oldPodID != "" {
+			if err := updateBadgerSandboxContainers(txn, oldPodID, containerID, false); err != nil {
+				return nil, err
+			}
+		}
+		if newData.Config.PodSandboxID != "" {
+			if err := updateBadgerSandboxContainers(txn, newData.Config.PodSandboxID, containerID, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := badgerPut(txn, key, newData); err != nil {
+		return nil, err
+	}
+	return containerEvent(containerID, current, newData), nil
+}
+
+// updateBadgerSandboxContainers adds the container to the list of the
+// containers that belong to the pod sandbox or removes it from there.
+func updateBadgerSandboxContainers(txn *badger.Txn, podID, containerID string, add bool) error {
+	key := badgerSandboxContainersKey(podID)
+	var ids []string
+	found, err := badgerGet(txn, key, &ids)
+	switch {
+	case err != nil:
+		return err
+	case !add && !found:
+		return nil
+	}
+	newIDs := []string{}
+	for _, id := range ids {
+		if id != containerID {
+			newIDs = append(newIDs, id)
+		}
+	}
+	if add {
+		newIDs = append(newIDs, containerID)
+		sort.Strings(newIDs)
+	}
+	return badgerPut(txn, key, newIDs)
+}
+
+type badgerTx struct {
+	client *badgerClient
+	txn    *badger.Txn
+	events []*Event
+}
+
+var _ Tx = &badgerTx{}
+
+// SavePodSandbox implements SavePodSandbox method of Tx interface
+func (t *badgerTx) SavePodSandbox(podID string, updater func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error)) error {
+	if podID == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	events, err := t.client.savePodSandbox(t.txn, podID, updater)
+	if err != nil {
+		return err
+	}
+	t.events = append(t.events, events...)
+	return nil
+}
+
+// SaveContainer implements SaveContainer method of Tx interface
+func (t *badgerTx) SaveContainer(containerID string, updater func(*types.ContainerInfo) (*types.ContainerInfo, error)) error {
+	if containerID == "" {
+		return errors.New("Container ID cannot be empty")
+	}
+	event, err := t.client.saveContainer(t.txn, containerID, updater)
+	if err != nil {
+		return err
+	}
+	t.events = append(t.events, eventList(event)...)
+	return nil
+}
+
+// PodContainerIDs implements PodContainerIDs method of Tx interface
+func (t *badgerTx) PodContainerIDs(podID string) ([]string, error) {
+	if podID == "" {
+		return nil, errors.New("Pod sandbox ID cannot be empty")
+	}
+	var ids []string
+	if _, err := badgerGet(t.txn, badgerSandboxContainersKey(podID), &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Update implements Update method of TransactionStore interface.
+// The changes are made within a single badger transaction, which is
+// retried in case of conflicts.
+func (c *badgerClient) Update(fn func(tx Tx) error) error {
+	return c.updateAs(nil, fn)
+}
+
+// updateAs implements updateAs method of auditedBackend interface
+func (c *badgerClient) updateAs(origin *auditOrigin, fn func(tx Tx) error) error {
+	return c.watchers.updateMulti(origin, func() ([]*Event, error) {
+		var events []*Event
+		if err := c.update(func(txn *badger.Txn) error {
+			t := &badgerTx{client: c, txn: txn}
+			if err := fn(t); err != nil {
+				return err
+			}
+			events = t.events
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		return events, nil
+	})
+}
+
+// Container implements Container method of ContainerStore interface
+func (c *badgerClient) Container(containerID string) ContainerMetadata {
+	return c.containerAs(nil, containerID)
+}
+
+// containerAs implements containerAs method of auditedBackend interface
+func (c *badgerClient) containerAs(origin *auditOrigin, containerID string) ContainerMetadata {
+	return &badgerContainerMeta{id: containerID, client: c, origin: origin}
+}
+
+// ListPodContainers implements ListPodContainers method of ContainerStore interface
+func (c *badgerClient) ListPodContainers(podID string) ([]ContainerMetadata, error) {
+	if podID == "" {
+		return nil, errors.New("Pod sandbox ID cannot be empty")
+	}
+	var ids []string
+	if err := c.db.View(func(txn *badger.Txn) error {
+		found, err := badgerGet(txn, badgerSandboxContainersKey(podID), &ids)
+		if err != nil || found {
+			return err
+		}
+		hasSandbox, err := badgerHas(txn, badgerSandboxKey(podID))
+		switch {
+		case err != nil:
+			return err
+		case !hasSandbox:
+			return fmt.Errorf("pod sandbox %q does not exist", podID)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	var result []ContainerMetadata
+	for _, id := range ids {
+		result = append(result, c.Container(id))
+	}
+	return result, nil
+}
+
+// ListContainersPage implements ListContainersPage method of ContainerStore interface
+func (c *badgerClient) ListContainersPage(limit int, continueToken string) ([]ContainerMetadata, string, error) {
+	var ids []string
+	if err := c.db.View(func(txn *badger.Txn) error {
+		for _, key := range badgerKeys(txn, badgerContainerPrefix) {
+			ids = append(ids, key[len(badgerContainerPrefix):])
+		}
+		return nil
+	}); err != nil {
+		return nil, "", err
+	}
+
+	pageIDs, nextToken, err := listPage(ids, limit, continueToken, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	var result []ContainerMetadata
+	for _, id := range pageIDs {
+		result = append(result, c.Container(id))
+	}
+	return result, nextToken, nil
+}
+
+// ListContainerInfos implements ListContainerInfos method of ContainerStore interface.
+// The containers are read within a single read-only transaction.
+func (c *badgerClient) ListContainerInfos() ([]*types.ContainerInfo, error) {
+	var result []*types.ContainerInfo
+	if err := c.db.View(func(txn *badger.Txn) error {
+		return badgerList(txn, badgerContainerPrefix, func(key string, value []byte) error {
+			var ci *types.ContainerInfo
+			if err := json.Unmarshal(value, &ci); err != nil {
+				return fmt.Errorf("error unmarshalling container %q: %v", key, err)
+			}
+			result = append(result, ci)
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ImagesInUse implements ImagesInUse method of ContainerStore interface
+func (c *badgerClient) ImagesInUse() (map[string]bool, error) {
+	result := make(map[string]bool)
+	if err := c.db.View(func(txn *badger.Txn) error {
+		return badgerList(txn, badgerSandboxContainersPrefix, func(key string, value []byte) error {
+			var ids []string
+			if err := json.Unmarshal(value, &ids); err != nil {
+				return err
+			}
+			for _, id := range ids {
+				var ci *types.ContainerInfo
+				if _, err := badgerGet(txn, badgerContainerKey(id), &ci); err != nil {
+					return err
+				}
+				if ci == nil {
+					return fmt.Errorf("containerInfo of container %q not found in Virtlet metadata store", id)
+				}
+				result[ci.Config.Image] = true
+				if ci.Config.ParsedAnnotations != nil {
+					for _, image := range ci.Config.ParsedAnnotations.CDROMImages {
+						result[image] = true
+					}
+				}
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// pruneBadgerKeys removes the oldest keys with the specified prefix
+// so that no more than maxCount of such keys remain. The ids of the
+// keys are extracted using keyID
+func pruneBadgerKeys(txn *badger.Txn, prefix string, maxCount int, keyID func(key []byte) uint64) error {
+	keys := badgerKeys(txn, prefix)
+	if len(keys) <= maxCount {
+		return nil
+	}
+	sort.Slice(keys, func(i, j int) bool { return keyID([]byte(keys[i])) < keyID([]byte(keys[j])) })
+	for _, key := range keys[:len(keys)-maxCount] {
+		if err := txn.Delete([]byte(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddStartRecord implements AddStartRecord method of StartRecordStore interface
+func (c *badgerClient) AddStartRecord(record *types.VMStartRecord) error {
+	if record.PodName == "" {
+		return errors.New("Pod name cannot be empty")
+	}
+	return c.update(func(txn *badger.Txn) error {
+		id, err := badgerNextID(txn, badgerStartRecordSeqKey)
+		if err != nil {
+			return err
+		}
+		record.ID = id
+		if err := badgerPut(txn, badgerStartRecordPrefix+string(startRecordKey(record.PodNamespace, record.PodName, id)), record); err != nil {
+			return err
+		}
+		if err := pruneBadgerKeys(txn, badgerStartRecordPrefix+string(startRecordPrefix(record.PodNamespace, record.PodName)), maxStartRecordsPerPod, startRecordID); err != nil {
+			return err
+		}
+		return pruneBadgerKeys(txn, badgerStartRecordPrefix, maxStartRecords, startRecordID)
+	})
+}
+
+// ListStartRecords implements ListStartRecords method of StartRecordStore interface
+func (c *badgerClient) ListStartRecords(podNamespace, podName string) ([]*types.VMStartRecord, error) {
+	prefix := badgerStartRecordPrefix
+	if podName != "" {
+		prefix += string(startRecordPrefix(podNamespace, podName))
+	}
+	var records []*types.VMStartRecord
+	if err := c.db.View(func(txn *badger.Txn) error {
+		return badgerList(txn, prefix, func(key string, value []byte) error {
+			var record *types.VMStartRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return fmt.Errorf("error unmarshalling start record %q: %v", key, err)
+			}
+			records = append(records, record)
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records, nil
+}
+
+// listBadgerSandboxTombstones returns the tombstones ordered from the
+// oldest to the newest one by their deletion time
+func listBadgerSandboxTombstones(txn *badger.Txn) ([]*types.SandboxTombstone, error) {
+	var tombstones []*types.SandboxTombstone
+	if err := badgerList(txn, badgerSandboxTombstonePrefix, func(key string, value []byte) error {
+		var tombstone *types.SandboxTombstone
+		if err := json.Unmarshal(value, &tombstone); err != nil {
+			return fmt.Errorf("error unmarshalling sandbox tombstone %q: %v", key, err)
+		}
+		tombstones = append(tombstones, tombstone)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sortSandboxTombstones(tombstones)
+	return tombstones, nil
+}
+
+func deleteBadgerSandboxTombstones(txn *badger.Txn, tombstones []*types.SandboxTombstone) error {
+	for _, t := range tombstones {
+		if err := txn.Delete([]byte(badgerSandboxTombstonePrefix + t.PodSandboxID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddSandboxTombstone implements AddSandboxTombstone method of SandboxTombstoneStore interface
+func (c *badgerClient) AddSandboxTombstone(tombstone *types.SandboxTombstone) error {
+	if tombstone.PodSandboxID == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	return c.update(func(txn *badger.Txn) error {
+		if err := badgerPut(txn, badgerSandboxTombstonePrefix+tombstone.PodSandboxID, tombstone); err != nil {
+			return err
+		}
+		tombstones, err := listBadgerSandboxTombstones(txn)
+		if err != nil {
+			return err
+		}
+		if len(tombstones) <= maxSandboxTombstones {
+			return nil
+		}
+		return deleteBadgerSandboxTombstones(txn, tombstones[:len(tombstones)-maxSandboxTombstones])
+	})
+}
+
+// ListSandboxTombstones implements ListSandboxTombstones method of SandboxTombstoneStore interface
+func (c *badgerClient) ListSandboxTombstones() ([]*types.SandboxTombstone, error) {
+	var tombstones []*types.SandboxTombstone
+	if err := c.db.View(func(txn *badger.Txn) error {
+		var err error
+		tombstones, err = listBadgerSandboxTombstones(txn)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return tombstones, nil
+}
+
+// PruneSandboxTombstones implements PruneSandboxTombstones method of SandboxTombstoneStore interface
+func (c *badgerClient) PruneSandboxTombstones(before int64) (int, error) {
+	var n int
+	if err := c.update(func(txn *badger.Txn) error {
+		tombstones, err := listBadgerSandboxTombstones(txn)
+		if err != nil {
+			return err
+		}
+		n = 0
+		for n < len(tombstones) && tombstones[n].DeletedAt < before {
+			n++
+		}
+		return deleteBadgerSandboxTombstones(txn, tombstones[:n])
+	}); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// AddSandboxEvent implements AddSandboxEvent method of SandboxEventStore interface
+func (c *badgerClient) AddSandboxEvent(event *types.SandboxEvent) error {
+	if event.PodSandboxID == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	return c.update(func(txn *badger.Txn) error {
+		id, err := badgerNextID(txn, badgerSandboxEventSeqKey)
+		if err != nil {
+			return err
+		}
+		event.ID = id
+		if err := badgerPut(txn, badgerSandboxEventPrefix+string(sandboxEventKey(event.PodSandboxID, id)), event); err != nil {
+			return err
+		}
+		if err := pruneBadgerKeys(txn, badgerSandboxEventPrefix+string(sandboxEventPrefix(event.PodSandboxID)), maxSandboxEventsPerPod, sandboxEventID); err != nil {
+			return err
+		}
+		return pruneBadgerKeys(txn, badgerSandboxEventPrefix, maxSandboxEvents, sandboxEventID)
+	})
+}
+
+// ListSandboxEvents implements ListSandboxEvents method of SandboxEventStore interface
+func (c *badgerClient) ListSandboxEvents(podID string) ([]*types.SandboxEvent, error) {
+	prefix := badgerSandboxEventPrefix
+	if podID != "" {
+		prefix += string(sandboxEventPrefix(podID))
+	}
+	var events []*types.SandboxEvent
+	if err := c.db.View(func(txn *badger.Txn) error {
+		return badgerList(txn, prefix, func(key string, value []byte) error {
+			var event *types.SandboxEvent
+			if err := json.Unmarshal(value, &event); err != nil {
+				return fmt.Errorf("error unmarshalling sandbox event %q: %v", key, err)
+			}
+			events = append(events, event)
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, nil
+}
+
+// GetFirstBootRecord implements GetFirstBootRecord method of FirstBootStore interface
+func (c *badgerClient) GetFirstBootRecord(volumeID string) (*types.FirstBootRecord, error) {
+	if volumeID == "" {
+		return nil, errors.New("Volume ID cannot be empty")
+	}
+	var record *types.FirstBootRecord
+	if err := c.db.View(func(txn *badger.Txn) error {
+		_, err := badgerGet(txn, badgerFirstBootPrefix+volumeID, &record)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// SaveFirstBootRecord implements SaveFirstBootRecord method of FirstBootStore interface
+func (c *badgerClient) SaveFirstBootRecord(volumeID string, updater func(*types.FirstBootRecord) (*types.FirstBootRecord, error)) error {
+	if volumeID == "" {
+		return errors.New("Volume ID cannot be empty")
+	}
+	key := badgerFirstBootPrefix + volumeID
+	return c.update(func(txn *badger.Txn) error {
+		var current *types.FirstBootRecord
+		if _, err := badgerGet(txn, key, &current); err != nil {
+			return err
+		}
+		record, err := updater(current)
+		switch {
+		case err != nil:
+			return err
+		case record == nil && current == nil:
+			return nil
+		case record == nil:
+			return txn.Delete([]byte(key))
+		}
+		record.VolumeID = volumeID
+		return badgerPut(txn, key, record)
+	})
+}
+
+// GetImagePullJob implements GetImagePullJob method of ImagePullStore interface
+func (c *badgerClient) GetImagePullJob(imageName string) (*types.ImagePullJob, error) {
+	if imageName == "" {
+		return nil, errors.New("Image name cannot be empty")
+	}
+	var job *types.ImagePullJob
+	if err := c.db.View(func(txn *badger.Txn) error {
+		_, err := badgerGet(txn, badgerImagePullPrefix+imageName, &job)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// SaveImagePullJob implements SaveImagePullJob method of ImagePullStore interface
+func (c *badgerClient) SaveImagePullJob(imageName string, updater func(*types.ImagePullJob) (*types.ImagePullJob, error)) error {
+	return c.saveImagePullJobAs(nil, imageName, updater)
+}
+
+// saveImagePullJobAs implements saveImagePullJobAs method of auditedBackend interface
+func (c *badgerClient) saveImagePullJobAs(origin *auditOrigin, imageName string, updater func(*types.ImagePullJob) (*types.ImagePullJob, error)) error {
+	if imageName == "" {
+		return errors.New("Image name cannot be empty")
+	}
+	updater, audit := c.watchers.auditImagePullJob(origin, imageName, updater)
+	key := badgerImagePullPrefix + imageName
+	return audit(c.update(func(txn *badger.Txn) error {
+		var current *types.ImagePullJob
+		if _, err := badgerGet(txn, key, &current); err != nil {
+			return err
+		}
+		job, err := updater(current)
+		switch {
+		case err != nil:
+			return err
+		case job == nil && current == nil:
+			return nil
+		case job == nil:
+			return txn.Delete([]byte(key))
+		}
+		job.ImageName = imageName
+		return badgerPut(txn, key, job)
+	}))
+}
+
+// ListImagePullJobs implements ListImagePullJobs method of ImagePullStore interface
+func (c *badgerClient) ListImagePullJobs() ([]*types.ImagePullJob, error) {
+	var jobs []*types.ImagePullJob
+	if err := c.db.View(func(txn *badger.Txn) error {
+		return badgerList(txn, badgerImagePullPrefix, func(key string, value []byte) error {
+			var job *types.ImagePullJob
+			if err := json.Unmarshal(value, &job); err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// isEmpty returns true if the database contains no records
+func (c *badgerClient) isEmpty() (bool, error) {
+	empty := true
+	err := c.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if string(it.Item().Key()) != badgerSchemaVersionKey {
+				empty = false
+				break
+			}
+		}
+		return nil
+	})
+	return empty, err
+}
+
+// ErrEncryptedMetadata is returned by MigrateBoltToBadger if the bolt
+// database contains encrypted records.
+var ErrEncryptedMetadata = errors.New("the bolt metadata database contains encrypted records, which can't be migrated as the badger backend doesn't support the encryption")
+
+// BadgerMigrationStats holds the numbers of the records copied by
+// MigrateBoltToBadger
+type BadgerMigrationStats struct {
+	Sandboxes         int
+	Containers        int
+	StartRecords      int
+	SandboxTombstones int
+	SandboxEvents     int
+	FirstBootRecords  int
+	ImagePullJobs     int
+}
+
+// MigrateBoltToBadger copies all the records from the bolt metadata
+// database at boltPath to a new Badger database in badgerDir, which
+// must either not exist or contain no records. The bolt database is
+// opened read-only and must not be in use, so Virtlet must be stopped
+// during the migration. As the badger backend doesn't support the
+// encryption, the migration is refused if the bolt database contains
+// encrypted records, so that they're never stored in plaintext. The
+// start records and the pod sandbox events get new IDs, preserving
+// their order, and the revisions of the pod sandboxes and containers
+// start anew. If the migration fails, the newly created Badger
+// database is removed.
+func MigrateBoltToBadger(boltPath, badgerDir string) (stats *BadgerMigrationStats, err error) {
+	src, err := NewReadOnlyStore(boltPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	var encrypted bool
+	if err := src.(*boltClient).db.View(func(tx *bolt.Tx) error {
+		var err error
+		encrypted, err = hasEncryptedRecords(tx)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	if encrypted {
+		return nil, ErrEncryptedMetadata
+	}
+
+	var created bool
+	if _, err := os.Stat(badgerDir); os.IsNotExist(err) {
+		created = true
+	}
+	dst, err := newBadgerClient(badgerDir)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil && created {
+			os.RemoveAll(badgerDir)
+		}
+	}()
+	switch empty, err := dst.isEmpty(); {
+	case err != nil:
+		return nil, err
+	case !empty:
+		return nil, fmt.Errorf("the badger database at %q is not empty", badgerDir)
+	}
+	return copyBoltToBadger(src.(*boltClient), dst)
+}
+
+func copyBoltToBadger(src *boltClient, dst *badgerClient) (*BadgerMigrationStats, error) {
+	var stats BadgerMigrationStats
+	sandboxes, err := src.ListPodSandboxes(nil)
+	if err != nil {
+		return nil, err
+	}
+	var sandboxInfos []*types.PodSandboxInfo
+	for _, sandbox := range sandboxes {
+		psi, err := sandbox.Retrieve()
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving pod sandbox %q: %v", sandbox.GetID(), err)
+		}
+		if psi != nil {
+			sandboxInfos = append(sandboxInfos, psi)
+		}
+	}
+	containerInfos, err := src.ListContainerInfos()
+	if err != nil {
+		return nil, err
+	}
+	if err := dst.Update(func(tx Tx) error {
+		for _, psi := range sandboxInfos {
+			psi := psi
+			if err := tx.SavePodSandbox(psi.PodID, func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+				return psi, nil
+			}); err != nil {
+				return err
+			}
+		}
+		for _, ci := range containerInfos {
+			ci := ci
+			if err := tx.SaveContainer(ci.Id, func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+				return ci, nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("error copying pod sandboxes and containers: %v", err)
+	}
+	stats.Sandboxes, stats.Containers = len(sandboxInfos), len(containerInfos)
+
+	records, err := src.ListStartRecords("", "")
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if err := dst.AddStartRecord(record); err != nil {
+			return nil, fmt.Errorf("error copying start record: %v", err)
+		}
+	}
+	stats.StartRecords = len(records)
+
+	tombstones, err := src.ListSandboxTombstones()
+	if err != nil {
+		return nil, err
+	}
+	for _, tombstone := range tombstones {
+		if err := dst.AddSandboxTombstone(tombstone); err != nil {
+			return nil, fmt.Errorf("error copying sandbox tombstone: %v", err)
+		}
+	}
+	stats.SandboxTombstones = len(tombstones)
+
+	events, err := src.ListSandboxEvents("")
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		if err := dst.AddSandboxEvent(event); err != nil {
+			return nil, fmt.Errorf("error copying sandbox event: %v", err)
+		}
+	}
+	stats.SandboxEvents = len(events)
+
+	firstBootRecords, err := src.listFirstBootRecords()
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range firstBootRecords {
+		record := record
+		if err := dst.SaveFirstBootRecord(record.VolumeID, func(*types.FirstBootRecord) (*types.FirstBootRecord, error) {
+			return record, nil
+		}); err != nil {
+			return nil, fmt.Errorf("error copying first boot record: %v", err)
+		}
+	}
+	stats.FirstBootRecords = len(firstBootRecords)
+
+	jobs, err := src.ListImagePullJobs()
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		job := job
+		if err := dst.SaveImagePullJob(job.ImageName, func(*types.ImagePullJob) (*types.ImagePullJob, error) {
+			return job, nil
+		}); err != nil {
+			return nil, fmt.Errorf("error copying image pull job: %v", err)
+		}
+	}
+	stats.ImagePullJobs = len(jobs)
+
+	return &stats, nil
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func withBadgerTestDir(t *testing.T, toCall func(dir string)) {
+	dir, err := ioutil.TempDir("", "badger-test-")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(dir)
+	toCall(dir)
+}
+
+func TestBadgerStore(t *testing.T) {
+	withBadgerTestDir(t, func(dir string) {
+		n := 0
+		var stores []Store
+		oldNewTestStore := newTestStore
+		newTestStore = func() (Store, error) {
+			n++
+			store, err := NewBadgerStore(filepath.Join(dir, fmt.Sprintf("virtlet-%d", n)))
+			if err == nil {
+				stores = append(stores, store)
+			}
+			return store, err
+		}
+		defer func() {
+			newTestStore = oldNewTestStore
+			for _, store := range stores {
+				store.Close()
+			}
+		}()
+
+		// the tests that use golden data are not run here
+		// as they would need separate data files
+		for _, tc := range []struct {
+			name string
+			test func(t *testing.T)
+		}{
+			{"SetGetContainerInfo", TestSetGetContainerInfo},
+			{"ListContainerInfos", TestListContainerInfos},
+			{"GetImagesInUse", TestGetImagesInUse},
+			{"RemoveContainer", TestRemoveContainer},
+			{"FirstBootRecords", TestFirstBootRecords},
+			{"ImagePullJobs", TestImagePullJobs},
+			{"RemovePodSandbox", TestRemovePodSandbox},
+			{"SandboxRemovalPolicy", TestSandboxRemovalPolicy},
+			{"Retrieve", TestRetrieve},
+			{"SetGetPodSandboxStatus", TestSetGetPodSandboxStatus},
+			{"ListPodSandbox", TestListPodSandbox},
+			{"PodSandboxLabelIndex", TestPodSandboxLabelIndex},
+			{"ListPages", TestListPages},
+			{"StartRecords", TestStartRecords},
+			{"SandboxTombstones", TestSandboxTombstones},
+			{"SandboxEvents", TestSandboxEvents},
+			{"Update", TestUpdate},
+			{"Watch", TestWatch},
+			{"Revisions", TestRevisions},
+		} {
+			t.Run(tc.name, tc.test)
+		}
+	})
+}
+
+func TestBadgerSchemaVersion(t *testing.T) {
+	withBadgerTestDir(t, func(dir string) {
+		store, err := NewBadgerStore(dir)
+		if err != nil {
+			t.Fatalf("NewBadgerStore(): %v", err)
+		}
+		store.Close()
+
+		// reopening the database works
+		store, err = NewBadgerStore(dir)
+		if err != nil {
+			t.Fatalf("NewBadgerStore() on an existing database: %v", err)
+		}
+		if err := store.(*badgerClient).update(func(txn *badger.Txn) error {
+			return badgerPut(txn, badgerSchemaVersionKey, badgerSchemaVersion+1)
+		}); err != nil {
+			t.Fatalf("error setting the schema version: %v", err)
+		}
+		store.Close()
+
+		if _, err := NewBadgerStore(dir); err == nil {
+			t.Errorf("NewBadgerStore() didn't fail for a database with a newer schema")
+		} else if !strings.Contains(err.Error(), "newer than the supported") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestMigrateBoltToBadger(t *testing.T) {
+	withBadgerTestDir(t, func(dir string) {
+		boltPath := filepath.Join(dir, "virtlet.db")
+		badgerDir := filepath.Join(dir, "badger")
+		src, err := NewStore(boltPath)
+		if err != nil {
+			t.Fatalf("NewStore(): %v", err)
+		}
+		for _, podID := range []string{"pod1", "pod2"} {
+			if err := src.PodSandbox(podID).Save(func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+				return &types.PodSandboxInfo{
+					Config: &types.PodSandboxConfig{Name: podID + "-name"},
+					State:  types.PodSandboxState_SANDBOX_READY,
+				}, nil
+			}); err != nil {
+				t.Fatalf("PodSandbox(%q).Save(): %v", podID, err)
+			}
+		}
+		for n, podID := range []string{"pod1", "pod1", "pod2"} {
+			containerID := fmt.Sprintf("container%d", n+1)
+			if err := src.Container(containerID).Save(func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+				return &types.ContainerInfo{
+					Name: containerID + "-name",
+					Config: types.VMConfig{
+						PodSandboxID: podID,
+						Image:        fmt.Sprintf("image%d", n+1),
+					},
+				}, nil
+			}); err != nil {
+				t.Fatalf("Container(%q).Save(): %v", containerID, err)
+			}
+		}
+		for _, pod := range []string{"foo", "bar"} {
+			if err := src.AddStartRecord(&types.VMStartRecord{PodNamespace: "default", PodName: pod}); err != nil {
+				t.Fatalf("AddStartRecord(): %v", err)
+			}
+		}
+		if err := src.AddSandboxTombstone(&types.SandboxTombstone{PodSandboxID: "pod0", DeletedAt: 42}); err != nil {
+			t.Fatalf("AddSandboxTombstone(): %v", err)
+		}
+		if err := src.AddSandboxEvent(&types.SandboxEvent{PodSandboxID: "pod1", Type: types.SandboxEventCreated}); err != nil {
+			t.Fatalf("AddSandboxEvent(): %v", err)
+		}
+		if err := src.SaveFirstBootRecord("vol1", func(*types.FirstBootRecord) (*types.FirstBootRecord, error) {
+			return &types.FirstBootRecord{ImageDigest: "sha256:abc"}, nil
+		}); err != nil {
+			t.Fatalf("SaveFirstBootRecord(): %v", err)
+		}
+		if err := src.SaveImagePullJob("example.com/foo.qcow2", func(*types.ImagePullJob) (*types.ImagePullJob, error) {
+			return &types.ImagePullJob{BytesTotal: 1048576}, nil
+		}); err != nil {
+			t.Fatalf("SaveImagePullJob(): %v", err)
+		}
+		src.Close()
+
+		stats, err := MigrateBoltToBadger(boltPath, badgerDir)
+		if err != nil {
+			t.Fatalf("MigrateBoltToBadger(): %v", err)
+		}
+		expectedStats := &BadgerMigrationStats{
+			Sandboxes:         2,
+			Containers:        3,
+			StartRecords:      2,
+			SandboxTombstones: 1,
+			SandboxEvents:     1,
+			FirstBootRecords:  1,
+			ImagePullJobs:     1,
+		}
+		if !reflect.DeepEqual(stats, expectedStats) {
+			t.Errorf("bad migration stats: %#v instead of %#v", stats, expectedStats)
+		}
+
+		// the target database must be empty
+		if _, err := MigrateBoltToBadger(boltPath, badgerDir); err == nil {
+			t.Errorf("MigrateBoltToBadger() didn't fail for a non-empty database")
+		} else if !strings.Contains(err.Error(), "not empty") {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		dst, err := NewBadgerStore(badgerDir)
+		if err != nil {
+			t.Fatalf("NewBadgerStore(): %v", err)
+		}
+		defer dst.Close()
+		psi, err := dst.PodSandbox("pod1").Retrieve()
+		if err != nil {
+			t.Fatalf("PodSandbox().Retrieve(): %v", err)
+		}
+		if psi.PodID != "pod1" || psi.Config.Name != "pod1-name" {
+			t.Errorf("bad sandbox info: %#v", psi)
+		}
+		containers, err := dst.ListPodContainers("pod1")
+		if err != nil {
+			t.Fatalf("ListPodContainers(): %v", err)
+		}
+		if len(containers) != 2 || containers[0].GetID() != "container1" || containers[1].GetID() != "container2" {
+			t.Errorf("bad container list: %#v", containers)
+		}
+		images, err := dst.ImagesInUse()
+		if err != nil {
+			t.Fatalf("ImagesInUse(): %v", err)
+		}
+		expectedImages := map[string]bool{"image1": true, "image2": true, "image3": true}
+		if !reflect.DeepEqual(images, expectedImages) {
+			t.Errorf("bad images in use: %#v instead of %#v", images, expectedImages)
+		}
+		expectedIDs := []string{"1:default/foo:", "2:default/bar:"}
+		if ids := startRecordIDs(t, dst, "", ""); !reflect.DeepEqual(ids, expectedIDs) {
+			t.Errorf("bad start records: %#v instead of %#v", ids, expectedIDs)
+		}
+		if tombstones, err := dst.ListSandboxTombstones(); err != nil {
+			t.Errorf("ListSandboxTombstones(): %v", err)
+		} else if len(tombstones) != 1 || tombstones[0].PodSandboxID != "pod0" {
+			t.Errorf("bad sandbox tombstones: %#v", tombstones)
+		}
+		if events, err := dst.ListSandboxEvents("pod1"); err != nil {
+			t.Errorf("ListSandboxEvents(): %v", err)
+		} else if len(events) != 1 || events[0].Type != types.SandboxEventCreated {
+			t.Errorf("bad sandbox events: %#v", events)
+		}
+		if record, err := dst.GetFirstBootRecord("vol1"); err != nil {
+			t.Errorf("GetFirstBootRecord(): %v", err)
+		} else if record == nil || record.ImageDigest != "sha256:abc" {
+			t.Errorf("bad first boot record: %#v", record)
+		}
+		if job, err := dst.GetImagePullJob("example.com/foo.qcow2"); err != nil {
+			t.Errorf("GetImagePullJob(): %v", err)
+		} else if job == nil || job.BytesTotal != 1048576 {
+			t.Errorf("bad image pull job: %#v", job)
+		}
+	})
+}
+
+func TestMigrateEncryptedBoltToBadger(t *testing.T) {
+	withBadgerTestDir(t, func(dir string) {
+		boltPath := filepath.Join(dir, "virtlet.db")
+		badgerDir := filepath.Join(dir, "badger")
+		src, err := NewEncryptedStore(boltPath, testEncryptionKey)
+		if err != nil {
+			t.Fatalf("NewEncryptedStore(): %v", err)
+		}
+		secret := "ssh-rsa AAAAB3NzaC1yc2E-secret"
+		if err := src.PodSandbox("pod1").Save(func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+			return &types.PodSandboxInfo{
+				Config: &types.PodSandboxConfig{
+					Name:        "pod1-name",
+					Annotations: map[string]string{"VirtletSSHKeys": secret},
+				},
+			}, nil
+		}); err != nil {
+			t.Fatalf("PodSandbox().Save(): %v", err)
+		}
+		src.Close()
+
+		if _, err := MigrateBoltToBadger(boltPath, badgerDir); err != ErrEncryptedMetadata {
+			t.Errorf("bad error from MigrateBoltToBadger() for an encrypted database: %v", err)
+		}
+		if _, err := os.Stat(badgerDir); !os.IsNotExist(err) {
+			t.Errorf("the badger database was created for an encrypted source")
+		}
+		if err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil || fi.IsDir() {
+				return err
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if strings.Contains(string(data), secret) {
+				t.Errorf("plaintext metadata found in %q", path)
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("Walk(): %v", err)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// defaultMaxBatchSize is the maximum number of updates that are
+// committed within a single write transaction.
+const defaultMaxBatchSize = 100
+
+type batchCall struct {
+	fn  func(tx *bolt.Tx) ([]*Event, error)
+	err chan error
+	// origin is the origin of the update for the audit log
+	origin *auditOrigin
+}
+
+// writeBatcher coalesces the concurrent metadata updates into shared
+// write transactions so that a burst of updates (e.g. a lot of pods
+// being started at once) doesn't cost an fsync per update. There's
+// no delay involved: the updates that arrive while a transaction is
+// being committed are committed together by the next transaction.
+// The batches are committed one at a time, so the events are
+// delivered to the watchers in the commit order.
+type writeBatcher struct {
+	db           *bolt.DB
+	watchers     *watchHub
+	metrics      *storeMetrics
+	maxBatchSize int
+
+	// commitLock is held while a batch is being committed
+	commitLock sync.Mutex
+	lock       sync.Mutex
+	pending    []*batchCall
+}
+
+func newWriteBatcher(db *bolt.DB, watchers *watchHub, metrics *storeMetrics, maxBatchSize int) *writeBatcher {
+	return &writeBatcher{db: db, watchers: watchers, metrics: metrics, maxBatchSize: maxBatchSize}
+}
+
+// update runs fn within a write transaction that may be shared with
+// other updates, delivering the events returned by fn to the watchers
+// after the transaction is committed. Same as with bolt's Batch(),
+// fn may be invoked more than once, so it must not have side effects
+// besides the changes made to the database. origin denotes the actor
+// the changes are attributed to in the audit log.
+func (b *writeBatcher) update(origin *auditOrigin, fn func(tx *bolt.Tx) ([]*Event, error)) error {
+	call := &batchCall{fn: fn, err: make(chan error, 1), origin: origin}
+	b.lock.Lock()
+	b.pending = append(b.pending, call)
+	b.lock.Unlock()
+	for {
+		b.commitLock.Lock()
+		if calls := b.takePending(); len(calls) > 0 {
+			b.commit(calls)
+		}
+		b.commitLock.Unlock()
+		select {
+		case err := <-call.err:
+			return err
+		default:
+			// the pending updates didn't fit in a single
+			// batch, our one is still waiting
+		}
+	}
+}
+
+func (b *writeBatcher) takePending() []*batchCall {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	n := len(b.pending)
+	if b.maxBatchSize > 0 && n > b.maxBatchSize {
+		n = b.maxBatchSize
+	}
+	calls := b.pending[:n:n]
+	b.pending = b.pending[n:]
+	return calls
+}
+
+func (b *writeBatcher) commit(calls []*batchCall) {
+	for len(calls) > 0 {
+		failed := -1
+		events := make([][]*Event, len(calls))
+		b.metrics.batchSize.Observe(float64(len(calls)))
+		err := b.metrics.timeTx(txTypeWrite, func() error {
+			return b.db.Update(func(tx *bolt.Tx) error {
+				for i, call := range calls {
+					event, err := call.fn(tx)
+					if err != nil {
+						failed = i
+						return err
+					}
+					events[i] = event
+				}
+				return nil
+			})
+		})
+		if failed < 0 {
+			for i, call := range calls {
+				if err == nil {
+					b.watchers.notifyAll(call.origin, events[i])
+				}
+				call.err <- err
+			}
+			return
+		}
+		// One of the updates has failed, so the whole batch was
+		// rolled back. The failed update is retried on its own
+		// so its error doesn't depend on the other updates, and
+		// then the rest of the batch is retried.
+		b.metrics.batchRetries.Inc()
+		b.commitSingle(calls[failed])
+		calls = append(calls[:failed:failed], calls[failed+1:]...)
+	}
+}
+
+func (b *writeBatcher) commitSingle(call *batchCall) {
+	var events []*Event
+	b.metrics.batchSize.Observe(1)
+	err := b.metrics.timeTx(txTypeWrite, func() error {
+		return b.db.Update(func(tx *bolt.Tx) error {
+			var err error
+			events, err = call.fn(tx)
+			return err
+		})
+	})
+	if err == nil {
+		b.watchers.notifyAll(call.origin, events)
+	}
+	call.err <- err
+}
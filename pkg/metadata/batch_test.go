@@ -0,0 +1,152 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const numConcurrentSaves = 50
+
+func newBatchTestStore(tb testing.TB, maxBatchSize int) (*boltClient, func()) {
+	filename, err := tempfile()
+	if err != nil {
+		tb.Fatalf("tempfile(): %v", err)
+	}
+	db, err := bolt.Open(filename, 0600, nil)
+	if err != nil {
+		tb.Fatalf("bolt.Open(): %v", err)
+	}
+	return newBoltClient(db, maxBatchSize), func() {
+		db.Close()
+		os.Remove(filename)
+	}
+}
+
+func saveBatchTestContainer(store Store, containerID string) error {
+	return store.Container(containerID).Save(func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+		return &types.ContainerInfo{
+			Name:   "vm-" + containerID,
+			Config: types.VMConfig{PodSandboxID: "pod-" + containerID},
+		}, nil
+	})
+}
+
+func TestBatchedSaves(t *testing.T) {
+	for _, maxBatchSize := range []int{1, 7, defaultMaxBatchSize} {
+		t.Run(fmt.Sprintf("max batch size %d", maxBatchSize), func(t *testing.T) {
+			store, cleanup := newBatchTestStore(t, maxBatchSize)
+			defer cleanup()
+			ch, stop := store.Watch()
+			defer stop()
+
+			var wg sync.WaitGroup
+			errs := make([]error, numConcurrentSaves)
+			for i := 0; i < numConcurrentSaves; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					containerID := fmt.Sprintf("container%02d", i)
+					if i%10 != 3 {
+						errs[i] = saveBatchTestContainer(store, containerID)
+						return
+					}
+					// a failed update must not affect the other
+					// updates in the same batch
+					errs[i] = store.Container(containerID).Save(func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+						return nil, errors.New("oops")
+					})
+				}(i)
+			}
+			wg.Wait()
+
+			var expectedIDs []string
+			for i, err := range errs {
+				switch {
+				case i%10 == 3 && err == nil:
+					t.Errorf("Save() didn't fail for container %d", i)
+				case i%10 != 3 && err != nil:
+					t.Errorf("Save() failed for container %d: %v", i, err)
+				case i%10 != 3:
+					expectedIDs = append(expectedIDs, fmt.Sprintf("container%02d", i))
+				}
+			}
+
+			var ids []string
+			if err := ForEachContainer(store, 0, func(cm ContainerMetadata) error {
+				ci, err := cm.Retrieve()
+				if err != nil {
+					return err
+				}
+				if ci.Name != "vm-"+cm.GetID() {
+					t.Errorf("bad name for container %q: %q", cm.GetID(), ci.Name)
+				}
+				ids = append(ids, cm.GetID())
+				return nil
+			}); err != nil {
+				t.Fatalf("ForEachContainer(): %v", err)
+			}
+			sort.Strings(ids)
+			if fmt.Sprint(ids) != fmt.Sprint(expectedIDs) {
+				t.Errorf("bad container ids: %v instead of %v", ids, expectedIDs)
+			}
+
+			events, _ := receiveEvents(ch)
+			if len(events) != len(expectedIDs) {
+				t.Errorf("bad number of events: %d instead of %d", len(events), len(expectedIDs))
+			}
+		})
+	}
+}
+
+// BenchmarkContainerSave compares the throughput of concurrent
+// container updates with and without write batching.
+func BenchmarkContainerSave(b *testing.B) {
+	for _, bc := range []struct {
+		name         string
+		maxBatchSize int
+	}{
+		{"unbatched", 1},
+		{"batched", defaultMaxBatchSize},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			store, cleanup := newBatchTestStore(b, bc.maxBatchSize)
+			defer cleanup()
+			var n int64
+			b.SetParallelism(numConcurrentSaves)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					containerID := fmt.Sprintf("container%d", atomic.AddInt64(&n, 1))
+					if err := saveBatchTestContainer(store, containerID); err != nil {
+						b.Errorf("Save(): %v", err)
+					}
+				}
+			})
+		})
+	}
+}
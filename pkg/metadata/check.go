@@ -0,0 +1,406 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+// The kinds of the problems reported by the metadata check.
+const (
+	// ProblemBadSandboxData means that the pod sandbox record
+	// can't be decrypted or parsed
+	ProblemBadSandboxData = "bad-sandbox-data"
+	// ProblemMissingSandboxData means that the pod sandbox
+	// bucket has no pod sandbox record
+	ProblemMissingSandboxData = "missing-sandbox-data"
+	// ProblemBadContainerData means that the container record
+	// can't be decrypted or parsed
+	ProblemBadContainerData = "bad-container-data"
+	// ProblemIDMismatch means that the id stored in the record
+	// differs from the key of the record
+	ProblemIDMismatch = "id-mismatch"
+	// ProblemDuplicateID means that the record claims the id of
+	// another record
+	ProblemDuplicateID = "duplicate-id"
+	// ProblemOrphanContainer means that the container belongs
+	// to a pod sandbox that doesn't exist
+	ProblemOrphanContainer = "orphan-container"
+	// ProblemMissingContainerRef means that the container is not
+	// listed in its pod sandbox
+	ProblemMissingContainerRef = "missing-container-ref"
+	// ProblemDanglingContainerRef means that the pod sandbox lists
+	// a container that doesn't exist
+	ProblemDanglingContainerRef = "dangling-container-ref"
+	// ProblemMisplacedContainerRef means that the pod sandbox lists
+	// a container that belongs to another pod sandbox
+	ProblemMisplacedContainerRef = "misplaced-container-ref"
+	// ProblemDanglingLabelRef means that the pod sandbox label
+	// index refers to a pod sandbox that doesn't exist
+	ProblemDanglingLabelRef = "dangling-label-ref"
+	// ProblemMissingImage means that the image used by the
+	// container is not in the image store. Such problems can't
+	// be repaired automatically.
+	ProblemMissingImage = "missing-image"
+)
+
+// CheckProblem describes an inconsistency found in the metadata.
+type CheckProblem struct {
+	// Kind is the kind of the problem
+	Kind string `json:"kind"`
+	// ID is the id of the pod sandbox or the container
+	ID string `json:"id"`
+	// Message describes the problem
+	Message string `json:"message"`
+	// Repaired is true if the problem was fixed
+	Repaired bool `json:"repaired"`
+}
+
+// CheckReport contains the results of the metadata check.
+type CheckReport struct {
+	// Sandboxes is the number of the valid pod sandboxes
+	Sandboxes int `json:"sandboxes"`
+	// Containers is the number of the valid containers
+	Containers int `json:"containers"`
+	// Problems lists the problems found
+	Problems []CheckProblem `json:"problems"`
+}
+
+// Unrepaired returns the number of the problems that weren't fixed.
+func (r *CheckReport) Unrepaired() int {
+	n := 0
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *CheckReport) add(kind, id string, repaired bool, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, CheckProblem{
+		Kind:     kind,
+		ID:       id,
+		Message:  fmt.Sprintf(format, args...),
+		Repaired: repaired,
+	})
+}
+
+// ImageChecker returns true if the image with the specified name is
+// present in the image store.
+type ImageChecker func(name string) (bool, error)
+
+// Checker is implemented by the stores that can check the consistency
+// of the pod sandbox and container records.
+type Checker interface {
+	// Check checks the pod sandbox and container records. If
+	// imageExists is not nil, it's used to verify that the images
+	// used by the containers are present. If repair is true, the
+	// broken records are fixed or removed.
+	Check(imageExists ImageChecker, repair bool) (*CheckReport, error)
+}
+
+var _ Checker = &boltClient{}
+
+// Check implements Check method of Checker interface. The records are
+// checked and repaired within a single transaction, but the images are
+// checked after it's finished so the image store is not accessed while
+// the transaction is open.
+func (b *boltClient) Check(imageExists ImageChecker, repair bool) (*CheckReport, error) {
+	var report *CheckReport
+	var images map[string][]string
+	run := func(tx *bolt.Tx) error {
+		var err error
+		report, images, err = checkRecords(tx, b.cipher, repair)
+		return err
+	}
+	var err error
+	if repair {
+		// The watchers are not notified about the repairs as the
+		// broken records can't be represented by the events.
+		err = b.batch.update(nil, func(tx *bolt.Tx) ([]*Event, error) {
+			return nil, run(tx)
+		})
+	} else {
+		err = b.db.View(run)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if imageExists == nil {
+		return report, nil
+	}
+
+	var imageNames []string
+	for name := range images {
+		imageNames = append(imageNames, name)
+	}
+	sort.Strings(imageNames)
+	for _, name := range imageNames {
+		found, err := imageExists(name)
+		if found && err == nil {
+			continue
+		}
+		for _, containerID := range images[name] {
+			if err != nil {
+				report.add(ProblemMissingImage, containerID, false, "can't check image %q: %v", name, err)
+			} else {
+				report.add(ProblemMissingImage, containerID, false, "image %q is not in the image store", name)
+			}
+		}
+	}
+	return report, nil
+}
+
+// checkRecords checks the records within the transaction, fixing them
+// if repair is true. Besides the report, it returns the map of the
+// images used by the valid containers to the container ids.
+func checkRecords(tx *bolt.Tx, c *valueCipher, repair bool) (*CheckReport, map[string][]string, error) {
+	report := &CheckReport{Problems: []CheckProblem{}}
+	images := make(map[string][]string)
+
+	var sandboxIDs []string
+	knownSandboxes := make(map[string]bool)
+	cur := tx.Cursor()
+	for k, _ := cur.Seek(sandboxKeyPrefix); k != nil && bytes.HasPrefix(k, sandboxKeyPrefix); k, _ = cur.Next() {
+		id := string(k[len(sandboxKeyPrefix):])
+		sandboxIDs = append(sandboxIDs, id)
+		knownSandboxes[id] = true
+	}
+
+	sandboxes := make(map[string]bool)
+	sandboxRefs := make(map[string]map[string]bool)
+	for _, id := range sandboxIDs {
+		bucket := tx.Bucket(sandboxKey(id))
+		if bucket == nil {
+			continue
+		}
+		refs := make(map[string]bool)
+		bc := bucket.Cursor()
+		for k, _ := bc.Seek(containerKeyPrefix); k != nil && bytes.HasPrefix(k, containerKeyPrefix); k, _ = bc.Next() {
+			refs[string(k[len(containerKeyPrefix):])] = true
+		}
+
+		var psi *types.PodSandboxInfo
+		kind := ""
+		var err error
+		if bucket.Get(sandboxDataBucket) == nil {
+			kind, err = ProblemMissingSandboxData, errors.New("no pod sandbox record")
+		} else if err = retrieveSandboxFromDB(bucket, c, &psi); err == nil && psi == nil {
+			err = errors.New("null pod sandbox record")
+		}
+		if err != nil {
+			if kind == "" {
+				kind = ProblemBadSandboxData
+			}
+			if repair {
+				if err := tx.DeleteBucket(sandboxKey(id)); err != nil {
+					return nil, nil, err
+				}
+			}
+			report.add(kind, id, repair, "bad pod sandbox: %v", err)
+			continue
+		}
+
+		sandboxes[id] = true
+		sandboxRefs[id] = refs
+		report.Sandboxes++
+		if psi.PodID == "" || psi.PodID == id {
+			continue
+		}
+		if knownSandboxes[psi.PodID] {
+			report.add(ProblemDuplicateID, id, repair, "the pod sandbox record claims the id of pod sandbox %q", psi.PodID)
+		} else {
+			report.add(ProblemIDMismatch, id, repair, "the pod sandbox record has id %q", psi.PodID)
+		}
+		if repair {
+			psi.PodID = id
+			if err := saveSandboxToDB(bucket, c, psi); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	// the bucket can't be modified while iterating over it
+	var containerIDs []string
+	values := make(map[string][]byte)
+	containersBkt := tx.Bucket(containersBucket)
+	if containersBkt != nil {
+		if err := containersBkt.ForEach(func(k, v []byte) error {
+			if v != nil {
+				containerIDs = append(containerIDs, string(k))
+				values[string(k)] = append([]byte(nil), v...)
+			}
+			return nil
+		}); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	removeContainer := func(id string) error {
+		for podID, refs := range sandboxRefs {
+			if !refs[id] {
+				continue
+			}
+			if err := tx.Bucket(sandboxKey(podID)).Delete(containerKey(id)); err != nil {
+				return err
+			}
+			delete(refs, id)
+		}
+		return containersBkt.Delete([]byte(id))
+	}
+
+	containerSandboxes := make(map[string]string)
+	for _, id := range containerIDs {
+		var ci *types.ContainerInfo
+		data, err := c.open(values[id])
+		if err == nil {
+			if err = json.Unmarshal(data, &ci); err == nil && ci == nil {
+				err = errors.New("null container record")
+			}
+		}
+		if err != nil {
+			if repair {
+				if err := removeContainer(id); err != nil {
+					return nil, nil, err
+				}
+			}
+			report.add(ProblemBadContainerData, id, repair, "bad container: %v", err)
+			continue
+		}
+
+		podID := ci.Config.PodSandboxID
+		if !sandboxes[podID] {
+			if repair {
+				if err := removeContainer(id); err != nil {
+					return nil, nil, err
+				}
+			}
+			report.add(ProblemOrphanContainer, id, repair, "the container belongs to pod sandbox %q that's missing or broken", podID)
+			continue
+		}
+
+		containerSandboxes[id] = podID
+		report.Containers++
+		if ci.Id != "" && ci.Id != id {
+			if _, found := values[ci.Id]; found {
+				report.add(ProblemDuplicateID, id, repair, "the container record claims the id of container %q", ci.Id)
+			} else {
+				report.add(ProblemIDMismatch, id, repair, "the container record has id %q", ci.Id)
+			}
+			if repair {
+				ci.Id = id
+				data, err := json.Marshal(ci)
+				if err == nil {
+					data, err = c.seal(data)
+				}
+				if err == nil {
+					err = containersBkt.Put([]byte(id), data)
+				}
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+		if !sandboxRefs[podID][id] {
+			if repair {
+				if err := tx.Bucket(sandboxKey(podID)).Put(containerKey(id), []byte{}); err != nil {
+					return nil, nil, err
+				}
+				sandboxRefs[podID][id] = true
+			}
+			report.add(ProblemMissingContainerRef, id, repair, "the container is not listed in pod sandbox %q", podID)
+		}
+
+		if ci.Config.Image != "" {
+			images[ci.Config.Image] = append(images[ci.Config.Image], id)
+		}
+		if ci.Config.ParsedAnnotations != nil {
+			for _, image := range ci.Config.ParsedAnnotations.CDROMImages {
+				images[image] = append(images[image], id)
+			}
+		}
+	}
+
+	for _, podID := range sandboxIDs {
+		refs := sandboxRefs[podID]
+		if refs == nil {
+			continue
+		}
+		var refIDs []string
+		for id := range refs {
+			refIDs = append(refIDs, id)
+		}
+		sort.Strings(refIDs)
+		for _, id := range refIDs {
+			kind := ""
+			if _, found := values[id]; !found {
+				kind = ProblemDanglingContainerRef
+			} else if owner, valid := containerSandboxes[id]; valid && owner != podID {
+				kind = ProblemMisplacedContainerRef
+			} else {
+				// the broken and orphaned containers are
+				// reported above
+				continue
+			}
+			if repair {
+				if err := tx.Bucket(sandboxKey(podID)).Delete(containerKey(id)); err != nil {
+					return nil, nil, err
+				}
+			}
+			if kind == ProblemDanglingContainerRef {
+				report.add(kind, podID, repair, "the pod sandbox lists container %q that doesn't exist", id)
+			} else {
+				report.add(kind, podID, repair, "the pod sandbox lists container %q that belongs to pod sandbox %q", id, containerSandboxes[id])
+			}
+		}
+	}
+
+	if labelsBkt := tx.Bucket(sandboxLabelsBucket); labelsBkt != nil {
+		var danglingKeys [][]byte
+		if err := labelsBkt.ForEach(func(k, v []byte) error {
+			if !sandboxes[string(v)] {
+				danglingKeys = append(danglingKeys, append([]byte(nil), k...))
+				// the labels of the broken pod sandboxes
+				// are removed silently along with them
+				if !knownSandboxes[string(v)] {
+					report.add(ProblemDanglingLabelRef, string(v), repair, "the label index refers to a pod sandbox that doesn't exist")
+				}
+			}
+			return nil
+		}); err != nil {
+			return nil, nil, err
+		}
+		if repair {
+			for _, k := range danglingKeys {
+				if err := labelsBkt.Delete(k); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+
+	return report, images, nil
+}
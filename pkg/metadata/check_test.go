@@ -0,0 +1,164 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/fake"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func checkProblemList(t *testing.T, report *CheckReport, repaired bool) []string {
+	var r []string
+	for _, p := range report.Problems {
+		if p.Repaired != repaired {
+			t.Errorf("bad repaired flag for the problem %#v", p)
+		}
+		r = append(r, p.Kind+" "+p.ID)
+	}
+	sort.Strings(r)
+	return r
+}
+
+func TestCheckMetadata(t *testing.T) {
+	sandboxConfigs := fake.GetSandboxes(2)
+	containerConfigs := fake.GetContainersConfig(sandboxConfigs)
+	store := setUpTestStore(t, sandboxConfigs, containerConfigs, nil)
+	defer store.Close()
+	podID0, podID1 := sandboxConfigs[0].Uid, sandboxConfigs[1].Uid
+	containerID0, containerID1 := containerConfigs[0].ContainerID, containerConfigs[1].ContainerID
+
+	report, err := store.(Checker).Check(nil, false)
+	if err != nil {
+		t.Fatalf("Check(): %v", err)
+	}
+	if report.Sandboxes != 2 || report.Containers != 2 || len(report.Problems) != 0 {
+		t.Errorf("bad report for the consistent metadata: %#v", report)
+	}
+
+	if err := store.(*boltClient).db.Update(func(tx *bolt.Tx) error {
+		containers := tx.Bucket(containersBucket)
+		var ci *types.ContainerInfo
+		if err := json.Unmarshal(containers.Get([]byte(containerID0)), &ci); err != nil {
+			return err
+		}
+		ci.Id = containerID1
+		data, err := json.Marshal(ci)
+		if err != nil {
+			return err
+		}
+		if err := containers.Put([]byte(containerID0), data); err != nil {
+			return err
+		}
+		if err := containers.Put([]byte("bad-container"), []byte("{")); err != nil {
+			return err
+		}
+		if err := containers.Put([]byte("orphan"), []byte(`{"Id":"orphan","Config":{"PodSandboxID":"nonexistent"}}`)); err != nil {
+			return err
+		}
+		broken, err := tx.CreateBucket(sandboxKey("broken"))
+		if err != nil {
+			return err
+		}
+		if err := broken.Put(sandboxDataBucket, []byte("{")); err != nil {
+			return err
+		}
+		if err := tx.Bucket(sandboxKey(podID0)).Put(containerKey("ghost"), []byte{}); err != nil {
+			return err
+		}
+		if err := tx.Bucket(sandboxKey(podID1)).Delete(containerKey(containerID1)); err != nil {
+			return err
+		}
+		labels, err := tx.CreateBucketIfNotExists(sandboxLabelsBucket)
+		if err != nil {
+			return err
+		}
+		return labels.Put(sandboxLabelKey("foo", "bar", "gone"), []byte("gone"))
+	}); err != nil {
+		t.Fatalf("Error corrupting the metadata: %v", err)
+	}
+
+	var checkedImages []string
+	report, err = store.(Checker).Check(func(name string) (bool, error) {
+		checkedImages = append(checkedImages, name)
+		return false, nil
+	}, false)
+	if err != nil {
+		t.Fatalf("Check(): %v", err)
+	}
+	if report.Sandboxes != 2 || report.Containers != 2 {
+		t.Errorf("bad counts in the report: %#v", report)
+	}
+	if !reflect.DeepEqual(checkedImages, []string{"testImage"}) {
+		t.Errorf("bad list of the checked images: %#v", checkedImages)
+	}
+	expectedProblems := []string{
+		ProblemBadContainerData + " bad-container",
+		ProblemBadSandboxData + " broken",
+		ProblemDanglingContainerRef + " " + podID0,
+		ProblemDanglingLabelRef + " gone",
+		ProblemDuplicateID + " " + containerID0,
+		ProblemMissingContainerRef + " " + containerID1,
+		ProblemMissingImage + " " + containerID0,
+		ProblemMissingImage + " " + containerID1,
+		ProblemOrphanContainer + " orphan",
+	}
+	sort.Strings(expectedProblems)
+	if problems := checkProblemList(t, report, false); !reflect.DeepEqual(problems, expectedProblems) {
+		t.Errorf("bad problem list:\n%#v\ninstead of\n%#v", problems, expectedProblems)
+	}
+	if report.Unrepaired() != len(expectedProblems) {
+		t.Errorf("bad number of the unrepaired problems: %d", report.Unrepaired())
+	}
+
+	report, err = store.(Checker).Check(nil, true)
+	if err != nil {
+		t.Fatalf("Check(): %v", err)
+	}
+	// the missing images are not checked this time
+	if problems := checkProblemList(t, report, true); len(problems) != len(expectedProblems)-2 || report.Unrepaired() != 0 {
+		t.Errorf("bad report after the repair: %#v", report)
+	}
+
+	report, err = store.(Checker).Check(nil, false)
+	if err != nil {
+		t.Fatalf("Check(): %v", err)
+	}
+	if report.Sandboxes != 2 || report.Containers != 2 || len(report.Problems) != 0 {
+		t.Errorf("bad report for the repaired metadata: %#v", report)
+	}
+	for _, podID := range []string{podID0, podID1} {
+		containers, err := store.ListPodContainers(podID)
+		if err != nil {
+			t.Fatalf("ListPodContainers(): %v", err)
+		}
+		if len(containers) != 1 {
+			t.Errorf("bad number of containers for the pod sandbox %q after the repair: %d", podID, len(containers))
+		}
+	}
+	if ci, err := store.Container(containerID0).Retrieve(); err != nil {
+		t.Errorf("Container().Retrieve(): %v", err)
+	} else if ci == nil || ci.Id != containerID0 {
+		t.Errorf("the container id is not fixed: %#v", ci)
+	}
+}
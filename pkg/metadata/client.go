@@ -17,24 +17,87 @@ limitations under the License.
 package metadata
 
 import (
-	"github.com/boltdb/bolt"
+	bolt "go.etcd.io/bbolt"
 )
 
 type boltClient struct {
-	db *bolt.DB
+	db       *bolt.DB
+	watchers *watchHub
+	batch    *writeBatcher
+	cipher   *valueCipher
+	metrics  *storeMetrics
+	// removalPolicy is the policy for the containers of the
+	// removed pod sandboxes, empty value meaning cascade
+	removalPolicy SandboxRemovalPolicy
 }
 
-// NewStore is a factory function for Store interface
+func newBoltClient(db *bolt.DB, maxBatchSize int) *boltClient {
+	watchers := newWatchHub()
+	metrics := newStoreMetrics()
+	return &boltClient{
+		db:       db,
+		watchers: watchers,
+		batch:    newWriteBatcher(db, watchers, metrics, maxBatchSize),
+		metrics:  metrics,
+	}
+}
+
+// NewStore is a factory function for Store interface that returns
+// a Store which keeps the metadata in a local bolt database file.
+// If a snapshot was staged for restore, it replaces the database
+// first. The database is upgraded to the current schema version
+// if needed.
 func NewStore(path string) (Store, error) {
+	return NewEncryptedStore(path, nil)
+}
+
+// NewEncryptedStore is the same as NewStore, but it also encrypts
+// the pod sandbox and container records using the specified key.
+// The records stored without encryption are encrypted when the
+// store is opened. If the key is nil, the records are stored as
+// is, and the store can't be opened if it contains any encrypted
+// records.
+func NewEncryptedStore(path string, key []byte) (Store, error) {
+	var c *valueCipher
+	if key != nil {
+		var err error
+		if c, err = newValueCipher(key); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyPendingRestore(path); err != nil {
+		return nil, err
+	}
 	db, err := bolt.Open(path, 0600, nil)
 	if err != nil {
 		return nil, err
 	}
+	if _, err := migrateDB(db, c, false); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return sealRecords(tx, c)
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	client := &boltClient{db: db}
+	client := newBoltClient(db, defaultMaxBatchSize)
+	client.cipher = c
 	return client, nil
 }
 
+// Watch implements Watch method of WatchStore interface
+func (b boltClient) Watch() (<-chan Event, func()) {
+	return b.watchers.watch()
+}
+
+// SetAuditLog implements SetAuditLog method of AuditStore interface
+func (b *boltClient) SetAuditLog(log *AuditLog) {
+	b.watchers.setAuditLog(log)
+}
+
 // Close releases all database resources
 func (b boltClient) Close() error {
 	return b.db.Close()
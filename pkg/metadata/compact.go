@@ -0,0 +1,182 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// compactSuffix is the suffix of the marker file requesting
+	// the compaction of the database upon the next Virtlet start
+	compactSuffix = ".compact"
+	// compactTmpSuffix is the suffix of the file the database
+	// is copied to during the compaction
+	compactTmpSuffix = ".compact.tmp"
+	// compactOpenTimeout is the time to wait for the database
+	// lock when the database is opened by CompactDatabase
+	compactOpenTimeout = 10 * time.Second
+)
+
+// RequestCompaction makes the database at dbPath be compacted upon
+// the next Virtlet start regardless of the amount of free space in
+// it. The live database is not touched as it can't be replaced while
+// Virtlet is using it.
+func RequestCompaction(dbPath string) error {
+	if _, err := os.Stat(dbPath); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dbPath+compactSuffix, nil, 0600)
+}
+
+// CompactDatabase compacts the bolt database at dbPath if the
+// compaction was requested using RequestCompaction or if the free
+// pages make up more than threshold percent of the database file.
+// threshold of 0 disables the automatic compaction. The live buckets
+// are copied into a fresh database file which then atomically
+// replaces the original one. If there's a snapshot staged for
+// restore, it replaces the database first. CompactDatabase must be
+// called before the database is opened by the store.
+func CompactDatabase(dbPath string, threshold int) error {
+	if err := applyPendingRestore(dbPath); err != nil {
+		return err
+	}
+	markerPath := dbPath + compactSuffix
+	requested := true
+	if _, err := os.Stat(markerPath); os.IsNotExist(err) {
+		requested = false
+	} else if err != nil {
+		return err
+	}
+	if !requested && threshold <= 0 {
+		return nil
+	}
+	fi, err := os.Stat(dbPath)
+	switch {
+	case os.IsNotExist(err):
+		// nothing to compact
+		return os.RemoveAll(markerPath)
+	case err != nil:
+		return err
+	}
+
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: compactOpenTimeout})
+	if err != nil {
+		return fmt.Errorf("error opening the metadata database %q: %v", dbPath, err)
+	}
+	freePercent, err := freePagePercent(db)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	if !requested && freePercent <= threshold {
+		return db.Close()
+	}
+
+	tmpPath := dbPath + compactTmpSuffix
+	if err := os.RemoveAll(tmpPath); err != nil {
+		db.Close()
+		return err
+	}
+	err = copyDatabase(db, tmpPath)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error compacting the metadata database: %v", err)
+	}
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error replacing the metadata database with the compacted one: %v", err)
+	}
+	if err := os.RemoveAll(markerPath); err != nil {
+		glog.Warningf("Failed to remove the compaction request %q: %v", markerPath, err)
+	}
+
+	newSize := int64(-1)
+	if fi, err := os.Stat(dbPath); err == nil {
+		newSize = fi.Size()
+	}
+	glog.V(1).Infof("Metadata database %q compacted: %d%% free pages, %d bytes before, %d bytes after", dbPath, freePercent, fi.Size(), newSize)
+	return nil
+}
+
+// freePagePercent returns the percentage of the free pages in
+// the database file.
+func freePagePercent(db *bolt.DB) (int, error) {
+	var percent int
+	err := db.View(func(tx *bolt.Tx) error {
+		pageCount := tx.Size() / int64(db.Info().PageSize)
+		if pageCount == 0 {
+			return nil
+		}
+		stats := db.Stats()
+		percent = int(int64(stats.FreePageN+stats.PendingPageN) * 100 / pageCount)
+		return nil
+	})
+	return percent, err
+}
+
+// copyDatabase copies all the buckets of src into a new database
+// at dstPath within a single transaction.
+func copyDatabase(src *bolt.DB, dstPath string) error {
+	dst, err := bolt.Open(dstPath, 0600, nil)
+	if err != nil {
+		return err
+	}
+	err = src.View(func(srcTx *bolt.Tx) error {
+		return dst.Update(func(dstTx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				dstBucket, err := dstTx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(b, dstBucket)
+			})
+		})
+	})
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func copyBucket(src, dst *bolt.Bucket) error {
+	// the buckets are filled sequentially, so the pages
+	// can be packed fully
+	dst.FillPercent = 1
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		nested, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(src.Bucket(k), nested)
+	})
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+// makeFragmentedDB makes a database at dbPath that has a lot of free
+// pages left after removing most of the records, returning its size.
+func makeFragmentedDB(t *testing.T, dbPath string) int64 {
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore(): %v", err)
+	}
+	bigName := strings.Repeat("x", 4096)
+	for i := 0; i < 200; i++ {
+		if err := store.Container(fmt.Sprintf("container-%d", i)).Save(func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
+			return &types.ContainerInfo{
+				Name:   bigName,
+				Config: types.VMConfig{PodSandboxID: testSandboxID},
+			}, nil
+		}); err != nil {
+			t.Fatalf("Container().Save(): %v", err)
+		}
+	}
+	for i := 1; i < 200; i++ {
+		if err := store.Container(fmt.Sprintf("container-%d", i)).Save(func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
+			return nil, nil
+		}); err != nil {
+			t.Fatalf("Container().Save(): %v", err)
+		}
+	}
+	saveTestContainer(t, store, "foobar")
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close(): %v", err)
+	}
+	return fileSize(t, dbPath)
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat(): %v", err)
+	}
+	return fi.Size()
+}
+
+func verifyCompactedDB(t *testing.T, dbPath string) {
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore(): %v", err)
+	}
+	defer store.Close()
+	if name := testContainerName(t, store); name != "foobar" {
+		t.Errorf("bad container name after the compaction: %q", name)
+	}
+	ci, err := store.Container("container-0").Retrieve()
+	switch {
+	case err != nil:
+		t.Errorf("Container().Retrieve(): %v", err)
+	case ci == nil:
+		t.Errorf("container-0 is lost after the compaction")
+	}
+}
+
+func TestCompactDatabase(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		threshold       int
+		request         bool
+		expectCompacted bool
+	}{
+		{
+			name: "no compaction by default",
+		},
+		{
+			name:      "threshold not exceeded",
+			threshold: 100,
+		},
+		{
+			name:            "threshold exceeded",
+			threshold:       20,
+			expectCompacted: true,
+		},
+		{
+			name:            "requested compaction",
+			request:         true,
+			expectCompacted: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, err := ioutil.TempDir("", "virtlet-compact-")
+			if err != nil {
+				t.Fatalf("TempDir(): %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+			dbPath := filepath.Join(tmpDir, "virtlet.db")
+			origSize := makeFragmentedDB(t, dbPath)
+
+			if tc.request {
+				if err := RequestCompaction(dbPath); err != nil {
+					t.Fatalf("RequestCompaction(): %v", err)
+				}
+			}
+			if err := CompactDatabase(dbPath, tc.threshold); err != nil {
+				t.Fatalf("CompactDatabase(): %v", err)
+			}
+
+			newSize := fileSize(t, dbPath)
+			switch {
+			case tc.expectCompacted && newSize >= origSize:
+				t.Errorf("the database was not compacted: %d bytes before, %d bytes after", origSize, newSize)
+			case !tc.expectCompacted && newSize != origSize:
+				t.Errorf("the database was compacted unexpectedly: %d bytes before, %d bytes after", origSize, newSize)
+			}
+			verifyCompactedDB(t, dbPath)
+
+			files, err := filepath.Glob(filepath.Join(tmpDir, "*"))
+			if err != nil {
+				t.Fatalf("Glob(): %v", err)
+			}
+			if len(files) != 1 {
+				t.Errorf("CompactDatabase() left extra files: %v", files)
+			}
+		})
+	}
+}
+
+func TestRequestCompactionWithoutDatabase(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "virtlet-compact-")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := RequestCompaction(filepath.Join(tmpDir, "virtlet.db")); err == nil {
+		t.Errorf("RequestCompaction() didn't fail for a nonexistent database")
+	}
+}
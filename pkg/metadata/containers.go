@@ -22,7 +22,7 @@ import (
 	"errors"
 	"fmt"
 
-	"github.com/boltdb/bolt"
+	bolt "go.etcd.io/bbolt"
 
 	"github.com/Mirantis/virtlet/pkg/metadata/types"
 )
@@ -39,92 +39,111 @@ func containerKey(containerID string) []byte {
 type containerMeta struct {
 	client *boltClient
 	id     string
+	origin *auditOrigin
 }
 
-// GetID returns ID of the container managed by this object
+// GetID implements GetID method of ContainerMetadata interface
 func (m containerMeta) GetID() string {
 	return m.id
 }
 
-// Retrieve loads from DB and returns container data bound to the object
+// Retrieve implements Retrieve method of ContainerMetadata interface
 func (m containerMeta) Retrieve() (*types.ContainerInfo, error) {
 	if m.GetID() == "" {
 		return nil, errors.New("Container ID cannot be empty")
 	}
 	var ci *types.ContainerInfo
-	err := m.client.db.View(func(tx *bolt.Tx) error {
+	err := m.client.view(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(containersBucket)
 		if bucket == nil {
 			return nil
 		}
-		data := bucket.Get([]byte(m.GetID()))
-		if data == nil {
-			return nil
+		data, err := m.client.cipher.open(bucket.Get([]byte(m.GetID())))
+		if err != nil || data == nil {
+			return err
 		}
 		return json.Unmarshal(data, &ci)
 	})
 	return ci, err
 }
 
-// Save allows to create/modify/delete container data bound to the object.
-// Supplied handler gets current ContainerInfo value (nil if doesn't exist) and returns new structure
-// value to be saved or nil to delete. If error value is returned from the handler, the transaction is
-// rolled back and returned error becomes the result of the function
+// Save implements Save method of ContainerMetadata interface
 func (m containerMeta) Save(updater func(*types.ContainerInfo) (*types.ContainerInfo, error)) error {
 	if m.GetID() == "" {
 		return errors.New("Container ID cannot be empty")
 	}
-	return m.client.db.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists(containersBucket)
-		if err != nil {
-			return err
-		}
-		var current *types.ContainerInfo
-		var oldPodID string
-		data := bucket.Get([]byte(m.GetID()))
-		if data != nil {
-			if err = json.Unmarshal(data, &current); err != nil {
-				return err
-			}
-			oldPodID = current.Config.PodSandboxID
-		}
-		newData, err := updater(current)
-		if err != nil {
-			return err
-		}
+	// the updater may be invoked more than once if the batch
+	// containing this update is retried
+	return m.client.batch.update(m.origin, func(tx *bolt.Tx) ([]*Event, error) {
+		event, err := saveContainer(tx, m.client.cipher, m.GetID(), updater)
+		return eventList(event), err
+	})
+}
 
-		if current == nil && newData == nil {
-			return nil
+// saveContainer updates the container with given ID within the
+// transaction, returning the event that describes the change, if any.
+func saveContainer(tx *bolt.Tx, c *valueCipher, containerID string, updater func(*types.ContainerInfo) (*types.ContainerInfo, error)) (*Event, error) {
+	var current, newData *types.ContainerInfo
+	bucket, err := tx.CreateBucketIfNotExists(containersBucket)
+	if err != nil {
+		return nil, err
+	}
+	var oldPodID string
+	data, err := c.open(bucket.Get([]byte(containerID)))
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		if err = json.Unmarshal(data, &current); err != nil {
+			return nil, err
 		}
+		oldPodID = current.Config.PodSandboxID
+	}
+	newData, err = updateContainer(current, updater, nextRevision(tx))
+	if err != nil {
+		return nil, err
+	}
 
-		if newData == nil {
-			if oldPodID != "" {
-				if err = removeContainerFromSandbox(tx, m.GetID(), oldPodID); err != nil {
-					return err
-				}
+	if current == nil && newData == nil {
+		return nil, nil
+	}
+
+	if newData == nil {
+		if oldPodID != "" {
+			if err = removeContainerFromSandbox(tx, containerID, oldPodID); err != nil {
+				return nil, err
 			}
-			return bucket.Delete([]byte(m.GetID()))
 		}
-		newData.Id = m.GetID()
-		data, err = json.Marshal(newData)
-		if err != nil {
-			return err
+		if err = bucket.Delete([]byte(containerID)); err != nil {
+			return nil, err
 		}
+		return containerEvent(containerID, current, nil), nil
+	}
+	newData.Id = containerID
+	data, err = json.Marshal(newData)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = c.seal(data); err != nil {
+		return nil, err
+	}
 
-		if oldPodID != newData.Config.PodSandboxID {
-			if oldPodID != "" {
-				if err = removeContainerFromSandbox(tx, m.GetID(), oldPodID); err != nil {
-					return err
-				}
+	if oldPodID != newData.Config.PodSandboxID {
+		if oldPodID != "" {
+			if err = removeContainerFromSandbox(tx, containerID, oldPodID); err != nil {
+				return nil, err
 			}
-			if newData.Config.PodSandboxID != "" {
-				if err = addContainerToSandbox(tx, m.GetID(), newData.Config.PodSandboxID); err != nil {
-					return err
-				}
+		}
+		if newData.Config.PodSandboxID != "" {
+			if err = addContainerToSandbox(tx, containerID, newData.Config.PodSandboxID); err != nil {
+				return nil, err
 			}
 		}
-		return bucket.Put([]byte(m.GetID()), data)
-	})
+	}
+	if err = bucket.Put([]byte(containerID), data); err != nil {
+		return nil, err
+	}
+	return containerEvent(containerID, current, newData), nil
 }
 
 func addContainerToSandbox(tx *bolt.Tx, containerID, sandboxID string) error {
@@ -146,36 +165,108 @@ func removeContainerFromSandbox(tx *bolt.Tx, containerID, sandboxID string) erro
 	return bucket.Delete(containerKey(containerID))
 }
 
-// Container returns interface instance which manages container with given ID
+// Container implements Container method of ContainerStore interface
 func (b *boltClient) Container(containerID string) ContainerMetadata {
-	return &containerMeta{id: containerID, client: b}
+	return b.containerAs(nil, containerID)
 }
 
-// ListPodContainers returns a list of containers that belong to the pod with given ID value
+// containerAs implements containerAs method of auditedBackend interface
+func (b *boltClient) containerAs(origin *auditOrigin, containerID string) ContainerMetadata {
+	return &containerMeta{id: containerID, client: b, origin: origin}
+}
+
+// ListPodContainers implements ListPodContainers method of ContainerStore interface
 func (b *boltClient) ListPodContainers(podID string) ([]ContainerMetadata, error) {
 	if podID == "" {
 		return nil, errors.New("Pod sandbox ID cannot be empty")
 	}
 	var result []ContainerMetadata
-	err := b.db.View(func(tx *bolt.Tx) error {
+	err := b.view(func(tx *bolt.Tx) error {
 		bucket, err := getSandboxBucket(tx, podID, false, false)
 		if err != nil {
 			return err
 		}
-		c := bucket.Cursor()
-		for k, _ := c.Seek(containerKeyPrefix); k != nil && bytes.HasPrefix(k, containerKeyPrefix); k, _ = c.Next() {
-			result = append(result, b.Container(string(k[len(containerKeyPrefix):])))
+		for _, id := range sandboxContainerIDs(bucket) {
+			result = append(result, b.Container(id))
 		}
 		return nil
 	})
 	return result, err
 }
 
-// ImagesInUse returns a set of images in use by containers in the store.
-// The keys of the returned map are image names and the values are always true.
+// sandboxContainerIDs returns the sorted list of IDs of the
+// containers that are listed in the pod sandbox bucket.
+func sandboxContainerIDs(bucket *bolt.Bucket) []string {
+	var ids []string
+	c := bucket.Cursor()
+	for k, _ := c.Seek(containerKeyPrefix); k != nil && bytes.HasPrefix(k, containerKeyPrefix); k, _ = c.Next() {
+		ids = append(ids, string(k[len(containerKeyPrefix):]))
+	}
+	return ids
+}
+
+// ListContainersPage implements ListContainersPage method of ContainerStore interface
+func (b *boltClient) ListContainersPage(limit int, continueToken string) ([]ContainerMetadata, string, error) {
+	lastID, err := decodeContinueToken(continueToken)
+	if err != nil {
+		return nil, "", err
+	}
+	var ids []string
+	if err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(containersBucket)
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, _ := c.Seek([]byte(lastID)); k != nil; k, _ = c.Next() {
+			ids = append(ids, string(k))
+		}
+		return nil
+	}); err != nil {
+		return nil, "", err
+	}
+	pageIDs, nextToken, err := listPage(ids, limit, continueToken, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	var result []ContainerMetadata
+	for _, id := range pageIDs {
+		result = append(result, b.Container(id))
+	}
+	return result, nextToken, nil
+}
+
+// ListContainerInfos implements ListContainerInfos method of ContainerStore interface.
+// The containers are read within a single read-only transaction.
+func (b *boltClient) ListContainerInfos() ([]*types.ContainerInfo, error) {
+	var result []*types.ContainerInfo
+	if err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(containersBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			data, err := b.cipher.open(v)
+			if err != nil {
+				return fmt.Errorf("error reading container %q: %v", string(k), err)
+			}
+			var ci *types.ContainerInfo
+			if err := json.Unmarshal(data, &ci); err != nil {
+				return fmt.Errorf("error unmarshalling container %q: %v", string(k), err)
+			}
+			result = append(result, ci)
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ImagesInUse implements ImagesInUse method of ContainerStore interface
 func (b *boltClient) ImagesInUse() (map[string]bool, error) {
 	result := make(map[string]bool)
-	if err := b.db.View(func(tx *bolt.Tx) error {
+	if err := b.view(func(tx *bolt.Tx) error {
 		c := tx.Cursor()
 		for k, _ := c.Seek(sandboxKeyPrefix); k != nil && bytes.HasPrefix(k, sandboxKeyPrefix); k, _ = c.Next() {
 			containers, err := b.ListPodContainers(string(k[len(sandboxKeyPrefix):]))
@@ -191,6 +282,11 @@ func (b *boltClient) ImagesInUse() (map[string]bool, error) {
 					return fmt.Errorf("containerInfo of container %q not found in Virtlet metadata store", containerMeta.GetID())
 				}
 				result[ci.Config.Image] = true
+				if ci.Config.ParsedAnnotations != nil {
+					for _, image := range ci.Config.ParsedAnnotations.CDROMImages {
+						result[image] = true
+					}
+				}
 			}
 		}
 		return nil
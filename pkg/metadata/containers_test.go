@@ -19,6 +19,7 @@ package metadata
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/Mirantis/virtlet/pkg/metadata/fake"
@@ -62,6 +63,30 @@ func TestSetGetContainerInfo(t *testing.T) {
 	}
 }
 
+func TestListContainerInfos(t *testing.T) {
+	sandboxes := fake.GetSandboxes(3)
+	containers := fake.GetContainersConfig(sandboxes)
+
+	store := setUpTestStore(t, sandboxes, containers, nil)
+
+	infos, err := store.ListContainerInfos()
+	if err != nil {
+		t.Fatalf("ListContainerInfos(): %v", err)
+	}
+	var expectedInfos []*types.ContainerInfo
+	for _, container := range containers {
+		ci, err := store.Container(container.ContainerID).Retrieve()
+		if err != nil {
+			t.Fatalf("Retrieve(): %v", err)
+		}
+		expectedInfos = append(expectedInfos, ci)
+	}
+	sort.Slice(expectedInfos, func(i, j int) bool { return expectedInfos[i].Id < expectedInfos[j].Id })
+	if !reflect.DeepEqual(infos, expectedInfos) {
+		t.Errorf("bad container infos: %#v instead of %#v", infos, expectedInfos)
+	}
+}
+
 func TestGetImagesInUse(t *testing.T) {
 	sandboxes := fake.GetSandboxes(2)
 	containers := fake.GetContainersConfig(sandboxes)
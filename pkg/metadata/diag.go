@@ -18,6 +18,7 @@ package metadata
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"strings"
@@ -78,22 +79,26 @@ func (d *metadataDumper) outputError(description string, err error) {
 
 func (d *metadataDumper) dump() {
 	d.output("Sandboxes:")
-	sandboxes, err := d.store.ListPodSandboxes(nil)
+	// the sandboxes are listed page by page so that the store
+	// isn't blocked while the dump is being written
+	var err error
+	numSandboxes := 0
+	d.withAddedIndent(func() {
+		err = ForEachPodSandbox(d.store, nil, 0, func(smeta PodSandboxMetadata) error {
+			numSandboxes++
+			if sinfo, err := smeta.Retrieve(); err != nil {
+				d.outputError("can't retrieve sandbox", err)
+			} else if err := d.dumpSandbox(smeta.GetID(), sinfo); err != nil {
+				d.outputError("dumping sandbox", err)
+			}
+			return nil
+		})
+	})
 	switch {
 	case err != nil:
 		d.outputError("can't list sandboxes", err)
-	case len(sandboxes) == 0:
+	case numSandboxes == 0:
 		d.output("no sandboxes found")
-	default:
-		d.withAddedIndent(func() {
-			for _, smeta := range sandboxes {
-				if sinfo, err := smeta.Retrieve(); err != nil {
-					d.outputError("can't retrieve sandbox", err)
-				} else if err := d.dumpSandbox(smeta.GetID(), sinfo); err != nil {
-					d.outputError("dumping sandbox", err)
-				}
-			}
-		})
 	}
 
 	d.output("Images:")
@@ -150,3 +155,90 @@ func GetMetadataDumpSource(store Store) diag.Source {
 		return out.String(), nil
 	})
 }
+
+// StartRecordsFileName returns the name of the file that contains
+// the start records of the specified pod in the diagnostics output
+// of the source returned by GetStartRecordsSource.
+func StartRecordsFileName(podNamespace, podName string) string {
+	return fmt.Sprintf("%s-%s", podNamespace, podName)
+}
+
+type startRecordsSource struct {
+	store Store
+}
+
+// GetStartRecordsSource returns a Source that dumps the VM start
+// records as JSON, one file per pod.
+func GetStartRecordsSource(store Store) diag.Source {
+	return startRecordsSource{store: store}
+}
+
+func (s startRecordsSource) DiagnosticInfo() (diag.Result, error) {
+	records, err := s.store.ListStartRecords("", "")
+	if err != nil {
+		return diag.Result{}, err
+	}
+	recordsByFile := make(map[string][]*types.VMStartRecord)
+	for _, record := range records {
+		fileName := StartRecordsFileName(record.PodNamespace, record.PodName)
+		recordsByFile[fileName] = append(recordsByFile[fileName], record)
+	}
+	dr := diag.Result{
+		IsDir:    true,
+		Children: make(map[string]diag.Result),
+	}
+	for fileName, podRecords := range recordsByFile {
+		var out bytes.Buffer
+		encoder := json.NewEncoder(&out)
+		// keep the domain XML readable
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(podRecords); err != nil {
+			return diag.Result{}, fmt.Errorf("error marshalling start records: %v", err)
+		}
+		dr.Children[fileName] = diag.Result{
+			Name: fileName,
+			Ext:  "json",
+			Data: out.String(),
+		}
+	}
+	return dr, nil
+}
+
+// GetSandboxTombstonesSource returns a Source that dumps the
+// tombstones of the recently removed pod sandboxes as JSON.
+func GetSandboxTombstonesSource(store Store) diag.Source {
+	return diag.NewSimpleTextSource("json", func() (string, error) {
+		tombstones, err := store.ListSandboxTombstones()
+		if err != nil {
+			return "", err
+		}
+		if tombstones == nil {
+			tombstones = []*types.SandboxTombstone{}
+		}
+		out, err := json.MarshalIndent(tombstones, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("error marshalling sandbox tombstones: %v", err)
+		}
+		return string(out), nil
+	})
+}
+
+// GetSandboxEventsSource returns a Source that dumps the lifecycle
+// event history of the pod sandboxes as JSON.
+func GetSandboxEventsSource(store Store) diag.Source {
+	return diag.NewSimpleTextSource("json", func() (string, error) {
+		events, err := store.ListSandboxEvents("")
+		if err != nil {
+			return "", err
+		}
+		if events == nil {
+			events = []*types.SandboxEvent{}
+		}
+		out, err := json.MarshalIndent(events, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("error marshalling sandbox events: %v", err)
+		}
+		return string(out), nil
+	})
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/jonboulle/clockwork"
 
 	"github.com/Mirantis/virtlet/pkg/metadata/fake"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
 	"github.com/Mirantis/virtlet/tests/gm"
 )
 
@@ -51,3 +52,28 @@ func TestDumpEmptyMetadata(t *testing.T) {
 	store := setUpTestStore(t, nil, nil, nil)
 	verifyMetadataDump(t, store)
 }
+
+func TestStartRecordsSource(t *testing.T) {
+	store := setUpTestStore(t, nil, nil, nil)
+	for _, pod := range []string{"foo", "bar", "foo"} {
+		if err := store.AddStartRecord(&types.VMStartRecord{
+			Timestamp:    1531164300000000000,
+			PodNamespace: "default",
+			PodName:      pod,
+			DomainXML:    "<domain type='kvm'/>",
+			CloudInitHashes: map[string]string{
+				"meta-data": "0123456789abcdef",
+			},
+		}); err != nil {
+			t.Fatalf("AddStartRecord(): %v", err)
+		}
+	}
+	dr, err := GetStartRecordsSource(store).DiagnosticInfo()
+	switch {
+	case err != nil:
+		t.Fatalf("DiagnosticInfo(): %v", err)
+	case !dr.IsDir:
+		t.Error("start records result is expected to be a directory")
+	}
+	gm.Verify(t, gm.NewYamlVerifier(dr))
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// MinEncryptionKeySize is the minimum size of the metadata
+// encryption key in bytes.
+const MinEncryptionKeySize = 16
+
+// encryptedValuePrefix marks the encrypted values in the database.
+// JSON documents can't start with it, so the values that were
+// stored before the encryption was enabled can be told apart.
+var encryptedValuePrefix = []byte("enc:aes-gcm:")
+
+var errNoEncryptionKey = errors.New("the metadata is encrypted, but no encryption key is configured")
+
+// valueCipher encrypts the serialized pod sandbox and container
+// records using AES-256-GCM. nil *valueCipher leaves the values
+// as is, but still refuses to return the encrypted ones.
+type valueCipher struct {
+	aead cipher.AEAD
+}
+
+// newValueCipher returns a valueCipher for the specified key. The
+// AES key is derived from it using SHA-256, so the key can be of
+// any size starting from MinEncryptionKeySize bytes.
+func newValueCipher(key []byte) (*valueCipher, error) {
+	if len(key) < MinEncryptionKeySize {
+		return nil, fmt.Errorf("the metadata encryption key is too short (%d bytes, must be at least %d)", len(key), MinEncryptionKeySize)
+	}
+	aesKey := sha256.Sum256(key)
+	block, err := aes.NewCipher(aesKey[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &valueCipher{aead: aead}, nil
+}
+
+func isEncryptedValue(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedValuePrefix)
+}
+
+// seal encrypts the value. The result consists of the prefix, the
+// random nonce and the ciphertext.
+func (c *valueCipher) seal(data []byte) ([]byte, error) {
+	if c == nil {
+		return data, nil
+	}
+	nonceSize := c.aead.NonceSize()
+	r := make([]byte, len(encryptedValuePrefix)+nonceSize, len(encryptedValuePrefix)+nonceSize+len(data)+c.aead.Overhead())
+	copy(r, encryptedValuePrefix)
+	nonce := r[len(encryptedValuePrefix):]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("can't generate the nonce: %v", err)
+	}
+	return c.aead.Seal(r, nonce, data, nil), nil
+}
+
+// open decrypts the value if it's encrypted.
+func (c *valueCipher) open(data []byte) ([]byte, error) {
+	if !isEncryptedValue(data) {
+		return data, nil
+	}
+	if c == nil {
+		return nil, errNoEncryptionKey
+	}
+	data = data[len(encryptedValuePrefix):]
+	nonceSize := c.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, errors.New("the encrypted value is truncated")
+	}
+	r, err := c.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return nil, errors.New("can't decrypt the metadata, the encryption key may be wrong")
+	}
+	return r, nil
+}
+
+// sealRecords encrypts the pod sandbox and container records that
+// were stored before the encryption was enabled. It also checks
+// that the records that are already encrypted can be decrypted, so
+// that Virtlet doesn't start with the metadata it can't read because
+// of a wrong or missing key.
+func sealRecords(tx *bolt.Tx, c *valueCipher) error {
+	reseal := func(bucket *bolt.Bucket, key, data []byte) error {
+		if isEncryptedValue(data) {
+			// make sure the key is right
+			_, err := c.open(data)
+			return err
+		}
+		if c == nil {
+			return nil
+		}
+		sealed, err := c.seal(data)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, sealed)
+	}
+
+	var sandboxKeys [][]byte
+	cur := tx.Cursor()
+	for k, _ := cur.Seek(sandboxKeyPrefix); k != nil && bytes.HasPrefix(k, sandboxKeyPrefix); k, _ = cur.Next() {
+		sandboxKeys = append(sandboxKeys, append([]byte(nil), k...))
+	}
+	for _, k := range sandboxKeys {
+		bucket := tx.Bucket(k)
+		if bucket == nil {
+			continue
+		}
+		if data := bucket.Get(sandboxDataBucket); data != nil {
+			if err := reseal(bucket, sandboxDataBucket, data); err != nil {
+				return err
+			}
+		}
+	}
+
+	bucket := tx.Bucket(containersBucket)
+	if bucket == nil {
+		return nil
+	}
+	// the bucket can't be modified while iterating over it
+	values := make(map[string][]byte)
+	if err := bucket.ForEach(func(k, v []byte) error {
+		if v != nil {
+			values[string(k)] = append([]byte(nil), v...)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for k, v := range values {
+		if err := reseal(bucket, []byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasEncryptedRecords returns true if any of the pod sandbox or
+// container records in the database is encrypted.
+func hasEncryptedRecords(tx *bolt.Tx) (bool, error) {
+	cur := tx.Cursor()
+	for k, _ := cur.Seek(sandboxKeyPrefix); k != nil && bytes.HasPrefix(k, sandboxKeyPrefix); k, _ = cur.Next() {
+		if bucket := tx.Bucket(k); bucket != nil && isEncryptedValue(bucket.Get(sandboxDataBucket)) {
+			return true, nil
+		}
+	}
+	bucket := tx.Bucket(containersBucket)
+	if bucket == nil {
+		return false, nil
+	}
+	found := false
+	err := bucket.ForEach(func(k, v []byte) error {
+		if isEncryptedValue(v) {
+			found = true
+		}
+		return nil
+	})
+	return found, err
+}
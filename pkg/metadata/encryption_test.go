@@ -0,0 +1,164 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+var (
+	testEncryptionKey  = []byte("0123456789abcdef0123456789abcdef")
+	otherEncryptionKey = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func TestValueCipher(t *testing.T) {
+	if _, err := newValueCipher([]byte("short")); err == nil {
+		t.Errorf("newValueCipher() didn't fail for a short key")
+	}
+	c, err := newValueCipher(testEncryptionKey)
+	if err != nil {
+		t.Fatalf("newValueCipher(): %v", err)
+	}
+	plaintext := []byte(`{"Name":"secret"}`)
+	sealed, err := c.seal(plaintext)
+	if err != nil {
+		t.Fatalf("seal(): %v", err)
+	}
+	if !isEncryptedValue(sealed) || bytes.Contains(sealed, []byte("secret")) {
+		t.Errorf("bad sealed value %q", sealed)
+	}
+	if sealedAgain, err := c.seal(plaintext); err != nil {
+		t.Errorf("seal(): %v", err)
+	} else if bytes.Equal(sealed, sealedAgain) {
+		t.Errorf("the same value is encrypted twice with the same nonce")
+	}
+	if opened, err := c.open(sealed); err != nil {
+		t.Errorf("open(): %v", err)
+	} else if !bytes.Equal(opened, plaintext) {
+		t.Errorf("bad opened value %q instead of %q", opened, plaintext)
+	}
+	if opened, err := c.open(plaintext); err != nil {
+		t.Errorf("open() failed for a plaintext value: %v", err)
+	} else if !bytes.Equal(opened, plaintext) {
+		t.Errorf("plaintext value changed by open(): %q", opened)
+	}
+
+	var noCipher *valueCipher
+	if _, err := noCipher.open(sealed); err != errNoEncryptionKey {
+		t.Errorf("bad error when opening an encrypted value without a key: %v", err)
+	}
+	otherCipher, err := newValueCipher(otherEncryptionKey)
+	if err != nil {
+		t.Fatalf("newValueCipher(): %v", err)
+	}
+	if _, err := otherCipher.open(sealed); err == nil {
+		t.Errorf("open() didn't fail for a wrong key")
+	}
+	if _, err := c.open(sealed[:len(encryptedValuePrefix)+4]); err == nil {
+		t.Errorf("open() didn't fail for a truncated value")
+	}
+}
+
+func rawContainerValue(t *testing.T, dbPath string) []byte {
+	db, err := bolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open(): %v", err)
+	}
+	defer db.Close()
+	var r []byte
+	if err := db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(containersBucket); bucket != nil {
+			r = append(r, bucket.Get([]byte(testContainerID))...)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("View(): %v", err)
+	}
+	return r
+}
+
+func TestEncryptedStore(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "virtlet-encryption-")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dbPath := filepath.Join(tmpDir, "virtlet.db")
+
+	store, err := NewStore(dbPath)
+	if err != nil {
+		t.Fatalf("NewStore(): %v", err)
+	}
+	if err := store.PodSandbox(testSandboxID).Save(func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+		return &types.PodSandboxInfo{
+			Config: &types.PodSandboxConfig{Name: "secret-pod"},
+		}, nil
+	}); err != nil {
+		t.Fatalf("PodSandbox().Save(): %v", err)
+	}
+	saveTestContainer(t, store, "stored-before-encryption")
+	store.Close()
+	if v := rawContainerValue(t, dbPath); isEncryptedValue(v) {
+		t.Errorf("the container is encrypted without a key: %q", v)
+	}
+
+	// the existing records are encrypted when the key is set
+	if store, err = NewEncryptedStore(dbPath, testEncryptionKey); err != nil {
+		t.Fatalf("NewEncryptedStore(): %v", err)
+	}
+	if name := testContainerName(t, store); name != "stored-before-encryption" {
+		t.Errorf("bad container name after enabling the encryption: %q", name)
+	}
+	saveTestContainer(t, store, "stored-after-encryption")
+	if psi, err := store.PodSandbox(testSandboxID).Retrieve(); err != nil {
+		t.Errorf("PodSandbox().Retrieve(): %v", err)
+	} else if psi == nil || psi.Config.Name != "secret-pod" {
+		t.Errorf("bad pod sandbox after enabling the encryption: %#v", psi)
+	}
+	store.Close()
+	if v := rawContainerValue(t, dbPath); !isEncryptedValue(v) || bytes.Contains(v, []byte("stored-after-encryption")) {
+		t.Errorf("the container is not encrypted: %q", v)
+	}
+
+	if store, err = NewStore(dbPath); err == nil {
+		store.Close()
+		t.Errorf("NewStore() didn't fail for an encrypted database without a key")
+	} else if err != errNoEncryptionKey {
+		t.Errorf("bad error for an encrypted database without a key: %v", err)
+	}
+
+	if store, err = NewEncryptedStore(dbPath, otherEncryptionKey); err == nil {
+		store.Close()
+		t.Errorf("NewEncryptedStore() didn't fail for a wrong key")
+	} else if !strings.Contains(err.Error(), "can't decrypt") {
+		t.Errorf("bad error for a wrong key: %v", err)
+	}
+
+	// the snapshots of the encrypted databases are considered valid
+	if err := ValidateSnapshot(dbPath); err != nil {
+		t.Errorf("ValidateSnapshot() failed for an encrypted database: %v", err)
+	}
+}
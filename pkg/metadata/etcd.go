@@ -0,0 +1,1026 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/coreos/etcd/pkg/transport"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const (
+	etcdDialTimeout    = 5 * time.Second
+	etcdRequestTimeout = 10 * time.Second
+
+	etcdSandboxPrefix           = "sandboxes/"
+	etcdSandboxContainersPrefix = "sandboxContainers/"
+	etcdContainerPrefix         = "containers/"
+	etcdStartRecordPrefix       = "startRecords/"
+	etcdStartRecordSeqKey       = "startRecordSeq"
+	etcdFirstBootPrefix         = "firstBoot/"
+	etcdImagePullPrefix         = "imagePulls/"
+	etcdSandboxTombstonePrefix  = "sandboxTombstones/"
+	etcdSandboxEventPrefix      = "sandboxEvents/"
+	etcdSandboxEventSeqKey      = "sandboxEventSeq"
+)
+
+// EtcdConfig specifies the settings of the etcd-backed metadata store.
+type EtcdConfig struct {
+	// Endpoints lists the client URLs of the etcd cluster
+	Endpoints []string
+	// KeyPrefix is prepended to all the keys used by the store.
+	// Different Virtlet instances that share the same etcd
+	// cluster must use different key prefixes
+	KeyPrefix string
+	// CAFile is the path to the CA certificate used to verify
+	// the etcd server certificates
+	CAFile string
+	// CertFile is the path to the client certificate
+	CertFile string
+	// KeyFile is the path to the private key of the client certificate
+	KeyFile string
+}
+
+// etcdClient is a Store implementation that keeps the metadata in
+// etcd. The pod sandboxes, containers and other records are stored
+// as JSON under separate keys, with the IDs of the containers that
+// belong to each pod sandbox being kept under a separate key, too.
+// The updaters passed to Save methods are run in etcd software
+// transactions and may be invoked more than once if the transaction
+// conflicts with a concurrent update.
+type etcdClient struct {
+	client        *clientv3.Client
+	prefix        string
+	watchers      *watchHub
+	removalPolicy SandboxRemovalPolicy
+}
+
+var _ Store = &etcdClient{}
+
+// NewEtcdStore returns a Store that keeps the metadata in etcd
+func NewEtcdStore(cfg EtcdConfig) (Store, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, errors.New("no etcd endpoints specified")
+	}
+	var tlsConfig *tls.Config
+	if cfg.CAFile != "" || cfg.CertFile != "" || cfg.KeyFile != "" {
+		tlsInfo := transport.TLSInfo{
+			CertFile:      cfg.CertFile,
+			KeyFile:       cfg.KeyFile,
+			TrustedCAFile: cfg.CAFile,
+		}
+		var err error
+		if tlsConfig, err = tlsInfo.ClientConfig(); err != nil {
+			return nil, fmt.Errorf("error setting up TLS for etcd: %v", err)
+		}
+	}
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   cfg.Endpoints,
+		DialTimeout: etcdDialTimeout,
+		TLS:         tlsConfig,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to etcd: %v", err)
+	}
+	return &etcdClient{client: client, prefix: cfg.KeyPrefix, watchers: newWatchHub()}, nil
+}
+
+// Watch implements Watch method of WatchStore interface. Only the
+// changes made via this store are reported.
+func (c *etcdClient) Watch() (<-chan Event, func()) {
+	return c.watchers.watch()
+}
+
+// SetAuditLog implements SetAuditLog method of AuditStore interface
+func (c *etcdClient) SetAuditLog(log *AuditLog) {
+	c.watchers.setAuditLog(log)
+}
+
+// Close releases the etcd connection
+func (c *etcdClient) Close() error {
+	return c.client.Close()
+}
+
+func (c *etcdClient) sandboxKey(podID string) string {
+	return c.prefix + etcdSandboxPrefix + podID
+}
+
+func (c *etcdClient) sandboxContainersKey(podID string) string {
+	return c.prefix + etcdSandboxContainersPrefix + podID
+}
+
+func (c *etcdClient) containerKey(containerID string) string {
+	return c.prefix + etcdContainerPrefix + containerID
+}
+
+// getMulti retrieves the key-value pairs for the specified keys
+// atomically. The pairs for the missing keys are nil.
+func (c *etcdClient) getMulti(keys ...string) ([]*mvccpb.KeyValue, int64, error) {
+	var ops []clientv3.Op
+	for _, key := range keys {
+		ops = append(ops, clientv3.OpGet(key))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+	defer cancel()
+	resp, err := c.client.Txn(ctx).Then(ops...).Commit()
+	if err != nil {
+		return nil, 0, err
+	}
+	result := make([]*mvccpb.KeyValue, len(keys))
+	for n, r := range resp.Responses {
+		if kvs := r.GetResponseRange().Kvs; len(kvs) != 0 {
+			result[n] = kvs[0]
+		}
+	}
+	return result, resp.Header.Revision, nil
+}
+
+// get retrieves the value of the key and unmarshals it into v.
+// It returns the key-value pair, or nil if there's no such key.
+func (c *etcdClient) get(key string, v interface{}, opts ...clientv3.OpOption) (*mvccpb.KeyValue, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+	defer cancel()
+	resp, err := c.client.Get(ctx, key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+	return resp.Kvs[0], json.Unmarshal(resp.Kvs[0].Value, v)
+}
+
+// list returns the key-value pairs with the specified key prefix
+// sorted by key along with the revision of the store.
+func (c *etcdClient) list(prefix string, opts ...clientv3.OpOption) ([]*mvccpb.KeyValue, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+	defer cancel()
+	opts = append(opts, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	resp, err := c.client.Get(ctx, prefix, opts...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Kvs, resp.Header.Revision, nil
+}
+
+// update runs fn in a serializable software transaction
+func (c *etcdClient) update(fn func(stm concurrency.STM) error) error {
+	_, err := c.updateRev(fn)
+	return err
+}
+
+// updateRev runs fn in a serializable software transaction and
+// returns the revision of etcd after the transaction is committed,
+// which becomes the ModRevision of the keys written by it.
+func (c *etcdClient) updateRev(fn func(stm concurrency.STM) error) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+	defer cancel()
+	resp, err := concurrency.NewSTMSerializable(ctx, c.client, fn)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Header.Revision, nil
+}
+
+// updateRecords runs fn that updates pod sandboxes and containers in
+// a serializable software transaction. The revision of each pod
+// sandbox or container record is the ModRevision of its etcd key,
+// so the revisions of the records in the events for the changes
+// are set to the revision of the transaction after it's committed.
+// Unlike a counter kept under a separate key, this doesn't make
+// every pair of transactions conflict with each other.
+func (c *etcdClient) updateRecords(fn func(stm concurrency.STM) ([]*Event, error)) ([]*Event, error) {
+	var events []*Event
+	rev, err := c.updateRev(func(stm concurrency.STM) error {
+		var err error
+		events, err = fn(stm)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		if event.Type == EventDeleted {
+			continue
+		}
+		switch {
+		case event.Sandbox != nil:
+			event.Sandbox.Revision = uint64(rev)
+		case event.Container != nil:
+			event.Container.Revision = uint64(rev)
+		}
+	}
+	return events, nil
+}
+
+// stmRevision returns the revision of the record stored under the
+// key as seen by the software transaction, that is, the ModRevision
+// of the key.
+func stmRevision(stm concurrency.STM, key string) uint64 {
+	return uint64(stm.Rev(key))
+}
+
+// pendingRevision is passed to updatePodSandbox and updateContainer
+// by the etcd store. The revision of the record being written is
+// only known after the transaction is committed, and it's not taken
+// from the stored value anyway.
+func pendingRevision() (uint64, error) {
+	return 0, nil
+}
+
+// stmGet unmarshals the value of the key into v leaving v
+// untouched if there's no such key.
+func stmGet(stm concurrency.STM, key string, v interface{}) error {
+	data := stm.Get(key)
+	if data == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(data), v); err != nil {
+		return fmt.Errorf("error unmarshalling the value of etcd key %q: %v", key, err)
+	}
+	return nil
+}
+
+func stmPut(stm concurrency.STM, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	stm.Put(key, string(data))
+	return nil
+}
+
+type etcdPodSandboxMeta struct {
+	client *etcdClient
+	id     string
+	origin *auditOrigin
+}
+
+// GetID implements GetID method of PodSandboxMetadata interface
+func (m etcdPodSandboxMeta) GetID() string {
+	return m.id
+}
+
+// Retrieve implements Retrieve method of PodSandboxMetadata interface
+func (m etcdPodSandboxMeta) Retrieve() (*types.PodSandboxInfo, error) {
+	if m.GetID() == "" {
+		return nil, errors.New("Pod sandbox ID cannot be empty")
+	}
+	kvs, _, err := m.client.getMulti(m.client.sandboxKey(m.GetID()), m.client.sandboxContainersKey(m.GetID()))
+	switch {
+	case err != nil:
+		return nil, err
+	case kvs[0] == nil && kvs[1] == nil:
+		return nil, sandboxNotFoundError(m.GetID())
+	case kvs[0] == nil:
+		// the sandbox only has containers associated with it
+		return nil, nil
+	}
+	var psi *types.PodSandboxInfo
+	if err := json.Unmarshal(kvs[0].Value, &psi); err != nil {
+		return nil, err
+	}
+	psi.PodID = m.GetID()
+	psi.Revision = uint64(kvs[0].ModRevision)
+	return psi, nil
+}
+
+// Save implements Save method of PodSandboxMetadata interface
+func (m etcdPodSandboxMeta) Save(updater func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error)) error {
+	if m.GetID() == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	return m.client.watchers.updateMulti(m.origin, func() ([]*Event, error) {
+		return m.client.updateRecords(func(stm concurrency.STM) ([]*Event, error) {
+			return m.client.savePodSandbox(stm, m.GetID(), updater)
+		})
+	})
+}
+
+// savePodSandbox updates the pod sandbox with given ID within the
+// software transaction, returning the events that describe the
+// changes. When the pod sandbox is removed, its containers are
+// handled according to the removal policy.
+func (c *etcdClient) savePodSandbox(stm concurrency.STM, podID string, updater func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error)) ([]*Event, error) {
+	key := c.sandboxKey(podID)
+	var current *types.PodSandboxInfo
+	if err := stmGet(stm, key, &current); err != nil {
+		return nil, err
+	}
+	if current != nil {
+		current.Revision = stmRevision(stm, key)
+	}
+	newData, err := updatePodSandbox(current, updater, pendingRevision)
+	if err != nil {
+		return nil, err
+	}
+	if newData != nil {
+		if err := stmPut(stm, key, newData); err != nil {
+			return nil, err
+		}
+		return eventList(sandboxEvent(podID, current, newData)), nil
+	}
+
+	var containerIDs []string
+	if err := stmGet(stm, c.sandboxContainersKey(podID), &containerIDs); err != nil {
+		return nil, err
+	}
+	if err := checkSandboxRemoval(c.removalPolicy, podID, containerIDs); err != nil {
+		return nil, err
+	}
+	var events []*Event
+	for _, containerID := range containerIDs {
+		event, err := c.saveContainer(stm, containerID, func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+			return nil, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, eventList(event)...)
+	}
+	stm.Del(key)
+	stm.Del(c.sandboxContainersKey(podID))
+	return append(events, eventList(sandboxEvent(podID, current, nil))...), nil
+}
+
+// SetSandboxRemovalPolicy implements SetSandboxRemovalPolicy method
+// of SandboxStore interface
+func (c *etcdClient) SetSandboxRemovalPolicy(policy SandboxRemovalPolicy) {
+	c.removalPolicy = policy
+}
+
+// PodSandbox implements PodSandbox method of SandboxStore interface
+func (c *etcdClient) PodSandbox(podID string) PodSandboxMetadata {
+	return c.podSandboxAs(nil, podID)
+}
+
+// podSandboxAs implements podSandboxAs method of auditedBackend interface
+func (c *etcdClient) podSandboxAs(origin *auditOrigin, podID string) PodSandboxMetadata {
+	return &etcdPodSandboxMeta{id: podID, client: c, origin: origin}
+}
+
+// ListPodSandboxes implements ListPodSandboxes method of SandboxStore interface
+func (c *etcdClient) ListPodSandboxes(filter *types.PodSandboxFilter) ([]PodSandboxMetadata, error) {
+	result, _, err := c.ListPodSandboxesPage(filter, 0, "")
+	return result, err
+}
+
+// ListPodSandboxesPage implements ListPodSandboxesPage method of SandboxStore interface
+func (c *etcdClient) ListPodSandboxesPage(filter *types.PodSandboxFilter, limit int, continueToken string) ([]PodSandboxMetadata, string, error) {
+	ids := make(map[string]bool)
+	for _, prefix := range []string{etcdSandboxPrefix, etcdSandboxContainersPrefix} {
+		kvs, _, err := c.list(c.prefix+prefix, clientv3.WithKeysOnly())
+		if err != nil {
+			return nil, "", err
+		}
+		for _, kv := range kvs {
+			ids[string(kv.Key[len(c.prefix+prefix):])] = true
+		}
+	}
+	var sortedIDs []string
+	for id := range ids {
+		sortedIDs = append(sortedIDs, id)
+	}
+	sort.Strings(sortedIDs)
+
+	pageIDs, nextToken, err := listPage(sortedIDs, limit, continueToken, func(id string) (bool, error) {
+		return filterPodSandboxMeta(&etcdPodSandboxMeta{client: c, id: id}, filter)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	var result []PodSandboxMetadata
+	for _, id := range pageIDs {
+		result = append(result, etcdPodSandboxMeta{client: c, id: id})
+	}
+	return result, nextToken, nil
+}
+
+type etcdContainerMeta struct {
+	client *etcdClient
+	id     string
+	origin *auditOrigin
+}
+
+// GetID implements GetID method of ContainerMetadata interface
+func (m etcdContainerMeta) GetID() string {
+	return m.id
+}
+
+// Retrieve implements Retrieve method of ContainerMetadata interface
+func (m etcdContainerMeta) Retrieve() (*types.ContainerInfo, error) {
+	if m.GetID() == "" {
+		return nil, errors.New("Container ID cannot be empty")
+	}
+	var ci *types.ContainerInfo
+	kv, err := m.client.get(m.client.containerKey(m.GetID()), &ci)
+	if err != nil {
+		return nil, err
+	}
+	if kv != nil && ci != nil {
+		ci.Revision = uint64(kv.ModRevision)
+	}
+	return ci, nil
+}
+
+// Save implements Save method of ContainerMetadata interface
+func (m etcdContainerMeta) Save(updater func(*types.ContainerInfo) (*types.ContainerInfo, error)) error {
+	if m.GetID() == "" {
+		return errors.New("Container ID cannot be empty")
+	}
+	return m.client.watchers.update(m.origin, func() (*Event, error) {
+		events, err := m.client.updateRecords(func(stm concurrency.STM) ([]*Event, error) {
+			event, err := m.client.saveContainer(stm, m.GetID(), updater)
+			return eventList(event), err
+		})
+		if err != nil || len(events) == 0 {
+			return nil, err
+		}
+		return events[0], nil
+	})
+}
+
+// saveContainer updates the container with given ID within the
+// software transaction, returning the event that describes the
+// change, if any.
+func (c *etcdClient) saveContainer(stm concurrency.STM, containerID string, updater func(*types.ContainerInfo) (*types.ContainerInfo, error)) (*Event, error) {
+	key := c.containerKey(containerID)
+	var current *types.ContainerInfo
+	if err := stmGet(stm, key, &current); err != nil {
+		return nil, err
+	}
+	var oldPodID string
+	if current != nil {
+		oldPodID = current.Config.PodSandboxID
+		current.Revision = stmRevision(stm, key)
+	}
+	newData, err := updateContainer(current, updater, pendingRevision)
+	if err != nil {
+		return nil, err
+	}
+
+	if current == nil && newData == nil {
+		return nil, nil
+	}
+
+	if newData == nil {
+		if oldPodID != "" {
+			if err := c.updateSandboxContainers(stm, oldPodID, containerID, false); err != nil {
+				return nil, err
+			}
+		}
+		stm.Del(key)
+		return containerEvent(containerID, current, nil), nil
+	}
+	newData.Id = containerID
+
+	if oldPodID != newData.Config.PodSandboxID {
+		if oldPodID != "" {
+			if err := c.updateSandboxContainers(stm, oldPodID, containerID, false); err != nil {
+				return nil, err
+			}
+		}
+		if newData.Config.PodSandboxID != "" {
+			if err := c.updateSandboxContainers(stm, newData.Config.PodSandboxID, containerID, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := stmPut(stm, key, newData); err != nil {
+		return nil, err
+	}
+	return containerEvent(containerID, current, newData), nil
+}
+
+// updateSandboxContainers adds the container to the list of the
+// containers that belong to the pod sandbox or removes it from there.
+func (c *etcdClient) updateSandboxContainers(stm concurrency.STM, podID, containerID string, add bool) error {
+	key := c.sandboxContainersKey(podID)
+	if !add && stm.Get(key) == "" {
+		return nil
+	}
+	var ids []string
+	if err := stmGet(stm, key, &ids); err != nil {
+		return err
+	}
+	newIDs := []string{}
+	for _, id := range ids {
+		if id != containerID {
+			newIDs = append(newIDs, id)
+		}
+	}
+	if add {
+		newIDs = append(newIDs, containerID)
+		sort.Strings(newIDs)
+	}
+	return stmPut(stm, key, newIDs)
+}
+
+type etcdTx struct {
+	client *etcdClient
+	stm    concurrency.STM
+	events []*Event
+}
+
+var _ Tx = &etcdTx{}
+
+// SavePodSandbox implements SavePodSandbox method of Tx interface
+func (t *etcdTx) SavePodSandbox(podID string, updater func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error)) error {
+	if podID == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	events, err := t.client.savePodSandbox(t.stm, podID, updater)
+	if err != nil {
+		return err
+	}
+	t.events = append(t.events, events...)
+	return nil
+}
+
+// SaveContainer implements SaveContainer method of Tx interface
+func (t *etcdTx) SaveContainer(containerID string, updater func(*types.ContainerInfo) (*types.ContainerInfo, error)) error {
+	if containerID == "" {
+		return errors.New("Container ID cannot be empty")
+	}
+	event, err := t.client.saveContainer(t.stm, containerID, updater)
+	if err != nil {
+		return err
+	}
+	t.events = append(t.events, eventList(event)...)
+	return nil
+}
+
+// PodContainerIDs implements PodContainerIDs method of Tx interface
+func (t *etcdTx) PodContainerIDs(podID string) ([]string, error) {
+	if podID == "" {
+		return nil, errors.New("Pod sandbox ID cannot be empty")
+	}
+	var ids []string
+	if err := stmGet(t.stm, t.client.sandboxContainersKey(podID), &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Update implements Update method of TransactionStore interface.
+// The changes are made within a single serializable software
+// transaction, which is retried by the STM in case of conflicts.
+func (c *etcdClient) Update(fn func(tx Tx) error) error {
+	return c.updateAs(nil, fn)
+}
+
+// updateAs implements updateAs method of auditedBackend interface
+func (c *etcdClient) updateAs(origin *auditOrigin, fn func(tx Tx) error) error {
+	return c.watchers.updateMulti(origin, func() ([]*Event, error) {
+		return c.updateRecords(func(stm concurrency.STM) ([]*Event, error) {
+			t := &etcdTx{client: c, stm: stm}
+			if err := fn(t); err != nil {
+				return nil, err
+			}
+			return t.events, nil
+		})
+	})
+}
+
+// Container implements Container method of ContainerStore interface
+func (c *etcdClient) Container(containerID string) ContainerMetadata {
+	return c.containerAs(nil, containerID)
+}
+
+// containerAs implements containerAs method of auditedBackend interface
+func (c *etcdClient) containerAs(origin *auditOrigin, containerID string) ContainerMetadata {
+	return &etcdContainerMeta{id: containerID, client: c, origin: origin}
+}
+
+// ListPodContainers implements ListPodContainers method of ContainerStore interface
+func (c *etcdClient) ListPodContainers(podID string) ([]ContainerMetadata, error) {
+	if podID == "" {
+		return nil, errors.New("Pod sandbox ID cannot be empty")
+	}
+	kvs, _, err := c.getMulti(c.sandboxKey(podID), c.sandboxContainersKey(podID))
+	switch {
+	case err != nil:
+		return nil, err
+	case kvs[0] == nil && kvs[1] == nil:
+		return nil, fmt.Errorf("pod sandbox %q does not exist", podID)
+	case kvs[1] == nil:
+		return nil, nil
+	}
+	var ids []string
+	if err := json.Unmarshal(kvs[1].Value, &ids); err != nil {
+		return nil, err
+	}
+	var result []ContainerMetadata
+	for _, id := range ids {
+		result = append(result, c.Container(id))
+	}
+	return result, nil
+}
+
+// ListContainersPage implements ListContainersPage method of ContainerStore interface
+func (c *etcdClient) ListContainersPage(limit int, continueToken string) ([]ContainerMetadata, string, error) {
+	kvs, _, err := c.list(c.prefix+etcdContainerPrefix, clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, "", err
+	}
+	var ids []string
+	for _, kv := range kvs {
+		ids = append(ids, string(kv.Key[len(c.prefix+etcdContainerPrefix):]))
+	}
+
+	pageIDs, nextToken, err := listPage(ids, limit, continueToken, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	var result []ContainerMetadata
+	for _, id := range pageIDs {
+		result = append(result, c.Container(id))
+	}
+	return result, nextToken, nil
+}
+
+// ListContainerInfos implements ListContainerInfos method of ContainerStore interface.
+// The containers are retrieved using a single range request.
+func (c *etcdClient) ListContainerInfos() ([]*types.ContainerInfo, error) {
+	kvs, _, err := c.list(c.prefix + etcdContainerPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var result []*types.ContainerInfo
+	for _, kv := range kvs {
+		var ci *types.ContainerInfo
+		if err := json.Unmarshal(kv.Value, &ci); err != nil {
+			return nil, err
+		}
+		ci.Revision = uint64(kv.ModRevision)
+		result = append(result, ci)
+	}
+	return result, nil
+}
+
+// ImagesInUse implements ImagesInUse method of ContainerStore interface
+func (c *etcdClient) ImagesInUse() (map[string]bool, error) {
+	kvs, rev, err := c.list(c.prefix + etcdSandboxContainersPrefix)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool)
+	for _, kv := range kvs {
+		var ids []string
+		if err := json.Unmarshal(kv.Value, &ids); err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			// use the same revision so that the container
+			// list stays consistent
+			var ci *types.ContainerInfo
+			if _, err := c.get(c.containerKey(id), &ci, clientv3.WithRev(rev)); err != nil {
+				return nil, err
+			}
+			if ci == nil {
+				return nil, fmt.Errorf("containerInfo of container %q not found in Virtlet metadata store", id)
+			}
+			result[ci.Config.Image] = true
+			if ci.Config.ParsedAnnotations != nil {
+				for _, image := range ci.Config.ParsedAnnotations.CDROMImages {
+					result[image] = true
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// AddStartRecord implements AddStartRecord method of StartRecordStore interface
+func (c *etcdClient) AddStartRecord(record *types.VMStartRecord) error {
+	if record.PodName == "" {
+		return errors.New("Pod name cannot be empty")
+	}
+	seqKey := c.prefix + etcdStartRecordSeqKey
+	if err := c.update(func(stm concurrency.STM) error {
+		var id uint64
+		if seq := stm.Get(seqKey); seq != "" {
+			var err error
+			if id, err = strconv.ParseUint(seq, 10, 64); err != nil {
+				return fmt.Errorf("bad start record sequence number %q: %v", seq, err)
+			}
+		}
+		id++
+		record.ID = id
+		stm.Put(seqKey, strconv.FormatUint(id, 10))
+		return stmPut(stm, c.prefix+etcdStartRecordPrefix+string(startRecordKey(record.PodNamespace, record.PodName, id)), record)
+	}); err != nil {
+		return err
+	}
+	if err := c.pruneStartRecords(c.prefix+etcdStartRecordPrefix+string(startRecordPrefix(record.PodNamespace, record.PodName)), maxStartRecordsPerPod); err != nil {
+		return err
+	}
+	return c.pruneStartRecords(c.prefix+etcdStartRecordPrefix, maxStartRecords)
+}
+
+// pruneStartRecords removes the oldest start records with the
+// specified key prefix so that no more than maxCount of such
+// records remain.
+func (c *etcdClient) pruneStartRecords(prefix string, maxCount int) error {
+	kvs, _, err := c.list(prefix, clientv3.WithKeysOnly())
+	if err != nil {
+		return err
+	}
+	if len(kvs) <= maxCount {
+		return nil
+	}
+	sort.Slice(kvs, func(i, j int) bool { return startRecordID(kvs[i].Key) < startRecordID(kvs[j].Key) })
+	var ops []clientv3.Op
+	for _, kv := range kvs[:len(kvs)-maxCount] {
+		ops = append(ops, clientv3.OpDelete(string(kv.Key)))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+	defer cancel()
+	_, err = c.client.Txn(ctx).Then(ops...).Commit()
+	return err
+}
+
+// ListStartRecords implements ListStartRecords method of StartRecordStore interface
+func (c *etcdClient) ListStartRecords(podNamespace, podName string) ([]*types.VMStartRecord, error) {
+	prefix := c.prefix + etcdStartRecordPrefix
+	if podName != "" {
+		prefix += string(startRecordPrefix(podNamespace, podName))
+	}
+	kvs, _, err := c.list(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var records []*types.VMStartRecord
+	for _, kv := range kvs {
+		var record *types.VMStartRecord
+		if err := json.Unmarshal(kv.Value, &record); err != nil {
+			return nil, fmt.Errorf("error unmarshalling start record %q: %v", kv.Key, err)
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records, nil
+}
+
+// AddSandboxTombstone implements AddSandboxTombstone method of SandboxTombstoneStore interface
+func (c *etcdClient) AddSandboxTombstone(tombstone *types.SandboxTombstone) error {
+	if tombstone.PodSandboxID == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	if err := c.update(func(stm concurrency.STM) error {
+		return stmPut(stm, c.prefix+etcdSandboxTombstonePrefix+tombstone.PodSandboxID, tombstone)
+	}); err != nil {
+		return err
+	}
+	tombstones, err := c.ListSandboxTombstones()
+	if err != nil {
+		return err
+	}
+	if len(tombstones) <= maxSandboxTombstones {
+		return nil
+	}
+	return c.deleteSandboxTombstones(tombstones[:len(tombstones)-maxSandboxTombstones])
+}
+
+func (c *etcdClient) deleteSandboxTombstones(tombstones []*types.SandboxTombstone) error {
+	if len(tombstones) == 0 {
+		return nil
+	}
+	var ops []clientv3.Op
+	for _, t := range tombstones {
+		ops = append(ops, clientv3.OpDelete(c.prefix+etcdSandboxTombstonePrefix+t.PodSandboxID))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+	defer cancel()
+	_, err := c.client.Txn(ctx).Then(ops...).Commit()
+	return err
+}
+
+// ListSandboxTombstones implements ListSandboxTombstones method of SandboxTombstoneStore interface
+func (c *etcdClient) ListSandboxTombstones() ([]*types.SandboxTombstone, error) {
+	kvs, _, err := c.list(c.prefix + etcdSandboxTombstonePrefix)
+	if err != nil {
+		return nil, err
+	}
+	var tombstones []*types.SandboxTombstone
+	for _, kv := range kvs {
+		var tombstone *types.SandboxTombstone
+		if err := json.Unmarshal(kv.Value, &tombstone); err != nil {
+			return nil, fmt.Errorf("error unmarshalling sandbox tombstone %q: %v", kv.Key, err)
+		}
+		tombstones = append(tombstones, tombstone)
+	}
+	sortSandboxTombstones(tombstones)
+	return tombstones, nil
+}
+
+// PruneSandboxTombstones implements PruneSandboxTombstones method of SandboxTombstoneStore interface
+func (c *etcdClient) PruneSandboxTombstones(before int64) (int, error) {
+	tombstones, err := c.ListSandboxTombstones()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for n < len(tombstones) && tombstones[n].DeletedAt < before {
+		n++
+	}
+	if err := c.deleteSandboxTombstones(tombstones[:n]); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// AddSandboxEvent implements AddSandboxEvent method of SandboxEventStore interface
+func (c *etcdClient) AddSandboxEvent(event *types.SandboxEvent) error {
+	if event.PodSandboxID == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	seqKey := c.prefix + etcdSandboxEventSeqKey
+	if err := c.update(func(stm concurrency.STM) error {
+		var id uint64
+		if seq := stm.Get(seqKey); seq != "" {
+			var err error
+			if id, err = strconv.ParseUint(seq, 10, 64); err != nil {
+				return fmt.Errorf("bad sandbox event sequence number %q: %v", seq, err)
+			}
+		}
+		id++
+		event.ID = id
+		stm.Put(seqKey, strconv.FormatUint(id, 10))
+		return stmPut(stm, c.prefix+etcdSandboxEventPrefix+string(sandboxEventKey(event.PodSandboxID, id)), event)
+	}); err != nil {
+		return err
+	}
+	if err := c.pruneSandboxEvents(c.prefix+etcdSandboxEventPrefix+string(sandboxEventPrefix(event.PodSandboxID)), maxSandboxEventsPerPod); err != nil {
+		return err
+	}
+	return c.pruneSandboxEvents(c.prefix+etcdSandboxEventPrefix, maxSandboxEvents)
+}
+
+// pruneSandboxEvents removes the oldest events with the specified
+// key prefix so that no more than maxCount of such events remain.
+func (c *etcdClient) pruneSandboxEvents(prefix string, maxCount int) error {
+	kvs, _, err := c.list(prefix, clientv3.WithKeysOnly())
+	if err != nil {
+		return err
+	}
+	if len(kvs) <= maxCount {
+		return nil
+	}
+	sort.Slice(kvs, func(i, j int) bool { return sandboxEventID(kvs[i].Key) < sandboxEventID(kvs[j].Key) })
+	var ops []clientv3.Op
+	for _, kv := range kvs[:len(kvs)-maxCount] {
+		ops = append(ops, clientv3.OpDelete(string(kv.Key)))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+	defer cancel()
+	_, err = c.client.Txn(ctx).Then(ops...).Commit()
+	return err
+}
+
+// ListSandboxEvents implements ListSandboxEvents method of SandboxEventStore interface
+func (c *etcdClient) ListSandboxEvents(podID string) ([]*types.SandboxEvent, error) {
+	prefix := c.prefix + etcdSandboxEventPrefix
+	if podID != "" {
+		prefix += string(sandboxEventPrefix(podID))
+	}
+	kvs, _, err := c.list(prefix)
+	if err != nil {
+		return nil, err
+	}
+	var events []*types.SandboxEvent
+	for _, kv := range kvs {
+		var event *types.SandboxEvent
+		if err := json.Unmarshal(kv.Value, &event); err != nil {
+			return nil, fmt.Errorf("error unmarshalling sandbox event %q: %v", kv.Key, err)
+		}
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, nil
+}
+
+// GetFirstBootRecord implements GetFirstBootRecord method of FirstBootStore interface
+func (c *etcdClient) GetFirstBootRecord(volumeID string) (*types.FirstBootRecord, error) {
+	if volumeID == "" {
+		return nil, errors.New("Volume ID cannot be empty")
+	}
+	var record *types.FirstBootRecord
+	if _, err := c.get(c.prefix+etcdFirstBootPrefix+volumeID, &record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// SaveFirstBootRecord implements SaveFirstBootRecord method of FirstBootStore interface
+func (c *etcdClient) SaveFirstBootRecord(volumeID string, updater func(*types.FirstBootRecord) (*types.FirstBootRecord, error)) error {
+	if volumeID == "" {
+		return errors.New("Volume ID cannot be empty")
+	}
+	key := c.prefix + etcdFirstBootPrefix + volumeID
+	return c.update(func(stm concurrency.STM) error {
+		var current *types.FirstBootRecord
+		if err := stmGet(stm, key, &current); err != nil {
+			return err
+		}
+		record, err := updater(current)
+		switch {
+		case err != nil:
+			return err
+		case record == nil && current == nil:
+			return nil
+		case record == nil:
+			stm.Del(key)
+			return nil
+		}
+		record.VolumeID = volumeID
+		return stmPut(stm, key, record)
+	})
+}
+
+// GetImagePullJob implements GetImagePullJob method of ImagePullStore interface
+func (c *etcdClient) GetImagePullJob(imageName string) (*types.ImagePullJob, error) {
+	if imageName == "" {
+		return nil, errors.New("Image name cannot be empty")
+	}
+	var job *types.ImagePullJob
+	if _, err := c.get(c.prefix+etcdImagePullPrefix+imageName, &job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// SaveImagePullJob implements SaveImagePullJob method of ImagePullStore interface
+func (c *etcdClient) SaveImagePullJob(imageName string, updater func(*types.ImagePullJob) (*types.ImagePullJob, error)) error {
+	return c.saveImagePullJobAs(nil, imageName, updater)
+}
+
+// saveImagePullJobAs implements saveImagePullJobAs method of auditedBackend interface
+func (c *etcdClient) saveImagePullJobAs(origin *auditOrigin, imageName string, updater func(*types.ImagePullJob) (*types.ImagePullJob, error)) error {
+	if imageName == "" {
+		return errors.New("Image name cannot be empty")
+	}
+	updater, audit := c.watchers.auditImagePullJob(origin, imageName, updater)
+	key := c.prefix + etcdImagePullPrefix + imageName
+	return audit(c.update(func(stm concurrency.STM) error {
+		var current *types.ImagePullJob
+		if err := stmGet(stm, key, &current); err != nil {
+			return err
+		}
+		job, err := updater(current)
+		switch {
+		case err != nil:
+			return err
+		case job == nil && current == nil:
+			return nil
+		case job == nil:
+			stm.Del(key)
+			return nil
+		}
+		job.ImageName = imageName
+		return stmPut(stm, key, job)
+	}))
+}
+
+// ListImagePullJobs implements ListImagePullJobs method of ImagePullStore interface
+func (c *etcdClient) ListImagePullJobs() ([]*types.ImagePullJob, error) {
+	kvs, _, err := c.list(c.prefix + etcdImagePullPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*types.ImagePullJob
+	for _, kv := range kvs {
+		var job *types.ImagePullJob
+		if err := json.Unmarshal(kv.Value, &job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
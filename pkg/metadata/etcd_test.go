@@ -0,0 +1,323 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/embed"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func freeLocalURL(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen(): %v", err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+func withEtcdStore(t *testing.T, toCall func(store Store)) {
+	tmpDir, err := ioutil.TempDir("", "virtlet-etcd-")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg := embed.NewConfig()
+	cfg.Dir = tmpDir
+	clientURL, peerURL := freeLocalURL(t), freeLocalURL(t)
+	cfg.LCUrls, cfg.ACUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.LPUrls, cfg.APUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("StartEtcd(): %v", err)
+	}
+	defer e.Close()
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(time.Minute):
+		t.Fatalf("timed out waiting for etcd to start")
+	}
+
+	store, err := NewEtcdStore(EtcdConfig{
+		Endpoints: []string{clientURL.String()},
+		KeyPrefix: "/virtlet/node1/",
+	})
+	if err != nil {
+		t.Fatalf("NewEtcdStore(): %v", err)
+	}
+	defer store.Close()
+	toCall(store)
+}
+
+func TestEtcdSandboxesAndContainers(t *testing.T) {
+	withEtcdStore(t, func(store Store) {
+		for _, podID := range []string{"pod1", "pod2"} {
+			if err := store.PodSandbox(podID).Save(func(psi *types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+				if psi != nil {
+					t.Errorf("unexpected existing sandbox: %#v", psi)
+				}
+				return &types.PodSandboxInfo{
+					Config: &types.PodSandboxConfig{Name: podID + "-name"},
+					State:  types.PodSandboxState_SANDBOX_READY,
+				}, nil
+			}); err != nil {
+				t.Fatalf("PodSandbox(%q).Save(): %v", podID, err)
+			}
+		}
+		for n, podID := range []string{"pod1", "pod1", "pod2"} {
+			containerID := fmt.Sprintf("container%d", n+1)
+			if err := store.Container(containerID).Save(func(ci *types.ContainerInfo) (*types.ContainerInfo, error) {
+				return &types.ContainerInfo{
+					Name: containerID + "-name",
+					Config: types.VMConfig{
+						PodSandboxID: podID,
+						Image:        fmt.Sprintf("image%d", n+1),
+					},
+				}, nil
+			}); err != nil {
+				t.Fatalf("Container(%q).Save(): %v", containerID, err)
+			}
+		}
+
+		psi, err := store.PodSandbox("pod1").Retrieve()
+		if err != nil {
+			t.Fatalf("PodSandbox().Retrieve(): %v", err)
+		}
+		if psi.PodID != "pod1" || psi.Config.Name != "pod1-name" {
+			t.Errorf("bad sandbox info: %#v", psi)
+		}
+		if _, err := store.PodSandbox("nosuchpod").Retrieve(); err == nil {
+			t.Errorf("PodSandbox().Retrieve() didn't fail for a nonexistent sandbox")
+		}
+
+		sandboxes, err := store.ListPodSandboxes(nil)
+		if err != nil {
+			t.Fatalf("ListPodSandboxes(): %v", err)
+		}
+		if len(sandboxes) != 2 || sandboxes[0].GetID() != "pod1" || sandboxes[1].GetID() != "pod2" {
+			t.Errorf("bad sandbox list: %#v", sandboxes)
+		}
+
+		ci, err := store.Container("container1").Retrieve()
+		if err != nil {
+			t.Fatalf("Container().Retrieve(): %v", err)
+		}
+		if ci.Id != "container1" || ci.Config.PodSandboxID != "pod1" {
+			t.Errorf("bad container info: %#v", ci)
+		}
+
+		// move container2 to pod2
+		if err := store.Container("container2").Save(func(ci *types.ContainerInfo) (*types.ContainerInfo, error) {
+			ci.Config.PodSandboxID = "pod2"
+			return ci, nil
+		}); err != nil {
+			t.Fatalf("Container().Save(): %v", err)
+		}
+		for podID, expectedIDs := range map[string][]string{
+			"pod1": {"container1"},
+			"pod2": {"container2", "container3"},
+		} {
+			containers, err := store.ListPodContainers(podID)
+			if err != nil {
+				t.Fatalf("ListPodContainers(): %v", err)
+			}
+			var ids []string
+			for _, c := range containers {
+				ids = append(ids, c.GetID())
+			}
+			if !reflect.DeepEqual(ids, expectedIDs) {
+				t.Errorf("bad container list for %q: %#v instead of %#v", podID, ids, expectedIDs)
+			}
+		}
+
+		images, err := store.ImagesInUse()
+		if err != nil {
+			t.Fatalf("ImagesInUse(): %v", err)
+		}
+		expectedImages := map[string]bool{"image1": true, "image2": true, "image3": true}
+		if !reflect.DeepEqual(images, expectedImages) {
+			t.Errorf("bad images in use: %#v instead of %#v", images, expectedImages)
+		}
+
+		if err := store.Container("container1").Save(func(ci *types.ContainerInfo) (*types.ContainerInfo, error) {
+			return nil, nil
+		}); err != nil {
+			t.Fatalf("Container().Save(): %v", err)
+		}
+		if ci, err := store.Container("container1").Retrieve(); err != nil || ci != nil {
+			t.Errorf("container not removed: %#v, %v", ci, err)
+		}
+		if containers, err := store.ListPodContainers("pod1"); err != nil || len(containers) != 0 {
+			t.Errorf("bad container list after removal: %#v, %v", containers, err)
+		}
+
+		if err := store.PodSandbox("pod2").Save(func(psi *types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+			return nil, nil
+		}); err != nil {
+			t.Fatalf("PodSandbox().Save(): %v", err)
+		}
+		if _, err := store.ListPodContainers("pod2"); err == nil {
+			t.Errorf("ListPodContainers() didn't fail for a removed sandbox")
+		}
+	})
+}
+
+func TestEtcdStartRecords(t *testing.T) {
+	oldMaxPerPod, oldMax := maxStartRecordsPerPod, maxStartRecords
+	maxStartRecordsPerPod, maxStartRecords = 2, 3
+	defer func() {
+		maxStartRecordsPerPod, maxStartRecords = oldMaxPerPod, oldMax
+	}()
+
+	withEtcdStore(t, func(store Store) {
+		for n, pod := range []string{"foo", "foo", "foo", "bar", "baz"} {
+			if err := store.AddStartRecord(&types.VMStartRecord{
+				PodNamespace: "default",
+				PodName:      pod,
+				DomainXML:    fmt.Sprintf("<domain%d/>", n+1),
+			}); err != nil {
+				t.Fatalf("AddStartRecord(): %v", err)
+			}
+		}
+		expectedIDs := []string{
+			"3:default/foo:<domain3/>",
+			"4:default/bar:<domain4/>",
+			"5:default/baz:<domain5/>",
+		}
+		if ids := startRecordIDs(t, store, "", ""); !reflect.DeepEqual(ids, expectedIDs) {
+			t.Errorf("bad start records: %#v instead of %#v", ids, expectedIDs)
+		}
+	})
+}
+
+func TestEtcdSandboxTombstones(t *testing.T) {
+	withEtcdStore(t, func(store Store) {
+		testSandboxTombstones(t, store)
+	})
+}
+
+func TestEtcdSandboxEvents(t *testing.T) {
+	withEtcdStore(t, func(store Store) {
+		testSandboxEvents(t, store)
+	})
+}
+
+func TestEtcdUpdate(t *testing.T) {
+	withEtcdStore(t, func(store Store) {
+		testUpdate(t, store)
+	})
+}
+
+func TestEtcdRevisions(t *testing.T) {
+	withEtcdStore(t, func(store Store) {
+		testRevisions(t, store)
+	})
+}
+
+func TestEtcdEventRevisions(t *testing.T) {
+	withEtcdStore(t, func(store Store) {
+		ch, stop := store.Watch()
+		defer stop()
+		saveTestSandbox(t, store, "pod1", "pod1-name")
+		saveWatchedContainer(t, store, "container1", "pod1", "vm1")
+		psi, err := store.PodSandbox("pod1").Retrieve()
+		if err != nil {
+			t.Fatalf("PodSandbox().Retrieve(): %v", err)
+		}
+		ci, err := store.Container("container1").Retrieve()
+		if err != nil {
+			t.Fatalf("Container().Retrieve(): %v", err)
+		}
+		for _, expected := range []uint64{psi.Revision, ci.Revision} {
+			var event Event
+			select {
+			case event = <-ch:
+			case <-time.After(time.Minute):
+				t.Fatalf("timed out waiting for the event")
+			}
+			var revision uint64
+			if event.Sandbox != nil {
+				revision = event.Sandbox.Revision
+			} else {
+				revision = event.Container.Revision
+			}
+			if revision != expected {
+				t.Errorf("bad revision in the %s event for %q: %d instead of %d", event.Type, event.ID, revision, expected)
+			}
+		}
+	})
+}
+
+func TestEtcdRecords(t *testing.T) {
+	withEtcdStore(t, func(store Store) {
+		imageName := "example.com/foo.qcow2"
+		if err := store.SaveImagePullJob(imageName, func(job *types.ImagePullJob) (*types.ImagePullJob, error) {
+			return &types.ImagePullJob{BytesTotal: 1048576}, nil
+		}); err != nil {
+			t.Fatalf("SaveImagePullJob(): %v", err)
+		}
+		jobs, err := store.ListImagePullJobs()
+		if err != nil {
+			t.Fatalf("ListImagePullJobs(): %v", err)
+		}
+		expectedJobs := []*types.ImagePullJob{{ImageName: imageName, BytesTotal: 1048576}}
+		if !reflect.DeepEqual(jobs, expectedJobs) {
+			t.Errorf("bad image pull jobs: %#v instead of %#v", jobs, expectedJobs)
+		}
+
+		if err := store.SaveFirstBootRecord("vol1", func(record *types.FirstBootRecord) (*types.FirstBootRecord, error) {
+			return &types.FirstBootRecord{}, nil
+		}); err != nil {
+			t.Fatalf("SaveFirstBootRecord(): %v", err)
+		}
+		record, err := store.GetFirstBootRecord("vol1")
+		if err != nil {
+			t.Fatalf("GetFirstBootRecord(): %v", err)
+		}
+		if record == nil || record.VolumeID != "vol1" {
+			t.Errorf("bad first boot record: %#v", record)
+		}
+
+		expectedErr := fmt.Errorf("oops")
+		if err := store.SaveFirstBootRecord("vol1", func(record *types.FirstBootRecord) (*types.FirstBootRecord, error) {
+			return nil, expectedErr
+		}); err != expectedErr {
+			t.Errorf("SaveFirstBootRecord() returned %v instead of the updater error", err)
+		}
+		if record, err := store.GetFirstBootRecord("vol1"); err != nil || record == nil {
+			t.Errorf("first boot record removed by a failed update: %#v, %v", record, err)
+		}
+	})
+}
+
+func TestEtcdWatch(t *testing.T) {
+	withEtcdStore(t, func(store Store) {
+		verifyWatch(t, store)
+	})
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+// ExportVersion is the version of the metadata export format.
+const ExportVersion = 1
+
+// ExportedMetadata holds the pod sandboxes and the containers
+// exported from the metadata store.
+type ExportedMetadata struct {
+	// Version is the version of the export format
+	Version int `json:"version"`
+	// Sandboxes is the list of the pod sandboxes
+	Sandboxes []*types.PodSandboxInfo `json:"sandboxes"`
+	// Containers is the list of the containers
+	Containers []*types.ContainerInfo `json:"containers"`
+}
+
+// Exporter is implemented by the stores that can export the pod
+// sandboxes and the containers as JSON and import them back.
+type Exporter interface {
+	// Export writes the pod sandboxes and the containers
+	// to w as JSON.
+	Export(w io.Writer) error
+	// Import reads the JSON produced by Export from r and
+	// adds the pod sandboxes and the containers to the store.
+	// It fails without making any changes if any of them
+	// already exist in the store.
+	Import(r io.Reader) error
+}
+
+var _ Exporter = &boltClient{}
+
+// Export implements Export method of Exporter interface. The data is
+// read within a single read-only transaction, so it's consistent and
+// doesn't block the updates of the database.
+func (b *boltClient) Export(w io.Writer) error {
+	data := ExportedMetadata{
+		Version:    ExportVersion,
+		Sandboxes:  []*types.PodSandboxInfo{},
+		Containers: []*types.ContainerInfo{},
+	}
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Cursor()
+		for k, _ := c.Seek(sandboxKeyPrefix); k != nil && bytes.HasPrefix(k, sandboxKeyPrefix); k, _ = c.Next() {
+			bucket := tx.Bucket(k)
+			if bucket == nil {
+				continue
+			}
+			podID := string(k[len(sandboxKeyPrefix):])
+			var psi *types.PodSandboxInfo
+			if err := retrieveSandboxFromDB(bucket, b.cipher, &psi); err != nil {
+				return fmt.Errorf("bad data for pod sandbox %q: %v", podID, err)
+			}
+			if psi != nil {
+				psi.PodID = podID
+				data.Sandboxes = append(data.Sandboxes, psi)
+			}
+		}
+
+		bucket := tx.Bucket(containersBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			v, err := b.cipher.open(v)
+			if err != nil {
+				return fmt.Errorf("bad data for container %q: %v", k, err)
+			}
+			var ci *types.ContainerInfo
+			if err := json.Unmarshal(v, &ci); err != nil {
+				return fmt.Errorf("bad data for container %q: %v", k, err)
+			}
+			ci.Id = string(k)
+			data.Containers = append(data.Containers, ci)
+			return nil
+		})
+	}); err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
+
+// Import implements Import method of Exporter interface.
+func (b *boltClient) Import(r io.Reader) error {
+	var data ExportedMetadata
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return fmt.Errorf("error decoding the metadata: %v", err)
+	}
+	return importMetadata(b, &data)
+}
+
+// importMetadata adds the exported pod sandboxes and containers to
+// the store. The data is checked before any changes are made, so the
+// import only fails halfway if the store is modified concurrently.
+func importMetadata(store Store, data *ExportedMetadata) error {
+	if data.Version != ExportVersion {
+		return fmt.Errorf("unsupported metadata export version %d", data.Version)
+	}
+	sandboxIDs := make(map[string]bool)
+	for _, psi := range data.Sandboxes {
+		switch {
+		case psi == nil || psi.PodID == "":
+			return errors.New("pod sandbox without an id")
+		case psi.Config == nil:
+			return fmt.Errorf("pod sandbox %q has no config", psi.PodID)
+		case sandboxIDs[psi.PodID]:
+			return fmt.Errorf("duplicate pod sandbox %q", psi.PodID)
+		}
+		sandboxIDs[psi.PodID] = true
+		if existing, err := store.PodSandbox(psi.PodID).Retrieve(); err != nil {
+			return err
+		} else if existing != nil {
+			return fmt.Errorf("pod sandbox %q already exists", psi.PodID)
+		}
+	}
+
+	containerIDs := make(map[string]bool)
+	for _, ci := range data.Containers {
+		switch {
+		case ci == nil || ci.Id == "":
+			return errors.New("container without an id")
+		case containerIDs[ci.Id]:
+			return fmt.Errorf("duplicate container %q", ci.Id)
+		}
+		containerIDs[ci.Id] = true
+		if existing, err := store.Container(ci.Id).Retrieve(); err != nil {
+			return err
+		} else if existing != nil {
+			return fmt.Errorf("container %q already exists", ci.Id)
+		}
+		if podID := ci.Config.PodSandboxID; !sandboxIDs[podID] {
+			if psi, err := store.PodSandbox(podID).Retrieve(); err != nil {
+				return err
+			} else if psi == nil {
+				return fmt.Errorf("container %q belongs to unknown pod sandbox %q", ci.Id, podID)
+			}
+		}
+	}
+
+	for _, psi := range data.Sandboxes {
+		psi := psi
+		if err := store.PodSandbox(psi.PodID).Save(func(current *types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+			if current != nil {
+				return nil, fmt.Errorf("pod sandbox %q already exists", psi.PodID)
+			}
+			return psi, nil
+		}); err != nil {
+			return err
+		}
+	}
+	for _, ci := range data.Containers {
+		ci := ci
+		if err := store.Container(ci.Id).Save(func(current *types.ContainerInfo) (*types.ContainerInfo, error) {
+			if current != nil {
+				return nil, fmt.Errorf("container %q already exists", ci.Id)
+			}
+			return ci, nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
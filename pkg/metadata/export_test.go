@@ -0,0 +1,180 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/fake"
+)
+
+func exportTestStore(t *testing.T, store Store) string {
+	var buf bytes.Buffer
+	if err := store.(Exporter).Export(&buf); err != nil {
+		t.Fatalf("Export(): %v", err)
+	}
+	return buf.String()
+}
+
+func TestExportImport(t *testing.T) {
+	sandboxConfigs := fake.GetSandboxes(2)
+	store := setUpTestStore(t, sandboxConfigs, fake.GetContainersConfig(sandboxConfigs), nil)
+	defer store.Close()
+	exported := exportTestStore(t, store)
+	for _, s := range []string{sandboxConfigs[0].Uid, sandboxConfigs[1].Uid, "container-for-testName_1"} {
+		if !strings.Contains(exported, s) {
+			t.Errorf("%q not found in the exported metadata:\n%s", s, exported)
+		}
+	}
+
+	newStore, err := newTestStore()
+	if err != nil {
+		t.Fatalf("Error creating the store: %v", err)
+	}
+	defer newStore.Close()
+	if exported := exportTestStore(t, newStore); !strings.Contains(exported, `"sandboxes": []`) {
+		t.Errorf("bad export of an empty store:\n%s", exported)
+	}
+	if err := newStore.(Exporter).Import(strings.NewReader(exported)); err != nil {
+		t.Fatalf("Import(): %v", err)
+	}
+	if reexported := exportTestStore(t, newStore); reexported != exported {
+		t.Errorf("the imported metadata differs from the original one:\n%s\ninstead of\n%s", reexported, exported)
+	}
+
+	// the existing objects are not overwritten
+	if err := newStore.(Exporter).Import(strings.NewReader(exported)); err == nil {
+		t.Errorf("Import() didn't fail for the existing objects")
+	} else if !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("bad error for the existing objects: %v", err)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, data, errSubstring string
+	}{
+		{
+			name:         "bad json",
+			data:         "{",
+			errSubstring: "error decoding",
+		},
+		{
+			name:         "bad version",
+			data:         `{"version":42}`,
+			errSubstring: "unsupported metadata export version",
+		},
+		{
+			name:         "sandbox without an id",
+			data:         `{"version":1,"sandboxes":[{"Config":{}}]}`,
+			errSubstring: "pod sandbox without an id",
+		},
+		{
+			name:         "duplicate sandbox",
+			data:         `{"version":1,"sandboxes":[{"PodID":"a","Config":{}},{"PodID":"a","Config":{}}]}`,
+			errSubstring: `duplicate pod sandbox "a"`,
+		},
+		{
+			name:         "unknown sandbox",
+			data:         `{"version":1,"sandboxes":[{"PodID":"a","Config":{}}],"containers":[{"Id":"c","Config":{"PodSandboxID":"b"}}]}`,
+			errSubstring: `container "c" belongs to unknown pod sandbox "b"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store, err := newTestStore()
+			if err != nil {
+				t.Fatalf("Error creating the store: %v", err)
+			}
+			defer store.Close()
+			err = store.(Exporter).Import(strings.NewReader(tc.data))
+			switch {
+			case err == nil:
+				t.Errorf("Import() didn't fail")
+			case !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("bad error %q, expected it to contain %q", err, tc.errSubstring)
+			}
+			// nothing is imported on error
+			if exported := exportTestStore(t, store); !strings.Contains(exported, `"sandboxes": []`) {
+				t.Errorf("the store is not empty after the failed import:\n%s", exported)
+			}
+		})
+	}
+}
+
+func TestExportImportServer(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "virtlet-export-")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sandboxConfigs := fake.GetSandboxes(1)
+	store := setUpTestStore(t, sandboxConfigs, fake.GetContainersConfig(sandboxConfigs), nil)
+	defer store.Close()
+	socketPath := filepath.Join(tmpDir, "src.sock")
+	s := NewBackupServer(store.(Backuper))
+	go s.Serve(socketPath)
+	defer s.Stop()
+
+	var exported bytes.Buffer
+	for i := 0; ; i++ {
+		// wait for the server to start listening
+		if err = ExportMetadata(socketPath, &exported); err == nil || i == 50 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("ExportMetadata(): %v", err)
+	}
+
+	newStore, err := newTestStore()
+	if err != nil {
+		t.Fatalf("Error creating the store: %v", err)
+	}
+	defer newStore.Close()
+	newSocketPath := filepath.Join(tmpDir, "dest.sock")
+	newServer := NewBackupServer(newStore.(Backuper))
+	go newServer.Serve(newSocketPath)
+	defer newServer.Stop()
+
+	data := exported.String()
+	for i := 0; ; i++ {
+		if err = ImportMetadata(newSocketPath, strings.NewReader(data)); err == nil || i == 50 || !strings.Contains(err.Error(), "can't connect") {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("ImportMetadata(): %v", err)
+	}
+	if reexported := exportTestStore(t, newStore); reexported != data {
+		t.Errorf("the imported metadata differs from the original one:\n%s\ninstead of\n%s", reexported, data)
+	}
+
+	if err := ImportMetadata(newSocketPath, strings.NewReader(data)); err == nil {
+		t.Errorf("ImportMetadata() didn't fail for the existing objects")
+	} else if !strings.Contains(err.Error(), "400 Bad Request") {
+		t.Errorf("bad error for the existing objects: %v", err)
+	}
+}
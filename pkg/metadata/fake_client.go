@@ -20,7 +20,7 @@ import (
 	"io/ioutil"
 	"os"
 
-	"github.com/boltdb/bolt"
+	bolt "go.etcd.io/bbolt"
 )
 
 func tempfile() (string, error) {
@@ -44,5 +44,5 @@ func NewFakeStore() (Store, error) {
 		return nil, err
 	}
 
-	return &boltClient{db: db}, nil
+	return newBoltClient(db, defaultMaxBatchSize), nil
 }
@@ -0,0 +1,103 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+var firstBootBucket = []byte("firstBoot")
+
+// GetFirstBootRecord implements GetFirstBootRecord method of FirstBootStore interface
+func (b *boltClient) GetFirstBootRecord(volumeID string) (*types.FirstBootRecord, error) {
+	if volumeID == "" {
+		return nil, errors.New("Volume ID cannot be empty")
+	}
+	var record *types.FirstBootRecord
+	err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(firstBootBucket)
+		if bucket == nil {
+			return nil
+		}
+		data := bucket.Get([]byte(volumeID))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &record)
+	})
+	return record, err
+}
+
+// SaveFirstBootRecord implements SaveFirstBootRecord method of FirstBootStore interface
+func (b *boltClient) SaveFirstBootRecord(volumeID string, updater func(*types.FirstBootRecord) (*types.FirstBootRecord, error)) error {
+	if volumeID == "" {
+		return errors.New("Volume ID cannot be empty")
+	}
+	return b.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(firstBootBucket)
+		if err != nil {
+			return err
+		}
+		var current *types.FirstBootRecord
+		if data := bucket.Get([]byte(volumeID)); data != nil {
+			if err := json.Unmarshal(data, &current); err != nil {
+				return err
+			}
+		}
+		record, err := updater(current)
+		switch {
+		case err != nil:
+			return err
+		case record == nil && current == nil:
+			return nil
+		case record == nil:
+			return bucket.Delete([]byte(volumeID))
+		}
+		record.VolumeID = volumeID
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(volumeID), data)
+	})
+}
+
+// listFirstBootRecords returns all the first boot records. It's used
+// to copy the records to another store
+func (b *boltClient) listFirstBootRecords() ([]*types.FirstBootRecord, error) {
+	var records []*types.FirstBootRecord
+	err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(firstBootBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var record *types.FirstBootRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	return records, err
+}
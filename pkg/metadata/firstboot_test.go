@@ -0,0 +1,106 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func TestFirstBootRecords(t *testing.T) {
+	store := setUpTestStore(t, nil, nil, nil)
+	volumeID := "77f29a0e-46af-4188-a6af-9ff8b8a65224"
+
+	record, err := store.GetFirstBootRecord(volumeID)
+	if err != nil {
+		t.Fatalf("GetFirstBootRecord(): %v", err)
+	}
+	if record != nil {
+		t.Errorf("GetFirstBootRecord() returned a record for an empty db: %#v", record)
+	}
+
+	expectedRecord := &types.FirstBootRecord{
+		VolumeID:     volumeID,
+		ImageDigest:  "sha256:0123456789abcdef",
+		InstanceID:   "foo.default",
+		PodNamespace: "default",
+		PodName:      "foo",
+	}
+	if err := store.SaveFirstBootRecord(volumeID, func(r *types.FirstBootRecord) (*types.FirstBootRecord, error) {
+		if r != nil {
+			t.Errorf("unexpected existing record: %#v", r)
+		}
+		return &types.FirstBootRecord{
+			ImageDigest:  "sha256:0123456789abcdef",
+			InstanceID:   "foo.default",
+			PodNamespace: "default",
+			PodName:      "foo",
+		}, nil
+	}); err != nil {
+		t.Fatalf("SaveFirstBootRecord(): %v", err)
+	}
+	record, err = store.GetFirstBootRecord(volumeID)
+	if err != nil {
+		t.Fatalf("GetFirstBootRecord(): %v", err)
+	}
+	if !reflect.DeepEqual(record, expectedRecord) {
+		t.Errorf("bad first boot record: %#v instead of %#v", record, expectedRecord)
+	}
+	if record.Completed() {
+		t.Errorf("the first boot is reported as completed")
+	}
+
+	if err := store.SaveFirstBootRecord(volumeID, func(r *types.FirstBootRecord) (*types.FirstBootRecord, error) {
+		r.CompletedAt = 1531164400000000000
+		return nil, errors.New("oops")
+	}); err == nil {
+		t.Errorf("SaveFirstBootRecord() didn't return the error from the handler")
+	}
+	if err := store.SaveFirstBootRecord(volumeID, func(r *types.FirstBootRecord) (*types.FirstBootRecord, error) {
+		r.CompletedAt = 1531164400000000000
+		return r, nil
+	}); err != nil {
+		t.Fatalf("SaveFirstBootRecord(): %v", err)
+	}
+	record, err = store.GetFirstBootRecord(volumeID)
+	if err != nil {
+		t.Fatalf("GetFirstBootRecord(): %v", err)
+	}
+	if record == nil || !record.Completed() {
+		t.Errorf("the first boot is not reported as completed: %#v", record)
+	}
+
+	if err := store.SaveFirstBootRecord(volumeID, func(r *types.FirstBootRecord) (*types.FirstBootRecord, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatalf("SaveFirstBootRecord(): %v", err)
+	}
+	record, err = store.GetFirstBootRecord(volumeID)
+	if err != nil {
+		t.Fatalf("GetFirstBootRecord(): %v", err)
+	}
+	if record != nil {
+		t.Errorf("the first boot record wasn't removed: %#v", record)
+	}
+
+	if _, err := store.GetFirstBootRecord(""); err == nil {
+		t.Errorf("GetFirstBootRecord() didn't fail for an empty volume ID")
+	}
+}
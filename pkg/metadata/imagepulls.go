@@ -0,0 +1,108 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+var imagePullBucket = []byte("imagePulls")
+
+// GetImagePullJob implements GetImagePullJob method of ImagePullStore interface
+func (b *boltClient) GetImagePullJob(imageName string) (*types.ImagePullJob, error) {
+	if imageName == "" {
+		return nil, errors.New("Image name cannot be empty")
+	}
+	var job *types.ImagePullJob
+	err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(imagePullBucket)
+		if bucket == nil {
+			return nil
+		}
+		data := bucket.Get([]byte(imageName))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &job)
+	})
+	return job, err
+}
+
+// SaveImagePullJob implements SaveImagePullJob method of ImagePullStore interface
+func (b *boltClient) SaveImagePullJob(imageName string, updater func(*types.ImagePullJob) (*types.ImagePullJob, error)) error {
+	return b.saveImagePullJobAs(nil, imageName, updater)
+}
+
+// saveImagePullJobAs implements saveImagePullJobAs method of auditedBackend interface
+func (b *boltClient) saveImagePullJobAs(origin *auditOrigin, imageName string, updater func(*types.ImagePullJob) (*types.ImagePullJob, error)) error {
+	if imageName == "" {
+		return errors.New("Image name cannot be empty")
+	}
+	updater, audit := b.watchers.auditImagePullJob(origin, imageName, updater)
+	return audit(b.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(imagePullBucket)
+		if err != nil {
+			return err
+		}
+		var current *types.ImagePullJob
+		if data := bucket.Get([]byte(imageName)); data != nil {
+			if err := json.Unmarshal(data, &current); err != nil {
+				return err
+			}
+		}
+		job, err := updater(current)
+		switch {
+		case err != nil:
+			return err
+		case job == nil && current == nil:
+			return nil
+		case job == nil:
+			return bucket.Delete([]byte(imageName))
+		}
+		job.ImageName = imageName
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(imageName), data)
+	}))
+}
+
+// ListImagePullJobs implements ListImagePullJobs method of ImagePullStore interface
+func (b *boltClient) ListImagePullJobs() ([]*types.ImagePullJob, error) {
+	var jobs []*types.ImagePullJob
+	err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(imagePullBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var job *types.ImagePullJob
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+	})
+	return jobs, err
+}
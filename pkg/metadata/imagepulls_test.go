@@ -0,0 +1,111 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func TestImagePullJobs(t *testing.T) {
+	store := setUpTestStore(t, nil, nil, nil)
+	imageName := "example.com/foo.qcow2"
+
+	job, err := store.GetImagePullJob(imageName)
+	if err != nil {
+		t.Fatalf("GetImagePullJob(): %v", err)
+	}
+	if job != nil {
+		t.Errorf("GetImagePullJob() returned a job for an empty db: %#v", job)
+	}
+
+	expectedJob := &types.ImagePullJob{
+		ImageName:  imageName,
+		StartedAt:  1531164400000000000,
+		BytesTotal: 1048576,
+	}
+	if err := store.SaveImagePullJob(imageName, func(j *types.ImagePullJob) (*types.ImagePullJob, error) {
+		if j != nil {
+			t.Errorf("unexpected existing job: %#v", j)
+		}
+		return &types.ImagePullJob{
+			StartedAt:  1531164400000000000,
+			BytesTotal: 1048576,
+		}, nil
+	}); err != nil {
+		t.Fatalf("SaveImagePullJob(): %v", err)
+	}
+	job, err = store.GetImagePullJob(imageName)
+	if err != nil {
+		t.Fatalf("GetImagePullJob(): %v", err)
+	}
+	if !reflect.DeepEqual(job, expectedJob) {
+		t.Errorf("bad image pull job: %#v instead of %#v", job, expectedJob)
+	}
+	if job.Finished() {
+		t.Errorf("the image pull is reported as finished")
+	}
+
+	if err := store.SaveImagePullJob(imageName, func(j *types.ImagePullJob) (*types.ImagePullJob, error) {
+		j.BytesDone = 1048576
+		return nil, errors.New("oops")
+	}); err == nil {
+		t.Errorf("SaveImagePullJob() didn't return the error from the handler")
+	}
+	if err := store.SaveImagePullJob(imageName, func(j *types.ImagePullJob) (*types.ImagePullJob, error) {
+		j.BytesDone = 1048576
+		j.FinishedAt = 1531164500000000000
+		j.ImageRef = imageName + "@sha256:0123456789abcdef"
+		return j, nil
+	}); err != nil {
+		t.Fatalf("SaveImagePullJob(): %v", err)
+	}
+	jobs, err := store.ListImagePullJobs()
+	if err != nil {
+		t.Fatalf("ListImagePullJobs(): %v", err)
+	}
+	if len(jobs) != 1 || !jobs[0].Finished() || jobs[0].BytesDone != 1048576 || jobs[0].ImageRef != imageName+"@sha256:0123456789abcdef" {
+		t.Errorf("bad image pull job list: %#v", jobs)
+	}
+
+	if err := store.SaveImagePullJob(imageName, func(j *types.ImagePullJob) (*types.ImagePullJob, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatalf("SaveImagePullJob(): %v", err)
+	}
+	job, err = store.GetImagePullJob(imageName)
+	if err != nil {
+		t.Fatalf("GetImagePullJob(): %v", err)
+	}
+	if job != nil {
+		t.Errorf("the image pull job wasn't removed: %#v", job)
+	}
+	jobs, err = store.ListImagePullJobs()
+	if err != nil {
+		t.Fatalf("ListImagePullJobs(): %v", err)
+	}
+	if len(jobs) != 0 {
+		t.Errorf("unexpected image pull jobs after removal: %#v", jobs)
+	}
+
+	if _, err := store.GetImagePullJob(""); err == nil {
+		t.Errorf("GetImagePullJob() didn't fail for an empty image name")
+	}
+}
@@ -0,0 +1,954 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+// FaultInjector is invoked by MemStore for each transaction. It's
+// passed the name of the operation, such as "PodSandbox.Save",
+// "Container.Retrieve", "ListPodContainers" or "AddStartRecord"
+// (the names correspond to the methods of Store and its
+// PodSandboxMetadata / ContainerMetadata objects) and the ID of the
+// object the operation applies to, which is empty for the operations
+// that don't have one. For read-only operations, the injector is
+// invoked before the data is read. For the updates, it's invoked
+// after the updater is run but before the changes are committed.
+// If the injector returns an error, the transaction fails with that
+// error without making any changes to the store.
+type FaultInjector func(op, id string) error
+
+type memSandbox struct {
+	// data is nil for the sandboxes that only have containers
+	// associated with them
+	data       []byte
+	containers map[string]bool
+}
+
+type memState struct {
+	sandboxes      map[string]*memSandbox
+	containers     map[string][]byte
+	startRecords   map[string][]byte
+	startRecordSeq uint64
+	firstBoot      map[string][]byte
+	imagePulls     map[string][]byte
+	tombstones     map[string][]byte
+	events         map[string][]byte
+	eventSeq       uint64
+	revisionSeq    uint64
+}
+
+func newMemState() *memState {
+	return &memState{
+		sandboxes:    make(map[string]*memSandbox),
+		containers:   make(map[string][]byte),
+		startRecords: make(map[string][]byte),
+		firstBoot:    make(map[string][]byte),
+		imagePulls:   make(map[string][]byte),
+		tombstones:   make(map[string][]byte),
+		events:       make(map[string][]byte),
+	}
+}
+
+func copyMemMap(to, from map[string][]byte) {
+	for k, v := range from {
+		to[k] = v
+	}
+}
+
+func sortedMemKeys(m map[string][]byte) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// clone makes a copy of the state. The values are never modified
+// in place, so they're shared between the copies.
+func (st *memState) clone() *memState {
+	r := newMemState()
+	for id, sb := range st.sandboxes {
+		containers := make(map[string]bool)
+		for containerID := range sb.containers {
+			containers[containerID] = true
+		}
+		r.sandboxes[id] = &memSandbox{data: sb.data, containers: containers}
+	}
+	copyMemMap(r.containers, st.containers)
+	copyMemMap(r.startRecords, st.startRecords)
+	r.startRecordSeq = st.startRecordSeq
+	copyMemMap(r.firstBoot, st.firstBoot)
+	copyMemMap(r.imagePulls, st.imagePulls)
+	copyMemMap(r.tombstones, st.tombstones)
+	copyMemMap(r.events, st.events)
+	r.eventSeq = st.eventSeq
+	r.revisionSeq = st.revisionSeq
+	return r
+}
+
+// nextRevision returns the next revision of the store.
+func (st *memState) nextRevision() (uint64, error) {
+	st.revisionSeq++
+	return st.revisionSeq, nil
+}
+
+// MemStore is a Store implementation that keeps the metadata in
+// memory. It's intended to be used in tests and has the same
+// semantics as the bolt-based store, including the transactional
+// Save updaters. The read-only operations see a snapshot of the
+// store, while the updates are serialized and are applied
+// atomically. MemStore supports fault injection via
+// SetFaultInjector.
+type MemStore struct {
+	sync.Mutex
+	// writeLock serializes the updates
+	writeLock sync.Mutex
+	// state is the last committed state of the store. It's never
+	// modified in place, with the updates working on its copies.
+	state         *memState
+	injector      FaultInjector
+	watchers      *watchHub
+	removalPolicy SandboxRemovalPolicy
+}
+
+var _ Store = &MemStore{}
+
+// NewMemStore returns a new empty MemStore
+func NewMemStore() *MemStore {
+	return &MemStore{state: newMemState(), watchers: newWatchHub()}
+}
+
+// SetFaultInjector sets the function that's invoked for each
+// transaction and can make it fail. nil injector disables the
+// fault injection.
+func (s *MemStore) SetFaultInjector(injector FaultInjector) {
+	s.Lock()
+	defer s.Unlock()
+	s.injector = injector
+}
+
+// Close implements Close method of the Store interface. It doesn't
+// do anything for MemStore
+func (s *MemStore) Close() error {
+	return nil
+}
+
+// Watch implements Watch method of WatchStore interface
+func (s *MemStore) Watch() (<-chan Event, func()) {
+	return s.watchers.watch()
+}
+
+// SetAuditLog implements SetAuditLog method of AuditStore interface
+func (s *MemStore) SetAuditLog(log *AuditLog) {
+	s.watchers.setAuditLog(log)
+}
+
+func (s *MemStore) inject(op, id string) error {
+	s.Lock()
+	injector := s.injector
+	s.Unlock()
+	if injector == nil {
+		return nil
+	}
+	return injector(op, id)
+}
+
+func (s *MemStore) view(op, id string, fn func(st *memState) error) error {
+	if err := s.inject(op, id); err != nil {
+		return err
+	}
+	s.Lock()
+	st := s.state
+	s.Unlock()
+	return fn(st)
+}
+
+func (s *MemStore) update(op, id string, fn func(st *memState) error) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	s.Lock()
+	st := s.state.clone()
+	s.Unlock()
+	if err := fn(st); err != nil {
+		return err
+	}
+	if err := s.inject(op, id); err != nil {
+		return err
+	}
+	s.Lock()
+	s.state = st
+	s.Unlock()
+	return nil
+}
+
+func (st *memState) getSandbox(podID string, create bool) *memSandbox {
+	sb := st.sandboxes[podID]
+	if sb == nil && create {
+		sb = &memSandbox{containers: make(map[string]bool)}
+		st.sandboxes[podID] = sb
+	}
+	return sb
+}
+
+type memPodSandboxMeta struct {
+	store  *MemStore
+	id     string
+	origin *auditOrigin
+}
+
+// GetID implements GetID method of PodSandboxMetadata interface
+func (m memPodSandboxMeta) GetID() string {
+	return m.id
+}
+
+// Retrieve implements Retrieve method of PodSandboxMetadata interface
+func (m memPodSandboxMeta) Retrieve() (*types.PodSandboxInfo, error) {
+	if m.GetID() == "" {
+		return nil, errors.New("Pod sandbox ID cannot be empty")
+	}
+	var psi *types.PodSandboxInfo
+	if err := m.store.view("PodSandbox.Retrieve", m.GetID(), func(st *memState) error {
+		sb := st.getSandbox(m.GetID(), false)
+		switch {
+		case sb == nil:
+			return sandboxNotFoundError(m.GetID())
+		case sb.data == nil:
+			return nil
+		}
+		return json.Unmarshal(sb.data, &psi)
+	}); err != nil {
+		return nil, err
+	}
+	if psi != nil {
+		psi.PodID = m.GetID()
+	}
+	return psi, nil
+}
+
+// Save implements Save method of PodSandboxMetadata interface
+func (m memPodSandboxMeta) Save(updater func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error)) error {
+	if m.GetID() == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	return m.store.watchers.updateMulti(m.origin, func() ([]*Event, error) {
+		var events []*Event
+		if err := m.store.update("PodSandbox.Save", m.GetID(), func(st *memState) error {
+			var err error
+			events, err = st.savePodSandbox(m.store.sandboxRemovalPolicy(), m.GetID(), updater)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		return events, nil
+	})
+}
+
+// savePodSandbox updates the pod sandbox with given ID in the state,
+// returning the events that describe the changes. When the pod
+// sandbox is removed, its containers are handled according to the
+// removal policy.
+func (st *memState) savePodSandbox(policy SandboxRemovalPolicy, podID string, updater func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error)) ([]*Event, error) {
+	var current, newData *types.PodSandboxInfo
+	sb := st.getSandbox(podID, true)
+	if sb.data != nil {
+		if err := json.Unmarshal(sb.data, &current); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	newData, err = updatePodSandbox(current, updater, st.nextRevision)
+	if err != nil {
+		return nil, err
+	}
+
+	if newData != nil {
+		if sb.data, err = json.Marshal(newData); err != nil {
+			return nil, err
+		}
+		return eventList(sandboxEvent(podID, current, newData)), nil
+	}
+
+	var containerIDs []string
+	for containerID := range sb.containers {
+		containerIDs = append(containerIDs, containerID)
+	}
+	sort.Strings(containerIDs)
+	if err := checkSandboxRemoval(policy, podID, containerIDs); err != nil {
+		return nil, err
+	}
+	var events []*Event
+	for _, containerID := range containerIDs {
+		event, err := st.saveContainer(containerID, func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+			return nil, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, eventList(event)...)
+	}
+	delete(st.sandboxes, podID)
+	return append(events, eventList(sandboxEvent(podID, current, nil))...), nil
+}
+
+// SetSandboxRemovalPolicy implements SetSandboxRemovalPolicy method
+// of SandboxStore interface
+func (s *MemStore) SetSandboxRemovalPolicy(policy SandboxRemovalPolicy) {
+	s.Lock()
+	defer s.Unlock()
+	s.removalPolicy = policy
+}
+
+func (s *MemStore) sandboxRemovalPolicy() SandboxRemovalPolicy {
+	s.Lock()
+	defer s.Unlock()
+	return s.removalPolicy
+}
+
+// PodSandbox implements PodSandbox method of SandboxStore interface
+func (s *MemStore) PodSandbox(podID string) PodSandboxMetadata {
+	return s.podSandboxAs(nil, podID)
+}
+
+// podSandboxAs implements podSandboxAs method of auditedBackend interface
+func (s *MemStore) podSandboxAs(origin *auditOrigin, podID string) PodSandboxMetadata {
+	return &memPodSandboxMeta{id: podID, store: s, origin: origin}
+}
+
+// ListPodSandboxes implements ListPodSandboxes method of SandboxStore interface
+func (s *MemStore) ListPodSandboxes(filter *types.PodSandboxFilter) ([]PodSandboxMetadata, error) {
+	result, _, err := s.ListPodSandboxesPage(filter, 0, "")
+	return result, err
+}
+
+// ListPodSandboxesPage implements ListPodSandboxesPage method of SandboxStore interface
+func (s *MemStore) ListPodSandboxesPage(filter *types.PodSandboxFilter, limit int, continueToken string) ([]PodSandboxMetadata, string, error) {
+	var ids []string
+	if err := s.view("ListPodSandboxes", "", func(st *memState) error {
+		for id := range st.sandboxes {
+			ids = append(ids, id)
+		}
+		return nil
+	}); err != nil {
+		return nil, "", err
+	}
+	sort.Strings(ids)
+
+	pageIDs, nextToken, err := listPage(ids, limit, continueToken, func(id string) (bool, error) {
+		return filterPodSandboxMeta(&memPodSandboxMeta{store: s, id: id}, filter)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	var result []PodSandboxMetadata
+	for _, id := range pageIDs {
+		result = append(result, memPodSandboxMeta{store: s, id: id})
+	}
+	return result, nextToken, nil
+}
+
+type memContainerMeta struct {
+	store  *MemStore
+	id     string
+	origin *auditOrigin
+}
+
+// GetID implements GetID method of ContainerMetadata interface
+func (m memContainerMeta) GetID() string {
+	return m.id
+}
+
+// Retrieve implements Retrieve method of ContainerMetadata interface
+func (m memContainerMeta) Retrieve() (*types.ContainerInfo, error) {
+	if m.GetID() == "" {
+		return nil, errors.New("Container ID cannot be empty")
+	}
+	var ci *types.ContainerInfo
+	err := m.store.view("Container.Retrieve", m.GetID(), func(st *memState) error {
+		data := st.containers[m.GetID()]
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &ci)
+	})
+	return ci, err
+}
+
+// Save implements Save method of ContainerMetadata interface
+func (m memContainerMeta) Save(updater func(*types.ContainerInfo) (*types.ContainerInfo, error)) error {
+	if m.GetID() == "" {
+		return errors.New("Container ID cannot be empty")
+	}
+	return m.store.watchers.update(m.origin, func() (*Event, error) {
+		var event *Event
+		if err := m.store.update("Container.Save", m.GetID(), func(st *memState) error {
+			var err error
+			event, err = st.saveContainer(m.GetID(), updater)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		return event, nil
+	})
+}
+
+// saveContainer updates the container with given ID in the state,
+// returning the event that describes the change, if any.
+func (st *memState) saveContainer(containerID string, updater func(*types.ContainerInfo) (*types.ContainerInfo, error)) (*Event, error) {
+	var current, newData *types.ContainerInfo
+	var oldPodID string
+	if data := st.containers[containerID]; data != nil {
+		if err := json.Unmarshal(data, &current); err != nil {
+			return nil, err
+		}
+		oldPodID = current.Config.PodSandboxID
+	}
+	var err error
+	newData, err = updateContainer(current, updater, st.nextRevision)
+	if err != nil {
+		return nil, err
+	}
+
+	if current == nil && newData == nil {
+		return nil, nil
+	}
+
+	if newData == nil {
+		if sb := st.getSandbox(oldPodID, false); oldPodID != "" && sb != nil {
+			delete(sb.containers, containerID)
+		}
+		delete(st.containers, containerID)
+		return containerEvent(containerID, current, nil), nil
+	}
+	newData.Id = containerID
+	data, err := json.Marshal(newData)
+	if err != nil {
+		return nil, err
+	}
+
+	if oldPodID != newData.Config.PodSandboxID {
+		if sb := st.getSandbox(oldPodID, false); oldPodID != "" && sb != nil {
+			delete(sb.containers, containerID)
+		}
+		if newData.Config.PodSandboxID != "" {
+			st.getSandbox(newData.Config.PodSandboxID, true).containers[containerID] = true
+		}
+	}
+	st.containers[containerID] = data
+	return containerEvent(containerID, current, newData), nil
+}
+
+// Container implements Container method of ContainerStore interface
+func (s *MemStore) Container(containerID string) ContainerMetadata {
+	return s.containerAs(nil, containerID)
+}
+
+// containerAs implements containerAs method of auditedBackend interface
+func (s *MemStore) containerAs(origin *auditOrigin, containerID string) ContainerMetadata {
+	return &memContainerMeta{id: containerID, store: s, origin: origin}
+}
+
+type memTx struct {
+	st     *memState
+	policy SandboxRemovalPolicy
+	events []*Event
+}
+
+var _ Tx = &memTx{}
+
+// SavePodSandbox implements SavePodSandbox method of Tx interface
+func (t *memTx) SavePodSandbox(podID string, updater func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error)) error {
+	if podID == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	events, err := t.st.savePodSandbox(t.policy, podID, updater)
+	if err != nil {
+		return err
+	}
+	t.events = append(t.events, events...)
+	return nil
+}
+
+// SaveContainer implements SaveContainer method of Tx interface
+func (t *memTx) SaveContainer(containerID string, updater func(*types.ContainerInfo) (*types.ContainerInfo, error)) error {
+	if containerID == "" {
+		return errors.New("Container ID cannot be empty")
+	}
+	event, err := t.st.saveContainer(containerID, updater)
+	if err != nil {
+		return err
+	}
+	t.events = append(t.events, eventList(event)...)
+	return nil
+}
+
+// PodContainerIDs implements PodContainerIDs method of Tx interface
+func (t *memTx) PodContainerIDs(podID string) ([]string, error) {
+	if podID == "" {
+		return nil, errors.New("Pod sandbox ID cannot be empty")
+	}
+	sb := t.st.getSandbox(podID, false)
+	if sb == nil {
+		return nil, nil
+	}
+	var ids []string
+	for id := range sb.containers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Update implements Update method of TransactionStore interface
+func (s *MemStore) Update(fn func(tx Tx) error) error {
+	return s.updateAs(nil, fn)
+}
+
+// updateAs implements updateAs method of auditedBackend interface
+func (s *MemStore) updateAs(origin *auditOrigin, fn func(tx Tx) error) error {
+	return s.watchers.updateMulti(origin, func() ([]*Event, error) {
+		var events []*Event
+		if err := s.update("Update", "", func(st *memState) error {
+			t := &memTx{st: st, policy: s.sandboxRemovalPolicy()}
+			if err := fn(t); err != nil {
+				return err
+			}
+			events = t.events
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		return events, nil
+	})
+}
+
+// ListPodContainers implements ListPodContainers method of ContainerStore interface
+func (s *MemStore) ListPodContainers(podID string) ([]ContainerMetadata, error) {
+	if podID == "" {
+		return nil, errors.New("Pod sandbox ID cannot be empty")
+	}
+	var ids []string
+	if err := s.view("ListPodContainers", podID, func(st *memState) error {
+		sb := st.getSandbox(podID, false)
+		if sb == nil {
+			return fmt.Errorf("pod sandbox %q does not exist", podID)
+		}
+		for id := range sb.containers {
+			ids = append(ids, id)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	var result []ContainerMetadata
+	for _, id := range ids {
+		result = append(result, s.Container(id))
+	}
+	return result, nil
+}
+
+// ListContainersPage implements ListContainersPage method of ContainerStore interface
+func (s *MemStore) ListContainersPage(limit int, continueToken string) ([]ContainerMetadata, string, error) {
+	var ids []string
+	if err := s.view("ListContainers", "", func(st *memState) error {
+		for id := range st.containers {
+			ids = append(ids, id)
+		}
+		return nil
+	}); err != nil {
+		return nil, "", err
+	}
+	sort.Strings(ids)
+
+	pageIDs, nextToken, err := listPage(ids, limit, continueToken, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	var result []ContainerMetadata
+	for _, id := range pageIDs {
+		result = append(result, s.Container(id))
+	}
+	return result, nextToken, nil
+}
+
+// ListContainerInfos implements ListContainerInfos method of ContainerStore interface.
+// The data is taken from the last committed state of the store.
+func (s *MemStore) ListContainerInfos() ([]*types.ContainerInfo, error) {
+	var result []*types.ContainerInfo
+	if err := s.view("ListContainerInfos", "", func(st *memState) error {
+		var ids []string
+		for id := range st.containers {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			var ci *types.ContainerInfo
+			if err := json.Unmarshal(st.containers[id], &ci); err != nil {
+				return err
+			}
+			result = append(result, ci)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ImagesInUse implements ImagesInUse method of ContainerStore interface
+func (s *MemStore) ImagesInUse() (map[string]bool, error) {
+	result := make(map[string]bool)
+	if err := s.view("ImagesInUse", "", func(st *memState) error {
+		for _, sb := range st.sandboxes {
+			for containerID := range sb.containers {
+				data := st.containers[containerID]
+				if data == nil {
+					return fmt.Errorf("containerInfo of container %q not found in Virtlet metadata store", containerID)
+				}
+				var ci *types.ContainerInfo
+				if err := json.Unmarshal(data, &ci); err != nil {
+					return err
+				}
+				result[ci.Config.Image] = true
+				if ci.Config.ParsedAnnotations != nil {
+					for _, image := range ci.Config.ParsedAnnotations.CDROMImages {
+						result[image] = true
+					}
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AddStartRecord implements AddStartRecord method of StartRecordStore interface
+func (s *MemStore) AddStartRecord(record *types.VMStartRecord) error {
+	if record.PodName == "" {
+		return errors.New("Pod name cannot be empty")
+	}
+	return s.update("AddStartRecord", record.PodName, func(st *memState) error {
+		st.startRecordSeq++
+		record.ID = st.startRecordSeq
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		st.startRecords[string(startRecordKey(record.PodNamespace, record.PodName, record.ID))] = data
+		st.pruneStartRecords(string(startRecordPrefix(record.PodNamespace, record.PodName)), maxStartRecordsPerPod)
+		st.pruneStartRecords("", maxStartRecords)
+		return nil
+	})
+}
+
+// pruneStartRecords removes the oldest start records with the
+// specified key prefix so that no more than maxCount of such
+// records remain.
+func (st *memState) pruneStartRecords(prefix string, maxCount int) {
+	var keys []string
+	for k := range st.startRecords {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) <= maxCount {
+		return
+	}
+	sort.Slice(keys, func(i, j int) bool { return startRecordID([]byte(keys[i])) < startRecordID([]byte(keys[j])) })
+	for _, k := range keys[:len(keys)-maxCount] {
+		delete(st.startRecords, k)
+	}
+}
+
+// ListStartRecords implements ListStartRecords method of StartRecordStore interface
+func (s *MemStore) ListStartRecords(podNamespace, podName string) ([]*types.VMStartRecord, error) {
+	var prefix string
+	if podName != "" {
+		prefix = string(startRecordPrefix(podNamespace, podName))
+	}
+	var records []*types.VMStartRecord
+	if err := s.view("ListStartRecords", podName, func(st *memState) error {
+		for k, v := range st.startRecords {
+			if !strings.HasPrefix(k, prefix) {
+				continue
+			}
+			var record *types.VMStartRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return fmt.Errorf("error unmarshalling start record %q: %v", k, err)
+			}
+			records = append(records, record)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records, nil
+}
+
+func (st *memState) sandboxTombstones() ([]*types.SandboxTombstone, error) {
+	var tombstones []*types.SandboxTombstone
+	for k, v := range st.tombstones {
+		var tombstone *types.SandboxTombstone
+		if err := json.Unmarshal(v, &tombstone); err != nil {
+			return nil, fmt.Errorf("error unmarshalling sandbox tombstone %q: %v", k, err)
+		}
+		tombstones = append(tombstones, tombstone)
+	}
+	sortSandboxTombstones(tombstones)
+	return tombstones, nil
+}
+
+// AddSandboxTombstone implements AddSandboxTombstone method of SandboxTombstoneStore interface
+func (s *MemStore) AddSandboxTombstone(tombstone *types.SandboxTombstone) error {
+	if tombstone.PodSandboxID == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	return s.update("AddSandboxTombstone", tombstone.PodSandboxID, func(st *memState) error {
+		data, err := json.Marshal(tombstone)
+		if err != nil {
+			return err
+		}
+		st.tombstones[tombstone.PodSandboxID] = data
+		tombstones, err := st.sandboxTombstones()
+		if err != nil {
+			return err
+		}
+		if len(tombstones) > maxSandboxTombstones {
+			for _, t := range tombstones[:len(tombstones)-maxSandboxTombstones] {
+				delete(st.tombstones, t.PodSandboxID)
+			}
+		}
+		return nil
+	})
+}
+
+// ListSandboxTombstones implements ListSandboxTombstones method of SandboxTombstoneStore interface
+func (s *MemStore) ListSandboxTombstones() ([]*types.SandboxTombstone, error) {
+	var tombstones []*types.SandboxTombstone
+	if err := s.view("ListSandboxTombstones", "", func(st *memState) error {
+		var err error
+		tombstones, err = st.sandboxTombstones()
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return tombstones, nil
+}
+
+// PruneSandboxTombstones implements PruneSandboxTombstones method of SandboxTombstoneStore interface
+func (s *MemStore) PruneSandboxTombstones(before int64) (int, error) {
+	n := 0
+	if err := s.update("PruneSandboxTombstones", "", func(st *memState) error {
+		tombstones, err := st.sandboxTombstones()
+		if err != nil {
+			return err
+		}
+		for _, t := range tombstones {
+			if t.DeletedAt >= before {
+				break
+			}
+			delete(st.tombstones, t.PodSandboxID)
+			n++
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// AddSandboxEvent implements AddSandboxEvent method of SandboxEventStore interface
+func (s *MemStore) AddSandboxEvent(event *types.SandboxEvent) error {
+	if event.PodSandboxID == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	return s.update("AddSandboxEvent", event.PodSandboxID, func(st *memState) error {
+		st.eventSeq++
+		event.ID = st.eventSeq
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		st.events[string(sandboxEventKey(event.PodSandboxID, event.ID))] = data
+		st.pruneSandboxEvents(string(sandboxEventPrefix(event.PodSandboxID)), maxSandboxEventsPerPod)
+		st.pruneSandboxEvents("", maxSandboxEvents)
+		return nil
+	})
+}
+
+// pruneSandboxEvents removes the oldest events with the specified
+// key prefix so that no more than maxCount of such events remain.
+func (st *memState) pruneSandboxEvents(prefix string, maxCount int) {
+	var keys []string
+	for k := range st.events {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) <= maxCount {
+		return
+	}
+	sort.Slice(keys, func(i, j int) bool { return sandboxEventID([]byte(keys[i])) < sandboxEventID([]byte(keys[j])) })
+	for _, k := range keys[:len(keys)-maxCount] {
+		delete(st.events, k)
+	}
+}
+
+// ListSandboxEvents implements ListSandboxEvents method of SandboxEventStore interface
+func (s *MemStore) ListSandboxEvents(podID string) ([]*types.SandboxEvent, error) {
+	var prefix string
+	if podID != "" {
+		prefix = string(sandboxEventPrefix(podID))
+	}
+	var events []*types.SandboxEvent
+	if err := s.view("ListSandboxEvents", podID, func(st *memState) error {
+		for k, v := range st.events {
+			if !strings.HasPrefix(k, prefix) {
+				continue
+			}
+			var event *types.SandboxEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return fmt.Errorf("error unmarshalling sandbox event %q: %v", k, err)
+			}
+			events = append(events, event)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, nil
+}
+
+// GetFirstBootRecord implements GetFirstBootRecord method of FirstBootStore interface
+func (s *MemStore) GetFirstBootRecord(volumeID string) (*types.FirstBootRecord, error) {
+	if volumeID == "" {
+		return nil, errors.New("Volume ID cannot be empty")
+	}
+	var record *types.FirstBootRecord
+	err := s.view("GetFirstBootRecord", volumeID, func(st *memState) error {
+		data := st.firstBoot[volumeID]
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &record)
+	})
+	return record, err
+}
+
+// SaveFirstBootRecord implements SaveFirstBootRecord method of FirstBootStore interface
+func (s *MemStore) SaveFirstBootRecord(volumeID string, updater func(*types.FirstBootRecord) (*types.FirstBootRecord, error)) error {
+	if volumeID == "" {
+		return errors.New("Volume ID cannot be empty")
+	}
+	return s.update("SaveFirstBootRecord", volumeID, func(st *memState) error {
+		var current *types.FirstBootRecord
+		if data := st.firstBoot[volumeID]; data != nil {
+			if err := json.Unmarshal(data, &current); err != nil {
+				return err
+			}
+		}
+		record, err := updater(current)
+		switch {
+		case err != nil:
+			return err
+		case record == nil:
+			delete(st.firstBoot, volumeID)
+			return nil
+		}
+		record.VolumeID = volumeID
+		st.firstBoot[volumeID], err = json.Marshal(record)
+		return err
+	})
+}
+
+// GetImagePullJob implements GetImagePullJob method of ImagePullStore interface
+func (s *MemStore) GetImagePullJob(imageName string) (*types.ImagePullJob, error) {
+	if imageName == "" {
+		return nil, errors.New("Image name cannot be empty")
+	}
+	var job *types.ImagePullJob
+	err := s.view("GetImagePullJob", imageName, func(st *memState) error {
+		data := st.imagePulls[imageName]
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &job)
+	})
+	return job, err
+}
+
+// SaveImagePullJob implements SaveImagePullJob method of ImagePullStore interface
+func (s *MemStore) SaveImagePullJob(imageName string, updater func(*types.ImagePullJob) (*types.ImagePullJob, error)) error {
+	return s.saveImagePullJobAs(nil, imageName, updater)
+}
+
+// saveImagePullJobAs implements saveImagePullJobAs method of auditedBackend interface
+func (s *MemStore) saveImagePullJobAs(origin *auditOrigin, imageName string, updater func(*types.ImagePullJob) (*types.ImagePullJob, error)) error {
+	if imageName == "" {
+		return errors.New("Image name cannot be empty")
+	}
+	updater, audit := s.watchers.auditImagePullJob(origin, imageName, updater)
+	return audit(s.update("SaveImagePullJob", imageName, func(st *memState) error {
+		var current *types.ImagePullJob
+		if data := st.imagePulls[imageName]; data != nil {
+			if err := json.Unmarshal(data, &current); err != nil {
+				return err
+			}
+		}
+		job, err := updater(current)
+		switch {
+		case err != nil:
+			return err
+		case job == nil:
+			delete(st.imagePulls, imageName)
+			return nil
+		}
+		job.ImageName = imageName
+		st.imagePulls[imageName], err = json.Marshal(job)
+		return err
+	}))
+}
+
+// ListImagePullJobs implements ListImagePullJobs method of ImagePullStore interface
+func (s *MemStore) ListImagePullJobs() ([]*types.ImagePullJob, error) {
+	var jobs []*types.ImagePullJob
+	err := s.view("ListImagePullJobs", "", func(st *memState) error {
+		for _, k := range sortedMemKeys(st.imagePulls) {
+			var job *types.ImagePullJob
+			if err := json.Unmarshal(st.imagePulls[k], &job); err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+		}
+		return nil
+	})
+	return jobs, err
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/fake"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func TestMemStore(t *testing.T) {
+	oldNewTestStore := newTestStore
+	newTestStore = func() (Store, error) { return NewMemStore(), nil }
+	defer func() { newTestStore = oldNewTestStore }()
+
+	// the tests that use golden data are not run here
+	// as they would need separate data files
+	for _, tc := range []struct {
+		name string
+		test func(t *testing.T)
+	}{
+		{"SetGetContainerInfo", TestSetGetContainerInfo},
+		{"ListContainerInfos", TestListContainerInfos},
+		{"GetImagesInUse", TestGetImagesInUse},
+		{"RemoveContainer", TestRemoveContainer},
+		{"FirstBootRecords", TestFirstBootRecords},
+		{"ImagePullJobs", TestImagePullJobs},
+		{"RemovePodSandbox", TestRemovePodSandbox},
+		{"SandboxRemovalPolicy", TestSandboxRemovalPolicy},
+		{"Retrieve", TestRetrieve},
+		{"SetGetPodSandboxStatus", TestSetGetPodSandboxStatus},
+		{"ListPodSandbox", TestListPodSandbox},
+		{"PodSandboxLabelIndex", TestPodSandboxLabelIndex},
+		{"ListPages", TestListPages},
+		{"StartRecords", TestStartRecords},
+		{"SandboxTombstones", TestSandboxTombstones},
+		{"SandboxEvents", TestSandboxEvents},
+		{"Update", TestUpdate},
+		{"Watch", TestWatch},
+		{"Revisions", TestRevisions},
+	} {
+		t.Run(tc.name, tc.test)
+	}
+}
+
+func TestMemStoreFaultInjection(t *testing.T) {
+	sandboxes := fake.GetSandboxes(1)
+	containers := fake.GetContainersConfig(sandboxes)
+	store := NewMemStore()
+	for _, container := range containers {
+		if err := store.Container(container.ContainerID).Save(func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
+			return &types.ContainerInfo{
+				Name:   container.Name,
+				Config: types.VMConfig{PodSandboxID: container.SandboxID, Image: container.Image},
+			}, nil
+		}); err != nil {
+			t.Fatalf("Container().Save(): %v", err)
+		}
+	}
+	containerID := containers[0].ContainerID
+
+	var ops []string
+	expectedErr := errors.New("injected fault")
+	store.SetFaultInjector(func(op, id string) error {
+		ops = append(ops, op+":"+id)
+		if op == "Container.Save" {
+			return expectedErr
+		}
+		return nil
+	})
+
+	updaterCalled := false
+	if err := store.Container(containerID).Save(func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
+		updaterCalled = true
+		return nil, nil
+	}); err != expectedErr {
+		t.Errorf("Container().Save() returned %v instead of the injected error", err)
+	}
+	if !updaterCalled {
+		t.Errorf("the updater was not called")
+	}
+	ci, err := store.Container(containerID).Retrieve()
+	if err != nil {
+		t.Fatalf("Container().Retrieve(): %v", err)
+	}
+	if ci == nil {
+		t.Errorf("the container was removed by the failed transaction")
+	}
+	podContainers, err := store.ListPodContainers(sandboxes[0].Uid)
+	if err != nil {
+		t.Fatalf("ListPodContainers(): %v", err)
+	}
+	if len(podContainers) != 1 || podContainers[0].GetID() != containerID {
+		t.Errorf("bad pod containers after the failed transaction: %#v", podContainers)
+	}
+
+	expectedOps := []string{
+		"Container.Save:" + containerID,
+		"Container.Retrieve:" + containerID,
+		"ListPodContainers:" + sandboxes[0].Uid,
+	}
+	if !reflect.DeepEqual(ops, expectedOps) {
+		t.Errorf("bad ops: %#v instead of %#v", ops, expectedOps)
+	}
+
+	store.SetFaultInjector(func(op, id string) error {
+		if op == "ImagesInUse" {
+			return expectedErr
+		}
+		return nil
+	})
+	if _, err := store.ImagesInUse(); err != expectedErr {
+		t.Errorf("ImagesInUse() returned %v instead of the injected error", err)
+	}
+
+	store.SetFaultInjector(nil)
+	if err := store.Container(containerID).Save(func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
+		return nil, nil
+	}); err != nil {
+		t.Errorf("Container().Save(): %v", err)
+	}
+	if ci, err := store.Container(containerID).Retrieve(); err != nil || ci != nil {
+		t.Errorf("container not removed: %#v, %v", ci, err)
+	}
+}
+
+func TestMemStoreReadsDuringUpdate(t *testing.T) {
+	store := NewMemStore()
+	if err := store.SaveImagePullJob("foo", func(job *types.ImagePullJob) (*types.ImagePullJob, error) {
+		return &types.ImagePullJob{BytesTotal: 42}, nil
+	}); err != nil {
+		t.Fatalf("SaveImagePullJob(): %v", err)
+	}
+	if err := store.SaveImagePullJob("foo", func(job *types.ImagePullJob) (*types.ImagePullJob, error) {
+		// the reads made while the update is in progress
+		// see the last committed state
+		inner, err := store.GetImagePullJob("foo")
+		if err != nil {
+			t.Errorf("GetImagePullJob(): %v", err)
+		} else if inner == nil || inner.BytesDone != 0 {
+			t.Errorf("bad job retrieved during the update: %#v", inner)
+		}
+		job.BytesDone = 42
+		return job, nil
+	}); err != nil {
+		t.Fatalf("SaveImagePullJob(): %v", err)
+	}
+	job, err := store.GetImagePullJob("foo")
+	if err != nil {
+		t.Fatalf("GetImagePullJob(): %v", err)
+	}
+	expectedJob := &types.ImagePullJob{ImageName: "foo", BytesDone: 42, BytesTotal: 42}
+	if !reflect.DeepEqual(job, expectedJob) {
+		t.Errorf("bad image pull job: %#v instead of %#v", job, expectedJob)
+	}
+}
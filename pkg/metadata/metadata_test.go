@@ -19,15 +19,23 @@ package metadata
 import (
 	"testing"
 
-	"github.com/boltdb/bolt"
 	"github.com/jonboulle/clockwork"
+	bolt "go.etcd.io/bbolt"
 
 	"github.com/Mirantis/virtlet/pkg/metadata/fake"
 	"github.com/Mirantis/virtlet/pkg/metadata/types"
 )
 
+// newTestStore is used to make the stores for the tests. It's
+// replaced in TestMemStore to run the tests against MemStore.
+var newTestStore = NewFakeStore
+
 func dumpDB(t *testing.T, store Store, context string) error {
-	db := store.(*boltClient).db
+	client, ok := store.(*boltClient)
+	if !ok {
+		return nil
+	}
+	db := client.db
 	t.Logf("==[ %s ]==> Start DB dump", context)
 	err := db.View(func(tx *bolt.Tx) error {
 		var iterateOverElements func(tx *bolt.Tx, bucket *bolt.Bucket, indent string)
@@ -62,7 +70,7 @@ func dumpDB(t *testing.T, store Store, context string) error {
 }
 
 func setUpTestStore(t *testing.T, sandboxConfigs []*types.PodSandboxConfig, containerConfigs []*fake.ContainerTestConfig, clock clockwork.Clock) Store {
-	store, err := NewFakeStore()
+	store, err := newTestStore()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,145 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	metricsNamespace = "virtlet"
+	metricsSubsystem = "metadata"
+
+	txTypeRead  = "read"
+	txTypeWrite = "write"
+)
+
+var (
+	dbSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, metricsSubsystem, "db_size_bytes"),
+		"Size of the metadata database in bytes.",
+		nil, nil)
+	bucketEntriesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, metricsSubsystem, "bucket_entries"),
+		"Number of entries in the metadata database buckets. For the sandboxes, this is the number of pod sandbox buckets.",
+		[]string{"bucket"}, nil)
+)
+
+// storeMetrics holds the metrics of the bolt metadata store that
+// are updated as the store is being used.
+type storeMetrics struct {
+	txDuration   *prometheus.HistogramVec
+	batchSize    prometheus.Histogram
+	batchRetries prometheus.Counter
+}
+
+func newStoreMetrics() *storeMetrics {
+	return &storeMetrics{
+		txDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "transaction_duration_seconds",
+			Help:      "Duration of the metadata database transactions in seconds, including the time spent waiting for the database lock.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+		}, []string{"type"}),
+		batchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "write_batch_size",
+			Help:      "Number of the updates committed within a single write transaction.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 8),
+		}),
+		batchRetries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "write_batch_retries_total",
+			Help:      "Number of the write batches that were rolled back and retried because one of their updates has failed.",
+		}),
+	}
+}
+
+// timeTx invokes fn which runs a transaction of the specified type,
+// recording its duration.
+func (m *storeMetrics) timeTx(txType string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	m.txDuration.WithLabelValues(txType).Observe(time.Since(start).Seconds())
+	return err
+}
+
+func (b boltClient) view(fn func(tx *bolt.Tx) error) error {
+	return b.metrics.timeTx(txTypeRead, func() error { return b.db.View(fn) })
+}
+
+func (b boltClient) update(fn func(tx *bolt.Tx) error) error {
+	return b.metrics.timeTx(txTypeWrite, func() error { return b.db.Update(fn) })
+}
+
+var _ prometheus.Collector = boltClient{}
+
+// Describe implements Describe method of prometheus.Collector
+// interface.
+func (b boltClient) Describe(ch chan<- *prometheus.Desc) {
+	b.metrics.txDuration.Describe(ch)
+	b.metrics.batchSize.Describe(ch)
+	b.metrics.batchRetries.Describe(ch)
+	ch <- dbSizeDesc
+	ch <- bucketEntriesDesc
+}
+
+// Collect implements Collect method of prometheus.Collector
+// interface. The size of the database and the bucket entry counts
+// are retrieved within a read-only transaction each time the metrics
+// are collected.
+func (b boltClient) Collect(ch chan<- prometheus.Metric) {
+	b.metrics.txDuration.Collect(ch)
+	b.metrics.batchSize.Collect(ch)
+	b.metrics.batchRetries.Collect(ch)
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		ch <- prometheus.MustNewConstMetric(dbSizeDesc, prometheus.GaugeValue, float64(tx.Size()))
+		// the sandbox count is reported even if there are no sandboxes
+		counts := map[string]int{"sandboxes": 0}
+		if err := tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			if bytes.HasPrefix(name, sandboxKeyPrefix) {
+				counts["sandboxes"]++
+				return nil
+			}
+			n := 0
+			if err := bucket.ForEach(func(k, v []byte) error {
+				n++
+				return nil
+			}); err != nil {
+				return err
+			}
+			counts[string(name)] = n
+			return nil
+		}); err != nil {
+			return err
+		}
+		for name, n := range counts {
+			ch <- prometheus.MustNewConstMetric(bucketEntriesDesc, prometheus.GaugeValue, float64(n), name)
+		}
+		return nil
+	}); err != nil {
+		glog.Warningf("Error collecting the metadata database metrics: %v", err)
+	}
+}
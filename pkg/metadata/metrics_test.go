@@ -0,0 +1,97 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestStoreMetrics(t *testing.T) {
+	store, cleanup := newBatchTestStore(t, defaultMaxBatchSize)
+	defer cleanup()
+
+	for _, id := range []string{"container1", "container2"} {
+		if err := saveBatchTestContainer(store, id); err != nil {
+			t.Fatalf("Save(): %v", err)
+		}
+	}
+	if _, err := store.Container("container1").Retrieve(); err != nil {
+		t.Fatalf("Retrieve(): %v", err)
+	}
+
+	// make a batch fail so it's retried
+	calls := []*batchCall{
+		{
+			fn:  func(tx *bolt.Tx) ([]*Event, error) { return nil, nil },
+			err: make(chan error, 1),
+		},
+		{
+			fn:  func(tx *bolt.Tx) ([]*Event, error) { return nil, errors.New("oops") },
+			err: make(chan error, 1),
+		},
+	}
+	store.batch.commit(calls)
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(store); err != nil {
+		t.Fatalf("Register(): %v", err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather(): %v", err)
+	}
+
+	var dbSize float64
+	bucketEntries := make(map[string]float64)
+	txCounts := make(map[string]uint64)
+	var retries float64
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			switch mf.GetName() {
+			case "virtlet_metadata_db_size_bytes":
+				dbSize = m.GetGauge().GetValue()
+			case "virtlet_metadata_bucket_entries":
+				bucketEntries[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+			case "virtlet_metadata_transaction_duration_seconds":
+				txCounts[m.GetLabel()[0].GetValue()] = m.GetHistogram().GetSampleCount()
+			case "virtlet_metadata_write_batch_retries_total":
+				retries = m.GetCounter().GetValue()
+			}
+		}
+	}
+
+	if dbSize <= 0 {
+		t.Errorf("bad db size: %v", dbSize)
+	}
+	if bucketEntries["containers"] != 2 || bucketEntries["sandboxes"] != 2 {
+		t.Errorf("bad bucket entry counts: %v", bucketEntries)
+	}
+	// 2 saves + the failed batch + the failed update + the rest of the batch
+	if txCounts[txTypeWrite] != 5 {
+		t.Errorf("bad number of write transactions: %d", txCounts[txTypeWrite])
+	}
+	if txCounts[txTypeRead] != 1 {
+		t.Errorf("bad number of read transactions: %d", txCounts[txTypeRead])
+	}
+	if retries != 1 {
+		t.Errorf("bad number of batch retries: %v", retries)
+	}
+}
@@ -0,0 +1,306 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const (
+	// migrationOpenTimeout is the time to wait for the database
+	// lock when the database is opened by MigrateDB
+	migrationOpenTimeout = 10 * time.Second
+)
+
+var (
+	schemaBucket     = []byte("schema")
+	schemaVersionKey = []byte("version")
+	errDryRun        = errors.New("dry run")
+)
+
+// MigrationStep describes a step that upgrades the layout of the
+// metadata kept in the database to the next schema version.
+type MigrationStep struct {
+	// Version is the schema version the step upgrades to
+	Version int
+	// Description is a human-readable description of the step
+	Description string
+}
+
+type migration struct {
+	MigrationStep
+	// migrate performs the migration. The pod sandbox and
+	// container records may be encrypted, so they must be read
+	// and written using the cipher
+	migrate func(tx *bolt.Tx, c *valueCipher) error
+}
+
+// migrations lists the migration steps in the order they must be
+// applied. The step for version N upgrades the database from
+// version N-1 to N. The steps work with the raw JSON data instead
+// of the types from pkg/metadata/types so that they don't break
+// when these types change. New steps must only be appended to the
+// end of the list.
+var migrations = []migration{
+	{
+		MigrationStep: MigrationStep{
+			Version:     1,
+			Description: "initial schema",
+		},
+	},
+	{
+		MigrationStep: MigrationStep{
+			Version:     2,
+			Description: "normalize pod sandbox hostnames",
+		},
+		migrate: normalizeSandboxHostnames,
+	},
+	{
+		MigrationStep: MigrationStep{
+			Version:     3,
+			Description: "index pod sandbox labels",
+		},
+		migrate: indexSandboxLabels,
+	},
+}
+
+// CurrentSchemaVersion returns the metadata schema version that's
+// used by this Virtlet build.
+func CurrentSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// MigrateDB upgrades the bolt database at the specified path to
+// the current schema version, returning the steps that were
+// applied. If dryRun is true, the database is left intact, with
+// the returned steps being the ones that would be applied. key is
+// the metadata encryption key, which is needed if the database
+// contains encrypted records. It may be nil otherwise.
+func MigrateDB(path string, key []byte, dryRun bool) ([]MigrationStep, error) {
+	var c *valueCipher
+	if key != nil {
+		var err error
+		if c, err = newValueCipher(key); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: migrationOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return migrateDB(db, c, dryRun)
+}
+
+// migrateDB applies the pending migrations to the database within
+// a single transaction, so either all of them are applied or none.
+// In dry run mode, the migrations are still performed so that the
+// errors are detected, but the transaction is rolled back.
+func migrateDB(db *bolt.DB, c *valueCipher, dryRun bool) ([]MigrationStep, error) {
+	var steps []MigrationStep
+	err := db.Update(func(tx *bolt.Tx) error {
+		version, err := getSchemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		current := CurrentSchemaVersion()
+		if version > current {
+			return fmt.Errorf("metadata schema version %d is newer than the version %d supported by this Virtlet build", version, current)
+		}
+		for _, m := range migrations {
+			if m.Version <= version {
+				continue
+			}
+			if m.migrate != nil {
+				if err := m.migrate(tx, c); err != nil {
+					return fmt.Errorf("metadata migration to schema version %d (%s) failed: %v", m.Version, m.Description, err)
+				}
+			}
+			steps = append(steps, m.MigrationStep)
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return setSchemaVersion(tx, current)
+	})
+	if err != nil && err != errDryRun {
+		return nil, err
+	}
+	if !dryRun {
+		for _, step := range steps {
+			glog.V(1).Infof("Applied metadata migration to schema version %d: %s", step.Version, step.Description)
+		}
+	}
+	return steps, nil
+}
+
+// getSchemaVersion returns the schema version of the database.
+// Fresh databases are considered to have the current version and
+// the databases that were created before the schema versioning
+// was introduced have version 0.
+func getSchemaVersion(tx *bolt.Tx) (int, error) {
+	if bucket := tx.Bucket(schemaBucket); bucket != nil {
+		if data := bucket.Get(schemaVersionKey); data != nil {
+			version, err := strconv.Atoi(string(data))
+			if err != nil {
+				return 0, fmt.Errorf("bad metadata schema version %q", data)
+			}
+			return version, nil
+		}
+	}
+	if k, _ := tx.Cursor().First(); k == nil {
+		return CurrentSchemaVersion(), nil
+	}
+	return 0, nil
+}
+
+func setSchemaVersion(tx *bolt.Tx, version int) error {
+	bucket, err := tx.CreateBucketIfNotExists(schemaBucket)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(schemaVersionKey, []byte(strconv.Itoa(version)))
+}
+
+// updateSandboxRecords invokes the specified function for the JSON
+// data of each pod sandbox, saving the data if it was changed. The
+// encrypted records are decrypted before the update and encrypted
+// again when they're saved.
+func updateSandboxRecords(tx *bolt.Tx, c *valueCipher, update func(psi map[string]interface{}) (bool, error)) error {
+	var keys [][]byte
+	c := tx.Cursor()
+	for k, _ := c.Seek(sandboxKeyPrefix); k != nil && bytes.HasPrefix(k, sandboxKeyPrefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for _, k := range keys {
+		bucket := tx.Bucket(k)
+		if bucket == nil {
+			continue
+		}
+		data := bucket.Get(sandboxDataBucket)
+		if data == nil {
+			continue
+		}
+		data, err := c.open(data)
+		if err != nil {
+			return fmt.Errorf("pod sandbox %q: %v", k[len(sandboxKeyPrefix):], err)
+		}
+		// UseNumber keeps the timestamps intact as they don't
+		// fit into float64 mantissa
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var psi map[string]interface{}
+		if err := decoder.Decode(&psi); err != nil {
+			return fmt.Errorf("error unmarshalling pod sandbox %q: %v", k[len(sandboxKeyPrefix):], err)
+		}
+		changed, err := update(psi)
+		switch {
+		case err != nil:
+			return fmt.Errorf("pod sandbox %q: %v", k[len(sandboxKeyPrefix):], err)
+		case !changed:
+			continue
+		}
+		newData, err := json.Marshal(psi)
+		if err != nil {
+			return err
+		}
+		if newData, err = c.seal(newData); err != nil {
+			return err
+		}
+		if err := bucket.Put(sandboxDataBucket, newData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeSandboxHostnames converts the hostnames in the pod
+// sandbox configs stored before the hostnames were normalized
+// upon RunPodSandbox to valid RFC 1123 labels. The configs that
+// can't be normalized are left as is. PodSandboxConfig is only
+// used here to apply the normalization rules, with only the
+// hostname being written back.
+func normalizeSandboxHostnames(tx *bolt.Tx, c *valueCipher) error {
+	return updateSandboxRecords(tx, c, func(psi map[string]interface{}) (bool, error) {
+		rawConfig, ok := psi["Config"].(map[string]interface{})
+		if !ok {
+			return false, nil
+		}
+		data, err := json.Marshal(rawConfig)
+		if err != nil {
+			return false, err
+		}
+		var config types.PodSandboxConfig
+		if err := json.Unmarshal(data, &config); err != nil {
+			return false, err
+		}
+		if config.Normalize() != nil || rawConfig["Hostname"] == config.Hostname {
+			return false, nil
+		}
+		rawConfig["Hostname"] = config.Hostname
+		return true, nil
+	})
+}
+
+// indexSandboxLabels fills the labels index for the pod sandboxes
+// stored before the index was introduced.
+func indexSandboxLabels(tx *bolt.Tx, c *valueCipher) error {
+	var keys [][]byte
+	c := tx.Cursor()
+	for k, _ := c.Seek(sandboxKeyPrefix); k != nil && bytes.HasPrefix(k, sandboxKeyPrefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for _, k := range keys {
+		bucket := tx.Bucket(k)
+		if bucket == nil {
+			continue
+		}
+		data := bucket.Get(sandboxDataBucket)
+		if data == nil {
+			continue
+		}
+		data, err := c.open(data)
+		if err != nil {
+			return fmt.Errorf("pod sandbox %q: %v", k[len(sandboxKeyPrefix):], err)
+		}
+		var psi struct {
+			Config *struct {
+				Labels map[string]string
+			}
+		}
+		if err := json.Unmarshal(data, &psi); err != nil {
+			return fmt.Errorf("error unmarshalling pod sandbox %q: %v", k[len(sandboxKeyPrefix):], err)
+		}
+		if psi.Config == nil {
+			continue
+		}
+		if err := updateSandboxLabelIndex(tx, string(k[len(sandboxKeyPrefix):]), nil, psi.Config.Labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
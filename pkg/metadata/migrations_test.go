@@ -0,0 +1,245 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const (
+	testSandboxID = "69eec606-0493-5825-73a4-c5e0c0236155"
+	// oldSandboxData is a pod sandbox record stored by a Virtlet
+	// version that didn't normalize the hostnames
+	oldSandboxData = `{"PodID":"` + testSandboxID + `","Config":{"Name":"foo","Uid":"` + testSandboxID + `","Namespace":"default","Hostname":"Foo_Bar","Labels":{"app":"foo"}},"CreatedAt":1531164300123456789,"State":0}`
+)
+
+func withTestDB(t *testing.T, toCall func(path string)) {
+	path, err := tempfile()
+	if err != nil {
+		t.Fatalf("tempfile(): %v", err)
+	}
+	defer os.Remove(path)
+	toCall(path)
+}
+
+func updateTestDB(t *testing.T, path string, update func(tx *bolt.Tx) error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open(): %v", err)
+	}
+	defer db.Close()
+	if err := db.Update(update); err != nil {
+		t.Fatalf("db.Update(): %v", err)
+	}
+}
+
+func writeOldSandbox(t *testing.T, path string) {
+	updateTestDB(t, path, func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket(sandboxKey(testSandboxID))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(sandboxDataBucket, []byte(oldSandboxData))
+	})
+}
+
+func readTestDB(t *testing.T, path string) (version string, sandboxData string) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open(): %v", err)
+	}
+	defer db.Close()
+	if err := db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(schemaBucket); bucket != nil {
+			version = string(bucket.Get(schemaVersionKey))
+		}
+		if bucket := tx.Bucket(sandboxKey(testSandboxID)); bucket != nil {
+			sandboxData = string(bucket.Get(sandboxDataBucket))
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("db.View(): %v", err)
+	}
+	return
+}
+
+func TestMigrationOrder(t *testing.T) {
+	for n, m := range migrations {
+		if m.Version != n+1 {
+			t.Errorf("migration %q has version %d instead of %d", m.Description, m.Version, n+1)
+		}
+	}
+}
+
+func TestMigrateOldDB(t *testing.T) {
+	withTestDB(t, func(path string) {
+		writeOldSandbox(t, path)
+		expectedSteps := []MigrationStep{
+			{Version: 1, Description: "initial schema"},
+			{Version: 2, Description: "normalize pod sandbox hostnames"},
+			{Version: 3, Description: "index pod sandbox labels"},
+		}
+
+		steps, err := MigrateDB(path, nil, true)
+		if err != nil {
+			t.Fatalf("MigrateDB() in dry run mode: %v", err)
+		}
+		if !reflect.DeepEqual(steps, expectedSteps) {
+			t.Errorf("bad dry run steps: %#v instead of %#v", steps, expectedSteps)
+		}
+		if version, sandboxData := readTestDB(t, path); version != "" || sandboxData != oldSandboxData {
+			t.Errorf("the database was changed during the dry run: version %q, sandbox data %q", version, sandboxData)
+		}
+
+		steps, err = MigrateDB(path, nil, false)
+		if err != nil {
+			t.Fatalf("MigrateDB(): %v", err)
+		}
+		if !reflect.DeepEqual(steps, expectedSteps) {
+			t.Errorf("bad migration steps: %#v instead of %#v", steps, expectedSteps)
+		}
+		if version, _ := readTestDB(t, path); version != strconv.Itoa(CurrentSchemaVersion()) {
+			t.Errorf("bad schema version after the migration: %q", version)
+		}
+
+		store, err := NewStore(path)
+		if err != nil {
+			t.Fatalf("NewStore(): %v", err)
+		}
+		psi, err := store.PodSandbox(testSandboxID).Retrieve()
+		if err != nil {
+			store.Close()
+			t.Fatalf("PodSandbox().Retrieve(): %v", err)
+		}
+		sandboxes, err := store.ListPodSandboxes(&types.PodSandboxFilter{
+			LabelSelector: map[string]string{"app": "foo"},
+		})
+		store.Close()
+		if err != nil {
+			t.Fatalf("ListPodSandboxes(): %v", err)
+		}
+		if len(sandboxes) != 1 || sandboxes[0].GetID() != testSandboxID {
+			t.Errorf("the pod sandbox labels weren't indexed: %#v", sandboxes)
+		}
+		if psi.Config.Hostname != "foo-bar" {
+			t.Errorf("the hostname wasn't normalized: %q", psi.Config.Hostname)
+		}
+		if psi.CreatedAt != 1531164300123456789 {
+			t.Errorf("CreatedAt was changed by the migration: %d", psi.CreatedAt)
+		}
+
+		if steps, err := MigrateDB(path, nil, false); err != nil {
+			t.Errorf("MigrateDB(): %v", err)
+		} else if len(steps) != 0 {
+			t.Errorf("unexpected migration steps for an up-to-date database: %#v", steps)
+		}
+	})
+}
+
+func TestMigrateFreshDB(t *testing.T) {
+	withTestDB(t, func(path string) {
+		store, err := NewStore(path)
+		if err != nil {
+			t.Fatalf("NewStore(): %v", err)
+		}
+		store.Close()
+		if version, _ := readTestDB(t, path); version != strconv.Itoa(CurrentSchemaVersion()) {
+			t.Errorf("bad schema version of a fresh database: %q", version)
+		}
+	})
+}
+
+func TestNewerSchemaVersion(t *testing.T) {
+	withTestDB(t, func(path string) {
+		writeOldSandbox(t, path)
+		updateTestDB(t, path, func(tx *bolt.Tx) error {
+			return setSchemaVersion(tx, CurrentSchemaVersion()+1)
+		})
+		if _, err := MigrateDB(path, nil, true); err == nil {
+			t.Errorf("MigrateDB() didn't fail for a newer schema version")
+		}
+		if store, err := NewStore(path); err == nil {
+			store.Close()
+			t.Errorf("NewStore() didn't fail for a newer schema version")
+		}
+		if _, sandboxData := readTestDB(t, path); sandboxData != oldSandboxData {
+			t.Errorf("the database was changed: %q", sandboxData)
+		}
+	})
+}
+
+func TestMigrateEncryptedDB(t *testing.T) {
+	withTestDB(t, func(path string) {
+		c, err := newValueCipher(testEncryptionKey)
+		if err != nil {
+			t.Fatalf("newValueCipher(): %v", err)
+		}
+		updateTestDB(t, path, func(tx *bolt.Tx) error {
+			bucket, err := tx.CreateBucket(sandboxKey(testSandboxID))
+			if err != nil {
+				return err
+			}
+			data, err := c.seal([]byte(oldSandboxData))
+			if err != nil {
+				return err
+			}
+			return bucket.Put(sandboxDataBucket, data)
+		})
+
+		if _, err := MigrateDB(path, nil, true); err == nil {
+			t.Errorf("MigrateDB() didn't fail for the encrypted records without the key")
+		}
+		if steps, err := MigrateDB(path, testEncryptionKey, true); err != nil {
+			t.Errorf("MigrateDB() in dry run mode: %v", err)
+		} else if len(steps) != 3 {
+			t.Errorf("bad dry run steps: %#v", steps)
+		}
+
+		store, err := NewEncryptedStore(path, testEncryptionKey)
+		if err != nil {
+			t.Fatalf("NewEncryptedStore(): %v", err)
+		}
+		psi, err := store.PodSandbox(testSandboxID).Retrieve()
+		if err != nil {
+			store.Close()
+			t.Fatalf("PodSandbox().Retrieve(): %v", err)
+		}
+		sandboxes, err := store.ListPodSandboxes(&types.PodSandboxFilter{
+			LabelSelector: map[string]string{"app": "foo"},
+		})
+		store.Close()
+		if err != nil {
+			t.Fatalf("ListPodSandboxes(): %v", err)
+		}
+		if len(sandboxes) != 1 || sandboxes[0].GetID() != testSandboxID {
+			t.Errorf("the pod sandbox labels weren't indexed: %#v", sandboxes)
+		}
+		if psi.Config.Hostname != "foo-bar" {
+			t.Errorf("the hostname wasn't normalized: %q", psi.Config.Hostname)
+		}
+		if _, sandboxData := readTestDB(t, path); !isEncryptedValue([]byte(sandboxData)) {
+			t.Errorf("the pod sandbox record was stored without encryption by the migration")
+		}
+	})
+}
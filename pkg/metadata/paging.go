@@ -0,0 +1,134 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+// DefaultPageSize is the page size used by ForEachPodSandbox and
+// ForEachContainer if the specified page size is not positive.
+const DefaultPageSize = 100
+
+var errBadContinueToken = errors.New("bad continue token")
+
+// encodeContinueToken makes a continue token that denotes the
+// position right after the object with the specified id.
+func encodeContinueToken(lastID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(lastID))
+}
+
+// decodeContinueToken returns the id of the last object of the
+// previous page, or an empty string for the first page.
+func decodeContinueToken(continueToken string) (string, error) {
+	if continueToken == "" {
+		return "", nil
+	}
+	lastID, err := base64.RawURLEncoding.DecodeString(continueToken)
+	if err != nil || len(lastID) == 0 {
+		return "", errBadContinueToken
+	}
+	return string(lastID), nil
+}
+
+// listPage returns at most limit ids from the sorted ids list that
+// follow the position denoted by continueToken and for which match
+// returns true, along with the continue token for the next page.
+// The returned token is empty if there are no more matching ids.
+// match may be nil, in which case all the ids match.
+func listPage(ids []string, limit int, continueToken string, match func(id string) (bool, error)) ([]string, string, error) {
+	lastID, err := decodeContinueToken(continueToken)
+	if err != nil {
+		return nil, "", err
+	}
+	var result []string
+	for _, id := range ids[sort.SearchStrings(ids, lastID):] {
+		if id == lastID {
+			continue
+		}
+		if match != nil {
+			matches, err := match(id)
+			switch {
+			case err != nil:
+				return nil, "", err
+			case !matches:
+				continue
+			}
+		}
+		if limit > 0 && len(result) == limit {
+			return result, encodeContinueToken(result[len(result)-1]), nil
+		}
+		result = append(result, id)
+	}
+	return result, "", nil
+}
+
+// ForEachPodSandbox invokes fn for each pod sandbox in the store
+// that matches the filter, retrieving the sandboxes in pages of the
+// specified size so that the store isn't blocked while fn runs.
+// It stops upon the first error returned by fn.
+func ForEachPodSandbox(store SandboxStore, filter *types.PodSandboxFilter, pageSize int, fn func(PodSandboxMetadata) error) error {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	continueToken := ""
+	for {
+		sandboxes, nextToken, err := store.ListPodSandboxesPage(filter, pageSize, continueToken)
+		if err != nil {
+			return err
+		}
+		for _, psm := range sandboxes {
+			if err := fn(psm); err != nil {
+				return err
+			}
+		}
+		if nextToken == "" {
+			return nil
+		}
+		continueToken = nextToken
+	}
+}
+
+// ForEachContainer invokes fn for each container in the store,
+// retrieving the containers in pages of the specified size so that
+// the store isn't blocked while fn runs. It stops upon the first
+// error returned by fn.
+func ForEachContainer(store ContainerStore, pageSize int, fn func(ContainerMetadata) error) error {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	continueToken := ""
+	for {
+		containers, nextToken, err := store.ListContainersPage(pageSize, continueToken)
+		if err != nil {
+			return err
+		}
+		for _, cm := range containers {
+			if err := fn(cm); err != nil {
+				return err
+			}
+		}
+		if nextToken == "" {
+			return nil
+		}
+		continueToken = nextToken
+	}
+}
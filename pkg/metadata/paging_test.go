@@ -0,0 +1,212 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/fake"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func TestListPage(t *testing.T) {
+	ids := []string{"a", "b", "c", "d", "e"}
+	notC := func(id string) (bool, error) { return id != "c", nil }
+	for _, tc := range []struct {
+		name          string
+		limit         int
+		continueToken string
+		match         func(id string) (bool, error)
+		expectedIDs   []string
+		expectedToken string
+		expectError   bool
+	}{
+		{
+			name:        "no limit",
+			expectedIDs: ids,
+		},
+		{
+			name:          "first page",
+			limit:         2,
+			expectedIDs:   []string{"a", "b"},
+			expectedToken: encodeContinueToken("b"),
+		},
+		{
+			name:          "next page",
+			limit:         2,
+			continueToken: encodeContinueToken("b"),
+			expectedIDs:   []string{"c", "d"},
+			expectedToken: encodeContinueToken("d"),
+		},
+		{
+			name:          "last page",
+			limit:         2,
+			continueToken: encodeContinueToken("d"),
+			expectedIDs:   []string{"e"},
+		},
+		{
+			name:          "exactly limit items left",
+			limit:         3,
+			continueToken: encodeContinueToken("b"),
+			expectedIDs:   []string{"c", "d", "e"},
+		},
+		{
+			name:          "the last item of the previous page was removed",
+			limit:         2,
+			continueToken: encodeContinueToken("bb"),
+			expectedIDs:   []string{"c", "d"},
+			expectedToken: encodeContinueToken("d"),
+		},
+		{
+			name:          "filtered",
+			limit:         2,
+			continueToken: encodeContinueToken("a"),
+			match:         notC,
+			expectedIDs:   []string{"b", "d"},
+			expectedToken: encodeContinueToken("d"),
+		},
+		{
+			name:          "bad token",
+			continueToken: "!!!",
+			expectError:   true,
+		},
+		{
+			name:        "match error",
+			match:       func(id string) (bool, error) { return false, errors.New("oops") },
+			expectError: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pageIDs, nextToken, err := listPage(ids, tc.limit, tc.continueToken, tc.match)
+			switch {
+			case tc.expectError && err == nil:
+				t.Errorf("listPage() didn't fail")
+			case !tc.expectError && err != nil:
+				t.Errorf("listPage(): %v", err)
+			case !reflect.DeepEqual(pageIDs, tc.expectedIDs):
+				t.Errorf("bad ids: %#v instead of %#v", pageIDs, tc.expectedIDs)
+			case nextToken != tc.expectedToken:
+				t.Errorf("bad next token %q instead of %q", nextToken, tc.expectedToken)
+			}
+		})
+	}
+}
+
+func TestListPages(t *testing.T) {
+	sandboxConfigs := fake.GetSandboxes(5)
+	store := setUpTestStore(t, sandboxConfigs, fake.GetContainersConfig(sandboxConfigs), nil)
+	defer store.Close()
+
+	var expectedSandboxIDs []string
+	for _, sandbox := range sandboxConfigs {
+		expectedSandboxIDs = append(expectedSandboxIDs, sandbox.Uid)
+	}
+	sort.Strings(expectedSandboxIDs)
+
+	var sandboxIDs []string
+	numPages := 0
+	continueToken := ""
+	for {
+		sandboxes, nextToken, err := store.ListPodSandboxesPage(&types.PodSandboxFilter{
+			LabelSelector: map[string]string{"foo": "bar"},
+		}, 2, continueToken)
+		if err != nil {
+			t.Fatalf("ListPodSandboxesPage(): %v", err)
+		}
+		numPages++
+		for _, psm := range sandboxes {
+			sandboxIDs = append(sandboxIDs, psm.GetID())
+		}
+		if nextToken == "" {
+			break
+		}
+		continueToken = nextToken
+	}
+	if numPages != 3 {
+		t.Errorf("bad number of pages: %d instead of 3", numPages)
+	}
+	if !reflect.DeepEqual(sandboxIDs, expectedSandboxIDs) {
+		t.Errorf("bad sandbox ids: %#v instead of %#v", sandboxIDs, expectedSandboxIDs)
+	}
+
+	sandboxIDs = nil
+	if err := ForEachPodSandbox(store, nil, 3, func(psm PodSandboxMetadata) error {
+		sandboxIDs = append(sandboxIDs, psm.GetID())
+		return nil
+	}); err != nil {
+		t.Fatalf("ForEachPodSandbox(): %v", err)
+	}
+	if !reflect.DeepEqual(sandboxIDs, expectedSandboxIDs) {
+		t.Errorf("bad sandbox ids from ForEachPodSandbox(): %#v instead of %#v", sandboxIDs, expectedSandboxIDs)
+	}
+
+	var containerNames []string
+	if err := ForEachContainer(store, 2, func(cm ContainerMetadata) error {
+		ci, err := cm.Retrieve()
+		if err != nil {
+			return err
+		}
+		containerNames = append(containerNames, ci.Name)
+		return nil
+	}); err != nil {
+		t.Fatalf("ForEachContainer(): %v", err)
+	}
+	sort.Strings(containerNames)
+	if expected := "container-for-testName_0,container-for-testName_1,container-for-testName_2,container-for-testName_3,container-for-testName_4"; strings.Join(containerNames, ",") != expected {
+		t.Errorf("bad container names: %v", containerNames)
+	}
+
+	if _, _, err := store.ListContainersPage(2, "!!!"); err == nil {
+		t.Errorf("ListContainersPage() didn't fail for a bad continue token")
+	}
+}
+
+func TestListPageWithRemovedSandbox(t *testing.T) {
+	sandboxConfigs := fake.GetSandboxes(3)
+	store := setUpTestStore(t, sandboxConfigs, nil, nil)
+	defer store.Close()
+
+	var ids []string
+	for _, sandbox := range sandboxConfigs {
+		ids = append(ids, sandbox.Uid)
+	}
+	sort.Strings(ids)
+	removedID := ids[1]
+	filter := &types.PodSandboxFilter{LabelSelector: map[string]string{"foo": "bar"}}
+	// the IDs are collected before the pod sandboxes are retrieved,
+	// so a pod sandbox may be removed in between
+	pageIDs, _, err := listPage(ids, 0, "", func(id string) (bool, error) {
+		if id == removedID {
+			if err := store.PodSandbox(id).Save(func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+				return nil, nil
+			}); err != nil {
+				t.Fatalf("error removing pod sandbox %q: %v", id, err)
+			}
+		}
+		return filterPodSandboxMeta(store.PodSandbox(id), filter)
+	})
+	if err != nil {
+		t.Fatalf("listPage(): %v", err)
+	}
+	if expected := []string{ids[0], ids[2]}; !reflect.DeepEqual(pageIDs, expected) {
+		t.Errorf("bad ids: %#v instead of %#v", pageIDs, expected)
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// readOnlyOpenTimeout is the time to wait for the database to be
+// released by the process that has it opened for writing
+const readOnlyOpenTimeout = time.Second
+
+// ErrDatabaseLocked is returned by NewReadOnlyStore if the database
+// is opened for writing by another process, such as the running
+// Virtlet.
+var ErrDatabaseLocked = errors.New("the metadata database is locked by another process")
+
+// NewReadOnlyStore opens the bolt database at the specified path in
+// read-only mode, so it can be inspected by the diagnostic tools
+// without the risk of changing it. Any attempt to modify the returned
+// store fails. The staged restore and compaction are not applied and
+// the schema is not migrated, so the database must have the current
+// schema version. Only a shared lock is taken on the file. If the
+// database is opened for writing by another process, NewReadOnlyStore
+// fails with ErrDatabaseLocked after a short timeout instead of
+// waiting for the lock to be released. The key is used to decrypt
+// the encrypted records and may be nil if there are none.
+func NewReadOnlyStore(path string, key []byte) (Store, error) {
+	var c *valueCipher
+	if key != nil {
+		var err error
+		if c, err = newValueCipher(key); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: readOnlyOpenTimeout})
+	switch {
+	case err == bolt.ErrTimeout:
+		return nil, ErrDatabaseLocked
+	case err != nil:
+		return nil, err
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		version, err := getSchemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		if current := CurrentSchemaVersion(); version != current {
+			return fmt.Errorf("metadata schema version %d doesn't match the version %d used by this Virtlet build", version, current)
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	client := newBoltClient(db, defaultMaxBatchSize)
+	client.cipher = c
+	return client, nil
+}
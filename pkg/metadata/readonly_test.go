@@ -0,0 +1,90 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func TestReadOnlyStore(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "virtlet-readonly-")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dbPath := filepath.Join(tmpDir, "virtlet.db")
+
+	if _, err := NewReadOnlyStore(dbPath, nil); err == nil {
+		t.Errorf("NewReadOnlyStore() didn't fail for a nonexistent database")
+	}
+
+	store, err := NewEncryptedStore(dbPath, testEncryptionKey)
+	if err != nil {
+		t.Fatalf("NewEncryptedStore(): %v", err)
+	}
+	saveTestContainer(t, store, "foobar")
+
+	// the database can't be opened while it's opened for writing
+	if roStore, err := NewReadOnlyStore(dbPath, testEncryptionKey); err == nil {
+		roStore.Close()
+		t.Errorf("NewReadOnlyStore() didn't fail for a database opened for writing")
+	} else if err != ErrDatabaseLocked {
+		t.Errorf("bad error for a database opened for writing: %v", err)
+	}
+	store.Close()
+
+	origData, err := ioutil.ReadFile(dbPath)
+	if err != nil {
+		t.Fatalf("ReadFile(): %v", err)
+	}
+	roStore, err := NewReadOnlyStore(dbPath, testEncryptionKey)
+	if err != nil {
+		t.Fatalf("NewReadOnlyStore(): %v", err)
+	}
+	if name := testContainerName(t, roStore); name != "foobar" {
+		t.Errorf("bad container name in the read-only store: %q", name)
+	}
+
+	// several read-only stores can be opened at the same time
+	otherStore, err := NewReadOnlyStore(dbPath, testEncryptionKey)
+	if err != nil {
+		t.Fatalf("NewReadOnlyStore() failed for a database that's opened read-only: %v", err)
+	}
+	otherStore.Close()
+
+	if err := roStore.Container(testContainerID).Save(func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
+		return nil, nil
+	}); err == nil {
+		t.Errorf("the container was removed from the read-only store")
+	}
+	if err := roStore.Close(); err != nil {
+		t.Errorf("Close(): %v", err)
+	}
+	newData, err := ioutil.ReadFile(dbPath)
+	if err != nil {
+		t.Fatalf("ReadFile(): %v", err)
+	}
+	if !bytes.Equal(origData, newData) {
+		t.Errorf("the database was modified via the read-only store")
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+var revisionBucket = []byte("revision")
+
+// ErrRevisionMismatch is returned by Save if the updater is wrapped
+// using ExpectPodSandboxRevision or ExpectContainerRevision and the
+// object was modified after the expected revision was read.
+var ErrRevisionMismatch = errors.New("the object was modified concurrently")
+
+// ExpectPodSandboxRevision wraps the pod sandbox updater so that the
+// update fails with ErrRevisionMismatch unless the stored pod sandbox
+// has the specified revision. Revision 0 means that the pod sandbox
+// must not exist. This makes it possible to do read-modify-write
+// updates with Retrieve and Save without holding a transaction
+// between the calls. As the revisions are unique within the store,
+// a pod sandbox that was removed and then re-created in the meantime
+// doesn't match the revision either.
+func ExpectPodSandboxRevision(revision uint64, updater func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error)) func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+	return func(current *types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+		var currentRevision uint64
+		if current != nil {
+			currentRevision = current.Revision
+		}
+		if currentRevision != revision {
+			return nil, ErrRevisionMismatch
+		}
+		return updater(current)
+	}
+}
+
+// ExpectContainerRevision wraps the container updater so that the
+// update fails with ErrRevisionMismatch unless the stored container
+// has the specified revision. Revision 0 means that the container
+// must not exist.
+func ExpectContainerRevision(revision uint64, updater func(*types.ContainerInfo) (*types.ContainerInfo, error)) func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+	return func(current *types.ContainerInfo) (*types.ContainerInfo, error) {
+		var currentRevision uint64
+		if current != nil {
+			currentRevision = current.Revision
+		}
+		if currentRevision != revision {
+			return nil, ErrRevisionMismatch
+		}
+		return updater(current)
+	}
+}
+
+// updatePodSandbox invokes the updater for the pod sandbox, setting
+// the revision of the resulting pod sandbox, if any, to the next
+// revision of the store. It's used by all the store implementations,
+// so the revision changes upon each Save no matter what the updater
+// returns. The revisions are taken from a store-wide counter rather
+// than incremented per object, so a pod sandbox that's removed and
+// then re-created with the same id never gets a revision it had
+// before. The etcd store uses the ModRevision of the record key as
+// its revision instead, which has the same property.
+func updatePodSandbox(current *types.PodSandboxInfo, updater func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error), nextRevision func() (uint64, error)) (*types.PodSandboxInfo, error) {
+	newData, err := updater(current)
+	if err != nil || newData == nil {
+		return newData, err
+	}
+	if newData.Revision, err = nextRevision(); err != nil {
+		return nil, fmt.Errorf("error getting the next revision: %v", err)
+	}
+	return newData, nil
+}
+
+// updateContainer invokes the updater for the container, setting the
+// revision of the resulting container, if any, to the next revision
+// of the store.
+func updateContainer(current *types.ContainerInfo, updater func(*types.ContainerInfo) (*types.ContainerInfo, error), nextRevision func() (uint64, error)) (*types.ContainerInfo, error) {
+	newData, err := updater(current)
+	if err != nil || newData == nil {
+		return newData, err
+	}
+	if newData.Revision, err = nextRevision(); err != nil {
+		return nil, fmt.Errorf("error getting the next revision: %v", err)
+	}
+	return newData, nil
+}
+
+// nextRevision returns the next revision of the bolt store. The
+// revision counter is kept as the sequence of its own bucket.
+func nextRevision(tx *bolt.Tx) func() (uint64, error) {
+	return func() (uint64, error) {
+		bucket, err := tx.CreateBucketIfNotExists(revisionBucket)
+		if err != nil {
+			return 0, err
+		}
+		return bucket.NextSequence()
+	}
+}
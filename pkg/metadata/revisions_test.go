@@ -0,0 +1,110 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func containerRevision(t *testing.T, store Store, containerID string) uint64 {
+	ci, err := store.Container(containerID).Retrieve()
+	if err != nil {
+		t.Fatalf("Container(%q).Retrieve(): %v", containerID, err)
+	}
+	if ci == nil {
+		t.Fatalf("container %q not found", containerID)
+	}
+	return ci.Revision
+}
+
+func testRevisions(t *testing.T, store Store) {
+	saveTestSandbox(t, store, "pod1", "pod1-name")
+	psi, err := store.PodSandbox("pod1").Retrieve()
+	if err != nil {
+		t.Fatalf("PodSandbox().Retrieve(): %v", err)
+	}
+	if psi.Revision == 0 {
+		t.Errorf("the new pod sandbox has no revision")
+	}
+	if err := store.PodSandbox("pod1").Save(ExpectPodSandboxRevision(0, func(c *types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+		return c, nil
+	})); err != ErrRevisionMismatch {
+		t.Errorf("PodSandbox().Save() with revision 0 for an existing pod sandbox: %v instead of ErrRevisionMismatch", err)
+	}
+
+	saveWatchedContainer(t, store, "container1", "pod1", "vm1")
+	rev1 := containerRevision(t, store, "container1")
+	if rev1 <= psi.Revision {
+		t.Errorf("the revision of the new container (%d) is not greater than that of the pod sandbox (%d)", rev1, psi.Revision)
+	}
+	saveWatchedContainer(t, store, "container1", "pod1", "vm1-renamed")
+	rev2 := containerRevision(t, store, "container1")
+	if rev2 <= rev1 {
+		t.Errorf("the revision didn't increase after the update: %d after %d", rev2, rev1)
+	}
+
+	// the update based on a stale revision must fail
+	if err := store.Container("container1").Save(ExpectContainerRevision(rev1, func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
+		c.Config.Image = "stale"
+		return c, nil
+	})); err != ErrRevisionMismatch {
+		t.Errorf("Container().Save() with a stale revision: %v instead of ErrRevisionMismatch", err)
+	}
+	if rev := containerRevision(t, store, "container1"); rev != rev2 {
+		t.Errorf("the revision changed after a failed update: %d instead of %d", rev, rev2)
+	}
+
+	if err := store.Container("container1").Save(ExpectContainerRevision(rev2, func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
+		c.Config.Image = "fresh"
+		return c, nil
+	})); err != nil {
+		t.Fatalf("Container().Save() with the current revision: %v", err)
+	}
+	ci, err := store.Container("container1").Retrieve()
+	if err != nil {
+		t.Fatalf("Container().Retrieve(): %v", err)
+	}
+	if ci.Revision <= rev2 || ci.Config.Image != "fresh" {
+		t.Errorf("bad container after the update: revision %d, image %q", ci.Revision, ci.Config.Image)
+	}
+
+	// a container that was removed and re-created doesn't get
+	// any of its old revisions
+	rev3 := ci.Revision
+	saveWatchedContainer(t, store, "container1", "pod1", "")
+	saveWatchedContainer(t, store, "container1", "pod1", "vm1")
+	if rev := containerRevision(t, store, "container1"); rev <= rev3 {
+		t.Errorf("the re-created container got an old revision: %d after %d", rev, rev3)
+	}
+	if err := store.Container("container1").Save(ExpectContainerRevision(rev3, func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
+		return c, nil
+	})); err != ErrRevisionMismatch {
+		t.Errorf("Container().Save() with the revision of the removed container: %v instead of ErrRevisionMismatch", err)
+	}
+
+	if err := store.Container("container2").Save(ExpectContainerRevision(1, func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
+		return &types.ContainerInfo{Id: "container2"}, nil
+	})); err != ErrRevisionMismatch {
+		t.Errorf("Container().Save() with nonzero revision for a nonexistent container: %v instead of ErrRevisionMismatch", err)
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	testRevisions(t, setUpTestStore(t, nil, nil, nil))
+}
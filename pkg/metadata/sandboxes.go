@@ -21,44 +21,59 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 
-	"github.com/boltdb/bolt"
+	bolt "go.etcd.io/bbolt"
 	"k8s.io/apimachinery/pkg/fields"
 
 	"github.com/Mirantis/virtlet/pkg/metadata/types"
 )
 
 var (
-	sandboxKeyPrefix  = []byte("sandboxes/")
-	sandboxDataBucket = []byte("data")
+	sandboxKeyPrefix    = []byte("sandboxes/")
+	sandboxDataBucket   = []byte("data")
+	sandboxLabelsBucket = []byte("sandboxLabels")
 )
 
 func sandboxKey(sandboxID string) []byte {
 	return append(sandboxKeyPrefix, []byte(sandboxID)...)
 }
 
+// sandboxLabelPrefix returns the prefix of the keys in the labels
+// index bucket that correspond to the specified label. The label
+// keys and values can't contain zero bytes so they're used as the
+// separators.
+func sandboxLabelPrefix(key, value string) []byte {
+	return []byte(key + "\x00" + value + "\x00")
+}
+
+func sandboxLabelKey(key, value, sandboxID string) []byte {
+	return append(sandboxLabelPrefix(key, value), []byte(sandboxID)...)
+}
+
 type podSandboxMeta struct {
 	client *boltClient
 	id     string
+	origin *auditOrigin
 }
 
-// GetID returns ID of the pod sandbox managed by this object
+// GetID implements GetID method of PodSandboxMetadata interface
 func (m podSandboxMeta) GetID() string {
 	return m.id
 }
 
-// Retrieve loads from DB and returns pod sandbox data bound to the object
+// Retrieve implements Retrieve method of PodSandboxMetadata interface
 func (m podSandboxMeta) Retrieve() (*types.PodSandboxInfo, error) {
 	if m.GetID() == "" {
 		return nil, errors.New("Pod sandbox ID cannot be empty")
 	}
 	var psi *types.PodSandboxInfo
-	err := m.client.db.View(func(tx *bolt.Tx) error {
+	err := m.client.view(func(tx *bolt.Tx) error {
 		bucket, err := getSandboxBucket(tx, m.GetID(), false, false)
 		if err != nil {
 			return err
 		}
-		return retrieveSandboxFromDB(bucket, &psi)
+		return retrieveSandboxFromDB(bucket, m.client.cipher, &psi)
 	})
 	if err == nil && psi != nil {
 		psi.PodID = m.GetID()
@@ -66,62 +81,196 @@ func (m podSandboxMeta) Retrieve() (*types.PodSandboxInfo, error) {
 	return psi, err
 }
 
-// Save allows to create/modify/delete pod sandbox instance bound to the object.
-// Supplied handler gets current PodSandboxInfo value (nil if doesn't exist) and returns new structure
-// value to be saved or nil to delete. If error value is returned from the handler, the transaction is
-// rolled back and returned error becomes the result of the function
+// Save implements Save method of PodSandboxMetadata interface
 func (m podSandboxMeta) Save(updater func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error)) error {
 	if m.GetID() == "" {
 		return errors.New("Pod sandbox ID cannot be empty")
 	}
-	return m.client.db.Update(func(tx *bolt.Tx) error {
-		key := sandboxKey(m.GetID())
-		var current *types.PodSandboxInfo
-		bucket, err := getSandboxBucket(tx, m.GetID(), true, false)
-		if err != nil {
-			return err
-		}
-		if err := retrieveSandboxFromDB(bucket, &current); err != nil {
-			return err
+	// the updater may be invoked more than once if the batch
+	// containing this update is retried
+	return m.client.batch.update(m.origin, func(tx *bolt.Tx) ([]*Event, error) {
+		return savePodSandbox(tx, m.client.cipher, m.client.removalPolicy, m.GetID(), updater)
+	})
+}
+
+// savePodSandbox updates the pod sandbox with given ID within the
+// transaction, returning the events that describe the changes. When
+// the pod sandbox is removed, its containers are handled according
+// to the removal policy.
+func savePodSandbox(tx *bolt.Tx, c *valueCipher, policy SandboxRemovalPolicy, podID string, updater func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error)) ([]*Event, error) {
+	var current, newData *types.PodSandboxInfo
+	key := sandboxKey(podID)
+	bucket, err := getSandboxBucket(tx, podID, true, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := retrieveSandboxFromDB(bucket, c, &current); err != nil {
+		return nil, err
+	}
+	newData, err = updatePodSandbox(current, updater, nextRevision(tx))
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*Event
+	if newData == nil {
+		containerIDs := sandboxContainerIDs(bucket)
+		if err := checkSandboxRemoval(policy, podID, containerIDs); err != nil {
+			return nil, err
 		}
-		newData, err := updater(current)
-		if err != nil {
-			return err
+		for _, containerID := range containerIDs {
+			event, err := saveContainer(tx, c, containerID, func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+				return nil, nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, eventList(event)...)
 		}
+	}
 
-		if newData == nil {
-			return tx.DeleteBucket(key)
-		}
-		return saveSandboxToDB(bucket, newData)
-	})
+	if err := updateSandboxLabelIndex(tx, podID, sandboxLabels(current), sandboxLabels(newData)); err != nil {
+		return nil, err
+	}
+	if newData == nil {
+		err = tx.DeleteBucket(key)
+	} else {
+		err = saveSandboxToDB(bucket, c, newData)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(events, eventList(sandboxEvent(podID, current, newData))...), nil
+}
+
+// SetSandboxRemovalPolicy implements SetSandboxRemovalPolicy method
+// of SandboxStore interface
+func (b *boltClient) SetSandboxRemovalPolicy(policy SandboxRemovalPolicy) {
+	b.removalPolicy = policy
 }
 
-// PodSandbox returns interface instance which manages pod sandbox with given ID
+// PodSandbox implements PodSandbox method of SandboxStore interface
 func (b *boltClient) PodSandbox(podID string) PodSandboxMetadata {
-	return &podSandboxMeta{id: podID, client: b}
+	return b.podSandboxAs(nil, podID)
 }
 
-// ListPodSandboxes returns list of pod sandboxes that match given filter
+// podSandboxAs implements podSandboxAs method of auditedBackend interface
+func (b *boltClient) podSandboxAs(origin *auditOrigin, podID string) PodSandboxMetadata {
+	return &podSandboxMeta{id: podID, client: b, origin: origin}
+}
+
+// ListPodSandboxes implements ListPodSandboxes method of SandboxStore interface
 func (b *boltClient) ListPodSandboxes(filter *types.PodSandboxFilter) ([]PodSandboxMetadata, error) {
-	var result []PodSandboxMetadata
-	err := b.db.View(func(tx *bolt.Tx) error {
+	result, _, err := b.ListPodSandboxesPage(filter, 0, "")
+	return result, err
+}
+
+// ListPodSandboxesPage implements ListPodSandboxesPage method of SandboxStore interface.
+// If the filter has a label selector but no pod sandbox ID, only the
+// sandboxes found in the labels index are checked. The read
+// transaction is only held while the IDs are being collected.
+func (b *boltClient) ListPodSandboxesPage(filter *types.PodSandboxFilter, limit int, continueToken string) ([]PodSandboxMetadata, string, error) {
+	lastID, err := decodeContinueToken(continueToken)
+	if err != nil {
+		return nil, "", err
+	}
+	var ids []string
+	if err := b.view(func(tx *bolt.Tx) error {
+		if filter != nil && filter.Id == "" && len(filter.LabelSelector) != 0 {
+			ids = sandboxIDsByLabels(tx, filter.LabelSelector)
+			return nil
+		}
 		c := tx.Cursor()
-		for k, _ := c.Seek(sandboxKeyPrefix); k != nil && bytes.HasPrefix(k, sandboxKeyPrefix); k, _ = c.Next() {
-			psm := podSandboxMeta{client: b, id: string(k[len(sandboxKeyPrefix):])}
-			fv, err := filterPodSandboxMeta(&psm, filter)
-			if err != nil {
-				return err
-			}
-			if fv {
-				result = append(result, psm)
-			}
+		for k, _ := c.Seek(sandboxKey(lastID)); k != nil && bytes.HasPrefix(k, sandboxKeyPrefix); k, _ = c.Next() {
+			ids = append(ids, string(k[len(sandboxKeyPrefix):]))
 		}
 		return nil
+	}); err != nil {
+		return nil, "", err
+	}
+	pageIDs, nextToken, err := listPage(ids, limit, continueToken, func(id string) (bool, error) {
+		return filterPodSandboxMeta(&podSandboxMeta{client: b, id: id}, filter)
 	})
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	var result []PodSandboxMetadata
+	for _, id := range pageIDs {
+		result = append(result, podSandboxMeta{client: b, id: id})
 	}
-	return result, nil
+	return result, nextToken, nil
+}
+
+// sandboxIDsByLabels returns the sorted list of the IDs of the pod
+// sandboxes that have all of the specified labels according to the
+// labels index.
+func sandboxIDsByLabels(tx *bolt.Tx, labels map[string]string) []string {
+	bucket := tx.Bucket(sandboxLabelsBucket)
+	if bucket == nil {
+		return nil
+	}
+	counts := make(map[string]int)
+	for k, v := range labels {
+		prefix := sandboxLabelPrefix(k, v)
+		c := bucket.Cursor()
+		for key, _ := c.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = c.Next() {
+			counts[string(key[len(prefix):])]++
+		}
+	}
+	var ids []string
+	for id, n := range counts {
+		if n == len(labels) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// updateSandboxLabelIndex updates the labels index entries of the
+// pod sandbox after its labels change from oldLabels to newLabels.
+func updateSandboxLabelIndex(tx *bolt.Tx, sandboxID string, oldLabels, newLabels map[string]string) error {
+	if len(oldLabels) == 0 && len(newLabels) == 0 {
+		return nil
+	}
+	bucket, err := tx.CreateBucketIfNotExists(sandboxLabelsBucket)
+	if err != nil {
+		return err
+	}
+	for k, v := range oldLabels {
+		if newValue, found := newLabels[k]; found && newValue == v {
+			continue
+		}
+		if err := bucket.Delete(sandboxLabelKey(k, v, sandboxID)); err != nil {
+			return err
+		}
+	}
+	for k, v := range newLabels {
+		if err := bucket.Put(sandboxLabelKey(k, v, sandboxID), []byte(sandboxID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sandboxLabels(psi *types.PodSandboxInfo) map[string]string {
+	if psi == nil || psi.Config == nil {
+		return nil
+	}
+	return psi.Config.Labels
+}
+
+// sandboxNotFoundError is returned when the pod sandbox with the
+// specified ID doesn't exist.
+type sandboxNotFoundError string
+
+func (e sandboxNotFoundError) Error() string {
+	return fmt.Sprintf("pod sandbox %q does not exist", string(e))
+}
+
+func isSandboxNotFound(err error) bool {
+	_, ok := err.(sandboxNotFoundError)
+	return ok
 }
 
 func getSandboxBucket(tx *bolt.Tx, podID string, create, optional bool) (*bolt.Bucket, error) {
@@ -135,24 +284,31 @@ func getSandboxBucket(tx *bolt.Tx, podID string, create, optional bool) (*bolt.B
 	}
 	bucket := tx.Bucket(key)
 	if bucket == nil && !optional {
-		return nil, fmt.Errorf("pod sandbox %q does not exist", podID)
+		return nil, sandboxNotFoundError(podID)
 	}
 	return bucket, nil
 }
 
-func retrieveSandboxFromDB(bucket *bolt.Bucket, psi **types.PodSandboxInfo) error {
+func retrieveSandboxFromDB(bucket *bolt.Bucket, c *valueCipher, psi **types.PodSandboxInfo) error {
 	data := bucket.Get(sandboxDataBucket)
 	if data == nil {
 		return nil
 	}
+	data, err := c.open(data)
+	if err != nil {
+		return err
+	}
 	return json.Unmarshal(data, psi)
 }
 
-func saveSandboxToDB(bucket *bolt.Bucket, psi *types.PodSandboxInfo) error {
+func saveSandboxToDB(bucket *bolt.Bucket, c *valueCipher, psi *types.PodSandboxInfo) error {
 	data, err := json.Marshal(psi)
 	if err != nil {
 		return err
 	}
+	if data, err = c.seal(data); err != nil {
+		return err
+	}
 
 	return bucket.Put(sandboxDataBucket, data)
 }
@@ -166,22 +322,48 @@ func filterPodSandboxMeta(psm PodSandboxMetadata, filter *types.PodSandboxFilter
 		return false, nil
 	}
 
+	// the IDs are listed before the pod sandboxes are retrieved,
+	// so the pod sandboxes removed in between are skipped, along
+	// with the ones that only have containers associated with them
 	psi, err := psm.Retrieve()
-	if err != nil {
+	switch {
+	case isSandboxNotFound(err):
+		return false, nil
+	case err != nil:
 		return false, err
-	}
-	if psi == nil {
-		return false, fmt.Errorf("no data found for pod id %q", psm.GetID())
+	case psi == nil:
+		return false, nil
 	}
 
 	if filter.State != nil && psi.State != *filter.State {
 		return false, nil
 	}
 
+	if filter.CreatedAfter != 0 && psi.CreatedAt < filter.CreatedAfter {
+		return false, nil
+	}
+
+	if filter.CreatedBefore != 0 && psi.CreatedAt >= filter.CreatedBefore {
+		return false, nil
+	}
+
+	if filter.Namespace != "" && psi.Config.Namespace != filter.Namespace {
+		return false, nil
+	}
+
+	if filter.Name != "" && psi.Config.Name != filter.Name {
+		return false, nil
+	}
+
 	sel := fields.SelectorFromSet(filter.LabelSelector)
 	if !sel.Matches(fields.Set(psi.Config.Labels)) {
 		return false, nil
 	}
 
+	sel = fields.SelectorFromSet(filter.AnnotationSelector)
+	if !sel.Matches(fields.Set(psi.Config.Annotations)) {
+		return false, nil
+	}
+
 	return true, nil
 }
@@ -19,6 +19,7 @@ package metadata
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -47,7 +48,7 @@ func TestRemovePodSandbox(t *testing.T) {
 	}
 
 	for _, tc := range tests {
-		store, err := NewFakeStore()
+		store, err := newTestStore()
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -82,13 +83,70 @@ func TestRemovePodSandbox(t *testing.T) {
 	}
 }
 
+func TestSandboxRemovalPolicy(t *testing.T) {
+	sandboxes := fake.GetSandboxes(2)
+	containers := fake.GetContainersConfig(sandboxes)
+	store := setUpTestStore(t, sandboxes, containers, nil)
+	removeSandbox := func(podID string) error {
+		return store.PodSandbox(podID).Save(func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+			return nil, nil
+		})
+	}
+
+	store.SetSandboxRemovalPolicy(SandboxRemovalRestrict)
+	if err := removeSandbox(sandboxes[0].Uid); err == nil {
+		t.Errorf("didn't get an error removing a sandbox with containers under restrict policy")
+	} else if !strings.Contains(err.Error(), containers[0].ContainerID) {
+		t.Errorf("the error doesn't mention the container: %v", err)
+	}
+	if psi, err := store.PodSandbox(sandboxes[0].Uid).Retrieve(); err != nil || psi == nil {
+		t.Errorf("the sandbox was removed despite the error: %#v, %v", psi, err)
+	}
+	if ci, err := store.Container(containers[0].ContainerID).Retrieve(); err != nil || ci == nil {
+		t.Errorf("the container was removed despite the error: %#v, %v", ci, err)
+	}
+	// the sandbox can be removed once its containers are removed
+	if err := store.Update(func(tx Tx) error {
+		if err := tx.SaveContainer(containers[0].ContainerID, func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+			return nil, nil
+		}); err != nil {
+			return err
+		}
+		return tx.SavePodSandbox(sandboxes[0].Uid, func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+			return nil, nil
+		})
+	}); err != nil {
+		t.Errorf("Update(): %v", err)
+	}
+
+	store.SetSandboxRemovalPolicy(SandboxRemovalCascade)
+	if err := removeSandbox(sandboxes[1].Uid); err != nil {
+		t.Fatalf("error removing the sandbox: %v", err)
+	}
+	if ci, err := store.Container(containers[1].ContainerID).Retrieve(); err != nil {
+		t.Errorf("Container().Retrieve(): %v", err)
+	} else if ci != nil {
+		t.Errorf("the container was not removed along with the sandbox")
+	}
+	if infos, err := store.ListContainerInfos(); err != nil {
+		t.Errorf("ListContainerInfos(): %v", err)
+	} else if len(infos) != 0 {
+		t.Errorf("unexpected containers left: %#v", infos)
+	}
+	if sandboxes, err := store.ListPodSandboxes(nil); err != nil {
+		t.Errorf("ListPodSandboxes(): %v", err)
+	} else if len(sandboxes) != 0 {
+		t.Errorf("unexpected sandboxes left: %#v", sandboxes)
+	}
+}
+
 func TestRetrieve(t *testing.T) {
 	sandboxes := fake.GetSandboxes(2)
 
 	fakeClock := clockwork.NewFakeClockAt(time.Now())
 	store := setUpTestStore(t, sandboxes, []*fake.ContainerTestConfig{}, fakeClock)
 
-	for _, sandbox := range sandboxes {
+	for n, sandbox := range sandboxes {
 		expectedSandboxInfo, err := NewPodSandboxInfo(sandbox, nil, types.PodSandboxState_SANDBOX_READY, fakeClock)
 		if err != nil {
 			t.Fatal(err)
@@ -108,6 +166,8 @@ func TestRetrieve(t *testing.T) {
 			t.Errorf("invalid podID for retrieved PodSandboxInfo: %s != %s", actualSandboxInfo.PodID, sandboxManager.GetID())
 		}
 		expectedSandboxInfo.PodID = sandboxManager.GetID()
+		// the revisions are allocated store-wide
+		expectedSandboxInfo.Revision = uint64(n + 1)
 		if !reflect.DeepEqual(expectedSandboxInfo, actualSandboxInfo) {
 			t.Error("retrieved sandbox info object is not equal to expected value")
 		}
@@ -154,6 +214,11 @@ func TestListPodSandbox(t *testing.T) {
 
 	firstSandboxConfig.Labels = map[string]string{"unique": "first", "common": "both"}
 	secondSandboxConfig.Labels = map[string]string{"unique": "second", "common": "both"}
+	firstSandboxConfig.Annotations = map[string]string{"note": "first", "common": "both"}
+	secondSandboxConfig.Annotations = map[string]string{"note": "second", "common": "both"}
+	secondSandboxConfig.Namespace = "kube-system"
+	clock := clockwork.NewFakeClockAt(time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC))
+	createdAt := clock.Now().UnixNano()
 
 	sandboxConfigs := []*types.PodSandboxConfig{firstSandboxConfig, secondSandboxConfig}
 	stateReady := types.PodSandboxState_SANDBOX_READY
@@ -224,10 +289,68 @@ func TestListPodSandbox(t *testing.T) {
 			},
 			expectedIds: []string{firstSandboxConfig.Uid},
 		},
+		{
+			filter: &types.PodSandboxFilter{
+				AnnotationSelector: map[string]string{"note": "second"},
+			},
+			expectedIds: []string{secondSandboxConfig.Uid},
+		},
+		{
+			filter: &types.PodSandboxFilter{
+				AnnotationSelector: map[string]string{"common": "both"},
+			},
+			expectedIds: []string{firstSandboxConfig.Uid, secondSandboxConfig.Uid},
+		},
+		{
+			filter: &types.PodSandboxFilter{
+				LabelSelector:      map[string]string{"unique": "first"},
+				AnnotationSelector: map[string]string{"note": "second"},
+			},
+			expectedIds: []string{},
+		},
+		{
+			filter: &types.PodSandboxFilter{
+				Namespace: "kube-system",
+			},
+			expectedIds: []string{secondSandboxConfig.Uid},
+		},
+		{
+			filter: &types.PodSandboxFilter{
+				Namespace: "default",
+				Name:      firstSandboxConfig.Name,
+			},
+			expectedIds: []string{firstSandboxConfig.Uid},
+		},
+		{
+			filter: &types.PodSandboxFilter{
+				Namespace: "default",
+				Name:      secondSandboxConfig.Name,
+			},
+			expectedIds: []string{},
+		},
+		{
+			filter: &types.PodSandboxFilter{
+				CreatedAfter:  createdAt,
+				CreatedBefore: createdAt + 1,
+			},
+			expectedIds: []string{firstSandboxConfig.Uid, secondSandboxConfig.Uid},
+		},
+		{
+			filter: &types.PodSandboxFilter{
+				CreatedAfter: createdAt + 1,
+			},
+			expectedIds: []string{},
+		},
+		{
+			filter: &types.PodSandboxFilter{
+				CreatedBefore: createdAt,
+			},
+			expectedIds: []string{},
+		},
 	}
 
 	cc := fake.GetContainersConfig(sandboxConfigs)
-	b := setUpTestStore(t, sandboxConfigs, cc, nil)
+	b := setUpTestStore(t, sandboxConfigs, cc, clock)
 
 	for _, tc := range tests {
 		sandboxes, err := b.ListPodSandboxes(tc.filter)
@@ -253,3 +376,69 @@ func TestListPodSandbox(t *testing.T) {
 		}
 	}
 }
+
+func sandboxIDsWithLabels(t *testing.T, store Store, labels map[string]string) []string {
+	sandboxes, err := store.ListPodSandboxes(&types.PodSandboxFilter{LabelSelector: labels})
+	if err != nil {
+		t.Fatalf("ListPodSandboxes(): %v", err)
+	}
+	ids := []string{}
+	for _, psm := range sandboxes {
+		ids = append(ids, psm.GetID())
+	}
+	return ids
+}
+
+func TestPodSandboxLabelIndex(t *testing.T) {
+	store, err := newTestStore()
+	if err != nil {
+		t.Fatalf("Error creating the store: %v", err)
+	}
+	defer store.Close()
+
+	setLabels := func(podID string, labels map[string]string) {
+		if err := store.PodSandbox(podID).Save(func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+			if labels == nil {
+				return nil, nil
+			}
+			return &types.PodSandboxInfo{
+				Config: &types.PodSandboxConfig{Name: podID, Labels: labels},
+				State:  types.PodSandboxState_SANDBOX_READY,
+			}, nil
+		}); err != nil {
+			t.Fatalf("PodSandbox().Save(): %v", err)
+		}
+	}
+	verify := func(labels map[string]string, expectedIDs ...string) {
+		if expectedIDs == nil {
+			expectedIDs = []string{}
+		}
+		if ids := sandboxIDsWithLabels(t, store, labels); !reflect.DeepEqual(ids, expectedIDs) {
+			t.Errorf("bad sandboxes for the labels %v: %v instead of %v", labels, ids, expectedIDs)
+		}
+	}
+
+	setLabels("pod1", map[string]string{"app": "foo", "tier": "db"})
+	setLabels("pod2", map[string]string{"app": "foo", "tier": "web"})
+	setLabels("pod3", map[string]string{"app": "bar"})
+	verify(map[string]string{"app": "foo"}, "pod1", "pod2")
+	verify(map[string]string{"app": "foo", "tier": "web"}, "pod2")
+	verify(map[string]string{"app": "foo", "tier": "cache"})
+	verify(map[string]string{"nosuchlabel": "foo"})
+
+	// the stale index entries are removed when the labels change
+	setLabels("pod2", map[string]string{"app": "bar", "tier": "web"})
+	verify(map[string]string{"app": "foo"}, "pod1")
+	verify(map[string]string{"app": "bar"}, "pod2", "pod3")
+
+	// the label values that have the other value as the prefix
+	// don't match
+	setLabels("pod4", map[string]string{"app": "foobar"})
+	verify(map[string]string{"app": "foo"}, "pod1")
+
+	setLabels("pod1", nil)
+	setLabels("pod2", nil)
+	verify(map[string]string{"app": "foo"})
+	verify(map[string]string{"app": "bar"}, "pod3")
+	verify(map[string]string{"tier": "web"})
+}
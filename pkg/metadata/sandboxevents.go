@@ -0,0 +1,139 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const sandboxEventIDLen = 20
+
+var (
+	sandboxEventsBucket = []byte("sandboxEvents")
+
+	// maxSandboxEventsPerPod is the number of the most recent
+	// events that are kept for each pod sandbox
+	maxSandboxEventsPerPod = 50
+	// maxSandboxEvents is the maximum total number of the pod
+	// sandbox events in the store
+	maxSandboxEvents = 5000
+)
+
+func sandboxEventPrefix(podID string) []byte {
+	return []byte(podID + "/")
+}
+
+func sandboxEventKey(podID string, id uint64) []byte {
+	return append(sandboxEventPrefix(podID), []byte(fmt.Sprintf("%0*d", sandboxEventIDLen, id))...)
+}
+
+func sandboxEventID(key []byte) uint64 {
+	if len(key) < sandboxEventIDLen {
+		return 0
+	}
+	id, err := strconv.ParseUint(string(key[len(key)-sandboxEventIDLen:]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+// AddSandboxEvent implements AddSandboxEvent method of SandboxEventStore interface
+func (b *boltClient) AddSandboxEvent(event *types.SandboxEvent) error {
+	if event.PodSandboxID == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	return b.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(sandboxEventsBucket)
+		if err != nil {
+			return err
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		event.ID = id
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(sandboxEventKey(event.PodSandboxID, id), data); err != nil {
+			return err
+		}
+		if err := pruneSandboxEvents(bucket, sandboxEventPrefix(event.PodSandboxID), maxSandboxEventsPerPod); err != nil {
+			return err
+		}
+		return pruneSandboxEvents(bucket, nil, maxSandboxEvents)
+	})
+}
+
+// pruneSandboxEvents removes the oldest events with the specified
+// key prefix so that no more than maxCount of such events remain.
+func pruneSandboxEvents(bucket *bolt.Bucket, prefix []byte, maxCount int) error {
+	var keys [][]byte
+	c := bucket.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	if len(keys) <= maxCount {
+		return nil
+	}
+	sort.Slice(keys, func(i, j int) bool { return sandboxEventID(keys[i]) < sandboxEventID(keys[j]) })
+	for _, k := range keys[:len(keys)-maxCount] {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListSandboxEvents implements ListSandboxEvents method of SandboxEventStore interface
+func (b *boltClient) ListSandboxEvents(podID string) ([]*types.SandboxEvent, error) {
+	var prefix []byte
+	if podID != "" {
+		prefix = sandboxEventPrefix(podID)
+	}
+	var events []*types.SandboxEvent
+	if err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sandboxEventsBucket)
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var event *types.SandboxEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return fmt.Errorf("error unmarshalling sandbox event %q: %v", k, err)
+			}
+			events = append(events, event)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, nil
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func sandboxEventIDs(t *testing.T, store Store, podID string) []string {
+	events, err := store.ListSandboxEvents(podID)
+	if err != nil {
+		t.Fatalf("ListSandboxEvents(): %v", err)
+	}
+	var r []string
+	for _, event := range events {
+		r = append(r, fmt.Sprintf("%d:%s:%s", event.ID, event.PodSandboxID, event.Type))
+	}
+	return r
+}
+
+func testSandboxEvents(t *testing.T, store Store) {
+	oldMaxPerPod, oldMax := maxSandboxEventsPerPod, maxSandboxEvents
+	maxSandboxEventsPerPod, maxSandboxEvents = 3, 5
+	defer func() {
+		maxSandboxEventsPerPod, maxSandboxEvents = oldMaxPerPod, oldMax
+	}()
+
+	if ids := sandboxEventIDs(t, store, ""); len(ids) != 0 {
+		t.Errorf("ListSandboxEvents() returned non-empty result for an empty db: %#v", ids)
+	}
+	if err := store.AddSandboxEvent(&types.SandboxEvent{Type: types.SandboxEventCreated}); err == nil {
+		t.Errorf("AddSandboxEvent() didn't fail for an event without pod sandbox id")
+	}
+
+	for n, event := range []*types.SandboxEvent{
+		{PodSandboxID: "pod1", Type: types.SandboxEventCreated},
+		{PodSandboxID: "pod1", Type: types.SandboxEventNetworkSetUp},
+		{PodSandboxID: "pod2", Type: types.SandboxEventCreated},
+		{PodSandboxID: "pod1", Type: types.SandboxEventVMCreated, ContainerID: "vm1"},
+		// pushes out the oldest event of pod1
+		{PodSandboxID: "pod1", Type: types.SandboxEventVMStarted, ContainerID: "vm1"},
+		// pushes out the oldest event in the store
+		{PodSandboxID: "pod3", Type: types.SandboxEventCreated},
+		{PodSandboxID: "pod2", Type: types.SandboxEventNetworkSetupFailed, Message: "no IP addresses left"},
+	} {
+		if err := store.AddSandboxEvent(event); err != nil {
+			t.Fatalf("AddSandboxEvent(): %v", err)
+		}
+		if event.ID != uint64(n+1) {
+			t.Errorf("bad event ID %d, expected %d", event.ID, n+1)
+		}
+	}
+
+	for _, tc := range []struct {
+		podID       string
+		expectedIDs []string
+	}{
+		{
+			podID: "pod1",
+			expectedIDs: []string{
+				"4:pod1:VMCreated",
+				"5:pod1:VMStarted",
+			},
+		},
+		{
+			podID: "pod2",
+			expectedIDs: []string{
+				"3:pod2:SandboxCreated",
+				"7:pod2:NetworkSetupFailed",
+			},
+		},
+		{
+			podID: "",
+			expectedIDs: []string{
+				"3:pod2:SandboxCreated",
+				"4:pod1:VMCreated",
+				"5:pod1:VMStarted",
+				"6:pod3:SandboxCreated",
+				"7:pod2:NetworkSetupFailed",
+			},
+		},
+		{
+			podID: "nosuchpod",
+		},
+	} {
+		t.Run(tc.podID, func(t *testing.T) {
+			ids := sandboxEventIDs(t, store, tc.podID)
+			if !reflect.DeepEqual(ids, tc.expectedIDs) {
+				t.Errorf("bad sandbox events: %#v instead of %#v", ids, tc.expectedIDs)
+			}
+		})
+	}
+
+	events, err := store.ListSandboxEvents("pod2")
+	if err != nil {
+		t.Fatalf("ListSandboxEvents(): %v", err)
+	}
+	expectedEvent := &types.SandboxEvent{
+		ID:           7,
+		PodSandboxID: "pod2",
+		Type:         types.SandboxEventNetworkSetupFailed,
+		Message:      "no IP addresses left",
+	}
+	if len(events) != 2 || !reflect.DeepEqual(events[1], expectedEvent) {
+		t.Errorf("bad sandbox events for pod2: %#v", events)
+	}
+}
+
+func TestSandboxEvents(t *testing.T) {
+	testSandboxEvents(t, setUpTestStore(t, nil, nil, nil))
+}
@@ -0,0 +1,952 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	// register the sqlite3 database/sql driver
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const (
+	// sqliteSchemaVersion is the version of the sqlite database
+	// schema that's kept in user_version pragma
+	sqliteSchemaVersion = 1
+	sqliteBusyTimeoutMs = 5000
+)
+
+// sqliteSchema is the schema of the sqlite metadata database. The
+// objects are stored as JSON in data columns, with the fields that
+// are useful for queries being duplicated in separate columns.
+// sandbox_containers lists the containers that belong to each pod
+// sandbox, and container_images lists the images used by each
+// container, including the CD-ROM images, so that e.g. the images
+// in use can be found by joining them. revisions is only used to
+// generate the store-wide revision numbers of the pod sandboxes and
+// containers.
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS sandboxes (
+		id TEXT PRIMARY KEY,
+		namespace TEXT NOT NULL,
+		name TEXT NOT NULL,
+		state INTEGER NOT NULL,
+		created_at INTEGER NOT NULL,
+		data TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS containers (
+		id TEXT PRIMARY KEY,
+		sandbox_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		image TEXT NOT NULL,
+		state INTEGER NOT NULL,
+		created_at INTEGER NOT NULL,
+		data TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS sandbox_containers (
+		sandbox_id TEXT NOT NULL,
+		container_id TEXT NOT NULL,
+		PRIMARY KEY (sandbox_id, container_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS sandbox_containers_container_id ON sandbox_containers (container_id)`,
+	`CREATE TABLE IF NOT EXISTS container_images (
+		container_id TEXT NOT NULL,
+		image TEXT NOT NULL,
+		PRIMARY KEY (container_id, image)
+	)`,
+	`CREATE TABLE IF NOT EXISTS start_records (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		pod_namespace TEXT NOT NULL,
+		pod_name TEXT NOT NULL,
+		data TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS start_records_pod ON start_records (pod_namespace, pod_name)`,
+	`CREATE TABLE IF NOT EXISTS sandbox_tombstones (
+		sandbox_id TEXT PRIMARY KEY,
+		deleted_at INTEGER NOT NULL,
+		data TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS sandbox_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		sandbox_id TEXT NOT NULL,
+		data TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS sandbox_events_sandbox_id ON sandbox_events (sandbox_id)`,
+	`CREATE TABLE IF NOT EXISTS first_boot_records (
+		volume_id TEXT PRIMARY KEY,
+		data TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS image_pull_jobs (
+		image_name TEXT PRIMARY KEY,
+		data TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT
+	)`,
+}
+
+// sqliteClient is a Store implementation that keeps the metadata in
+// a local SQLite database, which makes it possible to inspect the
+// metadata using SQL queries, e.g. with sqlite3 command line tool.
+// A single database connection is used, so all the transactions
+// are serialized.
+type sqliteClient struct {
+	db            *sql.DB
+	watchers      *watchHub
+	removalPolicy SandboxRemovalPolicy
+}
+
+var _ Store = &sqliteClient{}
+
+// NewSQLiteStore returns a Store that keeps the metadata in a SQLite
+// database at the specified path, creating the database if it
+// doesn't exist.
+func NewSQLiteStore(path string) (Store, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_busy_timeout=%d&_journal_mode=WAL", path, sqliteBusyTimeoutMs))
+	if err != nil {
+		return nil, fmt.Errorf("error opening sqlite database %q: %v", path, err)
+	}
+	db.SetMaxOpenConns(1)
+	c := &sqliteClient{db: db, watchers: newWatchHub()}
+	if err := c.initSchema(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing sqlite database %q: %v", path, err)
+	}
+	return c, nil
+}
+
+func (c *sqliteClient) initSchema() error {
+	return c.update(func(tx *sql.Tx) error {
+		var version int
+		if err := tx.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+			return err
+		}
+		if version > sqliteSchemaVersion {
+			return fmt.Errorf("the database schema version %d is newer than the supported one (%d)", version, sqliteSchemaVersion)
+		}
+		for _, stmt := range sqliteSchema {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", sqliteSchemaVersion))
+		return err
+	})
+}
+
+// Watch implements Watch method of WatchStore interface.
+func (c *sqliteClient) Watch() (<-chan Event, func()) {
+	return c.watchers.watch()
+}
+
+// SetAuditLog implements SetAuditLog method of AuditStore interface
+func (c *sqliteClient) SetAuditLog(log *AuditLog) {
+	c.watchers.setAuditLog(log)
+}
+
+// Close releases the database
+func (c *sqliteClient) Close() error {
+	return c.db.Close()
+}
+
+// sqliteQueryer is implemented by both *sql.DB and *sql.Tx
+type sqliteQueryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// update runs fn in a transaction which is committed if fn
+// succeeds and rolled back otherwise.
+func (c *sqliteClient) update(fn func(tx *sql.Tx) error) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// view runs fn in a transaction which is always rolled back.
+func (c *sqliteClient) view(fn func(tx *sql.Tx) error) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return fn(tx)
+}
+
+// sqliteGet runs a query that returns a single JSON value and
+// unmarshals it into v leaving v untouched if there's no result.
+func sqliteGet(q sqliteQueryer, v interface{}, query string, args ...interface{}) error {
+	var data string
+	switch err := q.QueryRow(query, args...).Scan(&data); {
+	case err == sql.ErrNoRows:
+		return nil
+	case err != nil:
+		return err
+	}
+	return json.Unmarshal([]byte(data), v)
+}
+
+// sqliteStrings runs a query that returns a single string column
+// and returns the values. The rows are read completely before
+// returning so the connection can be reused.
+func sqliteStrings(q sqliteQueryer, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var r []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		r = append(r, s)
+	}
+	return r, rows.Err()
+}
+
+// sqliteSandboxExists returns true if either the pod sandbox with the
+// specified id or any containers associated with it exist.
+func sqliteSandboxExists(q sqliteQueryer, podID string) (bool, error) {
+	var exists bool
+	if err := q.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM sandboxes WHERE id = ?) OR EXISTS (SELECT 1 FROM sandbox_containers WHERE sandbox_id = ?)",
+		podID, podID).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+type sqlitePodSandboxMeta struct {
+	client *sqliteClient
+	id     string
+	origin *auditOrigin
+}
+
+// GetID implements GetID method of PodSandboxMetadata interface
+func (m sqlitePodSandboxMeta) GetID() string {
+	return m.id
+}
+
+// Retrieve implements Retrieve method of PodSandboxMetadata interface
+func (m sqlitePodSandboxMeta) Retrieve() (*types.PodSandboxInfo, error) {
+	if m.GetID() == "" {
+		return nil, errors.New("Pod sandbox ID cannot be empty")
+	}
+	var psi *types.PodSandboxInfo
+	if err := m.client.view(func(tx *sql.Tx) error {
+		exists, err := sqliteSandboxExists(tx, m.GetID())
+		switch {
+		case err != nil:
+			return err
+		case !exists:
+			return sandboxNotFoundError(m.GetID())
+		}
+		// psi stays nil if the sandbox only has containers
+		// associated with it
+		return sqliteGet(tx, &psi, "SELECT data FROM sandboxes WHERE id = ?", m.GetID())
+	}); err != nil {
+		return nil, err
+	}
+	if psi != nil {
+		psi.PodID = m.GetID()
+	}
+	return psi, nil
+}
+
+// Save implements Save method of PodSandboxMetadata interface
+func (m sqlitePodSandboxMeta) Save(updater func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error)) error {
+	if m.GetID() == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	return m.client.watchers.updateMulti(m.origin, func() ([]*Event, error) {
+		var events []*Event
+		if err := m.client.update(func(tx *sql.Tx) error {
+			var err error
+			events, err = savePodSandboxSQLite(tx, m.client.removalPolicy, m.GetID(), updater)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		return events, nil
+	})
+}
+
+// sqliteNextRevision returns a function that allocates the next
+// revision of the store within the transaction. AUTOINCREMENT makes
+// sure the revisions are never reused even though only the last one
+// is kept in the table.
+func sqliteNextRevision(tx *sql.Tx) func() (uint64, error) {
+	return func() (uint64, error) {
+		res, err := tx.Exec("INSERT INTO revisions DEFAULT VALUES")
+		if err != nil {
+			return 0, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec("DELETE FROM revisions WHERE id < ?", id); err != nil {
+			return 0, err
+		}
+		return uint64(id), nil
+	}
+}
+
+// savePodSandboxSQLite updates the pod sandbox with given ID within
+// the transaction, returning the events that describe the changes.
+// When the pod sandbox is removed, its containers are handled
+// according to the removal policy.
+func savePodSandboxSQLite(tx *sql.Tx, policy SandboxRemovalPolicy, podID string, updater func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error)) ([]*Event, error) {
+	var current *types.PodSandboxInfo
+	if err := sqliteGet(tx, &current, "SELECT data FROM sandboxes WHERE id = ?", podID); err != nil {
+		return nil, err
+	}
+	newData, err := updatePodSandbox(current, updater, sqliteNextRevision(tx))
+	if err != nil {
+		return nil, err
+	}
+	if newData == nil {
+		containerIDs, err := sqliteStrings(tx, "SELECT container_id FROM sandbox_containers WHERE sandbox_id = ? ORDER BY container_id", podID)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkSandboxRemoval(policy, podID, containerIDs); err != nil {
+			return nil, err
+		}
+		var events []*Event
+		for _, containerID := range containerIDs {
+			event, err := saveContainerSQLite(tx, containerID, func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+				return nil, nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, eventList(event)...)
+		}
+		if _, err := tx.Exec("DELETE FROM sandboxes WHERE id = ?", podID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("DELETE FROM sandbox_containers WHERE sandbox_id = ?", podID); err != nil {
+			return nil, err
+		}
+		return append(events, eventList(sandboxEvent(podID, current, nil))...), nil
+	}
+
+	data, err := json.Marshal(newData)
+	if err != nil {
+		return nil, err
+	}
+	var namespace, name string
+	if newData.Config != nil {
+		namespace, name = newData.Config.Namespace, newData.Config.Name
+	}
+	if _, err := tx.Exec(
+		"INSERT OR REPLACE INTO sandboxes (id, namespace, name, state, created_at, data) VALUES (?, ?, ?, ?, ?, ?)",
+		podID, namespace, name, int32(newData.State), newData.CreatedAt, string(data)); err != nil {
+		return nil, err
+	}
+	return eventList(sandboxEvent(podID, current, newData)), nil
+}
+
+// SetSandboxRemovalPolicy implements SetSandboxRemovalPolicy method
+// of SandboxStore interface
+func (c *sqliteClient) SetSandboxRemovalPolicy(policy SandboxRemovalPolicy) {
+	c.removalPolicy = policy
+}
+
+// PodSandbox implements PodSandbox method of SandboxStore interface
+func (c *sqliteClient) PodSandbox(podID string) PodSandboxMetadata {
+	return c.podSandboxAs(nil, podID)
+}
+
+// podSandboxAs implements podSandboxAs method of auditedBackend interface
+func (c *sqliteClient) podSandboxAs(origin *auditOrigin, podID string) PodSandboxMetadata {
+	return &sqlitePodSandboxMeta{id: podID, client: c, origin: origin}
+}
+
+// ListPodSandboxes implements ListPodSandboxes method of SandboxStore interface
+func (c *sqliteClient) ListPodSandboxes(filter *types.PodSandboxFilter) ([]PodSandboxMetadata, error) {
+	result, _, err := c.ListPodSandboxesPage(filter, 0, "")
+	return result, err
+}
+
+// ListPodSandboxesPage implements ListPodSandboxesPage method of SandboxStore interface
+func (c *sqliteClient) ListPodSandboxesPage(filter *types.PodSandboxFilter, limit int, continueToken string) ([]PodSandboxMetadata, string, error) {
+	ids, err := sqliteStrings(c.db, "SELECT id FROM sandboxes UNION SELECT sandbox_id FROM sandbox_containers ORDER BY 1")
+	if err != nil {
+		return nil, "", err
+	}
+	pageIDs, nextToken, err := listPage(ids, limit, continueToken, func(id string) (bool, error) {
+		return filterPodSandboxMeta(&sqlitePodSandboxMeta{client: c, id: id}, filter)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	var result []PodSandboxMetadata
+	for _, id := range pageIDs {
+		result = append(result, sqlitePodSandboxMeta{client: c, id: id})
+	}
+	return result, nextToken, nil
+}
+
+type sqliteContainerMeta struct {
+	client *sqliteClient
+	id     string
+	origin *auditOrigin
+}
+
+// GetID implements GetID method of ContainerMetadata interface
+func (m sqliteContainerMeta) GetID() string {
+	return m.id
+}
+
+// Retrieve implements Retrieve method of ContainerMetadata interface
+func (m sqliteContainerMeta) Retrieve() (*types.ContainerInfo, error) {
+	if m.GetID() == "" {
+		return nil, errors.New("Container ID cannot be empty")
+	}
+	var ci *types.ContainerInfo
+	if err := sqliteGet(m.client.db, &ci, "SELECT data FROM containers WHERE id = ?", m.GetID()); err != nil {
+		return nil, err
+	}
+	return ci, nil
+}
+
+// Save implements Save method of ContainerMetadata interface
+func (m sqliteContainerMeta) Save(updater func(*types.ContainerInfo) (*types.ContainerInfo, error)) error {
+	if m.GetID() == "" {
+		return errors.New("Container ID cannot be empty")
+	}
+	return m.client.watchers.update(m.origin, func() (*Event, error) {
+		var event *Event
+		if err := m.client.update(func(tx *sql.Tx) error {
+			var err error
+			event, err = saveContainerSQLite(tx, m.GetID(), updater)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		return event, nil
+	})
+}
+
+// saveContainerSQLite updates the container with given ID within the
+// transaction, returning the event that describes the change, if any.
+func saveContainerSQLite(tx *sql.Tx, containerID string, updater func(*types.ContainerInfo) (*types.ContainerInfo, error)) (*Event, error) {
+	var current *types.ContainerInfo
+	if err := sqliteGet(tx, &current, "SELECT data FROM containers WHERE id = ?", containerID); err != nil {
+		return nil, err
+	}
+	var oldPodID string
+	if current != nil {
+		oldPodID = current.Config.PodSandboxID
+	}
+	newData, err := updateContainer(current, updater, sqliteNextRevision(tx))
+	if err != nil {
+		return nil, err
+	}
+
+	if current == nil && newData == nil {
+		return nil, nil
+	}
+
+	if _, err := tx.Exec("DELETE FROM container_images WHERE container_id = ?", containerID); err != nil {
+		return nil, err
+	}
+	var newPodID string
+	if newData != nil {
+		newPodID = newData.Config.PodSandboxID
+	}
+	// the sandbox association is only changed when the container
+	// moves to another sandbox, so the containers aren't
+	// re-associated with the removed sandboxes
+	if oldPodID != newPodID {
+		if oldPodID != "" {
+			if _, err := tx.Exec("DELETE FROM sandbox_containers WHERE sandbox_id = ? AND container_id = ?", oldPodID, containerID); err != nil {
+				return nil, err
+			}
+		}
+		if newPodID != "" {
+			if _, err := tx.Exec("INSERT OR IGNORE INTO sandbox_containers (sandbox_id, container_id) VALUES (?, ?)", newPodID, containerID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if newData == nil {
+		if _, err := tx.Exec("DELETE FROM containers WHERE id = ?", containerID); err != nil {
+			return nil, err
+		}
+		return containerEvent(containerID, current, nil), nil
+	}
+	newData.Id = containerID
+
+	data, err := json.Marshal(newData)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(
+		"INSERT OR REPLACE INTO containers (id, sandbox_id, name, image, state, created_at, data) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		containerID, newPodID, newData.Name, newData.Config.Image, int32(newData.State), newData.CreatedAt, string(data)); err != nil {
+		return nil, err
+	}
+	images := []string{newData.Config.Image}
+	if newData.Config.ParsedAnnotations != nil {
+		images = append(images, newData.Config.ParsedAnnotations.CDROMImages...)
+	}
+	for _, image := range images {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO container_images (container_id, image) VALUES (?, ?)", containerID, image); err != nil {
+			return nil, err
+		}
+	}
+	return containerEvent(containerID, current, newData), nil
+}
+
+type sqliteTx struct {
+	tx     *sql.Tx
+	policy SandboxRemovalPolicy
+	events []*Event
+}
+
+var _ Tx = &sqliteTx{}
+
+// SavePodSandbox implements SavePodSandbox method of Tx interface
+func (t *sqliteTx) SavePodSandbox(podID string, updater func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error)) error {
+	if podID == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	events, err := savePodSandboxSQLite(t.tx, t.policy, podID, updater)
+	if err != nil {
+		return err
+	}
+	t.events = append(t.events, events...)
+	return nil
+}
+
+// SaveContainer implements SaveContainer method of Tx interface
+func (t *sqliteTx) SaveContainer(containerID string, updater func(*types.ContainerInfo) (*types.ContainerInfo, error)) error {
+	if containerID == "" {
+		return errors.New("Container ID cannot be empty")
+	}
+	event, err := saveContainerSQLite(t.tx, containerID, updater)
+	if err != nil {
+		return err
+	}
+	t.events = append(t.events, eventList(event)...)
+	return nil
+}
+
+// PodContainerIDs implements PodContainerIDs method of Tx interface
+func (t *sqliteTx) PodContainerIDs(podID string) ([]string, error) {
+	if podID == "" {
+		return nil, errors.New("Pod sandbox ID cannot be empty")
+	}
+	return sqliteStrings(t.tx, "SELECT container_id FROM sandbox_containers WHERE sandbox_id = ? ORDER BY container_id", podID)
+}
+
+// Update implements Update method of TransactionStore interface
+func (c *sqliteClient) Update(fn func(tx Tx) error) error {
+	return c.updateAs(nil, fn)
+}
+
+// updateAs implements updateAs method of auditedBackend interface
+func (c *sqliteClient) updateAs(origin *auditOrigin, fn func(tx Tx) error) error {
+	return c.watchers.updateMulti(origin, func() ([]*Event, error) {
+		var events []*Event
+		if err := c.update(func(tx *sql.Tx) error {
+			t := &sqliteTx{tx: tx, policy: c.removalPolicy}
+			if err := fn(t); err != nil {
+				return err
+			}
+			events = t.events
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		return events, nil
+	})
+}
+
+// Container implements Container method of ContainerStore interface
+func (c *sqliteClient) Container(containerID string) ContainerMetadata {
+	return c.containerAs(nil, containerID)
+}
+
+// containerAs implements containerAs method of auditedBackend interface
+func (c *sqliteClient) containerAs(origin *auditOrigin, containerID string) ContainerMetadata {
+	return &sqliteContainerMeta{id: containerID, client: c, origin: origin}
+}
+
+// ListPodContainers implements ListPodContainers method of ContainerStore interface
+func (c *sqliteClient) ListPodContainers(podID string) ([]ContainerMetadata, error) {
+	if podID == "" {
+		return nil, errors.New("Pod sandbox ID cannot be empty")
+	}
+	var ids []string
+	if err := c.view(func(tx *sql.Tx) error {
+		exists, err := sqliteSandboxExists(tx, podID)
+		switch {
+		case err != nil:
+			return err
+		case !exists:
+			return fmt.Errorf("pod sandbox %q does not exist", podID)
+		}
+		ids, err = sqliteStrings(tx, "SELECT container_id FROM sandbox_containers WHERE sandbox_id = ? ORDER BY container_id", podID)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	var result []ContainerMetadata
+	for _, id := range ids {
+		result = append(result, c.Container(id))
+	}
+	return result, nil
+}
+
+// ListContainersPage implements ListContainersPage method of ContainerStore interface
+func (c *sqliteClient) ListContainersPage(limit int, continueToken string) ([]ContainerMetadata, string, error) {
+	ids, err := sqliteStrings(c.db, "SELECT id FROM containers ORDER BY id")
+	if err != nil {
+		return nil, "", err
+	}
+	pageIDs, nextToken, err := listPage(ids, limit, continueToken, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	var result []ContainerMetadata
+	for _, id := range pageIDs {
+		result = append(result, c.Container(id))
+	}
+	return result, nextToken, nil
+}
+
+// ListContainerInfos implements ListContainerInfos method of ContainerStore interface
+func (c *sqliteClient) ListContainerInfos() ([]*types.ContainerInfo, error) {
+	values, err := sqliteStrings(c.db, "SELECT data FROM containers ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	var result []*types.ContainerInfo
+	for _, v := range values {
+		var ci *types.ContainerInfo
+		if err := json.Unmarshal([]byte(v), &ci); err != nil {
+			return nil, err
+		}
+		result = append(result, ci)
+	}
+	return result, nil
+}
+
+// ImagesInUse implements ImagesInUse method of ContainerStore interface
+func (c *sqliteClient) ImagesInUse() (map[string]bool, error) {
+	images, err := sqliteStrings(c.db,
+		"SELECT DISTINCT ci.image FROM container_images ci JOIN sandbox_containers sc ON sc.container_id = ci.container_id")
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool)
+	for _, image := range images {
+		result[image] = true
+	}
+	return result, nil
+}
+
+// AddStartRecord implements AddStartRecord method of StartRecordStore interface
+func (c *sqliteClient) AddStartRecord(record *types.VMStartRecord) error {
+	if record.PodName == "" {
+		return errors.New("Pod name cannot be empty")
+	}
+	return c.update(func(tx *sql.Tx) error {
+		res, err := tx.Exec("INSERT INTO start_records (pod_namespace, pod_name, data) VALUES (?, ?, '')", record.PodNamespace, record.PodName)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		record.ID = uint64(id)
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE start_records SET data = ? WHERE id = ?", string(data), id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			"DELETE FROM start_records WHERE pod_namespace = ? AND pod_name = ? AND id NOT IN "+
+				"(SELECT id FROM start_records WHERE pod_namespace = ? AND pod_name = ? ORDER BY id DESC LIMIT ?)",
+			record.PodNamespace, record.PodName, record.PodNamespace, record.PodName, maxStartRecordsPerPod); err != nil {
+			return err
+		}
+		_, err = tx.Exec("DELETE FROM start_records WHERE id NOT IN (SELECT id FROM start_records ORDER BY id DESC LIMIT ?)", maxStartRecords)
+		return err
+	})
+}
+
+// ListStartRecords implements ListStartRecords method of StartRecordStore interface
+func (c *sqliteClient) ListStartRecords(podNamespace, podName string) ([]*types.VMStartRecord, error) {
+	var values []string
+	var err error
+	if podName == "" {
+		values, err = sqliteStrings(c.db, "SELECT data FROM start_records ORDER BY id")
+	} else {
+		values, err = sqliteStrings(c.db, "SELECT data FROM start_records WHERE pod_namespace = ? AND pod_name = ? ORDER BY id", podNamespace, podName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []*types.VMStartRecord
+	for _, v := range values {
+		var record *types.VMStartRecord
+		if err := json.Unmarshal([]byte(v), &record); err != nil {
+			return nil, fmt.Errorf("error unmarshalling start record: %v", err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// AddSandboxTombstone implements AddSandboxTombstone method of SandboxTombstoneStore interface
+func (c *sqliteClient) AddSandboxTombstone(tombstone *types.SandboxTombstone) error {
+	if tombstone.PodSandboxID == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	data, err := json.Marshal(tombstone)
+	if err != nil {
+		return err
+	}
+	return c.update(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(
+			"INSERT OR REPLACE INTO sandbox_tombstones (sandbox_id, deleted_at, data) VALUES (?, ?, ?)",
+			tombstone.PodSandboxID, tombstone.DeletedAt, string(data)); err != nil {
+			return err
+		}
+		_, err := tx.Exec(
+			"DELETE FROM sandbox_tombstones WHERE sandbox_id NOT IN "+
+				"(SELECT sandbox_id FROM sandbox_tombstones ORDER BY deleted_at DESC, sandbox_id DESC LIMIT ?)",
+			maxSandboxTombstones)
+		return err
+	})
+}
+
+// ListSandboxTombstones implements ListSandboxTombstones method of SandboxTombstoneStore interface
+func (c *sqliteClient) ListSandboxTombstones() ([]*types.SandboxTombstone, error) {
+	values, err := sqliteStrings(c.db, "SELECT data FROM sandbox_tombstones ORDER BY deleted_at, sandbox_id")
+	if err != nil {
+		return nil, err
+	}
+	var tombstones []*types.SandboxTombstone
+	for _, v := range values {
+		var tombstone *types.SandboxTombstone
+		if err := json.Unmarshal([]byte(v), &tombstone); err != nil {
+			return nil, fmt.Errorf("error unmarshalling sandbox tombstone: %v", err)
+		}
+		tombstones = append(tombstones, tombstone)
+	}
+	return tombstones, nil
+}
+
+// PruneSandboxTombstones implements PruneSandboxTombstones method of SandboxTombstoneStore interface
+func (c *sqliteClient) PruneSandboxTombstones(before int64) (int, error) {
+	res, err := c.db.Exec("DELETE FROM sandbox_tombstones WHERE deleted_at < ?", before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// AddSandboxEvent implements AddSandboxEvent method of SandboxEventStore interface
+func (c *sqliteClient) AddSandboxEvent(event *types.SandboxEvent) error {
+	if event.PodSandboxID == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	return c.update(func(tx *sql.Tx) error {
+		res, err := tx.Exec("INSERT INTO sandbox_events (sandbox_id, data) VALUES (?, '')", event.PodSandboxID)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		event.ID = uint64(id)
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE sandbox_events SET data = ? WHERE id = ?", string(data), id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			"DELETE FROM sandbox_events WHERE sandbox_id = ? AND id NOT IN "+
+				"(SELECT id FROM sandbox_events WHERE sandbox_id = ? ORDER BY id DESC LIMIT ?)",
+			event.PodSandboxID, event.PodSandboxID, maxSandboxEventsPerPod); err != nil {
+			return err
+		}
+		_, err = tx.Exec("DELETE FROM sandbox_events WHERE id NOT IN (SELECT id FROM sandbox_events ORDER BY id DESC LIMIT ?)", maxSandboxEvents)
+		return err
+	})
+}
+
+// ListSandboxEvents implements ListSandboxEvents method of SandboxEventStore interface
+func (c *sqliteClient) ListSandboxEvents(podID string) ([]*types.SandboxEvent, error) {
+	var values []string
+	var err error
+	if podID == "" {
+		values, err = sqliteStrings(c.db, "SELECT data FROM sandbox_events ORDER BY id")
+	} else {
+		values, err = sqliteStrings(c.db, "SELECT data FROM sandbox_events WHERE sandbox_id = ? ORDER BY id", podID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var events []*types.SandboxEvent
+	for _, v := range values {
+		var event *types.SandboxEvent
+		if err := json.Unmarshal([]byte(v), &event); err != nil {
+			return nil, fmt.Errorf("error unmarshalling sandbox event: %v", err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// GetFirstBootRecord implements GetFirstBootRecord method of FirstBootStore interface
+func (c *sqliteClient) GetFirstBootRecord(volumeID string) (*types.FirstBootRecord, error) {
+	if volumeID == "" {
+		return nil, errors.New("Volume ID cannot be empty")
+	}
+	var record *types.FirstBootRecord
+	if err := sqliteGet(c.db, &record, "SELECT data FROM first_boot_records WHERE volume_id = ?", volumeID); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// SaveFirstBootRecord implements SaveFirstBootRecord method of FirstBootStore interface
+func (c *sqliteClient) SaveFirstBootRecord(volumeID string, updater func(*types.FirstBootRecord) (*types.FirstBootRecord, error)) error {
+	if volumeID == "" {
+		return errors.New("Volume ID cannot be empty")
+	}
+	return c.update(func(tx *sql.Tx) error {
+		var current *types.FirstBootRecord
+		if err := sqliteGet(tx, &current, "SELECT data FROM first_boot_records WHERE volume_id = ?", volumeID); err != nil {
+			return err
+		}
+		record, err := updater(current)
+		switch {
+		case err != nil:
+			return err
+		case record == nil && current == nil:
+			return nil
+		case record == nil:
+			_, err := tx.Exec("DELETE FROM first_boot_records WHERE volume_id = ?", volumeID)
+			return err
+		}
+		record.VolumeID = volumeID
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT OR REPLACE INTO first_boot_records (volume_id, data) VALUES (?, ?)", volumeID, string(data))
+		return err
+	})
+}
+
+// GetImagePullJob implements GetImagePullJob method of ImagePullStore interface
+func (c *sqliteClient) GetImagePullJob(imageName string) (*types.ImagePullJob, error) {
+	if imageName == "" {
+		return nil, errors.New("Image name cannot be empty")
+	}
+	var job *types.ImagePullJob
+	if err := sqliteGet(c.db, &job, "SELECT data FROM image_pull_jobs WHERE image_name = ?", imageName); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// SaveImagePullJob implements SaveImagePullJob method of ImagePullStore interface
+func (c *sqliteClient) SaveImagePullJob(imageName string, updater func(*types.ImagePullJob) (*types.ImagePullJob, error)) error {
+	return c.saveImagePullJobAs(nil, imageName, updater)
+}
+
+// saveImagePullJobAs implements saveImagePullJobAs method of auditedBackend interface
+func (c *sqliteClient) saveImagePullJobAs(origin *auditOrigin, imageName string, updater func(*types.ImagePullJob) (*types.ImagePullJob, error)) error {
+	if imageName == "" {
+		return errors.New("Image name cannot be empty")
+	}
+	updater, audit := c.watchers.auditImagePullJob(origin, imageName, updater)
+	return audit(c.update(func(tx *sql.Tx) error {
+		var current *types.ImagePullJob
+		if err := sqliteGet(tx, &current, "SELECT data FROM image_pull_jobs WHERE image_name = ?", imageName); err != nil {
+			return err
+		}
+		job, err := updater(current)
+		switch {
+		case err != nil:
+			return err
+		case job == nil && current == nil:
+			return nil
+		case job == nil:
+			_, err := tx.Exec("DELETE FROM image_pull_jobs WHERE image_name = ?", imageName)
+			return err
+		}
+		job.ImageName = imageName
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT OR REPLACE INTO image_pull_jobs (image_name, data) VALUES (?, ?)", imageName, string(data))
+		return err
+	}))
+}
+
+// ListImagePullJobs implements ListImagePullJobs method of ImagePullStore interface
+func (c *sqliteClient) ListImagePullJobs() ([]*types.ImagePullJob, error) {
+	values, err := sqliteStrings(c.db, "SELECT data FROM image_pull_jobs ORDER BY image_name")
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*types.ImagePullJob
+	for _, v := range values {
+		var job *types.ImagePullJob
+		if err := json.Unmarshal([]byte(v), &job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
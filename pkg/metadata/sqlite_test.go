@@ -0,0 +1,202 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jonboulle/clockwork"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/fake"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func withSQLiteTestDir(t *testing.T, toCall func(dir string)) {
+	dir, err := ioutil.TempDir("", "sqlite-test-")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(dir)
+	toCall(dir)
+}
+
+func TestSQLiteStore(t *testing.T) {
+	withSQLiteTestDir(t, func(dir string) {
+		n := 0
+		var stores []Store
+		oldNewTestStore := newTestStore
+		newTestStore = func() (Store, error) {
+			n++
+			store, err := NewSQLiteStore(filepath.Join(dir, fmt.Sprintf("virtlet-%d.sqlite", n)))
+			if err == nil {
+				stores = append(stores, store)
+			}
+			return store, err
+		}
+		defer func() {
+			newTestStore = oldNewTestStore
+			for _, store := range stores {
+				store.Close()
+			}
+		}()
+
+		// the tests that use golden data are not run here
+		// as they would need separate data files
+		for _, tc := range []struct {
+			name string
+			test func(t *testing.T)
+		}{
+			{"SetGetContainerInfo", TestSetGetContainerInfo},
+			{"ListContainerInfos", TestListContainerInfos},
+			{"GetImagesInUse", TestGetImagesInUse},
+			{"RemoveContainer", TestRemoveContainer},
+			{"FirstBootRecords", TestFirstBootRecords},
+			{"ImagePullJobs", TestImagePullJobs},
+			{"RemovePodSandbox", TestRemovePodSandbox},
+			{"SandboxRemovalPolicy", TestSandboxRemovalPolicy},
+			{"Retrieve", TestRetrieve},
+			{"SetGetPodSandboxStatus", TestSetGetPodSandboxStatus},
+			{"ListPodSandbox", TestListPodSandbox},
+			{"PodSandboxLabelIndex", TestPodSandboxLabelIndex},
+			{"ListPages", TestListPages},
+			{"StartRecords", TestStartRecords},
+			{"SandboxTombstones", TestSandboxTombstones},
+			{"SandboxEvents", TestSandboxEvents},
+			{"Update", TestUpdate},
+			{"Watch", TestWatch},
+			{"Revisions", TestRevisions},
+		} {
+			t.Run(tc.name, tc.test)
+		}
+	})
+}
+
+func TestSQLiteQueries(t *testing.T) {
+	withSQLiteTestDir(t, func(dir string) {
+		dbPath := filepath.Join(dir, "virtlet.sqlite")
+		store, err := NewSQLiteStore(dbPath)
+		if err != nil {
+			t.Fatalf("NewSQLiteStore(): %v", err)
+		}
+		defer store.Close()
+
+		sandboxes := fake.GetSandboxes(2)
+		for _, sandbox := range sandboxes {
+			psi, err := NewPodSandboxInfo(sandbox, nil, types.PodSandboxState_SANDBOX_READY, clockwork.NewRealClock())
+			if err != nil {
+				t.Fatalf("NewPodSandboxInfo(): %v", err)
+			}
+			if err := store.PodSandbox(sandbox.Uid).Save(func(c *types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+				return psi, nil
+			}); err != nil {
+				t.Fatalf("PodSandbox().Save(): %v", err)
+			}
+		}
+		for _, container := range fake.GetContainersConfig(sandboxes) {
+			if err := store.Container(container.ContainerID).Save(func(c *types.ContainerInfo) (*types.ContainerInfo, error) {
+				return &types.ContainerInfo{
+					Name: container.Name,
+					Config: types.VMConfig{
+						PodSandboxID: container.SandboxID,
+						Image:        container.Image,
+					},
+				}, nil
+			}); err != nil {
+				t.Fatalf("Container().Save(): %v", err)
+			}
+		}
+
+		// the database can be queried directly while it's used
+		// by the store
+		db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro")
+		if err != nil {
+			t.Fatalf("sql.Open(): %v", err)
+		}
+		defer db.Close()
+		rows, err := db.Query(
+			"SELECT s.name, c.name, ci.image FROM sandboxes s " +
+				"JOIN containers c ON c.sandbox_id = s.id " +
+				"JOIN container_images ci ON ci.container_id = c.id " +
+				"ORDER BY s.name")
+		if err != nil {
+			t.Fatalf("Query(): %v", err)
+		}
+		defer rows.Close()
+		var result []string
+		for rows.Next() {
+			var podName, containerName, image string
+			if err := rows.Scan(&podName, &containerName, &image); err != nil {
+				t.Fatalf("Scan(): %v", err)
+			}
+			result = append(result, strings.Join([]string{podName, containerName, image}, " "))
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatalf("rows.Err(): %v", err)
+		}
+		var expectedResult []string
+		for _, container := range fake.GetContainersConfig(sandboxes) {
+			for _, sandbox := range sandboxes {
+				if sandbox.Uid == container.SandboxID {
+					expectedResult = append(expectedResult, strings.Join([]string{sandbox.Name, container.Name, container.Image}, " "))
+				}
+			}
+		}
+		if !reflect.DeepEqual(result, expectedResult) {
+			t.Errorf("bad query result: %#v instead of %#v", result, expectedResult)
+		}
+	})
+}
+
+func TestSQLiteSchemaVersion(t *testing.T) {
+	withSQLiteTestDir(t, func(dir string) {
+		dbPath := filepath.Join(dir, "virtlet.sqlite")
+		store, err := NewSQLiteStore(dbPath)
+		if err != nil {
+			t.Fatalf("NewSQLiteStore(): %v", err)
+		}
+		store.Close()
+
+		// reopening the database works
+		store, err = NewSQLiteStore(dbPath)
+		if err != nil {
+			t.Fatalf("NewSQLiteStore() on an existing database: %v", err)
+		}
+		store.Close()
+
+		db, err := sql.Open("sqlite3", "file:"+dbPath)
+		if err != nil {
+			t.Fatalf("sql.Open(): %v", err)
+		}
+		if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", sqliteSchemaVersion+1)); err != nil {
+			t.Fatalf("error setting user_version: %v", err)
+		}
+		db.Close()
+
+		if _, err := NewSQLiteStore(dbPath); err == nil {
+			t.Errorf("NewSQLiteStore() didn't fail for a database with a newer schema")
+		} else if !strings.Contains(err.Error(), "newer than the supported") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const startRecordIDLen = 20
+
+var (
+	startRecordsBucket = []byte("startRecords")
+
+	// maxStartRecordsPerPod is the number of the most recent start
+	// records that are kept for each pod
+	maxStartRecordsPerPod = 10
+	// maxStartRecords is the maximum total number of the start
+	// records in the store
+	maxStartRecords = 500
+)
+
+func startRecordPrefix(podNamespace, podName string) []byte {
+	return []byte(podNamespace + "/" + podName + "/")
+}
+
+func startRecordKey(podNamespace, podName string, id uint64) []byte {
+	return append(startRecordPrefix(podNamespace, podName), []byte(fmt.Sprintf("%0*d", startRecordIDLen, id))...)
+}
+
+func startRecordID(key []byte) uint64 {
+	if len(key) < startRecordIDLen {
+		return 0
+	}
+	id, err := strconv.ParseUint(string(key[len(key)-startRecordIDLen:]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+// AddStartRecord implements AddStartRecord method of StartRecordStore interface
+func (b *boltClient) AddStartRecord(record *types.VMStartRecord) error {
+	if record.PodName == "" {
+		return errors.New("Pod name cannot be empty")
+	}
+	return b.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(startRecordsBucket)
+		if err != nil {
+			return err
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		record.ID = id
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(startRecordKey(record.PodNamespace, record.PodName, id), data); err != nil {
+			return err
+		}
+		if err := pruneStartRecords(bucket, startRecordPrefix(record.PodNamespace, record.PodName), maxStartRecordsPerPod); err != nil {
+			return err
+		}
+		return pruneStartRecords(bucket, nil, maxStartRecords)
+	})
+}
+
+// pruneStartRecords removes the oldest start records with the
+// specified key prefix so that no more than maxCount of such
+// records remain.
+func pruneStartRecords(bucket *bolt.Bucket, prefix []byte, maxCount int) error {
+	var keys [][]byte
+	c := bucket.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	if len(keys) <= maxCount {
+		return nil
+	}
+	sort.Slice(keys, func(i, j int) bool { return startRecordID(keys[i]) < startRecordID(keys[j]) })
+	for _, k := range keys[:len(keys)-maxCount] {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListStartRecords implements ListStartRecords method of StartRecordStore interface
+func (b *boltClient) ListStartRecords(podNamespace, podName string) ([]*types.VMStartRecord, error) {
+	var prefix []byte
+	if podName != "" {
+		prefix = startRecordPrefix(podNamespace, podName)
+	}
+	var records []*types.VMStartRecord
+	if err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(startRecordsBucket)
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var record *types.VMStartRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return fmt.Errorf("error unmarshalling start record %q: %v", k, err)
+			}
+			records = append(records, record)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records, nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func startRecordIDs(t *testing.T, store Store, podNamespace, podName string) []string {
+	records, err := store.ListStartRecords(podNamespace, podName)
+	if err != nil {
+		t.Fatalf("ListStartRecords(): %v", err)
+	}
+	var r []string
+	for _, record := range records {
+		r = append(r, fmt.Sprintf("%d:%s/%s:%s", record.ID, record.PodNamespace, record.PodName, record.DomainXML))
+	}
+	return r
+}
+
+func TestStartRecords(t *testing.T) {
+	oldMaxPerPod, oldMax := maxStartRecordsPerPod, maxStartRecords
+	maxStartRecordsPerPod, maxStartRecords = 3, 5
+	defer func() {
+		maxStartRecordsPerPod, maxStartRecords = oldMaxPerPod, oldMax
+	}()
+
+	store := setUpTestStore(t, nil, nil, nil)
+	if ids := startRecordIDs(t, store, "", ""); len(ids) != 0 {
+		t.Errorf("ListStartRecords() returned non-empty result for an empty db: %#v", ids)
+	}
+
+	for n, pod := range []string{"foo", "foo", "bar", "foo", "foo", "baz", "bar"} {
+		record := &types.VMStartRecord{
+			PodNamespace: "default",
+			PodName:      pod,
+			DomainXML:    fmt.Sprintf("<domain%d/>", n+1),
+		}
+		if err := store.AddStartRecord(record); err != nil {
+			t.Fatalf("AddStartRecord(): %v", err)
+		}
+		if record.ID != uint64(n+1) {
+			t.Errorf("bad record ID %d, expected %d", record.ID, n+1)
+		}
+	}
+
+	for _, tc := range []struct {
+		podName     string
+		expectedIDs []string
+	}{
+		{
+			podName: "foo",
+			expectedIDs: []string{
+				"4:default/foo:<domain4/>",
+				"5:default/foo:<domain5/>",
+			},
+		},
+		{
+			podName: "bar",
+			expectedIDs: []string{
+				"3:default/bar:<domain3/>",
+				"7:default/bar:<domain7/>",
+			},
+		},
+		{
+			podName: "",
+			expectedIDs: []string{
+				"3:default/bar:<domain3/>",
+				"4:default/foo:<domain4/>",
+				"5:default/foo:<domain5/>",
+				"6:default/baz:<domain6/>",
+				"7:default/bar:<domain7/>",
+			},
+		},
+		{
+			podName: "nosuchpod",
+		},
+	} {
+		t.Run(tc.podName, func(t *testing.T) {
+			ids := startRecordIDs(t, store, "default", tc.podName)
+			if !reflect.DeepEqual(ids, tc.expectedIDs) {
+				t.Errorf("bad start records: %#v instead of %#v", ids, tc.expectedIDs)
+			}
+		})
+	}
+
+	if err := store.AddStartRecord(&types.VMStartRecord{PodNamespace: "default"}); err == nil {
+		t.Errorf("AddStartRecord() didn't fail for a record without the pod name")
+	}
+}
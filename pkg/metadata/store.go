@@ -20,6 +20,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
 
 	"github.com/jonboulle/clockwork"
 
@@ -27,6 +29,31 @@ import (
 	"github.com/Mirantis/virtlet/pkg/network"
 )
 
+// SandboxRemovalPolicy specifies what happens to the container
+// records of a pod sandbox when the pod sandbox is removed
+type SandboxRemovalPolicy string
+
+const (
+	// SandboxRemovalCascade makes the container records of the pod
+	// sandbox be removed along with it within the same transaction.
+	// This is the default policy
+	SandboxRemovalCascade SandboxRemovalPolicy = "cascade"
+	// SandboxRemovalRestrict makes the removal of a pod sandbox fail
+	// if there are container records that reference it
+	SandboxRemovalRestrict SandboxRemovalPolicy = "restrict"
+)
+
+// checkSandboxRemoval returns an error if the pod sandbox that still
+// has the specified containers can't be removed under the policy
+func checkSandboxRemoval(policy SandboxRemovalPolicy, podID string, containerIDs []string) error {
+	if policy != SandboxRemovalRestrict || len(containerIDs) == 0 {
+		return nil
+	}
+	ids := append([]string(nil), containerIDs...)
+	sort.Strings(ids)
+	return fmt.Errorf("can't remove pod sandbox %q because it still has containers: %s", podID, strings.Join(ids, ", "))
+}
+
 // PodSandboxMetadata contains methods of a single Pod sandbox
 type PodSandboxMetadata interface {
 	// GetID returns ID of the pod sandbox managed by this object
@@ -49,6 +76,20 @@ type SandboxStore interface {
 
 	// ListPodSandboxes returns list of pod sandboxes that match given filter
 	ListPodSandboxes(filter *types.PodSandboxFilter) ([]PodSandboxMetadata, error)
+
+	// ListPodSandboxesPage returns at most limit pod sandboxes that match
+	// given filter ordered by their IDs, starting after the position
+	// denoted by continueToken, which must be empty for the first page.
+	// limit <= 0 means no limit. The returned continue token must be
+	// passed to get the next page, and it's empty if there are no more
+	// pod sandboxes. The pages are read separately, so the updates made
+	// between the calls may or may not be reflected in the results
+	ListPodSandboxesPage(filter *types.PodSandboxFilter, limit int, continueToken string) ([]PodSandboxMetadata, string, error)
+
+	// SetSandboxRemovalPolicy sets the policy that specifies what
+	// happens to the container records of the pod sandboxes being
+	// removed. The default policy is SandboxRemovalCascade
+	SetSandboxRemovalPolicy(policy SandboxRemovalPolicy)
 }
 
 // ContainerMetadata contains methods of a single container (VM)
@@ -74,15 +115,171 @@ type ContainerStore interface {
 	// ListPodContainers returns a list of containers that belong to the pod with given ID value
 	ListPodContainers(podID string) ([]ContainerMetadata, error)
 
+	// ListContainersPage returns at most limit containers ordered by
+	// their IDs, starting after the position denoted by continueToken,
+	// which must be empty for the first page. limit <= 0 means no limit.
+	// The returned continue token must be passed to get the next page,
+	// and it's empty if there are no more containers
+	ListContainersPage(limit int, continueToken string) ([]ContainerMetadata, string, error)
+
+	// ListContainerInfos returns the data of all the containers
+	// ordered by their IDs. The data is read at once so it forms a
+	// consistent snapshot of the containers, and the read doesn't
+	// wait for the writes that are in progress
+	ListContainerInfos() ([]*types.ContainerInfo, error)
+
 	// ImagesInUse returns a set of images in use by containers in the store.
 	// The keys of the returned map are image names and the values are always true.
 	ImagesInUse() (map[string]bool, error)
 }
 
+// Tx is used to update several pod sandboxes and containers within
+// a single transaction
+type Tx interface {
+	// SavePodSandbox allows to create/modify/delete the pod sandbox
+	// with given ID within the transaction. The updater is used in
+	// the same way as in Save method of PodSandboxMetadata
+	SavePodSandbox(podID string, updater func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error)) error
+	// SaveContainer allows to create/modify/delete the container
+	// with given ID within the transaction. The updater is used in
+	// the same way as in Save method of ContainerMetadata
+	SaveContainer(containerID string, updater func(*types.ContainerInfo) (*types.ContainerInfo, error)) error
+	// PodContainerIDs returns the sorted list of IDs of the containers
+	// that belong to the pod sandbox with given ID, including the
+	// changes made within the transaction. For nonexistent pod
+	// sandboxes, an empty list is returned
+	PodContainerIDs(podID string) ([]string, error)
+}
+
+// TransactionStore contains methods to update several objects of the
+// store atomically
+type TransactionStore interface {
+	// Update invokes fn with a Tx that can be used to update several
+	// pod sandboxes and containers. Either all of the changes made
+	// via the Tx are committed or none of them. If fn returns an
+	// error, the transaction is rolled back and returned error
+	// becomes the result of the function. fn may be invoked more
+	// than once, so it must not have side effects besides the
+	// changes made via the Tx. The watchers receive the events for
+	// all the changes after the transaction is committed
+	Update(fn func(tx Tx) error) error
+}
+
+// StartRecordStore contains methods to operate on the records that
+// describe the artifacts used for the VM starts
+type StartRecordStore interface {
+	// AddStartRecord stores a new VM start record assigning it a new ID.
+	// Only a limited number of the most recent records is kept for each
+	// pod, with the total number of the records being limited, too
+	AddStartRecord(record *types.VMStartRecord) error
+
+	// ListStartRecords returns the start records for the pod with given
+	// namespace and name ordered from the oldest to the newest one.
+	// If podName is empty, the records for all the pods are returned
+	ListStartRecords(podNamespace, podName string) ([]*types.VMStartRecord, error)
+}
+
+// SandboxTombstoneStore contains methods to operate on the records
+// that are kept for the removed pod sandboxes
+type SandboxTombstoneStore interface {
+	// AddSandboxTombstone stores a tombstone for a removed pod
+	// sandbox, replacing the existing one for the same sandbox,
+	// if any. Only a limited number of the most recent tombstones
+	// is kept
+	AddSandboxTombstone(tombstone *types.SandboxTombstone) error
+
+	// ListSandboxTombstones returns the tombstones ordered from the
+	// oldest to the newest one by their deletion time
+	ListSandboxTombstones() ([]*types.SandboxTombstone, error)
+
+	// PruneSandboxTombstones removes the tombstones of the pod
+	// sandboxes deleted before the specified time (unix nanoseconds)
+	// and returns the number of the removed tombstones
+	PruneSandboxTombstones(before int64) (int, error)
+}
+
+// SandboxEventStore contains methods to operate on the lifecycle
+// event history of the pod sandboxes
+type SandboxEventStore interface {
+	// AddSandboxEvent stores a new pod sandbox event assigning it a
+	// new ID. Only a limited number of the most recent events is kept
+	// for each pod sandbox, with the total number of the events being
+	// limited, too
+	AddSandboxEvent(event *types.SandboxEvent) error
+
+	// ListSandboxEvents returns the events of the pod sandbox with
+	// given ID ordered from the oldest to the newest one. If podID is
+	// empty, the events of all the pod sandboxes are returned
+	ListSandboxEvents(podID string) ([]*types.SandboxEvent, error)
+}
+
+// FirstBootStore contains methods to operate on the records that
+// track the first boot of the VMs from persistent root volumes
+type FirstBootStore interface {
+	// GetFirstBootRecord returns the first boot record for the persistent
+	// root volume with given id, or nil if there's no such record
+	GetFirstBootRecord(volumeID string) (*types.FirstBootRecord, error)
+	// SaveFirstBootRecord allows to create/modify/delete the first boot record
+	// for the persistent root volume with given id.
+	// Supplied handler gets current FirstBootRecord value (nil if doesn't exist)
+	// and returns new value to be saved or nil to delete. If error value is
+	// returned from the handler, the transaction is rolled back and returned
+	// error becomes the result of the function
+	SaveFirstBootRecord(volumeID string, updater func(*types.FirstBootRecord) (*types.FirstBootRecord, error)) error
+}
+
+// ImagePullStore contains methods to operate on the records that
+// track the image pulls running in background
+type ImagePullStore interface {
+	// GetImagePullJob returns the pull job record for the image with
+	// given name, or nil if there's no such record
+	GetImagePullJob(imageName string) (*types.ImagePullJob, error)
+	// SaveImagePullJob allows to create/modify/delete the pull job record
+	// for the image with given name.
+	// Supplied handler gets current ImagePullJob value (nil if doesn't exist)
+	// and returns new value to be saved or nil to delete. If error value is
+	// returned from the handler, the transaction is rolled back and returned
+	// error becomes the result of the function
+	SaveImagePullJob(imageName string, updater func(*types.ImagePullJob) (*types.ImagePullJob, error)) error
+	// ListImagePullJobs returns all the image pull job records
+	ListImagePullJobs() ([]*types.ImagePullJob, error)
+}
+
+// WatchStore contains methods to watch the changes of pod sandboxes
+// and containers
+type WatchStore interface {
+	// Watch returns a channel that receives the events for the pod
+	// sandboxes and containers that are created, modified or deleted
+	// via this store, along with the function that stops watching and
+	// closes the channel. The events are delivered in the order of the
+	// updates. If the watcher doesn't keep up with the events, its
+	// channel is closed, in which case it should re-list the objects
+	// and start watching again
+	Watch() (<-chan Event, func())
+}
+
+// AuditStore contains methods to set up the audit of the changes
+// made in the store
+type AuditStore interface {
+	// SetAuditLog makes the store record the changes of the pod
+	// sandboxes, containers and image pull job records in the
+	// specified audit log along with the actors that have made
+	// them (see WithAuditActor). nil log disables the audit
+	SetAuditLog(log *AuditLog)
+}
+
 // Store provides single interface for metadata storage implementation
 type Store interface {
 	SandboxStore
 	ContainerStore
+	WatchStore
+	AuditStore
+	TransactionStore
+	StartRecordStore
+	SandboxTombstoneStore
+	SandboxEventStore
+	FirstBootStore
+	ImagePullStore
 	io.Closer
 }
 
@@ -0,0 +1,137 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+var (
+	sandboxTombstonesBucket = []byte("sandboxTombstones")
+
+	// maxSandboxTombstones is the maximum number of the sandbox
+	// tombstones in the store
+	maxSandboxTombstones = 1000
+)
+
+// sortSandboxTombstones sorts the tombstones by their deletion time
+// from the oldest to the newest one.
+func sortSandboxTombstones(tombstones []*types.SandboxTombstone) {
+	sort.SliceStable(tombstones, func(i, j int) bool { return tombstones[i].DeletedAt < tombstones[j].DeletedAt })
+}
+
+func loadSandboxTombstones(bucket *bolt.Bucket) ([]*types.SandboxTombstone, error) {
+	var tombstones []*types.SandboxTombstone
+	if err := bucket.ForEach(func(k, v []byte) error {
+		var tombstone *types.SandboxTombstone
+		if err := json.Unmarshal(v, &tombstone); err != nil {
+			return fmt.Errorf("error unmarshalling sandbox tombstone %q: %v", k, err)
+		}
+		tombstones = append(tombstones, tombstone)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sortSandboxTombstones(tombstones)
+	return tombstones, nil
+}
+
+// AddSandboxTombstone implements AddSandboxTombstone method of SandboxTombstoneStore interface
+func (b *boltClient) AddSandboxTombstone(tombstone *types.SandboxTombstone) error {
+	if tombstone.PodSandboxID == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	data, err := json.Marshal(tombstone)
+	if err != nil {
+		return err
+	}
+	return b.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(sandboxTombstonesBucket)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put([]byte(tombstone.PodSandboxID), data); err != nil {
+			return err
+		}
+		tombstones, err := loadSandboxTombstones(bucket)
+		if err != nil {
+			return err
+		}
+		if len(tombstones) <= maxSandboxTombstones {
+			return nil
+		}
+		for _, t := range tombstones[:len(tombstones)-maxSandboxTombstones] {
+			if err := bucket.Delete([]byte(t.PodSandboxID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListSandboxTombstones implements ListSandboxTombstones method of SandboxTombstoneStore interface
+func (b *boltClient) ListSandboxTombstones() ([]*types.SandboxTombstone, error) {
+	var tombstones []*types.SandboxTombstone
+	if err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sandboxTombstonesBucket)
+		if bucket == nil {
+			return nil
+		}
+		var err error
+		tombstones, err = loadSandboxTombstones(bucket)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return tombstones, nil
+}
+
+// PruneSandboxTombstones implements PruneSandboxTombstones method of SandboxTombstoneStore interface
+func (b *boltClient) PruneSandboxTombstones(before int64) (int, error) {
+	n := 0
+	if err := b.update(func(tx *bolt.Tx) error {
+		n = 0
+		bucket := tx.Bucket(sandboxTombstonesBucket)
+		if bucket == nil {
+			return nil
+		}
+		tombstones, err := loadSandboxTombstones(bucket)
+		if err != nil {
+			return err
+		}
+		for _, t := range tombstones {
+			if t.DeletedAt >= before {
+				break
+			}
+			if err := bucket.Delete([]byte(t.PodSandboxID)); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
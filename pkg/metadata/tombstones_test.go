@@ -0,0 +1,90 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func sandboxTombstoneIDs(t *testing.T, store Store) []string {
+	tombstones, err := store.ListSandboxTombstones()
+	if err != nil {
+		t.Fatalf("ListSandboxTombstones(): %v", err)
+	}
+	var r []string
+	for _, tombstone := range tombstones {
+		r = append(r, fmt.Sprintf("%s:%d:%s", tombstone.PodSandboxID, tombstone.DeletedAt, tombstone.Reason))
+	}
+	return r
+}
+
+func testSandboxTombstones(t *testing.T, store Store) {
+	oldMax := maxSandboxTombstones
+	maxSandboxTombstones = 3
+	defer func() {
+		maxSandboxTombstones = oldMax
+	}()
+
+	if ids := sandboxTombstoneIDs(t, store); len(ids) != 0 {
+		t.Errorf("ListSandboxTombstones() returned non-empty result for an empty db: %#v", ids)
+	}
+	if err := store.AddSandboxTombstone(&types.SandboxTombstone{}); err == nil {
+		t.Errorf("AddSandboxTombstone() didn't fail for a tombstone without pod sandbox id")
+	}
+
+	for _, tombstone := range []*types.SandboxTombstone{
+		{PodSandboxID: "pod1", DeletedAt: 100, Reason: types.SandboxRemovedByKubelet},
+		{PodSandboxID: "pod2", DeletedAt: 400, Reason: types.SandboxRemovedAsOrphan},
+		{PodSandboxID: "pod3", DeletedAt: 300, Reason: types.SandboxRemovedByKubelet},
+		// replaces the first tombstone for pod1
+		{PodSandboxID: "pod1", DeletedAt: 200, Reason: types.SandboxRemovedAsOrphan},
+		// pushes out the oldest tombstone
+		{PodSandboxID: "pod4", DeletedAt: 500, Reason: types.SandboxRemovedByKubelet},
+	} {
+		if err := store.AddSandboxTombstone(tombstone); err != nil {
+			t.Fatalf("AddSandboxTombstone(): %v", err)
+		}
+	}
+	expectedIDs := []string{
+		"pod3:300:RemovedByKubelet",
+		"pod2:400:RemovedAsOrphan",
+		"pod4:500:RemovedByKubelet",
+	}
+	if ids := sandboxTombstoneIDs(t, store); !reflect.DeepEqual(ids, expectedIDs) {
+		t.Errorf("bad sandbox tombstones: %#v instead of %#v", ids, expectedIDs)
+	}
+
+	n, err := store.PruneSandboxTombstones(401)
+	if err != nil {
+		t.Fatalf("PruneSandboxTombstones(): %v", err)
+	}
+	if n != 2 {
+		t.Errorf("bad number of pruned tombstones: %d instead of 2", n)
+	}
+	expectedIDs = []string{"pod4:500:RemovedByKubelet"}
+	if ids := sandboxTombstoneIDs(t, store); !reflect.DeepEqual(ids, expectedIDs) {
+		t.Errorf("bad sandbox tombstones after pruning: %#v instead of %#v", ids, expectedIDs)
+	}
+}
+
+func TestSandboxTombstones(t *testing.T) {
+	testSandboxTombstones(t, setUpTestStore(t, nil, nil, nil))
+}
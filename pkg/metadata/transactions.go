@@ -0,0 +1,89 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"errors"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+type boltTx struct {
+	client *boltClient
+	tx     *bolt.Tx
+	events []*Event
+}
+
+var _ Tx = &boltTx{}
+
+// SavePodSandbox implements SavePodSandbox method of Tx interface
+func (t *boltTx) SavePodSandbox(podID string, updater func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error)) error {
+	if podID == "" {
+		return errors.New("Pod sandbox ID cannot be empty")
+	}
+	events, err := savePodSandbox(t.tx, t.client.cipher, t.client.removalPolicy, podID, updater)
+	if err != nil {
+		return err
+	}
+	t.events = append(t.events, events...)
+	return nil
+}
+
+// SaveContainer implements SaveContainer method of Tx interface
+func (t *boltTx) SaveContainer(containerID string, updater func(*types.ContainerInfo) (*types.ContainerInfo, error)) error {
+	if containerID == "" {
+		return errors.New("Container ID cannot be empty")
+	}
+	event, err := saveContainer(t.tx, t.client.cipher, containerID, updater)
+	if err != nil {
+		return err
+	}
+	t.events = append(t.events, eventList(event)...)
+	return nil
+}
+
+// PodContainerIDs implements PodContainerIDs method of Tx interface
+func (t *boltTx) PodContainerIDs(podID string) ([]string, error) {
+	if podID == "" {
+		return nil, errors.New("Pod sandbox ID cannot be empty")
+	}
+	bucket, err := getSandboxBucket(t.tx, podID, false, true)
+	if err != nil || bucket == nil {
+		return nil, err
+	}
+	return sandboxContainerIDs(bucket), nil
+}
+
+// Update implements Update method of TransactionStore interface.
+// The changes are committed by the write batcher, possibly together
+// with other updates.
+func (b *boltClient) Update(fn func(tx Tx) error) error {
+	return b.updateAs(nil, fn)
+}
+
+// updateAs implements updateAs method of auditedBackend interface
+func (b *boltClient) updateAs(origin *auditOrigin, fn func(tx Tx) error) error {
+	return b.batch.update(origin, func(tx *bolt.Tx) ([]*Event, error) {
+		t := &boltTx{client: b, tx: tx}
+		if err := fn(t); err != nil {
+			return nil, err
+		}
+		return t.events, nil
+	})
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func deleteSandboxWithContainers(podID string) func(tx Tx) error {
+	return func(tx Tx) error {
+		ids, err := tx.PodContainerIDs(podID)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := tx.SaveContainer(id, func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+				return nil, nil
+			}); err != nil {
+				return err
+			}
+		}
+		return tx.SavePodSandbox(podID, func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+			return nil, nil
+		})
+	}
+}
+
+func testUpdate(t *testing.T, store Store) {
+	saveTestSandbox(t, store, "pod1", "pod1-name")
+	saveWatchedContainer(t, store, "container1", "pod1", "vm1")
+	saveWatchedContainer(t, store, "container2", "pod1", "vm2")
+
+	ch, stop := store.Watch()
+	defer stop()
+
+	// a failed transaction doesn't change anything
+	if err := store.Update(func(tx Tx) error {
+		if err := deleteSandboxWithContainers("pod1")(tx); err != nil {
+			return err
+		}
+		return errors.New("oops")
+	}); err == nil || err.Error() != "oops" {
+		t.Errorf("Update() didn't return the expected error: %v", err)
+	}
+	if events, _ := receiveEvents(ch); len(events) != 0 {
+		t.Errorf("unexpected events after a failed transaction: %#v", events)
+	}
+	containers, err := store.ListPodContainers("pod1")
+	if err != nil {
+		t.Fatalf("ListPodContainers(): %v", err)
+	}
+	if len(containers) != 2 {
+		t.Errorf("bad number of containers after a failed transaction: %d instead of 2", len(containers))
+	}
+
+	var ids []string
+	if err := store.Update(func(tx Tx) error {
+		var err error
+		ids, err = tx.PodContainerIDs("pod1")
+		if err != nil {
+			return err
+		}
+		return deleteSandboxWithContainers("pod1")(tx)
+	}); err != nil {
+		t.Fatalf("Update(): %v", err)
+	}
+	if expectedIDs := []string{"container1", "container2"}; !reflect.DeepEqual(ids, expectedIDs) {
+		t.Errorf("bad pod container ids: %#v instead of %#v", ids, expectedIDs)
+	}
+	events, _ := receiveEvents(ch)
+	expectedEvents := []string{
+		"deleted container container1 container1 vm1",
+		"deleted container container2 container2 vm2",
+		"deleted sandbox pod1 pod1 pod1-name",
+	}
+	if !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("bad events: %#v instead of %#v", events, expectedEvents)
+	}
+	for _, id := range ids {
+		ci, err := store.Container(id).Retrieve()
+		if err != nil {
+			t.Fatalf("Container(%q).Retrieve(): %v", id, err)
+		}
+		if ci != nil {
+			t.Errorf("container %q was not removed", id)
+		}
+	}
+	sandboxes, err := store.ListPodSandboxes(nil)
+	if err != nil {
+		t.Fatalf("ListPodSandboxes(): %v", err)
+	}
+	if len(sandboxes) != 0 {
+		t.Errorf("the pod sandbox was not removed")
+	}
+
+	if err := store.Update(func(tx Tx) error {
+		ids, err = tx.PodContainerIDs("pod1")
+		return err
+	}); err != nil {
+		t.Fatalf("Update(): %v", err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("unexpected container ids for a nonexistent pod sandbox: %#v", ids)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	testUpdate(t, setUpTestStore(t, nil, nil, nil))
+}
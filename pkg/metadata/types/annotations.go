@@ -19,6 +19,11 @@ package types
 import (
 	"encoding/base64"
 	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -27,13 +32,16 @@ import (
 	uuid "github.com/nu7hatch/gouuid"
 	"k8s.io/apimachinery/pkg/api/resource"
 
+	"github.com/Mirantis/virtlet/pkg/network"
 	"github.com/Mirantis/virtlet/pkg/utils"
 )
 
 const (
 	maxVCPUCount                      = 255
-	vcpuCountAnnotationKeyName        = "VirtletVCPUCount"
+	maxDiskQueues                     = 64
+	maxIOThreads                      = 64
 	diskDriverKeyName                 = "VirtletDiskDriver"
+	diskBusKeyName                    = "VirtletDiskBus"
 	cloudInitMetaDataKeyName          = "VirtletCloudInitMetaData"
 	cloudInitUserDataOverwriteKeyName = "VirtletCloudInitUserDataOverwrite"
 	cloudInitUserDataKeyName          = "VirtletCloudInitUserData"
@@ -44,12 +52,56 @@ const (
 	libvirtCPUSetting                 = "VirtletLibvirtCPUSetting"
 	sshKeysKeyName                    = "VirtletSSHKeys"
 	chown9pfsMountsKeyName            = "VirtletChown9pfsMounts"
-	systemUUIDKeyName                 = "VirtletSystemUUID"
 	forceDHCPNetworkConfigKeyName     = "VirtletForceDHCPNetworkConfig"
+	terminationGracePeriodKeyName     = "VirtletTerminationGracePeriodSeconds"
+	onCrashKeyName                    = "VirtletOnCrash"
+	watchdogActionKeyName             = "VirtletWatchdogAction"
+	restartBackoffKeyName             = "VirtletRestartBackoffSeconds"
+	softRebootKeyName                 = "VirtletSoftReboot"
+	bootOrderKeyName                  = "VirtletBootOrder"
+	pxeNextServerKeyName              = "VirtletPXENextServer"
+	pxeBootFilenameKeyName            = "VirtletPXEBootFilename"
+	ipxeScriptURLKeyName              = "VirtletIPXEScriptURL"
+	cdromImagesKeyName                = "VirtletCDROMImages"
+	guestAgentKeyName                 = "VirtletGuestAgent"
+	rootFSGrowModeKeyName             = "VirtletRootFSGrowMode"
+	postStartHookKeyName              = "VirtletPostStartHook"
+	preStopHookKeyName                = "VirtletPreStopHook"
+	postResumeHookKeyName             = "VirtletPostResumeHook"
+	guestHookTimeoutKeyName           = "VirtletGuestHookTimeoutSeconds"
+	bootTimeoutKeyName                = "VirtletBootTimeoutSeconds"
+	serialChannelsKeyName             = "VirtletSerialChannels"
+	confirmVolumeDeletionKeyName      = "VirtletConfirmVolumeDeletion"
+	guestLogFileKeyName               = "VirtletGuestLogFile"
+	swapTypeKeyName                   = "VirtletSwapType"
+	swapSizeKeyName                   = "VirtletSwapSize"
+	swapPriorityKeyName               = "VirtletSwapPriority"
+	hostDevicesKeyName                = "VirtletHostDevices"
+	mdevProfilesKeyName               = "VirtletMdevProfiles"
+	rootVolumeSourceKeyName           = "VirtletRootVolumeSource"
+	guestAddressDetectionKeyName      = "VirtletGuestAddressDetection"
+	guestEnvironmentKeyName           = "VirtletGuestEnvironment"
+	flavorKeyName                     = "VirtletFlavor"
+	maxVCPUCountKeyName               = "VirtletMaxVCPUCount"
+	vcpuAutoscaleKeyName              = "VirtletVCPUAutoscale"
+	diskQueuesKeyName                 = "VirtletDiskQueues"
+	ioThreadsKeyName                  = "VirtletIOThreads"
+	memoryBackingKeyName              = "VirtletMemoryBacking"
+	hugePageSizeKeyName               = "VirtletHugePageSize"
+	sharedMemoryKeyName               = "VirtletSharedMemory"
+	readOnlyRootfsKeyName             = "VirtletReadOnlyRootfs"
+	legacyVirtioKeyName               = "VirtletLegacyVirtio"
+	disabledVirtioFeaturesKeyName     = "VirtletDisabledVirtioFeatures"
+	nicModelKeyName                   = "VirtletNICModel"
+	maintenanceWindowKeyName          = "VirtletMaintenanceWindow"
+	maintenanceRebootConditionKeyName = "VirtletMaintenanceRebootCondition"
 	// CloudInitUserDataSourceKeyName is the name of user data source key in the pod annotations.
 	CloudInitUserDataSourceKeyName = "VirtletCloudInitUserDataSource"
 	// SSHKeySourceKeyName is the name of ssh key source key in the pod annotations.
 	SSHKeySourceKeyName = "VirtletSSHKeySource"
+	// TuningProfileKeyName is the name of the tuning profile key in
+	// the pod annotations and the RuntimeClass annotations.
+	TuningProfileKeyName = "VirtletTuningProfile"
 
 	cloudInitUserDataSourceKeyKeyName      = "VirtletCloudInitUserDataSourceKey"
 	cloudInitUserDataSourceEncodingKeyName = "VirtletCloudInitUserDataSourceEncoding"
@@ -57,6 +109,15 @@ const (
 	// FilesFromDSKeyName is the name of data source key in the pod annotations
 	// for the files to be injected into the rootfs.
 	FilesFromDSKeyName = "VirtletFilesFromDataSource"
+
+	// VCPUCountKeyName is the name of vCPU count key in the pod annotations.
+	VCPUCountKeyName = "VirtletVCPUCount"
+	// SystemUUIDKeyName is the name of SMBIOS system UUID key in the pod annotations.
+	SystemUUIDKeyName = "VirtletSystemUUID"
+	// AdoptDomainKeyName is the name of the key in the pod annotations
+	// that specifies a pre-existing libvirt domain to be taken over
+	// by the VM.
+	AdoptDomainKeyName = "VirtletAdoptDomain"
 )
 
 // CloudInitImageType specifies the image type used for cloud-init
@@ -82,6 +143,199 @@ const (
 	DiskDriverVirtio DiskDriverName = "virtio"
 	// DiskDriverScsi specifies scsi disk driver.
 	DiskDriverScsi DiskDriverName = "scsi"
+	// DiskDriverIde specifies emulated IDE disk driver which
+	// is intended for the guests that lack virtio drivers.
+	DiskDriverIde DiskDriverName = "ide"
+	// DiskDriverSata specifies emulated SATA disks attached to
+	// an AHCI controller. It's intended for the appliance images
+	// that can only boot from SATA disks.
+	DiskDriverSata DiskDriverName = "sata"
+	// DiskDriverUSB specifies emulated USB mass storage devices.
+	DiskDriverUSB DiskDriverName = "usb"
+)
+
+var validDiskDrivers = []string{
+	string(DiskDriverVirtio),
+	string(DiskDriverScsi),
+	string(DiskDriverIde),
+	string(DiskDriverSata),
+	string(DiskDriverUSB),
+}
+
+// NICModel specifies the model of the network interfaces of the VM.
+type NICModel string
+
+const (
+	// NICModelVirtio specifies virtio network interfaces.
+	NICModelVirtio NICModel = "virtio"
+	// NICModelE1000 specifies emulated Intel e1000 network
+	// interfaces which are intended for the guests that lack
+	// virtio drivers.
+	NICModelE1000 NICModel = "e1000"
+)
+
+// VirtioFeature specifies a modern virtio feature that can be
+// disabled for the virtio devices of the VM.
+type VirtioFeature string
+
+const (
+	// VirtioFeaturePacked denotes packed virtqueues.
+	VirtioFeaturePacked VirtioFeature = "packed"
+	// VirtioFeatureIOMMU denotes the use of the platform IOMMU
+	// by the virtio devices.
+	VirtioFeatureIOMMU VirtioFeature = "iommu"
+)
+
+// TuningProfile specifies a set of domain settings that tune
+// the VM for a specific kind of workload.
+type TuningProfile string
+
+const (
+	// TuningProfileLatency tunes the VM for low latency by pinning
+	// vCPUs to the host CPUs, using host CPU passthrough and disabling
+	// memory ballooning.
+	TuningProfileLatency TuningProfile = "latency"
+	// TuningProfileThroughput tunes the VM for high IO throughput
+	// by using multiqueue disk controllers and a dedicated iothread.
+	TuningProfileThroughput TuningProfile = "throughput"
+)
+
+// MemoryBackingSource specifies the source of the VM memory.
+type MemoryBackingSource string
+
+const (
+	// MemoryBackingDefault denotes anonymous memory allocated by qemu.
+	MemoryBackingDefault MemoryBackingSource = "default"
+	// MemoryBackingMemfd denotes memory allocated using memfd_create().
+	MemoryBackingMemfd MemoryBackingSource = "memfd"
+	// MemoryBackingHugepages denotes huge pages taken from a hugetlbfs mount.
+	MemoryBackingHugepages MemoryBackingSource = "hugepages"
+	// MemoryBackingFile denotes memory backed by files in libvirt's
+	// memory backing directory.
+	MemoryBackingFile MemoryBackingSource = "file"
+)
+
+// GuestEnvironmentTarget specifies a place inside the VM where the
+// container environment variables are made available besides
+// /etc/cloud/environment.
+type GuestEnvironmentTarget string
+
+const (
+	// GuestEnvironmentEtcEnvironment makes Virtlet add the
+	// environment variables to /etc/environment inside the VM,
+	// which makes them available to the login sessions.
+	GuestEnvironmentEtcEnvironment GuestEnvironmentTarget = "etc-environment"
+	// GuestEnvironmentSystemd makes Virtlet pass the environment
+	// variables to the systemd services and user sessions.
+	GuestEnvironmentSystemd GuestEnvironmentTarget = "systemd"
+)
+
+// RootFSGrowMode specifies how the root filesystem is grown
+// when the root volume is enlarged using VirtletRootVolumeSize.
+type RootFSGrowMode string
+
+const (
+	// RootFSGrowCloudInit makes Virtlet add growpart and
+	// resize_rootfs directives to cloud-init user data. This
+	// is the default.
+	RootFSGrowCloudInit RootFSGrowMode = "cloud-init"
+	// RootFSGrowOffline makes Virtlet grow the last partition of
+	// the root volume and its filesystem using libguestfs before
+	// the VM is started. It's intended for the images that don't
+	// use cloud-init.
+	RootFSGrowOffline RootFSGrowMode = "offline"
+	// RootFSGrowNone disables growing the root filesystem.
+	RootFSGrowNone RootFSGrowMode = "none"
+)
+
+// SwapType specifies how the swap space is provided for the VM.
+type SwapType string
+
+const (
+	// SwapDisk makes Virtlet attach a dedicated ephemeral disk to
+	// the VM that's initialized as swap space by cloud-init.
+	SwapDisk SwapType = "disk"
+	// SwapZram makes cloud-init set up a compressed swap device
+	// in the guest RAM using the zram kernel module.
+	SwapZram SwapType = "zram"
+)
+
+const (
+	// maxSwapPriority is the max swap priority accepted by swapon
+	maxSwapPriority = 32767
+)
+
+// SwapSettings describes the swap space of the VM.
+type SwapSettings struct {
+	// Type specifies how the swap space is provided.
+	Type SwapType
+	// Size specifies the size of the swap space in bytes.
+	Size int64
+	// Priority specifies the priority of the swap space
+	// inside the guest. nil means the default priority.
+	Priority *int
+}
+
+// NetworkVolumeProtocol specifies the network storage protocol
+// used to access a network root volume.
+type NetworkVolumeProtocol string
+
+const (
+	// NetworkVolumeRBD denotes a Ceph RBD image. The root volume
+	// is created by cloning an RBD snapshot on the Ceph side.
+	NetworkVolumeRBD NetworkVolumeProtocol = "rbd"
+	// NetworkVolumeISCSI denotes an iSCSI LUN that's used as the
+	// root volume as is.
+	NetworkVolumeISCSI NetworkVolumeProtocol = "iscsi"
+	// NetworkVolumeNBD denotes an NBD export that's used as the
+	// root volume as is.
+	NetworkVolumeNBD NetworkVolumeProtocol = "nbd"
+)
+
+// NetworkRootVolume describes a root volume that resides on the
+// network storage and is accessed by QEMU directly without making
+// a local copy of the image.
+type NetworkRootVolume struct {
+	// Protocol specifies the network storage protocol.
+	Protocol NetworkVolumeProtocol
+	// User is the Ceph user name used to access the RBD images.
+	User string
+	// Hosts lists the storage servers (Ceph monitors, iSCSI
+	// portals or NBD servers) as host or host:port.
+	Hosts []string
+	// Name is the name of the volume, i.e. pool/image for RBD,
+	// target IQN/LUN for iSCSI or the export name for NBD.
+	Name string
+	// Snapshot is the RBD snapshot to clone the root volume from.
+	Snapshot string
+}
+
+// MdevDevicePathPrefix is the path prefix of the mediated devices
+// (mdev) in sysfs. The host devices with paths starting with this
+// prefix are passed to the VM as mediated devices.
+const MdevDevicePathPrefix = "/sys/bus/mdev/devices/"
+
+// GuestAgentChannelName is the name of the virtio-serial channel
+// used by QEMU guest agent.
+const GuestAgentChannelName = "org.qemu.guest_agent.0"
+
+const (
+	// BootDeviceRoot denotes the root volume in the boot order.
+	BootDeviceRoot = "root"
+	// BootDeviceNetwork denotes the network (PXE) boot in the boot order.
+	BootDeviceNetwork = "network"
+)
+
+var (
+	validOnCrashActions  = []string{"destroy", "restart", "preserve", "coredump-destroy", "coredump-restart"}
+	validWatchdogActions = []string{"reset", "shutdown", "poweroff", "pause", "none", "dump", "inject-nmi"}
+	// serial channel names are used as socket file names, so they're
+	// restricted to be short enough for the socket paths to fit into
+	// sun_path
+	serialChannelNameRx = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,39}$`)
+	mdevUUIDRx          = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	// mdev profile names are used as sysfs directory names
+	mdevProfileRx = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
 )
 
 // VirtletAnnotations contains parsed values for pod annotations supported
@@ -105,6 +359,11 @@ type VirtletAnnotations struct {
 	SSHKeys []string
 	// DiskDriver specifies the disk driver to use.
 	DiskDriver DiskDriverName
+	// DiskBuses maps the names of the volumes to the disk drivers
+	// to use for them instead of DiskDriver. The names are the
+	// same as in BootOrder, with "cloud-init", "swap" and
+	// "cdromN" also being accepted.
+	DiskBuses map[string]DiskDriverName
 	// CPUSetting directly specifies the cpu to use for libvirt.
 	CPUSetting *libvirtxml.DomainCPU
 	// Root volume size in bytes. Defaults to 0 which means using
@@ -124,6 +383,170 @@ type VirtletAnnotations struct {
 	// configuration and makes it only provide DHCP. Note that this will
 	// not work for multi-CNI configuration.
 	ForceDHCPNetworkConfig bool
+	// TerminationGracePeriodSeconds specifies the time given to
+	// the guest to shut down gracefully upon the CRI StopContainer
+	// request that doesn't specify a timeout. 0 means destroying
+	// the domain right away in this case.
+	TerminationGracePeriodSeconds int64
+	// OnCrash specifies the libvirt on_crash action for the domain.
+	// Empty value means using the Virtlet default.
+	OnCrash string
+	// WatchdogAction specifies the action to take when the guest
+	// watchdog fires. If it's empty, no watchdog device is added.
+	WatchdogAction string
+	// RestartBackoffSeconds specifies the minimum time that must
+	// pass after the VM has been started before it can be restarted.
+	RestartBackoffSeconds int64
+	// TuningProfile specifies the performance tuning profile to use.
+	TuningProfile TuningProfile
+	// SoftReboot makes Virtlet keep the domain volumes when the
+	// container is stopped so that the VM is restarted in place,
+	// preserving the contents of its ephemeral disks.
+	SoftReboot bool
+	// BootOrder lists the devices to boot from in the order of
+	// their priority. The items are either BootDeviceRoot,
+	// BootDeviceNetwork or the names of the pod volumes used as
+	// disks. Empty list means booting from the root volume.
+	BootOrder []string
+	// NetBoot specifies the settings passed to the VM by the
+	// DHCP server for network boot. nil value means that the
+	// network boot isn't configured.
+	NetBoot *network.BootOptions
+	// CDROMImages lists the images to be attached to the VM
+	// as CD-ROM devices.
+	CDROMImages []string
+	// GuestAgent enables the channel used to communicate with
+	// QEMU guest agent running inside the VM.
+	GuestAgent bool
+	// RootFSGrowMode specifies how the root filesystem is grown
+	// when the root volume is enlarged. Empty value means
+	// RootFSGrowCloudInit.
+	RootFSGrowMode RootFSGrowMode
+	// PostStartHook specifies a shell command that's executed
+	// inside the VM via the guest agent after the VM is started.
+	PostStartHook string
+	// PreStopHook specifies a shell command that's executed
+	// inside the VM via the guest agent before the VM is stopped.
+	PreStopHook string
+	// PostResumeHook specifies a shell command that's executed
+	// inside the VM via the guest agent after the VM is resumed
+	// following a pause or a suspend.
+	PostResumeHook string
+	// GuestHookTimeoutSeconds specifies the time given to each of
+	// the guest hooks to complete, including the time needed for
+	// the guest agent to become available. Zero value means using
+	// the default timeout.
+	GuestHookTimeoutSeconds int64
+	// BootTimeoutSeconds specifies the time given to the VM to
+	// boot, i.e. for its guest agent to become available. If the
+	// VM doesn't boot within this time, the boot diagnostics are
+	// captured. Zero value disables the boot check.
+	BootTimeoutSeconds int64
+	// SerialChannels lists the names of virtio-serial channels
+	// to add to the VM. Each channel is exposed on the node as a
+	// unix socket.
+	SerialChannels []string
+	// ConfirmVolumeDeletion allows Virtlet to remove the VM
+	// together with its persistent volumes without asking for
+	// a confirmation using 'virtletctl confirm-delete'.
+	ConfirmVolumeDeletion bool
+	// GuestLogFile specifies the path of a log file inside the VM
+	// that's streamed via the guest agent into the container log
+	// as its stderr stream.
+	GuestLogFile string
+	// Swap specifies the swap space of the VM, if there should be
+	// any.
+	Swap *SwapSettings
+	// HostDevices lists the paths of the host character and block
+	// devices and the mediated devices to be passed to the VM.
+	// The devices must be allowed by the host device policy of
+	// the node.
+	HostDevices []string
+	// MdevProfiles lists the types of the mediated devices, such
+	// as NVIDIA vGPU profiles, to be created for the VM. A separate
+	// mediated device is created for each item of the list.
+	MdevProfiles []string
+	// RootVolumeSource specifies the network storage volume
+	// to be used as the root volume of the VM instead of a
+	// local copy of the image. nil means using the image.
+	RootVolumeSource *NetworkRootVolume
+	// AdoptDomain specifies the name of a pre-existing libvirt
+	// domain that is taken over by the VM. The disks of the
+	// domain are used instead of the root volume.
+	AdoptDomain string
+	// GuestEnvironment lists the places inside the VM where the
+	// container environment variables are written to via
+	// cloud-init, besides /etc/cloud/environment.
+	GuestEnvironment []GuestEnvironmentTarget
+	// Flavor is the name of the VirtletFlavor used for the VM.
+	Flavor string
+	// Memory specifies the amount of memory of the VM in bytes
+	// that's used if the container doesn't have a memory limit.
+	// 0 means using the Virtlet default.
+	Memory int64
+	// MaxVCPUCount specifies the maximum number of vCPUs that can
+	// be hot-added to the VM. 0 means the same as VCPUCount.
+	MaxVCPUCount int
+	// VCPUAutoscale enables adding vCPUs to the running VM when
+	// its CPU usage is high and removing them when it's idle.
+	VCPUAutoscale bool
+	// DiskQueues specifies the number of queues of the virtio
+	// disks and the virtio-scsi controller. 0 means using one
+	// queue per vCPU.
+	DiskQueues int
+	// IOThreads specifies the number of the iothreads that handle
+	// the IO of the virtio disks and the virtio-scsi controller.
+	// 0 means choosing it based on the number of the disks and
+	// the vCPUs.
+	IOThreads int
+	// MemoryBacking specifies the source of the VM memory.
+	// Empty value means using the node default.
+	MemoryBacking MemoryBackingSource
+	// HugePageSize specifies the size of the huge pages in bytes
+	// to use for the VM memory. 0 means the smallest huge page
+	// size available on the node.
+	HugePageSize int64
+	// SharedMemory specifies whether the VM memory should be
+	// shared with other processes, as required by vhost-user
+	// devices and virtio-fs.
+	SharedMemory bool
+	// ReadOnlyRootfs makes Virtlet attach the root volume in
+	// read-only mode and configure the guest to keep all the
+	// changes to the root filesystem in a tmpfs overlay, so
+	// that each boot starts from the original image contents.
+	ReadOnlyRootfs bool
+	// LegacyVirtio makes Virtlet use legacy (virtio 0.9
+	// compatible) virtio devices for the VM, which is needed
+	// for some very old guests.
+	LegacyVirtio bool
+	// DisabledVirtioFeatures lists the modern virtio features
+	// that are disabled for the virtio devices of the VM.
+	DisabledVirtioFeatures []VirtioFeature
+	// NICModel specifies the model of the network interfaces
+	// of the VM. Empty value means virtio.
+	NICModel NICModel
+	// MaintenanceWindow specifies the recurring time period
+	// during which Virtlet may reboot the VM, e.g. to apply
+	// the guest updates. nil means no maintenance reboots.
+	MaintenanceWindow *MaintenanceWindow
+	// MaintenanceRebootCondition specifies when the VM is
+	// rebooted during its maintenance window. Empty value
+	// means MaintenanceRebootAlways.
+	MaintenanceRebootCondition MaintenanceRebootCondition
+	// MaxConcurrentMaintenanceReboots specifies the maximum
+	// number of VMs from the pod's namespace that may be
+	// rebooted for maintenance at the same time on the node.
+	// It can only be set using VirtletVMPolicy. 0 means no
+	// per-namespace limit.
+	MaxConcurrentMaintenanceReboots int
+}
+
+// MaxVCPUs returns the maximum number of vCPUs the VM can have.
+func (va *VirtletAnnotations) MaxVCPUs() int {
+	if va.MaxVCPUCount > va.VCPUCount {
+		return va.MaxVCPUCount
+	}
+	return va.VCPUCount
 }
 
 // ExternalDataLoader is used to load extra pod data from
@@ -134,6 +557,31 @@ type ExternalDataLoader interface {
 	LoadCloudInitData(va *VirtletAnnotations, namespace string, podAnnotations map[string]string) error
 	// LoadFileMap loads a set of files from the data sources.
 	LoadFileMap(namespace, dsSpec string) (map[string][]byte, error)
+	// LoadVMPolicy applies the VirtletVMPolicy resources from
+	// the specified namespace to the annotations.
+	LoadVMPolicy(va *VirtletAnnotations, namespace string) error
+	// LoadVMQuota returns the per-node quota for the VM pods
+	// from the specified namespace as set by VirtletVMPolicy
+	// resources, or nil if there's no quota.
+	LoadVMQuota(namespace string) (*VMQuota, error)
+	// LoadFlavor applies the VirtletFlavor with the specified
+	// name from the specified namespace to the annotations.
+	LoadFlavor(va *VirtletAnnotations, namespace, name string) error
+	// LoadRuntimeClass applies the settings from the RuntimeClass
+	// of the specified pod to the annotations.
+	LoadRuntimeClass(va *VirtletAnnotations, namespace, podName string) error
+}
+
+// VMQuota specifies the limits on the resources that can be used
+// by the VM pods from a namespace on a single node. Negative values
+// mean no limit.
+type VMQuota struct {
+	// MaxVMs is the maximum number of VMs.
+	MaxVMs int
+	// MaxVCPUs is the maximum total number of vCPUs.
+	MaxVCPUs int
+	// MaxMemory is the maximum total amount of VM memory in bytes.
+	MaxMemory int64
 }
 
 var externalDataLoader ExternalDataLoader
@@ -160,6 +608,10 @@ func (va *VirtletAnnotations) applyDefaults() {
 	if va.CDImageType == "" {
 		va.CDImageType = CloudInitImageTypeNoCloud
 	}
+
+	if va.NetBoot != nil && len(va.BootOrder) == 0 {
+		va.BootOrder = []string{BootDeviceNetwork, BootDeviceRoot}
+	}
 }
 
 func (va *VirtletAnnotations) validate() error {
@@ -168,8 +620,52 @@ func (va *VirtletAnnotations) validate() error {
 		errs = append(errs, fmt.Sprintf("vcpu count %d too big, max is %d", va.VCPUCount, maxVCPUCount))
 	}
 
-	if va.DiskDriver != DiskDriverVirtio && va.DiskDriver != DiskDriverScsi {
-		errs = append(errs, fmt.Sprintf("bad disk driver %q. Must be either %q or %q", va.DiskDriver, DiskDriverVirtio, DiskDriverScsi))
+	if !stringInList(string(va.DiskDriver), validDiskDrivers) {
+		errs = append(errs, fmt.Sprintf("bad disk driver %q. Must be one of %s", va.DiskDriver, strings.Join(validDiskDrivers, ", ")))
+	}
+
+	var diskBusVolumes []string
+	for name := range va.DiskBuses {
+		diskBusVolumes = append(diskBusVolumes, name)
+	}
+	sort.Strings(diskBusVolumes)
+	for _, name := range diskBusVolumes {
+		driver := va.DiskBuses[name]
+		switch {
+		case name == "":
+			errs = append(errs, "empty volume name in the disk bus list")
+		case !stringInList(string(driver), validDiskDrivers):
+			errs = append(errs, fmt.Sprintf("bad disk bus %q for volume %q. Must be one of %s", driver, name, strings.Join(validDiskDrivers, ", ")))
+		}
+	}
+
+	if va.NICModel != "" && va.NICModel != NICModelVirtio && va.NICModel != NICModelE1000 {
+		errs = append(errs, fmt.Sprintf("bad NIC model %q. Must be empty, %q or %q", va.NICModel, NICModelVirtio, NICModelE1000))
+	}
+
+	switch va.MaintenanceRebootCondition {
+	case "", MaintenanceRebootAlways:
+	case MaintenanceRebootRequired:
+		if va.MaintenanceWindow != nil && !va.GuestAgent {
+			errs = append(errs, fmt.Sprintf("maintenance reboot condition %q requires the guest agent to be enabled", va.MaintenanceRebootCondition))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("bad maintenance reboot condition %q. Must be empty, %q or %q", va.MaintenanceRebootCondition, MaintenanceRebootAlways, MaintenanceRebootRequired))
+	}
+
+	if va.MaxConcurrentMaintenanceReboots < 0 {
+		errs = append(errs, fmt.Sprintf("bad max concurrent maintenance reboots %d", va.MaxConcurrentMaintenanceReboots))
+	}
+
+	seenVirtioFeatures := make(map[VirtioFeature]bool)
+	for _, feature := range va.DisabledVirtioFeatures {
+		switch {
+		case feature != VirtioFeaturePacked && feature != VirtioFeatureIOMMU:
+			errs = append(errs, fmt.Sprintf("bad virtio feature %q. Must be either %q or %q", feature, VirtioFeaturePacked, VirtioFeatureIOMMU))
+		case seenVirtioFeatures[feature]:
+			errs = append(errs, fmt.Sprintf("duplicate virtio feature %q", feature))
+		}
+		seenVirtioFeatures[feature] = true
 	}
 
 	if va.CDImageType != CloudInitImageTypeNoCloud && va.CDImageType != CloudInitImageTypeConfigDrive {
@@ -180,6 +676,198 @@ func (va *VirtletAnnotations) validate() error {
 		errs = append(errs, fmt.Sprintf("unknown cpu model type %q. Must be empty or %q", va.CPUModel, CPUModelHostModel))
 	}
 
+	if va.OnCrash != "" && !stringInList(va.OnCrash, validOnCrashActions) {
+		errs = append(errs, fmt.Sprintf("bad on_crash action %q. Must be one of %s", va.OnCrash, strings.Join(validOnCrashActions, ", ")))
+	}
+
+	if va.WatchdogAction != "" && !stringInList(va.WatchdogAction, validWatchdogActions) {
+		errs = append(errs, fmt.Sprintf("bad watchdog action %q. Must be one of %s", va.WatchdogAction, strings.Join(validWatchdogActions, ", ")))
+	}
+
+	if va.TerminationGracePeriodSeconds < 0 {
+		errs = append(errs, fmt.Sprintf("bad termination grace period %d", va.TerminationGracePeriodSeconds))
+	}
+
+	if va.RestartBackoffSeconds < 0 {
+		errs = append(errs, fmt.Sprintf("bad restart backoff %d", va.RestartBackoffSeconds))
+	}
+
+	if va.TuningProfile != "" && va.TuningProfile != TuningProfileLatency && va.TuningProfile != TuningProfileThroughput {
+		errs = append(errs, fmt.Sprintf("unknown tuning profile %q. Must be empty, %q or %q", va.TuningProfile, TuningProfileLatency, TuningProfileThroughput))
+	}
+
+	if (va.PostStartHook != "" || va.PreStopHook != "" || va.PostResumeHook != "") && !va.GuestAgent {
+		errs = append(errs, "guest hooks require the guest agent to be enabled")
+	}
+
+	if va.GuestHookTimeoutSeconds < 0 {
+		errs = append(errs, fmt.Sprintf("bad guest hook timeout %d", va.GuestHookTimeoutSeconds))
+	}
+
+	if va.BootTimeoutSeconds < 0 {
+		errs = append(errs, fmt.Sprintf("bad boot timeout %d", va.BootTimeoutSeconds))
+	}
+
+	if va.BootTimeoutSeconds > 0 && !va.GuestAgent {
+		errs = append(errs, "boot timeout requires the guest agent to be enabled")
+	}
+
+	if va.GuestLogFile != "" {
+		if !va.GuestAgent {
+			errs = append(errs, "guest log file requires the guest agent to be enabled")
+		}
+		if !strings.HasPrefix(va.GuestLogFile, "/") {
+			errs = append(errs, fmt.Sprintf("guest log file path must be absolute: %q", va.GuestLogFile))
+		}
+	}
+
+	if va.Swap != nil {
+		if va.Swap.Type != SwapDisk && va.Swap.Type != SwapZram {
+			errs = append(errs, fmt.Sprintf("bad swap type %q. Must be either %q or %q", va.Swap.Type, SwapDisk, SwapZram))
+		}
+		if va.Swap.Size <= 0 {
+			errs = append(errs, "swap size must be specified and positive")
+		}
+		if va.Swap.Priority != nil && (*va.Swap.Priority < 0 || *va.Swap.Priority > maxSwapPriority) {
+			errs = append(errs, fmt.Sprintf("bad swap priority %d, must be between 0 and %d", *va.Swap.Priority, maxSwapPriority))
+		}
+	}
+
+	switch va.RootFSGrowMode {
+	case "", RootFSGrowCloudInit, RootFSGrowOffline, RootFSGrowNone:
+	default:
+		errs = append(errs, fmt.Sprintf("bad root filesystem grow mode %q. Must be one of %q, %q or %q", va.RootFSGrowMode, RootFSGrowCloudInit, RootFSGrowOffline, RootFSGrowNone))
+	}
+
+	seenBootDevices := make(map[string]bool)
+	for _, dev := range va.BootOrder {
+		switch {
+		case dev == "":
+			errs = append(errs, "empty item in the boot order")
+		case seenBootDevices[dev]:
+			errs = append(errs, fmt.Sprintf("duplicate boot device %q", dev))
+		}
+		seenBootDevices[dev] = true
+	}
+
+	seenChannels := make(map[string]bool)
+	for _, name := range va.SerialChannels {
+		switch {
+		case !serialChannelNameRx.MatchString(name):
+			errs = append(errs, fmt.Sprintf("bad serial channel name %q", name))
+		case name == GuestAgentChannelName:
+			errs = append(errs, fmt.Sprintf("serial channel name %q is reserved for the guest agent", name))
+		case seenChannels[name]:
+			errs = append(errs, fmt.Sprintf("duplicate serial channel %q", name))
+		}
+		seenChannels[name] = true
+	}
+
+	seenHostDevices := make(map[string]bool)
+	for _, path := range va.HostDevices {
+		switch {
+		case path == "" || filepath.Clean(path) != path:
+			errs = append(errs, fmt.Sprintf("bad host device path %q", path))
+		case !strings.HasPrefix(path, "/dev/") && !strings.HasPrefix(path, MdevDevicePathPrefix):
+			errs = append(errs, fmt.Sprintf("host device path %q must start with /dev/ or %s", path, MdevDevicePathPrefix))
+		case strings.HasPrefix(path, MdevDevicePathPrefix) && !mdevUUIDRx.MatchString(path[len(MdevDevicePathPrefix):]):
+			errs = append(errs, fmt.Sprintf("bad mediated device UUID in %q", path))
+		case seenHostDevices[path]:
+			errs = append(errs, fmt.Sprintf("duplicate host device %q", path))
+		}
+		seenHostDevices[path] = true
+	}
+
+	for _, profile := range va.MdevProfiles {
+		if !mdevProfileRx.MatchString(profile) {
+			errs = append(errs, fmt.Sprintf("bad mediated device profile %q", profile))
+		}
+	}
+
+	if va.RootVolumeSource != nil {
+		errs = append(errs, va.RootVolumeSource.validate()...)
+		if va.RootVolumeSize > 0 {
+			errs = append(errs, "root volume size can't be specified for a network root volume")
+		}
+		if len(va.InjectedFiles) > 0 {
+			errs = append(errs, "files can't be injected into a network root volume")
+		}
+	}
+
+	if va.ReadOnlyRootfs {
+		switch {
+		case va.RootVolumeSource != nil:
+			errs = append(errs, "network root volume can't be read-only")
+		case va.AdoptDomain != "":
+			errs = append(errs, "read-only root filesystem can't be used for an adopted domain")
+		case va.RootVolumeSize > 0 && va.RootFSGrowMode != RootFSGrowOffline && va.RootFSGrowMode != RootFSGrowNone:
+			errs = append(errs, fmt.Sprintf("read-only root filesystem can only be grown using %q mode", RootFSGrowOffline))
+		}
+	}
+
+	if va.AdoptDomain != "" {
+		switch {
+		case strings.HasPrefix(va.AdoptDomain, "virtlet-"):
+			errs = append(errs, fmt.Sprintf("can't adopt domain %q that is managed by Virtlet", va.AdoptDomain))
+		case va.RootVolumeSource != nil:
+			errs = append(errs, "network root volume can't be used for an adopted domain")
+		case va.RootVolumeSize > 0:
+			errs = append(errs, "root volume size can't be specified for an adopted domain")
+		case len(va.InjectedFiles) > 0:
+			errs = append(errs, "files can't be injected into the disks of an adopted domain")
+		}
+	}
+
+	seenEnvTargets := make(map[GuestEnvironmentTarget]bool)
+	for _, target := range va.GuestEnvironment {
+		switch {
+		case target != GuestEnvironmentEtcEnvironment && target != GuestEnvironmentSystemd:
+			errs = append(errs, fmt.Sprintf("bad guest environment target %q. Must be either %q or %q", target, GuestEnvironmentEtcEnvironment, GuestEnvironmentSystemd))
+		case seenEnvTargets[target]:
+			errs = append(errs, fmt.Sprintf("duplicate guest environment target %q", target))
+		}
+		seenEnvTargets[target] = true
+	}
+	if len(va.GuestEnvironment) > 0 && va.UserDataScript != "" {
+		errs = append(errs, "guest environment targets can't be used together with cloud-init user-data script")
+	}
+
+	if va.MaxVCPUCount > maxVCPUCount {
+		errs = append(errs, fmt.Sprintf("max vcpu count %d too big, max is %d", va.MaxVCPUCount, maxVCPUCount))
+	}
+
+	if va.MaxVCPUCount != 0 && va.MaxVCPUCount < va.VCPUCount {
+		errs = append(errs, fmt.Sprintf("max vcpu count %d is less than vcpu count %d", va.MaxVCPUCount, va.VCPUCount))
+	}
+
+	if va.VCPUAutoscale && va.MaxVCPUCount <= va.VCPUCount {
+		errs = append(errs, "vcpu autoscaling requires max vcpu count greater than vcpu count")
+	}
+
+	if va.Memory < 0 {
+		errs = append(errs, fmt.Sprintf("bad memory size %d", va.Memory))
+	}
+
+	if va.DiskQueues < 0 || va.DiskQueues > maxDiskQueues {
+		errs = append(errs, fmt.Sprintf("bad disk queue count %d, must be between 0 (auto) and %d", va.DiskQueues, maxDiskQueues))
+	}
+
+	if va.IOThreads < 0 || va.IOThreads > maxIOThreads {
+		errs = append(errs, fmt.Sprintf("bad iothread count %d, must be between 0 (auto) and %d", va.IOThreads, maxIOThreads))
+	}
+
+	switch va.MemoryBacking {
+	case "", MemoryBackingDefault, MemoryBackingMemfd, MemoryBackingHugepages, MemoryBackingFile:
+	default:
+		errs = append(errs, fmt.Sprintf("bad memory backing %q. Must be one of %q, %q, %q or %q", va.MemoryBacking, MemoryBackingDefault, MemoryBackingMemfd, MemoryBackingHugepages, MemoryBackingFile))
+	}
+
+	if va.HugePageSize < 0 {
+		errs = append(errs, fmt.Sprintf("bad huge page size %d", va.HugePageSize))
+	} else if va.HugePageSize > 0 && va.MemoryBacking != MemoryBackingHugepages {
+		errs = append(errs, fmt.Sprintf("huge page size requires %q memory backing", MemoryBackingHugepages))
+	}
+
 	if errs != nil {
 		return fmt.Errorf("bad virtlet annotations. Errors:\n%s", strings.Join(errs, "\n"))
 	}
@@ -187,9 +875,167 @@ func (va *VirtletAnnotations) validate() error {
 	return nil
 }
 
-func loadAnnotations(ns string, podAnnotations map[string]string) (*VirtletAnnotations, error) {
+func stringInList(s string, l []string) bool {
+	for _, item := range l {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNetBootOptions returns the network boot settings specified
+// in the pod annotations or nil if there are no such settings.
+func ParseNetBootOptions(podAnnotations map[string]string) (*network.BootOptions, error) {
+	opts := &network.BootOptions{
+		NextServer:    podAnnotations[pxeNextServerKeyName],
+		BootFilename:  podAnnotations[pxeBootFilenameKeyName],
+		IPXEScriptURL: podAnnotations[ipxeScriptURLKeyName],
+	}
+	if *opts == (network.BootOptions{}) {
+		return nil, nil
+	}
+
+	if opts.NextServer != "" && net.ParseIP(opts.NextServer).To4() == nil {
+		return nil, fmt.Errorf("bad PXE next server address %q", opts.NextServer)
+	}
+
+	if opts.IPXEScriptURL != "" {
+		u, err := url.Parse(opts.IPXEScriptURL)
+		if err != nil {
+			return nil, fmt.Errorf("bad iPXE script url %q: %v", opts.IPXEScriptURL, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "tftp" {
+			return nil, fmt.Errorf("bad iPXE script url %q: the scheme must be http, https or tftp", opts.IPXEScriptURL)
+		}
+	}
+
+	return opts, nil
+}
+
+// ParseCDROMImages returns the list of the images to be attached
+// to the VM as CD-ROM devices that's specified in the pod annotations.
+func ParseCDROMImages(podAnnotations map[string]string) []string {
+	imagesStr, found := podAnnotations[cdromImagesKeyName]
+	if !found {
+		return nil
+	}
+	var images []string
+	for _, name := range strings.Split(imagesStr, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			images = append(images, name)
+		}
+	}
+	return images
+}
+
+// ParseGuestAddressDetection returns true if the pod annotations
+// request learning the IP address of the VM from the ARP and NDP
+// packets it sends instead of relying on the address assigned by
+// the CNI plugin.
+func ParseGuestAddressDetection(podAnnotations map[string]string) bool {
+	return podAnnotations[guestAddressDetectionKeyName] == "true"
+}
+
+// parseNetworkRootVolume parses the network root volume specified
+// as rbd://[user@][host[:port],...]/pool/image@snapshot,
+// iscsi://host[:port]/iqn/lun or nbd://host[:port]/export
+func parseNetworkRootVolume(s string) (*NetworkRootVolume, error) {
+	p := strings.Index(s, "://")
+	if p <= 0 {
+		return nil, fmt.Errorf("bad root volume source %q: must be protocol://[hosts]/name", s)
+	}
+	v := &NetworkRootVolume{Protocol: NetworkVolumeProtocol(s[:p])}
+	rest := s[p+3:]
+	p = strings.Index(rest, "/")
+	if p < 0 {
+		return nil, fmt.Errorf("bad root volume source %q: missing volume name", s)
+	}
+	hosts := rest[:p]
+	v.Name = rest[p+1:]
+	if p = strings.LastIndex(hosts, "@"); p >= 0 {
+		v.User, hosts = hosts[:p], hosts[p+1:]
+	}
+	if hosts != "" {
+		for _, host := range strings.Split(hosts, ",") {
+			v.Hosts = append(v.Hosts, strings.TrimSpace(host))
+		}
+	}
+	if v.Protocol == NetworkVolumeRBD {
+		if p = strings.LastIndex(v.Name, "@"); p >= 0 {
+			v.Name, v.Snapshot = v.Name[:p], v.Name[p+1:]
+		}
+	}
+	return v, nil
+}
+
+func (v *NetworkRootVolume) validate() []string {
+	var errs []string
+	switch v.Protocol {
+	case NetworkVolumeRBD:
+		parts := strings.Split(v.Name, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" || v.Snapshot == "" {
+			errs = append(errs, fmt.Sprintf("bad rbd root volume %q: must be specified as pool/image@snapshot", v.Name))
+		}
+	case NetworkVolumeISCSI, NetworkVolumeNBD:
+		if v.Name == "" {
+			errs = append(errs, fmt.Sprintf("%s root volume name must be specified", v.Protocol))
+		}
+		if len(v.Hosts) == 0 {
+			errs = append(errs, fmt.Sprintf("%s root volume requires the server address", v.Protocol))
+		}
+		if v.User != "" {
+			errs = append(errs, "user name can only be specified for rbd root volumes")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("unsupported root volume protocol %q. Must be one of %q, %q or %q", v.Protocol, NetworkVolumeRBD, NetworkVolumeISCSI, NetworkVolumeNBD))
+	}
+	for _, host := range v.Hosts {
+		name, port := host, ""
+		if p := strings.LastIndex(host, ":"); p >= 0 {
+			name, port = host[:p], host[p+1:]
+		}
+		if name == "" {
+			errs = append(errs, fmt.Sprintf("bad root volume server address %q", host))
+			continue
+		}
+		if port != "" {
+			if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+				errs = append(errs, fmt.Sprintf("bad root volume server port in %q", host))
+			}
+		}
+	}
+	return errs
+}
+
+func parseSwapSettings(podAnnotations map[string]string) (*SwapSettings, error) {
+	swapType, found := podAnnotations[swapTypeKeyName]
+	if !found {
+		return nil, nil
+	}
+	swap := &SwapSettings{Type: SwapType(swapType)}
+	if sizeStr, found := podAnnotations[swapSizeKeyName]; found {
+		if q, err := resource.ParseQuantity(sizeStr); err != nil {
+			return nil, fmt.Errorf("error parsing the swap size for VM pod: %q: %v", sizeStr, err)
+		} else if size, ok := q.AsInt64(); ok {
+			swap.Size = size
+		} else {
+			return nil, fmt.Errorf("bad swap size %q", sizeStr)
+		}
+	}
+	if priorityStr, found := podAnnotations[swapPriorityKeyName]; found {
+		priority, err := strconv.Atoi(priorityStr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing swap priority for VM pod: %q: %v", priorityStr, err)
+		}
+		swap.Priority = &priority
+	}
+	return swap, nil
+}
+
+func loadAnnotations(ns, podName string, podAnnotations map[string]string) (*VirtletAnnotations, error) {
 	var va VirtletAnnotations
-	if err := va.parsePodAnnotations(ns, podAnnotations); err != nil {
+	if err := va.parsePodAnnotations(ns, podName, podAnnotations); err != nil {
 		return nil, err
 	}
 	va.applyDefaults()
@@ -199,7 +1045,28 @@ func loadAnnotations(ns string, podAnnotations map[string]string) (*VirtletAnnot
 	return &va, nil
 }
 
-func (va *VirtletAnnotations) parsePodAnnotations(ns string, podAnnotations map[string]string) error {
+func (va *VirtletAnnotations) parsePodAnnotations(ns, podName string, podAnnotations map[string]string) error {
+	// namespace-wide VM policies are applied first so that
+	// they can be overridden by the pod annotations
+	if externalDataLoader != nil {
+		if err := externalDataLoader.LoadVMPolicy(va, ns); err != nil {
+			return fmt.Errorf("error loading VM policy for namespace %q: %v", ns, err)
+		}
+		if err := externalDataLoader.LoadRuntimeClass(va, ns, podName); err != nil {
+			return fmt.Errorf("error loading RuntimeClass of pod %s/%s: %v", ns, podName, err)
+		}
+	}
+
+	// the flavor is applied next, so its settings take precedence
+	// over the VM policies and the RuntimeClass but not over the
+	// pod annotations
+	if flavor, found := podAnnotations[flavorKeyName]; found && externalDataLoader != nil {
+		va.Flavor = flavor
+		if err := externalDataLoader.LoadFlavor(va, ns, flavor); err != nil {
+			return fmt.Errorf("error loading flavor %q for namespace %q: %v", flavor, ns, err)
+		}
+	}
+
 	if cpuSettingStr, found := podAnnotations[libvirtCPUSetting]; found {
 		var cpuSetting libvirtxml.DomainCPU
 		if err := yaml.Unmarshal([]byte(cpuSettingStr), &cpuSetting); err != nil {
@@ -230,13 +1097,87 @@ func (va *VirtletAnnotations) parsePodAnnotations(ns string, podAnnotations map[
 		}
 	}
 
-	if vcpuCountStr, found := podAnnotations[vcpuCountAnnotationKeyName]; found {
+	if vcpuCountStr, found := podAnnotations[VCPUCountKeyName]; found {
 		var err error
 		if va.VCPUCount, err = strconv.Atoi(vcpuCountStr); err != nil {
 			return fmt.Errorf("error parsing cpu count for VM pod: %q: %v", vcpuCountStr, err)
 		}
 	}
 
+	if maxVCPUCountStr, found := podAnnotations[maxVCPUCountKeyName]; found {
+		var err error
+		if va.MaxVCPUCount, err = strconv.Atoi(maxVCPUCountStr); err != nil {
+			return fmt.Errorf("error parsing max cpu count for VM pod: %q: %v", maxVCPUCountStr, err)
+		}
+	}
+
+	if podAnnotations[vcpuAutoscaleKeyName] == "true" {
+		va.VCPUAutoscale = true
+	}
+
+	if diskQueuesStr, found := podAnnotations[diskQueuesKeyName]; found {
+		var err error
+		if va.DiskQueues, err = strconv.Atoi(diskQueuesStr); err != nil {
+			return fmt.Errorf("error parsing disk queue count for VM pod: %q: %v", diskQueuesStr, err)
+		}
+	}
+
+	if ioThreadsStr, found := podAnnotations[ioThreadsKeyName]; found {
+		var err error
+		if va.IOThreads, err = strconv.Atoi(ioThreadsStr); err != nil {
+			return fmt.Errorf("error parsing iothread count for VM pod: %q: %v", ioThreadsStr, err)
+		}
+	}
+
+	va.MemoryBacking = MemoryBackingSource(podAnnotations[memoryBackingKeyName])
+	if hugePageSizeStr, found := podAnnotations[hugePageSizeKeyName]; found {
+		if q, err := resource.ParseQuantity(hugePageSizeStr); err != nil {
+			return fmt.Errorf("error parsing huge page size for VM pod: %q: %v", hugePageSizeStr, err)
+		} else if size, ok := q.AsInt64(); ok {
+			va.HugePageSize = size
+		} else {
+			return fmt.Errorf("bad huge page size %q", hugePageSizeStr)
+		}
+		// huge page size implies hugepage memory backing
+		if va.MemoryBacking == "" {
+			va.MemoryBacking = MemoryBackingHugepages
+		}
+	}
+
+	if podAnnotations[sharedMemoryKeyName] == "true" {
+		va.SharedMemory = true
+	}
+
+	if podAnnotations[readOnlyRootfsKeyName] == "true" {
+		va.ReadOnlyRootfs = true
+	}
+
+	if podAnnotations[legacyVirtioKeyName] == "true" {
+		va.LegacyVirtio = true
+	}
+
+	if featuresStr, found := podAnnotations[disabledVirtioFeaturesKeyName]; found {
+		va.DisabledVirtioFeatures = nil
+		for _, feature := range strings.Split(featuresStr, ",") {
+			va.DisabledVirtioFeatures = append(va.DisabledVirtioFeatures, VirtioFeature(strings.TrimSpace(feature)))
+		}
+	}
+
+	if nicModel, found := podAnnotations[nicModelKeyName]; found {
+		va.NICModel = NICModel(strings.ToLower(nicModel))
+	}
+
+	if windowStr, found := podAnnotations[maintenanceWindowKeyName]; found {
+		var err error
+		if va.MaintenanceWindow, err = ParseMaintenanceWindow(windowStr); err != nil {
+			return err
+		}
+	}
+
+	if condition, found := podAnnotations[maintenanceRebootConditionKeyName]; found {
+		va.MaintenanceRebootCondition = MaintenanceRebootCondition(condition)
+	}
+
 	if metaDataStr, found := podAnnotations[cloudInitMetaDataKeyName]; found {
 		if err := yaml.Unmarshal([]byte(metaDataStr), &va.MetaData); err != nil {
 			return fmt.Errorf("failed to unmarshal cloud-init metadata: %v", err)
@@ -302,6 +1243,21 @@ func (va *VirtletAnnotations) parsePodAnnotations(ns string, podAnnotations map[
 	va.CDImageType = CloudInitImageType(strings.ToLower(podAnnotations[cloudInitImageType]))
 	va.DiskDriver = DiskDriverName(podAnnotations[diskDriverKeyName])
 
+	if diskBusStr, found := podAnnotations[diskBusKeyName]; found {
+		va.DiskBuses = make(map[string]DiskDriverName)
+		for _, item := range strings.Split(diskBusStr, ",") {
+			parts := strings.SplitN(item, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("error parsing disk bus list for VM pod: %q: expected volume=bus items", diskBusStr)
+			}
+			name := strings.TrimSpace(parts[0])
+			if _, found := va.DiskBuses[name]; found {
+				return fmt.Errorf("error parsing disk bus list for VM pod: %q: duplicate volume %q", diskBusStr, name)
+			}
+			va.DiskBuses[name] = DiskDriverName(strings.TrimSpace(parts[1]))
+		}
+	}
+
 	if rootVolumeSizeStr, found := podAnnotations[rootVolumeSizeKeyName]; found {
 		if q, err := resource.ParseQuantity(rootVolumeSizeStr); err != nil {
 			return fmt.Errorf("error parsing the root volume size for VM pod: %q: %v", rootVolumeSizeStr, err)
@@ -316,7 +1272,7 @@ func (va *VirtletAnnotations) parsePodAnnotations(ns string, podAnnotations map[
 		va.VirtletChown9pfsMounts = true
 	}
 
-	if systemUUIDStr, found := podAnnotations[systemUUIDKeyName]; found {
+	if systemUUIDStr, found := podAnnotations[SystemUUIDKeyName]; found {
 		var err error
 		if va.SystemUUID, err = uuid.ParseHex(systemUUIDStr); err != nil {
 			return fmt.Errorf("failed to parse %q as a UUID: %v", systemUUIDStr, err)
@@ -331,5 +1287,128 @@ func (va *VirtletAnnotations) parsePodAnnotations(ns string, podAnnotations map[
 		va.ForceDHCPNetworkConfig = true
 	}
 
+	if gracePeriodStr, found := podAnnotations[terminationGracePeriodKeyName]; found {
+		var err error
+		if va.TerminationGracePeriodSeconds, err = strconv.ParseInt(gracePeriodStr, 10, 64); err != nil {
+			return fmt.Errorf("error parsing termination grace period for VM pod: %q: %v", gracePeriodStr, err)
+		}
+	}
+
+	if onCrash, found := podAnnotations[onCrashKeyName]; found {
+		va.OnCrash = onCrash
+	}
+
+	if watchdogAction, found := podAnnotations[watchdogActionKeyName]; found {
+		va.WatchdogAction = watchdogAction
+	}
+
+	if backoffStr, found := podAnnotations[restartBackoffKeyName]; found {
+		var err error
+		if va.RestartBackoffSeconds, err = strconv.ParseInt(backoffStr, 10, 64); err != nil {
+			return fmt.Errorf("error parsing restart backoff for VM pod: %q: %v", backoffStr, err)
+		}
+	}
+
+	if tuningProfile, found := podAnnotations[TuningProfileKeyName]; found {
+		va.TuningProfile = TuningProfile(tuningProfile)
+	}
+
+	if podAnnotations[softRebootKeyName] == "true" {
+		va.SoftReboot = true
+	}
+
+	netBoot, err := ParseNetBootOptions(podAnnotations)
+	if err != nil {
+		return err
+	}
+	if netBoot != nil {
+		va.NetBoot = netBoot
+	}
+
+	va.CDROMImages = ParseCDROMImages(podAnnotations)
+
+	if podAnnotations[guestAgentKeyName] == "true" {
+		va.GuestAgent = true
+	}
+
+	va.RootFSGrowMode = RootFSGrowMode(podAnnotations[rootFSGrowModeKeyName])
+
+	va.PostStartHook = podAnnotations[postStartHookKeyName]
+	va.PreStopHook = podAnnotations[preStopHookKeyName]
+	va.PostResumeHook = podAnnotations[postResumeHookKeyName]
+	if timeoutStr, found := podAnnotations[guestHookTimeoutKeyName]; found {
+		var err error
+		if va.GuestHookTimeoutSeconds, err = strconv.ParseInt(timeoutStr, 10, 64); err != nil {
+			return fmt.Errorf("error parsing guest hook timeout for VM pod: %q: %v", timeoutStr, err)
+		}
+	}
+	if timeoutStr, found := podAnnotations[bootTimeoutKeyName]; found {
+		var err error
+		if va.BootTimeoutSeconds, err = strconv.ParseInt(timeoutStr, 10, 64); err != nil {
+			return fmt.Errorf("error parsing boot timeout for VM pod: %q: %v", timeoutStr, err)
+		}
+	}
+
+	if channelsStr, found := podAnnotations[serialChannelsKeyName]; found {
+		va.SerialChannels = nil
+		for _, name := range strings.Split(channelsStr, ",") {
+			va.SerialChannels = append(va.SerialChannels, strings.TrimSpace(name))
+		}
+	}
+
+	if podAnnotations[confirmVolumeDeletionKeyName] == "true" {
+		va.ConfirmVolumeDeletion = true
+	}
+
+	va.GuestLogFile = podAnnotations[guestLogFileKeyName]
+
+	swap, err := parseSwapSettings(podAnnotations)
+	if err != nil {
+		return err
+	}
+	if swap != nil {
+		va.Swap = swap
+	}
+
+	if devicesStr, found := podAnnotations[hostDevicesKeyName]; found {
+		va.HostDevices = nil
+		for _, path := range strings.Split(devicesStr, ",") {
+			va.HostDevices = append(va.HostDevices, strings.TrimSpace(path))
+		}
+	}
+
+	if profilesStr, found := podAnnotations[mdevProfilesKeyName]; found {
+		va.MdevProfiles = nil
+		for _, profile := range strings.Split(profilesStr, ",") {
+			va.MdevProfiles = append(va.MdevProfiles, strings.TrimSpace(profile))
+		}
+	}
+
+	if sourceStr, found := podAnnotations[rootVolumeSourceKeyName]; found {
+		src, err := parseNetworkRootVolume(sourceStr)
+		if err != nil {
+			return err
+		}
+		va.RootVolumeSource = src
+	}
+
+	if domainName, found := podAnnotations[AdoptDomainKeyName]; found {
+		va.AdoptDomain = strings.TrimSpace(domainName)
+	}
+
+	if targetsStr, found := podAnnotations[guestEnvironmentKeyName]; found {
+		va.GuestEnvironment = nil
+		for _, target := range strings.Split(targetsStr, ",") {
+			va.GuestEnvironment = append(va.GuestEnvironment, GuestEnvironmentTarget(strings.TrimSpace(target)))
+		}
+	}
+
+	if bootOrderStr, found := podAnnotations[bootOrderKeyName]; found {
+		va.BootOrder = nil
+		for _, dev := range strings.Split(bootOrderStr, ",") {
+			va.BootOrder = append(va.BootOrder, strings.TrimSpace(dev))
+		}
+	}
+
 	return nil
 }
@@ -19,11 +19,15 @@ package types
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	uuid "github.com/nu7hatch/gouuid"
+
+	"github.com/Mirantis/virtlet/pkg/network"
 )
 
 func TestVirtletAnnotations(t *testing.T) {
+	swapPriority := 10
 
 	for _, testCase := range []struct {
 		name        string
@@ -85,6 +89,60 @@ func TestVirtletAnnotations(t *testing.T) {
 				CDImageType: "nocloud",
 			},
 		},
+		{
+			name: "vcpu autoscaling",
+			annotations: map[string]string{
+				"VirtletVCPUCount":     "2",
+				"VirtletMaxVCPUCount":  "8",
+				"VirtletVCPUAutoscale": "true",
+			},
+			va: &VirtletAnnotations{
+				VCPUCount:     2,
+				MaxVCPUCount:  8,
+				VCPUAutoscale: true,
+				DiskDriver:    "scsi",
+				CDImageType:   "nocloud",
+			},
+		},
+		{
+			name: "disk queues and iothreads",
+			annotations: map[string]string{
+				"VirtletDiskQueues": "4",
+				"VirtletIOThreads":  "2",
+			},
+			va: &VirtletAnnotations{
+				VCPUCount:   1,
+				DiskDriver:  "scsi",
+				CDImageType: "nocloud",
+				DiskQueues:  4,
+				IOThreads:   2,
+			},
+		},
+		{
+			name: "memfd memory backing",
+			annotations: map[string]string{
+				"VirtletMemoryBacking": "memfd",
+				"VirtletSharedMemory":  "true",
+			},
+			va: &VirtletAnnotations{
+				VCPUCount:     1,
+				DiskDriver:    "scsi",
+				CDImageType:   "nocloud",
+				MemoryBacking: MemoryBackingMemfd,
+				SharedMemory:  true,
+			},
+		},
+		{
+			name:        "huge page size",
+			annotations: map[string]string{"VirtletHugePageSize": "1Gi"},
+			va: &VirtletAnnotations{
+				VCPUCount:     1,
+				DiskDriver:    "scsi",
+				CDImageType:   "nocloud",
+				MemoryBacking: MemoryBackingHugepages,
+				HugePageSize:  1024 * 1024 * 1024,
+			},
+		},
 		{
 			name:        "root volume size",
 			annotations: map[string]string{"VirtletRootVolumeSize": "1Gi"},
@@ -175,7 +233,566 @@ func TestVirtletAnnotations(t *testing.T) {
 				ForceDHCPNetworkConfig: true,
 			},
 		},
+		{
+			name: "shutdown and crash handling",
+			annotations: map[string]string{
+				"VirtletTerminationGracePeriodSeconds": "60",
+				"VirtletOnCrash":                       "preserve",
+				"VirtletWatchdogAction":                "reset",
+				"VirtletRestartBackoffSeconds":         "30",
+			},
+			va: &VirtletAnnotations{
+				VCPUCount:                     1,
+				DiskDriver:                    "scsi",
+				CDImageType:                   "nocloud",
+				TerminationGracePeriodSeconds: 60,
+				OnCrash:                       "preserve",
+				WatchdogAction:                "reset",
+				RestartBackoffSeconds:         30,
+			},
+		},
+		{
+			name:        "tuning profile",
+			annotations: map[string]string{"VirtletTuningProfile": "latency"},
+			va: &VirtletAnnotations{
+				VCPUCount:     1,
+				DiskDriver:    "scsi",
+				CDImageType:   "nocloud",
+				TuningProfile: TuningProfileLatency,
+			},
+		},
+		{
+			name:        "soft reboot",
+			annotations: map[string]string{"VirtletSoftReboot": "true"},
+			va: &VirtletAnnotations{
+				VCPUCount:   1,
+				DiskDriver:  "scsi",
+				CDImageType: "nocloud",
+				SoftReboot:  true,
+			},
+		},
+		{
+			name:        "boot order",
+			annotations: map[string]string{"VirtletBootOrder": "network, root,data"},
+			va: &VirtletAnnotations{
+				VCPUCount:   1,
+				DiskDriver:  "scsi",
+				CDImageType: "nocloud",
+				BootOrder:   []string{"network", "root", "data"},
+			},
+		},
+		{
+			name: "network boot",
+			annotations: map[string]string{
+				"VirtletPXENextServer":   "10.1.1.1",
+				"VirtletPXEBootFilename": "undionly.kpxe",
+				"VirtletIPXEScriptURL":   "http://10.1.1.1/boot.ipxe",
+			},
+			va: &VirtletAnnotations{
+				VCPUCount:   1,
+				DiskDriver:  "scsi",
+				CDImageType: "nocloud",
+				BootOrder:   []string{"network", "root"},
+				NetBoot: &network.BootOptions{
+					NextServer:    "10.1.1.1",
+					BootFilename:  "undionly.kpxe",
+					IPXEScriptURL: "http://10.1.1.1/boot.ipxe",
+				},
+			},
+		},
+		{
+			name:        "cdrom images",
+			annotations: map[string]string{"VirtletCDROMImages": "example.com/installer.iso, example.com/drivers.iso"},
+			va: &VirtletAnnotations{
+				VCPUCount:   1,
+				DiskDriver:  "scsi",
+				CDImageType: "nocloud",
+				CDROMImages: []string{"example.com/installer.iso", "example.com/drivers.iso"},
+			},
+		},
+		{
+			name:        "guest agent",
+			annotations: map[string]string{"VirtletGuestAgent": "true"},
+			va: &VirtletAnnotations{
+				VCPUCount:   1,
+				DiskDriver:  "scsi",
+				CDImageType: "nocloud",
+				GuestAgent:  true,
+			},
+		},
+		{
+			name:        "offline root filesystem growth",
+			annotations: map[string]string{"VirtletRootFSGrowMode": "offline"},
+			va: &VirtletAnnotations{
+				VCPUCount:      1,
+				DiskDriver:     "scsi",
+				CDImageType:    "nocloud",
+				RootFSGrowMode: RootFSGrowOffline,
+			},
+		},
+		{
+			name: "guest hooks",
+			annotations: map[string]string{
+				"VirtletGuestAgent":              "true",
+				"VirtletPostStartHook":           "touch /tmp/started",
+				"VirtletPreStopHook":             "systemctl stop myapp",
+				"VirtletPostResumeHook":          "systemctl restart myapp",
+				"VirtletGuestHookTimeoutSeconds": "60",
+			},
+			va: &VirtletAnnotations{
+				VCPUCount:               1,
+				DiskDriver:              "scsi",
+				CDImageType:             "nocloud",
+				GuestAgent:              true,
+				PostStartHook:           "touch /tmp/started",
+				PreStopHook:             "systemctl stop myapp",
+				PostResumeHook:          "systemctl restart myapp",
+				GuestHookTimeoutSeconds: 60,
+			},
+		},
+		{
+			name: "boot timeout",
+			annotations: map[string]string{
+				"VirtletGuestAgent":         "true",
+				"VirtletBootTimeoutSeconds": "300",
+			},
+			va: &VirtletAnnotations{
+				VCPUCount:          1,
+				DiskDriver:         "scsi",
+				CDImageType:        "nocloud",
+				GuestAgent:         true,
+				BootTimeoutSeconds: 300,
+			},
+		},
+		{
+			name:        "serial channels",
+			annotations: map[string]string{"VirtletSerialChannels": "com.example.agent, org.example.metrics-0"},
+			va: &VirtletAnnotations{
+				VCPUCount:      1,
+				DiskDriver:     "scsi",
+				CDImageType:    "nocloud",
+				SerialChannels: []string{"com.example.agent", "org.example.metrics-0"},
+			},
+		},
+		{
+			name:        "host devices",
+			annotations: map[string]string{"VirtletHostDevices": "/dev/net/tun, /sys/bus/mdev/devices/83b8f4f2-509f-382f-3c1e-e6bfe0fa1001"},
+			va: &VirtletAnnotations{
+				VCPUCount:   1,
+				DiskDriver:  "scsi",
+				CDImageType: "nocloud",
+				HostDevices: []string{"/dev/net/tun", "/sys/bus/mdev/devices/83b8f4f2-509f-382f-3c1e-e6bfe0fa1001"},
+			},
+		},
+		{
+			name:        "mediated device profiles",
+			annotations: map[string]string{"VirtletMdevProfiles": "nvidia-63, nvidia-63"},
+			va: &VirtletAnnotations{
+				VCPUCount:    1,
+				DiskDriver:   "scsi",
+				CDImageType:  "nocloud",
+				MdevProfiles: []string{"nvidia-63", "nvidia-63"},
+			},
+		},
+		{
+			name: "rbd root volume",
+			annotations: map[string]string{
+				"VirtletRootVolumeSource": "rbd://libvirt@10.0.0.1:6789,10.0.0.2/rbd/ubuntu-18.04@golden",
+			},
+			va: &VirtletAnnotations{
+				VCPUCount:   1,
+				DiskDriver:  "scsi",
+				CDImageType: "nocloud",
+				RootVolumeSource: &NetworkRootVolume{
+					Protocol: NetworkVolumeRBD,
+					User:     "libvirt",
+					Hosts:    []string{"10.0.0.1:6789", "10.0.0.2"},
+					Name:     "rbd/ubuntu-18.04",
+					Snapshot: "golden",
+				},
+			},
+		},
+		{
+			name: "rbd root volume using ceph.conf",
+			annotations: map[string]string{
+				"VirtletRootVolumeSource": "rbd:///rbd/ubuntu-18.04@golden",
+			},
+			va: &VirtletAnnotations{
+				VCPUCount:   1,
+				DiskDriver:  "scsi",
+				CDImageType: "nocloud",
+				RootVolumeSource: &NetworkRootVolume{
+					Protocol: NetworkVolumeRBD,
+					Name:     "rbd/ubuntu-18.04",
+					Snapshot: "golden",
+				},
+			},
+		},
+		{
+			name: "iscsi root volume",
+			annotations: map[string]string{
+				"VirtletRootVolumeSource": "iscsi://10.0.0.3:3260/iqn.2018-12.com.example:vm-1/0",
+			},
+			va: &VirtletAnnotations{
+				VCPUCount:   1,
+				DiskDriver:  "scsi",
+				CDImageType: "nocloud",
+				RootVolumeSource: &NetworkRootVolume{
+					Protocol: NetworkVolumeISCSI,
+					Hosts:    []string{"10.0.0.3:3260"},
+					Name:     "iqn.2018-12.com.example:vm-1/0",
+				},
+			},
+		},
+		{
+			name:        "adopted domain",
+			annotations: map[string]string{"VirtletAdoptDomain": "legacy-vm"},
+			va: &VirtletAnnotations{
+				VCPUCount:   1,
+				DiskDriver:  "scsi",
+				CDImageType: "nocloud",
+				AdoptDomain: "legacy-vm",
+			},
+		},
+		{
+			name: "read-only rootfs",
+			annotations: map[string]string{
+				"VirtletReadOnlyRootfs": "true",
+				"VirtletRootVolumeSize": "1Gi",
+				"VirtletRootFSGrowMode": "offline",
+			},
+			va: &VirtletAnnotations{
+				VCPUCount:      1,
+				DiskDriver:     "scsi",
+				CDImageType:    "nocloud",
+				RootVolumeSize: 1073741824,
+				RootFSGrowMode: RootFSGrowOffline,
+				ReadOnlyRootfs: true,
+			},
+		},
+		{
+			name: "legacy guest devices",
+			annotations: map[string]string{
+				"VirtletDiskDriver":             "ide",
+				"VirtletNICModel":               "E1000",
+				"VirtletLegacyVirtio":           "true",
+				"VirtletDisabledVirtioFeatures": "packed, iommu",
+			},
+			va: &VirtletAnnotations{
+				VCPUCount:              1,
+				DiskDriver:             "ide",
+				CDImageType:            "nocloud",
+				NICModel:               NICModelE1000,
+				LegacyVirtio:           true,
+				DisabledVirtioFeatures: []VirtioFeature{VirtioFeaturePacked, VirtioFeatureIOMMU},
+			},
+		},
+		{
+			name: "per-volume disk buses",
+			annotations: map[string]string{
+				"VirtletDiskDriver": "virtio",
+				"VirtletDiskBus":    "root=sata, cloud-init=ide,data=usb",
+			},
+			va: &VirtletAnnotations{
+				VCPUCount:   1,
+				DiskDriver:  "virtio",
+				CDImageType: "nocloud",
+				DiskBuses: map[string]DiskDriverName{
+					"root":       DiskDriverSata,
+					"cloud-init": DiskDriverIde,
+					"data":       DiskDriverUSB,
+				},
+			},
+		},
+		{
+			name: "maintenance window",
+			annotations: map[string]string{
+				"VirtletGuestAgent":                 "true",
+				"VirtletMaintenanceWindow":          "Sun 02:00-04:00",
+				"VirtletMaintenanceRebootCondition": "reboot-required",
+			},
+			va: &VirtletAnnotations{
+				VCPUCount:   1,
+				DiskDriver:  "scsi",
+				CDImageType: "nocloud",
+				GuestAgent:  true,
+				MaintenanceWindow: &MaintenanceWindow{
+					Days:        []time.Weekday{time.Sunday},
+					StartMinute: 120,
+					EndMinute:   240,
+				},
+				MaintenanceRebootCondition: MaintenanceRebootRequired,
+			},
+		},
+		{
+			name:        "guest environment",
+			annotations: map[string]string{"VirtletGuestEnvironment": "etc-environment, systemd"},
+			va: &VirtletAnnotations{
+				VCPUCount:        1,
+				DiskDriver:       "scsi",
+				CDImageType:      "nocloud",
+				GuestEnvironment: []GuestEnvironmentTarget{GuestEnvironmentEtcEnvironment, GuestEnvironmentSystemd},
+			},
+		},
+		{
+			name:        "volume deletion confirmation",
+			annotations: map[string]string{"VirtletConfirmVolumeDeletion": "true"},
+			va: &VirtletAnnotations{
+				VCPUCount:             1,
+				DiskDriver:            "scsi",
+				CDImageType:           "nocloud",
+				ConfirmVolumeDeletion: true,
+			},
+		},
+		{
+			name: "guest log file",
+			annotations: map[string]string{
+				"VirtletGuestAgent":   "true",
+				"VirtletGuestLogFile": "/var/log/cloud-init-output.log",
+			},
+			va: &VirtletAnnotations{
+				VCPUCount:    1,
+				DiskDriver:   "scsi",
+				CDImageType:  "nocloud",
+				GuestAgent:   true,
+				GuestLogFile: "/var/log/cloud-init-output.log",
+			},
+		},
+		{
+			name: "swap disk",
+			annotations: map[string]string{
+				"VirtletSwapType":     "disk",
+				"VirtletSwapSize":     "1Gi",
+				"VirtletSwapPriority": "10",
+			},
+			va: &VirtletAnnotations{
+				VCPUCount:   1,
+				DiskDriver:  "scsi",
+				CDImageType: "nocloud",
+				Swap: &SwapSettings{
+					Type:     SwapDisk,
+					Size:     1073741824,
+					Priority: &swapPriority,
+				},
+			},
+		},
+		{
+			name: "zram swap",
+			annotations: map[string]string{
+				"VirtletSwapType": "zram",
+				"VirtletSwapSize": "512Mi",
+			},
+			va: &VirtletAnnotations{
+				VCPUCount:   1,
+				DiskDriver:  "scsi",
+				CDImageType: "nocloud",
+				Swap: &SwapSettings{
+					Type: SwapZram,
+					Size: 536870912,
+				},
+			},
+		},
 		// bad metadata items follow
+		{
+			name:        "guest hook without guest agent",
+			annotations: map[string]string{"VirtletPreStopHook": "systemctl stop myapp"},
+		},
+		{
+			name:        "post-resume hook without guest agent",
+			annotations: map[string]string{"VirtletPostResumeHook": "systemctl restart myapp"},
+		},
+		{
+			name: "bad guest hook timeout",
+			annotations: map[string]string{
+				"VirtletGuestAgent":              "true",
+				"VirtletGuestHookTimeoutSeconds": "-1",
+			},
+		},
+		{
+			name:        "boot timeout without guest agent",
+			annotations: map[string]string{"VirtletBootTimeoutSeconds": "300"},
+		},
+		{
+			name:        "guest log file without guest agent",
+			annotations: map[string]string{"VirtletGuestLogFile": "/var/log/syslog"},
+		},
+		{
+			name: "relative guest log file path",
+			annotations: map[string]string{
+				"VirtletGuestAgent":   "true",
+				"VirtletGuestLogFile": "var/log/syslog",
+			},
+		},
+		{
+			name: "bad boot timeout",
+			annotations: map[string]string{
+				"VirtletGuestAgent":         "true",
+				"VirtletBootTimeoutSeconds": "-1",
+			},
+		},
+		{
+			name: "bad swap type",
+			annotations: map[string]string{
+				"VirtletSwapType": "file",
+				"VirtletSwapSize": "1Gi",
+			},
+		},
+		{
+			name:        "swap without size",
+			annotations: map[string]string{"VirtletSwapType": "disk"},
+		},
+		{
+			name: "bad swap size",
+			annotations: map[string]string{
+				"VirtletSwapType": "disk",
+				"VirtletSwapSize": "lots",
+			},
+		},
+		{
+			name: "bad swap priority",
+			annotations: map[string]string{
+				"VirtletSwapType":     "zram",
+				"VirtletSwapSize":     "1Gi",
+				"VirtletSwapPriority": "-5",
+			},
+		},
+		{
+			name:        "bad root filesystem grow mode",
+			annotations: map[string]string{"VirtletRootFSGrowMode": "magic"},
+		},
+		{
+			name:        "bad PXE next server",
+			annotations: map[string]string{"VirtletPXENextServer": "foobar"},
+		},
+		{
+			name:        "bad iPXE script url",
+			annotations: map[string]string{"VirtletIPXEScriptURL": "ftp://10.1.1.1/boot.ipxe"},
+		},
+		{
+			name:        "duplicate boot device",
+			annotations: map[string]string{"VirtletBootOrder": "root,network,root"},
+		},
+		{
+			name:        "empty boot device",
+			annotations: map[string]string{"VirtletBootOrder": "root,,network"},
+		},
+		{
+			name:        "bad serial channel name",
+			annotations: map[string]string{"VirtletSerialChannels": "com.example.agent,../../etc/passwd"},
+		},
+		{
+			name:        "duplicate serial channel",
+			annotations: map[string]string{"VirtletSerialChannels": "com.example.agent,com.example.agent"},
+		},
+		{
+			name:        "serial channel reserved for the guest agent",
+			annotations: map[string]string{"VirtletSerialChannels": "org.qemu.guest_agent.0"},
+		},
+		{
+			name:        "host device outside /dev",
+			annotations: map[string]string{"VirtletHostDevices": "/etc/shadow"},
+		},
+		{
+			name:        "host device path with dot-dot",
+			annotations: map[string]string{"VirtletHostDevices": "/dev/../etc/shadow"},
+		},
+		{
+			name:        "bad mediated device uuid",
+			annotations: map[string]string{"VirtletHostDevices": "/sys/bus/mdev/devices/foo"},
+		},
+		{
+			name:        "duplicate host device",
+			annotations: map[string]string{"VirtletHostDevices": "/dev/net/tun,/dev/net/tun"},
+		},
+		{
+			name:        "bad mediated device profile",
+			annotations: map[string]string{"VirtletMdevProfiles": "../../../devices"},
+		},
+		{
+			name:        "empty mediated device profile",
+			annotations: map[string]string{"VirtletMdevProfiles": "nvidia-63,"},
+		},
+		{
+			name:        "bad root volume source",
+			annotations: map[string]string{"VirtletRootVolumeSource": "rbd/ubuntu-18.04@golden"},
+		},
+		{
+			name:        "unsupported root volume protocol",
+			annotations: map[string]string{"VirtletRootVolumeSource": "sheepdog://10.0.0.1/vm-1"},
+		},
+		{
+			name:        "rbd root volume without snapshot",
+			annotations: map[string]string{"VirtletRootVolumeSource": "rbd://10.0.0.1/rbd/ubuntu-18.04"},
+		},
+		{
+			name:        "nbd root volume without server",
+			annotations: map[string]string{"VirtletRootVolumeSource": "nbd:///vm-1"},
+		},
+		{
+			name:        "bad root volume server port",
+			annotations: map[string]string{"VirtletRootVolumeSource": "nbd://10.0.0.1:foo/vm-1"},
+		},
+		{
+			name: "network root volume with root volume size",
+			annotations: map[string]string{
+				"VirtletRootVolumeSource": "nbd://10.0.0.1/vm-1",
+				"VirtletRootVolumeSize":   "10Gi",
+			},
+		},
+		{
+			name:        "adopting a domain managed by Virtlet",
+			annotations: map[string]string{"VirtletAdoptDomain": "virtlet-cc349e91-dcf7-vm"},
+		},
+		{
+			name: "adopted domain with network root volume",
+			annotations: map[string]string{
+				"VirtletAdoptDomain":      "legacy-vm",
+				"VirtletRootVolumeSource": "nbd://10.0.0.1/vm-1",
+			},
+		},
+		{
+			name: "adopted domain with root volume size",
+			annotations: map[string]string{
+				"VirtletAdoptDomain":    "legacy-vm",
+				"VirtletRootVolumeSize": "10Gi",
+			},
+		},
+		{
+			name: "read-only network root volume",
+			annotations: map[string]string{
+				"VirtletReadOnlyRootfs":   "true",
+				"VirtletRootVolumeSource": "nbd://10.0.0.1/vm-1",
+			},
+		},
+		{
+			name: "read-only rootfs of an adopted domain",
+			annotations: map[string]string{
+				"VirtletReadOnlyRootfs": "true",
+				"VirtletAdoptDomain":    "legacy-vm",
+			},
+		},
+		{
+			name: "read-only rootfs grown by cloud-init",
+			annotations: map[string]string{
+				"VirtletReadOnlyRootfs": "true",
+				"VirtletRootVolumeSize": "10Gi",
+			},
+		},
+		{
+			name:        "bad guest environment target",
+			annotations: map[string]string{"VirtletGuestEnvironment": "etc-environment,profile"},
+		},
+		{
+			name:        "duplicate guest environment target",
+			annotations: map[string]string{"VirtletGuestEnvironment": "systemd,systemd"},
+		},
+		{
+			name: "guest environment with user-data script",
+			annotations: map[string]string{
+				"VirtletGuestEnvironment":        "systemd",
+				"VirtletCloudInitUserDataScript": "#!/bin/sh\necho hi\n",
+			},
+		},
 		{
 			name:        "bad vcpu count",
 			annotations: map[string]string{"VirtletVCPUCount": "256"},
@@ -184,6 +801,52 @@ func TestVirtletAnnotations(t *testing.T) {
 			name:        "bad disk driver",
 			annotations: map[string]string{"VirtletDiskDriver": "ducttape"},
 		},
+		{
+			name:        "bad disk bus",
+			annotations: map[string]string{"VirtletDiskBus": "root=floppy"},
+		},
+		{
+			name:        "malformed disk bus list",
+			annotations: map[string]string{"VirtletDiskBus": "root"},
+		},
+		{
+			name:        "duplicate volume in disk bus list",
+			annotations: map[string]string{"VirtletDiskBus": "root=sata,root=ide"},
+		},
+		{
+			name:        "empty volume name in disk bus list",
+			annotations: map[string]string{"VirtletDiskBus": "=sata"},
+		},
+		{
+			name:        "bad NIC model",
+			annotations: map[string]string{"VirtletNICModel": "rtl8139"},
+		},
+		{
+			name:        "bad virtio feature",
+			annotations: map[string]string{"VirtletDisabledVirtioFeatures": "packed,event-idx"},
+		},
+		{
+			name:        "duplicate virtio feature",
+			annotations: map[string]string{"VirtletDisabledVirtioFeatures": "iommu,iommu"},
+		},
+		{
+			name:        "bad maintenance window",
+			annotations: map[string]string{"VirtletMaintenanceWindow": "Sun 02:00"},
+		},
+		{
+			name: "bad maintenance reboot condition",
+			annotations: map[string]string{
+				"VirtletMaintenanceWindow":          "02:00-04:00",
+				"VirtletMaintenanceRebootCondition": "sometimes",
+			},
+		},
+		{
+			name: "reboot-required condition without the guest agent",
+			annotations: map[string]string{
+				"VirtletMaintenanceWindow":          "02:00-04:00",
+				"VirtletMaintenanceRebootCondition": "reboot-required",
+			},
+		},
 		{
 			name: "bad cloud-init meta-data",
 			annotations: map[string]string{
@@ -202,9 +865,70 @@ func TestVirtletAnnotations(t *testing.T) {
 				"VirtletCloudInitUserData": "{",
 			},
 		},
+		{
+			name:        "bad on_crash action",
+			annotations: map[string]string{"VirtletOnCrash": "ducttape"},
+		},
+		{
+			name:        "bad watchdog action",
+			annotations: map[string]string{"VirtletWatchdogAction": "ducttape"},
+		},
+		{
+			name:        "bad termination grace period",
+			annotations: map[string]string{"VirtletTerminationGracePeriodSeconds": "-1"},
+		},
+		{
+			name:        "bad restart backoff",
+			annotations: map[string]string{"VirtletRestartBackoffSeconds": "soon"},
+		},
+		{
+			name:        "bad tuning profile",
+			annotations: map[string]string{"VirtletTuningProfile": "ducttape"},
+		},
+		{
+			name: "max vcpu count less than vcpu count",
+			annotations: map[string]string{
+				"VirtletVCPUCount":    "4",
+				"VirtletMaxVCPUCount": "2",
+			},
+		},
+		{
+			name:        "max vcpu count too big",
+			annotations: map[string]string{"VirtletMaxVCPUCount": "1000"},
+		},
+		{
+			name: "vcpu autoscaling without max vcpu count",
+			annotations: map[string]string{
+				"VirtletVCPUCount":     "2",
+				"VirtletVCPUAutoscale": "true",
+			},
+		},
+		{
+			name:        "bad disk queue count",
+			annotations: map[string]string{"VirtletDiskQueues": "-1"},
+		},
+		{
+			name:        "too many iothreads",
+			annotations: map[string]string{"VirtletIOThreads": "100"},
+		},
+		{
+			name:        "bad memory backing",
+			annotations: map[string]string{"VirtletMemoryBacking": "swapfile"},
+		},
+		{
+			name:        "bad huge page size",
+			annotations: map[string]string{"VirtletHugePageSize": "lots"},
+		},
+		{
+			name: "huge page size without hugepage memory backing",
+			annotations: map[string]string{
+				"VirtletMemoryBacking": "memfd",
+				"VirtletHugePageSize":  "2Mi",
+			},
+		},
 	} {
 		t.Run(testCase.name, func(t *testing.T) {
-			va, err := loadAnnotations("", testCase.annotations)
+			va, err := loadAnnotations("", "", testCase.annotations)
 			switch {
 			case testCase.va == nil && err == nil:
 				t.Errorf("invalid annotations considered valid:\n%#v", testCase.annotations)
@@ -0,0 +1,147 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaintenanceRebootCondition specifies when the VM is rebooted
+// during its maintenance window.
+type MaintenanceRebootCondition string
+
+const (
+	// MaintenanceRebootAlways makes Virtlet reboot the VM once
+	// during each occurrence of its maintenance window.
+	MaintenanceRebootAlways MaintenanceRebootCondition = "always"
+	// MaintenanceRebootRequired makes Virtlet reboot the VM during
+	// its maintenance window only if the guest has requested it by
+	// creating /var/run/reboot-required file, as it's done by
+	// unattended-upgrades. It requires the guest agent.
+	MaintenanceRebootRequired MaintenanceRebootCondition = "reboot-required"
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// MaintenanceWindow specifies the recurring time period during
+// which the VM can be rebooted by Virtlet. The times are in UTC.
+type MaintenanceWindow struct {
+	// Days lists the days of week on which the window starts.
+	// Empty list means every day.
+	Days []time.Weekday `json:",omitempty"`
+	// StartMinute is the start of the window in minutes since
+	// midnight.
+	StartMinute int
+	// EndMinute is the end of the window in minutes since
+	// midnight. If it's less than StartMinute, the window
+	// ends on the next day.
+	EndMinute int
+}
+
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("bad time of day %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ParseMaintenanceWindow parses the maintenance window specification
+// in the form of "[DAYS ]HH:MM-HH:MM", where DAYS is a comma-separated
+// list of three-letter day of week names (e.g. "Sat,Sun") or "*"
+// for every day.
+func ParseMaintenanceWindow(s string) (*MaintenanceWindow, error) {
+	var w MaintenanceWindow
+	parts := strings.Fields(s)
+	switch len(parts) {
+	case 1:
+	case 2:
+		if parts[0] != "*" {
+			for _, dayStr := range strings.Split(parts[0], ",") {
+				day, found := weekdayNames[strings.ToLower(strings.TrimSpace(dayStr))]
+				if !found {
+					return nil, fmt.Errorf("bad maintenance window %q: unknown day of week %q", s, dayStr)
+				}
+				w.Days = append(w.Days, day)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("bad maintenance window %q", s)
+	}
+
+	times := strings.Split(parts[len(parts)-1], "-")
+	if len(times) != 2 {
+		return nil, fmt.Errorf("bad maintenance window %q: the time range must be HH:MM-HH:MM", s)
+	}
+	var err error
+	if w.StartMinute, err = parseTimeOfDay(times[0]); err != nil {
+		return nil, fmt.Errorf("bad maintenance window %q: %v", s, err)
+	}
+	if w.EndMinute, err = parseTimeOfDay(times[1]); err != nil {
+		return nil, fmt.Errorf("bad maintenance window %q: %v", s, err)
+	}
+	if w.StartMinute == w.EndMinute {
+		return nil, fmt.Errorf("bad maintenance window %q: empty time range", s)
+	}
+	return &w, nil
+}
+
+func (w *MaintenanceWindow) startsOn(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// OccurrenceStart returns the start of the occurrence of the window
+// that contains the specified time. The second return value is false
+// if the time doesn't fall into the window.
+func (w *MaintenanceWindow) OccurrenceStart(t time.Time) (time.Time, bool) {
+	t = t.UTC()
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	// the occurrence that started on the previous day
+	// may still be in progress
+	for _, day := range []time.Time{today, today.AddDate(0, 0, -1)} {
+		if !w.startsOn(day.Weekday()) {
+			continue
+		}
+		start := day.Add(time.Duration(w.StartMinute) * time.Minute)
+		end := day.Add(time.Duration(w.EndMinute) * time.Minute)
+		if w.EndMinute < w.StartMinute {
+			end = end.AddDate(0, 0, 1)
+		}
+		if !t.Before(start) && t.Before(end) {
+			return start, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMaintenanceWindow(t *testing.T) {
+	for _, tc := range []struct {
+		spec     string
+		expected *MaintenanceWindow
+		errorStr string
+	}{
+		{
+			spec:     "02:00-04:30",
+			expected: &MaintenanceWindow{StartMinute: 120, EndMinute: 270},
+		},
+		{
+			spec:     "* 23:00-01:00",
+			expected: &MaintenanceWindow{StartMinute: 1380, EndMinute: 60},
+		},
+		{
+			spec: "Sat,sun 02:00-04:00",
+			expected: &MaintenanceWindow{
+				Days:        []time.Weekday{time.Saturday, time.Sunday},
+				StartMinute: 120,
+				EndMinute:   240,
+			},
+		},
+		{
+			spec:     "Caturday 02:00-04:00",
+			errorStr: "unknown day of week",
+		},
+		{
+			spec:     "02:00",
+			errorStr: "the time range must be HH:MM-HH:MM",
+		},
+		{
+			spec:     "02:00-25:00",
+			errorStr: "bad time of day",
+		},
+		{
+			spec:     "02:00-02:00",
+			errorStr: "empty time range",
+		},
+		{
+			spec:     "Mon 02:00-03:00 UTC",
+			errorStr: "bad maintenance window",
+		},
+	} {
+		t.Run(tc.spec, func(t *testing.T) {
+			w, err := ParseMaintenanceWindow(tc.spec)
+			switch {
+			case tc.errorStr != "":
+				if err == nil {
+					t.Errorf("didn't get the expected error")
+				} else if !strings.Contains(err.Error(), tc.errorStr) {
+					t.Errorf("bad error message %q (expected it to contain %q)", err, tc.errorStr)
+				}
+			case err != nil:
+				t.Errorf("ParseMaintenanceWindow(): %v", err)
+			case !reflect.DeepEqual(w, tc.expected):
+				t.Errorf("bad maintenance window: %#v instead of %#v", w, tc.expected)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindowOccurrenceStart(t *testing.T) {
+	// 2019-03-02 is a Saturday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2019, 3, day, hour, minute, 0, 0, time.UTC)
+	}
+	for _, tc := range []struct {
+		name          string
+		spec          string
+		t             time.Time
+		expectedStart time.Time
+		expectedIn    bool
+	}{
+		{
+			name:          "daily, inside",
+			spec:          "02:00-04:00",
+			t:             at(5, 3, 15),
+			expectedStart: at(5, 2, 0),
+			expectedIn:    true,
+		},
+		{
+			name: "daily, after the end",
+			spec: "02:00-04:00",
+			t:    at(5, 4, 0),
+		},
+		{
+			name:          "weekend, inside",
+			spec:          "Sat,Sun 02:00-04:00",
+			t:             at(3, 2, 0),
+			expectedStart: at(3, 2, 0),
+			expectedIn:    true,
+		},
+		{
+			name: "weekend, wrong day",
+			spec: "Sat,Sun 02:00-04:00",
+			t:    at(4, 3, 0),
+		},
+		{
+			name:          "across midnight, after midnight",
+			spec:          "Sun 23:00-01:00",
+			t:             at(4, 0, 30),
+			expectedStart: at(3, 23, 0),
+			expectedIn:    true,
+		},
+		{
+			name: "across midnight, next day's start time",
+			spec: "Sun 23:00-01:00",
+			t:    at(4, 23, 30),
+		},
+		{
+			name:          "non-UTC time",
+			spec:          "02:00-04:00",
+			t:             time.Date(2019, 3, 5, 6, 0, 0, 0, time.FixedZone("UTC+3", 3*3600)),
+			expectedStart: at(5, 2, 0),
+			expectedIn:    true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w, err := ParseMaintenanceWindow(tc.spec)
+			if err != nil {
+				t.Fatalf("ParseMaintenanceWindow(): %v", err)
+			}
+			start, in := w.OccurrenceStart(tc.t)
+			if in != tc.expectedIn {
+				t.Errorf("bad in-window flag: %v instead of %v", in, tc.expectedIn)
+			}
+			if !start.Equal(tc.expectedStart) {
+				t.Errorf("bad occurrence start: %v instead of %v", start, tc.expectedStart)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+const (
+	// DefaultVMMemory is the amount of memory given to the VM
+	// when the container doesn't specify a memory limit.
+	DefaultVMMemory = 1024 * 1024 * 1024
+
+	// qemuBaseMemoryOverhead is the memory used by the QEMU
+	// process regardless of the VM configuration (heap, shared
+	// libraries, video memory, firmware)
+	qemuBaseMemoryOverhead = 96 * 1024 * 1024
+	// vcpuMemoryOverhead is the memory used for each vCPU thread
+	// (thread stack and KVM structures)
+	vcpuMemoryOverhead = 8 * 1024 * 1024
+	// diskMemoryOverhead is the memory used by QEMU block layer
+	// buffers for each disk
+	diskMemoryOverhead = 4 * 1024 * 1024
+	// interfaceMemoryOverhead is the memory used by the vhost-net
+	// worker and the ring buffers for each network interface
+	interfaceMemoryOverhead = 4 * 1024 * 1024
+	// ioThreadMemoryOverhead is the memory used by a dedicated
+	// iothread
+	ioThreadMemoryOverhead = 8 * 1024 * 1024
+	// pageTableOverheadRatio is the ratio between the guest memory
+	// size and the memory used by the page tables for it
+	pageTableOverheadRatio = 512
+
+	// qemuBaseCPUOverhead is the CPU time (in millicores) used by
+	// QEMU main loop and the emulator threads
+	qemuBaseCPUOverhead = 100
+	// interfaceCPUOverhead is the CPU time (in millicores) used by
+	// the vhost-net worker for each network interface
+	interfaceCPUOverhead = 10
+	// ioThreadCPUOverhead is the CPU time (in millicores) used by
+	// a dedicated iothread
+	ioThreadCPUOverhead = 50
+)
+
+// VMOverhead describes the resources consumed by the hypervisor for
+// a single VM on top of the resources given to the guest.
+type VMOverhead struct {
+	// MemoryBytes is the memory overhead in bytes.
+	MemoryBytes int64 `json:"memoryBytes"`
+	// MilliCPU is the CPU overhead in millicores.
+	MilliCPU int64 `json:"milliCPU"`
+}
+
+// EstimateVMOverhead returns the approximate amount of resources
+// consumed by QEMU for a VM with the specified number of vCPUs,
+// guest memory size, number of disks and network interfaces.
+func EstimateVMOverhead(vcpus int, memoryBytes int64, disks, interfaces int, ioThread bool) VMOverhead {
+	if vcpus <= 0 {
+		vcpus = 1
+	}
+	if memoryBytes <= 0 {
+		memoryBytes = DefaultVMMemory
+	}
+	r := VMOverhead{
+		MemoryBytes: qemuBaseMemoryOverhead +
+			int64(vcpus)*vcpuMemoryOverhead +
+			int64(disks)*diskMemoryOverhead +
+			int64(interfaces)*interfaceMemoryOverhead +
+			memoryBytes/pageTableOverheadRatio,
+		MilliCPU: qemuBaseCPUOverhead + int64(interfaces)*interfaceCPUOverhead,
+	}
+	if ioThread {
+		r.MemoryBytes += ioThreadMemoryOverhead
+		r.MilliCPU += ioThreadCPUOverhead
+	}
+	return r
+}
+
+// Overhead returns the approximate amount of resources consumed by
+// QEMU for the VM described by this config on top of the resources
+// given to the guest. ParsedAnnotations must be populated.
+func (c *VMConfig) Overhead() VMOverhead {
+	// root volume and cloud-init config volume
+	disks := 2 + len(c.VolumeDevices) + len(c.ParsedAnnotations.CDROMImages)
+	if c.RootVolumeDevice() != nil {
+		// the root volume is one of the volume devices
+		disks--
+	}
+	interfaces := 0
+	if c.ContainerSideNetwork != nil {
+		interfaces = len(c.ContainerSideNetwork.Interfaces)
+	}
+	memory := c.MemoryLimitInBytes
+	if memory == 0 {
+		memory = c.ParsedAnnotations.Memory
+	}
+	return EstimateVMOverhead(
+		c.ParsedAnnotations.VCPUCount,
+		memory,
+		disks,
+		interfaces,
+		c.ParsedAnnotations.TuningProfile == TuningProfileThroughput)
+}
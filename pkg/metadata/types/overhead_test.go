@@ -0,0 +1,74 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/network"
+)
+
+func TestVMOverhead(t *testing.T) {
+	const mib = 1024 * 1024
+	for _, tc := range []struct {
+		name     string
+		config   *VMConfig
+		expected VMOverhead
+	}{
+		{
+			name: "default",
+			config: &VMConfig{
+				ParsedAnnotations: &VirtletAnnotations{VCPUCount: 1},
+				ContainerSideNetwork: &network.ContainerSideNetwork{
+					Interfaces: []*network.InterfaceDescription{{}},
+				},
+			},
+			expected: VMOverhead{MemoryBytes: 118 * mib, MilliCPU: 110},
+		},
+		{
+			name: "throughput profile",
+			config: &VMConfig{
+				MemoryLimitInBytes: 4096 * mib,
+				ParsedAnnotations: &VirtletAnnotations{
+					VCPUCount:     4,
+					TuningProfile: TuningProfileThroughput,
+				},
+				VolumeDevices: []VMVolumeDevice{{DevicePath: "/dev/foo"}},
+				ContainerSideNetwork: &network.ContainerSideNetwork{
+					Interfaces: []*network.InterfaceDescription{{}, {}},
+				},
+			},
+			expected: VMOverhead{MemoryBytes: 164 * mib, MilliCPU: 170},
+		},
+		{
+			name: "persistent rootfs",
+			config: &VMConfig{
+				MemoryLimitInBytes: 512 * mib,
+				ParsedAnnotations:  &VirtletAnnotations{VCPUCount: 2},
+				VolumeDevices:      []VMVolumeDevice{{DevicePath: "/"}},
+			},
+			expected: VMOverhead{MemoryBytes: 121 * mib, MilliCPU: 100},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			overhead := tc.config.Overhead()
+			if overhead != tc.expected {
+				t.Errorf("bad overhead: expected %#v, got %#v", tc.expected, overhead)
+			}
+		})
+	}
+}
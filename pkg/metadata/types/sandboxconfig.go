@@ -0,0 +1,152 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxHostnameLength is the max length of the sandbox hostname
+	// (RFC 1123 label).
+	MaxHostnameLength = 63
+	// MaxSandboxMetadataFieldLength is the max length of the
+	// name, the namespace and the uid of the sandbox.
+	MaxSandboxMetadataFieldLength = 253
+	// MaxSandboxLabels is the max number of the sandbox labels.
+	MaxSandboxLabels = 256
+	// MaxLabelKeyLength is the max length of a label key,
+	// which consists of an optional DNS subdomain prefix up to
+	// 253 characters long, a slash and a name up to 63
+	// characters long.
+	MaxLabelKeyLength = 253 + 1 + 63
+	// MaxLabelValueLength is the max length of a label value.
+	MaxLabelValueLength = 63
+	// MaxTotalAnnotationSize is the max total size of the keys
+	// and values of the sandbox annotations, same as the limit
+	// used by Kubernetes.
+	MaxTotalAnnotationSize = 256 * 1024
+)
+
+// SandboxConfigError denotes an invalid pod sandbox config.
+type SandboxConfigError struct {
+	// Field is the name of the offending field.
+	Field string
+	// Reason describes the problem.
+	Reason string
+}
+
+// Error implements Error method of the error interface.
+func (e *SandboxConfigError) Error() string {
+	return fmt.Sprintf("invalid pod sandbox config: %s: %s", e.Field, e.Reason)
+}
+
+// IsSandboxConfigError returns true if err denotes an invalid pod
+// sandbox config.
+func IsSandboxConfigError(err error) bool {
+	_, ok := err.(*SandboxConfigError)
+	return ok
+}
+
+func sandboxConfigError(field, format string, args ...interface{}) error {
+	return &SandboxConfigError{Field: field, Reason: fmt.Sprintf(format, args...)}
+}
+
+// normalizeHostname converts the hostname to a valid RFC 1123
+// label: the letters are converted to lower case, the
+// characters other than letters, digits and dashes are replaced
+// with dashes, the leading and trailing dashes are removed
+// and the result is truncated to MaxHostnameLength characters.
+func normalizeHostname(hostname string) string {
+	r := []byte(strings.ToLower(hostname))
+	for n, c := range r {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			r[n] = '-'
+		}
+	}
+	s := strings.Trim(string(r), "-")
+	if len(s) > MaxHostnameLength {
+		s = strings.TrimRight(s[:MaxHostnameLength], "-")
+	}
+	return s
+}
+
+// Normalize validates the sandbox config and converts it to the
+// canonical form before it's stored in the metadata store. The
+// hostname is converted to a valid RFC 1123 label, falling back
+// to the pod name if the hostname is empty. The number and the
+// size of the labels and the annotations are limited so that
+// malformed sandbox configs can't bloat the metadata store.
+// The returned errors are of *SandboxConfigError type.
+func (c *PodSandboxConfig) Normalize() error {
+	for _, f := range []struct {
+		name  string
+		value string
+	}{
+		{"name", c.Name},
+		{"uid", c.Uid},
+		{"namespace", c.Namespace},
+	} {
+		switch {
+		case f.value == "":
+			return sandboxConfigError(f.name, "must not be empty")
+		case len(f.value) > MaxSandboxMetadataFieldLength:
+			return sandboxConfigError(f.name, "must be no more than %d characters", MaxSandboxMetadataFieldLength)
+		}
+	}
+	if strings.ContainsAny(c.Uid, "/\x00") {
+		return sandboxConfigError("uid", "contains invalid characters: %q", c.Uid)
+	}
+
+	hostname := c.Hostname
+	if hostname == "" {
+		hostname = c.Name
+	}
+	normalized := normalizeHostname(hostname)
+	if normalized == "" {
+		return sandboxConfigError("hostname", "can't convert %q to a valid hostname", hostname)
+	}
+	c.Hostname = normalized
+
+	if len(c.Labels) > MaxSandboxLabels {
+		return sandboxConfigError("labels", "too many labels: %d (max %d)", len(c.Labels), MaxSandboxLabels)
+	}
+	for k, v := range c.Labels {
+		switch {
+		case k == "":
+			return sandboxConfigError("labels", "empty label key")
+		case len(k) > MaxLabelKeyLength:
+			return sandboxConfigError("labels", "label key %q... is too long (max %d characters)", k[:MaxLabelValueLength], MaxLabelKeyLength)
+		case len(v) > MaxLabelValueLength:
+			return sandboxConfigError("labels", "the value of label %q is too long (max %d characters)", k, MaxLabelValueLength)
+		}
+	}
+
+	totalSize := 0
+	for k, v := range c.Annotations {
+		if k == "" {
+			return sandboxConfigError("annotations", "empty annotation key")
+		}
+		totalSize += len(k) + len(v)
+	}
+	if totalSize > MaxTotalAnnotationSize {
+		return sandboxConfigError("annotations", "total size of the annotations is %d bytes (max %d)", totalSize, MaxTotalAnnotationSize)
+	}
+
+	return nil
+}
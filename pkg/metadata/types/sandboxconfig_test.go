@@ -0,0 +1,133 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNormalizePodSandboxConfig(t *testing.T) {
+	tooManyLabels := map[string]string{}
+	for n := 0; n <= MaxSandboxLabels; n++ {
+		tooManyLabels[fmt.Sprintf("label%d", n)] = "value"
+	}
+	for _, tc := range []struct {
+		name             string
+		update           func(c *PodSandboxConfig)
+		expectedHostname string
+		errField         string
+	}{
+		{
+			name:             "valid config",
+			expectedHostname: "localhost",
+		},
+		{
+			name:             "hostname with invalid characters",
+			update:           func(c *PodSandboxConfig) { c.Hostname = "_My.Host_Name_" },
+			expectedHostname: "my-host-name",
+		},
+		{
+			name:             "long hostname",
+			update:           func(c *PodSandboxConfig) { c.Hostname = strings.Repeat("a", 62) + "-bcd" },
+			expectedHostname: strings.Repeat("a", 62),
+		},
+		{
+			name:             "empty hostname",
+			update:           func(c *PodSandboxConfig) { c.Hostname = "" },
+			expectedHostname: "foo-pod",
+		},
+		{
+			name:     "hostname that can't be normalized",
+			update:   func(c *PodSandboxConfig) { c.Hostname = "..." },
+			errField: "hostname",
+		},
+		{
+			name:     "empty uid",
+			update:   func(c *PodSandboxConfig) { c.Uid = "" },
+			errField: "uid",
+		},
+		{
+			name:     "bad uid",
+			update:   func(c *PodSandboxConfig) { c.Uid = "../foo" },
+			errField: "uid",
+		},
+		{
+			name:     "empty namespace",
+			update:   func(c *PodSandboxConfig) { c.Namespace = "" },
+			errField: "namespace",
+		},
+		{
+			name:     "long name",
+			update:   func(c *PodSandboxConfig) { c.Name = strings.Repeat("x", MaxSandboxMetadataFieldLength+1) },
+			errField: "name",
+		},
+		{
+			name:     "too many labels",
+			update:   func(c *PodSandboxConfig) { c.Labels = tooManyLabels },
+			errField: "labels",
+		},
+		{
+			name:     "long label key",
+			update:   func(c *PodSandboxConfig) { c.Labels[strings.Repeat("k", MaxLabelKeyLength+1)] = "v" },
+			errField: "labels",
+		},
+		{
+			name:     "long label value",
+			update:   func(c *PodSandboxConfig) { c.Labels["foo"] = strings.Repeat("v", MaxLabelValueLength+1) },
+			errField: "labels",
+		},
+		{
+			name:     "annotations too big",
+			update:   func(c *PodSandboxConfig) { c.Annotations["foo"] = strings.Repeat("v", MaxTotalAnnotationSize) },
+			errField: "annotations",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &PodSandboxConfig{
+				Name:        "foo_pod",
+				Uid:         "69eec606-0493-5825-73a4-c5e0c0236155",
+				Namespace:   "default",
+				Hostname:    "localhost",
+				Labels:      map[string]string{"foo": "bar"},
+				Annotations: map[string]string{"hello": "world"},
+			}
+			if tc.update != nil {
+				tc.update(c)
+			}
+			err := c.Normalize()
+			if tc.errField != "" {
+				switch {
+				case err == nil:
+					t.Fatalf("Normalize() didn't fail")
+				case !IsSandboxConfigError(err):
+					t.Fatalf("Normalize() returned an error of unexpected type: %v", err)
+				case err.(*SandboxConfigError).Field != tc.errField:
+					t.Errorf("bad error field %q instead of %q: %v", err.(*SandboxConfigError).Field, tc.errField, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Normalize(): %v", err)
+			}
+			if c.Hostname != tc.expectedHostname {
+				t.Errorf("bad hostname %q instead of %q", c.Hostname, tc.expectedHostname)
+			}
+		})
+	}
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package types
 
 import (
+	cnicurrent "github.com/containernetworking/cni/pkg/types/current"
+
 	"github.com/Mirantis/virtlet/pkg/network"
 	"github.com/Mirantis/virtlet/pkg/utils"
 )
@@ -72,6 +74,36 @@ type PodSandboxInfo struct {
 	State PodSandboxState
 	// Sandbox network state.
 	ContainerSideNetwork *network.ContainerSideNetwork
+	// Revision is changed each time the pod sandbox is saved, taking
+	// the next value of a store-wide counter (the ModRevision of
+	// the record key for the etcd store). It's used to detect
+	// concurrent modifications.
+	Revision uint64
+	// RetainedVolumes describes the volumes of the removed container
+	// of the pod sandbox that are kept for reuse, or is nil if
+	// there are no such volumes.
+	RetainedVolumes *RetainedVolumeSet `json:",omitempty"`
+}
+
+// RetainedVolumeSet describes the retainable volumes of a removed VM,
+// i.e. its root volume, ephemeral disks and config drive, which are
+// kept so they can be reused if the VM is re-created soon, e.g.
+// because it's crash-looping or has soft reboot enabled.
+type RetainedVolumeSet struct {
+	// ContainerID is the id of the removed container, which is
+	// also the UUID of its domain.
+	ContainerID string
+	// RetainedAt is the time when the volumes were retained, in
+	// nanoseconds since the epoch.
+	RetainedAt int64
+	// Fingerprint identifies the contents of the volumes right
+	// after they're set up. The volumes are only reused for a VM
+	// with the same fingerprint.
+	Fingerprint string
+	// SoftReboot is true if the volumes, along with the domain
+	// itself, are kept because of the soft reboot, in which case
+	// they don't expire while the pod sandbox exists.
+	SoftReboot bool
 }
 
 // ContainerInfo contains metadata information about container instance
@@ -86,8 +118,210 @@ type ContainerInfo struct {
 	StartedAt int64
 	// Current state of the container
 	State ContainerState
+	// Brief CamelCase string explaining why the container
+	// is in its current state, e.g. VMStartFailed
+	Reason string
+	// Human-readable message explaining why the container
+	// is in its current state
+	Message string
 	// Container configuration
 	Config VMConfig
+	// Libvirt definition of the VM domain as it was last
+	// defined by Virtlet. It's used to detect the changes
+	// made to the domain bypassing Virtlet
+	DomainXML string
+	// Revision is changed each time the container is saved, taking
+	// the next value of a store-wide counter (the ModRevision of
+	// the record key for the etcd store). It's used to detect
+	// concurrent modifications.
+	Revision uint64
+}
+
+// VMStartRecord describes the artifacts used to start a VM. The
+// records are kept in the metadata store so it's possible to find
+// out what has changed between the VM starts.
+type VMStartRecord struct {
+	// ID is the sequential number of the record
+	ID uint64
+	// Timestamp is the time of the VM start (unix nanoseconds)
+	Timestamp int64
+	// ContainerID is the id of the container (VM)
+	ContainerID string
+	// PodSandboxID is the id of the pod sandbox of the VM
+	PodSandboxID string
+	// PodNamespace is the namespace of the VM pod
+	PodNamespace string
+	// PodName is the name of the VM pod
+	PodName string
+	// Image is the name of the VM image
+	Image string
+	// Error contains the error message if the VM has failed
+	// to start
+	Error string
+	// DomainXML is the libvirt domain definition used to start
+	// the VM
+	DomainXML string
+	// CloudInitHashes maps the paths of the files inside the
+	// cloud-init image to SHA256 hashes of their contents
+	CloudInitHashes map[string]string
+	// CNIResult describes the network configuration of the VM
+	CNIResult *cnicurrent.Result
+}
+
+// Reasons for the removal of the pod sandboxes that are recorded
+// in the sandbox tombstones.
+const (
+	// SandboxRemovedByKubelet means that the pod sandbox was
+	// removed via RemovePodSandbox CRI call
+	SandboxRemovedByKubelet = "RemovedByKubelet"
+	// SandboxRemovedAsOrphan means that the pod sandbox was
+	// removed by the metadata GC as an orphan
+	SandboxRemovedAsOrphan = "RemovedAsOrphan"
+)
+
+// SandboxTombstone is kept in the metadata store for a while after
+// the pod sandbox is removed so it's possible to find out what has
+// happened to the pod.
+type SandboxTombstone struct {
+	// PodSandboxID is the id of the removed pod sandbox
+	PodSandboxID string
+	// PodNamespace is the namespace of the pod
+	PodNamespace string
+	// PodName is the name of the pod
+	PodName string
+	// PodUID is the UID of the pod
+	PodUID string
+	// CreatedAt is the creation time of the pod sandbox
+	// (unix nanoseconds)
+	CreatedAt int64
+	// DeletedAt is the removal time of the pod sandbox
+	// (unix nanoseconds)
+	DeletedAt int64
+	// Reason is the reason of the removal, one of
+	// SandboxRemoved* values
+	Reason string
+	// ContainerIDs lists the ids of the containers (VMs) that
+	// were started in the pod sandbox according to the start
+	// records
+	ContainerIDs []string
+}
+
+// The following are the types of the pod sandbox lifecycle events.
+const (
+	// SandboxEventCreated means that the pod sandbox was created
+	SandboxEventCreated = "SandboxCreated"
+	// SandboxEventNetworkSetUp means that the pod network was set up
+	SandboxEventNetworkSetUp = "NetworkSetUp"
+	// SandboxEventNetworkSetupFailed means that the pod network
+	// couldn't be set up
+	SandboxEventNetworkSetupFailed = "NetworkSetupFailed"
+	// SandboxEventNetworkTornDown means that the pod network was
+	// torn down
+	SandboxEventNetworkTornDown = "NetworkTornDown"
+	// SandboxEventStopped means that the pod sandbox was stopped
+	SandboxEventStopped = "SandboxStopped"
+	// SandboxEventRemoved means that the pod sandbox was removed
+	SandboxEventRemoved = "SandboxRemoved"
+	// SandboxEventVMCreated means that the VM was created
+	SandboxEventVMCreated = "VMCreated"
+	// SandboxEventVMStarted means that the VM was started
+	SandboxEventVMStarted = "VMStarted"
+	// SandboxEventVMStopped means that the VM was stopped, either
+	// by Virtlet or by the guest OS
+	SandboxEventVMStopped = "VMStopped"
+	// SandboxEventVMCrashed means that the VM was found to have
+	// crashed
+	SandboxEventVMCrashed = "VMCrashed"
+	// SandboxEventVMRemoved means that the VM was removed
+	SandboxEventVMRemoved = "VMRemoved"
+	// SandboxEventVMSuspended means that the running VM was found
+	// to be paused or suspended
+	SandboxEventVMSuspended = "VMSuspended"
+	// SandboxEventVMResumed means that the paused or suspended VM
+	// was found to be running again
+	SandboxEventVMResumed = "VMResumed"
+	// SandboxEventReconciled means that a discrepancy between
+	// the metadata store and libvirt concerning the pod sandbox
+	// was found and handled upon Virtlet startup
+	SandboxEventReconciled = "Reconciled"
+)
+
+// SandboxEvent describes a lifecycle event of a pod sandbox or its
+// VM. A limited number of the most recent events is kept for each
+// pod sandbox, including the removed ones, so it's possible to find
+// out what has happened to the pod from Virtlet's point of view.
+type SandboxEvent struct {
+	// ID is the id of the event. The ids are assigned by the
+	// store in increasing order
+	ID uint64
+	// PodSandboxID is the id of the pod sandbox
+	PodSandboxID string
+	// Timestamp is the time of the event (unix nanoseconds)
+	Timestamp int64
+	// Type is the type of the event, one of SandboxEvent* values
+	Type string
+	// ContainerID is the id of the container (VM) the event
+	// applies to, if any
+	ContainerID string
+	// Message contains the details of the event, if any
+	Message string
+}
+
+// FirstBootRecord tracks the first boot of the VMs from a persistent
+// root volume. It's used to keep cloud-init instance-id stable for
+// the volume so the VM isn't provisioned again after its pod is
+// recreated.
+type FirstBootRecord struct {
+	// VolumeID is the UUID of the persistent root volume
+	VolumeID string
+	// ImageDigest is the digest of the image written to the volume
+	ImageDigest string
+	// InstanceID is the cloud-init instance-id used for the first
+	// boot from the volume
+	InstanceID string
+	// PodNamespace is the namespace of the pod that booted from
+	// the volume for the first time
+	PodNamespace string
+	// PodName is the name of the pod that booted from the volume
+	// for the first time
+	PodName string
+	// CompletedAt is the time when the VM has started from the
+	// volume for the first time (unix nanoseconds), or 0 if it
+	// hasn't started yet
+	CompletedAt int64
+}
+
+// Completed returns true if the VM has already started from the
+// volume.
+func (r *FirstBootRecord) Completed() bool {
+	return r.CompletedAt != 0
+}
+
+// ImagePullJob tracks an image pull that runs in background.
+type ImagePullJob struct {
+	// ImageName is the name of the image being pulled
+	ImageName string
+	// StartedAt is the time when the pull has started (unix nanoseconds)
+	StartedAt int64
+	// FinishedAt is the time when the pull has finished (unix
+	// nanoseconds), or 0 if it's still running
+	FinishedAt int64
+	// BytesDone is the number of bytes downloaded so far
+	BytesDone int64
+	// BytesTotal is the size of the image in bytes, or 0 if
+	// it's unknown
+	BytesTotal int64
+	// ImageRef is the reference of the pulled image that
+	// includes the digest. It's set if the pull has succeeded
+	ImageRef string
+	// Error contains the error message if the pull has failed
+	Error string
+}
+
+// Finished returns true if the pull has finished, either
+// successfully or not.
+func (j *ImagePullJob) Finished() bool {
+	return j.FinishedAt != 0
 }
 
 // VMStats contains cpu/memory/disk usage for VM.
@@ -103,12 +337,96 @@ type VMStats struct {
 	// CpuUsage in nano seconds per cpu
 	CpuUsage uint64
 	// MemoryUsage is expected to contain the amount of working set memory
-	// in bytes what in our case will be returned using RSS value
+	// in bytes. It's the memory used by the guest as reported by the
+	// balloon driver or, if the guest stats aren't available, the RSS
+	// of the emulator process.
 	MemoryUsage uint64
+	// MemoryAvailable is the amount of memory in bytes that's
+	// available to the guest as reported by the balloon driver.
+	// It's 0 if the guest stats aren't available.
+	MemoryAvailable uint64
 	// FsBytes represents current size of rootfs in bytes
 	FsBytes uint64
 }
 
+// VMMemoryStats contains memory statistics for a VM.
+type VMMemoryStats struct {
+	// WorkingSet is the working set memory of the VM in bytes,
+	// see VMStats.MemoryUsage.
+	WorkingSet uint64
+	// Available is the memory available to the guest in bytes,
+	// see VMStats.MemoryAvailable.
+	Available uint64
+	// RSS is the resident set size of the emulator process in bytes.
+	RSS uint64
+	// Actual is the current balloon size in bytes.
+	Actual uint64
+	// Unused is the memory left completely unused by the guest in bytes.
+	Unused uint64
+	// LastUpdate is the timestamp of the last update of the guest
+	// memory stats in seconds since the epoch, 0 if the guest stats
+	// aren't available.
+	LastUpdate int64
+}
+
+// EmulatorInfo describes the emulator process of a VM.
+type EmulatorInfo struct {
+	// PID is the process id of the emulator.
+	PID int
+	// Cgroups maps the cgroup controller names to the cgroup
+	// paths of the emulator process.
+	Cgroups map[string]string
+	// VhostThreads lists the ids of the vhost threads that
+	// serve the network interfaces of the VM.
+	VhostThreads []int
+	// MonitorSocketPath is the path to the QEMU monitor socket
+	// used by libvirt, empty if it can't be determined.
+	MonitorSocketPath string
+}
+
+// VMDiskStats contains IO statistics for a VM disk.
+type VMDiskStats struct {
+	// Dev is the target device name of the disk, e.g. "sda".
+	Dev string
+	// VolumeName is the name of the pod volume that corresponds
+	// to the disk. It's "root" for the root volume and
+	// "cloud-init" for the Cloud-Init config disk.
+	VolumeName string
+	// ReadRequests is the number of read requests.
+	ReadRequests int64
+	// ReadBytes is the number of bytes read.
+	ReadBytes int64
+	// ReadTimeNs is the total time spent on reads in nanoseconds.
+	ReadTimeNs int64
+	// WriteRequests is the number of write requests.
+	WriteRequests int64
+	// WriteBytes is the number of bytes written.
+	WriteBytes int64
+	// WriteTimeNs is the total time spent on writes in nanoseconds.
+	WriteTimeNs int64
+	// FlushRequests is the number of flush requests.
+	FlushRequests int64
+	// FlushTimeNs is the total time spent on flushes in nanoseconds.
+	FlushTimeNs int64
+	// ReadLatencyNs is the average time per read request in
+	// nanoseconds.
+	ReadLatencyNs int64
+	// WriteLatencyNs is the average time per write request in
+	// nanoseconds.
+	WriteLatencyNs int64
+	// FlushLatencyNs is the average time per flush request in
+	// nanoseconds.
+	FlushLatencyNs int64
+	// ReadIOPS is the number of read requests per second since
+	// the previous time the stats were taken, zero if there's no
+	// previous sample.
+	ReadIOPS float64
+	// WriteIOPS is the number of write requests per second since
+	// the previous time the stats were taken, zero if there's no
+	// previous sample.
+	WriteIOPS float64
+}
+
 // NamespaceOption provides options for Linux namespaces.
 type NamespaceOption struct {
 	// If set, use the host's network namespace.
@@ -130,6 +448,19 @@ type PodSandboxFilter struct {
 	// Only api.MatchLabels is supported for now and the requirements
 	// are ANDed. MatchExpressions is not supported yet.
 	LabelSelector map[string]string
+	// AnnotationSelector to select matches by the pod annotations.
+	// The requirements are ANDed in the same way as for LabelSelector.
+	AnnotationSelector map[string]string
+	// Namespace of the pod. Empty value matches any namespace.
+	Namespace string
+	// Name of the pod. Empty value matches any name.
+	Name string
+	// CreatedAfter selects the sandboxes created at or after the
+	// specified time (unix nanoseconds). Zero value disables the check.
+	CreatedAfter int64
+	// CreatedBefore selects the sandboxes created before the
+	// specified time (unix nanoseconds). Zero value disables the check.
+	CreatedBefore int64
 }
 
 // DNSConfig specifies the DNS servers and search domains of a sandbox.
@@ -234,6 +565,9 @@ type VMConfig struct {
 	PodName string
 	// Namespace of the containing pod sandbox.
 	PodNamespace string
+	// Hostname of the containing pod sandbox. It's either the
+	// hostname set in the pod spec or the pod name.
+	Hostname string
 	// Name of the container (VM).
 	Name string
 	// Image to use for the VM.
@@ -248,6 +582,9 @@ type VMConfig struct {
 	CPUPeriod int64
 	// CPU CFS (Completely Fair Scheduler) quota. Default: 0 (not specified).
 	CPUQuota int64
+	// CPUs in which to allow execution, e.g. "0-3,6", as assigned
+	// by the kubelet CPU manager. Default: "" (not specified).
+	CPUSetCpus string `json:",omitempty"`
 	// Annotations for the containing pod.
 	PodAnnotations map[string]string
 	// Annotations for the container.
@@ -259,6 +596,24 @@ type VMConfig struct {
 	// Domain UUID (set by the CreateContainer).
 	// TODO: this field should be moved to VMStatus
 	DomainUUID string
+	// Cloud-init instance-id to use for the VM. It's set by
+	// CreateContainer for VMs with a persistent root volume.
+	// If it's empty, the instance-id is derived from the pod
+	// name and namespace.
+	InstanceID string
+	// Names of the volumes that are marked as persistent (set by
+	// the CreateContainer). The VM can't be removed together with
+	// these volumes without a confirmation.
+	PersistentVolumes []string
+	// UUIDs of the mediated devices created for the VM (set by
+	// the CreateContainer). The devices are removed together
+	// with the VM.
+	MdevUUIDs []string
+	// XML definition of the pre-existing libvirt domain taken
+	// over by the VM (set by the CreateContainer). The disks of
+	// this domain are used instead of the root volume, and the
+	// domain is defined again when the VM is removed.
+	AdoptedDomainXML string
 	// Environment variables to set in the VM.
 	Environment []VMKeyValue
 	// Host directories corresponding to the volumes which are to.
@@ -292,7 +647,7 @@ func (c *VMConfig) RootVolumeDevice() *VMVolumeDevice {
 // LoadAnnotations parses pod annotations in the VM config an
 // populates the ParsedAnnotations field.
 func (c *VMConfig) LoadAnnotations() error {
-	ann, err := loadAnnotations(c.PodNamespace, c.PodAnnotations)
+	ann, err := loadAnnotations(c.PodNamespace, c.PodName, c.PodAnnotations)
 	if err != nil {
 		return err
 	}
@@ -313,6 +668,19 @@ type ContainerFilter struct {
 	// Only api.MatchLabels is supported for now and the requirements
 	// are ANDed. MatchExpressions is not supported yet.
 	LabelSelector map[string]string
+	// AnnotationSelector to select matches by the pod annotations.
+	// The requirements are ANDed in the same way as for LabelSelector.
+	AnnotationSelector map[string]string
+	// Namespace of the pod. Empty value matches any namespace.
+	Namespace string
+	// Name of the pod. Empty value matches any name.
+	Name string
+	// CreatedAfter selects the sandboxes created at or after the
+	// specified time (unix nanoseconds). Zero value disables the check.
+	CreatedAfter int64
+	// CreatedBefore selects the sandboxes created before the
+	// specified time (unix nanoseconds). Zero value disables the check.
+	CreatedBefore int64
 }
 
 // VMStatsFilter is used to filter set of container stats
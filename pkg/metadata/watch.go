@@ -0,0 +1,244 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/golang/glog"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const watchBufferSize = 100
+
+// EventType denotes the kind of change of a metadata object.
+type EventType string
+
+const (
+	// EventCreated means that the object was created
+	EventCreated EventType = "created"
+	// EventUpdated means that the object was modified
+	EventUpdated EventType = "updated"
+	// EventDeleted means that the object was deleted
+	EventDeleted EventType = "deleted"
+)
+
+// ObjectKind denotes the kind of a metadata object.
+type ObjectKind string
+
+const (
+	// KindPodSandbox denotes pod sandboxes
+	KindPodSandbox ObjectKind = "sandbox"
+	// KindContainer denotes containers
+	KindContainer ObjectKind = "container"
+)
+
+// Event describes a change of a pod sandbox or a container in the
+// metadata store. The objects in the events are shared between the
+// watchers and must not be modified.
+type Event struct {
+	// Type is the type of the change
+	Type EventType
+	// Kind is the kind of the object that has changed
+	Kind ObjectKind
+	// ID is the id of the pod sandbox or the container
+	ID string
+	// Sandbox is the new state of the pod sandbox for
+	// KindPodSandbox events, or its last state if it was deleted
+	Sandbox *types.PodSandboxInfo
+	// Container is the new state of the container for
+	// KindContainer events, or its last state if it was deleted
+	Container *types.ContainerInfo
+}
+
+// watchHub delivers the events to the watchers of a store.
+type watchHub struct {
+	// updateLock serializes the updates that produce the events
+	// so that the events are delivered in the order of the updates
+	updateLock sync.Mutex
+	lock       sync.Mutex
+	watchers   map[chan Event]bool
+	// audit is the log that receives the records for the
+	// changes, nil if the audit is disabled
+	audit *AuditLog
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{watchers: make(map[chan Event]bool)}
+}
+
+// watch registers a new watcher, returning the event channel
+// and the function that stops watching.
+func (h *watchHub) watch() (<-chan Event, func()) {
+	ch := make(chan Event, watchBufferSize)
+	h.lock.Lock()
+	h.watchers[ch] = true
+	h.lock.Unlock()
+	return ch, func() {
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		if h.watchers[ch] {
+			delete(h.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// notify sends the event to the watchers. The watchers that don't
+// keep up with the events are dropped by closing their channels.
+func (h *watchHub) notify(event Event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.watchers) == 0 {
+		return
+	}
+	// make sure the watchers don't share the objects
+	// with the callers of Save()
+	if err := copyEventObjects(&event); err != nil {
+		glog.Errorf("Error copying metadata event objects: %v", err)
+		return
+	}
+	for ch := range h.watchers {
+		select {
+		case ch <- event:
+		default:
+			glog.Warningf("Metadata watcher is too slow, dropping it")
+			delete(h.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// update runs the update function which returns the event that
+// describes the change, if any, and delivers the event to the
+// watchers if the update succeeds. origin denotes the actor the
+// change is attributed to in the audit log, nil meaning Virtlet
+// itself.
+func (h *watchHub) update(origin *auditOrigin, fn func() (*Event, error)) error {
+	return h.updateMulti(origin, func() ([]*Event, error) {
+		event, err := fn()
+		return eventList(event), err
+	})
+}
+
+// updateMulti runs the update function which returns the events
+// that describe the changes and delivers the events to the watchers
+// in their order if the update succeeds.
+func (h *watchHub) updateMulti(origin *auditOrigin, fn func() ([]*Event, error)) error {
+	h.updateLock.Lock()
+	defer h.updateLock.Unlock()
+	events, err := fn()
+	if err == nil {
+		h.notifyAll(origin, events)
+	}
+	return err
+}
+
+// notifyAll records the changes made by the code denoted by origin
+// in the audit log and sends the events to the watchers in their
+// order.
+func (h *watchHub) notifyAll(origin *auditOrigin, events []*Event) {
+	h.recordAudit(origin, events)
+	for _, event := range events {
+		h.notify(*event)
+	}
+}
+
+// eventList returns a list containing the event, or an empty list
+// if the event is nil.
+func eventList(event *Event) []*Event {
+	if event == nil {
+		return nil
+	}
+	return []*Event{event}
+}
+
+func copyEventObjects(event *Event) error {
+	if event.Sandbox != nil {
+		var psi *types.PodSandboxInfo
+		if err := copyViaJSON(event.Sandbox, &psi); err != nil {
+			return err
+		}
+		psi.PodID = event.ID
+		event.Sandbox = psi
+	}
+	if event.Container != nil {
+		var ci *types.ContainerInfo
+		if err := copyViaJSON(event.Container, &ci); err != nil {
+			return err
+		}
+		event.Container = ci
+	}
+	return nil
+}
+
+func copyViaJSON(from, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, to)
+}
+
+func eventType(exists, deleted bool) EventType {
+	switch {
+	case deleted:
+		return EventDeleted
+	case exists:
+		return EventUpdated
+	default:
+		return EventCreated
+	}
+}
+
+// sandboxEvent returns the event for the pod sandbox change, or
+// nil if there was no change.
+func sandboxEvent(id string, current, newData *types.PodSandboxInfo) *Event {
+	if current == nil && newData == nil {
+		return nil
+	}
+	event := &Event{
+		Type:    eventType(current != nil, newData == nil),
+		Kind:    KindPodSandbox,
+		ID:      id,
+		Sandbox: newData,
+	}
+	if newData == nil {
+		event.Sandbox = current
+	}
+	return event
+}
+
+// containerEvent returns the event for the container change, or
+// nil if there was no change.
+func containerEvent(id string, current, newData *types.ContainerInfo) *Event {
+	if current == nil && newData == nil {
+		return nil
+	}
+	event := &Event{
+		Type:      eventType(current != nil, newData == nil),
+		Kind:      KindContainer,
+		ID:        id,
+		Container: newData,
+	}
+	if newData == nil {
+		event.Container = current
+	}
+	return event
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+// receiveEvents returns the events that are already queued for the
+// watcher and whether the channel is closed.
+func receiveEvents(ch <-chan Event) ([]string, bool) {
+	var events []string
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return events, true
+			}
+			s := fmt.Sprintf("%s %s %s", event.Type, event.Kind, event.ID)
+			switch {
+			case event.Sandbox != nil:
+				s += " " + event.Sandbox.PodID + " " + event.Sandbox.Config.Name
+			case event.Container != nil:
+				s += " " + event.Container.Id + " " + event.Container.Name
+			}
+			events = append(events, s)
+		default:
+			return events, false
+		}
+	}
+}
+
+func saveTestSandbox(t *testing.T, store Store, podID, name string) {
+	if err := store.PodSandbox(podID).Save(func(*types.PodSandboxInfo) (*types.PodSandboxInfo, error) {
+		if name == "" {
+			return nil, nil
+		}
+		return &types.PodSandboxInfo{
+			Config: &types.PodSandboxConfig{Name: name},
+			State:  types.PodSandboxState_SANDBOX_READY,
+		}, nil
+	}); err != nil {
+		t.Fatalf("PodSandbox().Save(): %v", err)
+	}
+}
+
+func saveWatchedContainer(t *testing.T, store Store, containerID, podID, name string) {
+	if err := store.Container(containerID).Save(func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+		if name == "" {
+			return nil, nil
+		}
+		return &types.ContainerInfo{
+			Name:   name,
+			Config: types.VMConfig{PodSandboxID: podID},
+		}, nil
+	}); err != nil {
+		t.Fatalf("Container().Save(): %v", err)
+	}
+}
+
+func verifyWatch(t *testing.T, store Store) {
+	ch, stop := store.Watch()
+	slowCh, stopSlow := store.Watch()
+	defer stopSlow()
+
+	saveTestSandbox(t, store, "pod1", "pod1-name")
+	saveWatchedContainer(t, store, "container1", "pod1", "vm1")
+	saveWatchedContainer(t, store, "container1", "pod1", "vm1-renamed")
+	if err := store.Container("container1").Save(func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+		return nil, errors.New("oops")
+	}); err == nil {
+		t.Errorf("Container().Save() didn't fail")
+	}
+	// removing a nonexistent container doesn't produce any events
+	saveWatchedContainer(t, store, "container2", "pod1", "")
+	saveWatchedContainer(t, store, "container1", "", "")
+	saveTestSandbox(t, store, "pod1", "")
+
+	events, closed := receiveEvents(ch)
+	expectedEvents := []string{
+		"created sandbox pod1 pod1 pod1-name",
+		"created container container1 container1 vm1",
+		"updated container container1 container1 vm1-renamed",
+		"deleted container container1 container1 vm1-renamed",
+		"deleted sandbox pod1 pod1 pod1-name",
+	}
+	if closed {
+		t.Errorf("the watch channel is closed unexpectedly")
+	}
+	if !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("bad events:\n%#v\ninstead of\n%#v", events, expectedEvents)
+	}
+
+	stop()
+	if _, closed := receiveEvents(ch); !closed {
+		t.Errorf("the watch channel is not closed after stopping the watch")
+	}
+	// stopping the watch again is a no-op
+	stop()
+
+	// the slow watcher that doesn't receive the events is
+	// dropped after its buffer overflows
+	for i := 0; i < watchBufferSize; i++ {
+		saveTestSandbox(t, store, fmt.Sprintf("pod%d", i), "name")
+	}
+	events, closed = receiveEvents(slowCh)
+	if len(events) != watchBufferSize {
+		t.Errorf("bad number of events for the slow watcher: %d instead of %d", len(events), watchBufferSize)
+	}
+	if !closed {
+		t.Errorf("the slow watcher was not dropped")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	store, err := newTestStore()
+	if err != nil {
+		t.Fatalf("Error creating the store: %v", err)
+	}
+	defer store.Close()
+	verifyWatch(t, store)
+}
@@ -545,6 +545,13 @@ func SetHardwareAddr(link netlink.Link, hwAddr net.HardwareAddr) error {
 	return nil
 }
 
+// TapInterfaceName returns the name of the tap interface that's
+// created for the interface with the specified index in the
+// container network namespace.
+func TapInterfaceName(ifaceNo int) string {
+	return fmt.Sprintf(tapInterfaceNameTemplate, ifaceNo)
+}
+
 func setupTapAndGetInterfaceDescription(link netlink.Link, nsPath string, ifaceNo int) (*network.InterfaceDescription, error) {
 	hwAddr := link.Attrs().HardwareAddr
 	ifaceName := link.Attrs().Name
@@ -652,7 +659,7 @@ func SetupContainerSideNetwork(info *cnicurrent.Result, nsPath string, allLinks
 		interfaces = append(interfaces, ifDesc)
 	}
 
-	return &network.ContainerSideNetwork{info, nsPath, interfaces}, nil
+	return &network.ContainerSideNetwork{Result: info, NsPath: nsPath, Interfaces: interfaces}, nil
 }
 
 // RecoverContainerSideNetwork tries to populate ContainerSideNetwork
@@ -0,0 +1,212 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nettools
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"time"
+
+	cnicurrent "github.com/containernetworking/cni/pkg/types/current"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	selfTestTimeout  = time.Second
+	selfTestAttempts = 2
+	dnsPort          = 53
+	icmpEchoRequest  = 8
+	icmpEchoReply    = 0
+	dnsTypeNS        = 2
+	dnsClassIN       = 1
+)
+
+// NetworkSelfTest checks whether the network configured by CNI in
+// the current network namespace is usable, i.e. the gateway responds
+// and the DNS servers (usually the cluster DNS service VIP) can be
+// reached. It must be called inside the pod network namespace before
+// the network is handed over to the VM. The return value is the list
+// of the problems found, which is empty if the self-test has passed.
+func NetworkSelfTest(info *cnicurrent.Result) []string {
+	var problems []string
+	if gw := findIPv4Gateway(info); gw == nil {
+		problems = append(problems, "no IPv4 gateway in the CNI result")
+	} else if err := checkGateway(gw); err != nil {
+		problems = append(problems, fmt.Sprintf("gateway %s doesn't respond: %v", gw, err))
+	}
+	for _, nameserver := range info.DNS.Nameservers {
+		ip := net.ParseIP(nameserver)
+		if ip == nil || ip.To4() == nil {
+			continue
+		}
+		if err := checkDNSServer(&net.UDPAddr{IP: ip, Port: dnsPort}); err != nil {
+			problems = append(problems, fmt.Sprintf("DNS server %s is not reachable: %v", nameserver, err))
+		}
+	}
+	return problems
+}
+
+func findIPv4Gateway(info *cnicurrent.Result) net.IP {
+	for _, ipConfig := range info.IPs {
+		if ipConfig.Version == "4" && ipConfig.Gateway != nil {
+			return ipConfig.Gateway
+		}
+	}
+	for _, route := range info.Routes {
+		if route.GW != nil && route.GW.To4() != nil {
+			return route.GW
+		}
+	}
+	return nil
+}
+
+// checkGateway verifies that the gateway responds to ICMP echo
+// requests or at least to ARP requests, as some gateways, like the
+// link-local one used by Calico, only do the latter.
+func checkGateway(gw net.IP) error {
+	pingErr := ping(gw)
+	if pingErr == nil {
+		return nil
+	}
+	neighs, err := netlink.NeighList(0, netlink.FAMILY_V4)
+	if err != nil {
+		return pingErr
+	}
+	for _, n := range neighs {
+		if n.IP.Equal(gw) && len(n.HardwareAddr) != 0 &&
+			n.State&(netlink.NUD_REACHABLE|netlink.NUD_STALE|netlink.NUD_DELAY|netlink.NUD_PROBE|netlink.NUD_PERMANENT) != 0 {
+			return nil
+		}
+	}
+	return pingErr
+}
+
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 != 0 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+func makeICMPEchoRequest(id, seq uint16) []byte {
+	msg := make([]byte, 8)
+	msg[0] = icmpEchoRequest
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	binary.BigEndian.PutUint16(msg[2:], icmpChecksum(msg))
+	return msg
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+func ping(ip net.IP) error {
+	conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return fmt.Errorf("can't open ICMP socket: %v", err)
+	}
+	defer conn.Close()
+	id := uint16(os.Getpid())
+	buf := make([]byte, 1500)
+	for seq := uint16(1); seq <= selfTestAttempts; seq++ {
+		if _, err := conn.WriteTo(makeICMPEchoRequest(id, seq), &net.IPAddr{IP: ip}); err != nil {
+			return fmt.Errorf("can't send ICMP echo request: %v", err)
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(selfTestTimeout)); err != nil {
+			return err
+		}
+		for {
+			// for ip4 sockets, the IP header is stripped by Go
+			n, peer, err := conn.ReadFrom(buf)
+			if isTimeout(err) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("error receiving ICMP echo reply: %v", err)
+			}
+			if addr, ok := peer.(*net.IPAddr); ok && addr.IP.Equal(ip) && n >= 8 &&
+				buf[0] == icmpEchoReply &&
+				binary.BigEndian.Uint16(buf[4:]) == id &&
+				binary.BigEndian.Uint16(buf[6:]) == seq {
+				return nil
+			}
+		}
+	}
+	return errors.New("no reply to ICMP echo requests")
+}
+
+func makeDNSQuery(id uint16) []byte {
+	msg := make([]byte, 17)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	// recursion desired
+	binary.BigEndian.PutUint16(msg[2:], 0x0100)
+	// a single question
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	// msg[12] is zero, which denotes the root domain name
+	binary.BigEndian.PutUint16(msg[13:], dnsTypeNS)
+	binary.BigEndian.PutUint16(msg[15:], dnsClassIN)
+	return msg
+}
+
+// checkDNSServer verifies that the DNS server replies to a query.
+// Any reply, including an error one, is considered to be fine as
+// the purpose of the check is verifying reachability of the server.
+func checkDNSServer(addr *net.UDPAddr) error {
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	id := uint16(rand.Uint32())
+	query := makeDNSQuery(id)
+	buf := make([]byte, 512)
+	for attempt := 0; attempt < selfTestAttempts; attempt++ {
+		if _, err := conn.Write(query); err != nil {
+			return err
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(selfTestTimeout)); err != nil {
+			return err
+		}
+		for {
+			n, err := conn.Read(buf)
+			if isTimeout(err) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			// check the id and the response flag
+			if n >= 12 && binary.BigEndian.Uint16(buf) == id && buf[2]&0x80 != 0 {
+				return nil
+			}
+		}
+	}
+	return errors.New("no reply to DNS queries")
+}
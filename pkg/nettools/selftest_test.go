@@ -0,0 +1,115 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nettools
+
+import (
+	"net"
+	"testing"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	cnicurrent "github.com/containernetworking/cni/pkg/types/current"
+)
+
+func TestICMPEchoRequest(t *testing.T) {
+	msg := makeICMPEchoRequest(0x1234, 1)
+	expected := []byte{8, 0, 0xe5, 0xca, 0x12, 0x34, 0, 1}
+	if string(msg) != string(expected) {
+		t.Errorf("bad ICMP echo request: %#v instead of %#v", msg, expected)
+	}
+	if icmpChecksum(msg) != 0 {
+		t.Errorf("bad ICMP checksum")
+	}
+}
+
+func TestFindIPv4Gateway(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		result *cnicurrent.Result
+		gw     string
+	}{
+		{
+			name: "gateway in ip config",
+			result: &cnicurrent.Result{
+				IPs: []*cnicurrent.IPConfig{
+					{Version: "4", Gateway: net.ParseIP("10.1.90.1")},
+				},
+			},
+			gw: "10.1.90.1",
+		},
+		{
+			name: "gateway in routes",
+			result: &cnicurrent.Result{
+				IPs: []*cnicurrent.IPConfig{{Version: "4"}},
+				Routes: []*cnitypes.Route{
+					{GW: net.ParseIP("169.254.1.1")},
+				},
+			},
+			gw: "169.254.1.1",
+		},
+		{
+			name:   "no gateway",
+			result: &cnicurrent.Result{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gw := findIPv4Gateway(tc.result)
+			switch {
+			case tc.gw == "" && gw != nil:
+				t.Errorf("unexpected gateway %s", gw)
+			case tc.gw != "" && !gw.Equal(net.ParseIP(tc.gw)):
+				t.Errorf("bad gateway %s instead of %s", gw, tc.gw)
+			}
+		})
+	}
+}
+
+func TestCheckDNSServer(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP(): %v", err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n < 12 {
+				continue
+			}
+			// reply with REFUSED
+			buf[2] |= 0x80
+			buf[3] = 5
+			conn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+
+	if err := checkDNSServer(conn.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Errorf("checkDNSServer(): %v", err)
+	}
+
+	silent, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP(): %v", err)
+	}
+	defer silent.Close()
+	if err := checkDNSServer(silent.LocalAddr().(*net.UDPAddr)); err == nil {
+		t.Errorf("checkDNSServer() didn't fail for a server that doesn't reply")
+	}
+}
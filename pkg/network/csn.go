@@ -58,6 +58,19 @@ type InterfaceDescription struct {
 	VlanID int
 }
 
+// BootOptions specifies the network boot settings that are passed
+// to the VM by the DHCP server.
+type BootOptions struct {
+	// NextServer specifies the address of the TFTP server to load
+	// the boot file from.
+	NextServer string `json:"nextServer,omitempty"`
+	// BootFilename specifies the name of the boot file to load.
+	BootFilename string `json:"bootFilename,omitempty"`
+	// IPXEScriptURL specifies the URL of the script to be used by
+	// iPXE clients instead of the boot file.
+	IPXEScriptURL string `json:"ipxeScriptURL,omitempty"`
+}
+
 // ContainerSideNetwork struct describes the container (VM) network
 // namespace properties.
 type ContainerSideNetwork struct {
@@ -68,4 +81,7 @@ type ContainerSideNetwork struct {
 	// Interfaces contains a list of interfaces with data needed
 	// to configure them.
 	Interfaces []*InterfaceDescription
+	// SelfTestProblems lists the problems found by the network
+	// self-test performed upon the setup of the pod network.
+	SelfTestProblems []string `json:",omitempty"`
 }
@@ -0,0 +1,559 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package simulator provides a no-op virtualization backend which is
+// used instead of libvirt and CNI for the scale testing of Virtlet.
+// The simulated VMs start instantly and report fake stats, so the
+// behavior of Virtlet manager and the metadata store can be examined
+// with thousands of pods per node without the hardware.
+package simulator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+const (
+	// simulatedCPULoad is the fraction of the vCPU time which
+	// is reported as used by the simulated VMs
+	simulatedCPULoad = 0.1
+	// simulatedMemoryUsage is the fraction of the VM memory which
+	// is reported as used by the simulated VMs
+	simulatedMemoryUsage = 0.5
+	// simulatedDiskBytesPerSecond is the number of bytes reported
+	// to be read and written per second for each disk
+	simulatedDiskBytesPerSecond = 64 * 1024
+	// simulatedIORequestSize is the size of a simulated disk
+	// request in bytes
+	simulatedIORequestSize = 4096
+	// simulatedHypervisorVersion is the hypervisor version
+	// reported by the simulated connection, i.e. QEMU 4.2.0
+	simulatedHypervisorVersion = 4002000
+)
+
+var capacityUnits = map[string]uint64{
+	"":      1024, // libvirt defaults to KiB
+	"b":     1,
+	"bytes": 1,
+	"KB":    1000,
+	"k":     1024,
+	"KiB":   1024,
+	"MB":    1000000,
+	"M":     1048576,
+	"MiB":   1048576,
+	"GB":    1000000000,
+	"G":     1073741824,
+	"GiB":   1073741824,
+	"TB":    1000000000000,
+	"T":     1099511627776,
+	"TiB":   1099511627776,
+}
+
+// Connection is a simulated libvirt connection. It implements both
+// DomainConnection and StorageConnection interfaces. Unlike the fakes
+// used in the unit tests, it's safe for concurrent use.
+type Connection struct {
+	sync.Mutex
+	clock         clockwork.Clock
+	domains       map[string]*Domain
+	domainsByUUID map[string]*Domain
+	secrets       map[string]*Secret
+	pools         map[string]*StoragePool
+}
+
+var _ virt.DomainConnection = &Connection{}
+var _ virt.StorageConnection = &Connection{}
+
+// NewConnection creates a new simulated libvirt connection. If clock
+// is nil, the real clock is used.
+func NewConnection(clock clockwork.Clock) *Connection {
+	if clock == nil {
+		clock = clockwork.NewRealClock()
+	}
+	return &Connection{
+		clock:         clock,
+		domains:       make(map[string]*Domain),
+		domainsByUUID: make(map[string]*Domain),
+		secrets:       make(map[string]*Secret),
+		pools:         make(map[string]*StoragePool),
+	}
+}
+
+// DefineDomain implements DefineDomain method of DomainConnection interface.
+func (c *Connection) DefineDomain(def *libvirtxml.Domain) (virt.Domain, error) {
+	switch {
+	case def.Name == "":
+		return nil, errors.New("domain name cannot be empty")
+	case def.UUID == "":
+		return nil, fmt.Errorf("domain %q has empty uuid", def.Name)
+	}
+	c.Lock()
+	defer c.Unlock()
+	if d, found := c.domains[def.Name]; found {
+		if d.def.UUID != def.UUID {
+			return nil, fmt.Errorf("domain %q already defined", def.Name)
+		}
+		// like libvirt, update the definition of the existing
+		// domain, which takes effect upon its next start
+		d.def = copyDomain(def)
+		return d, nil
+	}
+	d := &Domain{
+		conn:  c,
+		def:   copyDomain(def),
+		state: virt.DomainStateShutoff,
+	}
+	c.domains[def.Name] = d
+	c.domainsByUUID[def.UUID] = d
+	return d, nil
+}
+
+// ListDomains implements ListDomains method of DomainConnection interface.
+func (c *Connection) ListDomains() ([]virt.Domain, error) {
+	c.Lock()
+	defer c.Unlock()
+	names := make([]string, 0, len(c.domains))
+	for name := range c.domains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	r := make([]virt.Domain, len(names))
+	for n, name := range names {
+		r[n] = c.domains[name]
+	}
+	return r, nil
+}
+
+// LookupDomainByName implements LookupDomainByName method of DomainConnection interface.
+func (c *Connection) LookupDomainByName(name string) (virt.Domain, error) {
+	c.Lock()
+	defer c.Unlock()
+	if d, found := c.domains[name]; found {
+		return d, nil
+	}
+	return nil, virt.ErrDomainNotFound
+}
+
+// LookupDomainByUUIDString implements LookupDomainByUUIDString method of DomainConnection interface.
+func (c *Connection) LookupDomainByUUIDString(uuid string) (virt.Domain, error) {
+	c.Lock()
+	defer c.Unlock()
+	if d, found := c.domainsByUUID[uuid]; found {
+		return d, nil
+	}
+	return nil, virt.ErrDomainNotFound
+}
+
+// DefineSecret implements DefineSecret method of DomainConnection interface.
+func (c *Connection) DefineSecret(def *libvirtxml.Secret) (virt.Secret, error) {
+	if def.UUID == "" {
+		return nil, errors.New("the secret has empty uuid")
+	}
+	if def.Usage == nil || def.Usage.Name == "" {
+		return nil, errors.New("the secret has empty usage name")
+	}
+	c.Lock()
+	defer c.Unlock()
+	s := &Secret{conn: c, uuid: def.UUID, usageName: def.Usage.Name}
+	c.secrets[def.Usage.Name] = s
+	return s, nil
+}
+
+// LookupSecretByUUIDString implements LookupSecretByUUIDString method of DomainConnection interface.
+func (c *Connection) LookupSecretByUUIDString(uuid string) (virt.Secret, error) {
+	c.Lock()
+	defer c.Unlock()
+	for _, s := range c.secrets {
+		if s.uuid == uuid {
+			return s, nil
+		}
+	}
+	return nil, virt.ErrSecretNotFound
+}
+
+// LookupSecretByUsageName implements LookupSecretByUsageName method of DomainConnection interface.
+func (c *Connection) LookupSecretByUsageName(usageType string, usageName string) (virt.Secret, error) {
+	c.Lock()
+	defer c.Unlock()
+	if s, found := c.secrets[usageName]; found {
+		return s, nil
+	}
+	return nil, virt.ErrSecretNotFound
+}
+
+// HypervisorVersion implements HypervisorVersion method of DomainConnection interface.
+func (c *Connection) HypervisorVersion() (uint32, error) {
+	return simulatedHypervisorVersion, nil
+}
+
+// Domain is a simulated VM. Its methods lock the connection as
+// the domain can be undefined concurrently.
+type Domain struct {
+	conn      *Connection
+	def       *libvirtxml.Domain
+	state     virt.DomainState
+	removed   bool
+	startTime time.Time
+	// cpuTime and ioTime accumulate the simulated usage for the
+	// previous runs of the domain
+	cpuTime time.Duration
+	ioTime  time.Duration
+}
+
+var _ virt.Domain = &Domain{}
+
+// uptime returns the time the domain has been running since it
+// was started last time. Must be called with the connection locked.
+func (d *Domain) uptime() time.Duration {
+	if d.state != virt.DomainStateRunning {
+		return 0
+	}
+	return d.conn.clock.Since(d.startTime)
+}
+
+func (d *Domain) stop() {
+	if d.state == virt.DomainStateRunning {
+		uptime := d.uptime()
+		d.cpuTime += uptime
+		d.ioTime += uptime
+	}
+	d.state = virt.DomainStateShutoff
+}
+
+func (d *Domain) checkRemoved(op string) error {
+	if d.removed {
+		return fmt.Errorf("%s called on a removed (undefined) domain %q", op, d.def.Name)
+	}
+	return nil
+}
+
+// Create implements Create method of Domain interface.
+func (d *Domain) Create() error {
+	d.conn.Lock()
+	defer d.conn.Unlock()
+	if err := d.checkRemoved("Create()"); err != nil {
+		return err
+	}
+	if d.state != virt.DomainStateShutoff {
+		return fmt.Errorf("domain %q is already running", d.def.Name)
+	}
+	d.state = virt.DomainStateRunning
+	d.startTime = d.conn.clock.Now()
+	return nil
+}
+
+// Destroy implements Destroy method of Domain interface.
+func (d *Domain) Destroy() error {
+	d.conn.Lock()
+	defer d.conn.Unlock()
+	if err := d.checkRemoved("Destroy()"); err != nil {
+		return err
+	}
+	d.stop()
+	return nil
+}
+
+// Undefine implements Undefine method of Domain interface.
+func (d *Domain) Undefine() error {
+	d.conn.Lock()
+	defer d.conn.Unlock()
+	if err := d.checkRemoved("Undefine()"); err != nil {
+		return err
+	}
+	d.removed = true
+	delete(d.conn.domains, d.def.Name)
+	delete(d.conn.domainsByUUID, d.def.UUID)
+	return nil
+}
+
+// Shutdown implements Shutdown method of Domain interface.
+// The simulated VMs shut down instantly.
+func (d *Domain) Shutdown() error {
+	return d.Destroy()
+}
+
+// Reboot implements Reboot method of Domain interface.
+func (d *Domain) Reboot() error {
+	d.conn.Lock()
+	defer d.conn.Unlock()
+	if err := d.checkRemoved("Reboot()"); err != nil {
+		return err
+	}
+	if d.state != virt.DomainStateRunning {
+		return fmt.Errorf("domain %q is not running", d.def.Name)
+	}
+	return nil
+}
+
+// Reset implements Reset method of Domain interface.
+func (d *Domain) Reset() error {
+	d.conn.Lock()
+	defer d.conn.Unlock()
+	if err := d.checkRemoved("Reset()"); err != nil {
+		return err
+	}
+	if d.state == virt.DomainStateShutoff {
+		return fmt.Errorf("domain %q is not active", d.def.Name)
+	}
+	d.state = virt.DomainStateRunning
+	d.startTime = d.conn.clock.Now()
+	return nil
+}
+
+// State implements State method of Domain interface.
+func (d *Domain) State() (virt.DomainState, error) {
+	d.conn.Lock()
+	defer d.conn.Unlock()
+	if err := d.checkRemoved("State()"); err != nil {
+		return virt.DomainStateNoState, err
+	}
+	return d.state, nil
+}
+
+// UUIDString implements UUIDString method of Domain interface.
+func (d *Domain) UUIDString() (string, error) {
+	return d.def.UUID, nil
+}
+
+// Name implements Name method of Domain interface.
+func (d *Domain) Name() (string, error) {
+	return d.def.Name, nil
+}
+
+// XML implements XML method of Domain interface.
+func (d *Domain) XML() (*libvirtxml.Domain, error) {
+	d.conn.Lock()
+	defer d.conn.Unlock()
+	return copyDomain(d.def), nil
+}
+
+func (d *Domain) memorySize() uint64 {
+	if d.def.Memory == nil {
+		return 0
+	}
+	return uint64(d.def.Memory.Value) * capacityUnits[d.def.Memory.Unit]
+}
+
+func (d *Domain) vcpuCount() int {
+	if d.def.VCPU == nil {
+		return 1
+	}
+	if n, err := strconv.Atoi(d.def.VCPU.Current); err == nil && n > 0 {
+		return n
+	}
+	return d.def.VCPU.Value
+}
+
+// GetRSS implements GetRSS method of Domain interface.
+func (d *Domain) GetRSS() (uint64, error) {
+	d.conn.Lock()
+	defer d.conn.Unlock()
+	if d.state != virt.DomainStateRunning {
+		return 0, nil
+	}
+	return uint64(float64(d.memorySize()) * simulatedMemoryUsage), nil
+}
+
+// GetMemoryStats implements GetMemoryStats method of Domain interface.
+func (d *Domain) GetMemoryStats() (*virt.MemoryStats, error) {
+	d.conn.Lock()
+	defer d.conn.Unlock()
+	if d.state != virt.DomainStateRunning {
+		return &virt.MemoryStats{}, nil
+	}
+	size := d.memorySize()
+	used := uint64(float64(size) * simulatedMemoryUsage)
+	return &virt.MemoryStats{
+		RSS:        used,
+		Actual:     size,
+		Available:  size,
+		Unused:     size - used,
+		Usable:     size - used,
+		LastUpdate: d.conn.clock.Now().Unix(),
+	}, nil
+}
+
+// GetCPUTime implements GetCPUTime method of Domain interface.
+func (d *Domain) GetCPUTime() (uint64, error) {
+	d.conn.Lock()
+	defer d.conn.Unlock()
+	total := d.cpuTime + d.uptime()
+	return uint64(float64(total.Nanoseconds()) * float64(d.vcpuCount()) * simulatedCPULoad), nil
+}
+
+// GetBlockStats implements GetBlockStats method of Domain interface.
+func (d *Domain) GetBlockStats(dev string) (*virt.BlockStats, error) {
+	d.conn.Lock()
+	defer d.conn.Unlock()
+	found := false
+	if d.def.Devices != nil {
+		for _, disk := range d.def.Devices.Disks {
+			if disk.Target != nil && disk.Target.Dev == dev {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("disk %q not found in domain %q", dev, d.def.Name)
+	}
+	ioTime := d.ioTime + d.uptime()
+	bytes := int64(ioTime.Seconds() * simulatedDiskBytesPerSecond)
+	requests := bytes / simulatedIORequestSize
+	return &virt.BlockStats{
+		ReadRequests:  requests,
+		ReadBytes:     bytes,
+		WriteRequests: requests,
+		WriteBytes:    bytes,
+	}, nil
+}
+
+// QemuAgentCommand implements QemuAgentCommand method of Domain interface.
+// The simulated guest agent reports successful completion for all of
+// the commands.
+func (d *Domain) QemuAgentCommand(command string, timeout time.Duration) (string, error) {
+	var cmd struct {
+		Execute string `json:"execute"`
+	}
+	if err := json.Unmarshal([]byte(command), &cmd); err != nil {
+		return "", fmt.Errorf("bad guest agent command %q: %v", command, err)
+	}
+	d.conn.Lock()
+	defer d.conn.Unlock()
+	if d.state != virt.DomainStateRunning {
+		return "", fmt.Errorf("domain %q is not running", d.def.Name)
+	}
+	switch cmd.Execute {
+	case "guest-exec":
+		return `{"return":{"pid":1}}`, nil
+	case "guest-exec-status":
+		return `{"return":{"exited":true,"exitcode":0}}`, nil
+	default:
+		return `{"return":{}}`, nil
+	}
+}
+
+// Screenshot implements Screenshot method of Domain interface.
+// It returns a black 1x1 image.
+func (d *Domain) Screenshot() ([]byte, string, error) {
+	d.conn.Lock()
+	defer d.conn.Unlock()
+	if d.state != virt.DomainStateRunning {
+		return nil, "", fmt.Errorf("domain %q is not running", d.def.Name)
+	}
+	return []byte("P6\n1 1\n255\n\x00\x00\x00"), "image/x-portable-pixmap", nil
+}
+
+// SetVCPUs implements SetVCPUs method of Domain interface.
+func (d *Domain) SetVCPUs(count int) error {
+	d.conn.Lock()
+	defer d.conn.Unlock()
+	if d.state != virt.DomainStateRunning {
+		return fmt.Errorf("domain %q is not running", d.def.Name)
+	}
+	if d.def.VCPU == nil || count < 1 || count > d.def.VCPU.Value {
+		return fmt.Errorf("bad vcpu count %d for domain %q", count, d.def.Name)
+	}
+	// the CPU time used so far is accounted with the old count
+	d.cpuTime = time.Duration(float64(d.cpuTime+d.uptime()) * float64(d.vcpuCount()) / float64(count))
+	d.startTime = d.conn.clock.Now()
+	d.def.VCPU.Current = strconv.Itoa(count)
+	return nil
+}
+
+// GetVCPUs implements GetVCPUs method of Domain interface.
+func (d *Domain) GetVCPUs() (int, error) {
+	d.conn.Lock()
+	defer d.conn.Unlock()
+	if d.state != virt.DomainStateRunning {
+		return 0, fmt.Errorf("domain %q is not running", d.def.Name)
+	}
+	return d.vcpuCount(), nil
+}
+
+// CreateSnapshot implements CreateSnapshot method of Domain interface.
+// The simulated domains have no disk or memory contents, so only the
+// domain is checked.
+func (d *Domain) CreateSnapshot(name string) error {
+	d.conn.Lock()
+	defer d.conn.Unlock()
+	return d.checkRemoved("CreateSnapshot()")
+}
+
+// AttachDisk implements AttachDisk method of Domain interface.
+func (d *Domain) AttachDisk(disk *libvirtxml.DomainDisk) error {
+	d.conn.Lock()
+	defer d.conn.Unlock()
+	if err := d.checkRemoved("AttachDisk()"); err != nil {
+		return err
+	}
+	if d.state != virt.DomainStateRunning {
+		return fmt.Errorf("domain %q is not running", d.def.Name)
+	}
+	if d.def.Devices == nil {
+		d.def.Devices = &libvirtxml.DomainDeviceList{}
+	}
+	d.def.Devices.Disks = append(d.def.Devices.Disks, *disk)
+	return nil
+}
+
+// Secret is a simulated libvirt secret.
+type Secret struct {
+	conn      *Connection
+	uuid      string
+	usageName string
+}
+
+var _ virt.Secret = &Secret{}
+
+// SetValue implements SetValue method of Secret interface.
+func (s *Secret) SetValue(value []byte) error {
+	return nil
+}
+
+// Remove implements Remove method of Secret interface.
+func (s *Secret) Remove() error {
+	s.conn.Lock()
+	defer s.conn.Unlock()
+	if s.conn.secrets[s.usageName] == s {
+		delete(s.conn.secrets, s.usageName)
+	}
+	return nil
+}
+
+func copyDomain(def *libvirtxml.Domain) *libvirtxml.Domain {
+	s, err := def.Marshal()
+	if err != nil {
+		// the definitions are produced by Virtlet itself,
+		// so they can always be marshalled
+		panic(fmt.Sprintf("can't marshal the domain definition: %v", err))
+	}
+	var r libvirtxml.Domain
+	if err := r.Unmarshal(s); err != nil {
+		panic(fmt.Sprintf("can't unmarshal the domain definition: %v", err))
+	}
+	return &r
+}
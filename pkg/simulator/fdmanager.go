@@ -0,0 +1,331 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	cnicurrent "github.com/containernetworking/cni/pkg/types/current"
+
+	"github.com/Mirantis/virtlet/pkg/cni"
+	"github.com/Mirantis/virtlet/pkg/dhcp"
+	"github.com/Mirantis/virtlet/pkg/network"
+	"github.com/Mirantis/virtlet/pkg/tapmanager"
+)
+
+const (
+	// simulatedPacketsPerSecond is the number of packets reported
+	// to be received and sent per second for each pod
+	simulatedPacketsPerSecond = 10
+	// simulatedPacketSize is the size of a simulated packet in bytes
+	simulatedPacketSize = 512
+	// simulatedNetworkName is the CNI network name reported for
+	// the simulated pod networks
+	simulatedNetworkName = "simulated"
+	// maxSimulatedAddresses is the size of the simulated
+	// pod network, 10.200.0.0/16, minus the network address,
+	// the gateway and the broadcast address
+	maxSimulatedAddresses = 65536 - 3
+)
+
+var simulatedGateway = net.IP{10, 200, 0, 1}
+
+type simulatedPodNetwork struct {
+	index   int
+	mac     string
+	added   int64
+	podID   string
+	guestIP string
+}
+
+// FDManager is a simulated FDManager which provides pod networks
+// without invoking CNI plugins or creating any tap devices.
+type FDManager struct {
+	sync.Mutex
+	conn      *Connection
+	pods      map[string]*simulatedPodNetwork
+	usedIndex map[int]bool
+	nextIndex int
+}
+
+var _ tapmanager.FDManager = &FDManager{}
+
+// NewFDManager creates a new simulated FDManager. The connection is
+// used to get the clock for the fake network stats.
+func NewFDManager(conn *Connection) *FDManager {
+	return &FDManager{
+		conn:      conn,
+		pods:      make(map[string]*simulatedPodNetwork),
+		usedIndex: make(map[int]bool),
+	}
+}
+
+func simulatedAddress(index int) net.IP {
+	n := index + 2
+	return net.IP{10, 200, byte(n >> 8), byte(n)}
+}
+
+func simulatedMac(index int) string {
+	return fmt.Sprintf("42:a4:a6:00:%02x:%02x", byte(index>>8), byte(index))
+}
+
+// allocIndex allocates an index for the address of the pod.
+// Must be called with the FDManager locked.
+func (m *FDManager) allocIndex() (int, error) {
+	if len(m.usedIndex) >= maxSimulatedAddresses {
+		return 0, fmt.Errorf("simulated pod network address space exhausted")
+	}
+	for m.usedIndex[m.nextIndex] {
+		m.nextIndex = (m.nextIndex + 1) % maxSimulatedAddresses
+	}
+	index := m.nextIndex
+	m.usedIndex[index] = true
+	m.nextIndex = (m.nextIndex + 1) % maxSimulatedAddresses
+	return index, nil
+}
+
+func (m *FDManager) containerSideNetwork(pn *simulatedPodNetwork) (*network.ContainerSideNetwork, error) {
+	hwAddr, err := net.ParseMAC(pn.mac)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing hwaddr %q: %v", pn.mac, err)
+	}
+	nsPath := cni.PodNetNSPath(pn.podID)
+	return &network.ContainerSideNetwork{
+		Result: &cnicurrent.Result{
+			Interfaces: []*cnicurrent.Interface{
+				{
+					Name:    "eth0",
+					Mac:     pn.mac,
+					Sandbox: nsPath,
+				},
+			},
+			IPs: []*cnicurrent.IPConfig{
+				{
+					Version:   "4",
+					Interface: 0,
+					Address: net.IPNet{
+						IP:   simulatedAddress(pn.index),
+						Mask: net.IPMask{255, 255, 0, 0},
+					},
+					Gateway: simulatedGateway,
+				},
+			},
+			Routes: []*cnitypes.Route{
+				{
+					Dst: net.IPNet{
+						IP:   net.IP{0, 0, 0, 0},
+						Mask: net.IPMask{0, 0, 0, 0},
+					},
+					GW: simulatedGateway,
+				},
+			},
+		},
+		NsPath: nsPath,
+		Interfaces: []*network.InterfaceDescription{
+			{
+				Type:         network.InterfaceTypeTap,
+				HardwareAddr: hwAddr,
+			},
+		},
+	}, nil
+}
+
+// AddFDs implements AddFDs method of FDManager interface.
+func (m *FDManager) AddFDs(key string, data interface{}) ([]byte, error) {
+	payload, ok := data.(*tapmanager.GetFDPayload)
+	if !ok || payload.Description == nil {
+		return nil, fmt.Errorf("AddFDs(): bad data: %#v", data)
+	}
+	m.Lock()
+	defer m.Unlock()
+	if _, found := m.pods[key]; found {
+		return nil, fmt.Errorf("duplicate key: %q", key)
+	}
+	index, err := m.allocIndex()
+	if err != nil {
+		return nil, err
+	}
+	pn := &simulatedPodNetwork{
+		index: index,
+		mac:   simulatedMac(index),
+		added: m.conn.clock.Now().Unix(),
+		podID: payload.Description.PodID,
+	}
+	if payload.Description.DetectGuestAddresses {
+		pn.guestIP = simulatedAddress(index).String()
+	}
+	csn, err := m.containerSideNetwork(pn)
+	if err != nil {
+		delete(m.usedIndex, index)
+		return nil, err
+	}
+	respData, err := json.Marshal(csn)
+	if err != nil {
+		delete(m.usedIndex, index)
+		return nil, fmt.Errorf("error marshalling net config: %v", err)
+	}
+	m.pods[key] = pn
+	return respData, nil
+}
+
+// ReleaseFDs implements ReleaseFDs method of FDManager interface.
+func (m *FDManager) ReleaseFDs(key string) error {
+	m.Lock()
+	defer m.Unlock()
+	pn, found := m.pods[key]
+	if !found {
+		return fmt.Errorf("key not found: %q", key)
+	}
+	delete(m.usedIndex, pn.index)
+	delete(m.pods, key)
+	return nil
+}
+
+// recoveredIndex returns the address index used by the recovered
+// pod network, or -1 if it can't be reused.
+// Must be called with the FDManager locked.
+func (m *FDManager) recoveredIndex(csn *network.ContainerSideNetwork) int {
+	if csn == nil || csn.Result == nil || len(csn.Result.IPs) == 0 {
+		return -1
+	}
+	ip := csn.Result.IPs[0].Address.IP.To4()
+	if ip == nil || ip[0] != 10 || ip[1] != 200 {
+		return -1
+	}
+	index := (int(ip[2])<<8 | int(ip[3])) - 2
+	if index < 0 || index >= maxSimulatedAddresses || m.usedIndex[index] {
+		return -1
+	}
+	return index
+}
+
+// Recover implements Recover method of FDManager interface.
+// The address of the recovered pod network is kept if possible.
+func (m *FDManager) Recover(key string, data interface{}) error {
+	payload, ok := data.(*tapmanager.RecoverPayload)
+	if !ok || payload.Description == nil {
+		return fmt.Errorf("Recover(): bad data: %#v", data)
+	}
+	m.Lock()
+	defer m.Unlock()
+	if _, found := m.pods[key]; found {
+		return fmt.Errorf("duplicate key: %q", key)
+	}
+	index := m.recoveredIndex(payload.ContainerSideNetwork)
+	if index >= 0 {
+		m.usedIndex[index] = true
+	} else {
+		var err error
+		if index, err = m.allocIndex(); err != nil {
+			return err
+		}
+	}
+	m.pods[key] = &simulatedPodNetwork{
+		index: index,
+		mac:   simulatedMac(index),
+		added: m.conn.clock.Now().Unix(),
+		podID: payload.Description.PodID,
+	}
+	return nil
+}
+
+// GetStats implements GetStats method of FDManager interface.
+func (m *FDManager) GetStats(key string) ([]byte, error) {
+	m.Lock()
+	defer m.Unlock()
+	pn, found := m.pods[key]
+	if !found {
+		return nil, fmt.Errorf("key not found: %q", key)
+	}
+	packets := uint64(m.conn.clock.Now().Unix()-pn.added) * simulatedPacketsPerSecond
+	return json.Marshal([]tapmanager.InterfaceStats{
+		{
+			Name:      "eth0",
+			Network:   simulatedNetworkName,
+			Mac:       pn.mac,
+			RxBytes:   packets * simulatedPacketSize,
+			RxPackets: packets,
+			TxBytes:   packets * simulatedPacketSize,
+			TxPackets: packets,
+		},
+	})
+}
+
+// GetTraces implements GetTraces method of FDManager interface.
+// No traces are recorded for the simulated pod networks.
+func (m *FDManager) GetTraces(key string) ([]byte, error) {
+	return json.Marshal([]tapmanager.NetworkTrace{})
+}
+
+// GetGuestAddresses implements GetGuestAddresses method of FDManager interface.
+func (m *FDManager) GetGuestAddresses(key string) ([]byte, error) {
+	m.Lock()
+	defer m.Unlock()
+	pn, found := m.pods[key]
+	if !found {
+		return nil, fmt.Errorf("key not found: %q", key)
+	}
+	addrs := []tapmanager.GuestAddresses{}
+	if pn.guestIP != "" {
+		addrs = append(addrs, tapmanager.GuestAddresses{
+			Name: "eth0",
+			Mac:  pn.mac,
+			IPs:  []string{pn.guestIP},
+		})
+	}
+	return json.Marshal(addrs)
+}
+
+// GetNetworkInfo implements GetNetworkInfo method of FDManager interface.
+// The simulated VM is always considered to have acquired its address
+// via DHCP at the time its pod network was added.
+func (m *FDManager) GetNetworkInfo(key string) ([]byte, error) {
+	m.Lock()
+	defer m.Unlock()
+	pn, found := m.pods[key]
+	if !found {
+		return nil, fmt.Errorf("key not found: %q", key)
+	}
+	ip := simulatedAddress(pn.index).String()
+	info := tapmanager.PodNetworkInfo{
+		Leases: []dhcp.Lease{
+			{
+				HardwareAddr: pn.mac,
+				IP:           ip,
+				State:        dhcp.LeaseStateAcked,
+				Discovers:    1,
+				Requests:     1,
+				Updated:      time.Unix(pn.added, 0).UTC(),
+			},
+		},
+		Neighbors: []tapmanager.NeighborInfo{
+			{
+				Link:  "br0",
+				IP:    ip,
+				Mac:   pn.mac,
+				State: "REACHABLE",
+			},
+		},
+		Routes: []tapmanager.RouteInfo{},
+	}
+	return json.Marshal(info)
+}
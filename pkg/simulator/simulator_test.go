@@ -0,0 +1,211 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/network"
+	"github.com/Mirantis/virtlet/pkg/tapmanager"
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+func testDomainDef(n int) *libvirtxml.Domain {
+	return &libvirtxml.Domain{
+		Name:   fmt.Sprintf("virtlet-%d", n),
+		UUID:   fmt.Sprintf("00000000-0000-0000-0000-%012d", n),
+		Memory: &libvirtxml.DomainMemory{Value: 1024, Unit: "MiB"},
+		VCPU:   &libvirtxml.DomainVCPU{Value: 2},
+		Devices: &libvirtxml.DomainDeviceList{
+			Disks: []libvirtxml.DomainDisk{
+				{Target: &libvirtxml.DomainDiskTarget{Dev: "sda"}},
+			},
+		},
+	}
+}
+
+func TestSimulatedDomains(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC))
+	conn := NewConnection(clock)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			d, err := conn.DefineDomain(testDomainDef(n))
+			if err != nil {
+				t.Errorf("DefineDomain(): %v", err)
+				return
+			}
+			if err := d.Create(); err != nil {
+				t.Errorf("Create(): %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	domains, err := conn.ListDomains()
+	if err != nil {
+		t.Fatalf("ListDomains(): %v", err)
+	}
+	if len(domains) != 100 {
+		t.Fatalf("bad number of domains: %d", len(domains))
+	}
+	if _, err := conn.DefineDomain(testDomainDef(0)); err == nil {
+		t.Errorf("didn't get an error for a duplicate domain")
+	}
+
+	d, err := conn.LookupDomainByUUIDString(testDomainDef(42).UUID)
+	if err != nil {
+		t.Fatalf("LookupDomainByUUIDString(): %v", err)
+	}
+	if state, err := d.State(); err != nil {
+		t.Errorf("State(): %v", err)
+	} else if state != virt.DomainStateRunning {
+		t.Errorf("bad domain state %v", state)
+	}
+
+	clock.Advance(10 * time.Second)
+	if cpuTime, err := d.GetCPUTime(); err != nil {
+		t.Errorf("GetCPUTime(): %v", err)
+	} else if expected := uint64(2 * time.Second); cpuTime != expected {
+		t.Errorf("bad cpu time %d instead of %d", cpuTime, expected)
+	}
+	if rss, err := d.GetRSS(); err != nil {
+		t.Errorf("GetRSS(): %v", err)
+	} else if rss != 512*1048576 {
+		t.Errorf("bad rss %d", rss)
+	}
+	if stats, err := d.GetBlockStats("sda"); err != nil {
+		t.Errorf("GetBlockStats(): %v", err)
+	} else if stats.ReadBytes != 10*simulatedDiskBytesPerSecond || stats.WriteRequests == 0 {
+		t.Errorf("bad block stats: %#v", stats)
+	}
+	if _, err := d.GetBlockStats("sdz"); err == nil {
+		t.Errorf("didn't get an error for a nonexistent disk")
+	}
+	if r, err := d.QemuAgentCommand(`{"execute":"guest-ping"}`, time.Second); err != nil {
+		t.Errorf("QemuAgentCommand(): %v", err)
+	} else if r != `{"return":{}}` {
+		t.Errorf("bad guest agent response %q", r)
+	}
+
+	if err := d.Shutdown(); err != nil {
+		t.Fatalf("Shutdown(): %v", err)
+	}
+	clock.Advance(10 * time.Second)
+	if cpuTime, err := d.GetCPUTime(); err != nil {
+		t.Errorf("GetCPUTime(): %v", err)
+	} else if expected := uint64(2 * time.Second); cpuTime != expected {
+		t.Errorf("bad cpu time after shutdown %d instead of %d", cpuTime, expected)
+	}
+	if err := d.Undefine(); err != nil {
+		t.Fatalf("Undefine(): %v", err)
+	}
+	if _, err := conn.LookupDomainByName(testDomainDef(42).Name); err != virt.ErrDomainNotFound {
+		t.Errorf("expected ErrDomainNotFound, got %v", err)
+	}
+	if _, err := d.State(); err == nil {
+		t.Errorf("didn't get an error for a removed domain")
+	}
+}
+
+func TestSimulatedStorage(t *testing.T) {
+	conn := NewConnection(nil)
+	pool, err := conn.CreateStoragePool(&libvirtxml.StoragePool{
+		Name:   "volumes",
+		Target: &libvirtxml.StoragePoolTarget{Path: "/var/lib/virtlet/volumes"},
+	})
+	if err != nil {
+		t.Fatalf("CreateStoragePool(): %v", err)
+	}
+	if _, err := conn.LookupStoragePoolByName("images"); err != virt.ErrStoragePoolNotFound {
+		t.Errorf("expected ErrStoragePoolNotFound, got %v", err)
+	}
+	vol, err := pool.CreateStorageVol(&libvirtxml.StorageVolume{
+		Name:     "vol",
+		Capacity: &libvirtxml.StorageVolumeSize{Value: 10, Unit: "MiB"},
+	})
+	if err != nil {
+		t.Fatalf("CreateStorageVol(): %v", err)
+	}
+	if p, _ := vol.Path(); p != "/var/lib/virtlet/volumes/vol" {
+		t.Errorf("bad volume path %q", p)
+	}
+	if size, _ := vol.Size(); size != 10*1048576 {
+		t.Errorf("bad volume size %d", size)
+	}
+	if err := vol.Remove(); err != nil {
+		t.Fatalf("Remove(): %v", err)
+	}
+	if _, err := pool.LookupVolumeByName("vol"); err != virt.ErrStorageVolumeNotFound {
+		t.Errorf("expected ErrStorageVolumeNotFound, got %v", err)
+	}
+}
+
+func TestSimulatedFDManager(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	m := NewFDManager(NewConnection(clock))
+	var ips []string
+	for _, podID := range []string{"pod1", "pod2"} {
+		data, err := m.AddFDs(podID, &tapmanager.GetFDPayload{
+			Description: &tapmanager.PodNetworkDesc{PodID: podID},
+		})
+		if err != nil {
+			t.Fatalf("AddFDs(): %v", err)
+		}
+		var csn network.ContainerSideNetwork
+		if err := json.Unmarshal(data, &csn); err != nil {
+			t.Fatalf("error unmarshalling the container side network: %v", err)
+		}
+		ips = append(ips, csn.Result.IPs[0].Address.IP.String())
+	}
+	if ips[0] != "10.200.0.2" || ips[1] != "10.200.0.3" {
+		t.Errorf("bad pod addresses: %v", ips)
+	}
+
+	clock.Advance(10 * time.Second)
+	data, err := m.GetStats("pod1")
+	if err != nil {
+		t.Fatalf("GetStats(): %v", err)
+	}
+	var stats []tapmanager.InterfaceStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		t.Fatalf("error unmarshalling the stats: %v", err)
+	}
+	if len(stats) != 1 || stats[0].RxPackets != 10*simulatedPacketsPerSecond {
+		t.Errorf("bad stats: %#v", stats)
+	}
+
+	if err := m.ReleaseFDs("pod1"); err != nil {
+		t.Fatalf("ReleaseFDs(): %v", err)
+	}
+	if _, err := m.GetStats("pod1"); err == nil {
+		t.Errorf("didn't get an error for a released pod network")
+	}
+	if err := m.ReleaseFDs("pod1"); err == nil {
+		t.Errorf("didn't get an error for a duplicate ReleaseFDs() call")
+	}
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/Mirantis/virtlet/pkg/virt"
+)
+
+// CreateStoragePool implements CreateStoragePool method of StorageConnection interface.
+func (c *Connection) CreateStoragePool(def *libvirtxml.StoragePool) (virt.StoragePool, error) {
+	c.Lock()
+	defer c.Unlock()
+	if _, found := c.pools[def.Name]; found {
+		return nil, fmt.Errorf("storage pool %q already exists", def.Name)
+	}
+	poolPath := "/"
+	if def.Target != nil {
+		poolPath = def.Target.Path
+	}
+	p := &StoragePool{
+		conn:    c,
+		def:     def,
+		path:    poolPath,
+		volumes: make(map[string]*StorageVolume),
+	}
+	c.pools[def.Name] = p
+	return p, nil
+}
+
+// LookupStoragePoolByName implements LookupStoragePoolByName method of StorageConnection interface.
+func (c *Connection) LookupStoragePoolByName(name string) (virt.StoragePool, error) {
+	c.Lock()
+	defer c.Unlock()
+	if p, found := c.pools[name]; found {
+		return p, nil
+	}
+	return nil, virt.ErrStoragePoolNotFound
+}
+
+// ListPools implements ListPools method of StorageConnection interface.
+func (c *Connection) ListPools() ([]virt.StoragePool, error) {
+	c.Lock()
+	defer c.Unlock()
+	names := make([]string, 0, len(c.pools))
+	for name := range c.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	r := make([]virt.StoragePool, len(names))
+	for n, name := range names {
+		r[n] = c.pools[name]
+	}
+	return r, nil
+}
+
+// PutFiles implements PutFiles method of StorageConnection interface.
+// The simulated volumes have no contents, so it does nothing.
+func (c *Connection) PutFiles(imagePath string, files map[string][]byte) error {
+	return nil
+}
+
+// GrowRootFS implements GrowRootFS method of StorageConnection interface.
+// The simulated volumes have no contents, so it does nothing.
+func (c *Connection) GrowRootFS(imagePath string) error {
+	return nil
+}
+
+// StoragePool is a simulated storage pool.
+type StoragePool struct {
+	conn    *Connection
+	def     *libvirtxml.StoragePool
+	path    string
+	volumes map[string]*StorageVolume
+}
+
+var _ virt.StoragePool = &StoragePool{}
+
+// CreateStorageVol implements CreateStorageVol method of StoragePool interface.
+func (p *StoragePool) CreateStorageVol(def *libvirtxml.StorageVolume) (virt.StorageVolume, error) {
+	p.conn.Lock()
+	defer p.conn.Unlock()
+	if _, found := p.volumes[def.Name]; found {
+		return nil, fmt.Errorf("storage volume %q already exists in pool %q", def.Name, p.def.Name)
+	}
+	volPath := ""
+	if def.Target != nil {
+		volPath = def.Target.Path
+	}
+	if volPath == "" {
+		volPath = path.Join(p.path, def.Name)
+	}
+	v := &StorageVolume{
+		pool: p,
+		def:  def,
+		path: volPath,
+	}
+	if def.Capacity != nil {
+		coef, found := capacityUnits[def.Capacity.Unit]
+		if !found {
+			return nil, fmt.Errorf("bad capacity units: %q", def.Capacity.Unit)
+		}
+		v.size = def.Capacity.Value * coef
+	}
+	p.volumes[def.Name] = v
+	return v, nil
+}
+
+// ListVolumes implements ListVolumes method of StoragePool interface.
+func (p *StoragePool) ListVolumes() ([]virt.StorageVolume, error) {
+	p.conn.Lock()
+	defer p.conn.Unlock()
+	names := make([]string, 0, len(p.volumes))
+	for name := range p.volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	r := make([]virt.StorageVolume, len(names))
+	for n, name := range names {
+		r[n] = p.volumes[name]
+	}
+	return r, nil
+}
+
+// LookupVolumeByName implements LookupVolumeByName method of StoragePool interface.
+func (p *StoragePool) LookupVolumeByName(name string) (virt.StorageVolume, error) {
+	p.conn.Lock()
+	defer p.conn.Unlock()
+	if v, found := p.volumes[name]; found {
+		return v, nil
+	}
+	return nil, virt.ErrStorageVolumeNotFound
+}
+
+// RemoveVolumeByName implements RemoveVolumeByName method of StoragePool interface.
+func (p *StoragePool) RemoveVolumeByName(name string) error {
+	p.conn.Lock()
+	defer p.conn.Unlock()
+	if _, found := p.volumes[name]; !found {
+		return fmt.Errorf("storage volume %q not found in pool %q", name, p.def.Name)
+	}
+	delete(p.volumes, name)
+	return nil
+}
+
+// XML implements XML method of StoragePool interface.
+func (p *StoragePool) XML() (*libvirtxml.StoragePool, error) {
+	return p.def, nil
+}
+
+// StorageVolume is a simulated storage volume.
+type StorageVolume struct {
+	pool *StoragePool
+	def  *libvirtxml.StorageVolume
+	path string
+	size uint64
+}
+
+var _ virt.StorageVolume = &StorageVolume{}
+
+// Name implements Name method of StorageVolume interface.
+func (v *StorageVolume) Name() string {
+	return v.def.Name
+}
+
+// Size implements Size method of StorageVolume interface.
+func (v *StorageVolume) Size() (uint64, error) {
+	return v.size, nil
+}
+
+// Path implements Path method of StorageVolume interface.
+func (v *StorageVolume) Path() (string, error) {
+	return v.path, nil
+}
+
+// Remove implements Remove method of StorageVolume interface.
+func (v *StorageVolume) Remove() error {
+	return v.pool.RemoveVolumeByName(v.def.Name)
+}
+
+// Format implements Format method of StorageVolume interface.
+func (v *StorageVolume) Format() error {
+	return nil
+}
+
+// XML implements XML method of StorageVolume interface.
+func (v *StorageVolume) XML() (*libvirtxml.StorageVolume, error) {
+	return v.def, nil
+}
@@ -0,0 +1,334 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stream
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/Mirantis/virtlet/pkg/metadata"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const (
+	// ConsoleEndpointUnix denotes the console endpoints served
+	// on unix domain sockets
+	ConsoleEndpointUnix = "unix"
+	// ConsoleEndpointTCP denotes the console endpoints served
+	// on TCP ports of the loopback interface
+	ConsoleEndpointTCP = "tcp"
+
+	consoleEndpointAuthTimeout = 10 * time.Second
+	maxConsoleEndpointTokenLen = 1024
+	consoleEndpointSocketMode  = 0660
+)
+
+// consoleEndpoint exposes the serial console of a single VM.
+type consoleEndpoint struct {
+	sync.Mutex
+	// name is the name of the endpoint (namespace/pod)
+	name        string
+	containerID string
+	// address is the address of the endpoint as it's reported
+	// to the clients
+	address  string
+	listener net.Listener
+	conns    map[net.Conn]bool
+	closed   bool
+}
+
+func (ep *consoleEndpoint) track(conn net.Conn) bool {
+	ep.Lock()
+	defer ep.Unlock()
+	if ep.closed {
+		return false
+	}
+	ep.conns[conn] = true
+	return true
+}
+
+func (ep *consoleEndpoint) untrack(conn net.Conn) {
+	ep.Lock()
+	defer ep.Unlock()
+	delete(ep.conns, conn)
+}
+
+func (ep *consoleEndpoint) close() {
+	ep.Lock()
+	defer ep.Unlock()
+	if ep.closed {
+		return
+	}
+	ep.closed = true
+	ep.listener.Close()
+	for conn := range ep.conns {
+		conn.Close()
+	}
+}
+
+// consoleEndpoints manages the console endpoints of the VMs. The
+// endpoints are opened when the serial console of a VM connects to
+// the stream server and closed when the console connection ends.
+// The addresses of the endpoints are only kept in memory, as they
+// change when the VM or Virtlet is restarted, and updating them in
+// the container metadata would bump the container revision e.g.
+// while the container is being removed.
+type consoleEndpoints struct {
+	sync.Mutex
+	endpointType  string
+	dir           string
+	token         string
+	metadataStore metadata.Store
+	attach        func(containerID string, conn net.Conn) error
+	// endpoints maps the endpoint names to the endpoints
+	endpoints map[string]*consoleEndpoint
+}
+
+func newConsoleEndpoints(endpointType, dir, token string, metadataStore metadata.Store, attach func(containerID string, conn net.Conn) error) *consoleEndpoints {
+	return &consoleEndpoints{
+		endpointType:  endpointType,
+		dir:           dir,
+		token:         token,
+		metadataStore: metadataStore,
+		attach:        attach,
+		endpoints:     make(map[string]*consoleEndpoint),
+	}
+}
+
+// endpointName returns the name of the console endpoint for the
+// container. The VM pods have a single container, so the endpoints
+// are named after the pods, which makes their names stay the same
+// across the VM restarts.
+func endpointName(ci *types.ContainerInfo) string {
+	if ci.Config.PodNamespace == "" || ci.Config.PodName == "" {
+		return ci.Id
+	}
+	return ci.Config.PodNamespace + "/" + ci.Config.PodName
+}
+
+func (ce *consoleEndpoints) listen(name string) (net.Listener, string, error) {
+	switch ce.endpointType {
+	case ConsoleEndpointUnix:
+		if err := os.MkdirAll(ce.dir, 0755); err != nil {
+			return nil, "", fmt.Errorf("can't create console endpoint dir %q: %v", ce.dir, err)
+		}
+		path := filepath.Join(ce.dir, strings.Replace(name, "/", "_", -1)+".sock")
+		// remove the socket left over after Virtlet restart
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, "", fmt.Errorf("can't remove stale socket %q: %v", path, err)
+		}
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := os.Chmod(path, consoleEndpointSocketMode); err != nil {
+			l.Close()
+			return nil, "", fmt.Errorf("can't set the permissions of %q: %v", path, err)
+		}
+		return l, "unix:" + path, nil
+	case ConsoleEndpointTCP:
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, "", err
+		}
+		return l, "tcp:" + l.Addr().String(), nil
+	default:
+		return nil, "", fmt.Errorf("bad console endpoint type %q", ce.endpointType)
+	}
+}
+
+// open opens the console endpoint for the container.
+func (ce *consoleEndpoints) open(containerID string) {
+	ci, err := ce.metadataStore.Container(containerID).Retrieve()
+	switch {
+	case err != nil:
+		glog.Errorf("Can't open the console endpoint for %q: error retrieving the container metadata: %v", containerID, err)
+		return
+	case ci == nil:
+		glog.Warningf("Not opening the console endpoint for unknown container %q", containerID)
+		return
+	}
+	name := endpointName(ci)
+
+	ce.Lock()
+	defer ce.Unlock()
+	// the endpoint may still be held by the previous VM of the pod
+	if old := ce.endpoints[name]; old != nil {
+		old.close()
+		delete(ce.endpoints, name)
+	}
+	l, address, err := ce.listen(name)
+	if err != nil {
+		glog.Errorf("Can't open the console endpoint for %q: %v", containerID, err)
+		return
+	}
+	ep := &consoleEndpoint{
+		name:        name,
+		containerID: containerID,
+		address:     address,
+		listener:    l,
+		conns:       make(map[net.Conn]bool),
+	}
+	ce.endpoints[name] = ep
+	go ce.serve(ep)
+	glog.V(1).Infof("Serving the console of %q (%s) on %s", containerID, name, address)
+}
+
+// close closes the console endpoint of the container, if any.
+func (ce *consoleEndpoints) close(containerID string) {
+	ce.Lock()
+	defer ce.Unlock()
+	for name, ep := range ce.endpoints {
+		if ep.containerID == containerID {
+			ep.close()
+			delete(ce.endpoints, name)
+			glog.V(1).Infof("Closed the console endpoint of %q (%s)", containerID, name)
+		}
+	}
+}
+
+// closeAll closes all of the console endpoints.
+func (ce *consoleEndpoints) closeAll() {
+	ce.Lock()
+	defer ce.Unlock()
+	for name, ep := range ce.endpoints {
+		ep.close()
+		delete(ce.endpoints, name)
+	}
+}
+
+// address returns the address of the console endpoint of the
+// container, or an empty string if there's no such endpoint.
+func (ce *consoleEndpoints) address(containerID string) string {
+	ce.Lock()
+	defer ce.Unlock()
+	for _, ep := range ce.endpoints {
+		if ep.containerID == containerID {
+			return ep.address
+		}
+	}
+	return ""
+}
+
+func (ce *consoleEndpoints) serve(ep *consoleEndpoint) {
+	for {
+		conn, err := ep.listener.Accept()
+		if err != nil {
+			// the listener is closed
+			return
+		}
+		go ce.handle(ep, conn)
+	}
+}
+
+func (ce *consoleEndpoints) handle(ep *consoleEndpoint, conn net.Conn) {
+	defer conn.Close()
+	if !ep.track(conn) {
+		return
+	}
+	defer ep.untrack(conn)
+
+	// the unix sockets are protected by the file permissions,
+	// while any local user can connect to the loopback TCP ports
+	if ce.endpointType == ConsoleEndpointTCP {
+		if err := ce.authenticate(conn); err != nil {
+			glog.Warningf("Console endpoint %s: rejecting the client: %v", ep.address, err)
+			fmt.Fprintf(conn, "authentication failed\r\n")
+			return
+		}
+	}
+
+	glog.V(1).Infof("Console endpoint %s: client connected", ep.address)
+	if err := ce.attach(ep.containerID, conn); err != nil {
+		glog.V(1).Infof("Console endpoint %s: %v", ep.address, err)
+	}
+}
+
+// authenticate reads the token from the first line of the client
+// input. The line is read byte by byte so the console input that
+// follows it is not consumed.
+func (ce *consoleEndpoints) authenticate(conn net.Conn) error {
+	if err := conn.SetReadDeadline(time.Now().Add(consoleEndpointAuthTimeout)); err != nil {
+		return err
+	}
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return fmt.Errorf("error reading the token: %v", err)
+		}
+		if b[0] == '\n' {
+			break
+		}
+		if len(line) >= maxConsoleEndpointTokenLen {
+			return errors.New("the token is too long")
+		}
+		line = append(line, b[0])
+	}
+	token := strings.TrimSpace(string(line))
+	if ce.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(ce.token)) != 1 {
+		return errors.New("bad token")
+	}
+	return conn.SetReadDeadline(time.Time{})
+}
+
+// SetConsoleEndpoints makes the server expose the serial console of
+// each VM on a local endpoint for the external tools such as
+// conserver. endpointType is either ConsoleEndpointUnix, which makes
+// the server create a unix domain socket named after the pod
+// (namespace_name.sock) in dir, or ConsoleEndpointTCP, which makes
+// it listen on a random TCP port of 127.0.0.1. The clients of the
+// TCP endpoints must send the token followed by a newline before
+// any console input. The addresses of the endpoints are returned by
+// ConsoleEndpoint. It must be called before Start.
+func (s *Server) SetConsoleEndpoints(endpointType, dir, token string) error {
+	switch endpointType {
+	case "":
+		return nil
+	case ConsoleEndpointUnix, ConsoleEndpointTCP:
+	default:
+		return fmt.Errorf("bad console endpoint type %q", endpointType)
+	}
+	if endpointType == ConsoleEndpointTCP && token == "" {
+		return errors.New("the token must be set for the TCP console endpoints")
+	}
+	s.consoleEndpoints = newConsoleEndpoints(endpointType, dir, token, s.metadataStore, func(containerID string, conn net.Conn) error {
+		return s.Attach(containerID, conn, conn, nil, true, nil)
+	})
+	s.unixServer.SetConnectionHooks(s.consoleEndpoints.open, s.consoleEndpoints.close)
+	return nil
+}
+
+// ConsoleEndpoint returns the address of the console endpoint of
+// the VM, "unix:/path/to/socket" or "tcp:127.0.0.1:port", or an
+// empty string if the endpoint isn't available.
+func (s *Server) ConsoleEndpoint(containerID string) string {
+	if s.consoleEndpoints == nil {
+		return ""
+	}
+	return s.consoleEndpoints.address(containerID)
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stream
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Mirantis/virtlet/pkg/metadata"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const (
+	testEndpointContainerID = "231700d5-c9a6-5a49-738d-99a954c51550"
+	testEndpointToken       = "s3cr3t"
+)
+
+type endpointTester struct {
+	t             *testing.T
+	tmpDir        string
+	metadataStore metadata.Store
+	server        *Server
+	// revision is the revision of the container metadata
+	// before the VM connects
+	revision uint64
+	// vmConn is the qemu side of the serial console connection
+	vmConn *net.UnixConn
+	wg     sync.WaitGroup
+}
+
+func newEndpointTester(t *testing.T, endpointType string) *endpointTester {
+	tmpDir, err := ioutil.TempDir("", "console-endpoints")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	et := &endpointTester{
+		t:             t,
+		tmpDir:        tmpDir,
+		metadataStore: metadata.NewMemStore(),
+	}
+	if err := et.metadataStore.Container(testEndpointContainerID).Save(func(*types.ContainerInfo) (*types.ContainerInfo, error) {
+		return &types.ContainerInfo{
+			Id:   testEndpointContainerID,
+			Name: "vm",
+			Config: types.VMConfig{
+				PodName:      "cirros-vm",
+				PodNamespace: "default",
+			},
+		}, nil
+	}); err != nil {
+		t.Fatalf("Container().Save(): %v", err)
+	}
+	et.revision = et.containerRevision()
+
+	et.server = &Server{
+		unixServer:    NewUnixServer(filepath.Join(tmpDir, "streamer.sock")),
+		metadataStore: et.metadataStore,
+	}
+	if err := et.server.SetConsoleEndpoints(endpointType, filepath.Join(tmpDir, "consoles"), testEndpointToken); err != nil {
+		t.Fatalf("SetConsoleEndpoints(): %v", err)
+	}
+	et.connectVM()
+	return et
+}
+
+// connectVM imitates qemu connecting its serial console to the
+// stream server.
+func (et *endpointTester) connectVM() {
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(et.tmpDir, "serial.sock"), Net: "unix"})
+	if err != nil {
+		et.t.Fatalf("ListenUnix(): %v", err)
+	}
+	defer l.Close()
+	et.vmConn, err = net.DialUnix("unix", nil, l.Addr().(*net.UnixAddr))
+	if err != nil {
+		et.t.Fatalf("DialUnix(): %v", err)
+	}
+	conn, err := l.AcceptUnix()
+	if err != nil {
+		et.t.Fatalf("AcceptUnix(): %v", err)
+	}
+	u := et.server.unixServer
+	u.UnixConnections.Store(testEndpointContainerID, conn)
+	et.wg.Add(1)
+	go u.reader(testEndpointContainerID, &et.wg)
+	u.onConnect(testEndpointContainerID)
+}
+
+func (et *endpointTester) teardown() {
+	if et.vmConn != nil {
+		et.vmConn.Close()
+	}
+	et.wg.Wait()
+	os.RemoveAll(et.tmpDir)
+}
+
+func (et *endpointTester) containerRevision() uint64 {
+	ci, err := et.metadataStore.Container(testEndpointContainerID).Retrieve()
+	if err != nil {
+		et.t.Fatalf("Container().Retrieve(): %v", err)
+	}
+	return ci.Revision
+}
+
+func (et *endpointTester) endpointAddress() string {
+	return et.server.ConsoleEndpoint(testEndpointContainerID)
+}
+
+func (et *endpointTester) dial() net.Conn {
+	address := et.endpointAddress()
+	parts := strings.SplitN(address, ":", 2)
+	if len(parts) != 2 {
+		et.t.Fatalf("bad console endpoint address %q", address)
+	}
+	conn, err := net.Dial(parts[0], parts[1])
+	if err != nil {
+		et.t.Fatalf("Dial(): %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return conn
+}
+
+func (et *endpointTester) verifyConsole(conn net.Conn) {
+	if _, err := conn.Write([]byte("uname\n")); err != nil {
+		et.t.Fatalf("Write(): %v", err)
+	}
+	et.vmConn.SetDeadline(time.Now().Add(10 * time.Second))
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(et.vmConn, buf); err != nil {
+		et.t.Fatalf("error reading the console input: %v", err)
+	}
+	if string(buf) != "uname\n" {
+		et.t.Errorf("bad console input: %q", buf)
+	}
+
+	// the output reader of the attached client is added
+	// asynchronously, so the output is sent until it arrives
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(50 * time.Millisecond):
+				et.vmConn.Write([]byte("Linux"))
+			}
+		}
+	}()
+	buf = make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		et.t.Fatalf("error reading the console output: %v", err)
+	}
+	if string(buf) != "Linux" {
+		et.t.Errorf("bad console output: %q", buf)
+	}
+}
+
+func (et *endpointTester) disconnectVM(conn net.Conn) {
+	et.vmConn.Close()
+	et.vmConn = nil
+	et.wg.Wait()
+	if address := et.endpointAddress(); address != "" {
+		et.t.Errorf("the console endpoint address is still reported after the VM disconnect: %q", address)
+	}
+	// the container may be being removed when its VM
+	// disconnects, so its metadata must not be touched
+	if revision := et.containerRevision(); revision != et.revision {
+		et.t.Errorf("the container revision has changed from %d to %d", et.revision, revision)
+	}
+	// the client is disconnected when the VM console goes away
+	if _, err := ioutil.ReadAll(conn); err != nil && !strings.Contains(err.Error(), "reset") {
+		et.t.Errorf("ReadAll(): %v", err)
+	}
+}
+
+func TestUnixConsoleEndpoint(t *testing.T) {
+	et := newEndpointTester(t, ConsoleEndpointUnix)
+	defer et.teardown()
+
+	socketPath := filepath.Join(et.tmpDir, "consoles", "default_cirros-vm.sock")
+	if address := et.endpointAddress(); address != "unix:"+socketPath {
+		t.Errorf("bad console endpoint address %q", address)
+	}
+	fi, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("Stat(): %v", err)
+	}
+	if fi.Mode()&os.ModePerm != consoleEndpointSocketMode {
+		t.Errorf("bad socket permissions: %v", fi.Mode())
+	}
+
+	conn := et.dial()
+	defer conn.Close()
+	et.verifyConsole(conn)
+	et.disconnectVM(conn)
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("the socket was not removed")
+	}
+}
+
+func TestTCPConsoleEndpoint(t *testing.T) {
+	et := newEndpointTester(t, ConsoleEndpointTCP)
+	defer et.teardown()
+
+	if address := et.endpointAddress(); !strings.HasPrefix(address, "tcp:127.0.0.1:") {
+		t.Errorf("bad console endpoint address %q", address)
+	}
+
+	badConn := et.dial()
+	defer badConn.Close()
+	if _, err := badConn.Write([]byte("foobar\n")); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+	if out, err := ioutil.ReadAll(badConn); err != nil {
+		t.Errorf("ReadAll(): %v", err)
+	} else if string(out) != "authentication failed\r\n" {
+		t.Errorf("unexpected response to a bad token: %q", out)
+	}
+
+	conn := et.dial()
+	defer conn.Close()
+	if _, err := conn.Write([]byte(testEndpointToken + "\r\n")); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+	et.verifyConsole(conn)
+	et.disconnectVM(conn)
+}
+
+func TestConsoleEndpointConfig(t *testing.T) {
+	s := &Server{unixServer: NewUnixServer("/tmp/streamer.sock")}
+	if err := s.SetConsoleEndpoints("", "", ""); err != nil || s.consoleEndpoints != nil {
+		t.Errorf("empty endpoint type must disable the endpoints: %v", err)
+	}
+	if err := s.SetConsoleEndpoints("udp", "", ""); err == nil {
+		t.Errorf("SetConsoleEndpoints() didn't fail for a bad endpoint type")
+	}
+	if err := s.SetConsoleEndpoints(ConsoleEndpointTCP, "", ""); err == nil {
+		t.Errorf("SetConsoleEndpoints() didn't fail for TCP endpoints without a token")
+	}
+}
@@ -45,6 +45,9 @@ type UnixServer struct {
 	outputReadersMux sync.Mutex
 
 	workersWG sync.WaitGroup
+
+	onConnect    func(containerID string)
+	onDisconnect func(containerID string)
 }
 
 // NewUnixServer creates new UnixServer. Requires socketPath on which it will listen
@@ -61,6 +64,14 @@ func NewUnixServer(socketPath string) *UnixServer {
 	return &u
 }
 
+// SetConnectionHooks sets the functions to be called after a qemu
+// instance connects and after its connection is closed. It must be
+// called before Listen.
+func (u *UnixServer) SetConnectionHooks(onConnect, onDisconnect func(containerID string)) {
+	u.onConnect = onConnect
+	u.onDisconnect = onDisconnect
+}
+
 // Listen starts listening for connections from qemus
 func (u *UnixServer) Listen() {
 	glog.V(1).Info("UnixSocket Listener started")
@@ -130,6 +141,10 @@ func (u *UnixServer) Listen() {
 
 		u.workersWG.Add(1)
 		go NewLogWriter(logChan, logPath, &u.workersWG)
+
+		if u.onConnect != nil {
+			u.onConnect(containerID)
+		}
 	}
 }
 
@@ -157,7 +172,13 @@ func (u *UnixServer) reader(containerID string, wg *sync.WaitGroup) {
 		u.broadcast(containerID, bufCopy)
 	}
 	conn.Close()
-	u.UnixConnections.Delete(containerID)
+	// the connection may have been replaced by a new one
+	// from the same VM
+	replaced := true
+	if current, ok := u.UnixConnections.Load(containerID); ok && current == conn {
+		u.UnixConnections.Delete(containerID)
+		replaced = false
+	}
 
 	// Closing all channels
 	u.outputReadersMux.Lock()
@@ -171,6 +192,10 @@ func (u *UnixServer) reader(containerID string, wg *sync.WaitGroup) {
 	delete(u.outputReaders, containerID)
 	u.outputReadersMux.Unlock()
 
+	if !replaced && u.onDisconnect != nil {
+		u.onDisconnect(containerID)
+	}
+
 	glog.V(1).Infof("Stream reader for container '%s' stopped gracefully", containerID)
 }
 
@@ -27,6 +27,16 @@ import (
 	"github.com/golang/glog"
 )
 
+const (
+	// StdoutStream is the name of the container log stream used
+	// for the VM serial console output.
+	StdoutStream = "stdout"
+	// StderrStream is the name of the container log stream used
+	// for the secondary log, e.g. the in-guest log file streamed
+	// via the guest agent.
+	StderrStream = "stderr"
+)
+
 // NewLogWriter writes the lines from stdout channel to logFile in k8s format
 func NewLogWriter(stdout <-chan []byte, logFile string, wg *sync.WaitGroup) {
 	defer wg.Done()
@@ -62,7 +72,7 @@ func NewLogWriter(stdout <-chan []byte, logFile string, wg *sync.WaitGroup) {
 				}
 
 			}
-			err = writeLog(f, line)
+			err = writeLog(f, StdoutStream, line)
 			if err != nil {
 				break
 			}
@@ -71,11 +81,27 @@ func NewLogWriter(stdout <-chan []byte, logFile string, wg *sync.WaitGroup) {
 	glog.V(1).Info("Log writter stopped. Finished logging to file:", logFile)
 }
 
-func writeLog(f *os.File, line string) error {
+// AppendLogLines appends the lines to the logFile in k8s format
+// using the specified stream name.
+func AppendLogLines(logFile, stream string, lines []string) error {
+	f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0777)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, line := range lines {
+		if err := writeLog(f, stream, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeLog(f *os.File, stream, line string) error {
 	// Convert raw line into Kubernetes json.
 	m := map[string]interface{}{
 		"time":   time.Now().Format(time.RFC3339),
-		"stream": "stdout",
+		"stream": stream,
 		"log":    line,
 	}
 	converted, err := json.Marshal(m)
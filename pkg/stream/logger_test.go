@@ -160,3 +160,20 @@ func TestLoggingInNewLogWritter(t *testing.T) {
 		verifyJSONLines(t, test.outputFile, test.jsonLines)
 	}
 }
+
+func TestAppendLogLines(t *testing.T) {
+	outputFile := setupTmpLogFile()
+	defer os.RemoveAll(filepath.Dir(outputFile))
+
+	if err := AppendLogLines(outputFile, StderrStream, []string{"foo\n"}); err != nil {
+		t.Fatalf("AppendLogLines(): %v", err)
+	}
+	if err := AppendLogLines(outputFile, StderrStream, []string{"bar\n", "baz\n"}); err != nil {
+		t.Fatalf("AppendLogLines(): %v", err)
+	}
+	verifyJSONLines(t, outputFile, []map[string]interface{}{
+		{"stream": "stderr", "log": "foo\n"},
+		{"stream": "stderr", "log": "bar\n"},
+		{"stream": "stderr", "log": "baz\n"},
+	})
+}
@@ -0,0 +1,289 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stream
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// RecordingContentType denotes the content type used for
+	// the console session recordings that are posted to the
+	// audit webhook.
+	RecordingContentType = "application/x-asciicast"
+
+	// BinaryEventSuffix is appended to the event code of the
+	// recorded console input ("i") and output ("o") that isn't
+	// valid UTF-8 text, in which case the data is base64-encoded.
+	// Such events are ignored by asciinema.
+	BinaryEventSuffix = "b"
+
+	recordingVersion       = 2
+	defaultRecordingWidth  = 80
+	defaultRecordingHeight = 24
+	webhookTimeout         = 30 * time.Second
+)
+
+var errRecordingStopped = errors.New("the console recording is stopped")
+
+// RecordingHeader is the first line of a console session
+// recording. The recordings use asciicast v2 format, so they can
+// also be played back using asciinema.
+type RecordingHeader struct {
+	// Version is the version of the recording format.
+	Version int `json:"version"`
+	// Width is the initial width of the terminal.
+	Width int `json:"width"`
+	// Height is the initial height of the terminal.
+	Height int `json:"height"`
+	// Timestamp is the unix timestamp of the session start.
+	Timestamp int64 `json:"timestamp"`
+	// Title describes the VM that the session belongs to.
+	Title string `json:"title,omitempty"`
+}
+
+// sessionInfo identifies the VM which the console session
+// belongs to.
+type sessionInfo struct {
+	containerID  string
+	podNamespace string
+	podName      string
+}
+
+func (info sessionInfo) title() string {
+	if info.podName == "" {
+		return info.containerID
+	}
+	return fmt.Sprintf("%s/%s (%s)", info.podNamespace, info.podName, info.containerID)
+}
+
+func (info sessionInfo) fileName(t time.Time) string {
+	prefix := info.containerID
+	if info.podName != "" {
+		prefix = fmt.Sprintf("%s_%s_%s", info.podNamespace, info.podName, info.containerID)
+	}
+	return fmt.Sprintf("%s_%s.cast", prefix, t.UTC().Format("20060102T150405.000000000Z"))
+}
+
+// consoleRecorder records the interactive console sessions to
+// files in the audit directory and/or posts them to the audit
+// webhook.
+type consoleRecorder struct {
+	dir        string
+	webhookURL string
+	now        func() time.Time
+	client     *http.Client
+}
+
+func newConsoleRecorder(dir, webhookURL string, now func() time.Time) *consoleRecorder {
+	if dir == "" && webhookURL == "" {
+		return nil
+	}
+	if now == nil {
+		now = time.Now
+	}
+	return &consoleRecorder{
+		dir:        dir,
+		webhookURL: webhookURL,
+		now:        now,
+		client:     &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+// startSession starts recording a new console session.
+func (cr *consoleRecorder) startSession(info sessionInfo) (*consoleSession, error) {
+	start := cr.now()
+	var f *os.File
+	var err error
+	if cr.dir != "" {
+		if err = os.MkdirAll(cr.dir, 0700); err != nil {
+			return nil, fmt.Errorf("mkdir %q: %v", cr.dir, err)
+		}
+		f, err = os.OpenFile(filepath.Join(cr.dir, info.fileName(start)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	} else {
+		// the recording is only kept until it's posted
+		// to the webhook
+		f, err = ioutil.TempFile("", "virtlet-console-")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating console recording file: %v", err)
+	}
+
+	s := &consoleSession{
+		recorder: cr,
+		info:     info,
+		f:        f,
+		start:    start,
+	}
+	if err := json.NewEncoder(f).Encode(RecordingHeader{
+		Version:   recordingVersion,
+		Width:     defaultRecordingWidth,
+		Height:    defaultRecordingHeight,
+		Timestamp: start.Unix(),
+		Title:     info.title(),
+	}); err != nil {
+		s.cleanup()
+		return nil, fmt.Errorf("error writing console recording header: %v", err)
+	}
+	return s, nil
+}
+
+// consoleSession is a console session being recorded. The methods
+// of a nil *consoleSession do nothing, so a nil session can be
+// used when the recording is disabled.
+type consoleSession struct {
+	sync.Mutex
+	recorder *consoleRecorder
+	info     sessionInfo
+	f        *os.File
+	start    time.Time
+	// stopped is set after the session is closed or when
+	// writing the recording fails
+	stopped bool
+}
+
+func (s *consoleSession) record(kind, data string) error {
+	if s == nil {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.stopped {
+		return errRecordingStopped
+	}
+	elapsed := s.recorder.now().Sub(s.start).Seconds()
+	if err := json.NewEncoder(s.f).Encode([]interface{}{elapsed, kind, data}); err != nil {
+		s.stopped = true
+		return fmt.Errorf("error writing console recording %q: %v", s.f.Name(), err)
+	}
+	return nil
+}
+
+// recordData records the console data. JSON strings can only hold
+// UTF-8 text, so the data that isn't valid UTF-8 is recorded
+// base64-encoded to keep it intact.
+func (s *consoleSession) recordData(kind string, data []byte) error {
+	if utf8.Valid(data) {
+		return s.record(kind, string(data))
+	}
+	return s.record(kind+BinaryEventSuffix, base64.StdEncoding.EncodeToString(data))
+}
+
+// Input records the data sent to the VM console.
+func (s *consoleSession) Input(data []byte) error {
+	return s.recordData("i", data)
+}
+
+// Output records the data received from the VM console.
+func (s *consoleSession) Output(data []byte) error {
+	return s.recordData("o", data)
+}
+
+// Resize records the terminal size change.
+func (s *consoleSession) Resize(width, height uint16) error {
+	return s.record("r", fmt.Sprintf("%dx%d", width, height))
+}
+
+// InputReader returns a reader that records all the data read
+// from r as the console input.
+func (s *consoleSession) InputReader(r io.Reader) io.Reader {
+	if s == nil || r == nil {
+		return r
+	}
+	return io.TeeReader(r, inputWriter{s})
+}
+
+// Close finishes the recording and posts it to the audit webhook
+// if it's configured.
+func (s *consoleSession) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.stopped {
+		return nil
+	}
+	s.stopped = true
+	defer s.cleanup()
+	if err := s.f.Sync(); err != nil {
+		return fmt.Errorf("error syncing console recording %q: %v", s.f.Name(), err)
+	}
+	if s.recorder.webhookURL == "" {
+		return nil
+	}
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error rewinding console recording %q: %v", s.f.Name(), err)
+	}
+	return s.post()
+}
+
+func (s *consoleSession) post() error {
+	req, err := http.NewRequest("POST", s.recorder.webhookURL, s.f)
+	if err != nil {
+		return fmt.Errorf("error creating console audit webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", RecordingContentType)
+	req.Header.Set("X-Virtlet-Container-Id", s.info.containerID)
+	req.Header.Set("X-Virtlet-Pod-Namespace", s.info.podNamespace)
+	req.Header.Set("X-Virtlet-Pod-Name", s.info.podName)
+	resp, err := s.recorder.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error posting console recording to the audit webhook: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("console audit webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *consoleSession) cleanup() {
+	path := s.f.Name()
+	if err := s.f.Close(); err != nil {
+		glog.Warningf("Error closing console recording %q: %v", path, err)
+	}
+	if s.recorder.dir == "" {
+		if err := os.Remove(path); err != nil {
+			glog.Warningf("Error removing %q: %v", path, err)
+		}
+	}
+}
+
+type inputWriter struct {
+	s *consoleSession
+}
+
+func (w inputWriter) Write(p []byte) (int, error) {
+	if err := w.s.Input(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stream
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const expectedRecording = `{"version":2,"width":80,"height":24,"timestamp":1500000000,"title":"default/cirros-vm (a1b2c3)"}
+[0.5,"o","login: "]
+[1,"r","100x40"]
+[1.5,"i","cirros\r"]
+[2,"o","Password: "]
+[2.5,"ob","gP8="]
+`
+
+func newFakeNow() func() time.Time {
+	t := time.Unix(1500000000, 0)
+	first := true
+	return func() time.Time {
+		if !first {
+			t = t.Add(500 * time.Millisecond)
+		}
+		first = false
+		return t
+	}
+}
+
+func recordTestSession(t *testing.T, cr *consoleRecorder) {
+	s, err := cr.startSession(sessionInfo{
+		containerID:  "a1b2c3",
+		podNamespace: "default",
+		podName:      "cirros-vm",
+	})
+	if err != nil {
+		t.Fatalf("startSession(): %v", err)
+	}
+	s.Output([]byte("login: "))
+	s.Resize(100, 40)
+	if _, err := ioutil.ReadAll(s.InputReader(strings.NewReader("cirros\r"))); err != nil {
+		t.Fatalf("ReadAll(): %v", err)
+	}
+	s.Output([]byte("Password: "))
+	// the output that isn't valid UTF-8 is kept intact
+	s.Output([]byte{0x80, 0xff})
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close(): %v", err)
+	}
+	// the data that arrives after the session is closed is not recorded
+	if err := s.Output([]byte("foobar")); err == nil {
+		t.Errorf("Output() didn't fail after the session was closed")
+	}
+}
+
+func TestRecordConsoleSessionToDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "console-audit")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	auditDir := filepath.Join(tmpDir, "audit")
+
+	recordTestSession(t, newConsoleRecorder(auditDir, "", newFakeNow()))
+
+	files, err := filepath.Glob(filepath.Join(auditDir, "*"))
+	if err != nil {
+		t.Fatalf("Glob(): %v", err)
+	}
+	expectedPath := filepath.Join(auditDir, "default_cirros-vm_a1b2c3_20170714T024000.000000000Z.cast")
+	if len(files) != 1 || files[0] != expectedPath {
+		t.Fatalf("bad recording files: %#v instead of [%q]", files, expectedPath)
+	}
+	data, err := ioutil.ReadFile(expectedPath)
+	if err != nil {
+		t.Fatalf("ReadFile(): %v", err)
+	}
+	if string(data) != expectedRecording {
+		t.Errorf("bad recording:\n%s\n-- instead of --\n%s", data, expectedRecording)
+	}
+}
+
+func TestRecordConsoleSessionToWebhook(t *testing.T) {
+	var posted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("error reading the request body: %v", err)
+		}
+		if r.Method != "POST" {
+			t.Errorf("bad request method %q", r.Method)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != RecordingContentType {
+			t.Errorf("bad content type %q", ct)
+		}
+		for name, expectedValue := range map[string]string{
+			"X-Virtlet-Container-Id":  "a1b2c3",
+			"X-Virtlet-Pod-Namespace": "default",
+			"X-Virtlet-Pod-Name":      "cirros-vm",
+		} {
+			if v := r.Header.Get(name); v != expectedValue {
+				t.Errorf("bad %s header: %q instead of %q", name, v, expectedValue)
+			}
+		}
+		posted = append(posted, string(data))
+	}))
+	defer server.Close()
+
+	tmpDir, err := ioutil.TempDir("", "console-audit")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	oldTmpDir := os.Getenv("TMPDIR")
+	os.Setenv("TMPDIR", tmpDir)
+	defer os.Setenv("TMPDIR", oldTmpDir)
+
+	recordTestSession(t, newConsoleRecorder("", server.URL, newFakeNow()))
+
+	if len(posted) != 1 {
+		t.Fatalf("expected exactly one recording to be posted, got %d", len(posted))
+	}
+	if posted[0] != expectedRecording {
+		t.Errorf("bad recording:\n%s\n-- instead of --\n%s", posted[0], expectedRecording)
+	}
+	// the temporary file should be removed
+	if files, _ := filepath.Glob(filepath.Join(tmpDir, "*")); len(files) != 0 {
+		t.Errorf("temporary recording files left behind: %#v", files)
+	}
+}
+
+func TestConsoleRecordingDisabled(t *testing.T) {
+	if cr := newConsoleRecorder("", "", nil); cr != nil {
+		t.Errorf("the recorder should be nil when both the dir and the webhook are empty")
+	}
+	// the nil session does nothing
+	var s *consoleSession
+	s.Output([]byte("foo"))
+	r := strings.NewReader("bar")
+	if s.InputReader(r) != r {
+		t.Errorf("InputReader() should return the original reader for a nil session")
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("Close(): %v", err)
+	}
+}
+
+func TestConsoleRecordingFailure(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "console-audit")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	notADir := filepath.Join(tmpDir, "file")
+	if err := ioutil.WriteFile(notADir, nil, 0644); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+
+	// the console can't be attached to if the session
+	// can't be recorded
+	s := &Server{recorder: newConsoleRecorder(filepath.Join(notADir, "audit"), "", nil)}
+	if session, err := s.startConsoleSession("a1b2c3"); err == nil {
+		session.Close()
+		t.Errorf("startConsoleSession() didn't fail")
+	}
+}
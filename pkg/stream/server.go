@@ -19,6 +19,7 @@ package stream
 import (
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"strconv"
 	"syscall"
@@ -41,14 +42,25 @@ type Server struct {
 	streamServerCloseCh chan struct{}
 	streaming.Runtime
 
+	// streamAddr is the address of the streaming http server,
+	// which also serves the WebSocket consoles
+	streamAddr    string
+	httpServer    *http.Server
+	consoleTokens *consoleTokenCache
+	vncPort       func(containerID string) (int, error)
+
 	metadataStore metadata.Store //required for port-forward
+
+	recorder *consoleRecorder
+
+	consoleEndpoints *consoleEndpoints
 }
 
 var _ streaming.Runtime = (*Server)(nil)
 
 // NewServer creates a new Server
 func NewServer(socketPath string, metadataStore metadata.Store, iStreamPort int) (*Server, error) {
-	s := &Server{DeadlineSeconds: 10}
+	s := &Server{DeadlineSeconds: 10, consoleTokens: newConsoleTokenCache(nil)}
 
 	// Prepare unix server
 	s.unixServer = NewUnixServer(socketPath)
@@ -59,18 +71,34 @@ func NewServer(socketPath string, metadataStore metadata.Store, iStreamPort int)
 	}
 	streamPort := strconv.Itoa(iStreamPort)
 
+	s.streamAddr = net.JoinHostPort(bindAddress.String(), streamPort)
 	streamServerConfig := streaming.DefaultConfig
-	streamServerConfig.Addr = net.JoinHostPort(bindAddress.String(), streamPort)
+	streamServerConfig.Addr = s.streamAddr
 	s.streamServer, err = streaming.NewServer(streamServerConfig, s)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create streaming server")
 	}
 
+	// the WebSocket consoles are served alongside the standard
+	// streaming endpoints
+	mux := http.NewServeMux()
+	mux.HandleFunc(wsConsolePath, s.serveWebSocketConsole)
+	mux.Handle("/", s.streamServer)
+	s.httpServer = &http.Server{Addr: s.streamAddr, Handler: mux}
+
 	s.metadataStore = metadataStore
 
 	return s, nil
 }
 
+// SetConsoleAudit enables the recording of the interactive console
+// sessions. The recordings are stored in dir and/or posted to
+// webhookURL after each session ends. If both dir and webhookURL
+// are empty, the recording is disabled.
+func (s *Server) SetConsoleAudit(dir, webhookURL string) {
+	s.recorder = newConsoleRecorder(dir, webhookURL, nil)
+}
+
 // Start starts streaming server gorutine and unixServer gorutine
 func (s *Server) Start() error {
 	if err := syscall.Unlink(s.unixServer.SocketPath); err != nil && !os.IsNotExist(err) {
@@ -78,7 +106,7 @@ func (s *Server) Start() error {
 	}
 	// start http server
 	go func() {
-		if err := s.streamServer.Start(true); err != nil {
+		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			glog.Fatalf("Failed to start streaming server: %v", err)
 		}
 	}()
@@ -91,5 +119,9 @@ func (s *Server) Start() error {
 func (s *Server) Stop() {
 	// in k8s 1.7 Stop() does nothing, starting from 1.8 it will stop streaming server
 	s.streamServer.Stop()
+	s.httpServer.Close()
 	s.unixServer.Stop()
+	if s.consoleEndpoints != nil {
+		s.consoleEndpoints.closeAll()
+	}
 }
@@ -52,17 +52,44 @@ func (s *Server) Attach(containerID string, inputStream io.Reader, outputStream,
 	}
 	conn := c.(*net.UnixConn)
 
+	session, err := s.startConsoleSession(containerID)
+	if err != nil {
+		// the unrecorded sessions are not allowed when
+		// the console audit is enabled
+		return err
+	}
+	defer func() {
+		if err := session.Close(); err != nil {
+			glog.Errorf("Error recording the console session for %q: %v", containerID, err)
+		}
+	}()
+
 	outChan := make(chan []byte)
 	s.unixServer.AddOutputReader(containerID, outChan)
 
 	kubecontainer.HandleResizing(resize, func(size remotecommand.TerminalSize) {
 		glog.Infof("Got a resize event: %+v", size)
+		if err := session.Resize(size.Width, size.Height); err != nil {
+			glog.Errorf("Error recording the console session for %q: %v", containerID, err)
+		}
 	})
 
-	receiveStdout := make(chan error)
+	receiveStdout := make(chan error, 1)
 	if outputStream != nil {
 		go func() {
+			failed := false
 			for data := range outChan {
+				// keep draining the channel after a failure
+				// until the reader is removed so the
+				// broadcast doesn't block
+				if failed {
+					continue
+				}
+				if err := session.Output(data); err != nil {
+					failed = true
+					receiveStdout <- fmt.Errorf("console session for %q can't be recorded: %v", containerID, err)
+					continue
+				}
 				outputStream.Write(data)
 			}
 		}()
@@ -72,7 +99,7 @@ func (s *Server) Attach(containerID string, inputStream io.Reader, outputStream,
 	go func() {
 		var err error
 		if inputStream != nil {
-			_, err = CopyDetachable(conn, inputStream, nil)
+			_, err = CopyDetachable(conn, session.InputReader(inputStream), nil)
 			if err != nil {
 				glog.V(1).Info("Attach coppy error: %v", err)
 			}
@@ -80,7 +107,6 @@ func (s *Server) Attach(containerID string, inputStream io.Reader, outputStream,
 		}
 	}()
 
-	var err error
 	select {
 	case err = <-receiveStdout:
 	case err = <-stdinDone:
@@ -90,6 +116,74 @@ func (s *Server) Attach(containerID string, inputStream io.Reader, outputStream,
 	return err
 }
 
+// WatchConsole copies the console output of the VM to out until
+// stopCh is closed, the console connection is closed or writing
+// to out fails. The console input is not affected.
+func (s *Server) WatchConsole(containerID string, out io.Writer, stopCh <-chan struct{}) error {
+	if _, ok := s.unixServer.UnixConnections.Load(containerID); !ok {
+		return fmt.Errorf("could not find vm %q", containerID)
+	}
+
+	outChan := make(chan []byte)
+	s.unixServer.AddOutputReader(containerID, outChan)
+	defer func() {
+		// keep draining the channel while the reader is being
+		// removed so the broadcast doesn't block
+		done := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case <-outChan:
+				case <-done:
+					return
+				}
+			}
+		}()
+		s.unixServer.RemoveOutputReader(containerID, outChan)
+		close(done)
+	}()
+
+	for {
+		select {
+		case data, ok := <-outChan:
+			if !ok {
+				return nil
+			}
+			if _, err := out.Write(data); err != nil {
+				return err
+			}
+		case <-stopCh:
+			return nil
+		}
+	}
+}
+
+// startConsoleSession starts recording the console session for the
+// container if the console audit is enabled. It returns nil session
+// if the recording is disabled and an error if it can't be started.
+func (s *Server) startConsoleSession(containerID string) (*consoleSession, error) {
+	if s.recorder == nil {
+		return nil, nil
+	}
+	info := sessionInfo{containerID: containerID}
+	if s.metadataStore != nil {
+		containerInfo, err := s.metadataStore.Container(containerID).Retrieve()
+		switch {
+		case err != nil:
+			glog.Warningf("Error retrieving the metadata for container %q: %v", containerID, err)
+		case containerInfo != nil:
+			info.podNamespace = containerInfo.Config.PodNamespace
+			info.podName = containerInfo.Config.PodName
+		}
+	}
+	session, err := s.recorder.startSession(info)
+	if err != nil {
+		glog.Errorf("Can't record the console session for %q: %v", containerID, err)
+		return nil, fmt.Errorf("console session for %q can't be recorded: %v", containerID, err)
+	}
+	return session, nil
+}
+
 // PortForward endpoint for streaming.Runtime
 func (s *Server) PortForward(podSandboxID string, port int32, stream io.ReadWriteCloser) error {
 	// implementation based on https://github.com/kubernetes-incubator/cri-o/blob/master/server/container_portforward.go
@@ -0,0 +1,199 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stream
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// ConsoleSerial denotes the serial console of a VM
+	ConsoleSerial = "serial"
+	// ConsoleVNC denotes the VNC console of a VM
+	ConsoleVNC = "vnc"
+
+	wsConsolePath = "/console/"
+	// consoleTokenTTL and maxConsoleTokens match the settings of
+	// the token cache of the Kubernetes streaming server
+	consoleTokenTTL  = time.Minute
+	maxConsoleTokens = 1000
+	consoleTokenLen  = 8
+)
+
+type consoleRequest struct {
+	containerID string
+	consoleType string
+	expires     time.Time
+}
+
+// consoleTokenCache keeps the single-use tokens for the WebSocket
+// console requests, the same way as the Kubernetes streaming server
+// does for exec, attach and port-forward requests.
+type consoleTokenCache struct {
+	sync.Mutex
+	now      func() time.Time
+	requests map[string]consoleRequest
+}
+
+func newConsoleTokenCache(now func() time.Time) *consoleTokenCache {
+	if now == nil {
+		now = time.Now
+	}
+	return &consoleTokenCache{now: now, requests: make(map[string]consoleRequest)}
+}
+
+// insert stores the request, returning the new token for it.
+func (c *consoleTokenCache) insert(containerID, consoleType string) (string, error) {
+	c.Lock()
+	defer c.Unlock()
+	now := c.now()
+	for token, req := range c.requests {
+		if now.After(req.expires) {
+			delete(c.requests, token)
+		}
+	}
+	if len(c.requests) >= maxConsoleTokens {
+		return "", errors.New("too many console requests in flight")
+	}
+	b := make([]byte, consoleTokenLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	c.requests[token] = consoleRequest{
+		containerID: containerID,
+		consoleType: consoleType,
+		expires:     now.Add(consoleTokenTTL),
+	}
+	return token, nil
+}
+
+// consume returns the request for the token and invalidates the
+// token. It returns false if there's no such token or the token
+// has expired.
+func (c *consoleTokenCache) consume(token string) (consoleRequest, bool) {
+	c.Lock()
+	defer c.Unlock()
+	req, found := c.requests[token]
+	if !found {
+		return consoleRequest{}, false
+	}
+	delete(c.requests, token)
+	return req, !c.now().After(req.expires)
+}
+
+// SetVNCPortFunc sets the function that returns the port of the
+// VNC server of the VM. The VNC consoles aren't available if it's
+// not set.
+func (s *Server) SetVNCPortFunc(vncPort func(containerID string) (int, error)) {
+	s.vncPort = vncPort
+}
+
+// ConsoleURL returns a single-use URL of the WebSocket endpoint
+// for the specified console of the VM. consoleType must be either
+// ConsoleSerial or ConsoleVNC. The URL must be used within a
+// minute.
+func (s *Server) ConsoleURL(containerID, consoleType string) (string, error) {
+	switch consoleType {
+	case ConsoleSerial:
+		if _, ok := s.unixServer.UnixConnections.Load(containerID); !ok {
+			return "", fmt.Errorf("could not find vm %q", containerID)
+		}
+	case ConsoleVNC:
+		if s.vncPort == nil {
+			return "", errors.New("VNC consoles are not available")
+		}
+		if _, err := s.vncPort(containerID); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("bad console type %q", consoleType)
+	}
+	token, err := s.consoleTokens.insert(containerID, consoleType)
+	if err != nil {
+		return "", err
+	}
+	return "ws://" + s.streamAddr + wsConsolePath + token, nil
+}
+
+// serveWebSocketConsole handles the requests to the WebSocket
+// console URLs returned by ConsoleURL. The serial console data
+// and the VNC protocol data are sent as binary frames.
+func (s *Server) serveWebSocketConsole(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.consoleTokens.consume(strings.TrimPrefix(r.URL.Path, wsConsolePath))
+	if !ok {
+		http.Error(w, "invalid or expired console token", http.StatusNotFound)
+		return
+	}
+	wsServer := websocket.Server{
+		// the single-use token is what authorizes the request,
+		// so the consoles can be embedded into the dashboards
+		// served from any origin
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			ws.PayloadType = websocket.BinaryFrame
+			var err error
+			switch req.consoleType {
+			case ConsoleSerial:
+				err = s.Attach(req.containerID, ws, ws, nil, true, nil)
+			case ConsoleVNC:
+				err = s.proxyVNC(req.containerID, ws)
+			}
+			if err != nil {
+				glog.V(1).Infof("WebSocket %s console for %q closed: %v", req.consoleType, req.containerID, err)
+			}
+		},
+	}
+	glog.V(1).Infof("New WebSocket %s console request for %q", req.consoleType, req.containerID)
+	wsServer.ServeHTTP(w, r)
+}
+
+// proxyVNC copies the data between the WebSocket connection and
+// the VNC server of the VM till either side closes the connection.
+func (s *Server) proxyVNC(containerID string, ws *websocket.Conn) error {
+	port, err := s.vncPort(containerID)
+	if err != nil {
+		return err
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", fmt.Sprint(port)))
+	if err != nil {
+		return fmt.Errorf("can't connect to the VNC server: %v", err)
+	}
+	defer conn.Close()
+	errCh := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(conn, ws)
+		errCh <- err
+	}()
+	go func() {
+		_, err := io.Copy(ws, conn)
+		errCh <- err
+	}()
+	return <-errCh
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stream
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestConsoleTokenCache(t *testing.T) {
+	now := time.Date(2018, 7, 10, 12, 0, 0, 0, time.UTC)
+	c := newConsoleTokenCache(func() time.Time { return now })
+
+	token, err := c.insert("container1", ConsoleVNC)
+	if err != nil {
+		t.Fatalf("insert(): %v", err)
+	}
+	if req, ok := c.consume(token); !ok || req.containerID != "container1" || req.consoleType != ConsoleVNC {
+		t.Errorf("bad request for the token: %#v, %v", req, ok)
+	}
+	if _, ok := c.consume(token); ok {
+		t.Errorf("the token was consumed twice")
+	}
+
+	token, err = c.insert("container1", ConsoleSerial)
+	if err != nil {
+		t.Fatalf("insert(): %v", err)
+	}
+	now = now.Add(consoleTokenTTL + time.Second)
+	if _, ok := c.consume(token); ok {
+		t.Errorf("an expired token was accepted")
+	}
+
+	for i := 0; i < maxConsoleTokens; i++ {
+		if _, err := c.insert("container1", ConsoleSerial); err != nil {
+			t.Fatalf("insert(): %v", err)
+		}
+	}
+	if _, err := c.insert("container1", ConsoleSerial); err == nil {
+		t.Errorf("insert() didn't fail with too many tokens in flight")
+	}
+	now = now.Add(consoleTokenTTL + time.Second)
+	if _, err := c.insert("container1", ConsoleSerial); err != nil {
+		t.Errorf("the expired tokens were not removed: %v", err)
+	}
+}
+
+func TestWebSocketVNCConsole(t *testing.T) {
+	// the fake VNC server echoes the data back
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen(): %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	s := &Server{consoleTokens: newConsoleTokenCache(nil)}
+	s.SetVNCPortFunc(func(containerID string) (int, error) {
+		return ln.Addr().(*net.TCPAddr).Port, nil
+	})
+	ts := httptest.NewServer(http.HandlerFunc(s.serveWebSocketConsole))
+	defer ts.Close()
+	s.streamAddr = strings.TrimPrefix(ts.URL, "http://")
+
+	if _, err := s.ConsoleURL("container1", "spice"); err == nil {
+		t.Errorf("ConsoleURL() didn't fail for a bad console type")
+	}
+	url, err := s.ConsoleURL("container1", ConsoleVNC)
+	if err != nil {
+		t.Fatalf("ConsoleURL(): %v", err)
+	}
+	if !strings.HasPrefix(url, "ws://"+s.streamAddr+wsConsolePath) {
+		t.Errorf("bad console URL %q", url)
+	}
+
+	ws, err := websocket.Dial(url, "", "http://dashboard.example.com/")
+	if err != nil {
+		t.Fatalf("websocket.Dial(): %v", err)
+	}
+	defer ws.Close()
+	if _, err := ws.Write([]byte("RFB 003.008\n")); err != nil {
+		t.Fatalf("Write(): %v", err)
+	}
+	buf := make([]byte, 12)
+	if _, err := io.ReadFull(ws, buf); err != nil {
+		t.Fatalf("ReadFull(): %v", err)
+	}
+	if string(buf) != "RFB 003.008\n" {
+		t.Errorf("bad data received from the VNC server: %q", buf)
+	}
+
+	// the tokens are single-use
+	if _, err := websocket.Dial(url, "", "http://dashboard.example.com/"); err == nil {
+		t.Errorf("the console URL was accepted twice")
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tapmanager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	ethHeaderLen   = 14
+	ethTypeARP     = 0x0806
+	ethTypeIPv6    = 0x86dd
+	arpHwTypeEther = 1
+	arpProtoIPv4   = 0x0800
+	arpPacketLen   = 28
+	ipv6HeaderLen  = 40
+	ipProtoICMPv6  = 58
+	// ndpHopLimit is the hop limit that must be set for all
+	// the NDP packets (RFC 4861)
+	ndpHopLimit = 255
+	// ndpPacketLen is the minimum length of NS and NA messages
+	ndpPacketLen                = 24
+	icmpv6NeighborSolicitation  = 135
+	icmpv6NeighborAdvertisement = 136
+	// maxGuestAddresses is the max number of the addresses
+	// remembered for each VM interface
+	maxGuestAddresses = 8
+	// snoopReadTimeout is the read timeout for the packet socket
+	// which makes it possible to stop the snooper
+	snoopReadTimeout = time.Second
+	// snoopBufSize is the size of the buffer used to read the
+	// frames. Longer frames are truncated, which is ok as ARP
+	// and NDP packets are short.
+	snoopBufSize = 2048
+)
+
+// GuestAddresses contains the IP addresses used by the VM on one
+// of its network interfaces as learned from the ARP and NDP packets
+// sent by the VM.
+type GuestAddresses struct {
+	// Name is the name of the pod network interface which
+	// corresponds to the VM interface, e.g. eth0
+	Name string `json:"name"`
+	// Mac is the hardware address of the VM interface
+	Mac string `json:"mac"`
+	// IPs lists the addresses in the order in which they were
+	// first seen
+	IPs []string `json:"ips"`
+}
+
+// guestAddressFromFrame returns the IP address announced by the VM
+// with the specified hardware address in an ARP or NDP packet
+// contained in the ethernet frame. It returns nil if the frame
+// doesn't contain such an address.
+func guestAddressFromFrame(frame []byte, mac net.HardwareAddr) net.IP {
+	if len(frame) < ethHeaderLen || !bytes.Equal(frame[6:12], mac) {
+		return nil
+	}
+	payload := frame[ethHeaderLen:]
+	var ip net.IP
+	switch binary.BigEndian.Uint16(frame[12:14]) {
+	case ethTypeARP:
+		// htype(2) ptype(2) hlen(1) plen(1) oper(2)
+		// sha(6) spa(4) tha(6) tpa(4)
+		if len(payload) < arpPacketLen ||
+			binary.BigEndian.Uint16(payload[0:2]) != arpHwTypeEther ||
+			binary.BigEndian.Uint16(payload[2:4]) != arpProtoIPv4 ||
+			payload[4] != 6 || payload[5] != 4 ||
+			!bytes.Equal(payload[8:14], mac) {
+			return nil
+		}
+		ip = net.IPv4(payload[14], payload[15], payload[16], payload[17])
+	case ethTypeIPv6:
+		// NDP packets can't have extension headers
+		if len(payload) < ipv6HeaderLen+ndpPacketLen || payload[6] != ipProtoICMPv6 || payload[7] != ndpHopLimit {
+			return nil
+		}
+		icmp := payload[ipv6HeaderLen:]
+		switch icmp[0] {
+		case icmpv6NeighborSolicitation:
+			// the source address is unspecified
+			// during duplicate address detection
+			ip = append(net.IP(nil), payload[8:24]...)
+		case icmpv6NeighborAdvertisement:
+			ip = append(net.IP(nil), icmp[8:24]...)
+		default:
+			return nil
+		}
+	default:
+		return nil
+	}
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsMulticast() || ip.IsLinkLocalUnicast() {
+		return nil
+	}
+	return ip
+}
+
+// addrSnooper learns the IP addresses used by the VM by listening
+// to the ARP and NDP packets that the VM sends via its tap
+// interface. It's used for the VMs which don't get their addresses
+// from Virtlet's DHCP server, e.g. because their network settings
+// are hardcoded in the image.
+type addrSnooper struct {
+	sync.Mutex
+	fd     int
+	name   string
+	mac    net.HardwareAddr
+	ips    []net.IP
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// newAddrSnooper starts snooping on the link with the specified
+// index which corresponds to the VM interface with the specified
+// name and hardware address. It must be called in the network
+// namespace of the link.
+func newAddrSnooper(linkIndex int, name string, mac net.HardwareAddr) (*addrSnooper, error) {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ALL)))
+	if err != nil {
+		return nil, fmt.Errorf("error creating packet socket: %v", err)
+	}
+	tv := syscall.NsecToTimeval(snoopReadTimeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("error setting packet socket timeout: %v", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{
+		Protocol: htons(syscall.ETH_P_ALL),
+		Ifindex:  linkIndex,
+	}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("error binding packet socket: %v", err)
+	}
+	s := &addrSnooper{
+		fd:     fd,
+		name:   name,
+		mac:    mac,
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *addrSnooper) run() {
+	defer close(s.doneCh)
+	buf := make([]byte, snoopBufSize)
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		default:
+		}
+		n, err := syscall.Read(s.fd, buf)
+		switch {
+		case err == syscall.EAGAIN || err == syscall.EINTR:
+			continue
+		case err != nil:
+			glog.Warningf("Error reading from the packet socket for %s (%s), stopping guest address detection: %v", s.name, s.mac, err)
+			return
+		}
+		if ip := guestAddressFromFrame(buf[:n], s.mac); ip != nil {
+			s.add(ip)
+		}
+	}
+}
+
+func (s *addrSnooper) add(ip net.IP) {
+	s.Lock()
+	defer s.Unlock()
+	for _, curIP := range s.ips {
+		if curIP.Equal(ip) {
+			return
+		}
+	}
+	if len(s.ips) >= maxGuestAddresses {
+		return
+	}
+	glog.V(3).Infof("Detected guest address %s on %s (%s)", ip, s.name, s.mac)
+	s.ips = append(s.ips, ip)
+}
+
+// addresses returns the addresses learned so far.
+func (s *addrSnooper) addresses() GuestAddresses {
+	s.Lock()
+	defer s.Unlock()
+	r := GuestAddresses{
+		Name: s.name,
+		Mac:  s.mac.String(),
+		IPs:  []string{},
+	}
+	for _, ip := range s.ips {
+		r.IPs = append(r.IPs, ip.String())
+	}
+	return r
+}
+
+// stop stops the snooper and closes its socket.
+func (s *addrSnooper) stop() {
+	close(s.stopCh)
+	<-s.doneCh
+	syscall.Close(s.fd)
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tapmanager
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+)
+
+var (
+	testGuestMac = net.HardwareAddr{0x42, 0xa4, 0xa6, 0x22, 0x80, 0x2e}
+	testOtherMac = net.HardwareAddr{0x42, 0xa4, 0xa6, 0x22, 0x80, 0x2f}
+)
+
+func ethFrame(srcMac net.HardwareAddr, ethType uint16, payload []byte) []byte {
+	frame := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	frame = append(frame, srcMac...)
+	frame = append(frame, byte(ethType>>8), byte(ethType))
+	return append(frame, payload...)
+}
+
+func arpPacket(op byte, senderMac net.HardwareAddr, senderIP, targetIP string) []byte {
+	p := []byte{0, 1, 8, 0, 6, 4, 0, op}
+	p = append(p, senderMac...)
+	p = append(p, net.ParseIP(senderIP).To4()...)
+	p = append(p, 0, 0, 0, 0, 0, 0)
+	return append(p, net.ParseIP(targetIP).To4()...)
+}
+
+func ndpPacket(icmpType, hopLimit byte, srcIP, targetIP string) []byte {
+	p := []byte{0x60, 0, 0, 0, 0, 32, ipProtoICMPv6, hopLimit}
+	p = append(p, net.ParseIP(srcIP).To16()...)
+	p = append(p, net.ParseIP("ff02::1").To16()...)
+	p = append(p, icmpType, 0, 0, 0, 0, 0, 0, 0)
+	return append(p, net.ParseIP(targetIP).To16()...)
+}
+
+func TestGuestAddressFromFrame(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		frame      []byte
+		expectedIP string
+	}{
+		{
+			name:       "ARP request",
+			frame:      ethFrame(testGuestMac, ethTypeARP, arpPacket(1, testGuestMac, "10.1.90.5", "10.1.90.1")),
+			expectedIP: "10.1.90.5",
+		},
+		{
+			name:       "ARP reply",
+			frame:      ethFrame(testGuestMac, ethTypeARP, arpPacket(2, testGuestMac, "10.1.90.5", "10.1.90.1")),
+			expectedIP: "10.1.90.5",
+		},
+		{
+			name:  "ARP probe",
+			frame: ethFrame(testGuestMac, ethTypeARP, arpPacket(1, testGuestMac, "0.0.0.0", "10.1.90.5")),
+		},
+		{
+			name:  "ARP from another host",
+			frame: ethFrame(testOtherMac, ethTypeARP, arpPacket(1, testOtherMac, "10.1.90.6", "10.1.90.1")),
+		},
+		{
+			name:  "ARP with mismatching sender hardware address",
+			frame: ethFrame(testGuestMac, ethTypeARP, arpPacket(1, testOtherMac, "10.1.90.6", "10.1.90.1")),
+		},
+		{
+			name:  "truncated ARP",
+			frame: ethFrame(testGuestMac, ethTypeARP, arpPacket(1, testGuestMac, "10.1.90.5", "10.1.90.1")[:20]),
+		},
+		{
+			name:       "neighbor solicitation",
+			frame:      ethFrame(testGuestMac, ethTypeIPv6, ndpPacket(icmpv6NeighborSolicitation, 255, "fd00::5", "fd00::1")),
+			expectedIP: "fd00::5",
+		},
+		{
+			name:  "neighbor solicitation during DAD",
+			frame: ethFrame(testGuestMac, ethTypeIPv6, ndpPacket(icmpv6NeighborSolicitation, 255, "::", "fd00::5")),
+		},
+		{
+			name:       "neighbor advertisement",
+			frame:      ethFrame(testGuestMac, ethTypeIPv6, ndpPacket(icmpv6NeighborAdvertisement, 255, "fe80::1", "fd00::5")),
+			expectedIP: "fd00::5",
+		},
+		{
+			name:  "link-local neighbor advertisement",
+			frame: ethFrame(testGuestMac, ethTypeIPv6, ndpPacket(icmpv6NeighborAdvertisement, 255, "fe80::1", "fe80::5")),
+		},
+		{
+			name:  "bad hop limit",
+			frame: ethFrame(testGuestMac, ethTypeIPv6, ndpPacket(icmpv6NeighborAdvertisement, 64, "fe80::1", "fd00::5")),
+		},
+		{
+			name:  "other ICMPv6",
+			frame: ethFrame(testGuestMac, ethTypeIPv6, ndpPacket(128, 255, "fd00::5", "fd00::1")),
+		},
+		{
+			name:  "IPv4",
+			frame: ethFrame(testGuestMac, 0x0800, make([]byte, 40)),
+		},
+		{
+			name:  "short frame",
+			frame: []byte{1, 2, 3},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ip := guestAddressFromFrame(tc.frame, testGuestMac)
+			switch {
+			case tc.expectedIP == "" && ip != nil:
+				t.Errorf("unexpected address %s", ip)
+			case tc.expectedIP != "" && !ip.Equal(net.ParseIP(tc.expectedIP)):
+				t.Errorf("bad address %v instead of %s", ip, tc.expectedIP)
+			}
+		})
+	}
+}
+
+func TestAddrSnooperAddresses(t *testing.T) {
+	s := &addrSnooper{name: "eth0", mac: testGuestMac}
+	s.add(net.ParseIP("10.1.90.5"))
+	s.add(net.ParseIP("fd00::5"))
+	s.add(net.ParseIP("10.1.90.5"))
+	for i := 0; i < maxGuestAddresses; i++ {
+		s.add(net.ParseIP(fmt.Sprintf("10.1.91.%d", i)))
+	}
+	expected := GuestAddresses{
+		Name: "eth0",
+		Mac:  "42:a4:a6:22:80:2e",
+		IPs: []string{
+			"10.1.90.5", "fd00::5", "10.1.91.0", "10.1.91.1",
+			"10.1.91.2", "10.1.91.3", "10.1.91.4", "10.1.91.5",
+		},
+	}
+	if addrs := s.addresses(); !reflect.DeepEqual(addrs, expected) {
+		t.Errorf("bad addresses: %#v instead of %#v", addrs, expected)
+	}
+}
@@ -29,6 +29,8 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+
+	"github.com/Mirantis/virtlet/pkg/faults"
 )
 
 const (
@@ -40,11 +42,19 @@ const (
 	fdRelease           = 1
 	fdGet               = 2
 	fdRecover           = 3
+	fdStats             = 4
+	fdTraces            = 5
+	fdGuestAddresses    = 6
+	fdNetworkInfo       = 7
 	fdResponse          = 0x80
 	fdAddResponse       = fdAdd | fdResponse
 	fdReleaseResponse   = fdRelease | fdResponse
 	fdGetResponse       = fdGet | fdResponse
 	fdRecoverResponse   = fdRecover | fdResponse
+	fdStatsResponse     = fdStats | fdResponse
+	fdTracesResponse    = fdTraces | fdResponse
+	fdGuestAddrResponse = fdGuestAddresses | fdResponse
+	fdNetInfoResponse   = fdNetworkInfo | fdResponse
 	fdError             = 0xff
 )
 
@@ -61,6 +71,21 @@ type FDManager interface {
 	// specified key. It's intended to be called after
 	// Virtlet restart.
 	Recover(key string, data interface{}) error
+	// GetStats returns the statistics of the resources
+	// associated with the specified key
+	GetStats(key string) ([]byte, error)
+	// GetTraces returns the traces of the recent operations
+	// on the resources associated with the specified key,
+	// or of all the operations if the key is empty
+	GetTraces(key string) ([]byte, error)
+	// GetGuestAddresses returns the IP addresses used by the VM
+	// associated with the specified key as learned by snooping
+	// its network traffic
+	GetGuestAddresses(key string) ([]byte, error)
+	// GetNetworkInfo returns the DHCP lease states, the neighbor
+	// table entries and the routes for the network associated
+	// with the specified key
+	GetNetworkInfo(key string) ([]byte, error)
 }
 
 type fdHeader struct {
@@ -108,6 +133,21 @@ type FDSource interface {
 	Recover(key string, data []byte) error
 	// RetrieveFDs retrieves FDs in case the FD is null
 	RetrieveFDs(key string) ([]int, error)
+	// GetStats returns the statistics of the resources
+	// associated with the specified key
+	GetStats(key string) ([]byte, error)
+	// GetTraces returns the traces of the recent operations
+	// on the resources associated with the specified key,
+	// or of all the operations if the key is empty
+	GetTraces(key string) ([]byte, error)
+	// GetGuestAddresses returns the IP addresses used by the VM
+	// associated with the specified key as learned by snooping
+	// its network traffic
+	GetGuestAddresses(key string) ([]byte, error)
+	// GetNetworkInfo returns the DHCP lease states, the neighbor
+	// table entries and the routes for the network associated
+	// with the specified key
+	GetNetworkInfo(key string) ([]byte, error)
 	// Stop stops any goroutines associated with FDSource
 	// but doesn't release the namespaces
 	Stop() error
@@ -331,6 +371,62 @@ func (s *FDServer) serveRecover(c *net.UnixConn, hdr *fdHeader) (*fdHeader, erro
 	}, nil
 }
 
+func (s *FDServer) serveStats(hdr *fdHeader) (*fdHeader, []byte, error) {
+	key := hdr.getKey()
+	stats, err := s.source.GetStats(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't get stats for key %q: %v", key, err)
+	}
+	return &fdHeader{
+		Magic:    fdMagic,
+		Command:  fdStatsResponse,
+		DataSize: uint32(len(stats)),
+		Key:      hdr.Key,
+	}, stats, nil
+}
+
+func (s *FDServer) serveTraces(hdr *fdHeader) (*fdHeader, []byte, error) {
+	key := hdr.getKey()
+	traces, err := s.source.GetTraces(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't get traces for key %q: %v", key, err)
+	}
+	return &fdHeader{
+		Magic:    fdMagic,
+		Command:  fdTracesResponse,
+		DataSize: uint32(len(traces)),
+		Key:      hdr.Key,
+	}, traces, nil
+}
+
+func (s *FDServer) serveGuestAddresses(hdr *fdHeader) (*fdHeader, []byte, error) {
+	key := hdr.getKey()
+	addrs, err := s.source.GetGuestAddresses(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't get guest addresses for key %q: %v", key, err)
+	}
+	return &fdHeader{
+		Magic:    fdMagic,
+		Command:  fdGuestAddrResponse,
+		DataSize: uint32(len(addrs)),
+		Key:      hdr.Key,
+	}, addrs, nil
+}
+
+func (s *FDServer) serveNetworkInfo(hdr *fdHeader) (*fdHeader, []byte, error) {
+	key := hdr.getKey()
+	info, err := s.source.GetNetworkInfo(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't get network info for key %q: %v", key, err)
+	}
+	return &fdHeader{
+		Magic:    fdMagic,
+		Command:  fdNetInfoResponse,
+		DataSize: uint32(len(info)),
+		Key:      hdr.Key,
+	}, info, nil
+}
+
 func (s *FDServer) serveConn(c *net.UnixConn) error {
 	defer c.Close()
 	for {
@@ -344,6 +440,10 @@ func (s *FDServer) serveConn(c *net.UnixConn) error {
 		if hdr.Magic != fdMagic {
 			return errors.New("bad magic")
 		}
+		if err := faults.Inject(faults.PointTapManagerRecv); err != nil {
+			// drop the request by closing the connection
+			return err
+		}
 
 		var err error
 		var respHdr *fdHeader
@@ -357,6 +457,14 @@ func (s *FDServer) serveConn(c *net.UnixConn) error {
 			respHdr, data, oobData, err = s.serveGet(c, &hdr)
 		case fdRecover:
 			respHdr, err = s.serveRecover(c, &hdr)
+		case fdStats:
+			respHdr, data, err = s.serveStats(&hdr)
+		case fdTraces:
+			respHdr, data, err = s.serveTraces(&hdr)
+		case fdGuestAddresses:
+			respHdr, data, err = s.serveGuestAddresses(&hdr)
+		case fdNetworkInfo:
+			respHdr, data, err = s.serveNetworkInfo(&hdr)
 		default:
 			err = errors.New("bad command")
 		}
@@ -595,3 +703,74 @@ func (c *FDClient) Recover(key string, data interface{}) error {
 	}
 	return nil
 }
+
+// GetStats requests the statistics of the resources associated
+// with the specified key from the FDServer. It returns the data
+// returned by FDSource's GetStats() call.
+func (c *FDClient) GetStats(key string) ([]byte, error) {
+	respHdr, respData, _, err := c.request(&fdHeader{
+		Command: fdStats,
+		Key:     fdKey(key),
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if respHdr.getKey() != key {
+		return nil, fmt.Errorf("fd key mismatch in the server response")
+	}
+	return respData, nil
+}
+
+// GetTraces requests the traces of the recent operations on the
+// resources associated with the specified key from the FDServer.
+// If the key is empty, the traces of all the operations are
+// requested. It returns the data returned by FDSource's
+// GetTraces() call.
+func (c *FDClient) GetTraces(key string) ([]byte, error) {
+	respHdr, respData, _, err := c.request(&fdHeader{
+		Command: fdTraces,
+		Key:     fdKey(key),
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if respHdr.getKey() != key {
+		return nil, fmt.Errorf("fd key mismatch in the server response")
+	}
+	return respData, nil
+}
+
+// GetGuestAddresses requests the IP addresses used by the VM
+// associated with the specified key from the FDServer. It returns
+// the data returned by FDSource's GetGuestAddresses() call.
+func (c *FDClient) GetGuestAddresses(key string) ([]byte, error) {
+	respHdr, respData, _, err := c.request(&fdHeader{
+		Command: fdGuestAddresses,
+		Key:     fdKey(key),
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if respHdr.getKey() != key {
+		return nil, fmt.Errorf("fd key mismatch in the server response")
+	}
+	return respData, nil
+}
+
+// GetNetworkInfo requests the DHCP lease states, the neighbor table
+// entries and the routes for the network associated with the
+// specified key from the FDServer. It returns the data returned by
+// FDSource's GetNetworkInfo() call.
+func (c *FDClient) GetNetworkInfo(key string) ([]byte, error) {
+	respHdr, respData, _, err := c.request(&fdHeader{
+		Command: fdNetworkInfo,
+		Key:     fdKey(key),
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if respHdr.getKey() != key {
+		return nil, fmt.Errorf("fd key mismatch in the server response")
+	}
+	return respData, nil
+}
@@ -159,6 +159,49 @@ func (s *sampleFDSource) GetInfo(key string) ([]byte, error) {
 	return []byte("info_" + key), nil
 }
 
+func (s *sampleFDSource) GetStats(key string) ([]byte, error) {
+	if s.stopped {
+		return nil, errors.New("sampleFDSource is stopped")
+	}
+
+	_, found := s.files[key]
+	if !found {
+		return nil, fmt.Errorf("file not found: %q", key)
+	}
+	return []byte("stats_" + key), nil
+}
+
+func (s *sampleFDSource) GetTraces(key string) ([]byte, error) {
+	if s.stopped {
+		return nil, errors.New("sampleFDSource is stopped")
+	}
+	return []byte("traces_" + key), nil
+}
+
+func (s *sampleFDSource) GetGuestAddresses(key string) ([]byte, error) {
+	if s.stopped {
+		return nil, errors.New("sampleFDSource is stopped")
+	}
+
+	_, found := s.files[key]
+	if !found {
+		return nil, fmt.Errorf("file not found: %q", key)
+	}
+	return []byte("addrs_" + key), nil
+}
+
+func (s *sampleFDSource) GetNetworkInfo(key string) ([]byte, error) {
+	if s.stopped {
+		return nil, errors.New("sampleFDSource is stopped")
+	}
+
+	_, found := s.files[key]
+	if !found {
+		return nil, fmt.Errorf("file not found: %q", key)
+	}
+	return []byte("netinfo_" + key), nil
+}
+
 func (s *sampleFDSource) Stop() error {
 	s.stopped = true
 	return nil
@@ -242,6 +285,49 @@ func TestFDServer(t *testing.T) {
 			verifyFD(t, c, key, data)
 		}
 
+		for _, data := range content {
+			key := "k_" + data
+			stats, err := c.GetStats(key)
+			if err != nil {
+				t.Fatalf("GetStats(): key %q: %v", key, err)
+			}
+			if expectedStats := "stats_" + key; string(stats) != expectedStats {
+				t.Errorf("bad stats: %q instead of %q", stats, expectedStats)
+			}
+		}
+
+		for _, data := range content {
+			key := "k_" + data
+			addrs, err := c.GetGuestAddresses(key)
+			if err != nil {
+				t.Fatalf("GetGuestAddresses(): key %q: %v", key, err)
+			}
+			if expectedAddrs := "addrs_" + key; string(addrs) != expectedAddrs {
+				t.Errorf("bad guest addresses: %q instead of %q", addrs, expectedAddrs)
+			}
+		}
+
+		for _, data := range content {
+			key := "k_" + data
+			info, err := c.GetNetworkInfo(key)
+			if err != nil {
+				t.Fatalf("GetNetworkInfo(): key %q: %v", key, err)
+			}
+			if expectedInfo := "netinfo_" + key; string(info) != expectedInfo {
+				t.Errorf("bad network info: %q instead of %q", info, expectedInfo)
+			}
+		}
+
+		for _, key := range []string{"k_foo", ""} {
+			traces, err := c.GetTraces(key)
+			if err != nil {
+				t.Fatalf("GetTraces(): key %q: %v", key, err)
+			}
+			if expectedTraces := "traces_" + key; string(traces) != expectedTraces {
+				t.Errorf("bad traces: %q instead of %q", traces, expectedTraces)
+			}
+		}
+
 		for _, data := range content {
 			key := "k_" + data
 			if err := c.ReleaseFDs(key); err != nil {
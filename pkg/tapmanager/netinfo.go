@@ -0,0 +1,181 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tapmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/ns"
+	"github.com/vishvananda/netlink"
+
+	"github.com/Mirantis/virtlet/pkg/cni"
+	"github.com/Mirantis/virtlet/pkg/dhcp"
+)
+
+// NeighborInfo describes an ARP or NDP neighbor table entry in the
+// pod network namespace.
+type NeighborInfo struct {
+	// Link is the name of the link the entry belongs to
+	Link string `json:"link"`
+	// IP is the IP address of the neighbor
+	IP string `json:"ip"`
+	// Mac is the hardware address of the neighbor, if known
+	Mac string `json:"mac,omitempty"`
+	// State is the state of the entry, e.g. REACHABLE or FAILED
+	State string `json:"state"`
+}
+
+// RouteInfo describes a route in the pod network namespace.
+type RouteInfo struct {
+	// Link is the name of the output link of the route
+	Link string `json:"link,omitempty"`
+	// Dst is the destination of the route, or "default" for
+	// the default route
+	Dst string `json:"dst"`
+	// Gw is the gateway address, if any
+	Gw string `json:"gw,omitempty"`
+	// Src is the preferred source address, if any
+	Src string `json:"src,omitempty"`
+}
+
+// PodNetworkInfo contains the information that is useful for
+// debugging the network of a VM pod.
+type PodNetworkInfo struct {
+	// Leases contains the states of DHCP exchanges with the VM
+	Leases []dhcp.Lease `json:"leases"`
+	// Neighbors contains ARP and NDP neighbor table entries
+	// in the pod network namespace
+	Neighbors []NeighborInfo `json:"neighbors"`
+	// Routes contains the routes programmed in the pod
+	// network namespace
+	Routes []RouteInfo `json:"routes"`
+}
+
+var neighStates = []struct {
+	state int
+	name  string
+}{
+	{netlink.NUD_INCOMPLETE, "INCOMPLETE"},
+	{netlink.NUD_REACHABLE, "REACHABLE"},
+	{netlink.NUD_STALE, "STALE"},
+	{netlink.NUD_DELAY, "DELAY"},
+	{netlink.NUD_PROBE, "PROBE"},
+	{netlink.NUD_FAILED, "FAILED"},
+	{netlink.NUD_NOARP, "NOARP"},
+	{netlink.NUD_PERMANENT, "PERMANENT"},
+}
+
+func neighStateString(state int) string {
+	var names []string
+	for _, s := range neighStates {
+		if state&s.state != 0 {
+			names = append(names, s.name)
+		}
+	}
+	if len(names) == 0 {
+		return "NONE"
+	}
+	return strings.Join(names, ",")
+}
+
+// GetNetworkInfo implements GetNetworkInfo method of FDSource
+// interface. It returns JSON-encoded PodNetworkInfo for the pod
+// with the DHCP lease states and the neighbor table entries and
+// routes retrieved from the pod network namespace.
+func (s *TapFDSource) GetNetworkInfo(key string) ([]byte, error) {
+	s.Lock()
+	pn, found := s.fdMap[key]
+	s.Unlock()
+	if !found {
+		return nil, fmt.Errorf("bad fd key: %q", key)
+	}
+
+	info := PodNetworkInfo{
+		Leases:    []dhcp.Lease{},
+		Neighbors: []NeighborInfo{},
+		Routes:    []RouteInfo{},
+	}
+	if pn.dhcpServer != nil {
+		info.Leases = pn.dhcpServer.Leases()
+	}
+
+	netNSPath := cni.PodNetNSPath(pn.pnd.PodID)
+	vmNS, err := ns.GetNS(netNSPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open network namespace at %q: %v", netNSPath, err)
+	}
+	defer vmNS.Close()
+
+	if err := vmNS.Do(func(ns.NetNS) error {
+		links, err := netlink.LinkList()
+		if err != nil {
+			return fmt.Errorf("error listing the links: %v", err)
+		}
+		linkNames := make(map[int]string)
+		for _, link := range links {
+			linkNames[link.Attrs().Index] = link.Attrs().Name
+		}
+
+		neighs, err := netlink.NeighList(0, netlink.FAMILY_ALL)
+		if err != nil {
+			return fmt.Errorf("error listing the neighbors: %v", err)
+		}
+		for _, neigh := range neighs {
+			ni := NeighborInfo{
+				Link:  linkNames[neigh.LinkIndex],
+				IP:    neigh.IP.String(),
+				State: neighStateString(neigh.State),
+			}
+			if len(neigh.HardwareAddr) != 0 {
+				ni.Mac = neigh.HardwareAddr.String()
+			}
+			info.Neighbors = append(info.Neighbors, ni)
+		}
+
+		routes, err := netlink.RouteList(nil, netlink.FAMILY_ALL)
+		if err != nil {
+			return fmt.Errorf("error listing the routes: %v", err)
+		}
+		for _, route := range routes {
+			ri := RouteInfo{
+				Link: linkNames[route.LinkIndex],
+				Dst:  "default",
+			}
+			if route.Dst != nil {
+				ri.Dst = route.Dst.String()
+			}
+			if route.Gw != nil {
+				ri.Gw = route.Gw.String()
+			}
+			if route.Src != nil {
+				ri.Src = route.Src.String()
+			}
+			info.Routes = append(info.Routes, ri)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling pod network info: %v", err)
+	}
+	return data, nil
+}
@@ -52,6 +52,36 @@ type InterfaceDescription struct {
 	PCIAddress   string                `json:"pciAddress"`
 }
 
+// InterfaceStats contains packet and byte counters for a VM network
+// interface. The counters are given from the VM's point of view,
+// i.e. RxBytes is the number of bytes received by the VM.
+type InterfaceStats struct {
+	// Name is the name of the pod network interface which
+	// corresponds to the VM interface, e.g. eth0
+	Name string `json:"name"`
+	// Network is the name of the CNI network the interface
+	// belongs to
+	Network string `json:"network"`
+	// Mac is the hardware address of the VM interface
+	Mac string `json:"mac"`
+	// RxBytes is the number of bytes received by the VM
+	RxBytes uint64 `json:"rxBytes"`
+	// RxPackets is the number of packets received by the VM
+	RxPackets uint64 `json:"rxPackets"`
+	// RxErrors is the number of receive errors
+	RxErrors uint64 `json:"rxErrors"`
+	// RxDropped is the number of dropped incoming packets
+	RxDropped uint64 `json:"rxDropped"`
+	// TxBytes is the number of bytes sent by the VM
+	TxBytes uint64 `json:"txBytes"`
+	// TxPackets is the number of packets sent by the VM
+	TxPackets uint64 `json:"txPackets"`
+	// TxErrors is the number of transmit errors
+	TxErrors uint64 `json:"txErrors"`
+	// TxDropped is the number of dropped outgoing packets
+	TxDropped uint64 `json:"txDropped"`
+}
+
 // PodNetworkDesc contains the data that are required by TapFDSource
 // to set up a tap device for a VM
 type PodNetworkDesc struct {
@@ -63,6 +93,11 @@ type PodNetworkDesc struct {
 	PodName string `json:"podName"`
 	// DNS specifies DNS settings for the pod
 	DNS *cnitypes.DNS
+	// BootOptions specifies network boot settings for the pod
+	BootOptions *network.BootOptions `json:"bootOptions,omitempty"`
+	// DetectGuestAddresses enables learning the IP addresses
+	// used by the VM from the ARP and NDP packets it sends
+	DetectGuestAddresses bool `json:"detectGuestAddresses,omitempty"`
 }
 
 // GetFDPayload contains the data that are required by TapFDSource
@@ -90,6 +125,14 @@ type podNetwork struct {
 	csn        *network.ContainerSideNetwork
 	dhcpServer *dhcp.Server
 	doneCh     chan error
+	snoopers   []*addrSnooper
+}
+
+func (pn *podNetwork) stopAddrSnoopers() {
+	for _, snooper := range pn.snoopers {
+		snooper.stop()
+	}
+	pn.snoopers = nil
 }
 
 // TapFDSource sets up and tears down Virtlet VM network.
@@ -103,6 +146,7 @@ type TapFDSource struct {
 	fdMap              map[string]*podNetwork
 	enableSriov        bool
 	calicoSubnetSize   int
+	traces             *networkTraceStore
 }
 
 var _ FDSource = &TapFDSource{}
@@ -115,6 +159,7 @@ func NewTapFDSource(cniClient cni.Client, enableSriov bool, calicoSubnetSize int
 		fdMap:            make(map[string]*podNetwork),
 		calicoSubnetSize: calicoSubnetSize,
 		enableSriov:      enableSriov,
+		traces:           newNetworkTraceStore(),
 	}
 
 	return s, nil
@@ -140,7 +185,17 @@ func (s *TapFDSource) GetFDs(key string, data []byte) ([]int, []byte, error) {
 		return nil, nil, fmt.Errorf("error unmarshalling GetFD payload: %v", err)
 	}
 	pnd := payload.Description
-	if err := cni.CreateNetNS(pnd.PodID); err != nil {
+	tr := newNetworkTracer("setup", pnd)
+	fds, respData, err := s.setUpPodNetwork(key, pnd, tr)
+	s.traces.add(key, tr.finish(err))
+	return fds, respData, err
+}
+
+func (s *TapFDSource) setUpPodNetwork(key string, pnd *PodNetworkDesc, tr *networkTracer) ([]int, []byte, error) {
+	start := time.Now()
+	err := cni.CreateNetNS(pnd.PodID)
+	tr.step("create-netns", start, err, "")
+	if err != nil {
 		return nil, nil, fmt.Errorf("error creating new netns for pod %s (%s): %v", pnd.PodName, pnd.PodID, err)
 	}
 
@@ -149,17 +204,25 @@ func (s *TapFDSource) GetFDs(key string, data []byte) ([]int, []byte, error) {
 	defer func() {
 		if gotError {
 			if podAddedToNetwork {
-				if err := s.cniClient.RemoveSandboxFromNetwork(pnd.PodID, pnd.PodName, pnd.PodNs); err != nil {
+				start := time.Now()
+				err := s.cniClient.RemoveSandboxFromNetwork(pnd.PodID, pnd.PodName, pnd.PodNs)
+				tr.step("cleanup-cni-del", start, err, "")
+				if err != nil {
 					glog.Errorf("Error removing a pod from the pod network after failed network setup: %v", err)
 				}
 			}
-			if err := cni.DestroyNetNS(pnd.PodID); err != nil {
+			start := time.Now()
+			err := cni.DestroyNetNS(pnd.PodID)
+			tr.step("cleanup-destroy-netns", start, err, "")
+			if err != nil {
 				glog.Errorf("Error removing netns after failed network setup: %v", err)
 			}
 		}
 	}()
 
+	start = time.Now()
 	netConfig, err := s.cniClient.AddSandboxToNetwork(pnd.PodID, pnd.PodName, pnd.PodNs)
+	tr.step("cni-add", start, err, "%s", describeCNIResult(netConfig))
 	if err != nil {
 		gotError = true
 		return nil, nil, fmt.Errorf("error adding pod %s (%s) to CNI network: %v", pnd.PodName, pnd.PodID, err)
@@ -171,30 +234,60 @@ func (s *TapFDSource) GetFDs(key string, data []byte) ([]int, []byte, error) {
 		netConfig = &cnicurrent.Result{}
 	}
 
-	if payload.Description.DNS != nil {
-		netConfig.DNS.Nameservers = pnd.DNS.Nameservers
-		netConfig.DNS.Search = pnd.DNS.Search
-		netConfig.DNS.Options = pnd.DNS.Options
+	// The DNS settings passed by kubelet take precedence, but
+	// the ones from the CNI result are used for any fields
+	// left empty by kubelet
+	if pnd.DNS != nil {
+		if len(pnd.DNS.Nameservers) != 0 {
+			netConfig.DNS.Nameservers = pnd.DNS.Nameservers
+		}
+		if len(pnd.DNS.Search) != 0 {
+			netConfig.DNS.Search = pnd.DNS.Search
+		}
+		if len(pnd.DNS.Options) != 0 {
+			netConfig.DNS.Options = pnd.DNS.Options
+		}
 	}
 
 	var fds []int
 	var respData []byte
 	var csn *network.ContainerSideNetwork
-	if err := s.setupNetNS(key, pnd, func(netNSPath string, allLinks []netlink.Link, hostNS ns.NetNS) (*network.ContainerSideNetwork, error) {
-		if netConfig, err = nettools.ValidateAndFixCNIResult(netConfig, netNSPath, allLinks); err != nil {
+	if err := s.setupNetNS(key, pnd, tr, func(netNSPath string, allLinks []netlink.Link, hostNS ns.NetNS) (*network.ContainerSideNetwork, error) {
+		var err error
+		start := time.Now()
+		netConfig, err = nettools.ValidateAndFixCNIResult(netConfig, netNSPath, allLinks)
+		tr.step("fix-cni-result", start, err, "%s", describeCNIResult(netConfig))
+		if err != nil {
 			gotError = true
 			return nil, fmt.Errorf("error fixing cni configuration: %v", err)
 		}
-		if err := nettools.FixCalicoNetworking(netConfig, s.calicoSubnetSize, s.getDummyNetwork); err != nil {
+		// the self-test must be done before the pod network
+		// is handed over to the VM and
+		// before the Calico fix, which replaces the gateway
+		start = time.Now()
+		selfTestProblems := nettools.NetworkSelfTest(netConfig)
+		tr.step("self-test", start, nil, "%s", strings.Join(selfTestProblems, "; "))
+		if len(selfTestProblems) != 0 {
+			glog.Warningf("Network self-test failed for pod %s (%s): %s", pnd.PodName, pnd.PodID, strings.Join(selfTestProblems, "; "))
+		}
+
+		start = time.Now()
+		err = nettools.FixCalicoNetworking(netConfig, s.calicoSubnetSize, s.getDummyNetwork)
+		tr.step("calico-fix", start, err, "")
+		if err != nil {
 			// don't fail in this case because there may be even no Calico
 			glog.Warningf("Calico detection/fix didn't work: %v", err)
 		}
 		glog.V(3).Infof("CNI Result after fix:\n%s", spew.Sdump(netConfig))
 
-		var err error
-		if csn, err = nettools.SetupContainerSideNetwork(netConfig, netNSPath, allLinks, s.enableSriov, hostNS); err != nil {
+		start = time.Now()
+		csn, err = nettools.SetupContainerSideNetwork(netConfig, netNSPath, allLinks, s.enableSriov, hostNS)
+		if err != nil {
+			tr.step("setup-container-side-network", start, err, "")
 			return nil, err
 		}
+		tr.step("setup-container-side-network", start, nil, "%s", describeInterfaces(csn))
+		csn.SelfTestProblems = selfTestProblems
 
 		if respData, err = json.Marshal(csn); err != nil {
 			return nil, fmt.Errorf("error marshalling net config: %v", err)
@@ -221,6 +314,13 @@ func (s *TapFDSource) Release(key string) error {
 		return fmt.Errorf("bad fd key: %q", key)
 	}
 
+	tr := newNetworkTracer("teardown", &pn.pnd)
+	err := s.tearDownPodNetwork(key, pn, tr)
+	s.traces.add(key, tr.finish(err))
+	return err
+}
+
+func (s *TapFDSource) tearDownPodNetwork(key string, pn *podNetwork, tr *networkTracer) error {
 	netNSPath := cni.PodNetNSPath(pn.pnd.PodID)
 
 	vmNS, err := ns.GetNS(netNSPath)
@@ -233,26 +333,42 @@ func (s *TapFDSource) Release(key string) error {
 	// to call `RunPodSandbox` again after a failed attempt. Failing to do so would cause
 	// the next `RunPodSandbox` call to fail due to the netns already being present.
 	defer func() {
-		if err := cni.DestroyNetNS(pn.pnd.PodID); err != nil {
+		start := time.Now()
+		err := cni.DestroyNetNS(pn.pnd.PodID)
+		tr.step("destroy-netns", start, err, "")
+		if err != nil {
 			glog.Errorf("Error when removing network namespace for pod sandbox %q: %v", pn.pnd.PodID, err)
 		}
 	}()
 
-	if err := nettools.ReconstructVFs(pn.csn, vmNS, false); err != nil {
+	start := time.Now()
+	err = nettools.ReconstructVFs(pn.csn, vmNS, false)
+	tr.step("reconstruct-vfs", start, err, "")
+	if err != nil {
 		return fmt.Errorf("failed to reconstruct SR-IOV devices: %v", err)
 	}
 
 	if err := vmNS.Do(func(ns.NetNS) error {
-		if err := pn.dhcpServer.Close(); err != nil {
+		start := time.Now()
+		err := pn.dhcpServer.Close()
+		tr.step("stop-dhcp-server", start, err, "")
+		if err != nil {
 			return fmt.Errorf("failed to stop dhcp server: %v", err)
 		}
 		<-pn.doneCh
-		return nettools.Teardown(pn.csn)
+		pn.stopAddrSnoopers()
+		start = time.Now()
+		err = nettools.Teardown(pn.csn)
+		tr.step("teardown-container-side-network", start, err, "%s", describeInterfaces(pn.csn))
+		return err
 	}); err != nil {
 		return err
 	}
 
-	if err := s.cniClient.RemoveSandboxFromNetwork(pn.pnd.PodID, pn.pnd.PodName, pn.pnd.PodNs); err != nil {
+	start = time.Now()
+	err = s.cniClient.RemoveSandboxFromNetwork(pn.pnd.PodID, pn.pnd.PodName, pn.pnd.PodNs)
+	tr.step("cni-del", start, err, "")
+	if err != nil {
 		return fmt.Errorf("error removing pod sandbox %q from CNI network: %v", pn.pnd.PodID, err)
 	}
 
@@ -284,8 +400,111 @@ func (s *TapFDSource) GetInfo(key string) ([]byte, error) {
 	return data, nil
 }
 
-// Stop stops any running DHCP servers associated with TapFDSource
-// and closes tap fds without releasing any other resources.
+// GetStats implements GetStats method of FDSource interface.
+// It returns JSON-encoded list of InterfaceStats for the tap
+// interfaces of the pod. SR-IOV VFs are skipped as they're
+// passed directly to the VM.
+func (s *TapFDSource) GetStats(key string) ([]byte, error) {
+	s.Lock()
+	pn, found := s.fdMap[key]
+	s.Unlock()
+	if !found {
+		return nil, fmt.Errorf("bad fd key: %q", key)
+	}
+
+	networkName, err := s.cniClient.NetworkName()
+	if err != nil {
+		glog.Warningf("Can't get the CNI network name for the stats of pod %s (%s): %v", pn.pnd.PodName, pn.pnd.PodID, err)
+	}
+
+	netNSPath := cni.PodNetNSPath(pn.pnd.PodID)
+	vmNS, err := ns.GetNS(netNSPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open network namespace at %q: %v", netNSPath, err)
+	}
+	defer vmNS.Close()
+
+	stats := []InterfaceStats{}
+	if err := vmNS.Do(func(ns.NetNS) error {
+		for i, iface := range pn.csn.Interfaces {
+			if iface.Type != network.InterfaceTypeTap {
+				continue
+			}
+			tapName := nettools.TapInterfaceName(i)
+			link, err := netlink.LinkByName(tapName)
+			if err != nil {
+				return fmt.Errorf("can't get tap link %q: %v", tapName, err)
+			}
+			ls := link.Attrs().Statistics
+			if ls == nil {
+				return fmt.Errorf("no statistics for tap link %q", tapName)
+			}
+			// what's transmitted via the tap is received by the VM
+			// and vice versa
+			stats = append(stats, InterfaceStats{
+				Name:      iface.Name,
+				Network:   networkName,
+				Mac:       iface.HardwareAddr.String(),
+				RxBytes:   ls.TxBytes,
+				RxPackets: ls.TxPackets,
+				RxErrors:  ls.TxErrors,
+				RxDropped: ls.TxDropped,
+				TxBytes:   ls.RxBytes,
+				TxPackets: ls.RxPackets,
+				TxErrors:  ls.RxErrors,
+				TxDropped: ls.RxDropped,
+			})
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling interface stats: %v", err)
+	}
+	return data, nil
+}
+
+// GetTraces returns JSON-encoded list of NetworkTrace records for
+// the recent network setups, teardowns and recoveries of the pod
+// with the specified key. If the key is empty, the traces for all
+// of the pods are returned. The traces are kept after the pod
+// network is torn down.
+func (s *TapFDSource) GetTraces(key string) ([]byte, error) {
+	data, err := json.Marshal(s.traces.get(key))
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling network traces: %v", err)
+	}
+	return data, nil
+}
+
+// GetGuestAddresses implements GetGuestAddresses method of FDSource
+// interface. It returns JSON-encoded list of GuestAddresses for the
+// tap interfaces of the pod. The list is empty unless guest address
+// detection is enabled for the pod.
+func (s *TapFDSource) GetGuestAddresses(key string) ([]byte, error) {
+	s.Lock()
+	pn, found := s.fdMap[key]
+	s.Unlock()
+	if !found {
+		return nil, fmt.Errorf("bad fd key: %q", key)
+	}
+
+	addrs := []GuestAddresses{}
+	for _, snooper := range pn.snoopers {
+		addrs = append(addrs, snooper.addresses())
+	}
+	data, err := json.Marshal(addrs)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling guest addresses: %v", err)
+	}
+	return data, nil
+}
+
+// Stop stops any running DHCP servers and guest address snoopers
+// associated with TapFDSource and closes tap fds without releasing any other resources.
 func (s *TapFDSource) Stop() error {
 	s.Lock()
 	defer s.Unlock()
@@ -296,6 +515,7 @@ func (s *TapFDSource) Stop() error {
 		} else {
 			<-pn.doneCh
 		}
+		pn.stopAddrSnoopers()
 		for _, i := range pn.csn.Interfaces {
 			if err := i.Fo.Close(); err != nil {
 				errors = append(errors, fmt.Sprintf("error closing tap fd: %v", err))
@@ -323,18 +543,31 @@ func (s *TapFDSource) Recover(key string, data []byte) error {
 	if csn.Result == nil {
 		csn.Result = &cnicurrent.Result{}
 	}
+	tr := newNetworkTracer("recover", pnd)
+	err := s.recoverPodNetwork(key, pnd, csn, payload.HaveRunningContainers, tr)
+	s.traces.add(key, tr.finish(err))
+	return err
+}
+
+func (s *TapFDSource) recoverPodNetwork(key string, pnd *PodNetworkDesc, csn *network.ContainerSideNetwork, haveRunningContainers bool, tr *networkTracer) error {
 	netNSPath := cni.PodNetNSPath(pnd.PodID)
 	vmNS, err := ns.GetNS(netNSPath)
 	if err != nil {
 		return fmt.Errorf("failed to open network namespace at %q: %v", netNSPath, err)
 	}
-	if !payload.HaveRunningContainers {
-		if err := nettools.ReconstructVFs(csn, vmNS, true); err != nil {
+	if !haveRunningContainers {
+		start := time.Now()
+		err := nettools.ReconstructVFs(csn, vmNS, true)
+		tr.step("reconstruct-vfs", start, err, "")
+		if err != nil {
 			return err
 		}
 	}
-	return s.setupNetNS(key, pnd, func(netNSPath string, allLinks []netlink.Link, hostNS ns.NetNS) (*network.ContainerSideNetwork, error) {
-		if err := nettools.RecoverContainerSideNetwork(csn, netNSPath, allLinks, hostNS); err != nil {
+	return s.setupNetNS(key, pnd, tr, func(netNSPath string, allLinks []netlink.Link, hostNS ns.NetNS) (*network.ContainerSideNetwork, error) {
+		start := time.Now()
+		err := nettools.RecoverContainerSideNetwork(csn, netNSPath, allLinks, hostNS)
+		tr.step("recover-container-side-network", start, err, "%s", describeInterfaces(csn))
+		if err != nil {
 			return nil, err
 		}
 		return csn, nil
@@ -382,7 +615,7 @@ func (s *TapFDSource) RetrieveFDs(key string) ([]int, error) {
 	return fds, nil
 }
 
-func (s *TapFDSource) setupNetNS(key string, pnd *PodNetworkDesc, initNet func(netNSPath string, allLinks []netlink.Link, hostNS ns.NetNS) (*network.ContainerSideNetwork, error)) error {
+func (s *TapFDSource) setupNetNS(key string, pnd *PodNetworkDesc, tr *networkTracer, initNet func(netNSPath string, allLinks []netlink.Link, hostNS ns.NetNS) (*network.ContainerSideNetwork, error)) error {
 	netNSPath := cni.PodNetNSPath(pnd.PodID)
 	vmNS, err := ns.GetNS(netNSPath)
 	if err != nil {
@@ -391,9 +624,12 @@ func (s *TapFDSource) setupNetNS(key string, pnd *PodNetworkDesc, initNet func(n
 
 	var csn *network.ContainerSideNetwork
 	var dhcpServer *dhcp.Server
+	var snoopers []*addrSnooper
 	doneCh := make(chan error)
 	if err := utils.CallInNetNSWithSysfsRemounted(vmNS, func(hostNS ns.NetNS) error {
+		start := time.Now()
 		allLinks, err := netlink.LinkList()
+		tr.step("list-links", start, err, "%s", describeLinks(allLinks))
 		if err != nil {
 			return fmt.Errorf("error listing the links: %v", err)
 		}
@@ -402,8 +638,11 @@ func (s *TapFDSource) setupNetNS(key string, pnd *PodNetworkDesc, initNet func(n
 			return err
 		}
 
-		dhcpServer = dhcp.NewServer(csn)
-		if err := dhcpServer.SetupListener("0.0.0.0"); err != nil {
+		dhcpServer = dhcp.NewServer(csn, pnd.BootOptions)
+		start = time.Now()
+		err = dhcpServer.SetupListener("0.0.0.0")
+		tr.step("setup-dhcp-listener", start, err, "")
+		if err != nil {
 			return fmt.Errorf("Failed to set up dhcp listener: %v", err)
 		}
 		go func() {
@@ -420,6 +659,17 @@ func (s *TapFDSource) setupNetNS(key string, pnd *PodNetworkDesc, initNet func(n
 		// (happens if the VM makes DHCP request before DHCP server is ready)
 		// For now, let's make the probability of such problem even smaller
 		time.Sleep(500 * time.Millisecond)
+
+		if pnd.DetectGuestAddresses {
+			start = time.Now()
+			snoopers, err = startAddrSnoopers(csn)
+			tr.step("start-address-snoopers", start, err, "")
+			if err != nil {
+				// the VM can still work without address
+				// detection, so it's not treated as an error
+				glog.Warningf("Can't detect guest addresses for pod %s (%s): %v", pnd.PodName, pnd.PodID, err)
+			}
+		}
 		return nil
 	}); err != nil {
 		return err
@@ -432,6 +682,32 @@ func (s *TapFDSource) setupNetNS(key string, pnd *PodNetworkDesc, initNet func(n
 		csn:        csn,
 		dhcpServer: dhcpServer,
 		doneCh:     doneCh,
+		snoopers:   snoopers,
 	}
 	return nil
 }
+
+// startAddrSnoopers starts snooping on the tap interfaces of the
+// pod. It must be called in the network namespace of the pod.
+func startAddrSnoopers(csn *network.ContainerSideNetwork) ([]*addrSnooper, error) {
+	var snoopers []*addrSnooper
+	for i, iface := range csn.Interfaces {
+		if iface.Type != network.InterfaceTypeTap {
+			continue
+		}
+		tapName := nettools.TapInterfaceName(i)
+		link, err := netlink.LinkByName(tapName)
+		var snooper *addrSnooper
+		if err == nil {
+			snooper, err = newAddrSnooper(link.Attrs().Index, iface.Name, iface.HardwareAddr)
+		}
+		if err != nil {
+			for _, snooper := range snoopers {
+				snooper.stop()
+			}
+			return nil, fmt.Errorf("can't snoop on tap link %q: %v", tapName, err)
+		}
+		snoopers = append(snoopers, snooper)
+	}
+	return snoopers, nil
+}
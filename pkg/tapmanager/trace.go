@@ -0,0 +1,222 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tapmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cnicurrent "github.com/containernetworking/cni/pkg/types/current"
+	"github.com/vishvananda/netlink"
+
+	"github.com/Mirantis/virtlet/pkg/network"
+)
+
+const (
+	// maxTracesPerPod is the max number of network traces
+	// kept for each pod sandbox
+	maxTracesPerPod = 8
+	// maxTracedPods is the max number of pod sandboxes for
+	// which the network traces are kept. The traces are
+	// kept after the pod network is torn down so the failures
+	// can be investigated after the fact, so the traces of
+	// the pods that were seen least recently are dropped
+	// when the limit is reached.
+	maxTracedPods = 128
+)
+
+// NetworkTraceStep describes a single step of the pod network
+// setup or teardown.
+type NetworkTraceStep struct {
+	// Name is the name of the step, e.g. cni-add
+	Name string `json:"name"`
+	// StartTime is the time when the step was started
+	StartTime time.Time `json:"startTime"`
+	// Duration is the duration of the step
+	Duration string `json:"duration"`
+	// Details contains the results of the step, e.g.
+	// the CNI result or the list of the links in the
+	// pod network namespace
+	Details string `json:"details,omitempty"`
+	// Error contains the error message if the step failed
+	Error string `json:"error,omitempty"`
+}
+
+// NetworkTrace contains the record of a pod network setup,
+// teardown or recovery.
+type NetworkTrace struct {
+	// PodID is the id of the pod sandbox
+	PodID string `json:"podId"`
+	// PodNs is the namespace of the pod
+	PodNs string `json:"podNs"`
+	// PodName is the name of the pod
+	PodName string `json:"podName"`
+	// Operation is either setup, teardown or recover
+	Operation string `json:"operation"`
+	// StartTime is the time when the operation was started
+	StartTime time.Time `json:"startTime"`
+	// Duration is the duration of the operation
+	Duration string `json:"duration"`
+	// Steps lists the steps of the operation
+	Steps []NetworkTraceStep `json:"steps"`
+	// Error contains the error message if the operation failed
+	Error string `json:"error,omitempty"`
+}
+
+// networkTracer records a NetworkTrace.
+type networkTracer struct {
+	trace NetworkTrace
+	start time.Time
+}
+
+func newNetworkTracer(operation string, pnd *PodNetworkDesc) *networkTracer {
+	start := time.Now()
+	return &networkTracer{
+		trace: NetworkTrace{
+			PodID:     pnd.PodID,
+			PodNs:     pnd.PodNs,
+			PodName:   pnd.PodName,
+			Operation: operation,
+			StartTime: start,
+			Steps:     []NetworkTraceStep{},
+		},
+		start: start,
+	}
+}
+
+// step records a step that was started at the specified time and
+// has just finished with the specified error. If format is not
+// empty, it's used to format the details of the step.
+func (t *networkTracer) step(name string, start time.Time, err error, format string, args ...interface{}) {
+	s := NetworkTraceStep{
+		Name:      name,
+		StartTime: start,
+		Duration:  time.Since(start).String(),
+	}
+	if format != "" {
+		s.Details = fmt.Sprintf(format, args...)
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	t.trace.Steps = append(t.trace.Steps, s)
+}
+
+// finish finalizes the trace using the specified error as the
+// result of the operation.
+func (t *networkTracer) finish(err error) *NetworkTrace {
+	t.trace.Duration = time.Since(t.start).String()
+	if err != nil {
+		t.trace.Error = err.Error()
+	}
+	return &t.trace
+}
+
+// networkTraceStore keeps the recent network traces for each pod
+// sandbox.
+type networkTraceStore struct {
+	sync.Mutex
+	traces map[string][]*NetworkTrace
+	// keys holds the keys of the traces, least recently
+	// updated first
+	keys []string
+}
+
+func newNetworkTraceStore() *networkTraceStore {
+	return &networkTraceStore{traces: make(map[string][]*NetworkTrace)}
+}
+
+func (s *networkTraceStore) add(key string, trace *NetworkTrace) {
+	s.Lock()
+	defer s.Unlock()
+	for n, k := range s.keys {
+		if k == key {
+			s.keys = append(s.keys[:n], s.keys[n+1:]...)
+			break
+		}
+	}
+	s.keys = append(s.keys, key)
+	traces := append(s.traces[key], trace)
+	if len(traces) > maxTracesPerPod {
+		traces = traces[len(traces)-maxTracesPerPod:]
+	}
+	s.traces[key] = traces
+	if len(s.keys) > maxTracedPods {
+		delete(s.traces, s.keys[0])
+		s.keys = s.keys[1:]
+	}
+}
+
+// get returns the traces for the specified key, or all of the
+// traces sorted by their start time if the key is empty.
+func (s *networkTraceStore) get(key string) []*NetworkTrace {
+	s.Lock()
+	defer s.Unlock()
+	r := []*NetworkTrace{}
+	if key != "" {
+		return append(r, s.traces[key]...)
+	}
+	for _, traces := range s.traces {
+		r = append(r, traces...)
+	}
+	sort.SliceStable(r, func(i, j int) bool {
+		return r[i].StartTime.Before(r[j].StartTime)
+	})
+	return r
+}
+
+// describeCNIResult returns the CNI result as compact JSON.
+func describeCNIResult(result *cnicurrent.Result) string {
+	if result == nil {
+		return ""
+	}
+	out, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Sprintf("<error marshalling CNI result: %v>", err)
+	}
+	return string(out)
+}
+
+// describeLinks returns the names and the types of the links.
+func describeLinks(links []netlink.Link) string {
+	var r []string
+	for _, link := range links {
+		r = append(r, fmt.Sprintf("%s (%s)", link.Attrs().Name, link.Type()))
+	}
+	return strings.Join(r, ", ")
+}
+
+// describeInterfaces returns the names, the types and the hardware
+// addresses of the VM network interfaces.
+func describeInterfaces(csn *network.ContainerSideNetwork) string {
+	if csn == nil {
+		return ""
+	}
+	var r []string
+	for _, iface := range csn.Interfaces {
+		ifType := "tap"
+		if iface.Type == network.InterfaceTypeVF {
+			ifType = "vf"
+		}
+		r = append(r, fmt.Sprintf("%s (%s, %s)", iface.Name, ifType, iface.HardwareAddr))
+	}
+	return strings.Join(r, ", ")
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tapmanager
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNetworkTracer(t *testing.T) {
+	tr := newNetworkTracer("setup", &PodNetworkDesc{
+		PodID:   "69eec606-0493-5825-73a4-c5e0c0236155",
+		PodNs:   "default",
+		PodName: "foo",
+	})
+	tr.step("create-netns", time.Now(), nil, "")
+	tr.step("cni-add", time.Now(), errors.New("cni plugin failed"), "%d interfaces", 0)
+	trace := tr.finish(errors.New("setup failed"))
+
+	if trace.PodID != "69eec606-0493-5825-73a4-c5e0c0236155" || trace.PodNs != "default" || trace.PodName != "foo" || trace.Operation != "setup" {
+		t.Errorf("bad trace: %#v", trace)
+	}
+	if trace.Error != "setup failed" {
+		t.Errorf("bad trace error: %q", trace.Error)
+	}
+	if len(trace.Steps) != 2 {
+		t.Fatalf("bad number of steps: %d", len(trace.Steps))
+	}
+	if s := trace.Steps[0]; s.Name != "create-netns" || s.Details != "" || s.Error != "" {
+		t.Errorf("bad step: %#v", s)
+	}
+	if s := trace.Steps[1]; s.Name != "cni-add" || s.Details != "0 interfaces" || s.Error != "cni plugin failed" {
+		t.Errorf("bad step: %#v", s)
+	}
+}
+
+func TestNetworkTraceStore(t *testing.T) {
+	s := newNetworkTraceStore()
+	startTime := time.Now()
+	for n := 0; n < maxTracedPods+1; n++ {
+		key := fmt.Sprintf("pod%d", n)
+		for i := 0; i < 2; i++ {
+			s.add(key, &NetworkTrace{
+				PodID:     key,
+				StartTime: startTime.Add(time.Duration(n*2+i) * time.Second),
+			})
+		}
+	}
+	// pod0 is the least recently seen pod
+	if traces := s.get("pod0"); len(traces) != 0 {
+		t.Errorf("the traces for pod0 were not evicted")
+	}
+	if traces := s.get("pod1"); len(traces) != 2 {
+		t.Errorf("bad number of traces for pod1: %d", len(traces))
+	}
+	all := s.get("")
+	if len(all) != maxTracedPods*2 {
+		t.Fatalf("bad total number of traces: %d", len(all))
+	}
+	for n := 1; n < len(all); n++ {
+		if all[n].StartTime.Before(all[n-1].StartTime) {
+			t.Errorf("the traces are not sorted by start time")
+			break
+		}
+	}
+
+	for n := 0; n < maxTracesPerPod+2; n++ {
+		s.add("pod1", &NetworkTrace{PodID: "pod1", Operation: fmt.Sprintf("op%d", n)})
+	}
+	traces := s.get("pod1")
+	if len(traces) != maxTracesPerPod {
+		t.Fatalf("bad number of traces for pod1: %d", len(traces))
+	}
+	if expectedOp := fmt.Sprintf("op%d", maxTracesPerPod+1); traces[len(traces)-1].Operation != expectedOp {
+		t.Errorf("bad last trace operation %q instead of %q", traces[len(traces)-1].Operation, expectedOp)
+	}
+	// pod1 became the most recently seen one, so adding
+	// another pod evicts pod2 instead of pod1
+	s.add("newpod", &NetworkTrace{PodID: "newpod"})
+	if len(s.get("pod2")) != 0 {
+		t.Errorf("the traces for pod2 were not evicted")
+	}
+	if len(s.get("pod1")) == 0 {
+		t.Errorf("the traces for pod1 were evicted")
+	}
+}
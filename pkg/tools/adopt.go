@@ -0,0 +1,229 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	"github.com/renstrom/dedent"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const adoptedVMContainerName = "vm"
+
+var (
+	badPodNameCharsRx = regexp.MustCompile(`[^a-z0-9-]+`)
+	memoryUnitFactors = map[string]int64{
+		"":      1024, // libvirt defaults to KiB
+		"b":     1,
+		"bytes": 1,
+		"KB":    1000,
+		"k":     1024,
+		"KiB":   1024,
+		"MB":    1000000,
+		"M":     1048576,
+		"MiB":   1048576,
+		"GB":    1000000000,
+		"G":     1073741824,
+		"GiB":   1073741824,
+	}
+)
+
+type adoptCommand struct {
+	client     KubeClient
+	out        io.Writer
+	domainName string
+	nodeName   string
+	podName    string
+	image      string
+	dryRun     bool
+}
+
+// NewAdoptCmd returns a cobra.Command that creates a VM pod for
+// a pre-existing libvirt domain.
+func NewAdoptCmd(client KubeClient, out io.Writer) *cobra.Command {
+	c := &adoptCommand{client: client, out: out}
+	cmd := &cobra.Command{
+		Use:   "adopt [flags] domain",
+		Short: "Create a VM pod for a pre-existing libvirt domain",
+		Long: dedent.Dedent(`
+                        This command creates a VM pod that takes over a libvirt
+                        domain which was defined on the node by other means,
+                        e.g. using virsh. The domain must be shut off. The
+                        disks of the domain are used by the VM instead of
+                        the root volume, and the VM keeps the SMBIOS UUID of
+                        the domain. The image specified by --image is only
+                        needed to satisfy Kubernetes and is not used by the VM.
+                        When the pod is deleted, the original domain is
+                        defined again with its disks left intact. The pod
+                        is created in the current namespace.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("must specify the domain")
+			}
+			c.domainName = args[0]
+			return c.run()
+		},
+	}
+	cmd.Flags().StringVar(&c.nodeName, "node", "", "The node that runs the domain")
+	cmd.Flags().StringVar(&c.image, "image", "", "The image to specify in the pod definition")
+	cmd.Flags().StringVar(&c.podName, "name", "", "The name of the pod (defaults to the domain name)")
+	cmd.Flags().BoolVar(&c.dryRun, "dry-run", false, "Only print the pod definition without creating the pod")
+	return cmd
+}
+
+func (c *adoptCommand) getDomain() (*libvirtxml.Domain, error) {
+	virtletPodName, err := c.client.GetVirtletPodNameForNode(c.nodeName)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get Virtlet pod name for node %q: %v", c.nodeName, err)
+	}
+	var buf bytes.Buffer
+	exitCode, err := c.client.ExecInContainer(virtletPodName, "libvirt", "kube-system", nil, &buf, os.Stderr, []string{"virsh", "dumpxml", c.domainName})
+	if err != nil {
+		return nil, fmt.Errorf("error executing virsh in Virtlet pod %q: %v", virtletPodName, err)
+	}
+	if exitCode != 0 {
+		return nil, fmt.Errorf("virsh returned non-zero exit code %d", exitCode)
+	}
+	var def libvirtxml.Domain
+	if err := def.Unmarshal(buf.String()); err != nil {
+		return nil, fmt.Errorf("error parsing the definition of domain %q: %v", c.domainName, err)
+	}
+	return &def, nil
+}
+
+func (c *adoptCommand) vmPodName() (string, error) {
+	if c.podName != "" {
+		return c.podName, nil
+	}
+	name := strings.Trim(badPodNameCharsRx.ReplaceAllString(strings.ToLower(c.domainName), "-"), "-")
+	if name == "" {
+		return "", fmt.Errorf("can't make a pod name from domain name %q, please use --name", c.domainName)
+	}
+	return name, nil
+}
+
+func domainMemoryBytes(def *libvirtxml.Domain) (int64, error) {
+	if def.Memory == nil {
+		return 0, nil
+	}
+	factor, found := memoryUnitFactors[def.Memory.Unit]
+	if !found {
+		return 0, fmt.Errorf("unsupported memory unit %q", def.Memory.Unit)
+	}
+	return int64(def.Memory.Value) * factor, nil
+}
+
+func domainVCPUCount(def *libvirtxml.Domain) int {
+	if def.VCPU == nil {
+		return 0
+	}
+	if n, err := strconv.Atoi(def.VCPU.Current); err == nil && n > 0 {
+		return n
+	}
+	return def.VCPU.Value
+}
+
+func (c *adoptCommand) vmPod(def *libvirtxml.Domain) (*v1.Pod, error) {
+	podName, err := c.vmPodName()
+	if err != nil {
+		return nil, err
+	}
+	annotations := map[string]string{
+		runtimeAnnotation:        virtletRuntime,
+		types.AdoptDomainKeyName: c.domainName,
+	}
+	if def.UUID != "" {
+		annotations[types.SystemUUIDKeyName] = def.UUID
+	}
+	if n := domainVCPUCount(def); n > 0 {
+		annotations[types.VCPUCountKeyName] = strconv.Itoa(n)
+	}
+	container := v1.Container{
+		Name:  adoptedVMContainerName,
+		Image: c.image,
+	}
+	memory, err := domainMemoryBytes(def)
+	if err != nil {
+		return nil, err
+	}
+	if memory > 0 {
+		container.Resources.Limits = v1.ResourceList{
+			v1.ResourceMemory: *resource.NewQuantity(memory, resource.BinarySI),
+		}
+	}
+	return &v1.Pod{
+		TypeMeta: meta_v1.TypeMeta{
+			Kind:       "Pod",
+			APIVersion: "v1",
+		},
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        podName,
+			Annotations: annotations,
+		},
+		Spec: v1.PodSpec{
+			NodeName:   c.nodeName,
+			Containers: []v1.Container{container},
+		},
+	}, nil
+}
+
+func (c *adoptCommand) run() error {
+	switch {
+	case c.nodeName == "":
+		return errors.New("must specify the node using --node")
+	case c.image == "":
+		return errors.New("must specify the image using --image")
+	case strings.HasPrefix(c.domainName, "virtlet-"):
+		return fmt.Errorf("domain %q is already managed by Virtlet", c.domainName)
+	}
+	def, err := c.getDomain()
+	if err != nil {
+		return err
+	}
+	pod, err := c.vmPod(def)
+	if err != nil {
+		return err
+	}
+	if c.dryRun {
+		out, err := ToYaml([]runtime.Object{pod})
+		if err != nil {
+			return fmt.Errorf("error converting the pod definition to yaml: %v", err)
+		}
+		_, err = c.out.Write(out)
+		return err
+	}
+	if _, err := c.client.CreatePod(pod); err != nil {
+		return fmt.Errorf("error creating pod %q: %v", pod.Name, err)
+	}
+	fmt.Fprintf(c.out, "pod/%s created\n", pod.Name)
+	return nil
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const legacyDomainXML = `<domain type="kvm">
+  <name>Legacy_VM</name>
+  <uuid>2a3ba6c9-0b4a-4e6c-9b7a-a1b5b1e3f0d2</uuid>
+  <memory unit="KiB">1048576</memory>
+  <vcpu placement="static">2</vcpu>
+  <devices>
+    <disk type="file" device="disk">
+      <driver name="qemu" type="qcow2"></driver>
+      <source file="/var/lib/libvirt/images/legacy.qcow2"></source>
+      <target dev="vda" bus="virtio"></target>
+    </disk>
+  </devices>
+</domain>`
+
+func TestAdoptCommand(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		args                string
+		expectedCommands    map[string]string
+		expectedPodName     string
+		expectedAnnotations map[string]string
+		expectedMemory      string
+		outputSubstring     string
+		errSubstring        string
+	}{
+		{
+			name: "adopt",
+			args: "Legacy_VM --node kube-node-1 --image cirros",
+			expectedCommands: map[string]string{
+				"virtlet-foo42/libvirt/kube-system: virsh dumpxml Legacy_VM": legacyDomainXML,
+			},
+			expectedPodName: "legacy-vm",
+			expectedAnnotations: map[string]string{
+				"kubernetes.io/target-runtime": "virtlet.cloud",
+				"VirtletAdoptDomain":           "Legacy_VM",
+				"VirtletSystemUUID":            "2a3ba6c9-0b4a-4e6c-9b7a-a1b5b1e3f0d2",
+				"VirtletVCPUCount":             "2",
+			},
+			expectedMemory:  "1Gi",
+			outputSubstring: "pod/legacy-vm created",
+		},
+		{
+			name: "adopt with pod name",
+			args: "Legacy_VM --node kube-node-1 --image cirros --name vm1",
+			expectedCommands: map[string]string{
+				"virtlet-foo42/libvirt/kube-system: virsh dumpxml Legacy_VM": legacyDomainXML,
+			},
+			expectedPodName: "vm1",
+			expectedAnnotations: map[string]string{
+				"kubernetes.io/target-runtime": "virtlet.cloud",
+				"VirtletAdoptDomain":           "Legacy_VM",
+				"VirtletSystemUUID":            "2a3ba6c9-0b4a-4e6c-9b7a-a1b5b1e3f0d2",
+				"VirtletVCPUCount":             "2",
+			},
+			expectedMemory:  "1Gi",
+			outputSubstring: "pod/vm1 created",
+		},
+		{
+			name: "dry run",
+			args: "Legacy_VM --node kube-node-1 --image cirros --dry-run",
+			expectedCommands: map[string]string{
+				"virtlet-foo42/libvirt/kube-system: virsh dumpxml Legacy_VM": legacyDomainXML,
+			},
+			outputSubstring: "VirtletAdoptDomain: Legacy_VM",
+		},
+		{
+			name:         "no node",
+			args:         "Legacy_VM --image cirros",
+			errSubstring: "must specify the node",
+		},
+		{
+			name:         "no image",
+			args:         "Legacy_VM --node kube-node-1",
+			errSubstring: "must specify the image",
+		},
+		{
+			name:         "virtlet domain",
+			args:         "virtlet-cc349e91-dcf7-vm --node kube-node-1 --image cirros",
+			errSubstring: "already managed by Virtlet",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &fakeKubeClient{
+				t: t,
+				virtletPods: map[string]string{
+					"kube-node-1": "virtlet-foo42",
+				},
+				expectedCommands: tc.expectedCommands,
+			}
+			var out bytes.Buffer
+			cmd := NewAdoptCmd(c, &out)
+			cmd.SetArgs(strings.Split(tc.args, " "))
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			err := cmd.Execute()
+			switch {
+			case err != nil && tc.errSubstring == "":
+				t.Fatalf("adopt command returned an unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Fatalf("Didn't get expected error (substring %q), output: %q", tc.errSubstring, out.String())
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Fatalf("Didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			case err == nil && !strings.Contains(out.String(), tc.outputSubstring):
+				t.Errorf("Didn't get expected substring %q in the output: %q", tc.outputSubstring, out.String())
+			}
+			for c := range tc.expectedCommands {
+				t.Errorf("command not executed: %q", c)
+			}
+
+			if tc.expectedPodName == "" {
+				if len(c.pods) != 0 {
+					t.Errorf("unexpected pods created: %#v", c.pods)
+				}
+				return
+			}
+			if len(c.pods) != 1 {
+				t.Fatalf("expected a single pod to be created, got %#v", c.pods)
+			}
+			pod := c.pods[0]
+			if pod.Name != tc.expectedPodName || pod.Spec.NodeName != "kube-node-1" {
+				t.Errorf("bad pod name or node: %q on %q", pod.Name, pod.Spec.NodeName)
+			}
+			if !reflect.DeepEqual(pod.Annotations, tc.expectedAnnotations) {
+				t.Errorf("bad pod annotations %#v instead of %#v", pod.Annotations, tc.expectedAnnotations)
+			}
+			if len(pod.Spec.Containers) != 1 || pod.Spec.Containers[0].Image != "cirros" {
+				t.Fatalf("bad containers in the pod: %#v", pod.Spec.Containers)
+			}
+			if memory := pod.Spec.Containers[0].Resources.Limits.Memory().String(); memory != tc.expectedMemory {
+				t.Errorf("bad memory limit %q instead of %q", memory, tc.expectedMemory)
+			}
+		})
+	}
+}
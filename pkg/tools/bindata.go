@@ -66,7 +66,7 @@ func (fi bindataFileInfo) Sys() interface{} {
 	return nil
 }
 
-var _deployDataVirtletDsYaml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xd4\x5a\xdd\x6f\xdb\x38\x12\x7f\xcf\x5f\x31\x68\x80\x6b\x0b\x9c\xe2\xa4\xb8\xbd\x76\x8d\xbb\x87\x34\xf6\x66\x8d\x26\xb6\xe1\x7c\x74\xdf\x0c\x9a\x1a\xcb\x5c\x53\xa4\x96\xa4\x94\xf8\xfe\xfa\x03\x49\x49\xd1\x97\x1d\x27\x4d\x82\x6e\x5e\xea\x92\x9c\x1f\x67\x86\xf3\x49\x31\x08\x82\x03\x92\xb0\x5b\x54\x9a\x49\xd1\x07\x92\x24\xba\x97\x9d\x1c\xac\x99\x08\xfb\x30\x20\x18\x4b\x71\x85\xe6\x20\x46\x43\x42\x62\x48\xff\x00\x40\x90\x18\xfb\x90\x31\x65\x38\x9a\xfc\xff\x3a\x21\x14\xfb\xb0\x4e\x17\x18\xe8\x8d\x36\x18\x1f\xe8\x04\xa9\x5d\xae\x91\x23\x35\x52\xd9\xdf\x00\x31\x31\x74\x75\x41\x16\xc8\xb5\x1f\x00\x50\xa9\x30\xac\x0e\x69\x30\x4e\x38\x31\x98\xd3\x54\x36\xb7\x7f\x4d\x06\xec\x1f\xaf\x41\x76\x82\x02\x14\x2c\xd9\xbf\x95\xd4\x66\x8c\xe6\x4e\xaa\x75\x1f\x8c\x4a\x31\x1f\x0f\x85\x9e\x4a\xce\xe8\xa6\x0f\x67\x3c\xd5\x06\xd5\x6f\x4c\x69\xf3\x9d\x99\xd5\xef\x9e\x24\x5f\x78\xe8\x20\xa6\xa3\x01\x30\xed\x00\xc0\x48\xf8\x70\xf2\x11\x50\x90\x05\x47\xb8\xbd\xd4\x76\x44\xa7\x2a\x63\x19\x16\x7c\x00\x95\xc2\x10\x26\x50\x81\x42\x6d\x88\x7a\x80\xfb\x60\x24\x2c\x10\xe8\x0a\xe9\x1a\xc3\x8f\x40\x44\x08\x1f\x3e\x7d\xb4\x20\x39\xa4\x59\x21\xa4\x1a\x41\x2e\x41\x68\x14\x06\x15\x30\x01\x4c\xb0\x0a\x6c\x45\xbc\xe9\x68\x50\x13\xed\x10\x16\x52\x1a\x6d\x14\x49\x20\x51\x92\x62\x98\x2a\x04\x81\x18\x3a\x4e\xa9\x42\x62\x10\x88\xc5\x5a\xb2\x28\x26\x89\x45\xaf\x1c\xe9\xc3\x49\xe7\x80\x1a\x55\xc6\x28\x9e\x52\x2a\x53\x61\xc6\xb5\x63\x29\xf7\x94\x82\x6f\xec\x71\xc0\x6d\xae\x81\x44\x86\x1a\xa4\x70\xd2\x08\x19\xa2\x86\x3b\x66\x56\x80\xf7\x46\x91\x99\x3f\xb6\xff\x16\xda\x72\xc7\x9a\x43\x91\xe5\xd2\x8a\xba\x79\x38\x64\x4b\x7d\xda\x1a\x05\x50\xf8\x57\xca\x14\x86\x83\x54\x31\x11\x5d\xd1\x15\x86\x29\x67\x22\x1a\x45\x42\x96\xc3\xc3\x7b\xa4\xa9\xb1\x56\x5f\xa1\xf4\x98\x57\xb9\xc9\x5e\xa3\x8a\x75\x7d\x3a\xf0\x16\x3c\xbc\x4f\x14\x6a\xeb\x33\x8d\x79\xbb\x62\x8d\x9b\x7e\x4d\x9c\xc6\x0a\x00\x99\xa0\x22\xd6\x27\x60\x24\x5a\x93\x19\xe1\x29\xb6\x60\x2d\x70\x43\xb7\x56\xee\xb3\xe2\xdc\x4b\x82\x43\xb8\x5e\x61\xc3\x28\x80\xca\x84\xa1\x2e\x00\xde\x6b\x58\x72\xbc\xcf\x24\x4f\x63\x84\x50\xb1\xac\xb4\x9b\x43\x6b\x09\xf6\x64\x42\x5c\x92\x94\x1b\x77\xfe\xee\xd4\x78\x1a\x31\x01\x21\x53\xce\x30\x51\xe8\x54\xa1\x06\xb3\x22\x0f\x16\xec\xe8\x98\x72\xba\xb3\xdb\x59\xd3\xc2\x10\x16\x1b\xe0\x6c\x61\xf7\x86\x7f\x94\x7e\x80\xf7\x4c\x9b\xc2\x0c\xac\xb5\x1e\x14\x52\x7a\xf7\x4e\x14\x26\x44\x61\x60\xcf\xa3\x54\x05\x8b\x49\x84\x7d\x88\x99\x22\xc2\x30\xdd\xab\xc7\x80\x7c\x7e\x9a\x72\x5e\xb8\xf0\x68\x39\x96\x66\xaa\xd0\x7a\x4b\xb9\x8a\xca\x38\x26\x22\x7c\xd0\x70\x00\xbd\xea\x76\x47\x7a\x55\x4e\x79\x1d\x5d\x5a\xfb\xd6\x55\x02\xcf\xe4\xfa\x8b\x0e\x1e\x34\x19\x78\x1d\xe9\x20\x64\xaa\x72\x7a\xb1\x25\x9e\x12\xb3\xea\x43\x2f\xd7\x66\x50\x27\x68\xe1\xaa\xb4\x6a\x16\x87\x30\x90\xe2\xbd\x01\x12\x86\xf0\xce\xa3\x29\x99\x90\x88\x38\xeb\x85\xaf\xcc\xeb\x9c\x49\x41\xf8\xbb\x7f\x02\x33\x70\xc7\x38\x07\x4e\xe8\xda\x6f\x0e\x28\x8c\xda\x6c\x61\xa9\xba\x57\xb1\x7f\x28\xe9\x1a\x95\x96\x74\xbd\x85\x28\x23\xca\x12\xf6\xfc\xc2\xa3\xda\xca\x02\x84\xcb\x68\x0b\xb5\x3d\xee\xea\xec\x21\x2c\xa5\xf2\x26\xc5\x44\xe4\x6c\xca\x6f\xc1\xd9\xa2\x97\x9b\x4e\xcf\x9d\xad\xf6\x76\xe3\xe2\x47\xcd\x32\x8a\x4d\x33\xa2\x02\xce\x16\x3b\x36\x0e\x9a\x4b\x4a\xa1\x31\xdb\x42\x56\x9d\x09\x5a\x7a\xb0\x4c\x36\x0d\xb1\x3b\x49\xd9\x88\x49\x53\xc5\xcc\xc6\xba\x2d\xde\x9b\xaa\x93\x27\x8a\x65\x8c\x63\x84\x61\x2d\x68\x03\xa0\xc8\xda\x96\xf7\xed\xe6\xeb\x70\x3e\x9e\x0c\x86\xf3\xf1\xe9\xe5\xb0\x02\xe3\xa2\xc7\x6f\x4a\xc6\xf5\x00\xb2\x64\xc8\xc3\x19\x2e\x9b\x61\xa5\x9a\xfc\xb3\x93\xc6\xa4\x23\xf2\x92\xda\xd4\x79\x64\x35\x6e\xa3\x7c\x8b\x9b\xdb\xd1\xec\xfa\x62\x78\x3d\x1f\x8c\xae\x4e\xbf\x5e\x0c\xe7\xdf\x6e\x2f\x1f\x67\xc9\xa7\x99\x4b\x92\x7c\xc3\x4d\x07\x67\x35\x05\x06\x7e\x71\x63\x89\x0b\xb4\x21\xd3\x36\x39\xce\xd7\x59\xdc\x98\x96\x89\xf7\x89\x86\x3e\x9b\x4c\x5f\xcd\x46\x93\xdb\xf9\xd5\xcd\x74\x3a\x99\x5d\xbf\x19\xdb\x5a\x31\x99\xcd\x75\x9a\x24\x52\x99\xe7\x31\x3e\x98\x7c\x1f\x5f\x4c\x4e\x07\xf3\xe9\x6c\x72\x3d\x39\x9b\x5c\xbc\x9d\xce\xe5\x9d\xe0\x92\x84\xf3\x44\x49\x23\xa9\xe4\xcf\x13\xe0\x62\x72\x7e\x31\xbc\x1d\xbe\x1d\xdf\x5c\x46\x1c\x33\x7c\x26\xbb\x67\xa7\x17\xa3\xb3\xc9\xfc\xea\xe6\xeb\x78\xf8\x76\x86\x42\x09\x67\x54\x06\x3a\x5d\x08\x7c\xa2\xa1\x8c\x2e\x4f\xcf\x87\xf3\xd9\xf0\x7c\xf8\xc7\x74\x7e\x3d\x3b\x1d\x5f\x5d\x9c\x5e\x8f\x26\xe3\x37\xe3\xdd\xc5\xec\xb9\xc2\x08\xef\x93\xb9\x51\x44\x68\xee\x92\xd6\xf3\xf4\x3f\x3b\xfd\x3e\x1f\x0c\x6f\x47\x67\xc3\xab\x37\x93\x40\x91\xbb\x79\x88\xb6\xca\xd5\xcf\x74\xd2\x3c\x24\x5e\x4c\xce\xcf\x47\xe3\xf3\x37\x0f\x8b\x5c\x46\x11\x13\xcd\x25\xfb\x5a\xfc\xf4\x66\x7e\x39\x19\xbc\xa1\x87\xd2\x24\x0d\x62\x19\x3e\xd5\x45\x6d\x3a\x74\x26\x32\x99\x58\x95\xcf\xde\x8c\xdf\xbc\xa0\x9b\x2b\x29\xcd\xbc\x5e\xf7\x3d\x41\xcf\xde\x51\x2b\x1e\x7a\xd5\x25\x44\x1f\x7a\x68\x68\x51\x6b\xe4\x05\x51\xd1\x0c\xd0\x56\x23\x50\xd6\x61\xbe\x80\xda\xbb\x88\x3e\x84\x91\x00\x4a\x34\xc2\x9d\xed\x23\xfe\x44\x6a\x80\x4b\x4a\x78\x59\xbb\x3b\x04\x3b\x7b\x47\x84\xb1\x0d\x83\x6d\x4a\x99\x01\x21\x0d\xc8\xe5\x92\x51\x46\x38\xdf\x00\xc9\x08\xe3\xae\x71\x95\x02\x5f\xa0\x46\xcf\x05\xd9\xa7\x3c\xaf\xd6\x68\x56\x67\x45\x11\xf9\x17\xc6\x69\xab\x48\xab\x0d\xd6\x69\xf5\x46\xf7\x96\xba\x47\x23\x25\xd3\xa4\x45\xd8\x18\xae\x93\xda\xb2\x30\x96\x61\xca\x6b\x91\xc3\x13\xb6\xc7\x15\x92\x70\x22\xf8\xa6\x65\x28\x55\x48\xdb\xbe\xb7\xb0\x1a\x83\x7b\x01\xbd\x76\x7f\xd1\xee\x62\x7e\xac\x6c\xee\xa6\x6e\x1a\x36\x6c\x31\xf8\x36\xb5\x6d\x5d\x1e\xa1\x0e\x6c\x4f\x83\x46\x57\xdc\xc2\x76\xaa\x5c\x46\xae\x07\x66\x65\x77\xbb\x42\x85\xb0\x40\x4a\xdc\xcd\x8c\x59\xa1\xba\x63\x1a\xcb\x8e\xd7\xa9\x2a\x51\x32\x4c\x29\x02\x2a\x25\x55\x15\x92\xb3\x35\x82\x59\xb1\x8a\xf1\x1e\xc2\x4d\x7e\xdb\x23\x6d\x13\x1c\xe4\xd7\x32\x74\x45\x54\x88\x19\x2c\x19\x47\x78\xef\x75\x20\xa3\x5e\x16\xeb\x1e\x59\x86\x9f\x7f\x59\x2c\x16\xc1\x17\xfc\xf5\x73\x70\x72\x82\x9f\x83\x5f\x7f\xf9\xf7\x49\x70\xfc\xe9\x5f\x9f\x8e\x09\x3d\x3e\x3e\x3e\xfe\xd4\xa3\x4c\x29\xa9\x83\x2c\x9e\x1f\x1f\x71\x19\xbd\xef\xc3\x58\x82\x4e\xe9\xca\x23\x4a\x55\x76\xee\x9b\x76\x53\x15\xeb\x60\x7b\x37\x57\x61\xa5\xdd\x03\xe6\xca\x7c\x9c\xba\x7d\x68\x4f\xe9\xca\x9e\xd3\x57\x59\x4f\x61\x02\xb5\x9e\x2a\xb9\xc0\x2a\x09\xde\x3f\xdc\x13\xfa\xbf\x56\x38\xf2\x2c\xf6\x16\x4c\xf4\x2a\xe1\xc8\x8f\x06\xb4\x31\xa0\x25\x25\x06\x02\xb8\x19\x8f\xfe\xe8\x37\x0d\xb0\x57\x35\xb8\x40\x49\xf8\x8f\x95\xac\x27\x52\xce\x1b\x81\xbc\xf3\xb6\xe3\x67\x0f\xe4\xaf\x1d\xa1\xdf\x3e\x94\x1d\xfa\x40\xec\xae\xc1\xaa\x51\x1e\x88\xc2\xf2\xea\x11\x16\x1b\xd0\x69\x82\x2a\x66\xdb\x82\xe0\xcf\x96\x20\xde\xee\x16\xa4\xc0\xdd\x7a\x34\x3f\x55\xe0\xaf\xa3\xa4\xda\xf1\x60\x43\x84\xbb\xcd\x53\x02\x0d\xea\xf2\x62\x2f\xbf\xd1\xeb\x79\xb3\xef\xd9\x65\xad\x8d\xf6\xb8\x35\xec\x96\x3b\xdf\xa4\x97\xc8\xb0\x6d\x32\x16\xd5\x4e\x74\xde\x3e\xee\xa3\xe9\xe7\xc7\xfa\xa6\x2f\x37\x2a\xd4\x26\xa7\x6e\x38\xb0\xbf\x83\x4a\x4f\xd8\x4e\x1e\x4e\x9a\xc7\x79\xa9\x69\xe3\xb0\x48\xcb\x4b\x97\xd1\x48\x24\xa4\x36\x8c\x42\x92\xaa\x44\x6a\x7c\x8d\x0c\x25\xd0\xec\xbc\xf3\x2d\xec\xce\xad\xfb\x81\x93\x69\x15\xa1\x8f\x17\xaa\x3f\x77\x5a\x8c\x54\x42\xe7\x2b\x24\xdc\xac\xe6\x89\xdd\x0c\x02\x12\x86\x2a\x4f\x93\x56\x65\xb9\x21\x55\xef\x97\x2b\x76\xba\x77\x22\x7c\x7e\xcb\x91\xc5\xfa\xa9\xed\xc6\x4b\x04\xc3\xdf\xa5\x36\xd7\xf2\xac\xf1\x4d\xef\xc7\xc3\xe1\xcb\xb8\xf8\xcb\x86\xa3\xed\xb2\x3e\x2d\x21\x6d\x4b\x9c\xbb\x53\xae\x3f\xd1\xca\xc7\x33\x8b\x5a\xa9\xee\x6d\x18\x59\x49\x6d\x40\x91\x3b\xf0\x17\x41\x40\x28\x45\xad\x4b\x7b\x74\x9f\x5a\x2d\x7e\xd5\xbb\xda\x1c\x36\xa5\xd9\x49\xd8\xed\xce\x1d\x71\x60\x27\x4a\x57\x85\xd1\xa5\xa6\x9d\x20\xb5\xf2\xa1\x55\x51\xec\x24\xad\x56\x4d\xcd\x3a\xea\x10\xae\x27\x83\x49\x1f\x42\x57\xaf\xd9\xe6\x86\xca\x10\xf3\x2f\x4f\xe0\x73\xb0\xab\x56\xad\x95\xb8\x26\xeb\x81\x70\xc5\xb4\xaf\xdb\xf2\x6a\x0b\xce\x66\x23\xdb\x63\xdd\x6f\x80\x09\x6d\x08\xf7\x19\xc5\x16\xb4\xd5\x0d\x99\xf0\x47\xe9\x0b\xbd\xf2\x63\xf6\xd1\x3e\xa2\xec\xfa\xe0\xb5\xe5\x9b\xd9\xa3\x78\x5d\x51\xa2\x2b\x46\xec\x05\xd4\x74\xf6\xae\x10\xf0\x38\x50\x25\x2a\x34\x3f\xe2\xed\x24\xfe\x81\xaa\x68\xcf\x9a\x68\x2f\x25\x74\x46\xa4\xad\xf1\x68\x1f\xc8\xe6\xc1\xd4\xbe\x1d\xee\xa3\xcf\xb2\x18\xaa\xc6\xd3\xae\x38\xbc\x17\xd8\xce\x53\x7e\x0a\x58\x57\x21\xbc\xab\x0c\xde\x8b\xbb\x0e\xb5\x37\x6a\xb8\xbd\xf8\xaa\x17\x4a\xdd\x45\xd6\x4e\xa0\xad\xfd\x64\xab\x9b\x0c\x1e\xee\x81\xfb\xdb\x32\x75\xe0\xeb\xd5\xce\x52\x75\x77\x41\xdb\x7c\x5d\xa5\x16\x84\x1e\x91\xd4\xac\xa4\x62\xff\x73\x6b\x8e\xd6\x5f\xf4\x11\x93\xbd\xec\x64\x81\x86\x14\xef\xae\xf2\x87\x47\x33\xc9\xf1\x2b\x13\x21\x13\xd1\x8e\x07\x58\x4a\x72\xcc\x2f\xb0\x49\xc2\xce\x6d\x6e\xd8\xb1\xd3\x01\x40\x6b\x8f\x16\xa4\x4e\x17\x7f\x22\xb5\x25\x4e\x90\xaf\xbe\xaa\xbd\xf4\xd9\xff\x11\x98\xd5\x40\x7b\xbf\xa7\xe9\xe4\x19\x6f\xcf\x94\x4d\x6e\x76\x7d\x50\xea\x24\x4f\xf1\x01\xbc\x7b\xe7\x7e\x28\xd4\x32\x55\x14\xcb\xf1\xf2\xd5\x93\xce\x07\xdc\xdb\x24\xf7\x3b\x43\xb5\x78\x58\xe7\xee\xe3\xf2\xff\x44\x68\x5e\xe2\x94\x3b\x64\x2c\xd9\x09\x6c\x41\x8e\xaa\x90\xa9\x21\x51\x2e\x4f\x4d\x9a\x86\x2c\x25\xf7\x9e\x5d\xfb\x2f\x67\xda\xff\xb8\x23\x86\xae\x5e\x49\x82\xc2\x7d\x52\x8d\xca\xce\xfc\xb0\x20\x81\xed\x67\x94\x8f\x49\x0d\xa1\x5e\xd5\xd3\x8a\x2c\x66\x0d\x22\x58\xe4\xcb\x5e\xd0\xed\x5a\x47\x5d\xf5\xbf\xa7\x80\x9f\xe7\x85\xa1\x87\xf5\xbe\xd0\xf7\x66\xfc\xba\xa1\x28\x7e\x38\xe4\x57\xd0\xcf\x36\x43\xfa\x9b\x84\xa9\x80\xaa\x70\xbb\xd1\x93\x84\xe1\xbd\x41\xe1\xde\x12\xe6\x98\x5d\x8e\x90\x6a\x23\xe3\x62\x30\x44\xf7\xe8\x31\x4f\x45\x15\x5f\xc8\x83\x53\x7b\x9b\xa2\x93\x5e\x7f\xd1\x1d\xe8\xf9\xac\xcb\x63\x31\x49\x12\x26\x22\x5d\x9d\x28\x2d\xb4\x98\xa9\x6c\x59\xc6\x92\x57\xf7\xc3\x9a\x3e\x5f\xde\xbc\x2c\xec\xcb\x9a\x54\xe3\x71\x55\x27\xe0\x33\xb2\xdb\xff\x03\x00\x00\xff\xff\x3a\x1f\x89\x21\xb5\x2d\x00\x00")
+var _deployDataVirtletDsYaml = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\x03\xd5\x5a\x5f\x6f\x22\x39\x12\x7f\xcf\xa7\xb0\x26\xd2\xcd\x8c\x74\x0e\x93\xd1\xed\xce\x2e\xba\x7b\x60\x12\x36\x8b\x26\x81\x88\x90\xcc\xbe\x21\xd3\x5d\x80\x2f\xdd\xed\x5e\xbb\x9b\x24\xf7\xe9\xb7\xdc\x76\x37\xee\x7f\x04\x48\xc2\xcd\xa2\x28\x02\xdb\x55\x2e\x57\x95\x7f\xae\x2a\x9b\x52\x7a\xc4\x62\x7e\x07\x52\x71\x11\x75\x09\x8b\x63\xd5\x59\x9d\x1e\xdd\xf3\xc8\xef\x92\x73\x06\xa1\x88\x6e\x20\x39\x0a\x21\x61\x3e\x4b\x58\xf7\x88\x90\x88\x85\xd0\x25\x2b\x2e\x93\x00\x7b\xcc\x6f\x15\x33\x0f\x1b\xef\xd3\x19\x50\xf5\xa4\x12\x08\x8f\x54\x0c\x9e\x1e\xae\x20\x00\x2f\x11\x52\x7f\x27\x24\x64\x89\xb7\xbc\x64\x33\x08\x94\x69\x20\x44\xa6\x51\xc2\xcb\x2c\x91\x3e\x0e\x58\x02\x96\xc6\x99\x5c\x7f\xaa\x02\xe8\x4f\x50\x62\xd9\xc8\x14\x45\xb1\x22\xe9\xcf\x52\xa8\x64\x08\xc9\x83\x90\xf7\x5d\x92\xc8\x14\x6c\xbb\x1f\xa9\x6b\x11\x70\xef\xa9\x4b\xce\x82\x14\x57\x22\x7f\xe3\x52\x25\xdf\x79\xb2\xfc\xdd\x90\xd8\x81\xc7\x19\x8b\xeb\xc1\x39\xe1\x2a\x63\x40\x12\x41\x3e\x9c\x7e\x24\x10\xb1\x59\x00\xe4\xee\x4a\xe9\x16\x95\xca\x15\x5f\x41\x2e\x07\xf1\x44\x94\x30\x1e\x81\x24\x12\x54\xc2\xe4\x9a\xdd\x07\x1c\x3d\x03\xe2\x2d\xc1\xbb\x07\xff\x23\x61\x91\x4f\x3e\x7c\xfe\xa8\x99\x58\x96\xc9\x12\x48\xaa\x80\x88\x39\x89\x14\x44\x28\x1b\xe1\x11\xfe\x71\x87\xad\xb3\x3c\x94\xad\xb4\xb4\x63\x32\x13\x22\x51\x89\x64\x31\x89\xa5\xf0\xc0\x4f\x25\x90\x08\xc0\xcf\x24\xf5\x24\xa0\xca\x09\xd3\xbc\xe6\x7c\x11\xe2\x28\xe4\xee\x98\x74\x6d\x69\xcb\x50\x01\xae\xcd\x83\x9e\xe7\x09\x54\xf7\xb0\x64\x96\x62\x4e\x11\x05\x4f\xda\x1c\xe4\xce\x6a\x20\x16\x38\x9f\x88\xb2\xd5\x44\xc2\x07\x45\x1e\x50\xb9\x04\x1e\x51\xb0\xb1\x31\xdb\x7f\x72\x6d\x65\x66\xb5\xac\xd8\x7c\xae\x97\xfa\xb4\x36\xb2\xa6\xee\xd5\x5a\xd1\xf8\xf0\x67\xca\x25\xf8\xe7\xa9\xe4\xd1\xe2\x06\x35\xea\xa7\x01\x7e\x1b\x2c\x22\x51\x34\xf7\x1f\xc1\x4b\x13\xed\xf5\x0e\xa5\xe1\x79\x63\x5d\x76\x02\x32\x54\xe5\x6e\x6a\x3c\xb8\xff\x18\xa3\xf9\xf4\x9e\xa9\xf4\xeb\x11\xf7\x80\xce\xe3\x2e\xa7\x32\x82\x10\x11\x83\x64\x7a\x4f\x90\x41\x54\xeb\x5c\xb1\x20\x85\x1a\x5b\xcd\xb8\xa2\x5b\xbd\xee\xb3\xdc\xee\x05\xc1\x31\x99\xa0\x62\xcb\x4e\x81\xdf\x62\x8e\x8a\xb6\x0c\xde\x2b\x32\x0f\xe0\x71\x25\x82\x34\x04\xe2\x4b\xf4\x4f\x59\x50\xa3\x27\x68\xcb\xf8\x30\x67\x69\x90\x64\xf6\xcf\xac\x16\xa4\x0b\x74\x07\x9f\xcb\xcc\x31\x21\x42\xc7\x46\x8e\xc9\x92\xad\x3d\x38\xa3\x43\xc5\x6b\xdd\xe9\xe9\xb4\x6b\x81\x4f\x66\x4f\x24\xe0\x33\x3d\x37\xf9\x47\xb1\x0f\xe0\x91\xab\x24\x77\x03\xed\xad\x47\xf9\x2a\xcd\xf6\x46\xfd\xc6\x4c\x02\xd5\xf6\x28\x54\xc1\x43\xb6\xc0\xbe\x90\x4b\x86\x8a\x45\xa4\x2a\x61\x80\xed\xbf\x4e\x83\x20\xdf\xc2\x83\xf9\x50\x24\xd7\x28\x28\xee\x96\x62\x94\x27\xc2\x10\xd7\xb0\xd6\x30\x25\x1d\x77\xba\x13\xb5\x2c\xba\x8c\x8e\xae\xb4\x7f\x2b\x97\xc0\x08\x79\xff\x8b\xa2\x6b\x4d\x52\xa3\x23\x45\x51\x07\x8e\xf5\x42\x4d\x7c\xcd\x92\x65\x97\x74\xac\x36\x69\x99\xa0\xc6\x17\xb7\x8b\xc3\xe0\x98\x9c\x8b\xe8\x7d\x42\x98\xef\x93\x77\x86\x9b\x14\x31\x5b\xb0\xcc\x7b\xc9\x57\x6e\x74\x8e\x3f\x58\xf0\xee\x9f\x04\x0d\xff\xc0\x83\x00\xf7\x8e\x77\x6f\x26\x47\x6b\x25\xf2\xa9\x45\x24\x77\xae\x7c\x7e\x5f\x20\x04\x49\x85\xff\x5b\x88\x56\x4c\x6a\xc2\x8e\x19\x78\x52\x1a\x99\x33\x09\xc4\xa2\x85\x5a\x9b\xdb\xed\x3d\x26\x73\x21\x8d\x4b\xe1\xc6\xcc\x7c\xca\x4c\x81\x6e\xd3\xb1\xae\xd3\xc9\x6c\xab\x8c\xdf\x64\xf8\x51\xf2\x8c\x7c\x52\xa4\xa2\x48\xb1\x61\x62\x5a\x1d\x52\x2c\x1a\x56\x2d\x64\x6e\x0f\xad\xe9\x41\x0b\x59\x75\xc4\xe6\x43\xaa\x4a\x0d\x89\x97\x53\x76\x0c\xe8\xb6\x31\xa0\x95\x6e\x85\xd8\x25\x11\xf5\xf4\xf6\x47\xa4\x71\xc1\x22\xc6\xed\xcc\x03\x58\x80\x5f\x02\x7f\x82\xfa\x5d\xd5\x3d\xf8\xdb\xed\xd7\xfe\x74\x38\x3a\xc7\x7f\xbd\xab\xfe\x51\x05\x85\x7e\x93\x22\x2c\x03\xd1\x9c\x43\xe0\x8f\x61\x5e\x85\x27\x37\x88\xc0\xf8\xa1\xdc\x99\x11\x99\x35\xeb\x23\xf8\x44\x5b\x4e\x9f\x16\x35\x69\xee\x06\xe3\xc9\x65\x7f\x32\x3d\x1f\xdc\xf4\xbe\x5e\xf6\xa7\xdf\xee\xae\x9e\x17\xc9\xa8\xe6\x8a\xc5\xdf\xe0\xa9\x41\xb2\x8d\x7a\x34\x9f\x0c\xb0\x7d\xae\xf4\x21\x3b\xbd\x5f\x85\x47\x55\xb4\x36\x7b\xab\xa2\xcf\xaa\xd0\xbd\xdb\xc9\xe8\xff\x22\x39\x4b\x13\x31\x7d\xb1\xf8\x37\xe3\xc1\xe8\x6e\x7a\x73\x7b\x7d\x3d\x1a\x4f\x0e\x26\xbb\x92\x5c\xac\xa6\x2a\x8d\x63\x21\x93\xfd\x04\x3f\x1f\x7d\x1f\x5e\x8e\x7a\xe7\xd3\xeb\xf1\x68\x32\x3a\x1b\x5d\x1e\xce\x65\xc4\x43\x14\x08\xe6\x4f\x31\x9a\x4a\x84\x27\x82\xfd\x16\x70\x39\xba\xb8\xec\xdf\xf5\x0f\x27\x37\x62\x6f\x00\x2b\xd8\x53\xdc\xb3\xde\xe5\xe0\x6c\x84\x9e\xf2\x75\xd8\x3f\x9c\xa3\x78\x0c\x4f\x74\x41\x55\x3a\x8b\x60\x47\x47\x19\x5c\xf5\x2e\xfa\xd3\x71\xff\xa2\xff\xc7\xf5\x74\x32\xee\x0d\x6f\x2e\x7b\x93\xc1\x68\x78\x30\xd9\xb3\xa3\x6b\x2a\x11\x93\x1f\xe3\x29\x86\x85\x91\x0a\xb2\xb3\x7b\x3f\xfd\x8f\x7b\xdf\xa7\xe7\xfd\xbb\xc1\x59\xff\xe6\x60\x2b\x90\xec\x61\x8a\xa7\x20\x06\xfb\x6a\xcf\x4d\x6a\x71\x11\x7d\xfd\x62\x30\xbc\x38\x38\xaa\xa3\xcb\x63\xa4\xb5\xd8\xd3\xe3\xaf\x6f\xa7\x57\x78\x46\x1e\x6e\x87\x7a\x71\x4a\x43\x3c\x25\x77\xdc\xa2\xfa\x34\xcf\x5c\x64\x34\xd2\x2a\x1f\x1f\x4c\x5e\x1b\xd7\x4e\x25\x26\x98\xd3\x72\xf8\xbb\x83\x9e\xcd\x46\x75\x76\xe8\x4d\xd3\x22\x2a\x81\x93\x89\x0b\xf3\x9c\xc8\xab\xe5\x43\x45\x38\x6a\xe2\xc8\xad\x73\x89\x63\xcc\xcd\x10\x75\x30\xdd\x7e\xd0\xe9\xd4\x7f\x31\xc2\x46\xe0\x44\x18\x2a\x52\x98\x8c\x83\xee\x7d\x40\x0e\x3a\x6f\xd2\xb9\x39\x46\xdf\x91\xc0\xc4\x06\xd3\x52\x8f\xb3\x00\x73\x5f\xb6\x62\x3c\xc8\xf2\x77\x11\xc1\x2b\xa4\x2a\x76\x21\xdb\x64\x29\xd5\x60\x33\x8f\xa5\xff\x84\x30\xad\x85\x9a\xa5\xc6\x32\x2d\xe6\xff\x9d\xb9\xea\x78\x0b\x29\xd2\xb8\x46\x58\x69\x2e\x93\xea\xe8\x18\x3d\x39\x0d\x4a\xc8\x61\x08\xeb\xed\x12\x98\x3f\x8a\x82\xa7\x9a\xa3\xb8\x2c\x75\x15\xa3\xc6\xab\xd2\xb8\x15\xa3\xb7\x4e\xb3\xea\xc9\xdc\x6b\x66\x0f\x95\x04\xa9\x46\x5d\x6d\xa7\x8d\x19\xdc\x33\xd4\x54\xa7\x76\x90\x28\x67\x5b\xe8\x84\x1d\xe1\x34\x2b\x05\xf0\x22\xc9\x5f\x82\x04\x32\x03\x8f\x65\x05\x2a\x1c\x23\x1f\x38\x7e\xcb\x13\xff\x4c\x55\x18\x26\xf9\xa9\x07\x04\xa4\x14\xd2\x65\x19\xf0\x7b\x5d\xdd\xe2\x8e\xf3\x1e\x93\x5b\x5b\xf4\x12\xba\x16\x40\x6d\x75\xca\x5b\x32\x89\x07\x11\xa6\x14\xd8\xf5\xde\xe8\x40\x2c\x3a\xab\x50\x75\xd8\xdc\xff\xf2\xd3\x6c\x36\xa3\xbf\xc0\xaf\x5f\xe8\xe9\x29\x7c\xa1\xbf\xfe\xf4\xf3\x29\xfd\xf4\xf9\x5f\x9f\x3f\x31\xef\x13\x7e\x3e\x77\x3c\x8e\x73\x2b\xba\x0a\xa7\x9f\x4e\x90\xf0\x7d\x97\x0c\x75\x8d\xce\x5b\x1a\x8e\x98\x86\xe6\x05\x8c\xa7\x7a\x6e\x19\x2a\xda\x9e\xd4\x3a\xa2\xd4\x53\x61\xab\xcc\xe7\xa9\xeb\x46\xdb\x25\x39\xdd\x27\x2d\xd4\x3b\x05\x01\x53\x29\xf4\xf6\x19\xb8\x24\xf0\xb8\x2e\x97\xb6\xc0\x91\x85\xa4\x19\x8f\x3a\x0e\x1c\x99\x56\xea\x55\x1a\xd0\x95\x30\xb1\xa7\xe4\x76\x38\xf8\xa3\x5b\x75\xc0\x8e\xeb\x70\x54\x0a\xf2\x6f\xbd\xb2\x4e\x84\x08\x59\x01\xf2\xc6\xa2\xcf\x8f\x0e\xe4\x6f\x8d\xd0\x87\x87\xb2\x63\x03\xc4\x59\x35\xd0\x45\x79\xc2\x10\x08\xf2\x0a\xac\xae\xfd\x61\x72\x07\x32\xe4\xd1\xdf\xe4\x80\x38\x5c\x31\x28\xe7\xdb\x6a\x9a\x1f\x0a\xf8\xcb\x5c\x52\x95\xc9\xa0\x21\x22\x2b\x6a\x4a\xcc\xca\x40\x15\xf5\x4d\x5b\xd8\xec\x18\xb7\xef\xe8\x61\xb5\x89\xb6\x28\x9e\x36\xaf\xdb\x4e\xd2\xd1\x17\x09\x8d\x5c\x75\x47\x63\x11\x76\x1b\x4d\xef\x8f\xf5\x6d\xa5\x3d\x1b\xa1\x56\x25\xcd\x9a\xa9\xfe\x4e\x9d\x9c\x50\xbd\x7a\xad\xb0\xa8\xa8\x6b\xad\x3c\xbf\xa6\x92\x56\x8f\xf3\xe3\x7d\x9e\x9d\x8c\x6c\x11\x09\x95\x70\x8f\xc4\xa9\x8c\x85\x82\xb7\x38\xe9\xd0\x91\x36\x96\xd0\x73\xff\xcd\xc6\xbd\xc0\xc2\xb5\x60\xf6\xf9\x80\xf7\xc7\x3e\x5e\x17\x32\xf6\xa6\x4b\x60\x41\xb2\xd4\x05\xa9\x19\x10\x8a\xf8\x2f\xed\x71\xab\x55\x66\x1d\xc4\x2d\xd7\x3b\xfe\x7e\x80\x5b\x16\x9c\x65\xd7\xb4\xe5\x35\x40\x55\x5f\xdc\x4e\xc4\x59\xe5\x8a\xf4\xe5\xb0\xfa\x3a\x50\xf1\xba\xb0\xd6\xbe\xd6\xdd\x0e\xb6\xb6\x03\x78\xf3\xd1\x6d\x2c\xea\xdc\x45\x6a\xae\x4e\x96\xa0\x61\x44\x5f\xc2\xe8\x82\x12\x31\x05\x25\xc2\x3c\x0f\xb7\x47\xe1\x8f\xd9\xcd\xb5\xe6\xef\xee\xae\xba\x84\xd5\xd5\x6c\x24\x6c\xde\xce\x0d\x38\xb0\x91\x4b\x53\xa4\xd2\xa4\xa6\x8d\x4c\x4a\x61\x48\x2d\x32\xd9\x48\xea\x46\x5f\xd5\x78\xec\x98\x4c\x46\xe7\x23\x5d\x92\xd6\x71\x9f\x4e\x92\x3c\xe1\x83\xbd\xc8\x23\xe6\x2c\xcf\xa2\x5e\xed\x25\x59\xb2\xe6\x5c\x17\x73\x65\xe2\x3f\x1b\xb5\x91\xb3\xf1\x40\xe7\x6a\x8f\x4f\x18\x2e\xab\x04\x63\x5f\x43\x85\x81\xb1\x3b\x21\x8f\x8c\x29\x4d\xc0\x58\xbc\x0d\x38\xd9\x66\x29\x9b\xee\x0f\x5b\xae\x20\x9f\xe5\xd7\x84\x12\x4d\x18\xb1\x15\xa3\xea\x66\x6f\x82\x80\xe7\x19\x39\xa8\x50\xbd\x13\xdd\x48\xfc\x82\xe8\x6a\xcb\xd8\x6a\x2b\x25\x34\x22\x52\x2b\x1e\x6d\xc3\xb2\x6a\x98\xd2\x55\xec\x36\xfa\x2c\x62\x20\x17\x4f\x9b\x70\x78\x2b\x66\x1b\xad\xbc\x0b\xb3\xa6\x80\x7a\x53\x38\xbd\x95\x74\x0d\x6a\xaf\xc4\x70\x5b\xc9\x55\x0e\x94\x9a\x83\xac\x8d\x8c\x5a\xf3\xd2\x5a\x56\x4a\xd7\xf5\xe4\x6e\x6b\x78\x6a\xe2\xde\xc6\x90\x77\x9b\xc0\x78\xab\x39\x6a\x11\x72\x4b\x05\xba\x91\x8a\x56\x1e\xc5\xc9\x19\xf3\x4e\x58\x9a\x2c\x85\xe4\xff\xcb\x64\x39\x41\xf7\x3f\xe1\xa2\xb3\x3a\x9d\x41\xc2\xf2\xe7\x72\xf6\xbd\xd8\x58\x04\xf0\x15\x1b\xf4\x75\x43\xfb\xbb\x39\x89\xa3\x6c\xc1\x1d\xe7\xba\xd0\x67\xd0\x86\x99\x70\x54\x6d\x8e\x1a\x4b\x95\xce\x74\x71\x03\x4f\x5f\x6a\x47\xdf\x94\x1e\x68\x6d\xff\x76\x4f\x6b\xa0\x3e\xdf\x6e\x3a\xd9\xe3\xc9\xa0\xd4\x87\xa8\x1e\x4f\x0b\x9d\xd8\x50\x82\x92\x77\xef\x8e\x4c\x38\xad\x44\x2a\x3d\x28\xda\x8b\xc7\x6a\xca\x36\x64\x4f\xca\xb2\xef\x2b\x90\xb3\xf5\xb8\xac\x7e\x68\x7f\x2c\x32\x29\x76\x98\xa5\x85\x69\xc0\xed\xbb\x25\x4a\x1e\xf4\xb3\xb0\x3d\x98\x76\xf0\x80\x4d\xd2\x06\xde\xf1\xee\x0c\x61\x85\x01\xf8\xa6\xb5\xef\xc0\xab\x00\x9f\x12\xa7\x76\xc5\x65\x0f\xa7\x8c\x0b\xb4\x70\xb4\x4f\x32\xbd\x80\x29\x05\x2d\xbc\x5f\x61\xeb\x35\x38\x5e\xe1\x23\x54\x67\x63\x18\x14\x5b\x47\xab\xac\xc3\x2a\xa3\x24\x78\xc5\xc1\x0a\x81\xd7\xaa\xb0\x3e\x90\x7b\xc0\xdb\xac\x20\x47\xa8\x54\x81\xd4\x3d\x2f\x5e\x08\xd5\xc9\xac\x34\x07\x52\x65\x51\x6f\x0a\x7f\x79\x08\xa3\xdd\x85\xce\xec\xb0\x57\xc4\xc2\x9a\xa9\x5d\x50\xdc\x85\xf9\x85\xcd\x0a\x0c\x5b\x03\x50\x5d\x03\x03\x6f\x7b\x3e\x84\x6b\x23\xbf\x81\x7e\xda\x1c\xe9\x6f\x72\x76\x50\x4f\xfa\xed\x4e\x8f\xbf\xe1\x31\x81\x28\x7b\x97\xeb\x80\x51\x75\x23\xa0\x58\x22\xcc\x1b\x7d\xc8\x1e\x10\xdb\x08\xc3\xd9\x0b\x16\x35\xeb\xd3\xe4\x65\x14\x9c\xa0\x81\xbb\xed\xcd\x82\x18\xf4\xc3\x18\x0d\xa8\xdc\x8e\xc2\x43\x6b\x3d\xab\x30\xd6\x05\x15\x0e\xa5\xd6\x79\xc0\x56\xe6\xb6\xcc\x91\xad\x00\x9d\x1c\x85\x2c\xf8\xec\x27\x6b\x59\xa4\xf5\x89\xe4\x4c\x98\xc6\x7e\xa6\x8c\x03\x84\x46\xc6\xc6\xaf\xef\xf2\x9a\xed\xeb\xba\x79\xe5\xd1\x63\x23\xc3\x3d\xc2\xa0\xbf\x00\x44\x4a\x51\x93\x95\x31\x00\x00")
 
 func deployDataVirtletDsYamlBytes() ([]byte, error) {
 	return bindataRead(
@@ -81,7 +81,7 @@ func deployDataVirtletDsYaml() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "deploy/data/virtlet-ds.yaml", size: 11701, mode: os.FileMode(420), modTime: time.Unix(1522279343, 0)}
+	info := bindataFileInfo{name: "deploy/data/virtlet-ds.yaml", size: 12693, mode: os.FileMode(420), modTime: time.Unix(1522279343, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
@@ -0,0 +1,111 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/renstrom/dedent"
+	"github.com/spf13/cobra"
+
+	"github.com/Mirantis/virtlet/pkg/image"
+)
+
+const defaultImageDir = "/var/lib/virtlet/images"
+
+// cdromCommand contains the data needed by the cdrom subcommands
+// which change the media in the CD-ROM devices of a VM pod.
+type cdromCommand struct {
+	client   KubeClient
+	out      io.Writer
+	imageDir string
+}
+
+// NewCDROMCmd returns a cobra.Command that changes the media in
+// the CD-ROM devices of a VM pod.
+func NewCDROMCmd(client KubeClient, out io.Writer) *cobra.Command {
+	cdrom := &cdromCommand{client: client, out: out}
+	cmd := &cobra.Command{
+		Use:   "cdrom",
+		Short: "Manage CD-ROM devices of a VM pod",
+		Long:  "Eject or change the media in the CD-ROM devices of a VM pod",
+	}
+
+	ejectCmd := &cobra.Command{
+		Use:   "eject pod device",
+		Short: "Eject the media from a CD-ROM device",
+		Long: dedent.Dedent(`
+                        This command ejects the media from the specified CD-ROM
+                        device of a VM pod. The device is specified using its
+                        target name in the domain definition, e.g. sdc.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return errors.New("please specify the pod and the device")
+			}
+			return cdrom.changeMedia(args[0], args[1], "--eject")
+		},
+	}
+
+	changeCmd := &cobra.Command{
+		Use:   "change pod device image",
+		Short: "Insert an image into a CD-ROM device",
+		Long: dedent.Dedent(`
+                        This command inserts the specified image into a CD-ROM
+                        device of a VM pod, replacing the current media, if any.
+                        The image must be present in the Virtlet image store
+                        on the node that runs the VM pod.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 3 {
+				return errors.New("please specify the pod, the device and the image")
+			}
+			return cdrom.changeMedia(args[0], args[1], cdrom.imagePath(args[2]), "--update")
+		},
+	}
+	changeCmd.Flags().StringVar(&cdrom.imageDir, "image-dir", defaultImageDir, "Virtlet image directory on the node")
+
+	cmd.AddCommand(ejectCmd)
+	cmd.AddCommand(changeCmd)
+	return cmd
+}
+
+// imagePath returns the path of the image in the Virtlet image store
+func (c *cdromCommand) imagePath(imageName string) string {
+	imageName, _ = image.SplitImageName(imageName)
+	return filepath.Join(c.imageDir, "links", strings.Replace(imageName, "/", "%", -1))
+}
+
+func (c *cdromCommand) changeMedia(podName, dev string, args ...string) error {
+	vmPodInfo, err := c.client.GetVMPodInfo(podName)
+	if err != nil {
+		return fmt.Errorf("can't get VM pod info for %q: %v", podName, err)
+	}
+
+	virshArgs := append([]string{"virsh", "change-media", vmPodInfo.LibvirtDomainName(), dev}, args...)
+	exitCode, err := c.client.ExecInContainer(vmPodInfo.VirtletPodName, "libvirt", "kube-system", nil, c.out, os.Stderr, virshArgs)
+	if err != nil {
+		return fmt.Errorf("error executing virsh in Virtlet pod %q: %v", vmPodInfo.VirtletPodName, err)
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("virsh returned non-zero exit code %d", exitCode)
+	}
+	return nil
+}
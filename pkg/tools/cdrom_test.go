@@ -0,0 +1,89 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCDROMCommand(t *testing.T) {
+	for _, tc := range []struct {
+		args             string
+		expectedCommands map[string]string
+		errSubstring     string
+	}{
+		{
+			args: "eject cirros sdc",
+			expectedCommands: map[string]string{
+				"virtlet-foo42/libvirt/kube-system: virsh change-media virtlet-cc349e91-dcf7-foocontainer sdc --eject": "",
+			},
+		},
+		{
+			args: "change cirros sdc example.com/drivers.iso",
+			expectedCommands: map[string]string{
+				"virtlet-foo42/libvirt/kube-system: virsh change-media virtlet-cc349e91-dcf7-foocontainer sdc /var/lib/virtlet/images/links/example.com%drivers.iso --update": "",
+			},
+		},
+		{
+			args: "change --image-dir=/images cirros sdc example.com/drivers.iso",
+			expectedCommands: map[string]string{
+				"virtlet-foo42/libvirt/kube-system: virsh change-media virtlet-cc349e91-dcf7-foocontainer sdc /images/links/example.com%drivers.iso --update": "",
+			},
+		},
+		{
+			args:         "change cirros sdc",
+			errSubstring: "please specify the pod, the device and the image",
+		},
+		{
+			args:         "eject nosuchpod sdc",
+			errSubstring: "can't get VM pod info",
+		},
+	} {
+		t.Run(tc.args, func(t *testing.T) {
+			c := &fakeKubeClient{
+				t: t,
+				vmPods: map[string]VMPodInfo{
+					"cirros": {
+						NodeName:       "kube-node-1",
+						VirtletPodName: "virtlet-foo42",
+						ContainerID:    "cc349e91-dcf7-4f11-a077-36c3673c3fc4",
+						ContainerName:  "foocontainer",
+					},
+				},
+				expectedCommands: tc.expectedCommands,
+			}
+			var out bytes.Buffer
+			cmd := NewCDROMCmd(c, &out)
+			cmd.SetArgs(strings.Split(tc.args, " "))
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			switch err := cmd.Execute(); {
+			case err != nil && tc.errSubstring == "":
+				t.Errorf("cdrom command returned an unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Errorf("Didn't get expected error (substring %q)", tc.errSubstring)
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("Didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			}
+			for c := range tc.expectedCommands {
+				t.Errorf("command not executed: %q", c)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/renstrom/dedent"
+	"github.com/spf13/cobra"
+)
+
+// serialChannelDir is the directory inside Virtlet container
+// that holds the sockets of the virtio-serial channels.
+const serialChannelDir = "/var/lib/virtlet/channels"
+
+type channelCommand struct {
+	client      KubeClient
+	podName     string
+	channelName string
+	in          io.Reader
+	out         io.Writer
+}
+
+// NewChannelCmd returns a cobra.Command that connects to
+// a virtio-serial channel of a VM pod.
+func NewChannelCmd(client KubeClient, in io.Reader, out io.Writer) *cobra.Command {
+	channel := &channelCommand{client: client, in: in, out: out}
+	return &cobra.Command{
+		Use:   "channel pod channel-name",
+		Short: "Connect to a virtio-serial channel of a VM pod",
+		Long: dedent.Dedent(`
+                        This command connects the standard input and output
+                        to a virtio-serial channel of a VM pod. The channel must
+                        be declared using VirtletSerialChannels annotation of
+                        the pod.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return errors.New("must specify pod and channel names")
+			}
+			channel.podName = args[0]
+			channel.channelName = args[1]
+			return channel.Run()
+		},
+	}
+}
+
+// Run executes the command.
+func (c *channelCommand) Run() error {
+	vmPodInfo, err := c.client.GetVMPodInfo(c.podName)
+	if err != nil {
+		return fmt.Errorf("can't get VM pod info for %q: %v", c.podName, err)
+	}
+	if vmPodInfo.UID == "" {
+		return fmt.Errorf("can't determine the UID of pod %q", c.podName)
+	}
+
+	socketPath := path.Join(serialChannelDir, vmPodInfo.UID, c.channelName)
+	exitCode, err := c.client.ExecInContainer(
+		vmPodInfo.VirtletPodName, "virtlet", "kube-system",
+		c.in, c.out, os.Stderr,
+		[]string{"socat", "-", "UNIX-CONNECT:" + socketPath},
+	)
+	if err != nil {
+		return fmt.Errorf("error connecting to channel %q of pod %q: %v", c.channelName, c.podName, err)
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("connection to channel %q of pod %q failed with exit code %d", c.channelName, c.podName, exitCode)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChannelCommand(t *testing.T) {
+	socketCmd := "virtlet-foo42/virtlet/kube-system: socat - UNIX-CONNECT:/var/lib/virtlet/channels/4a1cfbb4-8e4e-11e8-9a4d-0242ac110002/com.example.agent"
+	for _, tc := range []struct {
+		args             string
+		expectedCommands map[string]string
+		expectedStdins   map[string]string
+		expectedOutput   string
+		errSubstring     string
+	}{
+		{
+			args: "cirros com.example.agent",
+			expectedCommands: map[string]string{
+				socketCmd: "pong",
+			},
+			expectedStdins: map[string]string{
+				socketCmd: "ping",
+			},
+			expectedOutput: "pong",
+		},
+		{
+			args:         "ubuntu com.example.agent",
+			errSubstring: "can't get VM pod info",
+		},
+		{
+			args:         "cirros",
+			errSubstring: "must specify pod and channel names",
+		},
+	} {
+		t.Run(tc.args, func(t *testing.T) {
+			c := &fakeKubeClient{
+				t: t,
+				vmPods: map[string]VMPodInfo{
+					"cirros": {
+						NodeName:       "kube-node-1",
+						VirtletPodName: "virtlet-foo42",
+						ContainerID:    "cc349e91-dcf7-4f11-a077-36c3673c3fc4",
+						ContainerName:  "foocontainer",
+						UID:            "4a1cfbb4-8e4e-11e8-9a4d-0242ac110002",
+					},
+				},
+				expectedCommands: tc.expectedCommands,
+				stdins:           make(map[string]string),
+			}
+			var out bytes.Buffer
+			cmd := NewChannelCmd(c, strings.NewReader("ping"), &out)
+			cmd.SetArgs(strings.Split(tc.args, " "))
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			switch err := cmd.Execute(); {
+			case err != nil && tc.errSubstring == "":
+				t.Errorf("channel command returned an unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Errorf("Didn't get expected error (substring %q), output: %q", tc.errSubstring, out.String())
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("Didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			case err == nil && out.String() != tc.expectedOutput:
+				t.Errorf("Unexpected output from the command: %q instead of %q", out.String(), tc.expectedOutput)
+			}
+			for c := range tc.expectedCommands {
+				t.Errorf("command not executed: %q", c)
+			}
+			expectedStdins := tc.expectedStdins
+			if expectedStdins == nil {
+				expectedStdins = map[string]string{}
+			}
+			if !reflect.DeepEqual(c.stdins, expectedStdins) {
+				t.Errorf("bad stdin data: %#v instead of %#v", c.stdins, expectedStdins)
+			}
+		})
+	}
+}
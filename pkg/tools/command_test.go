@@ -20,22 +20,29 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"sort"
 	"strings"
 	"testing"
 
 	"github.com/spf13/cobra"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 type fakeKubeClient struct {
 	t                       *testing.T
 	virtletPods             map[string]string
+	imageServerPod          string
 	vmPods                  map[string]VMPodInfo
 	expectedCommands        map[string]string
 	expectedPortForwards    []string
 	portForwardStopChannels []chan struct{}
 	logs                    map[string]string
+	stdins                  map[string]string
+	pods                    []v1.Pod
+	podDeleted              func(pod v1.Pod)
+	nodeAnnotations         map[string]map[string]string
 }
 
 var _ KubeClient = &fakeKubeClient{}
@@ -62,6 +69,13 @@ func (c *fakeKubeClient) GetVirtletPodNameForNode(nodeName string) (string, erro
 	return "", fmt.Errorf("no Virtlet pod on the node %q", nodeName)
 }
 
+func (c *fakeKubeClient) GetImageServerPodName() (string, error) {
+	if c.imageServerPod == "" {
+		return "", errors.New("no running Virtlet image server pods found")
+	}
+	return c.imageServerPod, nil
+}
+
 func (c *fakeKubeClient) GetVMPodInfo(podName string) (*VMPodInfo, error) {
 	if podInfo, found := c.vmPods[podName]; found {
 		return &podInfo, nil
@@ -79,6 +93,13 @@ func (c *fakeKubeClient) ExecInContainer(podName, containerName, namespace strin
 		return 0, fmt.Errorf("unexpected command: %s", key)
 	}
 	delete(c.expectedCommands, key)
+	if c.stdins != nil && stdin != nil {
+		data, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return 0, fmt.Errorf("ReadAll(): %v", err)
+		}
+		c.stdins[key] = string(data)
+	}
 	if stdout != nil {
 		if _, err := io.WriteString(stdout, out); err != nil {
 			return 0, fmt.Errorf("WriteString(): %v", err)
@@ -132,15 +153,64 @@ func (c *fakeKubeClient) GetNamesOfNodesMarkedForVirtlet() ([]string, error) {
 }
 
 func (c *fakeKubeClient) CreatePod(pod *v1.Pod) (*v1.Pod, error) {
-	return nil, errors.New("not implemented")
+	for _, p := range c.pods {
+		if p.Name == pod.Name && p.Namespace == pod.Namespace {
+			return nil, fmt.Errorf("pod already exists: %s/%s", pod.Namespace, pod.Name)
+		}
+	}
+	c.pods = append(c.pods, *pod)
+	return pod, nil
 }
 
 func (c *fakeKubeClient) GetPod(name, namespace string) (*v1.Pod, error) {
-	return nil, errors.New("not implemented")
+	for _, pod := range c.pods {
+		if pod.Name == name && pod.Namespace == namespace {
+			return pod.DeepCopy(), nil
+		}
+	}
+	return nil, fmt.Errorf("pod not found: %s/%s", namespace, name)
 }
 
-func (c *fakeKubeClient) DeletePod(pod, namespace string) error {
-	return errors.New("not implemented")
+func (c *fakeKubeClient) ListPods(labelSelector string) ([]v1.Pod, error) {
+	selector, err := labels.Parse(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+	var r []v1.Pod
+	for _, pod := range c.pods {
+		if selector.Matches(labels.Set(pod.Labels)) {
+			r = append(r, pod)
+		}
+	}
+	return r, nil
+}
+
+func (c *fakeKubeClient) DeletePod(name, namespace string) error {
+	for n, pod := range c.pods {
+		if pod.Name == name && pod.Namespace == namespace {
+			c.pods = append(c.pods[:n:n], c.pods[n+1:]...)
+			if c.podDeleted != nil {
+				c.podDeleted(pod)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("pod not found: %s/%s", namespace, name)
+}
+
+func (c *fakeKubeClient) PatchNodeAnnotations(nodeName string, annotations map[string]*string) error {
+	nodeAnnotations, found := c.nodeAnnotations[nodeName]
+	if !found {
+		return fmt.Errorf("node not found: %q", nodeName)
+	}
+	for k, v := range annotations {
+		if v == nil {
+			delete(nodeAnnotations, k)
+		} else {
+			nodeAnnotations[k] = *v
+		}
+	}
+	return nil
 }
 
 func fakeCobraCommand() *cobra.Command {
@@ -0,0 +1,107 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/renstrom/dedent"
+	"github.com/spf13/cobra"
+)
+
+// deletionConfirmationDir is the directory inside Virtlet container
+// that holds the confirmations of the deletion of persistent volumes.
+const deletionConfirmationDir = "/var/lib/virtlet/deletion-confirmations"
+
+type confirmDeleteCommand struct {
+	client   KubeClient
+	podName  string
+	nodeName string
+	podUID   string
+	out      io.Writer
+}
+
+// NewConfirmDeleteCmd returns a cobra.Command that confirms the
+// deletion of the persistent volumes of a VM pod.
+func NewConfirmDeleteCmd(client KubeClient, out io.Writer) *cobra.Command {
+	confirm := &confirmDeleteCommand{client: client, out: out}
+	cmd := &cobra.Command{
+		Use:   "confirm-delete [pod | --node node --uid pod-uid]",
+		Short: "Confirm the deletion of the persistent volumes of a VM pod",
+		Long: dedent.Dedent(`
+                        This command allows Virtlet to remove the VM together with
+                        its volumes that are marked as persistent. The confirmation
+                        is valid for 10 minutes. It can be given before deleting
+                        the pod or after that, in which case the node and the UID
+                        of the pod must be specified instead of the pod name.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case len(args) == 1 && confirm.nodeName == "" && confirm.podUID == "":
+				confirm.podName = args[0]
+			case len(args) == 0 && confirm.nodeName != "" && confirm.podUID != "":
+			default:
+				return errors.New("must specify either the pod name or both --node and --uid")
+			}
+			return confirm.Run()
+		},
+	}
+	cmd.Flags().StringVar(&confirm.nodeName, "node", "", "the name of the node of the deleted pod")
+	cmd.Flags().StringVar(&confirm.podUID, "uid", "", "the UID of the deleted pod")
+	return cmd
+}
+
+// Run executes the command.
+func (c *confirmDeleteCommand) Run() error {
+	var virtletPodName string
+	if c.podName != "" {
+		vmPodInfo, err := c.client.GetVMPodInfo(c.podName)
+		if err != nil {
+			return fmt.Errorf("can't get VM pod info for %q: %v", c.podName, err)
+		}
+		if vmPodInfo.UID == "" {
+			return fmt.Errorf("can't determine the UID of pod %q", c.podName)
+		}
+		virtletPodName = vmPodInfo.VirtletPodName
+		c.podUID = vmPodInfo.UID
+	} else {
+		var err error
+		virtletPodName, err = c.client.GetVirtletPodNameForNode(c.nodeName)
+		if err != nil {
+			return err
+		}
+	}
+
+	if path.Base(c.podUID) != c.podUID {
+		return fmt.Errorf("bad pod UID %q", c.podUID)
+	}
+	confirmationPath := path.Join(deletionConfirmationDir, c.podUID)
+	exitCode, err := c.client.ExecInContainer(
+		virtletPodName, "virtlet", "kube-system", nil, c.out, os.Stderr,
+		[]string{"/bin/sh", "-c", fmt.Sprintf("mkdir -p %s && date +%%s >%s", deletionConfirmationDir, confirmationPath)},
+	)
+	if err != nil {
+		return fmt.Errorf("error confirming volume deletion for pod with UID %q: %v", c.podUID, err)
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("confirming volume deletion for pod with UID %q failed with exit code %d", c.podUID, exitCode)
+	}
+	return nil
+}
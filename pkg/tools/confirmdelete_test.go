@@ -0,0 +1,100 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestConfirmDeleteCommand(t *testing.T) {
+	for _, tc := range []struct {
+		args             string
+		expectedCommands map[string]string
+		errSubstring     string
+	}{
+		{
+			args: "cirros",
+			expectedCommands: map[string]string{
+				"virtlet-foo42/virtlet/kube-system: /bin/sh -c mkdir -p /var/lib/virtlet/deletion-confirmations && date +%s >/var/lib/virtlet/deletion-confirmations/4a1cfbb4-8e4e-11e8-9a4d-0242ac110002": "",
+			},
+		},
+		{
+			args: "--node kube-node-2 --uid 5d8b8c3e-8e4e-11e8-9a4d-0242ac110002",
+			expectedCommands: map[string]string{
+				"virtlet-g4lv5/virtlet/kube-system: /bin/sh -c mkdir -p /var/lib/virtlet/deletion-confirmations && date +%s >/var/lib/virtlet/deletion-confirmations/5d8b8c3e-8e4e-11e8-9a4d-0242ac110002": "",
+			},
+		},
+		{
+			args:         "ubuntu",
+			errSubstring: "can't get VM pod info",
+		},
+		{
+			args:         "--node kube-node-3 --uid 5d8b8c3e-8e4e-11e8-9a4d-0242ac110002",
+			errSubstring: "no Virtlet pod",
+		},
+		{
+			args:         "--node kube-node-2 --uid ../../etc",
+			errSubstring: "bad pod UID",
+		},
+		{
+			args:         "--node kube-node-2",
+			errSubstring: "must specify either the pod name or both --node and --uid",
+		},
+		{
+			args:         "cirros --uid 5d8b8c3e-8e4e-11e8-9a4d-0242ac110002",
+			errSubstring: "must specify either the pod name or both --node and --uid",
+		},
+	} {
+		t.Run(tc.args, func(t *testing.T) {
+			c := &fakeKubeClient{
+				t: t,
+				virtletPods: map[string]string{
+					"kube-node-1": "virtlet-foo42",
+					"kube-node-2": "virtlet-g4lv5",
+				},
+				vmPods: map[string]VMPodInfo{
+					"cirros": {
+						NodeName:       "kube-node-1",
+						VirtletPodName: "virtlet-foo42",
+						ContainerID:    "cc349e91-dcf7-4f11-a077-36c3673c3fc4",
+						ContainerName:  "foocontainer",
+						UID:            "4a1cfbb4-8e4e-11e8-9a4d-0242ac110002",
+					},
+				},
+				expectedCommands: tc.expectedCommands,
+			}
+			var out bytes.Buffer
+			cmd := NewConfirmDeleteCmd(c, &out)
+			cmd.SetArgs(strings.Split(tc.args, " "))
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			switch err := cmd.Execute(); {
+			case err != nil && tc.errSubstring == "":
+				t.Errorf("confirm-delete command returned an unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Errorf("Didn't get expected error (substring %q), output: %q", tc.errSubstring, out.String())
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("Didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			}
+			for c := range tc.expectedCommands {
+				t.Errorf("command not executed: %q", c)
+			}
+		})
+	}
+}
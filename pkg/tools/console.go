@@ -0,0 +1,147 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/renstrom/dedent"
+	"github.com/spf13/cobra"
+)
+
+const maxRecordingLineSize = 16 * 1024 * 1024
+
+type consoleCommand struct {
+	in            io.Reader
+	out           io.Writer
+	sleep         func(time.Duration)
+	replayPath    string
+	speed         float64
+	idleTimeLimit time.Duration
+	showInput     bool
+}
+
+// NewConsoleCmd returns a cobra.Command that replays the recorded
+// VM console sessions. If sleep is nil, time.Sleep is used to
+// reproduce the timing of the session.
+func NewConsoleCmd(in io.Reader, out io.Writer, sleep func(time.Duration)) *cobra.Command {
+	c := &consoleCommand{in: in, out: out, sleep: sleep}
+	if c.sleep == nil {
+		c.sleep = time.Sleep
+	}
+	cmd := &cobra.Command{
+		Use:   "console --replay file",
+		Short: "Replay a recorded VM console session",
+		Long: dedent.Dedent(`
+                        This command plays back a VM console session recorded
+                        by Virtlet when the console audit is enabled using
+                        consoleAuditDir or consoleAuditWebhook config options.
+                        The recordings use asciicast v2 format. To connect to
+                        the console of a running VM, use 'kubectl attach -it'.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case len(args) != 0:
+				return errors.New("this command does not accept arguments")
+			case c.replayPath == "":
+				return errors.New("please specify the recording to replay using --replay; use 'kubectl attach -it' to connect to the VM console")
+			case c.speed <= 0:
+				return errors.New("the speed must be positive")
+			}
+			return withInputFile(c.replayPath, c.in, c.replay)
+		},
+	}
+	cmd.Flags().StringVar(&c.replayPath, "replay", "", "The recording file to replay, '-' for stdin")
+	cmd.Flags().Float64Var(&c.speed, "speed", 1, "The playback speed factor")
+	cmd.Flags().DurationVar(&c.idleTimeLimit, "idle-time-limit", 0, "Limit the pauses between the events to the specified duration (0 means no limit)")
+	cmd.Flags().BoolVar(&c.showInput, "show-input", false, "Also display the input sent to the console")
+	return cmd
+}
+
+func (c *consoleCommand) replay(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxRecordingLineSize)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("error reading the recording: %v", err)
+		}
+		return errors.New("the recording is empty")
+	}
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return fmt.Errorf("error parsing the recording header: %v", err)
+	}
+	if header.Version != 2 {
+		return fmt.Errorf("unsupported recording version %d", header.Version)
+	}
+
+	var lastTime float64
+	for lineNo := 2; scanner.Scan(); lineNo++ {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("error parsing the recording line %d: %v", lineNo, err)
+		}
+		if len(event) != 3 {
+			return fmt.Errorf("bad event at the recording line %d", lineNo)
+		}
+		eventTime, timeOk := event[0].(float64)
+		kind, kindOk := event[1].(string)
+		data, dataOk := event[2].(string)
+		if !timeOk || !kindOk || !dataOk {
+			return fmt.Errorf("bad event at the recording line %d", lineNo)
+		}
+		// the console data that isn't valid UTF-8 is recorded
+		// base64-encoded with "b" appended to the event code
+		binary := false
+		if kind == "ob" || kind == "ib" {
+			binary = true
+			kind = kind[:1]
+		}
+		if kind != "o" && (kind != "i" || !c.showInput) {
+			continue
+		}
+		if binary {
+			decoded, err := base64.StdEncoding.DecodeString(data)
+			if err != nil {
+				return fmt.Errorf("bad binary data at the recording line %d: %v", lineNo, err)
+			}
+			data = string(decoded)
+		}
+		if eventTime > lastTime {
+			delay := time.Duration((eventTime - lastTime) / c.speed * float64(time.Second))
+			if c.idleTimeLimit > 0 && delay > c.idleTimeLimit {
+				delay = c.idleTimeLimit
+			}
+			c.sleep(delay)
+			lastTime = eventTime
+		}
+		if _, err := io.WriteString(c.out, data); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading the recording: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleRecording = `{"version":2,"width":80,"height":24,"timestamp":1500000000,"title":"default/cirros-vm (a1b2c3)"}
+[0.5,"o","login: "]
+[1,"r","100x40"]
+[1.5,"i","cirros\r"]
+[1.5,"o","cirros\r\n"]
+[11.5,"o","Password: "]
+[12,"ob","gP8="]
+`
+
+func TestConsoleCommand(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "virtletctl-console")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	recordingPath := filepath.Join(tmpDir, "session.cast")
+	if err := ioutil.WriteFile(recordingPath, []byte(sampleRecording), 0644); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+
+	for _, tc := range []struct {
+		name           string
+		args           string
+		stdin          string
+		expectedOutput string
+		expectedDelays []time.Duration
+		errSubstring   string
+	}{
+		{
+			name:           "replay from a file",
+			args:           "--replay " + recordingPath,
+			expectedOutput: "login: cirros\r\nPassword: \x80\xff",
+			expectedDelays: []time.Duration{500 * time.Millisecond, time.Second, 10 * time.Second, 500 * time.Millisecond},
+		},
+		{
+			name:           "replay from stdin with speed and idle time limit",
+			args:           "--replay - --speed 2 --idle-time-limit 3s",
+			stdin:          sampleRecording,
+			expectedOutput: "login: cirros\r\nPassword: \x80\xff",
+			expectedDelays: []time.Duration{250 * time.Millisecond, 500 * time.Millisecond, 3 * time.Second, 250 * time.Millisecond},
+		},
+		{
+			name:           "replay with input",
+			args:           "--replay " + recordingPath + " --show-input",
+			expectedOutput: "login: cirros\rcirros\r\nPassword: \x80\xff",
+			expectedDelays: []time.Duration{500 * time.Millisecond, time.Second, 10 * time.Second, 500 * time.Millisecond},
+		},
+		{
+			name:         "no recording",
+			args:         "",
+			errSubstring: "kubectl attach -it",
+		},
+		{
+			name:         "bad speed",
+			args:         "--replay - --speed 0",
+			errSubstring: "the speed must be positive",
+		},
+		{
+			name:         "bad version",
+			args:         "--replay -",
+			stdin:        `{"version":1}`,
+			errSubstring: "unsupported recording version 1",
+		},
+		{
+			name:         "bad event",
+			args:         "--replay -",
+			stdin:        "{\"version\":2}\n[0.5,\"o\"]\n",
+			errSubstring: "bad event at the recording line 2",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var delays []time.Duration
+			var out bytes.Buffer
+			cmd := NewConsoleCmd(strings.NewReader(tc.stdin), &out, func(d time.Duration) {
+				delays = append(delays, d)
+			})
+			args := []string{}
+			if tc.args != "" {
+				args = strings.Split(tc.args, " ")
+			}
+			cmd.SetArgs(args)
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			switch err := cmd.Execute(); {
+			case err != nil && tc.errSubstring == "":
+				t.Errorf("console command returned an unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Errorf("Didn't get expected error (substring %q), output: %q", tc.errSubstring, out.String())
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("Didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			case err == nil && out.String() != tc.expectedOutput:
+				t.Errorf("Unexpected output from the command: %q instead of %q", out.String(), tc.expectedOutput)
+			}
+			if !reflect.DeepEqual(delays, tc.expectedDelays) {
+				t.Errorf("bad delays: %v instead of %v", delays, tc.expectedDelays)
+			}
+		})
+	}
+}
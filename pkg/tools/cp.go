@@ -0,0 +1,294 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/renstrom/dedent"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// the size of the chunks used to transfer files via the guest agent
+	agentChunkSize = 48 * 1024
+)
+
+// cpCommand contains the data needed by the cp subcommand which
+// copies files between a VM pod and the local filesystem.
+type cpCommand struct {
+	client    KubeClient
+	in        io.Reader
+	out       io.Writer
+	podName   string
+	guestPath string
+	localPath string
+	upload    bool
+	vmPodInfo *VMPodInfo
+}
+
+// NewCpCmd returns a cobra.Command that copies files between a VM pod
+// and the local filesystem.
+func NewCpCmd(client KubeClient, in io.Reader, out io.Writer) *cobra.Command {
+	cp := &cpCommand{client: client, in: in, out: out}
+	return &cobra.Command{
+		Use:   "cp [flags] src dest",
+		Short: "Copy files to and from a VM pod",
+		Long: dedent.Dedent(`
+                        This command copies a file from a VM pod to the local
+                        filesystem or vice versa. The path inside the VM is
+                        specified as pod:/path. Local path '-' denotes stdin
+                        or stdout.
+
+                        If the VM is running, the file is transferred via the
+                        QEMU guest agent, which must be enabled for the VM
+                        pod using VirtletGuestAgent annotation and must be
+                        running inside the VM. If the VM isn't running, the
+                        file is copied by accessing the root volume of the VM
+                        using libguestfs.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return errors.New("please specify the source and the destination")
+			}
+			srcPod, srcPath := parseCpArg(args[0])
+			dstPod, dstPath := parseCpArg(args[1])
+			switch {
+			case srcPod != "" && dstPod != "":
+				return errors.New("copying between VM pods is not supported")
+			case srcPod != "":
+				cp.podName, cp.guestPath, cp.localPath = srcPod, srcPath, dstPath
+			case dstPod != "":
+				cp.podName, cp.guestPath, cp.localPath, cp.upload = dstPod, dstPath, srcPath, true
+			default:
+				return errors.New("either the source or the destination must be pod:/path")
+			}
+			return cp.Run()
+		},
+	}
+}
+
+// parseCpArg splits pod:/path argument into the pod name and the path.
+// For local paths, the returned pod name is empty.
+func parseCpArg(arg string) (string, string) {
+	p := strings.Index(arg, ":")
+	if p <= 0 || strings.ContainsAny(arg[:p], "/.") {
+		return "", arg
+	}
+	return arg[:p], arg[p+1:]
+}
+
+// Run executes the command.
+func (c *cpCommand) Run() error {
+	var err error
+	c.vmPodInfo, err = c.client.GetVMPodInfo(c.podName)
+	if err != nil {
+		return fmt.Errorf("can't get VM pod info for %q: %v", c.podName, err)
+	}
+
+	var buf bytes.Buffer
+	if err := c.exec("libvirt", nil, &buf, "virsh", "domstate", c.vmPodInfo.LibvirtDomainName()); err != nil {
+		return err
+	}
+	if strings.TrimSpace(buf.String()) == "running" {
+		if err := c.agentCommand("guest-ping", nil, nil); err != nil {
+			return fmt.Errorf("VM pod %q is running, but the guest agent isn't available: %v", c.podName, err)
+		}
+		if c.upload {
+			return c.withLocalReader(c.agentUpload)
+		}
+		return c.withLocalWriter(c.agentDownload)
+	}
+
+	disk, err := c.rootDiskPath()
+	if err != nil {
+		return err
+	}
+	if c.upload {
+		return c.withLocalReader(func(r io.Reader) error {
+			return c.exec("virtlet", r, c.out, "guestfish", "-a", disk, "-i", "upload", "-", c.guestPath)
+		})
+	}
+	return c.withLocalWriter(func(w io.Writer) error {
+		return c.exec("virtlet", nil, w, "guestfish", "--ro", "-a", disk, "-i", "download", c.guestPath, "-")
+	})
+}
+
+func (c *cpCommand) withLocalReader(toCall func(r io.Reader) error) error {
+	if c.localPath == "-" {
+		return toCall(c.in)
+	}
+	f, err := os.Open(c.localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return toCall(f)
+}
+
+func (c *cpCommand) withLocalWriter(toCall func(w io.Writer) error) error {
+	if c.localPath == "-" {
+		return toCall(c.out)
+	}
+	f, err := os.Create(c.localPath)
+	if err != nil {
+		return err
+	}
+	if err := toCall(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (c *cpCommand) exec(containerName string, stdin io.Reader, stdout io.Writer, command ...string) error {
+	exitCode, err := c.client.ExecInContainer(c.vmPodInfo.VirtletPodName, containerName, "kube-system", stdin, stdout, os.Stderr, command)
+	if err != nil {
+		return fmt.Errorf("error executing %s in Virtlet pod %q: %v", command[0], c.vmPodInfo.VirtletPodName, err)
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("%s returned non-zero exit code %d", command[0], exitCode)
+	}
+	return nil
+}
+
+// rootDiskPath returns the path to the root volume of the VM,
+// which is the first disk of the domain.
+func (c *cpCommand) rootDiskPath() (string, error) {
+	var buf bytes.Buffer
+	if err := c.exec("libvirt", nil, &buf, "virsh", "domblklist", "--details", c.vmPodInfo.LibvirtDomainName()); err != nil {
+		return "", err
+	}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		// Type Device Target Source
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 4 && fields[1] == "disk" && fields[3] != "-" {
+			return fields[3], nil
+		}
+	}
+	return "", fmt.Errorf("root disk not found for VM pod %q", c.podName)
+}
+
+type agentRequest struct {
+	Execute   string      `json:"execute"`
+	Arguments interface{} `json:"arguments,omitempty"`
+}
+
+type agentReadResult struct {
+	Count  int    `json:"count"`
+	BufB64 string `json:"buf-b64"`
+	EOF    bool   `json:"eof"`
+}
+
+// agentCommand executes a guest agent command and unmarshals the
+// contents of its "return" field into result unless it's nil.
+func (c *cpCommand) agentCommand(command string, args, result interface{}) error {
+	req, err := json.Marshal(agentRequest{Execute: command, Arguments: args})
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := c.exec("libvirt", nil, &buf, "virsh", "qemu-agent-command", c.vmPodInfo.LibvirtDomainName(), string(req)); err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	resp := struct {
+		Return interface{} `json:"return"`
+	}{Return: result}
+	if err := json.Unmarshal(buf.Bytes(), &resp); err != nil {
+		return fmt.Errorf("error unmarshalling guest agent response for %s: %v", command, err)
+	}
+	return nil
+}
+
+func (c *cpCommand) agentOpen(mode string) (int, error) {
+	var handle int
+	if err := c.agentCommand("guest-file-open", map[string]string{"path": c.guestPath, "mode": mode}, &handle); err != nil {
+		return 0, fmt.Errorf("error opening %q inside the VM: %v", c.guestPath, err)
+	}
+	return handle, nil
+}
+
+func (c *cpCommand) agentClose(handle int) error {
+	return c.agentCommand("guest-file-close", map[string]int{"handle": handle}, nil)
+}
+
+func (c *cpCommand) agentDownload(w io.Writer) error {
+	handle, err := c.agentOpen("r")
+	if err != nil {
+		return err
+	}
+	for {
+		var r agentReadResult
+		if err := c.agentCommand("guest-file-read", map[string]int{"handle": handle, "count": agentChunkSize}, &r); err != nil {
+			c.agentClose(handle)
+			return err
+		}
+		data, err := base64.StdEncoding.DecodeString(r.BufB64)
+		if err != nil {
+			c.agentClose(handle)
+			return fmt.Errorf("error decoding file data: %v", err)
+		}
+		if _, err := w.Write(data); err != nil {
+			c.agentClose(handle)
+			return err
+		}
+		if r.EOF || r.Count == 0 {
+			break
+		}
+	}
+	return c.agentClose(handle)
+}
+
+func (c *cpCommand) agentUpload(r io.Reader) error {
+	handle, err := c.agentOpen("w")
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, agentChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			args := map[string]interface{}{
+				"handle":  handle,
+				"buf-b64": base64.StdEncoding.EncodeToString(buf[:n]),
+			}
+			if err := c.agentCommand("guest-file-write", args, nil); err != nil {
+				c.agentClose(handle)
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			c.agentClose(handle)
+			return err
+		}
+	}
+	return c.agentClose(handle)
+}
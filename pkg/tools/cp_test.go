@@ -0,0 +1,132 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const (
+	cpTestDomain  = "virtlet-cc349e91-dcf7-foocontainer"
+	cpTestVirsh   = "virtlet-foo42/libvirt/kube-system: virsh "
+	cpTestVirtlet = "virtlet-foo42/virtlet/kube-system: "
+	cpTestBlkList = "Type       Device     Target     Source\n" +
+		"------------------------------------------------\n" +
+		"file       disk       sda        /var/lib/virtlet/volumes/virtlet_root_cc349e91\n" +
+		"file       cdrom      sdb        /var/lib/virtlet/config/config-cc349e91.iso\n"
+)
+
+func TestCpCommand(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		args             string
+		stdin            string
+		expectedCommands map[string]string
+		expectedOutput   string
+		errSubstring     string
+	}{
+		{
+			name: "download from a stopped VM",
+			args: "cirros:/etc/hostname -",
+			expectedCommands: map[string]string{
+				cpTestVirsh + "domstate " + cpTestDomain:                                                                       "shut off\n",
+				cpTestVirsh + "domblklist --details " + cpTestDomain:                                                           cpTestBlkList,
+				cpTestVirtlet + "guestfish --ro -a /var/lib/virtlet/volumes/virtlet_root_cc349e91 -i download /etc/hostname -": "cirros",
+			},
+			expectedOutput: "cirros",
+		},
+		{
+			name:  "upload to a stopped VM",
+			args:  "- cirros:/etc/hostname",
+			stdin: "foobar",
+			expectedCommands: map[string]string{
+				cpTestVirsh + "domstate " + cpTestDomain:                                                                "shut off\n",
+				cpTestVirsh + "domblklist --details " + cpTestDomain:                                                    cpTestBlkList,
+				cpTestVirtlet + "guestfish -a /var/lib/virtlet/volumes/virtlet_root_cc349e91 -i upload - /etc/hostname": "",
+			},
+		},
+		{
+			name: "download from a running VM",
+			args: "cirros:/etc/hostname -",
+			expectedCommands: map[string]string{
+				cpTestVirsh + "domstate " + cpTestDomain:                                                                                              "running\n",
+				cpTestVirsh + "qemu-agent-command " + cpTestDomain + ` {"execute":"guest-ping"}`:                                                      `{"return":{}}`,
+				cpTestVirsh + "qemu-agent-command " + cpTestDomain + ` {"execute":"guest-file-open","arguments":{"mode":"r","path":"/etc/hostname"}}`: `{"return":1000}`,
+				cpTestVirsh + "qemu-agent-command " + cpTestDomain + ` {"execute":"guest-file-read","arguments":{"count":49152,"handle":1000}}`:       `{"return":{"count":6,"buf-b64":"Zm9vYmFy","eof":true}}`,
+				cpTestVirsh + "qemu-agent-command " + cpTestDomain + ` {"execute":"guest-file-close","arguments":{"handle":1000}}`:                    `{"return":{}}`,
+			},
+			expectedOutput: "foobar",
+		},
+		{
+			name:  "upload to a running VM",
+			args:  "- cirros:/etc/hostname",
+			stdin: "foobar",
+			expectedCommands: map[string]string{
+				cpTestVirsh + "domstate " + cpTestDomain:                                                                                                "running\n",
+				cpTestVirsh + "qemu-agent-command " + cpTestDomain + ` {"execute":"guest-ping"}`:                                                        `{"return":{}}`,
+				cpTestVirsh + "qemu-agent-command " + cpTestDomain + ` {"execute":"guest-file-open","arguments":{"mode":"w","path":"/etc/hostname"}}`:   `{"return":1000}`,
+				cpTestVirsh + "qemu-agent-command " + cpTestDomain + ` {"execute":"guest-file-write","arguments":{"buf-b64":"Zm9vYmFy","handle":1000}}`: `{"return":{"count":6,"eof":false}}`,
+				cpTestVirsh + "qemu-agent-command " + cpTestDomain + ` {"execute":"guest-file-close","arguments":{"handle":1000}}`:                      `{"return":{}}`,
+			},
+		},
+		{
+			name:         "no pod",
+			args:         "/tmp/foo /tmp/bar",
+			errSubstring: "either the source or the destination must be pod:/path",
+		},
+		{
+			name:         "two pods",
+			args:         "cirros:/tmp/foo cirros:/tmp/bar",
+			errSubstring: "copying between VM pods is not supported",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &fakeKubeClient{
+				t: t,
+				vmPods: map[string]VMPodInfo{
+					"cirros": {
+						NodeName:       "kube-node-1",
+						VirtletPodName: "virtlet-foo42",
+						ContainerID:    "cc349e91-dcf7-4f11-a077-36c3673c3fc4",
+						ContainerName:  "foocontainer",
+					},
+				},
+				expectedCommands: tc.expectedCommands,
+			}
+			var out bytes.Buffer
+			cmd := NewCpCmd(c, strings.NewReader(tc.stdin), &out)
+			cmd.SetArgs(strings.Split(tc.args, " "))
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			switch err := cmd.Execute(); {
+			case err != nil && tc.errSubstring == "":
+				t.Errorf("cp command returned an unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Errorf("Didn't get expected error (substring %q), output: %q", tc.errSubstring, out.String())
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("Didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			case err == nil && out.String() != tc.expectedOutput:
+				t.Errorf("Unexpected output from the command: %q instead of %q", out.String(), tc.expectedOutput)
+			}
+			for c := range tc.expectedCommands {
+				t.Errorf("command not executed: %q", c)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/renstrom/dedent"
+	"github.com/spf13/cobra"
+
+	"github.com/Mirantis/virtlet/pkg/diag"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const emulatorInfoDiagSource = "emulator-info"
+
+type describeCommand struct {
+	client KubeClient
+	out    io.Writer
+}
+
+// NewDescribeCmd returns a cobra.Command that displays the
+// information about a VM pod and its emulator process.
+func NewDescribeCmd(client KubeClient, out io.Writer) *cobra.Command {
+	c := &describeCommand{client: client, out: out}
+	return &cobra.Command{
+		Use:   "describe pod",
+		Short: "Display the information about a VM pod and its emulator process",
+		Long: dedent.Dedent(`
+                        This command displays the node, the Virtlet pod and the
+                        libvirt domain of a VM pod along with the information
+                        about the emulator (QEMU) process of the VM: its PID,
+                        the path to the monitor socket, the vhost threads and
+                        the cgroups.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("please specify the pod")
+			}
+			return c.run(args[0])
+		},
+	}
+}
+
+func (c *describeCommand) run(podName string) error {
+	vmPodInfo, err := c.client.GetVMPodInfo(podName)
+	if err != nil {
+		return fmt.Errorf("can't get VM pod info for %q: %v", podName, err)
+	}
+	info, err := c.getEmulatorInfo(vmPodInfo, podName)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "Pod: %s/%s\n", vmPodInfo.Namespace, podName)
+	fmt.Fprintf(&out, "Node: %s\n", vmPodInfo.NodeName)
+	fmt.Fprintf(&out, "Virtlet pod: %s\n", vmPodInfo.VirtletPodName)
+	fmt.Fprintf(&out, "Container ID: %s\n", vmPodInfo.ContainerID)
+	fmt.Fprintf(&out, "Libvirt domain: %s\n", vmPodInfo.LibvirtDomainName())
+	if info == nil {
+		out.WriteString("Emulator: not running\n")
+		_, err = c.out.Write(out.Bytes())
+		return err
+	}
+
+	fmt.Fprintf(&out, "Emulator PID: %d\n", info.PID)
+	fmt.Fprintf(&out, "Monitor socket: %s\n", info.MonitorSocketPath)
+	var threads []string
+	for _, tid := range info.VhostThreads {
+		threads = append(threads, fmt.Sprint(tid))
+	}
+	fmt.Fprintf(&out, "Vhost threads: %s\n", strings.Join(threads, ", "))
+	out.WriteString("Cgroups:\n")
+	var controllers []string
+	for controller := range info.Cgroups {
+		controllers = append(controllers, controller)
+	}
+	sort.Strings(controllers)
+	for _, controller := range controllers {
+		fmt.Fprintf(&out, "  %s: %s\n", controller, info.Cgroups[controller])
+	}
+	_, err = c.out.Write(out.Bytes())
+	return err
+}
+
+func (c *describeCommand) getEmulatorInfo(vmPodInfo *VMPodInfo, podName string) (*types.EmulatorInfo, error) {
+	var buf bytes.Buffer
+	exitCode, err := c.client.ExecInContainer(
+		vmPodInfo.VirtletPodName, "virtlet", "kube-system", nil,
+		&buf, os.Stderr, []string{"virtlet", "--diag"})
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("error getting diagnostics from Virtlet pod %q: %v", vmPodInfo.VirtletPodName, err)
+	case exitCode != 0:
+		return nil, fmt.Errorf("error getting diagnostics from Virtlet pod %q: exit code %d", vmPodInfo.VirtletPodName, exitCode)
+	}
+	dr, err := diag.DecodeDiagnostics(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	src, found := dr.Children[emulatorInfoDiagSource]
+	switch {
+	case !found:
+		return nil, fmt.Errorf("Virtlet on node %q doesn't provide the emulator info", vmPodInfo.NodeName)
+	case src.Error != "":
+		return nil, fmt.Errorf("error retrieving the emulator info: %s", src.Error)
+	}
+	podInfo, found := src.Children[fmt.Sprintf("%s-%s", vmPodInfo.Namespace, podName)]
+	if !found {
+		return nil, nil
+	}
+	var info types.EmulatorInfo
+	if err := json.Unmarshal([]byte(podInfo.Data), &info); err != nil {
+		return nil, fmt.Errorf("error unmarshalling the emulator info: %v", err)
+	}
+	return &info, nil
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/diag"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func emulatorInfoDiagOutput(t *testing.T, info *types.EmulatorInfo) string {
+	children := map[string]diag.Result{}
+	if info != nil {
+		infoData, err := json.Marshal(info)
+		if err != nil {
+			t.Fatalf("json.Marshal(): %v", err)
+		}
+		children["default-cirros-vm"] = diag.Result{
+			Name: "default-cirros-vm",
+			Ext:  "json",
+			Data: string(infoData),
+		}
+	}
+	dr := diag.Result{
+		Name:  "diagnostics",
+		IsDir: true,
+		Children: map[string]diag.Result{
+			"emulator-info": {
+				Name:     "emulator-info",
+				IsDir:    true,
+				Children: children,
+			},
+		},
+	}
+	return string(dr.ToJSON())
+}
+
+func TestDescribeCommand(t *testing.T) {
+	podInfo := "Pod: default/cirros-vm\n" +
+		"Node: kube-node-1\n" +
+		"Virtlet pod: virtlet-foo42\n" +
+		"Container ID: 6a5bd1b2-2f4c-4a0b-8c3e-1d2e3f4a5b6c\n" +
+		"Libvirt domain: virtlet-6a5bd1b2-2f4c-cirros-vm\n"
+	for _, tc := range []struct {
+		name           string
+		args           string
+		info           *types.EmulatorInfo
+		expectedOutput string
+		errSubstring   string
+	}{
+		{
+			name: "running vm",
+			args: "cirros-vm",
+			info: &types.EmulatorInfo{
+				PID: 4242,
+				Cgroups: map[string]string{
+					"memory":      "/machine/qemu-1-virtlet-6a5bd1b2-2f4c-cirros-vm",
+					"cpuset":      "/machine/qemu-1-virtlet-6a5bd1b2-2f4c-cirros-vm/emulator",
+					"cpu,cpuacct": "/machine/qemu-1-virtlet-6a5bd1b2-2f4c-cirros-vm",
+				},
+				VhostThreads:      []int{4250, 4251},
+				MonitorSocketPath: "/var/lib/libvirt/qemu/domain-1-virtlet-6a5bd1b2-2f4c-cirros-vm/monitor.sock",
+			},
+			expectedOutput: podInfo +
+				"Emulator PID: 4242\n" +
+				"Monitor socket: /var/lib/libvirt/qemu/domain-1-virtlet-6a5bd1b2-2f4c-cirros-vm/monitor.sock\n" +
+				"Vhost threads: 4250, 4251\n" +
+				"Cgroups:\n" +
+				"  cpu,cpuacct: /machine/qemu-1-virtlet-6a5bd1b2-2f4c-cirros-vm\n" +
+				"  cpuset: /machine/qemu-1-virtlet-6a5bd1b2-2f4c-cirros-vm/emulator\n" +
+				"  memory: /machine/qemu-1-virtlet-6a5bd1b2-2f4c-cirros-vm\n",
+		},
+		{
+			name:           "vm not running",
+			args:           "cirros-vm",
+			expectedOutput: podInfo + "Emulator: not running\n",
+		},
+		{
+			name:         "no pod",
+			args:         "",
+			errSubstring: "please specify the pod",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			expectedCommands := map[string]string{}
+			if tc.args != "" {
+				expectedCommands["virtlet-foo42/virtlet/kube-system: virtlet --diag"] = emulatorInfoDiagOutput(t, tc.info)
+			}
+			c := &fakeKubeClient{
+				t: t,
+				vmPods: map[string]VMPodInfo{
+					"cirros-vm": {
+						Namespace:      "default",
+						NodeName:       "kube-node-1",
+						VirtletPodName: "virtlet-foo42",
+						ContainerID:    "6a5bd1b2-2f4c-4a0b-8c3e-1d2e3f4a5b6c",
+						ContainerName:  "cirros-vm",
+					},
+				},
+				expectedCommands: expectedCommands,
+			}
+			var out bytes.Buffer
+			cmd := NewDescribeCmd(c, &out)
+			args := []string{}
+			if tc.args != "" {
+				args = strings.Split(tc.args, " ")
+			}
+			cmd.SetArgs(args)
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			switch err := cmd.Execute(); {
+			case err != nil && tc.errSubstring == "":
+				t.Errorf("describe command returned an unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Errorf("Didn't get expected error (substring %q), output: %q", tc.errSubstring, out.String())
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("Didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			case err == nil && out.String() != tc.expectedOutput:
+				t.Errorf("Unexpected output from the command:\n%s\n-- instead of --\n%s", out.String(), tc.expectedOutput)
+			}
+			for c := range c.expectedCommands {
+				t.Errorf("command not executed: %q", c)
+			}
+		})
+	}
+}
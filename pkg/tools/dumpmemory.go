@@ -0,0 +1,306 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/renstrom/dedent"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// defaultMaxDumpSize is the default limit for the size of
+	// the uncompressed memory dump
+	defaultMaxDumpSize = "4Gi"
+	// dumpDir is the directory inside the libvirt container
+	// where the memory dumps are stored before they're
+	// transferred
+	dumpDir = "/tmp"
+	// kernelBannerPrefix is the prefix of the Linux kernel
+	// banner (linux_banner) that's looked up in the dump
+	kernelBannerPrefix = "Linux version "
+	// maxKernelBannerLen is the max length of the kernel banner
+	// reported by dump-memory command
+	maxKernelBannerLen = 256
+)
+
+var errDumpTooBig = errors.New("the memory dump exceeds the max size")
+
+// dumpMemoryCommand contains the data needed by the dump-memory
+// subcommand which makes a memory dump of a running VM.
+type dumpMemoryCommand struct {
+	client     KubeClient
+	out        io.Writer
+	podName    string
+	outputPath string
+	compress   bool
+	analyze    bool
+	maxSize    string
+	vmPodInfo  *VMPodInfo
+}
+
+// NewDumpMemoryCmd returns a cobra.Command that makes a memory dump
+// of a VM pod.
+func NewDumpMemoryCmd(client KubeClient, out io.Writer) *cobra.Command {
+	d := &dumpMemoryCommand{client: client, out: out}
+	cmd := &cobra.Command{
+		Use:   "dump-memory [flags] pod",
+		Short: "Make a memory dump of a VM pod",
+		Long: dedent.Dedent(`
+                        This command makes a memory dump of a running VM pod
+                        without stopping the VM and saves it to a local file in
+                        ELF format which can be inspected using crash or gdb.
+                        The dump is stored on the node temporarily and is removed
+                        after it's transferred. Unless --analyze=false is
+                        specified, the command also tries to detect the version
+                        of Linux kernel running in the VM by looking up the
+                        kernel banner in the dump.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("please specify the pod")
+			}
+			d.podName = args[0]
+			return d.Run()
+		},
+	}
+	cmd.Flags().StringVarP(&d.outputPath, "output", "o", "", "output file name (defaults to <pod>.dump or <pod>.dump.gz)")
+	cmd.Flags().BoolVar(&d.compress, "compress", false, "compress the dump using gzip")
+	cmd.Flags().StringVar(&d.maxSize, "max-size", defaultMaxDumpSize, "max size of the uncompressed dump")
+	cmd.Flags().BoolVar(&d.analyze, "analyze", true, "detect the kernel version of the VM")
+	return cmd
+}
+
+// Run executes the command.
+func (d *dumpMemoryCommand) Run() error {
+	q, err := resource.ParseQuantity(d.maxSize)
+	if err != nil {
+		return fmt.Errorf("bad max dump size %q: %v", d.maxSize, err)
+	}
+	maxSize := q.Value()
+	if maxSize <= 0 {
+		return fmt.Errorf("bad max dump size %q", d.maxSize)
+	}
+
+	d.vmPodInfo, err = d.client.GetVMPodInfo(d.podName)
+	if err != nil {
+		return fmt.Errorf("can't get VM pod info for %q: %v", d.podName, err)
+	}
+	domainName := d.vmPodInfo.LibvirtDomainName()
+
+	// the dump is slightly larger than the VM memory because of
+	// the ELF headers, but this check avoids filling up the
+	// node disk with the dumps that will be rejected anyway
+	memSize, err := d.vmMemorySize(domainName)
+	if err != nil {
+		return err
+	}
+	if memSize > maxSize {
+		return fmt.Errorf("the memory size of VM pod %q (%d bytes) exceeds the max dump size (%d bytes)", d.podName, memSize, maxSize)
+	}
+
+	outputPath := d.outputPath
+	if outputPath == "" {
+		outputPath = d.podName + ".dump"
+		if d.compress {
+			outputPath += ".gz"
+		}
+	}
+
+	dumpPath := fmt.Sprintf("%s/%s.memdump", dumpDir, domainName)
+	if err := d.exec(nil, "virsh", "dump", "--live", "--memory-only", "--format", "elf", domainName, dumpPath); err != nil {
+		return err
+	}
+	defer func() {
+		if err := d.exec(nil, "rm", "-f", dumpPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to remove the memory dump from the node: %v\n", err)
+		}
+	}()
+
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	detector := &kernelBannerDetector{}
+	size, err := d.transferDump(f, dumpPath, maxSize, detector)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+
+	fmt.Fprintf(d.out, "Saved the memory dump of VM pod %q to %s (%d bytes uncompressed)\n", d.podName, outputPath, size)
+	if d.analyze {
+		banner := detector.banner
+		if banner == "" {
+			banner = "not detected"
+		}
+		fmt.Fprintf(d.out, "Kernel: %s\n", banner)
+	}
+	return nil
+}
+
+// transferDump copies the dump from the node to w, compressing it
+// if needed and feeding the uncompressed data to the kernel banner
+// detector. It returns the size of the uncompressed dump.
+func (d *dumpMemoryCommand) transferDump(w io.Writer, dumpPath string, maxSize int64, detector *kernelBannerDetector) (int64, error) {
+	var gz *gzip.Writer
+	if d.compress {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+	bw := bufio.NewWriter(w)
+	lw := &limitedWriter{w: bw, remaining: maxSize}
+	var dw io.Writer = lw
+	if d.analyze {
+		dw = io.MultiWriter(lw, detector)
+	}
+	if err := d.exec(dw, "cat", dumpPath); err != nil {
+		if lw.exceeded {
+			return 0, errDumpTooBig
+		}
+		return 0, err
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return 0, err
+		}
+	}
+	return maxSize - lw.remaining, nil
+}
+
+// vmMemorySize returns the max memory size of the VM in bytes.
+func (d *dumpMemoryCommand) vmMemorySize(domainName string) (int64, error) {
+	var buf bytes.Buffer
+	if err := d.exec(&buf, "virsh", "dominfo", domainName); err != nil {
+		return 0, err
+	}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		// Max memory:     1048576 KiB
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Max memory:") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "Max memory:"))
+		if len(fields) != 2 || fields[1] != "KiB" {
+			break
+		}
+		kib, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			break
+		}
+		return kib * 1024, nil
+	}
+	return 0, fmt.Errorf("can't get the memory size of VM pod %q", d.podName)
+}
+
+func (d *dumpMemoryCommand) exec(stdout io.Writer, command ...string) error {
+	exitCode, err := d.client.ExecInContainer(d.vmPodInfo.VirtletPodName, "libvirt", "kube-system", nil, stdout, os.Stderr, command)
+	if err != nil {
+		return fmt.Errorf("error executing %s in Virtlet pod %q: %v", command[0], d.vmPodInfo.VirtletPodName, err)
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("%s returned non-zero exit code %d", command[0], exitCode)
+	}
+	return nil
+}
+
+// limitedWriter passes at most the specified number of bytes to
+// the underlying writer and fails after that.
+type limitedWriter struct {
+	w         io.Writer
+	remaining int64
+	exceeded  bool
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > lw.remaining {
+		lw.exceeded = true
+		return 0, errDumpTooBig
+	}
+	n, err := lw.w.Write(p)
+	lw.remaining -= int64(n)
+	return n, err
+}
+
+// kernelBannerDetector looks up the Linux kernel banner such as
+// "Linux version 4.15.0-23-generic (buildd@lgw01-amd64-055) ..."
+// in the data written to it.
+type kernelBannerDetector struct {
+	// tail holds the end of the data written so far that
+	// may contain the beginning of the banner
+	tail   []byte
+	banner string
+}
+
+func (kd *kernelBannerDetector) Write(p []byte) (int, error) {
+	if kd.banner != "" {
+		return len(p), nil
+	}
+	data := append(kd.tail, p...)
+	prefix := []byte(kernelBannerPrefix)
+	for offset := 0; ; {
+		idx := bytes.Index(data[offset:], prefix)
+		if idx < 0 {
+			break
+		}
+		start := offset + idx
+		rest := data[start+len(prefix):]
+		if len(rest) == 0 {
+			kd.tail = append([]byte(nil), data[start:]...)
+			return len(p), nil
+		}
+		if rest[0] < '0' || rest[0] > '9' {
+			offset = start + len(prefix)
+			continue
+		}
+		end := bytes.IndexAny(rest, "\x00\n")
+		switch {
+		case end < 0 && len(rest) < maxKernelBannerLen:
+			// the banner may continue in the next chunk
+			kd.tail = append([]byte(nil), data[start:]...)
+			return len(p), nil
+		case end < 0 || end > maxKernelBannerLen:
+			end = maxKernelBannerLen
+		}
+		kd.banner = strings.TrimSpace(kernelBannerPrefix + string(rest[:end]))
+		kd.tail = nil
+		return len(p), nil
+	}
+	if len(data) >= len(prefix) {
+		data = data[len(data)-len(prefix)+1:]
+	}
+	kd.tail = append([]byte(nil), data...)
+	return len(p), nil
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	dumpTestDomain  = "virtlet-cc349e91-dcf7-foocontainer"
+	dumpTestPath    = "/tmp/" + dumpTestDomain + ".memdump"
+	dumpTestLibvirt = "virtlet-foo42/libvirt/kube-system: "
+	dumpTestBanner  = "Linux version 4.14.32 (builder@buildhost) (gcc version 6.3.0 (GCC)) #1 SMP Fri Apr 6 10:29:36 UTC 2018"
+	dumpTestDomInfo = "Id:             1\n" +
+		"Name:           " + dumpTestDomain + "\n" +
+		"State:          running\n" +
+		"Max memory:     1024 KiB\n" +
+		"Used memory:    1024 KiB\n"
+)
+
+func TestDumpMemoryCommand(t *testing.T) {
+	dump := "\x7fELF" + strings.Repeat("\x00", 100) +
+		"Linux version %s\x00" + strings.Repeat("\x00", 100) +
+		dumpTestBanner + "\n\x00" + strings.Repeat("\x00", 100) +
+		"Linux version 3.0.0 (not a banner)\x00"
+	for _, tc := range []struct {
+		name           string
+		args           string
+		output         string
+		domInfo        string
+		dump           string
+		compressed     bool
+		expectedOutput string
+		errSubstring   string
+	}{
+		{
+			name:    "dump",
+			args:    "cirros",
+			output:  "cirros.dump",
+			domInfo: dumpTestDomInfo,
+			dump:    dump,
+			expectedOutput: "Saved the memory dump of VM pod \"cirros\" to cirros.dump (460 bytes uncompressed)\n" +
+				"Kernel: " + dumpTestBanner + "\n",
+		},
+		{
+			name:       "compressed dump",
+			args:       "--compress cirros",
+			output:     "cirros.dump.gz",
+			domInfo:    dumpTestDomInfo,
+			dump:       dump,
+			compressed: true,
+			expectedOutput: "Saved the memory dump of VM pod \"cirros\" to cirros.dump.gz (460 bytes uncompressed)\n" +
+				"Kernel: " + dumpTestBanner + "\n",
+		},
+		{
+			name:           "custom output file without analysis",
+			args:           "-o foo.dump --analyze=false cirros",
+			output:         "foo.dump",
+			domInfo:        dumpTestDomInfo,
+			dump:           dump,
+			expectedOutput: "Saved the memory dump of VM pod \"cirros\" to foo.dump (460 bytes uncompressed)\n",
+		},
+		{
+			name:           "no kernel banner",
+			args:           "cirros",
+			output:         "cirros.dump",
+			domInfo:        dumpTestDomInfo,
+			dump:           "\x7fELF" + strings.Repeat("\x00", 100),
+			expectedOutput: "Saved the memory dump of VM pod \"cirros\" to cirros.dump (104 bytes uncompressed)\n" + "Kernel: not detected\n",
+		},
+		{
+			name:         "VM memory exceeds max size",
+			args:         "--max-size 512Ki cirros",
+			domInfo:      dumpTestDomInfo,
+			errSubstring: "exceeds the max dump size",
+		},
+		{
+			name:         "dump exceeds max size",
+			args:         "--max-size 1Ki cirros",
+			output:       "cirros.dump",
+			domInfo:      strings.Replace(dumpTestDomInfo, "Max memory:     1024 KiB", "Max memory:     1 KiB", 1),
+			dump:         dump + strings.Repeat("\x00", 1024),
+			errSubstring: "the memory dump exceeds the max size",
+		},
+		{
+			name:         "bad max size",
+			args:         "--max-size foo cirros",
+			errSubstring: "bad max dump size",
+		},
+		{
+			name:         "no pod",
+			args:         "",
+			errSubstring: "please specify the pod",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, err := ioutil.TempDir("", "dump-memory-")
+			if err != nil {
+				t.Fatalf("TempDir(): %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+			curDir, err := os.Getwd()
+			if err != nil {
+				t.Fatalf("Getwd(): %v", err)
+			}
+			if err := os.Chdir(tmpDir); err != nil {
+				t.Fatalf("Chdir(): %v", err)
+			}
+			defer os.Chdir(curDir)
+
+			expectedCommands := map[string]string{}
+			if tc.domInfo != "" {
+				expectedCommands[dumpTestLibvirt+"virsh dominfo "+dumpTestDomain] = tc.domInfo
+			}
+			if tc.dump != "" {
+				expectedCommands[dumpTestLibvirt+"virsh dump --live --memory-only --format elf "+dumpTestDomain+" "+dumpTestPath] = ""
+				expectedCommands[dumpTestLibvirt+"cat "+dumpTestPath] = tc.dump
+				expectedCommands[dumpTestLibvirt+"rm -f "+dumpTestPath] = ""
+			}
+			c := &fakeKubeClient{
+				t: t,
+				vmPods: map[string]VMPodInfo{
+					"cirros": {
+						NodeName:       "kube-node-1",
+						VirtletPodName: "virtlet-foo42",
+						ContainerID:    "cc349e91-dcf7-4f11-a077-36c3673c3fc4",
+						ContainerName:  "foocontainer",
+					},
+				},
+				expectedCommands: expectedCommands,
+			}
+			var out bytes.Buffer
+			cmd := NewDumpMemoryCmd(c, &out)
+			args := []string{}
+			if tc.args != "" {
+				args = strings.Split(tc.args, " ")
+			}
+			cmd.SetArgs(args)
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			switch err := cmd.Execute(); {
+			case err != nil && tc.errSubstring == "":
+				t.Errorf("dump-memory command returned an unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Errorf("Didn't get expected error (substring %q), output: %q", tc.errSubstring, out.String())
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("Didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			case err == nil && out.String() != tc.expectedOutput:
+				t.Errorf("Unexpected output from the command:\n%s\n-- instead of --\n%s", out.String(), tc.expectedOutput)
+			}
+			for c := range c.expectedCommands {
+				t.Errorf("command not executed: %q", c)
+			}
+
+			if tc.errSubstring != "" {
+				if tc.output != "" {
+					if _, err := os.Stat(tc.output); !os.IsNotExist(err) {
+						t.Errorf("the output file %q was not removed after an error", tc.output)
+					}
+				}
+				return
+			}
+			f, err := os.Open(filepath.Join(tmpDir, tc.output))
+			if err != nil {
+				t.Fatalf("Open(): %v", err)
+			}
+			defer f.Close()
+			var r io.Reader = f
+			if tc.compressed {
+				if r, err = gzip.NewReader(f); err != nil {
+					t.Fatalf("gzip.NewReader(): %v", err)
+				}
+			}
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("error reading the dump: %v", err)
+			}
+			if string(data) != tc.dump {
+				t.Errorf("bad dump contents: %q", data)
+			}
+		})
+	}
+}
+
+func TestKernelBannerDetector(t *testing.T) {
+	data := []byte(strings.Repeat("x", 50) + dumpTestBanner + "\x00" + strings.Repeat("y", 50))
+	// feed the data in small chunks so that the banner is split
+	// between them
+	for _, chunkSize := range []int{1, 3, 7, 16, 1000} {
+		d := &kernelBannerDetector{}
+		for p := 0; p < len(data); p += chunkSize {
+			end := p + chunkSize
+			if end > len(data) {
+				end = len(data)
+			}
+			if n, err := d.Write(data[p:end]); err != nil || n != end-p {
+				t.Fatalf("Write(): n=%d, err=%v", n, err)
+			}
+		}
+		if d.banner != dumpTestBanner {
+			t.Errorf("chunk size %d: bad banner %q", chunkSize, d.banner)
+		}
+	}
+}
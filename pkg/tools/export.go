@@ -0,0 +1,527 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	"github.com/renstrom/dedent"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// exportDir is the directory on the node where the disk
+	// snapshots are stored before they're transferred. It must
+	// be accessible from both libvirt and virtlet containers.
+	exportDir = "/var/lib/virtlet/export"
+	// exportManifestVersion is the version of the bundle
+	// manifest format
+	exportManifestVersion = 1
+	// qcow2FormatURI is the disk format identifier used in OVF
+	// descriptors for qcow2 images
+	qcow2FormatURI = "http://www.gnome.org/~markmc/qcow-image-format.html"
+)
+
+// exportManifest describes the contents of a VM bundle made by
+// the export command.
+type exportManifest struct {
+	// Version is the version of the manifest format
+	Version int `json:"version"`
+	// PodName is the name of the exported VM pod
+	PodName string `json:"podName"`
+	// PodNamespace is the namespace of the exported VM pod
+	PodNamespace string `json:"podNamespace"`
+	// DomainName is the name of the libvirt domain of the VM
+	DomainName string `json:"domainName"`
+	// ExportedAt is the time of the export
+	ExportedAt time.Time `json:"exportedAt"`
+	// Live is true if the disks were snapshotted while the VM
+	// was running
+	Live bool `json:"live"`
+	// Disks describes the disk images in the bundle
+	Disks []exportedDisk `json:"disks"`
+}
+
+// exportedDisk describes a disk image in a VM bundle.
+type exportedDisk struct {
+	// Target is the target device of the disk in the domain
+	// definition, e.g. sda
+	Target string `json:"target"`
+	// File is the name of the disk image in the bundle
+	File string `json:"file"`
+	// Format is the format of the disk image
+	Format string `json:"format"`
+	// Size is the size of the disk image file in bytes
+	Size int64 `json:"size"`
+	// VirtualSize is the size of the disk as seen by the VM in bytes
+	VirtualSize int64 `json:"virtualSize"`
+	// SHA256 is the checksum of the disk image file
+	SHA256 string `json:"sha256"`
+}
+
+// domainDisk denotes a disk of the domain that's being exported.
+type domainDisk struct {
+	target string
+	source string
+}
+
+// exportCommand contains the data needed by the export subcommand
+// which packages the disks and the definition of a VM pod into a
+// tar bundle.
+type exportCommand struct {
+	client     KubeClient
+	out        io.Writer
+	podName    string
+	outputPath string
+	ovf        bool
+	quiesce    bool
+	vmPodInfo  *VMPodInfo
+	// overlaysInUse is set if some of the overlays couldn't be
+	// merged back, so they're still used by the VM
+	overlaysInUse bool
+}
+
+// NewExportCmd returns a cobra.Command that exports a VM pod to
+// a tar bundle.
+func NewExportCmd(client KubeClient, out io.Writer) *cobra.Command {
+	e := &exportCommand{client: client, out: out}
+	cmd := &cobra.Command{
+		Use:   "export [flags] pod",
+		Short: "Export a VM pod to a portable bundle",
+		Long: dedent.Dedent(`
+                        This command packages the disks of a VM pod converted to
+                        standalone qcow2 images, the libvirt domain definition
+                        and the pod definition into a tar bundle along with
+                        manifest.json that describes the bundle, so the VM can
+                        be moved out of the cluster or archived. If the VM is
+                        running, the disks are snapshotted without stopping it.
+                        The snapshot is crash-consistent unless --quiesce is
+                        specified, which requires the QEMU guest agent to be
+                        running inside the VM. With --ovf, the bundle also
+                        includes an OVF descriptor, making it an OVA archive.
+                        The disk images are stored on the node temporarily and
+                        are removed after they're transferred.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("please specify the pod")
+			}
+			e.podName = args[0]
+			return e.Run()
+		},
+	}
+	cmd.Flags().StringVarP(&e.outputPath, "output", "o", "", "output file name (defaults to <pod>.tar, or <pod>.ova if --ovf is specified)")
+	cmd.Flags().BoolVar(&e.ovf, "ovf", false, "include an OVF descriptor in the bundle")
+	cmd.Flags().BoolVar(&e.quiesce, "quiesce", false, "freeze the guest filesystems using the guest agent while the disks are snapshotted")
+	return cmd
+}
+
+// Run executes the command.
+func (e *exportCommand) Run() error {
+	var err error
+	e.vmPodInfo, err = e.client.GetVMPodInfo(e.podName)
+	if err != nil {
+		return fmt.Errorf("can't get VM pod info for %q: %v", e.podName, err)
+	}
+	pod, err := e.client.GetPod(e.podName, e.vmPodInfo.Namespace)
+	if err != nil {
+		return fmt.Errorf("can't get the definition of pod %q: %v", e.podName, err)
+	}
+	podYaml, err := ToYaml([]runtime.Object{portablePod(pod)})
+	if err != nil {
+		return fmt.Errorf("error converting the pod definition to yaml: %v", err)
+	}
+
+	domainName := e.vmPodInfo.LibvirtDomainName()
+	var buf bytes.Buffer
+	if err := e.exec("libvirt", &buf, "virsh", "dumpxml", domainName); err != nil {
+		return err
+	}
+	domainXML := buf.String()
+	disks, err := e.listDisks(domainName)
+	if err != nil {
+		return err
+	}
+	if len(disks) == 0 {
+		return fmt.Errorf("VM pod %q has no disks that can be exported", e.podName)
+	}
+
+	buf.Reset()
+	if err := e.exec("libvirt", &buf, "virsh", "domstate", domainName); err != nil {
+		return err
+	}
+	live := strings.TrimSpace(buf.String()) != "shut off"
+
+	outputPath := e.outputPath
+	if outputPath == "" {
+		outputPath = e.podName + ".tar"
+		if e.ovf {
+			outputPath = e.podName + ".ova"
+		}
+	}
+
+	workDir := fmt.Sprintf("%s/%s", exportDir, domainName)
+	if err := e.exec("virtlet", nil, "mkdir", "-p", workDir); err != nil {
+		return err
+	}
+	defer func() {
+		if e.overlaysInUse {
+			fmt.Fprintf(os.Stderr, "Warning: not removing %s from the node as it contains the overlays used by the VM\n", workDir)
+			return
+		}
+		if err := e.exec("virtlet", nil, "rm", "-rf", workDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to remove the disk images from the node: %v\n", err)
+		}
+	}()
+	if err := e.snapshotDisks(domainName, workDir, disks, live); err != nil {
+		return err
+	}
+
+	manifest := exportManifest{
+		Version:      exportManifestVersion,
+		PodName:      e.podName,
+		PodNamespace: e.vmPodInfo.Namespace,
+		DomainName:   domainName,
+		ExportedAt:   time.Now().UTC(),
+		Live:         live,
+	}
+	for _, disk := range disks {
+		exported, err := e.describeImage(disk.target, workDir)
+		if err != nil {
+			return err
+		}
+		manifest.Disks = append(manifest.Disks, *exported)
+	}
+
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	err = e.writeBundle(f, &manifest, domainXML, podYaml, workDir)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+	fmt.Fprintf(e.out, "Exported VM pod %q to %s\n", e.podName, outputPath)
+	return nil
+}
+
+// portablePod returns a copy of the pod definition that can be
+// used to recreate the pod elsewhere.
+func portablePod(pod *v1.Pod) *v1.Pod {
+	r := &v1.Pod{
+		TypeMeta: meta_v1.TypeMeta{
+			Kind:       "Pod",
+			APIVersion: "v1",
+		},
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        pod.Name,
+			Namespace:   pod.Namespace,
+			Labels:      pod.Labels,
+			Annotations: pod.Annotations,
+		},
+		Spec: *pod.Spec.DeepCopy(),
+	}
+	r.Spec.NodeName = ""
+	return r
+}
+
+// listDisks returns the disks of the domain that can be exported.
+// CD-ROMs are skipped as they contain the cloud-init data that's
+// generated by Virtlet or the images that are available elsewhere.
+func (e *exportCommand) listDisks(domainName string) ([]domainDisk, error) {
+	var buf bytes.Buffer
+	if err := e.exec("libvirt", &buf, "virsh", "domblklist", "--details", domainName); err != nil {
+		return nil, err
+	}
+	var disks []domainDisk
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		// Type Device Target Source
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 || fields[1] != "disk" || fields[3] == "-" {
+			continue
+		}
+		if fields[0] != "file" && fields[0] != "block" {
+			fmt.Fprintf(os.Stderr, "Warning: skipping disk %s of type %s\n", fields[2], fields[0])
+			continue
+		}
+		disks = append(disks, domainDisk{target: fields[2], source: fields[3]})
+	}
+	return disks, nil
+}
+
+// snapshotDisks converts the disks of the domain to standalone
+// qcow2 images in workDir. If the VM is running, the disks are
+// redirected to temporary overlays while they're being converted
+// and the overlays are merged back afterwards.
+func (e *exportCommand) snapshotDisks(domainName, workDir string, disks []domainDisk, live bool) error {
+	if live {
+		args := []string{"virsh", "snapshot-create-as", domainName, "--name", domainName + "-export", "--disk-only", "--atomic", "--no-metadata"}
+		if e.quiesce {
+			args = append(args, "--quiesce")
+		}
+		for _, disk := range disks {
+			args = append(args, "--diskspec", fmt.Sprintf("%s,file=%s", disk.target, overlayPath(workDir, disk.target)))
+		}
+		if err := e.exec("libvirt", nil, args...); err != nil {
+			return fmt.Errorf("error snapshotting the disks: %v", err)
+		}
+		defer e.mergeOverlays(domainName, workDir, disks)
+	}
+	for _, disk := range disks {
+		if err := e.exec("virtlet", nil, "qemu-img", "convert", "-O", "qcow2", disk.source, imagePath(workDir, disk.target)); err != nil {
+			return fmt.Errorf("error converting disk %s: %v", disk.target, err)
+		}
+	}
+	return nil
+}
+
+// mergeOverlays merges the temporary overlays back into the disks
+// of the running VM.
+func (e *exportCommand) mergeOverlays(domainName, workDir string, disks []domainDisk) {
+	for _, disk := range disks {
+		if err := e.exec("libvirt", nil, "virsh", "blockcommit", domainName, disk.target, "--active", "--pivot"); err != nil {
+			e.overlaysInUse = true
+			fmt.Fprintf(os.Stderr, "Warning: failed to merge the overlay %s back into disk %s, please do it manually using virsh blockcommit: %v\n", overlayPath(workDir, disk.target), disk.target, err)
+		}
+	}
+}
+
+// describeImage returns the description of the image of the
+// specified disk in workDir. The checksum of the image is
+// calculated when it's transferred.
+func (e *exportCommand) describeImage(target, workDir string) (*exportedDisk, error) {
+	path := imagePath(workDir, target)
+	var buf bytes.Buffer
+	if err := e.exec("virtlet", &buf, "stat", "-c", "%s", path); err != nil {
+		return nil, err
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(buf.String()), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("can't get the size of the image of disk %s: %v", target, err)
+	}
+	buf.Reset()
+	if err := e.exec("virtlet", &buf, "qemu-img", "info", "--output=json", path); err != nil {
+		return nil, err
+	}
+	var info struct {
+		VirtualSize int64 `json:"virtual-size"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &info); err != nil {
+		return nil, fmt.Errorf("error parsing qemu-img info output for disk %s: %v", target, err)
+	}
+	return &exportedDisk{
+		Target:      target,
+		File:        exportedDiskFileName(target),
+		Format:      "qcow2",
+		Size:        size,
+		VirtualSize: info.VirtualSize,
+	}, nil
+}
+
+// writeBundle writes the tar bundle to w. The manifest is written
+// last as it includes the checksums of the disk images. If the OVF
+// descriptor is requested, it's written first, as required for OVA
+// archives.
+func (e *exportCommand) writeBundle(w io.Writer, manifest *exportManifest, domainXML string, podYaml []byte, workDir string) error {
+	bw := bufio.NewWriter(w)
+	tw := tar.NewWriter(bw)
+	if e.ovf {
+		descriptor, err := ovfDescriptor(manifest, domainXML)
+		if err != nil {
+			return err
+		}
+		if err := writeTarFile(tw, e.podName+".ovf", descriptor, manifest.ExportedAt); err != nil {
+			return err
+		}
+	}
+	if err := writeTarFile(tw, "domain.xml", []byte(domainXML), manifest.ExportedAt); err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, "pod.yaml", podYaml, manifest.ExportedAt); err != nil {
+		return err
+	}
+	for i := range manifest.Disks {
+		disk := &manifest.Disks[i]
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    disk.File,
+			Mode:    0644,
+			Size:    disk.Size,
+			ModTime: manifest.ExportedAt,
+		}); err != nil {
+			return err
+		}
+		h := sha256.New()
+		if err := e.exec("virtlet", io.MultiWriter(tw, h), "cat", imagePath(workDir, disk.Target)); err != nil {
+			return err
+		}
+		disk.SHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, "manifest.json", manifestData, manifest.ExportedAt); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func (e *exportCommand) exec(containerName string, stdout io.Writer, command ...string) error {
+	exitCode, err := e.client.ExecInContainer(e.vmPodInfo.VirtletPodName, containerName, "kube-system", nil, stdout, os.Stderr, command)
+	if err != nil {
+		return fmt.Errorf("error executing %s in Virtlet pod %q: %v", command[0], e.vmPodInfo.VirtletPodName, err)
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("%s returned non-zero exit code %d", command[0], exitCode)
+	}
+	return nil
+}
+
+func exportedDiskFileName(target string) string {
+	return fmt.Sprintf("disk-%s.qcow2", target)
+}
+
+func imagePath(workDir, target string) string {
+	return fmt.Sprintf("%s/%s", workDir, exportedDiskFileName(target))
+}
+
+func overlayPath(workDir, target string) string {
+	return fmt.Sprintf("%s/%s.overlay", workDir, target)
+}
+
+var ovfTemplate = template.Must(template.New("ovf").Funcs(template.FuncMap{
+	"xml": func(s string) (string, error) {
+		var buf bytes.Buffer
+		if err := xml.EscapeText(&buf, []byte(s)); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	},
+	"inc": func(n int) int { return n + 1 },
+}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="http://schemas.dmtf.org/ovf/envelope/1" xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1" xmlns:rasd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData">
+  <References>
+{{- range $i, $disk := .Disks }}
+    <File ovf:id="file{{ inc $i }}" ovf:href="{{ xml $disk.File }}" ovf:size="{{ $disk.Size }}"/>
+{{- end }}
+  </References>
+  <DiskSection>
+    <Info>Virtual disks</Info>
+{{- range $i, $disk := .Disks }}
+    <Disk ovf:diskId="disk{{ inc $i }}" ovf:fileRef="file{{ inc $i }}" ovf:capacity="{{ $disk.VirtualSize }}" ovf:format="{{ $.DiskFormat }}"/>
+{{- end }}
+  </DiskSection>
+  <VirtualSystem ovf:id="{{ xml .PodName }}">
+    <Info>VM exported from Kubernetes pod {{ xml .PodNamespace }}/{{ xml .PodName }}</Info>
+    <Name>{{ xml .PodName }}</Name>
+    <VirtualHardwareSection>
+      <Info>Virtual hardware requirements</Info>
+      <Item>
+        <rasd:ElementName>{{ .VCPUs }} virtual CPU(s)</rasd:ElementName>
+        <rasd:InstanceID>1</rasd:InstanceID>
+        <rasd:ResourceType>3</rasd:ResourceType>
+        <rasd:VirtualQuantity>{{ .VCPUs }}</rasd:VirtualQuantity>
+      </Item>
+      <Item>
+        <rasd:AllocationUnits>byte * 2^20</rasd:AllocationUnits>
+        <rasd:ElementName>{{ .MemoryMiB }} MB of memory</rasd:ElementName>
+        <rasd:InstanceID>2</rasd:InstanceID>
+        <rasd:ResourceType>4</rasd:ResourceType>
+        <rasd:VirtualQuantity>{{ .MemoryMiB }}</rasd:VirtualQuantity>
+      </Item>
+{{- range $i, $disk := .Disks }}
+      <Item>
+        <rasd:ElementName>{{ xml $disk.Target }}</rasd:ElementName>
+        <rasd:HostResource>ovf:/disk/disk{{ inc $i }}</rasd:HostResource>
+        <rasd:InstanceID>{{ inc (inc (inc $i)) }}</rasd:InstanceID>
+        <rasd:ResourceType>17</rasd:ResourceType>
+      </Item>
+{{- end }}
+    </VirtualHardwareSection>
+  </VirtualSystem>
+</Envelope>
+`))
+
+// ovfDescriptor returns an OVF 1.0 descriptor for the bundle. The
+// number of CPUs and the memory size are taken from the domain
+// definition.
+func ovfDescriptor(manifest *exportManifest, domainXML string) ([]byte, error) {
+	var def libvirtxml.Domain
+	if err := def.Unmarshal(domainXML); err != nil {
+		return nil, fmt.Errorf("error parsing the domain definition: %v", err)
+	}
+	vcpus := 1
+	if def.VCPU != nil && def.VCPU.Value > 0 {
+		vcpus = def.VCPU.Value
+	}
+	memory, err := domainMemoryBytes(&def)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := ovfTemplate.Execute(&buf, map[string]interface{}{
+		"PodName":      manifest.PodName,
+		"PodNamespace": manifest.PodNamespace,
+		"Disks":        manifest.Disks,
+		"DiskFormat":   qcow2FormatURI,
+		"VCPUs":        vcpus,
+		"MemoryMiB":    memory / (1024 * 1024),
+	}); err != nil {
+		return nil, fmt.Errorf("error generating the OVF descriptor: %v", err)
+	}
+	return buf.Bytes(), nil
+}
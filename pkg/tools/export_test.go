@@ -0,0 +1,258 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	exportTestDomain  = "virtlet-cc349e91-dcf7-foocontainer"
+	exportTestWorkDir = "/var/lib/virtlet/export/" + exportTestDomain
+	exportTestImage   = exportTestWorkDir + "/disk-sda.qcow2"
+	exportTestLibvirt = "virtlet-foo42/libvirt/kube-system: "
+	exportTestVirtlet = "virtlet-foo42/virtlet/kube-system: "
+	exportTestDisk    = "QFI\xfbdiskdata"
+	exportTestXML     = `<domain type="kvm">
+  <name>` + exportTestDomain + `</name>
+  <memory unit="KiB">1048576</memory>
+  <vcpu>2</vcpu>
+</domain>`
+	exportTestBlkList = "Type       Device     Target     Source\n" +
+		"------------------------------------------------\n" +
+		"file       disk       sda        /var/lib/virtlet/volumes/virtlet_root_cc349e91\n" +
+		"file       cdrom      sdb        /var/lib/virtlet/config/config-cc349e91.iso\n"
+)
+
+func TestExportCommand(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		args          string
+		output        string
+		state         string
+		snapshotFlags string
+		expectedFiles []string
+		ovfSubstrings []string
+		errSubstring  string
+	}{
+		{
+			name:          "running VM",
+			args:          "cirros",
+			output:        "cirros.tar",
+			state:         "running",
+			expectedFiles: []string{"domain.xml", "pod.yaml", "disk-sda.qcow2", "manifest.json"},
+		},
+		{
+			name:          "running VM with quiesce",
+			args:          "--quiesce -o vm.tar cirros",
+			output:        "vm.tar",
+			state:         "running",
+			snapshotFlags: " --quiesce",
+			expectedFiles: []string{"domain.xml", "pod.yaml", "disk-sda.qcow2", "manifest.json"},
+		},
+		{
+			name:          "stopped VM",
+			args:          "cirros",
+			output:        "cirros.tar",
+			state:         "shut off",
+			expectedFiles: []string{"domain.xml", "pod.yaml", "disk-sda.qcow2", "manifest.json"},
+		},
+		{
+			name:          "OVA",
+			args:          "--ovf cirros",
+			output:        "cirros.ova",
+			state:         "shut off",
+			expectedFiles: []string{"cirros.ovf", "domain.xml", "pod.yaml", "disk-sda.qcow2", "manifest.json"},
+			ovfSubstrings: []string{
+				`<File ovf:id="file1" ovf:href="disk-sda.qcow2" ovf:size="12"/>`,
+				`<Disk ovf:diskId="disk1" ovf:fileRef="file1" ovf:capacity="10737418240"`,
+				`<rasd:VirtualQuantity>2</rasd:VirtualQuantity>`,
+				`<rasd:VirtualQuantity>1024</rasd:VirtualQuantity>`,
+				`<rasd:HostResource>ovf:/disk/disk1</rasd:HostResource>`,
+			},
+		},
+		{
+			name:         "no pod",
+			args:         "",
+			errSubstring: "please specify the pod",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, err := ioutil.TempDir("", "export-")
+			if err != nil {
+				t.Fatalf("TempDir(): %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+			curDir, err := os.Getwd()
+			if err != nil {
+				t.Fatalf("Getwd(): %v", err)
+			}
+			if err := os.Chdir(tmpDir); err != nil {
+				t.Fatalf("Chdir(): %v", err)
+			}
+			defer os.Chdir(curDir)
+
+			expectedCommands := map[string]string{}
+			if tc.state != "" {
+				expectedCommands[exportTestLibvirt+"virsh dumpxml "+exportTestDomain] = exportTestXML
+				expectedCommands[exportTestLibvirt+"virsh domblklist --details "+exportTestDomain] = exportTestBlkList
+				expectedCommands[exportTestLibvirt+"virsh domstate "+exportTestDomain] = tc.state + "\n\n"
+				expectedCommands[exportTestVirtlet+"mkdir -p "+exportTestWorkDir] = ""
+				expectedCommands[exportTestVirtlet+"qemu-img convert -O qcow2 /var/lib/virtlet/volumes/virtlet_root_cc349e91 "+exportTestImage] = ""
+				expectedCommands[exportTestVirtlet+"stat -c %s "+exportTestImage] = "12\n"
+				expectedCommands[exportTestVirtlet+"qemu-img info --output=json "+exportTestImage] = `{"virtual-size": 10737418240, "format": "qcow2"}`
+				expectedCommands[exportTestVirtlet+"cat "+exportTestImage] = exportTestDisk
+				expectedCommands[exportTestVirtlet+"rm -rf "+exportTestWorkDir] = ""
+			}
+			if tc.state == "running" {
+				expectedCommands[exportTestLibvirt+"virsh snapshot-create-as "+exportTestDomain+" --name "+exportTestDomain+"-export --disk-only --atomic --no-metadata"+tc.snapshotFlags+" --diskspec sda,file="+exportTestWorkDir+"/sda.overlay"] = ""
+				expectedCommands[exportTestLibvirt+"virsh blockcommit "+exportTestDomain+" sda --active --pivot"] = ""
+			}
+			c := &fakeKubeClient{
+				t: t,
+				vmPods: map[string]VMPodInfo{
+					"cirros": {
+						Namespace:      "default",
+						NodeName:       "kube-node-1",
+						VirtletPodName: "virtlet-foo42",
+						ContainerID:    "cc349e91-dcf7-4f11-a077-36c3673c3fc4",
+						ContainerName:  "foocontainer",
+					},
+				},
+				pods: []v1.Pod{
+					{
+						ObjectMeta: meta_v1.ObjectMeta{
+							Name:        "cirros",
+							Namespace:   "default",
+							Annotations: map[string]string{"kubernetes.io/target-runtime": "virtlet.cloud"},
+						},
+						Spec: v1.PodSpec{
+							NodeName: "kube-node-1",
+							Containers: []v1.Container{
+								{Name: "foocontainer", Image: "virtlet.cloud/cirros"},
+							},
+						},
+					},
+				},
+				expectedCommands: expectedCommands,
+			}
+			var out bytes.Buffer
+			cmd := NewExportCmd(c, &out)
+			args := []string{}
+			if tc.args != "" {
+				args = strings.Split(tc.args, " ")
+			}
+			cmd.SetArgs(args)
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			switch err := cmd.Execute(); {
+			case err != nil && tc.errSubstring == "":
+				t.Fatalf("export command returned an unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Fatalf("Didn't get expected error (substring %q), output: %q", tc.errSubstring, out.String())
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Fatalf("Didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			}
+			for c := range c.expectedCommands {
+				t.Errorf("command not executed: %q", c)
+			}
+			if tc.errSubstring != "" {
+				return
+			}
+
+			f, err := os.Open(filepath.Join(tmpDir, tc.output))
+			if err != nil {
+				t.Fatalf("Open(): %v", err)
+			}
+			defer f.Close()
+			files := map[string]string{}
+			var names []string
+			tr := tar.NewReader(f)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("error reading the bundle: %v", err)
+				}
+				data, err := ioutil.ReadAll(tr)
+				if err != nil {
+					t.Fatalf("error reading %q from the bundle: %v", hdr.Name, err)
+				}
+				names = append(names, hdr.Name)
+				files[hdr.Name] = string(data)
+			}
+			if !reflect.DeepEqual(names, tc.expectedFiles) {
+				t.Errorf("bad files in the bundle: %v instead of %v", names, tc.expectedFiles)
+			}
+			if files["domain.xml"] != exportTestXML {
+				t.Errorf("bad domain definition in the bundle: %q", files["domain.xml"])
+			}
+			if files["disk-sda.qcow2"] != exportTestDisk {
+				t.Errorf("bad disk image in the bundle: %q", files["disk-sda.qcow2"])
+			}
+			if !strings.Contains(files["pod.yaml"], "virtlet.cloud/cirros") || strings.Contains(files["pod.yaml"], "kube-node-1") {
+				t.Errorf("bad pod definition in the bundle:\n%s", files["pod.yaml"])
+			}
+
+			var manifest exportManifest
+			if err := json.Unmarshal([]byte(files["manifest.json"]), &manifest); err != nil {
+				t.Fatalf("error unmarshalling the manifest: %v", err)
+			}
+			sum := sha256.Sum256([]byte(exportTestDisk))
+			expectedDisks := []exportedDisk{
+				{
+					Target:      "sda",
+					File:        "disk-sda.qcow2",
+					Format:      "qcow2",
+					Size:        12,
+					VirtualSize: 10737418240,
+					SHA256:      hex.EncodeToString(sum[:]),
+				},
+			}
+			if manifest.PodName != "cirros" || manifest.PodNamespace != "default" || manifest.DomainName != exportTestDomain {
+				t.Errorf("bad pod info in the manifest: %#v", manifest)
+			}
+			if manifest.Live != (tc.state == "running") {
+				t.Errorf("bad live flag in the manifest: %v", manifest.Live)
+			}
+			if !reflect.DeepEqual(manifest.Disks, expectedDisks) {
+				t.Errorf("bad disks in the manifest: %#v instead of %#v", manifest.Disks, expectedDisks)
+			}
+			for _, s := range tc.ovfSubstrings {
+				if !strings.Contains(files["cirros.ovf"], s) {
+					t.Errorf("Didn't find %q in the OVF descriptor:\n%s", s, files["cirros.ovf"])
+				}
+			}
+		})
+	}
+}
@@ -23,24 +23,30 @@ import (
 	"github.com/spf13/cobra"
 	apps "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 
 	"github.com/Mirantis/virtlet/pkg/config"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
 	"github.com/Mirantis/virtlet/pkg/version"
 )
 
 const (
-	sourceYamlFile = "deploy/data/virtlet-ds.yaml"
-	virtletImage   = "mirantis/virtlet"
+	sourceYamlFile   = "deploy/data/virtlet-ds.yaml"
+	virtletImage     = "mirantis/virtlet"
+	runtimeClassName = "virtlet"
 )
 
 // genCommand is used to generate Kubernetes YAML for Virtlet deployment
 type genCommand struct {
-	out    io.Writer
-	dev    bool
-	compat bool
-	crd    bool
-	tag    string
+	out          io.Writer
+	dev          bool
+	compat       bool
+	crd          bool
+	runtimeClass bool
+	imageServer  bool
+	tag          string
 }
 
 // NewGenCmd returns a cobra.Command that generates Kubernetes YAML for Virtlet
@@ -61,6 +67,8 @@ func NewGenCmd(out io.Writer) *cobra.Command {
 	cmd.Flags().BoolVar(&g.dev, "dev", false, "Development mode for use with kubeadm-dind-cluster")
 	cmd.Flags().BoolVar(&g.compat, "compat", false, "Produce YAML that's compatible with older Kubernetes versions")
 	cmd.Flags().BoolVar(&g.crd, "crd", false, "Dump CRD definitions only")
+	cmd.Flags().BoolVar(&g.runtimeClass, "runtime-class", false, "Add a RuntimeClass with the pod overhead of a VM to the YAML")
+	cmd.Flags().BoolVar(&g.imageServer, "image-server", false, "Add the Virtlet image server to the YAML")
 	cmd.Flags().StringVar(&g.tag, "tag", version.Get().ImageTag, "Set virtlet image tag")
 	return cmd
 }
@@ -93,6 +101,12 @@ func (g *genCommand) getYaml() ([]byte, error) {
 		if g.tag != "" {
 			applyTag(ds, g.tag)
 		}
+		if g.runtimeClass {
+			objs = append(objs, newRuntimeClass())
+		}
+		if g.imageServer {
+			objs = append(objs, newImageServerObjects(g.tag)...)
+		}
 	}
 
 	objs = append(objs, config.GetCRDDefinitions()...)
@@ -164,3 +178,27 @@ func applyTag(ds *apps.DaemonSet, tag string) {
 		}
 	})
 }
+
+// newRuntimeClass returns a RuntimeClass object with the pod overhead
+// set to the estimated overhead of a VM with the default settings.
+// The RuntimeClass API is newer than the Kubernetes client used by
+// Virtlet, so an unstructured object is used here.
+func newRuntimeClass() runtime.Object {
+	overhead := types.EstimateVMOverhead(1, types.DefaultVMMemory, 2, 1, false)
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "node.k8s.io/v1beta1",
+			"kind":       "RuntimeClass",
+			"metadata": map[string]interface{}{
+				"name": runtimeClassName,
+			},
+			"handler": runtimeClassName,
+			"overhead": map[string]interface{}{
+				"podFixed": map[string]interface{}{
+					"cpu":    resource.NewMilliQuantity(overhead.MilliCPU, resource.DecimalSI).String(),
+					"memory": resource.NewQuantity(overhead.MemoryBytes, resource.BinarySI).String(),
+				},
+			},
+		},
+	}
+}
@@ -49,6 +49,14 @@ func TestGenCommand(t *testing.T) {
 			name: "tag",
 			args: "--tag 0.9.42",
 		},
+		{
+			name: "runtime class",
+			args: "--runtime-class",
+		},
+		{
+			name: "image server",
+			args: "--image-server",
+		},
 		{
 			name: "crd",
 			args: "--crd",
@@ -0,0 +1,189 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/renstrom/dedent"
+	"github.com/spf13/cobra"
+)
+
+// imageCommand contains the data needed by the image save and
+// image load subcommands.
+type imageCommand struct {
+	client   KubeClient
+	in       io.Reader
+	out      io.Writer
+	nodeName string
+	path     string
+}
+
+// virtletPods returns the names of the Virtlet pods to use along
+// with the names of their nodes. If no node is specified, all of
+// the Virtlet pods are returned.
+func (c *imageCommand) virtletPods() ([]string, []string, error) {
+	if c.nodeName != "" {
+		podName, err := c.client.GetVirtletPodNameForNode(c.nodeName)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []string{podName}, []string{c.nodeName}, nil
+	}
+	podNames, nodeNames, err := c.client.GetVirtletPodAndNodeNames()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(podNames) == 0 {
+		return nil, nil, errors.New("no Virtlet pods found")
+	}
+	return podNames, nodeNames, nil
+}
+
+func (c *imageCommand) exec(podName, nodeName string, stdin io.Reader, stdout io.Writer, command ...string) error {
+	cmdStr := strings.Join(command[:2], " ")
+	exitCode, err := c.client.ExecInContainer(podName, "virtlet", "kube-system", stdin, stdout, os.Stderr, command)
+	if err != nil {
+		return fmt.Errorf("error executing %s in Virtlet pod %q on node %q: %v", cmdStr, podName, nodeName, err)
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("%s failed on node %q with exit code %d", cmdStr, nodeName, exitCode)
+	}
+	return nil
+}
+
+// NewImageSaveCmd returns a cobra.Command that saves the images
+// cached by Virtlet on a node to a tar archive.
+func NewImageSaveCmd(client KubeClient, out io.Writer) *cobra.Command {
+	c := &imageCommand{client: client, out: out}
+	cmd := &cobra.Command{
+		Use:   "save [flags] [image...]",
+		Short: "Save the cached VM images to a tar archive",
+		Long: dedent.Dedent(`
+                        This command saves the VM images cached by Virtlet on
+                        a node to a tar archive along with their digests. If
+                        no images are specified, all of the cached images are
+                        saved. The node may be omitted if there's only one
+                        Virtlet node in the cluster.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			podNames, nodeNames, err := c.virtletPods()
+			switch {
+			case err != nil:
+				return err
+			case len(podNames) > 1:
+				return errors.New("there are several Virtlet nodes in the cluster, please specify the node using --node")
+			}
+			return withOutputFile(c.path, c.out, func(w io.Writer) error {
+				command := append([]string{"virtlet", "--image-save"}, args...)
+				return c.exec(podNames[0], nodeNames[0], nil, w, command...)
+			})
+		},
+	}
+	cmd.Flags().StringVar(&c.nodeName, "node", "", "The node to save the images from")
+	cmd.Flags().StringVarP(&c.path, "output", "o", "-", "The file to write the archive to, '-' for stdout")
+	return cmd
+}
+
+// NewImageLoadCmd returns a cobra.Command that loads the images from
+// a tar archive into the Virtlet image store on the nodes.
+func NewImageLoadCmd(client KubeClient, in io.Reader, out io.Writer) *cobra.Command {
+	c := &imageCommand{client: client, in: in, out: out}
+	cmd := &cobra.Command{
+		Use:   "load [flags]",
+		Short: "Load VM images from a tar archive",
+		Long: dedent.Dedent(`
+                        This command loads the VM images from a tar archive
+                        produced by 'image save' into the Virtlet image store
+                        on a node, or on all of the Virtlet nodes if the node
+                        isn't specified. The digests of the images are
+                        verified. After the images are loaded, the VM pods
+                        can use them without downloading.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return errors.New("this command does not accept arguments")
+			}
+			podNames, nodeNames, err := c.virtletPods()
+			switch {
+			case err != nil:
+				return err
+			case len(podNames) > 1 && c.path == "-":
+				return errors.New("please specify the archive file using --input or the node using --node")
+			}
+			for n, podName := range podNames {
+				err := withInputFile(c.path, c.in, func(r io.Reader) error {
+					return c.exec(podName, nodeNames[n], r, c.out, "virtlet", "--image-load")
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&c.nodeName, "node", "", "The node to load the images to")
+	cmd.Flags().StringVarP(&c.path, "input", "i", "-", "The file to read the archive from, '-' for stdin")
+	return cmd
+}
+
+// NewImageCmd returns a cobra.Command that handles the VM images
+// cached by Virtlet.
+func NewImageCmd(client KubeClient, in io.Reader, out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "image",
+		Short: "Manage the VM images cached on the nodes",
+		Long: dedent.Dedent(`
+                        Save the VM images cached by Virtlet to a tar archive
+                        and load them on other nodes, e.g. in air-gapped
+                        environments which can't reach the image servers,
+                        and manage the images on the Virtlet image server.`),
+	}
+	cmd.AddCommand(NewImageSaveCmd(client, out))
+	cmd.AddCommand(NewImageLoadCmd(client, in, out))
+	cmd.AddCommand(NewImageServerCmd(client, out))
+	return cmd
+}
+
+func withInputFile(path string, in io.Reader, toCall func(r io.Reader) error) error {
+	if path == "-" {
+		return toCall(in)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return toCall(f)
+}
+
+func withOutputFile(path string, out io.Writer, toCall func(w io.Writer) error) error {
+	if path == "-" {
+		return toCall(out)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := toCall(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/image"
+)
+
+const (
+	imageTestNode1 = "virtlet-foo42/virtlet/kube-system: "
+	imageTestNode2 = "virtlet-bar42/virtlet/kube-system: "
+)
+
+func TestImageCommand(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "virtletctl-image")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	archivePath := filepath.Join(tmpDir, "images.tar")
+	if err := ioutil.WriteFile(archivePath, []byte("archive"), 0644); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+
+	for _, tc := range []struct {
+		name             string
+		args             string
+		stdin            string
+		virtletPods      map[string]string
+		expectedCommands map[string]string
+		expectedStdins   map[string]string
+		expectedOutput   string
+		errSubstring     string
+	}{
+		{
+			name:        "save all images from the only node",
+			args:        "save",
+			virtletPods: map[string]string{"kube-node-1": "virtlet-foo42"},
+			expectedCommands: map[string]string{
+				imageTestNode1 + "virtlet --image-save": "archive",
+			},
+			expectedOutput: "archive",
+		},
+		{
+			name: "save selected images from the specified node",
+			args: "save --node kube-node-2 cirros ubuntu/16.04",
+			virtletPods: map[string]string{
+				"kube-node-1": "virtlet-foo42",
+				"kube-node-2": "virtlet-bar42",
+			},
+			expectedCommands: map[string]string{
+				imageTestNode2 + "virtlet --image-save cirros ubuntu/16.04": "archive",
+			},
+			expectedOutput: "archive",
+		},
+		{
+			name: "save without the node",
+			args: "save",
+			virtletPods: map[string]string{
+				"kube-node-1": "virtlet-foo42",
+				"kube-node-2": "virtlet-bar42",
+			},
+			errSubstring: "please specify the node",
+		},
+		{
+			name:  "load from stdin to the specified node",
+			args:  "load --node kube-node-1",
+			stdin: "archive",
+			virtletPods: map[string]string{
+				"kube-node-1": "virtlet-foo42",
+				"kube-node-2": "virtlet-bar42",
+			},
+			expectedCommands: map[string]string{
+				imageTestNode1 + "virtlet --image-load": "Loaded image: cirros@sha256:0123\n",
+			},
+			expectedStdins: map[string]string{
+				imageTestNode1 + "virtlet --image-load": "archive",
+			},
+			expectedOutput: "Loaded image: cirros@sha256:0123\n",
+		},
+		{
+			name: "load from a file to all of the nodes",
+			args: "load -i " + archivePath,
+			virtletPods: map[string]string{
+				"kube-node-1": "virtlet-foo42",
+				"kube-node-2": "virtlet-bar42",
+			},
+			expectedCommands: map[string]string{
+				imageTestNode1 + "virtlet --image-load": "Loaded image: cirros@sha256:0123\n",
+				imageTestNode2 + "virtlet --image-load": "Loaded image: cirros@sha256:0123\n",
+			},
+			expectedStdins: map[string]string{
+				imageTestNode1 + "virtlet --image-load": "archive",
+				imageTestNode2 + "virtlet --image-load": "archive",
+			},
+			expectedOutput: "Loaded image: cirros@sha256:0123\nLoaded image: cirros@sha256:0123\n",
+		},
+		{
+			name:  "load from stdin to all of the nodes",
+			args:  "load",
+			stdin: "archive",
+			virtletPods: map[string]string{
+				"kube-node-1": "virtlet-foo42",
+				"kube-node-2": "virtlet-bar42",
+			},
+			errSubstring: "please specify the archive file",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &fakeKubeClient{
+				t:                t,
+				virtletPods:      tc.virtletPods,
+				expectedCommands: tc.expectedCommands,
+				stdins:           make(map[string]string),
+			}
+			var out bytes.Buffer
+			cmd := NewImageCmd(c, strings.NewReader(tc.stdin), &out)
+			cmd.SetArgs(strings.Split(tc.args, " "))
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			switch err := cmd.Execute(); {
+			case err != nil && tc.errSubstring == "":
+				t.Errorf("image command returned an unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Errorf("Didn't get expected error (substring %q), output: %q", tc.errSubstring, out.String())
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("Didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			case err == nil && out.String() != tc.expectedOutput:
+				t.Errorf("Unexpected output from the command: %q instead of %q", out.String(), tc.expectedOutput)
+			}
+			for c := range tc.expectedCommands {
+				t.Errorf("command not executed: %q", c)
+			}
+			expectedStdins := tc.expectedStdins
+			if expectedStdins == nil {
+				expectedStdins = map[string]string{}
+			}
+			if !reflect.DeepEqual(c.stdins, expectedStdins) {
+				t.Errorf("bad stdin data: %#v instead of %#v", c.stdins, expectedStdins)
+			}
+		})
+	}
+}
+
+func TestImageServerCommand(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "virtletctl-image-server")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	imagePath := filepath.Join(tmpDir, "cirros.img")
+	if err := ioutil.WriteFile(imagePath, []byte("cirros"), 0644); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+	var archive bytes.Buffer
+	if _, err := image.WriteFileArchive(&archive, "cirros", imagePath); err != nil {
+		t.Fatalf("WriteFileArchive(): %v", err)
+	}
+
+	const imageServerPod = "virtlet-image-server-foo42/image-server/kube-system: virtlet --image-dir=/var/lib/virtlet-image-server "
+	for _, tc := range []struct {
+		name             string
+		args             string
+		imageServerPod   string
+		expectedCommands map[string]string
+		expectedStdins   map[string]string
+		expectedOutput   string
+		errSubstring     string
+	}{
+		{
+			name:           "push",
+			args:           "push cirros " + imagePath,
+			imageServerPod: "virtlet-image-server-foo42",
+			expectedCommands: map[string]string{
+				imageServerPod + "--image-load": "Loaded image: cirros@sha256:0123\n",
+			},
+			expectedStdins: map[string]string{
+				imageServerPod + "--image-load": archive.String(),
+			},
+			expectedOutput: "Loaded image: cirros@sha256:0123\n" +
+				"Image URL: http://virtlet-image-server.kube-system.svc:8080/images/cirros\n",
+		},
+		{
+			name:           "push nonexistent file",
+			args:           "push cirros " + filepath.Join(tmpDir, "nonexistent.img"),
+			imageServerPod: "virtlet-image-server-foo42",
+			errSubstring:   "no such file",
+		},
+		{
+			name:         "push without image server",
+			args:         "push cirros " + imagePath,
+			errSubstring: "no running Virtlet image server pods found",
+		},
+		{
+			name:           "list",
+			args:           "list",
+			imageServerPod: "virtlet-image-server-foo42",
+			expectedCommands: map[string]string{
+				imageServerPod + "--image-list": `[{"name":"cirros","digest":"sha256:0123","size":6,"path":"/images/cirros"}]`,
+			},
+			expectedOutput: "cirros@sha256:0123 6 http://virtlet-image-server.kube-system.svc:8080/images/cirros\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &fakeKubeClient{
+				t:                t,
+				imageServerPod:   tc.imageServerPod,
+				expectedCommands: tc.expectedCommands,
+				stdins:           make(map[string]string),
+			}
+			var out bytes.Buffer
+			cmd := NewImageServerCmd(c, &out)
+			cmd.SetArgs(strings.Split(tc.args, " "))
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			switch err := cmd.Execute(); {
+			case err != nil && tc.errSubstring == "":
+				t.Errorf("image server command returned an unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Errorf("Didn't get expected error (substring %q), output: %q", tc.errSubstring, out.String())
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("Didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			case err == nil && out.String() != tc.expectedOutput:
+				t.Errorf("Unexpected output from the command: %q instead of %q", out.String(), tc.expectedOutput)
+			}
+			for c := range tc.expectedCommands {
+				t.Errorf("command not executed: %q", c)
+			}
+			expectedStdins := tc.expectedStdins
+			if expectedStdins == nil {
+				expectedStdins = map[string]string{}
+			}
+			if !reflect.DeepEqual(c.stdins, expectedStdins) {
+				t.Errorf("bad stdin data: %#v instead of %#v", c.stdins, expectedStdins)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/renstrom/dedent"
+	"github.com/spf13/cobra"
+	apps "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/Mirantis/virtlet/pkg/image"
+	"github.com/Mirantis/virtlet/pkg/imageserver"
+)
+
+const (
+	imageServerName      = "virtlet-image-server"
+	imageServerLabel     = "app"
+	imageServerContainer = "image-server"
+	imageServerDir       = "/var/lib/virtlet-image-server"
+	imageServerPort      = 8080
+)
+
+// imageServerURL returns the URL of the image with the specified
+// name on the image server that's reachable from the Virtlet pods.
+func imageServerURL(name string) string {
+	return fmt.Sprintf("http://%s.kube-system.svc:%d%s%s", imageServerName, imageServerPort, imageserver.ImagePathPrefix, name)
+}
+
+// imageServerCommand contains the data needed by the image server
+// subcommands.
+type imageServerCommand struct {
+	client KubeClient
+	out    io.Writer
+}
+
+func (c *imageServerCommand) exec(stdin io.Reader, stdout io.Writer, args ...string) error {
+	podName, err := c.client.GetImageServerPodName()
+	if err != nil {
+		return err
+	}
+	command := append([]string{"virtlet", "--image-dir=" + imageServerDir}, args...)
+	exitCode, err := c.client.ExecInContainer(podName, imageServerContainer, "kube-system", stdin, stdout, os.Stderr, command)
+	if err != nil {
+		return fmt.Errorf("error executing virtlet %s in the image server pod %q: %v", strings.Join(args, " "), podName, err)
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("virtlet %s failed in the image server pod %q with exit code %d", strings.Join(args, " "), podName, exitCode)
+	}
+	return nil
+}
+
+// NewImageServerPushCmd returns a cobra.Command that uploads an
+// image file to the Virtlet image server.
+func NewImageServerPushCmd(client KubeClient, out io.Writer) *cobra.Command {
+	c := &imageServerCommand{client: client, out: out}
+	return &cobra.Command{
+		Use:   "push name file",
+		Short: "Upload a VM image to the image server",
+		Long: dedent.Dedent(`
+                        This command uploads a QCOW2 image file to the Virtlet
+                        image server under the specified name. The digest of
+                        the image is calculated and verified by the server.
+                        If the name is already used by another image, it's
+                        replaced. After the upload, the command prints the URL
+                        of the image that can be used in the image translation
+                        configs.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return errors.New("please specify the image name and the image file")
+			}
+			return c.push(args[0], args[1])
+		},
+	}
+}
+
+func (c *imageServerCommand) push(name, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := image.WriteFileArchive(pw, name, path)
+		pw.CloseWithError(err)
+	}()
+	// the pipe must be closed if the command fails
+	// before reading all of the archive
+	defer pr.Close()
+	if err := c.exec(pr, c.out, "--image-load"); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(c.out, "Image URL: %s\n", imageServerURL(name))
+	return err
+}
+
+// NewImageServerListCmd returns a cobra.Command that lists the
+// images on the Virtlet image server.
+func NewImageServerListCmd(client KubeClient, out io.Writer) *cobra.Command {
+	c := &imageServerCommand{client: client, out: out}
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the VM images on the image server",
+		Long: dedent.Dedent(`
+                        This command lists the VM images on the Virtlet image
+                        server along with their digests, sizes and URLs.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return errors.New("this command does not accept arguments")
+			}
+			return c.list()
+		},
+	}
+}
+
+func (c *imageServerCommand) list() error {
+	var buf bytes.Buffer
+	if err := c.exec(nil, &buf, "--image-list"); err != nil {
+		return err
+	}
+	var images []imageserver.ImageInfo
+	if err := json.Unmarshal(buf.Bytes(), &images); err != nil {
+		return fmt.Errorf("error unmarshalling the image list: %v", err)
+	}
+	for _, img := range images {
+		if _, err := fmt.Fprintf(c.out, "%s@%s %d %s\n", img.Name, img.Digest, img.Size, imageServerURL(img.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewImageServerCmd returns a cobra.Command that manages the
+// images on the Virtlet image server.
+func NewImageServerCmd(client KubeClient, out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "server",
+		Short: "Manage the VM images on the Virtlet image server",
+		Long: dedent.Dedent(`
+                        Upload and list the VM images on the optional Virtlet
+                        image server, which can be deployed using
+                        'virtletctl gen --image-server'.`),
+	}
+	cmd.AddCommand(NewImageServerPushCmd(client, out))
+	cmd.AddCommand(NewImageServerListCmd(client, out))
+	return cmd
+}
+
+// newImageServerObjects returns the Deployment and the Service
+// for the Virtlet image server. The images are kept in a host
+// directory, so the pod should be pinned to a particular node
+// using a nodeSelector if there's more than one node in the
+// cluster.
+func newImageServerObjects(tag string) []runtime.Object {
+	labels := map[string]string{imageServerLabel: imageServerName}
+	objectMeta := meta_v1.ObjectMeta{
+		Name:      imageServerName,
+		Namespace: "kube-system",
+		Labels:    labels,
+	}
+	img := virtletImage
+	if tag != "" {
+		img += ":" + tag
+	}
+	replicas := int32(1)
+	hostPathType := v1.HostPathDirectoryOrCreate
+	return []runtime.Object{
+		&apps.Deployment{
+			TypeMeta: meta_v1.TypeMeta{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+			},
+			ObjectMeta: objectMeta,
+			Spec: apps.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &meta_v1.LabelSelector{MatchLabels: labels},
+				// the image directory can't be shared
+				// between the pods
+				Strategy: apps.DeploymentStrategy{Type: apps.RecreateDeploymentStrategyType},
+				Template: v1.PodTemplateSpec{
+					ObjectMeta: meta_v1.ObjectMeta{Labels: labels},
+					Spec: v1.PodSpec{
+						Containers: []v1.Container{
+							{
+								Name:            imageServerContainer,
+								Image:           img,
+								ImagePullPolicy: v1.PullIfNotPresent,
+								Command: []string{
+									"virtlet",
+									"--image-server",
+									"--image-dir=" + imageServerDir,
+									fmt.Sprintf("--image-server-listen=:%d", imageServerPort),
+								},
+								Ports: []v1.ContainerPort{
+									{
+										Name:          "http",
+										ContainerPort: imageServerPort,
+									},
+								},
+								ReadinessProbe: &v1.Probe{
+									Handler: v1.Handler{
+										HTTPGet: &v1.HTTPGetAction{
+											Path: imageserver.ImagePathPrefix,
+											Port: intstr.FromInt(imageServerPort),
+										},
+									},
+								},
+								VolumeMounts: []v1.VolumeMount{
+									{
+										Name:      "images",
+										MountPath: imageServerDir,
+									},
+								},
+							},
+						},
+						Volumes: []v1.Volume{
+							{
+								Name: "images",
+								VolumeSource: v1.VolumeSource{
+									HostPath: &v1.HostPathVolumeSource{
+										Path: imageServerDir,
+										Type: &hostPathType,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		&v1.Service{
+			TypeMeta: meta_v1.TypeMeta{
+				APIVersion: "v1",
+				Kind:       "Service",
+			},
+			ObjectMeta: objectMeta,
+			Spec: v1.ServiceSpec{
+				Selector: labels,
+				Ports: []v1.ServicePort{
+					{
+						Name:       "http",
+						Port:       imageServerPort,
+						TargetPort: intstr.FromInt(imageServerPort),
+					},
+				},
+			},
+		},
+	}
+}
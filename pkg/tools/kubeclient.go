@@ -18,6 +18,7 @@ package tools
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -31,6 +32,7 @@ import (
 
 	v1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp" // GKE support
@@ -49,6 +51,10 @@ const (
 // VMPodInfo describes a VM pod in a way that's necessary for virtletctl to
 // handle it
 type VMPodInfo struct {
+	// Namespace is the namespace of the VM pod
+	Namespace string
+	// UID is the UID of the VM pod
+	UID string
 	// NodeName is the name of the node where the VM pod runs
 	NodeName string
 	// VirtletPodName is the name of the virtlet pod that manages this VM pod
@@ -132,13 +138,20 @@ type KubeClient interface {
 	// GetVirtletPodNameForNode returns a name of the virtlet pod on
 	// the specified k8s node.
 	GetVirtletPodNameForNode(nodeName string) (string, error)
+	// GetImageServerPodName returns the name of a running
+	// Virtlet image server pod.
+	GetImageServerPodName() (string, error)
 	// GetVMPodInfo returns then name of the virtlet pod and the vm container name for
 	// the specified VM pod.
 	GetVMPodInfo(podName string) (*VMPodInfo, error)
-	// CreatePod creates a pod.
+	// CreatePod creates a pod. If the namespace of the pod is
+	// empty, the pod is created in the current namespace.
 	CreatePod(pod *v1.Pod) (*v1.Pod, error)
 	// GetPod retrieves a pod definition from the apiserver.
 	GetPod(name, namespace string) (*v1.Pod, error)
+	// ListPods returns the pods in the current namespace that
+	// match the specified label selector.
+	ListPods(labelSelector string) ([]v1.Pod, error)
 	// DeletePod removes the specified pod from the specified namespace.
 	DeletePod(pod, namespace string) error
 	// ExecInContainer given a pod, a container, a namespace and a command
@@ -157,6 +170,9 @@ type KubeClient interface {
 	// Retrieves the logs for the specified pod. If tailLines is
 	// non-zero, it limits the numer of lines to be retrieved.
 	PodLogs(podName, containerName, namespace string, tailLines int64) ([]byte, error)
+	// PatchNodeAnnotations sets the annotations of the specified
+	// node. The annotations with nil values are removed.
+	PatchNodeAnnotations(nodeName string, annotations map[string]*string) error
 }
 
 type remoteExecutor interface {
@@ -327,6 +343,25 @@ func (c *RealKubeClient) GetVirtletPodNameForNode(nodeName string) (string, erro
 	return virtletPodNames[0], nil
 }
 
+// GetImageServerPodName implements GetImageServerPodName method of KubeClient interface.
+func (c *RealKubeClient) GetImageServerPodName() (string, error) {
+	if err := c.setup(); err != nil {
+		return "", err
+	}
+	pods, err := c.client.CoreV1().Pods("kube-system").List(meta_v1.ListOptions{
+		LabelSelector: imageServerLabel + "=" + imageServerName,
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, item := range pods.Items {
+		if item.Status.Phase == v1.PodRunning {
+			return item.Name, nil
+		}
+	}
+	return "", errors.New("no running Virtlet image server pods found")
+}
+
 // GetVMPodInfo implements GetVMPodInfo method of KubeClient interface.
 func (c *RealKubeClient) GetVMPodInfo(podName string) (*VMPodInfo, error) {
 	pod, err := c.getVMPod(podName)
@@ -350,6 +385,8 @@ func (c *RealKubeClient) GetVMPodInfo(podName string) (*VMPodInfo, error) {
 	}
 
 	return &VMPodInfo{
+		Namespace:      pod.Namespace,
+		UID:            string(pod.UID),
 		NodeName:       pod.Spec.NodeName,
 		VirtletPodName: virtletPodName,
 		ContainerID:    pod.Status.ContainerStatuses[0].ContainerID,
@@ -362,7 +399,11 @@ func (c *RealKubeClient) CreatePod(pod *v1.Pod) (*v1.Pod, error) {
 	if err := c.setup(); err != nil {
 		return nil, err
 	}
-	return c.client.CoreV1().Pods(pod.Namespace).Create(pod)
+	namespace := pod.Namespace
+	if namespace == "" {
+		namespace = c.namespace
+	}
+	return c.client.CoreV1().Pods(namespace).Create(pod)
 }
 
 // GetPod implements GetPod method of KubeClient interface.
@@ -370,6 +411,20 @@ func (c *RealKubeClient) GetPod(name, namespace string) (*v1.Pod, error) {
 	return c.client.CoreV1().Pods(namespace).Get(name, meta_v1.GetOptions{})
 }
 
+// ListPods implements ListPods method of KubeClient interface.
+func (c *RealKubeClient) ListPods(labelSelector string) ([]v1.Pod, error) {
+	if err := c.setup(); err != nil {
+		return nil, err
+	}
+	pods, err := c.client.CoreV1().Pods(c.namespace).List(meta_v1.ListOptions{
+		LabelSelector: labelSelector,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
 // DeletePod implements DeletePod method of KubeClient interface.
 func (c *RealKubeClient) DeletePod(name, namespace string) error {
 	return c.client.CoreV1().Pods(namespace).Delete(name, &meta_v1.DeleteOptions{})
@@ -490,3 +545,22 @@ func (c *RealKubeClient) PodLogs(podName, containerName, namespace string, tailL
 	}
 	return c.client.CoreV1().Pods(namespace).GetLogs(podName, opts).Do().Raw()
 }
+
+// PatchNodeAnnotations implements PatchNodeAnnotations method of KubeClient interface.
+func (c *RealKubeClient) PatchNodeAnnotations(nodeName string, annotations map[string]*string) error {
+	if err := c.setup(); err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error marshalling the node patch: %v", err)
+	}
+	if _, err := c.client.CoreV1().Nodes().Patch(nodeName, types.MergePatchType, patch); err != nil {
+		return fmt.Errorf("error updating the annotations of node %q: %v", nodeName, err)
+	}
+	return nil
+}
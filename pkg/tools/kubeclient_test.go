@@ -227,6 +227,7 @@ func TestGetVMPodInfo(t *testing.T) {
 			ObjectMeta: meta_v1.ObjectMeta{
 				Name:      "cirros-vm",
 				Namespace: "default",
+				UID:       "4a1cfbb4-8e4e-11e8-9a4d-0242ac110002",
 				Annotations: map[string]string{
 					"kubernetes.io/target-runtime": "virtlet.cloud",
 				},
@@ -297,6 +298,8 @@ func TestGetVMPodInfo(t *testing.T) {
 	}
 
 	expectedVMPodInfo := &VMPodInfo{
+		Namespace:      "default",
+		UID:            "4a1cfbb4-8e4e-11e8-9a4d-0242ac110002",
 		NodeName:       "kube-node-1",
 		VirtletPodName: "virtlet-g9wtz",
 		ContainerID:    sampleContainerID,
@@ -0,0 +1,346 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/renstrom/dedent"
+	"github.com/spf13/cobra"
+
+	"github.com/Mirantis/virtlet/pkg/metadata"
+)
+
+// metadataCommand contains the data needed by the metadata backup
+// and metadata restore subcommands.
+type metadataCommand struct {
+	client   KubeClient
+	in       io.Reader
+	out      io.Writer
+	nodeName string
+	path     string
+	format   string
+	repair   bool
+	readOnly bool
+}
+
+// virtletPod returns the name of the Virtlet pod to use along with
+// the name of its node. The node may only be omitted if there's
+// just one Virtlet node in the cluster.
+func (c *metadataCommand) virtletPod() (string, string, error) {
+	if c.nodeName != "" {
+		podName, err := c.client.GetVirtletPodNameForNode(c.nodeName)
+		if err != nil {
+			return "", "", err
+		}
+		return podName, c.nodeName, nil
+	}
+	podNames, nodeNames, err := c.client.GetVirtletPodAndNodeNames()
+	switch {
+	case err != nil:
+		return "", "", err
+	case len(podNames) == 0:
+		return "", "", errors.New("no Virtlet pods found")
+	case len(podNames) > 1:
+		return "", "", errors.New("there are several Virtlet nodes in the cluster, please specify the node using --node")
+	}
+	return podNames[0], nodeNames[0], nil
+}
+
+func (c *metadataCommand) exec(stdin io.Reader, stdout io.Writer, flags ...string) error {
+	podName, nodeName, err := c.virtletPod()
+	if err != nil {
+		return err
+	}
+	flagStr := strings.Join(flags, " ")
+	exitCode, err := c.client.ExecInContainer(podName, "virtlet", "kube-system", stdin, stdout, os.Stderr, append([]string{"virtlet"}, flags...))
+	if err != nil {
+		return fmt.Errorf("error executing virtlet %s in Virtlet pod %q on node %q: %v", flagStr, podName, nodeName, err)
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("virtlet %s failed on node %q with exit code %d", flagStr, nodeName, exitCode)
+	}
+	return nil
+}
+
+// readOnlyFlags returns the flags of virtlet command that make it
+// access the metadata read-only if requested.
+func (c *metadataCommand) readOnlyFlags(flag string) []string {
+	if c.readOnly {
+		return []string{flag, "--metadata-read-only"}
+	}
+	return []string{flag}
+}
+
+// NewMetadataBackupCmd returns a cobra.Command that takes a snapshot
+// of the Virtlet metadata database on a node.
+func NewMetadataBackupCmd(client KubeClient, out io.Writer) *cobra.Command {
+	c := &metadataCommand{client: client, out: out}
+	cmd := &cobra.Command{
+		Use:   "backup [flags]",
+		Short: "Take a snapshot of the metadata database",
+		Long: dedent.Dedent(`
+                        This command takes a consistent snapshot of the Virtlet
+                        metadata database on a node while Virtlet is running,
+                        without interrupting the VMs. The snapshot is validated
+                        before it's written. The node may be omitted if there's
+                        only one Virtlet node in the cluster. Only the bolt
+                        metadata backend is supported.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return errors.New("this command does not accept arguments")
+			}
+			return withOutputFile(c.path, c.out, func(w io.Writer) error {
+				return c.exec(nil, w, "--metadata-backup")
+			})
+		},
+	}
+	cmd.Flags().StringVar(&c.nodeName, "node", "", "The node to take the snapshot on")
+	cmd.Flags().StringVarP(&c.path, "output", "o", "-", "The file to write the snapshot to, '-' for stdout")
+	return cmd
+}
+
+// NewMetadataRestoreCmd returns a cobra.Command that restores the
+// Virtlet metadata database on a node from a snapshot.
+func NewMetadataRestoreCmd(client KubeClient, in io.Reader, out io.Writer) *cobra.Command {
+	c := &metadataCommand{client: client, in: in, out: out}
+	cmd := &cobra.Command{
+		Use:   "restore [flags]",
+		Short: "Restore the metadata database from a snapshot",
+		Long: dedent.Dedent(`
+                        This command uploads a snapshot produced by 'metadata
+                        backup' to a node. The snapshot is validated and then
+                        staged to replace the metadata database when the
+                        virtlet container is restarted next time, as the
+                        database can't be replaced while Virtlet is using it.
+                        The replaced database is kept next to the database
+                        file with '.pre-restore' suffix. The node may be
+                        omitted if there's only one Virtlet node in the
+                        cluster.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return errors.New("this command does not accept arguments")
+			}
+			return withInputFile(c.path, c.in, func(r io.Reader) error {
+				return c.exec(r, c.out, "--metadata-restore")
+			})
+		},
+	}
+	cmd.Flags().StringVar(&c.nodeName, "node", "", "The node to restore the database on")
+	cmd.Flags().StringVarP(&c.path, "input", "i", "-", "The file to read the snapshot from, '-' for stdin")
+	return cmd
+}
+
+// NewDumpMetadataCmd returns a cobra.Command that exports the pod
+// sandboxes and the containers from the Virtlet metadata on a node.
+func NewDumpMetadataCmd(client KubeClient, out io.Writer) *cobra.Command {
+	c := &metadataCommand{client: client, out: out}
+	cmd := &cobra.Command{
+		Use:   "dump-metadata [flags]",
+		Short: "Export the pod sandboxes and the containers from the metadata",
+		Long: dedent.Dedent(`
+                        This command exports the pod sandboxes and the containers
+                        from the Virtlet metadata on a node as JSON or YAML, e.g.
+                        for a bug report or for seeding the test fixtures. The
+                        export can be loaded into the metadata on another node
+                        using 'load-metadata'. The node may be omitted if
+                        there's only one Virtlet node in the cluster. With
+                        --read-only, the metadata database is opened read-only
+                        by the command itself instead of the running Virtlet
+                        process, so the metadata can be exported even if
+                        Virtlet isn't running on the node. Only the bolt
+                        metadata backend is supported.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return errors.New("this command does not accept arguments")
+			}
+			if c.format != "json" && c.format != "yaml" {
+				return fmt.Errorf("bad format %q, must be either 'json' or 'yaml'", c.format)
+			}
+			var buf bytes.Buffer
+			if err := c.exec(nil, &buf, c.readOnlyFlags("--metadata-export")...); err != nil {
+				return err
+			}
+			data := buf.Bytes()
+			if c.format == "yaml" {
+				var err error
+				if data, err = yaml.JSONToYAML(data); err != nil {
+					return fmt.Errorf("error converting the metadata to YAML: %v", err)
+				}
+			}
+			return withOutputFile(c.path, c.out, func(w io.Writer) error {
+				_, err := w.Write(data)
+				return err
+			})
+		},
+	}
+	cmd.Flags().StringVar(&c.nodeName, "node", "", "The node to export the metadata from")
+	cmd.Flags().StringVar(&c.format, "format", "json", "The output format, 'json' or 'yaml'")
+	cmd.Flags().StringVarP(&c.path, "output", "o", "-", "The file to write the metadata to, '-' for stdout")
+	cmd.Flags().BoolVar(&c.readOnly, "read-only", false, "Open the metadata database read-only instead of asking the running Virtlet process to export the metadata")
+	return cmd
+}
+
+// NewLoadMetadataCmd returns a cobra.Command that imports the pod
+// sandboxes and the containers into the Virtlet metadata on a node.
+func NewLoadMetadataCmd(client KubeClient, in io.Reader, out io.Writer) *cobra.Command {
+	c := &metadataCommand{client: client, in: in, out: out}
+	cmd := &cobra.Command{
+		Use:   "load-metadata [flags]",
+		Short: "Import the pod sandboxes and the containers into the metadata",
+		Long: dedent.Dedent(`
+                        This command adds the pod sandboxes and the containers
+                        exported by 'dump-metadata' in either JSON or YAML
+                        format to the Virtlet metadata on a node. Nothing is
+                        imported if any of the pod sandboxes or the containers
+                        already exist on the node. The node may be omitted if
+                        there's only one Virtlet node in the cluster. Only the
+                        bolt metadata backend is supported.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return errors.New("this command does not accept arguments")
+			}
+			return withInputFile(c.path, c.in, func(r io.Reader) error {
+				data, err := ioutil.ReadAll(r)
+				if err != nil {
+					return err
+				}
+				// YAMLToJSON also accepts JSON as it's a subset of YAML
+				if data, err = yaml.YAMLToJSON(data); err != nil {
+					return fmt.Errorf("error parsing the metadata: %v", err)
+				}
+				return c.exec(bytes.NewReader(data), c.out, "--metadata-import")
+			})
+		},
+	}
+	cmd.Flags().StringVar(&c.nodeName, "node", "", "The node to import the metadata on")
+	cmd.Flags().StringVarP(&c.path, "input", "i", "-", "The file to read the metadata from, '-' for stdin")
+	return cmd
+}
+
+// NewCheckMetadataCmd returns a cobra.Command that checks the
+// consistency of the Virtlet metadata on a node.
+func NewCheckMetadataCmd(client KubeClient, out io.Writer) *cobra.Command {
+	c := &metadataCommand{client: client, out: out}
+	cmd := &cobra.Command{
+		Use:   "check-metadata [flags]",
+		Short: "Check the consistency of the metadata",
+		Long: dedent.Dedent(`
+                        This command checks the pod sandbox and container
+                        records in the Virtlet metadata on a node for the
+                        records that can't be parsed, the containers that
+                        refer to the nonexistent pod sandboxes, the records
+                        with duplicate ids and the containers that use the
+                        images that are not in the image store. The report
+                        is written as JSON. With --repair, the broken
+                        records are fixed or removed where possible. The
+                        command fails if there are any problems left
+                        unrepaired. With --read-only, the metadata database is
+                        opened read-only by the command itself instead of the
+                        running Virtlet process, and the images are not
+                        checked. The node may be omitted if there's only
+                        one Virtlet node in the cluster. Only the bolt
+                        metadata backend is supported.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return errors.New("this command does not accept arguments")
+			}
+			if c.repair && c.readOnly {
+				return errors.New("--repair can't be used together with --read-only")
+			}
+			flag := "--metadata-check"
+			if c.repair {
+				flag = "--metadata-repair"
+			}
+			var buf bytes.Buffer
+			if err := c.exec(nil, &buf, c.readOnlyFlags(flag)...); err != nil {
+				return err
+			}
+			var report metadata.CheckReport
+			if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+				return fmt.Errorf("error parsing the metadata check report: %v", err)
+			}
+			data, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(c.out, "%s\n", data); err != nil {
+				return err
+			}
+			if n := report.Unrepaired(); n != 0 {
+				return fmt.Errorf("%d unrepaired metadata problem(s) found", n)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&c.nodeName, "node", "", "The node to check the metadata on")
+	cmd.Flags().BoolVar(&c.repair, "repair", false, "Repair the problems found where possible")
+	cmd.Flags().BoolVar(&c.readOnly, "read-only", false, "Open the metadata database read-only instead of asking the running Virtlet process to check the metadata")
+	return cmd
+}
+
+// NewCompactMetadataCmd returns a cobra.Command that requests the
+// compaction of the Virtlet metadata database on a node.
+func NewCompactMetadataCmd(client KubeClient, out io.Writer) *cobra.Command {
+	c := &metadataCommand{client: client, out: out}
+	cmd := &cobra.Command{
+		Use:   "compact-metadata [flags]",
+		Short: "Compact the metadata database",
+		Long: dedent.Dedent(`
+                        This command makes the Virtlet metadata database on a
+                        node be compacted when the virtlet container is
+                        restarted next time, as the database can't be replaced
+                        while Virtlet is using it. The live records are copied
+                        into a fresh database file which then replaces the
+                        original one, so the space taken by the removed
+                        records is returned to the filesystem. The node may
+                        be omitted if there's only one Virtlet node in the
+                        cluster. Only the bolt metadata backend is supported.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return errors.New("this command does not accept arguments")
+			}
+			return c.exec(nil, c.out, "--metadata-compact")
+		},
+	}
+	cmd.Flags().StringVar(&c.nodeName, "node", "", "The node to compact the metadata database on")
+	return cmd
+}
+
+// NewMetadataCmd returns a cobra.Command that handles the Virtlet
+// metadata database.
+func NewMetadataCmd(client KubeClient, in io.Reader, out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "metadata",
+		Short: "Back up and restore the metadata database",
+		Long: dedent.Dedent(`
+                        Take the snapshots of the Virtlet metadata database
+                        on the nodes and restore the database from them, e.g.
+                        as a part of the node backup procedures.`),
+	}
+	cmd.AddCommand(NewMetadataBackupCmd(client, out))
+	cmd.AddCommand(NewMetadataRestoreCmd(client, in, out))
+	return cmd
+}
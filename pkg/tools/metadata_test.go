@@ -0,0 +1,373 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestMetadataCommand(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "virtletctl-metadata")
+	if err != nil {
+		t.Fatalf("TempDir(): %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	snapshotPath := filepath.Join(tmpDir, "virtlet.db")
+	if err := ioutil.WriteFile(snapshotPath, []byte("snapshot"), 0644); err != nil {
+		t.Fatalf("WriteFile(): %v", err)
+	}
+
+	const restoredMsg = "The snapshot is valid and will replace the metadata database upon Virtlet restart\n"
+	for _, tc := range []struct {
+		name             string
+		args             string
+		stdin            string
+		virtletPods      map[string]string
+		expectedCommands map[string]string
+		expectedStdins   map[string]string
+		expectedOutput   string
+		errSubstring     string
+	}{
+		{
+			name:        "backup on the only node",
+			args:        "backup",
+			virtletPods: map[string]string{"kube-node-1": "virtlet-foo42"},
+			expectedCommands: map[string]string{
+				imageTestNode1 + "virtlet --metadata-backup": "snapshot",
+			},
+			expectedOutput: "snapshot",
+		},
+		{
+			name: "backup on the specified node",
+			args: "backup --node kube-node-2",
+			virtletPods: map[string]string{
+				"kube-node-1": "virtlet-foo42",
+				"kube-node-2": "virtlet-bar42",
+			},
+			expectedCommands: map[string]string{
+				imageTestNode2 + "virtlet --metadata-backup": "snapshot",
+			},
+			expectedOutput: "snapshot",
+		},
+		{
+			name: "backup without the node",
+			args: "backup",
+			virtletPods: map[string]string{
+				"kube-node-1": "virtlet-foo42",
+				"kube-node-2": "virtlet-bar42",
+			},
+			errSubstring: "please specify the node",
+		},
+		{
+			name: "restore from a file",
+			args: "restore --node kube-node-1 -i " + snapshotPath,
+			virtletPods: map[string]string{
+				"kube-node-1": "virtlet-foo42",
+				"kube-node-2": "virtlet-bar42",
+			},
+			expectedCommands: map[string]string{
+				imageTestNode1 + "virtlet --metadata-restore": restoredMsg,
+			},
+			expectedStdins: map[string]string{
+				imageTestNode1 + "virtlet --metadata-restore": "snapshot",
+			},
+			expectedOutput: restoredMsg,
+		},
+		{
+			name:        "restore from stdin",
+			args:        "restore",
+			stdin:       "snapshot",
+			virtletPods: map[string]string{"kube-node-1": "virtlet-foo42"},
+			expectedCommands: map[string]string{
+				imageTestNode1 + "virtlet --metadata-restore": restoredMsg,
+			},
+			expectedStdins: map[string]string{
+				imageTestNode1 + "virtlet --metadata-restore": "snapshot",
+			},
+			expectedOutput: restoredMsg,
+		},
+		{
+			name:  "restore without the node",
+			args:  "restore",
+			stdin: "snapshot",
+			virtletPods: map[string]string{
+				"kube-node-1": "virtlet-foo42",
+				"kube-node-2": "virtlet-bar42",
+			},
+			errSubstring: "please specify the node",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &fakeKubeClient{
+				t:                t,
+				virtletPods:      tc.virtletPods,
+				expectedCommands: tc.expectedCommands,
+				stdins:           make(map[string]string),
+			}
+			var out bytes.Buffer
+			cmd := NewMetadataCmd(c, strings.NewReader(tc.stdin), &out)
+			cmd.SetArgs(strings.Split(tc.args, " "))
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			switch err := cmd.Execute(); {
+			case err != nil && tc.errSubstring == "":
+				t.Errorf("metadata command returned an unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Errorf("Didn't get expected error (substring %q), output: %q", tc.errSubstring, out.String())
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("Didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			case err == nil && out.String() != tc.expectedOutput:
+				t.Errorf("Unexpected output from the command: %q instead of %q", out.String(), tc.expectedOutput)
+			}
+			for c := range tc.expectedCommands {
+				t.Errorf("command not executed: %q", c)
+			}
+			expectedStdins := tc.expectedStdins
+			if expectedStdins == nil {
+				expectedStdins = map[string]string{}
+			}
+			if !reflect.DeepEqual(c.stdins, expectedStdins) {
+				t.Errorf("bad stdin data: %#v instead of %#v", c.stdins, expectedStdins)
+			}
+		})
+	}
+}
+
+func TestDumpLoadMetadataCommands(t *testing.T) {
+	const (
+		exportedJSON  = "{\n  \"version\": 1,\n  \"sandboxes\": [],\n  \"containers\": []\n}\n"
+		exportedYAML  = "containers: []\nsandboxes: []\nversion: 1\n"
+		normalized    = `{"containers":[],"sandboxes":[],"version":1}`
+		importedMsg   = "The metadata has been imported\n"
+		exportCommand = imageTestNode1 + "virtlet --metadata-export"
+		importCommand = imageTestNode1 + "virtlet --metadata-import"
+	)
+	for _, tc := range []struct {
+		name             string
+		args             string
+		stdin            string
+		expectedCommands map[string]string
+		expectedStdins   map[string]string
+		expectedOutput   string
+		errSubstring     string
+	}{
+		{
+			name:             "dump as json",
+			args:             "dump-metadata",
+			expectedCommands: map[string]string{exportCommand: exportedJSON},
+			expectedOutput:   exportedJSON,
+		},
+		{
+			name:             "dump as yaml",
+			args:             "dump-metadata --format yaml",
+			expectedCommands: map[string]string{exportCommand: exportedJSON},
+			expectedOutput:   exportedYAML,
+		},
+		{
+			name: "read-only dump",
+			args: "dump-metadata --read-only",
+			expectedCommands: map[string]string{
+				exportCommand + " --metadata-read-only": exportedJSON,
+			},
+			expectedOutput: exportedJSON,
+		},
+		{
+			name:         "dump with bad format",
+			args:         "dump-metadata --format xml",
+			errSubstring: "bad format",
+		},
+		{
+			name:             "load json",
+			args:             "load-metadata",
+			stdin:            exportedJSON,
+			expectedCommands: map[string]string{importCommand: importedMsg},
+			expectedStdins:   map[string]string{importCommand: normalized},
+			expectedOutput:   importedMsg,
+		},
+		{
+			name:             "load yaml",
+			args:             "load-metadata",
+			stdin:            exportedYAML,
+			expectedCommands: map[string]string{importCommand: importedMsg},
+			expectedStdins:   map[string]string{importCommand: normalized},
+			expectedOutput:   importedMsg,
+		},
+		{
+			name:         "load bad data",
+			args:         "load-metadata",
+			stdin:        "{",
+			errSubstring: "error parsing the metadata",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &fakeKubeClient{
+				t:                t,
+				virtletPods:      map[string]string{"kube-node-1": "virtlet-foo42"},
+				expectedCommands: tc.expectedCommands,
+				stdins:           make(map[string]string),
+			}
+			var out bytes.Buffer
+			cmd := &cobra.Command{Use: "virtletctl"}
+			cmd.AddCommand(NewDumpMetadataCmd(c, &out))
+			cmd.AddCommand(NewLoadMetadataCmd(c, strings.NewReader(tc.stdin), &out))
+			cmd.SetArgs(strings.Split(tc.args, " "))
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			switch err := cmd.Execute(); {
+			case err != nil && tc.errSubstring == "":
+				t.Errorf("command returned an unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Errorf("Didn't get expected error (substring %q), output: %q", tc.errSubstring, out.String())
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("Didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			case err == nil && out.String() != tc.expectedOutput:
+				t.Errorf("Unexpected output from the command: %q instead of %q", out.String(), tc.expectedOutput)
+			}
+			for c := range tc.expectedCommands {
+				t.Errorf("command not executed: %q", c)
+			}
+			expectedStdins := tc.expectedStdins
+			if expectedStdins == nil {
+				expectedStdins = map[string]string{}
+			}
+			if !reflect.DeepEqual(c.stdins, expectedStdins) {
+				t.Errorf("bad stdin data: %#v instead of %#v", c.stdins, expectedStdins)
+			}
+		})
+	}
+}
+
+func TestCheckMetadataCommand(t *testing.T) {
+	const (
+		checkCommand  = imageTestNode1 + "virtlet --metadata-check"
+		repairCommand = imageTestNode1 + "virtlet --metadata-repair"
+		cleanReport   = `{"sandboxes":1,"containers":1,"problems":[]}`
+		badReport     = `{"sandboxes":1,"containers":0,"problems":[{"kind":"orphan-container","id":"c1","message":"orphan","repaired":false}]}`
+		repairReport  = `{"sandboxes":1,"containers":0,"problems":[{"kind":"orphan-container","id":"c1","message":"orphan","repaired":true}]}`
+	)
+	for _, tc := range []struct {
+		name             string
+		args             string
+		expectedCommands map[string]string
+		outputSubstring  string
+		errSubstring     string
+	}{
+		{
+			name:             "no problems",
+			args:             "check-metadata",
+			expectedCommands: map[string]string{checkCommand: cleanReport},
+			outputSubstring:  "\"problems\": []",
+		},
+		{
+			name:             "problems found",
+			args:             "check-metadata --node kube-node-1",
+			expectedCommands: map[string]string{checkCommand: badReport},
+			outputSubstring:  "\"kind\": \"orphan-container\"",
+			errSubstring:     "1 unrepaired metadata problem(s) found",
+		},
+		{
+			name:             "repair",
+			args:             "check-metadata --repair",
+			expectedCommands: map[string]string{repairCommand: repairReport},
+			outputSubstring:  "\"repaired\": true",
+		},
+		{
+			name: "read-only check",
+			args: "check-metadata --read-only",
+			expectedCommands: map[string]string{
+				checkCommand + " --metadata-read-only": cleanReport,
+			},
+			outputSubstring: "\"problems\": []",
+		},
+		{
+			name:         "read-only repair",
+			args:         "check-metadata --repair --read-only",
+			errSubstring: "--repair can't be used together with --read-only",
+		},
+		{
+			name:             "bad report",
+			args:             "check-metadata",
+			expectedCommands: map[string]string{checkCommand: "{"},
+			errSubstring:     "error parsing the metadata check report",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &fakeKubeClient{
+				t:                t,
+				virtletPods:      map[string]string{"kube-node-1": "virtlet-foo42"},
+				expectedCommands: tc.expectedCommands,
+				stdins:           make(map[string]string),
+			}
+			var out bytes.Buffer
+			cmd := &cobra.Command{Use: "virtletctl"}
+			cmd.AddCommand(NewCheckMetadataCmd(c, &out))
+			cmd.SetArgs(strings.Split(tc.args, " "))
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			switch err := cmd.Execute(); {
+			case err != nil && tc.errSubstring == "":
+				t.Errorf("command returned an unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Errorf("Didn't get expected error (substring %q), output: %q", tc.errSubstring, out.String())
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("Didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			}
+			if !strings.Contains(out.String(), tc.outputSubstring) {
+				t.Errorf("Didn't get expected substring %q in the output: %q", tc.outputSubstring, out.String())
+			}
+			for c := range tc.expectedCommands {
+				t.Errorf("command not executed: %q", c)
+			}
+		})
+	}
+}
+
+func TestCompactMetadataCommand(t *testing.T) {
+	const (
+		compactCommand = imageTestNode1 + "virtlet --metadata-compact"
+		compactMsg     = "The metadata database will be compacted upon Virtlet restart\n"
+	)
+	c := &fakeKubeClient{
+		t:                t,
+		virtletPods:      map[string]string{"kube-node-1": "virtlet-foo42"},
+		expectedCommands: map[string]string{compactCommand: compactMsg},
+		stdins:           make(map[string]string),
+	}
+	var out bytes.Buffer
+	cmd := &cobra.Command{Use: "virtletctl"}
+	cmd.AddCommand(NewCompactMetadataCmd(c, &out))
+	cmd.SetArgs([]string{"compact-metadata", "--node", "kube-node-1"})
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = true
+	if err := cmd.Execute(); err != nil {
+		t.Errorf("command returned an unexpected error: %v", err)
+	}
+	if out.String() != compactMsg {
+		t.Errorf("bad output: %q", out.String())
+	}
+	for c := range c.expectedCommands {
+		t.Errorf("command not executed: %q", c)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/renstrom/dedent"
+	"github.com/spf13/cobra"
+
+	"github.com/Mirantis/virtlet/pkg/diag"
+)
+
+const networkInfoDiagSource = "network-info"
+
+// podNetworkInfo mirrors tapmanager.PodNetworkInfo. It's not used
+// directly to avoid pulling Linux-specific networking code into
+// virtletctl.
+type podNetworkInfo struct {
+	Leases []struct {
+		HardwareAddr string    `json:"mac"`
+		IP           string    `json:"ip"`
+		State        string    `json:"state"`
+		Discovers    int       `json:"discovers"`
+		Requests     int       `json:"requests"`
+		Updated      time.Time `json:"updated"`
+		Error        string    `json:"error"`
+	} `json:"leases"`
+	Neighbors []struct {
+		Link  string `json:"link"`
+		IP    string `json:"ip"`
+		Mac   string `json:"mac"`
+		State string `json:"state"`
+	} `json:"neighbors"`
+	Routes []struct {
+		Link string `json:"link"`
+		Dst  string `json:"dst"`
+		Gw   string `json:"gw"`
+		Src  string `json:"src"`
+	} `json:"routes"`
+}
+
+type networkInfoCommand struct {
+	client KubeClient
+	out    io.Writer
+}
+
+// NewNetworkInfoCmd returns a cobra.Command that displays the DHCP
+// lease state, the neighbor table and the routes of a VM pod's
+// network namespace.
+func NewNetworkInfoCmd(client KubeClient, out io.Writer) *cobra.Command {
+	c := &networkInfoCommand{client: client, out: out}
+	return &cobra.Command{
+		Use:   "network-info pod",
+		Short: "Display DHCP leases, neighbors and routes of a VM pod",
+		Long: dedent.Dedent(`
+                        This command displays the state of the DHCP exchange
+                        between Virtlet and the VM, the ARP/NDP neighbor table
+                        entries and the routes of the pod network namespace,
+                        as retrieved by Virtlet on the node.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("please specify the pod")
+			}
+			return c.run(args[0])
+		},
+	}
+}
+
+func (c *networkInfoCommand) run(podName string) error {
+	vmPodInfo, err := c.client.GetVMPodInfo(podName)
+	if err != nil {
+		return fmt.Errorf("can't get VM pod info for %q: %v", podName, err)
+	}
+
+	var buf bytes.Buffer
+	exitCode, err := c.client.ExecInContainer(
+		vmPodInfo.VirtletPodName, "virtlet", "kube-system", nil,
+		&buf, os.Stderr, []string{"virtlet", "--diag"})
+	switch {
+	case err != nil:
+		return fmt.Errorf("error getting diagnostics from Virtlet pod %q: %v", vmPodInfo.VirtletPodName, err)
+	case exitCode != 0:
+		return fmt.Errorf("error getting diagnostics from Virtlet pod %q: exit code %d", vmPodInfo.VirtletPodName, exitCode)
+	}
+	dr, err := diag.DecodeDiagnostics(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	src, found := dr.Children[networkInfoDiagSource]
+	switch {
+	case !found:
+		return fmt.Errorf("Virtlet on node %q doesn't provide the network info", vmPodInfo.NodeName)
+	case src.Error != "":
+		return fmt.Errorf("error retrieving the network info: %s", src.Error)
+	}
+	podInfo, found := src.Children[vmPodInfo.Namespace+"-"+podName]
+	if !found {
+		return fmt.Errorf("no network info found for pod %q", podName)
+	}
+	var info podNetworkInfo
+	if err := json.Unmarshal([]byte(podInfo.Data), &info); err != nil {
+		return fmt.Errorf("error unmarshalling the network info: %v", err)
+	}
+
+	fmt.Fprintln(c.out, "DHCP leases:")
+	if len(info.Leases) == 0 {
+		fmt.Fprintln(c.out, "  <no DHCP requests from the VM>")
+	}
+	for _, l := range info.Leases {
+		ip := l.IP
+		if ip == "" {
+			ip = "-"
+		}
+		fmt.Fprintf(c.out, "  %s %s %s (discovers: %d, requests: %d, updated: %s)\n",
+			l.HardwareAddr, ip, l.State, l.Discovers, l.Requests, l.Updated.UTC().Format(time.RFC3339))
+		if l.Error != "" {
+			fmt.Fprintf(c.out, "    error: %s\n", l.Error)
+		}
+	}
+
+	fmt.Fprintln(c.out, "Neighbors:")
+	for _, n := range info.Neighbors {
+		fmt.Fprintf(c.out, "  %s dev %s", n.IP, n.Link)
+		if n.Mac != "" {
+			fmt.Fprintf(c.out, " lladdr %s", n.Mac)
+		}
+		fmt.Fprintf(c.out, " %s\n", n.State)
+	}
+
+	fmt.Fprintln(c.out, "Routes:")
+	for _, r := range info.Routes {
+		fmt.Fprintf(c.out, "  %s", r.Dst)
+		if r.Gw != "" {
+			fmt.Fprintf(c.out, " via %s", r.Gw)
+		}
+		if r.Link != "" {
+			fmt.Fprintf(c.out, " dev %s", r.Link)
+		}
+		if r.Src != "" {
+			fmt.Fprintf(c.out, " src %s", r.Src)
+		}
+		fmt.Fprintln(c.out)
+	}
+	return nil
+}
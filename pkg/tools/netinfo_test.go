@@ -0,0 +1,165 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/diag"
+)
+
+const sampleNetworkInfo = `{
+  "leases": [
+    {
+      "mac": "42:a4:a6:22:80:2e",
+      "ip": "10.1.90.5",
+      "state": "acked",
+      "discovers": 1,
+      "requests": 2,
+      "updated": "2018-07-09T19:25:00Z"
+    },
+    {
+      "mac": "42:a4:a6:22:80:2f",
+      "state": "failed",
+      "discovers": 3,
+      "requests": 0,
+      "updated": "2018-07-09T19:26:00Z",
+      "error": "IPv4 config for interface 42:a4:a6:22:80:2f is not specified in CNI config"
+    }
+  ],
+  "neighbors": [
+    {"link": "br0", "ip": "10.1.90.5", "mac": "42:a4:a6:22:80:2e", "state": "REACHABLE"},
+    {"link": "br0", "ip": "10.1.90.7", "state": "FAILED"}
+  ],
+  "routes": [
+    {"link": "br0", "dst": "169.254.0.0/16"},
+    {"dst": "default", "gw": "10.1.90.1", "link": "br0", "src": "10.1.90.2"}
+  ]
+}`
+
+func networkInfoDiagOutput(podInfo map[string]string) string {
+	children := make(map[string]diag.Result)
+	for name, data := range podInfo {
+		children[name] = diag.Result{
+			Name: name,
+			Ext:  "json",
+			Data: data,
+		}
+	}
+	dr := diag.Result{
+		Name:  "diagnostics",
+		IsDir: true,
+		Children: map[string]diag.Result{
+			"network-info": {
+				Name:     "network-info",
+				IsDir:    true,
+				Children: children,
+			},
+		},
+	}
+	return string(dr.ToJSON())
+}
+
+func TestNetworkInfoCommand(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		args           string
+		podInfo        map[string]string
+		expectedOutput string
+		errSubstring   string
+	}{
+		{
+			name:    "network info",
+			args:    "cirros-vm",
+			podInfo: map[string]string{"default-cirros-vm": sampleNetworkInfo},
+			expectedOutput: "DHCP leases:\n" +
+				"  42:a4:a6:22:80:2e 10.1.90.5 acked (discovers: 1, requests: 2, updated: 2018-07-09T19:25:00Z)\n" +
+				"  42:a4:a6:22:80:2f - failed (discovers: 3, requests: 0, updated: 2018-07-09T19:26:00Z)\n" +
+				"    error: IPv4 config for interface 42:a4:a6:22:80:2f is not specified in CNI config\n" +
+				"Neighbors:\n" +
+				"  10.1.90.5 dev br0 lladdr 42:a4:a6:22:80:2e REACHABLE\n" +
+				"  10.1.90.7 dev br0 FAILED\n" +
+				"Routes:\n" +
+				"  169.254.0.0/16 dev br0\n" +
+				"  default via 10.1.90.1 dev br0 src 10.1.90.2\n",
+		},
+		{
+			name:    "no DHCP requests",
+			args:    "cirros-vm",
+			podInfo: map[string]string{"default-cirros-vm": `{"leases":[],"neighbors":[],"routes":[]}`},
+			expectedOutput: "DHCP leases:\n" +
+				"  <no DHCP requests from the VM>\n" +
+				"Neighbors:\n" +
+				"Routes:\n",
+		},
+		{
+			name:         "no info for the pod",
+			args:         "cirros-vm",
+			podInfo:      map[string]string{"default-another-vm": sampleNetworkInfo},
+			errSubstring: "no network info found",
+		},
+		{
+			name:         "no pod",
+			args:         "",
+			errSubstring: "please specify the pod",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			expectedCommands := map[string]string{}
+			if tc.args != "" {
+				expectedCommands["virtlet-foo42/virtlet/kube-system: virtlet --diag"] = networkInfoDiagOutput(tc.podInfo)
+			}
+			c := &fakeKubeClient{
+				t: t,
+				vmPods: map[string]VMPodInfo{
+					"cirros-vm": {
+						Namespace:      "default",
+						NodeName:       "kube-node-1",
+						VirtletPodName: "virtlet-foo42",
+						ContainerID:    "a1b2c3",
+						ContainerName:  "cirros-vm",
+					},
+				},
+				expectedCommands: expectedCommands,
+			}
+			var out bytes.Buffer
+			cmd := NewNetworkInfoCmd(c, &out)
+			args := []string{}
+			if tc.args != "" {
+				args = strings.Split(tc.args, " ")
+			}
+			cmd.SetArgs(args)
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			switch err := cmd.Execute(); {
+			case err != nil && tc.errSubstring == "":
+				t.Errorf("network-info command returned an unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Errorf("Didn't get expected error (substring %q), output: %q", tc.errSubstring, out.String())
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("Didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			case err == nil && out.String() != tc.expectedOutput:
+				t.Errorf("Unexpected output from the command:\n%s\n-- instead of --\n%s", out.String(), tc.expectedOutput)
+			}
+			for c := range c.expectedCommands {
+				t.Errorf("command not executed: %q", c)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/renstrom/dedent"
+	"github.com/spf13/cobra"
+
+	"github.com/Mirantis/virtlet/pkg/config"
+)
+
+const defaultCordonReason = "maintenance"
+
+type nodeCordonCommand struct {
+	client KubeClient
+	out    io.Writer
+	reason string
+}
+
+// NewCordonVMsCmd returns a cobra.Command that makes Virtlet reject
+// new VM pods on a node.
+func NewCordonVMsCmd(client KubeClient, out io.Writer) *cobra.Command {
+	c := &nodeCordonCommand{client: client, out: out}
+	cmd := &cobra.Command{
+		Use:   "cordon-vms node",
+		Short: "Stop accepting new VM pods on a node",
+		Long: dedent.Dedent(`
+                        This command makes Virtlet on the node reject the new
+                        VM pods with an error that includes the reason, while
+                        leaving the running VMs untouched, e.g. during storage
+                        or libvirt maintenance. This is done by setting
+                        the ` + config.CordonVMsAnnotation + ` annotation
+                        on the node.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("must specify the node")
+			}
+			if c.reason == "" {
+				return errors.New("the reason must not be empty")
+			}
+			return c.setCordon(args[0], &c.reason)
+		},
+	}
+	cmd.Flags().StringVar(&c.reason, "reason", defaultCordonReason, "The reason to report for the rejected VM pods")
+	return cmd
+}
+
+// NewUncordonVMsCmd returns a cobra.Command that makes Virtlet
+// accept new VM pods on a node again.
+func NewUncordonVMsCmd(client KubeClient, out io.Writer) *cobra.Command {
+	c := &nodeCordonCommand{client: client, out: out}
+	return &cobra.Command{
+		Use:   "uncordon-vms node",
+		Short: "Resume accepting new VM pods on a node",
+		Long: dedent.Dedent(`
+                        This command makes Virtlet on the node accept new
+                        VM pods again after 'node cordon-vms'.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("must specify the node")
+			}
+			return c.setCordon(args[0], nil)
+		},
+	}
+}
+
+func (c *nodeCordonCommand) setCordon(nodeName string, reason *string) error {
+	if err := c.client.PatchNodeAnnotations(nodeName, map[string]*string{
+		config.CordonVMsAnnotation: reason,
+	}); err != nil {
+		return err
+	}
+	if reason != nil {
+		fmt.Fprintf(c.out, "node/%s cordoned for new VMs\n", nodeName)
+	} else {
+		fmt.Fprintf(c.out, "node/%s uncordoned for new VMs\n", nodeName)
+	}
+	return nil
+}
+
+// NewNodeCmd returns a cobra.Command that manages Virtlet on the
+// nodes.
+func NewNodeCmd(client KubeClient, out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "node",
+		Short: "Manage Virtlet on the nodes",
+		Long: dedent.Dedent(`
+                        Pause and resume the admission of new VM pods
+                        on the nodes, e.g. for node maintenance.`),
+	}
+	cmd.AddCommand(NewCordonVMsCmd(client, out))
+	cmd.AddCommand(NewUncordonVMsCmd(client, out))
+	return cmd
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2019 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/config"
+)
+
+func TestNodeCommand(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		args                string
+		annotations         map[string]string
+		expectedAnnotations map[string]string
+		expectedOutput      string
+		errSubstring        string
+	}{
+		{
+			name:                "cordon",
+			args:                "cordon-vms kube-node-1",
+			annotations:         map[string]string{"foo": "bar"},
+			expectedAnnotations: map[string]string{"foo": "bar", config.CordonVMsAnnotation: "maintenance"},
+			expectedOutput:      "node/kube-node-1 cordoned for new VMs\n",
+		},
+		{
+			name:                "cordon with a reason",
+			args:                "cordon-vms kube-node-1 --reason libvirt-upgrade",
+			annotations:         map[string]string{},
+			expectedAnnotations: map[string]string{config.CordonVMsAnnotation: "libvirt-upgrade"},
+			expectedOutput:      "node/kube-node-1 cordoned for new VMs\n",
+		},
+		{
+			name:                "uncordon",
+			args:                "uncordon-vms kube-node-1",
+			annotations:         map[string]string{"foo": "bar", config.CordonVMsAnnotation: "maintenance"},
+			expectedAnnotations: map[string]string{"foo": "bar"},
+			expectedOutput:      "node/kube-node-1 uncordoned for new VMs\n",
+		},
+		{
+			name:                "no node",
+			args:                "cordon-vms",
+			annotations:         map[string]string{},
+			expectedAnnotations: map[string]string{},
+			errSubstring:        "must specify the node",
+		},
+		{
+			name:                "nonexistent node",
+			args:                "cordon-vms kube-node-2",
+			annotations:         map[string]string{},
+			expectedAnnotations: map[string]string{},
+			errSubstring:        "node not found",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &fakeKubeClient{
+				t:               t,
+				nodeAnnotations: map[string]map[string]string{"kube-node-1": tc.annotations},
+			}
+			var out bytes.Buffer
+			cmd := NewNodeCmd(c, &out)
+			cmd.SetArgs(strings.Split(tc.args, " "))
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			switch err := cmd.Execute(); {
+			case err != nil && tc.errSubstring == "":
+				t.Errorf("node command returned an unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Errorf("Didn't get expected error (substring %q), output: %q", tc.errSubstring, out.String())
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("Didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			case err == nil && out.String() != tc.expectedOutput:
+				t.Errorf("Unexpected output from the command: %q instead of %q", out.String(), tc.expectedOutput)
+			}
+			if !reflect.DeepEqual(c.nodeAnnotations["kube-node-1"], tc.expectedAnnotations) {
+				t.Errorf("bad node annotations: %#v instead of %#v", c.nodeAnnotations["kube-node-1"], tc.expectedAnnotations)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/renstrom/dedent"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// defaultRolloutTimeout is the default time to wait for a
+	// recreated VM pod to be replaced by a ready one
+	defaultRolloutTimeout = 10 * time.Minute
+	// rolloutPollInterval is the interval between the checks
+	// of the VM pods during the rollout
+	rolloutPollInterval = 2 * time.Second
+	// virtletImagePrefix is the prefix of the VM image names
+	// that's removed by CRI proxy before the image names are
+	// passed to Virtlet
+	virtletImagePrefix = "virtlet.cloud/"
+)
+
+// rolloutImageCommand contains the data needed by the rollout-image
+// subcommand which updates a pool of VM pods to a new image.
+type rolloutImageCommand struct {
+	client      KubeClient
+	out         io.Writer
+	sleep       func(time.Duration)
+	image       string
+	selector    string
+	timeout     time.Duration
+	pullOnly    bool
+	initialUIDs map[string]bool
+}
+
+// NewRolloutImageCmd returns a cobra.Command that pre-pulls a new
+// VM image on the nodes of a pool of VM pods and then recreates the
+// pods one by one. If sleep is nil, time.Sleep is used to wait
+// between the checks of the pods.
+func NewRolloutImageCmd(client KubeClient, out io.Writer, sleep func(time.Duration)) *cobra.Command {
+	r := &rolloutImageCommand{client: client, out: out, sleep: sleep}
+	if r.sleep == nil {
+		r.sleep = time.Sleep
+	}
+	cmd := &cobra.Command{
+		Use:   "rollout-image [flags] image",
+		Short: "Update a pool of VM pods to a new image",
+		Long: dedent.Dedent(`
+                        This command updates the VM pods matching the selector
+                        to a new image with minimal downtime. First, the image
+                        is pulled on each node that runs the pods, so the VMs
+                        don't have to wait for the download upon restart. Then
+                        the pods that don't use the image yet are deleted one
+                        at a time in the order of their names, each time waiting
+                        for the controller to replace the pod with a ready one
+                        before proceeding with the next one. The pod template of
+                        the controller (StatefulSet, Deployment etc.) must be
+                        updated to use the new image beforehand, with its update
+                        strategy set to OnDelete where applicable. The VMs are
+                        recreated instead of rebasing their root volumes onto the
+                        new image while they're running, as the guest filesystem
+                        in the overlay depends on the contents of the old image.
+                        The VMs that boot from persistent root volumes keep using
+                        the image written to the volume.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case len(args) != 1:
+				return errors.New("please specify the image")
+			case r.selector == "":
+				return errors.New("please specify the pod selector using --selector")
+			}
+			r.image = args[0]
+			return r.Run()
+		},
+	}
+	cmd.Flags().StringVarP(&r.selector, "selector", "l", "", "label selector for the VM pods to update")
+	cmd.Flags().DurationVar(&r.timeout, "timeout", defaultRolloutTimeout, "the time to wait for each recreated pod to be replaced by a ready one")
+	cmd.Flags().BoolVar(&r.pullOnly, "pull-only", false, "only pull the image on the nodes without recreating the pods")
+	return cmd
+}
+
+// Run executes the command.
+func (r *rolloutImageCommand) Run() error {
+	pods, err := r.vmPods()
+	switch {
+	case err != nil:
+		return err
+	case len(pods) == 0:
+		return fmt.Errorf("no VM pods match the selector %q", r.selector)
+	}
+
+	if err := r.pullImage(pods); err != nil {
+		return err
+	}
+	if r.pullOnly {
+		return nil
+	}
+
+	r.initialUIDs = make(map[string]bool)
+	var outdated []v1.Pod
+	for _, pod := range pods {
+		r.initialUIDs[string(pod.UID)] = true
+		if !isPodReady(pod) {
+			return fmt.Errorf("VM pod %q is not ready, not starting the rollout", pod.Name)
+		}
+		if vmPodImage(pod) != r.image {
+			outdated = append(outdated, pod)
+		}
+	}
+	if len(outdated) == 0 {
+		fmt.Fprintf(r.out, "All of the VM pods already use image %s\n", r.image)
+		return nil
+	}
+
+	sort.Slice(outdated, func(i, j int) bool { return outdated[i].Name < outdated[j].Name })
+	for _, pod := range outdated {
+		if err := r.recreatePod(pod, len(pods)); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(r.out, "Updated %d VM pod(s) to image %s\n", len(outdated), r.image)
+	return nil
+}
+
+// vmPods returns the VM pods that match the selector.
+func (r *rolloutImageCommand) vmPods() ([]v1.Pod, error) {
+	pods, err := r.client.ListPods(r.selector)
+	if err != nil {
+		return nil, fmt.Errorf("error listing the pods: %v", err)
+	}
+	var vmPods []v1.Pod
+	for _, pod := range pods {
+		if pod.Annotations[runtimeAnnotation] == virtletRuntime && len(pod.Spec.Containers) == 1 {
+			vmPods = append(vmPods, pod)
+		}
+	}
+	return vmPods, nil
+}
+
+// pullImage pulls the image on each node that runs the VM pods.
+func (r *rolloutImageCommand) pullImage(pods []v1.Pod) error {
+	nodes := make(map[string]bool)
+	var nodeNames []string
+	for _, pod := range pods {
+		if nodeName := pod.Spec.NodeName; nodeName != "" && !nodes[nodeName] {
+			nodes[nodeName] = true
+			nodeNames = append(nodeNames, nodeName)
+		}
+	}
+	sort.Strings(nodeNames)
+
+	imageName := strings.TrimPrefix(r.image, virtletImagePrefix)
+	for _, nodeName := range nodeNames {
+		virtletPodName, err := r.client.GetVirtletPodNameForNode(nodeName)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(r.out, "Pulling image %s on node %s\n", imageName, nodeName)
+		exitCode, err := r.client.ExecInContainer(virtletPodName, "virtlet", "kube-system", nil, r.out, os.Stderr, []string{"virtlet", "--image-pull", imageName})
+		switch {
+		case err != nil:
+			return fmt.Errorf("error pulling image %s in Virtlet pod %q on node %q: %v", imageName, virtletPodName, nodeName, err)
+		case exitCode != 0:
+			return fmt.Errorf("pulling image %s failed on node %q with exit code %d", imageName, nodeName, exitCode)
+		}
+	}
+	return nil
+}
+
+// recreatePod deletes the pod and waits till the number of ready VM
+// pods gets back to poolSize.
+func (r *rolloutImageCommand) recreatePod(pod v1.Pod, poolSize int) error {
+	fmt.Fprintf(r.out, "Recreating VM pod %s on node %s\n", pod.Name, pod.Spec.NodeName)
+	if err := r.client.DeletePod(pod.Name, pod.Namespace); err != nil {
+		return fmt.Errorf("error deleting pod %q: %v", pod.Name, err)
+	}
+	for elapsed := time.Duration(0); ; elapsed += rolloutPollInterval {
+		pods, err := r.vmPods()
+		if err != nil {
+			return err
+		}
+		replaced, err := r.podReplaced(pod, pods, poolSize)
+		switch {
+		case err != nil:
+			return err
+		case replaced:
+			return nil
+		case elapsed >= r.timeout:
+			return fmt.Errorf("timed out waiting for VM pod %q to be replaced by a ready pod", pod.Name)
+		}
+		r.sleep(rolloutPollInterval)
+	}
+}
+
+// podReplaced returns true if the specified pod is gone and the
+// number of ready VM pods is back to poolSize. An error is returned
+// if the controller has recreated the pod using a different image.
+func (r *rolloutImageCommand) podReplaced(oldPod v1.Pod, pods []v1.Pod, poolSize int) (bool, error) {
+	numReady := 0
+	for _, pod := range pods {
+		if pod.UID == oldPod.UID {
+			return false, nil
+		}
+		if !r.initialUIDs[string(pod.UID)] && vmPodImage(pod) != r.image {
+			return false, fmt.Errorf("VM pod %q was recreated using image %s instead of %s, please update the pod template of its controller", pod.Name, vmPodImage(pod), r.image)
+		}
+		if pod.DeletionTimestamp == nil && isPodReady(pod) {
+			numReady++
+		}
+	}
+	return numReady >= poolSize, nil
+}
+
+func vmPodImage(pod v1.Pod) string {
+	return pod.Spec.Containers[0].Image
+}
+
+func isPodReady(pod v1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == v1.PodReady {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	rolloutOldImage = "virtlet.cloud/cirros:0.3.5"
+	rolloutNewImage = "virtlet.cloud/cirros:0.4.0"
+)
+
+func rolloutTestPod(name, uid, nodeName, image string, ready bool) v1.Pod {
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+	return v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			UID:         types.UID(uid),
+			Labels:      map[string]string{"app": "db"},
+			Annotations: map[string]string{runtimeAnnotation: virtletRuntime},
+		},
+		Spec: v1.PodSpec{
+			NodeName:   nodeName,
+			Containers: []v1.Container{{Name: "vm", Image: image}},
+		},
+		Status: v1.PodStatus{
+			Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: status}},
+		},
+	}
+}
+
+func TestRolloutImageCommand(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		args             string
+		pods             []v1.Pod
+		replacementImage string
+		expectedCommands map[string]string
+		expectedOutput   string
+		expectedImages   map[string]string
+		errSubstring     string
+	}{
+		{
+			name: "rollout",
+			args: "-l app=db " + rolloutNewImage,
+			pods: []v1.Pod{
+				rolloutTestPod("db-1", "uid-db-1", "kube-node-2", rolloutOldImage, true),
+				rolloutTestPod("db-0", "uid-db-0", "kube-node-1", rolloutOldImage, true),
+				rolloutTestPod("db-2", "uid-db-2", "kube-node-1", rolloutNewImage, true),
+			},
+			replacementImage: rolloutNewImage,
+			expectedCommands: map[string]string{
+				"virtlet-foo42/virtlet/kube-system: virtlet --image-pull cirros:0.4.0": "Pulled image: cirros:0.4.0@sha256:fc8d\n",
+				"virtlet-g4lv5/virtlet/kube-system: virtlet --image-pull cirros:0.4.0": "Pulled image: cirros:0.4.0@sha256:fc8d\n",
+			},
+			expectedOutput: "Pulling image cirros:0.4.0 on node kube-node-1\n" +
+				"Pulled image: cirros:0.4.0@sha256:fc8d\n" +
+				"Pulling image cirros:0.4.0 on node kube-node-2\n" +
+				"Pulled image: cirros:0.4.0@sha256:fc8d\n" +
+				"Recreating VM pod db-0 on node kube-node-1\n" +
+				"Recreating VM pod db-1 on node kube-node-2\n" +
+				"Updated 2 VM pod(s) to image virtlet.cloud/cirros:0.4.0\n",
+			expectedImages: map[string]string{
+				"db-0": rolloutNewImage,
+				"db-1": rolloutNewImage,
+				"db-2": rolloutNewImage,
+			},
+		},
+		{
+			name: "pull only",
+			args: "-l app=db --pull-only " + rolloutNewImage,
+			pods: []v1.Pod{
+				rolloutTestPod("db-0", "uid-db-0", "kube-node-1", rolloutOldImage, true),
+			},
+			expectedCommands: map[string]string{
+				"virtlet-foo42/virtlet/kube-system: virtlet --image-pull cirros:0.4.0": "Pulled image: cirros:0.4.0@sha256:fc8d\n",
+			},
+			expectedOutput: "Pulling image cirros:0.4.0 on node kube-node-1\n" +
+				"Pulled image: cirros:0.4.0@sha256:fc8d\n",
+			expectedImages: map[string]string{
+				"db-0": rolloutOldImage,
+			},
+		},
+		{
+			name: "pods already updated",
+			args: "-l app=db " + rolloutNewImage,
+			pods: []v1.Pod{
+				rolloutTestPod("db-0", "uid-db-0", "kube-node-1", rolloutNewImage, true),
+			},
+			expectedCommands: map[string]string{
+				"virtlet-foo42/virtlet/kube-system: virtlet --image-pull cirros:0.4.0": "",
+			},
+			expectedOutput: "Pulling image cirros:0.4.0 on node kube-node-1\n" +
+				"All of the VM pods already use image virtlet.cloud/cirros:0.4.0\n",
+			expectedImages: map[string]string{
+				"db-0": rolloutNewImage,
+			},
+		},
+		{
+			name: "pod template not updated",
+			args: "-l app=db " + rolloutNewImage,
+			pods: []v1.Pod{
+				rolloutTestPod("db-0", "uid-db-0", "kube-node-1", rolloutOldImage, true),
+				rolloutTestPod("db-1", "uid-db-1", "kube-node-1", rolloutOldImage, true),
+			},
+			replacementImage: rolloutOldImage,
+			expectedCommands: map[string]string{
+				"virtlet-foo42/virtlet/kube-system: virtlet --image-pull cirros:0.4.0": "",
+			},
+			errSubstring: "please update the pod template",
+		},
+		{
+			name: "pod not replaced",
+			args: "-l app=db --timeout 10s " + rolloutNewImage,
+			pods: []v1.Pod{
+				rolloutTestPod("db-0", "uid-db-0", "kube-node-1", rolloutOldImage, true),
+			},
+			expectedCommands: map[string]string{
+				"virtlet-foo42/virtlet/kube-system: virtlet --image-pull cirros:0.4.0": "",
+			},
+			errSubstring: "timed out waiting for VM pod \"db-0\"",
+		},
+		{
+			name: "pod not ready",
+			args: "-l app=db " + rolloutNewImage,
+			pods: []v1.Pod{
+				rolloutTestPod("db-0", "uid-db-0", "kube-node-1", rolloutOldImage, true),
+				rolloutTestPod("db-1", "uid-db-1", "kube-node-2", rolloutOldImage, false),
+			},
+			expectedCommands: map[string]string{
+				"virtlet-foo42/virtlet/kube-system: virtlet --image-pull cirros:0.4.0": "",
+				"virtlet-g4lv5/virtlet/kube-system: virtlet --image-pull cirros:0.4.0": "",
+			},
+			errSubstring: "VM pod \"db-1\" is not ready",
+		},
+		{
+			name:         "no pods",
+			args:         "-l app=web " + rolloutNewImage,
+			errSubstring: "no VM pods match the selector",
+		},
+		{
+			name:         "no selector",
+			args:         rolloutNewImage,
+			errSubstring: "please specify the pod selector",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &fakeKubeClient{
+				t: t,
+				virtletPods: map[string]string{
+					"kube-node-1": "virtlet-foo42",
+					"kube-node-2": "virtlet-g4lv5",
+				},
+				expectedCommands: tc.expectedCommands,
+				pods:             tc.pods,
+			}
+			if tc.replacementImage != "" {
+				c.podDeleted = func(pod v1.Pod) {
+					// the controller replaces the pod
+					c.pods = append(c.pods, rolloutTestPod(pod.Name, "new-"+string(pod.UID), pod.Spec.NodeName, tc.replacementImage, true))
+				}
+			}
+			var out bytes.Buffer
+			cmd := NewRolloutImageCmd(c, &out, func(time.Duration) {})
+			cmd.SetArgs(strings.Split(tc.args, " "))
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			switch err := cmd.Execute(); {
+			case err != nil && tc.errSubstring == "":
+				t.Errorf("rollout-image command returned an unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Errorf("Didn't get expected error (substring %q), output: %q", tc.errSubstring, out.String())
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("Didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			}
+			if tc.errSubstring == "" && out.String() != tc.expectedOutput {
+				t.Errorf("Bad output:\n%s\n-- instead of --\n%s", out.String(), tc.expectedOutput)
+			}
+			for c := range tc.expectedCommands {
+				t.Errorf("command not executed: %q", c)
+			}
+			if tc.expectedImages != nil {
+				images := make(map[string]string)
+				for _, pod := range c.pods {
+					images[pod.Name] = vmPodImage(pod)
+				}
+				if len(images) != len(tc.expectedImages) {
+					t.Errorf("bad pods after the rollout: %#v", images)
+				}
+				for name, image := range tc.expectedImages {
+					if images[name] != image {
+						t.Errorf("bad image for pod %q after the rollout: %q instead of %q", name, images[name], image)
+					}
+				}
+			}
+		})
+	}
+}
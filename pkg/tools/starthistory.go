@@ -0,0 +1,186 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/renstrom/dedent"
+	"github.com/spf13/cobra"
+
+	"github.com/Mirantis/virtlet/pkg/diag"
+	"github.com/Mirantis/virtlet/pkg/metadata"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+const startRecordsDiagSource = "start-records"
+
+type startHistoryCommand struct {
+	client KubeClient
+	out    io.Writer
+	diff   bool
+}
+
+// NewStartHistoryCmd returns a cobra.Command that displays the
+// artifacts used for the recent starts of a VM pod.
+func NewStartHistoryCmd(client KubeClient, out io.Writer) *cobra.Command {
+	c := &startHistoryCommand{client: client, out: out}
+	cmd := &cobra.Command{
+		Use:   "start-history [flags] pod",
+		Short: "Display the artifacts used for the recent VM starts",
+		Long: dedent.Dedent(`
+                        This command displays the domain definitions, the
+                        hashes of cloud-init data and the CNI results used
+                        for the recent starts of a VM pod, as recorded by
+                        Virtlet on the node. With --diff, only the
+                        differences between the last two starts are shown.`),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New("please specify the pod")
+			}
+			return c.run(args[0])
+		},
+	}
+	cmd.Flags().BoolVar(&c.diff, "diff", false, "Show the differences between the last two starts")
+	return cmd
+}
+
+func (c *startHistoryCommand) run(podName string) error {
+	records, err := c.getStartRecords(podName)
+	if err != nil {
+		return err
+	}
+	if !c.diff {
+		if len(records) == 0 {
+			return fmt.Errorf("no start records found for pod %q", podName)
+		}
+		for n, record := range records {
+			if n > 0 {
+				fmt.Fprintln(c.out)
+			}
+			io.WriteString(c.out, formatStartRecord(record))
+		}
+		return nil
+	}
+
+	if len(records) < 2 {
+		return fmt.Errorf("need at least 2 start records for pod %q to compare, got %d", podName, len(records))
+	}
+	a, b := records[len(records)-2], records[len(records)-1]
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(formatStartRecord(a)),
+		B:        difflib.SplitLines(formatStartRecord(b)),
+		FromFile: fmt.Sprintf("start #%d", a.ID),
+		ToFile:   fmt.Sprintf("start #%d", b.ID),
+		Context:  3,
+	})
+	if err != nil {
+		return fmt.Errorf("error comparing the start records: %v", err)
+	}
+	io.WriteString(c.out, diff)
+	return nil
+}
+
+func (c *startHistoryCommand) getStartRecords(podName string) ([]*types.VMStartRecord, error) {
+	vmPodInfo, err := c.client.GetVMPodInfo(podName)
+	if err != nil {
+		return nil, fmt.Errorf("can't get VM pod info for %q: %v", podName, err)
+	}
+
+	var buf bytes.Buffer
+	exitCode, err := c.client.ExecInContainer(
+		vmPodInfo.VirtletPodName, "virtlet", "kube-system", nil,
+		&buf, os.Stderr, []string{"virtlet", "--diag"})
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("error getting diagnostics from Virtlet pod %q: %v", vmPodInfo.VirtletPodName, err)
+	case exitCode != 0:
+		return nil, fmt.Errorf("error getting diagnostics from Virtlet pod %q: exit code %d", vmPodInfo.VirtletPodName, exitCode)
+	}
+	dr, err := diag.DecodeDiagnostics(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	src, found := dr.Children[startRecordsDiagSource]
+	switch {
+	case !found:
+		return nil, fmt.Errorf("Virtlet on node %q doesn't provide the start records", vmPodInfo.NodeName)
+	case src.Error != "":
+		return nil, fmt.Errorf("error retrieving the start records: %s", src.Error)
+	}
+	podRecords, found := src.Children[metadata.StartRecordsFileName(vmPodInfo.Namespace, podName)]
+	if !found {
+		return nil, nil
+	}
+	var records []*types.VMStartRecord
+	if err := json.Unmarshal([]byte(podRecords.Data), &records); err != nil {
+		return nil, fmt.Errorf("error unmarshalling the start records: %v", err)
+	}
+	return records, nil
+}
+
+func formatStartRecord(record *types.VMStartRecord) string {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "Start #%d at %s\n", record.ID, time.Unix(0, record.Timestamp).UTC().Format(time.RFC3339))
+	fmt.Fprintf(&out, "Container ID: %s\n", record.ContainerID)
+	fmt.Fprintf(&out, "Image: %s\n", record.Image)
+	if record.Error != "" {
+		fmt.Fprintf(&out, "Error: %s\n", record.Error)
+	}
+
+	out.WriteString("Cloud-init data hashes:\n")
+	var locations []string
+	for location := range record.CloudInitHashes {
+		locations = append(locations, location)
+	}
+	sort.Strings(locations)
+	for _, location := range locations {
+		fmt.Fprintf(&out, "  %s: %s\n", location, record.CloudInitHashes[location])
+	}
+
+	out.WriteString("CNI result:\n")
+	if record.CNIResult != nil {
+		cniResult, err := json.MarshalIndent(record.CNIResult, "", "  ")
+		if err != nil {
+			cniResult = []byte(fmt.Sprintf("<error marshalling CNI result: %v>", err))
+		}
+		writeIndented(&out, string(cniResult))
+	}
+
+	out.WriteString("Domain XML:\n")
+	writeIndented(&out, record.DomainXML)
+	return out.String()
+}
+
+func writeIndented(out *bytes.Buffer, text string) {
+	for _, l := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		if l != "" {
+			out.WriteString("  " + l)
+		}
+		out.WriteString("\n")
+	}
+}
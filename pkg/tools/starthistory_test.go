@@ -0,0 +1,202 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Mirantis/virtlet/pkg/diag"
+	"github.com/Mirantis/virtlet/pkg/metadata/types"
+)
+
+func startHistoryDiagOutput(t *testing.T, records ...*types.VMStartRecord) string {
+	recordData, err := json.Marshal(records)
+	if err != nil {
+		t.Fatalf("json.Marshal(): %v", err)
+	}
+	dr := diag.Result{
+		Name:  "diagnostics",
+		IsDir: true,
+		Children: map[string]diag.Result{
+			"start-records": {
+				Name:  "start-records",
+				IsDir: true,
+				Children: map[string]diag.Result{
+					"default-cirros-vm": {
+						Name: "default-cirros-vm",
+						Ext:  "json",
+						Data: string(recordData),
+					},
+				},
+			},
+		},
+	}
+	return string(dr.ToJSON())
+}
+
+func TestStartHistoryCommand(t *testing.T) {
+	records := []*types.VMStartRecord{
+		{
+			ID:           1,
+			Timestamp:    1531164300000000000,
+			ContainerID:  "a1b2c3",
+			PodNamespace: "default",
+			PodName:      "cirros-vm",
+			Image:        "cirros",
+			DomainXML:    "<domain type='kvm'>\n  <memory unit='KiB'>524288</memory>\n</domain>",
+			CloudInitHashes: map[string]string{
+				"user-data": "1111",
+				"meta-data": "2222",
+			},
+		},
+		{
+			ID:           2,
+			Timestamp:    1531164360000000000,
+			ContainerID:  "a1b2c3",
+			PodNamespace: "default",
+			PodName:      "cirros-vm",
+			Image:        "cirros",
+			Error:        "domain crashed on start",
+			DomainXML:    "<domain type='kvm'>\n  <memory unit='KiB'>65536</memory>\n</domain>",
+			CloudInitHashes: map[string]string{
+				"user-data": "1111",
+				"meta-data": "3333",
+			},
+		},
+	}
+	for _, tc := range []struct {
+		name           string
+		args           string
+		records        []*types.VMStartRecord
+		expectedOutput string
+		errSubstring   string
+	}{
+		{
+			name:    "list",
+			args:    "cirros-vm",
+			records: records,
+			expectedOutput: "Start #1 at 2018-07-09T19:25:00Z\n" +
+				"Container ID: a1b2c3\n" +
+				"Image: cirros\n" +
+				"Cloud-init data hashes:\n" +
+				"  meta-data: 2222\n" +
+				"  user-data: 1111\n" +
+				"CNI result:\n" +
+				"Domain XML:\n" +
+				"  <domain type='kvm'>\n" +
+				"    <memory unit='KiB'>524288</memory>\n" +
+				"  </domain>\n" +
+				"\n" +
+				"Start #2 at 2018-07-09T19:26:00Z\n" +
+				"Container ID: a1b2c3\n" +
+				"Image: cirros\n" +
+				"Error: domain crashed on start\n" +
+				"Cloud-init data hashes:\n" +
+				"  meta-data: 3333\n" +
+				"  user-data: 1111\n" +
+				"CNI result:\n" +
+				"Domain XML:\n" +
+				"  <domain type='kvm'>\n" +
+				"    <memory unit='KiB'>65536</memory>\n" +
+				"  </domain>\n",
+		},
+		{
+			name:    "diff",
+			args:    "--diff cirros-vm",
+			records: records,
+			expectedOutput: "--- start #1\n" +
+				"+++ start #2\n" +
+				"@@ -1,11 +1,12 @@\n" +
+				"-Start #1 at 2018-07-09T19:25:00Z\n" +
+				"+Start #2 at 2018-07-09T19:26:00Z\n" +
+				" Container ID: a1b2c3\n" +
+				" Image: cirros\n" +
+				"+Error: domain crashed on start\n" +
+				" Cloud-init data hashes:\n" +
+				"-  meta-data: 2222\n" +
+				"+  meta-data: 3333\n" +
+				"   user-data: 1111\n" +
+				" CNI result:\n" +
+				" Domain XML:\n" +
+				"   <domain type='kvm'>\n" +
+				"-    <memory unit='KiB'>524288</memory>\n" +
+				"+    <memory unit='KiB'>65536</memory>\n" +
+				"   </domain>\n",
+		},
+		{
+			name:         "diff with a single record",
+			args:         "--diff cirros-vm",
+			records:      records[:1],
+			errSubstring: "need at least 2 start records",
+		},
+		{
+			name:         "no records",
+			args:         "cirros-vm",
+			errSubstring: "no start records found",
+		},
+		{
+			name:         "no pod",
+			args:         "",
+			errSubstring: "please specify the pod",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			expectedCommands := map[string]string{}
+			if tc.args != "" {
+				expectedCommands["virtlet-foo42/virtlet/kube-system: virtlet --diag"] = startHistoryDiagOutput(t, tc.records...)
+			}
+			c := &fakeKubeClient{
+				t: t,
+				vmPods: map[string]VMPodInfo{
+					"cirros-vm": {
+						Namespace:      "default",
+						NodeName:       "kube-node-1",
+						VirtletPodName: "virtlet-foo42",
+						ContainerID:    "a1b2c3",
+						ContainerName:  "cirros-vm",
+					},
+				},
+				expectedCommands: expectedCommands,
+			}
+			var out bytes.Buffer
+			cmd := NewStartHistoryCmd(c, &out)
+			args := []string{}
+			if tc.args != "" {
+				args = strings.Split(tc.args, " ")
+			}
+			cmd.SetArgs(args)
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+			switch err := cmd.Execute(); {
+			case err != nil && tc.errSubstring == "":
+				t.Errorf("start-history command returned an unexpected error: %v", err)
+			case err == nil && tc.errSubstring != "":
+				t.Errorf("Didn't get expected error (substring %q), output: %q", tc.errSubstring, out.String())
+			case err != nil && !strings.Contains(err.Error(), tc.errSubstring):
+				t.Errorf("Didn't get expected substring %q in the error: %v", tc.errSubstring, err)
+			case err == nil && out.String() != tc.expectedOutput:
+				t.Errorf("Unexpected output from the command:\n%s\n-- instead of --\n%s", out.String(), tc.expectedOutput)
+			}
+			for c := range c.expectedCommands {
+				t.Errorf("command not executed: %q", c)
+			}
+		})
+	}
+}
@@ -30,11 +30,32 @@ const (
 	cgroupfs = "/sys/fs/cgroup"
 )
 
+// v2SettingNames maps cgroup v1 setting names to their cgroup v2
+// counterparts for the settings that differ between the versions.
+var v2SettingNames = map[string]string{
+	"memory.limit_in_bytes":            "memory.max",
+	"memory.soft_limit_in_bytes":       "memory.high",
+	"blkio.throttle.read_bps_device":   "io.max",
+	"blkio.throttle.write_bps_device":  "io.max",
+	"blkio.throttle.read_iops_device":  "io.max",
+	"blkio.throttle.write_iops_device": "io.max",
+}
+
+// v2IOMaxKeys maps cgroup v1 blkio throttle settings to the keys
+// used in cgroup v2 io.max file.
+var v2IOMaxKeys = map[string]string{
+	"blkio.throttle.read_bps_device":   "rbps",
+	"blkio.throttle.write_bps_device":  "wbps",
+	"blkio.throttle.read_iops_device":  "riops",
+	"blkio.throttle.write_iops_device": "wiops",
+}
+
 // Controller represents a named controller for a process
 type Controller struct {
-	fsys fs.FileSystem
-	name string
-	path string
+	fsys    fs.FileSystem
+	name    string
+	path    string
+	unified bool
 }
 
 // Manager provides an interface to operate on linux cgroups
@@ -44,8 +65,13 @@ type Manager interface {
 	GetProcessControllers() (map[string]string, error)
 	// GetProcessController returns a named resource Controller for the specified PID.
 	GetProcessController(controllerName string) (*Controller, error)
-	// MoveProcess move the process to the path under a cgroup controller
+	// MoveProcess move the process to the path under a cgroup controller.
+	// In cgroup v2 unified hierarchy, the process is moved for all of
+	// the controllers at once.
 	MoveProcess(controller, path string) error
+	// IsUnified returns true if the process belongs to cgroup v2
+	// unified hierarchy and doesn't use any cgroup v1 controllers.
+	IsUnified() (bool, error)
 }
 
 // RealManager provides an implementation of Manager which is
@@ -65,22 +91,25 @@ func NewManager(pid interface{}, fsys fs.FileSystem) Manager {
 	return &RealManager{fsys: fsys, pid: utils.Stringify(pid)}
 }
 
-// GetProcessControllers is an implementation of GetProcessControllers method
-// of Manager interface.
-func (c *RealManager) GetProcessControllers() (map[string]string, error) {
+// readProcessCgroups parses /proc/<pid>/cgroup file for the process.
+// It returns the mapping between cgroup v1 controllers and their paths
+// along with the path of the process in cgroup v2 unified hierarchy,
+// which is empty if the process doesn't belong to it.
+func (c *RealManager) readProcessCgroups() (map[string]string, string, error) {
 	fr, err := c.fsys.GetDelimitedReader(filepath.Join("/proc", c.pid, "cgroup"))
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer fr.Close()
 
 	ctrls := make(map[string]string)
+	unifiedPath := ""
 
 	for {
 		line, err := fr.ReadString('\n')
 		if err != nil {
 			if err != io.EOF {
-				return nil, err
+				return nil, "", err
 			}
 		}
 
@@ -93,59 +122,182 @@ func (c *RealManager) GetProcessControllers() (map[string]string, error) {
 		// split entries like:
 		// "6:memory:/user.slice/user-xxx.slice/session-xx.scope"
 		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			return nil, "", fmt.Errorf("bad cgroup entry for process %v: %q", c.pid, line)
+		}
 
 		name := parts[1]
-		if strings.HasPrefix(name, "name=") {
+		switch {
+		case parts[0] == "0" && name == "":
+			// cgroup v2 unified hierarchy entry:
+			// "0::/kubepods/burstable/podxxx/yyy"
+			unifiedPath = parts[2]
+		case strings.HasPrefix(name, "name="):
 			// Handle named cgroup hierarchies like name=systemd
 			// The corresponding directory tree will be /sys/fs/cgroup/systemd
-			name = name[5:]
+			ctrls[name[5:]] = parts[2]
+		default:
+			// use second part as controller name and third as its path
+			// (comounted controllers like "cpu,cpuacct" are kept as is)
+			ctrls[name] = parts[2]
 		}
 
-		// use second part as controller name and third as its path
-		ctrls[name] = parts[2]
-
 		if err == io.EOF {
 			break
 		}
 	}
 
-	return ctrls, nil
+	return ctrls, unifiedPath, nil
+}
+
+// readUnifiedControllers returns the list of controllers that are
+// available in the specified cgroup of cgroup v2 unified hierarchy.
+func (c *RealManager) readUnifiedControllers(path string) ([]string, error) {
+	fr, err := c.fsys.GetDelimitedReader(filepath.Join(cgroupfs, path, "cgroup.controllers"))
+	if err != nil {
+		return nil, err
+	}
+	defer fr.Close()
+
+	line, err := fr.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return strings.Fields(line), nil
+}
+
+// getControllers returns the mapping between controller types and
+// their paths for the process along with a flag that indicates whether
+// the process belongs to cgroup v2 unified hierarchy.
+func (c *RealManager) getControllers() (map[string]string, bool, error) {
+	ctrls, unifiedPath, err := c.readProcessCgroups()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(ctrls) != 0 || unifiedPath == "" {
+		if unifiedPath != "" {
+			// hybrid mode, keep the unified hierarchy
+			// entry for the sake of compatibility
+			ctrls[""] = unifiedPath
+		}
+		return ctrls, false, nil
+	}
+
+	names, err := c.readUnifiedControllers(unifiedPath)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, name := range names {
+		ctrls[name] = unifiedPath
+	}
+
+	return ctrls, true, nil
+}
+
+// IsUnified is an implementation of IsUnified method of Manager interface.
+func (c *RealManager) IsUnified() (bool, error) {
+	ctrls, unifiedPath, err := c.readProcessCgroups()
+	if err != nil {
+		return false, err
+	}
+	return len(ctrls) == 0 && unifiedPath != "", nil
+}
+
+// GetProcessControllers is an implementation of GetProcessControllers method
+// of Manager interface. For processes in cgroup v2 unified hierarchy,
+// all of the controllers that are available in the process cgroup
+// are mapped to its path.
+func (c *RealManager) GetProcessControllers() (map[string]string, error) {
+	ctrls, _, err := c.getControllers()
+	return ctrls, err
 }
 
 // GetProcessController is an implementation of GetProcessController method
 // of Manager interface.
 func (c *RealManager) GetProcessController(controllerName string) (*Controller, error) {
-	controllers, err := c.GetProcessControllers()
+	controllers, unified, err := c.getControllers()
 	if err != nil {
 		return nil, err
 	}
 
+	if unified && controllerName == "blkio" {
+		// blkio controller is called io in cgroup v2
+		controllerName = "io"
+	}
+
 	controllerPath, ok := controllers[controllerName]
 	if !ok {
 		return nil, fmt.Errorf("controller %q for process %v not found", controllerName, c.pid)
 	}
 
 	return &Controller{
-		fsys: c.fsys,
-		name: controllerName,
-		path: controllerPath,
+		fsys:    c.fsys,
+		name:    controllerName,
+		path:    controllerPath,
+		unified: unified,
 	}, nil
 }
 
 // MoveProcess implements MoveProcess method of Manager
 func (c *RealManager) MoveProcess(controller, path string) error {
+	unified, err := c.IsUnified()
+	if err != nil {
+		return err
+	}
+	if !unified {
+		path = filepath.Join(controller, path)
+	}
 	return c.fsys.WriteFile(
-		filepath.Join(cgroupfs, controller, path, "cgroup.procs"),
+		filepath.Join(cgroupfs, path, "cgroup.procs"),
 		[]byte(utils.Stringify(c.pid)),
 		0644,
 	)
 }
 
-// Set sets the value of a controller setting
+// Set sets the value of a controller setting. The setting is
+// specified using its cgroup v1 name without the controller prefix,
+// e.g. "cpus" for cpuset controller. For cgroup v2, the settings
+// that were renamed are translated to their new names, e.g.
+// memory "soft_limit_in_bytes" is written to memory.high and
+// blkio throttle settings are written to io.max.
 func (c *Controller) Set(name string, value interface{}) error {
-	return c.fsys.WriteFile(
-		filepath.Join(cgroupfs, c.name, c.path, c.name+"."+name),
-		[]byte(utils.Stringify(value)),
-		0644,
-	)
+	dir := filepath.Join(cgroupfs, c.name, c.path)
+	fileName := c.name + "." + name
+	data := utils.Stringify(value)
+	if c.unified {
+		dir = filepath.Join(cgroupfs, c.path)
+		v1Name := fileName
+		if c.name == "io" {
+			v1Name = "blkio." + name
+		}
+		if v2Name, found := v2SettingNames[v1Name]; found {
+			fileName = v2Name
+		}
+		data = v2SettingValue(v1Name, data)
+	}
+	return c.fsys.WriteFile(filepath.Join(dir, fileName), []byte(data), 0644)
+}
+
+// v2SettingValue converts the value of cgroup v1 setting to the
+// format used by the corresponding cgroup v2 setting.
+func v2SettingValue(v1Name, value string) string {
+	switch {
+	case strings.HasPrefix(v1Name, "memory.") && value == "-1":
+		// "no limit" is denoted by "max" in cgroup v2
+		return "max"
+	case v2IOMaxKeys[v1Name] != "":
+		// "8:0 1048576" -> "8:0 rbps=1048576"
+		parts := strings.Fields(value)
+		if len(parts) != 2 {
+			return value
+		}
+		limit := parts[1]
+		if limit == "0" {
+			limit = "max"
+		}
+		return fmt.Sprintf("%s %s=%s", parts[0], v2IOMaxKeys[v1Name], limit)
+	default:
+		return value
+	}
 }
@@ -0,0 +1,140 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cgroups
+
+import (
+	"reflect"
+	"testing"
+
+	fakefs "github.com/Mirantis/virtlet/pkg/fs/fake"
+	testutils "github.com/Mirantis/virtlet/pkg/utils/testing"
+)
+
+const (
+	v1ProcCgroup = "11:pids:/kubepods/pod1/c1\n" +
+		"6:memory:/kubepods/pod1/c1\n" +
+		"5:blkio:/kubepods/pod1/c1\n" +
+		"3:cpuset:/kubepods/pod1/c1\n" +
+		"1:name=systemd:/kubepods/pod1/c1\n" +
+		"0::/system.slice/containerd.service\n"
+	v2ProcCgroup = "0::/kubepods/pod1/c1\n"
+)
+
+func TestControllers(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		procCgroup          string
+		expectedUnified     bool
+		expectedControllers map[string]string
+		expectedFiles       map[string]string
+	}{
+		{
+			name:            "cgroup v1",
+			procCgroup:      v1ProcCgroup,
+			expectedUnified: false,
+			expectedControllers: map[string]string{
+				"pids":    "/kubepods/pod1/c1",
+				"memory":  "/kubepods/pod1/c1",
+				"blkio":   "/kubepods/pod1/c1",
+				"cpuset":  "/kubepods/pod1/c1",
+				"systemd": "/kubepods/pod1/c1",
+				"":        "/system.slice/containerd.service",
+			},
+			expectedFiles: map[string]string{
+				"/sys/fs/cgroup/cpuset/kubepods/pod1/c1/cpuset.cpus":                     "0-3",
+				"/sys/fs/cgroup/memory/kubepods/pod1/c1/memory.soft_limit_in_bytes":      "1073741824",
+				"/sys/fs/cgroup/memory/kubepods/pod1/c1/memory.limit_in_bytes":           "-1",
+				"/sys/fs/cgroup/blkio/kubepods/pod1/c1/blkio.throttle.read_bps_device":   "8:0 1048576",
+				"/sys/fs/cgroup/blkio/kubepods/pod1/c1/blkio.throttle.write_iops_device": "8:0 100",
+				"/sys/fs/cgroup/pids/cgroup.procs":                                       "4242",
+			},
+		},
+		{
+			name:            "cgroup v2",
+			procCgroup:      v2ProcCgroup,
+			expectedUnified: true,
+			expectedControllers: map[string]string{
+				"cpuset": "/kubepods/pod1/c1",
+				"cpu":    "/kubepods/pod1/c1",
+				"io":     "/kubepods/pod1/c1",
+				"memory": "/kubepods/pod1/c1",
+				"pids":   "/kubepods/pod1/c1",
+			},
+			expectedFiles: map[string]string{
+				"/sys/fs/cgroup/kubepods/pod1/c1/cpuset.cpus": "0-3",
+				"/sys/fs/cgroup/kubepods/pod1/c1/memory.high": "1073741824",
+				"/sys/fs/cgroup/kubepods/pod1/c1/memory.max":  "max",
+				// the fake fs keeps only the last write
+				"/sys/fs/cgroup/kubepods/pod1/c1/io.max": "8:0 wiops=100",
+				"/sys/fs/cgroup/cgroup.procs":            "4242",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			files := map[string]string{
+				"/proc/4242/cgroup": tc.procCgroup,
+				"/sys/fs/cgroup/kubepods/pod1/c1/cgroup.controllers": "cpuset cpu io memory pids\n",
+			}
+			fsys := fakefs.NewFakeFileSystem(t, testutils.NewToplevelRecorder(), "", files)
+			cm := NewManager(4242, fsys)
+
+			unified, err := cm.IsUnified()
+			if err != nil {
+				t.Fatalf("IsUnified(): %v", err)
+			}
+			if unified != tc.expectedUnified {
+				t.Errorf("IsUnified(): expected %v, got %v", tc.expectedUnified, unified)
+			}
+
+			controllers, err := cm.GetProcessControllers()
+			if err != nil {
+				t.Fatalf("GetProcessControllers(): %v", err)
+			}
+			if !reflect.DeepEqual(controllers, tc.expectedControllers) {
+				t.Errorf("bad controllers: expected %#v, got %#v", tc.expectedControllers, controllers)
+			}
+
+			for _, s := range []struct {
+				controller, name string
+				value            interface{}
+			}{
+				{"cpuset", "cpus", "0-3"},
+				{"memory", "soft_limit_in_bytes", 1073741824},
+				{"memory", "limit_in_bytes", -1},
+				{"blkio", "throttle.read_bps_device", "8:0 1048576"},
+				{"blkio", "throttle.write_iops_device", "8:0 100"},
+			} {
+				ctl, err := cm.GetProcessController(s.controller)
+				if err != nil {
+					t.Fatalf("GetProcessController(%q): %v", s.controller, err)
+				}
+				if err := ctl.Set(s.name, s.value); err != nil {
+					t.Fatalf("Set(%q, %v): %v", s.name, s.value, err)
+				}
+			}
+			if err := cm.MoveProcess("pids", "/"); err != nil {
+				t.Fatalf("MoveProcess(): %v", err)
+			}
+
+			for path, expectedContent := range tc.expectedFiles {
+				if files[path] != expectedContent {
+					t.Errorf("bad content of %q: expected %q, got %q", path, expectedContent, files[path])
+				}
+			}
+		})
+	}
+}
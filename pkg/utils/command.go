@@ -19,6 +19,7 @@ package utils
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os/exec"
 	"strings"
 )
@@ -40,23 +41,101 @@ type Commander interface {
 	Command(name string, arg ...string) Command
 }
 
+// LineCommand is implemented by the commands that can pass the
+// lines of their standard output to a callback while they're
+// running.
+type LineCommand interface {
+	Command
+	// RunWithLines is like Run but also calls onLine for each
+	// line of the standard output of the command as soon as
+	// the line is available. Both '\n' and '\r' are treated as
+	// line terminators.
+	RunWithLines(stdin []byte, onLine func(line string)) ([]byte, error)
+}
+
+// RunWithLines runs the command passing each line of its standard
+// output to onLine. If the command doesn't implement LineCommand,
+// the lines are passed to onLine after the command finishes.
+func RunWithLines(cmd Command, stdin []byte, onLine func(line string)) ([]byte, error) {
+	if lc, ok := cmd.(LineCommand); ok {
+		return lc.RunWithLines(stdin, onLine)
+	}
+	out, err := cmd.Run(stdin)
+	if err == nil {
+		lw := &lineWriter{onLine: onLine}
+		lw.Write(out)
+		lw.flush()
+	}
+	return out, err
+}
+
+// lineWriter splits the data written to it into lines.
+type lineWriter struct {
+	buf    bytes.Buffer
+	out    bytes.Buffer
+	onLine func(line string)
+}
+
+var _ io.Writer = &lineWriter{}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.out.Write(p)
+	for _, b := range p {
+		if b == '\n' || b == '\r' {
+			lw.flush()
+		} else {
+			lw.buf.WriteByte(b)
+		}
+	}
+	return len(p), nil
+}
+
+func (lw *lineWriter) flush() {
+	if lw.buf.Len() > 0 {
+		lw.onLine(lw.buf.String())
+		lw.buf.Reset()
+	}
+}
+
 type realCommand struct {
 	*exec.Cmd
 }
 
+var _ LineCommand = realCommand{}
+
 func (c realCommand) Run(stdin []byte) ([]byte, error) {
 	if stdin != nil {
 		c.Stdin = bytes.NewBuffer(stdin)
 	}
 	out, err := c.Cmd.Output()
+	return out, c.wrapError(err)
+}
+
+func (c realCommand) RunWithLines(stdin []byte, onLine func(line string)) ([]byte, error) {
+	if stdin != nil {
+		c.Stdin = bytes.NewBuffer(stdin)
+	}
+	lw := &lineWriter{onLine: onLine}
+	var stderr bytes.Buffer
+	c.Stdout = lw
+	c.Stderr = &stderr
+	err := c.Cmd.Run()
+	lw.flush()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exitErr.Stderr = stderr.Bytes()
+	}
+	return lw.out.Bytes(), c.wrapError(err)
+}
+
+func (c realCommand) wrapError(err error) error {
 	if err == nil {
-		return out, nil
+		return nil
 	}
 	fullCmd := strings.Join(append([]string{c.Path}, c.Args...), " ")
 	if exitErr, ok := err.(*exec.ExitError); ok {
-		return out, fmt.Errorf("command %s: %v: %s", fullCmd, exitErr, exitErr.Stderr)
+		return fmt.Errorf("command %s: %v: %s", fullCmd, exitErr, exitErr.Stderr)
 	}
-	return out, fmt.Errorf("command %s: %v", fullCmd, err)
+	return fmt.Errorf("command %s: %v", fullCmd, err)
 }
 
 type defaultCommander struct{}
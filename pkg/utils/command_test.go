@@ -62,3 +62,27 @@ func TestCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestRunWithLines(t *testing.T) {
+	c := DefaultCommander.Command("/bin/bash", "-c", "echo -n 'a\rb'; echo; echo c")
+	var lines []string
+	out, err := RunWithLines(c, nil, func(line string) {
+		lines = append(lines, line)
+	})
+	if err != nil {
+		t.Fatalf("RunWithLines(): %v", err)
+	}
+	if string(out) != "a\rb\nc\n" {
+		t.Errorf("Command output mismatch: %q", out)
+	}
+	if strings.Join(lines, ",") != "a,b,c" {
+		t.Errorf("Bad lines: %#v", lines)
+	}
+
+	c = DefaultCommander.Command("/bin/bash", "-c", "echo -n >&2 'stderr here'; exit 1")
+	if _, err := RunWithLines(c, nil, func(string) {}); err == nil {
+		t.Errorf("Didn't get the expected error")
+	} else if !strings.Contains(err.Error(), "stderr here") {
+		t.Errorf("Bad error message %q (no substring %q)", err.Error(), "stderr here")
+	}
+}
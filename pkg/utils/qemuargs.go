@@ -0,0 +1,58 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"strings"
+)
+
+// DisableQemuImageLocking returns a copy of qemu command line
+// arguments with image locking turned off for the drives that are
+// backed by local files or block devices. Both the legacy -drive
+// option and -blockdev with JSON syntax are handled. The drives
+// that use network protocols such as rbd are left untouched.
+func DisableQemuImageLocking(args []string) []string {
+	r := make([]string, len(args))
+	copy(r, args)
+	for n := 1; n < len(r); n++ {
+		switch r[n-1] {
+		case "-drive":
+			if isLocalDrive(r[n]) && !strings.Contains(r[n], "file.locking=") {
+				r[n] += ",file.locking=off"
+			}
+		case "-blockdev":
+			if strings.HasPrefix(r[n], "{") &&
+				(strings.Contains(r[n], `"driver":"file"`) || strings.Contains(r[n], `"driver":"host_device"`)) &&
+				!strings.Contains(r[n], `"locking":`) {
+				r[n] = `{"locking":"off",` + r[n][1:]
+			}
+		}
+	}
+	return r
+}
+
+// isLocalDrive returns true if the value of -drive option specifies
+// a local file or block device. Commas in the option values are
+// escaped by doubling them.
+func isLocalDrive(drive string) bool {
+	for _, opt := range strings.Split(strings.Replace(drive, ",,", "", -1), ",") {
+		if strings.HasPrefix(opt, "file=/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDisableQemuImageLocking(t *testing.T) {
+	args := []string{
+		"-name", "guest=virtlet-foo,debug-threads=on",
+		"-drive", "file=/var/lib/virtlet/volumes/virtlet_root_foo,format=qcow2,if=none,id=drive-scsi0-0-0-0",
+		"-drive", "file=/var/lib/virtlet/config/config-foo,,bar.iso,format=raw,if=none,id=drive-scsi0-0-0-1,readonly=on",
+		"-drive", "file=rbd:libvirt-pool/rbd-test-image:auth_supported=none,format=raw,if=none,id=drive-scsi0-0-0-2",
+		"-drive", "file=/dev/loop0,format=raw,if=none,id=drive-scsi0-0-0-3,file.locking=on",
+		"-blockdev", `{"driver":"file","filename":"/var/lib/virtlet/volumes/virtlet_root_foo","node-name":"libvirt-1-storage"}`,
+		"-blockdev", `{"node-name":"libvirt-1-format","driver":"qcow2","file":"libvirt-1-storage"}`,
+		"-device", "scsi-hd,drive=drive-scsi0-0-0-0,file=/dev/null",
+	}
+	expectedArgs := []string{
+		"-name", "guest=virtlet-foo,debug-threads=on",
+		"-drive", "file=/var/lib/virtlet/volumes/virtlet_root_foo,format=qcow2,if=none,id=drive-scsi0-0-0-0,file.locking=off",
+		"-drive", "file=/var/lib/virtlet/config/config-foo,,bar.iso,format=raw,if=none,id=drive-scsi0-0-0-1,readonly=on,file.locking=off",
+		"-drive", "file=rbd:libvirt-pool/rbd-test-image:auth_supported=none,format=raw,if=none,id=drive-scsi0-0-0-2",
+		"-drive", "file=/dev/loop0,format=raw,if=none,id=drive-scsi0-0-0-3,file.locking=on",
+		"-blockdev", `{"locking":"off","driver":"file","filename":"/var/lib/virtlet/volumes/virtlet_root_foo","node-name":"libvirt-1-storage"}`,
+		"-blockdev", `{"node-name":"libvirt-1-format","driver":"qcow2","file":"libvirt-1-storage"}`,
+		"-device", "scsi-hd,drive=drive-scsi0-0-0-0,file=/dev/null",
+	}
+	origArgs := append([]string(nil), args...)
+	r := DisableQemuImageLocking(args)
+	if !reflect.DeepEqual(r, expectedArgs) {
+		t.Errorf("bad args:\n%#v\ninstead of\n%#v", r, expectedArgs)
+	}
+	if !reflect.DeepEqual(args, origArgs) {
+		t.Errorf("DisableQemuImageLocking() modified the original args")
+	}
+}
@@ -18,6 +18,7 @@ package virt
 
 import (
 	"errors"
+	"time"
 
 	libvirtxml "github.com/libvirt/libvirt-go-xml"
 )
@@ -76,6 +77,9 @@ type DomainConnection interface {
 	// secret cannot be found but no other error occurred, it returns
 	// ErrSecretNotFound
 	LookupSecretByUsageName(usageType string, usageName string) (Secret, error)
+	// HypervisorVersion returns the version of the hypervisor, i.e.
+	// QEMU, as major * 1000000 + minor * 1000 + release
+	HypervisorVersion() (uint32, error)
 }
 
 // Secret represents a secret that's used by the domain
@@ -86,6 +90,48 @@ type Secret interface {
 	Remove() error
 }
 
+// BlockStats contains IO statistics for a domain disk
+type BlockStats struct {
+	// ReadRequests is the number of read requests
+	ReadRequests int64
+	// ReadBytes is the number of bytes read
+	ReadBytes int64
+	// ReadTimeNs is the total time spent on read requests in nanoseconds
+	ReadTimeNs int64
+	// WriteRequests is the number of write requests
+	WriteRequests int64
+	// WriteBytes is the number of bytes written
+	WriteBytes int64
+	// WriteTimeNs is the total time spent on write requests in nanoseconds
+	WriteTimeNs int64
+	// FlushRequests is the number of flush requests
+	FlushRequests int64
+	// FlushTimeNs is the total time spent on flush requests in nanoseconds
+	FlushTimeNs int64
+}
+
+// MemoryStats contains memory statistics for a domain.
+// The values that come from the memory balloon driver are
+// zero if the balloon driver doesn't report them.
+type MemoryStats struct {
+	// RSS is the resident set size of the emulator process in bytes
+	RSS uint64
+	// Actual is the current balloon size in bytes
+	Actual uint64
+	// Available is the amount of memory usable by the guest OS
+	// as reported by the balloon driver in bytes
+	Available uint64
+	// Unused is the amount of memory left completely unused
+	// by the guest OS in bytes
+	Unused uint64
+	// Usable is the amount of memory that can be reclaimed by
+	// the guest OS without swapping in bytes
+	Usable uint64
+	// LastUpdate is the timestamp of the last update of the
+	// balloon driver stats in seconds since the epoch
+	LastUpdate int64
+}
+
 // Domain represents a domain which corresponds to a VM
 type Domain interface {
 	// Create boots the domain
@@ -97,6 +143,11 @@ type Domain interface {
 	Undefine() error
 	// Shutdown shuts down the domain
 	Shutdown() error
+	// Reboot asks the guest OS to reboot
+	Reboot() error
+	// Reset resets the domain as if its reset button were pressed,
+	// resuming it if it's paused, e.g. after a guest crash
+	Reset() error
 	// State obtains the current state of the domain
 	State() (DomainState, error)
 	// UUIDString returns UUID string for this domain
@@ -107,6 +158,32 @@ type Domain interface {
 	XML() (*libvirtxml.Domain, error)
 	// GetRSS returns RSS used by VM in bytes
 	GetRSS() (uint64, error)
+	// GetMemoryStats returns memory statistics for the domain
+	// including the guest stats reported by the balloon driver
+	GetMemoryStats() (*MemoryStats, error)
 	// GetCPUTime returns cpu time used by VM in nanoseconds per core
 	GetCPUTime() (uint64, error)
+	// GetBlockStats returns IO statistics for the disk
+	// with the specified target device name
+	GetBlockStats(dev string) (*BlockStats, error)
+	// QemuAgentCommand sends a command to the QEMU guest agent
+	// running inside the VM and returns the response
+	QemuAgentCommand(command string, timeout time.Duration) (string, error)
+	// Screenshot takes a screenshot of the VM console and returns
+	// the image data along with its MIME type
+	Screenshot() ([]byte, string, error)
+	// SetVCPUs changes the number of vCPUs of the running domain.
+	// The count can't exceed the maximum number of vCPUs specified
+	// in the domain definition
+	SetVCPUs(count int) error
+	// GetVCPUs returns the number of vCPUs the running domain
+	// currently has
+	GetVCPUs() (int, error)
+	// CreateSnapshot takes an internal snapshot of the domain with
+	// the specified name. The snapshot of a running domain includes
+	// its memory state
+	CreateSnapshot(name string) error
+	// AttachDisk attaches the disk to the running domain and adds
+	// it to the persistent definition of the domain
+	AttachDisk(disk *libvirtxml.DomainDisk) error
 }
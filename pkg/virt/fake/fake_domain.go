@@ -17,11 +17,14 @@ limitations under the License.
 package fake
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	libvirtxml "github.com/libvirt/libvirt-go-xml"
 
@@ -29,6 +32,10 @@ import (
 	"github.com/Mirantis/virtlet/pkg/virt"
 )
 
+// defaultHypervisorVersion is the hypervisor version reported by
+// FakeDomainConnection by default, i.e. QEMU 3.1.0
+const defaultHypervisorVersion = 3001000
+
 func mustMarshal(d libvirtxml.Document) string {
 	s, err := d.Marshal()
 	if err != nil {
@@ -45,6 +52,11 @@ type FakeDomainConnection struct {
 	secretsByUsageName      map[string]*FakeSecret
 	ignoreShutdown          bool
 	useNonVolatileDomainDef bool
+	agentResponses          map[string]string
+	memoryStats             *virt.MemoryStats
+	cpuTime                 uint64
+	blockStats              map[string]*virt.BlockStats
+	hypervisorVersion       uint32
 }
 
 var _ virt.DomainConnection = &FakeDomainConnection{}
@@ -60,6 +72,7 @@ func NewFakeDomainConnection(rec testutils.Recorder) *FakeDomainConnection {
 		domains:            make(map[string]*FakeDomain),
 		domainsByUuid:      make(map[string]*FakeDomain),
 		secretsByUsageName: make(map[string]*FakeSecret),
+		hypervisorVersion:  defaultHypervisorVersion,
 	}
 }
 
@@ -74,6 +87,42 @@ func (dc *FakeDomainConnection) SetIgnoreShutdown(ignoreShutdown bool) {
 	dc.ignoreShutdown = ignoreShutdown
 }
 
+// SetGuestAgentResponse sets the response returned by the domains'
+// QemuAgentCommand() method for the specified guest agent command.
+func (dc *FakeDomainConnection) SetGuestAgentResponse(execute, response string) {
+	if dc.agentResponses == nil {
+		dc.agentResponses = make(map[string]string)
+	}
+	dc.agentResponses[execute] = response
+}
+
+// SetMemoryStats sets the memory stats returned by the domains'
+// GetMemoryStats() method.
+func (dc *FakeDomainConnection) SetMemoryStats(stats *virt.MemoryStats) {
+	dc.memoryStats = stats
+}
+
+// SetCPUTime sets the cpu time returned by the domains'
+// GetCPUTime() method.
+func (dc *FakeDomainConnection) SetCPUTime(cpuTime uint64) {
+	dc.cpuTime = cpuTime
+}
+
+// SetBlockStats sets the stats returned by the domains'
+// GetBlockStats() method for the specified block device.
+func (dc *FakeDomainConnection) SetBlockStats(dev string, stats *virt.BlockStats) {
+	if dc.blockStats == nil {
+		dc.blockStats = make(map[string]*virt.BlockStats)
+	}
+	dc.blockStats[dev] = stats
+}
+
+// SetHypervisorVersion sets the version returned by the
+// HypervisorVersion() method.
+func (dc *FakeDomainConnection) SetHypervisorVersion(version uint32) {
+	dc.hypervisorVersion = version
+}
+
 func (dc *FakeDomainConnection) removeDomain(d *FakeDomain) {
 	if _, found := dc.domains[d.def.Name]; !found {
 		log.Panicf("domain %q not found", d.def.Name)
@@ -99,8 +148,20 @@ func (dc *FakeDomainConnection) DefineDomain(def *libvirtxml.Domain) (virt.Domai
 	assignFakePCIAddressesToControllers(def)
 	// TODO: dump any ISOs mentioned in disks (Type=file) as json
 	// Include file name (base) in rec name
-	if _, found := dc.domains[def.Name]; found {
-		return nil, fmt.Errorf("domain %q already defined", def.Name)
+	if d, found := dc.domains[def.Name]; found {
+		if d.def.UUID != def.UUID {
+			return nil, fmt.Errorf("domain %q already defined", def.Name)
+		}
+		// like libvirt, update the definition of the existing
+		// domain, which takes effect upon its next start
+		d.def = def
+		if d.state == virt.DomainStateShutoff {
+			d.created = false
+		}
+		updatedDef := copyDomain(def)
+		removeVolatilePathsFromDomainDef(updatedDef)
+		dc.rec.Rec("DefineDomain", mustMarshal(updatedDef))
+		return d, nil
 	}
 	if def.Name == "" {
 		return nil, fmt.Errorf("domain name cannot be empty")
@@ -171,6 +232,11 @@ func (dc *FakeDomainConnection) LookupSecretByUUIDString(uuid string) (virt.Secr
 	return nil, virt.ErrSecretNotFound
 }
 
+// HypervisorVersion implements HypervisorVersion method of DomainConnection interface.
+func (dc *FakeDomainConnection) HypervisorVersion() (uint32, error) {
+	return dc.hypervisorVersion, nil
+}
+
 // LookupSecretByUsageName implements LookupSecretByUsageName method of DomainConnection interface.
 func (dc *FakeDomainConnection) LookupSecretByUsageName(usageType string, usageName string) (virt.Secret, error) {
 	if d, found := dc.secretsByUsageName[usageName]; found {
@@ -181,12 +247,13 @@ func (dc *FakeDomainConnection) LookupSecretByUsageName(usageType string, usageN
 
 // FakeDomain is a fake implementation of Domain interface.
 type FakeDomain struct {
-	rec     testutils.Recorder
-	dc      *FakeDomainConnection
-	removed bool
-	created bool
-	state   virt.DomainState
-	def     *libvirtxml.Domain
+	rec           testutils.Recorder
+	dc            *FakeDomainConnection
+	removed       bool
+	created       bool
+	state         virt.DomainState
+	def           *libvirtxml.Domain
+	blockStatsErr error
 }
 
 var _ virt.Domain = &FakeDomain{}
@@ -270,6 +337,31 @@ func (d *FakeDomain) Shutdown() error {
 	return nil
 }
 
+// Reboot implements Reboot method of Domain interface.
+func (d *FakeDomain) Reboot() error {
+	d.rec.Rec("Reboot", nil)
+	if d.removed {
+		return fmt.Errorf("Reboot() called on a removed (undefined) domain %q", d.def.Name)
+	}
+	if d.state != virt.DomainStateRunning {
+		return fmt.Errorf("domain %q is not running", d.def.Name)
+	}
+	return nil
+}
+
+// Reset implements Reset method of Domain interface.
+func (d *FakeDomain) Reset() error {
+	d.rec.Rec("Reset", nil)
+	if d.removed {
+		return fmt.Errorf("Reset() called on a removed (undefined) domain %q", d.def.Name)
+	}
+	if d.state == virt.DomainStateShutoff {
+		return fmt.Errorf("domain %q is not active", d.def.Name)
+	}
+	d.state = virt.DomainStateRunning
+	return nil
+}
+
 // State implements State method of Domain interface.
 func (d *FakeDomain) State() (virt.DomainState, error) {
 	if d.removed {
@@ -278,6 +370,18 @@ func (d *FakeDomain) State() (virt.DomainState, error) {
 	return d.state, nil
 }
 
+// SetState sets the state of the domain, e.g. to simulate the domain
+// being paused and resumed outside of Virtlet.
+func (d *FakeDomain) SetState(state virt.DomainState) {
+	d.state = state
+}
+
+// SetBlockStatsError makes GetBlockStats() method of the domain
+// fail with the specified error.
+func (d *FakeDomain) SetBlockStatsError(err error) {
+	d.blockStatsErr = err
+}
+
 // UUIDString implements UUIDString method of Domain interface.
 func (d *FakeDomain) UUIDString() (string, error) {
 	if d.removed {
@@ -302,8 +406,9 @@ func (d *FakeDomain) XML() (*libvirtxml.Domain, error) {
 }
 
 // GetCPUTime implements GetCPUTime of Domain interface.
+// Unless overridden using SetCPUTime(), it returns 0.
 func (d *FakeDomain) GetCPUTime() (uint64, error) {
-	return 0, nil
+	return d.dc.cpuTime, nil
 }
 
 // GetRSS implements GetRSS of Domain interface.
@@ -311,6 +416,136 @@ func (d *FakeDomain) GetRSS() (uint64, error) {
 	return 0, nil
 }
 
+// GetMemoryStats implements GetMemoryStats of Domain interface.
+// Unless overridden using SetMemoryStats(), it returns zero stats,
+// which correspond to a domain without the balloon driver stats.
+func (d *FakeDomain) GetMemoryStats() (*virt.MemoryStats, error) {
+	if d.dc.memoryStats == nil {
+		return &virt.MemoryStats{}, nil
+	}
+	stats := *d.dc.memoryStats
+	return &stats, nil
+}
+
+// GetBlockStats implements GetBlockStats of Domain interface.
+// Unless overridden using SetBlockStats(), it returns zero stats.
+func (d *FakeDomain) GetBlockStats(dev string) (*virt.BlockStats, error) {
+	if d.blockStatsErr != nil {
+		return nil, d.blockStatsErr
+	}
+	for _, disk := range d.def.Devices.Disks {
+		if disk.Target != nil && disk.Target.Dev == dev {
+			if stats, found := d.dc.blockStats[dev]; found {
+				r := *stats
+				return &r, nil
+			}
+			return &virt.BlockStats{}, nil
+		}
+	}
+	return nil, fmt.Errorf("disk %q not found in domain %q", dev, d.def.Name)
+}
+
+// Screenshot implements Screenshot method of Domain interface.
+func (d *FakeDomain) Screenshot() ([]byte, string, error) {
+	d.rec.Rec("Screenshot", nil)
+	if d.removed {
+		return nil, "", fmt.Errorf("Screenshot() called on a removed (undefined) domain %q", d.def.Name)
+	}
+	if d.state != virt.DomainStateRunning {
+		return nil, "", fmt.Errorf("domain %q is not running", d.def.Name)
+	}
+	return []byte("P6\n1 1\n255\n\x00\x00\x00"), "image/x-portable-pixmap", nil
+}
+
+// SetVCPUs implements SetVCPUs method of Domain interface.
+func (d *FakeDomain) SetVCPUs(count int) error {
+	d.rec.Rec("SetVCPUs", count)
+	if d.removed {
+		return fmt.Errorf("SetVCPUs() called on a removed (undefined) domain %q", d.def.Name)
+	}
+	if d.state != virt.DomainStateRunning {
+		return fmt.Errorf("domain %q is not running", d.def.Name)
+	}
+	if d.def.VCPU == nil || count < 1 || count > d.def.VCPU.Value {
+		return fmt.Errorf("bad vcpu count %d for domain %q", count, d.def.Name)
+	}
+	d.def.VCPU.Current = strconv.Itoa(count)
+	return nil
+}
+
+// GetVCPUs implements GetVCPUs method of Domain interface.
+func (d *FakeDomain) GetVCPUs() (int, error) {
+	if d.removed {
+		return 0, fmt.Errorf("GetVCPUs() called on a removed (undefined) domain %q", d.def.Name)
+	}
+	if d.state != virt.DomainStateRunning {
+		return 0, fmt.Errorf("domain %q is not running", d.def.Name)
+	}
+	if d.def.VCPU == nil {
+		return 1, nil
+	}
+	if d.def.VCPU.Current != "" {
+		return strconv.Atoi(d.def.VCPU.Current)
+	}
+	return d.def.VCPU.Value, nil
+}
+
+// CreateSnapshot implements CreateSnapshot method of Domain interface.
+func (d *FakeDomain) CreateSnapshot(name string) error {
+	d.rec.Rec("CreateSnapshot", name)
+	if d.removed {
+		return fmt.Errorf("CreateSnapshot() called on a removed (undefined) domain %q", d.def.Name)
+	}
+	return nil
+}
+
+// AttachDisk implements AttachDisk method of Domain interface.
+func (d *FakeDomain) AttachDisk(disk *libvirtxml.DomainDisk) error {
+	var source string
+	if disk.Source != nil && disk.Source.Block != nil {
+		source = disk.Source.Block.Dev
+	}
+	d.rec.Rec("AttachDisk", map[string]interface{}{"target": disk.Target.Dev, "source": source})
+	if d.removed {
+		return fmt.Errorf("AttachDisk() called on a removed (undefined) domain %q", d.def.Name)
+	}
+	if d.state != virt.DomainStateRunning {
+		return fmt.Errorf("domain %q is not running", d.def.Name)
+	}
+	d.def.Devices.Disks = append(d.def.Devices.Disks, *disk)
+	return nil
+}
+
+// QemuAgentCommand implements QemuAgentCommand method of Domain interface.
+// Unless overridden using SetGuestAgentResponse(), guest-exec returns
+// pid 1 and guest-exec-status returns successful completion of the
+// command.
+func (d *FakeDomain) QemuAgentCommand(command string, timeout time.Duration) (string, error) {
+	var cmd interface{}
+	if err := json.Unmarshal([]byte(command), &cmd); err != nil {
+		return "", fmt.Errorf("bad guest agent command %q: %v", command, err)
+	}
+	d.rec.Rec("QemuAgentCommand", cmd)
+	if d.removed {
+		return "", fmt.Errorf("QemuAgentCommand() called on a removed (undefined) domain %q", d.def.Name)
+	}
+	if d.state != virt.DomainStateRunning {
+		return "", fmt.Errorf("domain %q is not running", d.def.Name)
+	}
+	execute, _ := cmd.(map[string]interface{})["execute"].(string)
+	if r, found := d.dc.agentResponses[execute]; found {
+		return r, nil
+	}
+	switch execute {
+	case "guest-exec":
+		return `{"return":{"pid":1}}`, nil
+	case "guest-exec-status":
+		return `{"return":{"exited":true,"exitcode":0}}`, nil
+	default:
+		return `{"return":{}}`, nil
+	}
+}
+
 // FakeSecret is a fake implementation of Secret interace.
 type FakeSecret struct {
 	rec       testutils.Recorder
@@ -106,6 +106,12 @@ func (sc *FakeStorageConnection) PutFiles(imagePath string, files map[string][]b
 	return nil
 }
 
+// GrowRootFS implements GrowRootFS method of StorageConnection interface.
+func (sc *FakeStorageConnection) GrowRootFS(imagePath string) error {
+	sc.rec.Rec("GrowRootFS", fixPath(imagePath))
+	return nil
+}
+
 // FakeStoragePool is a fake implementation of StoragePool interface.
 type FakeStoragePool struct {
 	rec     testutils.Recorder
@@ -44,6 +44,9 @@ type StorageConnection interface {
 	ListPools() ([]StoragePool, error)
 	// PutFiles add files to the specified image.
 	PutFiles(imagePath string, files map[string][]byte) error
+	// GrowRootFS grows the last partition of the specified image
+	// and the filesystem on it to occupy all of the image space.
+	GrowRootFS(imagePath string) error
 }
 
 // StoragePool represents a pool of volumes
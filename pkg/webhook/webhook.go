@@ -0,0 +1,276 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// EventHeader is the HTTP header that contains the event type
+	EventHeader = "X-Virtlet-Event"
+	// SignatureHeader is the HTTP header that contains the HMAC
+	// signature of the request body
+	SignatureHeader = "X-Virtlet-Signature"
+
+	signaturePrefix       = "sha256="
+	defaultQueueSize      = 256
+	defaultMaxAttempts    = 5
+	defaultRetryInterval  = time.Second
+	defaultRequestTimeout = 10 * time.Second
+)
+
+// EventType denotes the type of a VM lifecycle event.
+type EventType string
+
+const (
+	// EventCreated is sent after the VM is created
+	EventCreated EventType = "created"
+	// EventStarted is sent after the VM is started
+	EventStarted EventType = "started"
+	// EventStopped is sent after the VM is stopped, either by
+	// Virtlet or by the guest OS
+	EventStopped EventType = "stopped"
+	// EventCrashed is sent when the VM is found to have crashed
+	EventCrashed EventType = "crashed"
+	// EventRemoved is sent after the VM is removed
+	EventRemoved EventType = "removed"
+	// EventSuspended is sent when the running VM is found to be
+	// paused or suspended
+	EventSuspended EventType = "suspended"
+	// EventResumed is sent when the paused or suspended VM is
+	// found to be running again
+	EventResumed EventType = "resumed"
+)
+
+// Event describes a VM lifecycle event. It's sent as the JSON body
+// of the webhook requests.
+type Event struct {
+	// Type is the type of the event
+	Type EventType `json:"type"`
+	// Timestamp is the time of the event
+	Timestamp time.Time `json:"timestamp"`
+	// Node is the name of the node that runs the VM
+	Node string `json:"node,omitempty"`
+	// ContainerID is the id of the VM (container)
+	ContainerID string `json:"containerID"`
+	// ContainerName is the name of the container
+	ContainerName string `json:"containerName,omitempty"`
+	// PodID is the id of the pod sandbox (pod UID)
+	PodID string `json:"podID,omitempty"`
+	// PodName is the name of the VM pod
+	PodName string `json:"podName,omitempty"`
+	// PodNamespace is the namespace of the VM pod
+	PodNamespace string `json:"podNamespace,omitempty"`
+	// Image is the name of the VM image
+	Image string `json:"image,omitempty"`
+}
+
+// Options specify the settings of a Dispatcher.
+type Options struct {
+	// URLs are the endpoints to POST the events to
+	URLs []string
+	// Secret is the key used to sign the request bodies. If it's
+	// empty, the requests are not signed.
+	Secret []byte
+	// Node is the node name to put in the events
+	Node string
+	// QueueSize is the maximum number of events waiting to be
+	// delivered. The events that don't fit in the queue are
+	// dropped. Defaults to 256.
+	QueueSize int
+	// MaxAttempts is the maximum number of delivery attempts
+	// for each event and endpoint. Defaults to 5.
+	MaxAttempts int
+	// RetryInterval is the delay before the first retry. It's
+	// doubled after each failed attempt. Defaults to 1s.
+	RetryInterval time.Duration
+	// Client is the HTTP client to use. If it's nil, a client
+	// with 10s timeout is used.
+	Client *http.Client
+}
+
+// Dispatcher delivers VM lifecycle events to the webhook endpoints.
+// The events are queued and delivered in order by a background
+// goroutine, so Dispatch doesn't block on the network. A nil
+// Dispatcher ignores the events.
+type Dispatcher struct {
+	opts    Options
+	queue   chan Event
+	done    chan struct{}
+	mutex   sync.Mutex
+	stopped bool
+}
+
+// NewDispatcher creates a Dispatcher and starts its delivery goroutine.
+func NewDispatcher(opts Options) *Dispatcher {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = defaultRetryInterval
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: defaultRequestTimeout}
+	}
+	d := &Dispatcher{
+		opts:  opts,
+		queue: make(chan Event, opts.QueueSize),
+		done:  make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// Dispatch queues the event for delivery. The node name and the
+// timestamp are filled in if they're not set.
+func (d *Dispatcher) Dispatch(event Event) {
+	if d == nil {
+		return
+	}
+	if event.Node == "" {
+		event.Node = d.opts.Node
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.stopped {
+		return
+	}
+	select {
+	case d.queue <- event:
+	default:
+		glog.Warningf("Webhook queue is full, dropping %q event for container %q", event.Type, event.ContainerID)
+	}
+}
+
+// Stop stops accepting new events and waits till the queued events
+// are delivered.
+func (d *Dispatcher) Stop() {
+	if d == nil {
+		return
+	}
+	d.mutex.Lock()
+	if !d.stopped {
+		d.stopped = true
+		close(d.queue)
+	}
+	d.mutex.Unlock()
+	<-d.done
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	for event := range d.queue {
+		body, err := json.Marshal(event)
+		if err != nil {
+			glog.Errorf("Error marshalling webhook event: %v", err)
+			continue
+		}
+		for _, url := range d.opts.URLs {
+			if err := d.deliver(url, event.Type, body); err != nil {
+				glog.Warningf("Failed to deliver %q event for container %q to %s: %v", event.Type, event.ContainerID, url, err)
+			}
+		}
+	}
+}
+
+// deliver posts the event to the specified URL, retrying with
+// exponential backoff upon network errors and 5xx responses.
+func (d *Dispatcher) deliver(url string, eventType EventType, body []byte) error {
+	interval := d.opts.RetryInterval
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = d.post(url, eventType, body)
+		if !retry || attempt >= d.opts.MaxAttempts {
+			return err
+		}
+		glog.V(2).Infof("Webhook delivery to %s failed (attempt %d): %v", url, attempt, err)
+		time.Sleep(interval)
+		interval *= 2
+	}
+}
+
+// post performs a single delivery attempt. It returns true
+// if the attempt has failed and should be retried.
+func (d *Dispatcher) post(url string, eventType EventType, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(eventType))
+	if len(d.opts.Secret) != 0 {
+		req.Header.Set(SignatureHeader, Sign(d.opts.Secret, body))
+	}
+	resp, err := d.opts.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	// drain the body so the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500:
+		return true, fmt.Errorf("server error: %s", resp.Status)
+	case resp.StatusCode >= 300:
+		return false, fmt.Errorf("request rejected: %s", resp.Status)
+	}
+	return false, nil
+}
+
+// Sign returns the value of the signature header for the request
+// body, which is the hex-encoded HMAC-SHA256 of the body prefixed
+// with "sha256=".
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// LoadSecret reads the signing key from the specified file,
+// trimming the surrounding whitespace.
+func LoadSecret(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the webhook secret file: %v", err)
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return nil, fmt.Errorf("the webhook secret file %q is empty", path)
+	}
+	return []byte(secret), nil
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2018 Mirantis
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type receivedRequest struct {
+	eventType string
+	signature string
+	event     Event
+}
+
+type fakeEndpoint struct {
+	sync.Mutex
+	t        *testing.T
+	secret   []byte
+	statuses []int
+	requests []receivedRequest
+}
+
+func (e *fakeEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.Lock()
+	defer e.Unlock()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		e.t.Errorf("error reading the request body: %v", err)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+		e.t.Errorf("bad content type %q", ct)
+	}
+	signature := r.Header.Get(SignatureHeader)
+	if e.secret != nil && signature != Sign(e.secret, body) {
+		e.t.Errorf("bad signature %q", signature)
+	}
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		e.t.Errorf("error unmarshalling the event: %v", err)
+	}
+	e.requests = append(e.requests, receivedRequest{
+		eventType: r.Header.Get(EventHeader),
+		signature: signature,
+		event:     event,
+	})
+	status := http.StatusOK
+	if len(e.statuses) != 0 {
+		status, e.statuses = e.statuses[0], e.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (e *fakeEndpoint) eventTypes() []string {
+	e.Lock()
+	defer e.Unlock()
+	var r []string
+	for _, req := range e.requests {
+		r = append(r, req.eventType)
+	}
+	return r
+}
+
+var testTimestamp = time.Date(2018, 7, 10, 12, 0, 0, 0, time.UTC)
+
+func TestDispatcher(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		secret        []byte
+		statuses      []int
+		expectedTypes []string
+	}{
+		{
+			name:          "delivery",
+			expectedTypes: []string{"created", "started", "stopped"},
+		},
+		{
+			name:          "signed delivery",
+			secret:        []byte("s3cr3t"),
+			expectedTypes: []string{"created", "started", "stopped"},
+		},
+		{
+			name:          "retry upon server errors",
+			statuses:      []int{http.StatusInternalServerError, http.StatusBadGateway},
+			expectedTypes: []string{"created", "created", "created", "started", "stopped"},
+		},
+		{
+			name:          "no retry upon client errors",
+			statuses:      []int{http.StatusBadRequest},
+			expectedTypes: []string{"created", "started", "stopped"},
+		},
+		{
+			name: "giving up after max attempts",
+			statuses: []int{
+				http.StatusServiceUnavailable,
+				http.StatusServiceUnavailable,
+				http.StatusServiceUnavailable,
+			},
+			expectedTypes: []string{"created", "created", "created", "started", "stopped"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			endpoint := &fakeEndpoint{t: t, secret: tc.secret, statuses: tc.statuses}
+			srv := httptest.NewServer(endpoint)
+			defer srv.Close()
+			d := NewDispatcher(Options{
+				URLs:          []string{srv.URL},
+				Secret:        tc.secret,
+				Node:          "kube-node-1",
+				MaxAttempts:   3,
+				RetryInterval: time.Millisecond,
+			})
+			for _, eventType := range []EventType{EventCreated, EventStarted, EventStopped} {
+				d.Dispatch(Event{
+					Type:         eventType,
+					Timestamp:    testTimestamp,
+					ContainerID:  "231700d5-c9a6-5a49-738d-99a954c51550",
+					PodName:      "cirros-vm",
+					PodNamespace: "default",
+				})
+			}
+			d.Stop()
+
+			if types := endpoint.eventTypes(); !reflect.DeepEqual(types, tc.expectedTypes) {
+				t.Errorf("bad events received: %#v instead of %#v", types, tc.expectedTypes)
+			}
+			lastReq := endpoint.requests[len(endpoint.requests)-1]
+			expectedEvent := Event{
+				Type:         EventStopped,
+				Timestamp:    testTimestamp,
+				Node:         "kube-node-1",
+				ContainerID:  "231700d5-c9a6-5a49-738d-99a954c51550",
+				PodName:      "cirros-vm",
+				PodNamespace: "default",
+			}
+			if !reflect.DeepEqual(lastReq.event, expectedEvent) {
+				t.Errorf("bad event: %#v instead of %#v", lastReq.event, expectedEvent)
+			}
+			if tc.secret == nil && lastReq.signature != "" {
+				t.Errorf("unexpected signature for an unsigned request: %q", lastReq.signature)
+			}
+		})
+	}
+}
+
+func TestMultipleEndpoints(t *testing.T) {
+	var endpoints []*fakeEndpoint
+	var urls []string
+	for i := 0; i < 2; i++ {
+		endpoint := &fakeEndpoint{t: t}
+		srv := httptest.NewServer(endpoint)
+		defer srv.Close()
+		endpoints = append(endpoints, endpoint)
+		urls = append(urls, srv.URL)
+	}
+	d := NewDispatcher(Options{URLs: urls, RetryInterval: time.Millisecond})
+	d.Dispatch(Event{Type: EventRemoved, ContainerID: "231700d5-c9a6-5a49-738d-99a954c51550"})
+	d.Stop()
+	for n, endpoint := range endpoints {
+		if types := endpoint.eventTypes(); !reflect.DeepEqual(types, []string{"removed"}) {
+			t.Errorf("bad events received by endpoint %d: %#v", n, types)
+		}
+		if endpoint.requests[0].event.Timestamp.IsZero() {
+			t.Errorf("the timestamp was not set")
+		}
+	}
+	// the events dispatched after Stop() are ignored
+	d.Dispatch(Event{Type: EventRemoved})
+}
+
+func TestNilDispatcher(t *testing.T) {
+	var d *Dispatcher
+	d.Dispatch(Event{Type: EventCreated})
+	d.Stop()
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '{"type":"created"}' | openssl dgst -sha256 -hmac s3cr3t
+	expected := "sha256=d63538a4de7e16b928fbe9c71253f81f74c91f7c22573adc75c351028b060049"
+	if sig := Sign([]byte("s3cr3t"), []byte(`{"type":"created"}`)); sig != expected {
+		t.Errorf("bad signature: %q instead of %q", sig, expected)
+	}
+}
+
+func TestLoadSecret(t *testing.T) {
+	f, err := ioutil.TempFile("", "webhook-secret-")
+	if err != nil {
+		t.Fatalf("TempFile(): %v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("s3cr3t\n"); err != nil {
+		t.Fatalf("WriteString(): %v", err)
+	}
+	f.Close()
+	secret, err := LoadSecret(f.Name())
+	if err != nil {
+		t.Fatalf("LoadSecret(): %v", err)
+	}
+	if string(secret) != "s3cr3t" {
+		t.Errorf("bad secret: %q", secret)
+	}
+	if _, err := LoadSecret(f.Name() + "-nonexistent"); err == nil {
+		t.Errorf("LoadSecret() didn't fail for a nonexistent file")
+	}
+}
@@ -27,7 +27,7 @@ import (
 )
 
 const (
-	configDriveMetaData      = "{\"hostname\":\"testName_0\",\"instance-id\":\"testName_0.default\",\"local-hostname\":\"testName_0\",\"uuid\":\"testName_0.default\"}"
+	configDriveMetaData      = "{\"hostname\":\"localhost\",\"instance-id\":\"testName_0.default\",\"local-hostname\":\"localhost\",\"uuid\":\"testName_0.default\"}"
 	configDriveUserData      = "#cloud-config\n"
 	configDriveNetworkConfig = "{}"
 )
@@ -57,6 +57,22 @@ func (m *fakeFDManager) Recover(key string, data interface{}) error {
 	return nil
 }
 
+func (m *fakeFDManager) GetStats(key string) ([]byte, error) {
+	return []byte("[]"), nil
+}
+
+func (m *fakeFDManager) GetTraces(key string) ([]byte, error) {
+	return []byte("[]"), nil
+}
+
+func (m *fakeFDManager) GetGuestAddresses(key string) ([]byte, error) {
+	return []byte("[]"), nil
+}
+
+func (m *fakeFDManager) GetNetworkInfo(key string) ([]byte, error) {
+	return []byte(`{"leases":[],"neighbors":[],"routes":[]}`), nil
+}
+
 type fakeImageFileSystem struct {
 	t     *testing.T
 	inner http.FileSystem
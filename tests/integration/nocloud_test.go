@@ -27,7 +27,7 @@ import (
 )
 
 const (
-	noCloudMetaData      = "{\"instance-id\":\"testName_0.default\",\"local-hostname\":\"testName_0\"}"
+	noCloudMetaData      = "{\"instance-id\":\"testName_0.default\",\"local-hostname\":\"localhost\"}"
 	noCloudUserData      = "#cloud-config\n"
 	noCloudNetworkConfig = "version: 1\n"
 )
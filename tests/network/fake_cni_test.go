@@ -196,6 +196,10 @@ func (c *FakeCNIClient) GetDummyNetwork() (*cnicurrent.Result, string, error) {
 	return result, cni.PodNetNSPath(c.DummyPodId), nil
 }
 
+func (c *FakeCNIClient) NetworkName() (string, error) {
+	return "fake-network", nil
+}
+
 func (c *FakeCNIClient) getEntry(podId, podName, podNS string) *fakeCNIEntry {
 	if entry, found := c.entries[podKey(podId, podName, podNS)]; found {
 		return entry
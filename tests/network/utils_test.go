@@ -169,7 +169,7 @@ func (d *DhcpServerTester) Name() string { return "dhcp server" }
 func (d *DhcpServerTester) Fg() bool     { return false }
 
 func (d *DhcpServerTester) Run(readyCh, stopCh chan struct{}) error {
-	server := dhcp.NewServer(d.config)
+	server := dhcp.NewServer(d.config, nil)
 	if err := server.SetupListener("0.0.0.0"); err != nil {
 		return fmt.Errorf("failed to setup dhcp listener: %v", err)
 	}